@@ -23,9 +23,10 @@ import (
 
 /** Manages user authentication for a database. */
 type Authenticator struct {
-	bucket            base.Bucket
-	channelComputer   ChannelComputer
-	sessionCookieName string // Custom per-database session cookie name
+	bucket               base.Bucket
+	channelComputer      ChannelComputer
+	sessionCookieName    string               // Custom per-database session cookie name
+	sessionCookieOptions SessionCookieOptions // Attributes of the session cookies set
 }
 
 // Interface for deriving the set of channels and roles a User/Role has access to.
@@ -59,6 +60,10 @@ func (auth *Authenticator) SetSessionCookieName(cookieName string) {
 	auth.sessionCookieName = cookieName
 }
 
+func (auth *Authenticator) SetSessionCookieOptions(options SessionCookieOptions) {
+	auth.sessionCookieOptions = options
+}
+
 func docIDForUserEmail(email string) string {
 	return "_sync:useremail:" + email
 }
@@ -109,7 +114,7 @@ func (auth *Authenticator) getPrincipal(docID string, factory func() Principal)
 
 		princ = factory()
 		if err := json.Unmarshal(currentValue, princ); err != nil {
-			return nil, nil, pkgerrors.WithStack(base.RedactErrorf("json.Unmarshal() error for doc ID: %s in getPrincipal().  Error: %v", base.UD(docID), err))
+			return nil, nil, pkgerrors.WithStack(base.RedactErrorf("json.Unmarshal() error for doc ID: %s in getPrincipal().  Error: %v", base.UDDocID(docID), err))
 		}
 		changed := false
 		if princ.Channels() == nil {
@@ -134,7 +139,7 @@ func (auth *Authenticator) getPrincipal(docID string, factory func() Principal)
 			// Save the updated doc:
 			updatedBytes, marshalErr := json.Marshal(princ)
 			if marshalErr != nil {
-				marshalErr = pkgerrors.WithStack(base.RedactErrorf("json.Unmarshal() error for doc ID: %s in getPrincipal(). Error: %v", base.UD(docID), marshalErr))
+				marshalErr = pkgerrors.WithStack(base.RedactErrorf("json.Unmarshal() error for doc ID: %s in getPrincipal(). Error: %v", base.UDDocID(docID), marshalErr))
 			}
 			return updatedBytes, nil, marshalErr
 		} else {
@@ -183,7 +188,7 @@ func (auth *Authenticator) rebuildChannels(princ Principal) error {
 	// always grant access to the public document channel
 	channels.AddChannel(ch.DocumentStarChannel, 1)
 
-	base.Infof(base.KeyAccess, "Computed channels for %q: %s", base.UD(princ.Name()), base.UD(channels))
+	base.Infof(base.KeyAccess, "Computed channels for %q: %s", base.UDUsername(princ.Name()), base.UD(channels))
 	princ.SetPreviousChannels(nil)
 	princ.setChannels(channels)
 
@@ -197,7 +202,7 @@ func (auth *Authenticator) rebuildRoles(user User) error {
 		var err error
 		roles, err = auth.channelComputer.ComputeRolesForUser(user)
 		if err != nil {
-			base.Warnf(base.KeyAll, "channelComputer.ComputeRolesForUser failed on user %s: %v", base.UD(user.Name()), err)
+			base.Warnf(base.KeyAll, "channelComputer.ComputeRolesForUser failed on user %s: %v", base.UDUsername(user.Name()), err)
 			return err
 		}
 	}
@@ -209,7 +214,7 @@ func (auth *Authenticator) rebuildRoles(user User) error {
 		roles.Add(explicit)
 	}
 
-	base.Infof(base.KeyAccess, "Computed roles for %q: %s", base.UD(user.Name()), base.UD(roles))
+	base.Infof(base.KeyAccess, "Computed roles for %q: %s", base.UDUsername(user.Name()), base.UD(roles))
 	user.setRolesSince(roles)
 	return nil
 }
@@ -226,6 +231,62 @@ func (auth *Authenticator) GetUserByEmail(email string) (User, error) {
 	return auth.GetUser(info.Username)
 }
 
+// Registers an email address to a user before the user's saved, unless it's registered to another user.  Returns
+// false if it's taken.  An address registered to a user that's been deleted, or whose address has changed, is free;
+// it's taken over with a CAS write, so it's still taken if another user claims it first.
+func (auth *Authenticator) ClaimUserEmail(email string, username string) (bool, error) {
+	docID := docIDForUserEmail(email)
+	info := userByEmailInfo{username}
+	added, err := auth.bucket.Add(docID, 0, info)
+	if err != nil || added {
+		return added, err
+	}
+
+	var existing userByEmailInfo
+	cas, err := auth.bucket.Get(docID, &existing)
+	if base.IsDocNotFoundError(err) {
+		// The claim was removed since the add, so try again
+		return auth.bucket.Add(docID, 0, info)
+	} else if err != nil {
+		return false, err
+	}
+	if existing.Username != username {
+		owner, err := auth.GetUser(existing.Username)
+		if err != nil {
+			return false, err
+		}
+		if owner != nil && owner.Email() == email {
+			return false, nil
+		}
+	}
+	_, err = auth.bucket.WriteCas(docID, 0, 0, cas, info, 0)
+	if base.IsCasMismatch(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Removes an email address registration made by ClaimUserEmail, if it's still registered to the user.  Used when the
+// user couldn't be saved after claiming the address.
+func (auth *Authenticator) ReleaseUserEmail(email string, username string) error {
+	docID := docIDForUserEmail(email)
+	var existing userByEmailInfo
+	cas, err := auth.bucket.Get(docID, &existing)
+	if base.IsDocNotFoundError(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if existing.Username != username {
+		return nil
+	}
+	_, err = auth.bucket.Remove(docID, cas)
+	if base.IsCasMismatch(err) || base.IsDocNotFoundError(err) {
+		return nil
+	}
+	return err
+}
+
 // CAS-safe save of the information for a user/role.  For updates, expects the incoming principal to have
 // p.Cas to be set correctly (done automatically for principals retrieved via auth functions).
 func (auth *Authenticator) Save(p Principal) error {
@@ -282,7 +343,7 @@ func (auth *Authenticator) InvalidateRoles(user User) error {
 		if user == nil || user.RoleNames() == nil {
 			return p, base.ErrUpdateCancel
 		}
-		base.Infof(base.KeyAccess, "Invalidate roles of %q", base.UD(user.Name()))
+		base.Infof(base.KeyAccess, "Invalidate roles of %q", base.UDUsername(user.Name()))
 		user.setRolesSince(nil)
 		return user, nil
 	}
@@ -347,7 +408,7 @@ func (auth *Authenticator) rehashPassword(user User, password string) error {
 	}
 
 	base.Debugf(base.KeyAuth, "User account %q changed password hash cost from %d to %d",
-		base.UD(user.Name()), hashCost, bcryptCost)
+		base.UDUsername(user.Name()), hashCost, bcryptCost)
 	return nil
 }
 
@@ -494,11 +555,11 @@ func (auth *Authenticator) authenticateJWT(jwt jose.JWT, provider *OIDCProvider)
 	}
 
 	username := GetOIDCUsername(provider, identity.ID)
-	base.Debugf(base.KeyAuth, "OIDCUsername: %v", base.UD(username))
+	base.Debugf(base.KeyAuth, "OIDCUsername: %v", base.UDUsername(username))
 
 	user, userErr := auth.GetUser(username)
 	if userErr != nil {
-		base.Debugf(base.KeyAuth, "Failed to get OIDC User from %v.  Error: %v", base.UD(username), userErr)
+		base.Debugf(base.KeyAuth, "Failed to get OIDC User from %v.  Error: %v", base.UDUsername(username), userErr)
 		return nil, jwt, userErr
 	}
 
@@ -514,7 +575,7 @@ func (auth *Authenticator) authenticateJWT(jwt jose.JWT, provider *OIDCProvider)
 	// Auto-registration.  This will normally be done when token is originally returned
 	// to client by oidc callback, but also needed here to handle clients obtaining their own tokens.
 	if user == nil && provider.Register {
-		base.Debugf(base.KeyAuth, "Registering new user: %v with email: %v", base.UD(username), base.UD(identity.Email))
+		base.Debugf(base.KeyAuth, "Registering new user: %v with email: %v", base.UDUsername(username), base.UD(identity.Email))
 		var err error
 		user, err = auth.RegisterNewUser(username, identity.Email)
 		if err != nil && !base.IsCasMismatch(err) {
@@ -467,6 +467,38 @@ func TestRegisterUser(t *testing.T) {
 	assert.Equal(t, nil, err)
 }
 
+func TestClaimUserEmail(t *testing.T) {
+	gTestBucket := base.GetTestBucketOrPanic()
+	defer gTestBucket.Close()
+	auth := NewAuthenticator(gTestBucket.Bucket, nil)
+
+	claimed, err := auth.ClaimUserEmail("foo@example.com", "alice")
+	assert.NoError(t, err)
+	assert.True(t, claimed)
+
+	// A claim for a user that hasn't been saved is stale, so it can be taken over
+	claimed, err = auth.ClaimUserEmail("foo@example.com", "bob")
+	assert.NoError(t, err)
+	assert.True(t, claimed)
+
+	// Releasing only removes the user's own claim
+	assert.NoError(t, auth.ReleaseUserEmail("foo@example.com", "alice"))
+	var info userByEmailInfo
+	_, err = gTestBucket.Bucket.Get(docIDForUserEmail("foo@example.com"), &info)
+	assert.NoError(t, err)
+	assert.Equal(t, "bob", info.Username)
+	assert.NoError(t, auth.ReleaseUserEmail("foo@example.com", "bob"))
+	_, err = gTestBucket.Bucket.Get(docIDForUserEmail("foo@example.com"), &info)
+	assert.True(t, base.IsDocNotFoundError(err))
+
+	// An address registered to a saved user isn't free
+	_, err = auth.RegisterNewUser("carol", "carol@example.com")
+	assert.NoError(t, err)
+	claimed, err = auth.ClaimUserEmail("carol@example.com", "bob")
+	assert.NoError(t, err)
+	assert.False(t, claimed)
+}
+
 // 8 cases
 // C: Channel grant
 // R: Role grant
@@ -760,3 +792,21 @@ func NewTestingClockAtSequence(sequence uint64) *base.SequenceClockImpl {
 	return clock
 
 }
+
+func TestUserPasswordHash(t *testing.T) {
+	gTestBucket := base.GetTestBucketOrPanic()
+	defer gTestBucket.Close()
+	auth := NewAuthenticator(gTestBucket.Bucket, nil)
+	user, _ := auth.NewUser("me", "letmein", nil)
+
+	// A copy of the password hash authenticates the same password
+	user2, _ := auth.NewUser("you", "", nil)
+	assert.NoError(t, user2.SetPasswordHash(user.PasswordHash()))
+	assert.True(t, user2.Authenticate("letmein"))
+	assert.False(t, user2.Authenticate("password"))
+
+	assert.Error(t, user2.SetPasswordHash([]byte("letmein")))
+	assert.True(t, user2.Authenticate("letmein"))
+	assert.NoError(t, user2.SetPasswordHash(nil))
+	assert.Nil(t, user2.PasswordHash())
+}
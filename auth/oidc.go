@@ -254,6 +254,24 @@ func (op *OIDCProvider) FetchCustomProviderConfig(discoveryURL string) (*oidc.Pr
 
 }
 
+// CheckReachable verifies that the provider's discovery endpoint can be fetched using the given client.
+func (op *OIDCProvider) CheckReachable(client *http.Client) error {
+	discoveryURL := op.DiscoveryURI
+	if discoveryURL == "" {
+		discoveryURL = strings.TrimSuffix(op.Issuer, "/") + discoveryConfigPath
+	}
+
+	resp, err := client.Get(discoveryURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("discovery endpoint %s returned status %d", discoveryURL, resp.StatusCode)
+	}
+	return nil
+}
+
 func GetOIDCUsername(provider *OIDCProvider, subject string) string {
 	return fmt.Sprintf("%s_%s", provider.UserPrefix, url.QueryEscape(subject))
 }
@@ -101,6 +101,12 @@ type User interface {
 	// Changes the user's password.
 	SetPassword(password string)
 
+	// The bcrypt hash of the user's password, or nil if it doesn't have one.
+	PasswordHash() []byte
+
+	// Sets the bcrypt hash of the user's password, for copying a user without knowing its password.
+	SetPasswordHash(hash []byte) error
+
 	// The set of Roles the user belongs to (including ones given to it by the sync function)
 	RoleNames() ch.TimedSet
 
@@ -28,6 +28,23 @@ type LoginSession struct {
 
 const DefaultCookieName = "SyncGatewaySession"
 
+// Values of a session cookie's SameSite attribute
+const (
+	SameSiteLax    = "Lax"
+	SameSiteStrict = "Strict"
+	SameSiteNone   = "None" // Needed by browsers to send cookies on cross-site requests; requires Secure
+)
+
+// Attributes of the session cookies set by an Authenticator.  The zero value gives the default cookies, which are
+// sent for the database's path on the host that set them.
+type SessionCookieOptions struct {
+	Secure   bool   // Only send the cookie over HTTPS
+	HTTPOnly bool   // Hide the cookie from scripts
+	SameSite string // SameSiteLax, SameSiteStrict or SameSiteNone, or empty to omit the attribute
+	Domain   string // If set, the cookie is sent to this domain and its subdomains
+	Path     string // If set, the cookie is sent for this path instead of the database's
+}
+
 const SessionKeyPrefix = "_sync:session:"
 
 func (auth *Authenticator) AuthenticateCookie(rq *http.Request, response http.ResponseWriter) (User, error) {
@@ -60,9 +77,8 @@ func (auth *Authenticator) AuthenticateCookie(rq *http.Request, response http.Re
 		if err = auth.bucket.Set(docIDForSession(session.ID), base.DurationToCbsExpiry(duration), session); err != nil {
 			return nil, err
 		}
-		base.AddDbPathToCookie(rq, cookie)
 		cookie.Expires = session.Expiration
-		http.SetCookie(response, cookie)
+		auth.SetSessionCookie(rq, response, cookie)
 	}
 
 	user, err := auth.GetUser(session.Username)
@@ -113,6 +129,29 @@ func (auth *Authenticator) MakeSessionCookie(session *LoginSession) *http.Cookie
 	}
 }
 
+// Sets the configured attributes of a session cookie, and adds it to the response.
+func (auth *Authenticator) SetSessionCookie(rq *http.Request, response http.ResponseWriter, cookie *http.Cookie) {
+	options := auth.sessionCookieOptions
+	if options.Path != "" {
+		cookie.Path = options.Path
+	} else {
+		base.AddDbPathToCookie(rq, cookie)
+	}
+	cookie.Domain = options.Domain
+	cookie.Secure = options.Secure
+	cookie.HttpOnly = options.HTTPOnly
+
+	// http.Cookie can't express SameSite=None in this version of Go, so the attribute is added here
+	value := cookie.String()
+	if value == "" {
+		return
+	}
+	if options.SameSite != "" {
+		value += "; SameSite=" + options.SameSite
+	}
+	response.Header().Add("Set-Cookie", value)
+}
+
 func (auth Authenticator) DeleteSessionForCookie(rq *http.Request) *http.Cookie {
 	cookie, _ := rq.Cookie(auth.sessionCookieName)
 	if cookie == nil {
@@ -178,7 +178,7 @@ func (user *userImpl) Authenticate(password string) bool {
 
 	// exit early if old hash is present
 	if user.OldPasswordHash_ != nil {
-		base.Warnf(base.KeyAll, "User account %q still has pre-beta password hash; need to reset password", base.UD(user.Name_))
+		base.Warnf(base.KeyAll, "User account %q still has pre-beta password hash; need to reset password", base.UDUsername(user.Name_))
 		return false // Password must be reset to use new (bcrypt) password hash
 	}
 
@@ -193,7 +193,7 @@ func (user *userImpl) Authenticate(password string) bool {
 		// e.g: in the case of bcryptCost changes
 		if err := user.auth.rehashPassword(user, password); err != nil {
 			// rehash is best effort, just log a warning on error.
-			base.Warnf(base.KeyAll, "Error when rehashing password for user %s: %v", base.UD(user.Name()), err)
+			base.Warnf(base.KeyAll, "Error when rehashing password for user %s: %v", base.UDUsername(user.Name()), err)
 		}
 	} else {
 		// no hash, but (incorrect) password provided
@@ -218,6 +218,21 @@ func (user *userImpl) SetPassword(password string) {
 	}
 }
 
+func (user *userImpl) PasswordHash() []byte {
+	return user.PasswordHash_
+}
+
+// Sets the user's password hash, which must be a bcrypt hash.  A nil hash removes the password.
+func (user *userImpl) SetPasswordHash(hash []byte) error {
+	if hash != nil {
+		if _, err := bcrypt.Cost(hash); err != nil {
+			return fmt.Errorf("Invalid password hash: %v", err)
+		}
+	}
+	user.PasswordHash_ = hash
+	return nil
+}
+
 // Returns the sequence number since which the user has been able to access the channel, else zero.  Sets the vb
 // for an admin channel grant, if needed.
 func (user *userImpl) CanSeeChannelSinceVbSeq(channel string, hashFunction VBHashFunction) (base.VbSeq, bool) {
@@ -265,7 +280,7 @@ func (user *userImpl) GetRoles() []Role {
 		roles := make([]Role, 0, len(user.RolesSince_))
 		for name := range user.RolesSince_ {
 			role, err := user.auth.GetRole(name)
-			//base.Infof(base.KeyAccess, "User %s role %q = %v", base.UD(user.Name_), base.UD(name), base.UD(role))
+			//base.Infof(base.KeyAccess, "User %s role %q = %v", base.UDUsername(user.Name_), base.UD(name), base.UD(role))
 			if err != nil {
 				panic(fmt.Sprintf("Error getting user role %q: %v", name, err))
 			} else if role != nil {
@@ -0,0 +1,259 @@
+package base
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// AuditSinkFile writes audit entries as JSON lines to an append-only file.
+	AuditSinkFile = "file"
+	// AuditSinkBucket writes audit entries as documents to a Couchbase bucket.
+	AuditSinkBucket = "bucket"
+
+	AuditLogKeyPrefix  = "_sync:audit:"      // Prefix of bucket keys used to store audit entries (is appended with node and seq)
+	auditLogHeadPrefix = "_sync:audit_head:" // Prefix of the bucket docs storing each node's most recent audit entry, used to resume its hash chain
+)
+
+// AuditEntry is a single record of a mutating admin API call.  Each entry includes the hash of the previous entry,
+// so any modification, removal or reordering of entries can be detected by VerifyAuditChain.
+type AuditEntry struct {
+	Seq      uint64          `json:"seq"`
+	Time     string          `json:"timestamp"`
+	Actor    string          `json:"actor"`
+	SourceIP string          `json:"source_ip,omitempty"`
+	Method   string          `json:"method"`
+	Path     string          `json:"path"`
+	Database string          `json:"db,omitempty"`
+	Status   int             `json:"status"`
+	Before   json.RawMessage `json:"before,omitempty"`
+	After    json.RawMessage `json:"after,omitempty"`
+	PrevHash string          `json:"prev_hash"`
+	Hash     string          `json:"hash"`
+}
+
+// AuditSummary marshals v for use as an entry's Before or After summary.  Summaries are stored pre-marshalled
+// so that an entry's hash is unchanged when it's read back from the sink.
+func AuditSummary(v interface{}) json.RawMessage {
+	if v == nil {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		Warnf(KeyAll, "Unable to marshal audit summary: %v", err)
+		return nil
+	}
+	if string(data) == "null" {
+		return nil
+	}
+	return data
+}
+
+// computeHash returns the hash of the entry's contents, excluding the Hash field itself.
+func (e AuditEntry) computeHash() (string, error) {
+	e.Hash = ""
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// AuditSink is a destination audit entries are written to.
+type AuditSink interface {
+	// LastEntry returns the most recently written entry, or nil if nothing has been written yet.
+	LastEntry() (*AuditEntry, error)
+	WriteEntry(entry *AuditEntry) error
+	Close() error
+}
+
+// AuditLogger assigns sequence numbers and chained hashes to audit entries and writes them to a sink.
+type AuditLogger struct {
+	lock     sync.Mutex
+	sink     AuditSink
+	lastSeq  uint64
+	lastHash string
+}
+
+// NewAuditLogger returns an AuditLogger writing to the given sink, continuing the hash chain from any entries
+// previously written to it.
+func NewAuditLogger(sink AuditSink) (*AuditLogger, error) {
+	last, err := sink.LastEntry()
+	if err != nil {
+		return nil, err
+	}
+
+	logger := &AuditLogger{sink: sink}
+	if last != nil {
+		logger.lastSeq = last.Seq
+		logger.lastHash = last.Hash
+	}
+	return logger, nil
+}
+
+// Log chains the given entry onto the audit log and writes it to the sink.
+func (l *AuditLogger) Log(entry AuditEntry) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	entry.Seq = l.lastSeq + 1
+	entry.Time = time.Now().Format(ISO8601Format)
+	entry.PrevHash = l.lastHash
+
+	hash, err := entry.computeHash()
+	if err != nil {
+		return err
+	}
+	entry.Hash = hash
+
+	if err := l.sink.WriteEntry(&entry); err != nil {
+		return err
+	}
+
+	l.lastSeq = entry.Seq
+	l.lastHash = entry.Hash
+	return nil
+}
+
+// Close closes the underlying sink.
+func (l *AuditLogger) Close() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.sink.Close()
+}
+
+// VerifyAuditChain checks that the given entries, in order, form an unbroken hash chain.
+func VerifyAuditChain(entries []AuditEntry) error {
+	for i, entry := range entries {
+		hash, err := entry.computeHash()
+		if err != nil {
+			return err
+		}
+		if hash != entry.Hash {
+			return fmt.Errorf("audit entry %d has been modified: hash mismatch", entry.Seq)
+		}
+		if i > 0 {
+			prev := entries[i-1]
+			if entry.PrevHash != prev.Hash || entry.Seq != prev.Seq+1 {
+				return fmt.Errorf("audit entry %d does not follow entry %d", entry.Seq, prev.Seq)
+			}
+		}
+	}
+	return nil
+}
+
+// FileAuditSink appends audit entries to a file, one JSON object per line.
+type FileAuditSink struct {
+	lock sync.Mutex
+	path string
+	file *os.File
+}
+
+// NewFileAuditSink opens the audit log file at the given path for appending, creating it if needed.
+func NewFileAuditSink(path string) (*FileAuditSink, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileAuditSink{path: path, file: file}, nil
+}
+
+func (s *FileAuditSink) LastEntry() (*AuditEntry, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	file, err := os.Open(s.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var last *AuditEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("unable to parse audit log %s: %v", s.path, err)
+		}
+		last = &entry
+	}
+	return last, scanner.Err()
+}
+
+func (s *FileAuditSink) WriteEntry(entry *AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, err := s.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+func (s *FileAuditSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.file.Close()
+}
+
+// BucketAuditSink writes each audit entry to its own document, keyed by AuditLogKeyPrefix, the node and the entry's
+// seq.  Each node that shares the bucket has its own hash chain, as the nodes assign seqs independently.
+type BucketAuditSink struct {
+	bucket Bucket
+	node   string
+}
+
+// NewBucketAuditSink returns a sink that writes the given node's audit entries to the given bucket.  The node name
+// must be unique among the nodes writing to the bucket, and the same each time the node starts, so that it continues
+// its chain.
+func NewBucketAuditSink(bucket Bucket, node string) *BucketAuditSink {
+	return &BucketAuditSink{bucket: bucket, node: node}
+}
+
+// Returns the key of the node's audit entry with the given seq.
+func AuditLogEntryKey(node string, seq uint64) string {
+	return fmt.Sprintf("%s%s:%d", AuditLogKeyPrefix, node, seq)
+}
+
+func (s *BucketAuditSink) LastEntry() (*AuditEntry, error) {
+	var entry AuditEntry
+	_, err := s.bucket.Get(auditLogHeadPrefix+s.node, &entry)
+	if err != nil {
+		if IsKeyNotFoundError(s.bucket, err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func (s *BucketAuditSink) WriteEntry(entry *AuditEntry) error {
+	added, err := s.bucket.Add(AuditLogEntryKey(s.node, entry.Seq), 0, entry)
+	if err != nil {
+		return err
+	}
+	if !added {
+		return fmt.Errorf("audit entry already exists for node %s seq %d", s.node, entry.Seq)
+	}
+	return s.bucket.Set(auditLogHeadPrefix+s.node, 0, entry)
+}
+
+func (s *BucketAuditSink) Close() error {
+	s.bucket.Close()
+	return nil
+}
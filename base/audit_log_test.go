@@ -0,0 +1,106 @@
+package base
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func readAuditFile(t *testing.T, path string) (entries []AuditEntry) {
+	file, err := os.Open(path)
+	assert.NoError(t, err)
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry AuditEntry
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &entry))
+		entries = append(entries, entry)
+	}
+	assert.NoError(t, scanner.Err())
+	return entries
+}
+
+func TestFileAuditLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit_log")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	sink, err := NewFileAuditSink(path)
+	assert.NoError(t, err)
+	logger, err := NewAuditLogger(sink)
+	assert.NoError(t, err)
+
+	assert.NoError(t, logger.Log(AuditEntry{Actor: "admin", Method: "PUT", Path: "/db/_user/alice", Status: 201,
+		After: AuditSummary(map[string]interface{}{"name": "alice", "admin_channels": []string{"a", "b"}})}))
+	assert.NoError(t, logger.Log(AuditEntry{Actor: "admin", Method: "DELETE", Path: "/db/_user/alice", Status: 200,
+		Before: AuditSummary(map[string]interface{}{"name": "alice"})}))
+	assert.NoError(t, logger.Close())
+
+	// Reopening the log should continue the existing chain
+	sink, err = NewFileAuditSink(path)
+	assert.NoError(t, err)
+	logger, err = NewAuditLogger(sink)
+	assert.NoError(t, err)
+	assert.NoError(t, logger.Log(AuditEntry{Actor: "admin", Method: "POST", Path: "/db/_resync", Status: 200}))
+	assert.NoError(t, logger.Close())
+
+	entries := readAuditFile(t, path)
+	assert.Len(t, entries, 3)
+	assert.Equal(t, uint64(3), entries[2].Seq)
+	assert.Equal(t, "", entries[0].PrevHash)
+	assert.Equal(t, entries[1].Hash, entries[2].PrevHash)
+	assert.NoError(t, VerifyAuditChain(entries))
+
+	// Modifying, removing or reordering entries must be detected
+	modified := append([]AuditEntry{}, entries...)
+	modified[1].Actor = "someone_else"
+	assert.Error(t, VerifyAuditChain(modified))
+
+	assert.Error(t, VerifyAuditChain([]AuditEntry{entries[0], entries[2]}))
+	assert.Error(t, VerifyAuditChain([]AuditEntry{entries[1], entries[0], entries[2]}))
+}
+
+func TestAuditSummary(t *testing.T) {
+	assert.Nil(t, AuditSummary(nil))
+
+	var nilMap map[string]interface{}
+	assert.Nil(t, AuditSummary(nilMap))
+
+	assert.Equal(t, `{"name":"alice"}`, string(AuditSummary(map[string]string{"name": "alice"})))
+}
+
+// Nodes sharing a bucket each write their own chain, without their seqs colliding.
+func TestBucketAuditLogNodes(t *testing.T) {
+	testBucket := GetTestBucketOrPanic()
+	defer testBucket.Close()
+	bucket := testBucket.Bucket
+
+	for _, node := range []string{"node1", "node2"} {
+		logger, err := NewAuditLogger(NewBucketAuditSink(bucket, node))
+		assert.NoError(t, err)
+		assert.NoError(t, logger.Log(AuditEntry{Actor: "admin", Method: "PUT", Path: "/db/_user/" + node, Status: 201}))
+	}
+
+	// A node that restarts continues its own chain
+	logger, err := NewAuditLogger(NewBucketAuditSink(bucket, "node1"))
+	assert.NoError(t, err)
+	assert.NoError(t, logger.Log(AuditEntry{Actor: "admin", Method: "DELETE", Path: "/db/_user/node1", Status: 200}))
+
+	entries := make([]AuditEntry, 2)
+	for i := range entries {
+		_, err := bucket.Get(AuditLogEntryKey("node1", uint64(i+1)), &entries[i])
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, VerifyAuditChain(entries))
+	var entry AuditEntry
+	_, err = bucket.Get(AuditLogEntryKey("node2", 1), &entry)
+	assert.NoError(t, err)
+	assert.Equal(t, "/db/_user/node2", entry.Path)
+}
@@ -0,0 +1,88 @@
+package base
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	awsSigV4Algorithm  = "AWS4-HMAC-SHA256"
+	awsSigV4TimeFormat = "20060102T150405Z"
+	awsSigV4DateFormat = "20060102"
+)
+
+type awsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+func awsHMAC(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func awsSHA256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Signs the request using AWS Signature Version 4, setting the X-Amz-Date, X-Amz-Security-Token (if the
+// credentials have a session token) and Authorization headers.  All headers already set on the request are signed.
+// The request's query string must already be in canonical (sorted, escaped) form.
+func signAWSRequestV4(req *http.Request, body []byte, credentials awsCredentials, region, service string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format(awsSigV4TimeFormat)
+	req.Header.Set("X-Amz-Date", amzDate)
+	if credentials.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", credentials.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	headerNames := make([]string, 0, len(headers))
+	for name := range headers {
+		headerNames = append(headerNames, name)
+	}
+	sort.Strings(headerNames)
+
+	var canonicalHeaders bytes.Buffer
+	for _, name := range headerNames {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(headerNames, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		awsSHA256Hex(body),
+	}, "\n")
+
+	scope := strings.Join([]string{now.Format(awsSigV4DateFormat), region, service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{awsSigV4Algorithm, amzDate, scope, awsSHA256Hex([]byte(canonicalRequest))}, "\n")
+
+	signingKey := awsHMAC([]byte("AWS4"+credentials.SecretAccessKey), now.Format(awsSigV4DateFormat))
+	signingKey = awsHMAC(signingKey, region)
+	signingKey = awsHMAC(signingKey, service)
+	signingKey = awsHMAC(signingKey, "aws4_request")
+	signature := hex.EncodeToString(awsHMAC(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", awsSigV4Algorithm+" Credential="+credentials.AccessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
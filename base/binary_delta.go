@@ -0,0 +1,158 @@
+package base
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// Size of the source blocks that BinaryDiff looks for in the target.  Matches shorter than this aren't found.
+const kBinaryDeltaBlockSize = 64
+
+// Instructions in a binary delta
+const (
+	binaryDeltaCopy   = byte('C') // Followed by the uvarint offset and length of a range of the source to copy
+	binaryDeltaInsert = byte('I') // Followed by the uvarint length of the literal bytes that follow, to insert
+)
+
+var errInvalidBinaryDelta = errors.New("Invalid binary delta")
+
+// Rolling checksum of a block of bytes, as used by rsync.
+type rollingChecksum struct {
+	a, b uint32
+	size uint32
+}
+
+func newRollingChecksum(block []byte) rollingChecksum {
+	sum := rollingChecksum{size: uint32(len(block))}
+	for i, c := range block {
+		sum.a += uint32(c)
+		sum.b += uint32(len(block)-i) * uint32(c)
+	}
+	return sum
+}
+
+// Slides the block along by one byte, removing out and appending in.
+func (sum *rollingChecksum) roll(out, in byte) {
+	sum.a += uint32(in) - uint32(out)
+	sum.b += sum.a - sum.size*uint32(out)
+}
+
+func (sum rollingChecksum) value() uint32 {
+	return (sum.a & 0xffff) | (sum.b << 16)
+}
+
+// BinaryDiff returns an rsync-style delta that transforms source into target: blocks of the source found in the
+// target are copied by reference, and everything else is inserted literally.  Apply it with BinaryPatch.
+func BinaryDiff(source, target []byte) []byte {
+
+	// Index the source's blocks by checksum
+	blocks := make(map[uint32][]int, len(source)/kBinaryDeltaBlockSize)
+	for offset := 0; offset+kBinaryDeltaBlockSize <= len(source); offset += kBinaryDeltaBlockSize {
+		value := newRollingChecksum(source[offset : offset+kBinaryDeltaBlockSize]).value()
+		blocks[value] = append(blocks[value], offset)
+	}
+
+	var delta bytes.Buffer
+	var varint [binary.MaxVarintLen64]byte
+	writeUvarint := func(n int) {
+		delta.Write(varint[:binary.PutUvarint(varint[:], uint64(n))])
+	}
+	literalStart := 0
+	flushLiteral := func(end int) {
+		if end > literalStart {
+			delta.WriteByte(binaryDeltaInsert)
+			writeUvarint(end - literalStart)
+			delta.Write(target[literalStart:end])
+		}
+	}
+
+	pos := 0
+	var sum rollingChecksum
+	if len(target) >= kBinaryDeltaBlockSize {
+		sum = newRollingChecksum(target[:kBinaryDeltaBlockSize])
+	}
+	for pos+kBinaryDeltaBlockSize <= len(target) {
+		matchOffset := -1
+		for _, offset := range blocks[sum.value()] {
+			if bytes.Equal(source[offset:offset+kBinaryDeltaBlockSize], target[pos:pos+kBinaryDeltaBlockSize]) {
+				matchOffset = offset
+				break
+			}
+		}
+
+		if matchOffset < 0 {
+			if pos+kBinaryDeltaBlockSize < len(target) {
+				sum.roll(target[pos], target[pos+kBinaryDeltaBlockSize])
+			}
+			pos++
+			continue
+		}
+
+		// Extend the match as far as the source and target keep matching
+		matchLen := kBinaryDeltaBlockSize
+		for matchOffset+matchLen < len(source) && pos+matchLen < len(target) && source[matchOffset+matchLen] == target[pos+matchLen] {
+			matchLen++
+		}
+		flushLiteral(pos)
+		delta.WriteByte(binaryDeltaCopy)
+		writeUvarint(matchOffset)
+		writeUvarint(matchLen)
+
+		pos += matchLen
+		literalStart = pos
+		if pos+kBinaryDeltaBlockSize <= len(target) {
+			sum = newRollingChecksum(target[pos : pos+kBinaryDeltaBlockSize])
+		}
+	}
+	flushLiteral(len(target))
+	return delta.Bytes()
+}
+
+// BinaryPatch applies a delta generated by BinaryDiff to source, and returns the target.
+func BinaryPatch(source, delta []byte) ([]byte, error) {
+	var target bytes.Buffer
+	reader := bytes.NewReader(delta)
+	readUvarint := func() (int, error) {
+		n, err := binary.ReadUvarint(reader)
+		if err != nil || n > uint64(len(delta))+uint64(len(source)) {
+			return 0, errInvalidBinaryDelta
+		}
+		return int(n), nil
+	}
+
+	for {
+		instruction, err := reader.ReadByte()
+		if err != nil {
+			return target.Bytes(), nil
+		}
+		switch instruction {
+		case binaryDeltaCopy:
+			offset, err := readUvarint()
+			if err != nil {
+				return nil, err
+			}
+			length, err := readUvarint()
+			if err != nil {
+				return nil, err
+			}
+			if offset+length > len(source) {
+				return nil, errInvalidBinaryDelta
+			}
+			target.Write(source[offset : offset+length])
+		case binaryDeltaInsert:
+			length, err := readUvarint()
+			if err != nil {
+				return nil, err
+			}
+			if length > reader.Len() {
+				return nil, errInvalidBinaryDelta
+			}
+			literal := make([]byte, length)
+			_, _ = reader.Read(literal)
+			target.Write(literal)
+		default:
+			return nil, errInvalidBinaryDelta
+		}
+	}
+}
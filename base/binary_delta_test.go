@@ -0,0 +1,46 @@
+package base
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBinaryDelta(t *testing.T) {
+	random := rand.New(rand.NewSource(42))
+	source := make([]byte, 64*1024)
+	random.Read(source)
+
+	// Edit the source in a few places: overwrite, insert, delete and append
+	target := append([]byte{}, source[:1000]...)
+	target = append(target, []byte("inserted bytes")...)
+	target = append(target, source[1000:20000]...)
+	target = append(target, source[30000:50000]...)
+	target = append(target, bytes.Repeat([]byte{'x'}, 500)...)
+	target = append(target, source[50500:]...)
+	target = append(target, []byte("appended")...)
+
+	delta := BinaryDiff(source, target)
+	assert.True(t, len(delta) < 1024, "Delta should only contain the edits, was %d bytes", len(delta))
+	patched, err := BinaryPatch(source, delta)
+	assert.NoError(t, err)
+	assert.Equal(t, target, patched)
+
+	// Unrelated and short inputs
+	for _, pair := range [][2][]byte{{source, []byte("short")}, {[]byte("short"), source}, {nil, nil}, {source, nil}} {
+		patched, err = BinaryPatch(pair[0], BinaryDiff(pair[0], pair[1]))
+		assert.NoError(t, err)
+		assert.Equal(t, len(pair[1]), len(patched))
+		assert.True(t, bytes.Equal(pair[1], patched))
+	}
+
+	// Invalid deltas
+	_, err = BinaryPatch([]byte("short"), []byte{binaryDeltaCopy, 0, 10})
+	assert.Error(t, err)
+	_, err = BinaryPatch(source, []byte{binaryDeltaInsert, 10, 'a'})
+	assert.Error(t, err)
+	_, err = BinaryPatch(source, []byte("?"))
+	assert.Error(t, err)
+}
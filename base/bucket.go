@@ -99,18 +99,17 @@ type BucketSpec struct {
 	Server, PoolName, BucketName, FeedType string
 	Auth                                   AuthHandler
 	CouchbaseDriver                        CouchbaseDriver
-	Certpath, Keypath, CACertPath          string         // X.509 auth parameters
-	KvTLSPort                              int            // Port to use for memcached over TLS.  Required for cbdatasource auth when using TLS
-	MaxNumRetries                          int            // max number of retries before giving up
-	InitialRetrySleepTimeMS                int            // the initial time to sleep in between retry attempts (in millisecond), which will double each retry
-	UseXattrs                              bool           // Whether to use xattrs to store _sync metadata.  Used during view initialization
-	ViewQueryTimeoutSecs                   *uint32        // the view query timeout in seconds (default: 75 seconds)
-	BucketOpTimeout                        *time.Duration // How long bucket ops should block returning "operation timed out". If nil, uses GoCB default.  GoCB buckets only.
-}
-
-// Create a RetrySleeper based on the bucket spec properties.  Used to retry bucket operations after transient errors.
-func (spec BucketSpec) RetrySleeper() RetrySleeper {
-	return CreateDoublingSleeperFunc(spec.MaxNumRetries, spec.InitialRetrySleepTimeMS)
+	Certpath, Keypath, CACertPath          string                // X.509 auth parameters.  CACertPath may be a bundle of several PEM certs
+	TLSSkipVerify                          *bool                 // Whether to skip verification of the server's TLS cert.  If nil, it's verified only when CACertPath is set
+	KvTLSPort                              int                   // Port to use for memcached over TLS.  Required for cbdatasource auth when using TLS
+	RetryPolicy                            *RetryPolicy          // Retry policy for bucket operations that fail with transient errors.  If nil, uses DefaultRetryPolicy
+	CircuitBreaker                         *CircuitBreakerConfig // Circuit breaker for bucket operations.  If nil, not enabled.  GoCB buckets only.
+	UseXattrs                              bool                  // Whether to use xattrs to store _sync metadata.  Used during view initialization
+	ViewQueryTimeoutSecs                   *uint32               // the view query timeout in seconds (default: 75 seconds)
+	BucketOpTimeout                        *time.Duration        // How long bucket ops should block returning "operation timed out". If nil, uses GoCB default.  GoCB buckets only.
+	WalrusSnapshotPath                     string                // File to persist walrus bucket contents to, and restore them from when opened.  Walrus buckets only.
+	WalrusSnapshotInterval                 time.Duration         // How often to persist walrus bucket contents.  If zero, uses kDefaultWalrusSnapshotInterval
+	DatabaseName                           string                // Name of the database the bucket's opened for, if any, which is added to the bucket's log entries
 }
 
 func (spec BucketSpec) IsWalrusBucket() bool {
@@ -128,6 +127,49 @@ func (spec BucketSpec) UseClientCert() bool {
 	return true
 }
 
+// Returns true if the spec authenticates as an RBAC user, rather than with a legacy bucket password.  Legacy
+// credentials use the bucket name as the username.
+func (spec BucketSpec) UseRBACCredentials() bool {
+	if spec.Auth == nil || spec.UseClientCert() {
+		return false
+	}
+	username, _, _ := spec.Auth.GetCredentials()
+	return username != "" && username != spec.BucketName
+}
+
+// Returns true if the server's TLS certificate shouldn't be verified.  Unless TLSSkipVerify is set, the certificate
+// is only verified when a root CA cert is provided to verify it against.
+func (spec BucketSpec) skipTLSVerify() bool {
+	if spec.TLSSkipVerify != nil {
+		return *spec.TLSSkipVerify
+	}
+	return spec.CACertPath == ""
+}
+
+// ValidateTLS returns an error if the spec's TLS and X.509 authentication settings are inconsistent.
+func (spec BucketSpec) ValidateTLS() error {
+	if (spec.Certpath == "") != (spec.Keypath == "") {
+		return errors.New("certpath and keypath must both be set to use X.509 authentication")
+	}
+	if spec.UseClientCert() && !spec.IsTLS() {
+		return fmt.Errorf("X.509 authentication requires a couchbases:// or https:// server, not %s", SD(spec.Server))
+	}
+	if spec.IsTLS() && spec.TLSSkipVerify != nil && !*spec.TLSSkipVerify && spec.CACertPath == "" {
+		return errors.New("cacertpath must be set to verify the server's TLS certificate")
+	}
+	return nil
+}
+
+// TLSConfig returns the config for TLS connections to the server, including the client cert when using X.509
+// authentication.
+func (spec BucketSpec) TLSConfig() (*tls.Config, error) {
+	cacertpath := spec.CACertPath
+	if spec.skipTLSVerify() {
+		cacertpath = ""
+	}
+	return TLSConfigForX509(spec.Certpath, spec.Keypath, cacertpath)
+}
+
 // Builds a gocb connection string based on BucketSpec.Server.
 // Adds idle connection configuration, and X.509 auth settings when
 // certpath/keypath/cacertpath specified.
@@ -152,7 +194,8 @@ func (spec BucketSpec) GetGoCBConnString() (string, error) {
 		asValues.Set("certpath", spec.Certpath)
 		asValues.Set("keypath", spec.Keypath)
 	}
-	if spec.CACertPath != "" {
+	// gocb only verifies the server's certificate when given a root CA cert
+	if spec.CACertPath != "" && !spec.skipTLSVerify() {
 		asValues.Set("cacertpath", spec.CACertPath)
 	}
 
@@ -213,7 +256,7 @@ func (b BucketSpec) TLSConnect(prot, dest string) (rv *memcached.Client, err err
 			return nil, pkgerrors.Wrapf(err, "Error setting NoDelay on tcpConn during TLS Connect")
 		}
 
-		tlsConfig, configErr := TLSConfigForX509(b.Certpath, b.Keypath, b.CACertPath)
+		tlsConfig, configErr := b.TLSConfig()
 		if configErr != nil {
 			return nil, pkgerrors.Wrapf(configErr, "Error creating TLSConfig for DCP TLS connection")
 		}
@@ -243,6 +286,7 @@ func TLSConfigForX509(certpath, keypath, cacertpath string) (*tls.Config, error)
 			if err != nil {
 				return nil, err
 			}
+			// The file may be a bundle of several certs, all of which are trusted
 			ok := rootCerts.AppendCertsFromPEM(cacert)
 			if !ok {
 				return nil, fmt.Errorf("can't append certs from PEM")
@@ -373,6 +417,14 @@ func GetBucket(spec BucketSpec, callback sgbucket.BucketNotifyFn) (bucket Bucket
 		Infof(KeyAll, "Opening Walrus database %s on <%s>", MD(spec.BucketName), SD(spec.Server))
 		sgbucket.SetLogging(ConsoleLogKey().Enabled(KeyBucket))
 		bucket, err = walrus.GetBucket(spec.Server, spec.PoolName, spec.BucketName)
+		if err != nil {
+			return nil, err
+		}
+		walrusBucket := bucket
+		if bucket, err = NewWalrusSnapshotBucket(walrusBucket, spec.WalrusSnapshotPath, spec.WalrusSnapshotInterval); err != nil {
+			walrusBucket.Close()
+			return nil, err
+		}
 		// If feed type is not specified (defaults to DCP) or isn't TAP, wrap with pseudo-vbucket handling for walrus
 		if spec.FeedType == "" || spec.FeedType != TapFeedType {
 			bucket = &LeakyBucket{bucket: bucket, config: LeakyBucketConfig{TapFeedVbuckets: true}}
@@ -383,7 +435,7 @@ func GetBucket(spec BucketSpec, callback sgbucket.BucketNotifyFn) (bucket Bucket
 		if spec.Auth != nil {
 			username, _, _ = spec.Auth.GetCredentials()
 		}
-		Infof(KeyAll, "%v Opening Couchbase database %s on <%s> as user %q", spec.CouchbaseDriver, MD(spec.BucketName), SD(spec.Server), UD(username))
+		Infof(KeyAll, "%v Opening Couchbase database %s on <%s> as user %q", spec.CouchbaseDriver, MD(spec.BucketName), SD(spec.Server), UDUsername(username))
 
 		switch spec.CouchbaseDriver {
 		case GoCB, GoCBCustomSGTranscoder:
@@ -399,7 +451,7 @@ func GetBucket(spec BucketSpec, callback sgbucket.BucketNotifyFn) (bucket Bucket
 
 		if err != nil {
 			if pkgerrors.Cause(err) == gocb.ErrAuthError {
-				Warnf(KeyAll, "Unable to authenticate as user %q: %v", UD(username), err)
+				Warnf(KeyAll, "Unable to authenticate as user %q: %v", UDUsername(username), err)
 				return nil, ErrFatalBucketConnection
 			}
 			return nil, err
@@ -420,8 +472,8 @@ func GetBucket(spec BucketSpec, callback sgbucket.BucketNotifyFn) (bucket Bucket
 
 	}
 
-	if LogDebugEnabled(KeyBucket) {
-		bucket = &LoggingBucket{bucket: bucket}
+	if LogDebugEnabled(KeyBucket) || SlowBucketOpWarningThreshold > 0 {
+		bucket = NewLoggingBucket(bucket, spec.DatabaseName)
 	}
 	return
 }
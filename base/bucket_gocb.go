@@ -49,25 +49,30 @@ const (
 	xattrMacroValueCrc32c = "value_crc32c"
 )
 
-var recoverableGoCBErrors = map[string]struct{}{
-	gocbcore.ErrTimeout.Error():  {},
-	gocbcore.ErrOverload.Error(): {},
-	gocbcore.ErrBusy.Error():     {},
-	gocbcore.ErrTmpFail.Error():  {},
+var recoverableGoCBErrors = map[string]RetryErrorClass{
+	gocbcore.ErrTimeout.Error():  RetryErrorTimeout,
+	gocbcore.ErrOverload.Error(): RetryErrorOverload,
+	gocbcore.ErrBusy.Error():     RetryErrorBusy,
+	gocbcore.ErrTmpFail.Error():  RetryErrorTempFail,
 }
 
 // Implementation of sgbucket.Bucket that talks to a Couchbase server and uses gocb
 type CouchbaseBucketGoCB struct {
-	*gocb.Bucket               // the underlying gocb bucket
-	spec         BucketSpec    // keep a copy of the BucketSpec for DCP usage
-	singleOps    chan struct{} // Manages max concurrent single ops (per kv node)
-	bulkOps      chan struct{} // Manages max concurrent bulk ops (per kv node)
-	viewOps      chan struct{} // Manages max concurrent view ops (per kv node)
+	*gocb.Bucket                 // the underlying gocb bucket
+	spec         BucketSpec      // keep a copy of the BucketSpec for DCP usage
+	singleOps    chan struct{}   // Manages max concurrent single ops (per kv node)
+	bulkOps      chan struct{}   // Manages max concurrent bulk ops (per kv node)
+	viewOps      chan struct{}   // Manages max concurrent view ops (per kv node)
+	breaker      *CircuitBreaker // Fails ops fast while the server is degraded, or nil if not enabled
 }
 
 // Creates a Bucket that talks to a real live Couchbase server.
 func GetCouchbaseBucketGoCB(spec BucketSpec) (bucket *CouchbaseBucketGoCB, err error) {
 
+	if err := spec.ValidateTLS(); err != nil {
+		return nil, err
+	}
+
 	// TODO: Push the above down into spec.GetConnString
 	connString, err := spec.GetGoCBConnString()
 	if err != nil {
@@ -83,7 +88,7 @@ func GetCouchbaseBucketGoCB(spec BucketSpec) (bucket *CouchbaseBucketGoCB, err e
 
 	password := ""
 	// Check for client cert (x.509) authentication
-	if spec.Certpath != "" {
+	if spec.UseClientCert() {
 		certAuthErr := cluster.Authenticate(gocb.CertificateAuthenticator{})
 		if certAuthErr != nil {
 			Infof(KeyAuth, "Error Attempting certificate authentication %s", certAuthErr)
@@ -96,8 +101,13 @@ func GetCouchbaseBucketGoCB(spec BucketSpec) (bucket *CouchbaseBucketGoCB, err e
 			Username: user,
 			Password: pass,
 		})
-		// If RBAC authentication fails, revert to non-RBAC authentication by including the password to OpenBucket
 		if authErr != nil {
+			// RBAC users have no bucket password to fall back to
+			if spec.UseRBACCredentials() {
+				Warnf(KeyAuth, "RBAC authentication against bucket %s as user %s failed: %v", MD(spec.BucketName), UD(user), authErr)
+				return nil, pkgerrors.WithStack(authErr)
+			}
+			// If RBAC authentication fails, revert to non-RBAC authentication by including the password to OpenBucket
 			Warnf(KeyAuth, "RBAC authentication against bucket %s as user %s failed - will re-attempt w/ bucketname, password", MD(spec.BucketName), UD(user))
 			password = pass
 		}
@@ -130,8 +140,7 @@ func GetCouchbaseBucketGoCB(spec BucketSpec) (bucket *CouchbaseBucketGoCB, err e
 		goCBBucket.SetTranscoder(SGTranscoder{})
 	}
 
-	spec.MaxNumRetries = 10
-	spec.InitialRetrySleepTimeMS = 5
+	spec.RetryPolicy = spec.RetryPolicy.WithDefaults()
 
 	// Identify number of nodes to use as a multiplier for MaxConcurrentOps, since gocb maintains one pipeline per data node.
 	// TODO: We don't currently have a process to monitor cluster changes behind a gocb bucket.  When that's available, should
@@ -150,6 +159,10 @@ func GetCouchbaseBucketGoCB(spec BucketSpec) (bucket *CouchbaseBucketGoCB, err e
 		make(chan struct{}, MaxConcurrentSingleOps*nodeCount),
 		make(chan struct{}, MaxConcurrentBulkOps*nodeCount),
 		make(chan struct{}, MaxConcurrentViewOps*nodeCount),
+		nil,
+	}
+	if spec.CircuitBreaker != nil {
+		bucket.breaker = NewCircuitBreaker(spec.BucketName, spec.CircuitBreaker, isTransientGoCBError)
 	}
 
 	bucket.Bucket.SetViewTimeout(bucket.spec.GetViewQueryTimeout())
@@ -332,13 +345,13 @@ func (bucket *CouchbaseBucketGoCB) Get(k string, rv interface{}) (cas uint64, er
 	}()
 	worker := func() (shouldRetry bool, err error, value interface{}) {
 		casGoCB, err := bucket.Bucket.Get(k, rv)
-		shouldRetry = isRecoverableGoCBError(err)
+		shouldRetry = bucket.isRecoverableGoCBError(err)
 		return shouldRetry, err, uint64(casGoCB)
 	}
 
 	// Kick off retry loop
 	description := fmt.Sprintf("Get %v", k)
-	err, result := RetryLoop(description, worker, bucket.spec.RetrySleeper())
+	err, result := bucket.retryLoop(description, worker)
 
 	// If the retry loop returned a nil result, set to 0 to prevent type assertion on nil error
 	if result == nil {
@@ -368,7 +381,7 @@ func (bucket *CouchbaseBucketGoCB) SetBulk(entries []*sgbucket.BulkSetEntry) (er
 
 	// Kick off retry loop
 	description := fmt.Sprintf("SetBulk with %v entries", len(entries))
-	err, _ = RetryLoop(description, worker, bucket.spec.RetrySleeper())
+	err, _ = bucket.retryLoop(description, worker)
 
 	return err
 
@@ -448,13 +461,13 @@ func (bucket *CouchbaseBucketGoCB) processBulkSetEntriesBatch(entries []*sgbucke
 		case *gocb.InsertOp:
 			entry.Cas = uint64(item.Cas)
 			entry.Error = item.Err
-			if item.Err != nil && isRecoverableGoCBError(item.Err) {
+			if item.Err != nil && bucket.isRecoverableGoCBError(item.Err) {
 				retryEntries = append(retryEntries, entry)
 			}
 		case *gocb.ReplaceOp:
 			entry.Cas = uint64(item.Cas)
 			entry.Error = item.Err
-			if item.Err != nil && isRecoverableGoCBError(item.Err) {
+			if item.Err != nil && bucket.isRecoverableGoCBError(item.Err) {
 				retryEntries = append(retryEntries, entry)
 			}
 		}
@@ -482,7 +495,7 @@ func (bucket *CouchbaseBucketGoCB) GetBulkRaw(keys []string) (map[string][]byte,
 
 	// Kick off retry loop
 	description := fmt.Sprintf("GetBulkRaw with %v keys", len(keys))
-	err, result := RetryLoop(description, worker, bucket.spec.RetrySleeper())
+	err, result := bucket.retryLoop(description, worker)
 
 	// If the RetryLoop returns a nil result, convert to an empty map.
 	if result == nil {
@@ -517,7 +530,7 @@ func (bucket *CouchbaseBucketGoCB) GetBulkCounters(keys []string) (map[string]ui
 
 	// Kick off retry loop
 	description := fmt.Sprintf("GetBulkRaw with %v keys", len(keys))
-	err, result := RetryLoop(description, worker, bucket.spec.RetrySleeper())
+	err, result := bucket.retryLoop(description, worker)
 
 	// If the RetryLoop returns a nil result, convert to an empty map.
 	if result == nil {
@@ -644,7 +657,7 @@ func (bucket *CouchbaseBucketGoCB) processGetRawBatch(keys []string, resultAccum
 			}
 		} else {
 			// if it's a recoverable error, then throw it in retry collection.
-			if isRecoverableGoCBError(getOp.Err) {
+			if bucket.isRecoverableGoCBError(getOp.Err) {
 				retryKeys = append(retryKeys, getOp.Key)
 			}
 		}
@@ -684,7 +697,7 @@ func (bucket *CouchbaseBucketGoCB) processGetCountersBatch(keys []string, result
 			}
 		} else {
 			// if it's a recoverable error, then throw it in retry collection.
-			if isRecoverableGoCBError(getOp.Err) {
+			if bucket.isRecoverableGoCBError(getOp.Err) {
 				retryKeys = append(retryKeys, getOp.Key)
 			}
 		}
@@ -771,19 +784,41 @@ var doSingleFakeRecoverableGOCBError = false
 // 2) WARNING: WriteCasRaw got error when calling GetRaw:%!(EXTRA gocbcore.overloadError=Queue overflow.) -- db.writeCasRaw() at crud.go:958
 //
 // Other errors, such as "key not found" errors, which happen on CAS update failures and other
-// situations, should not be treated as recoverable
+// situations, should not be treated as recoverable.  Recoverable errors are only retried if their class
+// is retried by the bucket's RetryPolicy.
 //
-func isRecoverableGoCBError(err error) bool {
+func (bucket *CouchbaseBucketGoCB) isRecoverableGoCBError(err error) bool {
 
 	if err == nil {
 		return false
 	}
 
-	_, ok := recoverableGoCBErrors[pkgerrors.Cause(err).Error()]
+	class, ok := recoverableGoCBErrors[pkgerrors.Cause(err).Error()]
+
+	return ok && bucket.spec.RetryPolicy.Retries(class)
+}
 
+// Returns true if the error is one of the transient errors a RetryPolicy can retry, whether or not the bucket's policy
+// retries it.
+func isTransientGoCBError(err error) bool {
+	_, ok := recoverableGoCBErrors[pkgerrors.Cause(err).Error()]
 	return ok
 }
 
+// Runs the worker in a retry loop following the bucket's retry policy.  If the bucket has a circuit breaker, the
+// loop counts as failed if it gives up on a transient error, and fails fast with ErrCircuitOpen while the breaker
+// is open.
+func (bucket *CouchbaseBucketGoCB) retryLoop(description string, worker RetryWorker) (err error, value interface{}) {
+	if bucket.breaker == nil {
+		return bucket.spec.RetryPolicy.RetryLoop(description, worker)
+	}
+	err = bucket.breaker.Run(func() error {
+		err, value = bucket.spec.RetryPolicy.RetryLoop(description, worker)
+		return err
+	})
+	return err, value
+}
+
 // If the error is a net/url.Error and the error message is:
 // 		net/http: request canceled while waiting for connection
 // Then it means that the view request timed out, most likely due to the fact that it's a stale=false query and
@@ -830,14 +865,14 @@ func (bucket *CouchbaseBucketGoCB) GetAndTouchRaw(k string, exp uint32) (rv []by
 	var returnVal []byte
 	worker := func() (shouldRetry bool, err error, value interface{}) {
 		casGoCB, err := bucket.Bucket.GetAndTouch(k, exp, &returnVal)
-		shouldRetry = isRecoverableGoCBError(err)
+		shouldRetry = bucket.isRecoverableGoCBError(err)
 		return shouldRetry, err, uint64(casGoCB)
 
 	}
 
 	// Kick off retry loop
 	description := fmt.Sprintf("GetAndTouchRaw with key %v", k)
-	err, result := RetryLoop(description, worker, bucket.spec.RetrySleeper())
+	err, result := bucket.retryLoop(description, worker)
 
 	// If the retry loop returned a nil result, set to 0 to prevent type assertion on nil error
 	if result == nil {
@@ -868,14 +903,14 @@ func (bucket *CouchbaseBucketGoCB) Touch(k string, exp uint32) (cas uint64, err
 
 	worker := func() (shouldRetry bool, err error, value interface{}) {
 		casGoCB, err := bucket.Bucket.Touch(k, 0, exp)
-		shouldRetry = isRecoverableGoCBError(err)
+		shouldRetry = bucket.isRecoverableGoCBError(err)
 		return shouldRetry, err, uint64(casGoCB)
 
 	}
 
 	// Kick off retry loop
 	description := fmt.Sprintf("Touch for key %v", k)
-	err, result := RetryLoop(description, worker, bucket.spec.RetrySleeper())
+	err, result := bucket.retryLoop(description, worker)
 
 	// If the retry loop returned a nil result, set to 0 to prevent type assertion on nil error
 	if result == nil {
@@ -901,14 +936,14 @@ func (bucket *CouchbaseBucketGoCB) Add(k string, exp uint32, v interface{}) (add
 	worker := func() (shouldRetry bool, err error, value interface{}) {
 
 		_, err = bucket.Bucket.Insert(k, v, exp)
-		if isRecoverableGoCBError(err) {
+		if bucket.isRecoverableGoCBError(err) {
 			return true, err, nil
 		}
 
 		return false, err, nil
 
 	}
-	err, _ = RetryLoop("CouchbaseBucketGoCB Add()", worker, bucket.spec.RetrySleeper())
+	err, _ = bucket.retryLoop("CouchbaseBucketGoCB Add()", worker)
 
 	if err != nil && err == gocb.ErrKeyExists {
 		return false, nil
@@ -929,14 +964,14 @@ func (bucket *CouchbaseBucketGoCB) AddRaw(k string, exp uint32, v []byte) (added
 	worker := func() (shouldRetry bool, err error, value interface{}) {
 
 		_, err = bucket.Bucket.Insert(k, bucket.FormatBinaryDocument(v), exp)
-		if isRecoverableGoCBError(err) {
+		if bucket.isRecoverableGoCBError(err) {
 			return true, err, nil
 		}
 
 		return false, err, nil
 
 	}
-	err, _ = RetryLoop("CouchbaseBucketGoCB AddRaw()", worker, bucket.spec.RetrySleeper())
+	err, _ = bucket.retryLoop("CouchbaseBucketGoCB AddRaw()", worker)
 
 	if err != nil {
 		if err == gocb.ErrKeyExists {
@@ -964,14 +999,14 @@ func (bucket *CouchbaseBucketGoCB) Set(k string, exp uint32, v interface{}) erro
 	worker := func() (shouldRetry bool, err error, value interface{}) {
 
 		_, err = bucket.Bucket.Upsert(k, v, exp)
-		if isRecoverableGoCBError(err) {
+		if bucket.isRecoverableGoCBError(err) {
 			return true, err, nil
 		}
 
 		return false, err, nil
 
 	}
-	err, _ := RetryLoop("CouchbaseBucketGoCB Set()", worker, bucket.spec.RetrySleeper())
+	err, _ := bucket.retryLoop("CouchbaseBucketGoCB Set()", worker)
 	if err != nil {
 		err = pkgerrors.WithStack(err)
 	}
@@ -989,14 +1024,14 @@ func (bucket *CouchbaseBucketGoCB) SetRaw(k string, exp uint32, v []byte) error
 	worker := func() (shouldRetry bool, err error, value interface{}) {
 
 		_, err = bucket.Bucket.Upsert(k, bucket.FormatBinaryDocument(v), exp)
-		if isRecoverableGoCBError(err) {
+		if bucket.isRecoverableGoCBError(err) {
 			return true, err, nil
 		}
 
 		return false, err, nil
 
 	}
-	err, _ := RetryLoop("CouchbaseBucketGoCB SetRaw()", worker, bucket.spec.RetrySleeper())
+	err, _ := bucket.retryLoop("CouchbaseBucketGoCB SetRaw()", worker)
 
 	return err
 }
@@ -1006,14 +1041,14 @@ func (bucket *CouchbaseBucketGoCB) Delete(k string) error {
 	worker := func() (shouldRetry bool, err error, value interface{}) {
 
 		_, err = bucket.Remove(k, 0)
-		if isRecoverableGoCBError(err) {
+		if bucket.isRecoverableGoCBError(err) {
 			return true, err, nil
 		}
 
 		return false, err, nil
 
 	}
-	err, _ := RetryLoop("CouchbaseBucketGoCB Delete()", worker, bucket.spec.RetrySleeper())
+	err, _ := bucket.retryLoop("CouchbaseBucketGoCB Delete()", worker)
 
 	return err
 
@@ -1029,14 +1064,14 @@ func (bucket *CouchbaseBucketGoCB) Remove(k string, cas uint64) (casOut uint64,
 	worker := func() (shouldRetry bool, err error, value interface{}) {
 
 		newCas, errRemove := bucket.Bucket.Remove(k, gocb.Cas(cas))
-		if isRecoverableGoCBError(errRemove) {
+		if bucket.isRecoverableGoCBError(errRemove) {
 			return true, errRemove, newCas
 		}
 
 		return false, errRemove, newCas
 
 	}
-	err, newCasVal := RetryLoop("CouchbaseBucketGoCB Remove()", worker, bucket.spec.RetrySleeper())
+	err, newCasVal := bucket.retryLoop("CouchbaseBucketGoCB Remove()", worker)
 	if newCasVal != nil {
 		casOut = uint64(newCasVal.(gocb.Cas))
 	}
@@ -1076,20 +1111,20 @@ func (bucket *CouchbaseBucketGoCB) WriteCas(k string, flags int, exp uint32, cas
 		if cas == 0 {
 			// Try to insert the value into the bucket
 			newCas, err := bucket.Bucket.Insert(k, v, exp)
-			shouldRetry = isRecoverableGoCBError(err)
+			shouldRetry = bucket.isRecoverableGoCBError(err)
 			return shouldRetry, err, uint64(newCas)
 		}
 
 		// Otherwise, replace existing value
 		newCas, err := bucket.Bucket.Replace(k, v, gocb.Cas(cas), exp)
-		shouldRetry = isRecoverableGoCBError(err)
+		shouldRetry = bucket.isRecoverableGoCBError(err)
 		return shouldRetry, err, uint64(newCas)
 
 	}
 
 	// Kick off retry loop
 	description := fmt.Sprintf("WriteCas with key %v", k)
-	err, result := RetryLoop(description, worker, bucket.spec.RetrySleeper())
+	err, result := bucket.retryLoop(description, worker)
 
 	// If the retry loop returned a nil result, set to 0 to prevent type assertion on nil error
 	if result == nil {
@@ -1140,7 +1175,7 @@ func (bucket *CouchbaseBucketGoCB) WriteCasWithXattr(k string, xattrKey string,
 			docFragment, err := mutateInBuilder.Execute()
 
 			if err != nil {
-				shouldRetry = isRecoverableGoCBError(err)
+				shouldRetry = bucket.isRecoverableGoCBError(err)
 				return shouldRetry, err, uint64(0)
 			}
 			return false, nil, uint64(docFragment.Cas())
@@ -1160,7 +1195,7 @@ func (bucket *CouchbaseBucketGoCB) WriteCasWithXattr(k string, xattrKey string,
 			docFragment, err := mutateInBuilder.Execute()
 
 			if err != nil {
-				shouldRetry = isRecoverableGoCBError(err)
+				shouldRetry = bucket.isRecoverableGoCBError(err)
 				return shouldRetry, err, uint64(0)
 			}
 			casOut = uint64(docFragment.Cas())
@@ -1175,7 +1210,7 @@ func (bucket *CouchbaseBucketGoCB) WriteCasWithXattr(k string, xattrKey string,
 			docFragment, err := mutateInBuilder.Execute()
 
 			if err != nil {
-				shouldRetry = isRecoverableGoCBError(err)
+				shouldRetry = bucket.isRecoverableGoCBError(err)
 				return shouldRetry, err, uint64(0)
 			}
 			casOut = uint64(docFragment.Cas())
@@ -1186,7 +1221,7 @@ func (bucket *CouchbaseBucketGoCB) WriteCasWithXattr(k string, xattrKey string,
 
 	// Kick off retry loop
 	description := fmt.Sprintf("WriteCasWithXattr with key %v", k)
-	err, result := RetryLoop(description, worker, bucket.spec.RetrySleeper())
+	err, result := bucket.retryLoop(description, worker)
 
 	// If the retry loop returned a nil result, set to 0 to prevent type assertion on nil error
 	if result == nil {
@@ -1242,7 +1277,7 @@ func (bucket *CouchbaseBucketGoCB) UpdateXattr(k string, xattrKey string, exp ui
 		docFragment, removeErr := builder.Execute()
 
 		if removeErr != nil {
-			shouldRetry = isRecoverableGoCBError(removeErr)
+			shouldRetry = bucket.isRecoverableGoCBError(removeErr)
 			return shouldRetry, removeErr, uint64(0)
 		}
 		return false, nil, uint64(docFragment.Cas())
@@ -1250,7 +1285,7 @@ func (bucket *CouchbaseBucketGoCB) UpdateXattr(k string, xattrKey string, exp ui
 
 	// Kick off retry loop
 	description := fmt.Sprintf("UpdateXattr with key %v", k)
-	err, result := RetryLoop(description, worker, bucket.spec.RetrySleeper())
+	err, result := bucket.retryLoop(description, worker)
 
 	// If the retry loop returned a nil result, set to 0 to prevent type assertion on nil error
 	if result == nil {
@@ -1316,7 +1351,7 @@ func (bucket *CouchbaseBucketGoCB) GetWithXattr(k string, xattrKey string, rv in
 			return false, nil, cas
 
 		default:
-			shouldRetry = isRecoverableGoCBError(lookupErr)
+			shouldRetry = bucket.isRecoverableGoCBError(lookupErr)
 			return shouldRetry, lookupErr, uint64(0)
 		}
 
@@ -1324,7 +1359,7 @@ func (bucket *CouchbaseBucketGoCB) GetWithXattr(k string, xattrKey string, rv in
 
 	// Kick off retry loop
 	description := fmt.Sprintf("GetWithXattr %v", k)
-	err, result := RetryLoop(description, worker, bucket.spec.RetrySleeper())
+	err, result := bucket.retryLoop(description, worker)
 
 	if result == nil {
 		return 0, err
@@ -1487,6 +1522,7 @@ func (bucket *CouchbaseBucketGoCB) deleteDocBodyOnly(k string, xattrKey string,
 
 func (bucket *CouchbaseBucketGoCB) Update(k string, exp uint32, callback sgbucket.UpdateFunc) (casOut uint64, err error) {
 
+	retry := bucket.spec.RetryPolicy.Retrier(fmt.Sprintf("CouchbaseBucketGoCB Update() for key: %v", k))
 	for {
 
 		var value []byte
@@ -1536,8 +1572,11 @@ func (bucket *CouchbaseBucketGoCB) Update(k string, exp uint32, callback sgbucke
 
 		if pkgerrors.Cause(err) == gocb.ErrKeyExists {
 			// retry on cas failure
-		} else if isRecoverableGoCBError(err) {
-			// retry on recoverable failure
+		} else if bucket.isRecoverableGoCBError(err) {
+			// retry on recoverable failure, backing off as per the retry policy
+			if !retry() {
+				return 0, err
+			}
 		} else {
 			// err will be nil if successful
 			return uint64(casGoCB), err
@@ -1549,6 +1588,7 @@ func (bucket *CouchbaseBucketGoCB) Update(k string, exp uint32, callback sgbucke
 
 func (bucket *CouchbaseBucketGoCB) WriteUpdate(k string, exp uint32, callback sgbucket.WriteUpdateFunc) (casOut uint64, err error) {
 
+	retry := bucket.spec.RetryPolicy.Retrier(fmt.Sprintf("CouchbaseBucketGoCB WriteUpdate() for key: %v", k))
 	for {
 		var value []byte
 		var err error
@@ -1614,8 +1654,11 @@ func (bucket *CouchbaseBucketGoCB) WriteUpdate(k string, exp uint32, callback sg
 
 		if pkgerrors.Cause(err) == gocb.ErrKeyExists {
 			// retry on cas failure
-		} else if isRecoverableGoCBError(err) {
-			// retry on recoverable failure
+		} else if bucket.isRecoverableGoCBError(err) {
+			// retry on recoverable failure, backing off as per the retry policy
+			if !retry() {
+				return 0, err
+			}
 		} else {
 			// err will be nil if successful
 			return uint64(casGoCB), err
@@ -1625,6 +1668,7 @@ func (bucket *CouchbaseBucketGoCB) WriteUpdate(k string, exp uint32, callback sg
 
 // WriteUpdateWithXattr retrieves the existing doc from the bucket, invokes the callback to update the document, then writes the new document to the bucket.  Will repeat this process on cas
 // failure.  If previousValue/xattr/cas are provided, will use those on the first iteration instead of retrieving from the bucket.
+// If the callback returns the existing body unchanged, only the xattr is written.
 func (bucket *CouchbaseBucketGoCB) WriteUpdateWithXattr(k string, xattrKey string, exp uint32, previous *sgbucket.BucketDocument, callback sgbucket.WriteUpdateWithXattrFunc) (casOut uint64, err error) {
 
 	var value []byte
@@ -1678,7 +1722,14 @@ func (bucket *CouchbaseBucketGoCB) WriteUpdateWithXattr(k string, xattrKey strin
 
 		// Attempt to write the updated document to the bucket.  Mark body for deletion if previous body was non-empty
 		deleteBody := len(value) > 0
-		casOut, writeErr := bucket.WriteWithXattr(k, xattrKey, exp, cas, updatedValue, updatedXattrValue, isDelete, deleteBody)
+		var casOut uint64
+		var writeErr error
+		if !isDelete && cas != 0 && len(value) > 0 && bytes.Equal(updatedValue, value) {
+			// The body is unchanged - use a sub-document mutation to update only the xattr, so the body isn't rewritten
+			casOut, writeErr = bucket.UpdateXattr(k, xattrKey, exp, cas, updatedXattrValue, false)
+		} else {
+			casOut, writeErr = bucket.WriteWithXattr(k, xattrKey, exp, cas, updatedValue, updatedXattrValue, isDelete, deleteBody)
+		}
 
 		switch pkgerrors.Cause(writeErr) {
 		case nil:
@@ -1738,14 +1789,14 @@ func (bucket *CouchbaseBucketGoCB) Incr(k string, amt, def uint64, exp uint32) (
 	worker := func() (shouldRetry bool, err error, value interface{}) {
 
 		result, _, err := bucket.Counter(k, int64(amt), int64(def), exp)
-		shouldRetry = isRecoverableGoCBError(err)
+		shouldRetry = bucket.isRecoverableGoCBError(err)
 		return shouldRetry, err, result
 
 	}
 
 	// Kick off retry loop
 	description := fmt.Sprintf("Incr with key: %v", k)
-	err, result := RetryLoop(description, worker, bucket.spec.RetrySleeper())
+	err, result := bucket.retryLoop(description, worker)
 
 	// If the retry loop returned a nil result, set to 0 to prevent type assertion on nil error
 	if result == nil {
@@ -2213,12 +2264,12 @@ func (bucket *CouchbaseBucketGoCB) GetStatsVbSeqno(maxVbno uint16, useAbsHighSeq
 
 	worker := func() (shouldRetry bool, err error, value interface{}) {
 		stats, err := bucket.Stats("vbucket-seqno")
-		shouldRetry = (err != nil && isRecoverableGoCBError(err))
+		shouldRetry = (err != nil && bucket.isRecoverableGoCBError(err))
 		return shouldRetry, err, stats
 	}
 
 	// Kick off retry loop
-	err, result := RetryLoop("getStatsVbSeqno", worker, bucket.spec.RetrySleeper())
+	err, result := bucket.retryLoop("getStatsVbSeqno", worker)
 	if err != nil {
 		return uuids, highSeqnos, err
 	}
@@ -2403,13 +2454,13 @@ func (bucket *CouchbaseBucketGoCB) GetExpiry(k string) (expiry uint32, getMetaEr
 
 	worker := func() (shouldRetry bool, err error, value interface{}) {
 		expirySingleAttempt, err := bucket.getExpirySingleAttempt(k)
-		shouldRetry = (err != nil && isRecoverableGoCBError(err))
+		shouldRetry = (err != nil && bucket.isRecoverableGoCBError(err))
 		return shouldRetry, err, uint32(expirySingleAttempt)
 	}
 
 	// Kick off retry loop
 	description := fmt.Sprintf("getExpiry for key: %v", k)
-	err, result := RetryLoop(description, worker, bucket.spec.RetrySleeper())
+	err, result := bucket.retryLoop(description, worker)
 
 	// If the retry loop returned a nil result, set to 0 to prevent type assertion on nil error
 	if result == nil {
@@ -1123,6 +1123,55 @@ func TestXattrWriteUpdateXattr(t *testing.T) {
 
 }
 
+// TestXattrWriteUpdateXattrOnly.  Validates that a WriteUpdateWithXattr callback returning the existing body unchanged
+// updates the xattr and leaves the body as-is.
+func TestXattrWriteUpdateXattrOnly(t *testing.T) {
+
+	SkipXattrTestsIfNotEnabled(t)
+
+	testBucket := GetTestBucketOrPanic()
+	defer testBucket.Close()
+
+	bucket, ok := testBucket.Bucket.(*CouchbaseBucketGoCB)
+	if !ok {
+		log.Printf("Can't cast to bucket")
+		return
+	}
+	bucket.SetTranscoder(SGTranscoder{})
+
+	key := "TestWriteUpdateXattrOnly"
+	xattrName := "_sync"
+	body := []byte(`{"counter": 1}`)
+	_, err := bucket.WriteCasWithXattr(key, xattrName, 0, 0, body, map[string]interface{}{"seq": float64(1)})
+	assert.NoError(t, err, "Error doing WriteCasWithXattr")
+
+	writeUpdateFunc := func(current []byte, xattr []byte, cas uint64) (updatedDoc []byte, updatedXattr []byte, isDelete bool, expiry *uint32, err error) {
+		xattrMap := make(map[string]interface{})
+		if err := json.Unmarshal(xattr, &xattrMap); err != nil {
+			return nil, nil, false, nil, err
+		}
+		xattrMap["seq"] = xattrMap["seq"].(float64) + 1
+		updatedXattr, err = json.Marshal(xattrMap)
+		return current, updatedXattr, false, nil, err
+	}
+
+	casOut, err := bucket.WriteUpdateWithXattr(key, xattrName, 0, nil, writeUpdateFunc)
+	assert.NoError(t, err, "Error doing WriteUpdateWithXattr")
+
+	var retrievedVal []byte
+	var retrievedXattr map[string]interface{}
+	cas, err := bucket.GetWithXattr(key, xattrName, &retrievedVal, &retrievedXattr)
+	assert.NoError(t, err, "Error doing GetWithXattr")
+	assert.Equal(t, casOut, cas)
+	assert.Equal(t, string(body), string(retrievedVal))
+	assert.Equal(t, float64(2), retrievedXattr["seq"])
+
+	// The xattr is stamped with the cas of the sub-document mutation
+	casProperty, ok := retrievedXattr[xattrMacroCas].(string)
+	assert.True(t, ok)
+	assert.Equal(t, cas, HexCasToUint64(casProperty))
+}
+
 // TestXattrDeleteDocument.  Delete document that has a system xattr.  System XATTR should be retained and retrievable.
 func TestXattrDeleteDocument(t *testing.T) {
 
@@ -1,6 +1,7 @@
 package base
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"strings"
@@ -25,6 +26,47 @@ type N1qlIndexOptions struct {
 	DeferBuild      bool `json:"defer_build,omitempty"`          // Whether to defer initial build of index (requires a subsequent BUILD INDEX invocation)
 }
 
+// N1QLBucket is implemented by buckets that support N1QL queries against GSI indexes, and management of those
+// indexes.  Use AsN1QLBucket to obtain one from a Bucket, which doesn't itself include these methods.
+type N1QLBucket interface {
+	GetName() string
+	Query(statement string, params interface{}, consistency gocb.ConsistencyMode, adhoc bool) (results gocb.QueryResults, err error)
+	ExplainQuery(statement string, params interface{}) (plan map[string]interface{}, err error)
+	CreateIndex(indexName string, expression string, filterExpression string, options *N1qlIndexOptions) error
+	CreatePrimaryIndex(indexName string, options *N1qlIndexOptions) error
+	BuildDeferredIndexes(indexSet []string) error
+	WaitForIndexOnline(indexName string) error
+	GetIndexMeta(indexName string) (exists bool, meta *gocb.IndexInfo, err error)
+	DropIndex(indexName string) error
+}
+
+var _ N1QLBucket = &CouchbaseBucketGoCB{}
+
+// Returns the N1QLBucket underlying the given bucket, unwrapping any logging, stats or test wrappers.  Returns false
+// if the bucket doesn't support N1QL, e.g. walrus buckets.
+func AsN1QLBucket(bucket Bucket) (N1QLBucket, bool) {
+	switch typedBucket := bucket.(type) {
+	case N1QLBucket:
+		return typedBucket, true
+	case *LoggingBucket:
+		return AsN1QLBucket(typedBucket.GetUnderlyingBucket())
+	case *LeakyBucket:
+		return AsN1QLBucket(typedBucket.GetUnderlyingBucket())
+	case *StatsBucket:
+		return AsN1QLBucket(typedBucket.bucket)
+	case TestBucket:
+		return AsN1QLBucket(typedBucket.Bucket)
+	default:
+		return nil, false
+	}
+}
+
+// IsN1QLBucket returns true if N1QL queries can be run against the given bucket.
+func IsN1QLBucket(bucket Bucket) bool {
+	_, ok := AsN1QLBucket(bucket)
+	return ok
+}
+
 // Query accepts a parameterized statement,  optional list of params, and an optional flag to force adhoc query execution.
 // Params specified using the $param notation in the statement are intended to be used w/ N1QL prepared statements, and will be
 // passed through as params to n1ql.  e.g.:
@@ -368,9 +410,7 @@ func QueryCloseErrors(closeError error) []error {
 }
 
 func SlowQueryLog(startTime time.Time, messageFormat string, args ...interface{}) {
-	if elapsed := time.Now().Sub(startTime); elapsed > SlowQueryWarningThreshold {
-		Infof(KeyQuery, messageFormat+" took "+elapsed.String(), args...)
-	}
+	SlowOperationLog(context.TODO(), SlowQueryWarningThreshold, startTime, KeyQuery, messageFormat, args...)
 }
 
 // Converts to a format like `value1`,`value2` when quote=`
@@ -574,3 +574,21 @@ func tearDownTestIndex(bucket *CouchbaseBucketGoCB, indexName string) (err error
 	}
 
 }
+
+func TestAsN1QLBucket(t *testing.T) {
+
+	testBucket := GetTestBucketOrPanic()
+	defer testBucket.Close()
+
+	// Test buckets, and any logging or leaky wrappers around them, are N1QL-capable only against Couchbase Server
+	isCouchbase := !UnitTestUrlIsWalrus()
+	assert.Equal(t, isCouchbase, IsN1QLBucket(testBucket))
+	assert.Equal(t, isCouchbase, IsN1QLBucket(&LoggingBucket{bucket: testBucket.Bucket}))
+	assert.Equal(t, isCouchbase, IsN1QLBucket(&LeakyBucket{bucket: &LoggingBucket{bucket: testBucket.Bucket}}))
+
+	n1qlBucket, ok := AsN1QLBucket(NewStatsBucket(testBucket.Bucket))
+	assert.Equal(t, isCouchbase, ok)
+	if isCouchbase {
+		assert.Equal(t, testBucket.Bucket.GetName(), n1qlBucket.GetName())
+	}
+}
@@ -44,6 +44,74 @@ func TestBucketSpec(t *testing.T) {
 
 }
 
+func TestBucketSpecRBACCredentials(t *testing.T) {
+
+	bucketSpec := BucketSpec{
+		Server:     "http://localhost:8091",
+		BucketName: "mybucket",
+	}
+	assert.False(t, bucketSpec.UseRBACCredentials())
+
+	// Legacy bucket password credentials
+	bucketSpec.Auth = TestAuthenticator{Username: "mybucket", Password: "password", BucketName: "mybucket"}
+	assert.False(t, bucketSpec.UseRBACCredentials())
+
+	bucketSpec.Auth = TestAuthenticator{Username: "myuser", Password: "password", BucketName: "mybucket"}
+	assert.True(t, bucketSpec.UseRBACCredentials())
+
+	auth := RBACAuthHandler{handler: bucketSpec.Auth}
+	username, password, bucketName := auth.GetCredentials()
+	assert.Equal(t, []string{"myuser", "password", "mybucket"}, []string{username, password, bucketName})
+	username, password = auth.GetSaslCredentials()
+	assert.Equal(t, []string{"myuser", "password"}, []string{username, password})
+
+	// Client certs take precedence over credentials
+	bucketSpec.Server = "couchbases://localhost"
+	bucketSpec.Certpath = "/myCertPath"
+	bucketSpec.Keypath = "/my/key/path"
+	assert.False(t, bucketSpec.UseRBACCredentials())
+}
+
+func TestBucketSpecTLS(t *testing.T) {
+
+	queryTimeout := uint32(30)
+	skipVerify := true
+	verify := false
+	bucketSpec := BucketSpec{
+		Server:               "couchbases://localhost",
+		Certpath:             "/myCertPath",
+		Keypath:              "/my/key/path",
+		CACertPath:           "./myCACertPath",
+		ViewQueryTimeoutSecs: &queryTimeout,
+	}
+	assert.NoError(t, bucketSpec.ValidateTLS())
+	assert.False(t, bucketSpec.skipTLSVerify())
+
+	// Skipping verification omits the root CA cert, so that gocb doesn't verify the server's cert
+	bucketSpec.TLSSkipVerify = &skipVerify
+	assert.NoError(t, bucketSpec.ValidateTLS())
+	connStr, err := bucketSpec.GetGoCBConnString()
+	assert.NoError(t, err, "Error creating connection string for bucket spec")
+	goassert.Equals(t, connStr, "couchbases://localhost?certpath=%2FmyCertPath&http_idle_conn_timeout=90000&http_max_idle_conns=64000&http_max_idle_conns_per_host=256&keypath=%2Fmy%2Fkey%2Fpath&n1ql_timeout=30000")
+
+	// Verification requires a root CA cert
+	bucketSpec.TLSSkipVerify = &verify
+	bucketSpec.CACertPath = ""
+	assert.Error(t, bucketSpec.ValidateTLS())
+	bucketSpec.TLSSkipVerify = nil
+	assert.NoError(t, bucketSpec.ValidateTLS())
+	assert.True(t, bucketSpec.skipTLSVerify())
+
+	// Certpath and keypath must both be set
+	bucketSpec.Keypath = ""
+	assert.Error(t, bucketSpec.ValidateTLS())
+
+	// X.509 authentication requires TLS
+	bucketSpec.Keypath = "/my/key/path"
+	bucketSpec.Server = "http://localhost:8091"
+	assert.Error(t, bucketSpec.ValidateTLS())
+}
+
 func TestGetStatsVbSeqno(t *testing.T) {
 
 	// We'll artificially lower this here to make for easier test data
@@ -0,0 +1,165 @@
+package base
+
+import (
+	"expvar"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Defaults for a CircuitBreakerConfig's unset fields.
+const (
+	kDefaultCircuitBreakerFailureThreshold = 20
+	kDefaultCircuitBreakerOpenTimeoutMs    = 5000
+)
+
+// The state of a CircuitBreaker.
+type CircuitBreakerState string
+
+const (
+	CircuitBreakerClosed   CircuitBreakerState = "closed"    // Operations are allowed
+	CircuitBreakerOpen     CircuitBreakerState = "open"      // Operations fail fast with ErrCircuitOpen
+	CircuitBreakerHalfOpen CircuitBreakerState = "half_open" // A single probe operation is allowed, to check for recovery
+)
+
+// Configures a CircuitBreaker.  Zero-valued fields take their defaults.
+type CircuitBreakerConfig struct {
+	FailureThreshold int `json:"failure_threshold,omitempty"` // # of consecutive failed ops that trips the breaker - Default: 20
+	OpenTimeoutMs    int `json:"open_timeout_ms,omitempty"`   // How long the breaker stays open before probing for recovery - Default: 5000
+}
+
+// Validate returns an error if the config has invalid values.
+func (c *CircuitBreakerConfig) Validate() error {
+	if c.FailureThreshold < 0 || c.OpenTimeoutMs < 0 {
+		return fmt.Errorf("circuit breaker failure_threshold and open_timeout_ms must not be negative")
+	}
+	return nil
+}
+
+// A CircuitBreaker stops operations against a degraded backend from piling up.  Once FailureThreshold consecutive
+// operations fail, the breaker trips open and further operations fail fast with ErrCircuitOpen.  After OpenTimeoutMs,
+// a single probe operation is let through: if it succeeds the breaker closes again, otherwise it stays open for
+// another OpenTimeoutMs.
+type CircuitBreaker struct {
+	name                string               // Used for logging
+	failureThreshold    int                  // # of consecutive failures that trips the breaker
+	openTimeout         time.Duration        // How long the breaker stays open before probing
+	isFailure           func(err error) bool // Whether an operation's error counts towards tripping the breaker
+	lock                sync.Mutex           // Protects the fields below
+	state               CircuitBreakerState  // Current state
+	consecutiveFailures int                  // # of consecutive failed operations while closed
+	openedAt            time.Time            // When the breaker last tripped open
+	now                 func() time.Time     // Returns the current time.  Overridden in tests
+}
+
+// Creates a closed CircuitBreaker.  isFailure classifies the errors returned by operations - those it returns false
+// for, such as key not found errors, count as successes.
+func NewCircuitBreaker(name string, config *CircuitBreakerConfig, isFailure func(err error) bool) *CircuitBreaker {
+	cb := &CircuitBreaker{
+		name:             name,
+		failureThreshold: kDefaultCircuitBreakerFailureThreshold,
+		openTimeout:      kDefaultCircuitBreakerOpenTimeoutMs * time.Millisecond,
+		isFailure:        isFailure,
+		state:            CircuitBreakerClosed,
+		now:              time.Now,
+	}
+	if config != nil {
+		if config.FailureThreshold > 0 {
+			cb.failureThreshold = config.FailureThreshold
+		}
+		if config.OpenTimeoutMs > 0 {
+			cb.openTimeout = time.Duration(config.OpenTimeoutMs) * time.Millisecond
+		}
+	}
+	return cb
+}
+
+// State returns the breaker's current state.
+func (cb *CircuitBreaker) State() CircuitBreakerState {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	return cb.state
+}
+
+// Run calls op if the breaker allows it, and records the outcome.  Returns ErrCircuitOpen without calling op if the
+// breaker is open.
+func (cb *CircuitBreaker) Run(op func() error) error {
+	probe, err := cb.allow()
+	if err != nil {
+		return err
+	}
+	opErr := op()
+	cb.record(probe, opErr != nil && cb.isFailure(opErr))
+	return opErr
+}
+
+// Returns ErrCircuitOpen if the breaker doesn't allow an operation, otherwise whether the operation is a probe.
+func (cb *CircuitBreaker) allow() (probe bool, err error) {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	switch cb.state {
+	case CircuitBreakerClosed:
+		return false, nil
+	case CircuitBreakerOpen:
+		if cb.now().Sub(cb.openedAt) >= cb.openTimeout {
+			cb.state = CircuitBreakerHalfOpen
+			return true, nil
+		}
+	}
+	StatsCircuitBreakers().Add(StatKeyCircuitBreakerRejectedCount, 1)
+	return false, ErrCircuitOpen
+}
+
+// Records the outcome of an operation allowed by the breaker.
+func (cb *CircuitBreaker) record(probe bool, failed bool) {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	if probe {
+		if failed {
+			Warnf(KeyAll, "Circuit breaker for %s: probe operation failed, staying open for %v", MD(cb.name), cb.openTimeout)
+			cb.trip()
+		} else {
+			Infof(KeyAll, "Circuit breaker for %s: probe operation succeeded, closing", MD(cb.name))
+			cb.state = CircuitBreakerClosed
+			cb.consecutiveFailures = 0
+		}
+		return
+	}
+
+	// Only probes change the state of an open breaker - other operations may have been in flight when it tripped
+	if cb.state != CircuitBreakerClosed {
+		return
+	}
+
+	if !failed {
+		cb.consecutiveFailures = 0
+		return
+	}
+
+	cb.consecutiveFailures++
+	if cb.consecutiveFailures >= cb.failureThreshold {
+		Warnf(KeyAll, "Circuit breaker for %s: %d consecutive operations failed, failing fast for %v", MD(cb.name), cb.consecutiveFailures, cb.openTimeout)
+		StatsCircuitBreakers().Add(StatKeyCircuitBreakerTripCount, 1)
+		cb.trip()
+	}
+}
+
+// Opens the breaker.  Requires the lock.
+func (cb *CircuitBreaker) trip() {
+	cb.state = CircuitBreakerOpen
+	cb.openedAt = cb.now()
+	cb.consecutiveFailures = 0
+}
+
+func StatsCircuitBreakers() *expvar.Map {
+	return GlobalStats.Get(StatsGroupKeyCircuitBreakers).(*expvar.Map)
+}
+
+func NewStatsCircuitBreakers() *expvar.Map {
+	stats := new(expvar.Map).Init()
+	stats.Set(StatKeyCircuitBreakerTripCount, ExpvarIntVal(0))
+	stats.Set(StatKeyCircuitBreakerRejectedCount, ExpvarIntVal(0))
+	return stats
+}
@@ -0,0 +1,82 @@
+package base
+
+import (
+	"errors"
+	"expvar"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	errTransient := errors.New("transient")
+	errOther := errors.New("other")
+	isFailure := func(err error) bool { return err == errTransient }
+
+	cb := NewCircuitBreaker("TestCircuitBreaker", &CircuitBreakerConfig{FailureThreshold: 3, OpenTimeoutMs: 1000}, isFailure)
+	now := time.Now()
+	cb.now = func() time.Time { return now }
+
+	trips := StatsCircuitBreakers().Get(StatKeyCircuitBreakerTripCount).(*expvar.Int).Value()
+	rejected := StatsCircuitBreakers().Get(StatKeyCircuitBreakerRejectedCount).(*expvar.Int).Value()
+
+	calls := 0
+	run := func(err error) error {
+		return cb.Run(func() error {
+			calls++
+			return err
+		})
+	}
+
+	// Errors that aren't failures, and successes, reset the count of consecutive failures
+	assert.Equal(t, errTransient, run(errTransient))
+	assert.Equal(t, errTransient, run(errTransient))
+	assert.Equal(t, errOther, run(errOther))
+	assert.Equal(t, errTransient, run(errTransient))
+	assert.Equal(t, errTransient, run(errTransient))
+	assert.NoError(t, run(nil))
+	assert.Equal(t, CircuitBreakerClosed, cb.State())
+
+	// Consecutive failures trip the breaker, after which ops fail fast
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, errTransient, run(errTransient))
+	}
+	assert.Equal(t, CircuitBreakerOpen, cb.State())
+	calls = 0
+	assert.Equal(t, ErrCircuitOpen, run(nil))
+	assert.Equal(t, 0, calls)
+	status, _ := ErrorAsHTTPStatus(ErrCircuitOpen)
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+
+	// Once the open timeout has passed a probe is let through.  If it fails, the breaker stays open
+	now = now.Add(time.Second)
+	assert.Equal(t, errTransient, run(errTransient))
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, CircuitBreakerOpen, cb.State())
+	assert.Equal(t, ErrCircuitOpen, run(nil))
+
+	// Only one probe is let through at a time, and the breaker closes once one succeeds
+	now = now.Add(time.Second)
+	assert.NoError(t, cb.Run(func() error {
+		assert.Equal(t, CircuitBreakerHalfOpen, cb.State())
+		assert.Equal(t, ErrCircuitOpen, run(nil))
+		return nil
+	}))
+	assert.Equal(t, CircuitBreakerClosed, cb.State())
+	assert.NoError(t, run(nil))
+
+	assert.Equal(t, trips+1, StatsCircuitBreakers().Get(StatKeyCircuitBreakerTripCount).(*expvar.Int).Value())
+	assert.Equal(t, rejected+3, StatsCircuitBreakers().Get(StatKeyCircuitBreakerRejectedCount).(*expvar.Int).Value())
+}
+
+func TestCircuitBreakerConfig(t *testing.T) {
+	assert.NoError(t, (&CircuitBreakerConfig{}).Validate())
+	assert.Error(t, (&CircuitBreakerConfig{FailureThreshold: -1}).Validate())
+	assert.Error(t, (&CircuitBreakerConfig{OpenTimeoutMs: -1}).Validate())
+
+	cb := NewCircuitBreaker("TestCircuitBreakerConfig", nil, nil)
+	assert.Equal(t, kDefaultCircuitBreakerFailureThreshold, cb.failureThreshold)
+	assert.Equal(t, kDefaultCircuitBreakerOpenTimeoutMs*time.Millisecond, cb.openTimeout)
+}
@@ -54,6 +54,13 @@ const (
 	// Don't use an auth handler by default, but provide a way to override
 	TestEnvSyncGatewayUseAuthHandler = "SG_TEST_USE_AUTH_HANDLER"
 
+	// The max # of buckets of each type the test bucket pool creates on Couchbase Server, per test package
+	TestEnvSyncGatewayBucketPoolSize = "SG_TEST_BUCKET_POOL_SIZE"
+	DefaultTestBucketPoolSize        = 3
+
+	// Overrides the test package name that's included in the names of pooled test buckets
+	TestEnvSyncGatewayBucketPoolPrefix = "SG_TEST_BUCKET_POOL_PREFIX"
+
 	DefaultUseXattrs      = false // Whether Sync Gateway uses xattrs for metadata storage, if not specified in the config
 	DefaultAllowConflicts = true  // Whether Sync Gateway allows revision conflicts, if not specified in the config
 
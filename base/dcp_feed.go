@@ -71,6 +71,10 @@ func (s *SimpleFeed) Close() error {
 	return nil
 }
 
+// FeedEventSeqCallbackFunc is like sgbucket.FeedEventCallbackFunc, but is also passed the vbucket sequence of the
+// mutation, which sgbucket.FeedEvent doesn't include.
+type FeedEventSeqCallbackFunc func(event sgbucket.FeedEvent, seq uint64) bool
+
 type Receiver interface {
 	cbdatasource.Receiver
 	SeedSeqnos(map[uint16]uint64, map[uint16]uint64)
@@ -78,6 +82,7 @@ type Receiver interface {
 	SetBucketNotifyFn(sgbucket.BucketNotifyFn)
 	GetBucketNotifyFn() sgbucket.BucketNotifyFn
 	initFeed(feedType uint64) error
+	setSeqCallback(callback FeedEventSeqCallbackFunc)
 }
 
 // DCPReceiver implements cbdatasource.Receiver to manage updates coming from a
@@ -94,6 +99,7 @@ type DCPReceiver struct {
 	updatesSinceCheckpoint []uint64                       // Number of updates since the last checkpoint. Used to avoid checkpoint persistence feedback loop
 	notify                 sgbucket.BucketNotifyFn        // Function to callback when we lose our dcp feed
 	callback               sgbucket.FeedEventCallbackFunc // Function to callback for mutation processing
+	seqCallback            FeedEventSeqCallbackFunc       // If set, called for mutation processing instead of callback
 	backfill               backfillStatus                 // Backfill state and stats
 }
 
@@ -127,6 +133,18 @@ func (r *DCPReceiver) GetBucketNotifyFn() sgbucket.BucketNotifyFn {
 	return r.notify
 }
 
+func (r *DCPReceiver) setSeqCallback(callback FeedEventSeqCallbackFunc) {
+	r.seqCallback = callback
+}
+
+// Passes a mutation to the callback, returning whether the checkpoint should be persisted.
+func (r *DCPReceiver) dispatch(event sgbucket.FeedEvent, seq uint64) bool {
+	if r.seqCallback != nil {
+		return r.seqCallback(event, seq)
+	}
+	return r.callback(event)
+}
+
 func (r *DCPReceiver) OnError(err error) {
 	Warnf(KeyAll, "Error processing DCP stream - will attempt to restart/reconnect if appropriate: %v.", err)
 	// From cbdatasource:
@@ -146,7 +164,7 @@ func (r *DCPReceiver) OnError(err error) {
 func (r *DCPReceiver) DataUpdate(vbucketId uint16, key []byte, seq uint64,
 	req *gomemcached.MCRequest) error {
 	r.updateSeq(vbucketId, seq, true)
-	shouldPersistCheckpoint := r.callback(makeFeedEvent(req, vbucketId, sgbucket.FeedOpMutation))
+	shouldPersistCheckpoint := r.dispatch(makeFeedEvent(req, vbucketId, sgbucket.FeedOpMutation), seq)
 	if shouldPersistCheckpoint {
 		r.incrementCheckpointCount(vbucketId)
 	}
@@ -156,7 +174,7 @@ func (r *DCPReceiver) DataUpdate(vbucketId uint16, key []byte, seq uint64,
 func (r *DCPReceiver) DataDelete(vbucketId uint16, key []byte, seq uint64,
 	req *gomemcached.MCRequest) error {
 	r.updateSeq(vbucketId, seq, true)
-	shouldPersistCheckpoint := r.callback(makeFeedEvent(req, vbucketId, sgbucket.FeedOpDeletion))
+	shouldPersistCheckpoint := r.dispatch(makeFeedEvent(req, vbucketId, sgbucket.FeedOpDeletion), seq)
 	if shouldPersistCheckpoint {
 		r.incrementCheckpointCount(vbucketId)
 	}
@@ -183,6 +201,7 @@ func makeFeedEvent(rq *gomemcached.MCRequest, vbucketId uint16, opcode sgbucket.
 		Expiry:       ExtractExpiryFromDCPMutation(rq),
 		Synchronous:  true,
 		TimeReceived: time.Now(),
+		VbNo:         vbucketId,
 	}
 	return event
 }
@@ -512,6 +531,10 @@ func (r *DCPLoggingReceiver) initFeed(feedType uint64) error {
 	return r.rec.initFeed(feedType)
 }
 
+func (r *DCPLoggingReceiver) setSeqCallback(callback FeedEventSeqCallbackFunc) {
+	r.rec.setSeqCallback(callback)
+}
+
 // NoPasswordAuthHandler is used for client cert-based auth
 type NoPasswordAuthHandler struct {
 	handler AuthHandler
@@ -522,9 +545,44 @@ func (nph NoPasswordAuthHandler) GetCredentials() (username string, password str
 	return "", "", bucketname
 }
 
+// RBACAuthHandler is used for RBAC user credentials.  It implements go-couchbase's AuthWithSaslHandler, so
+// that cbdatasource selects the bucket after authenticating its memcached connections as the user.
+type RBACAuthHandler struct {
+	handler AuthHandler
+}
+
+func (rah RBACAuthHandler) GetCredentials() (username string, password string, bucketname string) {
+	return rah.handler.GetCredentials()
+}
+
+func (rah RBACAuthHandler) GetSaslCredentials() (username string, password string) {
+	username, password, _ = rah.handler.GetCredentials()
+	return username, password
+}
+
 // This starts a cbdatasource powered DCP Feed using an entirely separate connection to Couchbase Server than anything the existing
 // bucket is using, and it uses the go-couchbase cbdatasource DCP abstraction layer
 func StartDCPFeed(bucket Bucket, spec BucketSpec, args sgbucket.FeedArguments, callback sgbucket.FeedEventCallbackFunc) error {
+	return startDCPFeed(bucket, spec, args, callback, nil, nil, nil)
+}
+
+// Starts a DCP feed like StartDCPFeed, but passes the callback the vbucket sequence of each mutation.
+func StartDCPFeedWithSequences(bucket Bucket, spec BucketSpec, args sgbucket.FeedArguments, callback FeedEventSeqCallbackFunc) error {
+	return startDCPFeed(bucket, spec, args, nil, callback, nil, nil)
+}
+
+// Starts a DCP feed like StartDCPFeedWithSequences, but only for the given vbuckets, each starting after the given
+// sequence (or from zero, for vbuckets that don't have one).  args.Backfill is ignored.
+func StartDCPFeedForVbuckets(bucket Bucket, spec BucketSpec, args sgbucket.FeedArguments, vbNos []uint16, startSeqs map[uint16]uint64, callback FeedEventSeqCallbackFunc) error {
+	if startSeqs == nil {
+		startSeqs = map[uint16]uint64{}
+	}
+	return startDCPFeed(bucket, spec, args, nil, callback, vbNos, startSeqs)
+}
+
+// Starts a DCP feed for the given vbuckets, or all vbuckets if vbNos is nil.  If startSeqs is non-nil, the feed
+// starts after those sequences instead of where args.Backfill says.
+func startDCPFeed(bucket Bucket, spec BucketSpec, args sgbucket.FeedArguments, callback sgbucket.FeedEventCallbackFunc, seqCallback FeedEventSeqCallbackFunc, vbNos []uint16, startSeqs map[uint16]uint64) error {
 
 	// Recommended usage of cbdatasource is to let it manage it's own dedicated connection, so we're not
 	// reusing the bucket connection we've already established.
@@ -539,7 +597,7 @@ func StartDCPFeed(bucket Bucket, spec BucketSpec, args sgbucket.FeedArguments, c
 	}
 	bucketName := spec.BucketName
 
-	vbucketIdsArr := []uint16(nil) // nil means get all the vbuckets.
+	vbucketIdsArr := vbNos // nil means get all the vbuckets.
 
 	maxVbno, err := bucket.GetMaxVbno()
 	if err != nil {
@@ -553,11 +611,23 @@ func StartDCPFeed(bucket Bucket, spec BucketSpec, args sgbucket.FeedArguments, c
 
 	dcpReceiver := NewDCPReceiver(callback, bucket, maxVbno, persistCheckpoints)
 	dcpReceiver.SetBucketNotifyFn(args.Notify)
+	if seqCallback != nil {
+		dcpReceiver.setSeqCallback(seqCallback)
+	}
 
-	// Initialize the feed based on the backfill type
-	feedInitErr := dcpReceiver.initFeed(args.Backfill)
-	if feedInitErr != nil {
-		return feedInitErr
+	if startSeqs != nil {
+		// Start from the given sequences, using the vbuckets' current uuids
+		uuids, _, err := bucket.GetStatsVbSeqno(maxVbno, false)
+		if err != nil {
+			return pkgerrors.Wrap(err, "Error retrieving stats-vbseqno - DCP not supported")
+		}
+		dcpReceiver.SeedSeqnos(uuids, startSeqs)
+	} else {
+		// Initialize the feed based on the backfill type
+		feedInitErr := dcpReceiver.initFeed(args.Backfill)
+		if feedInitErr != nil {
+			return feedInitErr
+		}
 	}
 
 	dataSourceOptions := cbdatasource.DefaultBucketDataSourceOptions
@@ -578,12 +648,14 @@ func StartDCPFeed(bucket Bucket, spec BucketSpec, args sgbucket.FeedArguments, c
 
 	// If using client certificate for authentication, configure go-couchbase for cbdatasource's initial
 	// connection to retrieve cluster configuration.
-	if spec.Certpath != "" {
+	if spec.UseClientCert() {
 		couchbase.SetCertFile(spec.Certpath)
 		couchbase.SetKeyFile(spec.Keypath)
 		couchbase.SetRootFile(spec.CACertPath)
-		couchbase.SetSkipVerify(false)
+		couchbase.SetSkipVerify(spec.TLSSkipVerify != nil && *spec.TLSSkipVerify)
 		auth = NoPasswordAuthHandler{handler: spec.Auth}
+	} else if spec.UseRBACCredentials() {
+		auth = RBACAuthHandler{handler: spec.Auth}
 	}
 
 	// If using TLS, pass a custom connect method to support using TLS for cbdatasource's memcached connections
@@ -39,6 +39,7 @@ var (
 	ErrIndexAlreadyExists    = &sgError{"Index already exists"}
 	ErrNotFound              = &sgError{"Not Found"}
 	ErrUpdateCancel          = &sgError{"Cancel update"}
+	ErrCircuitOpen           = &sgError{"Database server is unavailable (circuit breaker open)"}
 
 	// ErrPartialViewErrors is returned if the view call contains any partial errors.
 	// This is more of a warning, and inspecting ViewResult.Errors is required for detail.
@@ -59,6 +60,8 @@ func (e *sgError) Error() string {
 type HTTPError struct {
 	Status  int
 	Message string
+	Code    string      // Error code to return, if not the default for the status
+	Details interface{} // Structured description of the error to return along with it, if any
 }
 
 func (err *HTTPError) Error() string {
@@ -66,7 +69,139 @@ func (err *HTTPError) Error() string {
 }
 
 func HTTPErrorf(status int, format string, args ...interface{}) *HTTPError {
-	return &HTTPError{status, fmt.Sprintf(format, args...)}
+	return &HTTPError{Status: status, Message: fmt.Sprintf(format, args...)}
+}
+
+// Like HTTPErrorf, but with an error code that's more specific than the status's default one.
+func CodedHTTPErrorf(status int, code string, format string, args ...interface{}) *HTTPError {
+	return &HTTPError{Status: status, Message: fmt.Sprintf(format, args...), Code: code}
+}
+
+// Machine-readable error codes, returned along with error responses' free-text reasons.  These are stable across
+// releases, so clients can act on them without matching reasons.
+const (
+	ErrorCodeBadRequest          = "bad_request"
+	ErrorCodeInvalidJSON         = "invalid_json"
+	ErrorCodeEmptyDocument       = "empty_document"
+	ErrorCodeInvalidDocument     = "invalid_document"  // The document doesn't match the schema of its type
+	ErrorCodeInvalidParameter    = "invalid_parameter" // A query parameter's value is malformed
+	ErrorCodeUnauthorized        = "unauthorized"
+	ErrorCodeForbidden           = "forbidden"
+	ErrorCodeNotFound            = "not_found"
+	ErrorCodeMethodNotAllowed    = "method_not_allowed"
+	ErrorCodeNotAcceptable       = "not_acceptable"
+	ErrorCodeRequestTimeout      = "request_timeout"
+	ErrorCodeConflict            = "conflict"
+	ErrorCodePreconditionFailed  = "precondition_failed"
+	ErrorCodeTooLarge            = "too_large"
+	ErrorCodeUnsupportedMedia    = "unsupported_media_type"
+	ErrorCodeRateLimited         = "rate_limited"
+	ErrorCodeClientError         = "client_error"
+	ErrorCodeInternal            = "internal_error"
+	ErrorCodeNotImplemented      = "not_implemented"
+	ErrorCodeNotSupported        = "not_supported"
+	ErrorCodeBucketError         = "bucket_error"
+	ErrorCodeUnavailable         = "unavailable"
+	ErrorCodeServerBusy          = "server_busy"
+	ErrorCodeTimeout             = "timeout"
+	ErrorCodeDatabaseUnavailable = "db_unavailable" // The database is offline, starting or resyncing
+	ErrorCodeDatabaseNotOffline  = "db_not_offline" // The operation needs the database to be taken offline first
+	ErrorCodeQuotaExceeded       = "quota_exceeded"
+	ErrorCodeReadOnly            = "read_only" // The node is a read-only replica; writes have to be sent to another node
+	ErrorCodeServerError         = "server_error"
+	ErrorCodeRequestTimedOut     = "request_timed_out" // The server stopped waiting for the request, which may still be running
+)
+
+// Default error codes of HTTP statuses.  Other 4xx statuses are ErrorCodeClientError, and 5xx ErrorCodeServerError.
+var httpStatusErrorCodes = map[int]string{
+	http.StatusBadRequest:            ErrorCodeBadRequest,
+	http.StatusUnauthorized:          ErrorCodeUnauthorized,
+	http.StatusForbidden:             ErrorCodeForbidden,
+	http.StatusNotFound:              ErrorCodeNotFound,
+	http.StatusMethodNotAllowed:      ErrorCodeMethodNotAllowed,
+	http.StatusNotAcceptable:         ErrorCodeNotAcceptable,
+	http.StatusRequestTimeout:        ErrorCodeRequestTimeout,
+	http.StatusConflict:              ErrorCodeConflict,
+	http.StatusPreconditionFailed:    ErrorCodePreconditionFailed,
+	http.StatusRequestEntityTooLarge: ErrorCodeTooLarge,
+	http.StatusUnsupportedMediaType:  ErrorCodeUnsupportedMedia,
+	http.StatusTooManyRequests:       ErrorCodeRateLimited,
+	http.StatusInternalServerError:   ErrorCodeInternal,
+	http.StatusNotImplemented:        ErrorCodeNotImplemented,
+	http.StatusBadGateway:            ErrorCodeBucketError,
+	http.StatusServiceUnavailable:    ErrorCodeUnavailable,
+	http.StatusGatewayTimeout:        ErrorCodeTimeout,
+	http.StatusInsufficientStorage:   ErrorCodeQuotaExceeded,
+}
+
+// Error codes of failures that may succeed if the request is retried later.
+var retryableErrorCodes = map[string]bool{
+	ErrorCodeRequestTimeout:      true,
+	ErrorCodeRateLimited:         true,
+	ErrorCodeUnavailable:         true,
+	ErrorCodeServerBusy:          true,
+	ErrorCodeTimeout:             true,
+	ErrorCodeDatabaseUnavailable: true,
+}
+
+// Returns the default error code of an HTTP error status, or "" for a status that isn't an error.
+func HTTPStatusErrorCode(status int) string {
+	if code, ok := httpStatusErrorCodes[status]; ok {
+		return code
+	}
+	switch {
+	case status >= 500:
+		return ErrorCodeServerError
+	case status >= 400:
+		return ErrorCodeClientError
+	}
+	return ""
+}
+
+// Whether a request that failed with the given error code may succeed if it's retried later.
+func IsRetryableErrorCode(code string) bool {
+	return retryableErrorCodes[code]
+}
+
+// Returns the error code to report an error with, and whether retrying the request that failed may succeed.  Like
+// ErrorAsHTTPStatus, returns "" for a nil error.
+func ErrorCode(err error) (code string, retryable bool) {
+	if err == nil {
+		return "", false
+	}
+
+	switch unwrappedErr := pkgerrors.Cause(err); unwrappedErr {
+	case gocb.ErrTimeout, ErrViewTimeoutError:
+		code = ErrorCodeTimeout
+	case gocb.ErrOverload, gocb.ErrBusy, gocb.ErrTmpFail:
+		code = ErrorCodeServerBusy
+	case ErrEmptyDocument:
+		code = ErrorCodeEmptyDocument
+	default:
+		switch unwrappedErr := unwrappedErr.(type) {
+		case *HTTPError:
+			code = unwrappedErr.Code
+		case *gomemcached.MCResponse:
+			if unwrappedErr.Status == gomemcached.TMPFAIL {
+				code = ErrorCodeServerBusy
+			}
+		case *json.SyntaxError, *json.UnmarshalTypeError:
+			code = ErrorCodeInvalidJSON
+		}
+	}
+	if code == "" {
+		status, _ := ErrorAsHTTPStatus(err)
+		code = HTTPStatusErrorCode(status)
+	}
+	return code, IsRetryableErrorCode(code)
+}
+
+// Returns the structured details of an error to return to clients, or nil if it has none.
+func ErrorDetails(err error) interface{} {
+	if httpErr, ok := pkgerrors.Cause(err).(*HTTPError); ok {
+		return httpErr.Details
+	}
+	return nil
 }
 
 // Attempts to map an error to an HTTP status code and message.
@@ -92,7 +227,7 @@ func ErrorAsHTTPStatus(err error) (int, string) {
 		return http.StatusServiceUnavailable, "Database server is over capacity (gocb.ErrBusy)"
 	case gocb.ErrTmpFail:
 		return http.StatusServiceUnavailable, "Database server is over capacity (gocb.ErrTmpFail)"
-	case ErrViewTimeoutError:
+	case ErrViewTimeoutError, ErrCircuitOpen:
 		return http.StatusServiceUnavailable, unwrappedErr.Error()
 	}
 
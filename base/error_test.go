@@ -0,0 +1,40 @@
+package base
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/couchbase/gocb"
+	pkgerrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorCode(t *testing.T) {
+	var syntaxErr error = &json.SyntaxError{}
+	tests := []struct {
+		err       error
+		code      string
+		retryable bool
+	}{
+		{HTTPErrorf(http.StatusNotFound, "missing"), ErrorCodeNotFound, false},
+		{HTTPErrorf(http.StatusTooManyRequests, "slow down"), ErrorCodeRateLimited, true},
+		{HTTPErrorf(http.StatusTeapot, "short and stout"), ErrorCodeClientError, false},
+		{HTTPErrorf(http.StatusServiceUnavailable, "busy"), ErrorCodeUnavailable, true},
+		{CodedHTTPErrorf(http.StatusServiceUnavailable, ErrorCodeDatabaseNotOffline, "take it offline"), ErrorCodeDatabaseNotOffline, false},
+		{pkgerrors.Wrap(gocb.ErrTimeout, "reading doc"), ErrorCodeTimeout, true},
+		{gocb.ErrTmpFail, ErrorCodeServerBusy, true},
+		{ErrEmptyDocument, ErrorCodeEmptyDocument, false},
+		{syntaxErr, ErrorCodeInvalidJSON, false},
+		{ErrImportCancelled, ErrorCodeInternal, false},
+	}
+	for _, test := range tests {
+		code, retryable := ErrorCode(test.err)
+		assert.Equal(t, test.code, code, "code of %v", test.err)
+		assert.Equal(t, test.retryable, retryable, "retryable of %v", test.err)
+	}
+
+	code, _ := ErrorCode(nil)
+	assert.Equal(t, "", code)
+	assert.Equal(t, "", HTTPStatusErrorCode(http.StatusOK))
+}
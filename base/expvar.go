@@ -66,10 +66,23 @@ const (
 	StatKeyGoMemstatsPauseTotalNs         = "go_memstats_pausetotalns"
 	StatKeyErrorCount                     = "error_count"
 	StatKeyWarnCount                      = "warn_count"
+	StatKeySlowOperationCount             = "slow_operation_count"
+	StatKeyRateLimitedRequestCount        = "rate_limited_request_count"
+	StatKeyAdminIPDeniedCount             = "admin_ip_denied_count"
+
+	// StatsBucketRetries
+	StatKeyBucketOpRetryCount          = "bucket_op_retry_count"
+	StatKeyBucketOpRetryExhaustedCount = "bucket_op_retry_exhausted_count"
+
+	// StatsCircuitBreakers
+	StatKeyCircuitBreakerTripCount     = "circuit_breaker_trip_count"
+	StatKeyCircuitBreakerRejectedCount = "circuit_breaker_rejected_count"
 
 	// StatsCache
 	StatKeyRevisionCacheHits          = "rev_cache_hits"
 	StatKeyRevisionCacheMisses        = "rev_cache_misses"
+	StatKeySharedRevisionCacheHits    = "shared_rev_cache_hits"
+	StatKeySharedRevisionCacheMisses  = "shared_rev_cache_misses"
 	StatKeyChannelCacheHits           = "chan_cache_hits"
 	StatKeyChannelCacheMisses         = "chan_cache_misses"
 	StatKeyChannelCacheRevsActive     = "chan_cache_active_revs"
@@ -101,6 +114,44 @@ const (
 	StatKeyDcpReceivedTime         = "dcp_received_time"
 	StatKeyDcpCachingCount         = "dcp_caching_count"
 	StatKeyDcpCachingTime          = "dcp_caching_time"
+	StatKeyExpiredTombstoneCount   = "expired_tombstone_count"
+
+	// StatsDatabase - replication lifecycle
+	StatKeyNumReplicationsCompleted = "num_replications_completed"
+	StatKeyNumReplicationErrors     = "num_replication_errors"
+
+	// StatsDatabase - quotas
+	StatKeyQuotaDocsRejectedCount         = "quota_docs_rejected_count"
+	StatKeyQuotaAttachmentsRejectedCount  = "quota_attachments_rejected_count"
+	StatKeyQuotaReplicationsRejectedCount = "quota_replications_rejected_count"
+	StatKeyQuotaOpsRejectedCount          = "quota_ops_rejected_count"
+
+	// StatsDatabase - webhooks
+	StatKeyWebhookDeliveredCount  = "webhook_delivered_count"
+	StatKeyWebhookFilteredCount   = "webhook_filtered_count"
+	StatKeyWebhookRetryCount      = "webhook_retry_count"
+	StatKeyWebhookFailedCount     = "webhook_failed_count"
+	StatKeyWebhookDeadLetterCount = "webhook_dead_letter_count"
+
+	// StatsDatabase - persistent event queue
+	StatKeyEventQueuePersistedCount = "event_queue_persisted_count"
+	StatKeyEventQueueProcessedCount = "event_queue_processed_count"
+	StatKeyEventQueueDroppedCount   = "event_queue_dropped_count"
+
+	// StatsDatabase - kafka sinks
+	StatKeyKafkaPublishedCount = "kafka_published_count"
+	StatKeyKafkaFilteredCount  = "kafka_filtered_count"
+	StatKeyKafkaFailedCount    = "kafka_failed_count"
+
+	// StatsDatabase - nats and amqp sinks
+	StatKeySinkPublishedCount = "sink_published_count"
+	StatKeySinkFilteredCount  = "sink_filtered_count"
+	StatKeySinkFailedCount    = "sink_failed_count"
+
+	// StatsDatabase - push notifications
+	StatKeyPushSentCount         = "push_sent_count"
+	StatKeyPushFailedCount       = "push_failed_count"
+	StatKeyPushUnregisteredCount = "push_unregistered_count"
 
 	// StatsDeltaSync
 	StatKeyDeltasRequested           = "deltas_requested"
@@ -109,6 +160,7 @@ const (
 	StatKeyDeltaCacheHits            = "delta_cache_hit"
 	StatKeyDeltaCacheMisses          = "delta_cache_miss"
 	StatKeyDeltaPushDocCount         = "delta_push_doc_count"
+	StatKeyDeltaCacheBytes           = "delta_cache_bytes"
 
 	// StatsSharedBucketImport
 	StatKeyImportCount          = "import_count"
@@ -175,6 +227,8 @@ const (
 	StatsGroupKeyCblReplicationPull  = "cbl_replication_pull"
 	StatsGroupKeySecurity            = "security"
 	StatsGroupKeyGsiViews            = "gsi_views"
+	StatsGroupKeyBucketRetries       = "bucket_retries"
+	StatsGroupKeyCircuitBreakers     = "circuit_breakers"
 )
 
 func init() {
@@ -207,6 +261,12 @@ func init() {
 	// Add StatsResourceUtilization under GlobalStats
 	GlobalStats.Set(StatsGroupKeyResourceUtilization, NewStatsResourceUtilization())
 
+	// Add StatsBucketRetries under GlobalStats
+	GlobalStats.Set(StatsGroupKeyBucketRetries, NewStatsBucketRetries())
+
+	// Add StatsCircuitBreakers under GlobalStats
+	GlobalStats.Set(StatsGroupKeyCircuitBreakers, NewStatsCircuitBreakers())
+
 }
 
 func StatsResourceUtilization() *expvar.Map {
@@ -236,6 +296,9 @@ func NewStatsResourceUtilization() *expvar.Map {
 	stats.Set(StatKeyGoMemstatsPauseTotalNs, ExpvarIntVal(0))
 	stats.Set(StatKeyErrorCount, ExpvarIntVal(0))
 	stats.Set(StatKeyWarnCount, ExpvarIntVal(0))
+	stats.Set(StatKeySlowOperationCount, ExpvarIntVal(0))
+	stats.Set(StatKeyRateLimitedRequestCount, ExpvarIntVal(0))
+	stats.Set(StatKeyAdminIPDeniedCount, ExpvarIntVal(0))
 	return stats
 }
 
@@ -0,0 +1,189 @@
+package base
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/couchbase/sg-bucket"
+)
+
+// A single event in a feed recording.  Recordings are written as one JSON object per line, in the order the events
+// arrived, so they can be streamed and edited by hand.  Compact JSON values are stored as-is, since encoding them
+// doesn't change them; other values are base64-encoded.
+type recordedFeedEvent struct {
+	Offset      time.Duration       `json:"t"` // When the event arrived, relative to the start of the recording
+	Opcode      sgbucket.FeedOpcode `json:"op"`
+	Key         string              `json:"key"`
+	JSON        json.RawMessage     `json:"json,omitempty"`
+	Raw         []byte              `json:"raw,omitempty"`
+	DataType    uint8               `json:"datatype,omitempty"`
+	Cas         uint64              `json:"cas,omitempty"`
+	Expiry      uint32              `json:"exp,omitempty"`
+	VbNo        uint16              `json:"vb,omitempty"`
+	Synchronous bool                `json:"sync,omitempty"`
+}
+
+func (e recordedFeedEvent) feedEvent() sgbucket.FeedEvent {
+	event := sgbucket.FeedEvent{
+		Opcode:       e.Opcode,
+		Key:          []byte(e.Key),
+		Value:        e.Raw,
+		DataType:     e.DataType,
+		Cas:          e.Cas,
+		Expiry:       e.Expiry,
+		VbNo:         e.VbNo,
+		Synchronous:  e.Synchronous,
+		TimeReceived: time.Now(),
+	}
+	if len(e.JSON) > 0 {
+		event.Value = e.JSON
+	}
+	return event
+}
+
+// Returns true if value is JSON that compacting wouldn't change.
+func isCompactJSON(value []byte) bool {
+	var compacted bytes.Buffer
+	if len(value) == 0 || json.Compact(&compacted, value) != nil {
+		return false
+	}
+	return bytes.Equal(compacted.Bytes(), value)
+}
+
+// FeedRecorder records the events of a TAP or DCP feed, so they can be replayed with ReplayFeed to reproduce
+// feed-processing bugs offline.  For testing use only.
+type FeedRecorder struct {
+	lock      sync.Mutex
+	writer    *bufio.Writer
+	encoder   *json.Encoder
+	start     time.Time
+	numEvents int
+	err       error // The first error encountered writing the recording
+}
+
+// Creates a FeedRecorder that writes its recording to w.  Flush must be called once recording is done.
+func NewFeedRecorder(w io.Writer) *FeedRecorder {
+	writer := bufio.NewWriter(w)
+	return &FeedRecorder{
+		writer:  writer,
+		encoder: json.NewEncoder(writer),
+		start:   time.Now(),
+	}
+}
+
+// Record adds an event to the recording.
+func (r *FeedRecorder) Record(event sgbucket.FeedEvent) {
+	recorded := recordedFeedEvent{
+		Opcode:      event.Opcode,
+		Key:         string(event.Key),
+		DataType:    event.DataType,
+		Cas:         event.Cas,
+		Expiry:      event.Expiry,
+		VbNo:        event.VbNo,
+		Synchronous: event.Synchronous,
+	}
+	if isCompactJSON(event.Value) {
+		recorded.JSON = event.Value
+	} else {
+		recorded.Raw = event.Value
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	recorded.Offset = time.Since(r.start)
+	if r.err == nil {
+		r.err = r.encoder.Encode(recorded)
+		r.numEvents++
+	}
+}
+
+// WrapCallback returns a feed callback that records each event before passing it on to callback.
+func (r *FeedRecorder) WrapCallback(callback sgbucket.FeedEventCallbackFunc) sgbucket.FeedEventCallbackFunc {
+	return func(event sgbucket.FeedEvent) bool {
+		r.Record(event)
+		return callback(event)
+	}
+}
+
+// Flush writes any buffered events, and returns the number of events recorded, or the first error encountered
+// writing the recording.
+func (r *FeedRecorder) Flush() (count int, err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.err == nil {
+		r.err = r.writer.Flush()
+	}
+	return r.numEvents, r.err
+}
+
+// Options for replaying a feed recording.
+type FeedReplayOptions struct {
+	Speed     float64        // Multiplier of the pace the events were recorded at.  If zero, events are replayed as fast as possible
+	Rollbacks []FeedRollback // Rollbacks to inject into the replay
+}
+
+// A rollback injected into a feed replay.  Once AfterEvent events have been replayed, the replay restarts from the
+// event numbered RestartFrom, emulating a stream that's been rolled back and resumed from an earlier sequence.  Events
+// are numbered from zero.  If VbNo is set, only events for that vbucket are sent again.  Each rollback happens once.
+type FeedRollback struct {
+	AfterEvent  int
+	RestartFrom int
+	VbNo        *uint16
+}
+
+// ReplayFeed reads a recording written by a FeedRecorder, and sends each of its events to callback.  Returns the
+// number of events sent, including any sent again by rollbacks.
+func ReplayFeed(r io.Reader, callback sgbucket.FeedEventCallbackFunc, options FeedReplayOptions) (count int, err error) {
+	var events []recordedFeedEvent
+	decoder := json.NewDecoder(r)
+	for decoder.More() {
+		var event recordedFeedEvent
+		if err := decoder.Decode(&event); err != nil {
+			return 0, fmt.Errorf("Unable to read event %d of feed recording: %v", len(events), err)
+		}
+		events = append(events, event)
+	}
+
+	for _, rollback := range options.Rollbacks {
+		if rollback.RestartFrom < 0 || rollback.RestartFrom > rollback.AfterEvent || rollback.AfterEvent > len(events) {
+			return 0, fmt.Errorf("Invalid rollback after event %d to event %d, in recording of %d events", rollback.AfterEvent, rollback.RestartFrom, len(events))
+		}
+	}
+
+	// Sends one event, after the delay between it and the previous event sent
+	var lastOffset time.Duration
+	send := func(event recordedFeedEvent) {
+		if options.Speed > 0 && event.Offset > lastOffset {
+			time.Sleep(time.Duration(float64(event.Offset-lastOffset) / options.Speed))
+		}
+		lastOffset = event.Offset
+		callback(event.feedEvent())
+		count++
+	}
+
+	for i := 0; i <= len(events); i++ {
+		for _, rollback := range options.Rollbacks {
+			if rollback.AfterEvent != i {
+				continue
+			}
+			Infof(KeyAll, "Replaying rollback of feed after event %d to event %d", i, rollback.RestartFrom)
+			if rollback.RestartFrom < len(events) {
+				lastOffset = events[rollback.RestartFrom].Offset
+			}
+			for _, event := range events[rollback.RestartFrom:i] {
+				if rollback.VbNo == nil || *rollback.VbNo == event.VbNo {
+					send(event)
+				}
+			}
+		}
+		if i < len(events) {
+			send(events[i])
+		}
+	}
+	return count, nil
+}
@@ -0,0 +1,76 @@
+package base
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	sgbucket "github.com/couchbase/sg-bucket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFeedRecordAndReplay(t *testing.T) {
+
+	var recording bytes.Buffer
+	recorder := NewFeedRecorder(&recording)
+	callback := recorder.WrapCallback(func(event sgbucket.FeedEvent) bool { return true })
+	for i := 0; i < 4; i++ {
+		callback(sgbucket.FeedEvent{
+			Opcode: sgbucket.FeedOpMutation,
+			Key:    []byte(fmt.Sprintf("doc%d", i)),
+			Value:  []byte(fmt.Sprintf(`{"n": %d}`, i)),
+			Cas:    uint64(i + 1),
+			VbNo:   uint16(i % 2),
+		})
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Non-JSON values are recorded too
+	callback(sgbucket.FeedEvent{Opcode: sgbucket.FeedOpMutation, Key: []byte("binary"), Value: []byte{0, 1, 2}})
+	callback(sgbucket.FeedEvent{Opcode: sgbucket.FeedOpDeletion, Key: []byte("doc0")})
+	count, err := recorder.Flush()
+	assert.NoError(t, err)
+	assert.Equal(t, 6, count)
+
+	var replayed []sgbucket.FeedEvent
+	replay := func(options FeedReplayOptions) (count int, err error) {
+		replayed = nil
+		return ReplayFeed(bytes.NewReader(recording.Bytes()), func(event sgbucket.FeedEvent) bool {
+			replayed = append(replayed, event)
+			return true
+		}, options)
+	}
+
+	count, err = replay(FeedReplayOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 6, count)
+	if assert.Len(t, replayed, 6) {
+		assert.Equal(t, "doc1", string(replayed[1].Key))
+		assert.Equal(t, `{"n": 1}`, string(replayed[1].Value))
+		assert.Equal(t, uint64(2), replayed[1].Cas)
+		assert.Equal(t, uint16(1), replayed[1].VbNo)
+		assert.Equal(t, []byte{0, 1, 2}, replayed[4].Value)
+		assert.Equal(t, sgbucket.FeedOpDeletion, replayed[5].Opcode)
+	}
+
+	// Replaying at the recorded pace takes as long as the recording did
+	start := time.Now()
+	_, err = replay(FeedReplayOptions{Speed: 1})
+	assert.NoError(t, err)
+	assert.True(t, time.Since(start) >= 30*time.Millisecond, "Replay was faster than the recording")
+
+	// After the third event, vbucket 0 is rolled back to the first event and its events are sent again
+	vbNo := uint16(0)
+	count, err = replay(FeedReplayOptions{Rollbacks: []FeedRollback{{AfterEvent: 3, RestartFrom: 0, VbNo: &vbNo}}})
+	assert.NoError(t, err)
+	assert.Equal(t, 8, count)
+	var keys []string
+	for _, event := range replayed {
+		keys = append(keys, string(event.Key))
+	}
+	assert.Equal(t, []string{"doc0", "doc1", "doc2", "doc0", "doc2", "doc3", "binary", "doc0"}, keys)
+
+	// Rollbacks must be to an earlier event
+	_, err = replay(FeedReplayOptions{Rollbacks: []FeedRollback{{AfterEvent: 1, RestartFrom: 2}}})
+	assert.Error(t, err)
+}
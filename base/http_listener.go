@@ -2,41 +2,120 @@ package base
 
 import (
 	"crypto/tls"
+	"fmt"
+	"io/ioutil"
 	"net"
 	"net/http"
+	"os"
 	"sync"
 	"time"
 )
 
+// TLS settings for a listener.
+type TLSOptions struct {
+	MinVersion     uint16        // Min TLS version accepted.  Defaults to TLS 1.0
+	CipherSuites   []uint16      // Cipher suites allowed, in order of preference.  Defaults to Go's default cipher suites
+	HTTP2Enabled   bool          // Whether HTTP/2 is offered
+	ReloadInterval time.Duration // How often the cert and key files are checked for changes, and reloaded.  Zero disables reloading
+}
+
+// TLS versions, by their config names
+var tlsVersions = map[string]uint16{
+	"tlsv1.0": tls.VersionTLS10,
+	"tlsv1.1": tls.VersionTLS11,
+	"tlsv1.2": tls.VersionTLS12,
+}
+
+// Cipher suites, by their crypto/tls names.  The broken RC4 and 3DES suites are left out so they can't be enabled
+var tlsCipherSuites = map[string]uint16{
+	"TLS_RSA_WITH_AES_128_CBC_SHA":            tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_RSA_WITH_AES_256_CBC_SHA":            tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+	"TLS_RSA_WITH_AES_128_CBC_SHA256":         tls.TLS_RSA_WITH_AES_128_CBC_SHA256,
+	"TLS_RSA_WITH_AES_128_GCM_SHA256":         tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_RSA_WITH_AES_256_GCM_SHA384":         tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA":    tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA":    tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA":      tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA":      tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256": tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256,
+	"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256":   tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256,
+	"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256":   tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256": tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384":   tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384": tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305":    tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+	"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305":  tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+}
+
+// Returns the TLS version with the given config name, e.g. "tlsv1.2".
+func ParseTLSVersion(name string) (uint16, error) {
+	version, ok := tlsVersions[name]
+	if !ok {
+		return 0, fmt.Errorf("Unknown TLS version %q; must be tlsv1.0, tlsv1.1 or tlsv1.2", name)
+	}
+	return version, nil
+}
+
+// Returns the cipher suites with the given crypto/tls names, e.g. "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256".
+func ParseTLSCipherSuites(names []string) ([]uint16, error) {
+	suites := make([]uint16, 0, len(names))
+	for _, name := range names {
+		suite, ok := tlsCipherSuites[name]
+		if !ok {
+			return nil, fmt.Errorf("Unknown TLS cipher suite %q", name)
+		}
+		suites = append(suites, suite)
+	}
+	return suites, nil
+}
+
 // This is like a combination of http.ListenAndServe and http.ListenAndServeTLS, which also
 // uses ThrottledListen to limit the number of open HTTP connections.
-func ListenAndServeHTTP(addr string, connLimit int, certFile *string, keyFile *string, handler http.Handler, readTimeout *int, writeTimeout *int, http2Enabled bool) error {
+func ListenAndServeHTTP(addr string, connLimit int, certFile *string, keyFile *string, handler http.Handler, readTimeout *int, writeTimeout *int, tlsOptions TLSOptions) error {
 	var config *tls.Config
 	if certFile != nil {
 		config = &tls.Config{}
 		config.MinVersion = tls.VersionTLS10 // Disable SSLv3 due to POODLE vulnerability
+		if tlsOptions.MinVersion > config.MinVersion {
+			config.MinVersion = tlsOptions.MinVersion
+		}
+		if len(tlsOptions.CipherSuites) > 0 {
+			config.CipherSuites = tlsOptions.CipherSuites
+			config.PreferServerCipherSuites = true
+		}
 		protocolsEnabled := []string{"http/1.1"}
-		if http2Enabled {
+		if tlsOptions.HTTP2Enabled {
 			protocolsEnabled = []string{"h2", "http/1.1"}
 		}
 		config.NextProtos = protocolsEnabled
 		Infof(KeyHTTP, "Protocols enabled: %v on %v", config.NextProtos, SD(addr))
-		config.Certificates = make([]tls.Certificate, 1)
-		var err error
-		config.Certificates[0], err = tls.LoadX509KeyPair(*certFile, *keyFile)
-		if err != nil {
-			return err
+		if tlsOptions.ReloadInterval > 0 {
+			reloader, err := newCertReloader(*certFile, *keyFile, tlsOptions.ReloadInterval)
+			if err != nil {
+				return err
+			}
+			defer reloader.stop()
+			config.GetCertificate = reloader.getCertificate
+		} else {
+			config.Certificates = make([]tls.Certificate, 1)
+			var err error
+			config.Certificates[0], err = loadX509KeyPair(*certFile, *keyFile)
+			if err != nil {
+				return err
+			}
 		}
 	}
 	listener, err := ThrottledListen("tcp", addr, connLimit)
 	if err != nil {
 		return err
 	}
+	server := &http.Server{Addr: addr, Handler: handler}
+	listener = trackServer(server, listener) // Under TLS, so that http.Server still sees *tls.Conns
+	defer untrackServer(server)
 	if config != nil {
 		listener = tls.NewListener(listener, config)
 	}
 	defer listener.Close()
-	server := &http.Server{Addr: addr, Handler: handler}
 	if readTimeout != nil {
 		server.ReadTimeout = time.Duration(*readTimeout) * time.Second
 	}
@@ -44,7 +123,134 @@ func ListenAndServeHTTP(addr string, connLimit int, certFile *string, keyFile *s
 		server.WriteTimeout = time.Duration(*writeTimeout) * time.Second
 	}
 
-	return server.Serve(listener)
+	err = server.Serve(listener)
+	if err == http.ErrServerClosed {
+		waitForDrain()
+		return nil
+	}
+	return err
+}
+
+// Loads a TLS certificate and private key.  The key may be a secret reference (see ResolveSecret) rather than a
+// file path, in which case it's held in memory only.
+func loadX509KeyPair(certFile, keyFile string) (tls.Certificate, error) {
+	if !IsSecretRef(keyFile) {
+		return tls.LoadX509KeyPair(certFile, keyFile)
+	}
+
+	certPEM, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	keyPEM, err := ResolveSecret(keyFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.X509KeyPair(certPEM, []byte(keyPEM))
+}
+
+// Holds a TLS certificate loaded from files, and reloads it when the files change, so that a renewed certificate can
+// be deployed without restarting.  If reloading fails, the previous certificate is kept.
+type certReloader struct {
+	certFile, keyFile string
+	lock              sync.RWMutex // Protects cert and modTimes
+	cert              *tls.Certificate
+	modTimes          []time.Time   // Modification times of the files cert was loaded from
+	terminator        chan struct{} // Closed by stop
+}
+
+func newCertReloader(certFile, keyFile string, interval time.Duration) (*certReloader, error) {
+	r := &certReloader{
+		certFile:   certFile,
+		keyFile:    keyFile,
+		terminator: make(chan struct{}),
+	}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	go r.watch(interval)
+	return r, nil
+}
+
+// Returns the modification times of the cert and key files.  A key held as a secret reference can't be watched.
+func (r *certReloader) fileModTimes() ([]time.Time, error) {
+	files := []string{r.certFile}
+	if !IsSecretRef(r.keyFile) {
+		files = append(files, r.keyFile)
+	}
+	modTimes := make([]time.Time, 0, len(files))
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		modTimes = append(modTimes, info.ModTime())
+	}
+	return modTimes, nil
+}
+
+// Loads the certificate if the files have changed since it was last loaded.
+func (r *certReloader) reload() error {
+	modTimes, err := r.fileModTimes()
+	if err != nil {
+		return err
+	}
+	r.lock.RLock()
+	unchanged := r.cert != nil && timesEqual(modTimes, r.modTimes)
+	r.lock.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	cert, err := loadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.lock.Lock()
+	reloaded := r.cert != nil
+	r.cert, r.modTimes = &cert, modTimes
+	r.lock.Unlock()
+	if reloaded {
+		Infof(KeyHTTP, "Reloaded TLS certificate from %s", UD(r.certFile))
+	}
+	return nil
+}
+
+func (r *certReloader) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := r.reload(); err != nil {
+				Warnf(KeyAll, "Unable to reload TLS certificate from %s, will keep using the current one: %v", UD(r.certFile), err)
+			}
+		case <-r.terminator:
+			return
+		}
+	}
+}
+
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.cert, nil
+}
+
+func (r *certReloader) stop() {
+	close(r.terminator)
+}
+
+func timesEqual(a, b []time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
 }
 
 type throttledListener struct {
@@ -58,8 +264,15 @@ type throttledListener struct {
 // connections at a time. When the limit is reached it will block until some are closed before
 // accepting any more.
 // If the 'limit' parameter is 0, there is no limit and the behavior is identical to net.Listen.
+// TCP sockets can be handed over to a new process by HandOffListeners.
 func ThrottledListen(protocol string, addr string, limit int) (net.Listener, error) {
-	listener, err := net.Listen(protocol, addr)
+	var listener net.Listener
+	var err error
+	if protocol == "tcp" {
+		listener, err = listenTCP(addr)
+	} else {
+		listener, err = net.Listen(protocol, addr)
+	}
 	if err != nil || limit <= 0 {
 		return listener, err
 	}
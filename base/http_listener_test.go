@@ -0,0 +1,98 @@
+package base
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTLSSettings(t *testing.T) {
+	version, err := ParseTLSVersion("tlsv1.2")
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS12), version)
+	_, err = ParseTLSVersion("sslv3")
+	assert.Error(t, err)
+
+	suites, err := ParseTLSCipherSuites([]string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305"})
+	assert.NoError(t, err)
+	assert.Equal(t, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305}, suites)
+	_, err = ParseTLSCipherSuites([]string{"TLS_FAKE_WITH_NULL"})
+	assert.Error(t, err)
+
+	// Broken cipher suites can't be enabled
+	_, err = ParseTLSCipherSuites([]string{"TLS_ECDHE_RSA_WITH_RC4_128_SHA"})
+	assert.Error(t, err)
+	_, err = ParseTLSCipherSuites([]string{"TLS_RSA_WITH_3DES_EDE_CBC_SHA"})
+	assert.Error(t, err)
+}
+
+// Writes a new self-signed server certificate with the given common name, and its key, to certPath and keyPath.
+func writeTestServerCert(t *testing.T, commonName, certPath, keyPath string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0600))
+	assert.NoError(t, ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+}
+
+func TestCertReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cert_reloader")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	certPath, keyPath := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeTestServerCert(t, "first", certPath, keyPath)
+
+	commonName := func(r *certReloader) string {
+		cert, err := r.getCertificate(nil)
+		assert.NoError(t, err)
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		assert.NoError(t, err)
+		return leaf.Subject.CommonName
+	}
+
+	reloader, err := newCertReloader(certPath, keyPath, 10*time.Millisecond)
+	assert.NoError(t, err)
+	defer reloader.stop()
+	assert.Equal(t, "first", commonName(reloader))
+
+	// A renewed certificate is picked up once the files change
+	writeTestServerCert(t, "second", certPath, keyPath)
+	later := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(certPath, later, later))
+	assert.NoError(t, os.Chtimes(keyPath, later, later))
+	for i := 0; i < 100 && commonName(reloader) != "second"; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, "second", commonName(reloader))
+
+	// If the files can't be loaded, the current certificate is kept
+	assert.NoError(t, ioutil.WriteFile(certPath, []byte("not a cert"), 0600))
+	assert.Error(t, reloader.reload())
+	assert.Equal(t, "second", commonName(reloader))
+
+	_, err = newCertReloader(filepath.Join(dir, "missing.pem"), keyPath, time.Second)
+	assert.Error(t, err)
+}
@@ -3,6 +3,7 @@ package base
 import (
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/couchbase/sg-bucket"
@@ -33,6 +34,53 @@ type LeakyBucketConfig struct {
 	// Returns a partial error the first time ViewCustom is called
 	FirstTimeViewCustomPartialError bool
 	PostQueryCallback               func(ddoc, viewName string, params map[string]interface{}) // Issues callback after issuing query when bucket.ViewQuery is called
+
+	// Delays each call of an operation by the given duration before issuing it, keyed by method name (e.g. "WriteCas")
+	OpLatency map[string]time.Duration
+
+	SetBulkPartialFailures *FaultSchedule // Fails the scheduled entries of SetBulk calls with a temporary error, without writing them
+	CASMismatches          *FaultSchedule // Fails the scheduled WriteCas and WriteCasWithXattr calls with a CAS mismatch, without writing
+	FeedEventDrops         *FaultSchedule // Drops the scheduled events from the TAP/DCP feed, emulating a stream that loses mutations
+}
+
+// A FaultSchedule decides which calls of an operation have a fault injected.  The first Skip calls are left alone,
+// then every Every'th call (every call if Every <= 1) has a fault injected, until Count faults have been injected
+// (forever if Count is 0).  Safe for concurrent use.
+type FaultSchedule struct {
+	Skip  int
+	Every int
+	Count int
+
+	lock   sync.Mutex
+	calls  int
+	faults int
+}
+
+// Records a call, and returns true if a fault should be injected into it.  Safe to call on a nil schedule, which never
+// injects faults.
+func (s *FaultSchedule) next() bool {
+	if s == nil {
+		return false
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.calls++
+	if s.calls <= s.Skip || (s.Count > 0 && s.faults >= s.Count) {
+		return false
+	}
+	if s.Every > 1 && (s.calls-s.Skip)%s.Every != 0 {
+		return false
+	}
+	s.faults++
+	return true
+}
+
+// Faults returns the number of faults the schedule has injected.
+func (s *FaultSchedule) Faults() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.faults
 }
 
 func NewLeakyBucket(bucket Bucket, config LeakyBucketConfig) Bucket {
@@ -46,33 +94,43 @@ func (b *LeakyBucket) GetName() string {
 	return b.bucket.GetName()
 }
 func (b *LeakyBucket) Get(k string, rv interface{}) (cas uint64, err error) {
+	b.delay("Get")
 	return b.bucket.Get(k, rv)
 }
 func (b *LeakyBucket) GetRaw(k string) (v []byte, cas uint64, err error) {
+	b.delay("GetRaw")
 	return b.bucket.GetRaw(k)
 }
 func (b *LeakyBucket) GetBulkRaw(keys []string) (map[string][]byte, error) {
+	b.delay("GetBulkRaw")
 	return b.bucket.GetBulkRaw(keys)
 }
 func (b *LeakyBucket) GetAndTouchRaw(k string, exp uint32) (v []byte, cas uint64, err error) {
+	b.delay("GetAndTouchRaw")
 	return b.bucket.GetAndTouchRaw(k, exp)
 }
 func (b *LeakyBucket) Touch(k string, exp uint32) (cas uint64, err error) {
+	b.delay("Touch")
 	return b.bucket.Touch(k, exp)
 }
 func (b *LeakyBucket) Add(k string, exp uint32, v interface{}) (added bool, err error) {
+	b.delay("Add")
 	return b.bucket.Add(k, exp, v)
 }
 func (b *LeakyBucket) AddRaw(k string, exp uint32, v []byte) (added bool, err error) {
+	b.delay("AddRaw")
 	return b.bucket.AddRaw(k, exp, v)
 }
 func (b *LeakyBucket) Append(k string, data []byte) error {
+	b.delay("Append")
 	return b.bucket.Append(k, data)
 }
 func (b *LeakyBucket) Set(k string, exp uint32, v interface{}) error {
+	b.delay("Set")
 	return b.bucket.Set(k, exp, v)
 }
 func (b *LeakyBucket) SetRaw(k string, exp uint32, v []byte) error {
+	b.delay("SetRaw")
 	for _, errorKey := range b.config.ForceErrorSetRawKeys {
 		if k == errorKey {
 			return fmt.Errorf("Leaky bucket forced SetRaw error for key %s", k)
@@ -81,28 +139,61 @@ func (b *LeakyBucket) SetRaw(k string, exp uint32, v []byte) error {
 	return b.bucket.SetRaw(k, exp, v)
 }
 func (b *LeakyBucket) Delete(k string) error {
+	b.delay("Delete")
 	return b.bucket.Delete(k)
 }
 func (b *LeakyBucket) Remove(k string, cas uint64) (casOut uint64, err error) {
+	b.delay("Remove")
 	return b.bucket.Remove(k, cas)
 }
 func (b *LeakyBucket) Write(k string, flags int, exp uint32, v interface{}, opt sgbucket.WriteOptions) error {
+	b.delay("Write")
 	return b.bucket.Write(k, flags, exp, v, opt)
 }
 func (b *LeakyBucket) WriteCas(k string, flags int, exp uint32, cas uint64, v interface{}, opt sgbucket.WriteOptions) (uint64, error) {
+	b.delay("WriteCas")
+	if b.config.CASMismatches.next() {
+		return 0, fmt.Errorf("Leaky bucket forced CAS mismatch for key %s", k)
+	}
 	return b.bucket.WriteCas(k, flags, exp, cas, v, opt)
 }
 func (b *LeakyBucket) Update(k string, exp uint32, callback sgbucket.UpdateFunc) (casOut uint64, err error) {
+	b.delay("Update")
 	return b.bucket.Update(k, exp, callback)
 }
 func (b *LeakyBucket) WriteUpdate(k string, exp uint32, callback sgbucket.WriteUpdateFunc) (casOut uint64, err error) {
+	b.delay("WriteUpdate")
 	return b.bucket.WriteUpdate(k, exp, callback)
 }
 func (b *LeakyBucket) SetBulk(entries []*sgbucket.BulkSetEntry) (err error) {
-	return b.bucket.SetBulk(entries)
+	b.delay("SetBulk")
+	if b.config.SetBulkPartialFailures == nil {
+		return b.bucket.SetBulk(entries)
+	}
+
+	writeEntries := make([]*sgbucket.BulkSetEntry, 0, len(entries))
+	for _, entry := range entries {
+		if b.config.SetBulkPartialFailures.next() {
+			entry.Error = fmt.Errorf("Leaky bucket forced SetBulk temporary failure for key %s", entry.Key)
+		} else {
+			writeEntries = append(writeEntries, entry)
+		}
+	}
+	if len(writeEntries) == 0 {
+		return nil
+	}
+	return b.bucket.SetBulk(writeEntries)
+}
+
+// Sleeps for the latency configured for the given operation, if any.
+func (b *LeakyBucket) delay(op string) {
+	if latency := b.config.OpLatency[op]; latency > 0 {
+		time.Sleep(latency)
+	}
 }
 
 func (b *LeakyBucket) Incr(k string, amt, def uint64, exp uint32) (uint64, error) {
+	b.delay("Incr")
 
 	if b.config.IncrTemporaryFailCount > 0 {
 		if b.incrCount < b.config.IncrTemporaryFailCount {
@@ -125,9 +216,11 @@ func (b *LeakyBucket) DeleteDDoc(docname string) error {
 	return b.bucket.DeleteDDoc(docname)
 }
 func (b *LeakyBucket) View(ddoc, name string, params map[string]interface{}) (sgbucket.ViewResult, error) {
+	b.delay("View")
 	return b.bucket.View(ddoc, name, params)
 }
 func (b *LeakyBucket) ViewCustom(ddoc, name string, params map[string]interface{}, vres interface{}) error {
+	b.delay("ViewCustom")
 	err := b.bucket.ViewCustom(ddoc, name, params, vres)
 
 	if b.config.FirstTimeViewCustomPartialError {
@@ -139,6 +232,7 @@ func (b *LeakyBucket) ViewCustom(ddoc, name string, params map[string]interface{
 }
 
 func (b *LeakyBucket) ViewQuery(ddoc, name string, params map[string]interface{}) (sgbucket.QueryResultIterator, error) {
+	b.delay("ViewQuery")
 	iterator, err := b.bucket.ViewQuery(ddoc, name, params)
 
 	if b.config.FirstTimeViewCustomPartialError {
@@ -161,18 +255,25 @@ func (b *LeakyBucket) Refresh() error {
 }
 
 func (b *LeakyBucket) WriteCasWithXattr(k string, xattr string, exp uint32, cas uint64, v interface{}, xv interface{}) (casOut uint64, err error) {
+	b.delay("WriteCasWithXattr")
+	if b.config.CASMismatches.next() {
+		return 0, fmt.Errorf("Leaky bucket forced CAS mismatch for key %s", k)
+	}
 	return b.bucket.WriteCasWithXattr(k, xattr, exp, cas, v, xv)
 }
 
 func (b *LeakyBucket) WriteUpdateWithXattr(k string, xattr string, exp uint32, previous *sgbucket.BucketDocument, callback sgbucket.WriteUpdateWithXattrFunc) (casOut uint64, err error) {
+	b.delay("WriteUpdateWithXattr")
 	return b.bucket.WriteUpdateWithXattr(k, xattr, exp, previous, callback)
 }
 
 func (b *LeakyBucket) GetWithXattr(k string, xattr string, rv interface{}, xv interface{}) (cas uint64, err error) {
+	b.delay("GetWithXattr")
 	return b.bucket.GetWithXattr(k, xattr, rv, xv)
 }
 
 func (b *LeakyBucket) DeleteWithXattr(k string, xattr string) error {
+	b.delay("DeleteWithXattr")
 	return b.bucket.DeleteWithXattr(k, xattr)
 }
 
@@ -180,6 +281,11 @@ func (b *LeakyBucket) StartTapFeed(args sgbucket.FeedArguments) (sgbucket.Mutati
 
 	if b.config.TapFeedDeDuplication {
 		return b.wrapFeedForDeduplication(args)
+	} else if b.config.FeedEventDrops != nil {
+		callback := func(event *sgbucket.FeedEvent) bool {
+			return !b.config.FeedEventDrops.next()
+		}
+		return b.wrapFeed(args, callback)
 	} else if len(b.config.TapFeedMissingDocs) > 0 {
 		callback := func(event *sgbucket.FeedEvent) bool {
 			for _, key := range b.config.TapFeedMissingDocs {
@@ -219,6 +325,15 @@ func (b *LeakyBucket) StartTapFeed(args sgbucket.FeedArguments) (sgbucket.Mutati
 }
 
 func (b *LeakyBucket) StartDCPFeed(args sgbucket.FeedArguments, callback sgbucket.FeedEventCallbackFunc) error {
+	if b.config.FeedEventDrops != nil {
+		wrappedCallback := callback
+		callback = func(event sgbucket.FeedEvent) bool {
+			if b.config.FeedEventDrops.next() {
+				return false
+			}
+			return wrappedCallback(event)
+		}
+	}
 	return b.bucket.StartDCPFeed(args, callback)
 }
 
@@ -320,6 +435,11 @@ func (b *LeakyBucket) wrapFeedForDeduplication(args sgbucket.FeedArguments) (sgb
 	return dupeTapFeed, nil
 }
 
+// GetUnderlyingBucket returns the underlying bucket for the LeakyBucket.
+func (b *LeakyBucket) GetUnderlyingBucket() Bucket {
+	return b.bucket
+}
+
 func (b *LeakyBucket) Close() {
 	b.bucket.Close()
 }
@@ -2,9 +2,11 @@ package base
 
 import (
 	"testing"
+	"time"
 
 	sgbucket "github.com/couchbase/sg-bucket"
 	goassert "github.com/couchbaselabs/go.assert"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestDedupeTapEventsLaterSeqSameDoc(t *testing.T) {
@@ -58,3 +60,71 @@ func TestDedupeNoDedupeDifferentDocs(t *testing.T) {
 	goassert.True(t, len(deduped) == 2)
 
 }
+
+func TestFaultSchedule(t *testing.T) {
+
+	// Skip the first 2 calls, then fault every 3rd call, twice
+	schedule := &FaultSchedule{Skip: 2, Every: 3, Count: 2}
+	var faultedCalls []int
+	for call := 1; call <= 12; call++ {
+		if schedule.next() {
+			faultedCalls = append(faultedCalls, call)
+		}
+	}
+	assert.Equal(t, []int{5, 8}, faultedCalls)
+	assert.Equal(t, 2, schedule.Faults())
+
+	// A nil schedule never faults
+	var nilSchedule *FaultSchedule
+	assert.False(t, nilSchedule.next())
+}
+
+func TestLeakyBucketCASMismatches(t *testing.T) {
+
+	testBucket := GetTestBucketOrPanic()
+	defer testBucket.Close()
+
+	casMismatches := &FaultSchedule{Count: 3}
+	bucket := NewLeakyBucket(testBucket.Bucket, LeakyBucketConfig{CASMismatches: casMismatches})
+
+	key := "TestLeakyBucketCASMismatches"
+	assert.NoError(t, bucket.SetRaw(key, 0, []byte("1")))
+	_, cas, err := bucket.GetRaw(key)
+	assert.NoError(t, err)
+
+	_, err = bucket.WriteCas(key, 0, 0, cas, []byte("2"), sgbucket.Raw)
+	assert.True(t, IsCasMismatch(err))
+
+	// WriteCasRaw retries until the CAS mismatches stop
+	numCallbacks := 0
+	_, err = WriteCasRaw(bucket, key, []byte("2"), cas, 0, func(value []byte) ([]byte, error) {
+		numCallbacks++
+		return []byte("2"), nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, numCallbacks)
+	assert.Equal(t, 3, casMismatches.Faults())
+
+	value, _, err := bucket.GetRaw(key)
+	assert.NoError(t, err)
+	assert.Equal(t, "2", string(value))
+}
+
+func TestLeakyBucketOpLatency(t *testing.T) {
+
+	testBucket := GetTestBucketOrPanic()
+	defer testBucket.Close()
+
+	latency := 100 * time.Millisecond
+	bucket := NewLeakyBucket(testBucket.Bucket, LeakyBucketConfig{OpLatency: map[string]time.Duration{"GetRaw": latency}})
+
+	key := "TestLeakyBucketOpLatency"
+	start := time.Now()
+	assert.NoError(t, bucket.SetRaw(key, 0, []byte("1")))
+	assert.True(t, time.Since(start) < latency, "SetRaw shouldn't be delayed")
+
+	start = time.Now()
+	_, _, err := bucket.GetRaw(key)
+	assert.NoError(t, err)
+	assert.True(t, time.Since(start) >= latency, "GetRaw should be delayed")
+}
@@ -0,0 +1,334 @@
+package base
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Environment variables through which a restarting Sync Gateway hands its listening sockets to its new process.
+const (
+	ListenerFDsEnvVar   = "SG_LISTENER_FDS"   // The inherited sockets, as comma-separated addr=fd pairs
+	ListenerReadyEnvVar = "SG_LISTENER_READY" // fd of a pipe the new process writes to once it's listening
+)
+
+// The listening sockets and servers of this process, so the sockets can be handed to a new process on restart and
+// the servers drained.
+type listenerHandoff struct {
+	lock      sync.Mutex
+	changed   *sync.Cond                    // Broadcast when a listener is added
+	listeners map[string]*net.TCPListener   // Listening sockets, by address
+	inherited map[string]*net.TCPListener   // Sockets inherited from the previous process that haven't been used yet
+	servers   map[*http.Server]*connTracker // Running servers, and their open connections
+	drained   chan struct{}                 // Closed once DrainListeners is done
+	loadOnce  sync.Once
+}
+
+var handoff = newListenerHandoff()
+
+func newListenerHandoff() *listenerHandoff {
+	h := &listenerHandoff{
+		listeners: map[string]*net.TCPListener{},
+		inherited: map[string]*net.TCPListener{},
+		servers:   map[*http.Server]*connTracker{},
+		drained:   make(chan struct{}),
+	}
+	h.changed = sync.NewCond(&h.lock)
+	return h
+}
+
+// Takes over the sockets listed in the environment by the process that started this one, if any.
+func loadInheritedListeners() {
+	fds := os.Getenv(ListenerFDsEnvVar)
+	if fds == "" {
+		return
+	}
+	os.Unsetenv(ListenerFDsEnvVar) // Not to be passed on to this process's own children
+	if err := inheritListeners(fds); err != nil {
+		Warnf(KeyAll, "Unable to take over the listening sockets of the previous process: %v", err)
+	}
+}
+
+// Adds the sockets with the given addr=fd pairs to the inherited listeners.
+func inheritListeners(fds string) error {
+	handoff.lock.Lock()
+	defer handoff.lock.Unlock()
+	for _, pair := range strings.Split(fds, ",") {
+		i := strings.LastIndex(pair, "=")
+		if i < 0 {
+			return fmt.Errorf("Invalid %s entry %q", ListenerFDsEnvVar, pair)
+		}
+		fd, err := strconv.Atoi(pair[i+1:])
+		if err != nil {
+			return fmt.Errorf("Invalid %s entry %q", ListenerFDsEnvVar, pair)
+		}
+		file := os.NewFile(uintptr(fd), pair[:i])
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+		tcpListener, ok := listener.(*net.TCPListener)
+		if !ok {
+			listener.Close()
+			return fmt.Errorf("Inherited socket for %s isn't a TCP listener", pair[:i])
+		}
+		handoff.inherited[pair[:i]] = tcpListener
+	}
+	return nil
+}
+
+// Equivalent to net.Listen("tcp", addr), except that a socket for addr inherited from the previous process is used
+// if there is one, and the socket is remembered so it can be handed to the next one.
+func listenTCP(addr string) (net.Listener, error) {
+	handoff.loadOnce.Do(loadInheritedListeners)
+	handoff.lock.Lock()
+	defer handoff.lock.Unlock()
+	listener, ok := handoff.inherited[addr]
+	if ok {
+		delete(handoff.inherited, addr)
+		Infof(KeyHTTP, "Took over the listening socket for %s from the previous process", SD(addr))
+	} else {
+		newListener, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		listener = newListener.(*net.TCPListener)
+	}
+	handoff.listeners[addr] = listener
+	handoff.changed.Broadcast()
+	return &handoffListener{TCPListener: listener, addr: addr}, nil
+}
+
+// A listening socket that's forgotten by the handoff once it's closed.
+type handoffListener struct {
+	*net.TCPListener
+	addr string
+}
+
+func (l *handoffListener) Close() error {
+	handoff.lock.Lock()
+	if handoff.listeners[l.addr] == l.TCPListener {
+		delete(handoff.listeners, l.addr)
+	}
+	handoff.lock.Unlock()
+	return l.TCPListener.Close()
+}
+
+// Registers a server so that DrainListeners waits for its connections, wrapping its listener to count them.
+func trackServer(server *http.Server, listener net.Listener) net.Listener {
+	tracker := &connTracker{Listener: listener}
+	handoff.lock.Lock()
+	handoff.servers[server] = tracker
+	handoff.lock.Unlock()
+	return tracker
+}
+
+func untrackServer(server *http.Server) {
+	handoff.lock.Lock()
+	delete(handoff.servers, server)
+	handoff.lock.Unlock()
+}
+
+// Called by a server that's been shut down, to keep it from returning while the connections it handed over to
+// WebSocket handlers, such as BLIP replications, are still being drained.
+func waitForDrain() {
+	<-handoff.drained
+}
+
+// Starts a new process with the same command line as this one, handing it the listening sockets, and waits up to
+// readyTimeout for it to report that it's listening.  If it doesn't, it's killed and an error returned; this process
+// keeps its sockets either way, so it can carry on serving until it's drained.
+func HandOffListeners(readyTimeout time.Duration) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	handoff.lock.Lock()
+	var files []*os.File
+	var fds []string
+	for addr, listener := range handoff.listeners {
+		file, err := listener.File()
+		if err != nil {
+			handoff.lock.Unlock()
+			closeFiles(files)
+			return err
+		}
+		fds = append(fds, addr+"="+strconv.Itoa(3+len(files))) // ExtraFiles start at fd 3
+		files = append(files, file)
+	}
+	handoff.lock.Unlock()
+	defer closeFiles(files)
+
+	readyReader, readyWriter, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer readyReader.Close()
+	readyFD := 3 + len(files)
+	files = append(files, readyWriter)
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = append(os.Environ(),
+		ListenerFDsEnvVar+"="+strings.Join(fds, ","),
+		ListenerReadyEnvVar+"="+strconv.Itoa(readyFD))
+	if err := cmd.Start(); err != nil {
+		readyWriter.Close()
+		return err
+	}
+	readyWriter.Close() // Only the new process's copy is left open, so reading ends if it exits
+
+	ready := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1)
+		if n, _ := readyReader.Read(buf); n == 1 {
+			ready <- nil
+		} else {
+			ready <- fmt.Errorf("New process exited before it was listening")
+		}
+	}()
+	select {
+	case err = <-ready:
+	case <-time.After(readyTimeout):
+		err = fmt.Errorf("New process wasn't listening after %v", readyTimeout)
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return err
+	}
+	go cmd.Wait()
+	Infof(KeyAll, "Handed the listening sockets to new process %d", cmd.Process.Pid)
+	return nil
+}
+
+func closeFiles(files []*os.File) {
+	for _, file := range files {
+		file.Close()
+	}
+}
+
+// Called by a process started by HandOffListeners once it has started its servers, to tell the previous process
+// that it can stop accepting connections.  Waits until the given addresses are all being listened on, then closes
+// any inherited sockets that weren't used, such as the old port after a change of interface.  Does nothing if this
+// process wasn't started by a handoff.
+func NotifyListenersReady(addrs ...string) {
+	readyFD, err := strconv.Atoi(os.Getenv(ListenerReadyEnvVar))
+	if err != nil {
+		return
+	}
+	os.Unsetenv(ListenerReadyEnvVar)
+
+	handoff.lock.Lock()
+	for !handoff.listening(addrs) {
+		handoff.changed.Wait()
+	}
+	for addr, listener := range handoff.inherited {
+		listener.Close()
+		delete(handoff.inherited, addr)
+	}
+	handoff.lock.Unlock()
+
+	ready := os.NewFile(uintptr(readyFD), "listener-ready")
+	if _, err := ready.Write([]byte{1}); err != nil {
+		Warnf(KeyAll, "Unable to tell the previous process that the listeners are ready: %v", err)
+	}
+	ready.Close()
+}
+
+// Whether all the given addresses are being listened on.  Must be called with the lock held.
+func (h *listenerHandoff) listening(addrs []string) bool {
+	for _, addr := range addrs {
+		if _, ok := h.listeners[addr]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Stops the servers from accepting connections, then waits up to timeout for the requests and the WebSocket
+// connections in progress to finish, before returning.  Requests still in progress after that are cut off when the
+// process exits.
+func DrainListeners(timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	handoff.lock.Lock()
+	servers := make(map[*http.Server]*connTracker, len(handoff.servers))
+	for server, tracker := range handoff.servers {
+		servers[server] = tracker
+	}
+	handoff.lock.Unlock()
+
+	// Shutdown closes the listeners and idle connections, and waits for the requests in progress
+	var wg sync.WaitGroup
+	for server := range servers {
+		wg.Add(1)
+		go func(server *http.Server) {
+			defer wg.Done()
+			if err := server.Shutdown(ctx); err != nil {
+				Infof(KeyHTTP, "Requests still in progress on %s after draining for %v", SD(server.Addr), timeout)
+			}
+		}(server)
+	}
+	wg.Wait()
+
+	// Connections hijacked by WebSocket handlers aren't tracked by the server, so are waited for here
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		open := int64(0)
+		for _, tracker := range servers {
+			open += atomic.LoadInt64(&tracker.open)
+		}
+		if open == 0 {
+			Infof(KeyAll, "Drained all connections")
+			break
+		}
+		select {
+		case <-ticker.C:
+			continue
+		case <-ctx.Done():
+			Infof(KeyAll, "Closing %d connections still open after draining for %v", open, timeout)
+		}
+		break
+	}
+	close(handoff.drained)
+}
+
+// A listener that counts its open connections.
+type connTracker struct {
+	net.Listener
+	open int64 // Accessed atomically
+}
+
+func (t *connTracker) Accept() (net.Conn, error) {
+	conn, err := t.Listener.Accept()
+	if err != nil {
+		return conn, err
+	}
+	atomic.AddInt64(&t.open, 1)
+	return &trackedConn{Conn: conn, tracker: t}, nil
+}
+
+type trackedConn struct {
+	net.Conn
+	tracker   *connTracker
+	closeOnce sync.Once
+}
+
+func (c *trackedConn) Close() error {
+	c.closeOnce.Do(func() { atomic.AddInt64(&c.tracker.open, -1) })
+	return c.Conn.Close()
+}
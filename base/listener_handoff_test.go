@@ -0,0 +1,89 @@
+package base
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInheritListeners(t *testing.T) {
+	defer func() { handoff = newListenerHandoff() }()
+
+	original, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer original.Close()
+	file, err := original.(*net.TCPListener).File()
+	assert.NoError(t, err)
+
+	assert.NoError(t, inheritListeners("example.com:4984="+strconv.Itoa(int(file.Fd()))))
+	assert.Error(t, inheritListeners("example.com:4985"))
+
+	// The inherited socket is used instead of listening again
+	listener, err := listenTCP("example.com:4984")
+	assert.NoError(t, err)
+	assert.Equal(t, original.Addr().String(), listener.Addr().String())
+	assert.True(t, handoff.listening([]string{"example.com:4984"}))
+	assert.NoError(t, listener.Close())
+	assert.False(t, handoff.listening([]string{"example.com:4984"}))
+}
+
+func TestDrainListeners(t *testing.T) {
+	defer func() { handoff = newListenerHandoff() }()
+
+	// Hijacks the connection, as a WebSocket handler does, and holds it until the client closes it
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		assert.NoError(t, err)
+		buf.WriteString("HTTP/1.1 101 Switching Protocols\r\n\r\n")
+		buf.Flush()
+		ioutil.ReadAll(conn)
+		conn.Close()
+	})
+	addr := "127.0.0.1:0"
+	served := make(chan error, 1)
+	go func() { served <- ListenAndServeHTTP(addr, 0, nil, nil, handler, nil, nil, TLSOptions{}) }()
+	handoff.lock.Lock()
+	for !handoff.listening([]string{addr}) {
+		handoff.changed.Wait()
+	}
+	listenAddr := handoff.listeners[addr].Addr().String()
+	handoff.lock.Unlock()
+
+	client, err := net.Dial("tcp", listenAddr)
+	assert.NoError(t, err)
+	_, err = client.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	assert.NoError(t, err)
+	status, err := bufio.NewReader(client).ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 101 Switching Protocols\r\n", status)
+
+	drained := make(chan struct{})
+	go func() {
+		DrainListeners(10 * time.Second)
+		close(drained)
+	}()
+
+	// New connections are refused, but the hijacked one is kept open until it's closed
+	time.Sleep(200 * time.Millisecond)
+	_, err = net.Dial("tcp", listenAddr)
+	assert.Error(t, err)
+	select {
+	case <-drained:
+		t.Fatal("Drain finished while a connection was open")
+	default:
+	}
+
+	assert.NoError(t, client.Close())
+	select {
+	case <-drained:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Drain didn't finish after the connection was closed")
+	}
+	assert.NoError(t, <-served)
+}
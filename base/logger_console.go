@@ -7,8 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
-
-	"github.com/natefinch/lumberjack"
+	"time"
 )
 
 // ConsoleLogger is a file logger with a default output of stderr, and tunable log level/keys.
@@ -49,6 +48,8 @@ func NewConsoleLogger(config *ConsoleLoggerConfig) (*ConsoleLogger, []DeferredLo
 		LogKey:       &logKey,
 		ColorEnabled: *config.ColorEnabled && isStderr,
 		FileLogger: FileLogger{
+			format: config.Format,
+			output: config.Output,
 			logger: log.New(config.Output, "", 0),
 		},
 		isStderr: isStderr,
@@ -62,6 +63,11 @@ func NewConsoleLogger(config *ConsoleLoggerConfig) (*ConsoleLogger, []DeferredLo
 		go logCollationWorker(logger.collateBuffer, logger.logger, *config.CollationBufferSize)
 	}
 
+	// Time-based rotation only applies when logging console output to a file.
+	if interval := config.Rotation.RotationIntervalHours; interval != nil && *interval > 0 && config.FileOutput != "" {
+		logger.startRotationTicker(time.Duration(*interval) * time.Hour)
+	}
+
 	if *config.Enabled {
 		consoleOutput := "stderr"
 		if config.FileOutput != "" {
@@ -105,6 +111,10 @@ func (lcc *ConsoleLoggerConfig) init() error {
 		return err
 	}
 
+	if err := validateLogFormat(lcc.Format); err != nil {
+		return err
+	}
+
 	// Default to os.Stderr if alternative output is not set
 	if lcc.Output == nil && lcc.FileOutput == "" {
 		lcc.Output = os.Stderr
@@ -113,12 +123,11 @@ func (lcc *ConsoleLoggerConfig) init() error {
 		if err := validateLogFileOutput(lcc.FileOutput); err != nil {
 			return err
 		}
-		lcc.Output = &lumberjack.Logger{
-			Filename: filepath.FromSlash(lcc.FileOutput),
-			MaxSize:  *lcc.Rotation.MaxSize,
-			MaxAge:   *lcc.Rotation.MaxAge,
-			Compress: false,
+		// Unlike the other file outputs, don't compress rotated console logs unless explicitly enabled.
+		if lcc.Rotation.Compress == nil {
+			lcc.Rotation.Compress = BoolPtr(false)
 		}
+		lcc.Output = newLumberjackOutput(filepath.FromSlash(lcc.FileOutput), lcc.Rotation)
 	}
 
 	// Default to false
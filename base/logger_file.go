@@ -5,6 +5,7 @@ import (
 	"io"
 	"log"
 	"path/filepath"
+	"time"
 
 	"github.com/natefinch/lumberjack"
 	"github.com/pkg/errors"
@@ -13,9 +14,10 @@ import (
 var (
 	ErrInvalidLogFilePath = errors.New("invalid log file path")
 
-	maxAgeLimit             = 9999 // days
-	defaultMaxSize          = 100  // 100 MB
-	defaultMaxAgeMultiplier = 2    // e.g. 90 minimum == 180 default maxAge
+	maxAgeLimit              = 9999 // days
+	defaultMaxSize           = 100  // 100 MB
+	defaultMaxAgeMultiplier  = 2    // e.g. 90 minimum == 180 default maxAge
+	maxRotationIntervalHours = 24 * 365
 
 	belowMinValueFmt = "%s for %v was set to %d which is below the minimum of %d"
 	aboveMaxValueFmt = "%s for %v was set to %d which is above the maximum of %d"
@@ -28,22 +30,31 @@ type FileLogger struct {
 	collateBuffer chan string
 	level         LogLevel
 	name          string
+	format        string
 	output        io.Writer
 	logger        *log.Logger
+
+	// rotationTicker triggers time-based rotation of the log file, if enabled, until rotationDone is closed.
+	rotationTicker *time.Ticker
+	rotationDone   chan struct{}
 }
 
 type FileLoggerConfig struct {
 	Enabled  *bool             `json:"enabled,omitempty"`  // Toggle for this log output
 	Rotation logRotationConfig `json:"rotation,omitempty"` // Log rotation settings
+	Format   string            `json:"format,omitempty"`   // Log output format, either "text" (default) or "json"
 
 	CollationBufferSize *int      `json:"collation_buffer_size,omitempty"` // The size of the log collation buffer.
 	Output              io.Writer `json:"-"`                               // Logger output. Defaults to os.Stderr. Can be overridden for testing purposes.
 }
 
 type logRotationConfig struct {
-	MaxSize   *int `json:"max_size,omitempty"`  // The maximum size in MB of the log file before it gets rotated.
-	MaxAge    *int `json:"max_age,omitempty"`   // The maximum number of days to retain old log files.
-	LocalTime bool `json:"localtime,omitempty"` // If true, it uses the computer's local time to format the backup timestamp.
+	MaxSize               *int  `json:"max_size,omitempty"`                // The maximum size in MB of the log file before it gets rotated.
+	MaxAge                *int  `json:"max_age,omitempty"`                 // The maximum number of days to retain old log files.
+	MaxBackups            *int  `json:"max_backups,omitempty"`             // The maximum number of old log files to retain. Zero retains all old log files (subject to MaxAge).
+	RotationIntervalHours *int  `json:"rotation_interval_hours,omitempty"` // If set, the log file is also rotated every N hours, regardless of size.
+	Compress              *bool `json:"compress,omitempty"`                // Whether rotated log files are compressed using gzip.
+	LocalTime             bool  `json:"localtime,omitempty"`               // If true, it uses the computer's local time to format the backup timestamp.
 }
 
 // NewFileLogger returms a new FileLogger from a config.
@@ -58,6 +69,7 @@ func NewFileLogger(config FileLoggerConfig, level LogLevel, name string, logFile
 		Enabled: *config.Enabled,
 		level:   level,
 		name:    name,
+		format:  config.Format,
 		output:  config.Output,
 		logger:  log.New(config.Output, "", 0),
 	}
@@ -70,9 +82,41 @@ func NewFileLogger(config FileLoggerConfig, level LogLevel, name string, logFile
 		go logCollationWorker(logger.collateBuffer, logger.logger, *config.CollationBufferSize)
 	}
 
+	if interval := config.Rotation.RotationIntervalHours; interval != nil && *interval > 0 && *config.Enabled {
+		logger.startRotationTicker(time.Duration(*interval) * time.Hour)
+	}
+
 	return logger, nil
 }
 
+// startRotationTicker will rotate the log file every interval, until stopRotationTicker is called.
+func (l *FileLogger) startRotationTicker(interval time.Duration) {
+	l.rotationTicker = time.NewTicker(interval)
+	l.rotationDone = make(chan struct{})
+	go func(ticker *time.Ticker, done chan struct{}) {
+		for {
+			select {
+			case <-ticker.C:
+				if err := l.Rotate(); err != nil {
+					Warnf(KeyAll, "Error rotating %v: %v", l, err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}(l.rotationTicker, l.rotationDone)
+}
+
+// stopRotationTicker stops any time-based rotation of the log file.
+func (l *FileLogger) stopRotationTicker() {
+	if l != nil && l.rotationTicker != nil {
+		// Stopping the ticker doesn't close its channel, so the goroutine has to be told to exit
+		l.rotationTicker.Stop()
+		close(l.rotationDone)
+		l.rotationTicker = nil
+	}
+}
+
 // Rotate will rotate the active log file.
 func (l *FileLogger) Rotate() error {
 	if l == nil {
@@ -100,6 +144,21 @@ func (l *FileLogger) logf(format string, args ...interface{}) {
 	}
 }
 
+// logEntry logs the pre-rendered JSON entry if the logger is configured for JSON output,
+// otherwise logs the given text format and args.
+func (l *FileLogger) logEntry(jsonEntry string, format string, args ...interface{}) {
+	if l.isJSON() {
+		l.logf("%s", jsonEntry)
+	} else {
+		l.logf(format, args...)
+	}
+}
+
+// isJSON returns true if the logger is configured to write JSON formatted log entries.
+func (l *FileLogger) isJSON() bool {
+	return l != nil && l.format == LogFormatJSON
+}
+
 // shouldLog returns true if we can log.
 func (l *FileLogger) shouldLog(logLevel LogLevel) bool {
 	return l != nil && l.logger != nil &&
@@ -123,11 +182,19 @@ func (lfc *FileLoggerConfig) init(level LogLevel, name string, logFilePath strin
 		return err
 	}
 
+	// Compress rotated log files by default.
+	if lfc.Rotation.Compress == nil {
+		lfc.Rotation.Compress = BoolPtr(true)
+	}
+
+	if err := validateLogFormat(lfc.Format); err != nil {
+		return err
+	}
+
 	if lfc.Output == nil {
 		lfc.Output = newLumberjackOutput(
 			filepath.Join(filepath.FromSlash(logFilePath), "sg_"+name+".log"),
-			*lfc.Rotation.MaxSize,
-			*lfc.Rotation.MaxAge,
+			lfc.Rotation,
 		)
 	}
 
@@ -163,14 +230,32 @@ func (lfc *FileLoggerConfig) initRotationConfig(name string, defaultMaxSize, min
 		return fmt.Errorf(aboveMaxValueFmt, "MaxAge", name, *lfc.Rotation.MaxAge, maxAgeLimit)
 	}
 
+	if lfc.Rotation.MaxBackups == nil {
+		// A value of zero retains all old log files in Lumberjack.
+		defaultMaxBackups := 0
+		lfc.Rotation.MaxBackups = &defaultMaxBackups
+	} else if *lfc.Rotation.MaxBackups < 0 {
+		return fmt.Errorf(belowMinValueFmt, "MaxBackups", name, *lfc.Rotation.MaxBackups, 0)
+	}
+
+	if lfc.Rotation.RotationIntervalHours != nil {
+		if *lfc.Rotation.RotationIntervalHours < 0 {
+			return fmt.Errorf(belowMinValueFmt, "RotationIntervalHours", name, *lfc.Rotation.RotationIntervalHours, 0)
+		} else if *lfc.Rotation.RotationIntervalHours > maxRotationIntervalHours {
+			return fmt.Errorf(aboveMaxValueFmt, "RotationIntervalHours", name, *lfc.Rotation.RotationIntervalHours, maxRotationIntervalHours)
+		}
+	}
+
 	return nil
 }
 
-func newLumberjackOutput(filename string, maxSize, maxAge int) *lumberjack.Logger {
+func newLumberjackOutput(filename string, rotation logRotationConfig) *lumberjack.Logger {
 	return &lumberjack.Logger{
-		Filename: filename,
-		MaxSize:  maxSize,
-		MaxAge:   maxAge,
-		Compress: true,
+		Filename:   filename,
+		MaxSize:    *rotation.MaxSize,
+		MaxAge:     *rotation.MaxAge,
+		MaxBackups: *rotation.MaxBackups,
+		LocalTime:  rotation.LocalTime,
+		Compress:   rotation.Compress == nil || *rotation.Compress,
 	}
 }
@@ -73,3 +73,73 @@ func TestFileShouldLog(t *testing.T) {
 		})
 	}
 }
+
+func TestFileLoggerRotationConfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		rotation    logRotationConfig
+		expectError bool
+	}{
+		{
+			name:     "defaults",
+			rotation: logRotationConfig{},
+		},
+		{
+			name:     "max backups and interval",
+			rotation: logRotationConfig{MaxBackups: IntPtr(5), RotationIntervalHours: IntPtr(24)},
+		},
+		{
+			name:        "negative max backups",
+			rotation:    logRotationConfig{MaxBackups: IntPtr(-1)},
+			expectError: true,
+		},
+		{
+			name:        "negative interval",
+			rotation:    logRotationConfig{RotationIntervalHours: IntPtr(-1)},
+			expectError: true,
+		},
+		{
+			name:        "interval too large",
+			rotation:    logRotationConfig{RotationIntervalHours: IntPtr(maxRotationIntervalHours + 1)},
+			expectError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(ts *testing.T) {
+			config := FileLoggerConfig{Rotation: test.rotation}
+			err := config.initRotationConfig("test", defaultMaxSize, infoMinAge)
+			if test.expectError {
+				goassert.NotEquals(ts, err, nil)
+				return
+			}
+			goassert.Equals(ts, err, nil)
+			goassert.NotEquals(ts, config.Rotation.MaxBackups, nil)
+		})
+	}
+}
+
+func TestNewLumberjackOutput(t *testing.T) {
+	config := FileLoggerConfig{
+		Rotation: logRotationConfig{
+			MaxBackups: IntPtr(3),
+			LocalTime:  true,
+		},
+		Output: ioutil.Discard,
+	}
+	goassert.Equals(t, config.init(LevelInfo, "info", "", infoMinAge), nil)
+
+	// Compression defaults to on for file outputs
+	goassert.Equals(t, *config.Rotation.Compress, true)
+
+	output := newLumberjackOutput("test.log", config.Rotation)
+	goassert.Equals(t, output.MaxSize, defaultMaxSize)
+	goassert.Equals(t, output.MaxAge, infoMinAge*defaultMaxAgeMultiplier)
+	goassert.Equals(t, output.MaxBackups, 3)
+	goassert.Equals(t, output.LocalTime, true)
+	goassert.Equals(t, output.Compress, true)
+
+	config.Rotation.Compress = BoolPtr(false)
+	output = newLumberjackOutput("test.log", config.Rotation)
+	goassert.Equals(t, output.Compress, false)
+}
@@ -0,0 +1,69 @@
+package base
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	// LogFormatText is the default, human-readable log output format.
+	LogFormatText = "text"
+	// LogFormatJSON writes each log entry as a single line JSON object, for ingestion by log aggregators.
+	LogFormatJSON = "json"
+)
+
+// jsonLogEntry is the structure of a single log line when using LogFormatJSON.
+type jsonLogEntry struct {
+	Timestamp     string `json:"timestamp"`
+	Level         string `json:"level,omitempty"`
+	Key           string `json:"key,omitempty"`
+	Database      string `json:"db,omitempty"`
+	CorrelationID string `json:"correlation_id,omitempty"`
+	Message       string `json:"msg"`
+	Caller        string `json:"caller,omitempty"`
+}
+
+// validateLogFormat returns an error if the given format is not a supported log format.
+func validateLogFormat(format string) error {
+	switch format {
+	case "", LogFormatText, LogFormatJSON:
+		return nil
+	default:
+		return fmt.Errorf("invalid log format: %q (valid options: %q, %q)", format, LogFormatText, LogFormatJSON)
+	}
+}
+
+// formatJSONLogEntry renders the given log message as a single line of JSON.
+// Any LogContext present in ctx is included as separate fields.
+func formatJSONLogEntry(ctx context.Context, logLevel LogLevel, logKey LogKey, caller string, format string, args ...interface{}) string {
+	entry := jsonLogEntry{
+		Timestamp: time.Now().Format(ISO8601Format),
+		Message:   fmt.Sprintf(format, args...),
+		Caller:    caller,
+	}
+
+	if logLevel > LevelNone {
+		entry.Level = logLevel.String()
+	}
+
+	if logKey > KeyNone && logKey != KeyAll {
+		entry.Key = logKey.String()
+	}
+
+	if ctx != nil {
+		if logCtx, ok := ctx.Value(LogContextKey{}).(LogContext); ok {
+			entry.Database = logCtx.Database
+			entry.CorrelationID = logCtx.CorrelationID
+		}
+	}
+
+	jsonEntry, err := json.Marshal(entry)
+	if err != nil {
+		// Shouldn't happen for a struct of strings, but fall back to the text format rather than dropping the log.
+		return addPrefixes(entry.Message, ctx, logLevel, logKey)
+	}
+
+	return string(jsonEntry)
+}
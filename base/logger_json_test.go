@@ -0,0 +1,69 @@
+package base
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateLogFormat(t *testing.T) {
+	assert.NoError(t, validateLogFormat(""))
+	assert.NoError(t, validateLogFormat(LogFormatText))
+	assert.NoError(t, validateLogFormat(LogFormatJSON))
+	assert.Error(t, validateLogFormat("xml"))
+}
+
+func TestFormatJSONLogEntry(t *testing.T) {
+	ctx := context.WithValue(context.Background(), LogContextKey{},
+		LogContext{CorrelationID: "#123", Database: "db1"},
+	)
+
+	var entry jsonLogEntry
+	err := json.Unmarshal([]byte(formatJSONLogEntry(ctx, LevelWarn, KeyCRUD, "caller.go:1", "doc %s: %d", "foo", 42)), &entry)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, entry.Timestamp)
+	assert.Equal(t, "warn", entry.Level)
+	assert.Equal(t, "CRUD", entry.Key)
+	assert.Equal(t, "db1", entry.Database)
+	assert.Equal(t, "#123", entry.CorrelationID)
+	assert.Equal(t, "doc foo: 42", entry.Message)
+	assert.Equal(t, "caller.go:1", entry.Caller)
+
+	// No context, and wildcard log key
+	entry = jsonLogEntry{}
+	err = json.Unmarshal([]byte(formatJSONLogEntry(context.TODO(), LevelInfo, KeyAll, "", "hello")), &entry)
+	assert.NoError(t, err)
+	assert.Equal(t, "info", entry.Level)
+	assert.Equal(t, "", entry.Key)
+	assert.Equal(t, "", entry.Database)
+	assert.Equal(t, "", entry.CorrelationID)
+	assert.Equal(t, "hello", entry.Message)
+}
+
+func TestJSONConsoleLogger(t *testing.T) {
+	originalLogger := consoleLogger
+	defer func() { consoleLogger = originalLogger }()
+
+	b := bytes.Buffer{}
+	level := LevelInfo
+	logKey := KeyAll
+	consoleLogger = &ConsoleLogger{
+		LogLevel:   &level,
+		LogKey:     &logKey,
+		FileLogger: FileLogger{Enabled: true, format: LogFormatJSON, logger: log.New(&b, "", 0)},
+	}
+
+	ctx := context.WithValue(context.Background(), LogContextKey{}, LogContext{CorrelationID: "#001", Database: "db"})
+	InfofCtx(ctx, KeyHTTP, "GET /db/")
+
+	var entry jsonLogEntry
+	assert.NoError(t, json.Unmarshal(b.Bytes(), &entry))
+	assert.Equal(t, "HTTP", entry.Key)
+	assert.Equal(t, "#001", entry.CorrelationID)
+	assert.Equal(t, "db", entry.Database)
+	assert.Equal(t, "GET /db/", entry.Message)
+}
@@ -496,31 +496,46 @@ func logTo(ctx context.Context, logLevel LogLevel, logKey LogKey, format string,
 		return
 	}
 
-	// Prepend timestamp, level, log key.
-	format = addPrefixes(format, ctx, logLevel, logKey)
-
 	// Warn and error logs also append caller name/line numbers.
+	var caller string
 	if logLevel <= LevelWarn {
-		format += " -- " + GetCallersName(2, true)
+		caller = GetCallersName(2, true)
 	}
 
 	// Perform log redaction, if necessary.
 	args = redact(args)
 
+	// Render the JSON form of the entry once, only if any of the outputs are going to use it.
+	var jsonEntry string
+	if (shouldLogConsole && consoleLogger.isJSON()) ||
+		(shouldLogError && errorLogger.isJSON()) ||
+		(shouldLogWarn && warnLogger.isJSON()) ||
+		(shouldLogInfo && infoLogger.isJSON()) ||
+		(shouldLogDebug && debugLogger.isJSON()) {
+		jsonEntry = formatJSONLogEntry(ctx, logLevel, logKey, caller, format, args...)
+	}
+
+	// Prepend timestamp, level, log key.
+	format = addPrefixes(format, ctx, logLevel, logKey)
+
+	if caller != "" {
+		format += " -- " + caller
+	}
+
 	if shouldLogConsole {
-		consoleLogger.logf(color(format, logLevel), args...)
+		consoleLogger.logEntry(jsonEntry, color(format, logLevel), args...)
 	}
 	if shouldLogError {
-		errorLogger.logf(format, args...)
+		errorLogger.logEntry(jsonEntry, format, args...)
 	}
 	if shouldLogWarn {
-		warnLogger.logf(format, args...)
+		warnLogger.logEntry(jsonEntry, format, args...)
 	}
 	if shouldLogInfo {
-		infoLogger.logf(format, args...)
+		infoLogger.logEntry(jsonEntry, format, args...)
 	}
 	if shouldLogDebug {
-		debugLogger.logf(format, args...)
+		debugLogger.logEntry(jsonEntry, format, args...)
 	}
 }
 
@@ -528,24 +543,27 @@ func logTo(ctx context.Context, logLevel LogLevel, logKey LogKey, format string,
 func LogSyncGatewayVersion() {
 	format := addPrefixes("==== %s ====", context.Background(), LevelNone, KeyNone)
 	msg := fmt.Sprintf(format, LongVersionString)
+	jsonMsg := formatJSONLogEntry(context.Background(), LevelNone, KeyNone, "", "==== %s ====", LongVersionString)
 
 	if !consoleLogger.isStderr {
 		fmt.Println(msg)
 	} else if consoleLogger.logger != nil {
-		consoleLogger.logger.Print(color(msg, LevelNone))
+		if consoleLogger.isJSON() {
+			consoleLogger.logger.Print(jsonMsg)
+		} else {
+			consoleLogger.logger.Print(color(msg, LevelNone))
+		}
 	}
 
-	if errorLogger.shouldLog(LevelNone) {
-		errorLogger.logger.Printf(msg)
-	}
-	if warnLogger.shouldLog(LevelNone) {
-		warnLogger.logger.Printf(msg)
-	}
-	if infoLogger.shouldLog(LevelNone) {
-		infoLogger.logger.Printf(msg)
-	}
-	if debugLogger.shouldLog(LevelNone) {
-		debugLogger.logger.Printf(msg)
+	for _, logger := range []*FileLogger{errorLogger, warnLogger, infoLogger, debugLogger} {
+		if !logger.shouldLog(LevelNone) {
+			continue
+		}
+		if logger.isJSON() {
+			logger.logger.Print(jsonMsg)
+		} else {
+			logger.logger.Print(msg)
+		}
 	}
 }
 
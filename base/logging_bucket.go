@@ -1,6 +1,7 @@
 package base
 
 import (
+	"context"
 	"time"
 
 	sgbucket "github.com/couchbase/sg-bucket"
@@ -9,6 +10,35 @@ import (
 // A wrapper around a Bucket that transparently adds logging of all the API calls.
 type LoggingBucket struct {
 	bucket Bucket
+	dbName string          // Name of the database the bucket was opened for, if any
+	logCtx context.Context // Context of the log entries, with dbName
+}
+
+// Returns a LoggingBucket whose log entries name the given database, if any.
+func NewLoggingBucket(bucket Bucket, dbName string) *LoggingBucket {
+	logCtx := context.TODO()
+	if dbName != "" {
+		logCtx = context.WithValue(context.Background(), LogContextKey{}, LogContext{Database: dbName})
+	}
+	return &LoggingBucket{bucket: bucket, dbName: dbName, logCtx: logCtx}
+}
+
+// logOp traces the given bucket operation and its duration, and warns if the
+// operation took longer than SlowBucketOpWarningThreshold.
+func (b *LoggingBucket) logOp(start time.Time, format string, args ...interface{}) {
+	logCtx := b.logCtx
+	if logCtx == nil {
+		logCtx = context.TODO()
+	}
+	TracefCtx(logCtx, KeyBucket, format+" [%v]", append(args, time.Since(start))...)
+	if b.dbName != "" {
+		// The text log format doesn't include the context's database
+		format = "bucket op for db %s: " + format
+		args = append([]interface{}{MD(b.dbName)}, args...)
+	} else {
+		format = "bucket op " + format
+	}
+	SlowOperationLog(logCtx, SlowBucketOpWarningThreshold, start, KeyBucket, format, args...)
 }
 
 func (b *LoggingBucket) GetName() string {
@@ -17,176 +47,176 @@ func (b *LoggingBucket) GetName() string {
 }
 func (b *LoggingBucket) Get(k string, rv interface{}) (uint64, error) {
 	start := time.Now()
-	defer func() { Tracef(KeyBucket, "Get(%q) [%v]", UD(k), time.Since(start)) }()
+	defer func() { b.logOp(start, "Get(%q)", UD(k)) }()
 	return b.bucket.Get(k, rv)
 }
 func (b *LoggingBucket) GetRaw(k string) (v []byte, cas uint64, err error) {
 	start := time.Now()
-	defer func() { Tracef(KeyBucket, "GetRaw(%q) [%v]", UD(k), time.Since(start)) }()
+	defer func() { b.logOp(start, "GetRaw(%q)", UD(k)) }()
 	return b.bucket.GetRaw(k)
 }
 func (b *LoggingBucket) GetAndTouchRaw(k string, exp uint32) (v []byte, cas uint64, err error) {
 	start := time.Now()
-	defer func() { Tracef(KeyBucket, "GetAndTouchRaw(%q) [%v]", UD(k), time.Since(start)) }()
+	defer func() { b.logOp(start, "GetAndTouchRaw(%q)", UD(k)) }()
 	return b.bucket.GetAndTouchRaw(k, exp)
 }
 func (b *LoggingBucket) Touch(k string, exp uint32) (cas uint64, err error) {
 	start := time.Now()
-	defer func() { Tracef(KeyBucket, "Touch(%q) [%v]", UD(k), time.Since(start)) }()
+	defer func() { b.logOp(start, "Touch(%q)", UD(k)) }()
 	return b.bucket.Touch(k, exp)
 }
 func (b *LoggingBucket) GetBulkRaw(keys []string) (map[string][]byte, error) {
 	start := time.Now()
-	defer func() { Tracef(KeyBucket, "GetBulkRaw(%q) [%v]", UD(keys), time.Since(start)) }()
+	defer func() { b.logOp(start, "GetBulkRaw(%q)", UD(keys)) }()
 	return b.bucket.GetBulkRaw(keys)
 }
 func (b *LoggingBucket) Add(k string, exp uint32, v interface{}) (added bool, err error) {
 	start := time.Now()
-	defer func() { Tracef(KeyBucket, "Add(%q, %d, ...) [%v]", UD(k), exp, time.Since(start)) }()
+	defer func() { b.logOp(start, "Add(%q, %d, ...)", UD(k), exp) }()
 	return b.bucket.Add(k, exp, v)
 }
 func (b *LoggingBucket) AddRaw(k string, exp uint32, v []byte) (added bool, err error) {
 	start := time.Now()
-	defer func() { Tracef(KeyBucket, "AddRaw(%q, %d, ...) [%v]", UD(k), exp, time.Since(start)) }()
+	defer func() { b.logOp(start, "AddRaw(%q, %d, ...)", UD(k), exp) }()
 	return b.bucket.AddRaw(k, exp, v)
 }
 func (b *LoggingBucket) Append(k string, data []byte) error {
 	start := time.Now()
-	defer func() { Tracef(KeyBucket, "Append(%q, ...) [%v]", UD(k), time.Since(start)) }()
+	defer func() { b.logOp(start, "Append(%q, ...)", UD(k)) }()
 	return b.bucket.Append(k, data)
 }
 func (b *LoggingBucket) Set(k string, exp uint32, v interface{}) error {
 	start := time.Now()
-	defer func() { Tracef(KeyBucket, "Set(%q, %d, ...) [%v]", UD(k), exp, time.Since(start)) }()
+	defer func() { b.logOp(start, "Set(%q, %d, ...)", UD(k), exp) }()
 	return b.bucket.Set(k, exp, v)
 }
 func (b *LoggingBucket) SetRaw(k string, exp uint32, v []byte) error {
 	start := time.Now()
-	defer func() { Tracef(KeyBucket, "SetRaw(%q, %d, ...) [%v]", UD(k), exp, time.Since(start)) }()
+	defer func() { b.logOp(start, "SetRaw(%q, %d, ...)", UD(k), exp) }()
 	return b.bucket.SetRaw(k, exp, v)
 }
 func (b *LoggingBucket) Delete(k string) error {
 	start := time.Now()
-	defer func() { Tracef(KeyBucket, "Delete(%q) [%v]", UD(k), time.Since(start)) }()
+	defer func() { b.logOp(start, "Delete(%q)", UD(k)) }()
 	return b.bucket.Delete(k)
 }
 func (b *LoggingBucket) Remove(k string, cas uint64) (casOut uint64, err error) {
 	start := time.Now()
-	defer func() { Tracef(KeyBucket, "Remove(%q) [%v]", UD(k), time.Since(start)) }()
+	defer func() { b.logOp(start, "Remove(%q)", UD(k)) }()
 	return b.bucket.Remove(k, cas)
 }
 func (b *LoggingBucket) Write(k string, flags int, exp uint32, v interface{}, opt sgbucket.WriteOptions) error {
 	start := time.Now()
 	defer func() {
-		Tracef(KeyBucket, "Write(%q, 0x%x, %d, ..., 0x%x) [%v]", UD(k), flags, exp, opt, time.Since(start))
+		b.logOp(start, "Write(%q, 0x%x, %d, ..., 0x%x)", UD(k), flags, exp, opt)
 	}()
 	return b.bucket.Write(k, flags, exp, v, opt)
 }
 func (b *LoggingBucket) WriteCas(k string, flags int, exp uint32, cas uint64, v interface{}, opt sgbucket.WriteOptions) (uint64, error) {
 	start := time.Now()
 	defer func() {
-		Tracef(KeyBucket, "WriteCas(%q, 0x%x, %d, %d, ..., 0x%x) [%v]", UD(k), flags, exp, cas, opt, time.Since(start))
+		b.logOp(start, "WriteCas(%q, 0x%x, %d, %d, ..., 0x%x)", UD(k), flags, exp, cas, opt)
 	}()
 	return b.bucket.WriteCas(k, flags, exp, cas, v, opt)
 }
 func (b *LoggingBucket) Update(k string, exp uint32, callback sgbucket.UpdateFunc) (casOut uint64, err error) {
 	start := time.Now()
-	defer func() { Tracef(KeyBucket, "Update(%q, %d, ...) --> %v [%v]", UD(k), exp, err, time.Since(start)) }()
+	defer func() { b.logOp(start, "Update(%q, %d, ...) --> %v", UD(k), exp, err) }()
 	return b.bucket.Update(k, exp, callback)
 }
 func (b *LoggingBucket) WriteUpdate(k string, exp uint32, callback sgbucket.WriteUpdateFunc) (casOut uint64, err error) {
 	start := time.Now()
-	defer func() { Tracef(KeyBucket, "WriteUpdate(%q, %d, ...) --> %v [%v]", UD(k), exp, err, time.Since(start)) }()
+	defer func() { b.logOp(start, "WriteUpdate(%q, %d, ...) --> %v", UD(k), exp, err) }()
 	return b.bucket.WriteUpdate(k, exp, callback)
 }
 
 func (b *LoggingBucket) Incr(k string, amt, def uint64, exp uint32) (uint64, error) {
 	start := time.Now()
-	defer func() { Tracef(KeyBucket, "Incr(%q, %d, %d, %d) [%v]", UD(k), amt, def, exp, time.Since(start)) }()
+	defer func() { b.logOp(start, "Incr(%q, %d, %d, %d)", UD(k), amt, def, exp) }()
 	return b.bucket.Incr(k, amt, def, exp)
 }
 func (b *LoggingBucket) WriteCasWithXattr(k string, xattr string, exp uint32, cas uint64, v interface{}, xv interface{}) (casOut uint64, err error) {
 	start := time.Now()
-	defer func() { Tracef(KeyBucket, "WriteCasWithXattr(%q, ...) [%v]", UD(k), time.Since(start)) }()
+	defer func() { b.logOp(start, "WriteCasWithXattr(%q, ...)", UD(k)) }()
 	return b.bucket.WriteCasWithXattr(k, xattr, exp, cas, v, xv)
 }
 func (b *LoggingBucket) WriteUpdateWithXattr(k string, xattr string, exp uint32, previous *sgbucket.BucketDocument, callback sgbucket.WriteUpdateWithXattrFunc) (casOut uint64, err error) {
 	start := time.Now()
 	defer func() {
-		Tracef(KeyBucket, "WriteUpdateWithXattr(%q, %d, ...) --> %v [%v]", UD(k), exp, err, time.Since(start))
+		b.logOp(start, "WriteUpdateWithXattr(%q, %d, ...) --> %v", UD(k), exp, err)
 	}()
 	return b.bucket.WriteUpdateWithXattr(k, xattr, exp, previous, callback)
 }
 func (b *LoggingBucket) GetWithXattr(k string, xattr string, rv interface{}, xv interface{}) (cas uint64, err error) {
 	start := time.Now()
-	defer func() { Tracef(KeyBucket, "GetWithXattr(%q, ...) [%v]", UD(k), time.Since(start)) }()
+	defer func() { b.logOp(start, "GetWithXattr(%q, ...)", UD(k)) }()
 	return b.bucket.GetWithXattr(k, xattr, rv, xv)
 }
 func (b *LoggingBucket) DeleteWithXattr(k string, xattr string) error {
 	start := time.Now()
-	defer func() { Tracef(KeyBucket, "DeleteWithXattr(%q, ...) [%v]", UD(k), time.Since(start)) }()
+	defer func() { b.logOp(start, "DeleteWithXattr(%q, ...)", UD(k)) }()
 	return b.bucket.DeleteWithXattr(k, xattr)
 }
 func (b *LoggingBucket) GetDDoc(docname string, value interface{}) error {
 	start := time.Now()
-	defer func() { Tracef(KeyBucket, "GetDDoc(%q, ...) [%v]", UD(docname), time.Since(start)) }()
+	defer func() { b.logOp(start, "GetDDoc(%q, ...)", UD(docname)) }()
 	return b.bucket.GetDDoc(docname, value)
 }
 func (b *LoggingBucket) PutDDoc(docname string, value interface{}) error {
 	start := time.Now()
-	defer func() { Tracef(KeyBucket, "PutDDoc(%q, ...) [%v]", UD(docname), time.Since(start)) }()
+	defer func() { b.logOp(start, "PutDDoc(%q, ...)", UD(docname)) }()
 	return b.bucket.PutDDoc(docname, value)
 }
 func (b *LoggingBucket) DeleteDDoc(docname string) error {
 	start := time.Now()
-	defer func() { Tracef(KeyBucket, "DeleteDDoc(%q, ...) [%v]", UD(docname), time.Since(start)) }()
+	defer func() { b.logOp(start, "DeleteDDoc(%q, ...)", UD(docname)) }()
 	return b.bucket.DeleteDDoc(docname)
 }
 func (b *LoggingBucket) View(ddoc, name string, params map[string]interface{}) (sgbucket.ViewResult, error) {
 	start := time.Now()
-	defer func() { Tracef(KeyBucket, "View(%q, %q, ...) [%v]", MD(ddoc), UD(name), time.Since(start)) }()
+	defer func() { b.logOp(start, "View(%q, %q, ...)", MD(ddoc), UD(name)) }()
 	return b.bucket.View(ddoc, name, params)
 }
 
 func (b *LoggingBucket) ViewCustom(ddoc, name string, params map[string]interface{}, vres interface{}) error {
 	start := time.Now()
-	defer func() { Tracef(KeyBucket, "ViewCustom(%q, %q, ...) [%v]", MD(ddoc), UD(name), time.Since(start)) }()
+	defer func() { b.logOp(start, "ViewCustom(%q, %q, ...)", MD(ddoc), UD(name)) }()
 	return b.bucket.ViewCustom(ddoc, name, params, vres)
 }
 
 func (b *LoggingBucket) ViewQuery(ddoc, name string, params map[string]interface{}) (sgbucket.QueryResultIterator, error) {
 	start := time.Now()
-	defer func() { Tracef(KeyBucket, "ViewQuery(%q, %q, ...) [%v]", MD(ddoc), UD(name), time.Since(start)) }()
+	defer func() { b.logOp(start, "ViewQuery(%q, %q, ...)", MD(ddoc), UD(name)) }()
 	return b.bucket.ViewQuery(ddoc, name, params)
 }
 
 func (b *LoggingBucket) SetBulk(entries []*sgbucket.BulkSetEntry) (err error) {
 	start := time.Now()
-	defer func() { Tracef(KeyBucket, "SetBulk(%q, ...) --> %v [%v]", UD(entries), err, time.Since(start)) }()
+	defer func() { b.logOp(start, "SetBulk(%q, ...) --> %v", UD(entries), err) }()
 	return b.bucket.SetBulk(entries)
 }
 
 func (b *LoggingBucket) Refresh() error {
 	start := time.Now()
-	defer func() { Tracef(KeyBucket, "Refresh() [%v]", time.Since(start)) }()
+	defer func() { b.logOp(start, "Refresh()") }()
 	return b.bucket.Refresh()
 }
 
 func (b *LoggingBucket) StartTapFeed(args sgbucket.FeedArguments) (sgbucket.MutationFeed, error) {
 	start := time.Now()
-	defer func() { Tracef(KeyBucket, "StartTapFeed(...) [%v]", time.Since(start)) }()
+	defer func() { b.logOp(start, "StartTapFeed(...)") }()
 	return b.bucket.StartTapFeed(args)
 }
 
 func (b *LoggingBucket) StartDCPFeed(args sgbucket.FeedArguments, callback sgbucket.FeedEventCallbackFunc) error {
 	start := time.Now()
-	defer func() { Tracef(KeyBucket, "StartDcpFeed(...) [%v]", time.Since(start)) }()
+	defer func() { b.logOp(start, "StartDcpFeed(...)") }()
 	return b.bucket.StartDCPFeed(args, callback)
 }
 
 func (b *LoggingBucket) Close() {
 	start := time.Now()
-	defer func() { Tracef(KeyBucket, "Close() [%v]", time.Since(start)) }()
+	defer func() { b.logOp(start, "Close()") }()
 	b.bucket.Close()
 }
 func (b *LoggingBucket) Dump() {
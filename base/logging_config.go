@@ -33,6 +33,8 @@ var ErrUnsetLogFilePath = errors.New("No log_file_path property specified in con
 type LoggingConfig struct {
 	LogFilePath    string              `json:"log_file_path,omitempty"`   // Absolute or relative path on the filesystem to the log file directory. A relative path is from the directory that contains the Sync Gateway executable file.
 	RedactionLevel RedactionLevel      `json:"redaction_level,omitempty"` // Redaction level to apply to log output.
+	RedactClasses  UserDataRedaction   `json:"redact_classes,omitempty"`  // Per-class overrides of the redaction level: "username", "doc_id", "channel", "doc_body" or "other" mapped to "none", "tag" or "hash".
+	RedactionSalt  string              `json:"redaction_salt,omitempty"`  // Salt used when hashing user data.  Random if not set.
 	Console        ConsoleLoggerConfig `json:"console,omitempty"`         // Console output
 	Error          FileLoggerConfig    `json:"error,omitempty"`           // Error log file output
 	Warn           FileLoggerConfig    `json:"warn,omitempty"`            // Warn log file output
@@ -49,6 +51,9 @@ func (c *LoggingConfig) Init(defaultLogFilePath string) (warnings []DeferredLogF
 		return warnings, errors.New("nil LoggingConfig")
 	}
 
+	// Stop time-based rotation of any previously initialized loggers before replacing them.
+	stopRotationTickers()
+
 	consoleLogger, warnings, err = NewConsoleLogger(&c.Console)
 	if err != nil {
 		return warnings, err
@@ -158,3 +163,13 @@ func validateLogFileOutput(logFileOutput string) error {
 func hasLogFilePath(logFilePath *string, defaultLogFilePath string) bool {
 	return (logFilePath != nil && *logFilePath != "") || defaultLogFilePath != ""
 }
+
+// stopRotationTickers stops the time-based rotation of all active loggers.
+func stopRotationTickers() {
+	if consoleLogger != nil {
+		consoleLogger.stopRotationTicker()
+	}
+	for _, logger := range []*FileLogger{errorLogger, warnLogger, infoLogger, debugLogger, statsLogger} {
+		logger.stopRotationTicker()
+	}
+}
@@ -8,6 +8,9 @@ type LogContext struct {
 	// CorrelationID is a pre-formatted identifier used to correlate logs.
 	// E.g: Either blip context ID or HTTP Serial number.
 	CorrelationID string
+
+	// Database is the name of the database the log entry relates to, if any.
+	Database string
 }
 
 // addContext returns a string format with additional log context if present.
@@ -0,0 +1,27 @@
+package base
+
+import (
+	"context"
+	"time"
+)
+
+// Thresholds above which operations are logged as slow, by subsystem.  A zero threshold disables slow
+// operation logging for that subsystem.  View and N1QL queries use SlowQueryWarningThreshold.
+var (
+	SlowBucketOpWarningThreshold     time.Duration
+	SlowSyncFunctionWarningThreshold time.Duration
+	SlowChangesWarningThreshold      time.Duration
+)
+
+// SlowOperationLog logs a warning under the given log key if the time elapsed since startTime exceeds
+// the given threshold.  The message format and args should identify the operation, e.g. by doc ID or channel.
+func SlowOperationLog(ctx context.Context, threshold time.Duration, startTime time.Time, logKey LogKey, messageFormat string, args ...interface{}) {
+	if threshold <= 0 {
+		return
+	}
+
+	if elapsed := time.Since(startTime); elapsed > threshold {
+		StatsResourceUtilization().Add(StatKeySlowOperationCount, 1)
+		WarnfCtx(ctx, logKey, "Slow operation: "+messageFormat+" took %v, exceeding threshold of %v", append(args, elapsed, threshold)...)
+	}
+}
@@ -11,12 +11,14 @@ package base
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"log"
 	"math/rand"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	goassert "github.com/couchbaselabs/go.assert"
 	"github.com/natefinch/lumberjack"
@@ -52,6 +54,42 @@ func TestRedactedLogFuncs(t *testing.T) {
 	assertLogContains(t, "Username: <ud>alice</ud>", func() { Warnf(KeyAll, "Username: %s", username) })
 }
 
+func TestSlowOperationLog(t *testing.T) {
+	startTime := time.Now().Add(-time.Second)
+
+	assertLogContains(t, "Slow operation: Get(\"doc1\") took", func() {
+		SlowOperationLog(context.TODO(), time.Millisecond, startTime, KeyBucket, "Get(%q)", "doc1")
+	})
+	assertLogContains(t, "exceeding threshold of 1ms", func() {
+		SlowOperationLog(context.TODO(), time.Millisecond, startTime, KeyBucket, "Get(%q)", "doc1")
+	})
+
+	// Operations under the threshold, or with slow operation logging disabled, aren't logged
+	originalLogger := consoleLogger
+	defer func() { consoleLogger = originalLogger }()
+	b := bytes.Buffer{}
+	level := LevelDebug
+	consoleLogger = &ConsoleLogger{LogLevel: &level, FileLogger: FileLogger{Enabled: true, logger: log.New(&b, "", 0)}}
+
+	SlowOperationLog(context.TODO(), time.Hour, startTime, KeyBucket, "Get(%q)", "doc1")
+	SlowOperationLog(context.TODO(), 0, startTime, KeyBucket, "Get(%q)", "doc1")
+	goassert.Equals(t, b.String(), "")
+}
+
+func TestLoggingBucketSlowOperation(t *testing.T) {
+	defer func(threshold time.Duration) { SlowBucketOpWarningThreshold = threshold }(SlowBucketOpWarningThreshold)
+	SlowBucketOpWarningThreshold = time.Millisecond
+	startTime := time.Now().Add(-time.Second)
+
+	// Slow operations name the database the bucket was opened for
+	assertLogContains(t, "Slow operation: bucket op for db db1: Get(\"doc1\") took", func() {
+		NewLoggingBucket(nil, "db1").logOp(startTime, "Get(%q)", "doc1")
+	})
+	assertLogContains(t, "Slow operation: bucket op Get(\"doc1\") took", func() {
+		NewLoggingBucket(nil, "").logOp(startTime, "Get(%q)", "doc1")
+	})
+}
+
 func Benchmark_LoggingPerformance(b *testing.B) {
 
 	consoleLogger.LogKey.Enable(KeyCRUD)
@@ -0,0 +1,389 @@
+package base
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	sgbucket "github.com/couchbase/sg-bucket"
+)
+
+// Wraps a map function of a PrefixedBucket's design doc, so that it only indexes the bucket's own docs, and sees
+// their IDs without the key prefix.  Arguments are the quoted key prefix and its length.
+const kPrefixedMapFnHeaderFormat = `function(doc, meta) {if (meta.id.indexOf(%s) != 0) {return;} var unprefixedMeta = JSON.parse(JSON.stringify(meta)); unprefixedMeta.id = meta.id.substring(%d); (`
+const kPrefixedMapFnFooter = `)(doc, unprefixedMeta);}`
+
+// A wrapper around a Bucket that transparently namespaces all keys with a prefix, so that several databases can share
+// a bucket.  Keys are prefixed on the way in, and feed events, view rows and bulk results for keys outside the
+// namespace are filtered out, with the prefix stripped from the rest.  Design doc names are prefixed too, and their
+// map functions only index the namespace's docs.
+//
+// N1QL isn't supported, as queries would span namespaces, and neither is direct access to the underlying
+// CouchbaseBucketGoCB - AsN1QLBucket and AsGoCBBucket return false for a PrefixedBucket.
+type PrefixedBucket struct {
+	bucket Bucket
+	prefix string
+}
+
+func NewPrefixedBucket(bucket Bucket, prefix string) *PrefixedBucket {
+	return &PrefixedBucket{
+		bucket: bucket,
+		prefix: prefix,
+	}
+}
+
+// Adds the prefix to a key.
+func (b *PrefixedBucket) key(k string) string {
+	return b.prefix + k
+}
+
+// Removes the prefix from a key of the underlying bucket.  Returns false if the key is outside the namespace.
+func (b *PrefixedBucket) unprefixedKey(k string) (string, bool) {
+	if !strings.HasPrefix(k, b.prefix) {
+		return "", false
+	}
+	return k[len(b.prefix):], true
+}
+
+// Prefix returns the key prefix of the bucket's namespace.
+func (b *PrefixedBucket) Prefix() string {
+	return b.prefix
+}
+
+// GetUnderlyingBucket returns the underlying bucket for the PrefixedBucket.
+func (b *PrefixedBucket) GetUnderlyingBucket() Bucket {
+	return b.bucket
+}
+
+func (b *PrefixedBucket) GetName() string {
+	return b.bucket.GetName()
+}
+func (b *PrefixedBucket) Get(k string, rv interface{}) (cas uint64, err error) {
+	return b.bucket.Get(b.key(k), rv)
+}
+func (b *PrefixedBucket) GetRaw(k string) (v []byte, cas uint64, err error) {
+	return b.bucket.GetRaw(b.key(k))
+}
+func (b *PrefixedBucket) GetBulkRaw(keys []string) (map[string][]byte, error) {
+	prefixedKeys := make([]string, len(keys))
+	for i, k := range keys {
+		prefixedKeys[i] = b.key(k)
+	}
+	prefixedResults, err := b.bucket.GetBulkRaw(prefixedKeys)
+	if prefixedResults == nil {
+		return nil, err
+	}
+	results := make(map[string][]byte, len(prefixedResults))
+	for k, v := range prefixedResults {
+		if unprefixed, ok := b.unprefixedKey(k); ok {
+			results[unprefixed] = v
+		}
+	}
+	return results, err
+}
+func (b *PrefixedBucket) GetAndTouchRaw(k string, exp uint32) (v []byte, cas uint64, err error) {
+	return b.bucket.GetAndTouchRaw(b.key(k), exp)
+}
+func (b *PrefixedBucket) Touch(k string, exp uint32) (cas uint64, err error) {
+	return b.bucket.Touch(b.key(k), exp)
+}
+func (b *PrefixedBucket) Add(k string, exp uint32, v interface{}) (added bool, err error) {
+	return b.bucket.Add(b.key(k), exp, v)
+}
+func (b *PrefixedBucket) AddRaw(k string, exp uint32, v []byte) (added bool, err error) {
+	return b.bucket.AddRaw(b.key(k), exp, v)
+}
+func (b *PrefixedBucket) Append(k string, data []byte) error {
+	return b.bucket.Append(b.key(k), data)
+}
+func (b *PrefixedBucket) Set(k string, exp uint32, v interface{}) error {
+	return b.bucket.Set(b.key(k), exp, v)
+}
+func (b *PrefixedBucket) SetRaw(k string, exp uint32, v []byte) error {
+	return b.bucket.SetRaw(b.key(k), exp, v)
+}
+func (b *PrefixedBucket) Delete(k string) error {
+	return b.bucket.Delete(b.key(k))
+}
+func (b *PrefixedBucket) Remove(k string, cas uint64) (casOut uint64, err error) {
+	return b.bucket.Remove(b.key(k), cas)
+}
+func (b *PrefixedBucket) Write(k string, flags int, exp uint32, v interface{}, opt sgbucket.WriteOptions) error {
+	return b.bucket.Write(b.key(k), flags, exp, v, opt)
+}
+func (b *PrefixedBucket) WriteCas(k string, flags int, exp uint32, cas uint64, v interface{}, opt sgbucket.WriteOptions) (uint64, error) {
+	return b.bucket.WriteCas(b.key(k), flags, exp, cas, v, opt)
+}
+func (b *PrefixedBucket) Update(k string, exp uint32, callback sgbucket.UpdateFunc) (casOut uint64, err error) {
+	return b.bucket.Update(b.key(k), exp, callback)
+}
+func (b *PrefixedBucket) WriteUpdate(k string, exp uint32, callback sgbucket.WriteUpdateFunc) (casOut uint64, err error) {
+	return b.bucket.WriteUpdate(b.key(k), exp, callback)
+}
+func (b *PrefixedBucket) SetBulk(entries []*sgbucket.BulkSetEntry) (err error) {
+	prefixedEntries := make([]*sgbucket.BulkSetEntry, len(entries))
+	for i, entry := range entries {
+		prefixedEntry := *entry
+		prefixedEntry.Key = b.key(entry.Key)
+		prefixedEntries[i] = &prefixedEntry
+	}
+	err = b.bucket.SetBulk(prefixedEntries)
+
+	// Copy the results back to the caller's entries
+	for i, entry := range entries {
+		entry.Cas = prefixedEntries[i].Cas
+		entry.Error = prefixedEntries[i].Error
+	}
+	return err
+}
+func (b *PrefixedBucket) Incr(k string, amt, def uint64, exp uint32) (uint64, error) {
+	return b.bucket.Incr(b.key(k), amt, def, exp)
+}
+
+func (b *PrefixedBucket) WriteCasWithXattr(k string, xattr string, exp uint32, cas uint64, v interface{}, xv interface{}) (casOut uint64, err error) {
+	return b.bucket.WriteCasWithXattr(b.key(k), xattr, exp, cas, v, xv)
+}
+func (b *PrefixedBucket) WriteUpdateWithXattr(k string, xattr string, exp uint32, previous *sgbucket.BucketDocument, callback sgbucket.WriteUpdateWithXattrFunc) (casOut uint64, err error) {
+	return b.bucket.WriteUpdateWithXattr(b.key(k), xattr, exp, previous, callback)
+}
+func (b *PrefixedBucket) GetWithXattr(k string, xattr string, rv interface{}, xv interface{}) (cas uint64, err error) {
+	return b.bucket.GetWithXattr(b.key(k), xattr, rv, xv)
+}
+func (b *PrefixedBucket) DeleteWithXattr(k string, xattr string) error {
+	return b.bucket.DeleteWithXattr(b.key(k), xattr)
+}
+
+// Returns the map function wrapped to only index the namespace's docs.
+func (b *PrefixedBucket) wrapMapFn(mapFn string) string {
+	return fmt.Sprintf(kPrefixedMapFnHeaderFormat, b.quotedPrefix(), len(b.prefix)) + mapFn + kPrefixedMapFnFooter
+}
+
+// Returns the original map function of a wrapped one.
+func (b *PrefixedBucket) unwrapMapFn(mapFn string) string {
+	header := fmt.Sprintf(kPrefixedMapFnHeaderFormat, b.quotedPrefix(), len(b.prefix))
+	if strings.HasPrefix(mapFn, header) && strings.HasSuffix(mapFn, kPrefixedMapFnFooter) {
+		return mapFn[len(header) : len(mapFn)-len(kPrefixedMapFnFooter)]
+	}
+	return mapFn
+}
+
+// Returns the prefix as a JavaScript string literal.
+func (b *PrefixedBucket) quotedPrefix() string {
+	quoted, _ := json.Marshal(b.prefix)
+	return string(quoted)
+}
+
+func (b *PrefixedBucket) GetDDoc(docname string, value interface{}) error {
+	var ddoc sgbucket.DesignDoc
+	if err := b.bucket.GetDDoc(b.key(docname), &ddoc); err != nil {
+		return err
+	}
+	views := make(sgbucket.ViewMap, len(ddoc.Views))
+	for name, view := range ddoc.Views {
+		view.Map = b.unwrapMapFn(view.Map)
+		views[name] = view
+	}
+	ddoc.Views = views
+
+	ddocBytes, err := json.Marshal(ddoc)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(ddocBytes, value)
+}
+func (b *PrefixedBucket) PutDDoc(docname string, value interface{}) error {
+	ddoc, ok := value.(sgbucket.DesignDoc)
+	if !ok {
+		ddocBytes, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(ddocBytes, &ddoc); err != nil {
+			return err
+		}
+	}
+	views := make(sgbucket.ViewMap, len(ddoc.Views))
+	for name, view := range ddoc.Views {
+		view.Map = b.wrapMapFn(view.Map)
+		views[name] = view
+	}
+	ddoc.Views = views
+	return b.bucket.PutDDoc(b.key(docname), ddoc)
+}
+func (b *PrefixedBucket) DeleteDDoc(docname string) error {
+	return b.bucket.DeleteDDoc(b.key(docname))
+}
+
+// Removes the prefix from the doc ID of a view row, as the map function emitted it with the unprefixed ID.
+func (b *PrefixedBucket) unprefixViewRowBytes(rowBytes []byte) []byte {
+	var row map[string]json.RawMessage
+	if err := json.Unmarshal(rowBytes, &row); err != nil {
+		return rowBytes
+	}
+	var id string
+	if err := json.Unmarshal(row["id"], &id); err != nil {
+		return rowBytes
+	}
+	unprefixedID, ok := b.unprefixedKey(id)
+	if !ok {
+		return rowBytes
+	}
+	row["id"], _ = json.Marshal(unprefixedID)
+	unprefixedBytes, err := json.Marshal(row)
+	if err != nil {
+		return rowBytes
+	}
+	return unprefixedBytes
+}
+
+func (b *PrefixedBucket) View(ddoc, name string, params map[string]interface{}) (sgbucket.ViewResult, error) {
+	result, err := b.bucket.View(b.key(ddoc), name, params)
+	for _, row := range result.Rows {
+		if unprefixedID, ok := b.unprefixedKey(row.ID); ok {
+			row.ID = unprefixedID
+		}
+	}
+	return result, err
+}
+func (b *PrefixedBucket) ViewCustom(ddoc, name string, params map[string]interface{}, vres interface{}) error {
+	viewResponse := struct {
+		TotalRows int                  `json:"total_rows,omitempty"`
+		Rows      []json.RawMessage    `json:"rows,omitempty"`
+		Errors    []sgbucket.ViewError `json:"errors,omitempty"`
+	}{}
+	viewErr := b.bucket.ViewCustom(b.key(ddoc), name, params, &viewResponse)
+	if viewErr != nil && viewErr != ErrPartialViewErrors {
+		return viewErr
+	}
+	for i, row := range viewResponse.Rows {
+		viewResponse.Rows[i] = b.unprefixViewRowBytes(row)
+	}
+
+	viewResponseBytes, err := json.Marshal(viewResponse)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(viewResponseBytes, vres); err != nil {
+		return err
+	}
+	return viewErr
+}
+func (b *PrefixedBucket) ViewQuery(ddoc, name string, params map[string]interface{}) (sgbucket.QueryResultIterator, error) {
+	iterator, err := b.bucket.ViewQuery(b.key(ddoc), name, params)
+	if iterator == nil {
+		return nil, err
+	}
+	return &prefixedViewIterator{iterator: iterator, bucket: b}, err
+}
+
+// Iterates over view query results, removing the key prefix from each row's doc ID.
+type prefixedViewIterator struct {
+	iterator sgbucket.QueryResultIterator
+	bucket   *PrefixedBucket
+}
+
+func (i *prefixedViewIterator) One(valuePtr interface{}) error {
+	if !i.Next(valuePtr) {
+		if err := i.Close(); err != nil {
+			return err
+		}
+		return ErrNotFound
+	}
+	return i.Close()
+}
+
+func (i *prefixedViewIterator) Next(valuePtr interface{}) bool {
+	rowBytes := i.NextBytes()
+	if rowBytes == nil {
+		return false
+	}
+	return json.Unmarshal(rowBytes, valuePtr) == nil
+}
+
+func (i *prefixedViewIterator) NextBytes() []byte {
+	rowBytes := i.iterator.NextBytes()
+	if len(rowBytes) == 0 {
+		return nil
+	}
+	return i.bucket.unprefixViewRowBytes(rowBytes)
+}
+
+func (i *prefixedViewIterator) Close() error {
+	return i.iterator.Close()
+}
+
+func (b *PrefixedBucket) GetMaxVbno() (uint16, error) {
+	return b.bucket.GetMaxVbno()
+}
+
+func (b *PrefixedBucket) Refresh() error {
+	return b.bucket.Refresh()
+}
+
+// Removes the prefix from the key of a feed event.  Returns false if the event is outside the namespace.
+func (b *PrefixedBucket) unprefixFeedEvent(event *sgbucket.FeedEvent) bool {
+	unprefixed, ok := b.unprefixedKey(string(event.Key))
+	if !ok {
+		return false
+	}
+	event.Key = []byte(unprefixed)
+	return true
+}
+
+func (b *PrefixedBucket) StartTapFeed(args sgbucket.FeedArguments) (sgbucket.MutationFeed, error) {
+	tapFeed, err := b.bucket.StartTapFeed(args)
+	if err != nil {
+		return tapFeed, err
+	}
+	prefixedFeed := &wrappedTapFeedImpl{
+		channel:        make(chan sgbucket.FeedEvent, 10),
+		wrappedTapFeed: tapFeed,
+	}
+	go func() {
+		defer close(prefixedFeed.channel)
+		for event := range tapFeed.Events() {
+			if b.unprefixFeedEvent(&event) {
+				prefixedFeed.channel <- event
+			}
+		}
+	}()
+	return prefixedFeed, nil
+}
+
+func (b *PrefixedBucket) StartDCPFeed(args sgbucket.FeedArguments, callback sgbucket.FeedEventCallbackFunc) error {
+	return b.bucket.StartDCPFeed(args, func(event sgbucket.FeedEvent) bool {
+		if !b.unprefixFeedEvent(&event) {
+			return false
+		}
+		return callback(event)
+	})
+}
+
+func (b *PrefixedBucket) Close() {
+	b.bucket.Close()
+}
+func (b *PrefixedBucket) Dump() {
+	b.bucket.Dump()
+}
+func (b *PrefixedBucket) VBHash(docID string) uint32 {
+	return b.bucket.VBHash(b.key(docID))
+}
+
+func (b *PrefixedBucket) CouchbaseServerVersion() (major uint64, minor uint64, micro string, err error) {
+	return b.bucket.CouchbaseServerVersion()
+}
+
+func (b *PrefixedBucket) UUID() (string, error) {
+	return b.bucket.UUID()
+}
+
+func (b *PrefixedBucket) CloseAndDelete() error {
+	if bucket, ok := b.bucket.(sgbucket.DeleteableBucket); ok {
+		return bucket.CloseAndDelete()
+	}
+	return nil
+}
+
+func (b *PrefixedBucket) GetStatsVbSeqno(maxVbno uint16, useAbsHighSeqNo bool) (uuids map[uint16]uint64, highSeqnos map[uint16]uint64, seqErr error) {
+	return b.bucket.GetStatsVbSeqno(maxVbno, useAbsHighSeqNo)
+}
@@ -0,0 +1,73 @@
+package base
+
+import (
+	"testing"
+
+	sgbucket "github.com/couchbase/sg-bucket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrefixedBucket(t *testing.T) {
+	bucket, err := GetBucket(BucketSpec{Server: "walrus:", BucketName: "prefixed"}, nil)
+	assert.NoError(t, err)
+	defer bucket.Close()
+
+	tenant1 := NewPrefixedBucket(bucket, "tenant1:")
+	tenant2 := NewPrefixedBucket(bucket, "tenant2:")
+
+	assert.NoError(t, tenant1.SetRaw("doc1", 0, []byte(`{"tenant":1}`)))
+	assert.NoError(t, tenant2.SetRaw("doc1", 0, []byte(`{"tenant":2}`)))
+	assert.NoError(t, tenant2.SetRaw("doc2", 0, []byte(`{"tenant":2}`)))
+
+	// Keys are namespaced in the underlying bucket
+	value, _, err := bucket.GetRaw("tenant1:doc1")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"tenant":1}`, string(value))
+	_, _, err = tenant1.GetRaw("doc2")
+	assert.True(t, IsDocNotFoundError(err))
+
+	results, err := tenant2.GetBulkRaw([]string{"doc1", "doc2"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]byte{"doc1": []byte(`{"tenant":2}`), "doc2": []byte(`{"tenant":2}`)}, results)
+
+	// Views only index the namespace's docs, and see their unprefixed IDs
+	mapFn := `function(doc, meta) {emit(meta.id, doc.tenant);}`
+	ddoc := sgbucket.DesignDoc{Views: sgbucket.ViewMap{"ids": sgbucket.ViewDef{Map: mapFn}}}
+	assert.NoError(t, tenant1.PutDDoc("ddoc", ddoc))
+	assert.NoError(t, tenant2.PutDDoc("ddoc", ddoc))
+	assert.Equal(t, mapFn, ddoc.Views["ids"].Map)
+
+	var storedDDoc sgbucket.DesignDoc
+	assert.NoError(t, tenant2.GetDDoc("ddoc", &storedDDoc))
+	assert.Equal(t, mapFn, storedDDoc.Views["ids"].Map)
+
+	result, err := tenant2.View("ddoc", "ids", map[string]interface{}{"stale": false})
+	assert.NoError(t, err)
+	if assert.Len(t, result.Rows, 2) {
+		assert.Equal(t, "doc1", result.Rows[0].ID)
+		assert.Equal(t, "doc1", result.Rows[0].Key)
+		assert.Equal(t, "doc2", result.Rows[1].ID)
+	}
+
+	iterator, err := tenant1.ViewQuery("ddoc", "ids", map[string]interface{}{"stale": false})
+	assert.NoError(t, err)
+	var row struct {
+		ID    string  `json:"id"`
+		Value float64 `json:"value"`
+	}
+	assert.True(t, iterator.Next(&row))
+	assert.Equal(t, "doc1", row.ID)
+	assert.Equal(t, float64(1), row.Value)
+	assert.False(t, iterator.Next(&row))
+	assert.NoError(t, iterator.Close())
+
+	// The feed only includes the namespace's docs, with unprefixed keys
+	feed, err := tenant2.StartTapFeed(sgbucket.FeedArguments{Backfill: uint64(sgbucket.FeedNoBackfill)})
+	assert.NoError(t, err)
+	defer feed.Close()
+	assert.NoError(t, tenant1.SetRaw("doc3", 0, []byte(`{"tenant":1}`)))
+	assert.NoError(t, tenant2.SetRaw("doc3", 0, []byte(`{"tenant":2}`)))
+	event := <-feed.Events()
+	assert.Equal(t, "doc3", string(event.Key))
+	assert.Equal(t, `{"tenant":2}`, string(event.Value))
+}
@@ -1,7 +1,14 @@
 package base
 
 import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"regexp"
+	"strings"
 
 	"github.com/couchbase/clog"
 )
@@ -22,10 +29,7 @@ type UserData string
 
 // Redact tags the string with UserData tags for post-processing.
 func (ud UserData) Redact() string {
-	if !RedactUserData {
-		return string(ud)
-	}
-	return clog.Tag(clog.UserData, string(ud)).(string)
+	return redactUserData(UserDataOther, string(ud))
 }
 
 // Compile-time interface check.
@@ -43,3 +47,202 @@ func UD(i interface{}) UserData {
 		return UserData(fmt.Sprintf("%+v", v))
 	}
 }
+
+// UserDataClass identifies the kind of user data being logged, so that each class can be redacted differently.
+type UserDataClass int
+
+const (
+	UserDataOther    UserDataClass = iota // User data not covered by a more specific class
+	UserDataUsername                      // User and role names
+	UserDataDocID                         // Document IDs
+	UserDataChannel                       // Channel names
+	UserDataDocBody                       // Document bodies and property values
+	numUserDataClasses
+)
+
+var userDataClassNames = []string{
+	UserDataOther:    "other",
+	UserDataUsername: "username",
+	UserDataDocID:    "doc_id",
+	UserDataChannel:  "channel",
+	UserDataDocBody:  "doc_body",
+}
+
+// String returns the config name of the user data class.
+func (c UserDataClass) String() string {
+	if c < 0 || c >= numUserDataClasses {
+		return fmt.Sprintf("UserDataClass(%d)", c)
+	}
+	return userDataClassNames[c]
+}
+
+// MarshalText marshals the UserDataClass to text.
+func (c UserDataClass) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// UnmarshalText unmarshals text to a UserDataClass.
+func (c *UserDataClass) UnmarshalText(text []byte) error {
+	for class, name := range userDataClassNames {
+		if strings.ToLower(string(text)) == name {
+			*c = UserDataClass(class)
+			return nil
+		}
+	}
+	return fmt.Errorf("unrecognized user data class: %q", text)
+}
+
+// UserDataRedactionMode is how a class of user data is written to the logs.
+type UserDataRedactionMode int
+
+const (
+	UserDataRedactDefault UserDataRedactionMode = iota // Tag if RedactUserData is set, based on the redaction level
+	UserDataRedactNone                                 // Log as-is
+	UserDataRedactTag                                  // Wrap in <ud> tags, for removal by sgcollect_info or log export
+	UserDataRedactHash                                 // Replace with a hash, so the value never appears in the logs
+)
+
+// String returns a lower-case ASCII representation of the redaction mode.
+func (m UserDataRedactionMode) String() string {
+	switch m {
+	case UserDataRedactDefault:
+		return "default"
+	case UserDataRedactNone:
+		return "none"
+	case UserDataRedactTag:
+		return "tag"
+	case UserDataRedactHash:
+		return "hash"
+	default:
+		return fmt.Sprintf("UserDataRedactionMode(%d)", m)
+	}
+}
+
+// MarshalText marshals the UserDataRedactionMode to text.
+func (m UserDataRedactionMode) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalText unmarshals text to a UserDataRedactionMode.
+func (m *UserDataRedactionMode) UnmarshalText(text []byte) error {
+	switch strings.ToLower(string(text)) {
+	case "default":
+		*m = UserDataRedactDefault
+	case "none":
+		*m = UserDataRedactNone
+	case "tag":
+		*m = UserDataRedactTag
+	case "hash":
+		*m = UserDataRedactHash
+	default:
+		return fmt.Errorf("unrecognized user data redaction mode: %q", text)
+	}
+	return nil
+}
+
+// UserDataRedaction maps classes of user data to how they should be redacted.
+type UserDataRedaction map[UserDataClass]UserDataRedactionMode
+
+// UserDataHashSalt is the salt used when hashing user data with UserDataRedactHash.
+var UserDataHashSalt = ""
+
+// userDataRedactionModes holds the per-class redaction mode overrides, indexed by UserDataClass.
+var userDataRedactionModes [numUserDataClasses]UserDataRedactionMode
+
+// SetUserDataRedaction overrides how the given classes of user data are redacted.  Classes not present are reset
+// to UserDataRedactDefault.  If salt is empty, a random salt is used, so hashed values can only be correlated
+// within the lifetime of the process.
+func SetUserDataRedaction(modes UserDataRedaction, salt string) {
+	for class := range userDataRedactionModes {
+		userDataRedactionModes[class] = modes[UserDataClass(class)]
+	}
+	if salt == "" {
+		salt = CreateUUID()
+	}
+	UserDataHashSalt = salt
+}
+
+func redactUserData(class UserDataClass, data string) string {
+	mode := userDataRedactionModes[class]
+	if mode == UserDataRedactDefault {
+		if RedactUserData {
+			mode = UserDataRedactTag
+		} else {
+			mode = UserDataRedactNone
+		}
+	}
+
+	switch mode {
+	case UserDataRedactTag:
+		return clog.Tag(clog.UserData, data).(string)
+	case UserDataRedactHash:
+		return hashUserData(UserDataHashSalt, data)
+	default:
+		return data
+	}
+}
+
+// hashUserData returns the salted hash used in place of user data, matching sgcollect_info's redaction.
+func hashUserData(salt, data string) string {
+	sum := sha1.Sum([]byte(salt + data))
+	return hex.EncodeToString(sum[:])
+}
+
+// classifiedUserData is user data of a specific class, which implements the Redactor interface.
+type classifiedUserData struct {
+	class UserDataClass
+	data  string
+}
+
+func (c classifiedUserData) Redact() string {
+	return redactUserData(c.class, c.data)
+}
+
+// String returns the unredacted value, for use outside of logging.
+func (c classifiedUserData) String() string {
+	return c.data
+}
+
+// UDUsername returns a Redactor for a user or role name.
+func UDUsername(i interface{}) Redactor {
+	return classifiedUserData{class: UserDataUsername, data: string(UD(i))}
+}
+
+// UDDocID returns a Redactor for a document ID.
+func UDDocID(i interface{}) Redactor {
+	return classifiedUserData{class: UserDataDocID, data: string(UD(i))}
+}
+
+// UDChannel returns a Redactor for a channel name, or set of channel names.
+func UDChannel(i interface{}) Redactor {
+	return classifiedUserData{class: UserDataChannel, data: string(UD(i))}
+}
+
+// UDBody returns a Redactor for a document body or property value.
+func UDBody(i interface{}) Redactor {
+	return classifiedUserData{class: UserDataDocBody, data: string(UD(i))}
+}
+
+var taggedUserDataRegexp = regexp.MustCompile(`<ud>(.*?)</ud>`)
+
+// RedactTaggedUserData copies log output from r to w, replacing the contents of every <ud> tag with its
+// salted hash, in the same way as sgcollect_info's log redaction.  The salt must not be empty, as an
+// unsalted hash of a low-entropy value (e.g. a username) is easily reversed.
+func RedactTaggedUserData(r io.Reader, w io.Writer, salt string) error {
+	if salt == "" {
+		return errors.New("a redaction salt is required")
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := taggedUserDataRegexp.ReplaceAllStringFunc(scanner.Text(), func(tagged string) string {
+			data := taggedUserDataRegexp.FindStringSubmatch(tagged)[1]
+			return "<ud>" + hashUserData(salt, data) + "</ud>"
+		})
+		if _, err := io.WriteString(w, line+"\n"); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
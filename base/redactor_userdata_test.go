@@ -1,7 +1,10 @@
 package base
 
 import (
+	"bytes"
+	"encoding/json"
 	"math/big"
+	"strings"
 	"testing"
 
 	goassert "github.com/couchbaselabs/go.assert"
@@ -61,3 +64,58 @@ func BenchmarkUserDataRedact(b *testing.B) {
 		}
 	})
 }
+
+func TestUserDataClassRedaction(t *testing.T) {
+	defer SetUserDataRedaction(nil, "")
+	defer func() { RedactUserData = false }()
+
+	// By default every class follows RedactUserData
+	RedactUserData = true
+	SetUserDataRedaction(nil, "salt")
+	goassert.Equals(t, UDDocID("doc1").Redact(), userDataPrefix+"doc1"+userDataSuffix)
+	goassert.Equals(t, UDChannel("ABC").Redact(), userDataPrefix+"ABC"+userDataSuffix)
+
+	SetUserDataRedaction(UserDataRedaction{
+		UserDataDocID:    UserDataRedactNone,
+		UserDataUsername: UserDataRedactHash,
+	}, "salt")
+	goassert.Equals(t, UDDocID("doc1").Redact(), "doc1")
+	goassert.Equals(t, UDUsername("alice").Redact(), hashUserData("salt", "alice"))
+	goassert.NotEquals(t, UDUsername("alice").Redact(), hashUserData("othersalt", "alice"))
+	goassert.Equals(t, UDChannel("ABC").Redact(), userDataPrefix+"ABC"+userDataSuffix)
+	goassert.Equals(t, UD("other").Redact(), userDataPrefix+"other"+userDataSuffix)
+
+	// Overrides apply regardless of RedactUserData
+	RedactUserData = false
+	SetUserDataRedaction(UserDataRedaction{UserDataDocBody: UserDataRedactTag}, "salt")
+	goassert.Equals(t, UDBody(`{"foo":"bar"}`).Redact(), userDataPrefix+`{"foo":"bar"}`+userDataSuffix)
+	goassert.Equals(t, UDChannel("ABC").Redact(), "ABC")
+}
+
+func TestUserDataRedactionConfig(t *testing.T) {
+	var config struct {
+		RedactClasses UserDataRedaction `json:"redact_classes"`
+	}
+	err := json.Unmarshal([]byte(`{"redact_classes": {"doc_id": "none", "username": "hash", "channel": "tag"}}`), &config)
+	goassert.Equals(t, err, nil)
+	goassert.Equals(t, config.RedactClasses[UserDataDocID], UserDataRedactNone)
+	goassert.Equals(t, config.RedactClasses[UserDataUsername], UserDataRedactHash)
+	goassert.Equals(t, config.RedactClasses[UserDataChannel], UserDataRedactTag)
+
+	err = json.Unmarshal([]byte(`{"redact_classes": {"passwords": "none"}}`), &config)
+	goassert.NotEquals(t, err, nil)
+	err = json.Unmarshal([]byte(`{"redact_classes": {"doc_id": "encrypt"}}`), &config)
+	goassert.NotEquals(t, err, nil)
+}
+
+func TestRedactTaggedUserData(t *testing.T) {
+	input := "2019-01-01T00:00:00.000Z [INF] CRUD: Doc <ud>doc1</ud> in channels <ud>ABC</ud>\nno user data\n"
+	var output bytes.Buffer
+	goassert.Equals(t, RedactTaggedUserData(strings.NewReader(input), &output, "salt"), nil)
+
+	expected := "2019-01-01T00:00:00.000Z [INF] CRUD: Doc <ud>" + hashUserData("salt", "doc1") + "</ud> in channels <ud>" +
+		hashUserData("salt", "ABC") + "</ud>\nno user data\n"
+	goassert.Equals(t, output.String(), expected)
+
+	goassert.NotEquals(t, RedactTaggedUserData(strings.NewReader(input), &output, ""), nil)
+}
@@ -0,0 +1,11 @@
+// +build !windows
+
+package base
+
+import (
+	"os"
+	"syscall"
+)
+
+// The signal that makes Sync Gateway restart its listeners, handing their sockets to a new process.
+var ListenerRestartSignal os.Signal = syscall.SIGUSR2
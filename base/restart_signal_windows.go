@@ -0,0 +1,6 @@
+package base
+
+import "os"
+
+// Restarting listeners isn't supported on Windows, as listening sockets can't be passed to a child process.
+var ListenerRestartSignal os.Signal
@@ -0,0 +1,173 @@
+package base
+
+import (
+	"expvar"
+	"fmt"
+	"time"
+)
+
+// Classes of transient bucket errors that a RetryPolicy can retry.
+type RetryErrorClass string
+
+const (
+	RetryErrorTimeout  RetryErrorClass = "timeout"   // The operation timed out
+	RetryErrorOverload RetryErrorClass = "overload"  // The client's operation queue is full
+	RetryErrorBusy     RetryErrorClass = "busy"      // The server is too busy to handle the operation
+	RetryErrorTempFail RetryErrorClass = "temp_fail" // The server temporarily can't handle the operation
+)
+
+var allRetryErrorClasses = []RetryErrorClass{RetryErrorTimeout, RetryErrorOverload, RetryErrorBusy, RetryErrorTempFail}
+
+// How the time to sleep between retries grows with each attempt.
+type RetryBackoff string
+
+const (
+	RetryBackoffDoubling RetryBackoff = "doubling" // Sleep time doubles after each retry
+	RetryBackoffLinear   RetryBackoff = "linear"   // Sleep time grows by the initial sleep time after each retry
+	RetryBackoffConstant RetryBackoff = "constant" // Sleep time stays at the initial sleep time
+)
+
+// A RetryPolicy determines which failed bucket operations are retried, and how often.  Zero-valued fields take the
+// value from DefaultRetryPolicy.
+type RetryPolicy struct {
+	MaxAttempts    int               `json:"max_attempts,omitempty"`     // Max # of retries after the initial attempt
+	InitialSleepMs int               `json:"initial_sleep_ms,omitempty"` // Time to sleep before the first retry
+	MaxSleepMs     int               `json:"max_sleep_ms,omitempty"`     // Upper bound on the time to sleep before each retry, if non-zero
+	Backoff        RetryBackoff      `json:"backoff,omitempty"`          // How the sleep time grows between retries
+	RetryOn        []RetryErrorClass `json:"retry_on,omitempty"`         // Classes of error that are retried.  All if unset, none if empty
+}
+
+// DefaultRetryPolicy returns the policy used for bucket operations if none is configured.
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:    10,
+		InitialSleepMs: 5,
+		Backoff:        RetryBackoffDoubling,
+		RetryOn:        allRetryErrorClasses,
+	}
+}
+
+// Validate returns an error if the policy has invalid values.
+func (p *RetryPolicy) Validate() error {
+	if p.MaxAttempts < 0 || p.InitialSleepMs < 0 || p.MaxSleepMs < 0 {
+		return fmt.Errorf("retry policy attempts and sleep times must not be negative")
+	}
+	switch p.Backoff {
+	case "", RetryBackoffDoubling, RetryBackoffLinear, RetryBackoffConstant:
+	default:
+		return fmt.Errorf("unknown retry backoff %q", p.Backoff)
+	}
+	for _, class := range p.RetryOn {
+		if !containsRetryErrorClass(allRetryErrorClasses, class) {
+			return fmt.Errorf("unknown retry error class %q", class)
+		}
+	}
+	return nil
+}
+
+// WithDefaults returns a copy of the policy with any unset fields taken from DefaultRetryPolicy.  Returns
+// DefaultRetryPolicy if the policy is nil.
+func (p *RetryPolicy) WithDefaults() *RetryPolicy {
+	policy := DefaultRetryPolicy()
+	if p == nil {
+		return policy
+	}
+	if p.MaxAttempts != 0 {
+		policy.MaxAttempts = p.MaxAttempts
+	}
+	if p.InitialSleepMs != 0 {
+		policy.InitialSleepMs = p.InitialSleepMs
+	}
+	if p.Backoff != "" {
+		policy.Backoff = p.Backoff
+	}
+	if p.RetryOn != nil {
+		policy.RetryOn = p.RetryOn
+	}
+	policy.MaxSleepMs = p.MaxSleepMs
+	return policy
+}
+
+func containsRetryErrorClass(classes []RetryErrorClass, class RetryErrorClass) bool {
+	for _, c := range classes {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// Retries returns true if errors of the given class should be retried.
+func (p *RetryPolicy) Retries(class RetryErrorClass) bool {
+	return containsRetryErrorClass(p.RetryOn, class)
+}
+
+// Sleeper returns a RetrySleeper following the policy's attempts and backoff.
+func (p *RetryPolicy) Sleeper() RetrySleeper {
+	sleepMs := p.InitialSleepMs
+	return func(numAttempts int) (bool, int) {
+		if numAttempts > p.MaxAttempts {
+			return false, -1
+		}
+		if numAttempts > 1 {
+			switch p.Backoff {
+			case RetryBackoffDoubling:
+				sleepMs *= 2
+			case RetryBackoffLinear:
+				sleepMs += p.InitialSleepMs
+			}
+		}
+		if p.MaxSleepMs > 0 && sleepMs > p.MaxSleepMs {
+			sleepMs = p.MaxSleepMs
+		}
+		return true, sleepMs
+	}
+}
+
+// Wraps a sleeper to count retries and exhausted retry loops in the bucket retry stats.
+func statsRetrySleeper(sleeper RetrySleeper) RetrySleeper {
+	return func(numAttempts int) (bool, int) {
+		shouldContinue, sleepMs := sleeper(numAttempts)
+		if shouldContinue {
+			StatsBucketRetries().Add(StatKeyBucketOpRetryCount, 1)
+		} else {
+			StatsBucketRetries().Add(StatKeyBucketOpRetryExhaustedCount, 1)
+		}
+		return shouldContinue, sleepMs
+	}
+}
+
+// RetryLoop runs the worker in a RetryLoop following the policy, recording retries in the bucket retry stats.
+func (p *RetryPolicy) RetryLoop(description string, worker RetryWorker) (error, interface{}) {
+	return RetryLoop(description, worker, statsRetrySleeper(p.Sleeper()))
+}
+
+// Retrier returns a function for loops that can't be expressed as a RetryWorker, such as CAS loops, to call after
+// each retryable failure.  The function sleeps as per the policy and returns true, or returns false once the
+// policy's attempts are exhausted.
+func (p *RetryPolicy) Retrier(description string) func() bool {
+	sleeper := statsRetrySleeper(p.Sleeper())
+	numAttempts := 0
+	return func() bool {
+		numAttempts++
+		shouldContinue, sleepMs := sleeper(numAttempts)
+		if !shouldContinue {
+			Warnf(KeyAll, "Retries for %v giving up after %v attempts", description, numAttempts)
+			return false
+		}
+		Debugf(KeyAll, "Retrying %v after %v ms.", description, sleepMs)
+		time.Sleep(time.Millisecond * time.Duration(sleepMs))
+		return true
+	}
+}
+
+func StatsBucketRetries() *expvar.Map {
+	return GlobalStats.Get(StatsGroupKeyBucketRetries).(*expvar.Map)
+}
+
+func NewStatsBucketRetries() *expvar.Map {
+	stats := new(expvar.Map).Init()
+	stats.Set(StatKeyBucketOpRetryCount, ExpvarIntVal(0))
+	stats.Set(StatKeyBucketOpRetryExhaustedCount, ExpvarIntVal(0))
+	return stats
+}
@@ -0,0 +1,74 @@
+package base
+
+import (
+	"errors"
+	"expvar"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Returns the sleep times of the given sleeper until it gives up.
+func sleeperTimes(sleeper RetrySleeper) []int {
+	var times []int
+	for numAttempts := 1; ; numAttempts++ {
+		shouldContinue, sleepMs := sleeper(numAttempts)
+		if !shouldContinue {
+			return times
+		}
+		times = append(times, sleepMs)
+	}
+}
+
+func TestRetryPolicySleeper(t *testing.T) {
+	policy := (&RetryPolicy{MaxAttempts: 5}).WithDefaults()
+	assert.Equal(t, []int{5, 10, 20, 40, 80}, sleeperTimes(policy.Sleeper()))
+
+	policy = (&RetryPolicy{MaxAttempts: 5, InitialSleepMs: 10, MaxSleepMs: 25, Backoff: RetryBackoffLinear}).WithDefaults()
+	assert.Equal(t, []int{10, 20, 25, 25, 25}, sleeperTimes(policy.Sleeper()))
+
+	policy = (&RetryPolicy{MaxAttempts: 3, Backoff: RetryBackoffConstant}).WithDefaults()
+	assert.Equal(t, []int{5, 5, 5}, sleeperTimes(policy.Sleeper()))
+}
+
+func TestRetryPolicyDefaults(t *testing.T) {
+	var nilPolicy *RetryPolicy
+	assert.Equal(t, DefaultRetryPolicy(), nilPolicy.WithDefaults())
+
+	policy := (&RetryPolicy{RetryOn: []RetryErrorClass{RetryErrorTimeout}}).WithDefaults()
+	assert.Equal(t, 10, policy.MaxAttempts)
+	assert.True(t, policy.Retries(RetryErrorTimeout))
+	assert.False(t, policy.Retries(RetryErrorTempFail))
+
+	// An empty list of error classes disables retries
+	policy = (&RetryPolicy{RetryOn: []RetryErrorClass{}}).WithDefaults()
+	assert.False(t, policy.Retries(RetryErrorTimeout))
+}
+
+func TestRetryPolicyValidate(t *testing.T) {
+	assert.NoError(t, (&RetryPolicy{}).Validate())
+	assert.NoError(t, (&RetryPolicy{MaxAttempts: 3, Backoff: RetryBackoffLinear, RetryOn: []RetryErrorClass{RetryErrorBusy}}).Validate())
+	assert.Error(t, (&RetryPolicy{MaxAttempts: -1}).Validate())
+	assert.Error(t, (&RetryPolicy{Backoff: "exponential"}).Validate())
+	assert.Error(t, (&RetryPolicy{RetryOn: []RetryErrorClass{"not_found"}}).Validate())
+}
+
+func TestRetryPolicyStats(t *testing.T) {
+	retries := StatsBucketRetries().Get(StatKeyBucketOpRetryCount).(*expvar.Int).Value()
+	exhausted := StatsBucketRetries().Get(StatKeyBucketOpRetryExhaustedCount).(*expvar.Int).Value()
+
+	policy := (&RetryPolicy{MaxAttempts: 2, InitialSleepMs: 1}).WithDefaults()
+	worker := func() (bool, error, interface{}) {
+		return true, errors.New("transient"), nil
+	}
+	err, _ := policy.RetryLoop("TestRetryPolicyStats", worker)
+	assert.Error(t, err)
+
+	retry := policy.Retrier("TestRetryPolicyStats")
+	assert.True(t, retry())
+	assert.True(t, retry())
+	assert.False(t, retry())
+
+	assert.Equal(t, retries+4, StatsBucketRetries().Get(StatKeyBucketOpRetryCount).(*expvar.Int).Value())
+	assert.Equal(t, exhausted+2, StatsBucketRetries().Get(StatKeyBucketOpRetryExhaustedCount).(*expvar.Int).Value())
+}
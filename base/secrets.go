@@ -0,0 +1,256 @@
+package base
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// URI schemes of secret references that can be used in place of passwords, OIDC client secrets and TLS keys in the
+// config.  References are resolved when the secret is needed, so the secret itself never has to be stored in the
+// config file.
+const (
+	SecretSchemeFile  = "file"  // file:///path/to/secret - contents of a local file, with any trailing newline removed
+	SecretSchemeVault = "vault" // vault://<mount>/<path>#<field> - field of a HashiCorp Vault secret (KV version 1 or 2)
+	SecretSchemeAWSSM = "awssm" // awssm://<region>/<secret-id>[#<field>] - AWS Secrets Manager secret, or a field of a JSON secret
+)
+
+// How long requests to an external secret store may take.
+const kSecretStoreTimeout = 10 * time.Second
+
+// A SecretResolver fetches the secret identified by a reference URI.
+type SecretResolver interface {
+	ResolveSecret(ref *url.URL) (string, error)
+}
+
+var (
+	secretResolversLock sync.RWMutex
+	secretResolvers     = map[string]SecretResolver{
+		SecretSchemeFile:  FileSecretResolver{},
+		SecretSchemeVault: &VaultSecretResolver{},
+		SecretSchemeAWSSM: &AWSSecretsManagerResolver{},
+	}
+)
+
+// RegisterSecretResolver sets the resolver used for secret references with the given URI scheme, replacing any
+// existing resolver for that scheme.
+func RegisterSecretResolver(scheme string, resolver SecretResolver) {
+	secretResolversLock.Lock()
+	secretResolvers[scheme] = resolver
+	secretResolversLock.Unlock()
+}
+
+// Returns the resolver and parsed URI for the given value, or a nil resolver if it isn't a secret reference.
+func secretResolverFor(value string) (SecretResolver, *url.URL) {
+	i := strings.Index(value, "://")
+	if i <= 0 {
+		return nil, nil
+	}
+
+	secretResolversLock.RLock()
+	resolver := secretResolvers[value[:i]]
+	secretResolversLock.RUnlock()
+	if resolver == nil {
+		return nil, nil
+	}
+
+	ref, err := url.Parse(value)
+	if err != nil {
+		return nil, nil
+	}
+	return resolver, ref
+}
+
+// IsSecretRef returns true if the given config value is a reference to a secret with a registered scheme.
+func IsSecretRef(value string) bool {
+	resolver, _ := secretResolverFor(value)
+	return resolver != nil
+}
+
+// ResolveSecret returns the secret referenced by the given config value, or the value itself if it isn't a secret
+// reference.
+func ResolveSecret(value string) (string, error) {
+	resolver, ref := secretResolverFor(value)
+	if resolver == nil {
+		return value, nil
+	}
+	secret, err := resolver.ResolveSecret(ref)
+	if err != nil {
+		return "", RedactErrorf("Unable to resolve secret %s: %v", UD(redactedSecretRef(ref)), err)
+	}
+	return secret, nil
+}
+
+// Returns the reference with any userinfo removed, for logging.
+func redactedSecretRef(ref *url.URL) string {
+	redacted := *ref
+	redacted.User = nil
+	return redacted.String()
+}
+
+// Returns the named field of a JSON object, which must be a string.
+func secretField(fields map[string]interface{}, field string) (string, error) {
+	value, ok := fields[field]
+	if !ok {
+		return "", fmt.Errorf("secret has no field %q", field)
+	}
+	str, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("secret field %q is not a string", field)
+	}
+	return str, nil
+}
+
+// Makes a request to a secret store, unmarshalling the JSON response into v.
+func doSecretStoreRequest(client *http.Client, req *http.Request, v interface{}) error {
+	if client == nil {
+		client = &http.Client{Timeout: kSecretStoreTimeout}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned status %d", req.URL.Host, resp.StatusCode)
+	}
+	return json.Unmarshal(body, v)
+}
+
+// FileSecretResolver resolves file:///path references to the contents of the file.
+type FileSecretResolver struct{}
+
+func (FileSecretResolver) ResolveSecret(ref *url.URL) (string, error) {
+	data, err := ioutil.ReadFile(ref.Path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// VaultSecretResolver resolves vault://<mount>/<path>#<field> references by reading the secret from HashiCorp Vault.
+// The field may be omitted if the secret has a single field.
+type VaultSecretResolver struct {
+	Address    string       // Vault server address.  Defaults to $VAULT_ADDR
+	Token      string       // Vault token.  Defaults to $VAULT_TOKEN
+	HTTPClient *http.Client // Defaults to a client with a kSecretStoreTimeout timeout
+}
+
+func (r *VaultSecretResolver) ResolveSecret(ref *url.URL) (string, error) {
+	address, token := r.Address, r.Token
+	if address == "" {
+		address = os.Getenv("VAULT_ADDR")
+	}
+	if token == "" {
+		token = os.Getenv("VAULT_TOKEN")
+	}
+	if address == "" {
+		return "", fmt.Errorf("Vault address not set (VAULT_ADDR)")
+	}
+
+	req, err := http.NewRequest("GET", strings.TrimSuffix(address, "/")+"/v1/"+ref.Host+ref.Path, nil)
+	if err != nil {
+		return "", err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+
+	var response struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := doSecretStoreRequest(r.HTTPClient, req, &response); err != nil {
+		return "", err
+	}
+
+	// KV version 2 secrets nest the fields within a further data object, alongside their metadata
+	fields := response.Data
+	if nested, ok := fields["data"].(map[string]interface{}); ok {
+		if _, ok := fields["metadata"]; ok {
+			fields = nested
+		}
+	}
+
+	field := ref.Fragment
+	if field == "" {
+		if len(fields) != 1 {
+			return "", fmt.Errorf("secret has %d fields, so a #field must be specified", len(fields))
+		}
+		for name := range fields {
+			field = name
+		}
+	}
+	return secretField(fields, field)
+}
+
+// AWSSecretsManagerResolver resolves awssm://<region>/<secret-id>[#<field>] references by reading the secret
+// from AWS Secrets Manager.  If a field is given, the secret string must be a JSON object.  Credentials are read
+// from the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and (optionally) AWS_SESSION_TOKEN environment variables.
+type AWSSecretsManagerResolver struct {
+	Endpoint   string       // Defaults to https://secretsmanager.<region>.amazonaws.com
+	HTTPClient *http.Client // Defaults to a client with a kSecretStoreTimeout timeout
+}
+
+func (r *AWSSecretsManagerResolver) ResolveSecret(ref *url.URL) (string, error) {
+	region := ref.Host
+	secretID := strings.TrimPrefix(ref.Path, "/")
+	if region == "" || secretID == "" {
+		return "", fmt.Errorf("reference must be of the form awssm://<region>/<secret-id>")
+	}
+
+	credentials := awsCredentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if credentials.AccessKeyID == "" || credentials.SecretAccessKey == "" {
+		return "", fmt.Errorf("AWS credentials not set (AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY)")
+	}
+
+	endpoint := r.Endpoint
+	if endpoint == "" {
+		endpoint = "https://secretsmanager." + region + ".amazonaws.com"
+	}
+
+	body, err := json.Marshal(map[string]string{"SecretId": secretID})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest("POST", strings.TrimSuffix(endpoint, "/")+"/", strings.NewReader(string(body)))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	signAWSRequestV4(req, body, credentials, region, "secretsmanager", time.Now())
+
+	var response struct {
+		SecretString *string `json:"SecretString"`
+	}
+	if err := doSecretStoreRequest(r.HTTPClient, req, &response); err != nil {
+		return "", err
+	}
+	if response.SecretString == nil {
+		return "", fmt.Errorf("secret has no string value")
+	}
+
+	if ref.Fragment == "" {
+		return *response.SecretString, nil
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(*response.SecretString), &fields); err != nil {
+		return "", fmt.Errorf("secret string is not a JSON object: %v", err)
+	}
+	return secretField(fields, ref.Fragment)
+}
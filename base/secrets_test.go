@@ -0,0 +1,163 @@
+package base
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveSecretPassthrough(t *testing.T) {
+	for _, value := range []string{"", "password", "pass://word", "://nope"} {
+		assert.False(t, IsSecretRef(value))
+		secret, err := ResolveSecret(value)
+		assert.NoError(t, err)
+		assert.Equal(t, value, secret)
+	}
+}
+
+func TestFileSecretResolver(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secrets")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "bucket_password")
+	assert.NoError(t, ioutil.WriteFile(path, []byte("s3cr3t\n"), 0600))
+
+	ref := "file://" + filepath.ToSlash(path)
+	assert.True(t, IsSecretRef(ref))
+	secret, err := ResolveSecret(ref)
+	assert.NoError(t, err)
+	assert.Equal(t, "s3cr3t", secret)
+
+	_, err = ResolveSecret("file://" + filepath.ToSlash(filepath.Join(dir, "missing")))
+	assert.Error(t, err)
+}
+
+func TestVaultSecretResolver(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/sync_gateway":
+			w.Write([]byte(`{"data": {"data": {"password": "kv2", "username": "sg"}, "metadata": {"version": 1}}}`))
+		case "/v1/kv/sync_gateway":
+			w.Write([]byte(`{"data": {"password": "kv1"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	resolver := &VaultSecretResolver{Address: server.URL, Token: "token"}
+	resolve := func(ref string) (string, error) {
+		u, err := url.Parse(ref)
+		assert.NoError(t, err)
+		return resolver.ResolveSecret(u)
+	}
+
+	secret, err := resolve("vault://secret/data/sync_gateway#password")
+	assert.NoError(t, err)
+	assert.Equal(t, "kv2", secret)
+
+	// The field can be omitted for secrets with a single field
+	secret, err = resolve("vault://kv/sync_gateway")
+	assert.NoError(t, err)
+	assert.Equal(t, "kv1", secret)
+
+	_, err = resolve("vault://secret/data/sync_gateway")
+	assert.Error(t, err)
+	_, err = resolve("vault://secret/data/sync_gateway#missing")
+	assert.Error(t, err)
+	_, err = resolve("vault://secret/data/missing#password")
+	assert.Error(t, err)
+}
+
+func TestAWSSecretsManagerResolver(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "secretsmanager.GetSecretValue", r.Header.Get("X-Amz-Target"))
+		assert.True(t, strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/"))
+		assert.Contains(t, r.Header.Get("Authorization"), "/us-east-1/secretsmanager/aws4_request")
+
+		var body struct{ SecretId string }
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		switch body.SecretId {
+		case "prod/sync_gateway":
+			w.Write([]byte(`{"SecretString": "{\"password\": \"fromjson\"}"}`))
+		case "plain":
+			w.Write([]byte(`{"SecretString": "plaintext"}`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	os.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	defer os.Unsetenv("AWS_ACCESS_KEY_ID")
+	defer os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+
+	resolver := &AWSSecretsManagerResolver{Endpoint: server.URL}
+	resolve := func(ref string) (string, error) {
+		u, err := url.Parse(ref)
+		assert.NoError(t, err)
+		return resolver.ResolveSecret(u)
+	}
+
+	secret, err := resolve("awssm://us-east-1/prod/sync_gateway#password")
+	assert.NoError(t, err)
+	assert.Equal(t, "fromjson", secret)
+
+	secret, err = resolve("awssm://us-east-1/plain")
+	assert.NoError(t, err)
+	assert.Equal(t, "plaintext", secret)
+
+	_, err = resolve("awssm://us-east-1/plain#password")
+	assert.Error(t, err)
+	_, err = resolve("awssm://us-east-1/missing")
+	assert.Error(t, err)
+}
+
+type testSecretResolver map[string]string
+
+func (r testSecretResolver) ResolveSecret(ref *url.URL) (string, error) {
+	return r[ref.Host], nil
+}
+
+func TestRegisterSecretResolver(t *testing.T) {
+	RegisterSecretResolver("test", testSecretResolver{"password": "registered"})
+	defer func() {
+		secretResolversLock.Lock()
+		delete(secretResolvers, "test")
+		secretResolversLock.Unlock()
+	}()
+
+	assert.True(t, IsSecretRef("test://password"))
+	secret, err := ResolveSecret("test://password")
+	assert.NoError(t, err)
+	assert.Equal(t, "registered", secret)
+}
+
+// Uses the get-vanilla case from the AWS Signature Version 4 test suite.
+func TestSignAWSRequestV4(t *testing.T) {
+	req, err := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+	assert.NoError(t, err)
+	now, err := time.Parse(awsSigV4TimeFormat, "20150830T123600Z")
+	assert.NoError(t, err)
+
+	credentials := awsCredentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	signAWSRequestV4(req, nil, credentials, "us-east-1", "service", now)
+	assert.Equal(t, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, "+
+		"SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		req.Header.Get("Authorization"))
+}
@@ -0,0 +1,182 @@
+package base
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long GetTestBucket waits for a pooled bucket to be released before giving up
+const kTestBucketPoolWaitTimeout = 5 * time.Minute
+
+// The pool that GetTestBucketOrPanic and friends get their buckets from
+var testBucketPool = NewTestBucketPool(testBucketPoolPrefix(), testBucketPoolSize())
+
+// TestBucketPool hands out isolated test buckets, so that test packages can run in parallel against the same
+// Couchbase Server without interfering with each other.
+//
+// Walrus buckets are in-memory and uniquely named, so they're simply opened.  Couchbase Server buckets are slow to
+// create, so each package gets up to size buckets of each type, named after the package, which are created on first
+// use and then recycled: closing a TestBucket returns it to the pool, and it's flushed before it's handed out again.
+type TestBucketPool struct {
+	lock       sync.Mutex
+	prefix     string                           // Included in bucket names, to keep packages tested in parallel apart
+	size       int                              // Max # of buckets of each type
+	idle       map[CouchbaseBucketType][]string // Names of buckets that have been returned to the pool
+	inUse      map[CouchbaseBucketType][]string // Names of buckets handed out, least recently first
+	leases     map[string]int                   // # of times each bucket has been handed out
+	numCreated map[CouchbaseBucketType]int
+}
+
+func NewTestBucketPool(prefix string, size int) *TestBucketPool {
+	return &TestBucketPool{
+		prefix:     prefix,
+		size:       size,
+		idle:       map[CouchbaseBucketType][]string{},
+		inUse:      map[CouchbaseBucketType][]string{},
+		leases:     map[string]int{},
+		numCreated: map[CouchbaseBucketType]int{},
+	}
+}
+
+// Returns the name of the package under test, from the name of the test binary (e.g. "db.test"), for use as the
+// pool's prefix.
+func testBucketPoolPrefix() string {
+	prefix := os.Getenv(TestEnvSyncGatewayBucketPoolPrefix)
+	if prefix == "" {
+		prefix = strings.TrimSuffix(filepath.Base(os.Args[0]), ".test")
+	}
+	// Bucket names may only contain letters, digits, '_', '-', '.' and '%'
+	return regexp.MustCompile(`[^A-Za-z0-9_\-.]`).ReplaceAllString(prefix, "_")
+}
+
+func testBucketPoolSize() int {
+	if size, err := strconv.Atoi(os.Getenv(TestEnvSyncGatewayBucketPoolSize)); err == nil && size > 0 {
+		return size
+	}
+	return DefaultTestBucketPoolSize
+}
+
+// GetTestBucket returns an empty bucket of the given type, which must be closed when the test is done with it.
+// Panics if one can't be provisioned.
+func (p *TestBucketPool) GetTestBucket(bucketType CouchbaseBucketType) TestBucket {
+	return p.getTestBucket(bucketType, true)
+}
+
+// GetTestBucketNoFlush is like GetTestBucket, but leaves whatever was last written to the bucket.  The most recently
+// returned bucket is handed out first, so a test that closes a bucket and gets another gets the same one back.
+func (p *TestBucketPool) GetTestBucketNoFlush(bucketType CouchbaseBucketType) TestBucket {
+	return p.getTestBucket(bucketType, false)
+}
+
+func (p *TestBucketPool) getTestBucket(bucketType CouchbaseBucketType, flush bool) TestBucket {
+	spec := GetTestBucketSpec(bucketType)
+	if spec.IsWalrusBucket() {
+		return openTestBucket(spec, flush)
+	}
+
+	name, lease := p.acquire(bucketType, kTestBucketPoolWaitTimeout)
+	spec.BucketName = name
+	// Pooled buckets don't have their own RBAC users, so are accessed as the administrator
+	spec.Auth = TestAuthenticator{
+		Username:   DefaultCouchbaseAdministrator,
+		Password:   DefaultCouchbasePassword,
+		BucketName: name,
+	}
+
+	testBucket := openTestBucket(spec, flush)
+	testBucket.pool = p
+	testBucket.bucketType = bucketType
+	testBucket.poolLease = lease
+	return testBucket
+}
+
+// Returns the name and lease of a bucket of the given type that isn't in use, waiting up to timeout for one to be
+// released.  Prefers returned buckets, then creating new ones.  Once size buckets exist, a bucket that's still in use but whose
+// handle has been disowned by its test (by decrementing its open bucket count, see DecrNumOpenBuckets) is
+// reclaimed, since tests in a package run one at a time.
+func (p *TestBucketPool) acquire(bucketType CouchbaseBucketType, timeout time.Duration) (name string, lease int) {
+	deadline := time.Now().Add(timeout)
+	for {
+		var ok bool
+		p.lock.Lock()
+		name, ok = p._takeBucket(bucketType)
+		lease = p.leases[name]
+		p.lock.Unlock()
+		if ok {
+			return name, lease
+		}
+		if time.Now().After(deadline) {
+			panic(fmt.Sprintf("Timed out after %v waiting for a test bucket of type %v.  All %d are in use: %v", timeout, bucketType, p.size, p.InUse(bucketType)))
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// Requires the pool lock.
+func (p *TestBucketPool) _takeBucket(bucketType CouchbaseBucketType) (name string, ok bool) {
+	if idle := p.idle[bucketType]; len(idle) > 0 {
+		name = idle[len(idle)-1]
+		p.idle[bucketType] = idle[:len(idle)-1]
+	} else if p.numCreated[bucketType] < p.size {
+		name = p.bucketName(bucketType, p.numCreated[bucketType])
+		p.numCreated[bucketType]++
+	} else {
+		inUse := p.inUse[bucketType]
+		for i, inUseName := range inUse {
+			if NumOpenBuckets(inUseName) <= 0 {
+				Infof(KeyAll, "Reclaiming test bucket %s, which wasn't returned to the pool", MD(inUseName))
+				name = inUseName
+				p.inUse[bucketType] = append(inUse[:i:i], inUse[i+1:]...)
+				break
+			}
+		}
+		if name == "" {
+			return "", false
+		}
+	}
+	p.inUse[bucketType] = append(p.inUse[bucketType], name)
+	p.leases[name]++
+	return name, true
+}
+
+func (p *TestBucketPool) bucketName(bucketType CouchbaseBucketType, n int) string {
+	baseName := DefaultTestBucketname
+	switch bucketType {
+	case ShadowBucket:
+		baseName = DefaultTestShadowBucketname
+	case IndexBucket:
+		baseName = DefaultTestIndexBucketname
+	}
+	return fmt.Sprintf("%s_%s_%d", baseName, p.prefix, n)
+}
+
+// Returns a bucket to the pool, once it's been closed.  Ignored if the bucket's been reclaimed and handed out again
+// since the given lease.
+func (p *TestBucketPool) release(bucketType CouchbaseBucketType, name string, lease int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.leases[name] != lease {
+		return
+	}
+	inUse := p.inUse[bucketType]
+	for i, inUseName := range inUse {
+		if inUseName == name {
+			p.inUse[bucketType] = append(inUse[:i:i], inUse[i+1:]...)
+			p.idle[bucketType] = append(p.idle[bucketType], name)
+			return
+		}
+	}
+}
+
+// InUse returns the names of the pooled buckets of the given type that are currently handed out.
+func (p *TestBucketPool) InUse(bucketType CouchbaseBucketType) []string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return append([]string(nil), p.inUse[bucketType]...)
+}
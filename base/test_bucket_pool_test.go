@@ -0,0 +1,54 @@
+package base
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTestBucketPoolRecycling(t *testing.T) {
+	pool := NewTestBucketPool("pkg", 2)
+
+	// Buckets are created up to the pool size, and named after the package
+	name1, lease1 := pool.acquire(DataBucket, time.Second)
+	IncrNumOpenBuckets(name1)
+	name2, lease2 := pool.acquire(DataBucket, time.Second)
+	IncrNumOpenBuckets(name2)
+	assert.Equal(t, DefaultTestBucketname+"_pkg_0", name1)
+	assert.Equal(t, DefaultTestBucketname+"_pkg_1", name2)
+	assert.Equal(t, []string{name1, name2}, pool.InUse(DataBucket))
+
+	// Each bucket type has its own buckets
+	indexName, _ := pool.acquire(IndexBucket, time.Second)
+	assert.Equal(t, DefaultTestIndexBucketname+"_pkg_0", indexName)
+
+	// Released buckets are handed out again
+	DecrNumOpenBuckets(name1)
+	pool.release(DataBucket, name1, lease1)
+	name3, lease3 := pool.acquire(DataBucket, time.Second)
+	IncrNumOpenBuckets(name3)
+	assert.Equal(t, name1, name3)
+
+	// Once all are in use, a bucket whose handle has been disowned is reclaimed
+	DecrNumOpenBuckets(name2)
+	name4, lease4 := pool.acquire(DataBucket, time.Second)
+	IncrNumOpenBuckets(name4)
+	assert.Equal(t, name2, name4)
+
+	// Releasing a reclaimed bucket under its old lease doesn't take it from its new user
+	pool.release(DataBucket, name2, lease2)
+	assert.Equal(t, []string{name3, name4}, pool.InUse(DataBucket))
+
+	// With none released or disowned, acquiring panics once it times out
+	assert.Panics(t, func() { pool.acquire(DataBucket, 200*time.Millisecond) })
+
+	for _, release := range []struct {
+		name  string
+		lease int
+	}{{name3, lease3}, {name4, lease4}} {
+		DecrNumOpenBuckets(release.name)
+		pool.release(DataBucket, release.name, release.lease)
+	}
+	assert.Len(t, pool.InUse(DataBucket), 0)
+}
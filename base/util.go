@@ -943,6 +943,10 @@ func Uint32Ptr(u uint32) *uint32 {
 	return &u
 }
 
+func IntPtr(i int) *int {
+	return &i
+}
+
 func BoolPtr(b bool) *bool {
 	return &b
 }
@@ -1034,6 +1038,41 @@ func ConvertBackQuotedStrings(data []byte) []byte {
 	})
 }
 
+// Matches $${...} (an escaped reference), ${NAME} and ${NAME:-default}
+var kEnvVarRegexp = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// Preprocesses config data, replacing ${NAME} references with the value of the NAME environment
+// variable.  ${NAME:-default} substitutes default if NAME is unset or empty, and $${NAME} produces a
+// literal ${NAME}.  Values are escaped so they can be used inside JSON strings, so backquoted strings must be
+// converted with ConvertBackQuotedStrings first, or they'd be escaped twice.  Returns an error listing
+// every referenced variable that is unset and has no default.
+func ExpandEnvVars(data []byte) ([]byte, error) {
+	var missing []string
+	expanded := kEnvVarRegexp.ReplaceAllFunc(data, func(ref []byte) []byte {
+		if bytes.HasPrefix(ref, []byte("$$")) {
+			return ref[1:]
+		}
+		match := kEnvVarRegexp.FindSubmatch(ref)
+		name := string(match[1])
+		value, ok := os.LookupEnv(name)
+		if !ok || value == "" {
+			if match[2] == nil {
+				if !ok {
+					missing = append(missing, name)
+				}
+				return nil
+			}
+			value = string(match[3])
+		}
+		escaped, _ := json.Marshal(value)
+		return escaped[1 : len(escaped)-1]
+	})
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("Required environment variables not set: %s", strings.Join(missing, ", "))
+	}
+	return expanded, nil
+}
+
 // FindPrimaryAddr returns the primary outbound IP of this machine.
 // This is the same as find_primary_addr in sgcollect_info.
 func FindPrimaryAddr() (net.IP, error) {
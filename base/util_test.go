@@ -14,6 +14,7 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"os"
 	"reflect"
 	"strings"
 	"testing"
@@ -72,6 +73,34 @@ func TestBackQuotedStrings(t *testing.T) {
 	goassert.Equals(t, string(output), `{"foo": "bar\\baz", "something": "else\\is\\here"}`)
 }
 
+func TestExpandEnvVars(t *testing.T) {
+	os.Setenv("SG_TEST_BUCKET", "db-bucket")
+	os.Setenv("SG_TEST_PASSWORD", `pa"ss\word`)
+	os.Setenv("SG_TEST_EMPTY", "")
+	defer os.Unsetenv("SG_TEST_BUCKET")
+	defer os.Unsetenv("SG_TEST_PASSWORD")
+	defer os.Unsetenv("SG_TEST_EMPTY")
+
+	output, err := ExpandEnvVars([]byte(`{"bucket": "${SG_TEST_BUCKET}", "password": "${SG_TEST_PASSWORD}"}`))
+	goassert.Equals(t, err, nil)
+	goassert.Equals(t, string(output), `{"bucket": "db-bucket", "password": "pa\"ss\\word"}`)
+
+	// Defaults are used for unset and empty variables
+	output, err = ExpandEnvVars([]byte(`{"server": "${SG_TEST_UNSET:-walrus:}", "bucket": "${SG_TEST_EMPTY:-default}", "empty": "${SG_TEST_EMPTY}"}`))
+	goassert.Equals(t, err, nil)
+	goassert.Equals(t, string(output), `{"server": "walrus:", "bucket": "default", "empty": ""}`)
+
+	// Escaped references are left as literals
+	output, err = ExpandEnvVars([]byte(`{"sync": "$${SG_TEST_BUCKET}"}`))
+	goassert.Equals(t, err, nil)
+	goassert.Equals(t, string(output), `{"sync": "${SG_TEST_BUCKET}"}`)
+
+	// Unset variables without a default are reported
+	_, err = ExpandEnvVars([]byte(`{"bucket": "${SG_TEST_UNSET}", "password": "${SG_TEST_UNSET_2}"}`))
+	goassert.NotEquals(t, err, nil)
+	goassert.StringContains(t, err.Error(), "SG_TEST_UNSET, SG_TEST_UNSET_2")
+}
+
 func TestCouchbaseUrlWithAuth(t *testing.T) {
 
 	// normal bucket
@@ -33,6 +33,9 @@ func init() {
 type TestBucket struct {
 	Bucket
 	BucketSpec BucketSpec
+	pool       *TestBucketPool     // The pool the bucket is returned to on Close, if any
+	bucketType CouchbaseBucketType // The type of bucket in the pool
+	poolLease  int                 // Identifies this use of the pooled bucket
 }
 
 func (tb TestBucket) Close() {
@@ -40,12 +43,21 @@ func (tb TestBucket) Close() {
 	tb.Bucket.Close()
 
 	DecrNumOpenBuckets(tb.Bucket.GetName())
+
+	if tb.pool != nil {
+		tb.pool.release(tb.bucketType, tb.BucketSpec.BucketName, tb.poolLease)
+	}
 }
 
 func GetTestBucketOrPanic() TestBucket {
 	return GetBucketOrPanicCommon(DataBucket)
 }
 
+// Gets a test bucket from the test bucket pool without emptying it.  Panics if one can't be provisioned.
+func GetTestBucketNoFlushOrPanic() TestBucket {
+	return testBucketPool.GetTestBucketNoFlush(DataBucket)
+}
+
 func GetTestIndexBucketOrPanic() TestBucket {
 	return GetBucketOrPanicCommon(IndexBucket)
 }
@@ -96,9 +108,14 @@ func GetTestBucketSpec(bucketType CouchbaseBucketType) BucketSpec {
 
 }
 
+// Gets a test bucket of the given type from the test bucket pool.  Panics if one can't be provisioned.
 func GetBucketOrPanicCommon(bucketType CouchbaseBucketType) TestBucket {
+	return testBucketPool.GetTestBucket(bucketType)
+}
 
-	spec := GetTestBucketSpec(bucketType)
+// Opens the bucket with the given spec, after creating it, or emptying it if flush is true, if it's a Couchbase
+// Server bucket.
+func openTestBucket(spec BucketSpec, flush bool) TestBucket {
 
 	if !spec.IsWalrusBucket() {
 
@@ -106,24 +123,35 @@ func GetBucketOrPanicCommon(bucketType CouchbaseBucketType) TestBucket {
 		// and therefore needs to create the bucket if it doesn't already exist, or flush it if it does.
 
 		tbm := NewTestBucketManager(spec)
-		bucketExists, err := tbm.OpenTestBucket()
+		bucketExists, err := tbm.BucketExists()
 		if err != nil {
 			panic(fmt.Sprintf("Error checking if bucket exists.  Spec: %+v err: %v", spec, err))
 		}
-		switch bucketExists {
-		case true:
+		switch {
+		case bucketExists && !flush:
+			// Leave it as it is, but count it as open, the same as a bucket opened by OpenTestBucket
+			if NumOpenBuckets(spec.BucketName) > 0 {
+				panic(fmt.Sprintf("There are already %d open buckets with name: %s.  The tests expect all buckets to be closed.", NumOpenBuckets(spec.BucketName), spec.BucketName))
+			}
+			IncrNumOpenBuckets(spec.BucketName)
+		case bucketExists:
 			// Empty it
+			if _, err := tbm.OpenTestBucket(); err != nil {
+				panic(fmt.Sprintf("Error opening bucket.  Spec: %+v err: %v", spec, err))
+			}
 			if err := tbm.RecreateOrEmptyBucket(); err != nil {
 				panic(fmt.Sprintf("Error trying to empty bucket.  Spec: %+v.  err: %v", spec, err))
 
 			}
-		case false:
+		default:
 			// Create a brand new bucket
 			// TODO: in this case, we should still wait until it's empty, just in case there was somehow residue
 			// TODO: in between deleting and recreating it, if it happened in rapid succession
 			if err := tbm.CreateTestBucket(); err != nil {
 				panic(fmt.Sprintf("Could not create bucket.  Spec: %+v Err: %v", spec, err))
 			}
+			// Count it as open, the same as an existing bucket opened by OpenTestBucket
+			IncrNumOpenBuckets(spec.BucketName)
 		}
 
 		// Close the bucket and any other temporary resources associated with the TestBucketManager
@@ -257,7 +285,42 @@ func (tbm *TestBucketManager) OpenTestBucket() (bucketExists bool, err error) {
 }
 
 func (tbm *TestBucketManager) Close() {
-	tbm.Bucket.Close()
+	if tbm.Bucket != nil {
+		tbm.Bucket.Close()
+	}
+	if tbm.Cluster != nil {
+		tbm.Cluster.Close()
+	}
+}
+
+// Connects to the cluster as the administrator, to manage buckets.
+func (tbm *TestBucketManager) connectCluster() (err error) {
+	if tbm.ClusterManager != nil {
+		return nil
+	}
+	tbm.Cluster, err = gocb.Connect(tbm.BucketSpec.Server)
+	if err != nil {
+		return err
+	}
+	tbm.ClusterManager = tbm.Cluster.Manager(tbm.AdministratorUsername, tbm.AdministratorPassword)
+	return nil
+}
+
+// Returns true if the bucket exists on the cluster.
+func (tbm *TestBucketManager) BucketExists() (bool, error) {
+	if err := tbm.connectCluster(); err != nil {
+		return false, err
+	}
+	buckets, err := tbm.ClusterManager.GetBuckets()
+	if err != nil {
+		return false, err
+	}
+	for _, bucket := range buckets {
+		if bucket.Name == tbm.BucketSpec.BucketName {
+			return true, nil
+		}
+	}
+	return false, nil
 }
 
 // GOCB doesn't currently offer a way to do this, and so this is a workaround to go directly
@@ -408,6 +471,10 @@ func (tbm *TestBucketManager) RecreateOrEmptyBucket() error {
 
 func (tbm *TestBucketManager) DeleteTestBucket() error {
 
+	if err := tbm.connectCluster(); err != nil {
+		return err
+	}
+
 	err := tbm.ClusterManager.RemoveBucket(tbm.BucketSpec.BucketName)
 	if err != nil {
 		return err
@@ -418,6 +485,10 @@ func (tbm *TestBucketManager) DeleteTestBucket() error {
 
 func (tbm *TestBucketManager) CreateTestBucket() error {
 
+	if err := tbm.connectCluster(); err != nil {
+		return err
+	}
+
 	username, password, _ := tbm.BucketSpec.Auth.GetCredentials()
 
 	log.Printf("Create bucket with username: %v password: %v", username, password)
@@ -0,0 +1,241 @@
+package base
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/couchbase/sg-bucket"
+)
+
+// How often a walrus bucket is persisted to its snapshot file, if not set in the BucketSpec.
+const kDefaultWalrusSnapshotInterval = 60 * time.Second
+
+// A single document in a bucket snapshot.  Snapshots are written as one JSON object per line, so they can be
+// streamed.  JSON documents are stored as-is for readability; other documents are base64-encoded.
+type bucketSnapshotDoc struct {
+	Key    string          `json:"key"`
+	JSON   json.RawMessage `json:"json,omitempty"`
+	Raw    []byte          `json:"raw,omitempty"`
+	Expiry uint32          `json:"exp,omitempty"`
+}
+
+// WalrusSnapshotBucket wraps a walrus bucket with support for taking and restoring snapshots of its contents.  If
+// created with a snapshot path, the bucket is restored from that file when opened, and persisted to it periodically
+// and when closed, so it keeps its state across restarts.
+type WalrusSnapshotBucket struct {
+	Bucket                  // The walrus bucket
+	path      string        // File the bucket is persisted to, or empty if not persisted
+	lock      sync.Mutex    // Serializes snapshots and restores
+	ticker    *time.Ticker  // Triggers periodic persistence, if enabled
+	stopChan  chan struct{} // Closed to stop periodic persistence
+	closeOnce sync.Once
+}
+
+// Wraps the given walrus bucket.  If path is non-empty and the file exists, the bucket's contents are restored from
+// it, and it's then persisted to the file every interval.
+func NewWalrusSnapshotBucket(bucket Bucket, path string, interval time.Duration) (*WalrusSnapshotBucket, error) {
+	b := &WalrusSnapshotBucket{Bucket: bucket, path: path}
+	if path == "" {
+		return b, nil
+	}
+
+	if f, err := os.Open(path); err == nil {
+		count, err := b.Restore(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("Unable to restore bucket from %s: %v", path, err)
+		}
+		Infof(KeyAll, "Restored %d docs into walrus bucket %s from %s", count, MD(bucket.GetName()), path)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if interval <= 0 {
+		interval = kDefaultWalrusSnapshotInterval
+	}
+	b.ticker = time.NewTicker(interval)
+	b.stopChan = make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-b.ticker.C:
+				if err := b.SaveSnapshot(); err != nil {
+					Warnf(KeyAll, "Unable to persist walrus bucket %s: %v", MD(b.GetName()), err)
+				}
+			case <-b.stopChan:
+				return
+			}
+		}
+	}()
+	return b, nil
+}
+
+// Returns the WalrusSnapshotBucket underlying the given bucket, if any.
+func AsWalrusSnapshotBucket(bucket Bucket) (*WalrusSnapshotBucket, bool) {
+	switch typedBucket := bucket.(type) {
+	case *WalrusSnapshotBucket:
+		return typedBucket, true
+	case *LoggingBucket:
+		return AsWalrusSnapshotBucket(typedBucket.GetUnderlyingBucket())
+	case *LeakyBucket:
+		return AsWalrusSnapshotBucket(typedBucket.GetUnderlyingBucket())
+	default:
+		return nil, false
+	}
+}
+
+// Calls the callback with every document in the bucket.  Relies on walrus closing TAP feeds once their backfill has
+// been dumped, which the pseudo-vbucket wrapping in LeakyBucket doesn't pass on.
+func (b *WalrusSnapshotBucket) forEachDoc(callback func(event sgbucket.FeedEvent) error) error {
+	feed, err := b.Bucket.StartTapFeed(sgbucket.FeedArguments{Backfill: 0, Dump: true})
+	if err != nil {
+		return err
+	}
+	defer feed.Close()
+
+	var callbackErr error
+	for event := range feed.Events() {
+		// Keep draining the feed after an error, so the feed's goroutine isn't left blocked
+		if event.Opcode == sgbucket.FeedOpMutation && callbackErr == nil {
+			callbackErr = callback(event)
+		}
+	}
+	return callbackErr
+}
+
+// Snapshot writes the contents of the bucket to w, returning the number of documents written.
+func (b *WalrusSnapshotBucket) Snapshot(w io.Writer) (count int, err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	buffered := bufio.NewWriter(w)
+	encoder := json.NewEncoder(buffered)
+	err = b.forEachDoc(func(event sgbucket.FeedEvent) error {
+		doc := bucketSnapshotDoc{Key: string(event.Key), Expiry: event.Expiry}
+		if json.Valid(event.Value) {
+			doc.JSON = event.Value
+		} else {
+			doc.Raw = event.Value
+		}
+		count++
+		return encoder.Encode(doc)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, buffered.Flush()
+}
+
+// Restore replaces the contents of the bucket with a snapshot read from r, returning the number of documents
+// restored.  Documents not in the snapshot are deleted.
+func (b *WalrusSnapshotBucket) Restore(r io.Reader) (count int, err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	existing := map[string]struct{}{}
+	err = b.forEachDoc(func(event sgbucket.FeedEvent) error {
+		existing[string(event.Key)] = struct{}{}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	decoder := json.NewDecoder(r)
+	for {
+		var doc bucketSnapshotDoc
+		if err := decoder.Decode(&doc); err == io.EOF {
+			break
+		} else if err != nil {
+			return count, fmt.Errorf("Invalid snapshot: %v", err)
+		}
+
+		if doc.JSON != nil {
+			err = b.Bucket.Set(doc.Key, doc.Expiry, doc.JSON)
+		} else {
+			err = b.Bucket.SetRaw(doc.Key, doc.Expiry, doc.Raw)
+		}
+		if err != nil {
+			return count, err
+		}
+		delete(existing, doc.Key)
+		count++
+	}
+
+	for key := range existing {
+		if err := b.Bucket.Delete(key); err != nil && !IsDocNotFoundError(err) {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+// SaveSnapshot persists the bucket to its snapshot file.  The snapshot is written to a temporary file first, so a
+// failed write doesn't leave a truncated snapshot behind.
+func (b *WalrusSnapshotBucket) SaveSnapshot() error {
+	if b.path == "" {
+		return nil
+	}
+	f, err := os.Create(b.path + ".tmp")
+	if err != nil {
+		return err
+	}
+	count, err := b.Snapshot(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), b.path); err != nil {
+		return err
+	}
+	Debugf(KeyBucket, "Persisted %d docs from walrus bucket %s to %s", count, MD(b.GetName()), filepath.Base(b.path))
+	return nil
+}
+
+// Stops periodic persistence, if enabled.
+func (b *WalrusSnapshotBucket) stopPersisting() {
+	if b.ticker != nil {
+		b.ticker.Stop()
+		close(b.stopChan)
+	}
+}
+
+// Close persists the bucket to its snapshot file, if any, before closing it.
+func (b *WalrusSnapshotBucket) Close() {
+	b.closeOnce.Do(func() {
+		b.stopPersisting()
+		if err := b.SaveSnapshot(); err != nil {
+			Warnf(KeyAll, "Unable to persist walrus bucket %s on close: %v", MD(b.GetName()), err)
+		}
+		b.Bucket.Close()
+	})
+}
+
+// CloseAndDelete deletes the bucket, along with its snapshot file.
+func (b *WalrusSnapshotBucket) CloseAndDelete() (err error) {
+	b.closeOnce.Do(func() {
+		b.stopPersisting()
+		if b.path != "" {
+			if removeErr := os.Remove(b.path); removeErr != nil && !os.IsNotExist(removeErr) {
+				err = removeErr
+			}
+		}
+		if bucket, ok := b.Bucket.(sgbucket.DeleteableBucket); ok {
+			if deleteErr := bucket.CloseAndDelete(); deleteErr != nil {
+				err = deleteErr
+			}
+		} else {
+			b.Bucket.Close()
+		}
+	})
+	return err
+}
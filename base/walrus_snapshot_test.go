@@ -0,0 +1,74 @@
+package base
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWalrusSnapshotRestore(t *testing.T) {
+	bucket, err := GetBucket(BucketSpec{Server: "walrus:", BucketName: "snapshot_restore"}, nil)
+	assert.NoError(t, err)
+	defer bucket.Close()
+
+	snapshotBucket, ok := AsWalrusSnapshotBucket(bucket)
+	assert.True(t, ok)
+
+	assert.NoError(t, bucket.Set("doc1", 0, map[string]interface{}{"value": 1}))
+	assert.NoError(t, bucket.SetRaw("raw1", 0, []byte("not json")))
+
+	var snapshot bytes.Buffer
+	count, err := snapshotBucket.Snapshot(&snapshot)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	// Restoring replaces modified docs and removes docs added since the snapshot
+	assert.NoError(t, bucket.Set("doc1", 0, map[string]interface{}{"value": 2}))
+	assert.NoError(t, bucket.Set("doc2", 0, map[string]interface{}{"value": 3}))
+	count, err = snapshotBucket.Restore(&snapshot)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	var body map[string]interface{}
+	_, err = bucket.Get("doc1", &body)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(1), body["value"])
+	raw, _, err := bucket.GetRaw("raw1")
+	assert.NoError(t, err)
+	assert.Equal(t, "not json", string(raw))
+	_, _, err = bucket.GetRaw("doc2")
+	assert.True(t, IsDocNotFoundError(err))
+
+	_, err = snapshotBucket.Restore(bytes.NewBufferString(`{"key": `))
+	assert.Error(t, err)
+}
+
+func TestWalrusSnapshotPersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "walrus_snapshot")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "bucket.snapshot")
+
+	bucket, err := GetBucket(BucketSpec{Server: "walrus:", BucketName: "snapshot_persist1", WalrusSnapshotPath: path}, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, bucket.Set("doc1", 0, map[string]interface{}{"value": 1}))
+	bucket.Close()
+
+	// A new bucket with the same snapshot path starts with the persisted contents
+	bucket, err = GetBucket(BucketSpec{Server: "walrus:", BucketName: "snapshot_persist2", WalrusSnapshotPath: path}, nil)
+	assert.NoError(t, err)
+	var body map[string]interface{}
+	_, err = bucket.Get("doc1", &body)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(1), body["value"])
+
+	snapshotBucket, ok := AsWalrusSnapshotBucket(bucket)
+	assert.True(t, ok)
+	assert.NoError(t, snapshotBucket.CloseAndDelete())
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
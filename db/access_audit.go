@@ -0,0 +1,102 @@
+package db
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/couchbase/sync_gateway/base"
+)
+
+const (
+	AccessAuditKeyPrefix         = "_sync:accessaudit:" // Prefix of the keys of principals' access audit trails
+	DefaultAccessAuditMaxEntries = 100                  // Default number of entries kept in each principal's trail
+)
+
+type AccessAuditOptions struct {
+	Enabled    bool // Whether changes to documents' access() and role() grants are recorded
+	MaxEntries int  // Max # of entries kept for each principal, oldest discarded first.  Zero means the default
+}
+
+// AccessGrantEntry records a change to the channels or roles that a document's access() and role() grants give a
+// principal.  The changes are only the ones made by that document; another document may grant the same channels.
+type AccessGrantEntry struct {
+	Time            time.Time `json:"time"`
+	DocID           string    `json:"doc_id"`
+	RevID           string    `json:"rev_id"`
+	Sequence        uint64    `json:"seq"`
+	Resync          bool      `json:"resync,omitempty"` // True if the change was made by re-running the sync function
+	ChannelsGranted []string  `json:"channels_granted,omitempty"`
+	ChannelsRevoked []string  `json:"channels_revoked,omitempty"`
+	RolesGranted    []string  `json:"roles_granted,omitempty"`
+	RolesRevoked    []string  `json:"roles_revoked,omitempty"`
+}
+
+// The stored access audit trail of a principal, oldest entry first.
+type accessAuditTrail struct {
+	Entries []AccessGrantEntry `json:"entries"`
+}
+
+func accessAuditKey(name string, isRole bool) string {
+	if isRole {
+		return AccessAuditKeyPrefix + "role:" + name
+	}
+	return AccessAuditKeyPrefix + "user:" + name
+}
+
+// Records grant changes made by a revision of a document in the access audit trails of the principals concerned.
+// The revision's already been stored, so failures are logged rather than returned.
+func (context *DatabaseContext) recordAccessGrants(deltas []PrincipalDelta, revID string, sequence uint64, resync bool) {
+	maxEntries := context.Options.AccessAuditOptions.MaxEntries
+	if maxEntries <= 0 {
+		maxEntries = DefaultAccessAuditMaxEntries
+	}
+	now := time.Now()
+
+	for _, delta := range deltas {
+		entry := AccessGrantEntry{
+			Time:            now,
+			DocID:           delta.DocID,
+			RevID:           revID,
+			Sequence:        sequence,
+			Resync:          resync,
+			ChannelsGranted: delta.ChannelsAdded,
+			ChannelsRevoked: delta.ChannelsRemoved,
+			RolesGranted:    delta.RolesAdded,
+			RolesRevoked:    delta.RolesRemoved,
+		}
+		_, err := context.MetadataBucket.Update(accessAuditKey(delta.Name, delta.IsRole), 0, func(currentValue []byte) ([]byte, *uint32, error) {
+			var trail accessAuditTrail
+			if len(currentValue) > 0 {
+				if err := json.Unmarshal(currentValue, &trail); err != nil {
+					return nil, nil, err
+				}
+			}
+			trail.Entries = append(trail.Entries, entry)
+			if excess := len(trail.Entries) - maxEntries; excess > 0 {
+				trail.Entries = trail.Entries[excess:]
+			}
+			updated, err := json.Marshal(trail)
+			return updated, nil, err
+		})
+		if err != nil {
+			base.Warnf(base.KeyAll, "Unable to record access grants of doc %q to %s in access audit trail: %v",
+				base.UDDocID(delta.DocID), base.UD(delta.Name), err)
+		}
+	}
+}
+
+// Returns a user's or role's access audit trail, oldest entry first.  If channel isn't empty, only the entries that
+// granted or revoked that channel are returned.
+func (context *DatabaseContext) GetAccessAudit(name string, isRole bool, channel string) ([]AccessGrantEntry, error) {
+	var trail accessAuditTrail
+	if _, err := context.MetadataBucket.Get(accessAuditKey(name, isRole), &trail); err != nil && !base.IsDocNotFoundError(err) {
+		return nil, err
+	}
+	entries := make([]AccessGrantEntry, 0, len(trail.Entries))
+	for _, entry := range trail.Entries {
+		if channel == "" || base.ContainsString(entry.ChannelsGranted, channel) || base.ContainsString(entry.ChannelsRevoked, channel) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,68 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/couchbase/sync_gateway/channels"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccessAudit(t *testing.T) {
+	db, testBucket := setupTestDB(t)
+	defer testBucket.Close()
+	defer tearDownTestDB(t, db)
+	db.Options.AccessAuditOptions = AccessAuditOptions{Enabled: true, MaxEntries: 3}
+	db.ChannelMapper = channels.NewChannelMapper(`function(doc) {access(doc.users, doc.channels); role(doc.users, doc.roles);}`)
+
+	rev1, err := db.Put("grants", Body{"users": []string{"alice"}, "channels": []string{"X"}, "roles": []string{"role:r1"}})
+	assert.NoError(t, err)
+	rev2, err := db.Put("grants", Body{"users": []string{"alice"}, "channels": []string{"Y"}, BodyRev: rev1})
+	assert.NoError(t, err)
+
+	entries, err := db.GetAccessAudit("alice", false, "")
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, "grants", entries[0].DocID)
+		assert.Equal(t, rev1, entries[0].RevID)
+		assert.Equal(t, []string{"X"}, entries[0].ChannelsGranted)
+		assert.Equal(t, []string{"r1"}, entries[0].RolesGranted)
+		assert.Equal(t, rev2, entries[1].RevID)
+		assert.True(t, entries[1].Sequence > entries[0].Sequence)
+		assert.Equal(t, []string{"Y"}, entries[1].ChannelsGranted)
+		assert.Equal(t, []string{"X"}, entries[1].ChannelsRevoked)
+		assert.Equal(t, []string{"r1"}, entries[1].RolesRevoked)
+	}
+
+	// Filtering by channel
+	entries, err = db.GetAccessAudit("alice", false, "Y")
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, rev2, entries[0].RevID)
+	}
+
+	// Principals without grants have empty trails
+	entries, err = db.GetAccessAudit("bob", false, "")
+	assert.NoError(t, err)
+	assert.Len(t, entries, 0)
+
+	// Grants changed by resync are recorded too
+	db.ChannelMapper = channels.NewChannelMapper(`function(doc) {access(doc.users, "Z");}`)
+	_, err = db.UpdateAllDocChannels()
+	assert.NoError(t, err)
+	entries, err = db.GetAccessAudit("alice", false, "Z")
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 1) {
+		assert.True(t, entries[0].Resync)
+		assert.Equal(t, rev2, entries[0].RevID)
+		assert.Equal(t, []string{"Y"}, entries[0].ChannelsRevoked)
+	}
+
+	// Only the most recent entries are kept
+	_, err = db.Put("grants", Body{BodyRev: rev2})
+	assert.NoError(t, err)
+	entries, err = db.GetAccessAudit("alice", false, "")
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 3) {
+		assert.Equal(t, rev2, entries[0].RevID)
+	}
+}
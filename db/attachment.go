@@ -164,11 +164,11 @@ func (db *Database) loadBodyAttachments(body Body, minRevpos int, docid string)
 		if ok && revpos >= int64(minRevpos) {
 			digest, ok := meta["digest"]
 			if !ok {
-				return nil, base.RedactErrorf("Unable to load attachment for doc: %v with name: %v and revpos: %v due to missing digest field", base.UD(docid), base.UD(attachmentName), revpos)
+				return nil, base.RedactErrorf("Unable to load attachment for doc: %v with name: %v and revpos: %v due to missing digest field", base.UDDocID(docid), base.UD(attachmentName), revpos)
 			}
 			digestStr, ok := digest.(string)
 			if !ok {
-				return nil, base.RedactErrorf("Unable to load attachment for doc: %v with name: %v and revpos: %v due to unexpected digest field: %v", base.UD(docid), base.UD(attachmentName), revpos, digest)
+				return nil, base.RedactErrorf("Unable to load attachment for doc: %v with name: %v and revpos: %v due to unexpected digest field: %v", base.UDDocID(docid), base.UD(attachmentName), revpos, digest)
 			}
 			key := AttachmentKey(digestStr)
 			data, err := db.GetAttachment(key)
@@ -200,24 +200,36 @@ func (db *Database) setAttachment(attachment []byte) (AttachmentKey, error) {
 
 func (db *Database) setAttachments(attachments AttachmentData) error {
 
+	reserved, err := db.quotas.reserveAttachmentBytes(attachments)
+	if err != nil {
+		return err
+	}
+
 	for key, data := range attachments {
 		attachmentSize := int64(len(data))
-		_, err := db.Bucket.AddRaw(attachmentKeyToString(key), 0, data)
+		added, err := db.Bucket.AddRaw(attachmentKeyToString(key), 0, data)
+		if added {
+			delete(reserved, key) // Keeps its reservation
+		}
 		if err == nil {
 			base.Infof(base.KeyCRUD, "\tAdded attachment %q", base.UD(key))
 			db.DbStats.CblReplicationPush().Add(base.StatKeyAttachmentPushCount, 1)
 			db.DbStats.CblReplicationPush().Add(base.StatKeyAttachmentPushBytes, attachmentSize)
 		} else {
+			db.quotas.releaseAttachmentBytes(reserved)
 			return err
 		}
 	}
+	// Attachments that were already stored don't need the room reserved for them
+	db.quotas.releaseAttachmentBytes(reserved)
 	return nil
 }
 
 //////// MIME MULTIPART:
 
-// Parses a JSON MIME body, unmarshaling it into "into".
-func ReadJSONFromMIME(headers http.Header, input io.Reader, into interface{}) error {
+// Parses a JSON MIME body, unmarshaling it into "into".  If maxDepth is nonzero, fails with a 413 error if objects
+// and arrays are nested more than maxDepth deep.
+func ReadJSONFromMIME(headers http.Header, input io.Reader, into interface{}, maxDepth int) error {
 	contentType := headers.Get("Content-Type")
 	if contentType != "" && !strings.HasPrefix(contentType, "application/json") {
 		return base.HTTPErrorf(http.StatusUnsupportedMediaType, "Invalid content type %s", contentType)
@@ -234,16 +246,61 @@ func ReadJSONFromMIME(headers http.Header, input io.Reader, into interface{}) er
 	default:
 		return base.HTTPErrorf(http.StatusUnsupportedMediaType, "Unsupported Content-Encoding; use gzip")
 	}
+	if maxDepth > 0 {
+		input = &jsonDepthLimitReader{reader: input, maxDepth: maxDepth}
+	}
 
 	decoder := json.NewDecoder(input)
 	decoder.UseNumber()
 	if err := decoder.Decode(into); err != nil {
+		if httpErr, ok := err.(*base.HTTPError); ok {
+			// The body is too large or too deeply nested
+			return httpErr
+		}
 		base.Warnf(base.KeyAll, "Couldn't parse JSON in HTTP request: %v", err)
 		return base.HTTPErrorf(http.StatusBadRequest, "Bad JSON")
 	}
 	return nil
 }
 
+// Passes JSON through from reader, failing with a 413 error once objects and arrays are nested more than maxDepth
+// deep, so that a hostile client can't make the decoder recurse without bound.
+type jsonDepthLimitReader struct {
+	reader   io.Reader
+	maxDepth int
+	depth    int
+	inString bool // Whether the last byte read was inside a string
+	escaped  bool // Whether the last byte read was a backslash escape inside a string
+}
+
+func (r *jsonDepthLimitReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	for i, c := range p[:n] {
+		if r.inString {
+			if r.escaped {
+				r.escaped = false
+			} else if c == '\\' {
+				r.escaped = true
+			} else if c == '"' {
+				r.inString = false
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			r.inString = true
+		case '{', '[':
+			r.depth++
+			if r.depth > r.maxDepth {
+				return i, base.HTTPErrorf(http.StatusRequestEntityTooLarge, "JSON is nested more than %d levels deep", r.maxDepth)
+			}
+		case '}', ']':
+			r.depth--
+		}
+	}
+	return n, err
+}
+
 type attInfo struct {
 	name        string
 	contentType string
@@ -359,14 +416,16 @@ func (db *Database) WriteRevisionAsPart(revBody Body, isError bool, compressPart
 	}
 }
 
-func ReadMultipartDocument(reader *multipart.Reader) (Body, error) {
+// Reads a multipart/related document body, with its attachments.  If maxJSONDepth is nonzero, fails with a 413 error
+// if the document's objects and arrays are nested more than maxJSONDepth deep.
+func ReadMultipartDocument(reader *multipart.Reader, maxJSONDepth int) (Body, error) {
 	// First read the main JSON document body:
 	mainPart, err := reader.NextPart()
 	if err != nil {
 		return nil, err
 	}
 	var body Body
-	err = ReadJSONFromMIME(http.Header(mainPart.Header), mainPart, &body)
+	err = ReadJSONFromMIME(http.Header(mainPart.Header), mainPart, &body, maxJSONDepth)
 	mainPart.Close()
 	if err != nil {
 		return nil, err
@@ -13,6 +13,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"net/http"
+	"strings"
 	"testing"
 
 	"github.com/couchbase/sync_gateway/base"
@@ -176,3 +178,27 @@ func TestAttachmentRetrievalUsingRevCache(t *testing.T) {
 	assert.NoError(t, countErr, "Couldn't retrieve document_gets expvar")
 	assert.Equal(t, initCount, getCount)
 }
+
+func TestReadJSONFromMIMEMaxDepth(t *testing.T) {
+	headers := http.Header{"Content-Type": []string{"application/json"}}
+	read := func(json string, maxDepth int) error {
+		var body Body
+		return ReadJSONFromMIME(headers, strings.NewReader(json), &body, maxDepth)
+	}
+
+	assert.NoError(t, read(`{"a":{"b":[1,2]}}`, 3))
+	assert.NoError(t, read(`{"a":{"b":[1,2]}}`, 0))
+	// Brackets in strings don't count
+	assert.NoError(t, read(`{"a":"[[[{{{\"[[["}`, 1))
+
+	err := read(`{"a":{"b":[1,2]}}`, 2)
+	if assert.Error(t, err) {
+		status, _ := base.ErrorAsHTTPStatus(err)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, status)
+	}
+	err = read("["+strings.Repeat("[", 100000), 10)
+	if assert.Error(t, err) {
+		status, _ := base.ErrorAsHTTPStatus(err)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, status)
+	}
+}
@@ -0,0 +1,539 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/sync_gateway/base"
+)
+
+// Key of the doc the state of the database's background tasks is persisted in.  It's shared by all of the database's
+// nodes, so the tasks of every node are listed, and a task's outcome is kept when its node restarts.
+const kBackgroundTasksKey = KSyncKeyPrefix + "backgroundTasks"
+
+// Max number of finished tasks kept in the persisted task list
+const kBackgroundTaskHistory = 50
+
+// Default max number of a database's tasks that run at once on each node
+const DefaultBackgroundTaskMaxConcurrent = 1
+
+// How often a scheduled task that's due checks whether its scheduling window has opened
+var backgroundTaskWindowCheckInterval = time.Minute
+
+// Names of the background tasks
+const (
+	BackgroundTaskCompact        = "compact"         // Tombstone compaction requested at _compact
+	BackgroundTaskResync         = "resync"          // Re-running the sync function on all documents, requested at _resync
+	BackgroundTaskTombstonePurge = "tombstone_purge" // Scheduled tombstone compaction
+	BackgroundTaskAttachmentGC   = "attachment_gc"   // Deleting unused attachments, requested at _vacuum
+)
+
+type BackgroundTaskState string
+
+const (
+	BackgroundTaskQueued      BackgroundTaskState = "queued"      // Waiting for one of the running tasks to finish
+	BackgroundTaskRunning     BackgroundTaskState = "running"     //
+	BackgroundTaskPaused      BackgroundTaskState = "paused"      // Stopped until it's resumed, keeping its place
+	BackgroundTaskCompleted   BackgroundTaskState = "completed"   //
+	BackgroundTaskFailed      BackgroundTaskState = "failed"      //
+	BackgroundTaskCancelled   BackgroundTaskState = "cancelled"   //
+	BackgroundTaskInterrupted BackgroundTaskState = "interrupted" // Its node stopped before it finished
+)
+
+// Returns true if a task in the state won't change state again.
+func (s BackgroundTaskState) finished() bool {
+	return s == BackgroundTaskCompleted || s == BackgroundTaskFailed || s == BackgroundTaskCancelled || s == BackgroundTaskInterrupted
+}
+
+var errBackgroundTaskCancelled = base.HTTPErrorf(http.StatusServiceUnavailable, "Task cancelled")
+var errBackgroundTaskOffline = base.HTTPErrorf(http.StatusServiceUnavailable, "Database went offline while the task was paused")
+
+// BackgroundTaskWindow is a daily period, in UTC, during which scheduled tasks may start.  A window whose end is
+// before its start runs over midnight.
+type BackgroundTaskWindow struct {
+	Start time.Duration // Offset from midnight
+	End   time.Duration // Offset from midnight
+}
+
+// Returns true if the time is within the window.
+func (w BackgroundTaskWindow) contains(t time.Time) bool {
+	t = t.UTC()
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	if w.Start <= w.End {
+		return offset >= w.Start && offset < w.End
+	}
+	return offset >= w.Start || offset < w.End
+}
+
+type BackgroundTaskOptions struct {
+	MaxConcurrent int                    // Max number of the database's tasks that run at once on this node
+	Windows       []BackgroundTaskWindow // When scheduled tasks may start.  If empty, they start whenever they're due
+}
+
+// BackgroundTaskStatus is the state of a background task, as listed and persisted.
+type BackgroundTaskStatus struct {
+	ID        string              `json:"id"`
+	Name      string              `json:"name"`
+	Node      string              `json:"node"`                // Host the task runs on
+	State     BackgroundTaskState `json:"state"`               //
+	Scheduled bool                `json:"scheduled,omitempty"` // Whether it was started by its schedule, rather than by an admin request
+	Processed int                 `json:"processed"`           // Number of items, such as documents, processed so far
+	Created   time.Time           `json:"created"`             //
+	Started   *time.Time          `json:"started,omitempty"`   //
+	Finished  *time.Time          `json:"finished,omitempty"`  //
+	Error     string              `json:"error,omitempty"`     //
+	Result    interface{}         `json:"result,omitempty"`    // What the task returned, such as the number of documents changed
+}
+
+type backgroundTasksDoc struct {
+	Tasks map[string]*BackgroundTaskStatus `json:"tasks"` // By task ID
+}
+
+// BackgroundTaskFunc is the work of a background task.  It's given a Database whose Ctx identifies the task, and should
+// call backgroundTaskCheckpoint with it for each item it processes, so the task can be paused and cancelled.
+type BackgroundTaskFunc func(db *Database) (result interface{}, err error)
+
+type backgroundTask struct {
+	status    BackgroundTaskStatus // Protected by the manager's lock
+	fn        BackgroundTaskFunc
+	cancelled chan struct{} // Closed when the task is cancelled
+	done      chan struct{} // Closed when the task has finished
+	err       error         // The task's error, once it's done
+}
+
+type backgroundTaskContextKey struct{}
+
+// BackgroundTaskManager runs a database's maintenance work, such as compaction, resync and tombstone purge, as tasks
+// that can be listed, paused and cancelled from the admin API.  At most MaxConcurrent tasks run at once on each node;
+// the others are queued.  Scheduled tasks only start within the scheduling windows, while tasks requested by an admin
+// start straight away.  Task state is persisted in the metadata bucket, so the database's other nodes list it too.
+type BackgroundTaskManager struct {
+	context    *DatabaseContext
+	node       string
+	windows    []BackgroundTaskWindow
+	slots      chan struct{}              // Holds a value for each running task
+	lock       sync.Mutex                 // Protects tasks, and the status of each
+	cond       *sync.Cond                 // Signalled when a task is resumed or cancelled
+	tasks      map[string]*backgroundTask // This node's tasks that haven't finished, by ID
+	terminator chan struct{}              // Stops the schedules
+}
+
+func newBackgroundTaskManager(context *DatabaseContext, options BackgroundTaskOptions) *BackgroundTaskManager {
+	maxConcurrent := options.MaxConcurrent
+	if maxConcurrent <= 0 {
+		maxConcurrent = DefaultBackgroundTaskMaxConcurrent
+	}
+	node, _ := os.Hostname()
+	m := &BackgroundTaskManager{
+		context:    context,
+		node:       node,
+		windows:    options.Windows,
+		slots:      make(chan struct{}, maxConcurrent),
+		tasks:      map[string]*backgroundTask{},
+		terminator: make(chan struct{}),
+	}
+	m.cond = sync.NewCond(&m.lock)
+	m.markInterrupted()
+	return m
+}
+
+// Submits a task, which runs once there's a free slot.  Returns its ID.
+func (m *BackgroundTaskManager) Start(name string, fn BackgroundTaskFunc) string {
+	return m.start(name, false, fn).status.ID
+}
+
+// Runs a task to completion, returning its result.
+func (m *BackgroundTaskManager) Run(name string, fn BackgroundTaskFunc) (result interface{}, err error) {
+	task := m.start(name, false, fn)
+	<-task.done
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return task.status.Result, task.err
+}
+
+func (m *BackgroundTaskManager) start(name string, scheduled bool, fn BackgroundTaskFunc) *backgroundTask {
+	task := &backgroundTask{
+		status: BackgroundTaskStatus{
+			ID:        base.CreateUUID(),
+			Name:      name,
+			Node:      m.node,
+			State:     BackgroundTaskQueued,
+			Scheduled: scheduled,
+			Created:   time.Now().UTC(),
+		},
+		fn:        fn,
+		cancelled: make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	m.lock.Lock()
+	m.tasks[task.status.ID] = task
+	m.lock.Unlock()
+	m.persist(task)
+	go m.run(task)
+	return task
+}
+
+func (m *BackgroundTaskManager) run(task *backgroundTask) {
+	select {
+	case m.slots <- struct{}{}:
+		defer func() { <-m.slots }()
+	case <-task.cancelled:
+		m.finish(task, nil, errBackgroundTaskCancelled)
+		return
+	}
+
+	m.lock.Lock()
+	now := time.Now().UTC()
+	task.status.Started = &now
+	if task.status.State == BackgroundTaskQueued {
+		task.status.State = BackgroundTaskRunning
+	}
+	m.lock.Unlock()
+	m.persist(task)
+	base.Infof(base.KeyAll, "Database %s: Starting background task %s (%s)", base.MD(m.context.Name), task.status.Name, task.status.ID)
+
+	ctx := context.WithValue(context.Background(), backgroundTaskContextKey{}, &backgroundTaskRef{manager: m, task: task})
+	result, err := task.fn(&Database{DatabaseContext: m.context, Ctx: ctx})
+	m.finish(task, result, err)
+}
+
+func (m *BackgroundTaskManager) finish(task *backgroundTask, result interface{}, err error) {
+	m.lock.Lock()
+	now := time.Now().UTC()
+	task.status.Finished = &now
+	task.status.Result = result
+	task.err = err
+	switch {
+	case err == errBackgroundTaskCancelled:
+		task.status.State = BackgroundTaskCancelled
+	case err != nil:
+		task.status.State = BackgroundTaskFailed
+		task.status.Error = err.Error()
+	default:
+		task.status.State = BackgroundTaskCompleted
+	}
+	delete(m.tasks, task.status.ID)
+	m.lock.Unlock()
+	m.persist(task)
+	close(task.done)
+	base.Infof(base.KeyAll, "Database %s: Background task %s (%s) %s", base.MD(m.context.Name), task.status.Name, task.status.ID, task.status.State)
+}
+
+// Writes a task's status to the persisted task list, pruning the oldest finished tasks.
+func (m *BackgroundTaskManager) persist(task *backgroundTask) {
+	m.lock.Lock()
+	status := task.status
+	m.lock.Unlock()
+	err := m.updateTasksDoc(func(tasks map[string]*BackgroundTaskStatus) {
+		tasks[status.ID] = &status
+	})
+	if err != nil {
+		base.Warnf(base.KeyAll, "Database %s: Unable to persist the state of background task %s: %v", base.MD(m.context.Name), status.ID, err)
+	}
+}
+
+func (m *BackgroundTaskManager) updateTasksDoc(change func(tasks map[string]*BackgroundTaskStatus)) error {
+	_, err := m.context.MetadataBucket.Update(kBackgroundTasksKey, 0, func(currentValue []byte) ([]byte, *uint32, error) {
+		var doc backgroundTasksDoc
+		if len(currentValue) > 0 {
+			if err := json.Unmarshal(currentValue, &doc); err != nil {
+				return nil, nil, err
+			}
+		}
+		if doc.Tasks == nil {
+			doc.Tasks = map[string]*BackgroundTaskStatus{}
+		}
+		change(doc.Tasks)
+
+		var finished []*BackgroundTaskStatus
+		for _, status := range doc.Tasks {
+			if status.State.finished() {
+				finished = append(finished, status)
+			}
+		}
+		if len(finished) > kBackgroundTaskHistory {
+			sortBackgroundTasks(finished)
+			for _, status := range finished[kBackgroundTaskHistory:] {
+				delete(doc.Tasks, status.ID)
+			}
+		}
+		updated, err := json.Marshal(doc)
+		return updated, nil, err
+	})
+	return err
+}
+
+// Marks the tasks this node left unfinished when it last stopped as interrupted.
+func (m *BackgroundTaskManager) markInterrupted() {
+	err := m.updateTasksDoc(func(tasks map[string]*BackgroundTaskStatus) {
+		for _, status := range tasks {
+			if status.Node == m.node && !status.State.finished() {
+				status.State = BackgroundTaskInterrupted
+			}
+		}
+	})
+	if err != nil {
+		base.Warnf(base.KeyAll, "Database %s: Unable to update the state of interrupted background tasks: %v", base.MD(m.context.Name), err)
+	}
+}
+
+// Sorts tasks newest first.
+func sortBackgroundTasks(tasks []*BackgroundTaskStatus) {
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].Created.After(tasks[j].Created) })
+}
+
+// Returns the state of the database's tasks on all of its nodes, newest first.
+func (m *BackgroundTaskManager) List() ([]*BackgroundTaskStatus, error) {
+	var doc backgroundTasksDoc
+	if _, err := m.context.MetadataBucket.Get(kBackgroundTasksKey, &doc); err != nil && !base.IsDocNotFoundError(err) {
+		return nil, err
+	}
+	if doc.Tasks == nil {
+		doc.Tasks = map[string]*BackgroundTaskStatus{}
+	}
+
+	// This node's own tasks may have moved on since they were persisted
+	m.lock.Lock()
+	for id, task := range m.tasks {
+		status := task.status
+		doc.Tasks[id] = &status
+	}
+	m.lock.Unlock()
+
+	tasks := make([]*BackgroundTaskStatus, 0, len(doc.Tasks))
+	for _, status := range doc.Tasks {
+		tasks = append(tasks, status)
+	}
+	sortBackgroundTasks(tasks)
+	return tasks, nil
+}
+
+// Returns one of this node's unfinished tasks, or an error if it isn't one.  Requires the lock.
+func (m *BackgroundTaskManager) _getTask(id string) (*backgroundTask, error) {
+	if task, ok := m.tasks[id]; ok {
+		return task, nil
+	}
+	return nil, base.HTTPErrorf(http.StatusNotFound, "No unfinished task %q on this node", id)
+}
+
+// Pauses a task at the next item it processes.  A paused task keeps its slot.
+func (m *BackgroundTaskManager) Pause(id string) error {
+	m.lock.Lock()
+	task, err := m._getTask(id)
+	if err == nil {
+		task.status.State = BackgroundTaskPaused
+	}
+	m.lock.Unlock()
+	if err == nil {
+		m.persist(task)
+	}
+	return err
+}
+
+// Resumes a paused task.
+func (m *BackgroundTaskManager) Resume(id string) error {
+	m.lock.Lock()
+	task, err := m._getTask(id)
+	if err == nil {
+		if task.status.State != BackgroundTaskPaused {
+			err = base.HTTPErrorf(http.StatusConflict, "Task isn't paused")
+		} else if task.status.Started == nil {
+			task.status.State = BackgroundTaskQueued
+		} else {
+			task.status.State = BackgroundTaskRunning
+		}
+		m.cond.Broadcast()
+	}
+	m.lock.Unlock()
+	if err == nil {
+		m.persist(task)
+	}
+	return err
+}
+
+// Cancels a task, which stops at the next item it processes.
+func (m *BackgroundTaskManager) Cancel(id string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	task, err := m._getTask(id)
+	if err != nil {
+		return err
+	}
+	m._cancel(task)
+	return nil
+}
+
+// Requires the lock.
+func (m *BackgroundTaskManager) _cancel(task *backgroundTask) {
+	select {
+	case <-task.cancelled:
+	default:
+		close(task.cancelled)
+	}
+	m.cond.Broadcast()
+}
+
+// Runs a task at the given frequency until the database is closed.  When a run is due outside of the scheduling
+// windows, it waits until one opens.  Runs don't overlap.
+func (m *BackgroundTaskManager) schedule(name string, frequency time.Duration, fn BackgroundTaskFunc) {
+	go func() {
+		for {
+			select {
+			case <-time.After(frequency):
+			case <-m.terminator:
+				return
+			}
+			for !m.inWindow(time.Now()) {
+				select {
+				case <-time.After(backgroundTaskWindowCheckInterval):
+				case <-m.terminator:
+					return
+				}
+			}
+			task := m.start(name, true, fn)
+			select {
+			case <-task.done:
+			case <-m.terminator:
+				return
+			}
+		}
+	}()
+}
+
+// Returns true if scheduled tasks may start at the given time.
+func (m *BackgroundTaskManager) inWindow(t time.Time) bool {
+	if len(m.windows) == 0 {
+		return true
+	}
+	for _, window := range m.windows {
+		if window.contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Stops the schedules and cancels this node's tasks.
+func (m *BackgroundTaskManager) stop() {
+	if m == nil {
+		return
+	}
+	close(m.terminator)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, task := range m.tasks {
+		m._cancel(task)
+	}
+}
+
+// Identifies the task a Database runs, in its Ctx.
+type backgroundTaskRef struct {
+	manager      *BackgroundTaskManager
+	task         *backgroundTask
+	accessLocked bool // Whether the task holds a read lock on the database's AccessLock.  Protected by the manager's lock
+}
+
+func getBackgroundTaskRef(ctx context.Context) *backgroundTaskRef {
+	if ctx == nil {
+		return nil
+	}
+	ref, _ := ctx.Value(backgroundTaskContextKey{}).(*backgroundTaskRef)
+	return ref
+}
+
+// Takes a read lock on the database's AccessLock, so that it stays online while a task works on it.  Returns false,
+// without the lock, if the database isn't online.  When the Database runs a task, backgroundTaskCheckpoint releases
+// the lock while the task is paused, so that a paused task doesn't stop the database being taken offline.
+func backgroundTaskLockOnline(db *Database) bool {
+	db.AccessLock.RLock()
+	if atomic.LoadUint32(&db.State) != DBOnline {
+		db.AccessLock.RUnlock()
+		return false
+	}
+	if ref := getBackgroundTaskRef(db.Ctx); ref != nil {
+		ref.manager.lock.Lock()
+		ref.accessLocked = true
+		ref.manager.lock.Unlock()
+	}
+	return true
+}
+
+// Releases the lock taken by backgroundTaskLockOnline.
+func backgroundTaskUnlock(db *Database) {
+	if ref := getBackgroundTaskRef(db.Ctx); ref != nil {
+		ref.manager.lock.Lock()
+		ref.accessLocked = false
+		ref.manager.lock.Unlock()
+	}
+	db.AccessLock.RUnlock()
+}
+
+// Called by a task for each item it processes.  Counts the item, blocks while the task is paused, and returns an
+// error if the task has been cancelled, or the database went offline while the task was paused, which the task
+// should stop and return.  Does nothing if the context isn't a task's.
+func backgroundTaskCheckpoint(ctx context.Context) error {
+	ref := getBackgroundTaskRef(ctx)
+	if ref == nil {
+		return nil
+	}
+	m, task := ref.manager, ref.task
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for task.status.State == BackgroundTaskPaused && !isClosed(task.cancelled) {
+		if !ref.accessLocked {
+			m.cond.Wait()
+			continue
+		}
+
+		// Don't hold the AccessLock while paused.  It's taken again without the manager's lock, as stop needs that
+		// while the database is being closed.
+		m.context.AccessLock.RUnlock()
+		for task.status.State == BackgroundTaskPaused && !isClosed(task.cancelled) {
+			m.cond.Wait()
+		}
+		m.lock.Unlock()
+		m.context.AccessLock.RLock()
+		m.lock.Lock()
+		if atomic.LoadUint32(&m.context.State) != DBOnline {
+			return errBackgroundTaskOffline
+		}
+	}
+	if isClosed(task.cancelled) {
+		return errBackgroundTaskCancelled
+	}
+	task.status.Processed++
+	return nil
+}
+
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+// Parses a scheduling window of the form HH:MM-HH:MM, in UTC.
+func ParseBackgroundTaskWindow(window string) (BackgroundTaskWindow, error) {
+	var start, end time.Time
+	var err error
+	if len(window) != len("00:00-00:00") || window[5] != '-' {
+		return BackgroundTaskWindow{}, errors.New("must be of the form HH:MM-HH:MM")
+	}
+	if start, err = time.Parse("15:04", window[:5]); err != nil {
+		return BackgroundTaskWindow{}, errors.New("must be of the form HH:MM-HH:MM")
+	}
+	if end, err = time.Parse("15:04", window[6:]); err != nil {
+		return BackgroundTaskWindow{}, errors.New("must be of the form HH:MM-HH:MM")
+	}
+	midnight := time.Date(0, 1, 1, 0, 0, 0, 0, time.UTC)
+	return BackgroundTaskWindow{Start: start.Sub(midnight), End: end.Sub(midnight)}, nil
+}
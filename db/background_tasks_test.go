@@ -0,0 +1,216 @@
+package db
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseBackgroundTaskWindow(t *testing.T) {
+	window, err := ParseBackgroundTaskWindow("01:30-05:00")
+	assert.NoError(t, err)
+	assert.Equal(t, BackgroundTaskWindow{Start: 90 * time.Minute, End: 5 * time.Hour}, window)
+
+	for _, invalid := range []string{"", "1:30-05:00", "01:30 05:00", "01:30-25:00", "01:30-05:00-06:00"} {
+		_, err = ParseBackgroundTaskWindow(invalid)
+		assert.Error(t, err, "window %q", invalid)
+	}
+}
+
+func TestBackgroundTaskWindowContains(t *testing.T) {
+	at := func(hour, minute int) time.Time {
+		return time.Date(2018, 6, 1, hour, minute, 0, 0, time.UTC)
+	}
+	window := BackgroundTaskWindow{Start: time.Hour, End: 5 * time.Hour}
+	assert.False(t, window.contains(at(0, 59)))
+	assert.True(t, window.contains(at(1, 0)))
+	assert.True(t, window.contains(at(4, 59)))
+	assert.False(t, window.contains(at(5, 0)))
+
+	// A window that runs over midnight
+	window = BackgroundTaskWindow{Start: 22 * time.Hour, End: 2 * time.Hour}
+	assert.True(t, window.contains(at(23, 0)))
+	assert.True(t, window.contains(at(1, 0)))
+	assert.False(t, window.contains(at(12, 0)))
+}
+
+func TestBackgroundTaskRun(t *testing.T) {
+	db, testBucket := setupTestDB(t)
+	defer testBucket.Close()
+	defer tearDownTestDB(t, db)
+
+	result, err := db.BackgroundTasks.Run("test", func(taskDB *Database) (interface{}, error) {
+		for i := 0; i < 3; i++ {
+			if err := backgroundTaskCheckpoint(taskDB.Ctx); err != nil {
+				return nil, err
+			}
+		}
+		return 3, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, result)
+
+	// The finished task is persisted
+	tasks, err := db.BackgroundTasks.List()
+	assert.NoError(t, err)
+	if assert.Len(t, tasks, 1) {
+		assert.Equal(t, "test", tasks[0].Name)
+		assert.Equal(t, BackgroundTaskCompleted, tasks[0].State)
+		assert.Equal(t, 3, tasks[0].Processed)
+		assert.NotNil(t, tasks[0].Finished)
+	}
+}
+
+func TestBackgroundTaskQueueing(t *testing.T) {
+	db, testBucket := setupTestDB(t)
+	defer testBucket.Close()
+	defer tearDownTestDB(t, db)
+
+	release := make(chan struct{})
+	firstID := db.BackgroundTasks.Start("first", func(taskDB *Database) (interface{}, error) {
+		<-release
+		return nil, nil
+	})
+	secondID := db.BackgroundTasks.Start("second", func(taskDB *Database) (interface{}, error) {
+		return nil, nil
+	})
+
+	// Only one task runs at once by default
+	waitForTaskState(t, db.BackgroundTasks, firstID, BackgroundTaskRunning)
+	assert.Equal(t, BackgroundTaskQueued, backgroundTaskStates(t, db.BackgroundTasks)[secondID])
+
+	close(release)
+	waitForTaskState(t, db.BackgroundTasks, secondID, BackgroundTaskCompleted)
+	assert.Equal(t, BackgroundTaskCompleted, backgroundTaskStates(t, db.BackgroundTasks)[firstID])
+}
+
+func TestBackgroundTaskPauseAndCancel(t *testing.T) {
+	db, testBucket := setupTestDB(t)
+	defer testBucket.Close()
+	defer tearDownTestDB(t, db)
+
+	// The task processes an item each time it's stepped
+	step := make(chan struct{})
+	processed := make(chan struct{})
+	taskID := db.BackgroundTasks.Start("test", func(taskDB *Database) (interface{}, error) {
+		for {
+			<-step
+			if err := backgroundTaskCheckpoint(taskDB.Ctx); err != nil {
+				return nil, err
+			}
+			processed <- struct{}{}
+		}
+	})
+	step <- struct{}{}
+	<-processed
+
+	assert.NoError(t, db.BackgroundTasks.Pause(taskID))
+	assertHTTPError(t, db.BackgroundTasks.Pause("nonexistent"), 404)
+	step <- struct{}{}
+	select {
+	case <-processed:
+		t.Fatal("Paused task processed an item")
+	case <-time.After(50 * time.Millisecond):
+	}
+	assert.Equal(t, BackgroundTaskPaused, backgroundTaskStates(t, db.BackgroundTasks)[taskID])
+
+	assert.NoError(t, db.BackgroundTasks.Resume(taskID))
+	<-processed
+	assertHTTPError(t, db.BackgroundTasks.Resume(taskID), 409)
+
+	assert.NoError(t, db.BackgroundTasks.Cancel(taskID))
+	step <- struct{}{}
+	waitForTaskState(t, db.BackgroundTasks, taskID, BackgroundTaskCancelled)
+
+	// Finished tasks can't be cancelled again
+	assertHTTPError(t, db.BackgroundTasks.Cancel(taskID), 404)
+}
+
+// A paused task that holds the database's AccessLock releases it, so the database can be taken offline.
+func TestBackgroundTaskPauseReleasesAccessLock(t *testing.T) {
+	db, testBucket := setupTestDB(t)
+	defer testBucket.Close()
+	defer tearDownTestDB(t, db)
+
+	step := make(chan struct{})
+	processed := make(chan struct{})
+	taskID := db.BackgroundTasks.Start("test", func(taskDB *Database) (interface{}, error) {
+		if !backgroundTaskLockOnline(taskDB) {
+			return nil, nil
+		}
+		defer backgroundTaskUnlock(taskDB)
+		for {
+			<-step
+			if err := backgroundTaskCheckpoint(taskDB.Ctx); err != nil {
+				return nil, err
+			}
+			processed <- struct{}{}
+		}
+	})
+	step <- struct{}{}
+	<-processed
+
+	assert.NoError(t, db.BackgroundTasks.Pause(taskID))
+	step <- struct{}{}
+	waitForTaskState(t, db.BackgroundTasks, taskID, BackgroundTaskPaused)
+
+	offline := make(chan struct{})
+	go func() {
+		db.AccessLock.Lock()
+		atomic.StoreUint32(&db.State, DBOffline)
+		db.AccessLock.Unlock()
+		close(offline)
+	}()
+	select {
+	case <-offline:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Paused task blocked taking the database offline")
+	}
+
+	// Once resumed, the task fails as the database is no longer online
+	assert.NoError(t, db.BackgroundTasks.Resume(taskID))
+	waitForTaskState(t, db.BackgroundTasks, taskID, BackgroundTaskFailed)
+	atomic.StoreUint32(&db.State, DBOnline)
+}
+
+func TestBackgroundTaskInterrupted(t *testing.T) {
+	db, testBucket := setupTestDB(t)
+	defer testBucket.Close()
+	defer tearDownTestDB(t, db)
+
+	// A task left running by a previous run of this node, and one running on another node
+	err := db.BackgroundTasks.updateTasksDoc(func(tasks map[string]*BackgroundTaskStatus) {
+		tasks["previous"] = &BackgroundTaskStatus{ID: "previous", Name: "test", Node: db.BackgroundTasks.node, State: BackgroundTaskRunning}
+		tasks["other"] = &BackgroundTaskStatus{ID: "other", Name: "test", Node: "other-node", State: BackgroundTaskRunning}
+	})
+	assert.NoError(t, err)
+
+	manager := newBackgroundTaskManager(db.DatabaseContext, BackgroundTaskOptions{})
+	defer manager.stop()
+	states := backgroundTaskStates(t, manager)
+	assert.Equal(t, BackgroundTaskInterrupted, states["previous"])
+	assert.Equal(t, BackgroundTaskRunning, states["other"])
+}
+
+// Returns the state of each of the listed tasks, by ID.
+func backgroundTaskStates(t *testing.T, manager *BackgroundTaskManager) map[string]BackgroundTaskState {
+	tasks, err := manager.List()
+	assert.NoError(t, err)
+	states := map[string]BackgroundTaskState{}
+	for _, task := range tasks {
+		states[task.ID] = task.State
+	}
+	return states
+}
+
+func waitForTaskState(t *testing.T, manager *BackgroundTaskManager, taskID string, state BackgroundTaskState) {
+	for i := 0; i < 100; i++ {
+		if backgroundTaskStates(t, manager)[taskID] == state {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Task %s didn't reach state %s", taskID, state)
+}
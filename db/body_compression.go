@@ -0,0 +1,109 @@
+package db
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+
+	"github.com/couchbase/sync_gateway/base"
+	pkgerrors "github.com/pkg/errors"
+)
+
+// Default size above which document bodies are stored compressed, when body compression is enabled
+const DefaultBodyCompressionMinBytes = 16 * 1024
+
+// Value of the sync metadata's body_encoding property for bodies stored gzip-compressed
+const BodyEncodingGzip = "gzip"
+
+// Property a compressed body is stored in, in place of the body's own properties.  The value is the base64 encoding
+// of the gzipped body JSON.
+const bodyCompressedProperty = "_compressed_body"
+
+type BodyCompressionOptions struct {
+	Enabled  bool // Whether large document bodies are stored compressed
+	MinBytes int  // Bodies whose JSON is smaller than this are stored uncompressed
+}
+
+// Marshals a document for storage with its sync metadata in the _sync property, compressing its body if body
+// compression is enabled and the body is large enough.  Only Sync Gateway can read compressed bodies, so it's not
+// enabled along with xattrs, queries or user views, and PutDesignDoc rejects views while it is.
+func (context *DatabaseContext) marshalDocument(doc *document) ([]byte, error) {
+	if !context.Options.BodyCompressionOptions.Enabled {
+		return json.Marshal(doc)
+	}
+	return doc.marshalCompressed(context.Options.BodyCompressionOptions.MinBytes)
+}
+
+// Marshals the document with its body gzipped, unless the body's JSON is smaller than minBytes or doesn't compress
+// enough to make up for the base64 encoding.  Compressed documents are flagged in their sync metadata, so
+// UnmarshalJSON knows to decompress them.  The body stays at the top level of uncompressed documents, so they can
+// still be read by other clients of the bucket.
+func (doc *document) marshalCompressed(minBytes int) ([]byte, error) {
+	if len(doc._body) == 0 {
+		return json.Marshal(doc)
+	}
+	bodyJSON, err := json.Marshal(doc._body)
+	if err != nil {
+		return nil, pkgerrors.WithStack(base.RedactErrorf("Failed to marshal body of doc with id: %s.  Error: %v", base.UDDocID(doc.ID), err))
+	}
+	if len(bodyJSON) < minBytes {
+		return json.Marshal(doc)
+	}
+
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	if _, err := gz.Write(bodyJSON); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	if compressed.Len()*4/3 >= len(bodyJSON) {
+		return json.Marshal(doc)
+	}
+
+	doc.BodyEncoding = BodyEncodingGzip
+	data, err := json.Marshal(map[string]interface{}{
+		"_sync":                &doc.syncData,
+		bodyCompressedProperty: compressed.Bytes(),
+	})
+	doc.BodyEncoding = ""
+	if err != nil {
+		return nil, pkgerrors.WithStack(base.RedactErrorf("Failed to marshal compressed doc with id: %s.  Error: %v", base.UDDocID(doc.ID), err))
+	}
+	base.Debugf(base.KeyCRUD, "Compressed body of doc %q from %d to %d bytes", base.UDDocID(doc.ID), len(bodyJSON), compressed.Len())
+	return data, nil
+}
+
+// Replaces the compressed body read by UnmarshalJSON with the body's own properties.
+func (doc *document) decompressBody() error {
+	if doc.BodyEncoding != BodyEncodingGzip {
+		return base.RedactErrorf("Unknown body_encoding %q of doc with id: %s", doc.BodyEncoding, base.UDDocID(doc.ID))
+	}
+	encoded, ok := doc._body[bodyCompressedProperty].(string)
+	if !ok {
+		return errors.New("Compressed document has no " + bodyCompressedProperty + " property")
+	}
+	compressed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return err
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return err
+	}
+	bodyJSON, err := ioutil.ReadAll(gz)
+	if err != nil {
+		return err
+	}
+	var body Body
+	if err := body.Unmarshal(bodyJSON); err != nil {
+		return err
+	}
+	doc._body = body
+	doc.BodyEncoding = "" // The body's no longer encoded once it's in memory
+	return nil
+}
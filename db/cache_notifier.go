@@ -0,0 +1,258 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbase/sync_gateway/channels"
+)
+
+// Key of the doc the database's nodes register their URLs in, so they can notify each other of their writes.
+const kCacheNotifyNodesKey = KSyncKeyPrefix + "cacheNotifyNodes"
+
+// How often each node renews its registration and reloads the other nodes' URLs.  A node that stops renewing is
+// dropped after three intervals.
+const kCacheNotifyHeartbeatInterval = 10 * time.Second
+
+// How long a node collects its writes before notifying the other nodes of them, so a burst of writes is sent in one
+// request to each node
+const kCacheNotifyBatchInterval = 5 * time.Millisecond
+
+// Max number of writes waiting to be sent.  Writes beyond it aren't sent, as the other nodes' feeds will deliver them.
+const kCacheNotifyMaxPending = 1000
+
+// Timeout of a request notifying another node
+const kCacheNotifyTimeout = 2 * time.Second
+
+// Header a node sends the shared secret in, so the other nodes know its notifications are genuine
+const CacheNotifySecretHeader = "X-Cache-Notify-Secret"
+
+type CacheNotifyOptions struct {
+	Enabled      bool
+	AdvertiseURL string // URL of the database on this node's admin API, which the other nodes send notifications to
+	Secret       string // Shared by the database's nodes, which only accept notifications sent with it
+}
+
+// CacheNotification tells a node about a document revision another node wrote, so that it can add the revision to
+// its channel caches without waiting for its feed to deliver it.  It has the document's sync metadata that the
+// channel caches use.
+type CacheNotification struct {
+	DocID           string              `json:"id"`
+	RevID           string              `json:"rev"`
+	Sequence        uint64              `json:"seq"`
+	Flags           uint8               `json:"flags,omitempty"`
+	Channels        channels.ChannelMap `json:"channels,omitempty"`
+	RecentSequences []uint64            `json:"recent_sequences,omitempty"`
+	UnusedSequences []uint64            `json:"unused_sequences,omitempty"`
+	TimeSaved       time.Time           `json:"time_saved"`
+}
+
+type cacheNotifyNode struct {
+	URL     string    `json:"url"`
+	Expires time.Time `json:"expires"`
+}
+
+type cacheNotifyNodesDoc struct {
+	Nodes map[string]*cacheNotifyNode `json:"nodes"` // Registered nodes, by node ID
+}
+
+// cacheNotifier sends the revisions this node writes to the database's other nodes, which add them to their channel
+// caches straight away.  Each revision still arrives on every node's feed, where it's ignored as a duplicate if a
+// notification got there first, so a lost notification only costs latency.  Nodes find each other through a
+// registry doc in the metadata bucket.  All methods are safe to call on a nil *cacheNotifier, which sends nothing.
+type cacheNotifier struct {
+	context    *DatabaseContext
+	nodeID     string
+	url        string
+	secret     string
+	client     *http.Client
+	lock       sync.Mutex           // Protects peers and pending
+	peers      []string             // URLs of the other nodes
+	pending    []*CacheNotification // Writes not sent yet
+	wake       chan struct{}        // Signals that there are writes to send
+	terminator chan struct{}        // Stops the background tasks
+}
+
+func newCacheNotifier(context *DatabaseContext, options CacheNotifyOptions) *cacheNotifier {
+	n := &cacheNotifier{
+		context:    context,
+		nodeID:     base.CreateUUID(),
+		url:        strings.TrimSuffix(options.AdvertiseURL, "/"),
+		secret:     options.Secret,
+		client:     &http.Client{Timeout: kCacheNotifyTimeout},
+		wake:       make(chan struct{}, 1),
+		terminator: make(chan struct{}),
+	}
+	n.register()
+	go func() {
+		for {
+			select {
+			case <-time.After(kCacheNotifyHeartbeatInterval):
+				n.register()
+			case <-n.terminator:
+				return
+			}
+		}
+	}()
+	go func() {
+		for {
+			select {
+			case <-n.wake:
+				time.Sleep(kCacheNotifyBatchInterval)
+				n.send()
+			case <-n.terminator:
+				return
+			}
+		}
+	}()
+	return n
+}
+
+// Updates this node's registration, removing any nodes whose registrations have expired, and with it the list of
+// other nodes.  If expire is true, this node's registration is removed instead.
+func (n *cacheNotifier) updateRegistration(expire bool) {
+	var peers []string
+	_, err := n.context.MetadataBucket.Update(kCacheNotifyNodesKey, 0, func(currentValue []byte) ([]byte, *uint32, error) {
+		var registry cacheNotifyNodesDoc
+		if len(currentValue) > 0 {
+			if err := json.Unmarshal(currentValue, &registry); err != nil {
+				return nil, nil, err
+			}
+		}
+		if registry.Nodes == nil {
+			registry.Nodes = map[string]*cacheNotifyNode{}
+		}
+		now := time.Now().UTC()
+		peers = peers[:0]
+		for nodeID, node := range registry.Nodes {
+			if node.Expires.Before(now) {
+				delete(registry.Nodes, nodeID)
+			} else if nodeID != n.nodeID {
+				peers = append(peers, node.URL)
+			}
+		}
+		if expire {
+			delete(registry.Nodes, n.nodeID)
+		} else {
+			registry.Nodes[n.nodeID] = &cacheNotifyNode{URL: n.url, Expires: now.Add(3 * kCacheNotifyHeartbeatInterval)}
+		}
+		updated, err := json.Marshal(registry)
+		return updated, nil, err
+	})
+	if err != nil {
+		base.Warnf(base.KeyAll, "Unable to update cache notification registration of db %s: %v", base.MD(n.context.Name), err)
+		return
+	}
+	n.lock.Lock()
+	n.peers = peers
+	n.lock.Unlock()
+}
+
+func (n *cacheNotifier) register() {
+	n.updateRegistration(false)
+}
+
+// Queues a document revision this node has written, to be sent to the other nodes.
+func (n *cacheNotifier) docChanged(docID string, syncData *syncData) {
+	if n == nil {
+		return
+	}
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if len(n.peers) == 0 || len(n.pending) >= kCacheNotifyMaxPending {
+		return
+	}
+	n.pending = append(n.pending, &CacheNotification{
+		DocID:           docID,
+		RevID:           syncData.CurrentRev,
+		Sequence:        syncData.Sequence,
+		Flags:           syncData.Flags,
+		Channels:        syncData.Channels,
+		RecentSequences: syncData.RecentSequences,
+		UnusedSequences: syncData.UnusedSequences,
+		TimeSaved:       syncData.TimeSaved,
+	})
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Sends the pending writes to each of the other nodes.
+func (n *cacheNotifier) send() {
+	n.lock.Lock()
+	pending, peers := n.pending, n.peers
+	n.pending = nil
+	n.lock.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(pending)
+	if err != nil {
+		base.Warnf(base.KeyAll, "Unable to encode cache notifications: %v", err)
+		return
+	}
+	var wg sync.WaitGroup
+	for _, peer := range peers {
+		wg.Add(1)
+		go func(peer string) {
+			defer wg.Done()
+			request, err := http.NewRequest("POST", peer+"/_cache_notify", bytes.NewReader(body))
+			if err != nil {
+				base.Warnf(base.KeyAll, "Unable to create cache notification request for %s: %v", base.MD(peer), err)
+				return
+			}
+			request.Header.Set("Content-Type", "application/json")
+			request.Header.Set(CacheNotifySecretHeader, n.secret)
+			response, err := n.client.Do(request)
+			if err != nil {
+				base.Debugf(base.KeyCache, "Unable to send cache notifications to %s: %v", base.MD(peer), err)
+				return
+			}
+			response.Body.Close()
+			if response.StatusCode != http.StatusOK {
+				base.Debugf(base.KeyCache, "Node %s rejected cache notifications with status %d", base.MD(peer), response.StatusCode)
+			}
+		}(peer)
+	}
+	wg.Wait()
+}
+
+// Stops the background tasks and removes this node's registration.
+func (n *cacheNotifier) stop() {
+	if n == nil {
+		return
+	}
+	close(n.terminator)
+	n.updateRegistration(true)
+}
+
+// ApplyCacheNotifications adds the revisions another node has written to the channel caches.
+func (context *DatabaseContext) ApplyCacheNotifications(notifications []*CacheNotification) {
+	cache, ok := context.changeCache.(*changeCache)
+	if !ok {
+		return
+	}
+	timeReceived := time.Now()
+	for _, notification := range notifications {
+		if notification.Sequence <= cache.getInitialSequence() {
+			continue
+		}
+		base.Debugf(base.KeyCache, "Received #%d from another node (%q / %q)", notification.Sequence, base.UDDocID(notification.DocID), notification.RevID)
+		cache.processSyncData(notification.DocID, &syncData{
+			CurrentRev:      notification.RevID,
+			Sequence:        notification.Sequence,
+			Flags:           notification.Flags,
+			Channels:        notification.Channels,
+			RecentSequences: notification.RecentSequences,
+			UnusedSequences: notification.UnusedSequences,
+			TimeSaved:       notification.TimeSaved,
+		}, timeReceived)
+	}
+}
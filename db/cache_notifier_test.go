@@ -0,0 +1,83 @@
+package db
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/couchbase/sync_gateway/channels"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyCacheNotifications(t *testing.T) {
+	db, testBucket := setupTestDB(t)
+	defer testBucket.Close()
+	defer tearDownTestDB(t, db)
+
+	lastSeq, err := db.LastSequence()
+	assert.NoError(t, err)
+	db.ApplyCacheNotifications([]*CacheNotification{{
+		DocID:     "doc1",
+		RevID:     "1-abc",
+		Sequence:  lastSeq + 1,
+		Channels:  channels.ChannelMap{"ABC": nil},
+		TimeSaved: time.Now(),
+	}})
+
+	entries, err := db.changeCache.GetChanges("ABC", ChangesOptions{Since: SequenceID{Seq: 0}})
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "doc1", entries[0].DocID)
+		assert.Equal(t, "1-abc", entries[0].RevID)
+		assert.Equal(t, lastSeq+1, entries[0].Sequence)
+	}
+}
+
+func TestCacheNotifier(t *testing.T) {
+	db, testBucket := setupTestDB(t)
+	defer testBucket.Close()
+	defer tearDownTestDB(t, db)
+
+	received := make(chan []*CacheNotification, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/db/_cache_notify", r.URL.Path)
+		assert.Equal(t, "s3cret", r.Header.Get(CacheNotifySecretHeader))
+		var notifications []*CacheNotification
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&notifications))
+		received <- notifications
+	}))
+	defer server.Close()
+
+	// Nodes only know about each other once they've registered
+	sender := newCacheNotifier(db.DatabaseContext, CacheNotifyOptions{Enabled: true, AdvertiseURL: "http://localhost:1/db", Secret: "s3cret"})
+	receiver := newCacheNotifier(db.DatabaseContext, CacheNotifyOptions{Enabled: true, AdvertiseURL: server.URL + "/db/"})
+	assert.Equal(t, []string{"http://localhost:1/db"}, receiver.peers)
+	sender.register()
+	assert.Equal(t, []string{server.URL + "/db"}, sender.peers)
+
+	sender.docChanged("doc1", &syncData{CurrentRev: "1-abc", Sequence: 5, Channels: channels.ChannelMap{"ABC": nil}})
+	select {
+	case notifications := <-received:
+		if assert.Len(t, notifications, 1) {
+			assert.Equal(t, "doc1", notifications[0].DocID)
+			assert.Equal(t, "1-abc", notifications[0].RevID)
+			assert.Equal(t, uint64(5), notifications[0].Sequence)
+			assert.Contains(t, notifications[0].Channels, "ABC")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Notification wasn't sent")
+	}
+
+	// Stopped nodes are unregistered
+	receiver.stop()
+	sender.register()
+	assert.Len(t, sender.peers, 0)
+	sender.stop()
+
+	// A nil notifier does nothing
+	var notifier *cacheNotifier
+	notifier.docChanged("doc1", &syncData{})
+	notifier.stop()
+}
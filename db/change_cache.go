@@ -21,6 +21,7 @@ const (
 	DefaultCachePendingSeqMaxNum  = 10000            // Max number of waiting sequences
 	DefaultCachePendingSeqMaxWait = 5 * time.Second  // Max time we'll wait for a pending sequence before sending to missed queue
 	DefaultSkippedSeqMaxWait      = 60 * time.Minute // Max time we'll wait for an entry in the missing before purging
+	DefaultSkippedSeqLookupWait   = 1 * time.Minute  // Time we'll wait for an entry in the missing before looking it up
 )
 
 var SkippedSeqCleanViewBatch = 50 // Max number of sequences checked per query during CleanSkippedSequence.  Var to support testing
@@ -60,6 +61,7 @@ type changeCache struct {
 	options         CacheOptions             // Cache config
 	terminator      chan bool                // Signal termination of background goroutines
 	initTime        time.Time                // Cache init time - used for latency calculations
+	savedCaches     map[string]*savedCache   // Saved channel caches not yet restored, by channel name
 }
 
 type LogEntry channels.LogEntry
@@ -80,9 +82,14 @@ type SkippedSequence struct {
 
 type CacheOptions struct {
 	ChannelCacheOptions
-	CachePendingSeqMaxWait time.Duration // Max wait for pending sequence before skipping
-	CachePendingSeqMaxNum  int           // Max number of pending sequences before skipping
-	CacheSkippedSeqMaxWait time.Duration // Max wait for skipped sequence before abandoning
+	CachePendingSeqMaxWait      time.Duration // Max wait for pending sequence before skipping
+	CachePendingSeqMaxNum       int           // Max number of pending sequences before skipping
+	CacheSkippedSeqMaxWait      time.Duration // Max wait for skipped sequence before abandoning
+	CacheSkippedSeqLookupWait   time.Duration // Wait for skipped sequence before looking up its doc, and abandoning it if not found
+	ChannelCachePersistInterval time.Duration // How often to save the channel caches, to restore on restart.  Zero disables
+
+	// Per-channel overrides of the ChannelCacheOptions.  The first override matching a channel's name applies.
+	ChannelCacheOverrides []ChannelCacheOverride
 }
 
 //////// HOUSEKEEPING:
@@ -104,9 +111,10 @@ func (c *changeCache) Init(context *DatabaseContext, notifyChange func(base.Set)
 
 	// init cache options
 	c.options = CacheOptions{
-		CachePendingSeqMaxWait: DefaultCachePendingSeqMaxWait,
-		CachePendingSeqMaxNum:  DefaultCachePendingSeqMaxNum,
-		CacheSkippedSeqMaxWait: DefaultSkippedSeqMaxWait,
+		CachePendingSeqMaxWait:    DefaultCachePendingSeqMaxWait,
+		CachePendingSeqMaxNum:     DefaultCachePendingSeqMaxNum,
+		CacheSkippedSeqMaxWait:    DefaultSkippedSeqMaxWait,
+		CacheSkippedSeqLookupWait: DefaultSkippedSeqLookupWait,
 		ChannelCacheOptions: ChannelCacheOptions{
 			ChannelCacheAge:       DefaultChannelCacheAge,
 			ChannelCacheMinLength: DefaultChannelCacheMinLength,
@@ -127,6 +135,10 @@ func (c *changeCache) Init(context *DatabaseContext, notifyChange func(base.Set)
 			c.options.CacheSkippedSeqMaxWait = options.CacheSkippedSeqMaxWait
 		}
 
+		if options.CacheSkippedSeqLookupWait > 0 {
+			c.options.CacheSkippedSeqLookupWait = options.CacheSkippedSeqLookupWait
+		}
+
 		if options.ChannelCacheAge > 0 {
 			c.options.ChannelCacheAge = options.ChannelCacheAge
 		}
@@ -138,6 +150,12 @@ func (c *changeCache) Init(context *DatabaseContext, notifyChange func(base.Set)
 		if options.ChannelCacheMaxLength > 0 {
 			c.options.ChannelCacheMaxLength = options.ChannelCacheMaxLength
 		}
+
+		if options.ChannelCachePersistInterval > 0 {
+			c.options.ChannelCachePersistInterval = options.ChannelCachePersistInterval
+		}
+
+		c.options.ChannelCacheOverrides = options.ChannelCacheOverrides
 	}
 
 	base.Infof(base.KeyCache, "Initializing changes cache with options %+v", c.options)
@@ -150,8 +168,11 @@ func (c *changeCache) Init(context *DatabaseContext, notifyChange func(base.Set)
 
 	// background tasks that perform housekeeping duties on the cache
 	c.backgroundTask("InsertPendingEntries", c.InsertPendingEntries, c.options.CachePendingSeqMaxWait/2)
-	c.backgroundTask("CleanSkippedSequenceQueue", c.CleanSkippedSequenceQueue, c.options.CacheSkippedSeqMaxWait/2)
-	c.backgroundTask("CleanAgedItems", c.CleanAgedItems, c.options.ChannelCacheAge)
+	c.backgroundTask("CleanSkippedSequenceQueue", c.CleanSkippedSequenceQueue, c.skippedSeqLookupWait()/2)
+	c.backgroundTask("CleanAgedItems", c.CleanAgedItems, c.minChannelCacheAge())
+	if c.options.ChannelCachePersistInterval > 0 {
+		c.backgroundTask("PersistChannelCaches", c.persistChannelCaches, c.options.ChannelCachePersistInterval)
+	}
 
 	// Lock the cache -- not usable until .Start() called.  This fixes the DCP startup race condition documented in SG #3558.
 	c.lock.Lock()
@@ -186,6 +207,9 @@ func (c *changeCache) Start() error {
 	}
 
 	c._setInitialSequence(lastSequence)
+	if c.options.ChannelCachePersistInterval > 0 {
+		c._loadChannelCacheSnapshot()
+	}
 	return nil
 }
 
@@ -196,6 +220,11 @@ func (c *changeCache) Stop() {
 	// their loop
 	close(c.terminator)
 
+	// Save the caches as they are now, for the next time the database is opened
+	if c.options.ChannelCachePersistInterval > 0 {
+		c.persistChannelCaches()
+	}
+
 	c.lock.Lock()
 	c.stopped = true
 	c.logsDisabled = true
@@ -223,6 +252,7 @@ func (c *changeCache) Clear() error {
 	}
 
 	c.channelCaches = make(map[string]*channelCache, 10)
+	c.savedCaches = nil
 	c.pendingLogs = nil
 	heap.Init(&c.pendingLogs)
 
@@ -251,6 +281,18 @@ func (c *changeCache) InsertPendingEntries() {
 	return
 }
 
+// Returns the shortest age entries are kept in any of the channel caches, so CleanAgedItems runs often enough for
+// the channels with overridden ages.
+func (c *changeCache) minChannelCacheAge() time.Duration {
+	age := c.options.ChannelCacheAge
+	for _, override := range c.options.ChannelCacheOverrides {
+		if override.ChannelCacheAge > 0 && override.ChannelCacheAge < age {
+			age = override.ChannelCacheAge
+		}
+	}
+	return age
+}
+
 // CleanAgedItems prunes the caches based on age of items
 func (c *changeCache) CleanAgedItems() {
 	c.lock.Lock()
@@ -264,26 +306,29 @@ func (c *changeCache) CleanAgedItems() {
 }
 
 // Cleanup function, invoked periodically.
-// Removes skipped entries from skippedSeqs that have been waiting longer
-// than MaxChannelLogMissingWaitTime from the queue.  Attempts view retrieval
-// prior to removal.  Only locks skipped sequence queue to build the initial set (GetSkippedSequencesOlderThanMaxWait)
-// and subsequent removal (RemoveSkipped).
+// Late-arriving sequences are waited for in tiers, so that a lost sequence doesn't hold back changes feeds' stable
+// sequence for longer than it takes to find out it's lost: sequences are pending for up to CachePendingSeqMaxWait,
+// then skipped.  Skipped entries that have been waiting longer than CacheSkippedSeqLookupWait are looked up by
+// sequence with a query, and are cached if found or removed from the queue if not.  Sequences whose query fails are
+// retried the next time.  If the query is disabled, skipped entries are removed once they've been waiting longer than
+// CacheSkippedSeqMaxWait.  Only locks skipped sequence queue to build the initial set and subsequent removal
+// (RemoveSkipped).
 func (c *changeCache) CleanSkippedSequenceQueue() {
 
-	oldSkippedSequences := c.GetSkippedSequencesOlderThanMaxWait()
-	if len(oldSkippedSequences) == 0 {
-		return
-	}
-
-	base.Infof(base.KeyCache, "Starting CleanSkippedSequenceQueue, found %d skipped sequences older than max wait for database %s", len(oldSkippedSequences), base.MD(c.context.Name))
-
+	var oldSkippedSequences []uint64
 	var foundEntries []*LogEntry
 	var pendingRemovals []uint64
 
 	if c.context.Options.UnsupportedOptions.DisableCleanSkippedQuery == true {
-		pendingRemovals = append(pendingRemovals, oldSkippedSequences...)
-		oldSkippedSequences = nil
+		pendingRemovals = c.GetSkippedSequencesOlderThanMaxWait()
+	} else {
+		oldSkippedSequences = c.skippedSeqs.getOlderThan(c.skippedSeqLookupWait())
 	}
+	if len(oldSkippedSequences) == 0 && len(pendingRemovals) == 0 {
+		return
+	}
+
+	base.Infof(base.KeyCache, "Starting CleanSkippedSequenceQueue, found %d skipped sequences to look up and %d to abandon for database %s", len(oldSkippedSequences), len(pendingRemovals), base.MD(c.context.Name))
 
 	for len(oldSkippedSequences) > 0 {
 		var skippedSeqBatch []uint64
@@ -316,7 +361,7 @@ func (c *changeCache) CleanSkippedSequenceQueue() {
 		// Add queried sequences not in the resultset to pendingRemovals
 		for _, skippedSeq := range skippedSeqBatch {
 			if _, ok := foundMap[skippedSeq]; !ok {
-				base.Warnf(base.KeyAll, "Skipped Sequence %d didn't show up in MaxChannelLogMissingWaitTime, and isn't available from a * channel query.  If it's a valid sequence, it won't be replicated until Sync Gateway is restarted.", skippedSeq)
+				base.Warnf(base.KeyAll, "Skipped Sequence %d didn't show up in CacheSkippedSeqLookupWait, and isn't available from a * channel query.  If it's a valid sequence, it won't be replicated until Sync Gateway is restarted.", skippedSeq)
 				pendingRemovals = append(pendingRemovals, skippedSeq)
 			}
 		}
@@ -330,7 +375,7 @@ func (c *changeCache) CleanSkippedSequenceQueue() {
 		// view will only have the * channel
 		doc, err := c.context.GetDocument(entry.DocID, DocUnmarshalNoHistory)
 		if err != nil {
-			base.Warnf(base.KeyAll, "Unable to retrieve doc when processing skipped document %q: abandoning sequence %d", base.UD(entry.DocID), entry.Sequence)
+			base.Warnf(base.KeyAll, "Unable to retrieve doc when processing skipped document %q: abandoning sequence %d", base.UDDocID(entry.DocID), entry.Sequence)
 			continue
 		}
 		entry.Channels = doc.Channels
@@ -372,7 +417,6 @@ func (c *changeCache) DocChanged(event sgbucket.FeedEvent) {
 func (c *changeCache) DocChangedSynchronous(event sgbucket.FeedEvent) {
 	docID := string(event.Key)
 	docJSON := event.Value
-	changedChannelsCombined := base.Set{}
 
 	// ** This method does not directly access any state of c, so it doesn't lock.
 	// Is this a user/role doc?
@@ -390,9 +434,13 @@ func (c *changeCache) DocChangedSynchronous(event sgbucket.FeedEvent) {
 		return
 	}
 
-	// If this is a delete and there are no xattrs (no existing SG revision), we can ignore
+	// If this is a delete and there are no xattrs (no existing SG revision), we can ignore - unless the doc expired, in
+	// which case it's replaced with a tombstone
 	if event.Opcode == sgbucket.FeedOpDeletion && len(docJSON) == 0 {
-		base.Debugf(base.KeyImport, "Ignoring delete mutation for %s - no existing Sync Gateway metadata.", base.UD(docID))
+		if !c.context.UseXattrs() && !strings.HasPrefix(docID, KSyncKeyPrefix) && c.context.tombstoneExpiredDoc(docID) {
+			return
+		}
+		base.Debugf(base.KeyImport, "Ignoring delete mutation for %s - no existing Sync Gateway metadata.", base.UDDocID(docID))
 		return
 	}
 
@@ -407,7 +455,7 @@ func (c *changeCache) DocChangedSynchronous(event sgbucket.FeedEvent) {
 	if err != nil {
 		// Avoid log noise related to failed unmarshaling of binary documents.
 		if event.DataType != base.MemcachedDataTypeRaw {
-			base.Debugf(base.KeyCache, "Unable to unmarshal sync metadata for feed document %q.  Will not be included in channel cache.  Error: %v", base.UD(docID), err)
+			base.Debugf(base.KeyCache, "Unable to unmarshal sync metadata for feed document %q.  Will not be included in channel cache.  Error: %v", base.UDDocID(docID), err)
 		}
 		if err == base.ErrEmptyMetadata {
 			base.Warnf(base.KeyAll, "Unexpected empty metadata when processing feed event.  docid: %s opcode: %v datatype:%v", base.UD(event.Key), event.Opcode, event.DataType)
@@ -429,7 +477,12 @@ func (c *changeCache) DocChangedSynchronous(event sgbucket.FeedEvent) {
 			}
 		}
 
-		if syncData == nil || !isSGWrite {
+		// A doc with its metadata in its body rather than the xattr was written by a node without xattrs enabled, as
+		// happens while a bucket's being migrated to xattrs.  Import nodes migrate its metadata to the xattr; other
+		// nodes cache it as it is, since it's still an SG write.  It's migrated the next time it's read or updated.
+		isLegacyWrite := rawXattr == nil && syncData.HasValidSyncData(c.context.writeSequences())
+
+		if (syncData == nil || !isSGWrite) && (c.context.autoImport || !isLegacyWrite) {
 			if c.context.autoImport {
 				// If syncData is nil, or if this was not an SG write, attempt to import
 				isDelete := event.Opcode == sgbucket.FeedOpDeletion
@@ -441,11 +494,11 @@ func (c *changeCache) DocChangedSynchronous(event sgbucket.FeedEvent) {
 				_, err := db.ImportDocRaw(docID, rawBody, rawXattr, isDelete, event.Cas, &event.Expiry, ImportFromFeed)
 				if err != nil {
 					if err == base.ErrImportCasFailure {
-						base.Debugf(base.KeyImport, "Not importing mutation - document %s has been subsequently updated and will be imported based on that mutation.", base.UD(docID))
+						base.Debugf(base.KeyImport, "Not importing mutation - document %s has been subsequently updated and will be imported based on that mutation.", base.UDDocID(docID))
 					} else if err == base.ErrImportCancelledFilter {
 						// No logging required - filter info already logged during importDoc
 					} else {
-						base.Debugf(base.KeyImport, "Did not import doc %q - external update will not be accessible via Sync Gateway.  Reason: %v", base.UD(docID), err)
+						base.Debugf(base.KeyImport, "Did not import doc %q - external update will not be accessible via Sync Gateway.  Reason: %v", base.UDDocID(docID), err)
 					}
 				}
 			}
@@ -457,10 +510,10 @@ func (c *changeCache) DocChangedSynchronous(event sgbucket.FeedEvent) {
 		// No sync metadata found - check whether we're mid-upgrade and attempting to read a doc w/ metadata stored in xattr
 		migratedDoc, _ := c.context.checkForUpgrade(docID)
 		if migratedDoc != nil && migratedDoc.Cas == event.Cas {
-			base.Infof(base.KeyCache, "Found mobile xattr on doc %q without _sync property - caching, assuming upgrade in progress.", base.UD(docID))
+			base.Infof(base.KeyCache, "Found mobile xattr on doc %q without _sync property - caching, assuming upgrade in progress.", base.UDDocID(docID))
 			syncData = &migratedDoc.syncData
 		} else {
-			base.Warnf(base.KeyAll, "changeCache: Doc %q does not have valid sync data.", base.UD(docID))
+			base.Warnf(base.KeyAll, "changeCache: Doc %q does not have valid sync data.", base.UDDocID(docID))
 			return
 		}
 	}
@@ -484,13 +537,22 @@ func (c *changeCache) DocChangedSynchronous(event sgbucket.FeedEvent) {
 		}
 	}
 	c.context.DbStats.StatsDatabase().Add(base.StatKeyDcpReceivedCount, 1)
+	base.Infof(base.KeyCache, "Received #%d after %3dms (%q / %q)", syncData.Sequence, int(feedLatency/time.Millisecond), base.UDDocID(docID), syncData.CurrentRev)
+
+	c.processSyncData(docID, syncData, event.TimeReceived)
+}
+
+// Adds the change entries for a document revision with the given sync metadata, which has come from the feed or
+// from another node that wrote it, and notifies the change listeners.
+func (c *changeCache) processSyncData(docID string, syncData *syncData, timeReceived time.Time) {
+	changedChannelsCombined := base.Set{}
 
 	// If the doc update wasted any sequences due to conflicts, add empty entries for them:
 	for _, seq := range syncData.UnusedSequences {
-		base.Infof(base.KeyCache, "Received unused #%d in _sync.unused_sequences property for (%q / %q)", seq, base.UD(docID), syncData.CurrentRev)
+		base.Infof(base.KeyCache, "Received unused #%d in _sync.unused_sequences property for (%q / %q)", seq, base.UDDocID(docID), syncData.CurrentRev)
 		change := &LogEntry{
 			Sequence:     seq,
-			TimeReceived: event.TimeReceived,
+			TimeReceived: timeReceived,
 		}
 		changedChannels := c.processEntry(change)
 		changedChannelsCombined = changedChannelsCombined.Update(changedChannels)
@@ -510,10 +572,10 @@ func (c *changeCache) DocChangedSynchronous(event sgbucket.FeedEvent) {
 
 		for _, seq := range syncData.RecentSequences {
 			if seq >= c.getNextSequence() && seq < currentSequence {
-				base.Infof(base.KeyCache, "Received deduplicated #%d in _sync.recent_sequences property for (%q / %q)", seq, base.UD(docID), syncData.CurrentRev)
+				base.Infof(base.KeyCache, "Received deduplicated #%d in _sync.recent_sequences property for (%q / %q)", seq, base.UDDocID(docID), syncData.CurrentRev)
 				change := &LogEntry{
 					Sequence:     seq,
-					TimeReceived: event.TimeReceived,
+					TimeReceived: timeReceived,
 				}
 
 				//if the doc was removed from one or more channels at this sequence
@@ -536,12 +598,10 @@ func (c *changeCache) DocChangedSynchronous(event sgbucket.FeedEvent) {
 		DocID:        docID,
 		RevID:        syncData.CurrentRev,
 		Flags:        syncData.Flags,
-		TimeReceived: event.TimeReceived,
+		TimeReceived: timeReceived,
 		TimeSaved:    syncData.TimeSaved,
 		Channels:     syncData.Channels,
 	}
-	base.Infof(base.KeyCache, "Received #%d after %3dms (%q / %q)", change.Sequence, int(feedLatency/time.Millisecond), base.UD(change.DocID), change.RevID)
-
 	changedChannels := c.processEntry(change)
 	changedChannelsCombined = changedChannelsCombined.Update(changedChannels)
 
@@ -588,7 +648,7 @@ func (c *changeCache) processUnusedSequence(docID string, timeReceived time.Time
 	sequenceStr := strings.TrimPrefix(docID, UnusedSequenceKeyPrefix)
 	sequence, err := strconv.ParseUint(sequenceStr, 10, 64)
 	if err != nil {
-		base.Warnf(base.KeyAll, "Unable to identify sequence number for unused sequence notification with key: %s, error: %v", base.UD(docID), err)
+		base.Warnf(base.KeyAll, "Unable to identify sequence number for unused sequence notification with key: %s, error: %v", base.UDDocID(docID), err)
 		return
 	}
 	change := &LogEntry{
@@ -613,7 +673,7 @@ func (c *changeCache) processPrincipalDoc(docID string, docJSON []byte, isUser b
 	// have gaps in it, causing later sequences to get stuck in the queue.
 	princ, err := c.unmarshalCachePrincipal(docJSON)
 	if err != nil {
-		base.Warnf(base.KeyAll, "changeCache: Error unmarshaling doc %q: %v", base.UD(docID), err)
+		base.Warnf(base.KeyAll, "changeCache: Error unmarshaling doc %q: %v", base.UDDocID(docID), err)
 		return
 	}
 	sequence := princ.Sequence
@@ -633,7 +693,7 @@ func (c *changeCache) processPrincipalDoc(docID string, docJSON []byte, isUser b
 		change.DocID = "_role/" + princ.Name
 	}
 
-	base.Infof(base.KeyCache, "Received #%d (%q)", change.Sequence, base.UD(change.DocID))
+	base.Infof(base.KeyCache, "Received #%d (%q)", change.Sequence, base.UDDocID(change.DocID))
 
 	changedChannels := c.processEntry(change)
 	if c.notifyChange != nil && len(changedChannels) > 0 {
@@ -669,7 +729,7 @@ func (c *changeCache) processEntry(change *LogEntry) base.Set {
 		heap.Push(&c.pendingLogs, change)
 		numPending := len(c.pendingLogs)
 		base.Infof(base.KeyCache, "  Deferring #%d (%d now waiting for #%d...#%d) doc %q / %q",
-			sequence, numPending, c.nextSequence, c.pendingLogs[0].Sequence-1, base.UD(change.DocID), change.RevID)
+			sequence, numPending, c.nextSequence, c.pendingLogs[0].Sequence-1, base.UDDocID(change.DocID), change.RevID)
 
 		// Update max pending high watermark stat
 		base.SetIfMax(c.context.DbStats.StatsCblReplicationPull(), base.StatKeyMaxPending, int64(numPending))
@@ -683,9 +743,9 @@ func (c *changeCache) processEntry(change *LogEntry) base.Set {
 		// Remove from skipped sequence queue
 		if !c.WasSkipped(sequence) {
 			// Error removing from skipped sequences
-			base.Infof(base.KeyCache, "  Received unexpected out-of-order change - not in skippedSeqs (seq %d, expecting %d) doc %q / %q", sequence, c.nextSequence, base.UD(change.DocID), change.RevID)
+			base.Infof(base.KeyCache, "  Received unexpected out-of-order change - not in skippedSeqs (seq %d, expecting %d) doc %q / %q", sequence, c.nextSequence, base.UDDocID(change.DocID), change.RevID)
 		} else {
-			base.Infof(base.KeyCache, "  Received previously skipped out-of-order change (seq %d, expecting %d) doc %q / %q ", sequence, c.nextSequence, base.UD(change.DocID), change.RevID)
+			base.Infof(base.KeyCache, "  Received previously skipped out-of-order change (seq %d, expecting %d) doc %q / %q ", sequence, c.nextSequence, base.UDDocID(change.DocID), change.RevID)
 			change.Skipped = true
 		}
 
@@ -717,7 +777,7 @@ func (c *changeCache) _addToCache(change *LogEntry) base.Set {
 	func() {
 		if change.Skipped {
 			c.lateSeqLock.Lock()
-			base.Infof(base.KeyChanges, "Acquired late sequence lock in order to cache %d - doc %q / %q", change.Sequence, base.UD(change.DocID), change.RevID)
+			base.Infof(base.KeyChanges, "Acquired late sequence lock in order to cache %d - doc %q / %q", change.Sequence, base.UDDocID(change.DocID), change.RevID)
 			defer c.lateSeqLock.Unlock()
 		}
 
@@ -726,6 +786,7 @@ func (c *changeCache) _addToCache(change *LogEntry) base.Set {
 				channelCache := c._getChannelCache(channelName)
 				channelCache.addToCache(change, removal != nil)
 				addedTo = addedTo.Add(channelName)
+				c.context.UsageStats.AddChannelChange(channelName)
 				if change.Skipped {
 					channelCache.AddLateSequence(change)
 				}
@@ -798,6 +859,10 @@ func (c *changeCache) _getChannelCache(channelName string) *channelCache {
 		validFrom := c.initialSequence + 1
 
 		cache = newChannelCacheWithOptions(c.context, channelName, validFrom, c.options)
+		if restore, found := c.savedCaches[channelName]; found {
+			cache.pendingRestore = restore
+			delete(c.savedCaches, channelName)
+		}
 		c.channelCaches[channelName] = cache
 		c.context.DbStats.StatsCache().Add(base.StatKeyChannelCacheNumChannels, 1)
 	}
@@ -915,6 +980,15 @@ func (c *changeCache) GetSkippedSequencesOlderThanMaxWait() (oldSequences []uint
 	return c.skippedSeqs.getOlderThan(c.options.CacheSkippedSeqMaxWait)
 }
 
+// Returns how long skipped sequences are waited for before they're looked up.  It's never longer than
+// CacheSkippedSeqMaxWait, so a skipped sequence isn't waited for longer than the max.
+func (c *changeCache) skippedSeqLookupWait() time.Duration {
+	if c.options.CacheSkippedSeqLookupWait < c.options.CacheSkippedSeqMaxWait {
+		return c.options.CacheSkippedSeqLookupWait
+	}
+	return c.options.CacheSkippedSeqMaxWait
+}
+
 // SkippedSequenceList stores the set of skipped sequences as an ordered list of *SkippedSequence with an associated map
 // for sequence-based lookup.
 type SkippedSequenceList struct {
@@ -10,6 +10,7 @@
 package db
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"log"
@@ -1069,6 +1070,57 @@ func TestSkippedViewRetrieval(t *testing.T) {
 
 }
 
+// Test that skipped sequences are looked up once they've been waited for longer than the lookup wait, well before the
+// max wait: found sequences are cached, and lost ones abandoned so they no longer hold back the stable sequence.
+func TestSkippedSequenceLookupWait(t *testing.T) {
+
+	if base.TestUseXattrs() {
+		t.Skip("This test does not work with XATTRs due to calling WriteDirect().  Skipping.")
+	}
+
+	leakyConfig := base.LeakyBucketConfig{
+		TapFeedMissingDocs: []string{"doc-3"},
+	}
+	options := shortWaitCache()
+	options.CacheSkippedSeqMaxWait = time.Hour
+	options.CacheSkippedSeqLookupWait = time.Minute
+	db := setupTestLeakyDBWithCacheOptions(t, options, leakyConfig)
+	defer tearDownTestDB(t, db)
+	db.ChannelMapper = channels.NewDefaultChannelMapper()
+
+	WriteDirect(db, []string{"ABC"}, 1)
+	WriteDirect(db, []string{"ABC"}, 2)
+	WriteDirect(db, []string{"ABC"}, 3)
+	WriteDirect(db, []string{"ABC"}, 6)
+
+	changeCache, ok := db.changeCache.(*changeCache)
+	assert.True(t, ok, "Testing skipped sequences without a change cache")
+	db.changeCache.waitForSequence(6, base.DefaultWaitForSequenceTesting)
+
+	// Sequence 3 exists but was lost by the feed, sequence 4 doesn't exist, and sequence 5 hasn't been waited for long enough
+	changeCache.RemoveSkippedSequences([]uint64{3, 4, 5})
+	changeCache.skippedSeqs.Push(&SkippedSequence{3, time.Now().Add(-2 * time.Minute)})
+	changeCache.skippedSeqs.Push(&SkippedSequence{4, time.Now().Add(-2 * time.Minute)})
+	changeCache.skippedSeqs.Push(&SkippedSequence{5, time.Now()})
+
+	// Without the query, skipped sequences are waited for up to the max wait
+	db.Options.UnsupportedOptions.DisableCleanSkippedQuery = true
+	changeCache.CleanSkippedSequenceQueue()
+	assert.True(t, verifySkippedSequences(changeCache.skippedSeqs, []uint64{3, 4, 5}))
+
+	db.Options.UnsupportedOptions.DisableCleanSkippedQuery = false
+	changeCache.CleanSkippedSequenceQueue()
+	assert.True(t, verifySkippedSequences(changeCache.skippedSeqs, []uint64{5}))
+	assert.Equal(t, uint64(5), db.changeCache.getOldestSkippedSequence())
+
+	entries, err := db.changeCache.GetChanges("ABC", ChangesOptions{Since: SequenceID{Seq: 2}})
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, "doc-3", entries[0].DocID)
+		assert.Equal(t, "doc-6", entries[1].DocID)
+	}
+}
+
 // Test that housekeeping goroutines get terminated when change cache is stopped
 func TestStopChangeCache(t *testing.T) {
 
@@ -1433,3 +1485,140 @@ func TestLateArrivingSequenceTriggersOnChange(t *testing.T) {
 	waitForOnChangeCallback.Wait()
 
 }
+
+// Test that a recording of one database's feed can be replayed into another, including across an injected rollback
+func TestFeedRecordAndReplay(t *testing.T) {
+	if base.TestUseXattrs() {
+		t.Skip("This test does not work with XATTRs due to calling WriteDirect().  Skipping.")
+	}
+
+	// Record the feed of a database as docs are written to it
+	var recording bytes.Buffer
+	db, testBucket := setupTestDB(t)
+	stopRecording := db.StartFeedRecording(&recording)
+	WriteDirect(db, []string{"ABC"}, 1)
+	WriteDirect(db, []string{"ABC"}, 2)
+	WriteDirect(db, []string{"ABC", "PBS"}, 3)
+	db.changeCache.waitForSequence(3, base.DefaultWaitForSequenceTesting)
+	count, err := stopRecording()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+	tearDownTestDB(t, db)
+	testBucket.Close()
+
+	// Replaying into a new database caches the recorded changes, even though they aren't in its bucket.  The rollback
+	// sends the second and third docs again, which are ignored as duplicates.
+	db, testBucket = setupTestDB(t)
+	defer testBucket.Close()
+	defer tearDownTestDB(t, db)
+
+	replayOptions := base.FeedReplayOptions{Rollbacks: []base.FeedRollback{{AfterEvent: 3, RestartFrom: 1}}}
+	count, err = db.ReplayFeed(bytes.NewReader(recording.Bytes()), replayOptions)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, count)
+	db.changeCache.waitForSequence(3, base.DefaultWaitForSequenceTesting)
+
+	entries, err := db.changeCache.GetChanges("ABC", ChangesOptions{Since: SequenceID{Seq: 0}})
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 3) {
+		for i, entry := range entries {
+			assert.Equal(t, uint64(i+1), entry.Sequence)
+			assert.Equal(t, fmt.Sprintf("doc-%d", i+1), entry.DocID)
+		}
+	}
+	entries, err = db.changeCache.GetChanges("PBS", ChangesOptions{Since: SequenceID{Seq: 0}})
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+// Test restoring the channel caches saved before a restart
+func TestChannelCachePersistence(t *testing.T) {
+
+	if base.TestUseXattrs() {
+		t.Skip("This test does not work with XATTRs due to calling WriteDirect().  Skipping.")
+	}
+
+	defer base.SetUpTestLogging(base.LevelDebug, base.KeyCache)()
+
+	cacheOptions := shortWaitCache()
+	cacheOptions.ChannelCachePersistInterval = time.Hour
+	db, testBucket := setupTestDBWithCacheOptions(t, cacheOptions)
+	defer tearDownTestDB(t, db)
+	defer testBucket.Close()
+
+	WriteDirect(db, []string{"ABC"}, 1)
+	WriteDirect(db, []string{"ABC", "PBS"}, 2)
+	WriteDirect(db, []string{"ABC"}, 3)
+	db.changeCache.waitForSequenceID(SequenceID{Seq: 3}, base.DefaultWaitForSequenceTesting)
+	cache, ok := db.changeCache.(*changeCache)
+	assert.True(t, ok, "Testing channel cache persistence without a change cache")
+	cache.persistChannelCaches()
+
+	var snapshot channelCacheSnapshot
+	_, err := db.Bucket.Get(ChannelCacheSnapshotKey, &snapshot)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), snapshot.ValidTo)
+	if assert.Contains(t, snapshot.Channels, "ABC") {
+		assert.Equal(t, uint64(1), snapshot.Channels["ABC"].ValidFrom)
+		assert.Len(t, snapshot.Channels["ABC"].Entries, 3)
+	}
+
+	// A doc written after the caches were saved is queried when the cache is restored
+	WriteDirect(db, []string{"ABC"}, 4)
+	_, err = db.Bucket.Incr(SyncSeqKey, 4, 4, 0)
+	assert.NoError(t, err)
+
+	restarted := &changeCache{}
+	assert.NoError(t, restarted.Init(db.DatabaseContext, nil, &cacheOptions, nil))
+	assert.NoError(t, restarted.Start())
+	defer restarted.Stop()
+	entries, err := restarted.GetChanges("ABC", ChangesOptions{Since: SequenceID{Seq: 0}})
+	assert.NoError(t, err)
+	assert.Len(t, entries, 4)
+	abcCache := restarted.getChannelCache("ABC")
+	goassert.True(t, verifyCacheSequences(abcCache, []uint64{1, 2, 3, 4}))
+	goassert.Equals(t, abcCache.validFrom, uint64(1))
+
+	// Channels that weren't saved start out empty, as usual
+	goassert.Equals(t, restarted.getChannelCache("NBC").validFrom, uint64(5))
+}
+
+// Test that a node that doesn't import still caches docs with their metadata in the body, written by a node without
+// xattrs enabled, while the bucket's being migrated to xattrs.
+func TestLegacyWriteCachedWithXattrs(t *testing.T) {
+	if !base.TestUseXattrs() {
+		t.Skip("This test only works with XATTRs enabled")
+	}
+
+	db, testBucket := setupTestDB(t)
+	defer tearDownTestDB(t, db)
+	defer testBucket.Close()
+
+	lastSeq, err := db.LastSequence()
+	assert.NoError(t, err)
+	doc := document{ID: "legacyDoc"}
+	doc.syncData = syncData{
+		CurrentRev: "1-abc",
+		Sequence:   lastSeq + 1,
+		Channels:   channels.ChannelMap{"ABC": nil},
+		TimeSaved:  time.Now(),
+	}
+	docBytes, err := doc.MarshalJSON()
+	assert.NoError(t, err)
+
+	db.changeCache.DocChanged(sgbucket.FeedEvent{
+		Synchronous: true,
+		Key:         []byte(doc.ID),
+		Value:       docBytes,
+		DataType:    base.MemcachedDataTypeJSON,
+		Cas:         1,
+	})
+	db.changeCache.waitForSequence(doc.Sequence, base.DefaultWaitForSequenceTesting)
+
+	entries, err := db.changeCache.GetChanges("ABC", ChangesOptions{})
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, doc.ID, entries[0].DocID)
+		assert.Equal(t, "1-abc", entries[0].RevID)
+	}
+}
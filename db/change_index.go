@@ -22,7 +22,8 @@ import (
 //      incoming sequences from the feed to provide consistency.
 //   2. kv_change_index.go
 //      Supports multiple SG nodes as index writers.  Uses vbucket sequence numbers for sequence management,
-//      and maintains stable sequence vector clocks to provide consistency.
+//      and maintains stable sequence vector clocks to provide consistency.  Written by SG Accel, or in
+//      distributed mode by the Sync Gateway nodes themselves - see kv_change_index_writer.go.
 
 type ChangeIndex interface {
 
@@ -85,10 +86,12 @@ type ChannelIndexOptions struct {
 	Spec                      base.BucketSpec // Indexing bucket spec
 	Bucket                    base.Bucket     // Indexing bucket
 	Writer                    bool            // Cache Writer
+	Distributed               bool            // Whether the database's Sync Gateway nodes write the index themselves, without SG Accel
 	Options                   CacheOptions    // Caching options
 	NumShards                 uint16          // The number of CBGT shards
 	HashFrequency             uint16          // Hash frequency for changes feeds (in changes entries)
 	TombstoneCompactFrequency *int            // Tombstone Compaction frequency (in hours)
+	LocalStoreDir             string          // If set, the channel blocks are kept in a LocalDenseStore in this directory instead of the index bucket
 }
 
 type SequenceHashOptions struct {
@@ -4,6 +4,7 @@ import (
 	"math"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/couchbase/sg-bucket"
@@ -18,6 +19,7 @@ type changeListener struct {
 	bucket                base.Bucket
 	bucketName            string                  // Used for logging
 	tapFeed               base.TapFeed            // Observes changes to bucket
+	metadataTapFeed       base.TapFeed            // Observes changes to the metadata bucket, if separate
 	tapNotifier           *sync.Cond              // Posts notifications when documents are updated
 	FeedArgs              sgbucket.FeedArguments  // The Tap Args (backfill, etc)
 	counter               uint64                  // Event counter; increments on every doc update
@@ -25,6 +27,7 @@ type changeListener struct {
 	keyCounts             map[string]uint64       // Latest count at which each doc key was updated
 	DocChannel            chan sgbucket.FeedEvent // Passthru channel for doc mutations
 	OnDocChanged          DocChangedFunc          // Called when change arrives on feed
+	feedRecorder          atomic.Value            // FOR TESTS ONLY: *base.FeedRecorder of the events passed to OnDocChanged
 	trackDocs             bool                    // Whether events should be routed to DocChannel passthru
 	terminator            chan bool               // Signal to cause cbdatasource bucketdatasource.Close() to be called, which removes dcp receiver
 }
@@ -95,6 +98,36 @@ func (listener *changeListener) StartMutationFeed(bucket base.Bucket) error {
 	}
 }
 
+// Starts a mutation feed on a separate metadata bucket, whose events are handled by ProcessFeedEvent alongside those of
+// the main feed.  Must be called after Start, and is stopped along with the main feed by Stop.  Only new changes are
+// needed from the metadata bucket, so the feed doesn't backfill.
+func (listener *changeListener) StartMetadataFeed(bucket base.Bucket, bucketStateNotify sgbucket.BucketNotifyFn) error {
+	feedArgs := sgbucket.FeedArguments{
+		Backfill:   sgbucket.FeedNoBackfill,
+		Notify:     bucketStateNotify,
+		Terminator: listener.terminator,
+	}
+
+	switch base.GetFeedType(bucket) {
+	case base.TapFeedType:
+		var err error
+		listener.metadataTapFeed, err = bucket.StartTapFeed(feedArgs)
+		if err != nil {
+			return err
+		}
+		go func() {
+			for event := range listener.metadataTapFeed.Events() {
+				event.TimeReceived = time.Now()
+				listener.ProcessFeedEvent(event)
+			}
+		}()
+		return nil
+	default:
+		base.Infof(base.KeyDCP, "Using DCP feed for metadata bucket: %q", base.UD(bucket.GetName()))
+		return bucket.StartDCPFeed(feedArgs, listener.ProcessFeedEvent)
+	}
+}
+
 // ProcessFeedEvent is invoked for each mutate or delete event seen on the server's mutation feed (TAP or DCP).  Uses document
 // key to determine handling, based on whether the incoming mutation is an internal Sync Gateway document.
 func (listener *changeListener) ProcessFeedEvent(event sgbucket.FeedEvent) bool {
@@ -103,22 +136,20 @@ func (listener *changeListener) ProcessFeedEvent(event sgbucket.FeedEvent) bool
 		key := string(event.Key)
 		if strings.HasPrefix(key, auth.UserKeyPrefix) ||
 			strings.HasPrefix(key, auth.RoleKeyPrefix) { // SG users and roles
-			if listener.OnDocChanged != nil && event.Opcode == sgbucket.FeedOpMutation {
-				listener.OnDocChanged(event)
+			if event.Opcode == sgbucket.FeedOpMutation {
+				listener.docChanged(event)
 			}
 			listener.Notify(base.SetOf(key))
 		} else if strings.HasPrefix(key, UnusedSequenceKeyPrefix) { // SG unused sequence marker docs
-			if listener.OnDocChanged != nil {
-				listener.OnDocChanged(event)
-			}
+			listener.docChanged(event)
+		} else if strings.HasPrefix(key, HealthCheckDocKey) { // SG health check docs
+			listener.Notify(base.SetOf(key))
 		} else if strings.HasPrefix(key, base.DCPCheckpointPrefix) { // SG DCP checkpoint docs
 			// Do not require checkpoint persistence when DCP checkpoint docs come back over DCP - otherwise
 			// we'll end up in a feedback loop for their vbucket
 			requiresCheckpointPersistence = false
 		} else if !strings.HasPrefix(key, KSyncKeyPrefix) && !strings.HasPrefix(key, base.KIndexPrefix) { // Non-SG docs
-			if listener.OnDocChanged != nil {
-				listener.OnDocChanged(event)
-			}
+			listener.docChanged(event)
 			if listener.trackDocs {
 				listener.DocChannel <- event
 			}
@@ -128,6 +159,16 @@ func (listener *changeListener) ProcessFeedEvent(event sgbucket.FeedEvent) bool
 	return requiresCheckpointPersistence
 }
 
+// Passes an event on to OnDocChanged, recording it first if a feed recording's in progress.
+func (listener *changeListener) docChanged(event sgbucket.FeedEvent) {
+	if recorder, _ := listener.feedRecorder.Load().(*base.FeedRecorder); recorder != nil {
+		recorder.Record(event)
+	}
+	if listener.OnDocChanged != nil {
+		listener.OnDocChanged(event)
+	}
+}
+
 // Stops a changeListener. Any pending Wait() calls will immediately return false.
 func (listener *changeListener) Stop() {
 
@@ -145,6 +186,10 @@ func (listener *changeListener) Stop() {
 	if listener.tapFeed != nil {
 		listener.tapFeed.Close()
 	}
+
+	if listener.metadataTapFeed != nil {
+		listener.metadataTapFeed.Close()
+	}
 }
 
 func (listener changeListener) TapFeed() base.TapFeed {
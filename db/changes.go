@@ -155,14 +155,14 @@ func (db *Database) AddDocInstanceToChangeEntry(entry *ChangeEntry, doc *documen
 	}
 	if options.IncludeDocs {
 		if doc.Body() == nil {
-			base.WarnfCtx(db.Ctx, base.KeyAll, "AddDocInstanceToChangeEntry called with options.IncludeDocs, but doc %q/%q is missing Body", base.UD(doc.ID), revID)
+			base.WarnfCtx(db.Ctx, base.KeyAll, "AddDocInstanceToChangeEntry called with options.IncludeDocs, but doc %q/%q is missing Body", base.UDDocID(doc.ID), revID)
 			return
 		}
 		var err error
 		entry.Doc, err = db.getRevFromDoc(doc, revID, false)
 		db.DbStats.StatsDatabase().Add(base.StatKeyNumDocReadsRest, 1)
 		if err != nil {
-			base.WarnfCtx(db.Ctx, base.KeyAll, "Changes feed: error getting doc %q/%q: %v", base.UD(doc.ID), revID, err)
+			base.WarnfCtx(db.Ctx, base.KeyAll, "Changes feed: error getting doc %q/%q: %v", base.UDDocID(doc.ID), revID, err)
 		}
 	}
 }
@@ -171,8 +171,10 @@ func (db *Database) AddDocInstanceToChangeEntry(entry *ChangeEntry, doc *documen
 // Does NOT handle the Wait option. Does NOT check authorization.
 func (db *Database) changesFeed(channel string, options ChangesOptions, to string) (<-chan *ChangeEntry, error) {
 	// TODO: pass db.Ctx down to changeCache?
+	startTime := time.Now()
 	log, err := db.changeCache.GetChanges(channel, options)
-	base.DebugfCtx(db.Ctx, base.KeyChanges, "[changesFeed] Found %d changes for channel %s", len(log), base.UD(channel))
+	base.SlowOperationLog(db.Ctx, base.SlowChangesWarningThreshold, startTime, base.KeyChanges, "Changes iteration for channel %s", base.UDChannel(channel))
+	base.DebugfCtx(db.Ctx, base.KeyChanges, "[changesFeed] Found %d changes for channel %s", len(log), base.UDChannel(channel))
 	if err != nil {
 		return nil, err
 	}
@@ -207,7 +209,7 @@ func (db *Database) changesFeed(channel string, options ChangesOptions, to strin
 
 			change := makeChangeEntry(logEntry, seqID, channel)
 
-			base.DebugfCtx(db.Ctx, base.KeyChanges, "Channel feed processing seq:%v in channel %s %s", seqID, base.UD(channel), base.UD(to))
+			base.DebugfCtx(db.Ctx, base.KeyChanges, "Channel feed processing seq:%v in channel %s %s", seqID, base.UDChannel(channel), base.UD(to))
 			select {
 			case <-options.Terminator:
 				base.DebugfCtx(db.Ctx, base.KeyChanges, "Terminating channel feed %s", base.UD(to))
@@ -331,13 +333,13 @@ func (db *Database) checkForUserUpdates(userChangeCount uint64, changeWaiter *ch
 		if db.user != nil {
 			previousChannels = db.user.InheritedChannels()
 			if err := db.ReloadUser(); err != nil {
-				base.WarnfCtx(db.Ctx, base.KeyAll, "Error reloading user %q: %v", base.UD(db.user.Name()), err)
+				base.WarnfCtx(db.Ctx, base.KeyAll, "Error reloading user %q: %v", base.UDUsername(db.user.Name()), err)
 				return false, 0, nil, err
 			}
 			// check whether channels have changed
 			newChannels = db.user.GetAddedChannels(previousChannels)
 			if len(newChannels) > 0 {
-				base.DebugfCtx(db.Ctx, base.KeyChanges, "New channels found after user reload: %v", base.UD(newChannels))
+				base.DebugfCtx(db.Ctx, base.KeyChanges, "New channels found after user reload: %v", base.UDChannel(newChannels))
 			}
 		}
 		return true, newCount, newChannels, nil
@@ -352,7 +354,7 @@ func (db *Database) SimpleMultiChangesFeed(chans base.Set, options ChangesOption
 		to = fmt.Sprintf("  (to %s)", db.user.Name())
 	}
 
-	base.InfofCtx(db.Ctx, base.KeyChanges, "MultiChangesFeed(channels: %s, options: %+v) ... %s", base.UD(chans), options, base.UD(to))
+	base.InfofCtx(db.Ctx, base.KeyChanges, "MultiChangesFeed(channels: %s, options: %+v) ... %s", base.UDChannel(chans), options, base.UD(to))
 	output := make(chan *ChangeEntry, 50)
 
 	go func() {
@@ -382,7 +384,7 @@ func (db *Database) SimpleMultiChangesFeed(chans base.Set, options ChangesOption
 			// included in the initial changes loop iteration, and (b) won't wake up the changeWaiter.
 			if db.user != nil {
 				if err := db.ReloadUser(); err != nil {
-					base.WarnfCtx(db.Ctx, base.KeyAll, "Error reloading user during changes initialization %q: %v", base.UD(db.user.Name()), err)
+					base.WarnfCtx(db.Ctx, base.KeyAll, "Error reloading user during changes initialization %q: %v", base.UDUsername(db.user.Name()), err)
 					change := makeErrorEntry("User not found during reload - terminating changes feed")
 					output <- &change
 					return
@@ -854,7 +856,7 @@ func (db *Database) DocIDChangesFeed(userChannels base.Set, explicitDocIds []str
 		// Fetch the document body and other metadata that lives with it:
 		populatedDoc, body, err := db.GetDocAndActiveRev(docid)
 		if err != nil {
-			base.InfofCtx(db.Ctx, base.KeyChanges, "Unable to get changes for docID %v, caused by %v", base.UD(docid), err)
+			base.InfofCtx(db.Ctx, base.KeyChanges, "Unable to get changes for docID %v, caused by %v", base.UDDocID(docid), err)
 			return nil
 		}
 
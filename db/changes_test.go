@@ -385,3 +385,44 @@ func BenchmarkChangesFeedDocUnmarshalling(b *testing.B) {
 	}
 
 }
+
+// Benchmarks merging the cached changes of many channels into a single changes feed
+func BenchmarkChangesFeedMerge(b *testing.B) {
+
+	defer base.DisableTestLogging()()
+	numEntries := 100000
+
+	for _, numChannels := range []int{10, 100, 1000} {
+		cacheOptions := CacheOptions{ChannelCacheOptions: ChannelCacheOptions{ChannelCacheMaxLength: numEntries}}
+		db, testBucket := setupTestDBWithCacheOptions(b, cacheOptions)
+
+		// Spread the entries evenly across the channels, so the feed has to interleave every channel's changes
+		channelNames := make([]string, numChannels)
+		for i := range channelNames {
+			channelNames[i] = fmt.Sprintf("channel_%d", i)
+		}
+		changeCache := db.changeCache.(*changeCache)
+		for seq := 1; seq <= numEntries; seq++ {
+			changeCache.processEntry(&LogEntry{
+				Sequence: uint64(seq),
+				DocID:    fmt.Sprintf("doc_%d", seq),
+				RevID:    "1-a",
+				Channels: channels.ChannelMap{channelNames[seq%numChannels]: nil},
+			})
+		}
+		channelSet := base.SetFromArray(channelNames)
+
+		b.Run(fmt.Sprintf("channels_%d", numChannels), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				changes, err := db.GetChanges(channelSet, ChangesOptions{Since: SequenceID{Seq: 0}})
+				if err != nil || len(changes) != numEntries {
+					b.Fatalf("Expected %d changes, got %d (err=%v)", numEntries, len(changes), err)
+				}
+			}
+		})
+
+		tearDownTestDB(b, db)
+		testBucket.Close()
+	}
+}
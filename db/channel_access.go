@@ -0,0 +1,178 @@
+package db
+
+import (
+	"sort"
+
+	"github.com/couchbase/sync_gateway/auth"
+	ch "github.com/couchbase/sync_gateway/channels"
+)
+
+// Ways a principal can be given access to a channel
+const (
+	ChannelAccessAdmin  = "admin"   // The channel is one of the principal's admin channels
+	ChannelAccessSyncFn = "sync_fn" // A document's access() call in the sync function granted the channel
+	ChannelAccessRole   = "role"    // The user inherits the channel from one of its roles
+	ChannelAccessPublic = "public"  // Every principal can access the public channel
+)
+
+// ChannelAccessGrant is one of the ways a principal was given access to a channel.
+type ChannelAccessGrant struct {
+	Type     string `json:"type"`
+	Since    uint64 `json:"since"`              // Sequence from which the grant gave access to the channel
+	Wildcard bool   `json:"wildcard,omitempty"` // True if the grant is of all channels ("*"), rather than of this one
+	DocID    string `json:"doc_id,omitempty"`   // Document that made a sync_fn grant
+	Role     string `json:"role,omitempty"`     // Role a role grant is inherited from
+}
+
+// ChannelPrincipalAccess is a user's or role's access to a channel.
+type ChannelPrincipalAccess struct {
+	Name     string               `json:"name"`
+	Disabled bool                 `json:"disabled,omitempty"` // True if the user is disabled, so can't use its access
+	Since    uint64               `json:"since"`              // Earliest sequence from which any of the grants gave access
+	Grants   []ChannelAccessGrant `json:"grants"`
+}
+
+// ChannelAccess lists the users and roles with access to a channel, by GetChannelAccess.
+type ChannelAccess struct {
+	Channel string                   `json:"channel"`
+	Users   []ChannelPrincipalAccess `json:"users"`
+	Roles   []ChannelPrincipalAccess `json:"roles"`
+}
+
+// Returns every user and role with access to a channel, with how each was given it.  This loads every principal and
+// queries the access view for each, so it's meant for occasional use, such as security reviews.
+func (dbc *DatabaseContext) GetChannelAccess(channel string) (*ChannelAccess, error) {
+	userNames, roleNames, err := dbc.AllPrincipalIDs()
+	if err != nil {
+		return nil, err
+	}
+	// The guest user isn't listed with the other users
+	userNames = append(userNames, "")
+	result := &ChannelAccess{
+		Channel: channel,
+		Users:   []ChannelPrincipalAccess{},
+		Roles:   []ChannelPrincipalAccess{},
+	}
+
+	roleAccess := map[string]*ChannelPrincipalAccess{}
+	for _, name := range roleNames {
+		role, err := dbc.Authenticator().GetRole(name)
+		if err != nil {
+			return nil, err
+		} else if role == nil {
+			continue
+		}
+		grants, err := dbc.channelAccessGrants(role, ch.RoleAccessPrefix+name, channel)
+		if err != nil {
+			return nil, err
+		}
+		if access := newChannelPrincipalAccess(name, grants); access != nil {
+			result.Roles = append(result.Roles, *access)
+			roleAccess[name] = access
+		}
+	}
+
+	for _, name := range userNames {
+		user, err := dbc.Authenticator().GetUser(name)
+		if err != nil {
+			return nil, err
+		} else if user == nil {
+			continue
+		}
+		grants, err := dbc.channelAccessGrants(user, name, channel)
+		if err != nil {
+			return nil, err
+		}
+		for roleName, roleSince := range user.RoleNames() {
+			access, ok := roleAccess[roleName]
+			if !ok {
+				continue
+			}
+			// The user can access the channel once it both has the role and the role has the channel
+			var since uint64
+			for _, grant := range access.Grants {
+				if grant.Type != ChannelAccessPublic && (since == 0 || grant.Since < since) {
+					since = grant.Since
+				}
+			}
+			if since == 0 {
+				continue
+			}
+			if roleSince.Sequence > since {
+				since = roleSince.Sequence
+			}
+			grants = append(grants, ChannelAccessGrant{Type: ChannelAccessRole, Since: since, Role: roleName})
+		}
+		if access := newChannelPrincipalAccess(name, grants); access != nil {
+			access.Disabled = user.Disabled()
+			result.Users = append(result.Users, *access)
+		}
+	}
+
+	sortChannelPrincipalAccess(result.Users)
+	sortChannelPrincipalAccess(result.Roles)
+	return result, nil
+}
+
+// Returns the grants of a channel that a principal has of its own, rather than through its roles.  The access key
+// identifies the principal in the access view.
+func (dbc *DatabaseContext) channelAccessGrants(princ auth.Principal, accessKey string, channel string) ([]ChannelAccessGrant, error) {
+	var grants []ChannelAccessGrant
+	if channel == ch.DocumentStarChannel {
+		grants = append(grants, ChannelAccessGrant{Type: ChannelAccessPublic, Since: 1})
+	}
+	if since, wildcard, ok := timedSetGrant(princ.ExplicitChannels(), channel); ok {
+		grants = append(grants, ChannelAccessGrant{Type: ChannelAccessAdmin, Since: since, Wildcard: wildcard})
+	}
+	if accessKey == "" {
+		return grants, nil // The sync function can't grant channels to the guest user
+	}
+
+	results, err := dbc.QueryAccess(accessKey)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var row QueryAccessRow
+		if !results.Next(&row) {
+			break
+		}
+		if since, wildcard, ok := timedSetGrant(row.Value, channel); ok {
+			grants = append(grants, ChannelAccessGrant{Type: ChannelAccessSyncFn, Since: since, Wildcard: wildcard, DocID: row.Id})
+		}
+	}
+	if err := results.Close(); err != nil {
+		return nil, err
+	}
+	return grants, nil
+}
+
+// Returns the sequence from which a set of channels has included a channel, either itself or through the all
+// channels wildcard.
+func timedSetGrant(set ch.TimedSet, channel string) (since uint64, wildcard bool, ok bool) {
+	if seq, found := set[channel]; found {
+		return seq.Sequence, false, true
+	}
+	if seq, found := set[ch.UserStarChannel]; found {
+		return seq.Sequence, true, true
+	}
+	return 0, false, false
+}
+
+// Returns a principal's access given its grants, or nil if it has none.
+func newChannelPrincipalAccess(name string, grants []ChannelAccessGrant) *ChannelPrincipalAccess {
+	if len(grants) == 0 {
+		return nil
+	}
+	access := &ChannelPrincipalAccess{Name: name, Since: grants[0].Since, Grants: grants}
+	for _, grant := range grants[1:] {
+		if grant.Since < access.Since {
+			access.Since = grant.Since
+		}
+	}
+	return access
+}
+
+func sortChannelPrincipalAccess(access []ChannelPrincipalAccess) {
+	sort.Slice(access, func(i, j int) bool { return access[i].Name < access[j].Name })
+}
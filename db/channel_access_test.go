@@ -0,0 +1,71 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/couchbase/sync_gateway/channels"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetChannelAccess(t *testing.T) {
+	db, testBucket := setupTestDB(t)
+	defer testBucket.Close()
+	defer tearDownTestDB(t, db)
+	db.ChannelMapper = channels.NewChannelMapper(`function(doc) {access(doc.users, doc.channels);}`)
+
+	authenticator := db.Authenticator()
+	role, err := authenticator.NewRole("staff", channels.SetOf("X"))
+	assert.NoError(t, err)
+	assert.NoError(t, authenticator.Save(role))
+	alice, err := authenticator.NewUser("alice", "letmein", nil)
+	assert.NoError(t, err)
+	alice.SetExplicitRoles(channels.AtSequence(channels.SetOf("staff"), 1))
+	assert.NoError(t, authenticator.Save(alice))
+	bob, err := authenticator.NewUser("bob", "letmein", channels.SetOf("*"))
+	assert.NoError(t, err)
+	assert.NoError(t, authenticator.Save(bob))
+	for _, name := range []string{"carol", "dave"} {
+		user, err := authenticator.NewUser(name, "letmein", nil)
+		assert.NoError(t, err)
+		assert.NoError(t, authenticator.Save(user))
+	}
+	_, err = db.Put("grants", Body{"users": []string{"carol"}, "channels": []string{"X"}})
+	assert.NoError(t, err)
+	doc, err := db.GetDocument("grants", DocUnmarshalSync)
+	assert.NoError(t, err)
+
+	access, err := db.GetChannelAccess("X")
+	assert.NoError(t, err)
+	assert.Equal(t, "X", access.Channel)
+	if assert.Len(t, access.Roles, 1) {
+		assert.Equal(t, "staff", access.Roles[0].Name)
+		assert.Equal(t, []ChannelAccessGrant{{Type: ChannelAccessAdmin, Since: 1}}, access.Roles[0].Grants)
+	}
+	if assert.Len(t, access.Users, 3) {
+		assert.Equal(t, "alice", access.Users[0].Name)
+		assert.Equal(t, []ChannelAccessGrant{{Type: ChannelAccessRole, Since: 1, Role: "staff"}}, access.Users[0].Grants)
+		assert.Equal(t, "bob", access.Users[1].Name)
+		assert.Equal(t, []ChannelAccessGrant{{Type: ChannelAccessAdmin, Since: 1, Wildcard: true}}, access.Users[1].Grants)
+		assert.Equal(t, "carol", access.Users[2].Name)
+		assert.Equal(t, []ChannelAccessGrant{{Type: ChannelAccessSyncFn, Since: doc.Sequence, DocID: "grants"}}, access.Users[2].Grants)
+		assert.Equal(t, doc.Sequence, access.Users[2].Since)
+	}
+
+	// Only the wildcard grants other channels
+	access, err = db.GetChannelAccess("Y")
+	assert.NoError(t, err)
+	assert.Len(t, access.Roles, 0)
+	if assert.Len(t, access.Users, 1) {
+		assert.Equal(t, "bob", access.Users[0].Name)
+	}
+
+	// Everyone can access the public channel, including the disabled guest user
+	access, err = db.GetChannelAccess(channels.DocumentStarChannel)
+	assert.NoError(t, err)
+	assert.Len(t, access.Roles, 1)
+	if assert.Len(t, access.Users, 5) {
+		assert.Equal(t, "", access.Users[0].Name)
+		assert.True(t, access.Users[0].Disabled)
+		assert.Equal(t, []ChannelAccessGrant{{Type: ChannelAccessPublic, Since: 1}}, access.Users[4].Grants)
+	}
+}
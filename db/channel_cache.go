@@ -3,6 +3,7 @@ package db
 import (
 	"errors"
 	"fmt"
+	"regexp"
 	"sync"
 	"time"
 
@@ -96,6 +97,7 @@ type channelCache struct {
 	lateLogLock      sync.RWMutex         // Controls access to lateLogs
 	options          *ChannelCacheOptions // Cache size/expiry settings
 	cachedDocIDs     map[string]struct{}
+	pendingRestore   *savedCache // Saved entries to restore when the cache is first used
 }
 
 func newChannelCache(context *DatabaseContext, channelName string, validFrom uint64) *channelCache {
@@ -128,6 +130,13 @@ func newChannelCacheWithOptions(context *DatabaseContext, channelName string, va
 		cache.options.ChannelCacheAge = options.ChannelCacheAge
 	}
 
+	for _, override := range options.ChannelCacheOverrides {
+		if override.ChannelPattern.MatchString(channelName) {
+			cache.options.override(override.ChannelCacheOptions)
+			break
+		}
+	}
+
 	base.Infof(base.KeyCache, "Initialized cache for channel %q with options: %+v", base.UD(cache.channelName), cache.options)
 
 	return cache
@@ -139,6 +148,26 @@ type ChannelCacheOptions struct {
 	ChannelCacheAge       time.Duration // Keep entries at least this long
 }
 
+// Replaces the options that are set in other.
+func (o *ChannelCacheOptions) override(other ChannelCacheOptions) {
+	if other.ChannelCacheMinLength > 0 {
+		o.ChannelCacheMinLength = other.ChannelCacheMinLength
+	}
+	if other.ChannelCacheMaxLength > 0 {
+		o.ChannelCacheMaxLength = other.ChannelCacheMaxLength
+	}
+	if other.ChannelCacheAge > 0 {
+		o.ChannelCacheAge = other.ChannelCacheAge
+	}
+}
+
+// Overrides the cache options of the channels whose names match its pattern, such as a busy channel that every user
+// has access to.  Zero-valued options aren't overridden.
+type ChannelCacheOverride struct {
+	ChannelPattern *regexp.Regexp // Matched against the channel name
+	ChannelCacheOptions
+}
+
 // Low-level method to add a LogEntry to a single channel's cache.
 func (c *channelCache) addToCache(change *LogEntry, isRemoval bool) {
 	c.lock.Lock()
@@ -146,7 +175,7 @@ func (c *channelCache) addToCache(change *LogEntry, isRemoval bool) {
 
 	if c.wouldBeImmediatelyPruned(change) {
 		base.Infof(base.KeyCache, "Not adding change #%d doc %q / %q ==> channel %q, since it will be immediately pruned",
-			change.Sequence, base.UD(change.DocID), change.RevID, base.UD(c.channelName))
+			change.Sequence, base.UDDocID(change.DocID), change.RevID, base.UDChannel(c.channelName))
 		return
 	}
 
@@ -202,7 +231,7 @@ func (c *channelCache) Remove(docIDs []string, startTime time.Time) (count int)
 			// This is to ensure that resurrected documents do not accidentally get removed.
 			if c.logs[i].TimeReceived.After(startTime) {
 				base.Debugf(base.KeyCache, "Skipping removal of doc %q from cache %q - received after purge",
-					base.UD(docID), base.UD(c.channelName))
+					base.UDDocID(docID), base.UDChannel(c.channelName))
 				continue
 			}
 
@@ -216,7 +245,7 @@ func (c *channelCache) Remove(docIDs []string, startTime time.Time) (count int)
 			delete(c.cachedDocIDs, docID)
 			count++
 
-			base.Tracef(base.KeyCache, "Removed doc %q from cache %q", base.UD(docID), base.UD(c.channelName))
+			base.Tracef(base.KeyCache, "Removed doc %q from cache %q", base.UDDocID(docID), base.UDChannel(c.channelName))
 		}
 	}
 
@@ -236,7 +265,7 @@ func (c *channelCache) _pruneCacheLength() (pruned int) {
 		c.logs = c.logs[pruned:]
 	}
 
-	base.Debugf(base.KeyCache, "Pruned %d entries from channel %q", pruned, base.UD(c.channelName))
+	base.Debugf(base.KeyCache, "Pruned %d entries from channel %q", pruned, base.UDChannel(c.channelName))
 
 	return pruned
 }
@@ -260,7 +289,7 @@ func (c *channelCache) pruneCacheAge() {
 		c.logs = c.logs[1:]
 		pruned++
 	}
-	base.Debugf(base.KeyCache, "Pruned %d old entries from channel %q", pruned, base.UD(c.channelName))
+	base.Debugf(base.KeyCache, "Pruned %d old entries from channel %q", pruned, base.UDChannel(c.channelName))
 
 }
 
@@ -318,15 +347,17 @@ func (c *channelCache) _getCachedChanges(sinceSeq uint64, limit int) (validFrom
 // view should be queried, because we don't want the view query to outrun the chanceCache's
 // nextSequence.
 func (c *channelCache) GetChanges(options ChangesOptions) ([]*LogEntry, error) {
+	c.restoreSnapshot()
+
 	// Use the cache, and return if it fulfilled the entire request:
 	cacheValidFrom, resultFromCache := c.getCachedChanges(options)
 	numFromCache := len(resultFromCache)
 	if numFromCache > 0 || resultFromCache == nil {
 		base.InfofCtx(options.Ctx, base.KeyCache, "getCachedChanges(%q, %s) --> %d changes valid from #%d",
-			base.UD(c.channelName), options.Since.String(), numFromCache, cacheValidFrom)
+			base.UDChannel(c.channelName), options.Since.String(), numFromCache, cacheValidFrom)
 	} else if resultFromCache == nil {
 		base.InfofCtx(options.Ctx, base.KeyCache, "getCachedChanges(%q, %s) --> nothing cached",
-			base.UD(c.channelName), options.Since.String())
+			base.UDChannel(c.channelName), options.Since.String())
 	}
 	startSeq := options.Since.SafeSequence() + 1
 	if cacheValidFrom <= startSeq {
@@ -347,7 +378,7 @@ func (c *channelCache) GetChanges(options ChangesOptions) ([]*LogEntry, error) {
 	cacheValidFrom, resultFromCache = c.getCachedChanges(options)
 	if len(resultFromCache) > numFromCache {
 		base.InfofCtx(options.Ctx, base.KeyCache, "2nd getCachedChanges(%q, %s) got %d more, valid from #%d!",
-			base.UD(c.channelName), options.Since.String(), len(resultFromCache)-numFromCache, cacheValidFrom)
+			base.UDChannel(c.channelName), options.Since.String(), len(resultFromCache)-numFromCache, cacheValidFrom)
 	}
 	if cacheValidFrom <= startSeq {
 		c.context.DbStats.StatsCache().Add(base.StatKeyChannelCacheHits, 1)
@@ -396,7 +427,7 @@ func (c *channelCache) GetChanges(options ChangesOptions) ([]*LogEntry, error) {
 		}
 		result = append(result, resultFromCache[0:n]...)
 	}
-	base.InfofCtx(options.Ctx, base.KeyCache, "GetChangesInChannel(%q) --> %d rows", base.UD(c.channelName), len(result))
+	base.InfofCtx(options.Ctx, base.KeyCache, "GetChangesInChannel(%q) --> %d rows", base.UDChannel(c.channelName), len(result))
 
 	return result, nil
 }
@@ -552,7 +583,7 @@ func (c *channelCache) prependChanges(changes LogEntries, changesValidFrom uint6
 		c.logs = make(LogEntries, len(changes))
 		copy(c.logs, changes)
 		base.Infof(base.KeyCache, "  Initialized cache of %q with %d entries from query (#%d--#%d)",
-			base.UD(c.channelName), len(changes), changes[0].Sequence, changes[len(changes)-1].Sequence)
+			base.UDChannel(c.channelName), len(changes), changes[0].Sequence, changes[len(changes)-1].Sequence)
 
 		c.validFrom = changesValidFrom
 		c.addDocIDs(changes)
@@ -603,7 +634,7 @@ func (c *channelCache) prependChanges(changes LogEntries, changesValidFrom uint6
 	if numToPrepend > 0 {
 		c.logs = append(entriesToPrepend, c.logs...)
 		base.Infof(base.KeyCache, "  Added %d entries from query (#%d--#%d) to cache of %q",
-			numToPrepend, entriesToPrepend[0].Sequence, entriesToPrepend[numToPrepend-1].Sequence, base.UD(c.channelName))
+			numToPrepend, entriesToPrepend[0].Sequence, entriesToPrepend[numToPrepend-1].Sequence, base.UDChannel(c.channelName))
 	}
 	base.Debugf(base.KeyCache, " Backfill cache from query c.validFrom from %v -> %v",
 		c.validFrom, changesValidFrom)
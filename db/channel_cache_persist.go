@@ -0,0 +1,205 @@
+package db
+
+import (
+	"sort"
+	"time"
+
+	"github.com/couchbase/sync_gateway/base"
+)
+
+// Key of the doc the channel caches are saved to, so they can be restored after a restart.  It's shared by all of
+// the database's nodes, since their caches all hold the same changes.
+const ChannelCacheSnapshotKey = KSyncKeyPrefix + "channelCache"
+
+// Max number of cache entries saved in the snapshot, to keep the doc well under the bucket's size limit.  The
+// channels with the most recent changes are saved first.
+const kMaxChannelCacheSnapshotEntries = 50000
+
+// Snapshots older than this aren't restored, as filling the gap since they were saved would take longer than
+// starting with empty caches.
+const kMaxChannelCacheSnapshotAge = time.Hour
+
+// The saved state of the channel caches.  Each channel's entries are complete from its ValidFrom up to the
+// snapshot's ValidTo.
+type channelCacheSnapshot struct {
+	SavedAt  time.Time                          `json:"saved_at"`
+	ValidTo  uint64                             `json:"valid_to"`
+	Channels map[string]*channelSnapshotEntries `json:"channels"`
+}
+
+type channelSnapshotEntries struct {
+	ValidFrom uint64                 `json:"valid_from"`
+	Entries   []channelSnapshotEntry `json:"entries"`
+}
+
+// A cache entry, with the same information as one from a channel query.
+type channelSnapshotEntry struct {
+	Sequence uint64 `json:"seq"`
+	DocID    string `json:"id"`
+	RevID    string `json:"rev"`
+	Flags    uint8  `json:"flags,omitempty"`
+}
+
+// Saves the channel caches' entries to the metadata bucket.  Only the entries up to the last sequence with no
+// skipped sequences before it are saved, as a skipped sequence may turn up in any channel.
+func (c *changeCache) persistChannelCaches() {
+	c.lock.RLock()
+	if c.stopped {
+		c.lock.RUnlock()
+		return
+	}
+	validTo := c.nextSequence - 1
+	caches := make([]*channelCache, 0, len(c.channelCaches))
+	for _, cache := range c.channelCaches {
+		caches = append(caches, cache)
+	}
+	c.lock.RUnlock()
+	if oldestSkipped := c.getOldestSkippedSequence(); oldestSkipped > 0 && oldestSkipped <= validTo {
+		validTo = oldestSkipped - 1
+	}
+
+	snapshot := channelCacheSnapshot{
+		SavedAt:  time.Now().UTC(),
+		ValidTo:  validTo,
+		Channels: make(map[string]*channelSnapshotEntries, len(caches)),
+	}
+	type channelEntries struct {
+		name    string
+		entries *channelSnapshotEntries
+		lastSeq uint64
+	}
+	channels := make([]channelEntries, 0, len(caches))
+	for _, cache := range caches {
+		if entries, lastSeq := cache.snapshotEntries(validTo); entries != nil {
+			channels = append(channels, channelEntries{name: cache.channelName, entries: entries, lastSeq: lastSeq})
+		}
+	}
+	sort.Slice(channels, func(i, j int) bool { return channels[i].lastSeq > channels[j].lastSeq })
+	numEntries := 0
+	for _, channel := range channels {
+		if numEntries += len(channel.entries.Entries); numEntries > kMaxChannelCacheSnapshotEntries {
+			break
+		}
+		snapshot.Channels[channel.name] = channel.entries
+	}
+
+	if err := c.context.MetadataBucket.Set(ChannelCacheSnapshotKey, 0, snapshot); err != nil {
+		base.Warnf(base.KeyCache, "Unable to save channel caches of db %s: %v", base.MD(c.context.Name), err)
+		return
+	}
+	base.Debugf(base.KeyCache, "Saved %d channel caches of db %s, valid to #%d", len(snapshot.Channels), base.MD(c.context.Name), validTo)
+}
+
+// Returns the cache's entries up to validTo, and the sequence of the last one, or nil if the cache has none.
+func (c *channelCache) snapshotEntries(validTo uint64) (*channelSnapshotEntries, uint64) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if c.validFrom > validTo {
+		return nil, 0
+	}
+	snapshot := &channelSnapshotEntries{ValidFrom: c.validFrom, Entries: make([]channelSnapshotEntry, 0, len(c.logs))}
+	for _, entry := range c.logs {
+		if entry.Sequence > validTo {
+			break
+		}
+		snapshot.Entries = append(snapshot.Entries, channelSnapshotEntry{
+			Sequence: entry.Sequence,
+			DocID:    entry.DocID,
+			RevID:    entry.RevID,
+			Flags:    entry.Flags,
+		})
+	}
+	if len(snapshot.Entries) == 0 {
+		return nil, 0
+	}
+	return snapshot, snapshot.Entries[len(snapshot.Entries)-1].Sequence
+}
+
+// Loads the channel caches saved by persistChannelCaches, for restoring as the channels are used.  Presumes that the
+// change cache is already locked, and its initial sequence set.
+func (c *changeCache) _loadChannelCacheSnapshot() {
+	var snapshot channelCacheSnapshot
+	if _, err := c.context.MetadataBucket.Get(ChannelCacheSnapshotKey, &snapshot); err != nil {
+		if !base.IsKeyNotFoundError(c.context.MetadataBucket, err) {
+			base.Warnf(base.KeyCache, "Unable to load saved channel caches of db %s: %v", base.MD(c.context.Name), err)
+		}
+		return
+	}
+	if age := time.Since(snapshot.SavedAt); age > kMaxChannelCacheSnapshotAge {
+		base.Infof(base.KeyCache, "Not restoring channel caches of db %s saved %v ago", base.MD(c.context.Name), age)
+		return
+	} else if snapshot.ValidTo > c.initialSequence {
+		// The sequence counter's gone backwards since the snapshot was saved, so it's not for this bucket's docs
+		base.Warnf(base.KeyCache, "Not restoring channel caches of db %s, valid to #%d, after the current sequence #%d", base.MD(c.context.Name), snapshot.ValidTo, c.initialSequence)
+		return
+	}
+
+	c.savedCaches = make(map[string]*savedCache, len(snapshot.Channels))
+	for name, channel := range snapshot.Channels {
+		c.savedCaches[name] = &savedCache{validFrom: channel.ValidFrom, validTo: snapshot.ValidTo, entries: channel.Entries}
+	}
+	base.Infof(base.KeyCache, "Loaded %d saved channel caches of db %s, valid to #%d", len(c.savedCaches), base.MD(c.context.Name), snapshot.ValidTo)
+}
+
+// Saved entries of a channel cache, waiting to be restored the first time the channel's changes are requested.
+type savedCache struct {
+	validFrom uint64
+	validTo   uint64
+	entries   []channelSnapshotEntry
+}
+
+// Restores the cache's saved entries, if it has any.  The changes in the channel since they were saved, up to the
+// cache's validFrom, are queried and added to them, so the restored cache has no gaps.
+func (c *channelCache) restoreSnapshot() {
+	c.lock.RLock()
+	pending := c.pendingRestore != nil
+	c.lock.RUnlock()
+	if !pending {
+		return
+	}
+
+	c.viewLock.Lock()
+	defer c.viewLock.Unlock()
+	c.lock.Lock()
+	restore := c.pendingRestore
+	c.pendingRestore = nil
+	endSeq := c.validFrom
+	c.lock.Unlock()
+	if restore == nil {
+		return // Restored by another goroutine while waiting for the view lock
+	}
+
+	now := time.Now()
+	changes := make(LogEntries, 0, len(restore.entries))
+	for _, entry := range restore.entries {
+		changes = append(changes, &LogEntry{
+			Sequence:     entry.Sequence,
+			DocID:        entry.DocID,
+			RevID:        entry.RevID,
+			Flags:        entry.Flags,
+			TimeReceived: now, // So the restored entries are kept as long as new ones
+		})
+	}
+	if startSeq := restore.validTo + 1; startSeq <= endSeq {
+		gap, err := c.context.getChangesInChannelFromQuery(c.channelName, startSeq, endSeq, 0, false)
+		if err != nil {
+			base.Warnf(base.KeyCache, "Unable to restore saved cache of channel %q: %v", base.UDChannel(c.channelName), err)
+			return
+		}
+		changes = append(changes, gap...)
+	}
+
+	// The cache only has one entry per doc, its latest
+	seen := make(map[string]struct{}, len(changes))
+	unique := make(LogEntries, len(changes))
+	n := len(unique)
+	for i := len(changes) - 1; i >= 0; i-- {
+		if _, found := seen[changes[i].DocID]; !found {
+			seen[changes[i].DocID] = struct{}{}
+			n--
+			unique[n] = changes[i]
+		}
+	}
+	numRestored := c.prependChanges(unique[n:], restore.validFrom, endSeq)
+	base.Infof(base.KeyCache, "Restored saved cache of channel %q with %d entries, valid from #%d", base.UDChannel(c.channelName), numRestored, restore.validFrom)
+}
@@ -13,6 +13,7 @@ import (
 	"fmt"
 	"log"
 	"math/rand"
+	"regexp"
 	"strconv"
 	"testing"
 	"time"
@@ -825,3 +826,101 @@ func generateDocs(percentInsert float64, N int) ([]string, []string) {
 	}
 	return docIDs, revStrings
 }
+
+// Benchmarks adding entries to a full channel cache, which prunes the oldest entry for each one added
+func BenchmarkChannelCacheInsertFull(b *testing.B) {
+
+	defer base.DisableTestLogging()()
+	context := testBucketContext()
+	defer context.Close()
+	defer base.DecrNumOpenBuckets(context.Bucket.GetName())
+
+	for _, cacheLength := range []int{DefaultChannelCacheMaxLength, 100000, 1000000} {
+		cache := newFullChannelCache(context, cacheLength)
+		nextSeq := uint64(cacheLength + 1)
+		b.Run(fmt.Sprintf("entries_%d", cacheLength), func(b *testing.B) {
+			docIDs := make([]string, b.N)
+			for i := 0; i < b.N; i++ {
+				docIDs[i] = fmt.Sprintf("long_document_id_for_sufficient_equals_complexity_%012d", nextSeq+uint64(i))
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				cache.addToCache(e(nextSeq, docIDs[i], "1-a"), false)
+				nextSeq++
+			}
+		})
+	}
+}
+
+// Benchmarks querying channel caches of various lengths, for just the latest changes and for every change
+func BenchmarkChannelCacheGetChanges(b *testing.B) {
+
+	defer base.DisableTestLogging()()
+	context := testBucketContext()
+	defer context.Close()
+	defer base.DecrNumOpenBuckets(context.Bucket.GetName())
+
+	for _, cacheLength := range []int{DefaultChannelCacheMaxLength, 100000, 1000000} {
+		cache := newFullChannelCache(context, cacheLength)
+		for _, numChanges := range []int{100, cacheLength} {
+			options := ChangesOptions{Since: SequenceID{Seq: uint64(cacheLength - numChanges)}}
+			b.Run(fmt.Sprintf("entries_%d/changes_%d", cacheLength, numChanges), func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					changes, err := cache.GetChanges(options)
+					if err != nil || len(changes) != numChanges {
+						b.Fatalf("Expected %d changes, got %d (err=%v)", numChanges, len(changes), err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// Returns a channel cache holding cacheLength entries, with sequences from 1 to cacheLength.
+func newFullChannelCache(context *DatabaseContext, cacheLength int) *channelCache {
+	options := CacheOptions{ChannelCacheOptions: ChannelCacheOptions{ChannelCacheMaxLength: cacheLength}}
+	cache := newChannelCacheWithOptions(context, "Benchmark", 0, options)
+	for i := 1; i <= cacheLength; i++ {
+		cache.addToCache(e(uint64(i), fmt.Sprintf("long_document_id_for_sufficient_equals_complexity_%012d", i), "1-a"), false)
+	}
+	return cache
+}
+
+func TestChannelCacheOverrides(t *testing.T) {
+	context := testBucketContext()
+	defer context.Close()
+	defer base.DecrNumOpenBuckets(context.Bucket.GetName())
+
+	options := CacheOptions{
+		ChannelCacheOptions: ChannelCacheOptions{ChannelCacheMaxLength: 100, ChannelCacheAge: time.Minute},
+		ChannelCacheOverrides: []ChannelCacheOverride{
+			{ChannelPattern: regexp.MustCompile("^global$"), ChannelCacheOptions: ChannelCacheOptions{ChannelCacheMaxLength: 5000}},
+			{ChannelPattern: regexp.MustCompile("^user-"), ChannelCacheOptions: ChannelCacheOptions{ChannelCacheMaxLength: 10, ChannelCacheMinLength: 5}},
+			{ChannelPattern: regexp.MustCompile("^user-admin"), ChannelCacheOptions: ChannelCacheOptions{ChannelCacheMaxLength: 1000}},
+		},
+	}
+
+	// Options that aren't overridden are the same as other channels'
+	cache := newChannelCacheWithOptions(context, "global", 0, options)
+	assert.Equal(t, ChannelCacheOptions{ChannelCacheMaxLength: 5000, ChannelCacheMinLength: DefaultChannelCacheMinLength, ChannelCacheAge: time.Minute}, *cache.options)
+
+	// The first matching override applies
+	cache = newChannelCacheWithOptions(context, "user-admin", 0, options)
+	assert.Equal(t, ChannelCacheOptions{ChannelCacheMaxLength: 10, ChannelCacheMinLength: 5, ChannelCacheAge: time.Minute}, *cache.options)
+
+	cache = newChannelCacheWithOptions(context, "globalish", 0, options)
+	assert.Equal(t, ChannelCacheOptions{ChannelCacheMaxLength: 100, ChannelCacheMinLength: DefaultChannelCacheMinLength, ChannelCacheAge: time.Minute}, *cache.options)
+	for i := 1; i <= 20; i++ {
+		cache.addToCache(e(uint64(i), fmt.Sprintf("doc%d", i), "1-a"), false)
+	}
+	assert.Len(t, cache.logs, 20)
+
+	// Overridden max length is enforced
+	cache = newChannelCacheWithOptions(context, "user-bob", 0, options)
+	for i := 1; i <= 20; i++ {
+		cache.addToCache(e(uint64(i), fmt.Sprintf("doc%d", i), "1-a"), false)
+	}
+	assert.Len(t, cache.logs, 10)
+}
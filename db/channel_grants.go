@@ -0,0 +1,132 @@
+package db
+
+import (
+	"net/http"
+
+	"github.com/couchbase/sync_gateway/auth"
+	"github.com/couchbase/sync_gateway/base"
+	ch "github.com/couchbase/sync_gateway/channels"
+)
+
+// A change to the admin channel grants of a set of users and roles, made by UpdateChannelGrants.
+type ChannelGrantRequest struct {
+	Users  []string `json:"users,omitempty"`
+	Roles  []string `json:"roles,omitempty"`
+	Grant  []string `json:"grant,omitempty"`  // Channels to add to each principal's admin channels
+	Revoke []string `json:"revoke,omitempty"` // Channels to remove from each principal's admin channels
+}
+
+// Outcomes of granting channels to a principal
+const (
+	ChannelGrantUpdated   = "updated"
+	ChannelGrantUnchanged = "unchanged" // The principal already had the grants given, and none of those revoked
+	ChannelGrantNotFound  = "not_found"
+	ChannelGrantFailed    = "failed"
+)
+
+// The outcome of UpdateChannelGrants for one principal.
+type ChannelGrantResult struct {
+	Name          string   `json:"name"`
+	Status        string   `json:"status"`
+	AdminChannels []string `json:"admin_channels,omitempty"` // The principal's admin channels afterwards
+	Error         string   `json:"error,omitempty"`
+}
+
+// The outcome of UpdateChannelGrants, with a result for each principal in the order given in the request.
+type ChannelGrantResults struct {
+	Users     []ChannelGrantResult `json:"users"`
+	Roles     []ChannelGrantResult `json:"roles"`
+	Updated   int                  `json:"updated"`
+	Unchanged int                  `json:"unchanged"`
+	Failed    int                  `json:"failed"` // Including principals that weren't found
+}
+
+// Grants and revokes admin channels for many users and roles at once.  Principals that don't exist aren't created;
+// they're reported as not found.  A principal that can't be updated doesn't stop the others from being updated.
+func (dbc *DatabaseContext) UpdateChannelGrants(request ChannelGrantRequest) (*ChannelGrantResults, error) {
+	grant, err := ch.SetFromArray(request.Grant, ch.KeepStar)
+	if err != nil {
+		return nil, err
+	}
+	revoke, err := ch.SetFromArray(request.Revoke, ch.KeepStar)
+	if err != nil {
+		return nil, err
+	}
+	if len(grant) == 0 && len(revoke) == 0 {
+		return nil, base.HTTPErrorf(http.StatusBadRequest, "No channels to grant or revoke")
+	}
+	for channel := range grant {
+		if revoke.Contains(channel) {
+			return nil, base.HTTPErrorf(http.StatusBadRequest, "Channel %q is both granted and revoked", channel)
+		}
+	}
+
+	results := &ChannelGrantResults{
+		Users: make([]ChannelGrantResult, 0, len(request.Users)),
+		Roles: make([]ChannelGrantResult, 0, len(request.Roles)),
+	}
+	update := func(name string, isUser bool) ChannelGrantResult {
+		result := ChannelGrantResult{Name: name}
+		var err error
+		result.Status, result.AdminChannels, err = dbc.updateChannelGrant(name, isUser, grant, revoke)
+		if err != nil {
+			base.Warnf(base.KeyAuth, "Unable to update channel grants of %s: %v", base.UD(name), err)
+			result.Error = err.Error()
+		}
+		switch result.Status {
+		case ChannelGrantUpdated:
+			results.Updated++
+		case ChannelGrantUnchanged:
+			results.Unchanged++
+		default:
+			results.Failed++
+		}
+		return result
+	}
+	for _, name := range request.Roles {
+		results.Roles = append(results.Roles, update(name, false))
+	}
+	for _, name := range request.Users {
+		results.Users = append(results.Users, update(name, true))
+	}
+	base.Infof(base.KeyAuth, "Updated channel grants in db %s: %d updated, %d unchanged, %d failed", base.MD(dbc.Name), results.Updated, results.Unchanged, results.Failed)
+	return results, nil
+}
+
+// Applies the grants and revocations to the principal's latest admin channels, retrying on CAS mismatches like
+// UpdatePrincipal, so that concurrent updates of the principal aren't lost.
+func (dbc *DatabaseContext) updateChannelGrant(name string, isUser bool, grant, revoke base.Set) (status string, adminChannels []string, err error) {
+	status = ChannelGrantFailed
+	_, err = dbc.UpdatePrincipalWithCallback(name, isUser, true, func(princ auth.Principal) (*PrincipalConfig, error) {
+		if princ == nil {
+			status, adminChannels = ChannelGrantNotFound, nil
+			return nil, nil
+		}
+		existing := princ.ExplicitChannels().AsSet()
+		channels := existing.Union(grant)
+		for channel := range revoke {
+			channels = channels.Removing(channel)
+		}
+		if channels.Equals(existing) {
+			status, adminChannels = ChannelGrantUnchanged, sortedSet(existing)
+			return nil, nil
+		}
+
+		// Keep the principal's other settings, as UpdatePrincipal replaces them all
+		info := &PrincipalConfig{
+			Name:             &name,
+			ExplicitChannels: channels,
+		}
+		if user, ok := princ.(auth.User); ok {
+			info.Email = user.Email()
+			info.Disabled = user.Disabled()
+			info.ExplicitRoleNames = user.ExplicitRoles().AllChannels()
+		}
+		status, adminChannels = ChannelGrantUpdated, sortedSet(channels)
+		return info, nil
+	})
+	if err != nil {
+		return ChannelGrantFailed, nil, err
+	}
+	return status, adminChannels, nil
+}
@@ -0,0 +1,409 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	sgbucket "github.com/couchbase/sg-bucket"
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbase/sync_gateway/channels"
+)
+
+const (
+	kRebuildBatchSize          = 500              // Entries buffered for a channel partition before they're written to its blocks
+	DefaultRebuildIdleTimeout  = 30 * time.Second // How long a rebuild waits for mutations before finishing
+	kRebuildIdleCheckFrequency = time.Second
+)
+
+// Options for RebuildChannelIndex.
+type ChannelIndexRebuildOptions struct {
+	Channels    []string      // Channels to rebuild.  If empty, every channel found in the data bucket is rebuilt
+	IdleTimeout time.Duration // How long to wait for mutations from vbuckets that haven't reached their high sequence.  Defaults to DefaultRebuildIdleTimeout
+	Store       DenseStore    // Where the channel blocks are kept.  Defaults to the index bucket
+}
+
+// Results of RebuildChannelIndex.
+type ChannelIndexRebuildStats struct {
+	DocsProcessed int            // Documents replayed from the data bucket
+	Entries       map[string]int // Number of entries indexed, by channel
+}
+
+// Rebuilds the dense channel index for the given channels, or for every channel, by replaying the sync metadata of
+// every document in the data bucket over DCP, using the data bucket's spec to connect.  The existing index for each
+// rebuilt channel is removed first, in each of the index's partitions.  Intended for recovering from index corruption
+// or a change to the partition map, so the database must be offline - index writers in particular mustn't be running
+// while the index is rebuilt.
+func RebuildChannelIndex(bucket base.Bucket, spec base.BucketSpec, indexBucket base.Bucket, options ChannelIndexRebuildOptions) (*ChannelIndexRebuildStats, error) {
+
+	partitions, err := loadRebuildIndexPartitions(indexBucket)
+	if err != nil {
+		return nil, err
+	}
+
+	maxVbNo, err := bucket.GetMaxVbno()
+	if err != nil {
+		return nil, err
+	}
+	_, highSeqnos, err := bucket.GetStatsVbSeqno(maxVbNo, false)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to retrieve vbucket sequences from the data bucket: %v", err)
+	}
+
+	store := options.Store
+	if store == nil {
+		store = NewBucketDenseStore(indexBucket)
+	}
+	rb := newChannelIndexRebuilder(indexBucket, store, partitions, options.Channels)
+	rb.setHighSeqnos(highSeqnos)
+
+	idleTimeout := options.IdleTimeout
+	if idleTimeout <= 0 {
+		idleTimeout = DefaultRebuildIdleTimeout
+	}
+
+	base.Infof(base.KeyAccel, "Rebuilding channel index from %d vbuckets", maxVbNo)
+	terminator := make(chan bool)
+	args := sgbucket.FeedArguments{
+		Backfill:   0, // Replay every vbucket from the start
+		Terminator: terminator,
+	}
+	err = base.StartDCPFeedWithSequences(bucket, spec, args, func(event sgbucket.FeedEvent, seq uint64) bool {
+		rb.processEvent(event, seq)
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ticker := time.NewTicker(kRebuildIdleCheckFrequency)
+	for waiting := true; waiting; {
+		select {
+		case <-rb.caughtUp:
+			waiting = false
+		case <-ticker.C:
+			if remaining, idle := rb.progress(); idle >= idleTimeout {
+				base.Warnf(base.KeyAll, "No mutations received for %v - finishing channel index rebuild with %d vbuckets short of their high sequence", idleTimeout, remaining)
+				waiting = false
+			}
+		}
+	}
+	ticker.Stop()
+	close(terminator)
+
+	if err := rb.finish(); err != nil {
+		return nil, err
+	}
+	base.Infof(base.KeyAccel, "Rebuilt channel index for %d channels from %d documents", len(rb.stats.Entries), rb.stats.DocsProcessed)
+	return rb.stats, nil
+}
+
+// Loads the index's partition map.  An index that doesn't have one yet can't be rebuilt, since its partitioning is
+// defined by the index writer that creates it.
+func loadRebuildIndexPartitions(indexBucket base.Bucket) (*base.IndexPartitions, error) {
+	value, _, err := indexBucket.GetRaw(base.KIndexPartitionKey)
+	if err != nil {
+		if base.IsKeyNotFoundError(indexBucket, err) {
+			return nil, errors.New("Channel index has no partition map - it must be initialized by an index writer before it can be rebuilt")
+		}
+		return nil, err
+	}
+	var partitionDefs base.PartitionStorageSet
+	if err := json.Unmarshal(value, &partitionDefs); err != nil {
+		return nil, fmt.Errorf("Unable to parse channel index partition map: %v", err)
+	}
+	return base.NewIndexPartitions(partitionDefs), nil
+}
+
+// channelIndexRebuilder writes the entries replayed from the data bucket to the dense channel index.  Feed events
+// can arrive concurrently from different vbuckets, so all of its state is protected by lock.
+type channelIndexRebuilder struct {
+	indexBucket base.Bucket
+	store       DenseStore
+	partitions  *base.IndexPartitions
+	channels    base.Set                                       // Channels to rebuild, or nil for all channels
+	lock        sync.Mutex                                     // Protects the fields below
+	writers     map[string]map[uint16]*channelPartitionRebuild // Keyed by channel, then partition
+	clocks      map[string]*base.SequenceClockImpl             // Latest sequence indexed for each channel
+	highSeqnos  map[uint16]uint64                              // Sequence each vbucket must reach to have been replayed
+	lastEvent   time.Time                                      // When the last feed event was received
+	caughtUp    chan struct{}                                  // Closed once every vbucket has been replayed
+	finished    bool                                           // Set once finish is called; later events are ignored
+	err         error                                          // The first error writing to the index
+	stats       *ChannelIndexRebuildStats
+}
+
+func newChannelIndexRebuilder(indexBucket base.Bucket, store DenseStore, partitions *base.IndexPartitions, channelNames []string) *channelIndexRebuilder {
+	rb := &channelIndexRebuilder{
+		indexBucket: indexBucket,
+		store:       store,
+		partitions:  partitions,
+		writers:     make(map[string]map[uint16]*channelPartitionRebuild),
+		clocks:      make(map[string]*base.SequenceClockImpl),
+		highSeqnos:  make(map[uint16]uint64),
+		lastEvent:   time.Now(),
+		caughtUp:    make(chan struct{}),
+		stats:       &ChannelIndexRebuildStats{Entries: make(map[string]int)},
+	}
+	if len(channelNames) > 0 {
+		rb.channels = base.SetFromArray(channelNames)
+	}
+	return rb
+}
+
+// Sets the sequences the vbuckets must reach.  Vbuckets without any mutations are already caught up.
+func (rb *channelIndexRebuilder) setHighSeqnos(highSeqnos map[uint16]uint64) {
+	rb.lock.Lock()
+	defer rb.lock.Unlock()
+	for vbNo, seq := range highSeqnos {
+		if seq > 0 {
+			rb.highSeqnos[vbNo] = seq
+		}
+	}
+	if len(rb.highSeqnos) == 0 {
+		close(rb.caughtUp)
+	}
+}
+
+// Returns the number of vbuckets that haven't been replayed yet, and how long it's been since the last feed event.
+func (rb *channelIndexRebuilder) progress() (remaining int, idle time.Duration) {
+	rb.lock.Lock()
+	defer rb.lock.Unlock()
+	return len(rb.highSeqnos), time.Since(rb.lastEvent)
+}
+
+// Indexes the document in a feed event in each of the channels it's in or has been removed from.
+func (rb *channelIndexRebuilder) processEvent(event sgbucket.FeedEvent, seq uint64) {
+	rb.lock.Lock()
+	defer rb.lock.Unlock()
+	if rb.finished {
+		return
+	}
+	rb.lastEvent = time.Now()
+
+	if event.Opcode == sgbucket.FeedOpMutation || event.Opcode == sgbucket.FeedOpDeletion {
+		rb._addDocument(string(event.Key), event.VbNo, seq, event.Value, event.DataType)
+	}
+
+	if highSeqno, ok := rb.highSeqnos[event.VbNo]; ok && seq >= highSeqno {
+		delete(rb.highSeqnos, event.VbNo)
+		if len(rb.highSeqnos) == 0 {
+			close(rb.caughtUp)
+		}
+	}
+}
+
+// Adds index entries for a document, from its sync metadata.  Requires the lock.
+func (rb *channelIndexRebuilder) _addDocument(docID string, vbNo uint16, seq uint64, value []byte, dataType uint8) {
+	entries, ok := feedDocIndexEntries(docID, vbNo, seq, value, dataType, false)
+	if !ok {
+		return
+	}
+	rb.stats.DocsProcessed++
+	for channelName, entry := range entries {
+		rb._addEntry(channelName, entry)
+	}
+}
+
+// Buffers an entry for a channel, writing the buffer to the index once it's full.  Requires the lock.
+func (rb *channelIndexRebuilder) _addEntry(channelName string, entry *LogEntry) {
+	if rb.err != nil || (rb.channels != nil && !rb.channels.Contains(channelName)) {
+		return
+	}
+	writer, err := rb._getWriter(channelName, rb.partitions.PartitionForVb(entry.VbNo))
+	if err != nil {
+		rb.err = err
+		return
+	}
+	writer.pending = append(writer.pending, entry)
+	if len(writer.pending) >= kRebuildBatchSize {
+		if err := writer.flush(); err != nil {
+			rb.err = err
+			return
+		}
+	}
+	rb.clocks[channelName].SetMaxSequence(entry.VbNo, entry.Sequence)
+	rb.stats.Entries[channelName]++
+}
+
+// Returns the writer for a channel partition.  The first time a channel is seen, its existing index is removed from
+// every partition.  Requires the lock.
+func (rb *channelIndexRebuilder) _getWriter(channelName string, partition uint16) (*channelPartitionRebuild, error) {
+	channelWriters, ok := rb.writers[channelName]
+	if !ok {
+		if err := rb.clearChannel(channelName); err != nil {
+			return nil, err
+		}
+		channelWriters = make(map[uint16]*channelPartitionRebuild)
+		rb.writers[channelName] = channelWriters
+		rb.clocks[channelName] = base.NewSequenceClockImpl()
+	}
+	writer, ok := channelWriters[partition]
+	if !ok {
+		list := NewDenseBlockList(channelName, partition, rb.store)
+		if list == nil {
+			return nil, fmt.Errorf("Unable to initialize block list for channel %s partition %d", base.UDChannel(channelName), partition)
+		}
+		writer = &channelPartitionRebuild{
+			list:   list,
+			docIDs: make(map[string]struct{}),
+		}
+		channelWriters[partition] = writer
+	}
+	return writer, nil
+}
+
+// Removes a channel's block lists, blocks and channel clock from the index.
+func (rb *channelIndexRebuilder) clearChannel(channelName string) error {
+	base.Debugf(base.KeyAccel, "Removing existing channel index for channel %s", base.UDChannel(channelName))
+	for _, partitionDef := range rb.partitions.PartitionDefs {
+		if err := clearDenseBlockList(channelName, partitionDef.Index, rb.store); err != nil {
+			return err
+		}
+	}
+	return deleteIndexDoc(rb.indexBucket, GetChannelClockKey(channelName))
+}
+
+// Writes the remaining buffered entries and the channel clocks.  Channels that were asked for but aren't in any
+// document are left with an empty index.
+func (rb *channelIndexRebuilder) finish() error {
+	rb.lock.Lock()
+	defer rb.lock.Unlock()
+	rb.finished = true
+	if rb.err != nil {
+		return rb.err
+	}
+
+	for channelName, channelWriters := range rb.writers {
+		for _, writer := range channelWriters {
+			if err := writer.flush(); err != nil {
+				return err
+			}
+		}
+		value, err := rb.clocks[channelName].Marshal()
+		if err != nil {
+			return err
+		}
+		if err := rb.indexBucket.SetRaw(GetChannelClockKey(channelName), 0, value); err != nil {
+			return err
+		}
+	}
+
+	for channelName := range rb.channels {
+		if _, ok := rb.writers[channelName]; !ok {
+			if err := rb.clearChannel(channelName); err != nil {
+				return err
+			}
+			rb.stats.Entries[channelName] = 0
+		}
+	}
+	return nil
+}
+
+// The rebuild of one partition of a channel's index.
+type channelPartitionRebuild struct {
+	list    *DenseBlockList     // The partition's block list
+	pending []*LogEntry         // Entries that haven't been written yet
+	docIDs  map[string]struct{} // Documents already indexed, whose entries replace an earlier one
+}
+
+// Writes the pending entries to the active block, adding blocks as they fill up.
+func (w *channelPartitionRebuild) flush() error {
+	entries := w.pending
+	w.pending = nil
+	for _, entry := range entries {
+		if _, ok := w.docIDs[entry.DocID]; !ok {
+			// The first entry for a doc is appended, without looking for an earlier one to replace
+			entry.Flags |= channels.Added
+			w.docIDs[entry.DocID] = struct{}{}
+		}
+	}
+
+	block := w.list.GetActiveBlock()
+	for len(entries) > 0 {
+		overflow, pendingRemoval, _, casFailure, err := block.AddEntrySet(entries, w.list.store)
+		if err != nil {
+			return err
+		}
+		if casFailure {
+			return fmt.Errorf("Block %s was updated during the rebuild - the index must not be in use while it's rebuilt", base.UD(block.Key))
+		}
+		if len(pendingRemoval) > 0 {
+			if err := removeFromPreviousBlocks(w.list, pendingRemoval); err != nil {
+				return err
+			}
+			block = w.list.GetActiveBlock()
+		}
+		if len(overflow) == 0 {
+			break
+		}
+		if block, err = w.list.AddBlock(); err != nil {
+			return err
+		}
+		entries = overflow
+	}
+	return nil
+}
+
+// Removes the earlier entries of the given documents from the blocks of a list before its active one, for new
+// entries that didn't replace one in the active block - documents the DCP feed replayed more than once, during a
+// rebuild.
+func removeFromPreviousBlocks(list *DenseBlockList, entries []*LogEntry) error {
+	activeEntry := list.ActiveListEntry()
+	if activeEntry == nil {
+		return nil
+	}
+	blockIndex := activeEntry.BlockIndex
+	for len(entries) > 0 {
+		previous, err := list.PreviousBlock(blockIndex)
+		if err != nil {
+			return err
+		}
+		if previous == nil {
+			break
+		}
+		if entries, err = list.LoadBlock(*previous).RemoveEntrySet(entries, list.store); err != nil {
+			return err
+		}
+		blockIndex = previous.BlockIndex
+	}
+	// Loading earlier block lists replaces the active list's cas, so reload it before the next write
+	_, err := list.ReloadDenseBlockList()
+	return err
+}
+
+// Removes a channel partition's block lists and blocks from the index, including rotated lists.
+func clearDenseBlockList(channelName string, partition uint16, store DenseStore) error {
+	list, err := loadExistingDenseBlockList(channelName, partition, store)
+	if err != nil || list == nil {
+		return err
+	}
+	for list.validFromCounter > 0 {
+		if err := list.LoadPrevious(); err != nil {
+			base.Warnf(base.KeyAll, "Unable to load rotated block list for channel %s partition %d - its blocks won't be removed: %v", base.UDChannel(channelName), partition, err)
+			break
+		}
+	}
+
+	for _, entry := range list.blocks {
+		if err := store.Delete(list.generateBlockKey(entry.BlockIndex)); err != nil {
+			return err
+		}
+	}
+	for count := uint32(0); count < list.activeCounter; count++ {
+		if err := store.Delete(list.generateNumberedListKey(count)); err != nil {
+			return err
+		}
+	}
+	return store.Delete(list.activeKey)
+}
+
+// Deletes a doc from the index, if it exists.
+func deleteIndexDoc(indexBucket base.Bucket, key string) error {
+	if err := indexBucket.Delete(key); err != nil && !base.IsKeyNotFoundError(indexBucket, err) {
+		return err
+	}
+	return nil
+}
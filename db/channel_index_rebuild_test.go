@@ -0,0 +1,80 @@
+package db
+
+import (
+	"testing"
+
+	sgbucket "github.com/couchbase/sg-bucket"
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbase/sync_gateway/channels"
+	"github.com/stretchr/testify/assert"
+)
+
+func rebuildFeedEvent(docID string, vbNo uint16, body string) sgbucket.FeedEvent {
+	return sgbucket.FeedEvent{
+		Opcode:   sgbucket.FeedOpMutation,
+		Key:      []byte(docID),
+		Value:    []byte(body),
+		DataType: base.MemcachedDataTypeJSON,
+		VbNo:     vbNo,
+	}
+}
+
+func TestChannelIndexRebuild(t *testing.T) {
+
+	testIndexBucket := base.GetTestIndexBucketOrPanic()
+	defer testIndexBucket.Close()
+	indexBucket := testIndexBucket.Bucket
+
+	// Without a partition map, the index can't be rebuilt
+	_, err := loadRebuildIndexPartitions(indexBucket)
+	assert.Error(t, err)
+
+	_, err = base.SeedTestPartitionMap(indexBucket, 64)
+	assert.NoError(t, err)
+	partitions, err := loadRebuildIndexPartitions(indexBucket)
+	assert.NoError(t, err)
+
+	// A stale entry, which the rebuild should remove
+	store := NewBucketDenseStore(indexBucket)
+	staleList := NewDenseBlockList("ABC", 1, store)
+	_, _, _, _, err = staleList.GetActiveBlock().AddEntrySet([]*LogEntry{makeBlockEntry("stale", "1-a", 20, 5, IsNotRemoval, IsAdded)}, store)
+	assert.NoError(t, err)
+
+	rb := newChannelIndexRebuilder(indexBucket, store, partitions, []string{"ABC", "DEF", "EMPTY"})
+	rb.setHighSeqnos(map[uint16]uint64{1: 2, 20: 7})
+
+	rb.processEvent(rebuildFeedEvent("doc1", 1, `{"_sync":{"rev":"2-b","sequence":10,"channels":{"ABC":null,"DEF":{"seq":10,"rev":"2-b"}}}}`), 2)
+	rb.processEvent(rebuildFeedEvent("doc2", 20, `{"_sync":{"rev":"1-a","sequence":11,"channels":{"ABC":null,"XYZ":null}}}`), 7)
+	rb.processEvent(rebuildFeedEvent("_sync:user:bob", 20, `{"name":"bob"}`), 8)
+	select {
+	case <-rb.caughtUp:
+	default:
+		assert.Fail(t, "Rebuild should have caught up with the vbuckets' high sequences")
+	}
+	assert.NoError(t, rb.finish())
+
+	assert.Equal(t, 2, rb.stats.DocsProcessed)
+	assert.Equal(t, map[string]int{"ABC": 2, "DEF": 1, "EMPTY": 0}, rb.stats.Entries)
+
+	abcEntries := NewDenseBlockListReader("ABC", 0, store).GetActiveBlock().GetAllEntries()
+	assert.Len(t, abcEntries, 1)
+	assertLogEntry(t, abcEntries[0], "doc1", "2-b", 1, 2)
+	abcEntries = NewDenseBlockListReader("ABC", 1, store).GetActiveBlock().GetAllEntries()
+	assert.Len(t, abcEntries, 1)
+	assertLogEntry(t, abcEntries[0], "doc2", "1-a", 20, 7)
+
+	defEntries := NewDenseBlockListReader("DEF", 0, store).GetActiveBlock().GetAllEntries()
+	assert.Len(t, defEntries, 1)
+	assertLogEntry(t, defEntries[0], "doc1", "2-b", 1, 2)
+	assert.True(t, defEntries[0].Flags&channels.Removed != 0)
+
+	// Channels that weren't asked for aren't rebuilt
+	assert.Nil(t, NewDenseBlockListReader("XYZ", 1, store))
+
+	value, _, err := indexBucket.GetRaw(GetChannelClockKey("ABC"))
+	assert.NoError(t, err)
+	clock := base.NewSequenceClockImpl()
+	assert.NoError(t, clock.Unmarshal(value))
+	assert.Equal(t, uint64(2), clock.GetSequence(1))
+	assert.Equal(t, uint64(7), clock.GetSequence(20))
+}
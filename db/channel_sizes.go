@@ -0,0 +1,203 @@
+package db
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/couchbase/sync_gateway/base"
+)
+
+// Key of the doc the channel size estimates are stored in.  It's shared by all of the database's nodes, which each
+// add the changes made by their own writes to it.
+const kChannelSizesKey = KSyncKeyPrefix + "channelSizes"
+
+// How often each node adds its writes' changes to the channel sizes to the stored estimates
+const kChannelSizesFlushInterval = 10 * time.Second
+
+type ChannelSizeOptions struct {
+	Enabled bool // Whether the number and size of the documents in each channel are estimated
+}
+
+// ChannelSize is the estimated number and total stored size of the live documents in a channel.
+type ChannelSize struct {
+	Docs  int64 `json:"docs"`
+	Bytes int64 `json:"bytes"`
+}
+
+type channelSizesDoc struct {
+	Channels map[string]*ChannelSize `json:"channels"`
+}
+
+// channelSizeTracker estimates the size of each channel, by counting the documents added to and removed from it as
+// they're written.  The changes are batched in memory and periodically added to the estimates in the metadata
+// bucket, so writes don't each have to update it.  The estimates are approximate: documents already in a channel
+// when tracking was enabled aren't counted, and a node's changes since its last flush are lost if it crashes.
+// All methods are safe to call on a nil *channelSizeTracker, which tracks nothing.
+type channelSizeTracker struct {
+	context    *DatabaseContext
+	lock       sync.Mutex              // Protects pending
+	pending    map[string]*ChannelSize // Changes made by this node that haven't been flushed yet
+	terminator chan struct{}           // Stops the flush task
+}
+
+func newChannelSizeTracker(context *DatabaseContext) *channelSizeTracker {
+	t := &channelSizeTracker{
+		context:    context,
+		pending:    map[string]*ChannelSize{},
+		terminator: make(chan struct{}),
+	}
+	go func() {
+		for {
+			select {
+			case <-time.After(kChannelSizesFlushInterval):
+				t.flush()
+			case <-t.terminator:
+				return
+			}
+		}
+	}()
+	return t
+}
+
+// Returns the channels a document's current revision is in, or nil if the document is deleted or doesn't exist.
+func (doc *document) liveChannels() base.Set {
+	if !doc.isLive() {
+		return nil
+	}
+	channels := base.Set{}
+	for channel, removal := range doc.Channels {
+		if removal == nil {
+			channels[channel] = struct{}{}
+		}
+	}
+	return channels
+}
+
+// Records a document update that took it from being in prevChannels with a stored size of prevBytes to being in
+// newChannels with a stored size of newBytes.
+func (t *channelSizeTracker) recordUpdate(prevChannels base.Set, prevBytes int, newChannels base.Set, newBytes int) {
+	if t == nil || (len(prevChannels) == 0 && len(newChannels) == 0) {
+		return
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for channel := range prevChannels {
+		size := t._pendingSize(channel)
+		if newChannels.Contains(channel) {
+			size.Bytes += int64(newBytes - prevBytes)
+		} else {
+			size.Docs--
+			size.Bytes -= int64(prevBytes)
+		}
+	}
+	for channel := range newChannels {
+		if !prevChannels.Contains(channel) {
+			size := t._pendingSize(channel)
+			size.Docs++
+			size.Bytes += int64(newBytes)
+		}
+	}
+}
+
+// Requires the lock to be held.
+func (t *channelSizeTracker) _pendingSize(channel string) *ChannelSize {
+	size, ok := t.pending[channel]
+	if !ok {
+		size = &ChannelSize{}
+		t.pending[channel] = size
+	}
+	return size
+}
+
+// Adds the changes to the channels' sizes to the estimates in the metadata bucket.  If they can't be stored, they're
+// kept for the next flush.
+func (t *channelSizeTracker) flush() {
+	t.lock.Lock()
+	pending := t.pending
+	t.pending = map[string]*ChannelSize{}
+	t.lock.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+
+	_, err := t.context.MetadataBucket.Update(kChannelSizesKey, 0, func(currentValue []byte) ([]byte, *uint32, error) {
+		var sizes channelSizesDoc
+		if len(currentValue) > 0 {
+			if err := json.Unmarshal(currentValue, &sizes); err != nil {
+				return nil, nil, err
+			}
+		}
+		if sizes.Channels == nil {
+			sizes.Channels = make(map[string]*ChannelSize, len(pending))
+		}
+		addChannelSizes(sizes.Channels, pending)
+		clampChannelSizes(sizes.Channels)
+		updated, err := json.Marshal(sizes)
+		return updated, nil, err
+	})
+	if err != nil {
+		base.Warnf(base.KeyAll, "Unable to update channel size estimates of db %s: %v", base.MD(t.context.Name), err)
+		t.lock.Lock()
+		addChannelSizes(t.pending, pending)
+		t.lock.Unlock()
+	}
+}
+
+// Adds the changes in deltas to sizes.
+func addChannelSizes(sizes map[string]*ChannelSize, deltas map[string]*ChannelSize) {
+	for channel, delta := range deltas {
+		size, ok := sizes[channel]
+		if !ok {
+			size = &ChannelSize{}
+			sizes[channel] = size
+		}
+		size.Docs += delta.Docs
+		size.Bytes += delta.Bytes
+	}
+}
+
+// Removes the empty channels from the estimates.  Sizes that have become negative, because the channel had documents
+// that weren't counted, are clamped to zero.
+func clampChannelSizes(sizes map[string]*ChannelSize) {
+	for channel, size := range sizes {
+		if size.Docs <= 0 {
+			delete(sizes, channel)
+		} else if size.Bytes < 0 {
+			size.Bytes = 0
+		}
+	}
+}
+
+// Stops the flush task, after flushing the changes made since the last flush.
+func (t *channelSizeTracker) stop() {
+	if t == nil {
+		return
+	}
+	close(t.terminator)
+	t.flush()
+}
+
+// GetChannelSizes returns the estimated number and total size of the live documents in each channel, including the
+// changes made by this node that haven't been flushed yet.  Returns a 404 error if estimates aren't enabled.
+func (context *DatabaseContext) GetChannelSizes() (map[string]*ChannelSize, error) {
+	t := context.channelSizes
+	if t == nil {
+		return nil, base.HTTPErrorf(http.StatusNotFound, "Channel size estimates are not enabled for this database")
+	}
+	var stored channelSizesDoc
+	if _, err := context.MetadataBucket.Get(kChannelSizesKey, &stored); err != nil && !base.IsDocNotFoundError(err) {
+		return nil, err
+	}
+	sizes := make(map[string]*ChannelSize, len(stored.Channels))
+	for channel, size := range stored.Channels {
+		sizes[channel] = &ChannelSize{Docs: size.Docs, Bytes: size.Bytes}
+	}
+	t.lock.Lock()
+	addChannelSizes(sizes, t.pending)
+	t.lock.Unlock()
+	clampChannelSizes(sizes)
+	return sizes, nil
+}
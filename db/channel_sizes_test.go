@@ -0,0 +1,69 @@
+package db
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/couchbase/sync_gateway/channels"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChannelSizes(t *testing.T) {
+	db, testBucket := setupTestDB(t)
+	defer testBucket.Close()
+	defer tearDownTestDB(t, db)
+	db.ChannelMapper = channels.NewDefaultChannelMapper()
+
+	_, err := db.GetChannelSizes()
+	assertHTTPError(t, err, http.StatusNotFound)
+	db.channelSizes = newChannelSizeTracker(db.DatabaseContext)
+
+	rev1, err := db.Put("doc1", Body{"channels": []string{"A", "B"}})
+	assert.NoError(t, err)
+	_, err = db.Put("doc2", Body{"channels": []string{"A"}, "value": strings.Repeat("x", 1000)})
+	assert.NoError(t, err)
+
+	sizes, err := db.GetChannelSizes()
+	assert.NoError(t, err)
+	assert.Len(t, sizes, 2)
+	assert.Equal(t, int64(2), sizes["A"].Docs)
+	assert.Equal(t, int64(1), sizes["B"].Docs)
+	assert.True(t, sizes["A"].Bytes-sizes["B"].Bytes > 1000)
+
+	// Removing a doc from a channel only changes that channel
+	_, err = db.Put("doc1", Body{"channels": []string{"B"}, BodyRev: rev1})
+	assert.NoError(t, err)
+	sizes, err = db.GetChannelSizes()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), sizes["A"].Docs)
+	assert.Equal(t, int64(1), sizes["B"].Docs)
+
+	// Flushed estimates are the same as unflushed ones
+	db.channelSizes.flush()
+	assert.Len(t, db.channelSizes.pending, 0)
+	flushed, err := db.GetChannelSizes()
+	assert.NoError(t, err)
+	assert.Equal(t, sizes, flushed)
+
+	// Deleted and purged docs aren't counted, and empty channels aren't reported
+	doc2, err := db.GetDocument("doc2", DocUnmarshalSync)
+	assert.NoError(t, err)
+	_, err = db.DeleteDoc("doc2", doc2.CurrentRev)
+	assert.NoError(t, err)
+	assert.NoError(t, db.Purge("doc1"))
+	db.channelSizes.flush()
+	sizes, err = db.GetChannelSizes()
+	assert.NoError(t, err)
+	assert.Len(t, sizes, 0)
+}
+
+func TestClampChannelSizes(t *testing.T) {
+	sizes := map[string]*ChannelSize{"A": {Docs: 2, Bytes: 200}}
+	addChannelSizes(sizes, map[string]*ChannelSize{
+		"A": {Docs: -1, Bytes: -300},
+		"B": {Docs: -1, Bytes: -100}, // A doc that was in the channel before it was counted
+	})
+	clampChannelSizes(sizes)
+	assert.Equal(t, map[string]*ChannelSize{"A": {Docs: 1, Bytes: 0}}, sizes)
+}
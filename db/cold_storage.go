@@ -0,0 +1,100 @@
+package db
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/couchbase/sync_gateway/base"
+)
+
+// Default number of seconds old revision bodies can be kept for in the database's bucket.  Bodies kept for longer are
+// moved to cold storage.
+const DefaultColdStorageAfterSecs = uint32(3600)
+
+type ColdStorageOptions struct {
+	Enabled   bool        // Whether old revision bodies are moved to cold storage
+	Bucket    base.Bucket // Bucket the old revision bodies are moved to
+	AfterSecs uint32      // Old revision bodies kept for longer than this are moved
+}
+
+// Cold storage of old revision bodies, for databases that keep them for a long time (see old_rev_expiry_seconds).  A
+// body backed up for longer than the cold storage threshold is written to the cold storage bucket instead of the
+// database's bucket, and any copy already in the database's bucket is removed.  If the write to cold storage fails,
+// the body is kept in the database's bucket.  Lookups that miss in the database's bucket fall back to cold storage,
+// so old revisions are still served to open_revs requests and conflict resolution.  The cold copies of revisions are
+// removed when they're pruned from their document's history, and when the document is purged.
+// All methods are safe to call on a nil *coldRevisionStore, which moves nothing.
+type coldRevisionStore struct {
+	bucket    base.Bucket
+	dbName    string
+	afterSecs uint32
+}
+
+func newColdRevisionStore(bucket base.Bucket, dbName string, afterSecs uint32) *coldRevisionStore {
+	if afterSecs == 0 {
+		afterSecs = DefaultColdStorageAfterSecs
+	}
+	return &coldRevisionStore{bucket: bucket, dbName: dbName, afterSecs: afterSecs}
+}
+
+// The key of a revision body in cold storage.  The db name is included so databases can share a cold storage bucket.
+func (s *coldRevisionStore) key(docid string, revid string) string {
+	return fmt.Sprintf("_sync:coldrev:%s:%s:%d:%s", s.dbName, docid, len(revid), revid)
+}
+
+// Returns true if a revision body backed up with the given expiry is kept for longer than the cold storage threshold,
+// so belongs in cold storage.
+func (s *coldRevisionStore) moves(expiry uint32) bool {
+	if s == nil {
+		return false
+	}
+	return expiry == 0 || base.CbsExpiryToTime(expiry).After(time.Now().Add(time.Duration(s.afterSecs)*time.Second))
+}
+
+// Writes a revision body (as stored in the database's bucket) to cold storage.
+func (s *coldRevisionStore) set(docid string, revid string, body []byte, expiry uint32) error {
+	err := s.bucket.SetRaw(s.key(docid, revid), expiry, base.BinaryDocument(body))
+	if err != nil {
+		base.Warnf(base.KeyAll, "Unable to move revision body %q/%q to cold storage: %v", base.UDDocID(docid), revid, err)
+	}
+	return err
+}
+
+// Reads a revision body from cold storage.  Returns a 404 error if it isn't there.
+func (s *coldRevisionStore) get(docid string, revid string) ([]byte, error) {
+	if s == nil {
+		return nil, base.HTTPErrorf(404, "missing")
+	}
+	data, _, err := s.bucket.GetRaw(s.key(docid, revid))
+	if base.IsDocNotFoundError(err) {
+		return nil, base.HTTPErrorf(404, "missing")
+	} else if err == nil {
+		base.Debugf(base.KeyCRUD, "Got old revision %q / %q from cold storage", base.UDDocID(docid), revid)
+	}
+	return data, err
+}
+
+// Extends the expiry of a revision body in cold storage.
+func (s *coldRevisionStore) touch(docid string, revid string, expiry uint32) error {
+	_, err := s.bucket.Touch(s.key(docid, revid), expiry)
+	return err
+}
+
+// Removes the bodies of the given revisions of a document from cold storage, if they're there.
+func (s *coldRevisionStore) remove(docid string, revids []string) {
+	if s == nil {
+		return
+	}
+	for _, revid := range revids {
+		if err := s.bucket.Delete(s.key(docid, revid)); err != nil && !base.IsKeyNotFoundError(s.bucket, err) {
+			base.Warnf(base.KeyAll, "Unable to remove revision body %q/%q from cold storage - it will be kept until it expires: %v", base.UDDocID(docid), revid, err)
+		}
+	}
+}
+
+func (s *coldRevisionStore) close() {
+	if s == nil {
+		return
+	}
+	s.bucket.Close()
+}
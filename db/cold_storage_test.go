@@ -0,0 +1,100 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestColdStorageMoves(t *testing.T) {
+	var store *coldRevisionStore
+	assert.False(t, store.moves(86400))
+
+	var bucket base.Bucket
+	store = newColdRevisionStore(bucket, "db", 0)
+	assert.False(t, store.moves(300))
+	assert.True(t, store.moves(86400))
+
+	// Expiries over 30 days are absolute, and zero never expires
+	assert.True(t, store.moves(uint32(time.Now().Add(60*24*time.Hour).Unix())))
+	assert.True(t, store.moves(0))
+}
+
+func TestOldRevisionColdStorage(t *testing.T) {
+	db, testBucket := setupTestDB(t)
+	defer testBucket.Close()
+	defer tearDownTestDB(t, db)
+
+	coldBucket := base.GetTestIndexBucketOrPanic()
+	db.coldRevisions = newColdRevisionStore(coldBucket.Bucket, "db", 60)
+	defer func() {
+		// The database would close the cold storage bucket with itself, so it's given up before being closed here
+		db.coldRevisions = nil
+		coldBucket.Close()
+	}()
+
+	assert.NoError(t, db.setOldRevisionJSON("doc1", "1-a", []byte(`{"value":1}`), 86400))
+	assert.NoError(t, db.setOldRevisionJSON("doc1", "2-b", []byte(`{"value":2}`), 30))
+
+	// Short-lived backups aren't moved
+	_, _, err := coldBucket.Bucket.GetRaw(db.coldRevisions.key("doc1", "2-b"))
+	assert.True(t, base.IsDocNotFoundError(err))
+	data, err := db.getOldRevisionJSON("doc1", "2-b")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"value":2}`, string(data))
+
+	// Long-lived ones are only kept in cold storage, and read from there
+	_, _, err = db.Bucket.GetRaw(oldRevisionKey("doc1", "1-a"))
+	assert.True(t, base.IsDocNotFoundError(err))
+	data, err = db.getOldRevisionJSON("doc1", "1-a")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"value":1}`, string(data))
+
+	// A backup already in the database's bucket is removed once it's moved
+	assert.NoError(t, db.Bucket.SetRaw(oldRevisionKey("doc1", "3-c"), 0, []byte(`{"value":3}`)))
+	assert.NoError(t, db.setOldRevisionJSON("doc1", "3-c", []byte(`{"value":3}`), 0))
+	_, _, err = db.Bucket.GetRaw(oldRevisionKey("doc1", "3-c"))
+	assert.True(t, base.IsDocNotFoundError(err))
+
+	assert.NoError(t, coldBucket.Bucket.Delete(db.coldRevisions.key("doc1", "1-a")))
+	_, err = db.getOldRevisionJSON("doc1", "1-a")
+	assertHTTPError(t, err, 404)
+}
+
+func TestColdStorageRemovedRevisions(t *testing.T) {
+	db, testBucket := setupTestDB(t)
+	defer testBucket.Close()
+	defer tearDownTestDB(t, db)
+
+	coldBucket := base.GetTestIndexBucketOrPanic()
+	db.coldRevisions = newColdRevisionStore(coldBucket.Bucket, "db", 60)
+	defer func() {
+		db.coldRevisions = nil
+		coldBucket.Close()
+	}()
+	inColdStorage := func(docid, revid string) bool {
+		_, _, err := coldBucket.Bucket.GetRaw(db.coldRevisions.key(docid, revid))
+		return err == nil
+	}
+
+	// Old revision bodies never expire in the test db, so they're all moved
+	db.RevsLimit = 2
+	history := []string{"1-a"}
+	for _, revid := range []string{"1-a", "2-b", "3-c", "4-d"} {
+		if revid != "1-a" {
+			history = append([]string{revid}, history...)
+		}
+		assert.NoError(t, db.PutExistingRev("doc1", Body{"rev": revid}, history, false))
+	}
+
+	// Pruned revisions are removed from cold storage
+	assert.False(t, inColdStorage("doc1", "1-a"))
+	assert.False(t, inColdStorage("doc1", "2-b"))
+	assert.True(t, inColdStorage("doc1", "3-c"))
+
+	// And purged documents' revisions are too
+	assert.NoError(t, db.Purge("doc1"))
+	assert.False(t, inColdStorage("doc1", "3-c"))
+}
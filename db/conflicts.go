@@ -0,0 +1,160 @@
+package db
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbase/sync_gateway/channels"
+)
+
+// A document that's currently in conflict, as returned by GetConflicts.
+type ConflictedDoc struct {
+	DocID      string    `json:"id"`
+	CurrentRev string    `json:"rev"`        // The winning revision
+	LeafRevs   []string  `json:"leaf_revs"`  // The non-deleted leaf revisions, winning revision first
+	TimeSaved  time.Time `json:"time_saved"` // When the document was last updated
+	AgeSecs    int64     `json:"age_secs"`   // Seconds since the document was last updated
+}
+
+// Returns up to limit documents that are currently in conflict, ordered by doc ID and starting at startKey.  Documents
+// the user doesn't have access to are skipped, so a page may have fewer than limit entries.  nextKey is the startKey
+// for the next page, or empty if there are no more conflicted documents.
+func (db *Database) GetConflicts(startKey string, limit int) (conflicts []ConflictedDoc, nextKey string, err error) {
+
+	// Query one extra row to find the start of the next page
+	queryLimit := 0
+	if limit > 0 {
+		queryLimit = limit + 1
+	}
+	results, err := db.QueryConflicts(startKey, queryLimit)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var docIDs []string
+	var queryRow QueryIdRow
+	for results.Next(&queryRow) {
+		if limit > 0 && len(docIDs) == limit {
+			nextKey = queryRow.Id
+			break
+		}
+		docIDs = append(docIDs, queryRow.Id)
+	}
+	if err := results.Close(); err != nil {
+		return nil, "", err
+	}
+
+	conflicts = []ConflictedDoc{}
+	for _, docID := range docIDs {
+		doc, err := db.GetDocument(docID, DocUnmarshalSync)
+		if err != nil {
+			if base.IsDocNotFoundError(err) {
+				continue
+			}
+			return nil, "", err
+		}
+
+		// The conflict may have been resolved since the query ran
+		if !doc.hasFlag(channels.Conflict) || db.authorizeDoc(doc, doc.CurrentRev) != nil {
+			continue
+		}
+
+		leafRevs := doc.History.GetLeavesFiltered(func(revID string) bool {
+			return !doc.History[revID].Deleted
+		})
+		sort.Slice(leafRevs, func(i, j int) bool {
+			return compareRevIDs(leafRevs[i], leafRevs[j]) > 0
+		})
+
+		conflicts = append(conflicts, ConflictedDoc{
+			DocID:      docID,
+			CurrentRev: doc.CurrentRev,
+			LeafRevs:   leafRevs,
+			TimeSaved:  doc.TimeSaved,
+			AgeSecs:    int64(time.Since(doc.TimeSaved).Seconds()),
+		})
+	}
+	return conflicts, nextKey, nil
+}
+
+// How ResolveConflicts resolves a conflicted document.
+type ConflictResolutionPolicy string
+
+const (
+	ConflictResolutionWinner ConflictResolutionPolicy = "winner" // Keeps the winning revision, tombstoning the other leaf revisions
+	ConflictResolutionDelete ConflictResolutionPolicy = "delete" // Tombstones every leaf revision, deleting the document
+)
+
+// The number of conflicted documents ResolveConflicts processes at a time.
+const kResolveConflictsPageSize = 100
+
+// A document that's still in conflict after ResolveConflicts.
+type UnresolvedConflict struct {
+	DocID string `json:"id"`
+	Error string `json:"error,omitempty"` // Why the document couldn't be resolved.  Empty in preview mode
+}
+
+// The outcome of ResolveConflicts.
+type ConflictResolutionResult struct {
+	Resolved  []string             `json:"resolved"`  // IDs of the documents that were resolved
+	Remaining []UnresolvedConflict `json:"remaining"` // Documents that are still in conflict
+	Ready     bool                 `json:"ready"`     // Whether no conflicts remain, so allow_conflicts can be set to false
+}
+
+// ResolveConflicts resolves every document that's currently in conflict using the given policy, to prepare the database
+// for allow_conflicts=false.  In preview mode nothing is resolved, and every conflicted document is reported as remaining.
+func (db *Database) ResolveConflicts(policy ConflictResolutionPolicy, preview bool) (*ConflictResolutionResult, error) {
+	if policy != ConflictResolutionWinner && policy != ConflictResolutionDelete {
+		return nil, base.HTTPErrorf(http.StatusBadRequest, "Unknown conflict resolution policy %q", policy)
+	}
+
+	result := &ConflictResolutionResult{
+		Resolved:  []string{},
+		Remaining: []UnresolvedConflict{},
+	}
+	startKey := ""
+	for {
+		conflicts, nextKey, err := db.GetConflicts(startKey, kResolveConflictsPageSize)
+		if err != nil {
+			return nil, err
+		}
+		for _, conflict := range conflicts {
+			if preview {
+				result.Remaining = append(result.Remaining, UnresolvedConflict{DocID: conflict.DocID})
+				continue
+			}
+			if err := db.resolveConflict(conflict, policy); err != nil {
+				base.Warnf(base.KeyCRUD, "Unable to resolve conflicted doc %s: %v", base.UD(conflict.DocID), err)
+				result.Remaining = append(result.Remaining, UnresolvedConflict{DocID: conflict.DocID, Error: err.Error()})
+				continue
+			}
+			result.Resolved = append(result.Resolved, conflict.DocID)
+		}
+		if nextKey == "" {
+			break
+		}
+		startKey = nextKey
+	}
+
+	result.Ready = len(result.Remaining) == 0
+	base.Infof(base.KeyAll, "Resolved %d conflicted docs in %s with policy %q, %d remaining", len(result.Resolved), base.UD(db.Name), policy, len(result.Remaining))
+	return result, nil
+}
+
+// Tombstones the leaf revisions of a conflicted document as per the policy.  The winning revision is tombstoned last,
+// so that the document isn't briefly visible with a non-winning revision as current.
+func (db *Database) resolveConflict(conflict ConflictedDoc, policy ConflictResolutionPolicy) error {
+	for _, revID := range conflict.LeafRevs[1:] {
+		if _, err := db.DeleteDoc(conflict.DocID, revID); err != nil {
+			return err
+		}
+	}
+	if policy == ConflictResolutionDelete {
+		if _, err := db.DeleteDoc(conflict.DocID, conflict.CurrentRev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
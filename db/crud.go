@@ -249,7 +249,7 @@ func (db *Database) GetRevCopy(docid, revid string, history bool, attachmentsSin
 	// Requesting history and/or attachments is incompatible with BodyNoCopy, as getRev will always mutate the body when these
 	// are specified.
 	if copyType == BodyNoCopy && (history || len(attachmentsSince) > 0) {
-		return nil, fmt.Errorf("GetRevCopy called with incompatible properties for key:%s rev:%s", base.UD(docid), revid)
+		return nil, fmt.Errorf("GetRevCopy called with incompatible properties for key:%s rev:%s", base.UDDocID(docid), revid)
 	}
 
 	maxHistory := 0
@@ -342,7 +342,7 @@ func (db *Database) getRev(docid, revid string, maxHistory int, historyFrom []st
 	}
 
 	if showExp && revision.Expiry != nil && !revision.Expiry.IsZero() {
-		revision.Body["_exp"] = revision.Expiry.Format(time.RFC3339)
+		revision.Body[BodyExpiry] = revision.Expiry.Format(time.RFC3339)
 	}
 
 	// Stamp attachment metadata back into the body
@@ -366,7 +366,7 @@ func (db *Database) getRev(docid, revid string, maxHistory int, historyFrom []st
 		}
 	}
 
-	return revision.Body, nil
+	return db.Options.ReadTransform.Apply(docid, revision.Body, db.user)
 }
 
 // GetDelta attempts to return the delta between fromRevId and toRevId.  If the delta can't be generated,
@@ -377,6 +377,18 @@ func (db *Database) GetDelta(docID, fromRevID, toRevID string) (delta []byte, er
 		return nil, nil
 	}
 
+	// Deltas between untransformed revisions would show the user what the read transform hides
+	if db.Options.ReadTransform.AppliesTo(db.user) {
+		return nil, nil
+	}
+
+	if db.deltaCache != nil {
+		if delta = db.deltaCache.Get(docID, fromRevID, toRevID); delta != nil {
+			db.DbStats.StatsDeltaSync().Add(base.StatKeyDeltaCacheHits, 1)
+			return delta, nil
+		}
+	}
+
 	fromRevision, err := db.revisionCache.GetWithCopy(docID, fromRevID, BodyNoCopy)
 
 	// If neither body nor delta is available for fromRevId, the delta can't be generated
@@ -424,6 +436,9 @@ func (db *Database) GetDelta(docID, fromRevID, toRevID string) (delta []byte, er
 		}
 		// Write the newly calculated delta back into the cache before returning
 		db.revisionCache.UpdateDelta(docID, fromRevID, toRevID, delta)
+		if db.deltaCache != nil {
+			db.deltaCache.Put(docID, fromRevID, toRevID, delta)
+		}
 		return delta, nil
 	}
 
@@ -580,7 +595,7 @@ func (db *Database) getRevFromDoc(doc *document, revid string, listRevisions boo
 		}
 		body[BodyRevisions] = encodeRevisions(validatedHistory)
 	}
-	return body, nil
+	return db.Options.ReadTransform.Apply(doc.ID, body, db.user)
 }
 
 // Returns the body of the asked-for revision or the most recent available ancestor.
@@ -625,7 +640,7 @@ func (db *Database) backupAncestorRevs(doc *document, newRevId string, newBody B
 // Used when importing an existing Couchbase doc that hasn't been seen by the gateway before.
 func (db *Database) initializeSyncData(doc *document) (err error) {
 	body := doc.Body()
-	doc.CurrentRev = createRevID(1, "", body)
+	doc.CurrentRev = db.newRevID(1, "", false, body)
 	body[BodyRev] = doc.CurrentRev
 	doc.setFlag(channels.Deleted, false)
 	doc.History = make(RevTree)
@@ -731,10 +746,10 @@ func (db *Database) Put(docid string, body Body) (newRevID string, err error) {
 		}
 
 		// Make up a new _rev, and add it to the history:
-		newRev := createRevID(generation, matchRev, body)
+		newRev := db.newRevID(generation, matchRev, deleted, body)
 		body[BodyRev] = newRev
 		if err := doc.History.addRevision(docid, RevInfo{ID: newRev, Parent: matchRev, Deleted: deleted}); err != nil {
-			base.InfofCtx(db.Ctx, base.KeyCRUD, "Failed to add revision ID: %s, for doc: %s, error: %v", newRev, base.UD(docid), err)
+			base.InfofCtx(db.Ctx, base.KeyCRUD, "Failed to add revision ID: %s, for doc: %s, error: %v", newRev, base.UDDocID(docid), err)
 			return nil, nil, nil, base.ErrRevTreeAddRevFailure
 		}
 
@@ -805,7 +820,7 @@ func (db *Database) PutExistingRev(docid string, body Body, docHistory []string,
 			}
 		}
 		if currentRevIndex == 0 {
-			base.DebugfCtx(db.Ctx, base.KeyCRUD, "PutExistingRev(%q): No new revisions to add", base.UD(docid))
+			base.DebugfCtx(db.Ctx, base.KeyCRUD, "PutExistingRev(%q): No new revisions to add", base.UDDocID(docid))
 			body[BodyRev] = newRev                     // The _rev field is expected by some callers.  If missing, may cause problems for callers.
 			return nil, nil, nil, base.ErrUpdateCancel // No new revisions to add
 		}
@@ -915,9 +930,14 @@ func (db *Database) updateAndReturnDoc(
 	var body Body                                    // Could be returned by documentUpdateFunc
 	var storedBody Body                              // Persisted revision body, used to update rev cache
 	var changedPrincipals, changedRoleUsers []string // Could be returned by documentUpdateFunc
+	var grantDeltas []PrincipalDelta                 // Set by documentUpdateFunc when there's a principal change event handler or an access audit trail.  Changes to the doc's grants
 	var docSequence uint64                           // Must be scoped outside callback, used over multiple iterations
 	var unusedSequences []uint64                     // Must be scoped outside callback, used over multiple iterations
 	var oldBodyJSON string                           // Could be returned by documentUpdateFunc.  Stores previous revision body for use by DocumentChangeEvent
+	var liveDocsDelta int                            // Set by documentUpdateFunc.  Change in the # of live docs, for the doc quota
+	var docQuotaReserved bool                        // Set by documentUpdateFunc.  Whether a new live doc has been reserved under the doc quota
+	var hadExpiry bool                               // Set by documentUpdateFunc.  Whether the previous revision had an expiry, for expiry tracking
+	var prevChannels base.Set                        // Set by documentUpdateFunc.  Channels the previous revision was in, for channel size estimates
 
 	// documentUpdateFunc applies the changes to the document.  Called by either WriteUpdate or WriteUpdateWithXATTR below.
 	documentUpdateFunc := func(doc *document, docExists bool, importAllowed bool) (updatedDoc *document, writeOpts sgbucket.WriteOptions, shadowerEcho bool, updatedExpiry *uint32, err error) {
@@ -928,6 +948,11 @@ func (db *Database) updateAndReturnDoc(
 			err = base.HTTPErrorf(409, "Not imported")
 			return
 		}
+		wasLive := doc.isLive()
+		hadExpiry = doc.Expiry != nil
+		if db.channelSizes != nil {
+			prevChannels = doc.liveChannels()
+		}
 
 		// Invoke the callback to update the document and return a new revision body:
 		body, newAttachments, updatedExpiry, err = callback(doc)
@@ -952,6 +977,11 @@ func (db *Database) updateAndReturnDoc(
 			return
 		}
 
+		// Reject bodies that don't match their type's schema, before the sync function sees them
+		if err = db.Options.DocSchemas.Validate(docid, body); err != nil {
+			return
+		}
+
 		// Determine which is the current "winning" revision (it's not necessarily the new one):
 		newRevID = body[BodyRev].(string)
 		prevCurrentRev := doc.CurrentRev
@@ -961,6 +991,23 @@ func (db *Database) updateAndReturnDoc(
 		doc.setFlag(channels.Conflict, inConflict)
 		doc.setFlag(channels.Branched, branched)
 
+		liveDocsDelta = 0
+		grantDeltas = nil
+		if isLive := doc.isLive(); isLive != wasLive {
+			if isLive {
+				liveDocsDelta = 1
+			} else {
+				liveDocsDelta = -1
+			}
+		}
+
+		// Imports aren't rejected, as the document is already in the bucket, but they're still counted
+		if liveDocsDelta == 1 && !docQuotaReserved && existingDoc == nil {
+			if docQuotaReserved, err = db.quotas.reserveDoc(); err != nil {
+				return
+			}
+		}
+
 		// If tombstone, write tombstone time
 		if doc.hasFlag(channels.Deleted) {
 			doc.syncData.TombstonedAt = time.Now().Unix()
@@ -968,7 +1015,7 @@ func (db *Database) updateAndReturnDoc(
 			doc.syncData.TombstonedAt = 0
 		}
 
-		if doc.CurrentRev != prevCurrentRev && prevCurrentRev != "" && doc.Body() != nil {
+		if doc.CurrentRev != prevCurrentRev && prevCurrentRev != "" && doc.hasBody() {
 			// Store the doc's previous body into the revision tree:
 			bodyJSON, marshalErr := doc.MarshalBody()
 			if marshalErr != nil {
@@ -1031,7 +1078,7 @@ func (db *Database) updateAndReturnDoc(
 					// we previously allocated is unusable now. We have to allocate a new sequence
 					// instead, but we add the unused one(s) to the document so when the changeCache
 					// reads the doc it won't freak out over the break in the sequence numbering.
-					base.InfofCtx(db.Ctx, base.KeyCache, "updateDoc %q: Unused sequence #%d", base.UD(docid), docSequence)
+					base.InfofCtx(db.Ctx, base.KeyCache, "updateDoc %q: Unused sequence #%d", base.UDDocID(docid), docSequence)
 					unusedSequences = append(unusedSequences, docSequence)
 				}
 
@@ -1099,7 +1146,7 @@ func (db *Database) updateAndReturnDoc(
 				var curBody Body
 				if curBody, err = db.getAvailableRev(doc, doc.CurrentRev); curBody != nil {
 					base.DebugfCtx(db.Ctx, base.KeyCRUD, "updateDoc(%q): Rev %q causes %q to become current again",
-						base.UD(docid), newRevID, doc.CurrentRev)
+						base.UDDocID(docid), newRevID, doc.CurrentRev)
 					channelSet, access, roles, syncExpiry, oldBody, err = db.getChannelsAndAccess(doc, curBody, doc.CurrentRev)
 
 					//Assign old revision body to variable in method scope
@@ -1110,7 +1157,7 @@ func (db *Database) updateAndReturnDoc(
 				} else {
 					// Shouldn't be possible (CurrentRev is a leaf so won't have been compacted)
 					base.WarnfCtx(db.Ctx, base.KeyAll, "updateDoc(%q): Rev %q missing, can't call getChannelsAndAccess "+
-						"on it (err=%v)", base.UD(docid), doc.CurrentRev, err)
+						"on it (err=%v)", base.UDDocID(docid), doc.CurrentRev, err)
 					channelSet = nil
 					access = nil
 					roles = nil
@@ -1120,6 +1167,9 @@ func (db *Database) updateAndReturnDoc(
 			// Update the document struct's channel assignment and user access.
 			// (This uses the new sequence # so has to be done after updating doc.Sequence)
 			doc.updateChannels(channelSet) //FIX: Incorrect if new rev is not current!
+			if db.EventMgr.HasHandlerForEvent(PrincipalChange) || db.Options.AccessAuditOptions.Enabled {
+				grantDeltas = doc.grantDeltas(access, roles)
+			}
 			changedPrincipals = doc.Access.updateAccess(doc, access)
 			changedRoleUsers = doc.RoleAccess.updateAccess(doc, roles)
 
@@ -1137,12 +1187,12 @@ func (db *Database) updateAndReturnDoc(
 
 		} else {
 			base.DebugfCtx(db.Ctx, base.KeyCRUD, "updateDoc(%q): Rev %q leaves %q still current",
-				base.UD(docid), newRevID, prevCurrentRev)
+				base.UDDocID(docid), newRevID, prevCurrentRev)
 		}
 
 		// Prune old revision history to limit the number of revisions:
-		if pruned := doc.pruneRevisions(db.RevsLimit, doc.CurrentRev); pruned > 0 {
-			base.DebugfCtx(db.Ctx, base.KeyCRUD, "updateDoc(%q): Pruned %d old revisions", base.UD(docid), pruned)
+		if pruned := doc.pruneRevisions(db.revsLimitForDoc(docid, body), doc.CurrentRev); pruned > 0 {
+			base.DebugfCtx(db.Ctx, base.KeyCRUD, "updateDoc(%q): Pruned %d old revisions", base.UDDocID(docid), pruned)
 		}
 
 		doc.TimeSaved = time.Now()
@@ -1167,7 +1217,8 @@ func (db *Database) updateAndReturnDoc(
 	// Update the document
 	inConflict := false
 	upgradeInProgress := false
-	docBytes := 0 // Track size of document written, for write stats
+	docBytes := 0     // Track size of document written, for write stats
+	prevDocBytes := 0 // Size of the document before it was written, for channel size estimates
 	if !db.UseXattrs() {
 		// Update the document, storing metadata in _sync property
 		_, err = db.Bucket.WriteUpdate(key, expiry, func(currentValue []byte) (raw []byte, writeOpts sgbucket.WriteOptions, syncFuncExpiry *uint32, err error) {
@@ -1175,14 +1226,15 @@ func (db *Database) updateAndReturnDoc(
 			if doc, err = unmarshalDocument(docid, currentValue); err != nil {
 				return
 			}
+			prevDocBytes = len(currentValue)
 			docOut, writeOpts, shadowerEcho, syncFuncExpiry, err = documentUpdateFunc(doc, currentValue != nil, allowImport)
 			if err != nil {
 				return
 			}
 			inConflict = docOut.hasFlag(channels.Conflict)
 			// Return the new raw document value for the bucket to store.
-			raw, err = json.Marshal(docOut)
-			base.DebugfCtx(db.Ctx, base.KeyCRUD, "Saving doc (seq: #%d, id: %v rev: %v)", doc.Sequence, base.UD(doc.ID), doc.CurrentRev)
+			raw, err = db.marshalDocument(docOut)
+			base.DebugfCtx(db.Ctx, base.KeyCRUD, "Saving doc (seq: #%d, id: %v rev: %v)", doc.Sequence, base.UDDocID(doc.ID), doc.CurrentRev)
 			docBytes = len(raw)
 			return raw, writeOpts, syncFuncExpiry, err
 		})
@@ -1202,10 +1254,14 @@ func (db *Database) updateAndReturnDoc(
 		// Update the document, storing metadata in extended attribute
 		casOut, err = db.Bucket.WriteUpdateWithXattr(key, KSyncXattrName, expiry, existingDoc, func(currentValue []byte, currentXattr []byte, cas uint64) (raw []byte, rawXattr []byte, deleteDoc bool, syncFuncExpiry *uint32, err error) {
 			// Be careful: this block can be invoked multiple times if there are races!
-			if doc, err = unmarshalDocumentWithXattr(docid, currentValue, currentXattr, cas, DocUnmarshalAll); err != nil {
+			// The body is left raw, as it often only needs copying into the revision tree or passing to the sync function
+			// as JSON.  It's unmarshalled on demand if anything needs its properties.
+			if doc, err = unmarshalDocumentWithXattr(docid, currentValue, currentXattr, cas, DocUnmarshalSync); err != nil {
 				return
 			}
+			prevDocBytes = len(currentValue)
 
+			prevCurrentRev := doc.CurrentRev
 			docOut, _, _, syncFuncExpiry, err = documentUpdateFunc(doc, currentValue != nil, true)
 			if err != nil {
 				return
@@ -1214,7 +1270,7 @@ func (db *Database) updateAndReturnDoc(
 
 			currentRevFromHistory, ok := docOut.History[docOut.CurrentRev]
 			if !ok {
-				err = base.RedactErrorf("WriteUpdateWithXattr() not able to find revision (%v) in history of doc: %+v.  Cannot update doc.", docOut.CurrentRev, base.UD(docOut))
+				err = base.RedactErrorf("WriteUpdateWithXattr() not able to find revision (%v) in history of doc: %+v.  Cannot update doc.", docOut.CurrentRev, base.UDBody(docOut))
 				return
 			}
 
@@ -1224,23 +1280,29 @@ func (db *Database) updateAndReturnDoc(
 			raw, rawXattr, err = docOut.MarshalWithXattr()
 			docBytes = len(raw)
 
+			// If the current revision hasn't changed (e.g. a non-winning revision was added), the body is unchanged.  Hand
+			// back the existing body so that the bucket only needs to update the sync metadata xattr.
+			if err == nil && !deleteDoc && currentValue != nil && docOut.CurrentRev == prevCurrentRev {
+				raw = currentValue
+			}
+
 			// Warn when sync data is larger than a configured threshold
 			if xattrBytesThreshold := db.Options.UnsupportedOptions.WarningThresholds.XattrSize; xattrBytesThreshold != nil {
 				xattrBytes := len(rawXattr)
 				if uint32(xattrBytes) >= *xattrBytesThreshold {
 					db.DbStats.StatsDatabase().Add(base.StatKeyWarnXattrSizeCount, 1)
-					base.WarnfCtx(db.Ctx, base.KeyAll, "Doc id: %v sync metadata size: %d bytes exceeds %d bytes for sync metadata warning threshold", base.UD(docOut.ID), xattrBytes, *xattrBytesThreshold)
+					base.WarnfCtx(db.Ctx, base.KeyAll, "Doc id: %v sync metadata size: %d bytes exceeds %d bytes for sync metadata warning threshold", base.UDDocID(docOut.ID), xattrBytes, *xattrBytesThreshold)
 				}
 			}
 
-			base.DebugfCtx(db.Ctx, base.KeyCRUD, "Saving doc (seq: #%d, id: %v rev: %v)", docOut.Sequence, base.UD(docOut.ID), docOut.CurrentRev)
+			base.DebugfCtx(db.Ctx, base.KeyCRUD, "Saving doc (seq: #%d, id: %v rev: %v)", docOut.Sequence, base.UDDocID(docOut.ID), docOut.CurrentRev)
 			return raw, rawXattr, deleteDoc, syncFuncExpiry, err
 		})
 		if err != nil {
 			if err == base.ErrDocumentMigrated {
-				base.DebugfCtx(db.Ctx, base.KeyCRUD, "Migrated document %q to use xattr.", base.UD(key))
+				base.DebugfCtx(db.Ctx, base.KeyCRUD, "Migrated document %q to use xattr.", base.UDDocID(key))
 			} else {
-				base.DebugfCtx(db.Ctx, base.KeyCRUD, "Did not update document %q w/ xattr: %v", base.UD(key), err)
+				base.DebugfCtx(db.Ctx, base.KeyCRUD, "Did not update document %q w/ xattr: %v", base.UDDocID(key), err)
 			}
 		} else if docOut != nil {
 			docOut.Cas = casOut
@@ -1262,18 +1324,33 @@ func (db *Database) updateAndReturnDoc(
 		}
 	}
 
+	// A reservation under the doc quota counts the new live doc, so it's released unless one was written
+	if docQuotaReserved {
+		if err != nil && err != couchbase.ErrOverwritten {
+			db.quotas.recordLiveDocs(-1)
+		} else {
+			liveDocsDelta--
+		}
+	}
+
 	if err == base.ErrUpdateCancel {
 		return nil, "", nil
 	} else if err == couchbase.ErrOverwritten {
 		// ErrOverwritten is ok; if a later revision got persisted, that's fine too
 		base.DebugfCtx(db.Ctx, base.KeyCRUD, "Note: Rev %q/%q was overwritten in RAM before becoming indexable",
-			base.UD(docid), newRevID)
+			base.UDDocID(docid), newRevID)
 	} else if err != nil {
 		return nil, "", err
 	}
 
 	db.DbStats.StatsDatabase().Add(base.StatKeyNumDocWrites, 1)
 	db.DbStats.StatsDatabase().Add(base.StatKeyDocWritesBytes, int64(docBytes))
+	db.quotas.recordLiveDocs(liveDocsDelta)
+	db.updateExpiryTracking(doc, hadExpiry)
+	db.cacheNotifier.docChanged(doc.ID, &doc.syncData)
+	if db.channelSizes != nil {
+		db.channelSizes.recordUpdate(prevChannels, prevDocBytes, doc.liveChannels(), docBytes)
+	}
 	if inConflict {
 		db.DbStats.StatsDatabase().Add(base.StatKeyConflictWriteCount, 1)
 	}
@@ -1307,17 +1384,24 @@ func (db *Database) updateAndReturnDoc(
 		}
 	} else {
 		//Revision has been pruned away so won't be added to cache
-		base.InfofCtx(db.Ctx, base.KeyCRUD, "doc %q / %q, has been pruned, it has not been inserted into the revision cache", base.UD(docid), newRevID)
+		base.InfofCtx(db.Ctx, base.KeyCRUD, "doc %q / %q, has been pruned, it has not been inserted into the revision cache", base.UDDocID(docid), newRevID)
 	}
 
 	// Now that the document has successfully been stored, we can make other db changes:
-	base.InfofCtx(db.Ctx, base.KeyCRUD, "Stored doc %q / %q as #%v", base.UD(docid), newRevID, doc.Sequence)
+	base.InfofCtx(db.Ctx, base.KeyCRUD, "Stored doc %q / %q as #%v", base.UDDocID(docid), newRevID, doc.Sequence)
 
-	// Remove any obsolete non-winning revision bodies
+	// Remove any obsolete non-winning revision bodies, and the cold storage copies of pruned revisions
 	doc.deleteRemovedRevisionBodies(db.Bucket)
+	db.coldRevisions.remove(docid, doc.prunedRevIDs)
 
 	// Mark affected users/roles as needing to recompute their channel access:
 	db.MarkPrincipalsChanged(docid, newRevID, changedPrincipals, changedRoleUsers)
+	if db.Options.AccessAuditOptions.Enabled {
+		db.recordAccessGrants(grantDeltas, doc.CurrentRev, doc.Sequence, false)
+	}
+	for _, delta := range grantDeltas {
+		db.EventMgr.RaisePrincipalChangeEvent(delta)
+	}
 	return docOut, newRevID, nil
 }
 
@@ -1327,7 +1411,7 @@ func (db *Database) checkDocChannelsAndGrantsLimits(docID string, channels base.
 		channelCount := len(channels)
 		if uint32(channelCount) >= *channelCountThreshold {
 			db.DbStats.StatsDatabase().Add(base.StatKeyWarnChannelsPerDocCount, 1)
-			base.WarnfCtx(db.Ctx, base.KeyAll, "Doc id: %v channel count: %d exceeds %d for channels per doc warning threshold", base.UD(docID), channelCount, *channelCountThreshold)
+			base.WarnfCtx(db.Ctx, base.KeyAll, "Doc id: %v channel count: %d exceeds %d for channels per doc warning threshold", base.UDDocID(docID), channelCount, *channelCountThreshold)
 		}
 	}
 
@@ -1336,7 +1420,7 @@ func (db *Database) checkDocChannelsAndGrantsLimits(docID string, channels base.
 		grantCount := len(accessGrants) + len(roleGrants)
 		if uint32(grantCount) >= *grantThreshold {
 			db.DbStats.StatsDatabase().Add(base.StatKeyWarnGrantsPerDocCount, 1)
-			base.WarnfCtx(db.Ctx, base.KeyAll, "Doc id: %v access and role grants count: %d exceeds %d for grants per doc warning threshold", base.UD(docID), grantCount, *grantThreshold)
+			base.WarnfCtx(db.Ctx, base.KeyAll, "Doc id: %v access and role grants count: %d exceeds %d for grants per doc warning threshold", base.UDDocID(docID), grantCount, *grantThreshold)
 		}
 	}
 }
@@ -1347,7 +1431,7 @@ func (db *Database) MarkPrincipalsChanged(docid string, newRevID string, changed
 
 	// Mark affected users/roles as needing to recompute their channel access:
 	if len(changedPrincipals) > 0 {
-		base.InfofCtx(db.Ctx, base.KeyAccess, "Rev %q / %q invalidates channels of %s", base.UD(docid), newRevID, changedPrincipals)
+		base.InfofCtx(db.Ctx, base.KeyAccess, "Rev %q / %q invalidates channels of %s", base.UDDocID(docid), newRevID, changedPrincipals)
 		for _, changedAccessPrincipalName := range changedPrincipals {
 			db.invalUserOrRoleChannels(changedAccessPrincipalName)
 			// Check whether the active user needs to be recalculated.  Skip check if reload has already been identified
@@ -1358,14 +1442,14 @@ func (db *Database) MarkPrincipalsChanged(docid string, newRevID string, changed
 				if isRole {
 					for roleName := range db.user.RoleNames() {
 						if roleName == changedPrincipalName {
-							base.Debugf(base.KeyAccess, "Active user belongs to role %q with modified channel access - user %q will be reloaded.", base.UD(roleName), base.UD(db.user.Name()))
+							base.Debugf(base.KeyAccess, "Active user belongs to role %q with modified channel access - user %q will be reloaded.", base.UDUsername(roleName), base.UDUsername(db.user.Name()))
 							reloadActiveUser = true
 							break
 						}
 					}
 				} else if db.user.Name() == changedPrincipalName {
 					// User matches
-					base.DebugfCtx(db.Ctx, base.KeyAccess, "Channel set for active user has been modified - user %q will be reloaded.", base.UD(db.user.Name()))
+					base.DebugfCtx(db.Ctx, base.KeyAccess, "Channel set for active user has been modified - user %q will be reloaded.", base.UDUsername(db.user.Name()))
 					reloadActiveUser = true
 				}
 
@@ -1374,12 +1458,12 @@ func (db *Database) MarkPrincipalsChanged(docid string, newRevID string, changed
 	}
 
 	if len(changedRoleUsers) > 0 {
-		base.InfofCtx(db.Ctx, base.KeyAccess, "Rev %q / %q invalidates roles of %s", base.UD(docid), newRevID, base.UD(changedRoleUsers))
+		base.InfofCtx(db.Ctx, base.KeyAccess, "Rev %q / %q invalidates roles of %s", base.UDDocID(docid), newRevID, base.UDUsername(changedRoleUsers))
 		for _, name := range changedRoleUsers {
 			db.invalUserRoles(name)
 			//If this is the current in memory db.user, reload to generate updated roles
 			if db.user != nil && db.user.Name() == name {
-				base.Debugf(base.KeyAccess, "Role set for active user has been modified - user %q will be reloaded.", base.UD(db.user.Name()))
+				base.Debugf(base.KeyAccess, "Role set for active user has been modified - user %q will be reloaded.", base.UDUsername(db.user.Name()))
 				reloadActiveUser = true
 
 			}
@@ -1389,7 +1473,7 @@ func (db *Database) MarkPrincipalsChanged(docid string, newRevID string, changed
 	if reloadActiveUser {
 		user, err := db.Authenticator().GetUser(db.user.Name())
 		if err != nil {
-			base.WarnfCtx(db.Ctx, base.KeyAll, "Error reloading active db.user[%s], security information will not be recalculated until next authentication --> %+v", base.UD(db.user.Name()), err)
+			base.WarnfCtx(db.Ctx, base.KeyAll, "Error reloading active db.user[%s], security information will not be recalculated until next authentication --> %+v", base.UDUsername(db.user.Name()), err)
 		} else {
 			db.user = user
 		}
@@ -1422,14 +1506,57 @@ func (db *Database) DeleteDoc(docid string, revid string) (string, error) {
 	return db.Put(docid, body)
 }
 
-// Purges a document from the bucket (no tombstone)
+// Purges a document from the bucket (no tombstone).  If the trash is enabled, the document is kept there until its
+// retention expires, and can be restored with RestoreFromTrash.
 func (db *Database) Purge(key string) error {
+	if db.Options.TrashOptions.Enabled {
+		if err := db.moveToTrash(key); err != nil {
+			return err
+		}
+	}
+	return db.purge(key)
+}
+
+// Purges a document without keeping it in the trash.
+func (db *Database) purge(key string) error {
+
+	// Purging a live doc frees up room in the doc quota, and removes it from its channels' size estimates.  Its old
+	// revision bodies are removed from cold storage, as they'd be kept there for a long time, and its revisions from the
+	// shared revision cache, as they'd be served in place of those of a new doc with the same ID.
+	wasLive := false
+	var prevChannels base.Set
+	prevDocBytes := 0
+	var revIDs []string
+	if db.quotas != nil || db.channelSizes != nil || db.coldRevisions != nil || db.sharedRevCache != nil {
+		if doc, err := db.GetDocument(key, DocUnmarshalSync); err == nil {
+			wasLive = doc.isLive()
+			revIDs = doc.History.revIDs()
+			if db.channelSizes != nil {
+				prevChannels = doc.liveChannels()
+				if bodyJSON, err := doc.MarshalBody(); err == nil {
+					prevDocBytes = len(bodyJSON)
+				}
+			}
+		}
+	}
 
+	var err error
 	if db.UseXattrs() {
-		return db.Bucket.DeleteWithXattr(key, KSyncXattrName)
+		err = db.Bucket.DeleteWithXattr(key, KSyncXattrName)
 	} else {
-		return db.Bucket.Delete(key)
+		// Stop tracking the doc's expiry first, so that the delete isn't mistaken for an expiration
+		_ = db.Bucket.Delete(expiryTrackingKey(key))
+		err = db.Bucket.Delete(key)
+	}
+	if err == nil && wasLive {
+		db.quotas.recordLiveDocs(-1)
+		db.channelSizes.recordUpdate(prevChannels, prevDocBytes, nil, 0)
 	}
+	if err == nil {
+		db.coldRevisions.remove(key, revIDs)
+		db.sharedRevCache.remove(key, revIDs)
+	}
+	return err
 }
 
 //////// CHANNELS:
@@ -1443,7 +1570,7 @@ func (db *Database) getChannelsAndAccess(doc *document, body Body, revID string)
 	expiry *uint32,
 	oldJson string,
 	err error) {
-	base.DebugfCtx(db.Ctx, base.KeyCRUD, "Invoking sync on doc %q rev %s", base.UD(doc.ID), body[BodyRev])
+	base.DebugfCtx(db.Ctx, base.KeyCRUD, "Invoking sync on doc %q rev %s", base.UDDocID(doc.ID), body[BodyRev])
 
 	// Get the parent revision, to pass to the sync function:
 	var oldJsonBytes []byte
@@ -1462,6 +1589,7 @@ func (db *Database) getChannelsAndAccess(doc *document, body Body, revID string)
 			makeUserCtx(db.user))
 
 		db.DbStats.CblReplicationPush().Add(base.StatKeySyncFunctionTime, time.Since(startTime).Nanoseconds())
+		base.SlowOperationLog(db.Ctx, base.SlowSyncFunctionWarningThreshold, startTime, base.KeyCRUD, "Sync function for doc %q / %q", base.UDDocID(doc.ID), revID)
 
 		if err == nil {
 			result = output.Channels
@@ -1470,8 +1598,8 @@ func (db *Database) getChannelsAndAccess(doc *document, body Body, revID string)
 			expiry = output.Expiry
 			err = output.Rejection
 			if err != nil {
-				base.InfofCtx(db.Ctx, base.KeyAll, "Sync fn rejected doc %q / %q --> %s", base.UD(doc.ID), base.UD(doc.NewestRev), err)
-				base.DebugfCtx(db.Ctx, base.KeyAll, "    rejected doc %q / %q : new=%+v  old=%s", base.UD(doc.ID), base.UD(doc.NewestRev), base.UD(body), base.UD(oldJson))
+				base.InfofCtx(db.Ctx, base.KeyAll, "Sync fn rejected doc %q / %q --> %s", base.UDDocID(doc.ID), base.UD(doc.NewestRev), err)
+				base.DebugfCtx(db.Ctx, base.KeyAll, "    rejected doc %q / %q : new=%+v  old=%s", base.UDDocID(doc.ID), base.UD(doc.NewestRev), base.UDBody(body), base.UDBody(oldJson))
 				db.DbStats.StatsSecurity().Add(base.StatKeyNumDocsRejected, 1)
 				if isAccessError(err) {
 					db.DbStats.StatsSecurity().Add(base.StatKeyNumAccessErrors, 1)
@@ -1481,7 +1609,7 @@ func (db *Database) getChannelsAndAccess(doc *document, body Body, revID string)
 			}
 
 		} else {
-			base.WarnfCtx(db.Ctx, base.KeyAll, "Sync fn exception: %+v; doc = %s", err, base.UD(body))
+			base.WarnfCtx(db.Ctx, base.KeyAll, "Sync fn exception: %+v; doc = %s", err, base.UDBody(body))
 			err = base.HTTPErrorf(500, "Exception in JS sync function")
 		}
 
@@ -1513,7 +1641,7 @@ func validateAccessMap(access channels.AccessMap) bool {
 	for name := range access {
 		principalName, _ := channels.AccessNameToPrincipalName(name)
 		if !auth.IsValidPrincipalName(principalName) {
-			base.Warnf(base.KeyAll, "Invalid principal name %q in access() or role() call", base.UD(principalName))
+			base.Warnf(base.KeyAll, "Invalid principal name %q in access() or role() call", base.UDUsername(principalName))
 			return false
 		}
 	}
@@ -1527,7 +1655,7 @@ func validateRoleAccessMap(roleAccess channels.AccessMap) bool {
 	for _, roles := range roleAccess {
 		for rolename := range roles {
 			if !auth.IsValidPrincipalName(rolename) {
-				base.Warnf(base.KeyAll, "Invalid role name %q in role() call", base.UD(rolename))
+				base.Warnf(base.KeyAll, "Invalid role name %q in role() call", base.UDUsername(rolename))
 				return false
 			}
 		}
@@ -1681,7 +1809,7 @@ func (db *Database) RevDiff(docid string, revids []string) (missing, possible []
 	doc, err := db.GetDocument(docid, DocUnmarshalSync)
 	if err != nil {
 		if !base.IsDocNotFoundError(err) {
-			base.WarnfCtx(db.Ctx, base.KeyAll, "RevDiff(%q) --> %T %v", base.UD(docid), err, err)
+			base.WarnfCtx(db.Ctx, base.KeyAll, "RevDiff(%q) --> %T %v", base.UDDocID(docid), err, err)
 			// If something goes wrong getting the doc, treat it as though it's nonexistent.
 		}
 		missing = revids
@@ -1736,7 +1864,7 @@ func (db *Database) CheckProposedRev(docid string, revid string, parentRevID str
 	doc, err := db.GetDocument(docid, DocUnmarshalAll)
 	if err != nil {
 		if !base.IsDocNotFoundError(err) {
-			base.WarnfCtx(db.Ctx, base.KeyAll, "CheckProposedRev(%q) --> %T %v", base.UD(docid), err, err)
+			base.WarnfCtx(db.Ctx, base.KeyAll, "CheckProposedRev(%q) --> %T %v", base.UDDocID(docid), err, err)
 			return ProposedRev_Error
 		}
 		// Doc doesn't exist locally; adding it is OK (even if it has a history)
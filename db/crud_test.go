@@ -5,6 +5,7 @@ import (
 	"log"
 	"testing"
 
+	"github.com/couchbase/sg-bucket"
 	"github.com/couchbase/sync_gateway/base"
 	"github.com/couchbase/sync_gateway/channels"
 	goassert "github.com/couchbaselabs/go.assert"
@@ -703,3 +704,63 @@ func TestMalformedRevisionStorageRecovery(t *testing.T) {
 	rev3c_body := Body{"key1": "value2", "v": "3c"}
 	assert.NoError(t, db.PutExistingRev("doc1", rev3c_body, []string{"3-c", "2-b", "1-a"}, false), "add 3-c")
 }
+
+func TestBodyCompression(t *testing.T) {
+	if base.TestUseXattrs() {
+		t.Skip("Body compression isn't supported with xattrs")
+	}
+	db, testBucket := setupTestDB(t)
+	defer testBucket.Close()
+	defer tearDownTestDB(t, db)
+	db.Options.BodyCompressionOptions = BodyCompressionOptions{Enabled: true, MinBytes: 1024}
+
+	var item map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(doc_1k), &item))
+	items := make([]interface{}, 20)
+	for i := range items {
+		items[i] = item
+	}
+	rev1ID, err := db.Put("large", Body{"items": items})
+	assert.NoError(t, err)
+	_, err = db.Put("small", Body{"key1": "value1"})
+	assert.NoError(t, err)
+
+	// The large body is stored compressed, and the small one as usual
+	raw, _, err := db.Bucket.GetRaw("large")
+	assert.NoError(t, err)
+	var stored map[string]json.RawMessage
+	assert.NoError(t, json.Unmarshal(raw, &stored))
+	assert.Contains(t, stored, bodyCompressedProperty)
+	assert.NotContains(t, stored, "items")
+	assert.Contains(t, string(stored["_sync"]), `"body_encoding":"gzip"`)
+	assert.True(t, len(raw) < 20*len(doc_1k)/4, "Compressed doc is %d bytes", len(raw))
+	raw, _, err = db.Bucket.GetRaw("small")
+	assert.NoError(t, err)
+	assert.NotContains(t, string(raw), bodyCompressedProperty)
+
+	// Compressed bodies are decompressed as they're read
+	db.FlushRevisionCacheForTest()
+	body, err := db.GetRev("large", "", false, nil)
+	assert.NoError(t, err)
+	assert.Len(t, body["items"], 20)
+	assert.NotContains(t, body, bodyCompressedProperty)
+
+	// Views can't be created, as they'd see only the compressed body
+	err = db.PutDesignDoc("views", sgbucket.DesignDoc{
+		Views: sgbucket.ViewMap{"items": sgbucket.ViewDef{Map: "function(doc){emit(doc.items.length);}"}},
+	})
+	assertHTTPError(t, err, 400)
+
+	// Once compression is disabled, the body is stored uncompressed again when the doc's updated
+	db.Options.BodyCompressionOptions.Enabled = false
+	_, err = db.Put("large", Body{BodyRev: rev1ID, "items": items[:10]})
+	assert.NoError(t, err)
+	raw, _, err = db.Bucket.GetRaw("large")
+	assert.NoError(t, err)
+	assert.NotContains(t, string(raw), bodyCompressedProperty)
+	assert.NotContains(t, string(raw), "body_encoding")
+	db.FlushRevisionCacheForTest()
+	body, err = db.GetRev("large", "", false, nil)
+	assert.NoError(t, err)
+	assert.Len(t, body["items"], 10)
+}
@@ -58,11 +58,15 @@ var (
 	DefaultDeltaSyncRevMaxAge = uint32(60 * 60 * 24) // 24 hours in seconds
 )
 
+// How often tombstones older than the purge interval are purged, when automatic tombstone purge is enabled
+var TombstonePurgeFrequency = time.Hour
+
 // Basic description of a database. Shared between all Database objects on the same database.
 // This object is thread-safe so it can be shared between HTTP handlers.
 type DatabaseContext struct {
 	Name               string                  // Database name
 	Bucket             base.Bucket             // Storage
+	MetadataBucket     base.Bucket             // Storage for Sync Gateway's internal docs.  Same as Bucket unless a metadata bucket is configured
 	BucketSpec         base.BucketSpec         // The BucketSpec
 	BucketLock         sync.RWMutex            // Control Access to the underlying bucket object
 	mutationListener   changeListener          // Listens on server mutation feed (TAP or DCP)
@@ -70,9 +74,13 @@ type DatabaseContext struct {
 	ChannelMapper      *channels.ChannelMapper // Runs JS 'sync' function
 	StartTime          time.Time               // Timestamp when context was instantiated
 	RevsLimit          uint32                  // Max depth a document's revision tree can grow to
+	RevsLimitOverrides []RevsLimitOverride     // Per-document overrides of RevsLimit, the first match applies
 	autoImport         bool                    // Add sync data to new untracked couchbase server docs?  (Xattr mode specific)
 	Shadower           *Shadower               // Tracks an external Couchbase bucket
 	revisionCache      *ShardedRevisionCache   // Cache of recently-accessed doc revisions
+	sharedRevCache     *sharedRevisionCache    // Revision cache shared with the database's other nodes, or nil if not enabled
+	coldRevisions      *coldRevisionStore      // Cold storage of old revision bodies, or nil if not enabled
+	deltaCache         *DeltaCache             // Cache of generated deltas, if enabled
 	changeCache        ChangeIndex             //
 	EventMgr           *EventManager           // Manages notification events
 	AllowEmptyPassword bool                    // Allow empty passwords?  Defaults to false
@@ -86,6 +94,13 @@ type DatabaseContext struct {
 	PurgeInterval      int                     // Metadata purge interval, in hours
 	serverUUID         string                  // UUID of the server, if available
 	DbStats            *DatabaseStats          // stats that correspond to this database context
+	UsageStats         *UsageStats             // Per-user and per-channel usage, or nil if not enabled
+	QueryResultCache   *QueryResultCache       // Cache of _all_docs and view query results, or nil if not enabled
+	BackgroundTasks    *BackgroundTaskManager  // Runs maintenance tasks, such as compaction and resync
+	quotas             *quotaTracker           // Enforces the resource quotas, or nil if none are set
+	channelSizes       *channelSizeTracker     // Estimates the size of each channel, or nil if not enabled
+	cacheNotifier      *cacheNotifier          // Notifies the other nodes of this node's writes, or nil if not enabled
+	healthCheck        *healthChecker          // The health check doc this node writes
 }
 
 type DatabaseContextOptions struct {
@@ -100,13 +115,36 @@ type DatabaseContextOptions struct {
 	OIDCOptions               *auth.OIDCOptions
 	DBOnlineCallback          DBOnlineCallback // Callback function to take the DB back online
 	ImportOptions             ImportOptions
-	EnableXattr               bool             // Use xattr for _sync
-	LocalDocExpirySecs        uint32           // The _local doc expiry time in seconds
-	SessionCookieName         string           // Pass-through DbConfig.SessionCookieName
-	AllowConflicts            *bool            // False forbids creating conflicts
-	SendWWWAuthenticateHeader *bool            // False disables setting of 'WWW-Authenticate' header
-	UseViews                  bool             // Force use of views
-	DeltaSyncOptions          DeltaSyncOptions // Delta Sync Options
+	EnableXattr               bool              // Use xattr for _sync
+	LocalDocExpirySecs        uint32            // The _local doc expiry time in seconds
+	SessionCookieName         string            // Pass-through DbConfig.SessionCookieName
+	AllowConflicts            *bool             // False forbids creating conflicts
+	SendWWWAuthenticateHeader *bool             // False disables setting of 'WWW-Authenticate' header
+	UseViews                  bool              // Force use of views
+	DeltaSyncOptions          DeltaSyncOptions  // Delta Sync Options
+	UsageStatsOptions         UsageStatsOptions // Per-user and per-channel usage tracking options
+	MetadataBucket            base.Bucket       // Separate bucket for internal docs (sequences, principals, sessions, local docs), if any
+	TombstonePurgeOptions     TombstonePurgeOptions
+	SessionCookieOptions      auth.SessionCookieOptions
+	CSRFOptions               CSRFOptions
+	AccessAuditOptions        AccessAuditOptions
+	DeterministicRevIDs       bool         // Generate revIDs from the revisions' content, so identical edits converge
+	QuotaOptions              QuotaOptions // Per-database resource quotas
+	SharedRevCacheOptions     SharedRevCacheOptions
+	BodyCompressionOptions    BodyCompressionOptions
+	ChannelSizeOptions        ChannelSizeOptions
+	DocSchemas                *DocSchemas    // JSON Schemas documents are validated against before the sync function runs
+	ReadTransform             *ReadTransform // Changes the bodies of the revisions users read, or nil
+	SignupOptions             SignupOptions
+	NamedQueries              map[string]*NamedQuery   // N1QL queries clients can run by name
+	UserFunctions             map[string]*UserFunction // JavaScript functions clients can call by name
+	GraphQLSchema             *GraphQLSchema           // Schema of the GraphQL API, or nil
+	QueryResultCacheOptions   QueryResultCacheOptions
+	WebSocketCompression      WebSocketCompressionOptions
+	CacheNotifyOptions        CacheNotifyOptions
+	TrashOptions              TrashOptions // Keeps purged documents for a while, so they can be restored
+	ColdStorageOptions        ColdStorageOptions
+	BackgroundTaskOptions     BackgroundTaskOptions
 }
 
 type OidcTestProviderOptions struct {
@@ -118,9 +156,58 @@ type UserViewsOptions struct {
 	Enabled *bool `json:"enabled,omitempty"` // Whether pass-through view query is supported through public API
 }
 
+// Overrides the database's RevsLimit for the documents that match all of its non-empty criteria.
+type RevsLimitOverride struct {
+	DocIDPattern *regexp.Regexp // Matched against the doc ID
+	DocType      string         // Matched against the document's "type" property
+	RevsLimit    uint32         // Max depth the revision tree of matching documents can grow to
+}
+
+// Matches returns true if the override applies to the document with the given ID and body.
+func (o RevsLimitOverride) Matches(docid string, body Body) bool {
+	if o.DocIDPattern != nil && !o.DocIDPattern.MatchString(docid) {
+		return false
+	}
+	if o.DocType != "" {
+		if docType, _ := body["type"].(string); docType != o.DocType {
+			return false
+		}
+	}
+	return true
+}
+
+type TombstonePurgeOptions struct {
+	Enabled       bool // Whether tombstones older than the purge interval are purged automatically
+	IntervalHours int  // Overrides the server's metadata purge interval, if non-zero
+}
+
 type DeltaSyncOptions struct {
 	Enabled          bool   // Whether delta sync is enabled (EE only)
 	RevMaxAgeSeconds uint32 // The number of seconds deltas for old revs are available for
+	CacheMaxBytes    int64  // Memory budget for the cache of generated deltas.  Zero disables the cache
+}
+
+// Default settings of the permessage-deflate compression of BLIP WebSocket messages
+const (
+	DefaultWebSocketCompressionLevel          = 6
+	DefaultWebSocketCompressionMinMessageSize = 256
+)
+
+type WebSocketCompressionOptions struct {
+	Enabled        bool // Whether permessage-deflate is accepted when a BLIP client offers it
+	Level          int  // Deflate compression level (1-9) of the messages sent
+	MinMessageSize int  // Messages smaller than this many bytes are sent uncompressed
+}
+
+// Modes of protection against cross-site request forgery, for requests authenticated by session cookie
+const (
+	CSRFModeOrigin = "origin" // An Origin or Referer header, if sent, must be the database's own origin or a trusted one
+	CSRFModeToken  = "token"  // The X-CSRF-Token header must hold the session's CSRF token
+)
+
+type CSRFOptions struct {
+	Mode           string   // CSRFModeOrigin or CSRFModeToken, or empty if disabled
+	TrustedOrigins []string // Origins trusted besides the database's own, in origin mode
 }
 
 type APIEndpoints struct {
@@ -216,25 +303,74 @@ func NewDatabaseContext(dbName string, bucket base.Bucket, autoImport bool, opti
 	base.PerDbStats.Set(dbName, dbStats.ExpvarMap())
 
 	context := &DatabaseContext{
-		Name:       dbName,
-		Bucket:     bucket,
-		StartTime:  time.Now(),
-		RevsLimit:  DefaultRevsLimit,
-		autoImport: autoImport,
-		Options:    options,
-		DbStats:    dbStats,
+		Name:           dbName,
+		Bucket:         bucket,
+		MetadataBucket: bucket,
+		StartTime:      time.Now(),
+		RevsLimit:      DefaultRevsLimit,
+		autoImport:     autoImport,
+		Options:        options,
+		DbStats:        dbStats,
+	}
+	if options.MetadataBucket != nil {
+		context.MetadataBucket = options.MetadataBucket
+	}
+
+	if options.SharedRevCacheOptions.Enabled {
+		// The cache's entries would add to the load on the data or metadata bucket, that the cache is meant to reduce
+		if options.SharedRevCacheOptions.Bucket == nil {
+			return nil, errors.New("The shared revision cache requires a bucket of its own")
+		}
+		context.sharedRevCache = newSharedRevisionCache(
+			options.SharedRevCacheOptions.Bucket,
+			dbName,
+			options.SharedRevCacheOptions.ExpirySeconds,
+			context.revCacheLoader,
+			context.DbStats.StatsCache(),
+		)
+	}
+
+	context.BackgroundTasks = newBackgroundTaskManager(context, options.BackgroundTaskOptions)
+	context.healthCheck = newHealthChecker()
+
+	if options.ColdStorageOptions.Enabled {
+		context.coldRevisions = newColdRevisionStore(options.ColdStorageOptions.Bucket, dbName, options.ColdStorageOptions.AfterSecs)
 	}
 
 	context.revisionCache = NewRevisionCache(
 		options.RevisionCacheCapacity,
-		context.revCacheLoader,
+		context.revisionCacheLoaderFunc(),
 		context.DbStats.StatsCache(),
 	)
 
+	if options.DeltaSyncOptions.Enabled && options.DeltaSyncOptions.CacheMaxBytes > 0 {
+		context.deltaCache = NewDeltaCache(options.DeltaSyncOptions.CacheMaxBytes, context.DbStats.StatsDeltaSync())
+	}
+
+	if options.QueryResultCacheOptions.TTL > 0 {
+		context.QueryResultCache = NewQueryResultCache(options.QueryResultCacheOptions)
+	}
+
+	if options.QuotaOptions.Enabled() {
+		context.quotas = newQuotaTracker(context, options.QuotaOptions)
+	}
+
 	context.EventMgr = NewEventManager()
 
+	if options.UsageStatsOptions.Enabled {
+		context.UsageStats = NewUsageStats(options.UsageStatsOptions.Window)
+	}
+
+	if options.ChannelSizeOptions.Enabled {
+		context.channelSizes = newChannelSizeTracker(context)
+	}
+
+	if options.CacheNotifyOptions.Enabled {
+		context.cacheNotifier = newCacheNotifier(context, options.CacheNotifyOptions)
+	}
+
 	var err error
-	context.sequences, err = newSequenceAllocator(bucket, dbStats)
+	context.sequences, err = newSequenceAllocator(context.MetadataBucket, dbStats)
 	if err != nil {
 		return nil, err
 	}
@@ -282,7 +418,7 @@ func NewDatabaseContext(dbName string, bucket base.Bucket, autoImport bool, opti
 	if options.IndexOptions == nil || options.TrackDocs {
 		base.Infof(base.KeyDCP, "Starting mutation feed on bucket %v due to either channel cache mode or doc tracking (auto-import/bucketshadow)", base.MD(bucket.GetName()))
 
-		err = context.mutationListener.Start(bucket, options.TrackDocs, feedMode, func(bucket string, err error) {
+		onFeedDropped := func(bucket string, err error) {
 
 			msgFormat := "%v dropped Mutation Feed (TAP/DCP) due to error: %v, taking offline"
 			base.Warnf(base.KeyAll, msgFormat, base.UD(bucket), err)
@@ -330,7 +466,9 @@ func NewDatabaseContext(dbName string, bucket base.Bucket, autoImport bool, opti
 
 			// TODO: invoke the same callback function from there as well, to pick up the auto-online handling
 
-		})
+		}
+
+		err = context.mutationListener.Start(bucket, options.TrackDocs, feedMode, onFeedDropped)
 
 		// Check if there is an error starting the DCP feed
 		if err != nil {
@@ -338,6 +476,16 @@ func NewDatabaseContext(dbName string, bucket base.Bucket, autoImport bool, opti
 			return nil, err
 		}
 
+		// Users, roles and unused sequence docs arrive on the metadata bucket's feed
+		if context.HasMetadataBucket() {
+			base.Infof(base.KeyDCP, "Starting mutation feed on metadata bucket %v", base.MD(context.MetadataBucket.GetName()))
+			if err = context.mutationListener.StartMetadataFeed(context.MetadataBucket, onFeedDropped); err != nil {
+				context.mutationListener.Stop()
+				context.changeCache = nil
+				return nil, err
+			}
+		}
+
 		// Unlock change cache
 		err := context.changeCache.Start()
 		if err != nil {
@@ -399,7 +547,9 @@ func NewDatabaseContext(dbName string, bucket base.Bucket, autoImport bool, opti
 		// Set the purge interval for tombstone compaction
 		context.PurgeInterval = DefaultPurgeInterval
 		gocbBucket, ok := base.AsGoCBBucket(bucket)
-		if ok {
+		if options.TombstonePurgeOptions.IntervalHours > 0 {
+			context.PurgeInterval = options.TombstonePurgeOptions.IntervalHours
+		} else if ok {
 			serverPurgeInterval, err := gocbBucket.GetMetadataPurgeInterval()
 			if err != nil {
 				base.Warnf(base.KeyAll, "Unable to retrieve server's metadata purge interval - will use default value. %s", err)
@@ -409,6 +559,9 @@ func NewDatabaseContext(dbName string, bucket base.Bucket, autoImport bool, opti
 		}
 		base.Infof(base.KeyAll, "Using metadata purge interval of %.2f days for tombstone compaction.", float64(context.PurgeInterval)/24)
 
+		if options.TombstonePurgeOptions.Enabled {
+			context.BackgroundTasks.schedule(BackgroundTaskTombstonePurge, TombstonePurgeFrequency, purgeTombstones)
+		}
 	}
 
 	// Make sure there is no MaxTTL set on the bucket (SG #3314)
@@ -513,11 +666,23 @@ func (context *DatabaseContext) Close() {
 	context.BucketLock.Lock()
 	defer context.BucketLock.Unlock()
 
+	context.BackgroundTasks.stop()
+	context.EventMgr.Stop()
 	context.mutationListener.Stop()
 	context.changeCache.Stop()
 	context.Shadower.Stop()
+	context.channelSizes.stop()
+	context.cacheNotifier.stop()
+	if context.HasMetadataBucket() {
+		context.MetadataBucket.Close()
+	}
+	if sharedBucket := context.Options.SharedRevCacheOptions.Bucket; sharedBucket != nil {
+		sharedBucket.Close()
+	}
+	context.coldRevisions.close()
 	context.Bucket.Close()
 	context.Bucket = nil
+	context.MetadataBucket = nil
 
 	base.RemovePerDbStats(context.Name)
 
@@ -543,9 +708,17 @@ func (context *DatabaseContext) RestartListener() error {
 	if err := context.mutationListener.Start(context.Bucket, context.Options.TrackDocs, feedMode, nil); err != nil {
 		return err
 	}
+	if context.HasMetadataBucket() {
+		return context.mutationListener.StartMetadataFeed(context.MetadataBucket, nil)
+	}
 	return nil
 }
 
+// HasMetadataBucket returns true if Sync Gateway's internal docs are stored in a separate bucket from the data.
+func (context *DatabaseContext) HasMetadataBucket() bool {
+	return context.MetadataBucket != nil && context.MetadataBucket != context.Bucket
+}
+
 // Cache flush support.  Currently test-only - added for unit test access from rest package
 func (context *DatabaseContext) FlushChannelCache() error {
 	base.Infof(base.KeyCache, "Flushing channel cache")
@@ -593,6 +766,7 @@ func (dc *DatabaseContext) TakeDbOffline(reason string) error {
 		if dc.EventMgr.HasHandlerForEvent(DBStateChange) {
 			dc.EventMgr.RaiseDBStateChangeEvent(dc.Name, "offline", reason, *dc.Options.AdminInterface)
 		}
+		dc.NotifyLifecycle(DBLifecycleStatus{Transition: DBLifecycleOffline, Reason: reason})
 
 		return nil
 	} else {
@@ -607,10 +781,11 @@ func (context *DatabaseContext) Authenticator() *auth.Authenticator {
 	defer context.BucketLock.RUnlock()
 
 	// Authenticators are lightweight & stateless, so it's OK to return a new one every time
-	authenticator := auth.NewAuthenticator(context.Bucket, context)
+	authenticator := auth.NewAuthenticator(context.MetadataBucket, context)
 	if context.Options.SessionCookieName != "" {
 		authenticator.SetSessionCookieName(context.Options.SessionCookieName)
 	}
+	authenticator.SetSessionCookieOptions(context.Options.SessionCookieOptions)
 	return authenticator
 }
 
@@ -797,7 +972,7 @@ func (db *DatabaseContext) DeleteUserSessions(userName string) error {
 	var sessionsRow QueryIdRow
 	for results.Next(&sessionsRow) {
 		base.Infof(base.KeyCRUD, "\tDeleting %q", sessionsRow.Id)
-		if err := db.Bucket.Delete(sessionsRow.Id); err != nil {
+		if err := db.MetadataBucket.Delete(sessionsRow.Id); err != nil {
 			base.Warnf(base.KeyAll, "Error deleting %q: %v", sessionsRow.Id, err)
 		}
 	}
@@ -817,6 +992,20 @@ func (db *Database) Compact() (int, error) {
 		return 0, nil
 	}
 
+	startTime := time.Now()
+	db.NotifyLifecycle(DBLifecycleStatus{Transition: DBLifecycleCompactionStarted})
+	count, err := db.compactTombstones()
+	db.NotifyLifecycle(DBLifecycleStatus{
+		Transition:       DBLifecycleCompactionFinished,
+		TombstonesPurged: count,
+		Duration:         time.Since(startTime),
+		Error:            err,
+	})
+	return count, err
+}
+
+func (db *Database) compactTombstones() (int, error) {
+
 	// Trigger view compaction for all tombstoned documents older than the purge interval
 	purgeIntervalDuration := time.Duration(-db.PurgeInterval) * time.Hour
 	startTime := time.Now()
@@ -831,17 +1020,21 @@ func (db *Database) Compact() (int, error) {
 	purgedDocs := make([]string, 0)
 
 	var tombstonesRow QueryIdRow
+	var taskErr error
 	for results.Next(&tombstonesRow) {
+		if taskErr = backgroundTaskCheckpoint(db.Ctx); taskErr != nil {
+			break
+		}
 		base.Infof(base.KeyCRUD, "\tDeleting %q", tombstonesRow.Id)
-		// First, attempt to purge.
-		purgeErr := db.Purge(tombstonesRow.Id)
+		// First, attempt to purge.  Expired tombstones aren't worth keeping in the trash.
+		purgeErr := db.purge(tombstonesRow.Id)
 		if purgeErr == nil {
 			purgedDocs = append(purgedDocs, tombstonesRow.Id)
 		} else if base.IsKeyNotFoundError(db.Bucket, purgeErr) {
 			// If key no longer exists, need to add and remove to trigger removal from view
 			_, addErr := db.Bucket.Add(tombstonesRow.Id, 0, purgeBody)
 			if addErr != nil {
-				base.Warnf(base.KeyAll, "Error compacting key %s (add) - tombstone will not be compacted.  %v", base.UD(tombstonesRow.Id), addErr)
+				base.Warnf(base.KeyAll, "Error compacting key %s (add) - tombstone will not be compacted.  %v", base.UDDocID(tombstonesRow.Id), addErr)
 				continue
 			}
 
@@ -850,10 +1043,10 @@ func (db *Database) Compact() (int, error) {
 			purgedDocs = append(purgedDocs, tombstonesRow.Id)
 
 			if delErr := db.Bucket.Delete(tombstonesRow.Id); delErr != nil {
-				base.Errorf(base.KeyAll, "Error compacting key %s (delete) - tombstone will not be compacted.  %v", base.UD(tombstonesRow.Id), delErr)
+				base.Errorf(base.KeyAll, "Error compacting key %s (delete) - tombstone will not be compacted.  %v", base.UDDocID(tombstonesRow.Id), delErr)
 			}
 		} else {
-			base.Warnf(base.KeyAll, "Error compacting key %s (purge) - tombstone will not be compacted.  %v", base.UD(tombstonesRow.Id), purgeErr)
+			base.Warnf(base.KeyAll, "Error compacting key %s (purge) - tombstone will not be compacted.  %v", base.UDDocID(tombstonesRow.Id), purgeErr)
 		}
 	}
 
@@ -863,7 +1056,35 @@ func (db *Database) Compact() (int, error) {
 		db.changeCache.Remove(purgedDocs, startTime)
 	}
 
-	return count, nil
+	if taskErr != nil {
+		_ = results.Close()
+	}
+	return count, taskErr
+}
+
+// The task requested at _compact, which runs Compact while the database is online.
+func CompactTask(db *Database) (interface{}, error) {
+	if !backgroundTaskLockOnline(db) {
+		return nil, base.HTTPErrorf(http.StatusServiceUnavailable, "Database is not online")
+	}
+	defer backgroundTaskUnlock(db)
+	return db.Compact()
+}
+
+// The scheduled task that runs Compact, so that tombstones are purged once they're older than the purge interval.
+// Skipped while the database isn't online.
+func purgeTombstones(db *Database) (interface{}, error) {
+	if !backgroundTaskLockOnline(db) {
+		return nil, nil
+	}
+	defer backgroundTaskUnlock(db)
+	count, err := db.Compact()
+	if err != nil {
+		base.Warnf(base.KeyAll, "Error purging tombstones for %s: %v", base.UD(db.Name), err)
+	} else if count > 0 {
+		base.Infof(base.KeyAll, "Purged %d tombstones for %s", count, base.UD(db.Name))
+	}
+	return Body{"purged": count}, err
 }
 
 // Deletes all orphaned CouchDB attachments not used by any revisions.
@@ -894,7 +1115,7 @@ func (context *DatabaseContext) UpdateSyncFun(syncFun string) (changed bool, err
 		Sync string
 	}
 
-	_, err = context.Bucket.Update(kSyncDataKey, 0, func(currentValue []byte) ([]byte, *uint32, error) {
+	_, err = context.MetadataBucket.Update(kSyncDataKey, 0, func(currentValue []byte) ([]byte, *uint32, error) {
 		// The first time opening a new db, currentValue will be nil. Don't treat this as a change.
 		if currentValue != nil {
 			parseErr := json.Unmarshal(currentValue, &syncData)
@@ -922,6 +1143,20 @@ func (context *DatabaseContext) UpdateSyncFun(syncFun string) (changed bool, err
 // To be used when the JavaScript sync function changes.
 func (db *Database) UpdateAllDocChannels() (int, error) {
 
+	startTime := time.Now()
+	db.NotifyLifecycle(DBLifecycleStatus{Transition: DBLifecycleResyncStarted})
+	changeCount, err := db.updateAllDocChannels()
+	db.NotifyLifecycle(DBLifecycleStatus{
+		Transition:  DBLifecycleResyncFinished,
+		DocsChanged: changeCount,
+		Duration:    time.Since(startTime),
+		Error:       err,
+	})
+	return changeCount, err
+}
+
+func (db *Database) updateAllDocChannels() (int, error) {
+
 	base.Infof(base.KeyAll, "Recomputing document channels...")
 
 	results, err := db.QueryResync()
@@ -943,19 +1178,33 @@ func (db *Database) UpdateAllDocChannels() (int, error) {
 	changeCount := 0
 	docCount := 0
 
+	// A cancelled resync still invalidates the channels of the docs it changed
+	var taskErr error
 	var importRow QueryIdRow
 	for results.Next(&importRow) {
+		if taskErr = backgroundTaskCheckpoint(db.Ctx); taskErr != nil {
+			break
+		}
 		docid := importRow.Id
 		key := realDocID(docid)
 
 		docCount++
+		var grantDeltas []PrincipalDelta // Changes to the doc's grants, for the access audit trail
+		var grantRevID string
+		var grantSequence uint64
+		var prevChannels, newChannels base.Set // The doc's channels before and after, for the channel size estimates
+		var docBytes int
 		documentUpdateFunc := func(doc *document) (updatedDoc *document, shouldUpdate bool, updatedExpiry *uint32, err error) {
 			imported := false
+			grantDeltas = nil
 			if !doc.HasValidSyncData(db.writeSequences()) {
 				// This is a document not known to the sync gateway. Ignore it:
 				return nil, false, nil, base.ErrUpdateCancel
 			} else {
-				base.Debugf(base.KeyCRUD, "\tRe-syncing document %q", base.UD(docid))
+				base.Debugf(base.KeyCRUD, "\tRe-syncing document %q", base.UDDocID(docid))
+			}
+			if db.channelSizes != nil {
+				prevChannels = doc.liveChannels()
 			}
 
 			// Run the sync fn over each current/leaf revision, in case there are conflicts:
@@ -965,13 +1214,17 @@ func (db *Database) UpdateAllDocChannels() (int, error) {
 				channels, access, roles, syncExpiry, _, err := db.getChannelsAndAccess(doc, body, rev.ID)
 				if err != nil {
 					// Probably the validator rejected the doc
-					base.Warnf(base.KeyAll, "Error calling sync() on doc %q: %v", base.UD(docid), err)
+					base.Warnf(base.KeyAll, "Error calling sync() on doc %q: %v", base.UDDocID(docid), err)
 					access = nil
 					channels = nil
 				}
 				rev.Channels = channels
 
 				if rev.ID == doc.CurrentRev {
+					if db.Options.AccessAuditOptions.Enabled {
+						grantDeltas = doc.grantDeltas(access, roles)
+						grantRevID, grantSequence = doc.CurrentRev, doc.Sequence
+					}
 					changed = len(doc.Access.updateAccess(doc, access)) +
 						len(doc.RoleAccess.updateAccess(doc, roles)) +
 						len(doc.updateChannels(channels))
@@ -983,6 +1236,9 @@ func (db *Database) UpdateAllDocChannels() (int, error) {
 				}
 			})
 			shouldUpdate = changed > 0 || imported
+			if db.channelSizes != nil {
+				newChannels = doc.liveChannels()
+			}
 			return doc, shouldUpdate, updatedExpiry, nil
 		}
 		var err error
@@ -998,18 +1254,21 @@ func (db *Database) UpdateAllDocChannels() (int, error) {
 				if err != nil {
 					return nil, nil, deleteDoc, nil, err
 				}
+				docBytes = len(currentValue)
 
 				updatedDoc, shouldUpdate, updatedExpiry, err := documentUpdateFunc(doc)
 				if err != nil {
 					return nil, nil, deleteDoc, nil, err
 				}
 				if shouldUpdate {
-					base.Infof(base.KeyAccess, "Saving updated channels and access grants of %q", base.UD(docid))
+					base.Infof(base.KeyAccess, "Saving updated channels and access grants of %q", base.UDDocID(docid))
 					if updatedExpiry != nil {
 						updatedDoc.UpdateExpiry(*updatedExpiry)
 					}
-					raw, rawXattr, err = updatedDoc.MarshalWithXattr()
-					return raw, rawXattr, deleteDoc, updatedExpiry, err
+					// Resync only changes sync metadata, so return the existing body unchanged and let the bucket
+					// write just the xattr
+					_, rawXattr, err = updatedDoc.MarshalWithXattr()
+					return currentValue, rawXattr, deleteDoc, updatedExpiry, err
 				} else {
 					return nil, nil, deleteDoc, nil, base.ErrUpdateCancel
 				}
@@ -1025,16 +1284,17 @@ func (db *Database) UpdateAllDocChannels() (int, error) {
 				if err != nil {
 					return nil, nil, err
 				}
+				docBytes = len(currentValue)
 				updatedDoc, shouldUpdate, updatedExpiry, err := documentUpdateFunc(doc)
 				if err != nil {
 					return nil, nil, err
 				}
 				if shouldUpdate {
-					base.Infof(base.KeyAccess, "Saving updated channels and access grants of %q", base.UD(docid))
+					base.Infof(base.KeyAccess, "Saving updated channels and access grants of %q", base.UDDocID(docid))
 					if updatedExpiry != nil {
 						updatedDoc.UpdateExpiry(*updatedExpiry)
 					}
-					updatedBytes, marshalErr := json.Marshal(updatedDoc)
+					updatedBytes, marshalErr := db.marshalDocument(updatedDoc)
 					return updatedBytes, updatedExpiry, marshalErr
 				} else {
 					return nil, nil, base.ErrUpdateCancel
@@ -1043,8 +1303,13 @@ func (db *Database) UpdateAllDocChannels() (int, error) {
 		}
 		if err == nil {
 			changeCount++
+			if len(grantDeltas) > 0 {
+				db.recordAccessGrants(grantDeltas, grantRevID, grantSequence, true)
+			}
+			// Resync only changes the doc's channels, not its body
+			db.channelSizes.recordUpdate(prevChannels, docBytes, newChannels, docBytes)
 		} else if err != base.ErrUpdateCancel {
-			base.Warnf(base.KeyAll, "Error updating doc %q: %v", base.UD(docid), err)
+			base.Warnf(base.KeyAll, "Error updating doc %q: %v", base.UDDocID(docid), err)
 		}
 	}
 
@@ -1067,14 +1332,14 @@ func (db *Database) UpdateAllDocChannels() (int, error) {
 			db.invalRoleChannels(name)
 		}
 	}
-	return changeCount, nil
+	return changeCount, taskErr
 }
 
 func (db *Database) invalUserRoles(username string) {
 	authr := db.Authenticator()
 	if user, _ := authr.GetUser(username); user != nil {
 		if err := authr.InvalidateRoles(user); err != nil {
-			base.Warnf(base.KeyAll, "Error invalidating roles for user %s: %v", base.UD(username), err)
+			base.Warnf(base.KeyAll, "Error invalidating roles for user %s: %v", base.UDUsername(username), err)
 		}
 	}
 }
@@ -1083,7 +1348,7 @@ func (db *Database) invalUserChannels(username string) {
 	authr := db.Authenticator()
 	if user, _ := authr.GetUser(username); user != nil {
 		if err := authr.InvalidateChannels(user); err != nil {
-			base.Warnf(base.KeyAll, "Error invalidating channels for user %s: %v", base.UD(username), err)
+			base.Warnf(base.KeyAll, "Error invalidating channels for user %s: %v", base.UDUsername(username), err)
 		}
 	}
 }
@@ -1092,7 +1357,7 @@ func (db *Database) invalRoleChannels(rolename string) {
 	authr := db.Authenticator()
 	if role, _ := authr.GetRole(rolename); role != nil {
 		if err := authr.InvalidateChannels(role); err != nil {
-			base.Warnf(base.KeyAll, "Error invalidating channels for role %s: %v", base.UD(rolename), err)
+			base.Warnf(base.KeyAll, "Error invalidating channels for role %s: %v", base.UDUsername(rolename), err)
 		}
 	}
 }
@@ -1145,15 +1410,38 @@ func (context *DatabaseContext) SetUserViewsEnabled(value bool) {
 	context.Options.UnsupportedOptions.UserViews.Enabled = &value
 }
 
+// Returns the function the revision cache loads revisions with: from the shared revision cache, if enabled, or
+// otherwise from their documents.
+func (context *DatabaseContext) revisionCacheLoaderFunc() RevisionCacheLoaderFunc {
+	if context.sharedRevCache != nil {
+		return context.sharedRevCache.load
+	}
+	return context.revCacheLoader
+}
+
 // For test usage
 func (context *DatabaseContext) FlushRevisionCacheForTest() {
 
 	context.revisionCache = NewRevisionCache(
 		context.Options.RevisionCacheCapacity,
-		context.revCacheLoader,
+		context.revisionCacheLoaderFunc(),
 		context.DbStats.StatsCache(),
 	)
 
+	if context.deltaCache != nil {
+		context.deltaCache.Clear()
+	}
+}
+
+// Returns the max depth of the given document's revision tree: the RevsLimit of the first matching override, otherwise
+// the database's RevsLimit.
+func (context *DatabaseContext) revsLimitForDoc(docid string, body Body) uint32 {
+	for _, override := range context.RevsLimitOverrides {
+		if override.Matches(docid, body) {
+			return override.RevsLimit
+		}
+	}
+	return context.RevsLimit
 }
 
 // For test usage
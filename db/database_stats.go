@@ -84,6 +84,8 @@ func initEmptyStatsMap(key string) *expvar.Map {
 		result.Set(base.StatKeyNumSkippedSeqs, base.ExpvarIntVal(0))
 		result.Set(base.StatKeyRevisionCacheHits, base.ExpvarIntVal(0))
 		result.Set(base.StatKeyRevisionCacheMisses, base.ExpvarIntVal(0))
+		result.Set(base.StatKeySharedRevisionCacheHits, base.ExpvarIntVal(0))
+		result.Set(base.StatKeySharedRevisionCacheMisses, base.ExpvarIntVal(0))
 		result.Set(base.StatKeyChannelCacheHits, base.ExpvarIntVal(0))
 		result.Set(base.StatKeyChannelCacheMisses, base.ExpvarIntVal(0))
 		result.Set(base.StatKeyChannelCacheRevsActive, base.ExpvarIntVal(0))
@@ -112,6 +114,30 @@ func initEmptyStatsMap(key string) *expvar.Map {
 		result.Set(base.StatKeyDcpCachingTime, base.ExpvarIntVal(0))
 		result.Set(base.StatKeyDcpReceivedCount, base.ExpvarIntVal(0))
 		result.Set(base.StatKeyDcpReceivedTime, base.ExpvarIntVal(0))
+		result.Set(base.StatKeyExpiredTombstoneCount, base.ExpvarIntVal(0))
+		result.Set(base.StatKeyNumReplicationsCompleted, base.ExpvarIntVal(0))
+		result.Set(base.StatKeyNumReplicationErrors, base.ExpvarIntVal(0))
+		result.Set(base.StatKeyQuotaDocsRejectedCount, base.ExpvarIntVal(0))
+		result.Set(base.StatKeyQuotaAttachmentsRejectedCount, base.ExpvarIntVal(0))
+		result.Set(base.StatKeyQuotaReplicationsRejectedCount, base.ExpvarIntVal(0))
+		result.Set(base.StatKeyQuotaOpsRejectedCount, base.ExpvarIntVal(0))
+		result.Set(base.StatKeyWebhookDeliveredCount, base.ExpvarIntVal(0))
+		result.Set(base.StatKeyWebhookFilteredCount, base.ExpvarIntVal(0))
+		result.Set(base.StatKeyWebhookRetryCount, base.ExpvarIntVal(0))
+		result.Set(base.StatKeyWebhookFailedCount, base.ExpvarIntVal(0))
+		result.Set(base.StatKeyWebhookDeadLetterCount, base.ExpvarIntVal(0))
+		result.Set(base.StatKeyEventQueuePersistedCount, base.ExpvarIntVal(0))
+		result.Set(base.StatKeyEventQueueProcessedCount, base.ExpvarIntVal(0))
+		result.Set(base.StatKeyEventQueueDroppedCount, base.ExpvarIntVal(0))
+		result.Set(base.StatKeyKafkaPublishedCount, base.ExpvarIntVal(0))
+		result.Set(base.StatKeyKafkaFilteredCount, base.ExpvarIntVal(0))
+		result.Set(base.StatKeyKafkaFailedCount, base.ExpvarIntVal(0))
+		result.Set(base.StatKeySinkPublishedCount, base.ExpvarIntVal(0))
+		result.Set(base.StatKeySinkFilteredCount, base.ExpvarIntVal(0))
+		result.Set(base.StatKeySinkFailedCount, base.ExpvarIntVal(0))
+		result.Set(base.StatKeyPushSentCount, base.ExpvarIntVal(0))
+		result.Set(base.StatKeyPushFailedCount, base.ExpvarIntVal(0))
+		result.Set(base.StatKeyPushUnregisteredCount, base.ExpvarIntVal(0))
 	case base.StatsGroupKeyDeltaSync:
 		result.Set(base.StatKeyDeltasRequested, base.ExpvarIntVal(0))
 		result.Set(base.StatKeyDeltasSent, base.ExpvarIntVal(0))
@@ -119,6 +145,7 @@ func initEmptyStatsMap(key string) *expvar.Map {
 		result.Set(base.StatKeyDeltaCacheHits, base.ExpvarIntVal(0))
 		result.Set(base.StatKeyDeltaCacheMisses, base.ExpvarIntVal(0))
 		result.Set(base.StatKeyDeltaPushDocCount, base.ExpvarIntVal(0))
+		result.Set(base.StatKeyDeltaCacheBytes, base.ExpvarIntVal(0))
 	case base.StatsGroupKeySharedBucketImport:
 		result.Set(base.StatKeyImportCount, base.ExpvarIntVal(0))
 		result.Set(base.StatKeyImportErrorCount, base.ExpvarIntVal(0))
@@ -12,6 +12,7 @@ package db
 import (
 	"fmt"
 	"log"
+	"regexp"
 	"strings"
 	"sync"
 	"testing"
@@ -1590,3 +1591,73 @@ func BenchmarkDatabase(b *testing.B) {
 		db.Close()
 	}
 }
+
+func TestTombstonePurgeOptions(t *testing.T) {
+
+	if !base.TestUseXattrs() {
+		t.Skip("Tombstone purge requires XATTRs.  Skipping.")
+	}
+
+	defer func(frequency time.Duration) { TombstonePurgeFrequency = frequency }(TombstonePurgeFrequency)
+	TombstonePurgeFrequency = 50 * time.Millisecond
+
+	dbcOptions := DatabaseContextOptions{
+		TombstonePurgeOptions: TombstonePurgeOptions{Enabled: true, IntervalHours: 1},
+	}
+	AddOptionsFromEnvironmentVariables(&dbcOptions)
+	tBucket := testBucket()
+	defer tBucket.Close()
+	context, err := NewDatabaseContext("db", tBucket.Bucket, false, dbcOptions)
+	assert.NoError(t, err, "Couldn't create context for database 'db'")
+	db, err := CreateDatabase(context)
+	assert.NoError(t, err, "Couldn't create database 'db'")
+	defer tearDownTestDB(t, db)
+
+	// The configured interval overrides the server's metadata purge interval
+	assert.Equal(t, 1, db.PurgeInterval)
+
+	rev, err := db.Put("doc1", Body{"foo": "bar"})
+	assert.NoError(t, err)
+	_, err = db.DeleteDoc("doc1", rev)
+	assert.NoError(t, err)
+
+	// Tombstones younger than the purge interval survive purge runs
+	time.Sleep(5 * TombstonePurgeFrequency)
+	doc, err := db.GetDocument("doc1", DocUnmarshalSync)
+	assert.NoError(t, err)
+	assert.True(t, doc.hasFlag(channels.Deleted))
+}
+
+func TestRevsLimitOverrides(t *testing.T) {
+
+	db, testBucket := setupTestDB(t)
+	defer testBucket.Close()
+	defer tearDownTestDB(t, db)
+
+	db.RevsLimit = 100
+	db.RevsLimitOverrides = []RevsLimitOverride{
+		{DocIDPattern: regexp.MustCompile("^telemetry:"), RevsLimit: 5},
+		{DocType: "log", RevsLimit: 10},
+		{DocIDPattern: regexp.MustCompile("^log"), DocType: "audit", RevsLimit: 15},
+	}
+
+	// Writes 20 revisions of the doc, and returns the number of revisions left after pruning
+	numRevsAfterUpdates := func(docid string, body Body) int {
+		revid, err := db.Put(docid, body.ShallowCopy())
+		assert.NoError(t, err)
+		for i := 1; i < 20; i++ {
+			update := body.ShallowCopy()
+			update[BodyRev] = revid
+			revid, err = db.Put(docid, update)
+			assert.NoError(t, err)
+		}
+		doc, err := db.GetDocument(docid, DocUnmarshalAll)
+		assert.NoError(t, err)
+		return len(doc.History)
+	}
+
+	assert.Equal(t, 5, numRevsAfterUpdates("telemetry:1", Body{"type": "log"}))
+	assert.Equal(t, 10, numRevsAfterUpdates("doc1", Body{"type": "log"}))
+	assert.Equal(t, 15, numRevsAfterUpdates("log1", Body{"type": "audit"}))
+	assert.Equal(t, 20, numRevsAfterUpdates("doc2", Body{"type": "audit"}))
+}
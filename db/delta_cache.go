@@ -0,0 +1,105 @@
+package db
+
+import (
+	"container/list"
+	"expvar"
+	"sync"
+
+	"github.com/couchbase/sync_gateway/base"
+)
+
+// Default memory budget for a database's DeltaCache
+const DefaultDeltaCacheMaxBytes = int64(10 * 1024 * 1024) // 10 MB
+
+// Identifies a delta between two revisions of a document.
+type DeltaCacheKey struct {
+	DocID     string
+	FromRevID string
+	ToRevID   string
+}
+
+// Size of the entry for the given delta, as counted against the cache's memory budget.
+func (key DeltaCacheKey) entrySize(delta []byte) int64 {
+	return int64(len(key.DocID) + len(key.FromRevID) + len(key.ToRevID) + len(delta))
+}
+
+// The cache payload data.  Stored as the Value of a list Element.
+type deltaCacheValue struct {
+	key   DeltaCacheKey
+	delta []byte
+}
+
+// An LRU cache of generated deltas, bounded by the total size of the deltas rather than their number.  Unlike the
+// delta stored on a revision cache entry, which only holds the most recent delta from that revision, deltas from the
+// same revision to several others can be cached, so many clients pulling the same hot doc from different revisions
+// don't regenerate the deltas each time.  Entries never go stale, as revisions are immutable.
+type DeltaCache struct {
+	cache      map[DeltaCacheKey]*list.Element // Fast lookup of list element by key
+	lruList    *list.List                      // List ordered by most recent access (Front is newest)
+	maxBytes   int64                           // Memory budget for the cached deltas
+	bytes      int64                           // Current size of the cached deltas
+	lock       sync.Mutex                      // For thread-safety
+	cacheBytes *expvar.Int                     // Stat tracking the current size of the cached deltas
+}
+
+// Creates a delta cache with the given memory budget, recording its size in the given delta sync stats map.
+func NewDeltaCache(maxBytes int64, statsDeltaSync *expvar.Map) *DeltaCache {
+	return &DeltaCache{
+		cache:      map[DeltaCacheKey]*list.Element{},
+		lruList:    list.New(),
+		maxBytes:   maxBytes,
+		cacheBytes: statsDeltaSync.Get(base.StatKeyDeltaCacheBytes).(*expvar.Int),
+	}
+}
+
+// Returns the cached delta between the given revisions, or nil if it isn't cached.
+func (dc *DeltaCache) Get(docID, fromRevID, toRevID string) []byte {
+	dc.lock.Lock()
+	defer dc.lock.Unlock()
+	elem, ok := dc.cache[DeltaCacheKey{DocID: docID, FromRevID: fromRevID, ToRevID: toRevID}]
+	if !ok {
+		return nil
+	}
+	dc.lruList.MoveToFront(elem)
+	return elem.Value.(*deltaCacheValue).delta
+}
+
+// Adds a delta to the cache, evicting the least recently used deltas as needed to stay within the memory budget.
+// Deltas larger than the whole budget aren't cached.
+func (dc *DeltaCache) Put(docID, fromRevID, toRevID string, delta []byte) {
+	key := DeltaCacheKey{DocID: docID, FromRevID: fromRevID, ToRevID: toRevID}
+	size := key.entrySize(delta)
+	if size > dc.maxBytes {
+		return
+	}
+
+	dc.lock.Lock()
+	defer dc.lock.Unlock()
+	if elem, ok := dc.cache[key]; ok {
+		dc.lruList.MoveToFront(elem)
+		return
+	}
+	dc.cache[key] = dc.lruList.PushFront(&deltaCacheValue{key: key, delta: delta})
+	dc.bytes += size
+	for dc.bytes > dc.maxBytes {
+		dc.removeElement(dc.lruList.Back())
+	}
+	dc.cacheBytes.Set(dc.bytes)
+}
+
+// Removes an element from the cache.  Requires the lock.
+func (dc *DeltaCache) removeElement(elem *list.Element) {
+	value := dc.lruList.Remove(elem).(*deltaCacheValue)
+	delete(dc.cache, value.key)
+	dc.bytes -= value.key.entrySize(value.delta)
+}
+
+// Removes all deltas from the cache.
+func (dc *DeltaCache) Clear() {
+	dc.lock.Lock()
+	defer dc.lock.Unlock()
+	dc.cache = map[DeltaCacheKey]*list.Element{}
+	dc.lruList.Init()
+	dc.bytes = 0
+	dc.cacheBytes.Set(0)
+}
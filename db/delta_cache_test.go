@@ -0,0 +1,44 @@
+package db
+
+import (
+	"expvar"
+	"testing"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeltaCache(t *testing.T) {
+	stats := initEmptyStatsMap(base.StatsGroupKeyDeltaSync)
+	cacheBytes := func() int64 {
+		return stats.Get(base.StatKeyDeltaCacheBytes).(*expvar.Int).Value()
+	}
+
+	// Each entry takes up 4 (doc ID) + 3 + 3 (rev IDs) + 10 (delta) = 20 bytes
+	cache := NewDeltaCache(50, stats)
+	delta := []byte("0123456789")
+
+	cache.Put("doc1", "1-a", "2-a", delta)
+	cache.Put("doc1", "1-a", "3-a", delta)
+	assert.Equal(t, delta, cache.Get("doc1", "1-a", "2-a"))
+	assert.Equal(t, delta, cache.Get("doc1", "1-a", "3-a"))
+	assert.Nil(t, cache.Get("doc1", "1-a", "4-a"))
+	assert.Equal(t, int64(40), cacheBytes())
+
+	// Exceeding the budget evicts the least recently used delta
+	cache.Get("doc1", "1-a", "2-a")
+	cache.Put("doc2", "1-a", "2-a", delta)
+	assert.Nil(t, cache.Get("doc1", "1-a", "3-a"))
+	assert.Equal(t, delta, cache.Get("doc1", "1-a", "2-a"))
+	assert.Equal(t, delta, cache.Get("doc2", "1-a", "2-a"))
+	assert.Equal(t, int64(40), cacheBytes())
+
+	// Deltas larger than the budget aren't cached
+	cache.Put("doc3", "1-a", "2-a", make([]byte, 50))
+	assert.Nil(t, cache.Get("doc3", "1-a", "2-a"))
+	assert.Equal(t, int64(40), cacheBytes())
+
+	cache.Clear()
+	assert.Nil(t, cache.Get("doc1", "1-a", "2-a"))
+	assert.Equal(t, int64(0), cacheBytes())
+}
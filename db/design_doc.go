@@ -17,14 +17,14 @@ import (
 // ViewVersion should be incremented every time any view definition changes.
 // Currently both Sync Gateway design docs share the same view version, but this is
 // subject to change if the update schedule diverges
-const DesignDocVersion = "2.1"
+const DesignDocVersion = "2.3"
 const DesignDocFormat = "%s_%s" // Design doc prefix, view version
 
 // DesignDocPreviousVersions defines the set of versions included during removal of obsolete
 // design docs.  Must be updated whenever DesignDocVersion is incremented.
 // Uses a hardcoded list instead of version comparison to simpify the processing
 // (particularly since there aren't expected to be many view versions before moving to GSI).
-var DesignDocPreviousVersions = []string{""}
+var DesignDocPreviousVersions = []string{"", "2.1", "2.2"}
 
 const (
 	DesignDocSyncGatewayPrefix      = "sync_gateway"
@@ -39,6 +39,8 @@ const (
 	ViewImport                      = "import"
 	ViewSessions                    = "sessions"
 	ViewTombstones                  = "tombstones"
+	ViewConflicts                   = "conflicts"
+	ViewSyncDocs                    = "sync_docs"
 )
 
 func isInternalDDoc(ddocName string) bool {
@@ -79,8 +81,15 @@ func (db *Database) PutDesignDoc(ddocName string, ddoc sgbucket.DesignDoc) (err
 		wrapViews(&ddoc, db.GetUserViewsEnabled(), db.UseXattrs())
 	}
 	if err = db.checkDDocAccess(ddocName); err == nil {
+		// Views' map functions would see only the compressed body
+		if db.Options.BodyCompressionOptions.Enabled {
+			return base.HTTPErrorf(http.StatusBadRequest, "Views are not supported with body_compression")
+		}
 		err = db.Bucket.PutDDoc(ddocName, ddoc)
 	}
+	if err == nil && db.QueryResultCache != nil {
+		db.QueryResultCache.Clear()
+	}
 	return
 }
 
@@ -191,6 +200,9 @@ func (db *Database) DeleteDesignDoc(ddocName string) (err error) {
 	if err = db.checkDDocAccess(ddocName); err == nil {
 		err = db.Bucket.DeleteDDoc(ddocName)
 	}
+	if err == nil && db.QueryResultCache != nil {
+		db.QueryResultCache.Clear()
+	}
 	return
 }
 
@@ -382,6 +394,22 @@ func installViews(bucket base.Bucket) error {
                      		emit(sync.tombstoned_at, meta.id);}`
 	tombstones_map = fmt.Sprintf(tombstones_map, syncData)
 
+	// Conflicts view - used for listing conflicted documents
+	// Key is docid
+	conflicts_map := `function (doc, meta) {
+                     	%s
+                     	if (sync === undefined || meta.id.substring(0,6) == "_sync:")
+                     		return;
+                     	if (sync.flags & %d) // channels.Conflict
+                     		emit(meta.id, null);}`
+	conflicts_map = fmt.Sprintf(conflicts_map, syncData, ch.Conflict)
+
+	// Sync docs view - used for metadata backups
+	// Key is docid
+	syncDocs_map := `function (doc, meta) {
+                     	if (meta.id.substring(0,6) == "_sync:")
+                     		emit(meta.id, null);}`
+
 	// All-principals view
 	// Key is name; value is true for user, false for role
 	principals_map := `function (doc, meta) {
@@ -528,6 +556,8 @@ func installViews(bucket base.Bucket) error {
 			ViewImport:     sgbucket.ViewDef{Map: import_map, Reduce: "_count"},
 			ViewSessions:   sgbucket.ViewDef{Map: sessions_map},
 			ViewTombstones: sgbucket.ViewDef{Map: tombstones_map},
+			ViewConflicts:  sgbucket.ViewDef{Map: conflicts_map},
+			ViewSyncDocs:   sgbucket.ViewDef{Map: syncDocs_map},
 		},
 		Options: &sgbucket.DesignDocOptions{
 			IndexXattrOnTombstones: true, // For ViewTombstones
@@ -61,6 +61,7 @@ type syncData struct {
 	Crc32c          string              `json:"value_crc32c"`            // String representation of crc32c hash of doc body, populated via macro expansion
 	TombstonedAt    int64               `json:"tombstoned_at,omitempty"` // Time the document was tombstoned.  Used for view compaction
 	Attachments     AttachmentsMeta     `json:"attachments,omitempty"`
+	BodyEncoding    string              `json:"body_encoding,omitempty"` // How the body is encoded, if it's stored compressed
 
 	// Fields used by bucket-shadowing:
 	UpstreamCAS *uint64 `json:"upstream_cas,omitempty"` // CAS value of remote doc
@@ -74,6 +75,7 @@ type syncData struct {
 
 	addedRevisionBodies     []string          // revIDs of non-winning revision bodies that have been added (and so require persistence)
 	removedRevisionBodyKeys map[string]string // keys of non-winning revisions that have been removed (and so may require deletion), indexed by revID
+	prunedRevIDs            []string          // revIDs pruned from the history (whose old revision bodies may require deletion from cold storage)
 }
 
 // A document as stored in Couchbase. Contains the body of the current revision plus metadata.
@@ -120,15 +122,24 @@ func (doc *document) RemoveBody() {
 	doc.rawBody = nil
 }
 
-// TODO: review whether this can just return raw body when available
+// Returns the JSON of the document body.  If the body hasn't been unmarshalled yet, the raw body is returned as
+// retrieved from the bucket, rather than being unmarshalled and marshalled again.
 func (doc *document) MarshalBody() ([]byte, error) {
-	marshalled, err := json.Marshal(doc.Body())
+	if doc._body == nil && doc.rawBody != nil {
+		return doc.rawBody, nil
+	}
+	marshalled, err := json.Marshal(doc._body)
 	if err != nil {
 		return []byte{}, pkgerrors.Wrapf(err, "Error marshalling JSON")
 	}
 	return marshalled, err
 }
 
+// Returns true if the document has a body, whether or not it's been unmarshalled.
+func (doc *document) hasBody() bool {
+	return doc._body != nil || doc.rawBody != nil
+}
+
 // Unmarshals a document from JSON data. The doc ID isn't in the data and must be given.  Uses decode to ensure
 // UseNumber handling is applied to numbers in the body.
 func unmarshalDocument(docid string, data []byte) (*document, error) {
@@ -325,7 +336,7 @@ func (doc *document) IsSGWrite(rawBody []byte) (isSGWrite bool, crc32Match bool)
 
 		isSgWriteFeed, crc32MatchFeed := doc.syncData.IsSGWrite(doc.Cas, rawBody)
 		if !isSgWriteFeed {
-			base.Debugf(base.KeyCRUD, "Doc %s is not an SG write, based on cas and body hash. cas:%x syncCas:%q", base.UD(doc.ID), doc.Cas, doc.syncData.Cas)
+			base.Debugf(base.KeyCRUD, "Doc %s is not an SG write, based on cas and body hash. cas:%x syncCas:%q", base.UDDocID(doc.ID), doc.Cas, doc.syncData.Cas)
 		}
 
 		return isSgWriteFeed, crc32MatchFeed
@@ -340,14 +351,14 @@ func (doc *document) IsSGWrite(rawBody []byte) (isSGWrite bool, crc32Match bool)
 	// Since raw body isn't available, marshal from the document to perform body hash comparison
 	docBody, err := doc.MarshalBody()
 	if err != nil {
-		base.Warnf(base.KeyAll, "Unable to marshal doc body during SG write check for doc %s.  Error: %v", base.UD(doc.ID), err)
+		base.Warnf(base.KeyAll, "Unable to marshal doc body during SG write check for doc %s.  Error: %v", base.UDDocID(doc.ID), err)
 		return false, false
 	}
 	if base.Crc32cHashString(docBody) == doc.syncData.Crc32c {
 		return true, true
 	}
 
-	base.Debugf(base.KeyCRUD, "Doc %s is not an SG write, based on cas and body hash. cas:%x syncCas:%q", base.UD(doc.ID), doc.Cas, doc.syncData.Cas)
+	base.Debugf(base.KeyCRUD, "Doc %s is not an SG write, based on cas and body hash. cas:%x syncCas:%q", base.UDDocID(doc.ID), doc.Cas, doc.syncData.Cas)
 	return false, false
 }
 
@@ -355,6 +366,11 @@ func (doc *document) hasFlag(flag uint8) bool {
 	return doc.Flags&flag != 0
 }
 
+// Returns true if the document has a current revision that isn't a tombstone.
+func (doc *document) isLive() bool {
+	return doc.CurrentRev != "" && !doc.hasFlag(channels.Deleted)
+}
+
 func (doc *document) setFlag(flag uint8, state bool) {
 	if state {
 		doc.Flags |= flag
@@ -410,7 +426,7 @@ func (doc *document) getRevisionBodyJSON(revid string, loader RevLoaderFunc) []b
 	var bodyJSON []byte
 	if revid == doc.CurrentRev {
 		var marshalErr error
-		bodyJSON, marshalErr = json.Marshal(doc._body)
+		bodyJSON, marshalErr = doc.MarshalBody()
 		if marshalErr != nil {
 			base.Warnf(base.KeyAll, "Marshal error when retrieving active current revision body: %v", marshalErr)
 		}
@@ -435,11 +451,23 @@ func (doc *document) promoteNonWinningRevisionBody(revid string, loader RevLoade
 	// If the new revision is not current, transfer the current revision's
 	// body to the top level doc._body:
 	doc._body = doc.getNonWinningRevisionBody(revid, loader)
+	doc.rawBody = nil
 	doc.removeRevisionBody(revid)
 }
 
 func (doc *document) pruneRevisions(maxDepth uint32, keepRev string) int {
+	var revIDs []string
+	if len(doc.History) > int(maxDepth) {
+		revIDs = doc.History.revIDs()
+	}
 	numPruned, prunedTombstoneBodyKeys := doc.History.pruneRevisions(maxDepth, keepRev)
+	if numPruned > 0 {
+		for _, revID := range revIDs {
+			if _, ok := doc.History[revID]; !ok {
+				doc.prunedRevIDs = append(doc.prunedRevIDs, revID)
+			}
+		}
+	}
 	for revID, bodyKey := range prunedTombstoneBodyKeys {
 		if doc.removedRevisionBodyKeys == nil {
 			doc.removedRevisionBodyKeys = make(map[string]string)
@@ -454,6 +482,7 @@ func (doc *document) setRevisionBody(revid string, body Body, storeInline bool)
 	strippedBody := stripSpecialProperties(body)
 	if revid == doc.CurrentRev {
 		doc._body = strippedBody
+		doc.rawBody = nil
 	} else {
 		var asJson []byte
 		if len(body) > 0 {
@@ -491,7 +520,7 @@ func (doc *document) persistModifiedRevisionBodies(bucket base.Bucket) error {
 			return err
 		}
 		if revInfo.BodyKey == "" || len(revInfo.Body) == 0 {
-			return base.RedactErrorf("Missing key or body for revision during external persistence.  doc: %s rev:%s key: %s  len(body): %d", base.UD(doc.ID), revID, base.UD(revInfo.BodyKey), len(revInfo.Body))
+			return base.RedactErrorf("Missing key or body for revision during external persistence.  doc: %s rev:%s key: %s  len(body): %d", base.UDDocID(doc.ID), revID, base.UD(revInfo.BodyKey), len(revInfo.Body))
 		}
 
 		// If addRaw indicates that the doc already exists, can ignore.  Another writer already persisted this rev backup.
@@ -535,7 +564,7 @@ func (doc *document) migrateRevisionBodies(bucket base.Bucket) error {
 			bodyKey := generateRevBodyKey(doc.ID, revID)
 			persistErr := doc.persistRevisionBody(bucket, bodyKey, revInfo.Body)
 			if persistErr != nil {
-				base.Warnf(base.KeyAll, "Unable to store revision body for doc %s, rev %s externally: %v", base.UD(doc.ID), revID, persistErr)
+				base.Warnf(base.KeyAll, "Unable to store revision body for doc %s, rev %s externally: %v", base.UDDocID(doc.ID), revID, persistErr)
 				continue
 			}
 			revInfo.BodyKey = bodyKey
@@ -598,7 +627,7 @@ func (doc *document) updateChannels(newChannels base.Set) (changedChannels base.
 		}
 	}
 	if changed != nil {
-		base.Infof(base.KeyCRUD, "\tDoc %q / %q in channels %q", base.UD(doc.ID), doc.CurrentRev, base.UD(newChannels))
+		base.Infof(base.KeyCRUD, "\tDoc %q / %q in channels %q", base.UDDocID(doc.ID), doc.CurrentRev, base.UDChannel(newChannels))
 		changedChannels = channels.SetOf(changed...)
 	}
 	return
@@ -677,7 +706,7 @@ func (accessMap *UserAccessMap) updateAccess(doc *document, newAccess channels.A
 		if accessMap == &doc.RoleAccess {
 			what = "role"
 		}
-		base.Infof(base.KeyAccess, "Doc %q grants %s access: %v", base.UD(doc.ID), what, base.UD(*accessMap))
+		base.Infof(base.KeyAccess, "Doc %q grants %s access: %v", base.UDDocID(doc.ID), what, base.UD(*accessMap))
 	}
 	return changedUsers
 }
@@ -695,7 +724,7 @@ func (doc *document) UnmarshalJSON(data []byte) error {
 	root := documentRoot{SyncData: &syncData{History: make(RevTree)}}
 	err := json.Unmarshal([]byte(data), &root)
 	if err != nil {
-		return pkgerrors.WithStack(base.RedactErrorf("Failed to UnmarshalJSON() doc with id: %s.  Error: %v", base.UD(doc.ID), err))
+		return pkgerrors.WithStack(base.RedactErrorf("Failed to UnmarshalJSON() doc with id: %s.  Error: %v", base.UDDocID(doc.ID), err))
 	}
 
 	if root.SyncData != nil {
@@ -703,10 +732,15 @@ func (doc *document) UnmarshalJSON(data []byte) error {
 	}
 
 	if err := doc._body.Unmarshal(data); err != nil {
-		return pkgerrors.WithStack(base.RedactErrorf("Failed to UnmarshalJSON() doc with id: %s.  Error: %v", base.UD(doc.ID), err))
+		return pkgerrors.WithStack(base.RedactErrorf("Failed to UnmarshalJSON() doc with id: %s.  Error: %v", base.UDDocID(doc.ID), err))
 	}
 
 	delete(doc._body, "_sync")
+	if doc.BodyEncoding != "" {
+		if err := doc.decompressBody(); err != nil {
+			return pkgerrors.WithStack(base.RedactErrorf("Failed to decompress body of doc with id: %s.  Error: %v", base.UDDocID(doc.ID), err))
+		}
+	}
 	return nil
 }
 
@@ -719,7 +753,7 @@ func (doc *document) MarshalJSON() ([]byte, error) {
 	data, err := json.Marshal(body)
 	delete(body, "_sync")
 	if err != nil {
-		err = pkgerrors.WithStack(base.RedactErrorf("Failed to MarshalJSON() doc with id: %s.  Error: %v", base.UD(doc.ID), err))
+		err = pkgerrors.WithStack(base.RedactErrorf("Failed to MarshalJSON() doc with id: %s.  Error: %v", base.UDDocID(doc.ID), err))
 	}
 	return data, err
 }
@@ -740,7 +774,7 @@ func (doc *document) UnmarshalWithXattr(data []byte, xdata []byte, unmarshalLeve
 		doc.syncData = syncData{History: make(RevTree)}
 		unmarshalErr := json.Unmarshal(xdata, &doc.syncData)
 		if unmarshalErr != nil {
-			return pkgerrors.WithStack(base.RedactErrorf("Failed to UnmarshalWithXattr() doc with id: %s (DocUnmarshalAll/Sync).  Error: %v", base.UD(doc.ID), unmarshalErr))
+			return pkgerrors.WithStack(base.RedactErrorf("Failed to UnmarshalWithXattr() doc with id: %s (DocUnmarshalAll/Sync).  Error: %v", base.UDDocID(doc.ID), unmarshalErr))
 		}
 		// Unmarshal body if requested and present
 		if unmarshalLevel == DocUnmarshalAll && len(data) > 0 {
@@ -754,7 +788,7 @@ func (doc *document) UnmarshalWithXattr(data []byte, xdata []byte, unmarshalLeve
 		doc.syncData = syncData{}
 		unmarshalErr := json.Unmarshal(xdata, &doc.syncData)
 		if unmarshalErr != nil {
-			return pkgerrors.WithStack(base.RedactErrorf("Failed to UnmarshalWithXattr() doc with id: %s (DocUnmarshalNoHistory).  Error: %v", base.UD(doc.ID), unmarshalErr))
+			return pkgerrors.WithStack(base.RedactErrorf("Failed to UnmarshalWithXattr() doc with id: %s (DocUnmarshalNoHistory).  Error: %v", base.UDDocID(doc.ID), unmarshalErr))
 		}
 		doc.rawBody = data
 	case DocUnmarshalRev:
@@ -762,7 +796,7 @@ func (doc *document) UnmarshalWithXattr(data []byte, xdata []byte, unmarshalLeve
 		var revOnlyMeta revOnlySyncData
 		unmarshalErr := json.Unmarshal(xdata, &revOnlyMeta)
 		if unmarshalErr != nil {
-			return pkgerrors.WithStack(base.RedactErrorf("Failed to UnmarshalWithXattr() doc with id: %s (DocUnmarshalRev).  Error: %v", base.UD(doc.ID), unmarshalErr))
+			return pkgerrors.WithStack(base.RedactErrorf("Failed to UnmarshalWithXattr() doc with id: %s (DocUnmarshalRev).  Error: %v", base.UDDocID(doc.ID), unmarshalErr))
 		}
 		doc.syncData = syncData{
 			CurrentRev: revOnlyMeta.CurrentRev,
@@ -774,7 +808,7 @@ func (doc *document) UnmarshalWithXattr(data []byte, xdata []byte, unmarshalLeve
 		var casOnlyMeta casOnlySyncData
 		unmarshalErr := json.Unmarshal(xdata, &casOnlyMeta)
 		if unmarshalErr != nil {
-			return pkgerrors.WithStack(base.RedactErrorf("Failed to UnmarshalWithXattr() doc with id: %s (DocUnmarshalCAS).  Error: %v", base.UD(doc.ID), unmarshalErr))
+			return pkgerrors.WithStack(base.RedactErrorf("Failed to UnmarshalWithXattr() doc with id: %s (DocUnmarshalCAS).  Error: %v", base.UDDocID(doc.ID), unmarshalErr))
 		}
 		doc.syncData = syncData{
 			Cas: casOnlyMeta.Cas,
@@ -799,14 +833,17 @@ func (doc *document) MarshalWithXattr() (data []byte, xdata []byte, err error) {
 		if !deleted {
 			data, err = json.Marshal(body)
 			if err != nil {
-				return nil, nil, pkgerrors.WithStack(base.RedactErrorf("Failed to MarshalWithXattr() doc body with id: %s.  Error: %v", base.UD(doc.ID), err))
+				return nil, nil, pkgerrors.WithStack(base.RedactErrorf("Failed to MarshalWithXattr() doc body with id: %s.  Error: %v", base.UDDocID(doc.ID), err))
 			}
 		}
+	} else if len(doc.rawBody) > 0 {
+		// The body was never unmarshalled, so it's unchanged
+		data = doc.rawBody
 	}
 
 	xdata, err = json.Marshal(doc.syncData)
 	if err != nil {
-		return nil, nil, pkgerrors.WithStack(base.RedactErrorf("Failed to MarshalWithXattr() doc syncData with id: %s.  Error: %v", base.UD(doc.ID), err))
+		return nil, nil, pkgerrors.WithStack(base.RedactErrorf("Failed to MarshalWithXattr() doc syncData with id: %s.  Error: %v", base.UDDocID(doc.ID), err))
 	}
 
 	return data, xdata, nil
@@ -0,0 +1,214 @@
+package db
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbase/sync_gateway/channels"
+)
+
+// The sync metadata of a document, in a form that's easier to read than the raw _sync property, for debugging sync
+// issues.  Returned by GET /{db}/_raw/{docid}?include_sync=true.
+type DocumentInspection struct {
+	ID              string                        `json:"id"`
+	Cas             uint64                        `json:"cas"`
+	CurrentRev      string                        `json:"rev"`
+	NewestRev       string                        `json:"new_rev,omitempty"`
+	Sequence        uint64                        `json:"sequence"`
+	UnusedSequences []uint64                      `json:"unused_sequences,omitempty"`
+	RecentSequences []uint64                      `json:"recent_sequences,omitempty"`
+	Flags           []string                      `json:"flags,omitempty"`
+	RevTree         []RevisionInspection          `json:"rev_tree"`
+	Channels        map[string]*ChannelInspection `json:"channels,omitempty"`
+	Access          UserAccessMap                 `json:"access,omitempty"`
+	RoleAccess      UserAccessMap                 `json:"role_access,omitempty"`
+	Xattr           json.RawMessage               `json:"xattr,omitempty"`        // The raw _sync xattr, when xattrs are in use
+	IndexBlocks     []IndexBlockReference         `json:"index_blocks,omitempty"` // Only found when using a channel index
+	Body            Body                          `json:"body,omitempty"`
+}
+
+// A revision in a document's rev tree.
+type RevisionInspection struct {
+	RevID    string   `json:"rev"`
+	Parent   string   `json:"parent,omitempty"`
+	Deleted  bool     `json:"deleted,omitempty"`
+	Leaf     bool     `json:"leaf,omitempty"`
+	HasBody  bool     `json:"has_body,omitempty"` // Whether the revision's body is still stored, inline or externally
+	BodyKey  string   `json:"body_key,omitempty"` // The key of an externally stored body
+	Channels []string `json:"channels,omitempty"`
+}
+
+// A document's history in one channel.
+type ChannelInspection struct {
+	Active    bool     `json:"active"`                // Whether the current revision is in the channel
+	RemovedAt uint64   `json:"removed_seq,omitempty"` // The sequence the document was removed from the channel at
+	RemovedBy string   `json:"removed_rev,omitempty"` // The revision that removed the document from the channel
+	Deletion  bool     `json:"removed_by_deletion,omitempty"`
+	Revisions []string `json:"revs,omitempty"` // The revisions in the rev tree that are in the channel
+}
+
+// A channel index block that has an entry for a document.
+type IndexBlockReference struct {
+	Channel   string `json:"channel"`
+	Partition uint16 `json:"partition"`
+	BlockKey  string `json:"block"`
+	Sequence  uint64 `json:"seq"`
+}
+
+// Names of the document flag bits, in bit order
+var documentFlagNames = []struct {
+	flag uint8
+	name string
+}{
+	{channels.Deleted, "deleted"},
+	{channels.Removed, "removed"},
+	{channels.Hidden, "hidden"},
+	{channels.Conflict, "conflict"},
+	{channels.Branched, "branched"},
+	{channels.Added, "added"},
+}
+
+// Returns the sync metadata of a document, along with the channel index blocks that reference it.
+func (db *Database) InspectDocument(docid string) (*DocumentInspection, error) {
+	doc, err := db.GetDocument(docid, DocUnmarshalAll)
+	if err != nil {
+		return nil, err
+	}
+
+	inspection := &DocumentInspection{
+		ID:              docid,
+		Cas:             doc.Cas,
+		CurrentRev:      doc.CurrentRev,
+		NewestRev:       doc.NewestRev,
+		Sequence:        doc.Sequence,
+		UnusedSequences: doc.UnusedSequences,
+		RecentSequences: doc.RecentSequences,
+		Flags:           documentFlags(doc.Flags),
+		RevTree:         inspectRevTree(doc.History),
+		Channels:        make(map[string]*ChannelInspection, len(doc.Channels)),
+		Access:          doc.Access,
+		RoleAccess:      doc.RoleAccess,
+		Body:            doc.Body(),
+	}
+
+	for channelName, removal := range doc.Channels {
+		channelInspection := &ChannelInspection{Active: removal == nil}
+		if removal != nil {
+			channelInspection.RemovedAt = removal.Seq
+			channelInspection.RemovedBy = removal.RevID
+			channelInspection.Deletion = removal.Deleted
+		}
+		inspection.Channels[channelName] = channelInspection
+	}
+	for _, rev := range inspection.RevTree {
+		for _, channelName := range rev.Channels {
+			if channelInspection, ok := inspection.Channels[channelName]; ok {
+				channelInspection.Revisions = append(channelInspection.Revisions, rev.RevID)
+			}
+		}
+	}
+
+	if db.UseXattrs() {
+		var rawBody, rawXattr []byte
+		if _, err := db.Bucket.GetWithXattr(docid, KSyncXattrName, &rawBody, &rawXattr); err != nil {
+			base.Warnf(base.KeyAll, "Unable to get the sync xattr of %s: %v", base.UD(docid), err)
+		} else if len(rawXattr) > 0 {
+			inspection.Xattr = rawXattr
+		}
+	}
+
+	if changeIndex, ok := db.changeCache.(*kvChangeIndex); ok {
+		channelNames := make([]string, 0, len(doc.Channels)+1)
+		for channelName := range doc.Channels {
+			channelNames = append(channelNames, channelName)
+		}
+		if EnableStarChannelLog {
+			channelNames = append(channelNames, channels.UserStarChannel)
+		}
+		sort.Strings(channelNames)
+		inspection.IndexBlocks, err = changeIndex.findIndexBlocks(docid, channelNames)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return inspection, nil
+}
+
+// Returns the names of the flags that are set.
+func documentFlags(flags uint8) []string {
+	var names []string
+	for _, f := range documentFlagNames {
+		if flags&f.flag != 0 {
+			names = append(names, f.name)
+		}
+	}
+	return names
+}
+
+// Returns the revisions of a rev tree, in order of generation.
+func inspectRevTree(tree RevTree) []RevisionInspection {
+	revs := make([]RevisionInspection, 0, len(tree))
+	for revID, info := range tree {
+		rev := RevisionInspection{
+			RevID:   revID,
+			Parent:  info.Parent,
+			Deleted: info.Deleted,
+			Leaf:    tree.isLeaf(revID),
+			HasBody: len(info.Body) > 0 || info.BodyKey != "",
+			BodyKey: info.BodyKey,
+		}
+		if info.Channels != nil {
+			rev.Channels = info.Channels.ToArray()
+			sort.Strings(rev.Channels)
+		}
+		revs = append(revs, rev)
+	}
+	sort.Slice(revs, func(i, j int) bool {
+		genI, genJ := genOfRevID(revs[i].RevID), genOfRevID(revs[j].RevID)
+		if genI != genJ {
+			return genI < genJ
+		}
+		return revs[i].RevID < revs[j].RevID
+	})
+	return revs
+}
+
+// Returns the blocks of the channel index that have an entry for the document, in the given channels.
+func (k *kvChangeIndex) findIndexBlocks(docID string, channelNames []string) ([]IndexBlockReference, error) {
+	partitions, err := k.getIndexPartitions()
+	if err != nil {
+		return nil, err
+	}
+	vbNo := uint16(base.VBHash(docID, int(k.reader.maxVbNo)))
+	partition := partitions.PartitionForVb(vbNo)
+
+	var refs []IndexBlockReference
+	for _, channelName := range channelNames {
+		list, err := loadExistingDenseBlockList(channelName, partition, k.reader.denseStore)
+		if err != nil {
+			return nil, err
+		}
+		if list == nil {
+			continue
+		}
+		for list.validFromCounter > 0 {
+			if err := list.LoadPrevious(); err != nil {
+				base.Warnf(base.KeyAll, "Unable to load rotated block list for channel %s partition %d: %v", base.UDChannel(channelName), partition, err)
+				break
+			}
+		}
+		for _, listEntry := range list.blocks {
+			block := list.LoadBlock(listEntry)
+			if indexPos, _, _, seq := block.findEntryByKey(vbNo, []byte(docID)); indexPos > 0 {
+				refs = append(refs, IndexBlockReference{
+					Channel:   channelName,
+					Partition: partition,
+					BlockKey:  block.Key,
+					Sequence:  seq,
+				})
+			}
+		}
+	}
+	return refs, nil
+}
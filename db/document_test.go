@@ -231,3 +231,33 @@ func TestParseDocumentCas(t *testing.T) {
 
 	goassert.Equals(t, casInt, uint64(1492749160563736576))
 }
+
+func TestRawBodyPreserved(t *testing.T) {
+	rawBody := []byte(`{"value": "ABC", "count":12345678901234567890}`)
+	rawXattr := []byte(`{"rev":"1-a","sequence":1,"history":{"revs":["1-a"],"parents":[-1],"channels":[null]}}`)
+	doc, err := unmarshalDocumentWithXattr("doc1", rawBody, rawXattr, 1, DocUnmarshalSync)
+	assert.NoError(t, err)
+
+	// The raw body is passed on as-is, without being unmarshalled
+	bodyJSON := doc.getRevisionBodyJSON("1-a", nil)
+	assert.Equal(t, string(rawBody), string(bodyJSON))
+	assert.True(t, doc.hasBody())
+	assert.Nil(t, doc._body)
+	data, _, err := doc.MarshalWithXattr()
+	assert.NoError(t, err)
+	assert.Equal(t, string(rawBody), string(data))
+
+	// It's unmarshalled on demand
+	assert.Equal(t, "ABC", doc.Body()["value"])
+	assert.Equal(t, json.Number("12345678901234567890"), doc.Body()["count"])
+
+	// A new current revision replaces the raw body
+	doc, err = unmarshalDocumentWithXattr("doc1", rawBody, rawXattr, 1, DocUnmarshalSync)
+	assert.NoError(t, err)
+	assert.NoError(t, doc.History.addRevision("doc1", RevInfo{ID: "2-b", Parent: "1-a"}))
+	doc.CurrentRev = "2-b"
+	doc.setRevisionBody("2-b", Body{"value": "DEF"}, true)
+	bodyJSON, err = doc.MarshalBody()
+	assert.NoError(t, err)
+	assert.Equal(t, `{"value":"DEF"}`, string(bodyJSON))
+}
@@ -17,6 +17,9 @@ const (
 	DocumentChange EventType = iota
 	DBStateChange
 	UserAdd
+	PrincipalChange
+	ReplicationChange
+	DBLifecycleChange
 )
 
 // An event that can be raised during SG processing.
@@ -69,6 +72,54 @@ func (dsce *DBStateChangeEvent) EventType() EventType {
 	return DBStateChange
 }
 
+// PrincipalChangeEvent is raised when a user or role is created, updated or deleted, or when a
+// document's grants to it change.  Event has the name and type of the principal, how it changed,
+// and the channels and roles it gained and lost.
+type PrincipalChangeEvent struct {
+	AsyncEvent
+	Doc Body
+}
+
+func (pce *PrincipalChangeEvent) String() string {
+	return fmt.Sprintf("Principal change event for %s: %s", pce.Doc["type"], pce.Doc["name"])
+}
+
+func (pce *PrincipalChangeEvent) EventType() EventType {
+	return PrincipalChange
+}
+
+// ReplicationEvent is raised when a BLIP replication starts, stops or fails, or when a one-shot
+// pull completes.  Event has the replication's state, user and client, and the number of docs
+// transferred.
+type ReplicationEvent struct {
+	AsyncEvent
+	Doc Body
+}
+
+func (re *ReplicationEvent) String() string {
+	return fmt.Sprintf("Replication event for %s: %s", re.Doc["replication_id"], re.Doc["state"])
+}
+
+func (re *ReplicationEvent) EventType() EventType {
+	return ReplicationChange
+}
+
+// DBLifecycleEvent is raised when a DB comes online or goes offline, and when a resync or compaction
+// starts or finishes.  Event has the name of the DB, the transition, and for the finished transitions
+// how long the operation took, what it changed and any error.
+type DBLifecycleEvent struct {
+	AsyncEvent
+	Doc Body
+}
+
+func (dle *DBLifecycleEvent) String() string {
+	return fmt.Sprintf("DB lifecycle event for db name: %s: %s", dle.Doc["dbname"], dle.Doc["event"])
+}
+
+func (dle *DBLifecycleEvent) EventType() EventType {
+	return DBLifecycleChange
+}
+
 // Javascript function handling for events
 const kTaskCacheSize = 4
 
@@ -147,6 +198,12 @@ func (ef *JSEventFunction) CallFunction(event Event) (interface{}, error) {
 		result, err = ef.Call(event.Doc, sgbucket.JSONString(event.OldDoc))
 	case *DBStateChangeEvent:
 		result, err = ef.Call(event.Doc)
+	case *PrincipalChangeEvent:
+		result, err = ef.Call(event.Doc)
+	case *ReplicationEvent:
+		result, err = ef.Call(event.Doc)
+	case *DBLifecycleEvent:
+		result, err = ef.Call(event.Doc)
 	}
 
 	if err != nil {
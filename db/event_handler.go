@@ -2,12 +2,19 @@ package db
 
 import (
 	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"expvar"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
 	"time"
 
 	"github.com/couchbase/sync_gateway/base"
@@ -24,17 +31,68 @@ type AsyncEventHandler struct{}
 // Webhook is an implementation of EventHandler that sends an asynchronous HTTP POST
 type Webhook struct {
 	AsyncEventHandler
-	url     string
-	filter  *JSEventFunction
-	timeout time.Duration
-	client  *http.Client
+	url             string
+	filter          *JSEventFunction
+	transform       *JSEventFunction
+	template        *template.Template
+	contentType     string
+	channels        base.Set
+	timeout         time.Duration
+	client          *http.Client
+	maxRetries      uint
+	retryBackoff    time.Duration
+	maxRetryBackoff time.Duration
+	deadLetterUrl   string
+	signingSecrets  [][]byte
+	stats           *expvar.Map
+}
+
+// Optional webhook settings
+type WebhookOptions struct {
+	Filter          string        // JS function that returns whether an event should be posted
+	Transform       string        // JS function that returns the payload to post for an event, or null to not post it
+	Template        string        // Go text/template that generates the payload to post for an event, or nothing to not post it
+	ContentType     string        // Content type of payloads generated by Transform or Template.  Defaults to JSON
+	Channels        []string      // If set, only changes to documents in one of these channels are posted
+	Timeout         *uint64       // HTTP post timeout (seconds)
+	MaxRetries      uint          // Max # of times a failed post is retried
+	RetryBackoff    time.Duration // Wait before the first retry, doubled for each retry after that
+	MaxRetryBackoff time.Duration // Max wait between retries
+	DeadLetterUrl   string        // If set, events that couldn't be posted are posted here instead, with the error
+	ClientCertPath  string        // Client certificate to authenticate to receivers with, for mutual TLS
+	ClientKeyPath   string        // Private key of the client certificate
+	CACertPath      string        // If set, receivers' certificates must be signed by this CA instead of one the system trusts
+	SigningSecrets  []string      // If set, posts are signed with each of these HMAC secrets, so receivers can rotate them
+	Stats           *expvar.Map   // If set, delivery stats are added to this map
 }
 
 // default HTTP post timeout
 const kDefaultWebhookTimeout = 60
 
+// default content type of transformed and templated payloads
+const kDefaultWebhookContentType = "application/json"
+
+// Headers of webhook posts signed with SigningSecrets.  The signature header has a comma-separated signature for each
+// secret, formatted as "sha256=<hex HMAC-SHA256 of the timestamp header's value, '.' and the body>".  Including the
+// timestamp lets receivers reject replayed posts.
+const (
+	WebhookTimestampHeader = "X-Sync-Gateway-Timestamp"
+	WebhookSignatureHeader = "X-Sync-Gateway-Signature"
+)
+
+// default waits between retries of failed posts
+const (
+	kDefaultWebhookRetryBackoff    = 1 * time.Second
+	kDefaultWebhookMaxRetryBackoff = 60 * time.Second
+)
+
 // Creates a new webhook handler based on the url and filter function.
 func NewWebhook(url string, filterFnString string, timeout *uint64) (*Webhook, error) {
+	return NewWebhookWithOptions(url, WebhookOptions{Filter: filterFnString, Timeout: timeout})
+}
+
+// Creates a new webhook handler based on the url and options.
+func NewWebhookWithOptions(url string, options WebhookOptions) (*Webhook, error) {
 
 	var err error
 
@@ -44,34 +102,95 @@ func NewWebhook(url string, filterFnString string, timeout *uint64) (*Webhook, e
 	}
 
 	wh := &Webhook{
-		url: url,
+		url:             url,
+		maxRetries:      options.MaxRetries,
+		retryBackoff:    options.RetryBackoff,
+		maxRetryBackoff: options.MaxRetryBackoff,
+		deadLetterUrl:   options.DeadLetterUrl,
+		stats:           options.Stats,
+	}
+	if options.Filter != "" {
+		wh.filter = NewJSEventFunction(options.Filter)
+	}
+	if options.Transform != "" && options.Template != "" {
+		return nil, errors.New("transform and payload_template can't both be defined for webhook events.")
 	}
-	if filterFnString != "" {
-		wh.filter = NewJSEventFunction(filterFnString)
+	if options.Transform != "" {
+		wh.transform = NewJSEventFunction(options.Transform)
+	}
+	if options.Template != "" {
+		wh.template, err = template.New("payload").Funcs(webhookTemplateFuncs).Parse(options.Template)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid payload_template for webhook: %v", err)
+		}
+	}
+	wh.contentType = options.ContentType
+	if wh.contentType == "" {
+		wh.contentType = kDefaultWebhookContentType
+	}
+	if len(options.Channels) > 0 {
+		wh.channels = base.SetFromArray(options.Channels)
 	}
 
-	if timeout != nil {
-		wh.timeout = time.Duration(*timeout) * time.Second
+	if options.Timeout != nil {
+		wh.timeout = time.Duration(*options.Timeout) * time.Second
 	} else {
 		wh.timeout = time.Duration(kDefaultWebhookTimeout) * time.Second
 	}
 
+	if wh.retryBackoff <= 0 {
+		wh.retryBackoff = kDefaultWebhookRetryBackoff
+	}
+	if wh.maxRetryBackoff <= 0 {
+		wh.maxRetryBackoff = kDefaultWebhookMaxRetryBackoff
+	}
+
+	for _, secret := range options.SigningSecrets {
+		wh.signingSecrets = append(wh.signingSecrets, []byte(secret))
+	}
+
 	// Initialize transport and client
 	t := http.DefaultTransport.(*http.Transport)
 	transport := *t
 	transport.DisableKeepAlives = false
+	if (options.ClientCertPath == "") != (options.ClientKeyPath == "") {
+		return nil, errors.New("client_cert_path and client_key_path must both be defined for webhook mutual TLS.")
+	}
+	if options.ClientCertPath != "" || options.CACertPath != "" {
+		tlsConfig, err := base.TLSConfigForX509(options.ClientCertPath, options.ClientKeyPath, options.CACertPath)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to load TLS certificates for webhook: %v", err)
+		}
+		// Without a CA cert, verify receivers against the CAs the system trusts, as usual
+		tlsConfig.InsecureSkipVerify = false
+		transport.TLSClientConfig = tlsConfig
+	}
 	wh.client = &http.Client{Transport: &transport, Timeout: wh.timeout}
 
 	return wh, err
 }
 
-// Performs an HTTP POST to the url defined for the handler.  If a filter function is defined,
-// calls it to determine whether to POST.  The payload for the POST is depends
-// on the event type.
+// Performs an HTTP POST to the url defined for the handler.  If channels or a filter function are
+// defined, uses them to determine whether to POST.  The payload for the POST is depends
+// on the event type.  Failed posts are retried up to maxRetries times, and then sent to the
+// dead letter url, if any.
 func (wh *Webhook) HandleEvent(event Event) {
+	wh.handleEventWithWait(event, time.Sleep)
+}
+
+// Handles the event like HandleEvent, calling wait to wait between retries.
+func (wh *Webhook) handleEventWithWait(event Event, wait func(time.Duration)) {
 
-	var payload *bytes.Buffer
+	var payload []byte
 	var contentType string
+	if wh.channels != nil {
+		// If channels are defined, only post changes to documents in those channels
+		if event, ok := event.(*DocumentChangeEvent); ok && !wh.inChannels(event.Channels) {
+			wh.addStat(base.StatKeyWebhookFilteredCount)
+			return
+		}
+	}
+
 	if wh.filter != nil {
 		// If filter function is defined, use it to determine whether to post
 		success, err := wh.filter.CallValidateFunction(event)
@@ -81,21 +200,70 @@ func (wh *Webhook) HandleEvent(event Event) {
 
 		// If filter returns false, cancel webhook post
 		if !success {
+			wh.addStat(base.StatKeyWebhookFilteredCount)
 			return
 		}
 	}
 
-	// Different events post different content by default
-	switch event := event.(type) {
-	case *DocumentChangeEvent:
-		// for DocumentChangeEvent, post document body
-		jsonOut, err := json.Marshal(event.Doc)
+	if wh.transform != nil || wh.template != nil {
+		// A transform function or payload template defines the payload for every event type
+		var err error
+		payload, err = wh.templatedPayload(event)
 		if err != nil {
-			base.Warnf(base.KeyAll, "Error marshalling doc for webhook post: %v", err)
+			base.Warnf(base.KeyAll, "Error generating webhook payload for %s: %v", base.UD(event.String()), err)
+			wh.addStat(base.StatKeyWebhookFailedCount)
+			return
+		}
+		if payload == nil {
+			// Transform function returned null, cancel webhook post
+			wh.addStat(base.StatKeyWebhookFilteredCount)
+			return
+		}
+		contentType = wh.contentType
+	} else {
+		var err error
+		if payload, err = defaultWebhookPayload(event); err != nil {
+			base.Warnf(base.KeyAll, "Error generating webhook payload for %s: %v", base.UD(event.String()), err)
 			return
 		}
 		contentType = "application/json"
-		payload = bytes.NewBuffer(jsonOut)
+	}
+
+	backoff := wh.retryBackoff
+	attempts := uint(0)
+	for {
+		attempts++
+		retryable, err := wh.post(wh.url, contentType, payload)
+		if err == nil {
+			wh.addStat(base.StatKeyWebhookDeliveredCount)
+			return
+		}
+
+		if !retryable || attempts > wh.maxRetries {
+			base.Warnf(base.KeyAll, "Error attempting to post %s to url %s: %s", base.UD(event.String()), base.UD(wh.SanitizedUrl()), err)
+			wh.addStat(base.StatKeyWebhookFailedCount)
+			wh.deadLetter(event, payload, attempts, err)
+			return
+		}
+
+		base.Infof(base.KeyEvents, "Error attempting to post %s to url %s, retrying in %v: %s", base.UD(event.String()), base.UD(wh.SanitizedUrl()), backoff, err)
+		wh.addStat(base.StatKeyWebhookRetryCount)
+		wait(backoff)
+		backoff *= 2
+		if backoff > wh.maxRetryBackoff {
+			backoff = wh.maxRetryBackoff
+		}
+	}
+}
+
+// Returns the payload posted for an event when there's no transform function or payload template, which is the
+// event's document as JSON.
+func defaultWebhookPayload(event Event) ([]byte, error) {
+	var doc Body
+	switch event := event.(type) {
+	case *DocumentChangeEvent:
+		// for DocumentChangeEvent, post document body
+		doc = event.Doc
 	case *DBStateChangeEvent:
 		// for DBStateChangeEvent, post JSON document with the following format
 		//{
@@ -105,39 +273,185 @@ func (wh *Webhook) HandleEvent(event Event) {
 		//	"reason":"DB started from config”,
 		//	“state”:"online"
 		//}
-		jsonOut, err := json.Marshal(event.Doc)
-		if err != nil {
-			base.Warnf(base.KeyAll, "Error marshalling doc for webhook post")
-			return
-		}
-		contentType = "application/json"
-		payload = bytes.NewBuffer(jsonOut)
+		doc = event.Doc
+	case *PrincipalChangeEvent:
+		// for PrincipalChangeEvent, post JSON document with the following format
+		//{
+		//	"name":"alice",
+		//	"type":"user",
+		//	"change":"grant_changed",
+		//	"doc_id":"team1",
+		//	"channels_added":["team1"],
+		//	"channels_removed":[],
+		//	"roles_added":[],
+		//	"roles_removed":[]
+		//}
+		doc = event.Doc
+	case *ReplicationEvent:
+		// for ReplicationEvent, post JSON document with the following format
+		//{
+		//	"state":"completed",
+		//	"replication_id":"c3a1b6e5d0f7a2e4",
+		//	"user":"alice",
+		//	"client_id":"cp-7HqZ0eL3CR8aylWaRFXhx8/V/Yw=",
+		//	"user_agent":"CouchbaseLite/2.5.0 (Java; Android 8.0.0)",
+		//	"docs_sent":12,
+		//	"docs_received":3,
+		//	"localtime":"2019-03-07T11:20:29.138+01:00"
+		//}
+		doc = event.Doc
+	case *DBLifecycleEvent:
+		// for DBLifecycleEvent, post JSON document with the following format
+		//{
+		//	"dbname":"db",
+		//	"event":"resync_finished",
+		//	"docs_changed":42,
+		//	"duration_ms":1250,
+		//	"localtime":"2019-03-07T11:20:29.138+01:00"
+		//}
+		doc = event.Doc
 	default:
-		base.Warnf(base.KeyAll, "Webhook invoked for unsupported event type.")
-		return
+		return nil, fmt.Errorf("webhook invoked for unsupported event type %T", event)
 	}
-	func() {
-		resp, err := wh.client.Post(wh.url, contentType, payload)
-		defer func() {
-			// Ensure we're closing the response, so it can be reused
-			if resp != nil && resp.Body != nil {
-				io.Copy(ioutil.Discard, resp.Body)
-				resp.Body.Close()
-			}
-		}()
+	return json.Marshal(doc)
+}
 
-		if err != nil {
-			base.Warnf(base.KeyAll, "Error attempting to post %s to url %s: %s", base.UD(event.String()), base.UD(wh.SanitizedUrl()), err)
-			return
+// Functions available to payload templates, in addition to text/template's builtins
+var webhookTemplateFuncs = template.FuncMap{
+	// Returns a value as JSON, e.g. {{json .Doc.tags}}
+	"json": func(value interface{}) (string, error) {
+		jsonOut, err := json.Marshal(value)
+		return string(jsonOut), err
+	},
+}
+
+// Returns the payload generated for an event by the webhook's transform function or payload template.  The
+// template is executed with the event as its data, so the document body is {{.Doc}}.  A transform function is
+// called with the same arguments as a filter function, and may return a string, which is posted as-is, or an
+// object, which is posted as JSON.  Returns nil if the transform function returns null or undefined, or the
+// template generates nothing.
+func (wh *Webhook) templatedPayload(event Event) ([]byte, error) {
+	if wh.template != nil {
+		var payload bytes.Buffer
+		if err := wh.template.Execute(&payload, event); err != nil {
+			return nil, err
 		}
+		return payload.Bytes(), nil
+	}
 
-		// Check Log Level first, as SanitizedUrl is expensive to evaluate.
-		if base.LogDebugEnabled(base.KeyEvents) {
-			base.Debugf(base.KeyEvents, "Webhook handler ran for event.  Payload %s posted to URL %s, got status %s",
-				base.UD(payload), base.UD(wh.SanitizedUrl()), resp.Status)
+	result, err := wh.transform.CallFunction(event)
+	if err != nil {
+		return nil, err
+	}
+	switch result := result.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []byte(result), nil
+	default:
+		return json.Marshal(result)
+	}
+}
+
+// Posts the payload to url.  Returns an error if the post fails or gets a non-2xx response, and whether it's worth
+// retrying: connection errors, timeouts, 429 and 5xx responses are assumed to be temporary.
+func (wh *Webhook) post(url string, contentType string, payload []byte) (retryable bool, err error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if len(wh.signingSecrets) > 0 {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(WebhookTimestampHeader, timestamp)
+		req.Header.Set(WebhookSignatureHeader, wh.signature(timestamp, payload))
+	}
+
+	resp, err := wh.client.Do(req)
+	defer func() {
+		// Ensure we're closing the response, so it can be reused
+		if resp != nil && resp.Body != nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
 		}
 	}()
 
+	if err != nil {
+		return true, err
+	}
+
+	// Check Log Level first, as SanitizedUrl is expensive to evaluate.
+	if base.LogDebugEnabled(base.KeyEvents) {
+		base.Debugf(base.KeyEvents, "Webhook handler ran for event.  Payload %s posted to URL %s, got status %s",
+			base.UD(string(payload)), base.UD(base.RedactBasicAuthURL(url)), resp.Status)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		retryable = resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		return retryable, fmt.Errorf("Unexpected response status %s", resp.Status)
+	}
+	return false, nil
+}
+
+// Returns the value of the signature header for a post of payload at timestamp.
+func (wh *Webhook) signature(timestamp string, payload []byte) string {
+	signatures := make([]string, 0, len(wh.signingSecrets))
+	for _, secret := range wh.signingSecrets {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(timestamp))
+		mac.Write([]byte("."))
+		mac.Write(payload)
+		signatures = append(signatures, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	return strings.Join(signatures, ",")
+}
+
+// The body posted to the dead letter url for an event that couldn't be posted.
+type webhookDeadLetter struct {
+	Url      string          `json:"url"`      // The webhook's url, with any credentials redacted
+	Event    string          `json:"event"`    // Description of the event
+	Error    string          `json:"error"`    // Why the last attempt to post the event failed
+	Attempts uint            `json:"attempts"` // # of times posting the event was attempted
+	Payload  json.RawMessage `json:"payload"`  // The body that couldn't be posted
+}
+
+// Posts an event that couldn't be posted to the dead letter url, if there is one.  Isn't retried.
+func (wh *Webhook) deadLetter(event Event, payload []byte, attempts uint, postErr error) {
+	if wh.deadLetterUrl == "" {
+		return
+	}
+	deadLetter, err := json.Marshal(webhookDeadLetter{
+		Url:      wh.SanitizedUrl(),
+		Event:    event.String(),
+		Error:    postErr.Error(),
+		Attempts: attempts,
+		Payload:  payload,
+	})
+	if err != nil {
+		base.Warnf(base.KeyAll, "Error marshalling dead letter for webhook post: %v", err)
+		return
+	}
+	if _, err := wh.post(wh.deadLetterUrl, "application/json", deadLetter); err != nil {
+		base.Warnf(base.KeyAll, "Error attempting to post %s to dead letter url %s - event has been dropped: %s", base.UD(event.String()), base.UD(base.RedactBasicAuthURL(wh.deadLetterUrl)), err)
+		return
+	}
+	wh.addStat(base.StatKeyWebhookDeadLetterCount)
+}
+
+// Returns true if any of the document's channels are one of the webhook's channels.
+func (wh *Webhook) inChannels(docChannels base.Set) bool {
+	for channel := range docChannels {
+		if wh.channels.Contains(channel) {
+			return true
+		}
+	}
+	return false
+}
+
+func (wh *Webhook) addStat(key string) {
+	if wh.stats != nil {
+		wh.stats.Add(key, 1)
+	}
 }
 
 func (wh *Webhook) String() string {
@@ -0,0 +1,97 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/streadway/amqp"
+)
+
+// Publishes event payloads to an AMQP exchange.  The AMQP client doesn't reconnect by itself, so if the connection
+// has been lost, publishing reconnects and tries again once.
+type amqpPublisher struct {
+	url        string
+	exchange   string
+	routingKey string
+	lock       sync.Mutex // Protects conn and channel
+	conn       *amqp.Connection
+	channel    *amqp.Channel
+}
+
+// Creates a message sink that publishes events to an exchange on the AMQP broker at url, with the given routing key.
+// An empty exchange is the broker's default exchange, which routes messages to the queue named by the routing key.
+func NewAMQPSink(url string, exchange string, routingKey string, options MessageSinkOptions) (*MessageSink, error) {
+	if url == "" {
+		return nil, errors.New("url parameter must be defined for amqp events.")
+	}
+	if exchange == "" && routingKey == "" {
+		return nil, errors.New("exchange or routing_key parameter must be defined for amqp events.")
+	}
+
+	p := &amqpPublisher{url: url, exchange: exchange, routingKey: routingKey}
+	if err := p._connect(); err != nil {
+		return nil, err
+	}
+	return NewMessageSink(p, options), nil
+}
+
+// Requires the lock.
+func (p *amqpPublisher) _connect() error {
+	conn, err := amqp.Dial(p.url)
+	if err != nil {
+		return fmt.Errorf("Unable to connect to amqp broker %s: %v", base.RedactBasicAuthURL(p.url), err)
+	}
+	channel, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("Unable to open channel to amqp broker %s: %v", base.RedactBasicAuthURL(p.url), err)
+	}
+	p.conn, p.channel = conn, channel
+	return nil
+}
+
+// Requires the lock.
+func (p *amqpPublisher) _close() {
+	if p.conn != nil {
+		p.conn.Close()
+	}
+	p.conn, p.channel = nil, nil
+}
+
+func (p *amqpPublisher) Publish(payload []byte) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	msg := amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		Body:         payload,
+	}
+	var err error
+	if p.channel != nil {
+		if err = p.channel.Publish(p.exchange, p.routingKey, false, false, msg); err != amqp.ErrClosed {
+			return err
+		}
+	}
+
+	// The connection's been lost, so reconnect and try again
+	base.Infof(base.KeyEvents, "Reconnecting to amqp broker %s", base.UD(base.RedactBasicAuthURL(p.url)))
+	p._close()
+	if err = p._connect(); err != nil {
+		return err
+	}
+	return p.channel.Publish(p.exchange, p.routingKey, false, false, msg)
+}
+
+func (p *amqpPublisher) Close() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p._close()
+	return nil
+}
+
+func (p *amqpPublisher) String() string {
+	return fmt.Sprintf("amqp %s/%s/%s", base.RedactBasicAuthURL(p.url), p.exchange, p.routingKey)
+}
@@ -0,0 +1,204 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/couchbase/sync_gateway/base"
+)
+
+// How a Kafka sink assigns document changes to partitions.  Kafka only orders messages within a partition, and
+// partitions messages by key, so the key determines which changes consumers see in order.
+const (
+	KafkaPartitionByDocID   = "doc_id"  // One message per change, keyed by doc ID
+	KafkaPartitionByChannel = "channel" // One message per channel the document is in, keyed by channel
+)
+
+// default time to wait for the brokers to acknowledge a message
+const kDefaultKafkaTimeout = 10
+
+// KafkaProducer publishes messages to Kafka.  It's implemented by sarama.SyncProducer.
+type KafkaProducer interface {
+	SendMessages(msgs []*sarama.ProducerMessage) error
+	Close() error
+}
+
+// KafkaSink is an implementation of EventHandler that publishes document changes to a Kafka topic, so that
+// they can be consumed without polling the changes feed.
+type KafkaSink struct {
+	AsyncEventHandler
+	brokers     []string
+	topic       string
+	partitionBy string
+	includeBody bool
+	channels    base.Set
+	producer    KafkaProducer
+	stats       *expvar.Map
+}
+
+// Optional Kafka sink settings
+type KafkaSinkOptions struct {
+	PartitionBy string        // KafkaPartitionByDocID (the default) or KafkaPartitionByChannel
+	IncludeBody bool          // Whether messages include the document body
+	Channels    []string      // If set, only changes to documents in one of these channels are published
+	Timeout     *uint64       // Time to wait for the brokers to acknowledge a message (seconds)
+	Stats       *expvar.Map   // If set, publishing stats are added to this map
+	Producer    KafkaProducer // If set, used instead of connecting to the brokers
+}
+
+// The message published for a document change.
+type kafkaDocChange struct {
+	DocID    string   `json:"doc_id"`
+	RevID    string   `json:"rev"`
+	Deleted  bool     `json:"deleted,omitempty"`
+	Channels []string `json:"channels"`       // All the channels the revision is in, sorted
+	Body     Body     `json:"body,omitempty"` // Only if the sink includes bodies
+}
+
+// Creates a new Kafka sink that publishes to the topic on the given brokers.
+func NewKafkaSink(brokers []string, topic string, options KafkaSinkOptions) (*KafkaSink, error) {
+
+	if len(brokers) == 0 {
+		return nil, errors.New("brokers parameter must be defined for kafka events.")
+	}
+	if topic == "" {
+		return nil, errors.New("topic parameter must be defined for kafka events.")
+	}
+
+	ks := &KafkaSink{
+		brokers:     brokers,
+		topic:       topic,
+		partitionBy: options.PartitionBy,
+		includeBody: options.IncludeBody,
+		producer:    options.Producer,
+		stats:       options.Stats,
+	}
+	switch ks.partitionBy {
+	case "":
+		ks.partitionBy = KafkaPartitionByDocID
+	case KafkaPartitionByDocID, KafkaPartitionByChannel:
+	default:
+		return nil, fmt.Errorf("partition_by must be %q or %q for kafka events, not %q", KafkaPartitionByDocID, KafkaPartitionByChannel, ks.partitionBy)
+	}
+	if len(options.Channels) > 0 {
+		ks.channels = base.SetFromArray(options.Channels)
+	}
+
+	if ks.producer == nil {
+		config := sarama.NewConfig()
+		config.ClientID = "sync_gateway"
+		config.Producer.RequiredAcks = sarama.WaitForAll
+		config.Producer.Return.Successes = true // Required by SyncProducer
+		config.Producer.Partitioner = sarama.NewHashPartitioner
+		if options.Timeout != nil {
+			config.Producer.Timeout = time.Duration(*options.Timeout) * time.Second
+		} else {
+			config.Producer.Timeout = time.Duration(kDefaultKafkaTimeout) * time.Second
+		}
+
+		producer, err := sarama.NewSyncProducer(brokers, config)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to connect to kafka brokers %v: %v", brokers, err)
+		}
+		ks.producer = producer
+	}
+
+	return ks, nil
+}
+
+// Publishes a document change to the sink's topic.  If channels are defined, only changes to documents in those
+// channels are published.  When partitioning by channel, a message is published for each of the document's channels
+// (or each of the sink's channels the document's in), so that consumers of a channel see all its changes in order.
+func (ks *KafkaSink) HandleEvent(event Event) {
+
+	dce, ok := event.(*DocumentChangeEvent)
+	if !ok {
+		base.Warnf(base.KeyAll, "Kafka sink invoked for unsupported event type.")
+		return
+	}
+
+	var channels []string
+	for channel := range dce.Channels {
+		if ks.channels == nil || ks.channels.Contains(channel) {
+			channels = append(channels, channel)
+		}
+	}
+	if ks.channels != nil && len(channels) == 0 {
+		ks.addStat(base.StatKeyKafkaFilteredCount, 1)
+		return
+	}
+	sort.Strings(channels)
+
+	docID, _ := dce.Doc[BodyId].(string)
+	revID, _ := dce.Doc[BodyRev].(string)
+	change := kafkaDocChange{
+		DocID:    docID,
+		RevID:    revID,
+		Channels: dce.Channels.ToArray(),
+	}
+	sort.Strings(change.Channels)
+	if deleted, _ := dce.Doc[BodyDeleted].(bool); deleted {
+		change.Deleted = true
+	}
+	if ks.includeBody {
+		change.Body = dce.Doc
+	}
+	value, err := json.Marshal(change)
+	if err != nil {
+		base.Warnf(base.KeyAll, "Error marshalling doc for kafka message: %v", err)
+		return
+	}
+
+	var msgs []*sarama.ProducerMessage
+	switch ks.partitionBy {
+	case KafkaPartitionByChannel:
+		for _, channel := range channels {
+			msgs = append(msgs, ks.message(channel, value))
+		}
+	default:
+		msgs = append(msgs, ks.message(docID, value))
+	}
+	if len(msgs) == 0 {
+		// Partitioning by channel, and the document isn't in any
+		return
+	}
+
+	err = ks.producer.SendMessages(msgs)
+	failed := 0
+	if producerErrs, ok := err.(sarama.ProducerErrors); ok {
+		failed = len(producerErrs)
+	} else if err != nil {
+		failed = len(msgs)
+	}
+	ks.addStat(base.StatKeyKafkaPublishedCount, len(msgs)-failed)
+	if err != nil {
+		base.Warnf(base.KeyAll, "Error publishing %d of %d kafka messages for %s to topic %s: %v", failed, len(msgs), base.UD(event.String()), base.MD(ks.topic), err)
+		ks.addStat(base.StatKeyKafkaFailedCount, failed)
+		return
+	}
+	base.Debugf(base.KeyEvents, "Kafka sink ran for event.  Published %d messages for doc %q / %q to topic %s", len(msgs), base.UD(docID), revID, base.MD(ks.topic))
+}
+
+func (ks *KafkaSink) message(key string, value []byte) *sarama.ProducerMessage {
+	return &sarama.ProducerMessage{
+		Topic: ks.topic,
+		Key:   sarama.StringEncoder(key),
+		Value: sarama.ByteEncoder(value),
+	}
+}
+
+func (ks *KafkaSink) addStat(key string, delta int) {
+	if ks.stats != nil && delta != 0 {
+		ks.stats.Add(key, int64(delta))
+	}
+}
+
+func (ks *KafkaSink) String() string {
+	return fmt.Sprintf("Kafka sink [%s/%s]", strings.Join(ks.brokers, ","), ks.topic)
+}
@@ -0,0 +1,122 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"expvar"
+	"sync"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/stretchr/testify/assert"
+)
+
+// A KafkaProducer that records the messages it's sent, instead of publishing them.
+type testKafkaProducer struct {
+	lock sync.Mutex
+	msgs []*sarama.ProducerMessage
+	err  error
+}
+
+func (p *testKafkaProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	p.msgs = append(p.msgs, msgs...)
+	return nil
+}
+
+func (p *testKafkaProducer) Close() error {
+	return nil
+}
+
+// Returns the keys and decoded values of the messages sent, and forgets them.
+func (p *testKafkaProducer) takeMessages(t *testing.T) (keys []string, changes []kafkaDocChange) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, msg := range p.msgs {
+		assert.Equal(t, "changes", msg.Topic)
+		key, _ := msg.Key.Encode()
+		value, _ := msg.Value.Encode()
+		var change kafkaDocChange
+		assert.NoError(t, json.Unmarshal(value, &change))
+		keys = append(keys, string(key))
+		changes = append(changes, change)
+	}
+	p.msgs = nil
+	return keys, changes
+}
+
+func TestKafkaSinkPartitioning(t *testing.T) {
+	docEvent := &DocumentChangeEvent{
+		Doc:      Body{BodyId: "doc1", BodyRev: "2-abc", "value": "foo"},
+		Channels: base.SetOf("ABC", "DEF"),
+	}
+
+	// By default, publishes one message keyed by doc ID, without the body
+	producer := &testKafkaProducer{}
+	ks, err := NewKafkaSink([]string{"localhost:9092"}, "changes", KafkaSinkOptions{Producer: producer})
+	assert.NoError(t, err)
+	ks.HandleEvent(docEvent)
+	keys, changes := producer.takeMessages(t)
+	assert.Equal(t, []string{"doc1"}, keys)
+	if assert.Len(t, changes, 1) {
+		assert.Equal(t, kafkaDocChange{DocID: "doc1", RevID: "2-abc", Channels: []string{"ABC", "DEF"}}, changes[0])
+	}
+
+	// Partitioned by channel, publishes a message keyed by each channel, with the body
+	ks, err = NewKafkaSink([]string{"localhost:9092"}, "changes", KafkaSinkOptions{
+		PartitionBy: KafkaPartitionByChannel,
+		IncludeBody: true,
+		Producer:    producer,
+	})
+	assert.NoError(t, err)
+	ks.HandleEvent(docEvent)
+	keys, changes = producer.takeMessages(t)
+	assert.Equal(t, []string{"ABC", "DEF"}, keys)
+	for _, change := range changes {
+		assert.Equal(t, "doc1", change.DocID)
+		assert.Equal(t, []string{"ABC", "DEF"}, change.Channels)
+		assert.Equal(t, "foo", change.Body["value"])
+	}
+
+	_, err = NewKafkaSink([]string{"localhost:9092"}, "changes", KafkaSinkOptions{PartitionBy: "rev", Producer: producer})
+	assert.Error(t, err)
+	_, err = NewKafkaSink(nil, "changes", KafkaSinkOptions{Producer: producer})
+	assert.Error(t, err)
+	_, err = NewKafkaSink([]string{"localhost:9092"}, "", KafkaSinkOptions{Producer: producer})
+	assert.Error(t, err)
+}
+
+func TestKafkaSinkChannelFilter(t *testing.T) {
+	stats := new(expvar.Map).Init()
+	producer := &testKafkaProducer{}
+	ks, err := NewKafkaSink([]string{"localhost:9092"}, "changes", KafkaSinkOptions{
+		PartitionBy: KafkaPartitionByChannel,
+		Channels:    []string{"ABC"},
+		Stats:       stats,
+		Producer:    producer,
+	})
+	assert.NoError(t, err)
+
+	// Only the sink's channels get a message, but the message lists all the doc's channels
+	ks.HandleEvent(&DocumentChangeEvent{Doc: Body{BodyId: "doc1", BodyRev: "1-abc"}, Channels: base.SetOf("ABC", "DEF")})
+	ks.HandleEvent(&DocumentChangeEvent{Doc: Body{BodyId: "doc2", BodyRev: "1-abc"}, Channels: base.SetOf("DEF")})
+	ks.HandleEvent(&DocumentChangeEvent{Doc: Body{BodyId: "doc3", BodyRev: "2-abc", BodyDeleted: true}, Channels: base.SetOf("ABC")})
+	keys, changes := producer.takeMessages(t)
+	assert.Equal(t, []string{"ABC", "ABC"}, keys)
+	if assert.Len(t, changes, 2) {
+		assert.Equal(t, kafkaDocChange{DocID: "doc1", RevID: "1-abc", Channels: []string{"ABC", "DEF"}}, changes[0])
+		assert.Equal(t, kafkaDocChange{DocID: "doc3", RevID: "2-abc", Deleted: true, Channels: []string{"ABC"}}, changes[1])
+	}
+	assert.Equal(t, "2", stats.Get(base.StatKeyKafkaPublishedCount).String())
+	assert.Equal(t, "1", stats.Get(base.StatKeyKafkaFilteredCount).String())
+
+	// Failures are counted
+	producer.err = errors.New("brokers unavailable")
+	ks.HandleEvent(&DocumentChangeEvent{Doc: Body{BodyId: "doc4", BodyRev: "1-abc"}, Channels: base.SetOf("ABC")})
+	assert.Equal(t, "1", stats.Get(base.StatKeyKafkaFailedCount).String())
+}
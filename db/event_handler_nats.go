@@ -0,0 +1,46 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/nats-io/go-nats"
+)
+
+// Publishes event payloads to a NATS subject.  The NATS client reconnects by itself, buffering messages published
+// while it's disconnected.
+type natsPublisher struct {
+	url     string
+	subject string
+	conn    *nats.Conn
+}
+
+// Creates a message sink that publishes events to a subject on the NATS server at url.
+func NewNATSSink(url string, subject string, options MessageSinkOptions) (*MessageSink, error) {
+	if url == "" {
+		return nil, errors.New("url parameter must be defined for nats events.")
+	}
+	if subject == "" {
+		return nil, errors.New("subject parameter must be defined for nats events.")
+	}
+
+	conn, err := nats.Connect(url, nats.Name("sync_gateway"), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to connect to nats server %s: %v", base.RedactBasicAuthURL(url), err)
+	}
+	return NewMessageSink(&natsPublisher{url: url, subject: subject, conn: conn}, options), nil
+}
+
+func (p *natsPublisher) Publish(payload []byte) error {
+	return p.conn.Publish(p.subject, payload)
+}
+
+func (p *natsPublisher) Close() error {
+	p.conn.Close()
+	return nil
+}
+
+func (p *natsPublisher) String() string {
+	return fmt.Sprintf("nats %s/%s", base.RedactBasicAuthURL(p.url), p.subject)
+}
@@ -0,0 +1,101 @@
+package db
+
+import (
+	"encoding/json"
+	"expvar"
+
+	"github.com/couchbase/sync_gateway/base"
+)
+
+// MessagePublisher publishes event payloads to a messaging system.
+type MessagePublisher interface {
+	Publish(payload []byte) error
+	Close() error
+	String() string // Describes the destination, with any credentials redacted
+}
+
+// MessageSink is an implementation of EventHandler that publishes events to a messaging system such as NATS or
+// AMQP, with the same JSON payloads that a webhook posts.
+type MessageSink struct {
+	AsyncEventHandler
+	publisher MessagePublisher
+	channels  base.Set
+	stats     *expvar.Map
+}
+
+// Optional message sink settings
+type MessageSinkOptions struct {
+	Channels []string    // If set, only changes to documents in one of these channels are published
+	Stats    *expvar.Map // If set, publishing stats are added to this map
+}
+
+// Creates a message sink that publishes events with the given publisher.
+func NewMessageSink(publisher MessagePublisher, options MessageSinkOptions) *MessageSink {
+	ms := &MessageSink{
+		publisher: publisher,
+		stats:     options.Stats,
+	}
+	if len(options.Channels) > 0 {
+		ms.channels = base.SetFromArray(options.Channels)
+	}
+	return ms
+}
+
+// Publishes the event's payload.  If channels are defined, only changes to documents in those channels are
+// published.
+func (ms *MessageSink) HandleEvent(event Event) {
+
+	var doc Body
+	switch event := event.(type) {
+	case *DocumentChangeEvent:
+		if ms.channels != nil && !ms.inChannels(event.Channels) {
+			ms.addStat(base.StatKeySinkFilteredCount)
+			return
+		}
+		doc = event.Doc
+	case *DBStateChangeEvent:
+		doc = event.Doc
+	case *PrincipalChangeEvent:
+		doc = event.Doc
+	case *ReplicationEvent:
+		doc = event.Doc
+	case *DBLifecycleEvent:
+		doc = event.Doc
+	default:
+		base.Warnf(base.KeyAll, "Message sink invoked for unsupported event type.")
+		return
+	}
+
+	payload, err := json.Marshal(doc)
+	if err != nil {
+		base.Warnf(base.KeyAll, "Error marshalling doc for message sink: %v", err)
+		return
+	}
+	if err := ms.publisher.Publish(payload); err != nil {
+		base.Warnf(base.KeyAll, "Error publishing %s to %s: %v", base.UD(event.String()), base.UD(ms.publisher.String()), err)
+		ms.addStat(base.StatKeySinkFailedCount)
+		return
+	}
+	ms.addStat(base.StatKeySinkPublishedCount)
+	base.Debugf(base.KeyEvents, "Message sink ran for event.  Payload %s published to %s", base.UD(string(payload)), base.UD(ms.publisher.String()))
+}
+
+// Returns true if any of the document's channels are one of the sink's channels.
+func (ms *MessageSink) inChannels(docChannels base.Set) bool {
+	for channel := range docChannels {
+		if ms.channels.Contains(channel) {
+			return true
+		}
+	}
+	return false
+}
+
+func (ms *MessageSink) addStat(key string) {
+	if ms.stats != nil {
+		ms.stats.Add(key, 1)
+	}
+}
+
+func (ms *MessageSink) String() string {
+	return "Message sink [" + ms.publisher.String() + "]"
+}
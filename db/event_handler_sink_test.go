@@ -0,0 +1,55 @@
+package db
+
+import (
+	"errors"
+	"expvar"
+	"sync"
+	"testing"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/stretchr/testify/assert"
+)
+
+// A MessagePublisher that records the payloads it's given, instead of publishing them.
+type testMessagePublisher struct {
+	lock     sync.Mutex
+	payloads []string
+	err      error
+}
+
+func (p *testMessagePublisher) Publish(payload []byte) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	p.payloads = append(p.payloads, string(payload))
+	return nil
+}
+
+func (p *testMessagePublisher) Close() error {
+	return nil
+}
+
+func (p *testMessagePublisher) String() string {
+	return "test publisher"
+}
+
+func TestMessageSink(t *testing.T) {
+	stats := new(expvar.Map).Init()
+	publisher := &testMessagePublisher{}
+	sink := NewMessageSink(publisher, MessageSinkOptions{Channels: []string{"ABC"}, Stats: stats})
+	assert.Equal(t, "Message sink [test publisher]", sink.String())
+
+	// Only changes to docs in the sink's channels are published, but other events always are
+	sink.HandleEvent(&DocumentChangeEvent{Doc: Body{BodyId: "doc1"}, Channels: base.SetOf("ABC", "DEF")})
+	sink.HandleEvent(&DocumentChangeEvent{Doc: Body{BodyId: "doc2"}, Channels: base.SetOf("DEF")})
+	sink.HandleEvent(&DBStateChangeEvent{Doc: Body{"dbname": "db", "state": "online"}})
+	assert.Equal(t, []string{`{"_id":"doc1"}`, `{"dbname":"db","state":"online"}`}, publisher.payloads)
+	assert.Equal(t, "2", stats.Get(base.StatKeySinkPublishedCount).String())
+	assert.Equal(t, "1", stats.Get(base.StatKeySinkFilteredCount).String())
+
+	publisher.err = errors.New("broker unavailable")
+	sink.HandleEvent(&DocumentChangeEvent{Doc: Body{BodyId: "doc3"}, Channels: base.SetOf("ABC")})
+	assert.Equal(t, "1", stats.Get(base.StatKeySinkFailedCount).String())
+}
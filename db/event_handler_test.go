@@ -1,8 +1,33 @@
 package db
 
 import (
-	goassert "github.com/couchbaselabs/go.assert"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"expvar"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/couchbase/sync_gateway/base"
+	goassert "github.com/couchbaselabs/go.assert"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestWebhookString(t *testing.T) {
@@ -32,3 +57,289 @@ func TestSanitizedUrl(t *testing.T) {
 	}
 	goassert.Equals(t, wh.SanitizedUrl(), "https://example.com/does-not-count-as-url-embedded:basic-auth-credentials@qux")
 }
+
+func TestWebhookRetryAndDeadLetter(t *testing.T) {
+	var attempts int32
+	var deadLetters [][]byte
+	var lock sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		switch r.URL.Path {
+		case "/flaky":
+			// Fails twice before succeeding
+			if atomic.AddInt32(&attempts, 1) <= 2 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		case "/rejects":
+			w.WriteHeader(http.StatusBadRequest)
+		case "/dead_letters":
+			lock.Lock()
+			deadLetters = append(deadLetters, body)
+			lock.Unlock()
+		}
+	}))
+	defer server.Close()
+
+	stats := initEmptyStatsMap(base.StatsGroupKeyDatabase)
+	event := &DocumentChangeEvent{Doc: Body{BodyId: "doc1"}, Channels: base.SetOf("ABC")}
+	statValue := func(key string) int64 {
+		return stats.Get(key).(*expvar.Int).Value()
+	}
+
+	// Temporary failures are retried
+	wh, err := NewWebhookWithOptions(server.URL+"/flaky", WebhookOptions{MaxRetries: 3, RetryBackoff: time.Millisecond, DeadLetterUrl: server.URL + "/dead_letters", Stats: stats})
+	assert.NoError(t, err)
+	wh.HandleEvent(event)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&attempts))
+	assert.Equal(t, int64(1), statValue(base.StatKeyWebhookDeliveredCount))
+	assert.Equal(t, int64(2), statValue(base.StatKeyWebhookRetryCount))
+
+	// Until they run out of retries, and are dead-lettered
+	atomic.StoreInt32(&attempts, 0)
+	wh, err = NewWebhookWithOptions(server.URL+"/flaky", WebhookOptions{MaxRetries: 1, RetryBackoff: time.Millisecond, DeadLetterUrl: server.URL + "/dead_letters", Stats: stats})
+	assert.NoError(t, err)
+	wh.HandleEvent(event)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&attempts))
+	assert.Equal(t, int64(1), statValue(base.StatKeyWebhookFailedCount))
+	assert.Equal(t, int64(1), statValue(base.StatKeyWebhookDeadLetterCount))
+
+	// Permanent failures aren't retried
+	wh, err = NewWebhookWithOptions(server.URL+"/rejects", WebhookOptions{MaxRetries: 3, RetryBackoff: time.Millisecond, DeadLetterUrl: server.URL + "/dead_letters", Stats: stats})
+	assert.NoError(t, err)
+	wh.HandleEvent(event)
+	assert.Equal(t, int64(3), statValue(base.StatKeyWebhookRetryCount))
+	assert.Equal(t, int64(2), statValue(base.StatKeyWebhookFailedCount))
+
+	lock.Lock()
+	defer lock.Unlock()
+	if assert.Len(t, deadLetters, 2) {
+		var deadLetter webhookDeadLetter
+		assert.NoError(t, json.Unmarshal(deadLetters[1], &deadLetter))
+		assert.Equal(t, server.URL+"/rejects", deadLetter.Url)
+		assert.Equal(t, uint(1), deadLetter.Attempts)
+		assert.Contains(t, deadLetter.Error, "400")
+		assert.Equal(t, `{"_id":"doc1"}`, string(deadLetter.Payload))
+	}
+}
+
+func TestWebhookRetryReleasesEventSlot(t *testing.T) {
+	var attempts int32
+	retried := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		close(retried)
+	}))
+
+	// The webhook's retry succeeds, so it's finished before the server's closed
+	defer func() {
+		select {
+		case <-retried:
+		case <-time.After(5 * time.Second):
+			t.Error("Timed out waiting for the webhook to retry")
+		}
+		server.Close()
+	}()
+
+	// Only one event can be processed at a time
+	em := NewEventManager()
+	em.Start(1, -1)
+	wh, err := NewWebhookWithOptions(server.URL, WebhookOptions{MaxRetries: 1, RetryBackoff: time.Second})
+	assert.NoError(t, err)
+	em.RegisterEventHandler(wh, DBStateChange)
+	resultChannel := make(chan Body, 1)
+	em.RegisterEventHandler(&TestingHandler{HandledEvent: DocumentChange, ResultChannel: resultChannel}, DocumentChange)
+
+	assert.NoError(t, em.RaiseDBStateChangeEvent("db", "online", "DB started from config", "127.0.0.1:4985"))
+	for i := 0; i < 100 && atomic.LoadInt32(&attempts) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&attempts))
+
+	// Other events are processed while the webhook waits to retry
+	assert.NoError(t, em.RaiseDocumentChangeEvent(Body{BodyId: "doc1"}, "", base.SetOf("ABC")))
+	assertChannelLengthWithTimeout(t, resultChannel, 1, time.Second)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&attempts))
+}
+
+func TestWebhookChannelFilter(t *testing.T) {
+	var posted []string
+	var lock sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body Body
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		lock.Lock()
+		posted = append(posted, body[BodyId].(string))
+		lock.Unlock()
+	}))
+	defer server.Close()
+
+	stats := initEmptyStatsMap(base.StatsGroupKeyDatabase)
+	wh, err := NewWebhookWithOptions(server.URL, WebhookOptions{
+		Channels: []string{"ABC", "DEF"},
+		Filter:   `function(doc) { return doc._id != "doc3"; }`,
+		Stats:    stats,
+	})
+	assert.NoError(t, err)
+
+	wh.HandleEvent(&DocumentChangeEvent{Doc: Body{BodyId: "doc1"}, Channels: base.SetOf("ABC", "XYZ")})
+	wh.HandleEvent(&DocumentChangeEvent{Doc: Body{BodyId: "doc2"}, Channels: base.SetOf("XYZ")})
+	wh.HandleEvent(&DocumentChangeEvent{Doc: Body{BodyId: "doc3"}, Channels: base.SetOf("DEF")})
+	wh.HandleEvent(&DocumentChangeEvent{Doc: Body{BodyId: "doc4"}, Channels: base.SetOf("DEF")})
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, []string{"doc1", "doc4"}, posted)
+	assert.Equal(t, int64(2), stats.Get(base.StatKeyWebhookFilteredCount).(*expvar.Int).Value())
+}
+
+func TestWebhookTemplatedPayload(t *testing.T) {
+	var posted []string
+	var contentTypes []string
+	var lock sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		lock.Lock()
+		posted = append(posted, string(body))
+		contentTypes = append(contentTypes, r.Header.Get("Content-Type"))
+		lock.Unlock()
+	}))
+	defer server.Close()
+
+	takePosted := func() ([]string, []string) {
+		lock.Lock()
+		defer lock.Unlock()
+		result, types := posted, contentTypes
+		posted, contentTypes = nil, nil
+		return result, types
+	}
+
+	// Payload template, which generates nothing for docs it shouldn't post
+	wh, err := NewWebhookWithOptions(server.URL, WebhookOptions{
+		Template:    `{{if .Doc.value}}id={{.Doc._id}}&tags={{json .Doc.tags}}{{end}}`,
+		ContentType: "text/plain",
+	})
+	assert.NoError(t, err)
+	wh.HandleEvent(&DocumentChangeEvent{Doc: Body{BodyId: "doc1", "value": "foo", "tags": []string{"a", "b"}}})
+	wh.HandleEvent(&DocumentChangeEvent{Doc: Body{BodyId: "doc2"}})
+	payloads, types := takePosted()
+	assert.Equal(t, []string{`id=doc1&tags=["a","b"]`}, payloads)
+	assert.Equal(t, []string{"text/plain"}, types)
+
+	// Transform function, which returns null for docs it shouldn't post
+	wh, err = NewWebhookWithOptions(server.URL, WebhookOptions{
+		Transform: `function(doc) {
+			if (!doc.value) {
+				return null;
+			}
+			return {id: doc._id, value: doc.value.toUpperCase()};
+		}`,
+	})
+	assert.NoError(t, err)
+	wh.HandleEvent(&DocumentChangeEvent{Doc: Body{BodyId: "doc1", "value": "foo"}})
+	wh.HandleEvent(&DocumentChangeEvent{Doc: Body{BodyId: "doc2"}})
+	payloads, types = takePosted()
+	assert.Equal(t, []string{`{"id":"doc1","value":"FOO"}`}, payloads)
+	assert.Equal(t, []string{"application/json"}, types)
+
+	_, err = NewWebhookWithOptions(server.URL, WebhookOptions{Template: `{{.Doc`})
+	assert.Error(t, err)
+	_, err = NewWebhookWithOptions(server.URL, WebhookOptions{Template: `{{.Doc}}`, Transform: `function(doc) { return doc; }`})
+	assert.Error(t, err)
+}
+
+func TestWebhookSigning(t *testing.T) {
+	var signatures []string
+	var lock sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		timestamp := r.Header.Get(WebhookTimestampHeader)
+		assert.NotEmpty(t, timestamp)
+
+		// Verify the signatures the way a receiver would
+		lock.Lock()
+		defer lock.Unlock()
+		for _, signature := range strings.Split(r.Header.Get(WebhookSignatureHeader), ",") {
+			for _, secret := range []string{"old", "new"} {
+				mac := hmac.New(sha256.New, []byte(secret))
+				mac.Write([]byte(timestamp + "." + string(body)))
+				if hmac.Equal([]byte(signature), []byte("sha256="+hex.EncodeToString(mac.Sum(nil)))) {
+					signatures = append(signatures, secret)
+				}
+			}
+		}
+	}))
+	defer server.Close()
+
+	// While rotating secrets, posts are signed with both
+	wh, err := NewWebhookWithOptions(server.URL, WebhookOptions{SigningSecrets: []string{"new", "old"}})
+	assert.NoError(t, err)
+	wh.HandleEvent(&DocumentChangeEvent{Doc: Body{BodyId: "doc1"}})
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, []string{"new", "old"}, signatures)
+}
+
+// Writes a new self-signed client certificate and its key to dir, returning their paths.
+func writeTestClientCert(t *testing.T, dir string) (certPath, keyPath string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sync_gateway"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	certPath, keyPath = filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key")
+	assert.NoError(t, ioutil.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0600))
+	assert.NoError(t, ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return certPath, keyPath
+}
+
+func TestWebhookMutualTLS(t *testing.T) {
+	var clientCerts int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) > 0 {
+			atomic.AddInt32(&clientCerts, 1)
+		}
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "webhook_tls")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	certPath, keyPath := writeTestClientCert(t, dir)
+	caPath := filepath.Join(dir, "ca.pem")
+	assert.NoError(t, ioutil.WriteFile(caPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600))
+
+	// Without a client cert, or the server's CA, the post fails
+	stats := initEmptyStatsMap(base.StatsGroupKeyDatabase)
+	wh, err := NewWebhookWithOptions(server.URL, WebhookOptions{CACertPath: caPath, Stats: stats})
+	assert.NoError(t, err)
+	wh.HandleEvent(&DocumentChangeEvent{Doc: Body{BodyId: "doc1"}})
+	wh, err = NewWebhookWithOptions(server.URL, WebhookOptions{ClientCertPath: certPath, ClientKeyPath: keyPath, Stats: stats})
+	assert.NoError(t, err)
+	wh.HandleEvent(&DocumentChangeEvent{Doc: Body{BodyId: "doc1"}})
+	assert.Equal(t, int64(2), stats.Get(base.StatKeyWebhookFailedCount).(*expvar.Int).Value())
+
+	wh, err = NewWebhookWithOptions(server.URL, WebhookOptions{ClientCertPath: certPath, ClientKeyPath: keyPath, CACertPath: caPath, Stats: stats})
+	assert.NoError(t, err)
+	wh.HandleEvent(&DocumentChangeEvent{Doc: Body{BodyId: "doc1"}})
+	assert.Equal(t, int64(1), stats.Get(base.StatKeyWebhookDeliveredCount).(*expvar.Int).Value())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&clientCerts))
+
+	_, err = NewWebhookWithOptions(server.URL, WebhookOptions{ClientCertPath: certPath})
+	assert.Error(t, err)
+}
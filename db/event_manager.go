@@ -16,6 +16,7 @@ type EventManager struct {
 	asyncEventChannel  chan Event
 	activeCountChannel chan bool
 	waitTime           int
+	queue              *persistentEventQueue // If set, document change events are queued here instead of in memory
 }
 
 const kMaxActiveEvents = 500 // number of events that are processed concurrently
@@ -63,22 +64,109 @@ func (em *EventManager) Start(maxProcesses uint, waitTime int) {
 		}
 	}()
 
+	if em.queue != nil {
+		go em.queue.run(em.processBatch, int(maxProcesses))
+	}
+}
+
+// Queues document change events in the bucket, rather than in memory, so that they survive restarts and aren't lost
+// when handlers fall behind.  Must be called before Start.
+func (em *EventManager) EnablePersistentQueue(bucket base.Bucket, options PersistentEventQueueOptions) error {
+	queue, err := newPersistentEventQueue(bucket, options)
+	if err != nil {
+		return err
+	}
+	em.queue = queue
+	return nil
+}
+
+// Stops delivering events from the persistent event queue, if any.  Events still queued are delivered once the
+// queue is started again.
+func (em *EventManager) Stop() {
+	if em.queue != nil {
+		em.queue.stop()
+	}
+}
+
+// Concurrently processes a batch of events, returning once they've all been handled.
+func (em *EventManager) processBatch(events []Event) {
+	var wg sync.WaitGroup
+	for _, event := range events {
+		em.activeCountChannel <- true
+		wg.Add(1)
+		go func(event Event) {
+			defer wg.Done()
+			em.ProcessEvent(event)
+		}(event)
+	}
+	wg.Wait()
 }
 
-// Concurrent processing of all async event handlers registered for the event type
+// Event handlers that wait between retries implement this to wait with the wait function they're given, so that the
+// event manager can let other events be processed while they wait.
+type waitingEventHandler interface {
+	handleEventWithWait(event Event, wait func(time.Duration))
+}
+
+// Tracks which of an event's handlers are running, so that the event's slot in activeCountChannel can be released
+// while all of them are waiting to retry, and once they've all finished.
+type eventSlot struct {
+	em      *EventManager
+	lock    sync.Mutex
+	running int // # of the event's handlers that are running and not waiting
+}
+
+// Called by a handler when it stops running, either to wait or because it's finished.
+func (s *eventSlot) release() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.running--
+	if s.running == 0 {
+		<-s.em.activeCountChannel
+	}
+}
+
+// Called by a handler when it's finished waiting.  Blocks until there's a slot free, if the event had released its own.
+func (s *eventSlot) acquire() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.running == 0 {
+		s.em.activeCountChannel <- true
+	}
+	s.running++
+}
+
+func (s *eventSlot) wait(d time.Duration) {
+	s.release()
+	time.Sleep(d)
+	s.acquire()
+}
+
+// Concurrent processing of all async event handlers registered for the event type.  Must be called holding a slot in
+// activeCountChannel, which is released once the handlers have finished.
 func (em *EventManager) ProcessEvent(event Event) {
-	defer func() { <-em.activeCountChannel }()
+	handlers := em.eventHandlers[event.EventType()]
+	if len(handlers) == 0 {
+		<-em.activeCountChannel
+		return
+	}
+	slot := &eventSlot{em: em, running: len(handlers)}
 	// Send event to all registered handlers concurrently.  WaitGroup blocks
 	// until all are finished
 	var wg sync.WaitGroup
-	for _, handler := range em.eventHandlers[event.EventType()] {
+	for _, handler := range handlers {
 		base.Debugf(base.KeyEvents, "Event queue worker sending event %s to: %s", base.UD(event.String()), handler)
 		wg.Add(1)
 		go func(event Event, handler EventHandler) {
 			defer wg.Done()
+			defer slot.release()
 			//TODO: Currently we're not tracking success/fail from event handlers.  When this
 			// is needed, could pass a channel to HandleEvent for tracking results
-			handler.HandleEvent(event)
+			if waitingHandler, ok := handler.(waitingEventHandler); ok {
+				waitingHandler.handleEventWithWait(event, slot.wait)
+			} else {
+				handler.HandleEvent(event)
+			}
 		}(event, handler)
 	}
 	wg.Wait()
@@ -99,6 +187,9 @@ func (em *EventManager) HasHandlerForEvent(eventType EventType) bool {
 
 // Adds async events to the channel for processing
 func (em *EventManager) raiseEvent(event Event) error {
+	if dce, ok := event.(*DocumentChangeEvent); ok && em.queue != nil {
+		return em.queue.enqueue(dce, time.Duration(em.waitTime)*time.Millisecond)
+	}
 	if !event.Synchronous() {
 		// When asyncEventChannel is full, the raiseEvent method will block for (waitTime).
 		// Default value of (waitTime) is 5 ms.
@@ -152,3 +243,110 @@ func (em *EventManager) RaiseDBStateChangeEvent(dbName string, state string, rea
 
 	return em.raiseEvent(event)
 }
+
+// Raises a principal change event describing the change to a user or role.  If the event manager doesn't have a
+// listener for this event, ignores.
+func (em *EventManager) RaisePrincipalChangeEvent(delta PrincipalDelta) error {
+
+	if !em.activeEventTypes[PrincipalChange] {
+		return nil
+	}
+
+	body := make(Body, 8)
+	body["name"] = delta.Name
+	body["type"] = "user"
+	if delta.IsRole {
+		body["type"] = "role"
+	}
+	body["change"] = delta.Change
+	if delta.DocID != "" {
+		body["doc_id"] = delta.DocID
+	}
+	body["channels_added"] = nonNilStrings(delta.ChannelsAdded)
+	body["channels_removed"] = nonNilStrings(delta.ChannelsRemoved)
+	if !delta.IsRole {
+		body["roles_added"] = nonNilStrings(delta.RolesAdded)
+		body["roles_removed"] = nonNilStrings(delta.RolesRemoved)
+	}
+
+	event := &PrincipalChangeEvent{
+		Doc: body,
+	}
+
+	return em.raiseEvent(event)
+}
+
+// Raises a replication event describing the state of a BLIP replication.  If the event manager doesn't have a
+// listener for this event, ignores.
+func (em *EventManager) RaiseReplicationEvent(status ReplicationStatus) error {
+
+	if !em.activeEventTypes[ReplicationChange] {
+		return nil
+	}
+
+	body := make(Body, 9)
+	body["state"] = status.State
+	body["replication_id"] = status.ReplicationID
+	body["user"] = status.Username
+	if status.ClientID != "" {
+		body["client_id"] = status.ClientID
+	}
+	if status.UserAgent != "" {
+		body["user_agent"] = status.UserAgent
+	}
+	body["docs_sent"] = status.DocsSent
+	body["docs_received"] = status.DocsReceived
+	if status.Error != "" {
+		body["error"] = status.Error
+	}
+	body["localtime"] = time.Now().Format(base.ISO8601Format)
+
+	event := &ReplicationEvent{
+		Doc: body,
+	}
+
+	return em.raiseEvent(event)
+}
+
+// Raises a DB lifecycle event describing a transition of the database.  If the event manager doesn't have a listener
+// for this event, ignores.
+func (em *EventManager) RaiseDBLifecycleEvent(dbName string, status DBLifecycleStatus) error {
+
+	if !em.activeEventTypes[DBLifecycleChange] {
+		return nil
+	}
+
+	body := make(Body, 7)
+	body["dbname"] = dbName
+	body["event"] = status.Transition
+	if status.Reason != "" {
+		body["reason"] = status.Reason
+	}
+	switch status.Transition {
+	case DBLifecycleResyncFinished:
+		body["docs_changed"] = status.DocsChanged
+	case DBLifecycleCompactionFinished:
+		body["tombstones_purged"] = status.TombstonesPurged
+	}
+	if status.Duration > 0 {
+		body["duration_ms"] = int64(status.Duration / time.Millisecond)
+	}
+	if status.Error != nil {
+		body["error"] = status.Error.Error()
+	}
+	body["localtime"] = time.Now().Format(base.ISO8601Format)
+
+	event := &DBLifecycleEvent{
+		Doc: body,
+	}
+
+	return em.raiseEvent(event)
+}
+
+// Returns values, or an empty slice if it's nil, so that it's marshalled as [] rather than null.
+func nonNilStrings(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
@@ -8,11 +8,14 @@ import (
 	"net"
 	"net/http"
 	"strconv"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbase/sync_gateway/channels"
 	goassert "github.com/couchbaselabs/go.assert"
+	"github.com/stretchr/testify/assert"
 )
 
 // Webhook tests use an HTTP listener.  Use of this listener is disabled by default, to avoid
@@ -65,6 +68,14 @@ func (th *TestingHandler) HandleEvent(event Event) {
 
 		th.ResultChannel <- dsceEvent.Doc
 	}
+
+	if pceEvent, ok := event.(*PrincipalChangeEvent); ok {
+		th.ResultChannel <- pceEvent.Doc
+	}
+
+	if dleEvent, ok := event.(*DBLifecycleEvent); ok {
+		th.ResultChannel <- dleEvent.Doc
+	}
 	return
 }
 
@@ -149,6 +160,161 @@ func TestDBStateChangeEvent(t *testing.T) {
 
 }
 
+func TestPrincipalChangeEvent(t *testing.T) {
+
+	db, testBucket := setupTestDB(t)
+	defer testBucket.Close()
+	defer tearDownTestDB(t, db)
+	db.ChannelMapper = channels.NewChannelMapper(`function(doc) {access(doc.users, doc.channels); role(doc.role_users, doc.roles);}`)
+
+	resultChannel := make(chan Body, 10)
+	testHandler := &TestingHandler{HandledEvent: PrincipalChange}
+	testHandler.SetChannel(resultChannel)
+	db.EventMgr.RegisterEventHandler(testHandler, PrincipalChange)
+	db.EventMgr.Start(0, -1)
+
+	nextEvent := func() Body {
+		select {
+		case body := <-resultChannel:
+			// Round trip through JSON, to compare with the payload webhooks receive
+			bodyBytes, err := json.Marshal(body)
+			assert.NoError(t, err)
+			var result Body
+			assert.NoError(t, json.Unmarshal(bodyBytes, &result))
+			return result
+		case <-time.After(10 * time.Second):
+			t.Fatalf("Timed out waiting for principal change event")
+			return nil
+		}
+	}
+	names := func(values ...string) []interface{} {
+		result := make([]interface{}, len(values))
+		for i, value := range values {
+			result[i] = value
+		}
+		return result
+	}
+
+	// Creating a user
+	name := "alice"
+	_, err := db.UpdatePrincipal(PrincipalConfig{Name: &name, Password: &name, ExplicitChannels: base.SetOf("A", "B")}, true, true)
+	assert.NoError(t, err)
+	assert.Equal(t, Body{
+		"name":             "alice",
+		"type":             "user",
+		"change":           PrincipalCreated,
+		"channels_added":   names("A", "B"),
+		"channels_removed": names(),
+		"roles_added":      names(),
+		"roles_removed":    names(),
+	}, nextEvent())
+
+	// Changing its admin channels and roles
+	_, err = db.UpdatePrincipal(PrincipalConfig{Name: &name, ExplicitChannels: base.SetOf("B", "C"), ExplicitRoleNames: []string{"r1"}}, true, true)
+	assert.NoError(t, err)
+	event := nextEvent()
+	assert.Equal(t, PrincipalUpdated, event["change"])
+	assert.Equal(t, names("C"), event["channels_added"])
+	assert.Equal(t, names("A"), event["channels_removed"])
+	assert.Equal(t, names("r1"), event["roles_added"])
+
+	// A document granting it access to a channel and a role, and a role access to a channel
+	rev1, err := db.Put("grants", Body{"users": []string{"alice", "role:r2"}, "channels": []string{"X"}, "role_users": []string{"alice"}, "roles": []string{"role:r2"}})
+	assert.NoError(t, err)
+	events := map[string]Body{}
+	for i := 0; i < 2; i++ {
+		event := nextEvent()
+		events[event["type"].(string)] = event
+	}
+	assert.Equal(t, Body{
+		"name":             "alice",
+		"type":             "user",
+		"change":           PrincipalGrantChanged,
+		"doc_id":           "grants",
+		"channels_added":   names("X"),
+		"channels_removed": names(),
+		"roles_added":      names("r2"),
+		"roles_removed":    names(),
+	}, events["user"])
+	assert.Equal(t, "r2", events["role"]["name"])
+	assert.Equal(t, names("X"), events["role"]["channels_added"])
+	assert.NotContains(t, events["role"], "roles_added")
+
+	// Revoking the user's access.  The role's grant is unchanged
+	_, err = db.Put("grants", Body{BodyRev: rev1, "users": []string{"role:r2"}, "channels": []string{"X"}})
+	assert.NoError(t, err)
+	event = nextEvent()
+	assert.Equal(t, "alice", event["name"])
+	assert.Equal(t, names("X"), event["channels_removed"])
+	assert.Equal(t, names("r2"), event["roles_removed"])
+
+	// Deleting the user
+	user, err := db.Authenticator().GetUser("alice")
+	assert.NoError(t, err)
+	assert.NoError(t, db.DeletePrincipal(user))
+	event = nextEvent()
+	assert.Equal(t, PrincipalDeleted, event["change"])
+	assert.Equal(t, names("B", "C"), event["channels_removed"])
+	assert.Equal(t, names("r1"), event["roles_removed"])
+
+	assert.Len(t, resultChannel, 0)
+}
+
+func TestDBLifecycleEvent(t *testing.T) {
+
+	db, testBucket := setupTestDB(t)
+	defer testBucket.Close()
+	defer tearDownTestDB(t, db)
+	db.ChannelMapper = channels.NewChannelMapper(`function(doc) {channel(doc.channels);}`)
+
+	resultChannel := make(chan Body, 10)
+	testHandler := &TestingHandler{HandledEvent: DBLifecycleChange}
+	testHandler.SetChannel(resultChannel)
+	db.EventMgr.RegisterEventHandler(testHandler, DBLifecycleChange)
+	db.EventMgr.Start(0, -1)
+
+	nextEvent := func() Body {
+		select {
+		case body := <-resultChannel:
+			goassert.Equals(t, body["dbname"], "db")
+			_, err := time.Parse(base.ISO8601Format, body["localtime"].(string))
+			assert.NoError(t, err)
+			return body
+		case <-time.After(10 * time.Second):
+			t.Fatalf("Timed out waiting for db lifecycle event")
+			return nil
+		}
+	}
+
+	_, err := db.Put("doc1", Body{"channels": "A"})
+	assert.NoError(t, err)
+
+	// Resync raises an event when it starts and when it finishes, with the # of docs changed
+	_, err = db.UpdateSyncFun(`function(doc) {channel("B");}`)
+	assert.NoError(t, err)
+	changeCount, err := db.UpdateAllDocChannels()
+	assert.NoError(t, err)
+	goassert.Equals(t, changeCount, 1)
+
+	event := nextEvent()
+	goassert.Equals(t, event["event"], DBLifecycleResyncStarted)
+	event = nextEvent()
+	goassert.Equals(t, event["event"], DBLifecycleResyncFinished)
+	goassert.Equals(t, event["docs_changed"], 1)
+	_, ok := event["duration_ms"]
+	goassert.True(t, ok)
+	_, ok = event["error"]
+	goassert.False(t, ok)
+
+	// Taking the db offline raises an event with the reason
+	db.ExitChanges = make(chan struct{})
+	atomic.StoreUint32(&db.State, DBOnline)
+	assert.NoError(t, db.TakeDbOffline("Test"))
+	event = nextEvent()
+	goassert.Equals(t, event["event"], DBLifecycleOffline)
+	goassert.Equals(t, event["reason"], "Test")
+}
+
 // Test sending many events with slow-running execution to validate they get dropped after hitting
 // the max concurrent goroutines
 func TestSlowExecutionProcessing(t *testing.T) {
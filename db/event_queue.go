@@ -0,0 +1,279 @@
+package db
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/couchbase/sync_gateway/base"
+)
+
+// Document change events are normally queued in memory, so they're lost if the node restarts, and dropped if the
+// handlers can't keep up.  A persistent event queue stores each event in the bucket instead, and its worker delivers
+// the queued events to the handlers in batches, only removing them once they've all been handled, so that every event
+// is handled at least once.  Each node has its own queue, named after the node, which it resumes when restarted.
+//
+// The queue is a range of numbered entries: the tail counter is the number of the last entry queued, and the head
+// counter the number of the last entry handled.
+const EventQueueKeyPrefix = KSyncKeyPrefix + "eventq:"
+
+// What happens to new events while a persistent event queue is full
+const (
+	EventQueueOverflowDrop  = "drop"  // Events are dropped and counted
+	EventQueueOverflowBlock = "block" // Raising an event waits up to the event manager's wait time for space, then drops it
+)
+
+const (
+	kDefaultEventQueueMaxDepth  = 100000
+	kEventQueuePollInterval     = 1 * time.Second        // How often the worker checks for events queued by other processes
+	kEventQueueBlockInterval    = 10 * time.Millisecond  // How often a blocked event checks for space in the queue
+	kEventQueueMissingEntryWait = 100 * time.Millisecond // How long to wait for an entry that's been numbered but not stored yet
+	kEventQueueMissingEntryMax  = 50                     // # of waits for a missing entry before assuming it was never stored
+)
+
+// Persistent event queue settings
+type PersistentEventQueueOptions struct {
+	Name     string      // Identifies this node's queue.  Defaults to the hostname
+	MaxDepth uint64      // Max # of queued events.  Defaults to kDefaultEventQueueMaxDepth
+	Overflow string      // EventQueueOverflowDrop (the default) or EventQueueOverflowBlock
+	Stats    *expvar.Map // If set, queue stats are added to this map
+}
+
+// A queued document change event
+type queuedEvent struct {
+	Doc      Body     `json:"doc"`
+	OldDoc   string   `json:"old_doc,omitempty"`
+	Channels []string `json:"channels,omitempty"`
+}
+
+func (e queuedEvent) event() *DocumentChangeEvent {
+	e.Doc.FixJSONNumbers()
+	return &DocumentChangeEvent{
+		Doc:      e.Doc,
+		OldDoc:   e.OldDoc,
+		Channels: base.SetFromArray(e.Channels),
+	}
+}
+
+type persistentEventQueue struct {
+	bucket     base.Bucket
+	keyPrefix  string
+	options    PersistentEventQueueOptions
+	notify     chan struct{} // Signalled when an event is queued
+	terminator chan struct{} // Closed by stop
+	stopOnce   sync.Once
+}
+
+func newPersistentEventQueue(bucket base.Bucket, options PersistentEventQueueOptions) (*persistentEventQueue, error) {
+	if options.Name == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("Persistent event queue has no name, and the hostname is unavailable: %v", err)
+		}
+		options.Name = hostname
+	}
+	if options.MaxDepth == 0 {
+		options.MaxDepth = kDefaultEventQueueMaxDepth
+	}
+	switch options.Overflow {
+	case "":
+		options.Overflow = EventQueueOverflowDrop
+	case EventQueueOverflowDrop, EventQueueOverflowBlock:
+	default:
+		return nil, fmt.Errorf("Persistent event queue overflow_policy must be %q or %q, not %q", EventQueueOverflowDrop, EventQueueOverflowBlock, options.Overflow)
+	}
+
+	return &persistentEventQueue{
+		bucket:     bucket,
+		keyPrefix:  EventQueueKeyPrefix + options.Name + ":",
+		options:    options,
+		notify:     make(chan struct{}, 1),
+		terminator: make(chan struct{}),
+	}, nil
+}
+
+func (q *persistentEventQueue) headKey() string {
+	return q.keyPrefix + "head"
+}
+
+func (q *persistentEventQueue) tailKey() string {
+	return q.keyPrefix + "tail"
+}
+
+func (q *persistentEventQueue) entryKey(n uint64) string {
+	return q.keyPrefix + strconv.FormatUint(n, 10)
+}
+
+// Returns the numbers of the last entry handled and the last entry queued.
+func (q *persistentEventQueue) bounds() (head, tail uint64, err error) {
+	if head, err = q.bucket.Incr(q.headKey(), 0, 0, 0); err != nil {
+		return 0, 0, err
+	}
+	if tail, err = q.bucket.Incr(q.tailKey(), 0, 0, 0); err != nil {
+		return 0, 0, err
+	}
+	return head, tail, nil
+}
+
+// Returns the # of events queued but not yet handled.
+func (q *persistentEventQueue) depth() (uint64, error) {
+	head, tail, err := q.bounds()
+	if err != nil || head >= tail {
+		return 0, err
+	}
+	return tail - head, nil
+}
+
+// Stores an event in the queue.  If the queue is full, the event is dropped, possibly after waiting up to
+// blockTimeout for space, depending on the overflow policy.
+func (q *persistentEventQueue) enqueue(event *DocumentChangeEvent, blockTimeout time.Duration) error {
+	deadline := time.Now().Add(blockTimeout)
+	for {
+		depth, err := q.depth()
+		if err != nil {
+			base.Warnf(base.KeyAll, "Unable to check depth of persistent event queue %s - discarding event: %s: %v", base.MD(q.options.Name), base.UD(event.String()), err)
+			q.addStat(base.StatKeyEventQueueDroppedCount, 1)
+			return err
+		}
+		if depth < q.options.MaxDepth {
+			break
+		}
+		if q.options.Overflow != EventQueueOverflowBlock || time.Now().After(deadline) {
+			base.Warnf(base.KeyAll, "Persistent event queue %s is full (%d events) - discarding event: %s", base.MD(q.options.Name), depth, base.UD(event.String()))
+			q.addStat(base.StatKeyEventQueueDroppedCount, 1)
+			return errors.New("Event queue full")
+		}
+		select {
+		case <-time.After(kEventQueueBlockInterval):
+		case <-q.terminator:
+			return errors.New("Event queue stopped")
+		}
+	}
+
+	entry := queuedEvent{
+		Doc:      event.Doc,
+		OldDoc:   event.OldDoc,
+		Channels: event.Channels.ToArray(),
+	}
+	n, err := q.bucket.Incr(q.tailKey(), 1, 1, 0)
+	if err == nil {
+		err = q.bucket.Set(q.entryKey(n), 0, entry)
+	}
+	if err != nil {
+		base.Warnf(base.KeyAll, "Unable to add event to persistent event queue %s - discarding event: %s: %v", base.MD(q.options.Name), base.UD(event.String()), err)
+		q.addStat(base.StatKeyEventQueueDroppedCount, 1)
+		return err
+	}
+	q.addStat(base.StatKeyEventQueuePersistedCount, 1)
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Delivers queued events to handle, in batches of up to batchSize in queue order, until the queue is stopped.  Each
+// batch is removed from the queue once handle returns.
+func (q *persistentEventQueue) run(handle func(events []Event), batchSize int) {
+	base.Infof(base.KeyEvents, "Starting persistent event queue %s", base.MD(q.options.Name))
+	missingWaits := 0
+	for {
+		head, tail, err := q.bounds()
+		if err != nil {
+			base.Warnf(base.KeyAll, "Unable to read persistent event queue %s, will retry: %v", base.MD(q.options.Name), err)
+			if !q.wait(kEventQueuePollInterval) {
+				return
+			}
+			continue
+		}
+		if head >= tail {
+			if !q.wait(kEventQueuePollInterval) {
+				return
+			}
+			continue
+		}
+
+		last := tail
+		if last-head > uint64(batchSize) {
+			last = head + uint64(batchSize)
+		}
+		events := make([]Event, 0, last-head)
+		for n := head + 1; n <= last; n++ {
+			var entry queuedEvent
+			if _, err = q.bucket.Get(q.entryKey(n), &entry); err != nil {
+				break
+			}
+			events = append(events, entry.event())
+		}
+
+		numEntries := uint64(len(events))
+		if len(events) == 0 {
+			// The first entry is missing.  Give the process that numbered it time to store it, before assuming it
+			// never will and skipping it.
+			if base.IsKeyNotFoundError(q.bucket, err) && missingWaits < kEventQueueMissingEntryMax {
+				missingWaits++
+				if !q.wait(kEventQueueMissingEntryWait) {
+					return
+				}
+				continue
+			} else if !base.IsKeyNotFoundError(q.bucket, err) {
+				base.Warnf(base.KeyAll, "Unable to read persistent event queue %s, will retry: %v", base.MD(q.options.Name), err)
+				if !q.wait(kEventQueuePollInterval) {
+					return
+				}
+				continue
+			}
+			base.Warnf(base.KeyAll, "Skipping missing entry %d of persistent event queue %s", head+1, base.MD(q.options.Name))
+			numEntries = 1
+		} else {
+			handle(events)
+			q.addStat(base.StatKeyEventQueueProcessedCount, len(events))
+		}
+		missingWaits = 0
+
+		// The head's advanced before the entries are deleted, so that if it can't be, the entries are still there to be
+		// read again
+		if _, err := q.bucket.Incr(q.headKey(), numEntries, numEntries, 0); err != nil {
+			// The events will be handled again
+			base.Warnf(base.KeyAll, "Unable to update head of persistent event queue %s: %v", base.MD(q.options.Name), err)
+			if !q.wait(kEventQueuePollInterval) {
+				return
+			}
+			continue
+		}
+		for n := head + 1; n <= head+numEntries; n++ {
+			if err := q.bucket.Delete(q.entryKey(n)); err != nil && !base.IsKeyNotFoundError(q.bucket, err) {
+				base.Warnf(base.KeyAll, "Unable to remove entry %d of persistent event queue %s: %v", n, base.MD(q.options.Name), err)
+			}
+		}
+	}
+}
+
+// Waits for an event to be queued, or the timeout.  Returns false if the queue's been stopped.
+func (q *persistentEventQueue) wait(timeout time.Duration) bool {
+	select {
+	case <-q.notify:
+		return true
+	case <-time.After(timeout):
+		return true
+	case <-q.terminator:
+		return false
+	}
+}
+
+func (q *persistentEventQueue) stop() {
+	q.stopOnce.Do(func() {
+		close(q.terminator)
+	})
+}
+
+func (q *persistentEventQueue) addStat(key string, delta int) {
+	if q.options.Stats != nil {
+		q.options.Stats.Add(key, int64(delta))
+	}
+}
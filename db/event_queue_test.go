@@ -0,0 +1,79 @@
+package db
+
+import (
+	"expvar"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPersistentEventQueue(t *testing.T) {
+	db, testBucket := setupTestDB(t)
+	defer testBucket.Close()
+	defer tearDownTestDB(t, db)
+
+	stats := new(expvar.Map).Init()
+	options := PersistentEventQueueOptions{Name: "node1", MaxDepth: 3, Stats: stats}
+	resultChannel := make(chan Body, 10)
+
+	// Events raised while nothing's working the queue stay queued, until the queue is full
+	em := NewEventManager()
+	em.RegisterEventHandler(&TestingHandler{HandledEvent: DocumentChange, ResultChannel: resultChannel, t: t}, DocumentChange)
+	assert.NoError(t, em.EnablePersistentQueue(db.Bucket, options))
+	for i := 0; i < 4; i++ {
+		err := em.RaiseDocumentChangeEvent(Body{BodyId: fmt.Sprintf("doc%d", i), "count": i}, "", base.SetOf("ABC"))
+		if i < 3 {
+			assert.NoError(t, err)
+		} else {
+			assert.Error(t, err)
+		}
+	}
+	assert.Equal(t, "3", stats.Get(base.StatKeyEventQueuePersistedCount).String())
+	assert.Equal(t, "1", stats.Get(base.StatKeyEventQueueDroppedCount).String())
+
+	// Another event manager using the same queue, e.g. after a restart, handles the queued events
+	em = NewEventManager()
+	em.RegisterEventHandler(&TestingHandler{HandledEvent: DocumentChange, ResultChannel: resultChannel, t: t}, DocumentChange)
+	assert.NoError(t, em.EnablePersistentQueue(db.Bucket, options))
+	em.Start(0, -1)
+	defer em.Stop()
+
+	var docIDs []string
+	for i := 0; i < 3; i++ {
+		select {
+		case body := <-resultChannel:
+			docIDs = append(docIDs, body[BodyId].(string))
+			assert.Equal(t, fmt.Sprintf("doc%d", body["count"]), body[BodyId])
+		case <-time.After(10 * time.Second):
+			t.Fatalf("Timed out waiting for queued events - only got %v", docIDs)
+		}
+	}
+	assert.ElementsMatch(t, []string{"doc0", "doc1", "doc2"}, docIDs)
+
+	// Handled events are removed from the queue, making room for new ones
+	var depth uint64
+	var err error
+	for i := 0; i < 50; i++ {
+		if depth, err = em.queue.depth(); err != nil || depth == 0 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), depth)
+	assert.Equal(t, "3", stats.Get(base.StatKeyEventQueueProcessedCount).String())
+
+	assert.NoError(t, em.RaiseDocumentChangeEvent(Body{BodyId: "doc4", "count": 4}, "", base.SetOf("ABC")))
+	select {
+	case body := <-resultChannel:
+		assert.Equal(t, "doc4", body[BodyId])
+	case <-time.After(10 * time.Second):
+		t.Fatal("Timed out waiting for new event")
+	}
+
+	_, err = newPersistentEventQueue(db.Bucket, PersistentEventQueueOptions{Overflow: "spill"})
+	assert.Error(t, err)
+}
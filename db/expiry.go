@@ -0,0 +1,112 @@
+package db
+
+import (
+	"time"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbase/sync_gateway/channels"
+)
+
+// Without xattrs, a document's sync metadata is deleted along with it when it expires, so nothing is left to tell
+// clients that it's gone.  Instead, whenever a document is written with an expiry, the revision and channels it
+// expires from are recorded in a separate tracking doc.  When the expiration arrives on the mutation feed, the
+// tracking doc is used to write a tombstone that removes the document from its channels.  (With xattrs the sync
+// metadata survives expiry, and the import feed tombstones the document instead.)
+const ExpiryTrackingKeyPrefix = KSyncKeyPrefix + "exp:"
+
+// How long a tracking doc outlives the document it tracks, to allow for the delay before Couchbase Server's expiry
+// pager deletes expired documents.
+const kExpiryTrackingGracePeriod = 24 * time.Hour
+
+// The state of a document when it was last written with an expiry.
+type expiryTrackingDoc struct {
+	RevID    string    `json:"rev"`      // The current revision
+	Channels []string  `json:"channels"` // The channels the document was in
+	Expiry   time.Time `json:"exp"`      // When the document expires
+}
+
+func expiryTrackingKey(docID string) string {
+	return ExpiryTrackingKeyPrefix + docID
+}
+
+// Records or clears the expiry tracking doc for a document that's just been written.  hadExpiry is whether the
+// previous revision had an expiry, and so may have been tracked.
+func (db *Database) updateExpiryTracking(doc *document, hadExpiry bool) {
+	if db.UseXattrs() {
+		return
+	}
+
+	key := expiryTrackingKey(doc.ID)
+	if doc.Expiry == nil || doc.hasFlag(channels.Deleted) {
+		if hadExpiry {
+			if err := db.Bucket.Delete(key); err != nil && !base.IsKeyNotFoundError(db.Bucket, err) {
+				base.WarnfCtx(db.Ctx, base.KeyAll, "Unable to remove expiry tracking for doc %q: %v", base.UDDocID(doc.ID), err)
+			}
+		}
+		return
+	}
+
+	tracking := expiryTrackingDoc{RevID: doc.CurrentRev, Expiry: *doc.Expiry}
+	for channel, removal := range doc.Channels {
+		if removal == nil {
+			tracking.Channels = append(tracking.Channels, channel)
+		}
+	}
+	trackingExpiry := uint32(doc.Expiry.Add(kExpiryTrackingGracePeriod).Unix())
+	if err := db.Bucket.Set(key, trackingExpiry, tracking); err != nil {
+		base.WarnfCtx(db.Ctx, base.KeyAll, "Unable to track expiry for doc %q - clients won't be notified when it expires: %v", base.UDDocID(doc.ID), err)
+	}
+}
+
+// Called when a document is deleted from the bucket without a tombstone being written.  If the document had expired,
+// writes a tombstone revision in its place, which removes it from the channels it was in.  Otherwise the document was
+// purged, or deleted by something other than Sync Gateway, and is ignored.  Returns true if a tombstone was written.
+func (context *DatabaseContext) tombstoneExpiredDoc(docID string) bool {
+	key := expiryTrackingKey(docID)
+	var tracking expiryTrackingDoc
+	if _, err := context.Bucket.Get(key, &tracking); err != nil {
+		return false
+	}
+	if tracking.Expiry.After(time.Now()) {
+		return false
+	}
+
+	db := Database{DatabaseContext: context}
+	generation, _ := ParseRevID(tracking.RevID)
+	tombstoneRevID := db.newRevID(generation+1, tracking.RevID, true, Body{})
+
+	newRevID, err := db.updateDoc(docID, false, 0, func(doc *document) (resultBody Body, resultAttachmentData AttachmentData, updatedExpiry *uint32, resultErr error) {
+		// (Be careful: this block can be invoked multiple times if there are races!)
+		if doc.CurrentRev != "" {
+			// Another node has already written the tombstone, or the doc has been recreated since it expired
+			return nil, nil, nil, base.ErrUpdateCancel
+		}
+
+		// Restore the expired revision, so that clients see the tombstone as its child, and its channels, so that
+		// the tombstone is recorded as a removal from them
+		doc.Channels = make(channels.ChannelMap, len(tracking.Channels))
+		for _, channel := range tracking.Channels {
+			doc.Channels[channel] = nil
+		}
+		if err := doc.History.addRevision(docID, RevInfo{ID: tracking.RevID, Channels: base.SetFromArray(tracking.Channels)}); err != nil {
+			return nil, nil, nil, err
+		}
+		if err := doc.History.addRevision(docID, RevInfo{ID: tombstoneRevID, Parent: tracking.RevID, Deleted: true}); err != nil {
+			return nil, nil, nil, err
+		}
+		return Body{BodyRev: tombstoneRevID, BodyDeleted: true}, nil, nil, nil
+	})
+	if err != nil {
+		base.Warnf(base.KeyAll, "Unable to write tombstone for expired doc %q - clients won't be notified that it's gone: %v", base.UDDocID(docID), err)
+		return false
+	} else if newRevID == "" {
+		return false
+	}
+
+	base.Infof(base.KeyCRUD, "Doc %q expired - wrote tombstone %s", base.UDDocID(docID), tombstoneRevID)
+	context.DbStats.StatsDatabase().Add(base.StatKeyExpiredTombstoneCount, 1)
+	if err := context.Bucket.Delete(key); err != nil && !base.IsKeyNotFoundError(context.Bucket, err) {
+		base.Warnf(base.KeyAll, "Unable to remove expiry tracking for doc %q: %v", base.UDDocID(docID), err)
+	}
+	return true
+}
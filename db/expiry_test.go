@@ -0,0 +1,70 @@
+package db
+
+import (
+	"expvar"
+	"testing"
+	"time"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbase/sync_gateway/channels"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTombstoneExpiredDoc(t *testing.T) {
+	if base.TestUseXattrs() {
+		t.Skip("Expired docs are tombstoned by import when using xattrs.  Skipping.")
+	}
+
+	db, testBucket := setupTestDB(t)
+	defer testBucket.Close()
+	defer tearDownTestDB(t, db)
+
+	rev1, err := db.Put("doc1", Body{"channels": []string{"ABC"}, BodyExpiry: int64(3600)})
+	assert.NoError(t, err)
+
+	var tracking expiryTrackingDoc
+	_, err = db.Bucket.Get(expiryTrackingKey("doc1"), &tracking)
+	assert.NoError(t, err)
+	assert.Equal(t, rev1, tracking.RevID)
+	assert.Equal(t, []string{"ABC"}, tracking.Channels)
+
+	// Updating the doc without an expiry stops tracking it
+	rev2, err := db.Put("doc1", Body{"channels": []string{"ABC"}, BodyRev: rev1})
+	assert.NoError(t, err)
+	_, err = db.Bucket.Get(expiryTrackingKey("doc1"), &tracking)
+	assert.True(t, base.IsKeyNotFoundError(db.Bucket, err))
+
+	// A doc that hasn't expired yet isn't tombstoned
+	rev3, err := db.Put("doc1", Body{"channels": []string{"ABC"}, BodyRev: rev2, BodyExpiry: int64(3600)})
+	assert.NoError(t, err)
+	assert.False(t, db.tombstoneExpiredDoc("doc1"))
+
+	// Simulate the doc expiring, by backdating its expiry and deleting it from the bucket
+	tracking.RevID = rev3
+	tracking.Channels = []string{"ABC"}
+	tracking.Expiry = time.Now().Add(-time.Minute)
+	assert.NoError(t, db.Bucket.Set(expiryTrackingKey("doc1"), 0, tracking))
+	assert.NoError(t, db.Bucket.Delete("doc1"))
+
+	// The deletion arrives on the mutation feed, and is replaced with a tombstone that removes the doc from its channels
+	var doc *document
+	for i := 0; i < 50; i++ {
+		if doc, err = db.GetDocument("doc1", DocUnmarshalAll); err == nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if assert.NoError(t, err) {
+		assert.True(t, doc.hasFlag(channels.Deleted))
+		assert.Equal(t, rev3, doc.History.getParent(doc.CurrentRev))
+		if assert.NotNil(t, doc.Channels["ABC"]) {
+			assert.True(t, doc.Channels["ABC"].Deleted)
+		}
+	}
+	_, err = db.Bucket.Get(expiryTrackingKey("doc1"), &tracking)
+	assert.True(t, base.IsKeyNotFoundError(db.Bucket, err))
+	assert.Equal(t, int64(1), db.DbStats.StatsDatabase().Get(base.StatKeyExpiredTombstoneCount).(*expvar.Int).Value())
+
+	// Once tombstoned, the doc isn't tombstoned again
+	assert.False(t, db.tombstoneExpiredDoc("doc1"))
+}
@@ -0,0 +1,63 @@
+// +build gofuzz
+
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"mime/multipart"
+)
+
+// Fuzz targets for parsers that consume untrusted input, for use with go-fuzz (github.com/dvyukov/go-fuzz):
+//
+//     go-fuzz-build -func FuzzSequenceID github.com/couchbase/sync_gateway/db
+//     go-fuzz -bin db-fuzz.zip -workdir fuzz/sequence_id
+//
+// Each target returns 1 if the input parsed (so the fuzzer should prioritize it), and 0 otherwise.  Panics are
+// reported by the fuzzer as crashers.
+
+// The boundary that FuzzMultipartDocument inputs are expected to use.
+const kFuzzMultipartBoundary = "fuzzboundary"
+
+// FuzzSequenceID fuzzes parsing of integer sequence IDs, both as plain strings (e.g. a _changes since parameter) and
+// as JSON.  Sequences that parse must survive a round trip through String.
+func FuzzSequenceID(data []byte) int {
+	seq, err := parseIntegerSequenceID(string(data))
+	if err != nil {
+		return 0
+	}
+	roundTripped, err := parseIntegerSequenceID(seq.String())
+	if err != nil {
+		panic(fmt.Sprintf("Unable to parse %q, formatted from %q: %v", seq.String(), data, err))
+	}
+	if roundTripped.TriggeredBy != seq.TriggeredBy || roundTripped.LowSeq != seq.LowSeq || roundTripped.Seq != seq.Seq {
+		panic(fmt.Sprintf("Sequence %q formatted as %q, which parses as %q", data, seq.String(), roundTripped.String()))
+	}
+
+	var unmarshalled SequenceID
+	if err := unmarshalled.UnmarshalJSON(data); err != nil {
+		return 0
+	}
+	return 1
+}
+
+// FuzzDenseBlock fuzzes deserialization of a dense channel index block, as read from the index bucket.
+func FuzzDenseBlock(data []byte) int {
+	if len(data) < DB_HEADER_LEN {
+		return 0
+	}
+	block := &DenseBlock{Key: "fuzz", value: data}
+	_ = block.GetAllEntries()
+	_ = block.getClock()
+	return 1
+}
+
+// FuzzMultipartDocument fuzzes parsing of a multipart/related document body with attachments, as sent to PUT/POST
+// doc endpoints and new_edits=false _bulk_docs.  Parts must be delimited by kFuzzMultipartBoundary.
+func FuzzMultipartDocument(data []byte) int {
+	reader := multipart.NewReader(bytes.NewReader(data), kFuzzMultipartBoundary)
+	if _, err := ReadMultipartDocument(reader, 0); err != nil {
+		return 0
+	}
+	return 1
+}
@@ -0,0 +1,683 @@
+package db
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// GraphQL support covers a subset of the June 2018 spec: schemas written in the schema definition language, with
+// object, enum and custom scalar types, and query and mutation operations using arguments, variables, aliases,
+// fragments and __typename.  Interfaces, unions, input objects, directives, subscriptions and introspection aren't
+// supported.
+
+// GraphQLError is an error in a GraphQL request, or in resolving one of its fields.
+type GraphQLError struct {
+	Message   string            `json:"message"`
+	Locations []GraphQLLocation `json:"locations,omitempty"`
+	Path      []interface{}     `json:"path,omitempty"` // Response keys and list indexes of the field that failed
+}
+
+func (e *GraphQLError) Error() string {
+	return e.Message
+}
+
+// GraphQLLocation is a position in the source of a GraphQL document.
+type GraphQLLocation struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// GraphQLErrors are the errors that made a request fail before any of its fields were resolved.
+type GraphQLErrors []*GraphQLError
+
+func (errs GraphQLErrors) Error() string {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Returns the location of the given offset in a GraphQL source.
+func graphQLLocation(source string, pos int) GraphQLLocation {
+	if pos > len(source) {
+		pos = len(source)
+	}
+	lines := strings.Split(source[:pos], "\n")
+	return GraphQLLocation{Line: len(lines), Column: len(lines[len(lines)-1]) + 1}
+}
+
+//////// Lexer and parser
+
+type gqlTokenKind int
+
+const (
+	gqlTokenEOF gqlTokenKind = iota
+	gqlTokenPunctuator
+	gqlTokenName
+	gqlTokenInt
+	gqlTokenFloat
+	gqlTokenString
+)
+
+type gqlToken struct {
+	kind  gqlTokenKind
+	value string // The punctuator, name or number, or the value of the string
+	pos   int    // Offset of the token in the source
+}
+
+var gqlNumberRegexp = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?`)
+var gqlLineTerminatorRegexp = regexp.MustCompile("\r\n|\n|\r")
+
+// Parses GraphQL documents and schemas.  The first error is kept, after which the parser only sees the end of the
+// source, so the parse functions can carry on without checking for errors at every step.
+type gqlParser struct {
+	source string
+	pos    int      // Offset of the next token
+	token  gqlToken // Current token
+	depth  int      // Nesting of the selection sets, list and object values and list types being parsed
+	err    *GraphQLError
+}
+
+func newGQLParser(source string) *gqlParser {
+	p := &gqlParser{source: source}
+	p.next()
+	return p
+}
+
+func (p *gqlParser) fail(pos int, format string, args ...interface{}) {
+	if p.err == nil {
+		p.err = &GraphQLError{
+			Message:   "Syntax error: " + fmt.Sprintf(format, args...),
+			Locations: []GraphQLLocation{graphQLLocation(p.source, pos)},
+		}
+	}
+	p.pos = len(p.source)
+	p.token = gqlToken{kind: gqlTokenEOF, pos: p.pos}
+}
+
+func (p *gqlParser) unexpected(expected string) {
+	var found string
+	switch p.token.kind {
+	case gqlTokenEOF:
+		found = "<EOF>"
+	case gqlTokenString:
+		found = "string"
+	case gqlTokenName:
+		found = "name " + strconv.Quote(p.token.value)
+	default:
+		found = strconv.Quote(p.token.value)
+	}
+	p.fail(p.token.pos, "Expected %s, found %s", expected, found)
+}
+
+// Enters a nested selection set, value or type, failing if that nests them too deeply, so that hostile input can't
+// exhaust the stack.  Every successful call must be paired with a call to leave.
+func (p *gqlParser) enter() bool {
+	if p.depth >= graphQLMaxNesting {
+		p.fail(p.token.pos, "Nested more than %d levels deep", graphQLMaxNesting)
+		return false
+	}
+	p.depth++
+	return true
+}
+
+func (p *gqlParser) leave() {
+	p.depth--
+}
+
+// Reads the next token.
+func (p *gqlParser) next() {
+	// Skip whitespace, commas and comments
+	for p.pos < len(p.source) {
+		c := p.source[p.pos]
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',' {
+			p.pos++
+		} else if c == '#' {
+			for p.pos < len(p.source) && p.source[p.pos] != '\n' && p.source[p.pos] != '\r' {
+				p.pos++
+			}
+		} else if strings.HasPrefix(p.source[p.pos:], "\uFEFF") {
+			p.pos += len("\uFEFF")
+		} else {
+			break
+		}
+	}
+
+	start := p.pos
+	if start >= len(p.source) {
+		p.token = gqlToken{kind: gqlTokenEOF, pos: start}
+		return
+	}
+	c := p.source[start]
+	switch {
+	case strings.HasPrefix(p.source[start:], "..."):
+		p.pos += 3
+		p.token = gqlToken{kind: gqlTokenPunctuator, value: "...", pos: start}
+	case strings.IndexByte("!$():=@[]{|}", c) >= 0:
+		p.pos++
+		p.token = gqlToken{kind: gqlTokenPunctuator, value: string(c), pos: start}
+	case isGQLNameStart(c):
+		for p.pos < len(p.source) && (isGQLNameStart(p.source[p.pos]) || isGQLDigit(p.source[p.pos])) {
+			p.pos++
+		}
+		p.token = gqlToken{kind: gqlTokenName, value: p.source[start:p.pos], pos: start}
+	case c == '-' || isGQLDigit(c):
+		number := gqlNumberRegexp.FindString(p.source[start:])
+		p.pos += len(number)
+		if number == "" || number == "-" || p.pos < len(p.source) && (p.source[p.pos] == '.' || isGQLNameStart(p.source[p.pos]) || isGQLDigit(p.source[p.pos])) {
+			p.fail(start, "Invalid number")
+			return
+		}
+		kind := gqlTokenInt
+		if strings.ContainsAny(number, ".eE") {
+			kind = gqlTokenFloat
+		}
+		p.token = gqlToken{kind: kind, value: number, pos: start}
+	case c == '"':
+		p.lexString()
+	default:
+		p.fail(start, "Unexpected character %q", c)
+	}
+}
+
+func isGQLNameStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isGQLDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// Reads a string or block string token.
+func (p *gqlParser) lexString() {
+	start := p.pos
+	if strings.HasPrefix(p.source[start:], `"""`) {
+		var raw strings.Builder
+		for p.pos = start + 3; ; {
+			if p.pos >= len(p.source) {
+				p.fail(start, "Unterminated string")
+				return
+			}
+			if strings.HasPrefix(p.source[p.pos:], `\"""`) {
+				raw.WriteString(`"""`)
+				p.pos += 4
+			} else if strings.HasPrefix(p.source[p.pos:], `"""`) {
+				p.pos += 3
+				break
+			} else {
+				raw.WriteByte(p.source[p.pos])
+				p.pos++
+			}
+		}
+		p.token = gqlToken{kind: gqlTokenString, value: blockStringValue(raw.String()), pos: start}
+		return
+	}
+
+	var value strings.Builder
+	for p.pos = start + 1; ; {
+		if p.pos >= len(p.source) || p.source[p.pos] == '\n' || p.source[p.pos] == '\r' {
+			p.fail(start, "Unterminated string")
+			return
+		}
+		c := p.source[p.pos]
+		if c == '"' {
+			p.pos++
+			break
+		} else if c < ' ' && c != '\t' {
+			p.fail(p.pos, "Invalid character in string")
+			return
+		} else if c != '\\' {
+			value.WriteByte(c)
+			p.pos++
+			continue
+		}
+		if p.pos+1 >= len(p.source) {
+			p.fail(start, "Unterminated string")
+			return
+		}
+		switch escaped := p.source[p.pos+1]; escaped {
+		case '"', '\\', '/':
+			value.WriteByte(escaped)
+		case 'b':
+			value.WriteByte('\b')
+		case 'f':
+			value.WriteByte('\f')
+		case 'n':
+			value.WriteByte('\n')
+		case 'r':
+			value.WriteByte('\r')
+		case 't':
+			value.WriteByte('\t')
+		case 'u':
+			if p.pos+6 > len(p.source) {
+				p.fail(p.pos, "Invalid escape sequence")
+				return
+			}
+			code, err := strconv.ParseUint(p.source[p.pos+2:p.pos+6], 16, 16)
+			if err != nil {
+				p.fail(p.pos, "Invalid escape sequence")
+				return
+			}
+			value.WriteRune(rune(code))
+			p.pos += 4
+		default:
+			p.fail(p.pos, "Invalid escape sequence")
+			return
+		}
+		p.pos += 2
+	}
+	p.token = gqlToken{kind: gqlTokenString, value: value.String(), pos: start}
+}
+
+// Removes the common indentation, and leading and trailing blank lines, of a block string.
+func blockStringValue(raw string) string {
+	lines := gqlLineTerminatorRegexp.Split(raw, -1)
+	commonIndent := -1
+	for _, line := range lines[1:] {
+		indent := len(line) - len(strings.TrimLeft(line, " \t"))
+		if indent < len(line) && (commonIndent < 0 || indent < commonIndent) {
+			commonIndent = indent
+		}
+	}
+	if commonIndent > 0 {
+		for i := 1; i < len(lines); i++ {
+			if len(lines[i]) >= commonIndent {
+				lines[i] = lines[i][commonIndent:]
+			} else {
+				lines[i] = ""
+			}
+		}
+	}
+	for len(lines) > 0 && strings.TrimLeft(lines[0], " \t") == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimLeft(lines[len(lines)-1], " \t") == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Returns whether the current token is the given punctuator.
+func (p *gqlParser) peek(punctuator string) bool {
+	return p.token.kind == gqlTokenPunctuator && p.token.value == punctuator
+}
+
+// Returns whether the current token is the given name, e.g. a keyword.
+func (p *gqlParser) peekName(name string) bool {
+	return p.token.kind == gqlTokenName && p.token.value == name
+}
+
+// Skips the current token if it's the given punctuator, returning whether it was.
+func (p *gqlParser) skip(punctuator string) bool {
+	if p.peek(punctuator) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *gqlParser) expect(punctuator string) {
+	if !p.skip(punctuator) {
+		p.unexpected(strconv.Quote(punctuator))
+	}
+}
+
+func (p *gqlParser) expectName() string {
+	if p.token.kind != gqlTokenName {
+		p.unexpected("a name")
+		return ""
+	}
+	name := p.token.value
+	p.next()
+	return name
+}
+
+// Returns whether there's another item before the given closing punctuator.
+func (p *gqlParser) more(closing string) bool {
+	if p.token.kind == gqlTokenEOF {
+		p.unexpected(strconv.Quote(closing))
+		return false
+	}
+	return !p.peek(closing)
+}
+
+// Fails on directives, which aren't supported.
+func (p *gqlParser) noDirectives() {
+	if p.peek("@") {
+		p.fail(p.token.pos, "Directives aren't supported")
+	}
+}
+
+// Skips a description, in a schema.
+func (p *gqlParser) skipDescription() {
+	if p.token.kind == gqlTokenString {
+		p.next()
+	}
+}
+
+// A reference to a type: a named type or a list, either of which may be non-null.
+type gqlType struct {
+	name    string   // Name of the type, unless it's a list
+	elem    *gqlType // Type of the elements of a list
+	nonNull bool
+}
+
+func (t *gqlType) String() string {
+	s := t.name
+	if t.elem != nil {
+		s = "[" + t.elem.String() + "]"
+	}
+	if t.nonNull {
+		s += "!"
+	}
+	return s
+}
+
+// Returns the named type at the bottom of any lists.
+func (t *gqlType) namedType() string {
+	for t.elem != nil {
+		t = t.elem
+	}
+	return t.name
+}
+
+// Returns whether the type is a list, or a non-null list.
+func (t *gqlType) isList() bool {
+	return t.elem != nil
+}
+
+// Returns the type without its non-null modifier.
+func (t *gqlType) nullable() *gqlType {
+	nullable := *t
+	nullable.nonNull = false
+	return &nullable
+}
+
+func (p *gqlParser) parseType() *gqlType {
+	var t *gqlType
+	if p.skip("[") {
+		if !p.enter() {
+			return &gqlType{}
+		}
+		t = &gqlType{elem: p.parseType()}
+		p.leave()
+		p.expect("]")
+	} else {
+		t = &gqlType{name: p.expectName()}
+	}
+	t.nonNull = p.skip("!")
+	return t
+}
+
+// Values in documents are parsed to int64, float64, string, bool, nil, []interface{} and map[string]interface{}, or
+// to these types for enum values and variables:
+type gqlEnumValue string
+type gqlVariable string
+
+// Parses a value.  Constant values can't contain variables.
+func (p *gqlParser) parseValue(constant bool) interface{} {
+	token := p.token
+	switch token.kind {
+	case gqlTokenPunctuator:
+		switch token.value {
+		case "$":
+			if constant {
+				p.fail(token.pos, "Variables can't be used here")
+				return nil
+			}
+			p.next()
+			return gqlVariable(p.expectName())
+		case "[":
+			p.next()
+			if !p.enter() {
+				return nil
+			}
+			defer p.leave()
+			list := []interface{}{}
+			for p.more("]") {
+				list = append(list, p.parseValue(constant))
+			}
+			p.expect("]")
+			return list
+		case "{":
+			p.next()
+			if !p.enter() {
+				return nil
+			}
+			defer p.leave()
+			object := map[string]interface{}{}
+			for p.more("}") {
+				namePos := p.token.pos
+				name := p.expectName()
+				if _, ok := object[name]; ok {
+					p.fail(namePos, "Duplicate field %q", name)
+				}
+				p.expect(":")
+				object[name] = p.parseValue(constant)
+			}
+			p.expect("}")
+			return object
+		}
+	case gqlTokenInt:
+		p.next()
+		value, err := strconv.ParseInt(token.value, 10, 64)
+		if err != nil {
+			p.fail(token.pos, "Invalid integer %s", token.value)
+		}
+		return value
+	case gqlTokenFloat:
+		p.next()
+		value, err := strconv.ParseFloat(token.value, 64)
+		if err != nil {
+			p.fail(token.pos, "Invalid number %s", token.value)
+		}
+		return value
+	case gqlTokenString:
+		p.next()
+		return token.value
+	case gqlTokenName:
+		p.next()
+		switch token.value {
+		case "true":
+			return true
+		case "false":
+			return false
+		case "null":
+			return nil
+		default:
+			return gqlEnumValue(token.value)
+		}
+	}
+	p.unexpected("a value")
+	return nil
+}
+
+//////// Documents
+
+// A parsed GraphQL request document.
+type gqlDocument struct {
+	source     string
+	operations []*gqlOperation
+	fragments  map[string]*gqlFragment
+}
+
+type gqlOperation struct {
+	kind       string // "query" or "mutation"
+	name       string
+	variables  []*gqlVariableDefinition
+	selections []gqlSelection
+	pos        int
+}
+
+type gqlVariableDefinition struct {
+	name         string
+	typ          *gqlType
+	defaultValue interface{}
+	hasDefault   bool
+	pos          int
+}
+
+// A selection is a *gqlField, *gqlFragmentSpread or *gqlInlineFragment.
+type gqlSelection interface{}
+
+type gqlField struct {
+	alias      string
+	name       string
+	arguments  map[string]interface{}
+	selections []gqlSelection // Nil for a leaf field
+	pos        int
+}
+
+// Returns the key of the field in the response.
+func (f *gqlField) responseKey() string {
+	if f.alias != "" {
+		return f.alias
+	}
+	return f.name
+}
+
+type gqlFragmentSpread struct {
+	name string
+	pos  int
+}
+
+type gqlInlineFragment struct {
+	typeCondition string // May be empty
+	selections    []gqlSelection
+	pos           int
+}
+
+type gqlFragment struct {
+	name          string
+	typeCondition string
+	selections    []gqlSelection
+	pos           int
+}
+
+// Parses a GraphQL request document.
+func parseGraphQLDocument(source string) (*gqlDocument, error) {
+	p := newGQLParser(source)
+	doc := &gqlDocument{source: source, fragments: map[string]*gqlFragment{}}
+	for p.token.kind != gqlTokenEOF {
+		pos := p.token.pos
+		switch {
+		case p.peek("{"):
+			doc.operations = append(doc.operations, &gqlOperation{kind: "query", selections: p.parseSelectionSet(), pos: pos})
+		case p.peekName("query"), p.peekName("mutation"):
+			doc.operations = append(doc.operations, p.parseOperation())
+		case p.peekName("subscription"):
+			p.fail(pos, "Subscriptions aren't supported")
+		case p.peekName("fragment"):
+			p.next()
+			fragment := &gqlFragment{pos: pos}
+			if p.peekName("on") {
+				p.unexpected("a fragment name")
+			}
+			fragment.name = p.expectName()
+			if !p.peekName("on") {
+				p.unexpected(`"on"`)
+			}
+			p.next()
+			fragment.typeCondition = p.expectName()
+			p.noDirectives()
+			fragment.selections = p.parseSelectionSet()
+			if _, ok := doc.fragments[fragment.name]; ok {
+				p.fail(pos, "Duplicate fragment %q", fragment.name)
+			}
+			doc.fragments[fragment.name] = fragment
+		default:
+			p.unexpected("an operation or fragment")
+		}
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	if len(doc.operations) == 0 {
+		return nil, &GraphQLError{Message: "Document has no operations"}
+	}
+	return doc, nil
+}
+
+func (p *gqlParser) parseOperation() *gqlOperation {
+	op := &gqlOperation{pos: p.token.pos, kind: p.expectName()}
+	if p.token.kind == gqlTokenName {
+		op.name = p.expectName()
+	}
+	if p.skip("(") {
+		for p.more(")") {
+			def := &gqlVariableDefinition{pos: p.token.pos}
+			p.expect("$")
+			def.name = p.expectName()
+			p.expect(":")
+			def.typ = p.parseType()
+			if p.skip("=") {
+				def.defaultValue, def.hasDefault = p.parseValue(true), true
+			}
+			op.variables = append(op.variables, def)
+		}
+		p.expect(")")
+	}
+	p.noDirectives()
+	op.selections = p.parseSelectionSet()
+	return op
+}
+
+func (p *gqlParser) parseSelectionSet() []gqlSelection {
+	pos := p.token.pos
+	if !p.enter() {
+		return nil
+	}
+	defer p.leave()
+	p.expect("{")
+	selections := []gqlSelection{}
+	for p.more("}") {
+		selectionPos := p.token.pos
+		if p.skip("...") {
+			if p.peekName("on") || p.peek("{") || p.peek("@") {
+				fragment := &gqlInlineFragment{pos: selectionPos}
+				if p.peekName("on") {
+					p.next()
+					fragment.typeCondition = p.expectName()
+				}
+				p.noDirectives()
+				fragment.selections = p.parseSelectionSet()
+				selections = append(selections, fragment)
+			} else {
+				selections = append(selections, &gqlFragmentSpread{name: p.expectName(), pos: selectionPos})
+				p.noDirectives()
+			}
+			continue
+		}
+
+		field := &gqlField{pos: selectionPos, name: p.expectName()}
+		if p.skip(":") {
+			field.alias, field.name = field.name, p.expectName()
+		}
+		if p.skip("(") {
+			field.arguments = map[string]interface{}{}
+			for p.more(")") {
+				argPos := p.token.pos
+				name := p.expectName()
+				if _, ok := field.arguments[name]; ok {
+					p.fail(argPos, "Duplicate argument %q", name)
+				}
+				p.expect(":")
+				field.arguments[name] = p.parseValue(false)
+			}
+			p.expect(")")
+		}
+		p.noDirectives()
+		if p.peek("{") {
+			field.selections = p.parseSelectionSet()
+		}
+		selections = append(selections, field)
+	}
+	p.expect("}")
+	if len(selections) == 0 {
+		p.fail(pos, "Selection set can't be empty")
+	}
+	return selections
+}
@@ -0,0 +1,559 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/couchbase/sync_gateway/base"
+)
+
+// Max depth of nested selection sets in a GraphQL operation, so that a request can't resolve an unbounded number of
+// documents by following references between them.
+const graphQLMaxDepth = 16
+
+// Max nesting of selection sets, list and object values and list types that the parser accepts.  It's well above
+// graphQLMaxDepth, so that it only stops pathological documents before they exhaust the stack.
+const graphQLMaxNesting = 4 * graphQLMaxDepth
+
+// Max length of a GraphQL request's query.
+const GraphQLMaxQueryLength = 64 * 1024
+
+// Limits on the documents a GraphQL request loads.  graphQLMaxDepth bounds how deep references between documents are
+// followed, but not how many documents are loaded at each level, which could grow exponentially with the depth.
+const (
+	GraphQLMaxListDocIDs = 100  // Max # of IDs in a list of documents to load
+	GraphQLMaxDocLoads   = 1000 // Max # of documents loaded by a request
+)
+
+// GraphQLOperation is a validated operation of a GraphQL request, ready to be executed.
+type GraphQLOperation struct {
+	schema    *GraphQLSchema
+	document  *gqlDocument
+	operation *gqlOperation
+	rootType  *gqlObjectType
+}
+
+// Returns whether the operation is a mutation, rather than a query.
+func (op *GraphQLOperation) IsMutation() bool {
+	return op.operation.kind == "mutation"
+}
+
+// GraphQLResult is the result of executing a GraphQL operation.
+type GraphQLResult struct {
+	Data   interface{}   // Nil if a non-null root field couldn't be resolved
+	Errors GraphQLErrors // Errors resolving fields
+}
+
+// Parses and validates a GraphQL request document, returning the operation to execute.  If the document has more
+// than one operation, the operation name picks which.  Any error is a GraphQLErrors.
+func (schema *GraphQLSchema) Prepare(source string, operationName string) (*GraphQLOperation, error) {
+	document, err := parseGraphQLDocument(source)
+	if err != nil {
+		return nil, GraphQLErrors{err.(*GraphQLError)}
+	}
+
+	var operation *gqlOperation
+	for _, op := range document.operations {
+		if operationName == "" && len(document.operations) == 1 || op.name == operationName && operationName != "" {
+			operation = op
+			break
+		}
+	}
+	if operation == nil {
+		if operationName == "" {
+			return nil, GraphQLErrors{{Message: "Document has more than one operation; an operation name must be given"}}
+		}
+		return nil, GraphQLErrors{{Message: fmt.Sprintf("Document has no operation %q", operationName)}}
+	}
+
+	op := &GraphQLOperation{schema: schema, document: document, operation: operation, rootType: schema.queryType}
+	v := &gqlValidator{schema: schema, document: document, variables: map[string]interface{}{}}
+	if op.IsMutation() {
+		if op.rootType = schema.mutationType; op.rootType == nil {
+			v.errorf(operation.pos, "Schema has no mutations")
+			return nil, v.errors
+		}
+	}
+	for _, def := range operation.variables {
+		if _, ok := v.variables[def.name]; ok {
+			v.errorf(def.pos, "Duplicate variable $%s", def.name)
+		} else if !schema.isInputType(def.typ) {
+			v.errorf(def.pos, "Variable $%s must have a scalar or enum type", def.name)
+		} else if def.hasDefault {
+			if _, err := schema.coerceInput(def.typ, def.defaultValue, nil, false); err != nil {
+				v.errorf(def.pos, "Invalid default value of $%s: %v", def.name, err)
+			}
+		}
+		v.variables[def.name] = gqlUnknownValue{}
+	}
+	v.validateSelections(op.rootType, operation.selections, 1, map[string]bool{})
+	if len(v.errors) == 0 {
+		v.checkConflicts(op.rootType, operation.selections)
+	}
+	if len(v.errors) > 0 {
+		return nil, v.errors
+	}
+	return op, nil
+}
+
+//////// Validation
+
+type gqlValidator struct {
+	schema    *GraphQLSchema
+	document  *gqlDocument
+	variables map[string]interface{} // gqlUnknownValue for each variable the operation defines
+	errors    GraphQLErrors
+}
+
+func (v *gqlValidator) errorf(pos int, format string, args ...interface{}) {
+	v.errors = append(v.errors, &GraphQLError{
+		Message:   fmt.Sprintf(format, args...),
+		Locations: []GraphQLLocation{graphQLLocation(v.document.source, pos)},
+	})
+}
+
+// Checks that the selections are valid for the given type.  visiting holds the fragments being spread, to catch
+// fragments that spread themselves.
+func (v *gqlValidator) validateSelections(object *gqlObjectType, selections []gqlSelection, depth int, visiting map[string]bool) {
+	for _, selection := range selections {
+		switch selection := selection.(type) {
+		case *gqlField:
+			v.validateField(object, selection, depth, visiting)
+		case *gqlFragmentSpread:
+			fragment := v.document.fragments[selection.name]
+			if fragment == nil {
+				v.errorf(selection.pos, "Unknown fragment %q", selection.name)
+			} else if visiting[fragment.name] {
+				v.errorf(selection.pos, "Fragment %q spreads itself", fragment.name)
+			} else if v.checkTypeCondition(object, fragment.typeCondition, selection.pos) {
+				visiting[fragment.name] = true
+				v.validateSelections(object, fragment.selections, depth, visiting)
+				delete(visiting, fragment.name)
+			}
+		case *gqlInlineFragment:
+			if selection.typeCondition == "" || v.checkTypeCondition(object, selection.typeCondition, selection.pos) {
+				v.validateSelections(object, selection.selections, depth, visiting)
+			}
+		}
+	}
+}
+
+// Checks that a fragment with the given type condition can be spread in a selection set of the given type.  As the
+// only composite types are object types, the type condition has to be the type itself.
+func (v *gqlValidator) checkTypeCondition(object *gqlObjectType, typeCondition string, pos int) bool {
+	if v.schema.objects[typeCondition] == nil {
+		v.errorf(pos, "Unknown type %q", typeCondition)
+		return false
+	} else if typeCondition != object.name {
+		v.errorf(pos, "Fragment on type %q can't be spread within type %q", typeCondition, object.name)
+		return false
+	}
+	return true
+}
+
+func (v *gqlValidator) validateField(object *gqlObjectType, field *gqlField, depth int, visiting map[string]bool) {
+	if field.name == "__typename" {
+		if len(field.arguments) > 0 || field.selections != nil {
+			v.errorf(field.pos, "Field __typename can't have arguments or subfields")
+		}
+		return
+	}
+	def := object.fields[field.name]
+	if def == nil {
+		v.errorf(field.pos, "Type %q has no field %q", object.name, field.name)
+		return
+	}
+	for name, value := range field.arguments {
+		arg := def.args[name]
+		if arg == nil {
+			v.errorf(field.pos, "Field %s.%s has no argument %q", object.name, field.name, name)
+		} else if _, err := v.schema.coerceInput(arg.typ, value, v.variables, true); err != nil {
+			v.errorf(field.pos, "Invalid argument %q of field %q: %v", name, field.name, err)
+		}
+	}
+	for name, arg := range def.args {
+		if _, given := field.arguments[name]; !given && arg.typ.nonNull && !arg.hasDefault {
+			v.errorf(field.pos, "Field %q needs argument %q", field.name, name)
+		}
+	}
+
+	if fieldObject := v.schema.objects[def.typ.namedType()]; fieldObject != nil {
+		if field.selections == nil {
+			v.errorf(field.pos, "Field %q of type %s must have a selection of subfields", field.name, def.typ)
+			return
+		}
+		if depth >= graphQLMaxDepth {
+			v.errorf(field.pos, "Selections can't be nested more than %d levels deep", graphQLMaxDepth)
+			return
+		}
+		v.validateSelections(fieldObject, field.selections, depth+1, visiting)
+	} else if field.selections != nil {
+		v.errorf(field.pos, "Field %q of type %s can't have a selection of subfields", field.name, def.typ)
+	}
+}
+
+// Checks that the fields selected with the same response key, which are merged, are the same field with the same
+// arguments.
+func (v *gqlValidator) checkConflicts(object *gqlObjectType, selections []gqlSelection) {
+	keys, fields := collectGraphQLFields(v.document, selections)
+	for _, key := range keys {
+		group := fields[key]
+		for _, field := range group[1:] {
+			if field.name != group[0].name || !reflect.DeepEqual(field.arguments, group[0].arguments) {
+				v.errorf(field.pos, "Fields %q conflict, as they select different fields or arguments", key)
+				return
+			}
+		}
+		if def := object.fields[group[0].name]; def != nil {
+			if fieldObject := v.schema.objects[def.typ.namedType()]; fieldObject != nil {
+				v.checkConflicts(fieldObject, mergedGraphQLSelections(group))
+			}
+		}
+	}
+}
+
+// Collects the fields of a selection set, including those of its fragments, by response key.  Returns the response
+// keys in the order they were first selected.  The selections must be valid.
+func collectGraphQLFields(document *gqlDocument, selections []gqlSelection) (keys []string, fields map[string][]*gqlField) {
+	fields = map[string][]*gqlField{}
+	var collect func(selections []gqlSelection)
+	collect = func(selections []gqlSelection) {
+		for _, selection := range selections {
+			switch selection := selection.(type) {
+			case *gqlField:
+				key := selection.responseKey()
+				if _, ok := fields[key]; !ok {
+					keys = append(keys, key)
+				}
+				fields[key] = append(fields[key], selection)
+			case *gqlFragmentSpread:
+				collect(document.fragments[selection.name].selections)
+			case *gqlInlineFragment:
+				collect(selection.selections)
+			}
+		}
+	}
+	collect(selections)
+	return keys, fields
+}
+
+// Returns the selections of all of the fields with the same response key.
+func mergedGraphQLSelections(fields []*gqlField) []gqlSelection {
+	if len(fields) == 1 {
+		return fields[0].selections
+	}
+	var selections []gqlSelection
+	for _, field := range fields {
+		selections = append(selections, field.selections...)
+	}
+	return selections
+}
+
+//////// Execution
+
+// The result of a selection set, which keeps its fields in the order they were selected.
+type gqlObject []gqlObjectField
+
+type gqlObjectField struct {
+	key   string
+	value interface{}
+}
+
+func (object gqlObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, field := range object {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		keyJSON, _ := json.Marshal(field.key)
+		buf.Write(keyJSON)
+		buf.WriteByte(':')
+		valueJSON, err := json.Marshal(field.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(valueJSON)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+type gqlExecution struct {
+	db        *Database
+	op        *GraphQLOperation
+	variables map[string]interface{} // Coerced values of the variables that were given or have defaults
+	errors    GraphQLErrors
+	docLoads  int // # of documents loaded so far, limited to GraphQLMaxDocLoads
+}
+
+// Executes a GraphQL operation on behalf of the database's user, with the given variable values.  The returned error
+// is a GraphQLErrors, if the variables are invalid.  Errors resolving fields are returned in the result, with the
+// fields that failed resolved to null.
+func (db *Database) ExecuteGraphQL(op *GraphQLOperation, variables map[string]interface{}) (*GraphQLResult, error) {
+	e := &gqlExecution{db: db, op: op, variables: map[string]interface{}{}}
+	var errs GraphQLErrors
+	for _, def := range op.operation.variables {
+		value, given := variables[def.name]
+		if !given && def.hasDefault {
+			value, given = def.defaultValue, true
+		}
+		if !given {
+			if def.typ.nonNull {
+				errs = append(errs, e.newError(def.pos, nil, "Variable $%s of type %s must be given", def.name, def.typ))
+			}
+			continue
+		}
+		coerced, err := op.schema.coerceInput(def.typ, value, nil, false)
+		if err != nil {
+			errs = append(errs, e.newError(def.pos, nil, "Invalid value of variable $%s: %v", def.name, err))
+			continue
+		}
+		e.variables[def.name] = coerced
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	base.Debugf(base.KeyQuery, "Executing GraphQL %s %q", op.operation.kind, base.MD(op.operation.name))
+	data, ok := e.executeSelections(op.rootType, nil, op.operation.selections, nil)
+	result := &GraphQLResult{Errors: e.errors}
+	if ok {
+		result.Data = data
+	}
+	return result, nil
+}
+
+func (e *gqlExecution) newError(pos int, path []interface{}, format string, args ...interface{}) *GraphQLError {
+	return &GraphQLError{
+		Message:   fmt.Sprintf(format, args...),
+		Locations: []GraphQLLocation{graphQLLocation(e.op.document.source, pos)},
+		Path:      path,
+	}
+}
+
+// Returns a copy of a path with another element appended, so that paths don't share backing arrays.
+func appendGraphQLPath(path []interface{}, element interface{}) []interface{} {
+	return append(path[:len(path):len(path)], element)
+}
+
+// Executes a selection set on an object, returning false if it resolved to null because of an error.
+func (e *gqlExecution) executeSelections(object *gqlObjectType, parent map[string]interface{}, selections []gqlSelection, path []interface{}) (gqlObject, bool) {
+	keys, fields := collectGraphQLFields(e.op.document, selections)
+	result := make(gqlObject, 0, len(keys))
+	for _, key := range keys {
+		group := fields[key]
+		if group[0].name == "__typename" {
+			result = append(result, gqlObjectField{key: key, value: object.name})
+			continue
+		}
+		value, ok := e.executeField(object.fields[group[0].name], parent, group, appendGraphQLPath(path, key))
+		if !ok {
+			return nil, false
+		}
+		result = append(result, gqlObjectField{key: key, value: value})
+	}
+	return result, true
+}
+
+// Resolves a field, returning false if it's non-null but resolved to null because of an error.
+func (e *gqlExecution) executeField(def *gqlFieldDefinition, parent map[string]interface{}, fields []*gqlField, path []interface{}) (interface{}, bool) {
+	field := fields[0]
+	value, err := e.resolve(def, parent, field)
+	if err != nil {
+		_, message := base.ErrorAsHTTPStatus(err)
+		e.errors = append(e.errors, e.newError(field.pos, path, "%s", message))
+		return nil, !def.typ.nonNull
+	}
+	return e.completeValue(def.typ, mergedGraphQLSelections(fields), value, field.pos, path)
+}
+
+// Coerces a resolved value to the field's type.  Returns false if the value couldn't be coerced, or the type is
+// non-null and the value resolved to null, in which case the error has been added.
+func (e *gqlExecution) completeValue(t *gqlType, selections []gqlSelection, value interface{}, pos int, path []interface{}) (interface{}, bool) {
+	if t.nonNull {
+		if value == nil {
+			e.errors = append(e.errors, e.newError(pos, path, "Non-null field resolved to null"))
+			return nil, false
+		}
+		return e.completeNonNullValue(t.nullable(), selections, value, pos, path)
+	}
+	if value == nil {
+		return nil, true
+	}
+	completed, ok := e.completeNonNullValue(t, selections, value, pos, path)
+	if !ok {
+		return nil, true
+	}
+	return completed, true
+}
+
+func (e *gqlExecution) completeNonNullValue(t *gqlType, selections []gqlSelection, value interface{}, pos int, path []interface{}) (interface{}, bool) {
+	if t.elem != nil {
+		list, ok := value.([]interface{})
+		if !ok {
+			e.errors = append(e.errors, e.newError(pos, path, "Can't return %s as type %s", gqlValueString(value), t))
+			return nil, false
+		}
+		completed := make([]interface{}, len(list))
+		for i, item := range list {
+			if completed[i], ok = e.completeValue(t.elem, selections, item, pos, appendGraphQLPath(path, i)); !ok {
+				return nil, false
+			}
+		}
+		return completed, true
+	}
+
+	if object := e.op.schema.objects[t.name]; object != nil {
+		var properties map[string]interface{}
+		switch value := value.(type) {
+		case map[string]interface{}:
+			properties = value
+		case Body:
+			properties = value
+		default:
+			e.errors = append(e.errors, e.newError(pos, path, "Can't return %s as type %s", gqlValueString(value), t))
+			return nil, false
+		}
+		return e.executeSelections(object, properties, selections, path)
+	}
+
+	if values := e.op.schema.enums[t.name]; values != nil {
+		if name, ok := value.(string); ok && values[name] {
+			return name, true
+		}
+		e.errors = append(e.errors, e.newError(pos, path, "Can't return %s as type %s", gqlValueString(value), t))
+		return nil, false
+	}
+
+	completed, err := coerceGraphQLOutput(t.name, value)
+	if err != nil {
+		e.errors = append(e.errors, e.newError(pos, path, "%v", err))
+		return nil, false
+	}
+	return completed, true
+}
+
+// Coerces the arguments given to a field, and those with default values, to their types.
+func (e *gqlExecution) coerceArguments(def *gqlFieldDefinition, field *gqlField) (map[string]interface{}, error) {
+	args := make(map[string]interface{}, len(def.args))
+	for name, arg := range def.args {
+		value, given := field.arguments[name]
+		if variable, ok := value.(gqlVariable); ok {
+			value, given = e.variables[string(variable)]
+		}
+		if !given {
+			if arg.hasDefault {
+				args[name] = arg.defaultValue
+			} else if arg.typ.nonNull {
+				return nil, fmt.Errorf("Argument %q of type %s must be given", name, arg.typ)
+			}
+			continue
+		}
+		coerced, err := e.op.schema.coerceInput(arg.typ, value, e.variables, false)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid argument %q: %v", name, err)
+		}
+		args[name] = coerced
+	}
+	return args, nil
+}
+
+// Resolves a field of the given parent object, with its resolver or else the parent's property.
+func (e *gqlExecution) resolve(def *gqlFieldDefinition, parent map[string]interface{}, field *gqlField) (interface{}, error) {
+	args, err := e.coerceArguments(def, field)
+	if err != nil {
+		return nil, err
+	}
+	resolver := def.resolver
+	if resolver == nil {
+		return parent[def.name], nil
+	}
+
+	// Arguments the resolver needs that the field doesn't have are taken from the parent's properties
+	value := func(name string) (interface{}, bool) {
+		if _, isArg := def.args[name]; isArg {
+			return args[name], true
+		}
+		v, ok := parent[name]
+		return v, ok
+	}
+
+	switch {
+	case resolver.GetDoc != "":
+		docIDs, _ := value(resolver.GetDoc)
+		if !def.typ.isList() {
+			return e.getDoc(docIDs)
+		}
+		if docIDs == nil {
+			return nil, nil
+		}
+		list, ok := docIDs.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Document IDs must be a list")
+		}
+		if len(list) > GraphQLMaxListDocIDs {
+			return nil, fmt.Errorf("Too many document IDs; at most %d can be given", GraphQLMaxListDocIDs)
+		}
+		docs := make([]interface{}, len(list))
+		for i, docID := range list {
+			if docs[i], err = e.getDoc(docID); err != nil {
+				return nil, err
+			}
+		}
+		return docs, nil
+
+	case resolver.Query != "":
+		query := e.db.Options.NamedQueries[resolver.Query]
+		if query == nil {
+			return nil, fmt.Errorf("No such query %q", resolver.Query)
+		}
+		params := make(map[string]interface{}, len(query.Parameters))
+		for _, name := range query.Parameters {
+			if v, ok := value(name); ok {
+				params[name] = v
+			}
+		}
+		rows, err := e.db.QueryNamed(resolver.Query, params)
+		if err != nil {
+			return nil, err
+		}
+		if def.typ.isList() {
+			return rows, nil
+		} else if len(rows) > 0 {
+			return rows[0], nil
+		}
+		return nil, nil
+
+	default:
+		argsJSON, err := json.Marshal(args)
+		if err != nil {
+			return nil, err
+		}
+		return e.db.CallUserFunction(resolver.Function, argsJSON)
+	}
+}
+
+// Returns the body of the document with the given ID, or nil if there's no such document.
+func (e *gqlExecution) getDoc(docID interface{}) (interface{}, error) {
+	if docID == nil {
+		return nil, nil
+	}
+	id, ok := docID.(string)
+	if !ok {
+		return nil, fmt.Errorf("Document ID must be a string")
+	}
+	if e.docLoads >= GraphQLMaxDocLoads {
+		return nil, fmt.Errorf("Request loads too many documents; at most %d can be loaded", GraphQLMaxDocLoads)
+	}
+	e.docLoads++
+	body, err := e.db.Get(id)
+	if base.IsDocNotFoundError(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}(body), nil
+}
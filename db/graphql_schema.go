@@ -0,0 +1,464 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// GraphQLSchema is a GraphQL schema whose fields are resolved from the database.  Fields with resolvers get
+// documents, named query rows or user function results; other fields are the properties, of the same name, of their
+// parent objects.
+type GraphQLSchema struct {
+	objects      map[string]*gqlObjectType
+	enums        map[string]map[string]bool // Values of each enum type
+	scalars      map[string]bool            // Built-in and custom scalars
+	queryType    *gqlObjectType
+	mutationType *gqlObjectType // Nil if the schema has no mutations
+}
+
+// GraphQLResolver says how a field is resolved.  Exactly one of its properties is set.
+type GraphQLResolver struct {
+	GetDoc   string // The argument of the field, or else the property of its parent, holding the ID of the document (or for a list, the IDs of the documents) to return
+	Query    string // Named query whose rows to return, or whose first row to return if the field isn't a list
+	Function string // User function whose result to return
+}
+
+type gqlObjectType struct {
+	name   string
+	fields map[string]*gqlFieldDefinition
+}
+
+type gqlFieldDefinition struct {
+	name     string
+	args     map[string]*gqlArgumentDefinition
+	typ      *gqlType
+	resolver *GraphQLResolver
+}
+
+type gqlArgumentDefinition struct {
+	name         string
+	typ          *gqlType
+	defaultValue interface{} // Coerced to the argument's type
+	hasDefault   bool
+}
+
+var gqlBuiltinScalars = []string{"String", "Int", "Float", "Boolean", "ID"}
+
+// NewGraphQLSchema parses a schema written in the GraphQL schema definition language, and attaches the resolvers to
+// its fields.  The resolvers are given by type name, then field name; every field of the query and mutation types
+// needs one.
+func NewGraphQLSchema(source string, resolvers map[string]map[string]*GraphQLResolver) (*GraphQLSchema, error) {
+	schema := &GraphQLSchema{
+		objects: map[string]*gqlObjectType{},
+		enums:   map[string]map[string]bool{},
+		scalars: map[string]bool{},
+	}
+	for _, name := range gqlBuiltinScalars {
+		schema.scalars[name] = true
+	}
+	type argumentDefault struct {
+		arg   *gqlArgumentDefinition
+		value interface{}
+		path  string
+	}
+	var defaults []argumentDefault
+	rootTypes := map[string]string{}
+
+	p := newGQLParser(source)
+	defined := func(pos int, name string) {
+		if schema.objects[name] != nil || schema.enums[name] != nil || schema.scalars[name] {
+			p.fail(pos, "Duplicate type %q", name)
+		} else if strings.HasPrefix(name, "__") {
+			p.fail(pos, "Name %q is reserved", name)
+		}
+	}
+	for p.token.kind != gqlTokenEOF {
+		p.skipDescription()
+		pos := p.token.pos
+		switch {
+		case p.peekName("schema"):
+			p.next()
+			p.noDirectives()
+			p.expect("{")
+			for p.more("}") {
+				opPos := p.token.pos
+				operation := p.expectName()
+				p.expect(":")
+				typeName := p.expectName()
+				if operation != "query" && operation != "mutation" {
+					p.fail(opPos, "%s operations aren't supported", operation)
+				} else if _, ok := rootTypes[operation]; ok {
+					p.fail(opPos, "Duplicate %s type", operation)
+				}
+				rootTypes[operation] = typeName
+			}
+			p.expect("}")
+		case p.peekName("type"):
+			p.next()
+			object := &gqlObjectType{name: p.expectName(), fields: map[string]*gqlFieldDefinition{}}
+			defined(pos, object.name)
+			if p.peekName("implements") {
+				p.fail(p.token.pos, "Interfaces aren't supported")
+			}
+			p.noDirectives()
+			p.expect("{")
+			for p.more("}") {
+				p.skipDescription()
+				fieldPos := p.token.pos
+				field := &gqlFieldDefinition{name: p.expectName(), args: map[string]*gqlArgumentDefinition{}}
+				if _, ok := object.fields[field.name]; ok {
+					p.fail(fieldPos, "Duplicate field %s.%s", object.name, field.name)
+				} else if strings.HasPrefix(field.name, "__") {
+					p.fail(fieldPos, "Name %q is reserved", field.name)
+				}
+				if p.skip("(") {
+					for p.more(")") {
+						p.skipDescription()
+						argPos := p.token.pos
+						arg := &gqlArgumentDefinition{name: p.expectName()}
+						if _, ok := field.args[arg.name]; ok {
+							p.fail(argPos, "Duplicate argument %s.%s(%s)", object.name, field.name, arg.name)
+						}
+						p.expect(":")
+						arg.typ = p.parseType()
+						if p.skip("=") {
+							path := fmt.Sprintf("%s.%s(%s)", object.name, field.name, arg.name)
+							defaults = append(defaults, argumentDefault{arg: arg, value: p.parseValue(true), path: path})
+							arg.hasDefault = true
+						}
+						p.noDirectives()
+						field.args[arg.name] = arg
+					}
+					p.expect(")")
+				}
+				p.expect(":")
+				field.typ = p.parseType()
+				p.noDirectives()
+				object.fields[field.name] = field
+			}
+			p.expect("}")
+			schema.objects[object.name] = object
+		case p.peekName("enum"):
+			p.next()
+			name := p.expectName()
+			defined(pos, name)
+			p.noDirectives()
+			values := map[string]bool{}
+			p.expect("{")
+			for p.more("}") {
+				p.skipDescription()
+				valuePos := p.token.pos
+				value := p.expectName()
+				if value == "true" || value == "false" || value == "null" || values[value] {
+					p.fail(valuePos, "Invalid enum value %q", value)
+				}
+				p.noDirectives()
+				values[value] = true
+			}
+			p.expect("}")
+			schema.enums[name] = values
+		case p.peekName("scalar"):
+			p.next()
+			name := p.expectName()
+			defined(pos, name)
+			p.noDirectives()
+			schema.scalars[name] = true
+		case p.peekName("interface"), p.peekName("union"), p.peekName("input"), p.peekName("extend"), p.peekName("directive"):
+			p.fail(pos, "%s definitions aren't supported", p.token.value)
+		default:
+			p.unexpected("a type definition")
+		}
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+
+	// Check the types that the fields and arguments refer to
+	for _, object := range schema.objects {
+		for _, field := range object.fields {
+			if !schema.isType(field.typ.namedType()) {
+				return nil, fmt.Errorf("Unknown type %q of field %s.%s", field.typ.namedType(), object.name, field.name)
+			}
+			for _, arg := range field.args {
+				if !schema.isInputType(arg.typ) {
+					return nil, fmt.Errorf("Argument %s.%s(%s) must have a scalar or enum type", object.name, field.name, arg.name)
+				}
+			}
+		}
+	}
+	for _, d := range defaults {
+		var err error
+		if d.arg.defaultValue, err = schema.coerceInput(d.arg.typ, d.value, nil, false); err != nil {
+			return nil, fmt.Errorf("Invalid default value of %s: %v", d.path, err)
+		}
+	}
+
+	// Find the root types.  By default they're the types called Query and Mutation
+	queryTypeName, ok := rootTypes["query"]
+	if !ok {
+		queryTypeName = "Query"
+	}
+	if schema.queryType = schema.objects[queryTypeName]; schema.queryType == nil {
+		return nil, fmt.Errorf("Schema has no query type %q", queryTypeName)
+	}
+	if mutationTypeName, ok := rootTypes["mutation"]; ok {
+		if schema.mutationType = schema.objects[mutationTypeName]; schema.mutationType == nil {
+			return nil, fmt.Errorf("Schema has no mutation type %q", mutationTypeName)
+		}
+	} else {
+		schema.mutationType = schema.objects["Mutation"]
+	}
+
+	for typeName, fieldResolvers := range resolvers {
+		object := schema.objects[typeName]
+		if object == nil {
+			return nil, fmt.Errorf("Resolvers given for unknown type %q", typeName)
+		}
+		for fieldName, resolver := range fieldResolvers {
+			field := object.fields[fieldName]
+			if field == nil {
+				return nil, fmt.Errorf("Resolver given for unknown field %s.%s", typeName, fieldName)
+			}
+			if err := schema.checkResolver(object, field, resolver); err != nil {
+				return nil, fmt.Errorf("Invalid resolver of %s.%s: %v", typeName, fieldName, err)
+			}
+			field.resolver = resolver
+		}
+	}
+	for _, root := range []*gqlObjectType{schema.queryType, schema.mutationType} {
+		if root == nil {
+			continue
+		}
+		for _, field := range root.fields {
+			if field.resolver == nil {
+				return nil, fmt.Errorf("Field %s.%s has no resolver", root.name, field.name)
+			}
+		}
+	}
+	return schema, nil
+}
+
+func (schema *GraphQLSchema) checkResolver(object *gqlObjectType, field *gqlFieldDefinition, resolver *GraphQLResolver) error {
+	kinds := 0
+	for _, name := range []string{resolver.GetDoc, resolver.Query, resolver.Function} {
+		if name != "" {
+			kinds++
+		}
+	}
+	if kinds != 1 {
+		return fmt.Errorf("exactly one of get_doc, query and function must be given")
+	}
+	isRoot := object == schema.queryType || object == schema.mutationType
+	if resolver.GetDoc != "" {
+		if _, isArg := field.args[resolver.GetDoc]; !isArg && isRoot {
+			return fmt.Errorf("field has no argument %q", resolver.GetDoc)
+		}
+		if _, isObject := schema.objects[field.typ.namedType()]; !isObject {
+			return fmt.Errorf("documents can only be returned as object types")
+		}
+	}
+	return nil
+}
+
+// Returns whether the given name is a type of the schema.
+func (schema *GraphQLSchema) isType(name string) bool {
+	return schema.objects[name] != nil || schema.enums[name] != nil || schema.scalars[name]
+}
+
+// Returns whether values of the given type can be given as arguments and variables.
+func (schema *GraphQLSchema) isInputType(t *gqlType) bool {
+	name := t.namedType()
+	return schema.enums[name] != nil || schema.scalars[name]
+}
+
+// Placeholder for the values of variables while a document is validated
+type gqlUnknownValue struct{}
+
+// Coerces a value given for an argument or variable to its type, returning the value to resolve fields with.  The
+// value can be a literal from a document, which may refer to the given variables, or one decoded from JSON.  When
+// validating, referring to a variable that isn't defined is an error.
+func (schema *GraphQLSchema) coerceInput(t *gqlType, value interface{}, variables map[string]interface{}, validating bool) (interface{}, error) {
+	if variable, ok := value.(gqlVariable); ok {
+		variableValue, defined := variables[string(variable)]
+		if !defined && validating {
+			return nil, fmt.Errorf("Variable $%s is not defined", variable)
+		}
+		if _, unknown := variableValue.(gqlUnknownValue); unknown {
+			return nil, nil
+		}
+		value = variableValue
+	}
+	if value == nil {
+		if t.nonNull {
+			return nil, fmt.Errorf("Expected a value of type %s, found null", t)
+		}
+		return nil, nil
+	}
+
+	if t.elem != nil {
+		list, ok := value.([]interface{})
+		if !ok {
+			// A single value is coerced to a list of one
+			item, err := schema.coerceInput(t.elem, value, variables, validating)
+			if err != nil {
+				return nil, err
+			}
+			return []interface{}{item}, nil
+		}
+		coerced := make([]interface{}, len(list))
+		for i, item := range list {
+			var err error
+			if coerced[i], err = schema.coerceInput(t.elem, item, variables, validating); err != nil {
+				return nil, err
+			}
+		}
+		return coerced, nil
+	}
+
+	if values := schema.enums[t.name]; values != nil {
+		var name string
+		switch value := value.(type) {
+		case gqlEnumValue:
+			name = string(value)
+		case string:
+			name = value
+		}
+		if !values[name] {
+			return nil, fmt.Errorf("Expected a value of type %s, found %s", t.name, gqlValueString(value))
+		}
+		return name, nil
+	}
+
+	switch t.name {
+	case "Int":
+		if number, ok := gqlNumber(value); ok && number == math.Trunc(number) && number >= math.MinInt32 && number <= math.MaxInt32 {
+			return int64(number), nil
+		}
+	case "Float":
+		if number, ok := gqlNumber(value); ok {
+			return number, nil
+		}
+	case "String":
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+	case "Boolean":
+		if b, ok := value.(bool); ok {
+			return b, nil
+		}
+	case "ID":
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+		if number, ok := gqlNumber(value); ok && number == math.Trunc(number) {
+			return strconv.FormatFloat(number, 'f', -1, 64), nil
+		}
+	default:
+		// Custom scalars take any value
+		return gqlPlainValue(value, variables), nil
+	}
+	return nil, fmt.Errorf("Expected a value of type %s, found %s", t.name, gqlValueString(value))
+}
+
+// Coerces a field's result to a scalar type.
+func coerceGraphQLOutput(scalar string, value interface{}) (interface{}, error) {
+	switch scalar {
+	case "Int":
+		if number, ok := gqlNumber(value); ok && number == math.Trunc(number) && number >= math.MinInt32 && number <= math.MaxInt32 {
+			return int64(number), nil
+		}
+	case "Float":
+		if number, ok := gqlNumber(value); ok {
+			return number, nil
+		}
+	case "String":
+		switch value := value.(type) {
+		case string:
+			return value, nil
+		case bool:
+			return strconv.FormatBool(value), nil
+		}
+		if number, ok := gqlNumber(value); ok {
+			return strconv.FormatFloat(number, 'f', -1, 64), nil
+		}
+	case "Boolean":
+		if b, ok := value.(bool); ok {
+			return b, nil
+		}
+	case "ID":
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+		if number, ok := gqlNumber(value); ok && number == math.Trunc(number) {
+			return strconv.FormatFloat(number, 'f', -1, 64), nil
+		}
+	default:
+		return value, nil
+	}
+	return nil, fmt.Errorf("Can't return %s as type %s", gqlValueString(value), scalar)
+}
+
+// Returns the value of a number, whether it's from a document or decoded from JSON.
+func gqlNumber(value interface{}) (float64, bool) {
+	switch value := value.(type) {
+	case float64:
+		return value, true
+	case int64:
+		return float64(value), true
+	case int:
+		return float64(value), true
+	case uint64:
+		return float64(value), true
+	case json.Number:
+		number, err := value.Float64()
+		return number, err == nil
+	}
+	return 0, false
+}
+
+// Converts a literal from a document to the value it would be decoded from JSON as.
+func gqlPlainValue(value interface{}, variables map[string]interface{}) interface{} {
+	switch value := value.(type) {
+	case gqlVariable:
+		variableValue := variables[string(value)]
+		if _, unknown := variableValue.(gqlUnknownValue); unknown {
+			return nil
+		}
+		return variableValue
+	case gqlEnumValue:
+		return string(value)
+	case []interface{}:
+		plain := make([]interface{}, len(value))
+		for i, item := range value {
+			plain[i] = gqlPlainValue(item, variables)
+		}
+		return plain
+	case map[string]interface{}:
+		plain := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			plain[key] = gqlPlainValue(item, variables)
+		}
+		return plain
+	}
+	return value
+}
+
+// Describes a value in an error message.
+func gqlValueString(value interface{}) string {
+	switch value := value.(type) {
+	case gqlEnumValue:
+		return string(value)
+	case map[string]interface{}:
+		return "an object"
+	case []interface{}:
+		return "a list"
+	}
+	valueJSON, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(valueJSON)
+}
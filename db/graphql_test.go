@@ -0,0 +1,294 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testGraphQLSchema = `
+	"The operations"
+	type Query {
+		task(id: ID!): Task
+		tasks(ids: [ID!]!): [Task]
+		greet(name: String = "world"): String!
+	}
+	type Mutation {
+		complete(id: ID!, done: Boolean = true): Boolean
+	}
+	type Task {
+		title: String!
+		done: Boolean
+		priority: Priority
+		tags: [String]
+		owner: User
+	}
+	type User {
+		name: String
+	}
+	enum Priority { LOW HIGH }
+`
+
+func testGraphQLResolvers() map[string]map[string]*GraphQLResolver {
+	return map[string]map[string]*GraphQLResolver{
+		"Query": {
+			"task":  {GetDoc: "id"},
+			"tasks": {GetDoc: "ids"},
+			"greet": {Function: "greet"},
+		},
+		"Mutation": {"complete": {Function: "complete"}},
+		"Task":     {"owner": {GetDoc: "ownerId"}},
+	}
+}
+
+func TestParseGraphQLDocument(t *testing.T) {
+	doc, err := parseGraphQLDocument(`
+		query Tasks($ids: [ID!]! = ["a"]) {
+			first: task(id: "a") { ...taskFields }
+			tasks(ids: $ids) { title, ... on Task { done } }
+		}
+		fragment taskFields on Task { title priority }`)
+	if assert.NoError(t, err) {
+		assert.Len(t, doc.operations, 1)
+		op := doc.operations[0]
+		assert.Equal(t, "query", op.kind)
+		assert.Equal(t, "Tasks", op.name)
+		assert.Equal(t, "[ID!]!", op.variables[0].typ.String())
+		assert.Equal(t, []interface{}{"a"}, op.variables[0].defaultValue)
+		assert.Len(t, op.selections, 2)
+		first := op.selections[0].(*gqlField)
+		assert.Equal(t, "first", first.responseKey())
+		assert.Equal(t, map[string]interface{}{"id": "a"}, first.arguments)
+		assert.Equal(t, gqlVariable("ids"), op.selections[1].(*gqlField).arguments["ids"])
+		assert.Contains(t, doc.fragments, "taskFields")
+	}
+
+	tests := []struct {
+		source   string
+		location GraphQLLocation
+	}{
+		{`{ task(id: "a") { title }`, GraphQLLocation{Line: 1, Column: 26}},
+		{"{\n  task(id: \"a) { title } }", GraphQLLocation{Line: 2, Column: 12}},
+		{`{ task { } }`, GraphQLLocation{Line: 1, Column: 8}},
+		{`query Q($a: Int = $b) { greet }`, GraphQLLocation{Line: 1, Column: 19}},
+		{`subscription { greet }`, GraphQLLocation{Line: 1, Column: 1}},
+		{`{ greet @skip(if: true) }`, GraphQLLocation{Line: 1, Column: 9}},
+	}
+	for _, test := range tests {
+		_, err := parseGraphQLDocument(test.source)
+		if assert.Error(t, err, test.source) {
+			assert.Equal(t, []GraphQLLocation{test.location}, err.(*GraphQLError).Locations, test.source)
+		}
+	}
+}
+
+// Deeply nested documents fail to parse rather than overflowing the stack.
+func TestParseGraphQLDocumentNesting(t *testing.T) {
+	_, err := parseGraphQLDocument(`{ greet(name: ` + strings.Repeat("[", 100000) + `) }`)
+	assert.Error(t, err)
+	_, err = parseGraphQLDocument(strings.Repeat("{ task(id: \"a\") ", 100000))
+	assert.Error(t, err)
+	_, err = parseGraphQLDocument(`query Q($a: ` + strings.Repeat("[", 100000) + `) { greet }`)
+	assert.Error(t, err)
+
+	_, err = parseGraphQLDocument(`{ greet(name: ` + strings.Repeat("[", graphQLMaxNesting-1) + strings.Repeat("]", graphQLMaxNesting-1) + `) }`)
+	assert.NoError(t, err)
+}
+
+func TestNewGraphQLSchema(t *testing.T) {
+	_, err := NewGraphQLSchema(testGraphQLSchema, testGraphQLResolvers())
+	assert.NoError(t, err)
+
+	resolvers := func(field string, resolver *GraphQLResolver) map[string]map[string]*GraphQLResolver {
+		return map[string]map[string]*GraphQLResolver{"Query": {field: resolver}}
+	}
+	queryResolver := resolvers("q", &GraphQLResolver{Query: "q"})
+	tests := []struct {
+		source    string
+		resolvers map[string]map[string]*GraphQLResolver
+	}{
+		{`type Query { q: [String] `, queryResolver},
+		{`type Query { q: Missing }`, queryResolver},
+		{`type Query { q: String } type Query { r: String }`, queryResolver},
+		{`type Query { q(t: Query): String }`, queryResolver},
+		{`type Query { q(n: Int = "one"): String }`, queryResolver},
+		{`type Query { q: String }`, nil},
+		{`type Root { q: String }`, queryResolver},
+		{`type Query { q: String }`, resolvers("q", &GraphQLResolver{Query: "q", Function: "f"})},
+		{`type Query { q: String }`, resolvers("r", &GraphQLResolver{Query: "q"})},
+		{`type Query { q(id: ID): String }`, resolvers("q", &GraphQLResolver{GetDoc: "id"})},
+		{`type Query { q: T } type T { s: String }`, resolvers("q", &GraphQLResolver{GetDoc: "id"})},
+		{`interface Node { id: ID } type Query { q: String }`, queryResolver},
+		{`enum E { A A } type Query { q: E }`, queryResolver},
+	}
+	for _, test := range tests {
+		_, err := NewGraphQLSchema(test.source, test.resolvers)
+		assert.Error(t, err, test.source)
+	}
+
+	// The root types can be renamed
+	schema, err := NewGraphQLSchema(`schema { query: Root } type Root { q: String }`, resolvers("q", nil))
+	assert.Error(t, err)
+	schema, err = NewGraphQLSchema(`schema { query: Root } type Root { q: String }`,
+		map[string]map[string]*GraphQLResolver{"Root": {"q": {Query: "q"}}})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "Root", schema.queryType.name)
+		assert.Nil(t, schema.mutationType)
+	}
+}
+
+func TestPrepareGraphQL(t *testing.T) {
+	schema, err := NewGraphQLSchema(testGraphQLSchema, testGraphQLResolvers())
+	assert.NoError(t, err)
+
+	valid := []string{
+		`{ greet }`,
+		`query($id: ID!) { task(id: $id) { title owner { name } } }`,
+		`{ task(id: "a") { ...f ... on Task { done } __typename } } fragment f on Task { title }`,
+		`{ a: task(id: "a") { title } b: task(id: "b") { title } }`,
+		`{ tasks(ids: "a") { tags priority } }`,
+		`mutation { complete(id: 1) }`,
+	}
+	for _, source := range valid {
+		_, err := schema.Prepare(source, "")
+		assert.NoError(t, err, source)
+	}
+
+	invalid := []string{
+		`{ missing }`,
+		`{ greet(nickname: "x") }`,
+		`{ greet(name: 1) }`,
+		`{ task { title } }`,
+		`{ task(id: "a") }`,
+		`{ greet { length } }`,
+		`{ task(id: $id) { title } }`,
+		`query($t: Task) { greet }`,
+		`query($a: Int, $a: Int) { greet }`,
+		`{ task(id: "a") { ...missing } }`,
+		`{ task(id: "a") { ...f } } fragment f on Task { ...f }`,
+		`{ task(id: "a") { ...f } } fragment f on User { name }`,
+		`{ greet greet: task(id: "a") { title } }`,
+		`{ task(id: "a") { title } task(id: "b") { title } }`,
+		`{ greet } { greet }`,
+	}
+	for _, source := range invalid {
+		_, err := schema.Prepare(source, "")
+		if assert.Error(t, err, source) {
+			assert.IsType(t, GraphQLErrors{}, err, source)
+		}
+	}
+
+	// Operations are picked by name
+	source := `query A { greet } mutation B { complete(id: "a") }`
+	op, err := schema.Prepare(source, "B")
+	if assert.NoError(t, err) {
+		assert.True(t, op.IsMutation())
+	}
+	_, err = schema.Prepare(source, "C")
+	assert.Error(t, err)
+
+	// A schema without a mutation type has no mutations
+	querySchema, err := NewGraphQLSchema(`type Query { q: String }`,
+		map[string]map[string]*GraphQLResolver{"Query": {"q": {Function: "f"}}})
+	assert.NoError(t, err)
+	_, err = querySchema.Prepare(`mutation { q }`, "")
+	assert.Error(t, err)
+}
+
+func TestExecuteGraphQL(t *testing.T) {
+	db, testBucket := setupTestDB(t)
+	defer testBucket.Close()
+	defer tearDownTestDB(t, db)
+
+	schema, err := NewGraphQLSchema(testGraphQLSchema, testGraphQLResolvers())
+	assert.NoError(t, err)
+	db.Options.UserFunctions = map[string]*UserFunction{
+		"greet": NewUserFunction(`function(args, user) {return "Hello, " + args.name;}`),
+		"complete": NewUserFunction(`function(args, user) {
+			var doc = getDoc(args.id);
+			if (!doc) return false;
+			doc.done = args.done;
+			putDoc(args.id, doc);
+			return true;
+		}`),
+	}
+
+	_, err = db.Put("user1", Body{"name": "Alice"})
+	assert.NoError(t, err)
+	_, err = db.Put("task1", Body{"title": "Write tests", "priority": "HIGH", "tags": []interface{}{"dev"}, "ownerId": "user1"})
+	assert.NoError(t, err)
+	_, err = db.Put("task2", Body{"done": true, "priority": "URGENT"})
+	assert.NoError(t, err)
+
+	execute := func(source string, variables map[string]interface{}) string {
+		op, err := schema.Prepare(source, "")
+		if !assert.NoError(t, err, source) {
+			return ""
+		}
+		result, err := db.ExecuteGraphQL(op, variables)
+		if !assert.NoError(t, err, source) {
+			return ""
+		}
+		response := Body{"data": result.Data}
+		if len(result.Errors) > 0 {
+			response["errors"] = result.Errors
+		}
+		responseJSON, err := json.Marshal(response)
+		assert.NoError(t, err)
+		return string(responseJSON)
+	}
+
+	// Fields are returned in the order they're selected
+	assert.Equal(t, `{"data":{"task":{"title":"Write tests","tags":["dev"],"priority":"HIGH","owner":{"name":"Alice"}}}}`,
+		execute(`{ task(id: "task1") { title tags priority owner { name } } }`, nil))
+
+	assert.JSONEq(t, `{"data":{"greet":"Hello, world","hi":"Hello, Bob"}}`,
+		execute(`{ greet hi: greet(name: "Bob") }`, nil))
+
+	assert.JSONEq(t, `{"data":{"task":{"__typename":"Task","title":"Write tests","done":null}}}`,
+		execute(`query($id: ID!) { task(id: $id) { __typename ...f ... on Task { done } } } fragment f on Task { title }`,
+			map[string]interface{}{"id": "task1"}))
+
+	// Documents that don't exist resolve to null
+	assert.JSONEq(t, `{"data":{"tasks":[{"title":"Write tests"},null]}}`,
+		execute(`{ tasks(ids: ["task1", "missing"]) { title } }`, nil))
+
+	// A null non-null field makes its parent null; a value of the wrong type is null
+	assert.JSONEq(t, `{"data":{"task":null},"errors":[
+			{"message":"Non-null field resolved to null","locations":[{"line":1,"column":23}],"path":["task","title"]}]}`,
+		execute(`{ task(id: "task2") { title } }`, nil))
+	assert.JSONEq(t, `{"data":{"task":{"done":true,"priority":null}},"errors":[
+			{"message":"Can't return \"URGENT\" as type Priority","locations":[{"line":1,"column":28}],"path":["task","priority"]}]}`,
+		execute(`{ task(id: "task2") { done priority } }`, nil))
+
+	assert.JSONEq(t, `{"data":{"complete":true}}`, execute(`mutation { complete(id: "task1", done: false) }`, nil))
+	body, err := db.Get("task1")
+	assert.NoError(t, err)
+	assert.Equal(t, false, body["done"])
+
+	// Lists of documents, and the documents loaded by a request, are limited
+	ids := make([]string, GraphQLMaxListDocIDs+1)
+	for i := range ids {
+		ids[i] = `"task1"`
+	}
+	assert.Contains(t, execute(`{ tasks(ids: [`+strings.Join(ids, ",")+`]) { title } }`, nil), "Too many document IDs")
+	idList := strings.Join(ids[1:], ",")
+	var fields []string
+	for i := 0; i <= GraphQLMaxDocLoads/GraphQLMaxListDocIDs; i++ {
+		fields = append(fields, fmt.Sprintf(`t%d: tasks(ids: [%s]) { title }`, i, idList))
+	}
+	result := execute(`{ `+strings.Join(fields, " ")+` }`, nil)
+	assert.Contains(t, result, "Request loads too many documents")
+	assert.Contains(t, result, `"path":["t10"]`)
+
+	// Variables are checked against their types
+	op, err := schema.Prepare(`query($id: ID!) { task(id: $id) { title } }`, "")
+	assert.NoError(t, err)
+	_, err = db.ExecuteGraphQL(op, nil)
+	assert.Error(t, err)
+	_, err = db.ExecuteGraphQL(op, map[string]interface{}{"id": true})
+	assert.Error(t, err)
+}
@@ -0,0 +1,134 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/couchbase/sync_gateway/base"
+)
+
+// Prefix of the keys of the docs written by health checks to verify bucket connectivity and mutation feed liveness.
+// Each database context writes its own doc, so that concurrent checks by other nodes don't interfere with its checks.
+const HealthCheckDocKey = KSyncKeyPrefix + "health_check"
+
+// Expiry of health check docs, in seconds, so that those of nodes that have gone away are removed.
+const healthCheckDocExpiry = 24 * 60 * 60
+
+// How often the mutation feed is polled while waiting for a health check doc to arrive.
+const healthCheckFeedPollInterval = 10 * time.Millisecond
+
+type healthCheckDoc struct {
+	Timestamp string `json:"timestamp"`
+}
+
+// The health check doc of a database context, and the lock that serializes the checks that write it.
+type healthChecker struct {
+	lock sync.Mutex
+	key  string
+}
+
+func newHealthChecker() *healthChecker {
+	return &healthChecker{key: HealthCheckDocKey + ":" + base.CreateUUID()}
+}
+
+// CheckBucketHealth writes the health check doc to the metadata bucket and reads it back, returning an error if
+// either operation fails or the doc read isn't the doc written.
+func (context *DatabaseContext) CheckBucketHealth() error {
+	context.healthCheck.lock.Lock()
+	defer context.healthCheck.lock.Unlock()
+
+	// No other node writes this doc, so it can be replaced by removing it and adding it again, which returns the CAS
+	// written for comparison
+	key := context.healthCheck.key
+	if err := context.MetadataBucket.Delete(key); err != nil && !base.IsKeyNotFoundError(context.MetadataBucket, err) {
+		return fmt.Errorf("write failed: %v", err)
+	}
+	written := healthCheckDoc{Timestamp: time.Now().Format(time.RFC3339Nano)}
+	writtenCas, err := context.MetadataBucket.WriteCas(key, 0, healthCheckDocExpiry, 0, written, 0)
+	if err != nil {
+		return fmt.Errorf("write failed: %v", err)
+	}
+
+	var read healthCheckDoc
+	readCas, err := context.MetadataBucket.Get(key, &read)
+	if err != nil {
+		return fmt.Errorf("read failed: %v", err)
+	}
+	if readCas != writtenCas {
+		return errors.New("read returned a different revision than was written")
+	}
+	return nil
+}
+
+// CheckBucketReadHealth reads the health check doc from the metadata bucket, without writing anything, returning an
+// error if the read fails other than because the doc doesn't exist.
+func (context *DatabaseContext) CheckBucketReadHealth() error {
+	var read healthCheckDoc
+	if _, err := context.MetadataBucket.Get(context.healthCheck.key, &read); err != nil && !base.IsDocNotFoundError(err) {
+		return fmt.Errorf("read failed: %v", err)
+	}
+	return nil
+}
+
+// CheckBackgroundTaskHealth returns an error if the latest run on this node of any of the database's background tasks
+// failed or was interrupted.  The detail gives the number of tasks running and queued on this node.
+func (context *DatabaseContext) CheckBackgroundTaskHealth() (detail string, err error) {
+	tasks, err := context.BackgroundTasks.List()
+	if err != nil {
+		return "", fmt.Errorf("listing tasks failed: %v", err)
+	}
+	running, queued := 0, 0
+	latest := map[string]bool{} // Names of the tasks whose latest run has been seen
+	var failed []string
+	for _, task := range tasks { // Newest first
+		if task.Node != context.BackgroundTasks.node {
+			continue
+		}
+		switch task.State {
+		case BackgroundTaskRunning, BackgroundTaskPaused:
+			running++
+		case BackgroundTaskQueued:
+			queued++
+		}
+		if !task.State.finished() || latest[task.Name] {
+			continue
+		}
+		latest[task.Name] = true
+		if task.State == BackgroundTaskFailed || task.State == BackgroundTaskInterrupted {
+			failed = append(failed, task.Name)
+		}
+	}
+	detail = fmt.Sprintf("%d running, %d queued", running, queued)
+	if len(failed) > 0 {
+		return detail, fmt.Errorf("latest run of %s didn't complete", strings.Join(failed, ", "))
+	}
+	return detail, nil
+}
+
+// CheckFeedHealth writes the health check doc to the bucket, and waits up to timeout for the mutation to arrive
+// on the database's mutation feed (DCP or TAP).  The doc is written to the bucket rather than the metadata bucket, as
+// that's the bucket the feed is of.
+func (context *DatabaseContext) CheckFeedHealth(timeout time.Duration) error {
+	context.healthCheck.lock.Lock()
+	defer context.healthCheck.lock.Unlock()
+
+	keys := []string{context.healthCheck.key}
+	startCount := context.mutationListener.CurrentCount(keys)
+
+	written := healthCheckDoc{Timestamp: time.Now().Format(time.RFC3339Nano)}
+	if err := context.Bucket.Set(context.healthCheck.key, healthCheckDocExpiry, written); err != nil {
+		return fmt.Errorf("write failed: %v", err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for context.mutationListener.CurrentCount(keys) == startCount {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("mutation not received on %s feed within %v", base.GetFeedType(context.Bucket), timeout)
+		}
+		time.Sleep(healthCheckFeedPollInterval)
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package db
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Concurrent health checks, by this node or another one sharing the bucket, don't make each other fail.
+func TestCheckBucketHealthConcurrent(t *testing.T) {
+	db, testBucket := setupTestDB(t)
+	defer testBucket.Close()
+	defer tearDownTestDB(t, db)
+
+	otherNode := &DatabaseContext{Bucket: db.Bucket, MetadataBucket: db.MetadataBucket, healthCheck: newHealthChecker()}
+	assert.NotEqual(t, db.healthCheck.key, otherNode.healthCheck.key)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(context *DatabaseContext) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				assert.NoError(t, context.CheckBucketHealth())
+			}
+		}([]*DatabaseContext{db.DatabaseContext, otherNode}[i%2])
+	}
+	wg.Wait()
+	assert.NoError(t, db.CheckBucketReadHealth())
+}
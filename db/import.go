@@ -60,7 +60,7 @@ func (db *Database) ImportDocRaw(docid string, value []byte, xattrValue []byte,
 func (db *Database) ImportDoc(docid string, existingDoc *document, isDelete bool, expiry *uint32, mode ImportMode) (docOut *document, err error) {
 
 	if existingDoc == nil {
-		return nil, base.RedactErrorf("No existing doc present when attempting to import %s", base.UD(docid))
+		return nil, base.RedactErrorf("No existing doc present when attempting to import %s", base.UDDocID(docid))
 	}
 
 	// Get the doc expiry if it wasn't passed in
@@ -91,11 +91,11 @@ func (db *Database) ImportDoc(docid string, existingDoc *document, isDelete bool
 
 func (db *Database) importDoc(docid string, body Body, isDelete bool, existingDoc *sgbucket.BucketDocument, mode ImportMode) (docOut *document, err error) {
 
-	base.Debugf(base.KeyImport, "Attempting to import doc %q...", base.UD(docid))
+	base.Debugf(base.KeyImport, "Attempting to import doc %q...", base.UDDocID(docid))
 	importStartTime := time.Now()
 
 	if existingDoc == nil {
-		return nil, base.RedactErrorf("No existing doc present when attempting to import %s", base.UD(docid))
+		return nil, base.RedactErrorf("No existing doc present when attempting to import %s", base.UDDocID(docid))
 	} else if body == nil {
 		return nil, base.ErrEmptyDocument
 	}
@@ -163,7 +163,7 @@ func (db *Database) importDoc(docid string, body Body, isDelete bool, existingDo
 		// If this is a delete, and there is no xattr on the existing doc,
 		// we shouldn't import.  (SG purge arriving over DCP feed)
 		if isDelete && doc.CurrentRev == "" {
-			base.Debugf(base.KeyImport, "Import not required for delete mutation with no existing SG xattr (SG purge): %s", base.UD(docid))
+			base.Debugf(base.KeyImport, "Import not required for delete mutation with no existing SG xattr (SG purge): %s", base.UDDocID(docid))
 			return nil, nil, updatedExpiry, base.ErrImportCancelled
 		}
 
@@ -176,7 +176,7 @@ func (db *Database) importDoc(docid string, body Body, isDelete bool, existingDo
 		// If the current version of the doc is an SG write, document has been updated by SG subsequent to the update that triggered this import.
 		// Cancel import
 		if isSgWrite {
-			base.Debugf(base.KeyImport, "During import, existing doc (%s) identified as SG write.  Canceling import.", base.UD(docid))
+			base.Debugf(base.KeyImport, "During import, existing doc (%s) identified as SG write.  Canceling import.", base.UDDocID(docid))
 			alreadyImportedDoc = doc
 			return nil, nil, updatedExpiry, base.ErrAlreadyImported
 		}
@@ -185,11 +185,11 @@ func (db *Database) importDoc(docid string, body Body, isDelete bool, existingDo
 		if db.DatabaseContext.Options.ImportOptions.ImportFilter != nil {
 			shouldImport, err := db.DatabaseContext.Options.ImportOptions.ImportFilter.EvaluateFunction(body)
 			if err != nil {
-				base.Debugf(base.KeyImport, "Error returned for doc %s while evaluating import function - will not be imported.", base.UD(docid))
+				base.Debugf(base.KeyImport, "Error returned for doc %s while evaluating import function - will not be imported.", base.UDDocID(docid))
 				return nil, nil, updatedExpiry, base.ErrImportCancelledFilter
 			}
 			if shouldImport == false {
-				base.Debugf(base.KeyImport, "Doc %s excluded by document import function - will not be imported.", base.UD(docid))
+				base.Debugf(base.KeyImport, "Doc %s excluded by document import function - will not be imported.", base.UDDocID(docid))
 				// TODO: If this document has a current revision (this is a document that was previously mobile-enabled), do additional opt-out processing
 				// pending https://github.com/couchbase/sync_gateway/issues/2750
 				return nil, nil, updatedExpiry, base.ErrImportCancelledFilter
@@ -200,8 +200,8 @@ func (db *Database) importDoc(docid string, body Body, isDelete bool, existingDo
 		parentRev := doc.CurrentRev
 		generation, _ := ParseRevID(parentRev)
 		generation++
-		newRev = createRevID(generation, parentRev, body)
-		base.InfofCtx(db.Ctx, base.KeyImport, "Created new rev ID for doc %q / %q", base.UD(docid), newRev)
+		newRev = db.newRevID(generation, parentRev, isDelete, body)
+		base.InfofCtx(db.Ctx, base.KeyImport, "Created new rev ID for doc %q / %q", base.UDDocID(docid), newRev)
 		body[BodyRev] = newRev
 		doc.History.addRevision(docid, RevInfo{ID: newRev, Parent: parentRev, Deleted: isDelete})
 
@@ -229,7 +229,7 @@ func (db *Database) importDoc(docid string, body Body, isDelete bool, existingDo
 	case nil:
 		db.DbStats.SharedBucketImport().Add(base.StatKeyImportCount, 1)
 		db.DbStats.SharedBucketImport().Add(base.StatKeyImportProcessingTime, time.Since(importStartTime).Nanoseconds())
-		base.Debugf(base.KeyImport, "Imported %s (delete=%v) as rev %s", base.UD(docid), isDelete, newRev)
+		base.Debugf(base.KeyImport, "Imported %s (delete=%v) as rev %s", base.UDDocID(docid), isDelete, newRev)
 	case base.ErrImportCancelled:
 		// Import was cancelled (SG purge) - don't return error.
 	case base.ErrImportCancelledFilter:
@@ -239,7 +239,7 @@ func (db *Database) importDoc(docid string, body Body, isDelete bool, existingDo
 		// Import was cancelled due to CAS failure.
 		return nil, err
 	default:
-		base.Infof(base.KeyImport, "Error importing doc %q: %v", base.UD(docid), err)
+		base.Infof(base.KeyImport, "Error importing doc %q: %v", base.UDDocID(docid), err)
 		db.DbStats.SharedBucketImport().Add(base.StatKeyImportErrorCount, 1)
 		return nil, err
 
@@ -261,7 +261,7 @@ func (db *Database) migrateMetadata(docid string, body Body, existingDoc *sgbuck
 
 	// If no sync metadata is present, return for import handling
 	if !doc.HasValidSyncData(false) {
-		base.Infof(base.KeyMigrate, "During migrate, doc %q doesn't have valid sync data.  Falling back to import handling.  (cas=%d)", base.UD(docid), doc.Cas)
+		base.Infof(base.KeyMigrate, "During migrate, doc %q doesn't have valid sync data.  Falling back to import handling.  (cas=%d)", base.UDDocID(docid), doc.Cas)
 		return doc, true, nil
 	}
 
@@ -286,7 +286,7 @@ func (db *Database) migrateMetadata(docid string, body Body, existingDoc *sgbuck
 	casOut, writeErr := gocbBucket.WriteWithXattr(docid, KSyncXattrName, existingDoc.Expiry, existingDoc.Cas, value, xattrValue, isDelete, deleteBody)
 	if writeErr == nil {
 		doc.Cas = casOut
-		base.Infof(base.KeyMigrate, "Successfully migrated doc %q", base.UD(docid))
+		base.Infof(base.KeyMigrate, "Successfully migrated doc %q", base.UDDocID(docid))
 		return doc, false, nil
 	}
 
@@ -358,6 +358,12 @@ func newImportFilterRunner(funcSource string) (sgbucket.JSServerTask, error) {
 	return importFilterRunner, nil
 }
 
+// ValidateImportFilter compiles the given import filter function source, returning any compilation error.
+func ValidateImportFilter(fnSource string) error {
+	_, err := newImportFilterRunner(fnSource)
+	return err
+}
+
 type ImportFilterFunction struct {
 	*sgbucket.JSServer
 }
@@ -30,7 +30,7 @@ func (db *Database) VectorMultiChangesFeed(chans base.Set, options ChangesOption
 		to = fmt.Sprintf("  (to %s)", db.user.Name())
 		userVbNo = uint16(db.Bucket.VBHash(db.user.DocID()))
 	}
-	base.DebugfCtx(db.Ctx, base.KeyChanges, "Vector MultiChangesFeed(channels: %s, options: %+v) ... %s", base.UD(chans), options, base.UD(to))
+	base.DebugfCtx(db.Ctx, base.KeyChanges, "Vector MultiChangesFeed(channels: %s, options: %+v) ... %s", base.UDChannel(chans), options, base.UD(to))
 
 	output := make(chan *ChangeEntry, 50)
 
@@ -72,7 +72,7 @@ func (db *Database) VectorMultiChangesFeed(chans base.Set, options ChangesOption
 				if err := db.ReloadUser(); err != nil {
 					change := makeErrorEntry("User not found during reload - terminating changes feed")
 					output <- &change
-					base.WarnfCtx(db.Ctx, base.KeyAll, "Error reloading user during changes initialization %q: %v", base.UD(db.user.Name()), err)
+					base.WarnfCtx(db.Ctx, base.KeyAll, "Error reloading user during changes initialization %q: %v", base.UDUsername(db.user.Name()), err)
 					return
 				}
 			}
@@ -287,7 +287,7 @@ func (db *Database) checkForUserUpdatesSince(userChangeCount uint64, changeWaite
 
 		if db.user != nil {
 			if err := db.ReloadUser(); err != nil {
-				base.Warnf(base.KeyAll, "Error reloading user %q: %v", base.UD(db.user.Name()), err)
+				base.Warnf(base.KeyAll, "Error reloading user %q: %v", base.UDUsername(db.user.Name()), err)
 				return false, 0, nil, err
 			}
 			// check whether channels have changed
@@ -300,7 +300,7 @@ func (db *Database) checkForUserUpdatesSince(userChangeCount uint64, changeWaite
 				}
 			}
 			if len(newChannels) > 0 {
-				base.Debugf(base.KeyChanges, "New channels found after user reload: %v", base.UD(newChannels))
+				base.Debugf(base.KeyChanges, "New channels found after user reload: %v", base.UDChannel(newChannels))
 			}
 		}
 		return true, newCount, newChannels, nil
@@ -657,7 +657,7 @@ func (db *Database) vectorChangesFeed(channel string, options ChangesOptions, se
 		if err != nil {
 			return nil, err
 		}
-		base.Debugf(base.KeyChanges, "[changesFeed] Found %d changes for channel %s", len(log), base.UD(channel))
+		base.Debugf(base.KeyChanges, "[changesFeed] Found %d changes for channel %s", len(log), base.UDChannel(channel))
 	case ChannelFeedType_ActiveBackfill:
 		// In-progress backfill for this channel
 		// Backfill position: (vb,seq) position in the backfill. e.g. [0,0] if we're just starting the backfill, [vb,seq] if we're midway through.
@@ -671,14 +671,14 @@ func (db *Database) vectorChangesFeed(channel string, options ChangesOptions, se
 		if err != nil {
 			return nil, err
 		}
-		base.Debugf(base.KeyChanges, "[changesFeed] Found %d backfill changes for channel %s", len(backfillLog), base.UD(channel))
+		base.Debugf(base.KeyChanges, "[changesFeed] Found %d backfill changes for channel %s", len(backfillLog), base.UDChannel(channel))
 		// If we still have room, get non-backfill entries
 		if options.Limit == 0 || len(backfillLog) < options.Limit {
 			log, err = changeIndex.reader.GetChangesForRange(channel, backfillTo, nil, options.Limit, options.ActiveOnly)
 			if err != nil {
 				return nil, err
 			}
-			base.Debugf(base.KeyChanges, "[changesFeed] Found %d non-backfill changes for channel %s", len(log), base.UD(channel))
+			base.Debugf(base.KeyChanges, "[changesFeed] Found %d non-backfill changes for channel %s", len(log), base.UDChannel(channel))
 		}
 	case ChannelFeedType_PendingBackfill:
 		// Pending backfill for this channel
@@ -752,7 +752,7 @@ func (db *Database) vectorChangesFeed(channel string, options ChangesOptions, se
 			// Get everything from zero to the cumulative clock as backfill
 			backfillLog, err = changeIndex.reader.GetChangesForRange(channel, base.NewSequenceClockImpl(), cumulativeClock, options.Limit, options.ActiveOnly)
 			if err != nil {
-				base.Warnf(base.KeyAll, "Error processing backfill changes for channel %s: %v", base.UD(channel), err)
+				base.Warnf(base.KeyAll, "Error processing backfill changes for channel %s: %v", base.UDChannel(channel), err)
 				return
 			}
 
@@ -760,10 +760,10 @@ func (db *Database) vectorChangesFeed(channel string, options ChangesOptions, se
 			if options.Limit == 0 || len(backfillLog) < options.Limit {
 				log, err = changeIndex.reader.GetChangesForRange(channel, cumulativeClock, stableClock, options.Limit, options.ActiveOnly)
 				if err != nil {
-					base.Warnf(base.KeyAll, "Error processing changes for channel %s: %v", base.UD(channel), err)
+					base.Warnf(base.KeyAll, "Error processing changes for channel %s: %v", base.UDChannel(channel), err)
 					return
 				}
-				base.Debugf(base.KeyChanges, "[changesFeed] Found %d non-backfill changes for channel %s", len(log), base.UD(channel))
+				base.Debugf(base.KeyChanges, "[changesFeed] Found %d non-backfill changes for channel %s", len(log), base.UDChannel(channel))
 			}
 		}
 
@@ -173,7 +173,7 @@ func (i *SGIndex) isXattrOnly() bool {
 
 // Creates index associated with specified SGIndex if not already present.  Always defers build - a subsequent BUILD INDEX
 // will need to be invoked for any created indexes.
-func (i *SGIndex) createIfNeeded(bucket *base.CouchbaseBucketGoCB, useXattrs bool, numReplica uint) (isDeferred bool, err error) {
+func (i *SGIndex) createIfNeeded(bucket base.N1QLBucket, useXattrs bool, numReplica uint) (isDeferred bool, err error) {
 
 	if i.isXattrOnly() && !useXattrs {
 		return false, nil
@@ -258,7 +258,7 @@ func (i *SGIndex) createIfNeeded(bucket *base.CouchbaseBucketGoCB, useXattrs boo
 // Initializes Sync Gateway indexes for bucket.  Creates required indexes if not found, then waits for index readiness.
 func InitializeIndexes(bucket base.Bucket, useXattrs bool, numReplicas uint) error {
 
-	gocbBucket, ok := base.AsGoCBBucket(bucket)
+	n1qlBucket, ok := base.AsN1QLBucket(bucket)
 	if !ok {
 		base.Warnf(base.KeyAll, "Using a non-Couchbase bucket: %T - indexes will not be created.", bucket)
 		return nil
@@ -270,7 +270,7 @@ func InitializeIndexes(bucket base.Bucket, useXattrs bool, numReplicas uint) err
 	allSGIndexes := make([]string, 0)
 	for _, sgIndex := range sgIndexes {
 		fullIndexName := sgIndex.fullIndexName(useXattrs)
-		isDeferred, err := sgIndex.createIfNeeded(gocbBucket, useXattrs, numReplicas)
+		isDeferred, err := sgIndex.createIfNeeded(n1qlBucket, useXattrs, numReplicas)
 		if err != nil {
 			return base.RedactErrorf("Unable to install index %s: %v", base.MD(sgIndex.simpleName), err)
 		}
@@ -283,7 +283,7 @@ func InitializeIndexes(bucket base.Bucket, useXattrs bool, numReplicas uint) err
 
 	// Issue BUILD INDEX for any deferred indexes.
 	if len(deferredIndexes) > 0 {
-		buildErr := gocbBucket.BuildDeferredIndexes(deferredIndexes)
+		buildErr := n1qlBucket.BuildDeferredIndexes(deferredIndexes)
 		if buildErr != nil {
 			base.Infof(base.KeyQuery, "Error building deferred indexes.  Error: %v", buildErr)
 			return buildErr
@@ -292,15 +292,15 @@ func InitializeIndexes(bucket base.Bucket, useXattrs bool, numReplicas uint) err
 
 	// Wait for newly built indexes to be online
 	for _, indexName := range deferredIndexes {
-		gocbBucket.WaitForIndexOnline(indexName)
+		n1qlBucket.WaitForIndexOnline(indexName)
 	}
 
 	// Wait for initial readiness queries to complete
-	return waitForIndexes(gocbBucket, useXattrs)
+	return waitForIndexes(n1qlBucket, useXattrs)
 }
 
 // Issue a consistency=request_plus query against critical indexes to guarantee indexing is complete and indexes are ready.
-func waitForIndexes(bucket *base.CouchbaseBucketGoCB, useXattrs bool) error {
+func waitForIndexes(bucket base.N1QLBucket, useXattrs bool) error {
 	var indexesWg sync.WaitGroup
 	base.Infof(base.KeyAll, "Verifying index availability for bucket %s...", base.MD(bucket.GetName()))
 	indexErrors := make(chan error, len(sgIndexes))
@@ -334,7 +334,7 @@ func waitForIndexes(bucket *base.CouchbaseBucketGoCB, useXattrs bool) error {
 }
 
 // Issues adhoc consistency=request_plus query to determine if specified is ready.  Retries indefinitely on timeout, backoff retry on indexer error.
-func waitForIndex(bucket *base.CouchbaseBucketGoCB, indexName string, queryStatement string) error {
+func waitForIndex(bucket base.N1QLBucket, indexName string, queryStatement string) error {
 
 	for {
 		_, err := bucket.Query(queryStatement, nil, gocb.RequestPlus, true)
@@ -356,7 +356,7 @@ func waitForIndex(bucket *base.CouchbaseBucketGoCB, indexName string, queryState
 //  - indexes associated with previous versions of the index, for either xattrs=true or xattrs=false
 func removeObsoleteIndexes(bucket base.Bucket, previewOnly bool, useXattrs bool) (removedIndexes []string, err error) {
 
-	gocbBucket, ok := base.AsGoCBBucket(bucket)
+	n1qlBucket, ok := base.AsN1QLBucket(bucket)
 	if !ok {
 		base.Warnf(base.KeyAll, "Cannot remove obsolete indexes for non-gocb bucket - skipping.")
 		return
@@ -377,7 +377,7 @@ func removeObsoleteIndexes(bucket base.Bucket, previewOnly bool, useXattrs bool)
 	// Attempt removal of candidates, adding to set of removedIndexes when found
 	removedIndexes = make([]string, 0)
 	for _, indexName := range removalCandidates {
-		removed, removeError := removeObsoleteIndex(gocbBucket, indexName, previewOnly)
+		removed, removeError := removeObsoleteIndex(n1qlBucket, indexName, previewOnly)
 		if removeError != nil {
 			return removedIndexes, removeError
 		}
@@ -390,7 +390,7 @@ func removeObsoleteIndexes(bucket base.Bucket, previewOnly bool, useXattrs bool)
 }
 
 // Removes an obsolete index from the database.  In preview mode, checks for existence of the index only.
-func removeObsoleteIndex(bucket *base.CouchbaseBucketGoCB, indexName string, previewOnly bool) (removed bool, err error) {
+func removeObsoleteIndex(bucket base.N1QLBucket, indexName string, previewOnly bool) (removed bool, err error) {
 
 	if previewOnly {
 		// Check for index existence
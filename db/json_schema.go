@@ -0,0 +1,696 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/couchbase/sync_gateway/base"
+)
+
+// The keywords a JSON Schema can use.  Annotations are accepted and ignored; any other keyword is rejected when the
+// schema is compiled, rather than being silently unenforced.
+var jsonSchemaKeywords = map[string]bool{
+	"$schema": true, "$id": true, "$comment": true, "title": true, "description": true, "default": true,
+	"examples": true, "format": true, "definitions": true, "$ref": true,
+	"type": true, "enum": true, "const": true,
+	"properties": true, "required": true, "additionalProperties": true, "minProperties": true, "maxProperties": true,
+	"items": true, "minItems": true, "maxItems": true, "uniqueItems": true,
+	"minLength": true, "maxLength": true, "pattern": true,
+	"minimum": true, "maximum": true, "exclusiveMinimum": true, "exclusiveMaximum": true, "multipleOf": true,
+	"allOf": true, "anyOf": true, "oneOf": true, "not": true,
+}
+
+// The JSON Schema value types.  An "integer" is a number without a fractional part.
+var jsonSchemaTypes = map[string]bool{
+	"null": true, "boolean": true, "object": true, "array": true, "number": true, "integer": true, "string": true,
+}
+
+// A compiled JSON Schema.  This supports the draft-07 keywords that describe the structure of a document; "format" is
+// treated as an annotation, and $ref can only refer to the schema's own definitions ("#/definitions/name") or to the
+// whole schema ("#").
+type JSONSchema struct {
+	always           *bool // Set for the boolean schemas true and false
+	types            []string
+	enum             []interface{}
+	constValue       interface{}
+	hasConst         bool
+	properties       map[string]*JSONSchema
+	required         []string
+	additional       *JSONSchema
+	minProperties    *int
+	maxProperties    *int
+	items            *JSONSchema
+	tupleItems       []*JSONSchema
+	minItems         *int
+	maxItems         *int
+	uniqueItems      bool
+	minLength        *int
+	maxLength        *int
+	pattern          *regexp.Regexp
+	minimum          *float64
+	maximum          *float64
+	exclusiveMinimum *float64
+	exclusiveMaximum *float64
+	multipleOf       *float64
+	allOf            []*JSONSchema
+	anyOf            []*JSONSchema
+	oneOf            []*JSONSchema
+	not              *JSONSchema
+	ref              string
+	root             *jsonSchemaRoot
+	path             string // JSON Pointer to the schema within the root schema, for compile errors
+}
+
+// Shared by a schema and all of its subschemas, to resolve their $refs.
+type jsonSchemaRoot struct {
+	schema      *JSONSchema
+	definitions map[string]*JSONSchema
+}
+
+// One way a value doesn't match a schema.
+type SchemaViolation struct {
+	Path    string `json:"path"`    // JSON Pointer to the value, "" for the document itself
+	Message string `json:"message"` // What's wrong with the value
+}
+
+// An error in a schema being compiled, at the JSON Pointer path of the invalid keyword.
+type jsonSchemaError struct {
+	path    string
+	message string
+}
+
+func (err *jsonSchemaError) Error() string {
+	if err.path == "" {
+		return "schema: " + err.message
+	}
+	return err.path + ": " + err.message
+}
+
+func (v SchemaViolation) String() string {
+	if v.Path == "" {
+		return v.Message
+	}
+	return v.Path + ": " + v.Message
+}
+
+// Compiles a JSON Schema, given as parsed JSON.
+func NewJSONSchema(schema interface{}) (*JSONSchema, error) {
+	root := &jsonSchemaRoot{definitions: map[string]*JSONSchema{}}
+	compiled, err := compileJSONSchema(schema, "", root)
+	if err != nil {
+		return nil, err
+	}
+	root.schema = compiled
+
+	if object, ok := schema.(map[string]interface{}); ok {
+		if definitions, ok := object["definitions"]; ok {
+			definitions, ok := definitions.(map[string]interface{})
+			if !ok {
+				return nil, &jsonSchemaError{path: "/definitions", message: "must be an object"}
+			}
+			for name, definition := range definitions {
+				if root.definitions[name], err = compileJSONSchema(definition, "/definitions/"+jsonPointerEscape(name), root); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	schemas := []*JSONSchema{compiled}
+	for _, definition := range root.definitions {
+		schemas = append(schemas, definition)
+	}
+	for _, schema := range schemas {
+		if err := schema.checkRefs(); err != nil {
+			return nil, err
+		}
+	}
+	for _, schema := range schemas {
+		if err := schema.checkRefLoops(map[*JSONSchema]bool{}); err != nil {
+			return nil, err
+		}
+	}
+	return compiled, nil
+}
+
+func compileJSONSchema(schema interface{}, path string, root *jsonSchemaRoot) (*JSONSchema, error) {
+	compiled := &JSONSchema{root: root, path: path}
+	if always, ok := schema.(bool); ok {
+		compiled.always = &always
+		return compiled, nil
+	}
+	object, ok := schema.(map[string]interface{})
+	if !ok {
+		return nil, &jsonSchemaError{path: path, message: "a schema must be an object or a boolean"}
+	}
+
+	keywords := make([]string, 0, len(object))
+	for keyword := range object {
+		keywords = append(keywords, keyword)
+	}
+	sort.Strings(keywords)
+	for _, keyword := range keywords {
+		if err := compiled.compileKeyword(keyword, object[keyword], path, root); err != nil {
+			if _, ok := err.(*jsonSchemaError); ok {
+				return nil, err // From a subschema, so it already has its path
+			}
+			return nil, &jsonSchemaError{path: path + "/" + keyword, message: err.Error()}
+		}
+	}
+	return compiled, nil
+}
+
+func (s *JSONSchema) compileKeyword(keyword string, value interface{}, path string, root *jsonSchemaRoot) (err error) {
+	subschema := func(value interface{}, subpath string) (*JSONSchema, error) {
+		return compileJSONSchema(value, path+"/"+keyword+subpath, root)
+	}
+	subschemas := func(value interface{}) ([]*JSONSchema, error) {
+		list, ok := value.([]interface{})
+		if !ok || len(list) == 0 {
+			return nil, fmt.Errorf("must be a non-empty array of schemas")
+		}
+		compiled := make([]*JSONSchema, len(list))
+		for i, item := range list {
+			if compiled[i], err = subschema(item, fmt.Sprintf("/%d", i)); err != nil {
+				return nil, err
+			}
+		}
+		return compiled, nil
+	}
+
+	switch keyword {
+	case "type":
+		switch value := value.(type) {
+		case string:
+			s.types = []string{value}
+		case []interface{}:
+			for _, item := range value {
+				name, _ := item.(string)
+				s.types = append(s.types, name)
+			}
+		}
+		if len(s.types) == 0 {
+			return fmt.Errorf("must be a type name or an array of them")
+		}
+		for _, name := range s.types {
+			if !jsonSchemaTypes[name] {
+				return fmt.Errorf("unknown type %q", name)
+			}
+		}
+	case "enum":
+		list, ok := value.([]interface{})
+		if !ok || len(list) == 0 {
+			return fmt.Errorf("must be a non-empty array")
+		}
+		s.enum = list
+	case "const":
+		s.constValue, s.hasConst = value, true
+	case "properties":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("must be an object")
+		}
+		s.properties = make(map[string]*JSONSchema, len(object))
+		for name, property := range object {
+			if s.properties[name], err = subschema(property, "/"+jsonPointerEscape(name)); err != nil {
+				return err
+			}
+		}
+	case "required":
+		list, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("must be an array of property names")
+		}
+		for _, item := range list {
+			name, ok := item.(string)
+			if !ok {
+				return fmt.Errorf("must be an array of property names")
+			}
+			s.required = append(s.required, name)
+		}
+	case "additionalProperties":
+		s.additional, err = subschema(value, "")
+	case "items":
+		if list, ok := value.([]interface{}); ok {
+			s.tupleItems, err = subschemas(list)
+		} else {
+			s.items, err = subschema(value, "")
+		}
+	case "uniqueItems":
+		unique, ok := value.(bool)
+		if !ok {
+			return fmt.Errorf("must be a boolean")
+		}
+		s.uniqueItems = unique
+	case "minProperties":
+		s.minProperties, err = schemaCount(value)
+	case "maxProperties":
+		s.maxProperties, err = schemaCount(value)
+	case "minItems":
+		s.minItems, err = schemaCount(value)
+	case "maxItems":
+		s.maxItems, err = schemaCount(value)
+	case "minLength":
+		s.minLength, err = schemaCount(value)
+	case "maxLength":
+		s.maxLength, err = schemaCount(value)
+	case "pattern":
+		pattern, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("must be a regular expression")
+		}
+		s.pattern, err = regexp.Compile(pattern)
+	case "minimum":
+		s.minimum, err = schemaNumber(value)
+	case "maximum":
+		s.maximum, err = schemaNumber(value)
+	case "exclusiveMinimum":
+		s.exclusiveMinimum, err = schemaNumber(value)
+	case "exclusiveMaximum":
+		s.exclusiveMaximum, err = schemaNumber(value)
+	case "multipleOf":
+		if s.multipleOf, err = schemaNumber(value); err == nil && *s.multipleOf <= 0 {
+			return fmt.Errorf("must be greater than zero")
+		}
+	case "allOf":
+		s.allOf, err = subschemas(value)
+	case "anyOf":
+		s.anyOf, err = subschemas(value)
+	case "oneOf":
+		s.oneOf, err = subschemas(value)
+	case "not":
+		s.not, err = subschema(value, "")
+	case "$ref":
+		ref, ok := value.(string)
+		if !ok || (ref != "#" && !strings.HasPrefix(ref, "#/definitions/")) {
+			return fmt.Errorf("only references to \"#\" or \"#/definitions/...\" are supported")
+		}
+		s.ref = ref
+	case "definitions":
+		if path != "" {
+			return fmt.Errorf("definitions are only supported at the top level of a schema")
+		}
+	default:
+		if !jsonSchemaKeywords[keyword] {
+			return fmt.Errorf("unsupported keyword")
+		}
+	}
+	return err
+}
+
+// Checks that the $refs in a schema and its subschemas refer to definitions that exist.
+func (s *JSONSchema) checkRefs() error {
+	if s.ref != "" && s.resolveRef() == nil {
+		return &jsonSchemaError{path: s.path + "/$ref", message: fmt.Sprintf("no definition for $ref %q", s.ref)}
+	}
+	var subschemas []*JSONSchema
+	for _, property := range s.properties {
+		subschemas = append(subschemas, property)
+	}
+	subschemas = append(subschemas, s.additional, s.items, s.not)
+	subschemas = append(subschemas, s.tupleItems...)
+	subschemas = append(subschemas, s.allOf...)
+	subschemas = append(subschemas, s.anyOf...)
+	subschemas = append(subschemas, s.oneOf...)
+	for _, subschema := range subschemas {
+		if subschema != nil {
+			if err := subschema.checkRefs(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Checks that validating a value against a schema can't lead back to validating the same value against the same
+// schema, through $refs and the keywords that apply subschemas to the value itself, which would never finish.
+func (s *JSONSchema) checkRefLoops(visiting map[*JSONSchema]bool) error {
+	if visiting[s] {
+		return &jsonSchemaError{path: s.path, message: "$ref loops back to this schema without checking a property or item"}
+	}
+	visiting[s] = true
+	defer delete(visiting, s)
+
+	subschemas := []*JSONSchema{s.not}
+	if s.ref != "" {
+		subschemas = append(subschemas, s.resolveRef())
+	}
+	subschemas = append(subschemas, s.allOf...)
+	subschemas = append(subschemas, s.anyOf...)
+	subschemas = append(subschemas, s.oneOf...)
+	for _, subschema := range subschemas {
+		if subschema != nil {
+			if err := subschema.checkRefLoops(visiting); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *JSONSchema) resolveRef() *JSONSchema {
+	if s.ref == "#" {
+		return s.root.schema
+	}
+	name := strings.TrimPrefix(s.ref, "#/definitions/")
+	name = strings.NewReplacer("~1", "/", "~0", "~").Replace(name)
+	return s.root.definitions[name]
+}
+
+// Validates a value, which has to be parsed JSON, returning every way it doesn't match the schema.
+func (s *JSONSchema) Validate(value interface{}) []SchemaViolation {
+	return s.validate(value, "", nil)
+}
+
+func (s *JSONSchema) validate(value interface{}, path string, violations []SchemaViolation) []SchemaViolation {
+	violation := func(format string, args ...interface{}) {
+		violations = append(violations, SchemaViolation{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if s.always != nil {
+		if !*s.always {
+			violation("no value is allowed here")
+		}
+		return violations
+	}
+	if body, ok := value.(Body); ok {
+		value = map[string]interface{}(body)
+	}
+	if s.ref != "" {
+		violations = s.resolveRef().validate(value, path, violations)
+	}
+	valueType := jsonSchemaTypeOf(value)
+	if len(s.types) > 0 && !jsonSchemaTypeMatches(s.types, valueType) {
+		violation("must be of type %s, not %s", strings.Join(s.types, " or "), valueType)
+		return violations
+	}
+	if len(s.enum) > 0 {
+		matched := false
+		for _, allowed := range s.enum {
+			if jsonEqual(value, allowed) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			violation("must be one of %s", jsonSchemaValues(s.enum))
+		}
+	}
+	if s.hasConst && !jsonEqual(value, s.constValue) {
+		violation("must be %s", jsonSchemaValues([]interface{}{s.constValue}))
+	}
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		violations = s.validateObject(value, path, violations)
+	case []interface{}:
+		violations = s.validateArray(value, path, violations)
+	case string:
+		length := utf8.RuneCountInString(value)
+		if s.minLength != nil && length < *s.minLength {
+			violation("must be at least %d characters long", *s.minLength)
+		}
+		if s.maxLength != nil && length > *s.maxLength {
+			violation("must be at most %d characters long", *s.maxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(value) {
+			violation("must match the pattern %q", s.pattern.String())
+		}
+	default:
+		if number, ok := jsonNumber(value); ok {
+			if s.minimum != nil && number < *s.minimum {
+				violation("must be at least %v", *s.minimum)
+			}
+			if s.maximum != nil && number > *s.maximum {
+				violation("must be at most %v", *s.maximum)
+			}
+			if s.exclusiveMinimum != nil && number <= *s.exclusiveMinimum {
+				violation("must be greater than %v", *s.exclusiveMinimum)
+			}
+			if s.exclusiveMaximum != nil && number >= *s.exclusiveMaximum {
+				violation("must be less than %v", *s.exclusiveMaximum)
+			}
+			if s.multipleOf != nil {
+				if quotient := number / *s.multipleOf; math.Abs(quotient-math.Round(quotient)) > 1e-9 {
+					violation("must be a multiple of %v", *s.multipleOf)
+				}
+			}
+		}
+	}
+
+	for _, subschema := range s.allOf {
+		violations = subschema.validate(value, path, violations)
+	}
+	if len(s.anyOf) > 0 && s.countMatches(s.anyOf, value, path) == 0 {
+		violation("must match at least one of the anyOf schemas")
+	}
+	if len(s.oneOf) > 0 {
+		if matches := s.countMatches(s.oneOf, value, path); matches != 1 {
+			violation("must match exactly one of the oneOf schemas, not %d", matches)
+		}
+	}
+	if s.not != nil && len(s.not.validate(value, path, nil)) == 0 {
+		violation("must not match the not schema")
+	}
+	return violations
+}
+
+func (s *JSONSchema) validateObject(object map[string]interface{}, path string, violations []SchemaViolation) []SchemaViolation {
+	for _, name := range s.required {
+		if _, ok := object[name]; !ok {
+			violations = append(violations, SchemaViolation{Path: path, Message: fmt.Sprintf("missing required property %q", name)})
+		}
+	}
+	if s.minProperties != nil && len(object) < *s.minProperties {
+		violations = append(violations, SchemaViolation{Path: path, Message: fmt.Sprintf("must have at least %d properties", *s.minProperties)})
+	}
+	if s.maxProperties != nil && len(object) > *s.maxProperties {
+		violations = append(violations, SchemaViolation{Path: path, Message: fmt.Sprintf("must have at most %d properties", *s.maxProperties)})
+	}
+
+	// Properties are checked in order, so the violations are reported in the same order every time
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		propertyPath := path + "/" + jsonPointerEscape(name)
+		if property, ok := s.properties[name]; ok {
+			violations = property.validate(object[name], propertyPath, violations)
+		} else if s.additional != nil {
+			if s.additional.always != nil && !*s.additional.always {
+				violations = append(violations, SchemaViolation{Path: propertyPath, Message: "property is not allowed"})
+			} else {
+				violations = s.additional.validate(object[name], propertyPath, violations)
+			}
+		}
+	}
+	return violations
+}
+
+func (s *JSONSchema) validateArray(array []interface{}, path string, violations []SchemaViolation) []SchemaViolation {
+	if s.minItems != nil && len(array) < *s.minItems {
+		violations = append(violations, SchemaViolation{Path: path, Message: fmt.Sprintf("must have at least %d items", *s.minItems)})
+	}
+	if s.maxItems != nil && len(array) > *s.maxItems {
+		violations = append(violations, SchemaViolation{Path: path, Message: fmt.Sprintf("must have at most %d items", *s.maxItems)})
+	}
+	for i, item := range array {
+		itemPath := fmt.Sprintf("%s/%d", path, i)
+		if s.tupleItems != nil {
+			if i < len(s.tupleItems) {
+				violations = s.tupleItems[i].validate(item, itemPath, violations)
+			}
+		} else if s.items != nil {
+			violations = s.items.validate(item, itemPath, violations)
+		}
+		if s.uniqueItems {
+			for j := 0; j < i; j++ {
+				if jsonEqual(array[j], item) {
+					violations = append(violations, SchemaViolation{Path: itemPath, Message: fmt.Sprintf("duplicates item %d", j)})
+					break
+				}
+			}
+		}
+	}
+	return violations
+}
+
+func (s *JSONSchema) countMatches(subschemas []*JSONSchema, value interface{}, path string) (matches int) {
+	for _, subschema := range subschemas {
+		if len(subschema.validate(value, path, nil)) == 0 {
+			matches++
+		}
+	}
+	return matches
+}
+
+// Returns the JSON Schema type of a parsed JSON value.  Whole numbers are "integer".
+func jsonSchemaTypeOf(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	default:
+		if number, ok := jsonNumber(value); ok {
+			if number == math.Trunc(number) {
+				return "integer"
+			}
+			return "number"
+		}
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+func jsonSchemaTypeMatches(types []string, valueType string) bool {
+	for _, name := range types {
+		if name == valueType || (name == "number" && valueType == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns a parsed JSON number as a float64.  Numbers may have been converted to integers, or left as json.Numbers.
+func jsonNumber(value interface{}) (float64, bool) {
+	switch value := value.(type) {
+	case float64:
+		return value, true
+	case float32:
+		return float64(value), true
+	case int:
+		return float64(value), true
+	case int64:
+		return float64(value), true
+	case uint64:
+		return float64(value), true
+	case json.Number:
+		number, err := value.Float64()
+		return number, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// Compares parsed JSON values, treating numbers as equal if they have the same value, whatever their Go types.
+func jsonEqual(a, b interface{}) bool {
+	if numberA, ok := jsonNumber(a); ok {
+		numberB, ok := jsonNumber(b)
+		return ok && numberA == numberB
+	}
+	switch a := a.(type) {
+	case map[string]interface{}:
+		b, ok := b.(map[string]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for key, value := range a {
+			if other, ok := b[key]; !ok || !jsonEqual(value, other) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		b, ok := b.([]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !jsonEqual(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	case nil, bool, string:
+		return a == b
+	default:
+		return false
+	}
+}
+
+func jsonSchemaValues(values []interface{}) string {
+	formatted := make([]string, len(values))
+	for i, value := range values {
+		data, _ := json.Marshal(value)
+		formatted[i] = string(data)
+	}
+	return strings.Join(formatted, ", ")
+}
+
+func schemaCount(value interface{}) (*int, error) {
+	number, ok := jsonNumber(value)
+	if !ok || number < 0 || number != math.Trunc(number) {
+		return nil, fmt.Errorf("must be a non-negative integer")
+	}
+	count := int(number)
+	return &count, nil
+}
+
+func schemaNumber(value interface{}) (*float64, error) {
+	number, ok := jsonNumber(value)
+	if !ok {
+		return nil, fmt.Errorf("must be a number")
+	}
+	return &number, nil
+}
+
+// Escapes a property name for use in a JSON Pointer.
+func jsonPointerEscape(name string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(name)
+}
+
+// DocSchemas validates documents against the JSON Schema of their type as they're written, before the sync function
+// runs, so that sync functions don't have to check the structure of documents themselves.
+type DocSchemas struct {
+	TypeProperty string                 // The top-level property holding a document's type
+	Types        map[string]*JSONSchema // The schema of each type.  Documents of other types aren't validated
+}
+
+// Returns a 400 error listing the ways a document body doesn't match its type's schema, or nil if it does, or if it
+// has no schema.  Deletions and special properties (starting with "_") aren't validated.  Safe to call on a nil
+// *DocSchemas, which validates nothing.
+func (s *DocSchemas) Validate(docid string, body Body) error {
+	if s == nil {
+		return nil
+	}
+	if deleted, _ := body[BodyDeleted].(bool); deleted {
+		return nil
+	}
+	docType, _ := body[s.TypeProperty].(string)
+	schema := s.Types[docType]
+	if schema == nil {
+		return nil
+	}
+
+	properties := make(map[string]interface{}, len(body))
+	for key, value := range body {
+		if !strings.HasPrefix(key, "_") {
+			properties[key] = value
+		}
+	}
+	violations := schema.Validate(properties)
+	if len(violations) == 0 {
+		return nil
+	}
+	base.Debugf(base.KeyCRUD, "Doc %q doesn't match the schema for type %q: %v", base.UDDocID(docid), base.UD(docType), base.UD(violations))
+	message := fmt.Sprintf("Document doesn't match the schema for type %q: %s", docType, violations[0])
+	if len(violations) > 1 {
+		message += fmt.Sprintf(" (and %d more)", len(violations)-1)
+	}
+	err := base.CodedHTTPErrorf(http.StatusBadRequest, base.ErrorCodeInvalidDocument, "%s", message)
+	err.Details = violations
+	return err
+}
@@ -0,0 +1,93 @@
+package db
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/stretchr/testify/assert"
+)
+
+func parseSchemaJSON(t *testing.T, data string) (value interface{}) {
+	assert.NoError(t, json.Unmarshal([]byte(data), &value))
+	return value
+}
+
+func TestJSONSchema(t *testing.T) {
+	schema, err := NewJSONSchema(parseSchemaJSON(t, `{
+		"type": "object",
+		"required": ["name", "age"],
+		"additionalProperties": false,
+		"properties": {
+			"name": {"type": "string", "minLength": 2},
+			"age": {"type": "integer", "minimum": 0},
+			"kind": {"enum": ["cat", "dog"]},
+			"tags": {"type": "array", "items": {"$ref": "#/definitions/tag"}, "uniqueItems": true},
+			"id": {"oneOf": [{"type": "string"}, {"type": "number"}]}
+		},
+		"definitions": {"tag": {"type": "string", "pattern": "^[a-z]+$"}}
+	}`))
+	assert.NoError(t, err)
+
+	assert.Len(t, schema.Validate(parseSchemaJSON(t, `{"name":"Rex", "age":3, "tags":["good"], "id":7}`)), 0)
+	assert.Len(t, schema.Validate(Body{"name": "Rex", "age": int64(3)}), 0)
+	assert.Equal(t, []SchemaViolation{
+		{Path: "/age", Message: "must be of type integer, not number"},
+		{Path: "/extra", Message: "property is not allowed"},
+		{Path: "/id", Message: "must match exactly one of the oneOf schemas, not 0"},
+		{Path: "/kind", Message: `must be one of "cat", "dog"`},
+		{Path: "/name", Message: "must be at least 2 characters long"},
+		{Path: "/tags/1", Message: "duplicates item 0"},
+		{Path: "/tags/2", Message: `must match the pattern "^[a-z]+$"`},
+	}, schema.Validate(parseSchemaJSON(t, `{"name":"R", "age":3.5, "kind":"cow", "tags":["a","a","B"], "id":true, "extra":1}`)))
+	assert.Equal(t, []SchemaViolation{{Path: "", Message: `missing required property "age"`}},
+		schema.Validate(parseSchemaJSON(t, `{"name":"Rex"}`)))
+
+	// Recursive schemas are fine as long as each $ref checks a level further down
+	tree, err := NewJSONSchema(parseSchemaJSON(t, `{"required":["n"], "properties":{"child":{"$ref":"#"}}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, []SchemaViolation{{Path: "/child/child", Message: `missing required property "n"`}},
+		tree.Validate(parseSchemaJSON(t, `{"n":1, "child":{"n":2, "child":{}}}`)))
+
+	for schema, expectedErr := range map[string]string{
+		`3`:                                    "schema: a schema must be an object or a boolean",
+		`{"type":"date"}`:                      `/type: unknown type "date"`,
+		`{"properties":{"a":{"minimum":"1"}}}`: "/properties/a/minimum: must be a number",
+		`{"patternProperties":{}}`:             "/patternProperties: unsupported keyword",
+		`{"$ref":"#/definitions/missing"}`:     `/$ref: no definition for $ref "#/definitions/missing"`,
+		`{"anyOf":[{"$ref":"#"}]}`:             "schema: $ref loops back to this schema without checking a property or item",
+	} {
+		_, err := NewJSONSchema(parseSchemaJSON(t, schema))
+		if assert.Error(t, err, schema) {
+			assert.Equal(t, expectedErr, err.Error())
+		}
+	}
+}
+
+func TestDocSchemas(t *testing.T) {
+	db, testBucket := setupTestDB(t)
+	defer testBucket.Close()
+	defer tearDownTestDB(t, db)
+
+	schema, err := NewJSONSchema(parseSchemaJSON(t, `{"required":["title"], "properties":{"title":{"type":"string"}}}`))
+	assert.NoError(t, err)
+	db.Options.DocSchemas = &DocSchemas{TypeProperty: "type", Types: map[string]*JSONSchema{"task": schema}}
+
+	// Documents of other types aren't validated
+	_, err = db.Put("note1", Body{"type": "note"})
+	assert.NoError(t, err)
+	rev1, err := db.Put("task1", Body{"type": "task", "title": "Write tests"})
+	assert.NoError(t, err)
+
+	_, err = db.Put("task1", Body{BodyRev: rev1, "type": "task", "title": 5})
+	assertHTTPError(t, err, http.StatusBadRequest)
+	code, retryable := base.ErrorCode(err)
+	assert.Equal(t, base.ErrorCodeInvalidDocument, code)
+	assert.False(t, retryable)
+	assert.Equal(t, []SchemaViolation{{Path: "/title", Message: "must be of type string, not integer"}}, base.ErrorDetails(err))
+
+	// Deletions don't have to match
+	_, err = db.DeleteDoc("task1", rev1)
+	assert.NoError(t, err)
+}
@@ -37,6 +37,7 @@ type kvChangeIndex struct {
 	indexPartitions     *base.IndexPartitions // Partitioning of vbuckets in the index
 	indexPartitionsLock sync.RWMutex          // Manages access to indexPartitions
 	reader              *kvChangeIndexReader  // Index reader
+	writer              *kvChangeIndexWriter  // Index writer, when this node writes a distributed index
 }
 
 type IndexPartitionsFunc func() (*base.IndexPartitions, error)
@@ -56,6 +57,18 @@ func (k *kvChangeIndex) Init(context *DatabaseContext, onChange func(base.Set),
 		return err
 	}
 
+	if indexOptions.Distributed {
+		maxVbNo, err := context.Bucket.GetMaxVbno()
+		if err != nil {
+			return err
+		}
+		partitions, err := initDistributedIndexPartitions(k.reader.indexReadBucket, indexOptions.NumShards, maxVbNo)
+		if err != nil {
+			return err
+		}
+		k.writer = newKvChangeIndexWriter(context, k.reader.indexReadBucket, k.reader.denseStore, partitions)
+	}
+
 	return nil
 }
 
@@ -69,6 +82,9 @@ func (k *kvChangeIndex) Clear() error {
 }
 
 func (k *kvChangeIndex) Stop() {
+	if k.writer != nil {
+		k.writer.stop()
+	}
 	k.reader.Stop()
 }
 
@@ -359,7 +375,7 @@ func (db *DatabaseContext) singleChannelStats(kvIndex *kvChangeIndex, channelNam
 	}
 
 	// Retrieve index stats from bucket
-	channelIndex := NewKvChannelIndex(channelName, kvIndex.reader.indexReadBucket, indexPartitions, nil)
+	channelIndex := NewKvChannelIndex(channelName, kvIndex.reader.indexReadBucket, kvIndex.reader.denseStore, indexPartitions, nil)
 	indexClock, err := channelIndex.loadChannelClock()
 	if err == nil {
 		channelStats.IndexStats = ChannelIndexStats{}
@@ -412,8 +428,13 @@ func (k *kvChangeIndex) generatePartitionStats() (PartitionStats, error) {
 	return partitionStats, nil
 }
 
-// The following are no-ops for kvChangeIndex.
-func (k *kvChangeIndex) Start() error { return nil }
+// Starts writing the index, when this node writes a distributed index.
+func (k *kvChangeIndex) Start() error {
+	if k.writer != nil {
+		k.writer.start()
+	}
+	return nil
+}
 
 func IsNotFoundError(err error) bool {
 	return strings.Contains(strings.ToLower(err.Error()), "not found")
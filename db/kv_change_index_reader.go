@@ -33,6 +33,7 @@ var indexReaderGetChangesUseIndexed expvar.Int
 
 type kvChangeIndexReader struct {
 	indexReadBucket           base.Bucket                // Index bucket
+	denseStore                DenseStore                 // Where the channel blocks are kept - the index bucket, unless a local store was configured
 	readerStableSequence      *base.ShardedClock         // Initialized on first polling, updated on subsequent polls
 	readerStableSequenceLock  sync.RWMutex               // Coordinates read access to channel index reader map
 	channelIndexReaders       map[string]*KvChannelIndex // Manages read access to channel.  Map indexed by channel name.
@@ -77,6 +78,12 @@ func (k *kvChangeIndexReader) Init(options *CacheOptions, indexOptions *ChannelI
 		return err
 	}
 
+	k.denseStore, err = NewDenseStore(k.indexReadBucket, indexOptions.LocalStoreDir)
+	if err != nil {
+		base.Infof(base.KeyAll, "Error opening local channel index store in %s", base.MD(indexOptions.LocalStoreDir))
+		return err
+	}
+
 	k.maxVbNo, err = k.indexReadBucket.GetMaxVbno()
 	if err != nil {
 		return err
@@ -299,12 +306,12 @@ func (k *kvChangeIndexReader) GetChangesForRange(channelName string, sinceClock
 
 	reader, err := k.getOrCreateReader(channelName)
 	if err != nil {
-		base.Warnf(base.KeyAll, "Error obtaining channel reader (need partition index?) for channel %s", base.UD(channelName))
+		base.Warnf(base.KeyAll, "Error obtaining channel reader (need partition index?) for channel %s", base.UDChannel(channelName))
 		return nil, err
 	}
 	changes, err := reader.GetChanges(sinceClock, toClock, limit, activeOnly)
 	if err != nil {
-		base.Debugf(base.KeyAccel, "No clock found for channel %s, assuming no entries in index", base.UD(channelName))
+		base.Debugf(base.KeyAccel, "No clock found for channel %s, assuming no entries in index", base.UDChannel(channelName))
 		return nil, nil
 	}
 
@@ -318,10 +325,10 @@ func (k *kvChangeIndexReader) getOrCreateReader(channelName string) (*KvChannelI
 	if index == nil {
 		index, err = k.newChannelReader(channelName)
 		IndexExpvars.Add("getOrCreateReader_create", 1)
-		base.Debugf(base.KeyAccel, "getOrCreateReader: Created new reader for channel %s", base.UD(channelName))
+		base.Debugf(base.KeyAccel, "getOrCreateReader: Created new reader for channel %s", base.UDChannel(channelName))
 	} else {
 		IndexExpvars.Add("getOrCreateReader_get", 1)
-		base.Debugf(base.KeyAccel, "getOrCreateReader: Using existing reader for channel %s", base.UD(channelName))
+		base.Debugf(base.KeyAccel, "getOrCreateReader: Using existing reader for channel %s", base.UDChannel(channelName))
 	}
 	return index, err
 }
@@ -345,7 +352,7 @@ func (k *kvChangeIndexReader) newChannelReader(channelName string) (*KvChannelIn
 	if err != nil {
 		return nil, err
 	}
-	k.channelIndexReaders[channelName] = NewKvChannelIndex(channelName, k.indexReadBucket, indexPartitions, k.onChange)
+	k.channelIndexReaders[channelName] = NewKvChannelIndex(channelName, k.indexReadBucket, k.denseStore, indexPartitions, k.onChange)
 	indexReaderPersistentCount.Add(1)
 	return k.channelIndexReaders[channelName], nil
 }
@@ -0,0 +1,605 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	sgbucket "github.com/couchbase/sg-bucket"
+	"github.com/couchbase/sync_gateway/auth"
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbase/sync_gateway/channels"
+)
+
+const (
+	kIndexWriterLeasesKey          = base.KIndexPrefix + "_writerLeases" // Doc in the index bucket assigning partitions to nodes
+	kIndexWriterHeartbeatFrequency = 10 * time.Second                    // How often nodes renew their partition leases
+	kIndexWriterLeaseTTL           = 4 * kIndexWriterHeartbeatFrequency  // How long a lease lasts without being renewed
+	kIndexWriterFlushFrequency     = 100 * time.Millisecond              // How often a partition's buffered entries are written
+	kIndexWriterBatchSize          = 500                                 // Buffered entries that trigger a write before the next flush
+	kIndexWriterMaxCasRetries      = 10                                  // CAS failures writing a block before the write is abandoned
+)
+
+// Returns the channel index entries for a document in a feed event, keyed by channel: one for each channel the
+// document is in or has been removed from, plus the star channel when it's indexed.  If currentRemovalsOnly is true,
+// channels the document was removed from by an earlier revision are left out, as they're already in the index.
+// Returns false for documents without valid sync metadata.
+func feedDocIndexEntries(docID string, vbNo uint16, seq uint64, value []byte, dataType uint8, currentRemovalsOnly bool) (entries map[string]*LogEntry, ok bool) {
+	if strings.HasPrefix(docID, KSyncKeyPrefix) || len(value) == 0 {
+		return nil, false
+	}
+	syncData, _, _, err := UnmarshalDocumentSyncDataFromFeed(value, dataType, false)
+	if err != nil || syncData == nil || !syncData.HasValidSyncData(false) {
+		return nil, false
+	}
+
+	entries = make(map[string]*LogEntry, len(syncData.Channels)+1)
+	for channelName, removal := range syncData.Channels {
+		entry := &LogEntry{
+			DocID:    docID,
+			RevID:    syncData.CurrentRev,
+			Flags:    syncData.Flags,
+			VbNo:     vbNo,
+			Sequence: seq,
+		}
+		if removal != nil {
+			if currentRemovalsOnly && removal.RevID != syncData.CurrentRev {
+				continue
+			}
+			entry.RevID = removal.RevID
+			entry.Flags = channels.Removed
+			if removal.Deleted {
+				entry.Flags |= channels.Deleted
+			}
+		}
+		entries[channelName] = entry
+	}
+	if EnableStarChannelLog {
+		entries[channels.UserStarChannel] = &LogEntry{
+			DocID:    docID,
+			RevID:    syncData.CurrentRev,
+			Flags:    syncData.Flags,
+			VbNo:     vbNo,
+			Sequence: seq,
+		}
+	}
+	return entries, true
+}
+
+// Loads the index's partition map, first creating one with the given number of partitions if the index doesn't have
+// one yet.  Used by distributed index writers, which don't get their partitioning from SG Accel's cbgt.  If several
+// nodes start at once, the first one to write its map wins and the others load it.
+func initDistributedIndexPartitions(indexBucket base.Bucket, numPartitions uint16, maxVbNo uint16) (*base.IndexPartitions, error) {
+	if numPartitions == 0 || numPartitions > maxVbNo {
+		return nil, fmt.Errorf("Invalid number of channel index partitions %d for %d vbuckets", numPartitions, maxVbNo)
+	}
+	partitionDefs := make(base.PartitionStorageSet, numPartitions)
+	for partition := range partitionDefs {
+		partitionDefs[partition] = base.PartitionStorage{
+			Uuid:  base.CreateUUID(),
+			Index: uint16(partition),
+			VbNos: make([]uint16, 0, int(maxVbNo)/int(numPartitions)+1),
+		}
+	}
+	for vbNo := uint16(0); vbNo < maxVbNo; vbNo++ {
+		partition := uint32(vbNo) * uint32(numPartitions) / uint32(maxVbNo)
+		partitionDefs[partition].VbNos = append(partitionDefs[partition].VbNos, vbNo)
+	}
+	value, err := json.Marshal(partitionDefs)
+	if err != nil {
+		return nil, err
+	}
+	added, err := indexBucket.AddRaw(base.KIndexPartitionKey, 0, value)
+	if err != nil {
+		return nil, err
+	}
+	if added {
+		base.Infof(base.KeyAccel, "Created channel index partition map with %d partitions", numPartitions)
+	}
+	return loadRebuildIndexPartitions(indexBucket)
+}
+
+// The leases of a distributed index's partitions.  Each node writing the index registers itself, and holds leases on
+// an even share of the partitions, which it renews until it stops.  Nodes whose registrations expire lose their
+// leases, and the other nodes take over their partitions.  Registrations don't hold timestamps, as nodes' clocks
+// needn't agree: each renewal increments the node's heartbeat count, and the other nodes consider it expired once
+// they've seen the same count for the lease TTL, by their own clocks.
+type indexWriterLeases struct {
+	Heartbeats map[string]uint64 `json:"heartbeats"` // Number of times each registered node has renewed, by node ID
+	Holders    map[uint16]string `json:"holders"`    // ID of the node holding each leased partition
+}
+
+// When a node first saw another node's heartbeat count, by its own clock.
+type observedHeartbeat struct {
+	count uint64
+	since time.Time
+}
+
+// Renews a node's registration and leases, drops expired registrations with their leases and the node's released
+// leases, and claims free partitions for the node up to its share.  observed holds when the node has seen the other
+// nodes' heartbeats, and is updated.  Returns the partitions the node holds, and how many of them are over its
+// share, which it should release once it's stopped writing them.  If leave is true, the node's registration and
+// leases are removed instead.
+func (l *indexWriterLeases) update(nodeID string, numPartitions uint16, now time.Time, observed map[string]*observedHeartbeat, release map[uint16]bool, leave bool) (owned []uint16, excess int) {
+	if l.Heartbeats == nil {
+		l.Heartbeats = map[string]uint64{}
+	}
+	if l.Holders == nil {
+		l.Holders = map[uint16]string{}
+	}
+	for node, count := range l.Heartbeats {
+		if node == nodeID {
+			continue
+		}
+		if seen, ok := observed[node]; !ok || seen.count != count {
+			observed[node] = &observedHeartbeat{count: count, since: now}
+		} else if now.Sub(seen.since) > kIndexWriterLeaseTTL {
+			delete(l.Heartbeats, node)
+		}
+	}
+	for node := range observed {
+		if _, ok := l.Heartbeats[node]; !ok {
+			delete(observed, node)
+		}
+	}
+	if leave {
+		delete(l.Heartbeats, nodeID)
+	} else {
+		l.Heartbeats[nodeID]++
+	}
+	for partition, node := range l.Holders {
+		_, live := l.Heartbeats[node]
+		if !live || (node == nodeID && release[partition]) {
+			delete(l.Holders, partition)
+		}
+	}
+	if leave {
+		return nil, 0
+	}
+
+	share := (int(numPartitions) + len(l.Heartbeats) - 1) / len(l.Heartbeats)
+	for partition := uint16(0); partition < numPartitions; partition++ {
+		if l.Holders[partition] == nodeID {
+			owned = append(owned, partition)
+		}
+	}
+	for partition := uint16(0); partition < numPartitions && len(owned) < share; partition++ {
+		if _, ok := l.Holders[partition]; !ok && !release[partition] {
+			l.Holders[partition] = nodeID
+			owned = append(owned, partition)
+		}
+	}
+	sort.Slice(owned, func(i, j int) bool { return owned[i] < owned[j] })
+	if len(owned) > share {
+		excess = len(owned) - share
+	}
+	return owned, excess
+}
+
+// Starts a DCP feed of the given vbuckets after the given sequences, until the terminator is closed
+type indexFeedStarter func(vbNos []uint16, startSeqs map[uint16]uint64, callback base.FeedEventSeqCallbackFunc, terminator chan bool) error
+
+// kvChangeIndexWriter writes the dense channel index from a Sync Gateway node, for databases using the distributed
+// index mode instead of SG Accel.  The database's nodes share the index's partitions between them through
+// indexWriterLeases, and each node runs a DCP feed for the vbuckets of each partition it holds, starting where the
+// partition's stable sequences say the index is up to.  Partitions change hands when nodes join or leave, and the
+// block and clock writes are CAS-safe, so an entry written twice around a handover is only indexed once.
+type kvChangeIndexWriter struct {
+	indexBucket base.Bucket
+	store       DenseStore // Where the channel blocks are written
+	partitions  *base.IndexPartitions
+	nodeID      string
+	startFeed   indexFeedStarter
+	lock        sync.Mutex                       // Protects the fields below
+	writers     map[uint16]*partitionIndexWriter // Writers of the partitions this node holds
+	excess      int                              // Number of held partitions over this node's share
+	leaseExpiry time.Time                        // When this node's leases expire if they aren't renewed, by its own clock
+	observed    map[string]*observedHeartbeat    // When this node saw the other nodes' heartbeats
+	terminator  chan struct{}                    // Stops the heartbeat
+	stopped     bool
+}
+
+func newKvChangeIndexWriter(context *DatabaseContext, indexBucket base.Bucket, store DenseStore, partitions *base.IndexPartitions) *kvChangeIndexWriter {
+	return &kvChangeIndexWriter{
+		indexBucket: indexBucket,
+		store:       store,
+		partitions:  partitions,
+		nodeID:      base.CreateUUID(),
+		startFeed: func(vbNos []uint16, startSeqs map[uint16]uint64, callback base.FeedEventSeqCallbackFunc, terminator chan bool) error {
+			args := sgbucket.FeedArguments{Terminator: terminator}
+			return base.StartDCPFeedForVbuckets(context.Bucket, context.BucketSpec, args, vbNos, startSeqs, callback)
+		},
+		writers:    make(map[uint16]*partitionIndexWriter),
+		observed:   make(map[string]*observedHeartbeat),
+		terminator: make(chan struct{}),
+	}
+}
+
+// Claims this node's share of the partitions, and starts the heartbeat that keeps the leases up to date.
+func (w *kvChangeIndexWriter) start() {
+	w.heartbeat()
+	go func() {
+		for {
+			select {
+			case <-time.After(kIndexWriterHeartbeatFrequency):
+				w.heartbeat()
+			case <-w.terminator:
+				return
+			}
+		}
+	}()
+}
+
+// Renews this node's leases, then starts writing newly claimed partitions and stops writing lost ones.  Partitions
+// over this node's share are released, once their writers have stopped, so that nodes that have joined get some.
+func (w *kvChangeIndexWriter) heartbeat() {
+	w.lock.Lock()
+	if w.stopped {
+		w.lock.Unlock()
+		return
+	}
+
+	release := make(map[uint16]bool)
+	held := w._heldPartitions()
+	for i := 0; i < w.excess && i < len(held); i++ {
+		partition := held[len(held)-1-i]
+		w._stopPartition(partition)
+		release[partition] = true
+	}
+
+	// The expiry is measured from before the renewal, so it's no later than the other nodes will see it
+	now := time.Now()
+	owned, excess, err := w.updateLeases(now, release, false)
+	if err != nil {
+		base.Warnf(base.KeyAll, "Unable to renew channel index partition leases: %v", err)
+		if !now.Before(w.leaseExpiry.Add(-2 * kIndexWriterHeartbeatFrequency)) {
+			// The writers would carry on until the next heartbeat, which has to be at least a heartbeat before the
+			// leases expire and other nodes may take over, so stop writing now
+			for partition := range w.writers {
+				w._stopPartition(partition)
+			}
+		}
+		w.lock.Unlock()
+		return
+	}
+	w.leaseExpiry = now.Add(kIndexWriterLeaseTTL)
+	w.excess = excess
+
+	ownedSet := make(map[uint16]bool, len(owned))
+	var toStart []uint16
+	for _, partition := range owned {
+		ownedSet[partition] = true
+		if _, ok := w.writers[partition]; !ok {
+			toStart = append(toStart, partition)
+		}
+	}
+	for partition := range w.writers {
+		if !ownedSet[partition] {
+			base.Infof(base.KeyAccel, "Lost the lease on channel index partition %d", partition)
+			w._stopPartition(partition)
+		}
+	}
+	w.lock.Unlock()
+
+	// Opening the feeds may take a while, so it's done without the lock.  Only heartbeats start writers, and they
+	// don't overlap, so the partitions are still unwritten once the lock is taken again.
+	started := make(map[uint16]*partitionIndexWriter, len(toStart))
+	for _, partition := range toStart {
+		writer, err := w.startPartition(partition)
+		if err != nil {
+			// The lease is kept, so the partition is retried at the next heartbeat
+			base.Warnf(base.KeyAll, "Unable to start writing channel index partition %d: %v", partition, err)
+			continue
+		}
+		started[partition] = writer
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	for partition, writer := range started {
+		if w.stopped {
+			writer.stop()
+			continue
+		}
+		w.writers[partition] = writer
+		base.Infof(base.KeyAccel, "Started writing channel index partition %d", partition)
+	}
+}
+
+// Applies indexWriterLeases.update to the leases doc.  Requires the lock.
+func (w *kvChangeIndexWriter) updateLeases(now time.Time, release map[uint16]bool, leave bool) (owned []uint16, excess int, err error) {
+	numPartitions := uint16(w.partitions.PartitionCount())
+	_, err = w.indexBucket.Update(kIndexWriterLeasesKey, 0, func(currentValue []byte) ([]byte, *uint32, error) {
+		var leases indexWriterLeases
+		if len(currentValue) > 0 {
+			if err := json.Unmarshal(currentValue, &leases); err != nil {
+				return nil, nil, err
+			}
+		}
+		owned, excess = leases.update(w.nodeID, numPartitions, now, w.observed, release, leave)
+		updated, err := json.Marshal(leases)
+		return updated, nil, err
+	})
+	return owned, excess, err
+}
+
+// Returns the partitions this node is writing, in order.  Requires the lock.
+func (w *kvChangeIndexWriter) _heldPartitions() []uint16 {
+	held := make([]uint16, 0, len(w.writers))
+	for partition := range w.writers {
+		held = append(held, partition)
+	}
+	sort.Slice(held, func(i, j int) bool { return held[i] < held[j] })
+	return held
+}
+
+// Returns a writer for a partition, writing from its vbuckets' stable sequences.
+func (w *kvChangeIndexWriter) startPartition(partition uint16) (*partitionIndexWriter, error) {
+	stableClock := base.NewShardedClockWithPartitions(base.KStableSequenceKey, w.partitions, w.indexBucket)
+	if _, err := stableClock.Load(); err != nil {
+		return nil, err
+	}
+	vbNos := w.partitions.PartitionDefs[partition].VbNos
+	startSeqs := make(map[uint16]uint64, len(vbNos))
+	for _, vbNo := range vbNos {
+		startSeqs[vbNo] = stableClock.GetSequence(vbNo)
+	}
+
+	writer := newPartitionIndexWriter(partition, w.indexBucket, w.store, w.partitions)
+	if err := w.startFeed(vbNos, startSeqs, writer.processEvent, writer.feedTerminator); err != nil {
+		writer.stop()
+		return nil, err
+	}
+	return writer, nil
+}
+
+// Stops writing a partition, once its buffered entries are written.  Requires the lock.
+func (w *kvChangeIndexWriter) _stopPartition(partition uint16) {
+	if writer, ok := w.writers[partition]; ok {
+		writer.stop()
+		delete(w.writers, partition)
+		base.Infof(base.KeyAccel, "Stopped writing channel index partition %d", partition)
+	}
+}
+
+// Stops writing every partition, and gives up this node's leases so that the other nodes take them over.
+func (w *kvChangeIndexWriter) stop() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.stopped {
+		return
+	}
+	w.stopped = true
+	close(w.terminator)
+	for partition := range w.writers {
+		w._stopPartition(partition)
+	}
+	if _, _, err := w.updateLeases(time.Now(), nil, true); err != nil {
+		base.Warnf(base.KeyAll, "Unable to release channel index partition leases: %v", err)
+	}
+}
+
+// partitionIndexWriter writes the entries from one partition's DCP feed to the partition's block lists, then the
+// channel clocks, then the stable clock, so that readers never see a stable sequence for an entry that isn't in the
+// index yet.  Entries are buffered and written in batches.
+type partitionIndexWriter struct {
+	partition      uint16
+	indexBucket    base.Bucket
+	store          DenseStore // Where the channel blocks are written
+	stableClock    *base.ShardedClock
+	lock           sync.Mutex                 // Protects the buffered entries and stopped
+	pending        map[string][]*LogEntry     // Buffered entries, by channel
+	pendingCount   int                        // Number of buffered entries
+	vbSeqs         map[uint16]uint64          // Latest sequence received for each vbucket since the last write
+	principals     []string                   // Keys of users and roles changed since the last write
+	stopped        bool                       // Set once stop is called; later feed events are ignored
+	flushLock      sync.Mutex                 // Serializes writes to the index
+	lists          map[string]*DenseBlockList // The partition's block list for each channel written
+	feedTerminator chan bool                  // Stops the DCP feed
+	terminator     chan struct{}              // Stops the flush loop
+	done           chan struct{}              // Closed when the flush loop has stopped
+}
+
+func newPartitionIndexWriter(partition uint16, indexBucket base.Bucket, store DenseStore, partitions *base.IndexPartitions) *partitionIndexWriter {
+	w := &partitionIndexWriter{
+		partition:      partition,
+		indexBucket:    indexBucket,
+		store:          store,
+		stableClock:    base.NewShardedClock(base.KStableSequenceKey, partitions, indexBucket),
+		pending:        make(map[string][]*LogEntry),
+		vbSeqs:         make(map[uint16]uint64),
+		lists:          make(map[string]*DenseBlockList),
+		feedTerminator: make(chan bool),
+		terminator:     make(chan struct{}),
+		done:           make(chan struct{}),
+	}
+	go func() {
+		defer close(w.done)
+		for {
+			select {
+			case <-time.After(kIndexWriterFlushFrequency):
+				w.flush()
+			case <-w.terminator:
+				return
+			}
+		}
+	}()
+	return w
+}
+
+// Buffers the index entries for a feed event, writing the buffer straight away if it's full.
+func (w *partitionIndexWriter) processEvent(event sgbucket.FeedEvent, seq uint64) bool {
+	docID := string(event.Key)
+	w.lock.Lock()
+	if w.stopped {
+		w.lock.Unlock()
+		return false
+	}
+	if event.Opcode == sgbucket.FeedOpMutation || event.Opcode == sgbucket.FeedOpDeletion {
+		if strings.HasPrefix(docID, auth.UserKeyPrefix) || strings.HasPrefix(docID, auth.RoleKeyPrefix) {
+			w.principals = append(w.principals, docID)
+		} else if entries, ok := feedDocIndexEntries(docID, event.VbNo, seq, event.Value, event.DataType, true); ok {
+			for channelName, entry := range entries {
+				if genOfRevID(entry.RevID) == 1 && entry.Flags&channels.Removed == 0 {
+					// A first revision can't have an earlier entry to replace
+					entry.Flags |= channels.Added
+				}
+				w.pending[channelName] = append(w.pending[channelName], entry)
+				w.pendingCount++
+			}
+		}
+	}
+	if seq > w.vbSeqs[event.VbNo] {
+		w.vbSeqs[event.VbNo] = seq
+	}
+	full := w.pendingCount >= kIndexWriterBatchSize
+	w.lock.Unlock()
+
+	if full {
+		w.flush()
+	}
+	return false
+}
+
+// Writes the buffered entries to the index.  If that fails, they're put back in the buffer to be retried, and the
+// stable clock isn't advanced past them.
+func (w *partitionIndexWriter) flush() {
+	w.flushLock.Lock()
+	defer w.flushLock.Unlock()
+
+	w.lock.Lock()
+	pending, pendingCount, vbSeqs, principals := w.pending, w.pendingCount, w.vbSeqs, w.principals
+	w.pending, w.pendingCount, w.vbSeqs, w.principals = make(map[string][]*LogEntry), 0, make(map[uint16]uint64), nil
+	w.lock.Unlock()
+	if len(vbSeqs) == 0 && len(principals) == 0 {
+		return
+	}
+
+	if err := w.write(pending, vbSeqs, principals); err != nil {
+		base.Warnf(base.KeyAll, "Unable to write channel index partition %d - will retry: %v", w.partition, err)
+		w.lock.Lock()
+		for channelName, entries := range pending {
+			w.pending[channelName] = append(entries, w.pending[channelName]...)
+		}
+		w.pendingCount += pendingCount
+		for vbNo, seq := range vbSeqs {
+			if seq > w.vbSeqs[vbNo] {
+				w.vbSeqs[vbNo] = seq
+			}
+		}
+		w.principals = append(principals, w.principals...)
+		w.lock.Unlock()
+	}
+}
+
+func (w *partitionIndexWriter) write(pending map[string][]*LogEntry, vbSeqs map[uint16]uint64, principals []string) error {
+	for channelName, entries := range pending {
+		if err := w.writeChannelEntries(channelName, entries); err != nil {
+			return err
+		}
+	}
+	for _, principalKey := range principals {
+		if _, err := w.indexBucket.Incr(fmt.Sprintf(base.KPrincipalCountKeyFormat, principalKey), 1, 1, 0); err != nil {
+			return err
+		}
+	}
+	if len(principals) > 0 {
+		if _, err := w.indexBucket.Incr(base.KTotalPrincipalCountKey, 1, 1, 0); err != nil {
+			return err
+		}
+	}
+	return w.stableClock.UpdateAndWrite(vbSeqs)
+}
+
+// Writes a channel's entries to the partition's active block, adding blocks as they fill up, then advances the
+// channel clock.
+func (w *partitionIndexWriter) writeChannelEntries(channelName string, entries []*LogEntry) error {
+	list, ok := w.lists[channelName]
+	if !ok {
+		if list = NewDenseBlockList(channelName, w.partition, w.store); list == nil {
+			return fmt.Errorf("Unable to initialize block list for channel %s partition %d", base.UDChannel(channelName), w.partition)
+		}
+		w.lists[channelName] = list
+	}
+
+	clock := base.NewSequenceClockImpl()
+	for _, entry := range entries {
+		clock.SetMaxSequence(entry.VbNo, entry.Sequence)
+	}
+
+	block := list.GetActiveBlock()
+	casFailures := 0
+	for len(entries) > 0 {
+		overflow, pendingRemoval, _, casFailure, err := block.AddEntrySet(entries, w.store)
+		if err != nil {
+			return err
+		}
+		if casFailure {
+			// A previous owner of the partition is still finishing its writes.  Reload the list, since it may have
+			// added blocks, and retry - entries it's already written are skipped.
+			if casFailures++; casFailures > kIndexWriterMaxCasRetries {
+				return fmt.Errorf("Too many CAS failures writing block %s", base.UD(block.Key))
+			}
+			if _, err := list.ReloadDenseBlockList(); err != nil {
+				return err
+			}
+			block = list.GetActiveBlock()
+			continue
+		}
+		if len(pendingRemoval) > 0 {
+			if err := removeFromPreviousBlocks(list, pendingRemoval); err != nil {
+				return err
+			}
+			block = list.GetActiveBlock()
+		}
+		if len(overflow) == 0 {
+			break
+		}
+		if block, err = list.AddBlock(); err != nil {
+			return err
+		}
+		entries = overflow
+	}
+
+	return updateChannelClock(w.indexBucket, channelName, clock)
+}
+
+// Stops the feed and the flush loop, then writes what's left in the buffer.
+func (w *partitionIndexWriter) stop() {
+	close(w.feedTerminator)
+	close(w.terminator)
+	<-w.done
+	w.lock.Lock()
+	w.stopped = true
+	w.lock.Unlock()
+	w.flush()
+}
+
+// Advances a channel's clock to the given sequences, keeping any later ones other writers have written.
+func updateChannelClock(indexBucket base.Bucket, channelName string, updates *base.SequenceClockImpl) error {
+	value, err := updates.Marshal()
+	if err != nil {
+		return err
+	}
+	_, err = base.WriteCasRaw(indexBucket, GetChannelClockKey(channelName), value, 0, 0, func(currentValue []byte) ([]byte, error) {
+		clock := base.NewSequenceClockImpl()
+		if err := clock.Unmarshal(currentValue); err != nil {
+			return nil, err
+		}
+		changed := false
+		for vbNo, seq := range updates.ValueAsMap() {
+			if seq > clock.GetSequence(vbNo) {
+				clock.SetSequence(vbNo, seq)
+				changed = true
+			}
+		}
+		if !changed {
+			return nil, nil
+		}
+		return clock.Marshal()
+	})
+	return err
+}
@@ -0,0 +1,185 @@
+package db
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbase/sync_gateway/channels"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInitDistributedIndexPartitions(t *testing.T) {
+
+	testIndexBucket := base.GetTestIndexBucketOrPanic()
+	defer testIndexBucket.Close()
+	indexBucket := testIndexBucket.Bucket
+
+	_, err := initDistributedIndexPartitions(indexBucket, 0, 1024)
+	assert.Error(t, err)
+
+	partitions, err := initDistributedIndexPartitions(indexBucket, 3, 1024)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, partitions.PartitionCount())
+	numVbs := 0
+	for _, partitionDef := range partitions.PartitionDefs {
+		assert.True(t, len(partitionDef.VbNos) >= 341)
+		numVbs += len(partitionDef.VbNos)
+	}
+	assert.Equal(t, 1024, numVbs)
+	assert.Equal(t, uint16(0), partitions.PartitionForVb(0))
+	assert.Equal(t, uint16(2), partitions.PartitionForVb(1023))
+
+	// Nodes starting later use the existing map
+	partitions, err = initDistributedIndexPartitions(indexBucket, 16, 1024)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, partitions.PartitionCount())
+}
+
+func TestIndexWriterLeases(t *testing.T) {
+	var leases indexWriterLeases
+	now := time.Now()
+	observed := map[string]map[string]*observedHeartbeat{"a": {}, "b": {}, "c": {}}
+	update := func(node string, at time.Time, release map[uint16]bool, leave bool) ([]uint16, int) {
+		return leases.update(node, 4, at, observed[node], release, leave)
+	}
+
+	owned, excess := update("a", now, nil, false)
+	assert.Equal(t, []uint16{0, 1, 2, 3}, owned)
+	assert.Equal(t, 0, excess)
+
+	// A new node gets nothing until the first node releases its excess
+	owned, excess = update("b", now, nil, false)
+	assert.Len(t, owned, 0)
+	owned, excess = update("a", now, nil, false)
+	assert.Len(t, owned, 4)
+	assert.Equal(t, 2, excess)
+	owned, excess = update("a", now, map[uint16]bool{2: true, 3: true}, false)
+	assert.Equal(t, []uint16{0, 1}, owned)
+	assert.Equal(t, 0, excess)
+	owned, _ = update("b", now, nil, false)
+	assert.Equal(t, []uint16{2, 3}, owned)
+
+	// Partitions of nodes that leave are taken over
+	owned, _ = update("a", now, nil, true)
+	assert.Len(t, owned, 0)
+	owned, _ = update("b", now, nil, false)
+	assert.Equal(t, []uint16{0, 1, 2, 3}, owned)
+
+	// As are those of nodes that stop renewing, once another node has seen the same heartbeat count for the TTL by
+	// its own clock, however far apart the nodes' clocks are
+	later := now.Add(time.Hour)
+	owned, _ = update("c", later, nil, false)
+	assert.Len(t, owned, 0)
+	owned, _ = update("c", later.Add(kIndexWriterLeaseTTL), nil, false)
+	assert.Len(t, owned, 0)
+	owned, _ = update("c", later.Add(kIndexWriterLeaseTTL+time.Second), nil, false)
+	assert.Equal(t, []uint16{0, 1, 2, 3}, owned)
+	assert.Len(t, leases.Heartbeats, 1)
+	assert.Len(t, observed["c"], 0)
+}
+
+func TestPartitionIndexWriter(t *testing.T) {
+
+	testIndexBucket := base.GetTestIndexBucketOrPanic()
+	defer testIndexBucket.Close()
+	indexBucket := testIndexBucket.Bucket
+
+	partitions, err := initDistributedIndexPartitions(indexBucket, 4, 1024)
+	assert.NoError(t, err)
+
+	w := newPartitionIndexWriter(0, indexBucket, NewBucketDenseStore(indexBucket), partitions)
+	w.processEvent(rebuildFeedEvent("doc1", 1, `{"_sync":{"rev":"1-a","sequence":10,"channels":{"ABC":null}}}`), 2)
+	w.processEvent(rebuildFeedEvent("doc2", 3, `{"_sync":{"rev":"1-a","sequence":11,"channels":{"ABC":null,"DEF":null}}}`), 4)
+	w.flush()
+	w.processEvent(rebuildFeedEvent("doc1", 1, `{"_sync":{"rev":"2-b","sequence":12,"channels":{"ABC":null}}}`), 5)
+	w.processEvent(rebuildFeedEvent("doc2", 3, `{"_sync":{"rev":"2-b","sequence":13,"channels":{"ABC":null,"DEF":{"seq":13,"rev":"2-b"}}}}`), 6)
+	w.processEvent(rebuildFeedEvent("_sync:user:bob", 3, `{"name":"bob"}`), 7)
+	w.stop()
+
+	// New revisions replace the earlier entries
+	abcEntries := NewDenseBlockListReader("ABC", 0, w.store).GetActiveBlock().GetAllEntries()
+	if assert.Len(t, abcEntries, 2) {
+		assertLogEntry(t, abcEntries[0], "doc1", "2-b", 1, 5)
+		assertLogEntry(t, abcEntries[1], "doc2", "2-b", 3, 6)
+	}
+	defEntries := NewDenseBlockListReader("DEF", 0, w.store).GetActiveBlock().GetAllEntries()
+	if assert.Len(t, defEntries, 1) {
+		assertLogEntry(t, defEntries[0], "doc2", "2-b", 3, 6)
+		assert.True(t, defEntries[0].Flags&channels.Removed != 0)
+	}
+
+	value, _, err := indexBucket.GetRaw(GetChannelClockKey("ABC"))
+	assert.NoError(t, err)
+	clock := base.NewSequenceClockImpl()
+	assert.NoError(t, clock.Unmarshal(value))
+	assert.Equal(t, uint64(5), clock.GetSequence(1))
+	assert.Equal(t, uint64(6), clock.GetSequence(3))
+
+	// The stable clock includes the sequences of docs that aren't in any channel
+	stableClock := base.NewShardedClockWithPartitions(base.KStableSequenceKey, partitions, indexBucket)
+	_, err = stableClock.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(5), stableClock.GetSequence(1))
+	assert.Equal(t, uint64(7), stableClock.GetSequence(3))
+
+	count, err := indexBucket.Incr(fmt.Sprintf(base.KPrincipalCountKeyFormat, "_sync:user:bob"), 0, 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), count)
+}
+
+func TestKvChangeIndexWriterHeartbeat(t *testing.T) {
+
+	testIndexBucket := base.GetTestIndexBucketOrPanic()
+	defer testIndexBucket.Close()
+	indexBucket := testIndexBucket.Bucket
+
+	partitions, err := initDistributedIndexPartitions(indexBucket, 4, 1024)
+	assert.NoError(t, err)
+
+	newWriter := func() *kvChangeIndexWriter {
+		w := newKvChangeIndexWriter(nil, indexBucket, NewBucketDenseStore(indexBucket), partitions)
+		w.startFeed = func(vbNos []uint16, startSeqs map[uint16]uint64, callback base.FeedEventSeqCallbackFunc, terminator chan bool) error {
+			assert.Len(t, startSeqs, len(vbNos))
+			return nil
+		}
+		return w
+	}
+	heldPartitions := func(w *kvChangeIndexWriter) []uint16 {
+		w.lock.Lock()
+		defer w.lock.Unlock()
+		return w._heldPartitions()
+	}
+
+	w1 := newWriter()
+	w1.heartbeat()
+	assert.Equal(t, []uint16{0, 1, 2, 3}, heldPartitions(w1))
+
+	// A second node takes over half of the partitions, once the first has stopped writing them
+	w2 := newWriter()
+	w2.heartbeat()
+	assert.Len(t, heldPartitions(w2), 0)
+	w1.heartbeat()
+	w1.heartbeat()
+	assert.Equal(t, []uint16{0, 1}, heldPartitions(w1))
+	w2.heartbeat()
+	assert.Equal(t, []uint16{2, 3}, heldPartitions(w2))
+
+	// When a node stops, the others take over its partitions
+	w1.stop()
+	w2.heartbeat()
+	assert.Equal(t, []uint16{0, 1, 2, 3}, heldPartitions(w2))
+
+	// A node that can't renew its leases keeps writing after one failure, but stops while the leases are still a
+	// heartbeat from expiring
+	assert.NoError(t, indexBucket.SetRaw(kIndexWriterLeasesKey, 0, []byte("not JSON")))
+	w2.heartbeat()
+	assert.Len(t, heldPartitions(w2), 4)
+	w2.lock.Lock()
+	w2.leaseExpiry = time.Now().Add(2*kIndexWriterHeartbeatFrequency - time.Second)
+	w2.lock.Unlock()
+	w2.heartbeat()
+	assert.Len(t, heldPartitions(w2), 0)
+	w2.stop()
+}
@@ -48,13 +48,13 @@ type KvChannelIndex struct {
 	partitions             *base.IndexPartitions   // Partition map
 }
 
-func NewKvChannelIndex(channelName string, bucket base.Bucket, partitions *base.IndexPartitions, onChangeCallback func(base.Set)) *KvChannelIndex {
+func NewKvChannelIndex(channelName string, bucket base.Bucket, store DenseStore, partitions *base.IndexPartitions, onChangeCallback func(base.Set)) *KvChannelIndex {
 
 	channelIndex := &KvChannelIndex{
 		channelName:    channelName,
 		indexBucket:    bucket,
 		onChange:       onChangeCallback,
-		channelStorage: NewDenseStorageReader(bucket, channelName, partitions),
+		channelStorage: NewDenseStorageReader(store, channelName, partitions),
 		partitions:     partitions,
 	}
 
@@ -77,7 +77,7 @@ func (k *KvChannelIndex) pollForChanges(stableClock base.SequenceClock, newChann
 	if unreadPollCount > kMaxUnreadPollCount {
 		// We've sent a notify, but had (kMaxUnreadPollCount) polls without anyone calling getChanges.
 		// Assume nobody is listening for updates - cancel polling for this channel
-		base.Debugf(base.KeyAccel, "Cancelling polling for channel %s", base.UD(k.channelName))
+		base.Debugf(base.KeyAccel, "Cancelling polling for channel %s", base.UDChannel(k.channelName))
 		return false, true
 	}
 
@@ -113,13 +113,13 @@ func (k *KvChannelIndex) pollForChanges(stableClock base.SequenceClock, newChann
 	}
 
 	if hasPostStableChanges {
-		base.Debugf(base.KeyAccel, "Channel %s has changes later than the stable sequence - will be updated in next polling cycle.", base.UD(k.channelName))
+		base.Debugf(base.KeyAccel, "Channel %s has changes later than the stable sequence - will be updated in next polling cycle.", base.UDChannel(k.channelName))
 	}
 	k.lastPolledPostStable = hasPostStableChanges
 
 	// The clock has changed - load the changes and store in last polled
 	if err := k.updateLastPolled(stableClock, newChannelClock, changedPartitions); err != nil {
-		base.Warnf(base.KeyAll, "Error updating last polled for channel %s: %v", base.UD(k.channelName), err)
+		base.Warnf(base.KeyAll, "Error updating last polled for channel %s: %v", base.UDChannel(k.channelName), err)
 		return false, false
 	}
 
@@ -264,7 +264,7 @@ func (k *KvChannelIndex) loadChannelClock() (base.SequenceClock, error) {
 	}
 	err = chanClock.Unmarshal(value)
 	if err != nil {
-		base.Warnf(base.KeyAll, "Error unmarshalling channel clock for channel %s, clock value %v", base.UD(k.channelName), value)
+		base.Warnf(base.KeyAll, "Error unmarshalling channel clock for channel %s, clock value %v", base.UDChannel(k.channelName), value)
 	}
 	return chanClock, err
 }
@@ -281,7 +281,7 @@ func (k *KvChannelIndex) loadClock() {
 	}
 	data, cas, err := k.indexBucket.GetRaw(GetChannelClockKey(k.channelName))
 	if err != nil {
-		base.Debugf(base.KeyAccel, "Unable to find existing channel clock for channel %s - treating as new", base.UD(k.channelName))
+		base.Debugf(base.KeyAccel, "Unable to find existing channel clock for channel %s - treating as new", base.UDChannel(k.channelName))
 	}
 	k.clock.Unmarshal(data)
 	k.clock.SetCas(cas)
@@ -14,7 +14,6 @@ import (
 	"encoding/binary"
 	"fmt"
 
-	sgbucket "github.com/couchbase/sg-bucket"
 	"github.com/couchbase/sync_gateway/base"
 	"github.com/couchbase/sync_gateway/channels"
 )
@@ -37,7 +36,7 @@ const DB_HEADER_LEN = 2 // Length of block header.  Currently only contains entr
 // a new entry is added to entries to store key/revId/flags, and entry count is incremented.
 // The _clock field is lazily loaded because DenseBlock is used for both readers and writers, and readers don't care about the cumulative clock.
 type DenseBlock struct {
-	Key        string              // Key of block document in the index bucket
+	Key        string              // Key of block document in the index store
 	value      []byte              // Binary storage of block data, in the above format
 	cas        uint64              // Document cas
 	_clock     base.PartitionClock // Highest seq per vbucket written to the block.  Unsafe to read directly due to lazy loading, use getClock() instead.
@@ -94,9 +93,9 @@ func (d *DenseBlock) getCumulativeClock() base.PartitionClock {
 	return cumulativeClock
 }
 
-func (d *DenseBlock) loadBlock(bucket base.Bucket) error {
+func (d *DenseBlock) loadBlock(store DenseStore) error {
 
-	value, cas, err := bucket.GetRaw(d.Key)
+	value, cas, err := store.GetRaw(d.Key)
 	if err != nil {
 		return err
 	}
@@ -124,8 +123,8 @@ func (d *DenseBlock) initClock() {
 	}
 }
 
-// Adds entries to block and writes block to the bucket
-func (d *DenseBlock) AddEntrySet(entries []*LogEntry, bucket base.Bucket) (overflow []*LogEntry, pendingRemoval []*LogEntry, updateClock base.PartitionClock, casFailure bool, err error) {
+// Adds entries to block and writes block to the store
+func (d *DenseBlock) AddEntrySet(entries []*LogEntry, store DenseStore) (overflow []*LogEntry, pendingRemoval []*LogEntry, updateClock base.PartitionClock, casFailure bool, err error) {
 
 	casFailure = false
 	// Check if block is already full.  If so, return all entries as overflow.
@@ -141,7 +140,7 @@ func (d *DenseBlock) AddEntrySet(entries []*LogEntry, bucket base.Bucket) (overf
 	if addError != nil {
 		// Error adding entries - reset the block and return error
 		base.Debugf(base.KeyAccel, "Error adding entries to block. %v", err)
-		d.loadBlock(bucket)
+		d.loadBlock(store)
 		return nil, nil, nil, casFailure, addError
 	}
 
@@ -149,7 +148,7 @@ func (d *DenseBlock) AddEntrySet(entries []*LogEntry, bucket base.Bucket) (overf
 		return overflow, pendingRemoval, updateClock, false, nil
 	}
 
-	casOut, err := bucket.WriteCas(d.Key, 0, 0, d.cas, d.value, sgbucket.Raw)
+	casOut, err := store.WriteCas(d.Key, d.cas, d.value, true)
 	if err != nil {
 		casFailure = true
 		base.Debugf(base.KeyAccel, "Block (%s) CAS error writing block to database. %v", d, err)
@@ -174,7 +173,7 @@ func (d *DenseBlock) addEntries(entries []*LogEntry) (overflow []*LogEntry, pend
 		if !blockFull {
 			changed, removalRequired, err := d.addEntry(entry)
 			if err != nil {
-				base.Debugf(base.KeyAccel, "Error adding entry to block.  key:[%s] error:%v", base.UD(entry.DocID), err)
+				base.Debugf(base.KeyAccel, "Error adding entry to block.  key:[%s] error:%v", base.UDDocID(entry.DocID), err)
 				return nil, nil, nil, false, err
 			}
 			if changed {
@@ -280,7 +279,7 @@ func (d *DenseBlock) findLogEntry(logEntry *LogEntry) (oldIndexPos, oldEntryPos
 }
 
 // Attempts to remove entries from the block
-func (d *DenseBlock) RemoveEntrySet(entries []*LogEntry, bucket base.Bucket) (pendingRemoval []*LogEntry, err error) {
+func (d *DenseBlock) RemoveEntrySet(entries []*LogEntry, store DenseStore) (pendingRemoval []*LogEntry, err error) {
 
 	pendingRemoval = d.removeEntries(entries)
 	// If nothing was removed, don't update the block
@@ -288,7 +287,7 @@ func (d *DenseBlock) RemoveEntrySet(entries []*LogEntry, bucket base.Bucket) (pe
 		return entries, nil
 	}
 
-	casOut, writeErr := base.WriteCasRaw(bucket, d.Key, d.value, d.cas, 0, func(value []byte) (updatedValue []byte, err error) {
+	casOut, writeErr := denseStoreWriteCasRaw(store, d.Key, d.value, d.cas, func(value []byte) (updatedValue []byte, err error) {
 		// Note: The following is invoked upon cas failure - may be called multiple times
 		d.value = value
 		d._clock = nil
@@ -314,7 +313,7 @@ func (d *DenseBlock) RemoveEntrySet(entries []*LogEntry, bucket base.Bucket) (pe
 
 // Removes all entries greater than vb, seq from the block.  Returns rollbackComplete=true if it finds a seq
 // in the block for the vbucket where seq <= rollbackSeq
-func (d *DenseBlock) RollbackTo(rollbackVbNo uint16, rollbackSeq uint64, bucket base.Bucket) (rollbackComplete bool, err error) {
+func (d *DenseBlock) RollbackTo(rollbackVbNo uint16, rollbackSeq uint64, store DenseStore) (rollbackComplete bool, err error) {
 
 	numRemoved := 0
 	numRemoved, rollbackComplete = d.rollbackEntries(rollbackVbNo, rollbackSeq)
@@ -326,7 +325,7 @@ func (d *DenseBlock) RollbackTo(rollbackVbNo uint16, rollbackSeq uint64, bucket
 		d._clock = nil
 	}
 
-	casOut, writeErr := base.WriteCasRaw(bucket, d.Key, d.value, d.cas, 0, func(value []byte) (updatedValue []byte, err error) {
+	casOut, writeErr := denseStoreWriteCasRaw(store, d.Key, d.value, d.cas, func(value []byte) (updatedValue []byte, err error) {
 		// Note: The following is invoked upon cas failure - may be called multiple times
 		d.value = value
 		d._clock = nil
@@ -28,7 +28,7 @@ var MaxListBlockCount = 1000 // When the number of blocks in the active list exc
 // clock for that DenseBlock.  The list is persisted into one or more documents (DenseBlockListStorage) in the index.
 // The active list has key activeKey - older lists are rotated into activeKey_n
 type DenseBlockList struct {
-	indexBucket      base.Bucket           // Index Bucket
+	store            DenseStore            // Index store
 	blocks           []DenseBlockListEntry // Dense Block keys
 	activeKey        string                // Key for active list doc
 	activeCas        uint64                // Cas for active list doc
@@ -70,12 +70,12 @@ func (e *DenseBlockListEntry) Key(parentList *DenseBlockList) string {
 	return e.key
 }
 
-func NewDenseBlockList(channelName string, partition uint16, indexBucket base.Bucket) *DenseBlockList {
+func NewDenseBlockList(channelName string, partition uint16, store DenseStore) *DenseBlockList {
 
 	list := &DenseBlockList{
 		channelName: channelName,
 		partition:   partition,
-		indexBucket: indexBucket,
+		store:       store,
 	}
 	list.activeKey = list.generateActiveListKey()
 	err := list.initDenseBlockList()
@@ -86,12 +86,12 @@ func NewDenseBlockList(channelName string, partition uint16, indexBucket base.Bu
 	return list
 }
 
-func NewDenseBlockListReader(channelName string, partition uint16, indexBucket base.Bucket) *DenseBlockList {
+func NewDenseBlockListReader(channelName string, partition uint16, store DenseStore) *DenseBlockList {
 
 	list := &DenseBlockList{
 		channelName: channelName,
 		partition:   partition,
-		indexBucket: indexBucket,
+		store:       store,
 	}
 	list.activeKey = list.generateActiveListKey()
 	found, err := list.loadDenseBlockList()
@@ -102,6 +102,22 @@ func NewDenseBlockListReader(channelName string, partition uint16, indexBucket b
 	return list
 }
 
+// Loads an existing DenseBlockList for the channel and partition, without initializing one.  Returns nil if the
+// channel has no block list in the partition.
+func loadExistingDenseBlockList(channelName string, partition uint16, store DenseStore) (*DenseBlockList, error) {
+	list := &DenseBlockList{
+		channelName: channelName,
+		partition:   partition,
+		store:       store,
+	}
+	list.activeKey = list.generateActiveListKey()
+	found, err := list.loadDenseBlockList()
+	if err != nil || !found {
+		return nil, err
+	}
+	return list, nil
+}
+
 func (l *DenseBlockList) ActiveListEntry() *DenseBlockListEntry {
 	if len(l.blocks) == 0 {
 		return nil
@@ -158,7 +174,7 @@ func (l *DenseBlockList) AddBlock() (*DenseBlock, error) {
 		nextStartClock = l.activeBlock.getCumulativeClock()
 	}
 
-	base.Debugf(base.KeyAccel, "Adding block to list. channel:[%s] partition:[%d] index:[%d]", base.UD(l.channelName), l.partition, nextIndex)
+	base.Debugf(base.KeyAccel, "Adding block to list. channel:[%s] partition:[%d] index:[%d]", base.UDChannel(l.channelName), l.partition, nextIndex)
 
 	nextBlockKey := l.generateBlockKey(nextIndex)
 	block := NewDenseBlock(nextBlockKey, nextStartClock)
@@ -182,9 +198,9 @@ func (l *DenseBlockList) AddBlock() (*DenseBlock, error) {
 	if err != nil {
 		return nil, err
 	}
-	casOut, err := l.indexBucket.WriteCas(l.activeKey, 0, 0, l.activeCas, storageValue, 0)
+	casOut, err := denseStoreWriteCas(l.store, l.activeKey, l.activeCas, storageValue)
 	if err != nil {
-		base.Debugf(base.KeyAccel, "DenseBlockList %s got CAS error trying to persist to bucket.  Reloading and retrying", l)
+		base.Debugf(base.KeyAccel, "DenseBlockList %s got CAS error trying to persist to the index store.  Reloading and retrying", l)
 		// CAS error.  If there's a concurrent writer for this partition, assume they have created the new block.
 		//  Re-initialize the current block list, and get the active block key from there.
 		found, err := l.loadDenseBlockList()
@@ -198,7 +214,7 @@ func (l *DenseBlockList) AddBlock() (*DenseBlock, error) {
 	}
 	l.activeCas = casOut
 	l.activeBlock = block
-	base.Debugf(base.KeyAccel, "Successfully added block to list. channel:[%s] partition:[%d] index:[%d] activeBlocks:[%d]", base.UD(l.channelName), l.partition, nextIndex, len(l.blocks))
+	base.Debugf(base.KeyAccel, "Successfully added block to list. channel:[%s] partition:[%d] index:[%d] activeBlocks:[%d]", base.UDChannel(l.channelName), l.partition, nextIndex, len(l.blocks))
 
 	return block, nil
 }
@@ -221,7 +237,7 @@ func (l *DenseBlockList) rotate() error {
 	if err != nil {
 		return err
 	}
-	_, err = l.indexBucket.WriteCas(rotatedKey, 0, 0, 0, rotatedStorageValue, 0)
+	_, err = denseStoreWriteCas(l.store, rotatedKey, 0, rotatedStorageValue)
 
 	// For CAS error - someone else has already rotated out for this count.  Continue to initialize empty.  For all other errors,
 	// return error
@@ -238,7 +254,7 @@ func (l *DenseBlockList) rotate() error {
 		return err
 	}
 	var casOut uint64
-	casOut, err = l.indexBucket.WriteCas(l.activeKey, 0, 0, l.activeCas, activeStorageValue, 0)
+	casOut, err = denseStoreWriteCas(l.store, l.activeKey, l.activeCas, activeStorageValue)
 	if err != nil {
 		if base.IsCasMismatch(err) {
 			// CAS error.  Assume concurrent writer has already updated the active block list.
@@ -271,7 +287,7 @@ func (l *DenseBlockList) initDenseBlockList() error {
 	// If block list doesn't exist, add a block (which will initialize)
 	if !found {
 		l.activeCas = 0
-		base.Debugf(base.KeyAccel, "Creating new block list. channel:[%s] partition:[%d] cas:[%d]", base.UD(l.channelName), l.partition, l.activeCas)
+		base.Debugf(base.KeyAccel, "Creating new block list. channel:[%s] partition:[%d] cas:[%d]", base.UDChannel(l.channelName), l.partition, l.activeCas)
 		l.blocks = make([]DenseBlockListEntry, 0)
 		_, err = l.AddBlock()
 		if err != nil {
@@ -287,7 +303,7 @@ func (l *DenseBlockList) loadDenseBlockList() (found bool, err error) {
 
 	activeBlockListStorage, casOut, readError := l.loadStorage(l.activeKey)
 	if readError != nil {
-		if base.IsKeyNotFoundError(l.indexBucket, readError) {
+		if base.IsDocNotFoundError(readError) {
 			return false, nil
 		} else {
 			base.Debugf(base.KeyAccel, "Unexpected error attempting to retrieve active block list.  key:[%s] err:[%v]", base.UD(l.activeKey), readError)
@@ -335,7 +351,7 @@ func (l *DenseBlockList) LoadPrevious() error {
 func (l *DenseBlockList) loadStorage(key string) (storage DenseBlockListStorage, cas uint64, err error) {
 
 	storage = DenseBlockListStorage{}
-	casOut, err := l.indexBucket.Get(key, &storage)
+	casOut, err := denseStoreGet(l.store, key, &storage)
 	if err != nil {
 		return storage, 0, err
 	}
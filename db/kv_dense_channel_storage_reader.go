@@ -20,17 +20,17 @@ import (
 // Implementation of ChannelStorage that stores entries as an append-based list of
 // full log entries
 type DenseStorageReader struct {
-	indexBucket          base.Bucket                             // Index bucket
+	store                DenseStore                              // Index store
 	channelName          string                                  // Channel name
 	partitions           *base.IndexPartitions                   // Partition assignment map
 	partitionStorage     map[uint16]*DensePartitionStorageReader // PartitionStorage for this channel
 	partitionStorageLock sync.RWMutex                            // Coordinates read access to partition storage map
 }
 
-func NewDenseStorageReader(bucket base.Bucket, channelName string, partitions *base.IndexPartitions) *DenseStorageReader {
+func NewDenseStorageReader(store DenseStore, channelName string, partitions *base.IndexPartitions) *DenseStorageReader {
 
 	storage := &DenseStorageReader{
-		indexBucket:      bucket,
+		store:            store,
 		channelName:      channelName,
 		partitions:       partitions,
 		partitionStorage: make(map[uint16]*DensePartitionStorageReader, partitions.PartitionCount()),
@@ -54,12 +54,12 @@ func (ds *DenseStorageReader) UpdateCache(sinceClock base.SequenceClock, toClock
 			defer wg.Done()
 			reader := ds.getPartitionStorageReader(partitionNo)
 			if reader == nil {
-				base.Warnf(base.KeyAll, "Expected to get reader for channel %s partition %d, based on changed range %v", base.UD(ds.channelName), partitionNo, partitionRange)
+				base.Warnf(base.KeyAll, "Expected to get reader for channel %s partition %d, based on changed range %v", base.UDChannel(ds.channelName), partitionNo, partitionRange)
 				return
 			}
 			err := reader.UpdateCache(kCachedBlocksPerShard)
 			if err != nil {
-				base.Warnf(base.KeyAll, "Unable to update cache for channel:[%s] partition:[%d] : %v", base.UD(ds.channelName), partitionNo, err)
+				base.Warnf(base.KeyAll, "Unable to update cache for channel:[%s] partition:[%d] : %v", base.UDChannel(ds.channelName), partitionNo, err)
 				errCh <- err
 			}
 		}(uint16(partitionNo), partitionRange)
@@ -151,7 +151,7 @@ func (ds *DenseStorageReader) _newPartitionStorageReader(partitionNo uint16) (pa
 	if _, ok := ds.partitionStorage[partitionNo]; ok {
 		return ds.partitionStorage[partitionNo]
 	}
-	ds.partitionStorage[partitionNo] = NewDensePartitionStorageReader(ds.channelName, partitionNo, ds.indexBucket)
+	ds.partitionStorage[partitionNo] = NewDensePartitionStorageReader(ds.channelName, partitionNo, ds.store)
 	return ds.partitionStorage[partitionNo]
 }
 
@@ -220,18 +220,18 @@ func (p *PartitionChanges) PrependChanges(other *PartitionChanges) {
 	}
 }
 
-// DensePartitionStorageReaderNonCaching is a non-caching reader - every read request retrieves the latest from the bucket.
+// DensePartitionStorageReaderNonCaching is a non-caching reader - every read request retrieves the latest from the index store.
 type DensePartitionStorageReaderNonCaching struct {
-	channelName string      // Channel name
-	partitionNo uint16      // Partition number
-	indexBucket base.Bucket // Index bucket
+	channelName string     // Channel name
+	partitionNo uint16     // Partition number
+	store       DenseStore // Index store
 }
 
-func NewDensePartitionStorageReaderNonCaching(channelName string, partitionNo uint16, indexBucket base.Bucket) *DensePartitionStorageReaderNonCaching {
+func NewDensePartitionStorageReaderNonCaching(channelName string, partitionNo uint16, store DenseStore) *DensePartitionStorageReaderNonCaching {
 	storage := &DensePartitionStorageReaderNonCaching{
 		channelName: channelName,
 		partitionNo: partitionNo,
-		indexBucket: indexBucket,
+		store:       store,
 	}
 	return storage
 }
@@ -243,7 +243,7 @@ func (r *DensePartitionStorageReaderNonCaching) GetChanges(partitionRange base.P
 	// Initialize the block list to the starting range, then find the starting block for the partition range
 	blockList := r.GetBlockListForRange(partitionRange)
 	if blockList == nil {
-		base.Debugf(base.KeyAccel, "No block found for requested partition range.  channel:[%s] partition:[%d]", base.UD(r.channelName), r.partitionNo)
+		base.Debugf(base.KeyAccel, "No block found for requested partition range.  channel:[%s] partition:[%d]", base.UDChannel(r.channelName), r.partitionNo)
 		return changes, nil
 	}
 	startIndex := 0
@@ -280,7 +280,7 @@ func (r *DensePartitionStorageReaderNonCaching) GetBlockListForRange(partitionRa
 
 	// Initialize the block list, by loading all block list docs until we get one with
 	// a starting clock earlier than the partitionRange start.
-	blockList := NewDenseBlockListReader(r.channelName, r.partitionNo, r.indexBucket)
+	blockList := NewDenseBlockListReader(r.channelName, r.partitionNo, r.store)
 	if blockList == nil {
 		return nil
 	}
@@ -288,7 +288,7 @@ func (r *DensePartitionStorageReaderNonCaching) GetBlockListForRange(partitionRa
 	if partitionRange.SinceBefore(validFromClock) {
 		err := blockList.LoadPrevious()
 		if err != nil {
-			base.Warnf(base.KeyAll, "Error loading previous block list - will not be included in set. channel:[%s] partition:[%d]", base.UD(r.channelName), r.partitionNo)
+			base.Warnf(base.KeyAll, "Error loading previous block list - will not be included in set. channel:[%s] partition:[%d]", base.UDChannel(r.channelName), r.partitionNo)
 		}
 		validFromClock = blockList.ValidFrom()
 	}
@@ -297,7 +297,7 @@ func (r *DensePartitionStorageReaderNonCaching) GetBlockListForRange(partitionRa
 
 func (l *DenseBlockList) LoadBlock(listEntry DenseBlockListEntry) *DenseBlock {
 	block := NewDenseBlock(l.generateBlockKey(listEntry.BlockIndex), listEntry.StartClock)
-	err := block.loadBlock(l.indexBucket)
+	err := block.loadBlock(l.store)
 	if err != nil {
 		// error loading block - leave as new block, and let any conflicts get resolved on next write
 	}
@@ -307,7 +307,7 @@ func (l *DenseBlockList) LoadBlock(listEntry DenseBlockListEntry) *DenseBlock {
 type DensePartitionStorageReader struct {
 	channelName          string                 // Channel name
 	partitionNo          uint16                 // Partition number
-	indexBucket          base.Bucket            // Index bucket
+	store                DenseStore             // Index store
 	blockList            *DenseBlockList        // Cached block list
 	blockCache           map[string]*DenseBlock // Cached blocks
 	activeCachedBlockKey string                 // Latest block cached
@@ -317,11 +317,11 @@ type DensePartitionStorageReader struct {
 	pendingReloadLock    sync.Mutex             // Allow holders of lock.RLock to update pendingReload
 }
 
-func NewDensePartitionStorageReader(channelName string, partitionNo uint16, indexBucket base.Bucket) *DensePartitionStorageReader {
+func NewDensePartitionStorageReader(channelName string, partitionNo uint16, store DenseStore) *DensePartitionStorageReader {
 	storage := &DensePartitionStorageReader{
 		channelName:   channelName,
 		partitionNo:   partitionNo,
-		indexBucket:   indexBucket,
+		store:         store,
 		blockCache:    make(map[string]*DenseBlock),
 		pendingReload: make(map[string]bool),
 	}
@@ -342,13 +342,13 @@ func (pr *DensePartitionStorageReader) GetChanges(partitionRange base.PartitionR
 		return nil, err
 	}
 	if cacheOk {
-		base.Debugf(base.KeyCache, "Returning cached changes for channel:[%s], partition:[%d]", base.UD(pr.channelName), pr.partitionNo)
+		base.Debugf(base.KeyCache, "Returning cached changes for channel:[%s], partition:[%d]", base.UDChannel(pr.channelName), pr.partitionNo)
 		indexReaderGetChangesUseCached.Add(1)
 		return changes, nil
 	}
 
 	// Cache didn't cover the partition range - retrieve from the index
-	base.Debugf(base.KeyCache, "Returning indexed changes for channel:[%s], partition:[%d]", base.UD(pr.channelName), pr.partitionNo)
+	base.Debugf(base.KeyCache, "Returning indexed changes for channel:[%s], partition:[%d]", base.UDChannel(pr.channelName), pr.partitionNo)
 	indexReaderGetChangesUseIndexed.Add(1)
 	return pr.getIndexedChanges(partitionRange)
 
@@ -360,7 +360,7 @@ func (pr *DensePartitionStorageReader) UpdateCache(numBlocks int) error {
 
 	// Reload the block list if changed
 	if pr.blockList == nil {
-		pr.blockList = NewDenseBlockListReader(pr.channelName, pr.partitionNo, pr.indexBucket)
+		pr.blockList = NewDenseBlockListReader(pr.channelName, pr.partitionNo, pr.store)
 		if pr.blockList == nil {
 			return errors.New("Unable to initialize block list")
 		}
@@ -384,7 +384,7 @@ func (pr *DensePartitionStorageReader) UpdateCache(numBlocks int) error {
 	}
 
 	if blockCount == 0 {
-		base.Warnf(base.KeyAll, "Attempted to update reader cache for partition with no blocks. channel:[%s] partition:[%d]", base.UD(pr.channelName), pr.partitionNo)
+		base.Warnf(base.KeyAll, "Attempted to update reader cache for partition with no blocks. channel:[%s] partition:[%d]", base.UDChannel(pr.channelName), pr.partitionNo)
 		return errors.New("No blocks found when updating partition cache")
 	}
 	// cacheKeySet tracks the blocks that should be in the cache - used for cache expiry, below
@@ -529,7 +529,7 @@ func (pr *DensePartitionStorageReader) notifyDuplicateFound(blockKey string) {
 func (pr *DensePartitionStorageReader) getIndexedChanges(partitionRange base.PartitionRange) (changes *PartitionChanges, err error) {
 
 	// Initialize block list
-	blockList := NewDenseBlockListReader(pr.channelName, pr.partitionNo, pr.indexBucket)
+	blockList := NewDenseBlockListReader(pr.channelName, pr.partitionNo, pr.store)
 	if blockList == nil {
 		return nil, errors.New("Unable to initialize block list")
 	}
@@ -609,11 +609,11 @@ func (pr *DensePartitionStorageReader) _getCachedBlock(key string) (*DenseBlock,
 	return block, true
 }
 
-// Loads a block from the index bucket
+// Loads a block from the index store
 func (pr *DensePartitionStorageReader) loadBlock(key string, startClock base.PartitionClock) (*DenseBlock, error) {
 
 	block := NewDenseBlock(key, startClock)
-	err := block.loadBlock(pr.indexBucket)
+	err := block.loadBlock(pr.store)
 	if err != nil {
 		return nil, err
 	}
@@ -45,7 +45,7 @@ func TestDenseBlockSingleDoc(t *testing.T) {
 
 	testIndexBucket := base.GetTestIndexBucketOrPanic()
 	defer testIndexBucket.Close()
-	indexBucket := testIndexBucket.Bucket
+	store := NewBucketDenseStore(testIndexBucket.Bucket)
 
 	block := NewDenseBlock("block1", nil)
 
@@ -53,7 +53,7 @@ func TestDenseBlockSingleDoc(t *testing.T) {
 	entries := make([]*LogEntry, 1)
 	entries[0] = makeBlockEntry("doc1", "1-abc", 50, 1, IsNotRemoval, IsAdded)
 
-	overflow, pendingRemoval, updateClock, _, err := block.AddEntrySet(entries, indexBucket)
+	overflow, pendingRemoval, updateClock, _, err := block.AddEntrySet(entries, store)
 	assert.NoError(t, err, "Error adding entry set")
 	goassert.Equals(t, len(overflow), 0)
 	goassert.Equals(t, len(pendingRemoval), 0)
@@ -66,7 +66,7 @@ func TestDenseBlockSingleDoc(t *testing.T) {
 	// Update within the same partition block, deduplicate by id
 	entries[0] = makeBlockEntry("doc1", "2-abc", 50, 3, IsNotRemoval, IsNotAdded)
 
-	overflow, pendingRemoval, updateClock, _, err = block.AddEntrySet(entries, indexBucket)
+	overflow, pendingRemoval, updateClock, _, err = block.AddEntrySet(entries, store)
 	assert.NoError(t, err, "Error adding entry set")
 	goassert.Equals(t, len(overflow), 0)
 	goassert.Equals(t, len(pendingRemoval), 0)
@@ -80,7 +80,7 @@ func TestDenseBlockSingleDoc(t *testing.T) {
 	entries[0] = makeBlockEntry("doc1", "3-abc", 50, 5, IsNotRemoval, IsNotAdded)
 	entries[0].PrevSequence = uint64(3)
 
-	overflow, pendingRemoval, updateClock, _, err = block.AddEntrySet(entries, indexBucket)
+	overflow, pendingRemoval, updateClock, _, err = block.AddEntrySet(entries, store)
 	assert.NoError(t, err, "Error adding entry set")
 	goassert.Equals(t, len(overflow), 0)
 	goassert.Equals(t, len(pendingRemoval), 0)
@@ -91,11 +91,105 @@ func TestDenseBlockSingleDoc(t *testing.T) {
 	assertLogEntry(t, foundEntries[0], "doc1", "3-abc", 50, 5)
 }
 
+// Checks that writes to a dense block recover from CAS failures
+func TestDenseBlockCASFailures(t *testing.T) {
+
+	testIndexBucket := base.GetTestIndexBucketOrPanic()
+	defer testIndexBucket.Close()
+
+	// The first write fails
+	casMismatches := &base.FaultSchedule{Count: 1}
+	store := NewBucketDenseStore(base.NewLeakyBucket(testIndexBucket.Bucket, base.LeakyBucketConfig{CASMismatches: casMismatches}))
+
+	block := NewDenseBlock("block1", nil)
+	entries := make([]*LogEntry, 3)
+	for i := 0; i < 3; i++ {
+		entries[i] = makeBlockEntry(fmt.Sprintf("doc%d", i), "1-abc", 50, i+1, IsNotRemoval, IsAdded)
+	}
+
+	// Adding a set returns every entry as overflow on CAS failure, so that the caller can reload the block and retry
+	overflow, _, _, casFailure, err := block.AddEntrySet(entries, store)
+	assert.NoError(t, err, "Error adding entry set")
+	assert.True(t, casFailure)
+	goassert.Equals(t, len(overflow), 3)
+	goassert.Equals(t, casMismatches.Faults(), 1)
+
+	block = NewDenseBlock("block1", nil)
+	overflow, _, _, casFailure, err = block.AddEntrySet(entries, store)
+	assert.NoError(t, err, "Error adding entry set")
+	assert.False(t, casFailure)
+	goassert.Equals(t, len(overflow), 0)
+
+	// Removing a set retries until the write succeeds
+	casMismatches = &base.FaultSchedule{Count: 3}
+	store = NewBucketDenseStore(base.NewLeakyBucket(testIndexBucket.Bucket, base.LeakyBucketConfig{CASMismatches: casMismatches}))
+	pendingRemoval, err := block.RemoveEntrySet(entries[1:2], store)
+	assert.NoError(t, err, "Error removing entry set")
+	goassert.Equals(t, len(pendingRemoval), 0)
+	goassert.Equals(t, casMismatches.Faults(), 3)
+
+	foundEntries := block.GetAllEntries()
+	goassert.Equals(t, len(foundEntries), 2)
+	assertLogEntry(t, foundEntries[0], "doc0", "1-abc", 50, 1)
+	assertLogEntry(t, foundEntries[1], "doc2", "1-abc", 50, 3)
+}
+
+// Benchmarks filling an empty dense block.  More entries are added than fit in a block, so the rest overflow.
+func BenchmarkDenseBlockAddEntrySet(b *testing.B) {
+
+	defer base.DisableTestLogging()()
+	testIndexBucket := base.GetTestIndexBucketOrPanic()
+	defer testIndexBucket.Close()
+	store := NewBucketDenseStore(testIndexBucket.Bucket)
+
+	entries := make([]*LogEntry, 1000)
+	for i := range entries {
+		entries[i] = makeBlockEntry(fmt.Sprintf("doc%d", i), "1-abc", i%1024, i+1, IsNotRemoval, IsAdded)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		block := NewDenseBlock(fmt.Sprintf("block%d", i), nil)
+		if _, _, _, _, err := block.AddEntrySet(entries, store); err != nil {
+			b.Fatalf("Error adding entry set: %v", err)
+		}
+	}
+}
+
+// Benchmarks reading every entry of a full dense block
+func BenchmarkDenseBlockGetAllEntries(b *testing.B) {
+
+	defer base.DisableTestLogging()()
+	testIndexBucket := base.GetTestIndexBucketOrPanic()
+	defer testIndexBucket.Close()
+	store := NewBucketDenseStore(testIndexBucket.Bucket)
+
+	entries := make([]*LogEntry, 1000)
+	for i := range entries {
+		entries[i] = makeBlockEntry(fmt.Sprintf("doc%d", i), "1-abc", i%1024, i+1, IsNotRemoval, IsAdded)
+	}
+	block := NewDenseBlock("block1", nil)
+	overflow, _, _, _, err := block.AddEntrySet(entries, store)
+	if err != nil {
+		b.Fatalf("Error adding entry set: %v", err)
+	}
+	numEntries := len(entries) - len(overflow)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if foundEntries := block.GetAllEntries(); len(foundEntries) != numEntries {
+			b.Fatalf("Expected %d entries, got %d", numEntries, len(foundEntries))
+		}
+	}
+}
+
 func TestDenseBlockMultipleInserts(t *testing.T) {
 
 	testIndexBucket := base.GetTestIndexBucketOrPanic()
 	defer testIndexBucket.Close()
-	indexBucket := testIndexBucket.Bucket
+	store := NewBucketDenseStore(testIndexBucket.Bucket)
 
 	block := DenseBlock{}
 	block.Key = "block1"
@@ -111,7 +205,7 @@ func TestDenseBlockMultipleInserts(t *testing.T) {
 	for i := 0; i < 10; i++ {
 		entries[i] = makeBlockEntry(fmt.Sprintf("doc%d", i), "1-abc", i*10, i+1, IsNotRemoval, IsAdded)
 	}
-	overflow, pendingRemoval, updateClock, _, err := block.AddEntrySet(entries, indexBucket)
+	overflow, pendingRemoval, updateClock, _, err := block.AddEntrySet(entries, store)
 	assert.NoError(t, err, "Error adding entry set")
 	goassert.Equals(t, len(overflow), 0)
 	goassert.Equals(t, len(pendingRemoval), 0)
@@ -130,7 +224,7 @@ func TestDenseBlockGetIndexEntry(t *testing.T) {
 
 	testIndexBucket := base.GetTestIndexBucketOrPanic()
 	defer testIndexBucket.Close()
-	indexBucket := testIndexBucket.Bucket
+	store := NewBucketDenseStore(testIndexBucket.Bucket)
 
 	block := NewDenseBlock("block1", nil)
 
@@ -139,7 +233,7 @@ func TestDenseBlockGetIndexEntry(t *testing.T) {
 	for i := 0; i < 10; i++ {
 		entries[i] = makeBlockEntry(fmt.Sprintf("doc%d", i), "1-abc", i*10, i+1, IsNotRemoval, IsAdded)
 	}
-	overflow, pendingRemoval, _, _, err := block.AddEntrySet(entries, indexBucket)
+	overflow, pendingRemoval, _, _, err := block.AddEntrySet(entries, store)
 	assert.NoError(t, err, "Error adding entry set")
 	goassert.Equals(t, len(overflow), 0)
 	goassert.Equals(t, len(pendingRemoval), 0)
@@ -157,7 +251,7 @@ func TestDenseBlockGetEntry(t *testing.T) {
 
 	testIndexBucket := base.GetTestIndexBucketOrPanic()
 	defer testIndexBucket.Close()
-	indexBucket := testIndexBucket.Bucket
+	store := NewBucketDenseStore(testIndexBucket.Bucket)
 
 	block := NewDenseBlock("block1", nil)
 
@@ -166,7 +260,7 @@ func TestDenseBlockGetEntry(t *testing.T) {
 	for i := 0; i < 10; i++ {
 		entries[i] = makeBlockEntry(fmt.Sprintf("doc%d", i), "1-abc", i*10, i+1, IsNotRemoval, IsAdded)
 	}
-	overflow, pendingRemoval, _, _, err := block.AddEntrySet(entries, indexBucket)
+	overflow, pendingRemoval, _, _, err := block.AddEntrySet(entries, store)
 	assert.NoError(t, err, "Error adding entry set")
 	goassert.Equals(t, len(overflow), 0)
 	goassert.Equals(t, len(pendingRemoval), 0)
@@ -186,7 +280,7 @@ func TestDenseBlockMultipleUpdates(t *testing.T) {
 
 	testIndexBucket := base.GetTestIndexBucketOrPanic()
 	defer testIndexBucket.Close()
-	indexBucket := testIndexBucket.Bucket
+	store := NewBucketDenseStore(testIndexBucket.Bucket)
 
 	block := NewDenseBlock("block1", nil)
 
@@ -197,7 +291,7 @@ func TestDenseBlockMultipleUpdates(t *testing.T) {
 		sequence := i + 1
 		entries[i] = makeBlockEntry(fmt.Sprintf("doc%d", i), "1-abc", vbno, sequence, IsNotRemoval, IsAdded)
 	}
-	overflow, pendingRemoval, updateClock, _, err := block.AddEntrySet(entries, indexBucket)
+	overflow, pendingRemoval, updateClock, _, err := block.AddEntrySet(entries, store)
 	assert.NoError(t, err, "Error adding entry set")
 	goassert.Equals(t, len(overflow), 0)
 	goassert.Equals(t, len(pendingRemoval), 0)
@@ -221,7 +315,7 @@ func TestDenseBlockMultipleUpdates(t *testing.T) {
 		entries[i] = makeBlockEntry(fmt.Sprintf("doc%d", i), "2-abc", vbno, sequence, IsNotRemoval, IsNotAdded)
 		entries[i].PrevSequence = uint64(i + 1)
 	}
-	overflow, pendingRemoval, updateClock, _, err = block.AddEntrySet(entries, indexBucket)
+	overflow, pendingRemoval, updateClock, _, err = block.AddEntrySet(entries, store)
 	assert.NoError(t, err, "Error adding entry set")
 	goassert.Equals(t, len(overflow), 0)
 	goassert.Equals(t, len(pendingRemoval), 0)
@@ -237,7 +331,7 @@ func TestDenseBlockMultipleUpdates(t *testing.T) {
 	// Validate pending removal by adding an entry where the previous revision isn't in the block
 	entries = make([]*LogEntry, 1)
 	entries[0] = makeBlockEntry("doc_not_in_block", "2-abc", 11, 65, IsNotRemoval, IsNotAdded)
-	overflow, pendingRemoval, updateClock, _, err = block.AddEntrySet(entries, indexBucket)
+	overflow, pendingRemoval, updateClock, _, err = block.AddEntrySet(entries, store)
 	assert.NoError(t, err, "Error adding entry set")
 	goassert.Equals(t, len(overflow), 0)
 	goassert.Equals(t, len(pendingRemoval), 1)
@@ -251,7 +345,7 @@ func TestDenseBlockRemovalByKey(t *testing.T) {
 
 	testIndexBucket := base.GetTestIndexBucketOrPanic()
 	defer testIndexBucket.Close()
-	indexBucket := testIndexBucket.Bucket
+	store := NewBucketDenseStore(testIndexBucket.Bucket)
 
 	block := NewDenseBlock("block1", nil)
 
@@ -262,7 +356,7 @@ func TestDenseBlockRemovalByKey(t *testing.T) {
 		sequence := i + 1
 		entries[i] = makeBlockEntry(fmt.Sprintf("doc%d", i), "1-abc", vbno, sequence, IsNotRemoval, IsAdded)
 	}
-	overflow, pendingRemoval, updateClock, _, err := block.AddEntrySet(entries, indexBucket)
+	overflow, pendingRemoval, updateClock, _, err := block.AddEntrySet(entries, store)
 	assert.NoError(t, err, "Error adding entry set")
 	goassert.Equals(t, len(overflow), 0)
 	goassert.Equals(t, len(pendingRemoval), 0)
@@ -283,7 +377,7 @@ func TestDenseBlockRemovalByKey(t *testing.T) {
 		sequence := i + 21
 		entries[i] = makeBlockEntry(fmt.Sprintf("doc%d", i), "2-abc", vbno, sequence, IsNotRemoval, IsNotAdded)
 	}
-	overflow, pendingRemoval, updateClock, _, err = block.AddEntrySet(entries, indexBucket)
+	overflow, pendingRemoval, updateClock, _, err = block.AddEntrySet(entries, store)
 	assert.NoError(t, err, "Error adding entry set")
 	goassert.Equals(t, len(overflow), 0)
 	goassert.Equals(t, len(pendingRemoval), 0)
@@ -299,7 +393,7 @@ func TestDenseBlockRemovalByKey(t *testing.T) {
 	// Validate pending removal by adding an entry where the previous revision isn't in the block
 	entries = make([]*LogEntry, 1)
 	entries[0] = makeBlockEntry("doc_not_in_block", "2-abc", 50, 65, IsNotRemoval, IsNotAdded)
-	overflow, pendingRemoval, updateClock, _, err = block.AddEntrySet(entries, indexBucket)
+	overflow, pendingRemoval, updateClock, _, err = block.AddEntrySet(entries, store)
 	assert.NoError(t, err, "Error adding entry set")
 	goassert.Equals(t, len(overflow), 0)
 	goassert.Equals(t, len(pendingRemoval), 1)
@@ -313,7 +407,7 @@ func TestDenseBlockRollbackTo(t *testing.T) {
 
 	testIndexBucket := base.GetTestIndexBucketOrPanic()
 	defer testIndexBucket.Close()
-	indexBucket := testIndexBucket.Bucket
+	store := NewBucketDenseStore(testIndexBucket.Bucket)
 
 	block := NewDenseBlock("block1", nil)
 
@@ -325,7 +419,7 @@ func TestDenseBlockRollbackTo(t *testing.T) {
 		vbNo := i % 3 // mix up the vbuckets
 		entries[i] = makeBlockEntry(fmt.Sprintf("doc%d", i), "1-abc", vbNo, sequence, IsNotRemoval, IsAdded)
 	}
-	overflow, pendingRemoval, _, _, err := block.AddEntrySet(entries, indexBucket)
+	overflow, pendingRemoval, _, _, err := block.AddEntrySet(entries, store)
 	assert.NoError(t, err, "Error adding entry set")
 	goassert.Equals(t, len(overflow), 0)
 	goassert.Equals(t, len(pendingRemoval), 0)
@@ -345,7 +439,7 @@ func TestDenseBlockRollbackTo(t *testing.T) {
 	assertLogEntry(t, foundEntries[9], "doc9", "1-abc", 0, 10)
 
 	// Rollback should complete in this block
-	rollbackComplete, err := block.RollbackTo(2, 5, indexBucket)
+	rollbackComplete, err := block.RollbackTo(2, 5, store)
 	assert.NoError(t, err, "Error rolling back")
 	goassert.Equals(t, rollbackComplete, true)
 	goassert.Equals(t, block.getEntryCount(), uint16(8))
@@ -363,7 +457,7 @@ func TestDenseBlockRollbackTo(t *testing.T) {
 
 	// Rollback should NOT complete in this block, because we don't see a sequence value earlier than
 	// rollback value in this block (haven't seen a sequence earlier than 1 in vb 1)
-	rollbackComplete, err = block.RollbackTo(1, 1, indexBucket)
+	rollbackComplete, err = block.RollbackTo(1, 1, store)
 	assert.NoError(t, err, "Error rolling back")
 	goassert.Equals(t, rollbackComplete, false)
 	goassert.Equals(t, block.getEntryCount(), uint16(5))
@@ -377,7 +471,7 @@ func TestDenseBlockRollbackTo(t *testing.T) {
 	assertLogEntry(t, foundEntries[4], "doc9", "1-abc", 0, 10)
 
 	// Remove the first entry, make sure nothing breaks
-	rollbackComplete, err = block.RollbackTo(0, 0, indexBucket)
+	rollbackComplete, err = block.RollbackTo(0, 0, store)
 	assert.NoError(t, err, "Error rolling back")
 	goassert.Equals(t, rollbackComplete, false)
 	goassert.Equals(t, block.getEntryCount(), uint16(1))
@@ -392,14 +486,14 @@ func TestDenseBlockRollbackTo(t *testing.T) {
 	// Insert an empty entry list
 	entries = make([]*LogEntry, 0)
 
-	overflow, pendingRemoval, _, _, err = block.AddEntrySet(entries, indexBucket)
+	overflow, pendingRemoval, _, _, err = block.AddEntrySet(entries, store)
 	assert.NoError(t, err, "Error adding empty entry set")
 	goassert.Equals(t, len(overflow), 0)
 	goassert.Equals(t, len(pendingRemoval), 0)
 	goassert.Equals(t, block.getEntryCount(), uint16(0))
 
 	// Rollback should complete in this empty block
-	rollbackComplete, err = block.RollbackTo(1, 1, indexBucket)
+	rollbackComplete, err = block.RollbackTo(1, 1, store)
 	assert.NoError(t, err, "Error rolling back")
 	goassert.Equals(t, rollbackComplete, true)
 	goassert.Equals(t, block.getEntryCount(), uint16(0))
@@ -414,7 +508,7 @@ func TestDenseBlockOverflow(t *testing.T) {
 
 	testIndexBucket := base.GetTestIndexBucketOrPanic()
 	defer testIndexBucket.Close()
-	indexBucket := testIndexBucket.Bucket
+	store := NewBucketDenseStore(testIndexBucket.Bucket)
 
 	block := NewDenseBlock("block1", nil)
 
@@ -425,7 +519,7 @@ func TestDenseBlockOverflow(t *testing.T) {
 		sequence := i + 1
 		entries[i] = makeBlockEntry(fmt.Sprintf("longerDocumentID-%d", sequence), "1-abcdef01234567890", vbno, sequence, IsNotRemoval, IsAdded)
 	}
-	overflow, pendingRemoval, updateClock, _, err := block.AddEntrySet(entries, indexBucket)
+	overflow, pendingRemoval, updateClock, _, err := block.AddEntrySet(entries, store)
 	assert.NoError(t, err, "Error adding entry set")
 	goassert.Equals(t, len(overflow), 0)
 	goassert.Equals(t, len(pendingRemoval), 0)
@@ -446,7 +540,7 @@ func TestDenseBlockOverflow(t *testing.T) {
 		sequence := i + 101
 		entries[i] = makeBlockEntry(fmt.Sprintf("longerDocumentID-%d", sequence), "1-abcdef01234567890", vbno, sequence, IsNotRemoval, IsAdded)
 	}
-	overflow, pendingRemoval, updateClock, _, err = block.AddEntrySet(entries, indexBucket)
+	overflow, pendingRemoval, updateClock, _, err = block.AddEntrySet(entries, store)
 	assert.NoError(t, err, "Error adding entry set")
 	goassert.Equals(t, len(overflow), 12)
 	goassert.Equals(t, len(pendingRemoval), 0)
@@ -469,7 +563,7 @@ func TestDenseBlockOverflow(t *testing.T) {
 
 	// Retry the 12 entries, all should overflow
 	var newOverflow []*LogEntry
-	newOverflow, pendingRemoval, updateClock, _, err = block.AddEntrySet(overflow, indexBucket)
+	newOverflow, pendingRemoval, updateClock, _, err = block.AddEntrySet(overflow, store)
 	assert.NoError(t, err, "Error adding entry set")
 	goassert.Equals(t, len(newOverflow), 12)
 	goassert.Equals(t, len(pendingRemoval), 0)
@@ -486,7 +580,7 @@ func TestDenseBlockConcurrentUpdates(t *testing.T) {
 
 	testIndexBucket := base.GetTestIndexBucketOrPanic()
 	defer testIndexBucket.Close()
-	indexBucket := testIndexBucket.Bucket
+	store := NewBucketDenseStore(testIndexBucket.Bucket)
 
 	block := NewDenseBlock("block1", nil)
 
@@ -494,7 +588,7 @@ func TestDenseBlockConcurrentUpdates(t *testing.T) {
 	entries := make([]*LogEntry, 1)
 	entries[0] = makeBlockEntry("doc1", "1-abc", 50, 1, IsNotRemoval, IsAdded)
 
-	overflow, pendingRemoval, updateClock, _, err := block.AddEntrySet(entries, indexBucket)
+	overflow, pendingRemoval, updateClock, _, err := block.AddEntrySet(entries, store)
 	assert.NoError(t, err, "Error adding entry set")
 	goassert.Equals(t, len(overflow), 0)
 	goassert.Equals(t, len(pendingRemoval), 0)
@@ -510,15 +604,15 @@ func TestDenseBlockConcurrentUpdates(t *testing.T) {
 	block2 := NewDenseBlock("block1", nil)
 	entries2 := make([]*LogEntry, 1)
 	entries2[0] = makeBlockEntry("doc2", "1-abc", 50, 3, IsNotRemoval, IsAdded)
-	overflow2, pendingRemoval2, updateClock2, casFail, err := block2.AddEntrySet(entries2, indexBucket)
+	overflow2, pendingRemoval2, updateClock2, casFail, err := block2.AddEntrySet(entries2, store)
 	assert.NoError(t, err, "Error adding entry set")
 	goassert.Equals(t, casFail, true)
 	goassert.Equals(t, len(overflow2), 1)
 	goassert.Equals(t, len(pendingRemoval2), 0)
 	goassert.Equals(t, updateClock2.GetSequence(50), uint64(0))
 
-	block2.loadBlock(indexBucket)
-	overflow2, pendingRemoval2, updateClock2, casFail, err = block2.AddEntrySet(entries2, indexBucket)
+	block2.loadBlock(store)
+	overflow2, pendingRemoval2, updateClock2, casFail, err = block2.AddEntrySet(entries2, store)
 	goassert.Equals(t, casFail, false)
 	goassert.Equals(t, len(overflow2), 0)
 	goassert.Equals(t, len(pendingRemoval2), 0)
@@ -531,7 +625,7 @@ func TestDenseBlockConcurrentUpdates(t *testing.T) {
 	assertLogEntry(t, foundEntries2[1], "doc2", "1-abc", 50, 3)
 
 	// Attempt to write the same entry with the first block/writer
-	overflow, pendingRemoval, updateClock, casFail, err = block.AddEntrySet(entries2, indexBucket)
+	overflow, pendingRemoval, updateClock, casFail, err = block.AddEntrySet(entries2, store)
 	assert.NoError(t, err, "Error adding entry set")
 	goassert.Equals(t, len(overflow), 1)
 	goassert.Equals(t, casFail, true)
@@ -539,7 +633,7 @@ func TestDenseBlockConcurrentUpdates(t *testing.T) {
 	goassert.Equals(t, updateClock.GetSequence(50), uint64(0))
 	log.Println("Wrote doc as block1")
 
-	block.loadBlock(indexBucket)
+	block.loadBlock(store)
 	foundEntries = block.GetAllEntries()
 	goassert.Equals(t, len(foundEntries), 2)
 	assertLogEntry(t, foundEntries[0], "doc1", "1-abc", 50, 1)
@@ -554,7 +648,7 @@ func TestDenseBlockIterator(t *testing.T) {
 
 	testIndexBucket := base.GetTestIndexBucketOrPanic()
 	defer testIndexBucket.Close()
-	indexBucket := testIndexBucket.Bucket
+	store := NewBucketDenseStore(testIndexBucket.Bucket)
 
 	block := NewDenseBlock("block1", nil)
 
@@ -565,7 +659,7 @@ func TestDenseBlockIterator(t *testing.T) {
 		sequence := i + 1
 		entries[i] = makeBlockEntry(fmt.Sprintf("doc%d", i), "1-abc", vbno, sequence, IsNotRemoval, IsAdded)
 	}
-	overflow, pendingRemoval, _, _, err := block.AddEntrySet(entries, indexBucket)
+	overflow, pendingRemoval, _, _, err := block.AddEntrySet(entries, store)
 	assert.NoError(t, err, "Error adding entry set")
 	goassert.Equals(t, len(overflow), 0)
 	goassert.Equals(t, len(pendingRemoval), 0)
@@ -621,9 +715,10 @@ func TestDenseBlockList(t *testing.T) {
 	testIndexBucket := base.GetTestIndexBucketOrPanic()
 	defer testIndexBucket.Close()
 	indexBucket := testIndexBucket.Bucket
+	store := NewBucketDenseStore(indexBucket)
 
 	// Initialize a new block list.  Will initialize with first block
-	list := NewDenseBlockList("ABC", 1, indexBucket)
+	list := NewDenseBlockList("ABC", 1, store)
 
 	// Simple insert
 	_, err := list.AddBlock()
@@ -632,7 +727,7 @@ func TestDenseBlockList(t *testing.T) {
 	indexBucket.Dump()
 
 	// Create a new instance of the same block list, validate contents
-	newList := NewDenseBlockList("ABC", 1, indexBucket)
+	newList := NewDenseBlockList("ABC", 1, store)
 	goassert.Equals(t, len(newList.blocks), 2)
 	goassert.Equals(t, newList.blocks[0].BlockIndex, 0)
 
@@ -662,12 +757,13 @@ func TestDenseBlockListBadCas(t *testing.T) {
 	testIndexBucket := base.GetTestIndexBucketOrPanic()
 	defer testIndexBucket.Close()
 	indexBucket := testIndexBucket.Bucket
+	store := NewBucketDenseStore(indexBucket)
 
 	// Initialize a new block list manually to set an unexpected cas value.
 	list := &DenseBlockList{
 		channelName: "ABC",
 		partition:   1,
-		indexBucket: indexBucket,
+		store:       store,
 	}
 	list.activeCas = 50
 	list.activeKey = list.generateActiveListKey()
@@ -680,7 +776,7 @@ func TestDenseBlockListBadCas(t *testing.T) {
 	indexBucket.Dump()
 
 	// Create a new instance of the same block list, validate contents
-	newList := NewDenseBlockList("ABC", 1, indexBucket)
+	newList := NewDenseBlockList("ABC", 1, store)
 	goassert.Equals(t, len(newList.blocks), 2)
 	goassert.Equals(t, newList.blocks[0].BlockIndex, 0)
 
@@ -707,7 +803,7 @@ func TestDenseBlockListConcurrentInit(t *testing.T) {
 
 	testIndexBucket := base.GetTestIndexBucketOrPanic()
 	defer testIndexBucket.Close()
-	indexBucket := testIndexBucket.Bucket
+	store := NewBucketDenseStore(testIndexBucket.Bucket)
 
 	// Concurrent initialization
 	var wg sync.WaitGroup
@@ -716,14 +812,14 @@ func TestDenseBlockListConcurrentInit(t *testing.T) {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			list := NewDenseBlockList("ABC", 1, indexBucket)
+			list := NewDenseBlockList("ABC", 1, store)
 			assert.True(t, list != nil, "Error creating block list")
 		}()
 	}
 	wg.Wait()
 
 	// Create a new instance of the same block list, validate contents
-	newList := NewDenseBlockList("ABC", 1, indexBucket)
+	newList := NewDenseBlockList("ABC", 1, store)
 	goassert.Equals(t, len(newList.blocks), 1)
 	goassert.Equals(t, newList.blocks[0].BlockIndex, 0)
 
@@ -744,9 +840,10 @@ func TestDenseBlockListRotate(t *testing.T) {
 	testIndexBucket := base.GetTestIndexBucketOrPanic()
 	defer testIndexBucket.Close()
 	indexBucket := testIndexBucket.Bucket
+	store := NewBucketDenseStore(indexBucket)
 
 	// Initialize a new block list.  Will initialize with first block
-	list := NewDenseBlockList("ABC", 1, indexBucket)
+	list := NewDenseBlockList("ABC", 1, store)
 
 	// Add more than max blocks to block list
 	for i := 1; i <= MaxListBlockCount+10; i++ {
@@ -757,7 +854,7 @@ func TestDenseBlockListRotate(t *testing.T) {
 	indexBucket.Dump()
 
 	// Create a new instance of the same block list, validate contents
-	newList := NewDenseBlockList("ABC", 1, indexBucket)
+	newList := NewDenseBlockList("ABC", 1, store)
 	goassert.Equals(t, len(newList.blocks), 10)
 
 	err := newList.LoadPrevious()
@@ -777,9 +874,9 @@ func TestCalculateChangedPartitions(t *testing.T) {
 
 	testIndexBucket := base.GetTestIndexBucketOrPanic()
 	defer testIndexBucket.Close()
-	indexBucket := testIndexBucket.Bucket
+	store := NewBucketDenseStore(testIndexBucket.Bucket)
 
-	reader := NewDenseStorageReader(indexBucket, "ABC", testPartitionMap())
+	reader := NewDenseStorageReader(store, "ABC", testPartitionMap())
 
 	startClock := getClockForMap(map[uint16]uint64{
 		0:   0,
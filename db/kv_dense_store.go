@@ -0,0 +1,217 @@
+package db
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	sgbucket "github.com/couchbase/sg-bucket"
+	"github.com/couchbase/sync_gateway/base"
+)
+
+// DenseStore is the storage the dense channel index keeps its blocks and block lists in.  It's a narrow key-value
+// interface so that the index can be kept somewhere other than a Couchbase bucket.
+type DenseStore interface {
+	// Returns the value of a key and its CAS.  Returns a sgbucket.MissingError if the key doesn't exist.
+	GetRaw(key string) (value []byte, cas uint64, err error)
+
+	// Writes the value of a key if its CAS is the given one.  A CAS of zero writes the key only if it doesn't
+	// exist.  Returns an error matching base.IsCasMismatch if the CAS doesn't match.  Values are JSON unless raw
+	// is true.
+	WriteCas(key string, cas uint64, value []byte, raw bool) (casOut uint64, err error)
+
+	// Removes a key.  Removing a key that doesn't exist isn't an error.
+	Delete(key string) error
+}
+
+// Returns the store the dense channel index keeps its blocks and block lists in: a LocalDenseStore in localDir, if
+// it's set, otherwise the index bucket.
+func NewDenseStore(indexBucket base.Bucket, localDir string) (DenseStore, error) {
+	if localDir != "" {
+		return NewLocalDenseStore(localDir)
+	}
+	return NewBucketDenseStore(indexBucket), nil
+}
+
+// Writes a raw value to a DenseStore, retrying on a CAS mismatch.  On each retry the callback is given the current
+// value, and returns the value to write, or nil to cancel the write.  This is base.WriteCasRaw for a DenseStore.
+func denseStoreWriteCasRaw(store DenseStore, key string, value []byte, cas uint64, callback func([]byte) ([]byte, error)) (casOut uint64, err error) {
+	if len(value) > 0 {
+		if casOut, err = store.WriteCas(key, cas, value, true); err == nil {
+			return casOut, nil
+		}
+	}
+	for {
+		currentValue, cas, err := store.GetRaw(key)
+		if err != nil {
+			base.Warnf(base.KeyAll, "denseStoreWriteCasRaw got error when calling GetRaw: %v", err)
+			return 0, err
+		}
+		currentValue, err = callback(currentValue)
+		if err != nil {
+			base.Warnf(base.KeyAll, "denseStoreWriteCasRaw got error when calling callback: %v", err)
+			return 0, err
+		}
+		if len(currentValue) == 0 {
+			return cas, nil
+		}
+		casOut, err := store.WriteCas(key, cas, currentValue, true)
+		if err == nil {
+			return casOut, nil
+		} else if !base.IsCasMismatch(err) {
+			return 0, err
+		}
+	}
+}
+
+// Reads a JSON value from a DenseStore.
+func denseStoreGet(store DenseStore, key string, valuePtr interface{}) (cas uint64, err error) {
+	value, cas, err := store.GetRaw(key)
+	if err != nil {
+		return 0, err
+	}
+	return cas, json.Unmarshal(value, valuePtr)
+}
+
+// Writes a JSON value to a DenseStore.
+func denseStoreWriteCas(store DenseStore, key string, cas uint64, value interface{}) (casOut uint64, err error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return 0, err
+	}
+	return store.WriteCas(key, cas, data, false)
+}
+
+// BucketDenseStore keeps the dense channel index in a Couchbase (or walrus) bucket.
+type BucketDenseStore struct {
+	bucket base.Bucket
+}
+
+func NewBucketDenseStore(bucket base.Bucket) *BucketDenseStore {
+	return &BucketDenseStore{bucket: bucket}
+}
+
+func (s *BucketDenseStore) GetRaw(key string) ([]byte, uint64, error) {
+	return s.bucket.GetRaw(key)
+}
+
+func (s *BucketDenseStore) WriteCas(key string, cas uint64, value []byte, raw bool) (uint64, error) {
+	if raw {
+		return s.bucket.WriteCas(key, 0, 0, cas, value, sgbucket.Raw)
+	}
+	// A json.RawMessage is stored as JSON without being encoded again
+	return s.bucket.WriteCas(key, 0, 0, cas, json.RawMessage(value), 0)
+}
+
+func (s *BucketDenseStore) Delete(key string) error {
+	if err := s.bucket.Delete(key); err != nil && !base.IsKeyNotFoundError(s.bucket, err) {
+		return err
+	}
+	return nil
+}
+
+// LocalDenseStore keeps the dense channel index's blocks in the Sync Gateway process, for single node and edge
+// deployments (see ChannelIndexOptions.LocalStoreDir), and for unit tests.  Values are held in memory and, when the store has a directory,
+// written to a file per key there, so the index survives restarts.  A directory mustn't be shared by more than one
+// store.
+type LocalDenseStore struct {
+	dir     string
+	lock    sync.Mutex // Protects values and lastCas
+	values  map[string]localDenseValue
+	lastCas uint64
+}
+
+type localDenseValue struct {
+	value []byte
+	cas   uint64
+}
+
+// Creates a LocalDenseStore.  If dir isn't empty, the store loads the values already in it, and persists values
+// there.
+func NewLocalDenseStore(dir string) (*LocalDenseStore, error) {
+	s := &LocalDenseStore{dir: dir, values: map[string]localDenseValue{}}
+	if dir == "" {
+		return s, nil
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if file.IsDir() || strings.HasSuffix(file.Name(), ".tmp") {
+			continue
+		}
+		key, err := hex.DecodeString(file.Name())
+		if err != nil {
+			continue
+		}
+		value, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		s.lastCas++
+		s.values[string(key)] = localDenseValue{value: value, cas: s.lastCas}
+	}
+	base.Infof(base.KeyAccel, "Loaded %d channel index documents from %s", len(s.values), base.MD(dir))
+	return s, nil
+}
+
+func (s *LocalDenseStore) GetRaw(key string) ([]byte, uint64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	stored, ok := s.values[key]
+	if !ok {
+		return nil, 0, sgbucket.MissingError{Key: key}
+	}
+	value := make([]byte, len(stored.value))
+	copy(value, stored.value)
+	return value, stored.cas, nil
+}
+
+func (s *LocalDenseStore) WriteCas(key string, cas uint64, value []byte, raw bool) (uint64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.values[key].cas != cas {
+		return 0, fmt.Errorf("CAS mismatch for key %s", key)
+	}
+	if s.dir != "" {
+		if err := s.persist(key, value); err != nil {
+			return 0, err
+		}
+	}
+	s.lastCas++
+	stored := localDenseValue{value: make([]byte, len(value)), cas: s.lastCas}
+	copy(stored.value, value)
+	s.values[key] = stored
+	return stored.cas, nil
+}
+
+func (s *LocalDenseStore) Delete(key string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.dir != "" {
+		path := filepath.Join(s.dir, hex.EncodeToString([]byte(key)))
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	delete(s.values, key)
+	return nil
+}
+
+// Writes a value to its file, replacing the previous one only once it's complete.  Requires the lock.
+func (s *LocalDenseStore) persist(key string, value []byte) error {
+	path := filepath.Join(s.dir, hex.EncodeToString([]byte(key)))
+	if err := ioutil.WriteFile(path+".tmp", value, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
@@ -0,0 +1,179 @@
+package db
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalDenseStore(t *testing.T) {
+	store, err := NewLocalDenseStore("")
+	assert.NoError(t, err)
+
+	_, _, err = store.GetRaw("key1")
+	assert.True(t, base.IsDocNotFoundError(err))
+
+	// A zero CAS only writes new keys
+	cas, err := store.WriteCas("key1", 0, []byte("value1"), true)
+	assert.NoError(t, err)
+	_, err = store.WriteCas("key1", 0, []byte("value2"), true)
+	assert.True(t, base.IsCasMismatch(err))
+	_, err = store.WriteCas("key1", cas+1, []byte("value2"), true)
+	assert.True(t, base.IsCasMismatch(err))
+
+	updatedCas, err := store.WriteCas("key1", cas, []byte("value2"), true)
+	assert.NoError(t, err)
+	assert.NotEqual(t, cas, updatedCas)
+	value, getCas, err := store.GetRaw("key1")
+	assert.NoError(t, err)
+	assert.Equal(t, "value2", string(value))
+	assert.Equal(t, updatedCas, getCas)
+
+	// Callers can't modify the stored value
+	value[0] = 'X'
+	value, _, err = store.GetRaw("key1")
+	assert.NoError(t, err)
+	assert.Equal(t, "value2", string(value))
+}
+
+func TestLocalDenseStorePersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dense_store")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	initCount := MaxListBlockCount
+	MaxListBlockCount = 5
+	defer func() {
+		MaxListBlockCount = initCount
+	}()
+
+	store, err := NewLocalDenseStore(dir)
+	assert.NoError(t, err)
+	list := NewDenseBlockList("ABC", 1, store)
+	for i := 1; i <= MaxListBlockCount+2; i++ {
+		_, err := list.AddBlock()
+		assert.NoError(t, err)
+	}
+	_, _, _, _, err = list.GetActiveBlock().AddEntrySet([]*LogEntry{makeBlockEntry("doc1", "1-a", 20, 5, IsNotRemoval, IsAdded)}, store)
+	assert.NoError(t, err)
+
+	// A new store in the same directory has the rotated list, the active list and the blocks
+	reopened, err := NewLocalDenseStore(dir)
+	assert.NoError(t, err)
+	newList := NewDenseBlockListReader("ABC", 1, reopened)
+	if assert.NotNil(t, newList) {
+		assert.Len(t, newList.blocks, 2)
+		assert.NoError(t, newList.LoadPrevious())
+		assert.Len(t, newList.blocks, MaxListBlockCount+3)
+		entries := newList.GetActiveBlock().GetAllEntries()
+		if assert.Len(t, entries, 1) {
+			assertLogEntry(t, entries[0], "doc1", "1-a", 20, 5)
+		}
+	}
+}
+
+func TestDenseStoreWriteCasRaw(t *testing.T) {
+	store, err := NewLocalDenseStore("")
+	assert.NoError(t, err)
+	_, err = store.WriteCas("key1", 0, []byte("a"), true)
+	assert.NoError(t, err)
+
+	// A stale CAS is retried with the current value
+	_, err = denseStoreWriteCasRaw(store, "key1", []byte("stale"), 0, func(value []byte) ([]byte, error) {
+		return append(value, 'b'), nil
+	})
+	assert.NoError(t, err)
+	value, _, err := store.GetRaw("key1")
+	assert.NoError(t, err)
+	assert.Equal(t, "ab", string(value))
+}
+
+func TestDenseStoreDelete(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dense_store")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store, err := NewLocalDenseStore(dir)
+	assert.NoError(t, err)
+	_, err = store.WriteCas("key1", 0, []byte("a"), true)
+	assert.NoError(t, err)
+
+	assert.NoError(t, store.Delete("key1"))
+	_, _, err = store.GetRaw("key1")
+	assert.True(t, base.IsDocNotFoundError(err))
+
+	// Deleting a missing key isn't an error, and deleted keys stay deleted when the store is reopened
+	assert.NoError(t, store.Delete("key1"))
+	reopened, err := NewLocalDenseStore(dir)
+	assert.NoError(t, err)
+	_, _, err = reopened.GetRaw("key1")
+	assert.True(t, base.IsDocNotFoundError(err))
+}
+
+func TestLocalDenseStoreBlockUpdates(t *testing.T) {
+	store, err := NewLocalDenseStore("")
+	assert.NoError(t, err)
+
+	block := NewDenseBlock("block1", nil)
+	entries := make([]*LogEntry, 10)
+	for i := 0; i < 10; i++ {
+		entries[i] = makeBlockEntry(fmt.Sprintf("doc%d", i), "1-abc", i%3, i+1, IsNotRemoval, IsAdded)
+	}
+	overflow, pendingRemoval, updateClock, _, err := block.AddEntrySet(entries, store)
+	assert.NoError(t, err)
+	assert.Len(t, overflow, 0)
+	assert.Len(t, pendingRemoval, 0)
+	assert.Equal(t, uint64(10), updateClock.GetSequence(0))
+
+	// Updates replace the previous entry for the doc
+	entries = []*LogEntry{makeBlockEntry("doc0", "2-abc", 0, 11, IsNotRemoval, IsNotAdded)}
+	entries[0].PrevSequence = 1
+	_, pendingRemoval, _, _, err = block.AddEntrySet(entries, store)
+	assert.NoError(t, err)
+	assert.Len(t, pendingRemoval, 0)
+	assert.Equal(t, uint16(10), block.getEntryCount())
+
+	// Rollback removes the later entries for the vbucket
+	rollbackComplete, err := block.RollbackTo(0, 5, store)
+	assert.NoError(t, err)
+	assert.True(t, rollbackComplete)
+	assert.Equal(t, uint16(7), block.getEntryCount())
+
+	// A block loaded from the store has the same entries
+	reloaded := NewDenseBlock("block1", nil)
+	assert.NoError(t, reloaded.loadBlock(store))
+	assert.Equal(t, block.GetAllEntries(), reloaded.GetAllEntries())
+}
+
+func TestLocalDenseStoreConcurrentUpdates(t *testing.T) {
+	store, err := NewLocalDenseStore("")
+	assert.NoError(t, err)
+
+	block := NewDenseBlock("block1", nil)
+	_, _, _, casFail, err := block.AddEntrySet([]*LogEntry{makeBlockEntry("doc1", "1-abc", 50, 1, IsNotRemoval, IsAdded)}, store)
+	assert.NoError(t, err)
+	assert.False(t, casFail)
+
+	// A second writer fails on CAS until it reloads the block
+	block2 := NewDenseBlock("block1", nil)
+	entries2 := []*LogEntry{makeBlockEntry("doc2", "1-abc", 50, 3, IsNotRemoval, IsAdded)}
+	overflow, _, _, casFail, err := block2.AddEntrySet(entries2, store)
+	assert.NoError(t, err)
+	assert.True(t, casFail)
+	assert.Len(t, overflow, 1)
+
+	assert.NoError(t, block2.loadBlock(store))
+	overflow, _, _, casFail, err = block2.AddEntrySet(entries2, store)
+	assert.NoError(t, err)
+	assert.False(t, casFail)
+	assert.Len(t, overflow, 0)
+	foundEntries := block2.GetAllEntries()
+	if assert.Len(t, foundEntries, 2) {
+		assertLogEntry(t, foundEntries[0], "doc1", "1-abc", 50, 1)
+		assertLogEntry(t, foundEntries[1], "doc2", "1-abc", 50, 3)
+	}
+}
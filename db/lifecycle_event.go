@@ -0,0 +1,48 @@
+package db
+
+import (
+	"time"
+
+	"github.com/couchbase/sync_gateway/base"
+)
+
+// Database lifecycle transitions, for a DBLifecycleEvent
+const (
+	DBLifecycleOnline             = "online"              // The database came online
+	DBLifecycleOffline            = "offline"             // The database went offline
+	DBLifecycleResyncStarted      = "resync_started"      // A _resync started re-running the sync function on all documents
+	DBLifecycleResyncFinished     = "resync_finished"     // A _resync finished, or failed
+	DBLifecycleCompactionStarted  = "compaction_started"  // A compaction, manual or automatic, started purging tombstones
+	DBLifecycleCompactionFinished = "compaction_finished" // A compaction finished, or failed
+)
+
+// Describes a database lifecycle transition, for a DBLifecycleEvent.
+type DBLifecycleStatus struct {
+	Transition       string        // One of the DBLifecycle constants
+	Reason           string        // For DBLifecycleOnline and DBLifecycleOffline, why the state changed
+	DocsChanged      int           // For DBLifecycleResyncFinished, the # of docs whose channels or access changed
+	TombstonesPurged int           // For DBLifecycleCompactionFinished, the # of tombstones purged
+	Duration         time.Duration // For the finished transitions, how long the operation took
+	Error            error         // For the finished transitions, why the operation failed
+}
+
+// Logs a lifecycle transition of the database, and raises a DBLifecycleEvent for it.
+func (context *DatabaseContext) NotifyLifecycle(status DBLifecycleStatus) {
+	switch {
+	case status.Error != nil:
+		base.Warnf(base.KeyAll, "Database %s: %s after %v with error: %v", base.MD(context.Name), status.Transition, status.Duration, status.Error)
+	case status.Reason != "":
+		base.Infof(base.KeyAll, "Database %s: %s (%s)", base.MD(context.Name), status.Transition, status.Reason)
+	case status.Duration > 0:
+		base.Infof(base.KeyAll, "Database %s: %s after %v", base.MD(context.Name), status.Transition, status.Duration)
+	default:
+		base.Infof(base.KeyAll, "Database %s: %s", base.MD(context.Name), status.Transition)
+	}
+
+	if !context.EventMgr.HasHandlerForEvent(DBLifecycleChange) {
+		return
+	}
+	if err := context.EventMgr.RaiseDBLifecycleEvent(context.Name, status); err != nil {
+		base.Warnf(base.KeyAll, "Error raising %s event for database %s: %v", status.Transition, base.MD(context.Name), err)
+	}
+}
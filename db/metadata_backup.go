@@ -0,0 +1,224 @@
+package db
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/couchbase/sync_gateway/auth"
+	"github.com/couchbase/sync_gateway/base"
+)
+
+// Categories of the docs in a metadata backup
+const (
+	MetadataSequence     = "sequence"      // The sequence counter
+	MetadataSyncFunction = "sync_function" // The record of the sync function the docs were last synced with
+	MetadataUsers        = "users"         // Users, and the index of users by email
+	MetadataRoles        = "roles"
+	MetadataSessions     = "sessions" // Only backed up if requested
+	MetadataLocalDocs    = "local_docs"
+	MetadataAccessAudit  = "access_audit"
+	MetadataChannelIndex = "channel_index" // The channel index's partition map
+)
+
+// Prefixes of the keys of the internal docs that are backed up.  Other internal docs, like DCP checkpoints and old
+// revision bodies, belong to the bucket or to the app's documents, so they aren't.
+var metadataBackupPrefixes = []struct {
+	prefix   string
+	category string
+}{
+	{auth.UserKeyPrefix, MetadataUsers},
+	{"_sync:useremail:", MetadataUsers},
+	{auth.RoleKeyPrefix, MetadataRoles},
+	{auth.SessionKeyPrefix, MetadataSessions},
+	{"_sync:local:", MetadataLocalDocs},
+	{AccessAuditKeyPrefix, MetadataAccessAudit},
+}
+
+// A document in a metadata backup.  Backups are written as one JSON object per line, like bucket snapshots.
+type MetadataBackupDoc struct {
+	Category string          `json:"category"`
+	Key      string          `json:"key"`
+	JSON     json.RawMessage `json:"json,omitempty"`
+	Raw      []byte          `json:"raw,omitempty"`
+}
+
+// Options for BackupMetadata.
+type MetadataBackupOptions struct {
+	IncludeSessions bool // Back up users' login sessions too, so they don't have to log in again after a restore
+}
+
+// Returns the backup category of an internal doc, or "" if it isn't backed up.
+func metadataCategory(key string) string {
+	switch key {
+	case SyncSeqKey:
+		return MetadataSequence
+	case kSyncDataKey:
+		return MetadataSyncFunction
+	}
+	for _, p := range metadataBackupPrefixes {
+		if strings.HasPrefix(key, p.prefix) {
+			return p.category
+		}
+	}
+	return ""
+}
+
+// Writes a backup of the database's metadata to w: its users, roles, local docs (including replication
+// checkpoints), sequence counter and access audit trails, plus the channel index's partition map if it has a
+// channel index.  Returns the number of docs backed up in each category.  The app's documents aren't included, so
+// the backup can be restored into a fresh bucket that the documents are then replicated or imported into.
+func (dbc *DatabaseContext) BackupMetadata(w io.Writer, options MetadataBackupOptions) (map[string]int, error) {
+	results, err := dbc.QuerySyncDocs()
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	var row QueryIdRow
+	for results.Next(&row) {
+		keys = append(keys, row.Id)
+	}
+	if err := results.Close(); err != nil {
+		return nil, err
+	}
+
+	buffered := bufio.NewWriter(w)
+	encoder := json.NewEncoder(buffered)
+	counts := map[string]int{}
+	write := func(category, key string, value []byte) error {
+		doc := MetadataBackupDoc{Category: category, Key: key}
+		if json.Valid(value) {
+			doc.JSON = value
+		} else {
+			doc.Raw = value
+		}
+		counts[category]++
+		return encoder.Encode(doc)
+	}
+
+	for _, key := range keys {
+		category := metadataCategory(key)
+		if category == "" || (category == MetadataSessions && !options.IncludeSessions) {
+			continue
+		}
+		value, _, err := dbc.MetadataBucket.GetRaw(key)
+		if base.IsDocNotFoundError(err) {
+			continue // Removed since the query, e.g. an expired session
+		} else if err != nil {
+			return nil, err
+		}
+		if err := write(category, key, value); err != nil {
+			return nil, err
+		}
+	}
+
+	if indexBucket := dbc.channelIndexBucket(); indexBucket != nil {
+		value, _, err := indexBucket.GetRaw(base.KIndexPartitionKey)
+		if err == nil {
+			err = write(MetadataChannelIndex, base.KIndexPartitionKey, value)
+		} else if base.IsKeyNotFoundError(indexBucket, err) {
+			err = nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := buffered.Flush(); err != nil {
+		return nil, err
+	}
+	base.Infof(base.KeyAll, "Backed up metadata of db %s: %v", base.MD(dbc.Name), counts)
+	return counts, nil
+}
+
+// Restores a backup written by BackupMetadata into the database, which should be offline.  Docs in the backup
+// replace existing ones with the same keys, except that the sequence counter is never moved backwards and an
+// existing channel index partition map is kept.  Expired sessions are skipped.  Returns the number of docs restored
+// in each category.
+func (dbc *DatabaseContext) RestoreMetadata(r io.Reader) (map[string]int, error) {
+	decoder := json.NewDecoder(r)
+	counts := map[string]int{}
+	for {
+		var doc MetadataBackupDoc
+		if err := decoder.Decode(&doc); err == io.EOF {
+			break
+		} else if err != nil {
+			return counts, fmt.Errorf("Invalid metadata backup: %v", err)
+		}
+		if metadataCategory(doc.Key) != doc.Category && doc.Category != MetadataChannelIndex {
+			return counts, fmt.Errorf("Invalid metadata backup: %q isn't in category %q", doc.Key, doc.Category)
+		}
+		value := []byte(doc.JSON)
+		if doc.JSON == nil {
+			value = doc.Raw
+		}
+
+		restored, err := dbc.restoreMetadataDoc(doc.Category, doc.Key, value)
+		if err != nil {
+			return counts, fmt.Errorf("Unable to restore %q: %v", doc.Key, err)
+		}
+		if restored {
+			counts[doc.Category]++
+		}
+	}
+	base.Infof(base.KeyAll, "Restored metadata of db %s: %v", base.MD(dbc.Name), counts)
+	return counts, nil
+}
+
+// Writes a doc from a metadata backup, returning false if it was skipped.
+func (dbc *DatabaseContext) restoreMetadataDoc(category, key string, value []byte) (bool, error) {
+	var expiry uint32
+	switch category {
+	case MetadataSequence:
+		return true, dbc.restoreSequenceCounter(value)
+	case MetadataChannelIndex:
+		indexBucket := dbc.channelIndexBucket()
+		if indexBucket == nil || key != base.KIndexPartitionKey {
+			base.Warnf(base.KeyAll, "Skipping channel index doc %q, as db %s doesn't have a channel index", key, base.MD(dbc.Name))
+			return false, nil
+		}
+		added, err := indexBucket.AddRaw(key, 0, value)
+		if err == nil && !added {
+			base.Warnf(base.KeyAll, "Keeping the existing channel index partition map of db %s", base.MD(dbc.Name))
+		}
+		return added, err
+	case MetadataSessions:
+		var session auth.LoginSession
+		if err := json.Unmarshal(value, &session); err != nil {
+			return false, err
+		}
+		ttl := time.Until(session.Expiration)
+		if ttl <= 0 {
+			return false, nil
+		}
+		expiry = base.DurationToCbsExpiry(ttl)
+	case MetadataLocalDocs:
+		if dbc.Options.LocalDocExpirySecs > 0 {
+			expiry = base.SecondsToCbsExpiry(int(dbc.Options.LocalDocExpirySecs))
+		}
+	}
+	return true, dbc.MetadataBucket.SetRaw(key, expiry, value)
+}
+
+// Raises the sequence counter to the backed up value, if it's lower, so that restored principals' sequences aren't
+// reused.
+func (dbc *DatabaseContext) restoreSequenceCounter(value []byte) error {
+	backupSeq, err := strconv.ParseUint(strings.TrimSpace(string(value)), 10, 64)
+	if err != nil {
+		return fmt.Errorf("Invalid sequence counter: %v", err)
+	}
+	_, err = dbc.raiseSequenceCounter(backupSeq)
+	return err
+}
+
+// Returns the channel index bucket, or nil if the database doesn't use a channel index.
+func (dbc *DatabaseContext) channelIndexBucket() base.Bucket {
+	if changeIndex, ok := dbc.changeCache.(*kvChangeIndex); ok {
+		return changeIndex.reader.indexReadBucket
+	}
+	return nil
+}
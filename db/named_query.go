@@ -0,0 +1,129 @@
+package db
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/couchbase/gocb"
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbase/sync_gateway/channels"
+)
+
+// Prefix of the names of named queries in query stats
+const QueryTypeNamed = "named_"
+
+// Parameter holding the channels a user can see, in named queries run on behalf of users.  Names of named query
+// parameters can't start with an underscore, so it can't clash with them.
+const QueryParamUserChannels = "_userChannels"
+
+// NamedQuery is an admin-defined N1QL query that clients can run by name, passing only the values of its parameters.
+// The query runs against the documents of the database (not Sync Gateway's internal docs, or deleted documents), and
+// when it's run on behalf of a user, only returns the documents in channels the user can see.
+type NamedQuery struct {
+	Select     string   // Expressions of the SELECT clause.  The document ID is always projected as "id"
+	Where      string   // Optional condition of the WHERE clause
+	OrderBy    string   // Optional ORDER BY clause
+	Limit      int      // Max # of rows to return, or 0 for no limit
+	Parameters []string // Names of the parameters, referenced in the clauses as $name.  All of them are required
+}
+
+// Assembles the query's N1QL statement.  If restrictToChannels is set, it only matches documents in one of the
+// channels given by the QueryParamUserChannels parameter.
+func (q *NamedQuery) statement(useXattrs bool, restrictToChannels bool) string {
+	statement := fmt.Sprintf(
+		"SELECT META(`%s`).id AS id, %s "+
+			"FROM `%s` "+
+			"WHERE META(`%s`).id NOT LIKE '%s' "+
+			"AND $sync IS NOT MISSING "+
+			"AND ($sync.flags IS MISSING OR BITTEST($sync.flags,1) = false)",
+		base.BucketQueryToken, q.Select, base.BucketQueryToken, base.BucketQueryToken, SyncDocWildcard)
+	if restrictToChannels {
+		statement += fmt.Sprintf(" AND ANY op IN OBJECT_PAIRS($sync.channels) "+
+			"SATISFIES op.val IS NOT VALUED AND op.name IN $%s END", QueryParamUserChannels)
+	}
+
+	// Only Sync Gateway's own clauses refer to the sync metadata
+	statement = replaceSyncTokensQuery(statement, useXattrs)
+	if q.Where != "" {
+		statement += " AND (" + q.Where + ")"
+	}
+	if q.OrderBy != "" {
+		statement += " ORDER BY " + q.OrderBy
+	}
+	if q.Limit > 0 {
+		statement += fmt.Sprintf(" LIMIT %d", q.Limit)
+	}
+	return statement
+}
+
+// Runs the named query with the given parameter values, returning its rows.  Every one of the query's parameters has
+// to be given a value, and no others may be.  Fails with a 403 error for users the database's read transform applies
+// to.
+func (db *Database) QueryNamed(name string, params map[string]interface{}) ([]interface{}, error) {
+	query, ok := db.Options.NamedQueries[name]
+	if !ok {
+		return nil, base.HTTPErrorf(http.StatusNotFound, "No such query %q", name)
+	}
+	// Rows are projected straight from the bucket, so the read transform couldn't hide properties from them
+	if db.Options.ReadTransform.AppliesTo(db.user) {
+		return nil, base.HTTPErrorf(http.StatusForbidden, "Named queries aren't available to users whose reads are transformed")
+	}
+	if !base.IsN1QLBucket(db.Bucket) {
+		return nil, base.HTTPErrorf(http.StatusNotImplemented, "Named queries require a Couchbase Server bucket")
+	}
+
+	queryParams := make(map[string]interface{}, len(query.Parameters)+1)
+	for _, param := range query.Parameters {
+		value, ok := params[param]
+		if !ok {
+			return nil, base.HTTPErrorf(http.StatusBadRequest, "Missing query parameter %q", param)
+		}
+		queryParams[param] = value
+	}
+	if len(params) > len(query.Parameters) {
+		for param := range params {
+			if _, ok := queryParams[param]; !ok {
+				return nil, base.HTTPErrorf(http.StatusBadRequest, "Unknown query parameter %q", param)
+			}
+		}
+	}
+
+	restrictToChannels := db.user != nil && !db.user.CanSeeChannel(channels.UserStarChannel)
+	if restrictToChannels {
+		queryParams[QueryParamUserChannels] = db.user.InheritedChannels().AsSet().ToArray()
+	}
+
+	statement := query.statement(db.UseXattrs(), restrictToChannels)
+	results, err := db.N1QLQueryWithStats(QueryTypeNamed+name, statement, queryParams, gocb.RequestPlus, false)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := []interface{}{}
+	for {
+		var row interface{}
+		if !results.Next(&row) {
+			break
+		}
+		rows = append(rows, row)
+	}
+	if err := results.Close(); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// Returns whether the given name can be used as a named query's parameter.  Names have to be N1QL identifiers, and
+// can't start with an underscore, which is reserved for Sync Gateway's own parameters.
+func IsValidNamedQueryParameter(name string) bool {
+	if name == "" || strings.HasPrefix(name, "_") {
+		return false
+	}
+	for i, c := range name {
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 0 && c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,65 @@
+package db
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamedQueryStatement(t *testing.T) {
+	query := &NamedQuery{
+		Select:     "title, `$_bucket`.author",
+		Where:      "type = 'post' AND author = $author",
+		OrderBy:    "title",
+		Limit:      10,
+		Parameters: []string{"author"},
+	}
+
+	statement := query.statement(false, false)
+	assert.True(t, strings.HasPrefix(statement, "SELECT META(`$_bucket`).id AS id, title, `$_bucket`.author FROM `$_bucket` WHERE "))
+	assert.True(t, strings.HasSuffix(statement, " AND (type = 'post' AND author = $author) ORDER BY title LIMIT 10"))
+	assert.Contains(t, statement, "`$_bucket`._sync IS NOT MISSING")
+	assert.NotContains(t, statement, "$sync")
+	assert.NotContains(t, statement, "$"+QueryParamUserChannels)
+
+	statement = query.statement(true, true)
+	assert.Contains(t, statement, "meta(`$_bucket`).xattrs._sync.channels")
+	assert.Contains(t, statement, "op.name IN $"+QueryParamUserChannels+" END AND (type = 'post'")
+
+	// Optional clauses are left out
+	statement = (&NamedQuery{Select: "title"}).statement(false, false)
+	assert.True(t, strings.HasSuffix(statement, "BITTEST(`$_bucket`._sync.flags,1) = false)"))
+}
+
+func TestQueryNamedErrors(t *testing.T) {
+	db, testBucket := setupTestDB(t)
+	defer testBucket.Close()
+	defer tearDownTestDB(t, db)
+
+	db.Options.NamedQueries = map[string]*NamedQuery{
+		"posts": {Select: "title", Where: "author = $author", Parameters: []string{"author"}},
+	}
+	_, err := db.QueryNamed("missing", nil)
+	assertHTTPError(t, err, http.StatusNotFound)
+	if base.UnitTestUrlIsWalrus() {
+		_, err = db.QueryNamed("posts", map[string]interface{}{"author": "alice"})
+		assertHTTPError(t, err, http.StatusNotImplemented)
+		return
+	}
+	_, err = db.QueryNamed("posts", nil)
+	assertHTTPError(t, err, http.StatusBadRequest)
+	_, err = db.QueryNamed("posts", map[string]interface{}{"author": "alice", "other": 1})
+	assertHTTPError(t, err, http.StatusBadRequest)
+}
+
+func TestIsValidNamedQueryParameter(t *testing.T) {
+	for _, name := range []string{"author", "a1", "min_date", "A"} {
+		assert.True(t, IsValidNamedQueryParameter(name), name)
+	}
+	for _, name := range []string{"", "_userChannels", "1a", "a-b", "a b", "$a"} {
+		assert.False(t, IsValidNamedQueryParameter(name), name)
+	}
+}
@@ -0,0 +1,170 @@
+package db
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/couchbase/sync_gateway/auth"
+	"github.com/couchbase/sync_gateway/base"
+)
+
+// Kinds of orphaned metadata
+const (
+	OrphanedSessions    = "sessions"     // Login sessions of users that no longer exist
+	OrphanedUserEmails  = "user_emails"  // Entries in the index of users by email, for users that no longer exist or have changed their email
+	OrphanedAccessAudit = "access_audit" // Access audit trails of users and roles that no longer exist
+	OrphanedCheckpoints = "checkpoints"  // Checkpoints of replication clients that haven't been updated for longer than the max age
+)
+
+// Keys of the local docs that store the checkpoints of replication clients, e.g. "_local/checkpoint/<client>" for
+// Couchbase Lite 2.x (see handleGetCheckpoint).  Other local docs belong to the app, so are never orphaned.
+const localCheckpointKeyPrefix = "_sync:local:checkpoint/"
+
+// Options for CleanOrphanedMetadata.
+type OrphanedMetadataOptions struct {
+	CheckpointMaxAge time.Duration // Checkpoints not updated for this long are orphaned.  Zero doesn't check checkpoints
+	Preview          bool          // Only report the orphaned docs, without deleting them
+}
+
+// The outcome of CleanOrphanedMetadata.
+type OrphanedMetadataResult struct {
+	Scanned  int                 `json:"scanned"`  // Number of internal docs checked
+	Orphaned map[string][]string `json:"orphaned"` // Keys of the orphaned docs found, by kind
+	Deleted  int                 `json:"deleted"`
+}
+
+// Scans the database's internal docs for ones that are no longer needed, because the user, role or client they
+// belong to has gone away, and deletes them unless previewing.  Checkpoints written before their update time was
+// recorded are never orphaned.  Channel index blocks are out of scope: they can't be found without the names of their
+// channels, so the index of a channel that's no longer used has to be removed by rebuilding it with
+// -rebuild-channel-index.
+func (dbc *DatabaseContext) CleanOrphanedMetadata(options OrphanedMetadataOptions) (*OrphanedMetadataResult, error) {
+	results, err := dbc.QuerySyncDocs()
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	var row QueryIdRow
+	for results.Next(&row) {
+		keys = append(keys, row.Id)
+	}
+	if err := results.Close(); err != nil {
+		return nil, err
+	}
+
+	result := &OrphanedMetadataResult{Orphaned: map[string][]string{}}
+	checker := orphanChecker{dbc: dbc, principals: map[string]bool{}, now: time.Now()}
+	for _, key := range keys {
+		kind, err := checker.orphanedKind(key, options.CheckpointMaxAge)
+		if base.IsDocNotFoundError(err) {
+			continue // Removed since the query, e.g. an expired session
+		} else if err != nil {
+			return nil, err
+		}
+		if kind == "" {
+			continue
+		}
+		result.Orphaned[kind] = append(result.Orphaned[kind], key)
+		if options.Preview {
+			continue
+		}
+		if err := dbc.MetadataBucket.Delete(key); err != nil && !base.IsDocNotFoundError(err) {
+			return nil, err
+		}
+		result.Deleted++
+	}
+	result.Scanned = checker.scanned
+
+	base.Infof(base.KeyAll, "Found orphaned metadata in db %s: %d sessions, %d user emails, %d access audit trails, %d checkpoints; %d deleted",
+		base.MD(dbc.Name), len(result.Orphaned[OrphanedSessions]), len(result.Orphaned[OrphanedUserEmails]),
+		len(result.Orphaned[OrphanedAccessAudit]), len(result.Orphaned[OrphanedCheckpoints]), result.Deleted)
+	return result, nil
+}
+
+// Checks whether internal docs are orphaned, remembering which principals exist.
+type orphanChecker struct {
+	dbc        *DatabaseContext
+	principals map[string]bool // Whether principals exist, keyed by "user:name" or "role:name"
+	now        time.Time
+	scanned    int
+}
+
+// Returns the kind of orphaned metadata the doc with the given key is, or "" if it isn't orphaned or isn't checked.
+func (c *orphanChecker) orphanedKind(key string, checkpointMaxAge time.Duration) (string, error) {
+	switch {
+	case strings.HasPrefix(key, auth.SessionKeyPrefix):
+		c.scanned++
+		var session auth.LoginSession
+		if _, err := c.dbc.MetadataBucket.Get(key, &session); err != nil {
+			return "", err
+		}
+		exists, err := c.principalExists(session.Username, false)
+		if err != nil || exists {
+			return "", err
+		}
+		return OrphanedSessions, nil
+
+	case strings.HasPrefix(key, "_sync:useremail:"):
+		c.scanned++
+		email := strings.TrimPrefix(key, "_sync:useremail:")
+		user, err := c.dbc.Authenticator().GetUserByEmail(email)
+		if err != nil || (user != nil && user.Email() == email) {
+			return "", err
+		}
+		return OrphanedUserEmails, nil
+
+	case strings.HasPrefix(key, AccessAuditKeyPrefix):
+		c.scanned++
+		principal := strings.TrimPrefix(key, AccessAuditKeyPrefix)
+		isRole := strings.HasPrefix(principal, "role:")
+		name := principal[strings.Index(principal, ":")+1:]
+		exists, err := c.principalExists(name, isRole)
+		if err != nil || exists {
+			return "", err
+		}
+		return OrphanedAccessAudit, nil
+
+	case strings.HasPrefix(key, localCheckpointKeyPrefix) && checkpointMaxAge > 0:
+		c.scanned++
+		var localDoc map[string]json.RawMessage
+		if _, err := c.dbc.MetadataBucket.Get(key, &localDoc); err != nil {
+			return "", err
+		}
+		var updatedAt time.Time
+		if err := json.Unmarshal(localDoc[localDocUpdatedAt], &updatedAt); err != nil || updatedAt.IsZero() {
+			return "", nil
+		}
+		if c.now.Sub(updatedAt) <= checkpointMaxAge {
+			return "", nil
+		}
+		return OrphanedCheckpoints, nil
+	}
+	return "", nil
+}
+
+func (c *orphanChecker) principalExists(name string, isRole bool) (bool, error) {
+	principalKey := "user:" + name
+	if isRole {
+		principalKey = "role:" + name
+	}
+	if exists, found := c.principals[principalKey]; found {
+		return exists, nil
+	}
+	var exists bool
+	if isRole {
+		role, err := c.dbc.Authenticator().GetRole(name)
+		if err != nil {
+			return false, err
+		}
+		exists = role != nil
+	} else {
+		user, err := c.dbc.Authenticator().GetUser(name)
+		if err != nil {
+			return false, err
+		}
+		exists = user != nil
+	}
+	c.principals[principalKey] = exists
+	return exists, nil
+}
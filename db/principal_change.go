@@ -0,0 +1,89 @@
+package db
+
+import (
+	"sort"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbase/sync_gateway/channels"
+)
+
+// How a principal changed, for a PrincipalChangeEvent
+const (
+	PrincipalCreated      = "created"       // Created via the admin REST API
+	PrincipalUpdated      = "updated"       // Admin channels or roles changed via the admin REST API
+	PrincipalDeleted      = "deleted"       // Deleted via the admin REST API
+	PrincipalGrantChanged = "grant_changed" // A document's access() or role() grants to it changed
+)
+
+// Describes a change to a user's or role's access, for a PrincipalChangeEvent.  The channels and roles are only
+// those that changed: a channel lost via one document's grants may still be granted by another.
+type PrincipalDelta struct {
+	Name            string
+	IsRole          bool
+	Change          string // One of PrincipalCreated, PrincipalUpdated, PrincipalDeleted or PrincipalGrantChanged
+	DocID           string // For PrincipalGrantChanged, the document whose grants changed
+	ChannelsAdded   []string
+	ChannelsRemoved []string
+	RolesAdded      []string // Users only
+	RolesRemoved    []string // Users only
+}
+
+// Returns the sorted names that are in set but not in other.
+func setDifference(set, other base.Set) []string {
+	result := []string{}
+	for name := range set {
+		if !other.Contains(name) {
+			result = append(result, name)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Returns the changes to the principals granted access by the document, from its channel grants (access) and role
+// grants (roles) changing to the given new grants.  Must be called before the document's grants are updated.
+func (doc *document) grantDeltas(access, roles channels.AccessMap) []PrincipalDelta {
+	var deltas []PrincipalDelta
+	deltaIndexes := map[string]int{} // Index in deltas of each access name's delta
+	delta := func(accessName string) *PrincipalDelta {
+		if i, found := deltaIndexes[accessName]; found {
+			return &deltas[i]
+		}
+		name, isRole := channels.AccessNameToPrincipalName(accessName)
+		deltaIndexes[accessName] = len(deltas)
+		deltas = append(deltas, PrincipalDelta{Name: name, IsRole: isRole, Change: PrincipalGrantChanged, DocID: doc.ID})
+		return &deltas[len(deltas)-1]
+	}
+
+	forEachAccessChange(doc.Access, access, func(accessName string, added, removed []string) {
+		d := delta(accessName)
+		d.ChannelsAdded, d.ChannelsRemoved = added, removed
+	})
+	forEachAccessChange(doc.RoleAccess, roles, func(accessName string, added, removed []string) {
+		d := delta(accessName)
+		d.RolesAdded, d.RolesRemoved = added, removed
+	})
+	return deltas
+}
+
+// Calls fn with the names added and removed for each principal whose access differs between the two maps.
+func forEachAccessChange(accessMap UserAccessMap, newAccess channels.AccessMap, fn func(accessName string, added, removed []string)) {
+	accessNames := make([]string, 0, len(accessMap)+len(newAccess))
+	for accessName := range accessMap {
+		accessNames = append(accessNames, accessName)
+	}
+	for accessName := range newAccess {
+		if _, found := accessMap[accessName]; !found {
+			accessNames = append(accessNames, accessName)
+		}
+	}
+	sort.Strings(accessNames)
+
+	for _, accessName := range accessNames {
+		oldSet := accessMap[accessName].AsSet()
+		added, removed := setDifference(newAccess[accessName], oldSet), setDifference(oldSet, newAccess[accessName])
+		if len(added) > 0 || len(removed) > 0 {
+			fn(accessName, added, removed)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package db
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/couchbase/sync_gateway/auth"
+	"github.com/couchbase/sync_gateway/base"
+)
+
+// Version of the principal export format
+const PrincipalExportVersion = 1
+
+// How imported principals are combined with existing ones of the same name.
+type PrincipalImportMode string
+
+const (
+	PrincipalImportMerge     PrincipalImportMode = "merge"     // Adds the imported channels and roles to existing principals
+	PrincipalImportOverwrite PrincipalImportMode = "overwrite" // Replaces existing principals with the imported ones
+)
+
+// The users and roles of a database, in a portable form that can be imported into another database.  Only the
+// grants made through the admin API and config are exported; the grants made by documents' access() and role()
+// calls are rebuilt as the documents are imported into the target database.
+type PrincipalExport struct {
+	Version    int                 `json:"version"`
+	Database   string              `json:"database,omitempty"` // The database the principals were exported from
+	ExportedAt time.Time           `json:"exported_at"`
+	Roles      []ExportedPrincipal `json:"roles"`
+	Users      []ExportedPrincipal `json:"users"`
+}
+
+// A user or role in a PrincipalExport.
+type ExportedPrincipal struct {
+	Name          string   `json:"name"`
+	AdminChannels []string `json:"admin_channels,omitempty"`
+	// Fields below only apply to users:
+	Email        string   `json:"email,omitempty"`
+	Disabled     bool     `json:"disabled,omitempty"`
+	AdminRoles   []string `json:"admin_roles,omitempty"`
+	PasswordHash []byte   `json:"password_hash,omitempty"` // bcrypt hash of the password, base64 encoded
+}
+
+// The outcome of importing principals.
+type PrincipalImportResult struct {
+	Created int               `json:"created"`
+	Updated int               `json:"updated"`
+	Failed  map[string]string `json:"failed,omitempty"` // Error messages, keyed by "user:name" or "role:name"
+}
+
+// Exports the database's users and roles, sorted by name.  The guest user isn't exported, since it's defined in
+// the config.  If includePasswords is false, the users' password hashes are left out.
+func (dbc *DatabaseContext) ExportPrincipals(includePasswords bool) (*PrincipalExport, error) {
+	userNames, roleNames, err := dbc.AllPrincipalIDs()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(userNames)
+	sort.Strings(roleNames)
+
+	export := &PrincipalExport{
+		Version:    PrincipalExportVersion,
+		Database:   dbc.Name,
+		ExportedAt: time.Now().UTC(),
+		Roles:      make([]ExportedPrincipal, 0, len(roleNames)),
+		Users:      make([]ExportedPrincipal, 0, len(userNames)),
+	}
+	authenticator := dbc.Authenticator()
+	for _, name := range roleNames {
+		role, err := authenticator.GetRole(name)
+		if err != nil {
+			return nil, err
+		}
+		if role == nil {
+			continue // deleted since the query
+		}
+		export.Roles = append(export.Roles, exportPrincipal(role, includePasswords))
+	}
+	for _, name := range userNames {
+		user, err := authenticator.GetUser(name)
+		if err != nil {
+			return nil, err
+		}
+		if user == nil {
+			continue
+		}
+		export.Users = append(export.Users, exportPrincipal(user, includePasswords))
+	}
+	return export, nil
+}
+
+func exportPrincipal(princ auth.Principal, includePassword bool) ExportedPrincipal {
+	exported := ExportedPrincipal{
+		Name:          princ.Name(),
+		AdminChannels: sortedSet(princ.ExplicitChannels().AsSet()),
+	}
+	if user, ok := princ.(auth.User); ok {
+		exported.Email = user.Email()
+		exported.Disabled = user.Disabled()
+		exported.AdminRoles = sortedSet(user.ExplicitRoles().AsSet())
+		if includePassword {
+			exported.PasswordHash = user.PasswordHash()
+		}
+	}
+	return exported
+}
+
+// Imports users and roles exported by ExportPrincipals.  Principals that don't exist are created; existing ones
+// are combined with the imported ones according to the mode.  Principals that aren't in the export are left
+// alone.  A principal that can't be imported doesn't stop the others from being imported.
+func (dbc *DatabaseContext) ImportPrincipals(export *PrincipalExport, mode PrincipalImportMode) (*PrincipalImportResult, error) {
+	if export.Version != PrincipalExportVersion {
+		return nil, base.HTTPErrorf(http.StatusBadRequest, "Unsupported principal export version %d", export.Version)
+	}
+	if mode != PrincipalImportMerge && mode != PrincipalImportOverwrite {
+		return nil, base.HTTPErrorf(http.StatusBadRequest, "Import mode must be %q or %q", PrincipalImportMerge, PrincipalImportOverwrite)
+	}
+
+	result := &PrincipalImportResult{}
+	importPrincipal := func(exported ExportedPrincipal, isUser bool) {
+		kind := "role"
+		if isUser {
+			kind = "user"
+		}
+		err := dbc.importPrincipal(exported, isUser, mode, result)
+		if err != nil {
+			base.Warnf(base.KeyAuth, "Unable to import %s %s: %v", kind, base.UD(exported.Name), err)
+			if result.Failed == nil {
+				result.Failed = map[string]string{}
+			}
+			result.Failed[kind+":"+exported.Name] = err.Error()
+		}
+	}
+
+	// Roles first, so that users' roles exist when the users are imported
+	for _, role := range export.Roles {
+		importPrincipal(role, false)
+	}
+	for _, user := range export.Users {
+		importPrincipal(user, true)
+	}
+	base.Infof(base.KeyAuth, "Imported principals into db %s: %d created, %d updated, %d failed", base.MD(dbc.Name), result.Created, result.Updated, len(result.Failed))
+	return result, nil
+}
+
+func (dbc *DatabaseContext) importPrincipal(exported ExportedPrincipal, isUser bool, mode PrincipalImportMode, result *PrincipalImportResult) error {
+	if !auth.IsValidPrincipalName(exported.Name) {
+		return fmt.Errorf("Invalid name %q", exported.Name)
+	}
+	name := exported.Name
+	info := PrincipalConfig{
+		Name:              &name,
+		ExplicitChannels:  base.SetFromArray(exported.AdminChannels),
+		Email:             exported.Email,
+		Disabled:          exported.Disabled,
+		ExplicitRoleNames: exported.AdminRoles,
+		PasswordHash:      exported.PasswordHash,
+	}
+
+	if mode == PrincipalImportMerge {
+		// Keep an existing principal's settings, adding the imported grants to its own
+		var existing auth.Principal
+		if isUser {
+			user, err := dbc.Authenticator().GetUser(name)
+			if err != nil {
+				return err
+			} else if user != nil {
+				existing = user
+			}
+		} else {
+			role, err := dbc.Authenticator().GetRole(name)
+			if err != nil {
+				return err
+			} else if role != nil {
+				existing = role
+			}
+		}
+		if existing != nil {
+			info.ExplicitChannels = existing.ExplicitChannels().AsSet().Union(info.ExplicitChannels)
+			if user, ok := existing.(auth.User); ok {
+				info.ExplicitRoleNames = user.ExplicitRoles().AsSet().Union(base.SetFromArray(exported.AdminRoles)).ToArray()
+				info.Disabled = user.Disabled()
+				if user.Email() != "" {
+					info.Email = user.Email()
+				}
+				if user.PasswordHash() != nil {
+					info.PasswordHash = nil
+				}
+			}
+		}
+	}
+
+	replaced, err := dbc.UpdatePrincipal(info, isUser, true)
+	if err != nil {
+		return err
+	}
+	if replaced {
+		result.Updated++
+	} else {
+		result.Created++
+	}
+	return nil
+}
+
+// Returns the members of a set in sorted order, or nil if it's empty.
+func sortedSet(set base.Set) []string {
+	if len(set) == 0 {
+		return nil
+	}
+	values := set.ToArray()
+	sort.Strings(values)
+	return values
+}
@@ -0,0 +1,524 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/couchbase/sync_gateway/auth"
+	"github.com/couchbase/sync_gateway/base"
+	"golang.org/x/net/http2"
+)
+
+// Platforms devices can register for push notifications on
+const (
+	PushPlatformAPNs = "apns" // Apple Push Notification service
+	PushPlatformFCM  = "fcm"  // Firebase Cloud Messaging
+)
+
+// Prefix of the keys of the docs holding each user's push registrations
+const kPushRegistrationsKeyPrefix = KSyncKeyPrefix + "push:"
+
+// Key of the doc listing the users with push registrations, so notifiers don't have to look at every user
+const kPushUsersKey = KSyncKeyPrefix + "pushUsers"
+
+// Max number of devices a user can register.  Registering another replaces the one registered longest ago.
+const kMaxPushRegistrationsPerUser = 10
+
+// Defaults of the push notifier options
+const (
+	kDefaultPushInterval = 5 * time.Second
+	kDefaultPushTimeout  = 10 * time.Second
+	kDefaultAPNsURL      = "https://api.push.apple.com"
+	kDefaultFCMURL       = "https://fcm.googleapis.com/fcm/send"
+)
+
+// How long a notifier uses a user's channel access for before reloading it
+const kPushUserCacheExpiry = time.Minute
+
+// PushRegistration is a device registered to be sent push notifications for its user.
+type PushRegistration struct {
+	Platform string    `json:"platform"`
+	Token    string    `json:"token"`
+	Updated  time.Time `json:"updated"`
+}
+
+type pushRegistrationsDoc struct {
+	Registrations []PushRegistration `json:"registrations"`
+}
+
+type pushUsersDoc struct {
+	Users []string `json:"users"` // Sorted
+}
+
+// Returns the name of the database's current user, or a 403 error if it's the guest user or an admin, which have no
+// devices to notify.
+func (db *Database) pushUserName() (string, error) {
+	if db.user == nil || db.user.Name() == "" {
+		return "", base.HTTPErrorf(http.StatusForbidden, "Push notifications can only be registered for by a named user")
+	}
+	return db.user.Name(), nil
+}
+
+// Returns the current user's push registrations.
+func (db *Database) GetPushRegistrations() ([]PushRegistration, error) {
+	name, err := db.pushUserName()
+	if err != nil {
+		return nil, err
+	}
+	return db.getPushRegistrations(name)
+}
+
+// Registers a device of the current user for push notifications.  Registering a token again updates its platform
+// and registration time.
+func (db *Database) RegisterPushToken(platform string, token string) error {
+	name, err := db.pushUserName()
+	if err != nil {
+		return err
+	}
+	if platform != PushPlatformAPNs && platform != PushPlatformFCM {
+		return base.HTTPErrorf(http.StatusBadRequest, "platform must be %q or %q", PushPlatformAPNs, PushPlatformFCM)
+	}
+	if token == "" {
+		return base.HTTPErrorf(http.StatusBadRequest, "Missing token")
+	}
+
+	err = db.updatePushRegistrations(name, func(registrations []PushRegistration) ([]PushRegistration, error) {
+		updated := []PushRegistration{{Platform: platform, Token: token, Updated: time.Now().UTC()}}
+		for _, registration := range registrations {
+			if registration.Token != token && len(updated) < kMaxPushRegistrationsPerUser {
+				updated = append(updated, registration)
+			}
+		}
+		return updated, nil
+	})
+	if err != nil {
+		return err
+	}
+	base.Infof(base.KeyAuth, "Registered %s device of user %s for push notifications", platform, base.UD(name))
+	return db.updatePushUsers(name, true)
+}
+
+// Unregisters a device of the current user.  Returns a 404 error if the token isn't registered.
+func (db *Database) UnregisterPushToken(token string) error {
+	name, err := db.pushUserName()
+	if err != nil {
+		return err
+	}
+	return db.removePushToken(name, token)
+}
+
+// Removes a device from a user's push registrations, and the user from the push users if it was their last.
+func (context *DatabaseContext) removePushToken(name string, token string) error {
+	remaining := 0
+	err := context.updatePushRegistrations(name, func(registrations []PushRegistration) ([]PushRegistration, error) {
+		updated := make([]PushRegistration, 0, len(registrations))
+		for _, registration := range registrations {
+			if registration.Token != token {
+				updated = append(updated, registration)
+			}
+		}
+		if len(updated) == len(registrations) {
+			return nil, base.HTTPErrorf(http.StatusNotFound, "Token is not registered")
+		}
+		remaining = len(updated)
+		return updated, nil
+	})
+	if err != nil || remaining > 0 {
+		return err
+	}
+	return context.updatePushUsers(name, false)
+}
+
+func (context *DatabaseContext) getPushRegistrations(name string) ([]PushRegistration, error) {
+	var doc pushRegistrationsDoc
+	if _, err := context.MetadataBucket.Get(kPushRegistrationsKeyPrefix+name, &doc); err != nil && !base.IsDocNotFoundError(err) {
+		return nil, err
+	}
+	if doc.Registrations == nil {
+		return []PushRegistration{}, nil
+	}
+	return doc.Registrations, nil
+}
+
+// Updates a user's push registrations with the given callback.  The doc is deleted once it has none left.
+func (context *DatabaseContext) updatePushRegistrations(name string, callback func([]PushRegistration) ([]PushRegistration, error)) error {
+	key := kPushRegistrationsKeyPrefix + name
+	_, err := context.MetadataBucket.Update(key, 0, func(currentValue []byte) ([]byte, *uint32, error) {
+		var doc pushRegistrationsDoc
+		if len(currentValue) > 0 {
+			if err := json.Unmarshal(currentValue, &doc); err != nil {
+				return nil, nil, err
+			}
+		}
+		registrations, err := callback(doc.Registrations)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(registrations) == 0 {
+			return nil, nil, nil
+		}
+		updated, err := json.Marshal(pushRegistrationsDoc{Registrations: registrations})
+		return updated, nil, err
+	})
+	return err
+}
+
+// Adds a user to, or removes them from, the list of users with push registrations.
+func (context *DatabaseContext) updatePushUsers(name string, add bool) error {
+	_, err := context.MetadataBucket.Update(kPushUsersKey, 0, func(currentValue []byte) ([]byte, *uint32, error) {
+		var doc pushUsersDoc
+		if len(currentValue) > 0 {
+			if err := json.Unmarshal(currentValue, &doc); err != nil {
+				return nil, nil, err
+			}
+		}
+		i := sort.SearchStrings(doc.Users, name)
+		found := i < len(doc.Users) && doc.Users[i] == name
+		if found == add {
+			return nil, nil, base.ErrUpdateCancel
+		}
+		if add {
+			doc.Users = append(doc.Users, "")
+			copy(doc.Users[i+1:], doc.Users[i:])
+			doc.Users[i] = name
+		} else {
+			doc.Users = append(doc.Users[:i], doc.Users[i+1:]...)
+		}
+		updated, err := json.Marshal(doc)
+		return updated, nil, err
+	})
+	if err == base.ErrUpdateCancel {
+		return nil
+	}
+	return err
+}
+
+func (context *DatabaseContext) getPushUsers() ([]string, error) {
+	var doc pushUsersDoc
+	if _, err := context.MetadataBucket.Get(kPushUsersKey, &doc); err != nil && !base.IsDocNotFoundError(err) {
+		return nil, err
+	}
+	return doc.Users, nil
+}
+
+// Optional push notifier settings
+type PushNotifierOptions struct {
+	Channels     []string      // Changes to documents in these channels wake the devices of the users with access to them
+	Interval     time.Duration // Changes are batched for this long, so a device is woken at most once per interval
+	Timeout      time.Duration // Timeout of each request to APNs or FCM
+	APNsURL      string        // Base URL of the APNs provider API.  Defaults to Apple's production server
+	APNsTopic    string        // The app's bundle ID.  APNs registrations are only notified if it's set
+	APNsCertPath string        // Certificate the notifier authenticates to APNs with
+	APNsKeyPath  string        // Private key of the APNs certificate
+	FCMURL       string        // URL of the FCM HTTP API.  Defaults to Google's server
+	FCMServerKey string        // Server key the notifier authenticates to FCM with.  FCM registrations are only notified if it's set
+	Stats        *expvar.Map   // If set, notification stats are added to this map
+}
+
+// Sends a push notification to a device.  unregistered is true if the push service says the device's token is no
+// longer valid, so its registration can be removed.
+type pushSender interface {
+	send(token string, channels []string) (unregistered bool, err error)
+}
+
+// PushNotifier is an implementation of EventHandler that wakes registered mobile devices with silent push
+// notifications when documents change in channels their users can see, so the apps can start replicating.  Changes
+// are batched, so a burst of writes only wakes each device once.  The notifications only list the changed channels;
+// the devices pull the changes themselves.
+type PushNotifier struct {
+	AsyncEventHandler
+	context  *DatabaseContext
+	channels base.Set
+	interval time.Duration
+	senders  map[string]pushSender // By platform
+	stats    *expvar.Map
+
+	lock    sync.Mutex
+	pending base.Set                   // Channels that have changed since the last batch was sent
+	users   map[string]*cachedPushUser // Users whose channel access has been loaded, by name
+}
+
+type cachedPushUser struct {
+	user   auth.User // nil if the user doesn't exist
+	loaded time.Time
+}
+
+// Creates a push notifier for the database's document changes.
+func NewPushNotifier(context *DatabaseContext, options PushNotifierOptions) (*PushNotifier, error) {
+	if len(options.Channels) == 0 {
+		return nil, errors.New("channels parameter must be defined for push events.")
+	}
+	timeout := options.Timeout
+	if timeout <= 0 {
+		timeout = kDefaultPushTimeout
+	}
+	pn := &PushNotifier{
+		context:  context,
+		channels: base.SetFromArray(options.Channels),
+		interval: options.Interval,
+		senders:  map[string]pushSender{},
+		stats:    options.Stats,
+		users:    map[string]*cachedPushUser{},
+	}
+	if pn.interval <= 0 {
+		pn.interval = kDefaultPushInterval
+	}
+
+	if options.APNsTopic != "" {
+		if options.APNsCertPath == "" || options.APNsKeyPath == "" {
+			return nil, errors.New("apns_cert_path and apns_key_path must be defined for push events sent to APNs.")
+		}
+		tlsConfig, err := base.TLSConfigForX509(options.APNsCertPath, options.APNsKeyPath, "")
+		if err != nil {
+			return nil, fmt.Errorf("Unable to load APNs certificate: %v", err)
+		}
+		tlsConfig.InsecureSkipVerify = false
+		transport := &http.Transport{TLSClientConfig: tlsConfig}
+		if err := http2.ConfigureTransport(transport); err != nil {
+			return nil, err
+		}
+		apnsURL := options.APNsURL
+		if apnsURL == "" {
+			apnsURL = kDefaultAPNsURL
+		}
+		pn.senders[PushPlatformAPNs] = &apnsSender{
+			url:    apnsURL,
+			topic:  options.APNsTopic,
+			client: &http.Client{Transport: transport, Timeout: timeout},
+		}
+	}
+	if options.FCMServerKey != "" {
+		fcmURL := options.FCMURL
+		if fcmURL == "" {
+			fcmURL = kDefaultFCMURL
+		}
+		pn.senders[PushPlatformFCM] = &fcmSender{
+			url:       fcmURL,
+			serverKey: options.FCMServerKey,
+			client:    &http.Client{Timeout: timeout},
+		}
+	}
+	if len(pn.senders) == 0 {
+		return nil, errors.New("apns_topic or fcm_server_key must be defined for push events.")
+	}
+	return pn, nil
+}
+
+// Adds the changed document's channels that the notifier is for to the next batch, scheduling it to be sent if it's
+// the first change since the last batch.
+func (pn *PushNotifier) HandleEvent(event Event) {
+	change, ok := event.(*DocumentChangeEvent)
+	if !ok {
+		base.Warnf(base.KeyAll, "Push notifier invoked for unsupported event type.")
+		return
+	}
+	pn.lock.Lock()
+	defer pn.lock.Unlock()
+	for channel := range change.Channels {
+		if !pn.channels.Contains(channel) {
+			continue
+		}
+		if pn.pending == nil {
+			pn.pending = base.Set{}
+			time.AfterFunc(pn.interval, pn.notify)
+		}
+		pn.pending[channel] = struct{}{}
+	}
+}
+
+// Sends the current batch: each registered device whose user can see any of the changed channels is sent one
+// notification listing them.
+func (pn *PushNotifier) notify() {
+	pn.lock.Lock()
+	changed := pn.pending
+	pn.pending = nil
+	pn.lock.Unlock()
+	if len(changed) == 0 {
+		return
+	}
+
+	names, err := pn.context.getPushUsers()
+	if err != nil {
+		base.Warnf(base.KeyAll, "Unable to load push notification users of db %s: %v", base.MD(pn.context.Name), err)
+		return
+	}
+	for _, name := range names {
+		user := pn.getUser(name)
+		if user == nil {
+			continue
+		}
+		channels := make([]string, 0, len(changed))
+		for channel := range changed {
+			if user.CanSeeChannel(channel) {
+				channels = append(channels, channel)
+			}
+		}
+		if len(channels) == 0 {
+			continue
+		}
+		sort.Strings(channels)
+		registrations, err := pn.context.getPushRegistrations(name)
+		if err != nil {
+			base.Warnf(base.KeyAll, "Unable to load push registrations of user %s: %v", base.UD(name), err)
+			continue
+		}
+		for _, registration := range registrations {
+			pn.send(name, registration, channels)
+		}
+	}
+}
+
+// Returns the named user, reloading them if their channel access was loaded too long ago.  Returns nil if the user
+// doesn't exist or can't be loaded.
+func (pn *PushNotifier) getUser(name string) auth.User {
+	pn.lock.Lock()
+	cached := pn.users[name]
+	pn.lock.Unlock()
+	if cached != nil && time.Since(cached.loaded) < kPushUserCacheExpiry {
+		return cached.user
+	}
+	user, err := pn.context.Authenticator().GetUser(name)
+	if err != nil {
+		base.Warnf(base.KeyAll, "Unable to load user %s for push notifications: %v", base.UD(name), err)
+		return nil
+	}
+	cached = &cachedPushUser{user: user, loaded: time.Now()}
+	pn.lock.Lock()
+	pn.users[name] = cached
+	pn.lock.Unlock()
+	return cached.user
+}
+
+// Sends a notification to a registered device.  Devices whose tokens the push service rejects are unregistered.
+func (pn *PushNotifier) send(name string, registration PushRegistration, channels []string) {
+	sender, ok := pn.senders[registration.Platform]
+	if !ok {
+		return // The notifier isn't configured for the device's platform
+	}
+	unregistered, err := sender.send(registration.Token, channels)
+	if unregistered {
+		base.Infof(base.KeyEvents, "Removing %s device of user %s rejected by push service: %v", registration.Platform, base.UD(name), err)
+		pn.addStat(base.StatKeyPushUnregisteredCount)
+		if err := pn.context.removePushToken(name, registration.Token); err != nil && !base.IsDocNotFoundError(err) {
+			base.Warnf(base.KeyAll, "Unable to remove push registration of user %s: %v", base.UD(name), err)
+		}
+		return
+	} else if err != nil {
+		base.Warnf(base.KeyAll, "Error sending push notification to %s device of user %s: %v", registration.Platform, base.UD(name), err)
+		pn.addStat(base.StatKeyPushFailedCount)
+		return
+	}
+	pn.addStat(base.StatKeyPushSentCount)
+	base.Debugf(base.KeyEvents, "Sent push notification for channels %v to %s device of user %s", base.UD(channels), registration.Platform, base.UD(name))
+}
+
+func (pn *PushNotifier) addStat(key string) {
+	if pn.stats != nil {
+		pn.stats.Add(key, 1)
+	}
+}
+
+func (pn *PushNotifier) String() string {
+	platforms := make([]string, 0, len(pn.senders))
+	for platform := range pn.senders {
+		platforms = append(platforms, platform)
+	}
+	sort.Strings(platforms)
+	return fmt.Sprintf("Push notifier %v", platforms)
+}
+
+// Sends background notifications with the APNs provider API.
+type apnsSender struct {
+	url    string
+	topic  string
+	client *http.Client
+}
+
+func (s *apnsSender) send(token string, channels []string) (bool, error) {
+	payload, err := json.Marshal(map[string]interface{}{
+		"aps":      map[string]interface{}{"content-available": 1},
+		"channels": channels,
+	})
+	if err != nil {
+		return false, err
+	}
+	rq, err := http.NewRequest("POST", s.url+"/3/device/"+url.PathEscape(token), bytes.NewReader(payload))
+	if err != nil {
+		return false, err
+	}
+	rq.Header.Set("apns-topic", s.topic)
+	rq.Header.Set("apns-push-type", "background")
+	rq.Header.Set("apns-priority", "5") // Required for background notifications
+	resp, err := s.client.Do(rq)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return false, nil
+	}
+	var result struct {
+		Reason string `json:"reason"`
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	_ = json.Unmarshal(body, &result)
+	err = fmt.Errorf("APNs returned %d %s", resp.StatusCode, result.Reason)
+	unregistered := resp.StatusCode == http.StatusGone || result.Reason == "BadDeviceToken"
+	return unregistered, err
+}
+
+// Sends data messages with the FCM legacy HTTP API.
+type fcmSender struct {
+	url       string
+	serverKey string
+	client    *http.Client
+}
+
+func (s *fcmSender) send(token string, channels []string) (bool, error) {
+	payload, err := json.Marshal(map[string]interface{}{
+		"to":                token,
+		"content_available": true, // Wakes iOS apps registered through FCM
+		"priority":          "high",
+		"data":              map[string]interface{}{"channels": channels},
+	})
+	if err != nil {
+		return false, err
+	}
+	rq, err := http.NewRequest("POST", s.url, bytes.NewReader(payload))
+	if err != nil {
+		return false, err
+	}
+	rq.Header.Set("Content-Type", "application/json")
+	rq.Header.Set("Authorization", "key="+s.serverKey)
+	resp, err := s.client.Do(rq)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("FCM returned %d", resp.StatusCode)
+	}
+	var result struct {
+		Results []struct {
+			Error string `json:"error"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, err
+	}
+	if len(result.Results) == 0 || result.Results[0].Error == "" {
+		return false, nil
+	}
+	reason := result.Results[0].Error
+	err = fmt.Errorf("FCM returned %s", reason)
+	return reason == "NotRegistered" || reason == "InvalidRegistration", err
+}
@@ -0,0 +1,124 @@
+package db
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbase/sync_gateway/channels"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPushRegistrations(t *testing.T) {
+	db, testBucket := setupTestDB(t)
+	defer testBucket.Close()
+	defer tearDownTestDB(t, db)
+
+	// The guest user can't register
+	assertHTTPError(t, db.RegisterPushToken(PushPlatformFCM, "token1"), http.StatusForbidden)
+
+	authenticator := db.Authenticator()
+	user, err := authenticator.NewUser("naomi", "letmein", channels.SetOf("ABC"))
+	assert.NoError(t, err)
+	assert.NoError(t, authenticator.Save(user))
+	userDB, err := GetDatabase(db.DatabaseContext, user)
+	assert.NoError(t, err)
+
+	assertHTTPError(t, userDB.RegisterPushToken("sms", "token1"), http.StatusBadRequest)
+	assert.NoError(t, userDB.RegisterPushToken(PushPlatformFCM, "token1"))
+	assert.NoError(t, userDB.RegisterPushToken(PushPlatformAPNs, "token2"))
+	assert.NoError(t, userDB.RegisterPushToken(PushPlatformFCM, "token1"))
+	registrations, err := userDB.GetPushRegistrations()
+	assert.NoError(t, err)
+	assert.Len(t, registrations, 2)
+	assert.Equal(t, "token1", registrations[0].Token)
+	users, err := db.getPushUsers()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"naomi"}, users)
+
+	// Once the last device is unregistered, the user's no longer listed
+	assert.NoError(t, userDB.UnregisterPushToken("token1"))
+	assertHTTPError(t, userDB.UnregisterPushToken("token1"), http.StatusNotFound)
+	assert.NoError(t, userDB.UnregisterPushToken("token2"))
+	registrations, err = userDB.GetPushRegistrations()
+	assert.NoError(t, err)
+	assert.Len(t, registrations, 0)
+	users, err = db.getPushUsers()
+	assert.NoError(t, err)
+	assert.Len(t, users, 0)
+}
+
+func TestPushNotifier(t *testing.T) {
+	db, testBucket := setupTestDB(t)
+	defer testBucket.Close()
+	defer tearDownTestDB(t, db)
+
+	var lock sync.Mutex
+	notified := map[string][]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var message struct {
+			To   string `json:"to"`
+			Data struct {
+				Channels []string `json:"channels"`
+			} `json:"data"`
+		}
+		assert.Equal(t, "key=serverkey", r.Header.Get("Authorization"))
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&message))
+		result := map[string]interface{}{}
+		if message.To == "stale" {
+			result["error"] = "NotRegistered"
+		} else {
+			lock.Lock()
+			notified[message.To] = message.Data.Channels
+			lock.Unlock()
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"results": []interface{}{result}})
+	}))
+	defer server.Close()
+
+	authenticator := db.Authenticator()
+	for name, tokens := range map[string][]string{"alice": {"alice1"}, "bob": {"bob1", "stale"}} {
+		user, err := authenticator.NewUser(name, "letmein", channels.SetOf(name))
+		assert.NoError(t, err)
+		assert.NoError(t, authenticator.Save(user))
+		userDB, err := GetDatabase(db.DatabaseContext, user)
+		assert.NoError(t, err)
+		for _, token := range tokens {
+			assert.NoError(t, userDB.RegisterPushToken(PushPlatformFCM, token))
+		}
+	}
+
+	stats := initEmptyStatsMap(base.StatsGroupKeyDatabase)
+	_, err := NewPushNotifier(db.DatabaseContext, PushNotifierOptions{Channels: []string{"alice"}})
+	assert.Error(t, err, "Push notifiers need a push service")
+	pn, err := NewPushNotifier(db.DatabaseContext, PushNotifierOptions{
+		Channels:     []string{"alice", "bob"},
+		Interval:     time.Hour, // Sent by the test instead
+		FCMURL:       server.URL,
+		FCMServerKey: "serverkey",
+		Stats:        stats,
+	})
+	assert.NoError(t, err)
+
+	// Only the users who can see the changed channels are notified, once per batch
+	pn.HandleEvent(&DocumentChangeEvent{Doc: Body{BodyId: "doc1"}, Channels: base.SetOf("alice", "other")})
+	pn.HandleEvent(&DocumentChangeEvent{Doc: Body{BodyId: "doc2"}, Channels: base.SetOf("alice")})
+	pn.notify()
+	assert.Equal(t, map[string][]string{"alice1": {"alice"}}, notified)
+	assert.Equal(t, int64(1), stats.Get(base.StatKeyPushSentCount).(*expvar.Int).Value())
+
+	// Devices the push service doesn't know any more are unregistered
+	pn.HandleEvent(&DocumentChangeEvent{Doc: Body{BodyId: "doc3"}, Channels: base.SetOf("bob")})
+	pn.notify()
+	assert.Equal(t, []string{"bob"}, notified["bob1"])
+	assert.Equal(t, int64(1), stats.Get(base.StatKeyPushUnregisteredCount).(*expvar.Int).Value())
+	registrations, err := db.getPushRegistrations("bob")
+	assert.NoError(t, err)
+	assert.Len(t, registrations, 1)
+	assert.Equal(t, "bob1", registrations[0].Token)
+}
@@ -29,6 +29,8 @@ const (
 	QueryTypeTombstones   = "tombstones"
 	QueryTypeResync       = "resync"
 	QueryTypeAllDocs      = "allDocs"
+	QueryTypeConflicts    = "conflicts"
+	QueryTypeSyncDocs     = "syncDocs"
 )
 
 type SGQuery struct {
@@ -40,26 +42,27 @@ type SGQuery struct {
 var QueryAccess = SGQuery{
 	name: QueryTypeAccess,
 	statement: fmt.Sprintf(
-		"SELECT $sync.access.`$$selectUserName` as `value` "+
+		"SELECT $sync.access.`$$selectUserName` as `value`, META(`%s`).id AS id "+
 			"FROM `%s` "+
 			"WHERE any op in object_pairs($sync.access) satisfies op.name = $userName end;",
-		base.BucketQueryToken),
+		base.BucketQueryToken, base.BucketQueryToken),
 	adhoc: true,
 }
 
 var QueryRoleAccess = SGQuery{
 	name: QueryTypeRoleAccess,
 	statement: fmt.Sprintf(
-		"SELECT $sync.role_access.`$$selectUserName` as `value` "+
+		"SELECT $sync.role_access.`$$selectUserName` as `value`, META(`%s`).id AS id "+
 			"FROM `%s` "+
 			"WHERE any op in object_pairs($sync.role_access) satisfies op.name = $userName end;",
-		base.BucketQueryToken),
+		base.BucketQueryToken, base.BucketQueryToken),
 	adhoc: true,
 }
 
 // QueryAccessRow used for response from both QueryAccess and QueryRoleAccess
 type QueryAccessRow struct {
 	Value channels.TimedSet
+	Id    string // ID of the document that made the grants
 }
 
 var QueryChannels = SGQuery{
@@ -138,6 +141,17 @@ var QuerySessions = SGQuery{
 		base.BucketQueryToken, base.BucketQueryToken, base.BucketQueryToken, SyncDocWildcard, base.BucketQueryToken, `\\_sync:session:%`),
 	adhoc: false,
 }
+// QuerySyncDocs uses IndexSyncDocs to retrieve the ids of all of Sync Gateway's internal docs.
+var QuerySyncDocs = SGQuery{
+	name: QueryTypeSyncDocs,
+	statement: fmt.Sprintf(
+		"SELECT META(`%s`).id "+
+			"FROM `%s` "+
+			"WHERE META(`%s`).id LIKE '%s'",
+		base.BucketQueryToken, base.BucketQueryToken, base.BucketQueryToken, SyncDocWildcard),
+	adhoc: false,
+}
+
 var QueryTombstones = SGQuery{
 	name: QueryTypeTombstones,
 	statement: fmt.Sprintf(
@@ -185,6 +199,23 @@ var QueryAllDocs = SGQuery{
 	adhoc: false,
 }
 
+// QueryConflicts uses IndexAllDocs, filtering on the conflict flag (channels.Conflict) in the sync metadata.
+// Note: QueryConflicts function may append additional filter, ordering and limit of the form:
+//    AND META(`bucket`).id >= '%s'
+//    ORDER BY META(`bucket`).id
+//    LIMIT %d
+var QueryConflicts = SGQuery{
+	name: QueryTypeConflicts,
+	statement: fmt.Sprintf(
+		"SELECT META(`%s`).id "+
+			"FROM `%s` "+
+			"WHERE $sync.sequence > 0 AND "+ // Required to use IndexAllDocs
+			"META(`%s`).id NOT LIKE '%s' "+
+			"AND BITTEST($sync.flags,4) = true",
+		base.BucketQueryToken, base.BucketQueryToken, base.BucketQueryToken, SyncDocWildcard),
+	adhoc: false,
+}
+
 // Query Parameters used as parameters in prepared statements.  Note that these are hardcoded into the query definitions above,
 // for improved query readability.
 const (
@@ -201,19 +232,24 @@ const (
 	QuerySelectUserName = "$$selectUserName"
 )
 
-// N1QlQueryWithStats is a wrapper for gocbBucket.Query that performs additional diagnostic processing (expvars, slow query logging)
+// N1QlQueryWithStats is a wrapper for N1QLBucket.Query that performs additional diagnostic processing (expvars, slow query logging)
 func (context *DatabaseContext) N1QLQueryWithStats(queryName string, statement string, params interface{}, consistency gocb.ConsistencyMode, adhoc bool) (results gocb.QueryResults, err error) {
+	return context.n1qlQueryWithStats(context.Bucket, queryName, statement, params, consistency, adhoc)
+}
+
+// Runs a N1QL query with stats against the given bucket - either the data bucket or the metadata bucket.
+func (context *DatabaseContext) n1qlQueryWithStats(bucket base.Bucket, queryName string, statement string, params interface{}, consistency gocb.ConsistencyMode, adhoc bool) (results gocb.QueryResults, err error) {
 
 	if base.SlowQueryWarningThreshold > 0 {
 		defer base.SlowQueryLog(time.Now(), "N1QL Query(%q)", queryName)
 	}
 
-	gocbBucket, ok := base.AsGoCBBucket(context.Bucket)
+	n1qlBucket, ok := base.AsN1QLBucket(bucket)
 	if !ok {
 		return nil, errors.New("Cannot perform N1QL query on non-Couchbase bucket.")
 	}
 
-	results, err = gocbBucket.Query(statement, params, consistency, adhoc)
+	results, err = n1qlBucket.Query(statement, params, consistency, adhoc)
 	if err != nil {
 		context.DbStats.StatsGsiViews().Add(fmt.Sprintf(base.StatKeyN1qlQueryErrorCountExpvarFormat, queryName), 1)
 	}
@@ -225,12 +261,17 @@ func (context *DatabaseContext) N1QLQueryWithStats(queryName string, statement s
 
 // N1QlQueryWithStats is a wrapper for gocbBucket.Query that performs additional diagnostic processing (expvars, slow query logging)
 func (context *DatabaseContext) ViewQueryWithStats(ddoc string, viewName string, params map[string]interface{}) (results sgbucket.QueryResultIterator, err error) {
+	return context.viewQueryWithStats(context.Bucket, ddoc, viewName, params)
+}
+
+// Runs a view query with stats against the given bucket - either the data bucket or the metadata bucket.
+func (context *DatabaseContext) viewQueryWithStats(bucket base.Bucket, ddoc string, viewName string, params map[string]interface{}) (results sgbucket.QueryResultIterator, err error) {
 
 	if base.SlowQueryWarningThreshold > 0 {
 		defer base.SlowQueryLog(time.Now(), "View Query (%s.%s)", ddoc, viewName)
 	}
 
-	results, err = context.Bucket.ViewQuery(ddoc, viewName, params)
+	results, err = bucket.ViewQuery(ddoc, viewName, params)
 	if err != nil {
 		context.DbStats.StatsGsiViews().Add(fmt.Sprintf(base.StatKeyViewQueryErrorCountExpvarFormat, ddoc, viewName), 1)
 	}
@@ -392,11 +433,11 @@ func (context *DatabaseContext) QueryPrincipals() (sgbucket.QueryResultIterator,
 	// View Query
 	if context.Options.UseViews {
 		opts := map[string]interface{}{"stale": false}
-		return context.ViewQueryWithStats(DesignDocSyncGateway(), ViewPrincipals, opts)
+		return context.viewQueryWithStats(context.MetadataBucket, DesignDocSyncGateway(), ViewPrincipals, opts)
 	}
 
 	// N1QL Query
-	return context.N1QLQueryWithStats(QueryTypePrincipals, QueryPrincipals.statement, nil, gocb.RequestPlus, QueryPrincipals.adhoc)
+	return context.n1qlQueryWithStats(context.MetadataBucket, QueryTypePrincipals, QueryPrincipals.statement, nil, gocb.RequestPlus, QueryPrincipals.adhoc)
 }
 
 // Query to retrieve the set of user and role doc ids, using the primary index
@@ -407,13 +448,26 @@ func (context *DatabaseContext) QuerySessions(userName string) (sgbucket.QueryRe
 		opts := Body{"stale": false}
 		opts[QueryParamStartKey] = userName
 		opts[QueryParamEndKey] = userName
-		return context.ViewQueryWithStats(DesignDocSyncHousekeeping(), ViewSessions, opts)
+		return context.viewQueryWithStats(context.MetadataBucket, DesignDocSyncHousekeeping(), ViewSessions, opts)
 	}
 
 	// N1QL Query
 	params := make(map[string]interface{}, 1)
 	params[QueryParamUserName] = userName
-	return context.N1QLQueryWithStats(QueryTypeSessions, QuerySessions.statement, params, gocb.RequestPlus, QuerySessions.adhoc)
+	return context.n1qlQueryWithStats(context.MetadataBucket, QueryTypeSessions, QuerySessions.statement, params, gocb.RequestPlus, QuerySessions.adhoc)
+}
+
+// Query to retrieve the ids of all of Sync Gateway's internal docs in the metadata bucket
+func (context *DatabaseContext) QuerySyncDocs() (sgbucket.QueryResultIterator, error) {
+
+	// View Query
+	if context.Options.UseViews {
+		opts := Body{"stale": false}
+		return context.viewQueryWithStats(context.MetadataBucket, DesignDocSyncHousekeeping(), ViewSyncDocs, opts)
+	}
+
+	// N1QL Query
+	return context.n1qlQueryWithStats(context.MetadataBucket, QueryTypeSyncDocs, QuerySyncDocs.statement, nil, gocb.RequestPlus, QuerySyncDocs.adhoc)
 }
 
 type AllDocsViewQueryRow struct {
@@ -487,6 +541,41 @@ func (context *DatabaseContext) QueryTombstones(olderThan time.Time) (sgbucket.Q
 	return context.N1QLQueryWithStats(QueryTypeTombstones, tombstoneQueryStatement, params, gocb.NotBounded, QueryTombstones.adhoc)
 }
 
+// Query to retrieve the ids of documents that are currently in conflict, ordered by doc id and starting at startKey.
+func (context *DatabaseContext) QueryConflicts(startKey string, limit int) (sgbucket.QueryResultIterator, error) {
+
+	// View Query
+	if context.Options.UseViews {
+		opts := Body{"stale": false}
+		if startKey != "" {
+			opts[QueryParamStartKey] = startKey
+		}
+		if limit > 0 {
+			opts["limit"] = limit
+		}
+		return context.ViewQueryWithStats(DesignDocSyncHousekeeping(), ViewConflicts, opts)
+	}
+
+	bucketName := context.Bucket.GetName()
+
+	// N1QL Query
+	conflictsQueryStatement := replaceSyncTokensQuery(QueryConflicts.statement, context.UseXattrs())
+	params := make(map[string]interface{}, 0)
+	if startKey != "" {
+		conflictsQueryStatement = fmt.Sprintf("%s AND META(`%s`).id >= $startkey",
+			conflictsQueryStatement, bucketName)
+		params[QueryParamStartKey] = startKey
+	}
+
+	conflictsQueryStatement = fmt.Sprintf("%s ORDER BY META(`%s`).id",
+		conflictsQueryStatement, bucketName)
+	if limit > 0 {
+		conflictsQueryStatement = fmt.Sprintf("%s LIMIT %d", conflictsQueryStatement, limit)
+	}
+
+	return context.N1QLQueryWithStats(QueryTypeConflicts, conflictsQueryStatement, params, gocb.RequestPlus, QueryConflicts.adhoc)
+}
+
 func changesViewOptions(channelName string, startSeq, endSeq uint64, limit int) map[string]interface{} {
 	endKey := []interface{}{channelName, endSeq}
 	if endSeq == 0 {
@@ -0,0 +1,112 @@
+package db
+
+import (
+	"container/list"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/couchbase/sync_gateway/auth"
+	"github.com/couchbase/sync_gateway/base"
+)
+
+// Default max number of results a database's QueryResultCache holds
+const DefaultQueryResultCacheMaxEntries = 1000
+
+type QueryResultCacheOptions struct {
+	TTL        time.Duration // How long results are reused for, as long as no document changes.  Zero disables the cache
+	MaxEntries int           // Max number of results cached
+}
+
+// The cache payload data.  Stored as the Value of a list Element.
+type queryResultCacheValue struct {
+	key      string
+	result   []byte
+	sequence uint64    // The database's last sequence when the result was generated
+	expires  time.Time // When the result stops being reused
+}
+
+// An LRU cache of the results of _all_docs and view queries, for clients that repeat the same queries many times a
+// second.  A result is only reused for a short time, and only while the database's last sequence is the one it was
+// generated at, so any document change invalidates every cached result.  Results are cached separately for each set
+// of channels they're filtered by; see QueryResultCacheKey.
+type QueryResultCache struct {
+	cache      map[string]*list.Element // Fast lookup of list element by key
+	lruList    *list.List               // List ordered by most recent access (Front is newest)
+	ttl        time.Duration
+	maxEntries int
+	lock       sync.Mutex // For thread-safety
+}
+
+func NewQueryResultCache(options QueryResultCacheOptions) *QueryResultCache {
+	return &QueryResultCache{
+		cache:      map[string]*list.Element{},
+		lruList:    list.New(),
+		ttl:        options.TTL,
+		maxEntries: options.MaxEntries,
+	}
+}
+
+// Returns the cache key of a query made by the given user (nil for an admin), which identifies the query by the
+// given string.  Users who can see the same channels share cached results, so results that a read transform changes
+// for the user mustn't be cached.
+func QueryResultCacheKey(user auth.User, query string) string {
+	if user == nil {
+		return "admin\x00" + query
+	}
+	channels := user.InheritedChannels().AllChannels()
+	sort.Strings(channels)
+	return strings.Join(channels, ",") + "\x00" + query
+}
+
+// Returns the cached result with the given key, or nil if it isn't cached, has expired, or was generated before the
+// given last sequence.
+func (qc *QueryResultCache) Get(key string, lastSequence uint64) []byte {
+	qc.lock.Lock()
+	defer qc.lock.Unlock()
+	elem, ok := qc.cache[key]
+	if !ok {
+		return nil
+	}
+	value := elem.Value.(*queryResultCacheValue)
+	if value.sequence != lastSequence || time.Now().After(value.expires) {
+		qc.removeElement(elem)
+		return nil
+	}
+	qc.lruList.MoveToFront(elem)
+	base.Debugf(base.KeyCache, "Query result cache hit for %q", base.UD(key))
+	return value.result
+}
+
+// Adds a result, generated at the given last sequence, to the cache, evicting the least recently used results as
+// needed to stay within the max number of entries.
+func (qc *QueryResultCache) Put(key string, lastSequence uint64, result []byte) {
+	value := &queryResultCacheValue{key: key, result: result, sequence: lastSequence, expires: time.Now().Add(qc.ttl)}
+
+	qc.lock.Lock()
+	defer qc.lock.Unlock()
+	if elem, ok := qc.cache[key]; ok {
+		elem.Value = value
+		qc.lruList.MoveToFront(elem)
+		return
+	}
+	qc.cache[key] = qc.lruList.PushFront(value)
+	for qc.lruList.Len() > qc.maxEntries {
+		qc.removeElement(qc.lruList.Back())
+	}
+}
+
+// Removes an element from the cache.  Requires the lock.
+func (qc *QueryResultCache) removeElement(elem *list.Element) {
+	value := qc.lruList.Remove(elem).(*queryResultCacheValue)
+	delete(qc.cache, value.key)
+}
+
+// Removes all results from the cache.  Needed when design docs change, as that doesn't advance the sequence.
+func (qc *QueryResultCache) Clear() {
+	qc.lock.Lock()
+	defer qc.lock.Unlock()
+	qc.cache = map[string]*list.Element{}
+	qc.lruList.Init()
+}
@@ -0,0 +1,59 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/couchbase/sync_gateway/channels"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryResultCache(t *testing.T) {
+	cache := NewQueryResultCache(QueryResultCacheOptions{TTL: time.Hour, MaxEntries: 2})
+	result := []byte(`{"rows":[]}`)
+
+	cache.Put("a", 10, result)
+	cache.Put("b", 10, result)
+	assert.Equal(t, result, cache.Get("a", 10))
+	assert.Equal(t, result, cache.Get("b", 10))
+
+	// Exceeding the max entries evicts the least recently used result
+	cache.Get("a", 10)
+	cache.Put("c", 10, result)
+	assert.Nil(t, cache.Get("b", 10))
+	assert.Equal(t, result, cache.Get("a", 10))
+	assert.Equal(t, result, cache.Get("c", 10))
+
+	// A change to the database invalidates results
+	assert.Nil(t, cache.Get("a", 11))
+	assert.Nil(t, cache.Get("a", 10))
+
+	cache.Clear()
+	assert.Nil(t, cache.Get("c", 10))
+
+	// Results expire after the TTL
+	cache = NewQueryResultCache(QueryResultCacheOptions{TTL: time.Millisecond, MaxEntries: 2})
+	cache.Put("a", 10, result)
+	time.Sleep(5 * time.Millisecond)
+	assert.Nil(t, cache.Get("a", 10))
+}
+
+func TestQueryResultCacheKey(t *testing.T) {
+	db, testBucket := setupTestDB(t)
+	defer testBucket.Close()
+	defer tearDownTestDB(t, db)
+
+	authenticator := db.Authenticator()
+	alice, err := authenticator.NewUser("alice", "letmein", channels.SetOf("red", "blue"))
+	assert.NoError(t, err)
+	bob, err := authenticator.NewUser("bob", "letmein", channels.SetOf("blue", "red"))
+	assert.NoError(t, err)
+	carol, err := authenticator.NewUser("carol", "letmein", channels.SetOf("red"))
+	assert.NoError(t, err)
+
+	// Users with the same channels share results
+	assert.Equal(t, QueryResultCacheKey(alice, "GET /db/_all_docs"), QueryResultCacheKey(bob, "GET /db/_all_docs"))
+	assert.NotEqual(t, QueryResultCacheKey(alice, "GET /db/_all_docs"), QueryResultCacheKey(carol, "GET /db/_all_docs"))
+	assert.NotEqual(t, QueryResultCacheKey(alice, "GET /db/_all_docs"), QueryResultCacheKey(alice, "GET /db/_all_docs?limit=1"))
+	assert.NotEqual(t, QueryResultCacheKey(nil, "GET /db/_all_docs"), QueryResultCacheKey(alice, "GET /db/_all_docs"))
+}
@@ -0,0 +1,274 @@
+package db
+
+import (
+	"expvar"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/sync_gateway/base"
+)
+
+// Keys of the counters that track a database's usage against its quotas.  They're stored in the metadata bucket so
+// that every node sees the same usage.  Counters can only be incremented, so usage is tracked as the difference
+// between an amount added and an amount removed.  Usage is reserved by incrementing the added counter before a write
+// and checking the result, so that concurrent writers can't both fit into the last of a quota.
+const (
+	kQuotaDocsCreatedKey             = KSyncKeyPrefix + "quota:docs_created"
+	kQuotaDocsDeletedKey             = KSyncKeyPrefix + "quota:docs_deleted"
+	kQuotaAttachmentBytesKey         = KSyncKeyPrefix + "quota:attachment_bytes"
+	kQuotaAttachmentBytesReleasedKey = KSyncKeyPrefix + "quota:attachment_bytes_released"
+)
+
+// Per-database resource quotas, so that one tenant of a shared deployment can't exhaust the node's resources.
+// Zero-valued fields are unlimited.  MaxConcurrentReplications and MaxOpsPerSec are enforced by each node separately.
+type QuotaOptions struct {
+	MaxDocs                   uint64 // Max # of live (non-deleted) documents
+	MaxAttachmentBytes        uint64 // Max total size of the attachments stored
+	MaxConcurrentReplications int    // Max # of concurrent BLIP sync connections and continuous/longpoll _changes feeds
+	MaxOpsPerSec              int    // Max # of non-admin REST requests and BLIP messages per second
+}
+
+// Enabled returns true if any quota is set.
+func (o QuotaOptions) Enabled() bool {
+	return o.MaxDocs > 0 || o.MaxAttachmentBytes > 0 || o.MaxConcurrentReplications > 0 || o.MaxOpsPerSec > 0
+}
+
+// quotaTracker enforces a database's QuotaOptions.  All methods are safe to call on a nil *quotaTracker, which
+// enforces nothing.
+type quotaTracker struct {
+	options      QuotaOptions
+	context      *DatabaseContext
+	replications int32            // # of active replications on this node.  Accessed atomically
+	docsLock     sync.Mutex       // Protects docsSeeded
+	docsSeeded   bool             // Whether the docs counters have been checked for, and seeded if missing
+	lock         sync.Mutex       // Protects the fields below
+	opTokens     float64          // Ops allowed before MaxOpsPerSec is exceeded, refilled at MaxOpsPerSec
+	lastOpTime   time.Time        // When opTokens was last refilled
+	now          func() time.Time // Returns the current time.  Overridden in tests
+}
+
+func newQuotaTracker(context *DatabaseContext, options QuotaOptions) *quotaTracker {
+	return &quotaTracker{
+		options:  options,
+		context:  context,
+		opTokens: float64(options.MaxOpsPerSec),
+		now:      time.Now,
+	}
+}
+
+func (q *quotaTracker) stats() *expvar.Map {
+	return q.context.DbStats.StatsDatabase()
+}
+
+// Returns the value of a quota counter, or zero if it doesn't exist.
+func (q *quotaTracker) counter(key string) (uint64, error) {
+	return q.context.MetadataBucket.Incr(key, 0, 0, 0)
+}
+
+// Atomically adds amount to the usage tracked by the addedKey and removedKey counters, and returns the new usage.
+// The removed counter is read after the added counter is incremented.  As neither counter decreases, a concurrent
+// removal can only make the usage returned too high, never too low.
+func (q *quotaTracker) addUsage(addedKey, removedKey string, amount uint64) (uint64, error) {
+	added, err := q.context.MetadataBucket.Incr(addedKey, amount, amount, 0)
+	if err != nil {
+		return 0, err
+	}
+	removed, err := q.counter(removedKey)
+	if err != nil {
+		q.removeUsage(removedKey, amount)
+		return 0, err
+	}
+	if removed > added {
+		return 0, nil
+	}
+	return added - removed, nil
+}
+
+// Subtracts amount from the usage tracked by removedKey's counter.
+func (q *quotaTracker) removeUsage(removedKey string, amount uint64) {
+	if _, err := q.context.MetadataBucket.Incr(removedKey, amount, amount, 0); err != nil {
+		base.Warnf(base.KeyAll, "Unable to update quota counter %s for database %s: %v", removedKey, base.MD(q.context.Name), err)
+	}
+}
+
+// The first time it's called, seeds the docs counters from the all docs index if they don't exist yet, so that
+// documents created before the quota was set are counted.
+func (q *quotaTracker) seedDocsOnce() error {
+	q.docsLock.Lock()
+	defer q.docsLock.Unlock()
+	if !q.docsSeeded {
+		if _, _, err := q.context.MetadataBucket.GetRaw(kQuotaDocsCreatedKey); base.IsKeyNotFoundError(q.context.MetadataBucket, err) {
+			if err := q.seedDocCount(); err != nil {
+				return err
+			}
+		}
+		q.docsSeeded = true
+	}
+	return nil
+}
+
+// Counts the live documents in the database, and initializes the docs counters to match.  Requires the docsLock.
+func (q *quotaTracker) seedDocCount() error {
+	results, err := q.context.QueryAllDocs("", "")
+	if err != nil {
+		return err
+	}
+	count := uint64(0)
+	var row interface{}
+	for results.Next(&row) {
+		count++
+	}
+	if err := results.Close(); err != nil {
+		return err
+	}
+	base.Infof(base.KeyAll, "Seeding document quota counter for database %s with %d documents", base.MD(q.context.Name), count)
+	if count > 0 {
+		_, err = q.context.MetadataBucket.Incr(kQuotaDocsCreatedKey, count, count, 0)
+	}
+	return err
+}
+
+// Reserves room for a new live document, or returns a 507 error if there's none left under the MaxDocs quota.  Returns
+// true if a reservation was made, which the caller must either keep by counting the document as created, or release
+// with recordLiveDocs(-1).
+func (q *quotaTracker) reserveDoc() (bool, error) {
+	if q == nil || q.options.MaxDocs == 0 {
+		return false, nil
+	}
+	if err := q.seedDocsOnce(); err != nil {
+		return false, err
+	}
+	count, err := q.addUsage(kQuotaDocsCreatedKey, kQuotaDocsDeletedKey, 1)
+	if err != nil {
+		return false, err
+	}
+	if count > q.options.MaxDocs {
+		q.removeUsage(kQuotaDocsDeletedKey, 1)
+		q.stats().Add(base.StatKeyQuotaDocsRejectedCount, 1)
+		return false, base.HTTPErrorf(http.StatusInsufficientStorage, "Database has reached its quota of %d documents", q.options.MaxDocs)
+	}
+	return true, nil
+}
+
+// Records a change in the # of live documents.
+func (q *quotaTracker) recordLiveDocs(delta int) {
+	if q == nil || q.options.MaxDocs == 0 || delta == 0 {
+		return
+	}
+	if delta < 0 {
+		q.removeUsage(kQuotaDocsDeletedKey, uint64(-delta))
+	} else if _, err := q.context.MetadataBucket.Incr(kQuotaDocsCreatedKey, uint64(delta), uint64(delta), 0); err != nil {
+		base.Warnf(base.KeyAll, "Unable to update document quota counter for database %s: %v", base.MD(q.context.Name), err)
+	}
+}
+
+// Reserves room for the attachments, or returns a 507 error if storing them would exceed the MaxAttachmentBytes
+// quota.  Returns the attachments that were reserved, which the caller must release with releaseAttachmentBytes
+// unless they're added to the bucket.  Attachments that are already stored don't count, but they're only looked up
+// when they don't fit, so that writes under the quota don't need an extra read per attachment.
+func (q *quotaTracker) reserveAttachmentBytes(attachments AttachmentData) (AttachmentData, error) {
+	if q == nil || q.options.MaxAttachmentBytes == 0 {
+		return nil, nil
+	}
+	reserved := make(AttachmentData, len(attachments))
+	for key, data := range attachments {
+		size := uint64(len(data))
+		if size == 0 {
+			continue
+		}
+		total, err := q.addUsage(kQuotaAttachmentBytesKey, kQuotaAttachmentBytesReleasedKey, size)
+		if err == nil && total > q.options.MaxAttachmentBytes {
+			q.removeUsage(kQuotaAttachmentBytesReleasedKey, size)
+			if _, _, getErr := q.context.Bucket.GetRaw(attachmentKeyToString(key)); getErr == nil {
+				continue // Already stored, so it doesn't count
+			}
+			q.stats().Add(base.StatKeyQuotaAttachmentsRejectedCount, 1)
+			err = base.HTTPErrorf(http.StatusInsufficientStorage, "Database has reached its quota of %d attachment bytes", q.options.MaxAttachmentBytes)
+		}
+		if err != nil {
+			q.releaseAttachmentBytes(reserved)
+			return nil, err
+		}
+		reserved[key] = data
+	}
+	return reserved, nil
+}
+
+// Releases the room reserved by reserveAttachmentBytes for the attachments.
+func (q *quotaTracker) releaseAttachmentBytes(reserved AttachmentData) {
+	if q == nil || q.options.MaxAttachmentBytes == 0 {
+		return
+	}
+	size := uint64(0)
+	for _, data := range reserved {
+		size += uint64(len(data))
+	}
+	if size > 0 {
+		q.removeUsage(kQuotaAttachmentBytesReleasedKey, size)
+	}
+}
+
+// Returns a 429 error if there are already MaxConcurrentReplications active, otherwise counts a new one that must be
+// ended with endReplication.
+func (q *quotaTracker) startReplication() error {
+	if q == nil || q.options.MaxConcurrentReplications == 0 {
+		return nil
+	}
+	if atomic.AddInt32(&q.replications, 1) > int32(q.options.MaxConcurrentReplications) {
+		atomic.AddInt32(&q.replications, -1)
+		q.stats().Add(base.StatKeyQuotaReplicationsRejectedCount, 1)
+		return base.HTTPErrorf(http.StatusTooManyRequests, "Database has reached its quota of %d concurrent replications", q.options.MaxConcurrentReplications)
+	}
+	return nil
+}
+
+func (q *quotaTracker) endReplication() {
+	if q == nil || q.options.MaxConcurrentReplications == 0 {
+		return
+	}
+	atomic.AddInt32(&q.replications, -1)
+}
+
+// Returns a 429 error if another op would exceed MaxOpsPerSec.  Ops are rate limited with a token bucket that holds
+// up to a second's worth of ops, so short bursts are allowed.
+func (q *quotaTracker) checkOpRate() error {
+	if q == nil || q.options.MaxOpsPerSec == 0 {
+		return nil
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	now := q.now()
+	if !q.lastOpTime.IsZero() {
+		q.opTokens += now.Sub(q.lastOpTime).Seconds() * float64(q.options.MaxOpsPerSec)
+		if max := float64(q.options.MaxOpsPerSec); q.opTokens > max {
+			q.opTokens = max
+		}
+	}
+	q.lastOpTime = now
+
+	if q.opTokens < 1 {
+		q.stats().Add(base.StatKeyQuotaOpsRejectedCount, 1)
+		return base.HTTPErrorf(http.StatusTooManyRequests, "Database has reached its quota of %d operations per second", q.options.MaxOpsPerSec)
+	}
+	q.opTokens--
+	return nil
+}
+
+// StartReplication returns a 429 error if the database's concurrent replication quota has been reached.  Otherwise
+// the replication is counted until EndReplication is called.
+func (context *DatabaseContext) StartReplication() error {
+	return context.quotas.startReplication()
+}
+
+// EndReplication stops counting a replication started with StartReplication.
+func (context *DatabaseContext) EndReplication() {
+	context.quotas.endReplication()
+}
+
+// CheckOpRate returns a 429 error if the database's ops/sec quota has been exceeded.
+func (context *DatabaseContext) CheckOpRate() error {
+	return context.quotas.checkOpRate()
+}
@@ -0,0 +1,157 @@
+package db
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/stretchr/testify/assert"
+)
+
+func assertErrorStatus(t *testing.T, err error, status int) {
+	actualStatus, _ := base.ErrorAsHTTPStatus(err)
+	assert.Equal(t, status, actualStatus, "Unexpected error: %v", err)
+}
+
+func TestDocQuota(t *testing.T) {
+	db, testBucket := setupTestDB(t)
+	defer testBucket.Close()
+	defer tearDownTestDB(t, db)
+
+	// Docs created before the quota was set are counted
+	rev1, err := db.Put("doc1", Body{"n": 1})
+	assert.NoError(t, err)
+	db.quotas = newQuotaTracker(db.DatabaseContext, QuotaOptions{MaxDocs: 2})
+
+	_, err = db.Put("doc2", Body{"n": 2})
+	assert.NoError(t, err)
+	_, err = db.Put("doc3", Body{"n": 3})
+	assertErrorStatus(t, err, http.StatusInsufficientStorage)
+	err = db.PutExistingRev("doc3", Body{"n": 3}, []string{"1-abc"}, false)
+	assertErrorStatus(t, err, http.StatusInsufficientStorage)
+	rejected := db.DbStats.StatsDatabase().Get(base.StatKeyQuotaDocsRejectedCount).(*expvar.Int).Value()
+	assert.Equal(t, int64(2), rejected)
+
+	// Updating an existing doc doesn't count against the quota
+	rev2, err := db.Put("doc1", Body{"n": 1, BodyRev: rev1})
+	assert.NoError(t, err)
+
+	// Deleting or purging a doc makes room for another
+	rev3, err := db.DeleteDoc("doc1", rev2)
+	assert.NoError(t, err)
+	_, err = db.Put("doc3", Body{"n": 3})
+	assert.NoError(t, err)
+	assert.NoError(t, db.Purge("doc2"))
+	_, err = db.Put("doc4", Body{"n": 4})
+	assert.NoError(t, err)
+
+	// Resurrecting a deleted doc counts as creating one
+	_, err = db.Put("doc1", Body{"n": 1, BodyRev: rev3})
+	assertErrorStatus(t, err, http.StatusInsufficientStorage)
+}
+
+func TestDocQuotaConcurrentWrites(t *testing.T) {
+	db, testBucket := setupTestDB(t)
+	defer testBucket.Close()
+	defer tearDownTestDB(t, db)
+	db.quotas = newQuotaTracker(db.DatabaseContext, QuotaOptions{MaxDocs: 5})
+
+	// Concurrent writers can't both fit into the last of the quota
+	var wg sync.WaitGroup
+	var created int32
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := db.Put(fmt.Sprintf("doc%d", i), Body{"n": i}); err == nil {
+				atomic.AddInt32(&created, 1)
+			} else {
+				assertErrorStatus(t, err, http.StatusInsufficientStorage)
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, int32(5), created)
+
+	// Rejected writes don't keep their reservations
+	count, err := db.quotas.addUsage(kQuotaDocsCreatedKey, kQuotaDocsDeletedKey, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(5), count)
+}
+
+func TestAttachmentQuota(t *testing.T) {
+	db, testBucket := setupTestDB(t)
+	defer testBucket.Close()
+	defer tearDownTestDB(t, db)
+	db.quotas = newQuotaTracker(db.DatabaseContext, QuotaOptions{MaxAttachmentBytes: 20})
+
+	// "hello world" is 11 bytes
+	helloWorld := func() Body {
+		return Body{"hello.txt": map[string]interface{}{"data": "aGVsbG8gd29ybGQ="}}
+	}
+	_, err := db.Put("doc1", Body{BodyAttachments: helloWorld()})
+	assert.NoError(t, err)
+
+	// Attachments that are already stored only count once
+	_, err = db.Put("doc2", Body{BodyAttachments: helloWorld()})
+	assert.NoError(t, err)
+
+	// "goodbye cruel world" is 19 bytes
+	_, err = db.Put("doc3", Body{BodyAttachments: Body{"bye.txt": map[string]interface{}{"data": "Z29vZGJ5ZSBjcnVlbCB3b3JsZA=="}}})
+	assertErrorStatus(t, err, http.StatusInsufficientStorage)
+	rejected := db.DbStats.StatsDatabase().Get(base.StatKeyQuotaAttachmentsRejectedCount).(*expvar.Int).Value()
+	assert.Equal(t, int64(1), rejected)
+
+	// Only the attachment that was stored counts, not the room reserved for rejected or already stored ones
+	total, err := db.quotas.addUsage(kQuotaAttachmentBytesKey, kQuotaAttachmentBytesReleasedKey, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(11), total)
+}
+
+func TestReplicationQuota(t *testing.T) {
+	context := &DatabaseContext{DbStats: NewDatabaseStats()}
+	context.quotas = newQuotaTracker(context, QuotaOptions{MaxConcurrentReplications: 2})
+
+	assert.NoError(t, context.StartReplication())
+	assert.NoError(t, context.StartReplication())
+	assertErrorStatus(t, context.StartReplication(), http.StatusTooManyRequests)
+	context.EndReplication()
+	assert.NoError(t, context.StartReplication())
+
+	// Without quotas, replications aren't limited
+	context.quotas = nil
+	assert.NoError(t, context.StartReplication())
+	context.EndReplication()
+}
+
+func TestOpRateQuota(t *testing.T) {
+	context := &DatabaseContext{DbStats: NewDatabaseStats()}
+	context.quotas = newQuotaTracker(context, QuotaOptions{MaxOpsPerSec: 10})
+	now := time.Now()
+	context.quotas.now = func() time.Time { return now }
+
+	// A second's worth of ops is allowed in a burst
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, context.CheckOpRate())
+	}
+	assertErrorStatus(t, context.CheckOpRate(), http.StatusTooManyRequests)
+
+	// Allowed ops are refilled at the quota's rate, up to a second's worth
+	now = now.Add(200 * time.Millisecond)
+	assert.NoError(t, context.CheckOpRate())
+	assert.NoError(t, context.CheckOpRate())
+	assertErrorStatus(t, context.CheckOpRate(), http.StatusTooManyRequests)
+
+	now = now.Add(time.Minute)
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, context.CheckOpRate())
+	}
+	assertErrorStatus(t, context.CheckOpRate(), http.StatusTooManyRequests)
+	rejected := context.DbStats.StatsDatabase().Get(base.StatKeyQuotaOpsRejectedCount).(*expvar.Int).Value()
+	assert.Equal(t, int64(3), rejected)
+}
@@ -0,0 +1,206 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	sgbucket "github.com/couchbase/sg-bucket"
+	"github.com/couchbase/sync_gateway/auth"
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/robertkrimen/otto"
+)
+
+// ReadTransform changes the bodies of the revisions users read, so that properties can be hidden from the users who
+// shouldn't see them without keeping separate copies of the documents.  It applies to the public API's document
+// reads, _bulk_get, _changes with include_docs and BLIP pulls, but not to the admin API.  A user who updates a
+// document, or adds an attachment to it, replaces its whole body with the one they read, so the sync function
+// shouldn't let users update documents they can't see all of.
+type ReadTransform struct {
+	Masks    []PropertyMask         // Properties to remove from the bodies sent to users without the masks' roles
+	Function *ReadTransformFunction // JavaScript function that transforms the bodies, after the masks are applied
+}
+
+// PropertyMask hides some of a document's properties from the users who don't have one of its roles.
+type PropertyMask struct {
+	DocType        string     // Matched against the document's "type" property, if set
+	Properties     [][]string // Paths of the properties to remove, each split into the names of nested properties
+	VisibleToRoles []string   // Users with any of these roles still see the properties
+}
+
+// Returns whether the mask hides properties from the given user.
+func (m PropertyMask) hidesFrom(user auth.User) bool {
+	roles := user.RoleNames()
+	for _, role := range m.VisibleToRoles {
+		if roles.Contains(role) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns whether the transform may change the revisions sent to the given user.  Safe to call on a nil
+// *ReadTransform, which changes nothing.  Revisions sent to admins (a nil user) aren't transformed.
+func (t *ReadTransform) AppliesTo(user auth.User) bool {
+	if t == nil || user == nil {
+		return false
+	}
+	if t.Function != nil {
+		return true
+	}
+	for _, mask := range t.Masks {
+		if mask.hidesFrom(user) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the body of a revision as the given user should see it.  The body isn't modified, as it may be shared with
+// the revision cache; if anything has to change, a new body is returned.  Special properties (starting with "_")
+// are never removed or changed.
+func (t *ReadTransform) Apply(docid string, body Body, user auth.User) (Body, error) {
+	if !t.AppliesTo(user) {
+		return body, nil
+	}
+	if deleted, _ := body[BodyDeleted].(bool); deleted {
+		return body, nil
+	}
+	if removed, _ := body["_removed"].(bool); removed {
+		return body, nil
+	}
+
+	result := map[string]interface{}(body)
+	copied := false
+	for _, mask := range t.Masks {
+		if !mask.hidesFrom(user) {
+			continue
+		}
+		if docType, _ := body["type"].(string); mask.DocType != "" && docType != mask.DocType {
+			continue
+		}
+		for _, path := range mask.Properties {
+			if masked, changed := withoutProperty(result, path, !copied); changed {
+				result, copied = masked, true
+			}
+		}
+	}
+
+	if t.Function != nil {
+		transformed, err := t.Function.Transform(result, user)
+		if err != nil {
+			base.Warnf(base.KeyAll, "Error calling read transform function for doc %q: %v", base.UDDocID(docid), err)
+			return nil, base.HTTPErrorf(http.StatusInternalServerError, "Error transforming document")
+		}
+		// Keep the special properties of the revision, whatever the function returned
+		for key, value := range body {
+			if strings.HasPrefix(key, "_") {
+				transformed[key] = value
+			}
+		}
+		for key := range transformed {
+			if _, ok := body[key]; !ok && strings.HasPrefix(key, "_") {
+				delete(transformed, key)
+			}
+		}
+		return transformed, nil
+	}
+	return Body(result), nil
+}
+
+// Removes the property at the given path from an object, returning whether it was there.  The objects on the path to
+// the property are copied rather than modified, as is the object itself if copyObject is set.
+func withoutProperty(object map[string]interface{}, path []string, copyObject bool) (map[string]interface{}, bool) {
+	value, ok := object[path[0]]
+	if !ok {
+		return object, false
+	}
+	var child map[string]interface{}
+	if len(path) > 1 {
+		switch value := value.(type) {
+		case map[string]interface{}:
+			child = value
+		case Body:
+			child = value
+		default:
+			return object, false
+		}
+		if child, ok = withoutProperty(child, path[1:], true); !ok {
+			return object, false
+		}
+	}
+
+	if copyObject {
+		copied := make(map[string]interface{}, len(object))
+		for key, value := range object {
+			copied[key] = value
+		}
+		object = copied
+	}
+	if child != nil {
+		object[path[0]] = child
+	} else {
+		delete(object, path[0])
+	}
+	return object, true
+}
+
+//////// Read Transform Function
+
+// Compiles a JavaScript read transform function.
+func newReadTransformRunner(funcSource string) (sgbucket.JSServerTask, error) {
+	readTransformRunner := &jsEventTask{}
+	err := readTransformRunner.Init(funcSource)
+	if err != nil {
+		return nil, err
+	}
+
+	readTransformRunner.After = func(result otto.Value, err error) (interface{}, error) {
+		nativeValue, _ := result.Export()
+		return nativeValue, err
+	}
+
+	return readTransformRunner, nil
+}
+
+// ValidateReadTransformFunction compiles the given read transform function source, returning any compilation error.
+func ValidateReadTransformFunction(fnSource string) error {
+	_, err := newReadTransformRunner(fnSource)
+	return err
+}
+
+// A thread-safe wrapper around a JavaScript function(doc, user) that returns the body of a revision as the user
+// should see it.  The user has the same name, roles and channels properties as the sync function's user.
+type ReadTransformFunction struct {
+	*sgbucket.JSServer
+}
+
+func NewReadTransformFunction(fnSource string) *ReadTransformFunction {
+
+	base.Debugf(base.KeyCRUD, "Creating new ReadTransformFunction")
+	return &ReadTransformFunction{
+		JSServer: sgbucket.NewJSServer(fnSource, kTaskCacheSize,
+			func(fnSource string) (sgbucket.JSServerTask, error) {
+				return newReadTransformRunner(fnSource)
+			}),
+	}
+}
+
+// Calls the function on a body, returning the body it returns.  The body is passed as JSON, so the function can't
+// modify the original.
+func (f *ReadTransformFunction) Transform(body map[string]interface{}, user auth.User) (Body, error) {
+	bodyJSON, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	result, err := f.Call(sgbucket.JSONString(bodyJSON), makeUserCtx(user))
+	if err != nil {
+		return nil, err
+	}
+	transformed, ok := result.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("Read transform function returned a non-object value")
+	}
+	return transformed, nil
+}
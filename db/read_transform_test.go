@@ -0,0 +1,106 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/couchbase/sync_gateway/channels"
+	"github.com/stretchr/testify/assert"
+)
+
+// Returns a Database for a new user with access to the "public" channel, and the given roles.
+func readTransformTestUser(t *testing.T, db *Database, name string, roles ...string) *Database {
+	authenticator := db.Authenticator()
+	user, err := authenticator.NewUser(name, "letmein", channels.SetOf("public"))
+	assert.NoError(t, err)
+	explicitRoles := channels.TimedSet{}
+	for _, roleName := range roles {
+		role, err := authenticator.NewRole(roleName, nil)
+		assert.NoError(t, err)
+		assert.NoError(t, authenticator.Save(role))
+		explicitRoles[roleName] = channels.NewVbSimpleSequence(1)
+	}
+	user.SetExplicitRoles(explicitRoles)
+	assert.NoError(t, authenticator.Save(user))
+	reloaded, err := authenticator.GetUser(name)
+	assert.NoError(t, err)
+	userDB, err := GetDatabase(db.DatabaseContext, reloaded)
+	assert.NoError(t, err)
+	return userDB
+}
+
+func TestReadTransformMasks(t *testing.T) {
+	db, testBucket := setupTestDB(t)
+	defer testBucket.Close()
+	defer tearDownTestDB(t, db)
+
+	db.Options.ReadTransform = &ReadTransform{Masks: []PropertyMask{{
+		DocType:        "employee",
+		Properties:     [][]string{{"salary"}, {"address", "street"}, {"missing", "property"}},
+		VisibleToRoles: []string{"hr"},
+	}}}
+	rev1, err := db.Put("emp1", Body{"type": "employee", "channels": "public", "name": "Ann", "salary": 100,
+		"address": map[string]interface{}{"street": "1 Main St", "city": "Springfield"}})
+	assert.NoError(t, err)
+	_, err = db.Put("note1", Body{"type": "note", "channels": "public", "salary": 100})
+	assert.NoError(t, err)
+	rev2, err := db.Put("emp1", Body{BodyRev: rev1, "type": "employee", "channels": "public", "name": "Ann", "salary": 110,
+		"address": map[string]interface{}{"street": "1 Main St", "city": "Springfield"}})
+	assert.NoError(t, err)
+
+	hrDB := readTransformTestUser(t, db, "alice", "hr")
+	userDB := readTransformTestUser(t, db, "bob")
+
+	body, err := userDB.Get("emp1")
+	assert.NoError(t, err)
+	assert.Nil(t, body["salary"])
+	assert.Equal(t, map[string]interface{}{"city": "Springfield"}, body["address"])
+	assert.Equal(t, "Ann", body["name"])
+	assert.Equal(t, "emp1", body[BodyId])
+
+	// Masks only apply to their doc type, and users with the masks' roles and admins see everything
+	body, err = userDB.Get("note1")
+	assert.NoError(t, err)
+	assert.NotNil(t, body["salary"])
+	for _, reader := range []*Database{hrDB, db} {
+		body, err = reader.Get("emp1")
+		assert.NoError(t, err)
+		assert.NotNil(t, body["salary"])
+		assert.Equal(t, map[string]interface{}{"street": "1 Main St", "city": "Springfield"}, body["address"])
+	}
+
+	// Deltas would leak the masked properties
+	assert.False(t, db.Options.ReadTransform.AppliesTo(hrDB.user))
+	assert.True(t, db.Options.ReadTransform.AppliesTo(userDB.user))
+	delta, err := userDB.GetDelta("emp1", rev1, rev2)
+	assert.NoError(t, err)
+	assert.Nil(t, delta)
+}
+
+func TestReadTransformFunction(t *testing.T) {
+	db, testBucket := setupTestDB(t)
+	defer testBucket.Close()
+	defer tearDownTestDB(t, db)
+
+	db.Options.ReadTransform = &ReadTransform{
+		Masks:    []PropertyMask{{Properties: [][]string{{"secret"}}}},
+		Function: NewReadTransformFunction(`function(doc, user) {doc.reader = user.name; doc._rev = "1-fake"; doc._extra = true; return doc;}`),
+	}
+	rev1, err := db.Put("doc1", Body{"channels": "public", "secret": "shh", "value": 1})
+	assert.NoError(t, err)
+
+	userDB := readTransformTestUser(t, db, "bob")
+	body, err := userDB.Get("doc1")
+	assert.NoError(t, err)
+	assert.Equal(t, "bob", body["reader"])
+	assert.Nil(t, body["secret"])
+	assert.NotNil(t, body["value"])
+
+	// The function can't change the special properties
+	assert.Equal(t, rev1, body[BodyRev])
+	assert.Nil(t, body["_extra"])
+
+	body, err = db.Get("doc1")
+	assert.NoError(t, err)
+	assert.Nil(t, body["reader"])
+	assert.Equal(t, "shh", body["secret"])
+}
@@ -0,0 +1,21 @@
+package db
+
+// The state of a BLIP replication, for a ReplicationEvent
+const (
+	ReplicationStarted   = "started"   // The client connected
+	ReplicationStopped   = "stopped"   // The client disconnected
+	ReplicationError     = "error"     // The connection failed
+	ReplicationCompleted = "completed" // A one-shot pull caught up, and all the changes the client asked for were sent
+)
+
+// Describes a BLIP replication, for a ReplicationEvent.  The doc counts are for the whole connection so far.
+type ReplicationStatus struct {
+	State         string // One of ReplicationStarted, ReplicationStopped, ReplicationError or ReplicationCompleted
+	ReplicationID string // Identifies the connection in the logs
+	Username      string
+	ClientID      string // The client's checkpoint ID, which is stable across its connections.  Unknown until it gets or sets a checkpoint
+	UserAgent     string
+	DocsSent      uint64 // Revisions pulled by the client
+	DocsReceived  uint64 // Revisions pushed by the client
+	Error         string // For ReplicationError, what went wrong
+}
@@ -12,6 +12,7 @@ package db
 import (
 	"bytes"
 	"crypto/md5"
+	"crypto/sha1"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -29,6 +30,7 @@ const (
 	BodyId          = "_id"
 	BodyRevisions   = "_revisions"
 	BodyAttachments = "_attachments"
+	BodyExpiry      = "_exp"
 )
 
 // A revisions property found within a Body.  Expected to be of the form:
@@ -150,14 +152,14 @@ func (body Body) extractExpiry() (uint32, error) {
 	if !present || err != nil {
 		return exp, err
 	}
-	delete(body, "_exp")
+	delete(body, BodyExpiry)
 
 	return exp, nil
 }
 
 // Looks up the _exp property in the document, and turns it into a Couchbase Server expiry value, as:
 func (body Body) getExpiry() (uint32, bool, error) {
-	rawExpiry, ok := body["_exp"]
+	rawExpiry, ok := body[BodyExpiry]
 	if !ok {
 		return 0, false, nil //_exp not present
 	}
@@ -171,12 +173,15 @@ func (body Body) getExpiry() (uint32, bool, error) {
 // nonJSONPrefix is used to ensure old revision bodies aren't hidden from N1QL/Views.
 const nonJSONPrefix = byte(1)
 
-// Looks up the raw JSON data of a revision that's been archived to a separate doc.
-// If the revision isn't found (e.g. has been deleted by compaction) returns 404 error.
+// Looks up the raw JSON data of a revision that's been archived to a separate doc, in the database's bucket or else
+// in cold storage.  If the revision isn't found (e.g. has been deleted by compaction) returns 404 error.
 func (db *DatabaseContext) getOldRevisionJSON(docid string, revid string) ([]byte, error) {
 	data, _, err := db.Bucket.GetRaw(oldRevisionKey(docid, revid))
 	if base.IsDocNotFoundError(err) {
-		base.Debugf(base.KeyCRUD, "No old revision %q / %q", base.UD(docid), revid)
+		data, err = db.coldRevisions.get(docid, revid)
+	}
+	if base.IsDocNotFoundError(err) {
+		base.Debugf(base.KeyCRUD, "No old revision %q / %q", base.UDDocID(docid), revid)
 		err = base.HTTPErrorf(404, "missing")
 	}
 	if data != nil {
@@ -184,7 +189,7 @@ func (db *DatabaseContext) getOldRevisionJSON(docid string, revid string) ([]byt
 		if len(data) > 0 && data[0] == nonJSONPrefix {
 			data = data[1:]
 		}
-		base.Debugf(base.KeyCRUD, "Got old revision %q / %q --> %d bytes", base.UD(docid), revid, len(data))
+		base.Debugf(base.KeyCRUD, "Got old revision %q / %q --> %d bytes", base.UDDocID(docid), revid, len(data))
 	}
 	return data, err
 }
@@ -208,7 +213,7 @@ func (db *Database) backupRevisionJSON(docId, newRevId, oldRevId string, newBody
 	if db.UseXattrs() {
 		newBodyBytes, marshalErr := json.Marshal(newBody)
 		if marshalErr != nil {
-			base.Warnf(base.KeyAll, "Unable to marshal new revision body during backupRevisionJSON: doc=%q rev=%q err=%v ", base.UD(docId), newRevId, marshalErr)
+			base.Warnf(base.KeyAll, "Unable to marshal new revision body during backupRevisionJSON: doc=%q rev=%q err=%v ", base.UDDocID(docId), newRevId, marshalErr)
 			return
 		}
 		_ = db.setOldRevisionJSON(docId, newRevId, newBodyBytes, db.Options.DeltaSyncOptions.RevMaxAgeSeconds)
@@ -228,11 +233,22 @@ func (db *Database) setOldRevisionJSON(docid string, revid string, body []byte,
 	body = append(body, byte(0))
 	copy(body[1:], body[0:])
 	body[0] = nonJSONPrefix
+
+	// Bodies kept longer than the cold storage threshold are moved there, unless that fails
+	if db.coldRevisions.moves(expiry) {
+		if err := db.coldRevisions.set(docid, revid, body, expiry); err == nil {
+			if err := db.Bucket.Delete(oldRevisionKey(docid, revid)); err != nil && !base.IsKeyNotFoundError(db.Bucket, err) {
+				base.Warnf(base.KeyAll, "Unable to remove revision body %q/%q after moving it to cold storage: %v", base.UDDocID(docid), revid, err)
+			}
+			base.Debugf(base.KeyCRUD, "Moved revision body %q/%q to cold storage (%d bytes, ttl:%d)", base.UDDocID(docid), revid, len(body), expiry)
+			return nil
+		}
+	}
 	err := db.Bucket.SetRaw(oldRevisionKey(docid, revid), expiry, base.BinaryDocument(body))
 	if err == nil {
-		base.Debugf(base.KeyCRUD, "Backed up revision body %q/%q (%d bytes, ttl:%d)", base.UD(docid), revid, len(body), expiry)
+		base.Debugf(base.KeyCRUD, "Backed up revision body %q/%q (%d bytes, ttl:%d)", base.UDDocID(docid), revid, len(body), expiry)
 	} else {
-		base.Warnf(base.KeyAll, "setOldRevisionJSON failed: doc=%q rev=%q err=%v", base.UD(docid), revid, err)
+		base.Warnf(base.KeyAll, "setOldRevisionJSON failed: doc=%q rev=%q err=%v", base.UDDocID(docid), revid, err)
 	}
 	return err
 }
@@ -241,6 +257,16 @@ func (db *Database) setOldRevisionJSON(docid string, revid string, body []byte,
 // recreate the revision backup when body is non-empty.
 func (db *Database) refreshPreviousRevisionBackup(docid string, revid string, body []byte, expiry uint32) error {
 
+	if db.coldRevisions.moves(expiry) {
+		err := db.coldRevisions.touch(docid, revid, expiry)
+		if err == nil {
+			return nil
+		} else if !base.IsKeyNotFoundError(db.coldRevisions.bucket, err) {
+			return err
+		}
+		// The body is still in the database's bucket if it couldn't be moved
+	}
+
 	_, err := db.Bucket.Touch(oldRevisionKey(docid, revid), expiry)
 	if base.IsKeyNotFoundError(db.Bucket, err) && len(body) > 0 {
 		return db.setOldRevisionJSON(docid, revid, body, expiry)
@@ -250,7 +276,7 @@ func (db *Database) refreshPreviousRevisionBackup(docid string, revid string, bo
 
 // Currently only used by unit tests - deletes an archived old revision from the database
 func (db *Database) purgeOldRevisionJSON(docid string, revid string) error {
-	base.Debugf(base.KeyCRUD, "Purging old revision backup %q / %q ", base.UD(docid), revid)
+	base.Debugf(base.KeyCRUD, "Purging old revision backup %q / %q ", base.UDDocID(docid), revid)
 	return db.Bucket.Delete(oldRevisionKey(docid, revid))
 }
 
@@ -276,6 +302,34 @@ func createRevID(generation int, parentRevID string, body Body) string {
 	return fmt.Sprintf("%d-%x", generation, digester.Sum(nil))
 }
 
+// Creates a revision ID that depends only on the revision's content, so that identical edits made through different
+// Sync Gateway nodes converge to the same revision ID: the SHA-1 digest of the length-prefixed parent revision ID,
+// the deletion flag and the canonical JSON encoding of the body's properties.  This isn't verified to match the
+// revision IDs Couchbase Lite generates, so edits made on devices don't necessarily converge with these.
+func createDeterministicRevID(generation int, parentRevID string, deleted bool, body Body) string {
+	if len(parentRevID) > 255 {
+		parentRevID = parentRevID[:255]
+	}
+	var deletedFlag byte
+	if deleted {
+		deletedFlag = 1
+	}
+	digester := sha1.New()
+	digester.Write([]byte{byte(len(parentRevID))})
+	digester.Write([]byte(parentRevID))
+	digester.Write([]byte{deletedFlag})
+	digester.Write(canonicalJSON(stripRevIDSpecialProperties(body)))
+	return fmt.Sprintf("%d-%x", generation, digester.Sum(nil))
+}
+
+// Creates the ID of a new revision, deterministically if the database's DeterministicRevIDs option is set.
+func (context *DatabaseContext) newRevID(generation int, parentRevID string, deleted bool, body Body) string {
+	if context.Options.DeterministicRevIDs {
+		return createDeterministicRevID(generation, parentRevID, deleted, body)
+	}
+	return createRevID(generation, parentRevID, body)
+}
+
 // Returns the generation number (numeric prefix) of a revision ID.
 func genOfRevID(revid string) int {
 	if revid == "" {
@@ -335,6 +389,18 @@ func stripSpecialProperties(body Body) Body {
 	return stripped
 }
 
+// Strips all special properties except _attachments, which are part of the revision's content.  Unlike
+// stripSpecialProperties, _deleted is removed, as the deletion flag is digested separately.
+func stripRevIDSpecialProperties(body Body) Body {
+	stripped := Body{}
+	for key, value := range body {
+		if key == "" || key[0] != '_' || key == BodyAttachments {
+			stripped[key] = value
+		}
+	}
+	return stripped
+}
+
 func containsUserSpecialProperties(body Body) bool {
 	for key := range body {
 		if key != "" && key[0] == '_' && key != BodyId && key != BodyRev && key != BodyDeleted && key != BodyAttachments && key != BodyRevisions {
@@ -352,6 +418,17 @@ func canonicalEncoding(body Body) []byte {
 	return encoded
 }
 
+// Encodes the body as canonical JSON: object keys sorted, no whitespace, and no escaping of HTML characters.
+func canonicalJSON(body Body) []byte {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(body); err != nil {
+		panic(fmt.Sprintf("Couldn't encode body %v", body))
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+}
+
 func GetStringArrayProperty(body map[string]interface{}, property string) ([]string, error) {
 	if raw, exists := body[property]; !exists {
 		return nil, nil
@@ -1,6 +1,7 @@
 package db
 
 import (
+	"encoding/json"
 	"fmt"
 	"math/rand"
 	"testing"
@@ -301,6 +302,65 @@ func TestRevisionImmutableDelta(t *testing.T) {
 
 }
 
+func TestSharedRevisionCache(t *testing.T) {
+	testBucket := testBucket()
+	defer testBucket.Close()
+
+	var callsToLoader = 0
+	loader := func(id IDAndRev) (body Body, history Revisions, channels base.Set, attachments AttachmentsMeta, expiry *time.Time, err error) {
+		callsToLoader++
+		if id.DocID != "doc1" {
+			return nil, nil, nil, nil, nil, base.HTTPErrorf(404, "missing")
+		}
+		body = Body{BodyId: id.DocID, BodyRev: id.RevID, "count": 12345678901234567}
+		history = encodeRevisions([]string{"2-b", "1-a"})
+		channels = base.SetOf("ABC")
+		attachments = AttachmentsMeta{"hello.txt": map[string]interface{}{"digest": "sha1-abc", "length": 11}}
+		return body, history, channels, attachments, nil, nil
+	}
+
+	// Each node has its own revision cache, sharing revisions through the bucket
+	node1Stats := initEmptyStatsMap(base.StatsGroupKeyCache)
+	node1 := NewRevisionCache(10, newSharedRevisionCache(testBucket.Bucket, "db", 0, loader, node1Stats).load, node1Stats)
+	node2Stats := initEmptyStatsMap(base.StatsGroupKeyCache)
+	node2 := NewRevisionCache(10, newSharedRevisionCache(testBucket.Bucket, "db", 0, loader, node2Stats).load, node2Stats)
+
+	docRev, err := node1.Get("doc1", "2-b")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, callsToLoader)
+	assert.Equal(t, "1", node1Stats.Get(base.StatKeySharedRevisionCacheMisses).String())
+
+	// The second node gets the revision from the shared cache rather than loading it
+	sharedRev, err := node2.Get("doc1", "2-b")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, callsToLoader)
+	assert.Equal(t, "1", node2Stats.Get(base.StatKeySharedRevisionCacheHits).String())
+	assert.Equal(t, "doc1", sharedRev.Body[BodyId])
+	assert.Equal(t, json.Number("12345678901234567"), sharedRev.Body["count"])
+	assert.Equal(t, docRev.History, sharedRev.History)
+	assert.Equal(t, []string{"2-b", "1-a"}, ParseRevisions(Body{BodyRevisions: map[string]interface{}(sharedRev.History)}))
+	assert.Equal(t, docRev.Channels, sharedRev.Channels)
+	assert.Equal(t, "sha1-abc", sharedRev.Attachments["hello.txt"].(map[string]interface{})["digest"])
+
+	// Failed loads aren't shared
+	_, err = node1.Get("doc2", "1-a")
+	assert.Error(t, err)
+	_, err = node2.Get("doc2", "1-a")
+	assert.Error(t, err)
+	assert.Equal(t, 3, callsToLoader)
+
+	// Other databases sharing the cache bucket have their own revisions
+	_, err = NewRevisionCache(10, newSharedRevisionCache(testBucket.Bucket, "otherdb", 0, loader, node2Stats).load, node2Stats).Get("doc1", "2-b")
+	assert.NoError(t, err)
+	assert.Equal(t, 4, callsToLoader)
+
+	// Removed revisions, such as those of a purged doc, are loaded again
+	newSharedRevisionCache(testBucket.Bucket, "db", 0, loader, node1Stats).remove("doc1", []string{"2-b", "1-a"})
+	_, err = NewRevisionCache(10, newSharedRevisionCache(testBucket.Bucket, "db", 0, loader, node2Stats).load, node2Stats).Get("doc1", "2-b")
+	assert.NoError(t, err)
+	assert.Equal(t, 5, callsToLoader)
+}
+
 func BenchmarkRevisionCacheRead(b *testing.B) {
 
 	//Create test document
@@ -70,3 +70,16 @@ func TestBodyUnmarshal(t *testing.T) {
 		})
 	}
 }
+
+func TestCreateDeterministicRevID(t *testing.T) {
+	body := Body{"b": "<x>", "a": 1, BodyId: "doc1", BodyRev: "1-abc", BodyRevisions: Body{"start": 1}}
+	revID := createDeterministicRevID(2, "1-abc", false, body)
+	// Recorded from this implementation, to catch changes to the algorithm; it's not a Couchbase Lite revID
+	assert.Equal(t, "2-641b2c5f45ad43b32fef2393ce5a8e87f633d0aa", revID)
+
+	// Special properties other than _attachments don't affect the revID, but the deletion flag does
+	assert.Equal(t, revID, createDeterministicRevID(2, "1-abc", false, Body{"a": 1, "b": "<x>"}))
+	assert.NotEqual(t, revID, createDeterministicRevID(2, "1-abc", true, Body{"a": 1, "b": "<x>", BodyDeleted: true}))
+	assert.NotEqual(t, revID, createDeterministicRevID(2, "1-abc", false, Body{"a": 1, "b": "<x>", BodyAttachments: Body{}}))
+	assert.NotEqual(t, revID, createDeterministicRevID(2, "1-def", false, body))
+}
@@ -242,6 +242,15 @@ func (tree RevTree) getParent(revid string) string {
 	return info.Parent
 }
 
+// Returns the IDs of all the revisions in the tree.
+func (tree RevTree) revIDs() []string {
+	revIDs := make([]string, 0, len(tree))
+	for revid := range tree {
+		revIDs = append(revIDs, revid)
+	}
+	return revIDs
+}
+
 // Returns the leaf revision IDs (those that have no children.)
 func (tree RevTree) GetLeaves() []string {
 	acceptAllLeavesFilter := func(revId string) bool {
@@ -0,0 +1,113 @@
+package db
+
+import (
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbase/sync_gateway/channels"
+)
+
+// The outcome of RepairSequenceCounter.
+type SequenceRepairResult struct {
+	Counter              uint64 `json:"counter"`                // The sequence counter before the repair
+	MaxDocSequence       uint64 `json:"max_doc_sequence"`       // Highest sequence of the docs ahead of the counter, or zero if there aren't any
+	MaxPrincipalSequence uint64 `json:"max_principal_sequence"` // Highest sequence of the users and roles
+	DocsAhead            int    `json:"docs_ahead"`             // Number of docs, users and roles whose sequences are ahead of the counter
+	NewCounter           uint64 `json:"new_counter"`            // The sequence counter after the repair, or that it would be set to in a preview
+	Repaired             bool   `json:"repaired"`
+}
+
+// Finds the highest sequence recorded in the database's docs and principals, and if it's ahead of the sequence
+// counter, such as after a bucket restore that rolled the counter back, fast-forwards the counter past it plus
+// margin, so that new writes don't reuse the sequences of existing docs.  The counter is never moved backwards.  If
+// preview is true, the counter is left alone.  The database should be offline, so that its change cache is rebuilt
+// from the repaired counter when it's brought back online.
+func (dbc *DatabaseContext) RepairSequenceCounter(margin uint64, preview bool) (*SequenceRepairResult, error) {
+	counter, err := dbc.LastSequence()
+	if err != nil {
+		return nil, err
+	}
+	result := &SequenceRepairResult{Counter: counter, NewCounter: counter}
+
+	// Only the docs with sequences above the counter can collide with new writes
+	results, err := dbc.QueryChannels(channels.UserStarChannel, counter+1, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var entry *LogEntry
+		var found bool
+		if dbc.Options.UseViews {
+			entry, found = nextChannelViewEntry(results)
+		} else {
+			entry, found = nextChannelQueryEntry(results)
+		}
+		if !found {
+			break
+		}
+		result.DocsAhead++
+		if entry.Sequence > result.MaxDocSequence {
+			result.MaxDocSequence = entry.Sequence
+		}
+	}
+	if err := results.Close(); err != nil {
+		return nil, err
+	}
+
+	// Users' and roles' sequences come from the same counter, but they aren't in the star channel
+	userNames, roleNames, err := dbc.AllPrincipalIDs()
+	if err != nil {
+		return nil, err
+	}
+	authenticator := dbc.Authenticator()
+	checkPrincipal := func(sequence uint64) {
+		if sequence > counter {
+			result.DocsAhead++
+		}
+		if sequence > result.MaxPrincipalSequence {
+			result.MaxPrincipalSequence = sequence
+		}
+	}
+	for _, name := range userNames {
+		user, err := authenticator.GetUser(name)
+		if err != nil {
+			return nil, err
+		} else if user != nil {
+			checkPrincipal(user.Sequence())
+		}
+	}
+	for _, name := range roleNames {
+		role, err := authenticator.GetRole(name)
+		if err != nil {
+			return nil, err
+		} else if role != nil {
+			checkPrincipal(role.Sequence())
+		}
+	}
+
+	maxSequence := result.MaxDocSequence
+	if result.MaxPrincipalSequence > maxSequence {
+		maxSequence = result.MaxPrincipalSequence
+	}
+	if maxSequence <= counter {
+		return result, nil
+	}
+
+	result.NewCounter = maxSequence + margin
+	if preview {
+		return result, nil
+	}
+	if result.NewCounter, err = dbc.raiseSequenceCounter(result.NewCounter); err != nil {
+		return nil, err
+	}
+	result.Repaired = true
+	base.Warnf(base.KeyAll, "Fast-forwarded the sequence counter of db %s from %d to %d, past the %d docs and principals ahead of it", base.MD(dbc.Name), counter, result.NewCounter, result.DocsAhead)
+	return result, nil
+}
+
+// Raises the sequence counter to the given sequence if it's lower, returning the counter's new value.
+func (dbc *DatabaseContext) raiseSequenceCounter(sequence uint64) (uint64, error) {
+	currentSeq, err := dbc.MetadataBucket.Incr(SyncSeqKey, 0, 0, 0)
+	if err != nil || sequence <= currentSeq {
+		return currentSeq, err
+	}
+	return dbc.MetadataBucket.Incr(SyncSeqKey, sequence-currentSeq, sequence, 0)
+}
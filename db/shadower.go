@@ -162,18 +162,18 @@ func (s *Shadower) PushRevision(doc *document) {
 
 	var err error
 	if doc.Flags&channels.Deleted != 0 {
-		base.Infof(base.KeyShadow, "Pushing %q, rev %q [deletion]", base.UD(doc.ID), doc.CurrentRev)
+		base.Infof(base.KeyShadow, "Pushing %q, rev %q [deletion]", base.UDDocID(doc.ID), doc.CurrentRev)
 		err = s.bucket.Delete(doc.ID)
 	} else {
-		base.Infof(base.KeyShadow, "Pushing %q, rev %q", base.UD(doc.ID), doc.CurrentRev)
+		base.Infof(base.KeyShadow, "Pushing %q, rev %q", base.UDDocID(doc.ID), doc.CurrentRev)
 		body := doc.getRevisionBody(doc.CurrentRev, s.context.RevisionBodyLoader)
 		if body == nil {
-			base.Warnf(base.KeyAll, "Can't get rev %q.%q to push to external bucket", base.UD(doc.ID), doc.CurrentRev)
+			base.Warnf(base.KeyAll, "Can't get rev %q.%q to push to external bucket", base.UDDocID(doc.ID), doc.CurrentRev)
 			return
 		}
 		err = s.bucket.Set(doc.ID, 0, body)
 	}
 	if err != nil {
-		base.Warnf(base.KeyAll, "Error pushing rev of %q to external bucket: %v", base.UD(doc.ID), err)
+		base.Warnf(base.KeyAll, "Error pushing rev of %q to external bucket: %v", base.UDDocID(doc.ID), err)
 	}
 }
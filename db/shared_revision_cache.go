@@ -0,0 +1,148 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"time"
+
+	"github.com/couchbase/sync_gateway/base"
+)
+
+// Default number of seconds revisions are kept in the shared revision cache
+const DefaultSharedRevCacheExpirySecs = uint32(300)
+
+type SharedRevCacheOptions struct {
+	Enabled       bool        // Whether revisions loaded from the bucket are cached for the database's other nodes
+	Bucket        base.Bucket // Separate bucket the revisions are cached in, such as a memcached bucket.  Required when enabled
+	ExpirySeconds uint32      // How long revisions are kept in the shared cache
+}
+
+// A second level of revision cache, shared by all of a database's nodes.  When a node's own revision cache misses,
+// the revision is looked up in the shared cache before the document is loaded from the bucket, so a hot document's
+// revision is only loaded and parsed once, by whichever node asks for it first.  Cached revisions are a fraction of
+// the size of their document, which also holds its revision tree and the bodies of conflicting revisions.  Entries
+// are keyed by revision ID, which a document can reuse once it's purged and created again, so purging a document
+// removes its revisions from the shared cache.  Entries also expire, which bounds how long a revision is served
+// after its document is removed other than by a purge, such as when it expires or is deleted by another client of
+// the bucket.
+type sharedRevisionCache struct {
+	bucket      base.Bucket
+	dbName      string
+	expiry      uint32
+	loaderFunc  RevisionCacheLoaderFunc // Loads the revisions that aren't in the shared cache
+	cacheHits   *expvar.Int
+	cacheMisses *expvar.Int
+}
+
+// A revision as stored in the shared cache.  The history is stored as its parts, so it's decoded to the same
+// types as encodeRevisions produces.
+type sharedRevCacheEntry struct {
+	Body         Body            `json:"body"`
+	HistoryStart int             `json:"history_start"`
+	HistoryIDs   []string        `json:"history_ids"`
+	Channels     base.Set        `json:"channels,omitempty"`
+	Attachments  AttachmentsMeta `json:"attachments,omitempty"`
+	Expiry       *time.Time      `json:"expiry,omitempty"`
+}
+
+func newSharedRevisionCache(bucket base.Bucket, dbName string, expiry uint32, loaderFunc RevisionCacheLoaderFunc, statsCache *expvar.Map) *sharedRevisionCache {
+	if expiry == 0 {
+		expiry = DefaultSharedRevCacheExpirySecs
+	}
+	return &sharedRevisionCache{
+		bucket:      bucket,
+		dbName:      dbName,
+		expiry:      expiry,
+		loaderFunc:  loaderFunc,
+		cacheHits:   statsCache.Get(base.StatKeySharedRevisionCacheHits).(*expvar.Int),
+		cacheMisses: statsCache.Get(base.StatKeySharedRevisionCacheMisses).(*expvar.Int),
+	}
+}
+
+// The key of a cached revision.  The db name is included so databases can share a cache bucket.
+func (c *sharedRevisionCache) key(id IDAndRev) string {
+	return fmt.Sprintf("_sync:revcache:%s:%s:%d:%s", c.dbName, id.DocID, len(id.RevID), id.RevID)
+}
+
+// A RevisionCacheLoaderFunc that returns the revision from the shared cache if it's there, and otherwise loads it
+// with the cache's loader function and adds it to the shared cache.
+func (c *sharedRevisionCache) load(id IDAndRev) (body Body, history Revisions, channels base.Set, attachments AttachmentsMeta, expiry *time.Time, err error) {
+	key := c.key(id)
+	if entry := c.get(key); entry != nil {
+		c.cacheHits.Add(1)
+		history = Revisions{RevisionsStart: entry.HistoryStart, RevisionsIds: entry.HistoryIDs}
+		return entry.Body, history, entry.Channels, entry.Attachments, entry.Expiry, nil
+	}
+	c.cacheMisses.Add(1)
+
+	body, history, channels, attachments, expiry, err = c.loaderFunc(id)
+	if err == nil && body != nil {
+		start, ids := splitRevisionList(history)
+		c.put(key, &sharedRevCacheEntry{
+			Body:         body,
+			HistoryStart: start,
+			HistoryIDs:   ids,
+			Channels:     channels,
+			Attachments:  attachments,
+			Expiry:       expiry,
+		})
+	}
+	return body, history, channels, attachments, expiry, err
+}
+
+// Removes the given revisions of a document from the shared cache, if they're there.  Failures are only logged, as
+// the entries expire anyway.
+func (c *sharedRevisionCache) remove(docid string, revids []string) {
+	if c == nil {
+		return
+	}
+	for _, revid := range revids {
+		key := c.key(IDAndRev{DocID: docid, RevID: revid})
+		if err := c.bucket.Delete(key); err != nil && !base.IsKeyNotFoundError(c.bucket, err) {
+			base.Warnf(base.KeyCache, "Unable to remove %q from shared revision cache - it will be kept until it expires: %v", base.UD(key), err)
+		}
+	}
+}
+
+// Returns the cached revision with the given key, or nil if it isn't cached or can't be read.
+func (c *sharedRevisionCache) get(key string) *sharedRevCacheEntry {
+	data, _, err := c.bucket.GetRaw(key)
+	if err != nil {
+		if !base.IsKeyNotFoundError(c.bucket, err) {
+			base.Warnf(base.KeyCache, "Unable to get %q from shared revision cache: %v", base.UD(key), err)
+		}
+		return nil
+	}
+	if len(data) > 0 && data[0] == nonJSONPrefix {
+		data = data[1:]
+	}
+	// Use a decoder to preserve large numbers, as when documents are unmarshalled
+	var entry sharedRevCacheEntry
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&entry); err != nil || entry.Body == nil {
+		base.Warnf(base.KeyCache, "Invalid entry %q in shared revision cache: %v", base.UD(key), err)
+		return nil
+	}
+	return &entry
+}
+
+// Adds a revision to the shared cache.  Failures are only logged, as the revision can still be loaded from its
+// document.
+func (c *sharedRevisionCache) put(key string, entry *sharedRevCacheEntry) {
+	if len(key) > 250 {
+		return // Longer than the bucket allows
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		base.Warnf(base.KeyCache, "Unable to marshal %q for shared revision cache: %v", base.UD(key), err)
+		return
+	}
+	// Prefix with non-JSON data, like old revision bodies, so N1QL ignores the cached revisions
+	data = append([]byte{nonJSONPrefix}, data...)
+	if err := c.bucket.SetRaw(key, c.expiry, base.BinaryDocument(data)); err != nil {
+		base.Warnf(base.KeyCache, "Unable to add %q to shared revision cache: %v", base.UD(key), err)
+	}
+}
@@ -0,0 +1,77 @@
+package db
+
+import (
+	"net/http"
+
+	"github.com/couchbase/sync_gateway/auth"
+	"github.com/couchbase/sync_gateway/base"
+)
+
+// SignupOptions configures self-service signup, which lets clients of the public API create their own users.
+type SignupOptions struct {
+	Enabled         bool
+	DefaultChannels base.Set // Admin channels of new users
+	DefaultRoles    []string // Admin roles of new users
+	RequireEmail    bool     // Whether new users have to give an email address
+	RequireApproval bool     // Whether new users are disabled until an admin enables them
+	RequestsPerSec  float64  // Signups allowed per second from each client IP address
+	Burst           uint32   // Max # of signups in a burst from each client IP address
+}
+
+// Creates a user for a client of the public API, with the signup options' default channels and roles.  The name and
+// email address mustn't belong to another user.  Returns whether the user has to be approved, by an admin enabling
+// it, before it can log in.
+func (dbc *DatabaseContext) Signup(name string, password string, email string) (pendingApproval bool, err error) {
+	options := dbc.Options.SignupOptions
+	if !options.Enabled {
+		return false, base.HTTPErrorf(http.StatusForbidden, "Signup is not enabled for this database")
+	}
+	if !auth.IsValidPrincipalName(name) {
+		return false, base.HTTPErrorf(http.StatusBadRequest, "Invalid name %q", name)
+	}
+	info := PrincipalConfig{
+		Name:              &name,
+		Password:          &password,
+		Email:             email,
+		ExplicitChannels:  options.DefaultChannels,
+		ExplicitRoleNames: options.DefaultRoles,
+		Disabled:          options.RequireApproval,
+	}
+	if isValid, reason := info.IsPasswordValid(false); !isValid {
+		return false, base.HTTPErrorf(http.StatusBadRequest, reason)
+	}
+	if email == "" && options.RequireEmail {
+		return false, base.HTTPErrorf(http.StatusBadRequest, "Missing email")
+	}
+	if email != "" && !auth.IsValidEmail(email) {
+		return false, base.HTTPErrorf(http.StatusBadRequest, "Invalid email address")
+	}
+
+	// The name has to be checked before the email address is claimed for it
+	authenticator := dbc.Authenticator()
+	if existing, err := authenticator.GetUser(name); err != nil {
+		return false, err
+	} else if existing != nil {
+		return false, base.HTTPErrorf(http.StatusConflict, "User %q already exists", name)
+	}
+	if email != "" {
+		claimed, err := authenticator.ClaimUserEmail(email, name)
+		if err != nil {
+			return false, err
+		}
+		if !claimed {
+			return false, base.HTTPErrorf(http.StatusConflict, "Email address is already registered")
+		}
+	}
+
+	if _, err := dbc.UpdatePrincipal(info, true, false); err != nil {
+		if email != "" {
+			if releaseErr := authenticator.ReleaseUserEmail(email, name); releaseErr != nil {
+				base.Warnf(base.KeyAuth, "Couldn't release email address claimed by user %q: %v", base.UDUsername(name), releaseErr)
+			}
+		}
+		return false, err
+	}
+	base.Infof(base.KeyAuth, "User %q signed up to db %q (pending approval: %t)", base.UDUsername(name), base.MD(dbc.Name), options.RequireApproval)
+	return options.RequireApproval, nil
+}
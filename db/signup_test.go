@@ -0,0 +1,61 @@
+package db
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignup(t *testing.T) {
+	db, testBucket := setupTestDB(t)
+	defer testBucket.Close()
+	defer tearDownTestDB(t, db)
+
+	_, err := db.Signup("alice", "letmein", "")
+	assertHTTPError(t, err, http.StatusForbidden)
+
+	db.Options.SignupOptions = SignupOptions{
+		Enabled:         true,
+		DefaultChannels: base.SetOf("public"),
+		DefaultRoles:    []string{"member"},
+		RequireEmail:    true,
+	}
+	_, err = db.Signup("alice", "letmein", "")
+	assertHTTPError(t, err, http.StatusBadRequest)
+	_, err = db.Signup("alice", "", "alice@example.com")
+	assertHTTPError(t, err, http.StatusBadRequest)
+	_, err = db.Signup("alice", "letmein", "not an address")
+	assertHTTPError(t, err, http.StatusBadRequest)
+
+	pendingApproval, err := db.Signup("alice", "letmein", "alice@example.com")
+	assert.NoError(t, err)
+	assert.False(t, pendingApproval)
+	user, err := db.Authenticator().GetUser("alice")
+	assert.NoError(t, err)
+	assert.Equal(t, "alice@example.com", user.Email())
+	assert.True(t, user.ExplicitChannels().Contains("public"))
+	assert.True(t, user.ExplicitRoles().Contains("member"))
+	assert.False(t, user.Disabled())
+
+	// Names and email addresses can't be reused
+	_, err = db.Signup("alice", "letmein", "alice2@example.com")
+	assertHTTPError(t, err, http.StatusConflict)
+	_, err = db.Signup("bob", "letmein", "alice@example.com")
+	assertHTTPError(t, err, http.StatusConflict)
+
+	// Until they're approved, new users are disabled
+	db.Options.SignupOptions.RequireApproval = true
+	pendingApproval, err = db.Signup("bob", "letmein", "bob@example.com")
+	assert.NoError(t, err)
+	assert.True(t, pendingApproval)
+	user, err = db.Authenticator().GetUser("bob")
+	assert.NoError(t, err)
+	assert.True(t, user.Disabled())
+
+	// Once a user's deleted, its email address is free
+	assert.NoError(t, db.DeletePrincipal(user))
+	_, err = db.Signup("carol", "letmein", "bob@example.com")
+	assert.NoError(t, err)
+}
@@ -13,10 +13,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/couchbase/sync_gateway/base"
 )
 
+// Property of stored local docs recording when they were last updated, so that the checkpoints of clients that have
+// gone away can be found.  It's not returned to clients, and clients can't set it, as properties with a leading
+// underscore are stripped.
+const localDocUpdatedAt = "_updated_at"
+
 func (db *Database) GetSpecial(doctype string, docid string) (Body, error) {
 	key := db.realSpecialDocID(doctype, docid)
 	if key == "" {
@@ -26,7 +32,7 @@ func (db *Database) GetSpecial(doctype string, docid string) (Body, error) {
 	body := Body{}
 
 	if doctype == "local" && db.DatabaseContext.Options.LocalDocExpirySecs > 0 {
-		rawDocBytes, _, err := db.Bucket.GetAndTouchRaw(key, base.SecondsToCbsExpiry(int(db.DatabaseContext.Options.LocalDocExpirySecs)))
+		rawDocBytes, _, err := db.MetadataBucket.GetAndTouchRaw(key, base.SecondsToCbsExpiry(int(db.DatabaseContext.Options.LocalDocExpirySecs)))
 		if err != nil {
 			return nil, err
 		}
@@ -34,11 +40,12 @@ func (db *Database) GetSpecial(doctype string, docid string) (Body, error) {
 			return nil, err
 		}
 	} else {
-		if _, err := db.Bucket.Get(key, &body); err != nil {
+		if _, err := db.MetadataBucket.Get(key, &body); err != nil {
 			return nil, err
 		}
 	}
 
+	delete(body, localDocUpdatedAt)
 	return body, nil
 }
 
@@ -58,7 +65,7 @@ func (db *Database) putSpecial(doctype string, docid string, matchRev string, bo
 	if expPresent && expiry == 0 && doctype == "local" {
 		expiry = uint32(base.SecondsToCbsExpiry(int(db.DatabaseContext.Options.LocalDocExpirySecs)))
 	}
-	_, err = db.Bucket.Update(key, expiry, func(value []byte) ([]byte, *uint32, error) {
+	_, err = db.MetadataBucket.Update(key, expiry, func(value []byte) ([]byte, *uint32, error) {
 		if len(value) == 0 {
 			if matchRev != "" || body == nil {
 				return nil, nil, base.HTTPErrorf(http.StatusNotFound, "No previous revision to replace")
@@ -81,6 +88,9 @@ func (db *Database) putSpecial(doctype string, docid string, matchRev string, bo
 			}
 			revid = fmt.Sprintf("0-%d", generation+1)
 			body[BodyRev] = revid
+			if doctype == "local" {
+				body[localDocUpdatedAt] = time.Now().UTC().Format(time.RFC3339)
+			}
 			bodyBytes, marshalErr := json.Marshal(body)
 			return bodyBytes, nil, marshalErr
 		} else {
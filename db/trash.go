@@ -0,0 +1,263 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/couchbase/sync_gateway/base"
+)
+
+// Keys of the trash, in the metadata bucket.  Each purged document is copied to the trash key prefix plus its doc ID,
+// and listed in one of the index docs.  The index is split by the day documents were purged, so that each day's index
+// doc expires once its entries have, and into shards by doc ID, so that concurrent purges don't all update the same
+// doc.
+const (
+	kTrashKeyPrefix         = KSyncKeyPrefix + "trash:"
+	kTrashIndexKeyPrefix    = KSyncKeyPrefix + "trashIndex:"
+	kTrashIndexShards       = 16
+	kTrashIndexKeyDayFormat = "20060102"
+)
+
+// Default number of days purged documents are kept in the trash
+const DefaultTrashRetentionDays = 7
+
+type TrashOptions struct {
+	Enabled   bool          // Whether purged documents are moved to the trash
+	Retention time.Duration // How long purged documents are kept before they're deleted for good
+}
+
+// TrashEntry describes a purged document held in the trash.
+type TrashEntry struct {
+	DocID    string    `json:"id"`
+	PurgedAt time.Time `json:"purged_at"`
+	Expires  time.Time `json:"expires"` // When the document is deleted for good
+}
+
+// The copy of a purged document kept in the trash, as it was stored in the bucket.
+type trashedDoc struct {
+	TrashEntry
+	Body  []byte `json:"body"`            // Raw document, including its sync metadata unless it's in an xattr
+	Xattr []byte `json:"xattr,omitempty"` // Raw sync xattr, if xattrs are enabled
+}
+
+type trashIndexDoc struct {
+	Entries map[string]*TrashEntry `json:"entries"` // Documents in the trash, by doc ID
+}
+
+func trashKey(docID string) string {
+	return kTrashKeyPrefix + docID
+}
+
+// The key of the index doc listing a document purged at the given time.
+func trashIndexKey(purgedAt time.Time, docID string) string {
+	shard := crc32.ChecksumIEEE([]byte(docID)) % kTrashIndexShards
+	return fmt.Sprintf("%s%s:%d", kTrashIndexKeyPrefix, purgedAt.UTC().Format(kTrashIndexKeyDayFormat), shard)
+}
+
+// Copies a document to the trash before it's purged.  Only live documents are kept, as purging a tombstone loses
+// nothing worth restoring.  Returns an error if the copy can't be made, so that the document isn't purged without it.
+func (db *Database) moveToTrash(key string) error {
+	var doc *document
+	var rawBody, rawXattr []byte
+	var err error
+	if db.UseXattrs() {
+		if _, err = db.Bucket.GetWithXattr(key, KSyncXattrName, &rawBody, &rawXattr); err != nil {
+			return err
+		}
+		doc, err = unmarshalDocumentWithXattr(key, rawBody, rawXattr, 0, DocUnmarshalSync)
+	} else {
+		if rawBody, _, err = db.Bucket.GetRaw(key); err != nil {
+			return err
+		}
+		doc, err = unmarshalDocument(key, rawBody)
+	}
+	if err != nil {
+		return err
+	}
+	if !doc.isLive() {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	entry := TrashEntry{DocID: key, PurgedAt: now, Expires: now.Add(db.Options.TrashOptions.Retention)}
+	trashed, err := json.Marshal(trashedDoc{TrashEntry: entry, Body: rawBody, Xattr: rawXattr})
+	if err != nil {
+		return err
+	}
+	if err = db.MetadataBucket.SetRaw(trashKey(key), base.DurationToCbsExpiry(db.Options.TrashOptions.Retention), trashed); err != nil {
+		return err
+	}
+	if err = db.updateTrashIndex(trashIndexKey(now, key), func(entries map[string]*TrashEntry) { entries[key] = &entry }); err != nil {
+		return err
+	}
+	base.Infof(base.KeyCRUD, "Moved purged document %s to the trash", base.UD(key))
+	return nil
+}
+
+// Applies a change to an index doc of the trash, removing the documents whose retention has expired.  The doc's expiry
+// is pushed back past the retention of the documents purged on its day, which could be added to it up to a day later.
+func (db *DatabaseContext) updateTrashIndex(indexKey string, change func(entries map[string]*TrashEntry)) error {
+	expiry := base.DurationToCbsExpiry(db.Options.TrashOptions.Retention + 48*time.Hour)
+	_, err := db.MetadataBucket.Update(indexKey, expiry, func(currentValue []byte) ([]byte, *uint32, error) {
+		var index trashIndexDoc
+		if len(currentValue) > 0 {
+			if err := json.Unmarshal(currentValue, &index); err != nil {
+				return nil, nil, err
+			}
+		}
+		if index.Entries == nil {
+			index.Entries = map[string]*TrashEntry{}
+		}
+		now := time.Now()
+		for docID, entry := range index.Entries {
+			if entry.Expires.Before(now) {
+				delete(index.Entries, docID)
+			}
+		}
+		change(index.Entries)
+		updated, err := json.Marshal(index)
+		return updated, nil, err
+	})
+	return err
+}
+
+// Returns the documents in the trash, most recently purged first.  Reads the index docs of every day within the
+// retention period.
+func (db *DatabaseContext) TrashEntries() ([]*TrashEntry, error) {
+	now := time.Now()
+	days := int(db.Options.TrashOptions.Retention/(24*time.Hour)) + 1
+	keys := make([]string, 0, (days+1)*kTrashIndexShards)
+	for day := 0; day <= days; day++ {
+		dayKey := kTrashIndexKeyPrefix + now.UTC().AddDate(0, 0, -day).Format(kTrashIndexKeyDayFormat)
+		for shard := 0; shard < kTrashIndexShards; shard++ {
+			keys = append(keys, fmt.Sprintf("%s:%d", dayKey, shard))
+		}
+	}
+	indexDocs, err := db.MetadataBucket.GetBulkRaw(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []*TrashEntry{}
+	for key, data := range indexDocs {
+		var index trashIndexDoc
+		if err := json.Unmarshal(data, &index); err != nil {
+			base.Warnf(base.KeyAll, "Invalid trash index doc %s: %v", base.MD(key), err)
+			continue
+		}
+		for _, entry := range index.Entries {
+			if entry.Expires.After(now) {
+				entries = append(entries, entry)
+			}
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].PurgedAt.After(entries[j].PurgedAt) })
+	return entries, nil
+}
+
+// Restores a document from the trash as it was when it was purged, with its revision history.  It's given a new
+// sequence, so that it reaches the channel caches and changes feeds, and clients that don't still have it pull it
+// again.  Fails with a 404 if the document isn't in the trash, and a 409 if a document with its ID has been created
+// since it was purged.
+func (db *Database) RestoreFromTrash(docID string) error {
+	var trashed trashedDoc
+	if _, err := db.MetadataBucket.Get(trashKey(docID), &trashed); err != nil {
+		if base.IsDocNotFoundError(err) {
+			return base.HTTPErrorf(http.StatusNotFound, "Document isn't in the trash")
+		}
+		return err
+	}
+	if trashed.Expires.Before(time.Now()) {
+		return base.HTTPErrorf(http.StatusNotFound, "Document isn't in the trash")
+	}
+
+	var doc *document
+	var err error
+	if db.UseXattrs() {
+		if len(trashed.Xattr) == 0 {
+			return base.HTTPErrorf(http.StatusConflict, "Document was purged without xattrs, so can't be restored while they're enabled")
+		}
+		doc, err = unmarshalDocumentWithXattr(docID, trashed.Body, trashed.Xattr, 0, DocUnmarshalAll)
+	} else {
+		doc, err = unmarshalDocument(docID, trashed.Body)
+	}
+	if err != nil {
+		return err
+	}
+
+	// A live document counts against the quota again, as purging it had removed it, so it needs room under the quota
+	docQuotaReserved := false
+	if doc.isLive() {
+		if docQuotaReserved, err = db.quotas.reserveDoc(); err != nil {
+			return err
+		}
+	}
+	if db.writeSequences() {
+		if doc.Sequence, err = db.sequences.nextSequence(); err != nil {
+			if docQuotaReserved {
+				db.quotas.recordLiveDocs(-1)
+			}
+			return err
+		}
+		doc.RecentSequences = append(doc.RecentSequences, doc.Sequence)
+	}
+	if err = db.writeRestoredDoc(doc); err != nil {
+		if db.writeSequences() {
+			db.sequences.releaseSequence(doc.Sequence)
+		}
+		if docQuotaReserved {
+			db.quotas.recordLiveDocs(-1)
+		}
+		return err
+	}
+
+	// The document counts against the channel sizes again too
+	if db.channelSizes != nil {
+		if doc, err := db.GetDocument(docID, DocUnmarshalSync); err == nil && doc.isLive() {
+			if bodyJSON, err := doc.MarshalBody(); err == nil {
+				db.channelSizes.recordUpdate(nil, 0, doc.liveChannels(), len(bodyJSON))
+			}
+		}
+	}
+
+	if err := db.MetadataBucket.Delete(trashKey(docID)); err != nil {
+		base.Warnf(base.KeyAll, "Unable to remove restored document %s from the trash: %v", base.UD(docID), err)
+	}
+	if err := db.updateTrashIndex(trashIndexKey(trashed.PurgedAt, docID), func(entries map[string]*TrashEntry) { delete(entries, docID) }); err != nil {
+		base.Warnf(base.KeyAll, "Unable to remove restored document %s from the trash index: %v", base.UD(docID), err)
+	}
+	base.Infof(base.KeyCRUD, "Restored purged document %s from the trash", base.UD(docID))
+	return nil
+}
+
+// Adds a document restored from the trash to the bucket.  Fails with a 409 if a document with its ID exists.
+func (db *Database) writeRestoredDoc(doc *document) error {
+	if db.UseXattrs() {
+		body, xattr, err := doc.MarshalWithXattr()
+		if err != nil {
+			return err
+		}
+		if _, err = db.Bucket.WriteCasWithXattr(doc.ID, KSyncXattrName, 0, 0, json.RawMessage(body), json.RawMessage(xattr)); err != nil {
+			if base.IsCasMismatch(err) {
+				return base.HTTPErrorf(http.StatusConflict, "Document exists")
+			}
+			return err
+		}
+		return nil
+	}
+	data, err := db.marshalDocument(doc)
+	if err != nil {
+		return err
+	}
+	added, err := db.Bucket.AddRaw(doc.ID, 0, data)
+	if err != nil {
+		return err
+	} else if !added {
+		return base.HTTPErrorf(http.StatusConflict, "Document exists")
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package db
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	DefaultUsageStatsWindow = time.Hour // Default rolling window over which usage stats are tracked
+	usageStatsNumSlots      = 60        // Number of slots the rolling window is divided into
+)
+
+type UsageStatsOptions struct {
+	Enabled bool          // Whether per-user and per-channel usage is tracked
+	Window  time.Duration // Rolling window usage is tracked over
+}
+
+// UserUsage holds the replication volume for a single user.
+type UserUsage struct {
+	DocsPulled            int64 `json:"docs_pulled"`
+	DocsPushed            int64 `json:"docs_pushed"`
+	BytesPulled           int64 `json:"bytes_pulled"`
+	BytesPushed           int64 `json:"bytes_pushed"`
+	AttachmentBytesPulled int64 `json:"attachment_bytes_pulled"`
+	AttachmentBytesPushed int64 `json:"attachment_bytes_pushed"`
+}
+
+func (u *UserUsage) add(other *UserUsage) {
+	u.DocsPulled += other.DocsPulled
+	u.DocsPushed += other.DocsPushed
+	u.BytesPulled += other.BytesPulled
+	u.BytesPushed += other.BytesPushed
+	u.AttachmentBytesPulled += other.AttachmentBytesPulled
+	u.AttachmentBytesPushed += other.AttachmentBytesPushed
+}
+
+// ChannelUsage holds the activity for a single channel.
+type ChannelUsage struct {
+	Changes int64 `json:"changes"` // Number of revisions added to the channel
+}
+
+// UsageSnapshot is the usage summed over the rolling window.
+type UsageSnapshot struct {
+	WindowSecs int64                    `json:"window_secs"`
+	Users      map[string]*UserUsage    `json:"users"`
+	Channels   map[string]*ChannelUsage `json:"channels"`
+}
+
+// A single interval of the rolling window.
+type usageSlot struct {
+	start    time.Time
+	users    map[string]*UserUsage
+	channels map[string]*ChannelUsage
+}
+
+// UsageStats tracks per-user replication volume and per-channel activity over a rolling window.  The window is
+// divided into a fixed number of slots, and usage older than the window is discarded as slots are reused.
+// All methods are safe to call on a nil *UsageStats, which tracks nothing.
+type UsageStats struct {
+	lock         sync.Mutex
+	window       time.Duration
+	slotDuration time.Duration
+	slots        [usageStatsNumSlots]usageSlot
+}
+
+func NewUsageStats(window time.Duration) *UsageStats {
+	if window <= 0 {
+		window = DefaultUsageStatsWindow
+	}
+	slotDuration := window / usageStatsNumSlots
+	if slotDuration <= 0 {
+		slotDuration = 1
+	}
+	return &UsageStats{window: window, slotDuration: slotDuration}
+}
+
+// Returns the slot for the current time, resetting it if it last held usage from an earlier window.
+// Requires the lock to be held.
+func (s *UsageStats) _currentSlot(now time.Time) *usageSlot {
+	start := now.Truncate(s.slotDuration)
+	slot := &s.slots[(start.UnixNano()/int64(s.slotDuration))%usageStatsNumSlots]
+	if !slot.start.Equal(start) {
+		*slot = usageSlot{
+			start:    start,
+			users:    map[string]*UserUsage{},
+			channels: map[string]*ChannelUsage{},
+		}
+	}
+	return slot
+}
+
+// Calls f with the current usage for the given user, for f to update.  Usage with an empty username isn't tracked.
+func (s *UsageStats) updateUser(username string, f func(usage *UserUsage)) {
+	if s == nil || username == "" {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	slot := s._currentSlot(time.Now())
+	usage, ok := slot.users[username]
+	if !ok {
+		usage = &UserUsage{}
+		slot.users[username] = usage
+	}
+	f(usage)
+}
+
+// AddDocPulled records a revision of the given size sent to the user.
+func (s *UsageStats) AddDocPulled(username string, bytes int) {
+	s.updateUser(username, func(usage *UserUsage) {
+		usage.DocsPulled++
+		usage.BytesPulled += int64(bytes)
+	})
+}
+
+// AddDocPushed records a revision of the given size received from the user.
+func (s *UsageStats) AddDocPushed(username string, bytes int) {
+	s.updateUser(username, func(usage *UserUsage) {
+		usage.DocsPushed++
+		usage.BytesPushed += int64(bytes)
+	})
+}
+
+// AddAttachmentPulled records an attachment of the given size sent to the user.
+func (s *UsageStats) AddAttachmentPulled(username string, bytes int) {
+	s.updateUser(username, func(usage *UserUsage) {
+		usage.AttachmentBytesPulled += int64(bytes)
+	})
+}
+
+// AddAttachmentPushed records an attachment of the given size received from the user.
+func (s *UsageStats) AddAttachmentPushed(username string, bytes int) {
+	s.updateUser(username, func(usage *UserUsage) {
+		usage.AttachmentBytesPushed += int64(bytes)
+	})
+}
+
+// AddChannelChange records a revision being added to the given channel.
+func (s *UsageStats) AddChannelChange(channelName string) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	slot := s._currentSlot(time.Now())
+	usage, ok := slot.channels[channelName]
+	if !ok {
+		usage = &ChannelUsage{}
+		slot.channels[channelName] = usage
+	}
+	usage.Changes++
+}
+
+// Snapshot returns the usage summed over the rolling window.
+func (s *UsageStats) Snapshot() *UsageSnapshot {
+	snapshot := &UsageSnapshot{
+		Users:    map[string]*UserUsage{},
+		Channels: map[string]*ChannelUsage{},
+	}
+	if s == nil {
+		return snapshot
+	}
+	snapshot.WindowSecs = int64(s.window / time.Second)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	cutoff := time.Now().Add(-s.window)
+	for i := range s.slots {
+		slot := &s.slots[i]
+		if slot.start.IsZero() || !slot.start.After(cutoff) {
+			continue
+		}
+		for username, usage := range slot.users {
+			total, ok := snapshot.Users[username]
+			if !ok {
+				total = &UserUsage{}
+				snapshot.Users[username] = total
+			}
+			total.add(usage)
+		}
+		for channelName, usage := range slot.channels {
+			total, ok := snapshot.Channels[channelName]
+			if !ok {
+				total = &ChannelUsage{}
+				snapshot.Channels[channelName] = total
+			}
+			total.Changes += usage.Changes
+		}
+	}
+	return snapshot
+}
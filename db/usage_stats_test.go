@@ -0,0 +1,56 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUsageStats(t *testing.T) {
+	stats := NewUsageStats(time.Hour)
+
+	stats.AddDocPulled("alice", 100)
+	stats.AddDocPulled("alice", 50)
+	stats.AddDocPushed("alice", 20)
+	stats.AddAttachmentPulled("alice", 1000)
+	stats.AddAttachmentPushed("bob", 2000)
+	stats.AddDocPulled("", 10) // Untracked
+	stats.AddChannelChange("ABC")
+	stats.AddChannelChange("ABC")
+	stats.AddChannelChange("DEF")
+
+	snapshot := stats.Snapshot()
+	assert.Equal(t, int64(3600), snapshot.WindowSecs)
+	assert.Len(t, snapshot.Users, 2)
+	assert.Equal(t, UserUsage{DocsPulled: 2, BytesPulled: 150, DocsPushed: 1, BytesPushed: 20, AttachmentBytesPulled: 1000}, *snapshot.Users["alice"])
+	assert.Equal(t, UserUsage{AttachmentBytesPushed: 2000}, *snapshot.Users["bob"])
+	assert.Equal(t, int64(2), snapshot.Channels["ABC"].Changes)
+	assert.Equal(t, int64(1), snapshot.Channels["DEF"].Changes)
+}
+
+func TestUsageStatsWindowExpiry(t *testing.T) {
+	stats := NewUsageStats(60 * time.Millisecond)
+
+	stats.AddDocPulled("alice", 100)
+	stats.AddChannelChange("ABC")
+	assert.Len(t, stats.Snapshot().Users, 1)
+	assert.Len(t, stats.Snapshot().Channels, 1)
+
+	// Usage older than the window is no longer reported
+	time.Sleep(100 * time.Millisecond)
+	stats.AddDocPulled("bob", 100)
+	snapshot := stats.Snapshot()
+	assert.Len(t, snapshot.Users, 1)
+	assert.Contains(t, snapshot.Users, "bob")
+	assert.Len(t, snapshot.Channels, 0)
+}
+
+func TestUsageStatsNil(t *testing.T) {
+	var stats *UsageStats
+	stats.AddDocPulled("alice", 100)
+	stats.AddChannelChange("ABC")
+	snapshot := stats.Snapshot()
+	assert.Len(t, snapshot.Users, 0)
+	assert.Len(t, snapshot.Channels, 0)
+}
@@ -0,0 +1,181 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	sgbucket "github.com/couchbase/sg-bucket"
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/robertkrimen/otto"
+)
+
+// Wraps a user function so that it can throw({forbidden:...}) or throw({unauthorized:...}) to fail the request,
+// as the sync function can.  A document operation's error that the function doesn't catch fails the request with
+// the operation's status.
+const userFunctionWrapper = `
+	function() {
+		var fn = %s;
+		return function(args, userCtx) {
+			try {
+				return fn(args, userCtx);
+			} catch(x) {
+				if (x && x.forbidden)
+					reject(403, x.forbidden);
+				else if (x && x.unauthorized)
+					reject(401, x.unauthorized);
+				else if (x instanceof Error && x.status)
+					reject(x.status, x.message);
+				else
+					throw(x);
+			}
+		}
+	}()`
+
+// An admin-defined JavaScript function(args, user) that clients call by name, through POST /db/_function/{name}.
+// The function can read and write documents with getDoc(docid), putDoc(docid, body) and deleteDoc(docid, revid),
+// which act on behalf of the calling user and run the sync function as usual.  They throw an Error with the HTTP
+// status as its status property if they fail.  The user has the same name, roles
+// and channels properties as the sync function's user, and is null when the function's called through the admin API.
+type UserFunction struct {
+	*sgbucket.JSServer
+}
+
+func NewUserFunction(fnSource string) *UserFunction {
+
+	base.Debugf(base.KeyCRUD, "Creating new UserFunction")
+	return &UserFunction{
+		JSServer: sgbucket.NewJSServer(fnSource, kTaskCacheSize,
+			func(fnSource string) (sgbucket.JSServerTask, error) {
+				return newUserFunctionRunner(fnSource)
+			}),
+	}
+}
+
+// ValidateUserFunction compiles the given user function source, returning any compilation error.
+func ValidateUserFunction(fnSource string) error {
+	_, err := newUserFunctionRunner(fnSource)
+	return err
+}
+
+// Runs a user function.  Not thread-safe; each call gets the Database it acts on as its first argument, since the
+// runners are shared by all of the function's callers.
+type userFunctionRunner struct {
+	sgbucket.JSRunner
+	db        *Database // The database of the call in progress
+	rejection error     // Error passed to reject() during the call
+}
+
+func newUserFunctionRunner(funcSource string) (*userFunctionRunner, error) {
+	runner := &userFunctionRunner{}
+	if err := runner.Init(fmt.Sprintf(userFunctionWrapper, funcSource)); err != nil {
+		return nil, err
+	}
+
+	// Implementation of the 'getDoc()' callback, which returns null if the document doesn't exist:
+	runner.DefineNativeFunction("getDoc", func(call otto.FunctionCall) otto.Value {
+		body, err := runner.db.Get(call.Argument(0).String())
+		if base.IsDocNotFoundError(err) {
+			return otto.NullValue()
+		}
+		return runner.result(call, body, err)
+	})
+
+	// Implementation of the 'putDoc()' callback, which returns the new revision ID.  Updates of existing documents
+	// have to give the current revision ID as the body's _rev property.
+	runner.DefineNativeFunction("putDoc", func(call otto.FunctionCall) otto.Value {
+		body, ok := exportBody(call.Argument(1))
+		if !ok {
+			return runner.result(call, nil, base.HTTPErrorf(http.StatusBadRequest, "putDoc() needs a document body"))
+		}
+		newRevID, err := runner.db.Put(call.Argument(0).String(), body)
+		return runner.result(call, newRevID, err)
+	})
+
+	// Implementation of the 'deleteDoc()' callback, which returns the revision ID of the deletion:
+	runner.DefineNativeFunction("deleteDoc", func(call otto.FunctionCall) otto.Value {
+		newRevID, err := runner.db.DeleteDoc(call.Argument(0).String(), call.Argument(1).String())
+		return runner.result(call, newRevID, err)
+	})
+
+	// Implementation of the 'reject()' callback:
+	runner.DefineNativeFunction("reject", func(call otto.FunctionCall) otto.Value {
+		if runner.rejection == nil {
+			if status, err := call.Argument(0).ToInteger(); err == nil && status >= 400 {
+				var message string
+				if len(call.ArgumentList) > 1 {
+					message = call.Argument(1).String()
+				}
+				runner.rejection = base.HTTPErrorf(int(status), message)
+			}
+		}
+		return otto.UndefinedValue()
+	})
+
+	runner.Before = func() {
+		runner.rejection = nil
+	}
+	runner.After = func(result otto.Value, err error) (interface{}, error) {
+		rejection := runner.rejection
+		runner.db, runner.rejection = nil, nil
+		if rejection != nil {
+			return nil, rejection
+		}
+		if err != nil {
+			base.Warnf(base.KeyAll, "Error calling user function: %v", err)
+			return nil, base.HTTPErrorf(http.StatusInternalServerError, "Error calling function")
+		}
+		nativeValue, _ := result.Export()
+		return nativeValue, nil
+	}
+	return runner, nil
+}
+
+// Calls the function with the Database and its JavaScript arguments.
+func (runner *userFunctionRunner) Call(inputs ...interface{}) (interface{}, error) {
+	runner.db = inputs[0].(*Database)
+	return runner.JSRunner.Call(inputs[1:]...)
+}
+
+// Converts the result of a document operation to a JavaScript value.  If the operation failed, its error is thrown,
+// so that the function stops unless it catches it.
+func (runner *userFunctionRunner) result(call otto.FunctionCall, value interface{}, err error) otto.Value {
+	var jsValue otto.Value
+	if err == nil {
+		var valueJSON []byte
+		if valueJSON, err = json.Marshal(value); err == nil {
+			jsValue, err = call.Otto.Call("JSON.parse", nil, string(valueJSON))
+		}
+	}
+	if err != nil {
+		status, message := base.ErrorAsHTTPStatus(err)
+		jsErr := call.Otto.MakeCustomError("Error", message)
+		_ = jsErr.Object().Set("status", status)
+		panic(jsErr)
+	}
+	return jsValue
+}
+
+// Exports a JavaScript object as a document body.
+func exportBody(value otto.Value) (Body, bool) {
+	if !value.IsObject() {
+		return nil, false
+	}
+	exported, err := value.Export()
+	if err != nil {
+		return nil, false
+	}
+	body, ok := exported.(map[string]interface{})
+	return body, ok
+}
+
+// Calls the named user function with the given JSON arguments, on behalf of the database's user.  Returns the
+// function's result.
+func (db *Database) CallUserFunction(name string, argsJSON []byte) (interface{}, error) {
+	function, ok := db.Options.UserFunctions[name]
+	if !ok {
+		return nil, base.HTTPErrorf(http.StatusNotFound, "No such function %q", name)
+	}
+	base.Debugf(base.KeyCRUD, "Calling user function %q", base.MD(name))
+	return function.Call(db, sgbucket.JSONString(argsJSON), makeUserCtx(db.user))
+}
@@ -0,0 +1,90 @@
+package db
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/couchbase/sync_gateway/channels"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserFunctions(t *testing.T) {
+	db, testBucket := setupTestDB(t)
+	defer testBucket.Close()
+	defer tearDownTestDB(t, db)
+
+	db.ChannelMapper = channels.NewChannelMapper(`function(doc) {channel(doc.channel);}`)
+	db.Options.UserFunctions = map[string]*UserFunction{
+		"increment": NewUserFunction(`function(args, user) {
+			var doc = getDoc(args.docid) || {channel: "counters", count: 0};
+			doc.count++;
+			doc.updatedBy = user ? user.name : "admin";
+			putDoc(args.docid, doc);
+			return doc.count;
+		}`),
+		"forbidden": NewUserFunction(`function(args, user) {throw({forbidden: "nope"});}`),
+		"broken":    NewUserFunction(`function(args, user) {return args.missing.property;}`),
+		"caught": NewUserFunction(`function(args, user) {
+			try {
+				putDoc(args.docid, {count: 0});
+			} catch(x) {
+				return "conflict";
+			}
+		}`),
+		"rethrown": NewUserFunction(`function(args, user) {
+			try {
+				putDoc(args.docid, {count: 0});
+			} catch(x) {
+				if (x.status != 409) throw(x);
+			}
+			return args.missing.property;
+		}`),
+	}
+
+	result, err := db.CallUserFunction("increment", []byte(`{"docid":"counter"}`))
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, result)
+	result, err = db.CallUserFunction("increment", []byte(`{"docid":"counter"}`))
+	assert.NoError(t, err)
+	assert.EqualValues(t, 2, result)
+	body, err := db.Get("counter")
+	assert.NoError(t, err)
+	assert.Equal(t, "admin", body["updatedBy"])
+
+	_, err = db.CallUserFunction("missing", []byte(`null`))
+	assertHTTPError(t, err, http.StatusNotFound)
+	_, err = db.CallUserFunction("forbidden", []byte(`null`))
+	assertHTTPError(t, err, http.StatusForbidden)
+	_, err = db.CallUserFunction("broken", []byte(`{}`))
+	assertHTTPError(t, err, http.StatusInternalServerError)
+
+	// Errors of document operations fail the call, unless the function catches them
+	result, err = db.CallUserFunction("caught", []byte(`{"docid":"counter"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "conflict", result)
+
+	// A caught error doesn't become the status of a later, unrelated error
+	_, err = db.CallUserFunction("rethrown", []byte(`{"docid":"counter"}`))
+	assertHTTPError(t, err, http.StatusInternalServerError)
+
+	// Functions act on behalf of the calling user
+	authenticator := db.Authenticator()
+	user, err := authenticator.NewUser("alice", "letmein", nil)
+	assert.NoError(t, err)
+	assert.NoError(t, authenticator.Save(user))
+	userDB, err := GetDatabase(db.DatabaseContext, user)
+	assert.NoError(t, err)
+	_, err = userDB.CallUserFunction("increment", []byte(`{"docid":"counter"}`))
+	assertHTTPError(t, err, http.StatusForbidden)
+
+	user.SetExplicitChannels(channels.TimedSet{"counters": channels.NewVbSimpleSequence(1)})
+	assert.NoError(t, authenticator.Save(user))
+	userDB, err = GetDatabase(db.DatabaseContext, user)
+	assert.NoError(t, err)
+	result, err = userDB.CallUserFunction("increment", []byte(`{"docid":"counter"}`))
+	assert.NoError(t, err)
+	assert.EqualValues(t, 3, result)
+	body, err = db.Get("counter")
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", body["updatedBy"])
+}
@@ -1,6 +1,7 @@
 package db
 
 import (
+	"bytes"
 	"net/http"
 
 	"github.com/couchbase/sync_gateway/auth"
@@ -21,6 +22,7 @@ type PrincipalConfig struct {
 	Password          *string  `json:"password,omitempty"`
 	ExplicitRoleNames []string `json:"admin_roles,omitempty"`
 	RoleNames         []string `json:"roles,omitempty"`
+	PasswordHash      []byte   `json:"-"` // bcrypt hash to set instead of Password, when importing principals
 }
 
 // Check if the password in this PrincipalConfig is valid.  Only allow
@@ -82,8 +84,39 @@ func (dbc *DatabaseContext) GetPrincipal(name string, isUser bool) (info *Princi
 	return
 }
 
+// Deletes a user or role, and raises a principal change event for the loss of its admin channels and roles.
+func (dbc *DatabaseContext) DeletePrincipal(princ auth.Principal) error {
+	if err := dbc.Authenticator().Delete(princ); err != nil {
+		return err
+	}
+	if dbc.EventMgr.HasHandlerForEvent(PrincipalChange) {
+		delta := PrincipalDelta{
+			Name:            princ.Name(),
+			IsRole:          true,
+			Change:          PrincipalDeleted,
+			ChannelsRemoved: setDifference(princ.ExplicitChannels().AsSet(), nil),
+		}
+		if user, isUser := princ.(auth.User); isUser {
+			delta.IsRole = false
+			delta.RolesRemoved = setDifference(user.ExplicitRoles().AsSet(), nil)
+		}
+		dbc.EventMgr.RaisePrincipalChangeEvent(delta)
+	}
+	return nil
+}
+
 // Updates or creates a principal from a PrincipalConfig structure.
 func (dbc *DatabaseContext) UpdatePrincipal(newInfo PrincipalConfig, isUser bool, allowReplace bool) (replaced bool, err error) {
+	return dbc.UpdatePrincipalWithCallback(*newInfo.Name, isUser, allowReplace, func(auth.Principal) (*PrincipalConfig, error) {
+		return &newInfo, nil
+	})
+}
+
+// Updates or creates a principal from the PrincipalConfig returned by the callback, which is given the existing
+// principal (or nil if there isn't one).  The callback is called again with the latest principal each time the
+// update is retried on a CAS mismatch, so changes it makes relative to the principal aren't lost to concurrent
+// updates.  If the callback returns nil, nothing is updated or created.
+func (dbc *DatabaseContext) UpdatePrincipalWithCallback(name string, isUser bool, allowReplace bool, callback func(princ auth.Principal) (*PrincipalConfig, error)) (replaced bool, err error) {
 	// Get the existing principal, or if this is a POST make sure there isn't one:
 	var princ auth.Principal
 	var user auth.User
@@ -93,29 +126,36 @@ func (dbc *DatabaseContext) UpdatePrincipal(newInfo PrincipalConfig, isUser bool
 	// to PrincipalUpdateMaxCasRetries defensively to avoid unexpected retry loops.
 	for i := 1; i <= auth.PrincipalUpdateMaxCasRetries; i++ {
 		if isUser {
-			user, err = authenticator.GetUser(*newInfo.Name)
+			user, err = authenticator.GetUser(name)
 			princ = user
 		} else {
-			princ, err = authenticator.GetRole(*newInfo.Name)
+			princ, err = authenticator.GetRole(name)
 		}
 		if err != nil {
 			return replaced, err
 		}
 
+		var newInfo *PrincipalConfig
+		if newInfo, err = callback(princ); err != nil || newInfo == nil {
+			return princ != nil, err
+		}
+
 		changed := false
 		replaced = (princ != nil)
 		if !replaced {
 			// If user/role didn't exist already, instantiate a new one:
 			if isUser {
-				isValid, reason := newInfo.IsPasswordValid(dbc.AllowEmptyPassword)
-				if !isValid {
-					err = base.HTTPErrorf(http.StatusBadRequest, reason)
-					return replaced, err
+				if newInfo.PasswordHash == nil {
+					isValid, reason := newInfo.IsPasswordValid(dbc.AllowEmptyPassword)
+					if !isValid {
+						err = base.HTTPErrorf(http.StatusBadRequest, reason)
+						return replaced, err
+					}
 				}
-				user, err = authenticator.NewUser(*newInfo.Name, "", nil)
+				user, err = authenticator.NewUser(name, "", nil)
 				princ = user
 			} else {
-				princ, err = authenticator.NewRole(*newInfo.Name, nil)
+				princ, err = authenticator.NewRole(name, nil)
 			}
 			if err != nil {
 				return replaced, err
@@ -151,6 +191,11 @@ func (dbc *DatabaseContext) UpdatePrincipal(newInfo PrincipalConfig, isUser bool
 			if newInfo.Password != nil {
 				user.SetPassword(*newInfo.Password)
 				changed = true
+			} else if newInfo.PasswordHash != nil && !bytes.Equal(newInfo.PasswordHash, user.PasswordHash()) {
+				if err = user.SetPasswordHash(newInfo.PasswordHash); err != nil {
+					return replaced, base.HTTPErrorf(http.StatusBadRequest, "%v", err)
+				}
+				changed = true
 			}
 			if newInfo.Disabled != user.Disabled() {
 				user.SetDisabled(newInfo.Disabled)
@@ -182,6 +227,23 @@ func (dbc *DatabaseContext) UpdatePrincipal(newInfo PrincipalConfig, isUser bool
 			princ.SetSequence(nextSeq)
 		}
 
+		// Describe the change for the principal change event, before the Principal's channels and roles are updated
+		delta := PrincipalDelta{
+			Name:            princ.Name(),
+			IsRole:          !isUser,
+			Change:          PrincipalUpdated,
+			ChannelsAdded:   setDifference(newInfo.ExplicitChannels, updatedChannels.AsSet()),
+			ChannelsRemoved: setDifference(updatedChannels.AsSet(), newInfo.ExplicitChannels),
+		}
+		if !replaced {
+			delta.Change = PrincipalCreated
+		}
+		if isUser {
+			newRoles := base.SetFromArray(newInfo.ExplicitRoleNames)
+			delta.RolesAdded = setDifference(newRoles, updatedRoles.AsSet())
+			delta.RolesRemoved = setDifference(updatedRoles.AsSet(), newRoles)
+		}
+
 		// Now update the Principal object from the properties in the request, first the channels:
 		if updatedChannels.UpdateAtSequence(newInfo.ExplicitChannels, nextSeq) {
 			princ.SetExplicitChannels(updatedChannels)
@@ -195,12 +257,15 @@ func (dbc *DatabaseContext) UpdatePrincipal(newInfo PrincipalConfig, isUser bool
 		err = authenticator.Save(princ)
 		// On cas error, retry.  Otherwise break out of loop
 		if base.IsCasMismatch(err) {
-			base.Infof(base.KeyAuth, "CAS mismatch updating principal %s - will retry", base.UD(princ.Name()))
+			base.Infof(base.KeyAuth, "CAS mismatch updating principal %s - will retry", base.UDUsername(princ.Name()))
 		} else {
+			if err == nil && dbc.EventMgr.HasHandlerForEvent(PrincipalChange) {
+				dbc.EventMgr.RaisePrincipalChangeEvent(delta)
+			}
 			return replaced, err
 		}
 	}
 
-	base.Errorf(base.KeyAuth, "CAS mismatch updating principal %s - exceeded retry count. Latest failure: %v", base.UD(princ.Name()), err)
+	base.Errorf(base.KeyAuth, "CAS mismatch updating principal %s - exceeded retry count. Latest failure: %v", base.UDUsername(princ.Name()), err)
 	return replaced, err
 }
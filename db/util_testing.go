@@ -2,6 +2,7 @@ package db
 
 import (
 	"fmt"
+	"io"
 	"math"
 	"time"
 
@@ -115,3 +116,22 @@ func (c *changeCache) waitForSequenceWithMissing(sequence uint64, maxWaitTime ti
 		time.Sleep(100 * time.Millisecond)
 	}
 }
+
+// FOR TESTS ONLY: Records the events the database's mutation feed passes on to the change cache and import, until the
+// returned stop func is called.  stop returns the number of events recorded.  The recording can be replayed into
+// another database with ReplayFeed.
+func (context *DatabaseContext) StartFeedRecording(w io.Writer) (stop func() (count int, err error)) {
+	// The recorder's swapped atomically, as the feed may already be running
+	recorder := base.NewFeedRecorder(w)
+	context.mutationListener.feedRecorder.Store(recorder)
+	return func() (int, error) {
+		context.mutationListener.feedRecorder.Store((*base.FeedRecorder)(nil))
+		return recorder.Flush()
+	}
+}
+
+// FOR TESTS ONLY: Replays a feed recording made by StartFeedRecording or a base.FeedRecorder into the database, as if
+// its events had arrived on the database's mutation feed.  Returns the number of events replayed.
+func (context *DatabaseContext) ReplayFeed(r io.Reader, options base.FeedReplayOptions) (count int, err error) {
+	return base.ReplayFeed(r, context.mutationListener.ProcessFeedEvent, options)
+}
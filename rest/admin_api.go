@@ -14,6 +14,9 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 	"sync/atomic"
 	"time"
@@ -33,14 +36,19 @@ const kDefaultDBOnlineDelay = 0
 func (h *handler) handleCreateDB() error {
 	h.assertAdminOnly()
 	dbName := h.PathVar("newdb")
-	var config *DbConfig
-	if err := h.readJSONInto(&config); err != nil {
+	config, err := h.readDbConfig()
+	if err != nil {
 		return err
 	}
 	if err := config.setup(dbName); err != nil {
 		return err
 	}
-	if _, err := h.server.AddDatabaseFromConfig(config); err != nil {
+	if watcher := h.server.configBucketWatcher; watcher != nil {
+		// Store the config in the config bucket, so the database is added on every node
+		if err := watcher.putDatabase(config, false); err != nil {
+			return err
+		}
+	} else if _, err := h.server.AddDatabaseFromConfig(config); err != nil {
 		return err
 	}
 	return base.HTTPErrorf(http.StatusCreated, "created")
@@ -112,23 +120,63 @@ func (h *handler) handleGetConfig() error {
 func (h *handler) handlePutDbConfig() error {
 	h.assertAdminOnly()
 	dbName := h.db.Name
-	var config *DbConfig
-	if err := h.readJSONInto(&config); err != nil {
+	config, err := h.readDbConfig()
+	if err != nil {
 		return err
 	}
 	if err := config.setup(dbName); err != nil {
 		return err
 	}
+	if watcher := h.server.configBucketWatcher; watcher != nil && watcher.ownsDatabase(dbName) {
+		// Databases stored in the config bucket are reloaded immediately, on every node
+		h.setAuditSummary(auditDbConfigSummary(h.server.GetDatabaseConfig(dbName)), auditDbConfigSummary(config))
+		if err := watcher.putDatabase(config, true); err != nil {
+			return err
+		}
+		return base.HTTPErrorf(http.StatusCreated, "created")
+	}
 	h.server.lock.Lock()
 	defer h.server.lock.Unlock()
+	h.setAuditSummary(auditDbConfigSummary(h.server.config.Databases[dbName]), auditDbConfigSummary(config))
 	h.server.config.Databases[dbName] = config
 
 	return base.HTTPErrorf(http.StatusCreated, "created")
 }
 
+// Reads a database config from the request body, expanding any environment variable references in it the same way
+// as in config files.
+func (h *handler) readDbConfig() (*DbConfig, error) {
+	var raw json.RawMessage
+	if err := h.readJSONInto(&raw); err != nil {
+		return nil, err
+	}
+	data, err := base.ExpandEnvVars(raw)
+	if err != nil {
+		return nil, base.HTTPErrorf(http.StatusBadRequest, "%v", err)
+	}
+	var config *DbConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, base.HTTPErrorf(http.StatusBadRequest, "Bad JSON: %v", err)
+	}
+	return config, nil
+}
+
 // "Delete" a database (it doesn't actually do anything to the underlying bucket)
 func (h *handler) handleDeleteDB() error {
 	h.assertAdminOnly()
+	if alias := h.PathVar("db"); alias != h.db.Name {
+		return base.HTTPErrorf(http.StatusBadRequest, "%q is an alias of db %q; remove it with DELETE /_alias/%s", alias, h.db.Name, alias)
+	}
+	if watcher := h.server.configBucketWatcher; watcher != nil && watcher.ownsDatabase(h.db.Name) {
+		found, err := watcher.deleteDatabase(h.db.Name)
+		if err != nil {
+			return err
+		} else if !found {
+			return base.HTTPErrorf(http.StatusNotFound, "missing")
+		}
+		h.response.Write([]byte("{}"))
+		return nil
+	}
 	if !h.server.RemoveDatabase(h.db.Name) {
 		return base.HTTPErrorf(http.StatusNotFound, "missing")
 	}
@@ -136,6 +184,46 @@ func (h *handler) handleDeleteDB() error {
 	return nil
 }
 
+// Stream a snapshot of the contents of a walrus database's bucket
+func (h *handler) handleGetSnapshot() error {
+	h.assertAdminOnly()
+	bucket, ok := base.AsWalrusSnapshotBucket(h.db.Bucket)
+	if !ok {
+		return base.HTTPErrorf(http.StatusBadRequest, "Snapshots are only supported for walrus buckets")
+	}
+
+	h.setHeader("Content-Type", "application/x-ndjson")
+	count, err := bucket.Snapshot(h.response)
+	if err != nil {
+		// The response has already been started, so the error can only be logged
+		base.Warnf(base.KeyAll, "Error writing snapshot of db %q: %v", base.MD(h.db.Name), err)
+		return nil
+	}
+	base.Infof(base.KeyHTTP, "Wrote snapshot of %d docs from db %q", count, base.MD(h.db.Name))
+	return nil
+}
+
+// Replace the contents of a walrus database's bucket with a snapshot.  The database must be offline, and picks up
+// the restored contents when it's next brought online.
+func (h *handler) handleRestoreSnapshot() error {
+	h.assertAdminOnly()
+	bucket, ok := base.AsWalrusSnapshotBucket(h.db.Bucket)
+	if !ok {
+		return base.HTTPErrorf(http.StatusBadRequest, "Snapshots are only supported for walrus buckets")
+	}
+	if atomic.LoadUint32(&h.db.State) != db.DBOffline {
+		return base.CodedHTTPErrorf(http.StatusServiceUnavailable, base.ErrorCodeDatabaseNotOffline, "Database must be _offline before calling /_restore")
+	}
+
+	count, err := bucket.Restore(h.requestBody)
+	if err != nil {
+		return base.HTTPErrorf(http.StatusBadRequest, "Unable to restore snapshot: %v", err)
+	}
+	base.Infof(base.KeyCRUD, "Restored snapshot of %d docs into db %q", count, base.MD(h.db.Name))
+	h.writeJSON(db.Body{"docs_restored": count})
+	return nil
+}
+
 /////// Replication and Task monitoring
 
 func (h *handler) handleReplicate() error {
@@ -312,11 +400,87 @@ func (h *handler) handleActiveTasks() error {
 	return nil
 }
 
-// raw document access for admin api
+// Returns per-user replication volume and per-channel activity over the database's usage stats window.
+// Optional 'user' and 'channel' query parameters restrict the results to a single user or channel.
+func (h *handler) handleGetUsage() error {
+	if h.db.UsageStats == nil {
+		return base.HTTPErrorf(http.StatusNotFound, "Usage stats are not enabled for this database")
+	}
+	snapshot := h.db.UsageStats.Snapshot()
+
+	if user := h.getQuery("user"); user != "" {
+		filtered := map[string]*db.UserUsage{}
+		if usage, found := snapshot.Users[user]; found {
+			filtered[user] = usage
+		}
+		snapshot.Users = filtered
+	}
+
+	if channel := h.getQuery("channel"); channel != "" {
+		filtered := map[string]*db.ChannelUsage{}
+		if usage, found := snapshot.Channels[channel]; found {
+			filtered[channel] = usage
+		}
+		snapshot.Channels = filtered
+	}
+
+	h.writeJSON(snapshot)
+	return nil
+}
+
+// Returns the estimated number and total size of the live documents in each channel, largest channel first.
+// The optional 'channel' query parameter restricts the results to a single channel, and 'limit' to the largest
+// channels.
+func (h *handler) handleGetChannelSizes() error {
+	sizes, err := h.db.GetChannelSizes()
+	if err != nil {
+		return err
+	}
+
+	type channelSize struct {
+		Channel string `json:"channel"`
+		db.ChannelSize
+	}
+	result := make([]channelSize, 0, len(sizes))
+	channel := h.getQuery("channel")
+	for name, size := range sizes {
+		if channel == "" || name == channel {
+			result = append(result, channelSize{Channel: name, ChannelSize: *size})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Bytes != result[j].Bytes {
+			return result[i].Bytes > result[j].Bytes
+		}
+		return result[i].Channel < result[j].Channel
+	})
+	if limit := h.getIntQuery("limit", 0); limit > 0 && limit < uint64(len(result)) {
+		result = result[:limit]
+	}
+
+	h.writeJSON(db.Body{"channels": result})
+	return nil
+}
+
+// raw document access for admin api.  With ?include_sync=true, returns the document's rev tree, channel history,
+// flags and xattr, along with the channel index blocks that reference it, pretty-printed.
 
 func (h *handler) handleGetRawDoc() error {
 	h.assertAdminOnly()
 	docid := h.PathVar("docid")
+	if h.getBoolQuery("include_sync") {
+		inspection, err := h.db.InspectDocument(docid)
+		if err != nil {
+			return err
+		}
+		output, err := json.MarshalIndent(inspection, "", "  ")
+		if err != nil {
+			return err
+		}
+		h.setHeader("Content-Type", "application/json")
+		h.response.Write(append(output, '\n'))
+		return nil
+	}
 	doc, err := h.db.GetDocument(docid, db.DocUnmarshalAll)
 	if doc != nil {
 		h.writeJSON(doc)
@@ -394,6 +558,43 @@ func (h *handler) handleSetLogging() error {
 	return nil
 }
 
+// Streams the current log file for the given level, with the contents of all tagged user data replaced by its
+// salted hash, in the same way as sgcollect_info's log redaction.  User data is only tagged in the log files
+// when redaction is enabled for its class.
+func (h *handler) handleLogExport() error {
+	var params struct {
+		Level string `json:"level"`
+		Salt  string `json:"salt"`
+	}
+	if err := h.readJSONInto(&params); err != nil {
+		return err
+	}
+
+	switch params.Level {
+	case "error", "warn", "info", "debug":
+	default:
+		return base.HTTPErrorf(http.StatusBadRequest, "level must be one of error, warn, info or debug")
+	}
+	if params.Salt == "" {
+		return base.HTTPErrorf(http.StatusBadRequest, "Missing JSON 'salt' parameter")
+	}
+
+	if h.server.config.Logging == nil || h.server.config.Logging.LogFilePath == "" {
+		return base.HTTPErrorf(http.StatusNotFound, "Logging to files is not enabled")
+	}
+	f, err := os.Open(filepath.Join(h.server.config.Logging.LogFilePath, "sg_"+params.Level+".log"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return kNotFoundError
+		}
+		return err
+	}
+	defer f.Close()
+
+	h.setHeader("Content-Type", "text/plain; charset=utf-8")
+	return base.RedactTaggedUserData(f, h.response, params.Salt)
+}
+
 func (h *handler) handleSGCollectStatus() error {
 	status := "stopped"
 	if sgcollectInstance.IsRunning() {
@@ -506,7 +707,9 @@ func (h *handler) updatePrincipal(name string, isUser bool) error {
 
 	internalName := internalUserName(*newInfo.Name)
 	newInfo.Name = &internalName
+	before := auditPrincipalSummary(h.db.DatabaseContext, internalName, isUser)
 	replaced, err := h.db.UpdatePrincipal(newInfo, isUser, h.rq.Method != "POST")
+	h.setAuditSummary(before, auditPrincipalSummary(h.db.DatabaseContext, internalName, isUser))
 	if err != nil {
 		return err
 	} else if replaced {
@@ -553,7 +756,8 @@ func (h *handler) deleteUser() error {
 		}
 		return err
 	}
-	return h.db.Authenticator().Delete(user)
+	h.setAuditSummary(auditPrincipalSummary(h.db.DatabaseContext, username, true), nil)
+	return h.db.DeletePrincipal(user)
 }
 
 func (h *handler) deleteRole() error {
@@ -565,7 +769,8 @@ func (h *handler) deleteRole() error {
 		}
 		return err
 	}
-	return h.db.Authenticator().Delete(role)
+	h.setAuditSummary(auditPrincipalSummary(h.db.DatabaseContext, role.Name(), false), nil)
+	return h.db.DeletePrincipal(role)
 }
 
 func (h *handler) getUserInfo() error {
@@ -597,6 +802,32 @@ func (h *handler) getRoleInfo() error {
 	return err
 }
 
+// GET /db/_user/{name}/_access_audit returns the changes that documents' access() and role() grants have made to
+// the user's channels and roles.
+func (h *handler) getUserAccessAudit() error {
+	return h.respondWithAccessAudit(internalUserName(mux.Vars(h.rq)["name"]), false)
+}
+
+// GET /db/_role/{name}/_access_audit returns the changes that documents' access() grants have made to the role's
+// channels.
+func (h *handler) getRoleAccessAudit() error {
+	return h.respondWithAccessAudit(mux.Vars(h.rq)["name"], true)
+}
+
+// Responds with a principal's access audit trail.  The "channel" query parameter limits it to the entries that
+// granted or revoked that channel.
+func (h *handler) respondWithAccessAudit(name string, isRole bool) error {
+	if !h.db.Options.AccessAuditOptions.Enabled {
+		return base.HTTPErrorf(http.StatusNotFound, "The access audit trail is not enabled for this database")
+	}
+	entries, err := h.db.GetAccessAudit(name, isRole, h.getQuery("channel"))
+	if err != nil {
+		return err
+	}
+	h.writeJSON(db.Body{"name": mux.Vars(h.rq)["name"], "entries": entries})
+	return nil
+}
+
 func (h *handler) getUsers() error {
 	users, _, err := h.db.AllPrincipalIDs()
 	if err != nil {
@@ -617,6 +848,43 @@ func (h *handler) getRoles() error {
 	return err
 }
 
+// POST /db/_channel_grants grants and revokes admin channels for many users and roles in one request, e.g.
+// {"users":["alice","bob"], "roles":["staff"], "grant":["news"], "revoke":["drafts"]}, returning each one's outcome.
+func (h *handler) handleChannelGrants() error {
+	h.assertAdminOnly()
+	var request db.ChannelGrantRequest
+	if err := h.readJSONInto(&request); err != nil {
+		return err
+	}
+	for i, name := range request.Users {
+		request.Users[i] = internalUserName(name)
+	}
+	results, err := h.db.UpdateChannelGrants(request)
+	if err != nil {
+		return err
+	}
+	for i := range results.Users {
+		results.Users[i].Name = externalUserName(results.Users[i].Name)
+	}
+	h.setAuditSummary(nil, results)
+	h.writeJSON(results)
+	return nil
+}
+
+// GET /db/_channel/{name}/_access returns every user and role with access to the channel, with how each was given it
+// (as an admin channel, by a document's access() call, or through a role) and from which sequence.
+func (h *handler) handleGetChannelAccess() error {
+	access, err := h.db.GetChannelAccess(h.PathVar("name"))
+	if err != nil {
+		return err
+	}
+	for i := range access.Users {
+		access.Users[i].Name = externalUserName(access.Users[i].Name)
+	}
+	h.writeJSON(access)
+	return nil
+}
+
 // HTTP handler for /index
 func (h *handler) handleIndex() error {
 	base.Infof(base.KeyHTTP, "Index")
@@ -634,7 +902,7 @@ func (h *handler) handleIndex() error {
 // HTTP handler for /index/channel
 func (h *handler) handleIndexChannel() error {
 	channelName := h.PathVar("channel")
-	base.Infof(base.KeyHTTP, "Index channel %q", base.UD(channelName))
+	base.Infof(base.KeyHTTP, "Index channel %q", base.UDChannel(channelName))
 
 	channelStats, err := h.db.IndexChannelStats(channelName)
 
@@ -723,6 +991,8 @@ func (h *handler) handlePurge() error {
 		}
 	}
 
+	h.setAuditSummary(nil, db.Body{"purged": docIDs})
+
 	if len(docIDs) > 0 {
 		count := h.db.GetChangeIndex().Remove(docIDs, startTime)
 		base.Debugf(base.KeyCache, "Purged %d items from caches", count)
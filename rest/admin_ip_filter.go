@@ -0,0 +1,114 @@
+package rest
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/couchbase/sync_gateway/base"
+)
+
+// adminIPFilter enforces the admin API's allow and deny lists.  Clients are identified by the address they connect
+// from; X-Forwarded-For is never trusted, since anyone who can reach the admin port could set it.
+type adminIPFilter struct {
+	allow []*net.IPNet // If non-empty, only these addresses are accepted
+	deny  []*net.IPNet // These addresses are rejected, even if they're allowed
+}
+
+// Sets up the admin API's IP filter from config.AdminIPFilter.  Does nothing if neither list is set.
+func (sc *ServerContext) setupAdminIPFilter() error {
+	filter, err := newAdminIPFilter(sc.config.AdminIPFilter)
+	if err != nil {
+		return err
+	}
+	if filter != nil {
+		base.Infof(base.KeyAll, "Admin API accepting requests from %v, rejecting %v", filter.allow, filter.deny)
+	}
+	sc.adminIPFilter = filter
+	return nil
+}
+
+// Returns an adminIPFilter for the given config, or nil if it doesn't set either list.
+func newAdminIPFilter(config *AdminIPFilterConfig) (*adminIPFilter, error) {
+	if config == nil || (len(config.Allow) == 0 && len(config.Deny) == 0) {
+		return nil, nil
+	}
+	allow, err := parseIPNets(config.Allow, "allow")
+	if err != nil {
+		return nil, err
+	}
+	deny, err := parseIPNets(config.Deny, "deny")
+	if err != nil {
+		return nil, err
+	}
+	return &adminIPFilter{allow: allow, deny: deny}, nil
+}
+
+// Parses a list of CIDR blocks and single addresses.
+func parseIPNets(entries []string, name string) ([]*net.IPNet, error) {
+	ipNets := make([]*net.IPNet, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("admin_ip_filter.%s: invalid address %q", name, entry)
+			}
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+			}
+			ipNets = append(ipNets, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("admin_ip_filter.%s: invalid CIDR block %q", name, entry)
+		}
+		ipNets = append(ipNets, ipNet)
+	}
+	return ipNets, nil
+}
+
+// Returns true if requests from the given address are accepted.  Addresses that can't be parsed are rejected.
+func (f *adminIPFilter) allows(ip net.IP) bool {
+	if ip == nil || ipNetsContain(f.deny, ip) {
+		return false
+	}
+	return len(f.allow) == 0 || ipNetsContain(f.allow, ip)
+}
+
+func ipNetsContain(ipNets []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range ipNets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the address the request was sent from.
+func remoteIP(rq *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(rq.RemoteAddr)
+	if err != nil {
+		host = rq.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// If the admin API's IP filter rejects the request, responds with a 403, records the attempt in the audit log and
+// returns false.
+func (sc *ServerContext) checkAdminIPFilter(response http.ResponseWriter, rq *http.Request) bool {
+	if sc.adminIPFilter == nil || sc.adminIPFilter.allows(remoteIP(rq)) {
+		return true
+	}
+
+	h := newHandler(sc, adminPrivs, response, rq, false)
+	base.WarnfCtx(h.logCtx, base.KeyAll, "Admin API request %s %s from %s rejected by admin_ip_filter",
+		rq.Method, base.SanitizeRequestURL(rq, nil), base.UD(rq.RemoteAddr))
+	base.StatsResourceUtilization().Add(base.StatKeyAdminIPDeniedCount, 1)
+	h.writeStatus(http.StatusForbidden, "Requests from this address are not allowed")
+	h.logAuditDenied()
+	h.logDuration(true)
+	return false
+}
@@ -0,0 +1,81 @@
+package rest
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminIPFilter(t *testing.T) {
+	filter, err := newAdminIPFilter(&AdminIPFilterConfig{
+		Allow: []string{"10.0.0.0/8", "127.0.0.1", "::1"},
+		Deny:  []string{"10.1.0.0/16"},
+	})
+	assert.NoError(t, err)
+
+	for _, test := range []struct {
+		ip      string
+		allowed bool
+	}{
+		{"10.0.0.1", true},
+		{"10.1.2.3", false},
+		{"127.0.0.1", true},
+		{"127.0.0.2", false},
+		{"::1", true},
+		{"192.0.2.1", false},
+	} {
+		assert.Equal(t, test.allowed, filter.allows(net.ParseIP(test.ip)), test.ip)
+	}
+	assert.False(t, filter.allows(nil))
+
+	// A deny list by itself allows everything else
+	filter, err = newAdminIPFilter(&AdminIPFilterConfig{Deny: []string{"192.0.2.0/24"}})
+	assert.NoError(t, err)
+	assert.False(t, filter.allows(net.ParseIP("192.0.2.1")))
+	assert.True(t, filter.allows(net.ParseIP("198.51.100.1")))
+
+	filter, err = newAdminIPFilter(&AdminIPFilterConfig{})
+	assert.NoError(t, err)
+	assert.Nil(t, filter)
+
+	_, err = newAdminIPFilter(&AdminIPFilterConfig{Allow: []string{"10.0.0.0/33"}})
+	assert.Error(t, err)
+	_, err = newAdminIPFilter(&AdminIPFilterConfig{Deny: []string{"not an address"}})
+	assert.Error(t, err)
+}
+
+func TestAdminIPFilterRequests(t *testing.T) {
+	rt := RestTester{ServerConfig: &ServerConfig{
+		AdminIPFilter: &AdminIPFilterConfig{Allow: []string{"192.0.2.0/24"}},
+	}}
+	defer rt.Close()
+	assert.NoError(t, rt.ServerContext().setupAdminIPFilter())
+
+	sendFrom := func(remoteAddr, method, resource string) *TestResponse {
+		request, _ := http.NewRequest(method, "http://localhost"+resource, nil)
+		request.RemoteAddr = remoteAddr
+		response := &TestResponse{httptest.NewRecorder(), request}
+		rt.TestAdminHandler().ServeHTTP(response, request)
+		return response
+	}
+
+	assertStatus(t, sendFrom("192.0.2.1:4321", "GET", "/db/"), http.StatusOK)
+	assertStatus(t, sendFrom("198.51.100.1:4321", "GET", "/db/"), http.StatusForbidden)
+
+	// Unknown URLs are rejected too, so they can't be used to probe the admin API
+	assertStatus(t, sendFrom("198.51.100.1:4321", "GET", "/_nonexistent"), http.StatusForbidden)
+
+	// X-Forwarded-For isn't trusted
+	request, _ := http.NewRequest("GET", "http://localhost/db/", nil)
+	request.RemoteAddr = "198.51.100.1:4321"
+	request.Header.Set("X-Forwarded-For", "192.0.2.1")
+	response := &TestResponse{httptest.NewRecorder(), request}
+	rt.TestAdminHandler().ServeHTTP(response, request)
+	assertStatus(t, response, http.StatusForbidden)
+
+	// The public API isn't filtered
+	assertStatus(t, rt.SendRequest("GET", "/db/", ""), http.StatusOK)
+}
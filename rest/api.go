@@ -12,14 +12,15 @@ package rest
 import (
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
 	httpprof "net/http/pprof"
 	"os"
 	"runtime"
 	"runtime/pprof"
 	"strconv"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	sgbucket "github.com/couchbase/sg-bucket"
 	"github.com/couchbase/sync_gateway/base"
@@ -45,21 +46,20 @@ func (h *handler) handleAllDbs() error {
 	return nil
 }
 
+// Starts compaction as a background task, responding with the task's ID; its progress and result are listed at
+// /db/_tasks.  It doesn't wait for the task, which may be queued behind others.
 func (h *handler) handleCompact() error {
-	revsDeleted, err := h.db.Compact()
-	if err != nil {
-		return err
-	}
-	h.writeJSON(db.Body{"revs": revsDeleted})
+	taskID := h.db.BackgroundTasks.Start(db.BackgroundTaskCompact, db.CompactTask)
+	h.writeJSONStatus(http.StatusAccepted, db.Body{"id": taskID})
 	return nil
 }
 
+// Starts attachment GC as a background task, like handleCompact.
 func (h *handler) handleVacuum() error {
-	attsDeleted, err := db.VacuumAttachments(h.db.Bucket)
-	if err != nil {
-		return err
-	}
-	h.writeJSON(db.Body{"atts": attsDeleted})
+	taskID := h.db.BackgroundTasks.Start(db.BackgroundTaskAttachmentGC, func(taskDB *db.Database) (interface{}, error) {
+		return db.VacuumAttachments(taskDB.Bucket)
+	})
+	h.writeJSONStatus(http.StatusAccepted, db.Body{"id": taskID})
 	return nil
 }
 
@@ -128,7 +128,7 @@ func (h *handler) handleFlush() error {
 
 	} else {
 
-		return base.HTTPErrorf(http.StatusServiceUnavailable, "Bucket does not support flush or delete")
+		return base.CodedHTTPErrorf(http.StatusServiceUnavailable, base.ErrorCodeNotSupported, "Bucket does not support flush or delete")
 
 	}
 
@@ -145,20 +145,43 @@ func (h *handler) handleResync() error {
 	}
 
 	if dbState != db.DBOffline {
-		return base.HTTPErrorf(http.StatusServiceUnavailable, "Database must be _offline before calling /_resync")
+		return base.CodedHTTPErrorf(http.StatusServiceUnavailable, base.ErrorCodeDatabaseNotOffline, "Database must be _offline before calling /_resync")
 	}
 
 	if atomic.CompareAndSwapUint32(&h.db.State, db.DBOffline, db.DBResyncing) {
 		defer atomic.CompareAndSwapUint32(&h.db.State, db.DBResyncing, db.DBOffline)
-		docsChanged, err := h.db.UpdateAllDocChannels()
+		docsChanged, err := h.db.BackgroundTasks.Run(db.BackgroundTaskResync, func(taskDB *db.Database) (interface{}, error) {
+			return taskDB.UpdateAllDocChannels()
+		})
 		if err != nil {
 			return err
 		}
+		h.setAuditSummary(nil, db.Body{"changes": docsChanged})
 		h.writeJSON(db.Body{"changes": docsChanged})
 	}
 	return nil
 }
 
+// HTTP handler for _resolve_conflicts, which resolves all conflicted documents using the policy given by the policy
+// query parameter, so that the database can be switched to allow_conflicts=false.
+func (h *handler) handleResolveConflicts() error {
+	policy := db.ConflictResolutionPolicy(h.getQuery("policy"))
+	if policy == "" {
+		policy = db.ConflictResolutionWinner
+	}
+	preview := h.getBoolQuery("preview")
+
+	result, err := h.db.ResolveConflicts(policy, preview)
+	if err != nil {
+		return err
+	}
+	if !preview {
+		h.setAuditSummary(nil, db.Body{"resolved": len(result.Resolved), "remaining": len(result.Remaining)})
+	}
+	h.writeJSON(result)
+	return nil
+}
+
 type PostUpgradeResponse struct {
 	Result  PostUpgradeResult `json:"post_upgrade_results"`
 	Preview bool              `json:"preview,omitempty"`
@@ -218,6 +241,22 @@ func (h *handler) handleGetDB() error {
 	return nil
 }
 
+// GET /db/_time returns the server's current time along with the database's high sequence, so that clients can
+// estimate their clock skew against the server (e.g. for last-write-wins conflict resolution.)
+func (h *handler) handleGetTime() error {
+	lastSeq, err := h.db.LastSequence()
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	h.writeJSON(db.Body{
+		"time":         now.Format(time.RFC3339Nano),
+		"time_unix_ms": now.UnixNano() / int64(time.Millisecond),
+		"update_seq":   lastSeq,
+	})
+	return nil
+}
+
 // Stub handler for hadling create DB on the public API returns HTTP status 412
 // if the db exists, and 403 if it doesn't.
 // fixes issue #562
@@ -239,11 +278,72 @@ func (h *handler) handleEFC() error { // Handles _ensure_full_commit.
 	return nil
 }
 
-// ADMIN API to turn Go CPU profiling on/off
+// The longest CPU profile that can be requested with duration_secs.
+const kMaxCPUProfileDuration = 10 * time.Minute
+
+var (
+	cpuProfileLock  sync.Mutex
+	cpuProfileFile  *os.File    // File the running CPU profile is being written to, or nil if none is running
+	cpuProfileTimer *time.Timer // Stops a time-boxed CPU profile once its duration has elapsed
+)
+
+// Starts a CPU profile written to the given file.  If duration is non-zero, the profile is stopped automatically
+// once it has elapsed.
+func startCPUProfile(filename string, duration time.Duration) error {
+	cpuProfileLock.Lock()
+	defer cpuProfileLock.Unlock()
+
+	if cpuProfileFile != nil {
+		return base.HTTPErrorf(http.StatusConflict, "A CPU profile is already running")
+	}
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := pprof.StartCPUProfile(f); err != nil {
+		f.Close()
+		return base.HTTPErrorf(http.StatusConflict, "Unable to start CPU profile: %v", err)
+	}
+	cpuProfileFile = f
+
+	if duration > 0 {
+		base.Infof(base.KeyAll, "Starting %v CPU profile to %s ...", duration, base.UD(filename))
+		cpuProfileTimer = time.AfterFunc(duration, func() { stopCPUProfile() })
+	} else {
+		base.Infof(base.KeyAll, "Starting CPU profile to %s ...", base.UD(filename))
+	}
+	return nil
+}
+
+// Stops the running CPU profile, if any.
+func stopCPUProfile() {
+	cpuProfileLock.Lock()
+	defer cpuProfileLock.Unlock()
+
+	if cpuProfileFile == nil {
+		return
+	}
+	if cpuProfileTimer != nil {
+		cpuProfileTimer.Stop()
+		cpuProfileTimer = nil
+	}
+
+	pprof.StopCPUProfile()
+	if err := cpuProfileFile.Close(); err != nil {
+		base.Warnf(base.KeyAll, "Error closing CPU profile %s: %v", base.UD(cpuProfileFile.Name()), err)
+	}
+	base.Infof(base.KeyAll, "...ending CPU profile to %s.", base.UD(cpuProfileFile.Name()))
+	cpuProfileFile = nil
+}
+
+// ADMIN API to turn Go CPU profiling on/off, or to write a named profile (e.g. "goroutine", "heap") to a file.
+// When starting a CPU profile, an optional duration_secs stops the profile automatically once it has elapsed.
 func (h *handler) handleProfiling() error {
 	profileName := h.PathVar("profilename")
 	var params struct {
-		File string `json:"file"`
+		File         string `json:"file"`
+		DurationSecs uint   `json:"duration_secs"`
 	}
 	body, err := h.readBody()
 	if err != nil {
@@ -255,45 +355,64 @@ func (h *handler) handleProfiling() error {
 		}
 	}
 
+	duration := time.Duration(params.DurationSecs) * time.Second
+	if duration > kMaxCPUProfileDuration {
+		return base.HTTPErrorf(http.StatusBadRequest, "duration_secs must not be greater than %d", int(kMaxCPUProfileDuration.Seconds()))
+	}
+
 	if params.File != "" {
-		f, err := os.Create(params.File)
-		if err != nil {
-			return err
-		}
 		if profileName != "" {
-			defer f.Close()
-			if profile := pprof.Lookup(profileName); profile != nil {
-				profile.WriteTo(f, 0)
-				base.Infof(base.KeyAll, "Wrote %s profile to %s", profileName, base.UD(params.File))
-			} else {
+			profile := pprof.Lookup(profileName)
+			if profile == nil {
 				return base.HTTPErrorf(http.StatusNotFound, "No such profile %q", profileName)
 			}
+			f, err := os.Create(params.File)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			if err := profile.WriteTo(f, 0); err != nil {
+				return err
+			}
+			base.Infof(base.KeyAll, "Wrote %s profile to %s", profileName, base.UD(params.File))
 		} else {
-			base.Infof(base.KeyAll, "Starting CPU profile to %s ...", base.UD(params.File))
-			pprof.StartCPUProfile(f)
+			return startCPUProfile(params.File, duration)
 		}
 	} else {
 		if profileName != "" {
 			return base.HTTPErrorf(http.StatusBadRequest, "Missing JSON 'file' parameter")
 		} else {
-			base.Infof(base.KeyAll, "...ending CPU profile.")
-			pprof.StopCPUProfile()
+			stopCPUProfile()
 		}
 	}
 	return nil
 }
 
-// ADMIN API to dump Go heap profile
+// ADMIN API to dump Go heap profiling.  Writes the profile to the given file, or to the response if no file is
+// given.  If gc is set, a garbage collection is run first so the snapshot only includes live objects.
 func (h *handler) handleHeapProfiling() error {
 	var params struct {
 		File string `json:"file"`
+		GC   bool   `json:"gc"`
 	}
 	body, err := h.readBody()
 	if err != nil {
 		return err
 	}
-	if err = json.Unmarshal(body, &params); err != nil {
-		return err
+	if len(body) > 0 {
+		if err = json.Unmarshal(body, &params); err != nil {
+			return err
+		}
+	}
+
+	if params.GC {
+		runtime.GC()
+	}
+
+	if params.File == "" {
+		h.setHeader("Content-Type", "application/octet-stream")
+		h.setHeader("Content-Disposition", `attachment; filename="heap"`)
+		return pprof.WriteHeapProfile(h.response)
 	}
 
 	base.Infof(base.KeyAll, "Dumping heap profile to %s ...", base.UD(params.File))
@@ -301,9 +420,8 @@ func (h *handler) handleHeapProfiling() error {
 	if err != nil {
 		return err
 	}
-	pprof.WriteHeapProfile(f)
-	f.Close()
-	return nil
+	defer f.Close()
+	return pprof.WriteHeapProfile(f)
 }
 
 func (h *handler) handlePprofGoroutine() error {
@@ -313,8 +431,7 @@ func (h *handler) handlePprofGoroutine() error {
 
 // Go execution tracer
 func (h *handler) handlePprofTrace() error {
-	log.Panicf("Disabled until we require Go1.5 as minimal version to build with")
-	// httpprof.Trace(h.response, h.rq)
+	httpprof.Trace(h.response, h.rq)
 	return nil
 }
 
@@ -339,8 +456,11 @@ func (h *handler) handlePprofProfile() error {
 }
 
 func (h *handler) handlePprofBlock() error {
-	httpprof.Handler("block").ServeHTTP(h.response, h.rq)
-	return nil
+	return h.writeSampledProfile("block", func(rate int) int {
+		// The block profile rate can't be read, and is off unless it's being sampled
+		runtime.SetBlockProfileRate(rate)
+		return 0
+	})
 }
 
 func (h *handler) handlePprofThreadcreate() error {
@@ -348,6 +468,46 @@ func (h *handler) handlePprofThreadcreate() error {
 	return nil
 }
 
+func (h *handler) handlePprofMutex() error {
+	return h.writeSampledProfile("mutex", runtime.SetMutexProfileFraction)
+}
+
+// Serializes the sampling of the block and mutex profiles, as their sampling rates are process-wide
+var sampledProfileLock sync.Mutex
+
+// Samples the block or mutex profile for ?seconds= (default 30) at ?rate= (default 1, which records every event), then
+// restores the sampling rate and writes the profile to the response.  The profiles aren't sampled otherwise, as it
+// slows down locking, but they're cumulative, so include the events of earlier samplings.  setRate sets the profile's
+// sampling rate and returns the previous one.
+func (h *handler) writeSampledProfile(name string, setRate func(rate int) (previous int)) error {
+	duration := time.Duration(h.getIntQuery("seconds", 30)) * time.Second
+	if duration <= 0 || duration > kMaxCPUProfileDuration {
+		return base.HTTPErrorf(http.StatusBadRequest, "seconds must be between 1 and %d", int(kMaxCPUProfileDuration.Seconds()))
+	}
+	rate := h.getIntQuery("rate", 1)
+	if rate == 0 {
+		return base.HTTPErrorf(http.StatusBadRequest, "rate must be at least 1")
+	}
+
+	sampledProfileLock.Lock()
+	defer sampledProfileLock.Unlock()
+	previous := setRate(int(rate))
+	select {
+	case <-time.After(duration):
+	case <-h.rq.Context().Done():
+	}
+	setRate(previous)
+
+	debug := int(h.getIntQuery("debug", 0))
+	if debug == 0 {
+		h.setHeader("Content-Type", "application/octet-stream")
+		h.setHeader("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, name))
+	} else {
+		h.setHeader("Content-Type", "text/plain; charset=utf-8")
+	}
+	return pprof.Lookup(name).WriteTo(h.response, debug)
+}
+
 type stats struct {
 	MemStats runtime.MemStats
 }
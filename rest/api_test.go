@@ -22,6 +22,9 @@ import (
 	"mime"
 	"mime/multipart"
 	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
 	"sort"
 	"strconv"
 	"strings"
@@ -68,6 +71,26 @@ func TestRoot(t *testing.T) {
 	goassert.Equals(t, response.Header().Get("Allow"), "GET, HEAD")
 }
 
+func TestGetServerTime(t *testing.T) {
+	var rt RestTester
+	defer rt.Close()
+
+	rt.createDoc(t, "doc")
+	before := time.Now()
+	response := rt.SendRequest("GET", "/db/_time", "")
+	assertStatus(t, response, http.StatusOK)
+	var body struct {
+		Time       time.Time `json:"time"`
+		TimeUnixMs int64     `json:"time_unix_ms"`
+		UpdateSeq  uint64    `json:"update_seq"`
+	}
+	assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &body))
+	assert.False(t, body.Time.Before(before.Truncate(time.Millisecond)))
+	assert.False(t, body.Time.After(time.Now()))
+	assert.Equal(t, body.Time.UnixNano()/int64(time.Millisecond), body.TimeUnixMs)
+	assert.Equal(t, uint64(1), body.UpdateSeq)
+}
+
 func (rt *RestTester) createDoc(t *testing.T, docid string) string {
 	response := rt.SendRequest("PUT", "/db/"+docid, `{"prop":true}`)
 	assertStatus(t, response, 201)
@@ -1599,6 +1622,44 @@ func TestCustomCookieName(t *testing.T) {
 
 }
 
+func TestSessionCookieAttributes(t *testing.T) {
+
+	rt := RestTester{
+		noAdminParty: true,
+		DatabaseConfig: &DbConfig{
+			Name: "db",
+			SessionCookie: &SessionCookieConfig{
+				HTTPOnly: base.BoolPtr(true),
+				SameSite: base.StringPointer("none"),
+				Domain:   base.StringPointer("example.com"),
+				Path:     base.StringPointer("/"),
+			},
+		},
+	}
+	defer rt.Close()
+
+	response := rt.SendAdminRequest("POST", "/db/_user/", `{"name":"user1", "password":"1234"}`)
+	assertStatus(t, response, 201)
+
+	// SameSite=None implies Secure
+	response = rt.SendRequest("POST", "/db/_session", `{"name":"user1", "password":"1234"}`)
+	assertStatus(t, response, 200)
+	setCookie := response.Header().Get("Set-Cookie")
+	for _, attribute := range []string{"Path=/;", "Domain=example.com", "HttpOnly", "Secure", "SameSite=None"} {
+		assert.Contains(t, setCookie, attribute)
+	}
+
+	// The cookie's deleted with the same attributes, or the browser wouldn't replace it
+	cookie := response.Result().Cookies()[0]
+	headers := map[string]string{"Cookie": fmt.Sprintf("%s=%s", cookie.Name, cookie.Value)}
+	response = rt.SendRequestWithHeaders("DELETE", "/db/_session", "", headers)
+	assertStatus(t, response, 200)
+	setCookie = response.Header().Get("Set-Cookie")
+	for _, attribute := range []string{"Path=/;", "Domain=example.com", "HttpOnly", "Secure", "SameSite=None"} {
+		assert.Contains(t, setCookie, attribute)
+	}
+}
+
 func TestReadChangesOptionsFromJSON(t *testing.T) {
 
 	h := &handler{}
@@ -3190,6 +3251,31 @@ func TestEventConfigValidationInvalid(t *testing.T) {
 
 }
 
+func TestBodyCompressionConfigValidation(t *testing.T) {
+
+	if !base.UnitTestUrlIsWalrus() {
+		t.Skip("This test only works under walrus")
+	}
+
+	sc := NewServerContext(&ServerConfig{})
+	defer sc.Close()
+
+	// Queries would see only the compressed bodies
+	configJSON := `{"name": "compressed",
+			"server": "walrus:",
+			"bucket": "compressed",
+			"body_compression": {"enabled": true},
+			"queries": {"all": {"select": "name"}}
+		}`
+
+	var dbConfig DbConfig
+	assert.NoError(t, json.Unmarshal([]byte(configJSON), &dbConfig))
+	_, err := sc.AddDatabaseFromConfig(&dbConfig)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "body_compression is not supported with queries")
+	}
+}
+
 // Reproduces https://github.com/couchbase/sync_gateway/issues/2427
 // NOTE: to repro, you must run with -race flag
 func TestBulkGetRevPruning(t *testing.T) {
@@ -3952,3 +4038,273 @@ func Benchmark_RestApiGetDocPerformanceFullRevCache(b *testing.B) {
 		}
 	})
 }
+
+func TestCorrelationIDHeader(t *testing.T) {
+	var rt RestTester
+	defer rt.Close()
+
+	response := rt.SendRequest("GET", "/db/", "")
+	assertStatus(t, response, 200)
+	firstID := response.Header().Get(correlationIDHeader)
+	assert.True(t, strings.HasPrefix(firstID, "#"), "Expected correlation ID header to be set, got %q", firstID)
+
+	// Unmatched routes still get a correlation ID
+	response = rt.SendRequest("GET", "/db/_nonexistent/foo", "")
+	assertStatus(t, response, 404)
+	secondID := response.Header().Get(correlationIDHeader)
+	assert.True(t, strings.HasPrefix(secondID, "#"), "Expected correlation ID header to be set, got %q", secondID)
+	assert.NotEqual(t, firstID, secondID)
+}
+
+func TestHealthCheck(t *testing.T) {
+	var rt RestTester
+	defer rt.Close()
+	defer func(ttl time.Duration) { healthCheckCacheTTL = ttl }(healthCheckCacheTTL)
+	healthCheckCacheTTL = 0
+
+	var health healthResponse
+	response := rt.SendAdminRequest("GET", "/_health", "")
+	assertStatus(t, response, 200)
+	assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &health))
+	assert.Equal(t, healthStatusOK, health.Status)
+	dbHealth := health.Databases["db"]
+	if assert.NotNil(t, dbHealth) {
+		assert.Equal(t, healthStatusOK, dbHealth.Status)
+		for _, check := range []string{"bucket", "feed", "state", "tasks"} {
+			if assert.Contains(t, dbHealth.Checks, check) {
+				assert.Equal(t, healthStatusOK, dbHealth.Checks[check].Status, "check %s: %s", check, dbHealth.Checks[check].Error)
+			}
+		}
+	}
+
+	// The public port only reports the overall status
+	health = healthResponse{}
+	response = rt.SendRequest("GET", "/_health", "")
+	assertStatus(t, response, 200)
+	assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &health))
+	assert.Equal(t, healthStatusOK, health.Status)
+	assert.Nil(t, health.Databases)
+
+	// An offline database fails the health check
+	response = rt.SendAdminRequest("POST", "/db/_offline", "")
+	assertStatus(t, response, 200)
+
+	health = healthResponse{}
+	response = rt.SendAdminRequest("GET", "/_health", "")
+	assertStatus(t, response, 503)
+	assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &health))
+	assert.Equal(t, healthStatusFail, health.Status)
+	assert.Equal(t, healthStatusFail, health.Databases["db"].Checks["state"].Status)
+	assert.Equal(t, "Offline", health.Databases["db"].Checks["state"].Detail)
+
+	response = rt.SendRequest("GET", "/_health", "")
+	assertStatus(t, response, 503)
+
+	// Results are reused until they're older than the TTL
+	healthCheckCacheTTL = time.Minute
+	assertStatus(t, rt.SendRequest("GET", "/_health", ""), 503)
+	assertStatus(t, rt.SendAdminRequest("POST", "/db/_online", ""), 200)
+	rt.WaitForDBOnline()
+	assertStatus(t, rt.SendRequest("GET", "/_health", ""), 503)
+	healthCheckCacheTTL = 0
+	assertStatus(t, rt.SendRequest("GET", "/_health", ""), 200)
+}
+
+func TestProfilingEndpoints(t *testing.T) {
+	var rt RestTester
+	defer rt.Close()
+
+	dir, err := ioutil.TempDir("", "profiling")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	cpuProfile := filepath.Join(dir, "cpu.prof")
+
+	// Start a time-boxed CPU profile.  Only one CPU profile can run at a time.
+	response := rt.SendAdminRequest("POST", "/_profile", fmt.Sprintf(`{"file":%q, "duration_secs":1}`, cpuProfile))
+	assertStatus(t, response, 200)
+	response = rt.SendAdminRequest("POST", "/_profile", fmt.Sprintf(`{"file":%q}`, filepath.Join(dir, "other.prof")))
+	assertStatus(t, response, 409)
+
+	// The profile should be stopped automatically once the duration has elapsed
+	err, _ = base.RetryLoop("wait for CPU profile to stop", func() (shouldRetry bool, err error, value interface{}) {
+		cpuProfileLock.Lock()
+		defer cpuProfileLock.Unlock()
+		return cpuProfileFile != nil, nil, nil
+	}, base.CreateSleeperFunc(50, 100))
+	assert.NoError(t, err)
+	info, err := os.Stat(cpuProfile)
+	assert.NoError(t, err)
+	assert.True(t, info.Size() > 0)
+
+	response = rt.SendAdminRequest("POST", "/_profile", fmt.Sprintf(`{"file":%q, "duration_secs":100000}`, cpuProfile))
+	assertStatus(t, response, 400)
+
+	// Heap profile is returned in the response when no file is given
+	response = rt.SendAdminRequest("POST", "/_heap", `{"gc":true}`)
+	assertStatus(t, response, 200)
+	assert.True(t, response.Body.Len() > 0)
+
+	response = rt.SendAdminRequest("GET", "/_debug/pprof/goroutine?debug=2", "")
+	assertStatus(t, response, 200)
+	assert.Contains(t, response.Body.String(), "goroutine")
+
+	// Mutex profile is sampled for the given time, and the sampling rate restored afterwards
+	response = rt.SendAdminRequest("GET", "/_debug/pprof/mutex?seconds=1&debug=1", "")
+	assertStatus(t, response, 200)
+	assert.Contains(t, response.Body.String(), "mutex")
+	assert.Equal(t, 0, runtime.SetMutexProfileFraction(-1))
+
+	response = rt.SendAdminRequest("GET", "/_debug/pprof/block?seconds=0", "")
+	assertStatus(t, response, 400)
+}
+
+func TestConflictsEndpoint(t *testing.T) {
+	var rt RestTester
+	defer rt.Close()
+
+	type conflictsResponse struct {
+		Conflicts    []db.ConflictedDoc `json:"conflicts"`
+		NextStartKey string             `json:"next_startkey"`
+	}
+
+	// Create conflicted docs doc1 and doc3, and non-conflicted doc2
+	assertStatus(t, rt.SendAdminRequest("PUT", "/db/doc1?new_edits=false", `{"_rev":"1-a", "channels":["A"]}`), 201)
+	assertStatus(t, rt.SendAdminRequest("PUT", "/db/doc1?new_edits=false", `{"_rev":"1-b", "channels":["A"]}`), 201)
+	assertStatus(t, rt.SendAdminRequest("PUT", "/db/doc2", `{"channels":["A"]}`), 201)
+	assertStatus(t, rt.SendAdminRequest("PUT", "/db/doc3?new_edits=false", `{"_rev":"1-a", "channels":["B"]}`), 201)
+	assertStatus(t, rt.SendAdminRequest("PUT", "/db/doc3?new_edits=false", `{"_rev":"1-b", "channels":["B"]}`), 201)
+
+	response := rt.SendAdminRequest("GET", "/db/_conflicts", "")
+	assertStatus(t, response, 200)
+	var result conflictsResponse
+	assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &result))
+	assert.Equal(t, 2, len(result.Conflicts))
+	assert.Equal(t, "", result.NextStartKey)
+	assert.Equal(t, "doc1", result.Conflicts[0].DocID)
+	assert.Equal(t, "1-b", result.Conflicts[0].CurrentRev)
+	assert.Equal(t, []string{"1-b", "1-a"}, result.Conflicts[0].LeafRevs)
+	assert.Equal(t, "doc3", result.Conflicts[1].DocID)
+
+	// Paging
+	response = rt.SendAdminRequest("GET", "/db/_conflicts?limit=1", "")
+	assertStatus(t, response, 200)
+	result = conflictsResponse{}
+	assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &result))
+	assert.Equal(t, 1, len(result.Conflicts))
+	assert.Equal(t, "doc1", result.Conflicts[0].DocID)
+	assert.Equal(t, "doc3", result.NextStartKey)
+
+	response = rt.SendAdminRequest("GET", `/db/_conflicts?limit=1&startkey="doc3"`, "")
+	assertStatus(t, response, 200)
+	result = conflictsResponse{}
+	assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &result))
+	assert.Equal(t, 1, len(result.Conflicts))
+	assert.Equal(t, "doc3", result.Conflicts[0].DocID)
+	assert.Equal(t, "", result.NextStartKey)
+
+	// Users only see the conflicted docs they have access to
+	a := rt.ServerContext().Database("db").Authenticator()
+	bob, err := a.NewUser("bob", "letmein", channels.SetOf("B"))
+	assert.NoError(t, err)
+	assert.NoError(t, a.Save(bob))
+	request, _ := http.NewRequest("GET", "/db/_conflicts", nil)
+	request.SetBasicAuth("bob", "letmein")
+	response = rt.Send(request)
+	assertStatus(t, response, 200)
+	result = conflictsResponse{}
+	assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &result))
+	assert.Equal(t, 1, len(result.Conflicts))
+	assert.Equal(t, "doc3", result.Conflicts[0].DocID)
+
+	// Resolving the conflict removes the doc from the list
+	assertStatus(t, rt.SendAdminRequest("DELETE", "/db/doc3?rev=1-a", ""), 200)
+	response = rt.SendAdminRequest("GET", "/db/_conflicts", "")
+	assertStatus(t, response, 200)
+	result = conflictsResponse{}
+	assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &result))
+	assert.Equal(t, 1, len(result.Conflicts))
+	assert.Equal(t, "doc1", result.Conflicts[0].DocID)
+}
+
+func TestResolveConflicts(t *testing.T) {
+	var rt RestTester
+	defer rt.Close()
+
+	createConflict := func(docID string) {
+		assertStatus(t, rt.SendAdminRequest("PUT", "/db/"+docID+"?new_edits=false", `{"_rev":"1-a"}`), 201)
+		assertStatus(t, rt.SendAdminRequest("PUT", "/db/"+docID+"?new_edits=false", `{"_rev":"1-b"}`), 201)
+	}
+	resolve := func(query string) db.ConflictResolutionResult {
+		response := rt.SendAdminRequest("POST", "/db/_resolve_conflicts"+query, "")
+		assertStatus(t, response, 200)
+		var result db.ConflictResolutionResult
+		assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &result))
+		return result
+	}
+	createConflict("doc1")
+	createConflict("doc2")
+
+	// Preview reports the conflicts without resolving them
+	result := resolve("?preview=true")
+	assert.False(t, result.Ready)
+	assert.Equal(t, 0, len(result.Resolved))
+	assert.Equal(t, []db.UnresolvedConflict{{DocID: "doc1"}, {DocID: "doc2"}}, result.Remaining)
+
+	assertStatus(t, rt.SendAdminRequest("POST", "/db/_resolve_conflicts?policy=unknown", ""), 400)
+
+	// The default policy keeps the winning revision
+	result = resolve("")
+	assert.True(t, result.Ready)
+	assert.Equal(t, []string{"doc1", "doc2"}, result.Resolved)
+	assert.Equal(t, 0, len(result.Remaining))
+	response := rt.SendAdminRequest("GET", "/db/doc1", "")
+	assertStatus(t, response, 200)
+	var body db.Body
+	assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &body))
+	assert.Equal(t, "1-b", body[db.BodyRev])
+
+	// The delete policy tombstones the document
+	createConflict("doc3")
+	result = resolve("?policy=delete")
+	assert.True(t, result.Ready)
+	assert.Equal(t, []string{"doc3"}, result.Resolved)
+	assertStatus(t, rt.SendAdminRequest("GET", "/db/doc3", ""), 404)
+
+	result = resolve("?preview=true")
+	assert.True(t, result.Ready)
+}
+
+func TestStructuredErrorResponse(t *testing.T) {
+	rt := RestTester{}
+	defer rt.Close()
+
+	errorResponse := func(response *TestResponse) (body ErrorResponse) {
+		assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &body))
+		assert.Equal(t, response.Header().Get("X-Correlation-ID"), body.CorrelationID)
+		return body
+	}
+
+	response := rt.SendAdminRequest("GET", "/db/missing", "")
+	assertStatus(t, response, 404)
+	body := errorResponse(response)
+	assert.Equal(t, "not_found", body.Error)
+	assert.Equal(t, "missing", body.Reason)
+	assert.Equal(t, base.ErrorCodeNotFound, body.Code)
+	assert.False(t, body.Retryable)
+
+	response = rt.SendAdminRequest("PUT", "/db/doc", `{"invalid"`)
+	assertStatus(t, response, 400)
+	assert.Equal(t, base.ErrorCodeInvalidJSON, errorResponse(response).Code)
+
+	// Errors written directly with a status get its default code
+	response = rt.SendAdminRequest("GET", "/db/_unknown_endpoint/x/y", "")
+	assertStatus(t, response, 404)
+	assert.Equal(t, base.ErrorCodeNotFound, errorResponse(response).Code)
+
+	assertStatus(t, rt.SendAdminRequest("POST", "/db/_offline", ""), 200)
+	response = rt.SendAdminRequest("GET", "/db/doc", "")
+	assertStatus(t, response, 503)
+	body = errorResponse(response)
+	assert.Equal(t, base.ErrorCodeDatabaseUnavailable, body.Code)
+	assert.True(t, body.Retryable)
+}
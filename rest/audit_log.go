@@ -0,0 +1,220 @@
+package rest
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbase/sync_gateway/db"
+)
+
+// The file name used for the file audit sink when no file_path is configured.
+const defaultAuditLogFilename = "sg_audit.log"
+
+// The actor recorded for admin API requests that don't identify a user.
+const defaultAuditActor = "admin"
+
+// Opens the sink configured in config.AuditLog and starts recording admin API mutations to it.
+// Does nothing if the audit log isn't enabled.
+func (sc *ServerContext) startAuditLogger() error {
+	config := sc.config.AuditLog
+	if config == nil || !config.Enabled {
+		return nil
+	}
+
+	var sink base.AuditSink
+	switch config.Sink {
+	case "", base.AuditSinkFile:
+		var path string
+		if config.FilePath != nil {
+			path = *config.FilePath
+		} else if sc.config.Logging != nil && sc.config.Logging.LogFilePath != "" {
+			path = filepath.Join(sc.config.Logging.LogFilePath, defaultAuditLogFilename)
+		} else {
+			return errors.New("audit_log.file_path must be set when logging.log_file_path is not")
+		}
+		fileSink, err := base.NewFileAuditSink(path)
+		if err != nil {
+			return err
+		}
+		sink = fileSink
+		base.Infof(base.KeyAll, "Writing audit log to %s", base.UD(path))
+	case base.AuditSinkBucket:
+		if config.Bucket == nil {
+			return errors.New("audit_log.bucket must be set when using the bucket sink")
+		}
+		bucket, err := base.GetBucket(config.MakeBucketSpec(), nil)
+		if err != nil {
+			return err
+		}
+		node, err := os.Hostname()
+		if err != nil {
+			bucket.Close()
+			return err
+		}
+		sink = base.NewBucketAuditSink(bucket, node)
+		base.Infof(base.KeyAll, "Writing audit log of node %s to bucket %s", base.MD(node), base.MD(*config.Bucket))
+	default:
+		return fmt.Errorf("invalid audit_log.sink: %q (valid options: %q, %q)", config.Sink, base.AuditSinkFile, base.AuditSinkBucket)
+	}
+
+	logger, err := base.NewAuditLogger(sink)
+	if err != nil {
+		_ = sink.Close()
+		return err
+	}
+
+	sc.auditLogger = logger
+	return nil
+}
+
+func (sc *ServerContext) stopAuditLogger() {
+	if sc.auditLogger == nil {
+		return
+	}
+	if err := sc.auditLogger.Close(); err != nil {
+		base.Warnf(base.KeyAll, "Error closing audit log: %v", err)
+	}
+	sc.auditLogger = nil
+}
+
+// Sets the before/after summaries recorded in this request's audit entry.  Summaries must not contain secrets.
+func (h *handler) setAuditSummary(before, after interface{}) {
+	h.auditBefore = base.AuditSummary(before)
+	h.auditAfter = base.AuditSummary(after)
+}
+
+// Records the request in the audit log, if it's a mutating admin API call.
+func (h *handler) logAudit() {
+	if h.server.auditLogger == nil || h.privs != adminPrivs {
+		return
+	}
+	switch h.rq.Method {
+	case "GET", "HEAD", "OPTIONS":
+		return
+	}
+	h.writeAuditEntry()
+}
+
+// Records a request rejected by the admin API's IP filter in the audit log, whatever its method.
+func (h *handler) logAuditDenied() {
+	if h.server.auditLogger == nil {
+		return
+	}
+	h.writeAuditEntry()
+}
+
+func (h *handler) writeAuditEntry() {
+	entry := base.AuditEntry{
+		Actor:    defaultAuditActor,
+		SourceIP: h.rq.RemoteAddr,
+		Method:   h.rq.Method,
+		Path:     h.rq.URL.Path,
+		Status:   h.status,
+		Before:   h.auditBefore,
+		After:    h.auditAfter,
+	}
+	if username, _, ok := h.rq.BasicAuth(); ok && username != "" {
+		entry.Actor = username
+	}
+	if host, _, err := net.SplitHostPort(h.rq.RemoteAddr); err == nil {
+		entry.SourceIP = host
+	}
+	if h.db != nil {
+		entry.Database = h.db.Name
+	}
+
+	if err := h.server.auditLogger.Log(entry); err != nil {
+		base.ErrorfCtx(h.logCtx, base.KeyAll, "Unable to write audit log entry for %s %s: %v", h.rq.Method, base.UD(h.rq.URL.Path), err)
+	}
+}
+
+// Returns a copy of the given database config that's safe to record in the audit log, with credentials removed.
+// Entries can't be changed once they're chained into the log, so every secret the config can hold has to be removed
+// here: bucket passwords, credentials in URLs, and the secrets of event handlers and cache notifications.
+func auditDbConfigSummary(config *DbConfig) *DbConfig {
+	if config == nil {
+		return nil
+	}
+	summary, err := config.DeepCopy()
+	if err != nil {
+		base.Warnf(base.KeyAll, "Unable to copy database config for the audit log: %v", err)
+		return nil
+	}
+	for _, field := range summary.secretFields() {
+		*field = ""
+	}
+	summary.Users = nil
+	summary.Roles = nil
+	summary.ChannelIndex = nil
+	summary.OIDCConfig = nil
+
+	buckets := []*BucketConfig{&summary.BucketConfig, summary.MetadataBucket}
+	if summary.Shadow != nil {
+		buckets = append(buckets, &summary.Shadow.BucketConfig)
+	}
+	if summary.Deprecated.Shadow != nil {
+		buckets = append(buckets, &summary.Deprecated.Shadow.BucketConfig)
+	}
+	if summary.SharedRevCache != nil {
+		buckets = append(buckets, summary.SharedRevCache.Bucket)
+	}
+	if summary.ColdStorage != nil {
+		buckets = append(buckets, summary.ColdStorage.Bucket)
+	}
+	for _, bucket := range buckets {
+		if bucket == nil {
+			continue
+		}
+		bucket.Password = ""
+		if bucket.Server != nil {
+			server := base.RedactBasicAuthURL(*bucket.Server)
+			bucket.Server = &server
+		}
+	}
+
+	if summary.CacheNotifications != nil {
+		summary.CacheNotifications.Secret = ""
+	}
+	auditEventHandlersSummary(summary.EventHandlers)
+	return summary
+}
+
+// Removes the secrets from the event handlers of a copied database config, which are unmarshalled generically.
+func auditEventHandlersSummary(eventHandlers interface{}) {
+	handlersByEvent, ok := eventHandlers.(map[string]interface{})
+	if !ok {
+		return
+	}
+	for _, handlers := range handlersByEvent {
+		handlerList, ok := handlers.([]interface{})
+		if !ok {
+			continue
+		}
+		for _, handler := range handlerList {
+			handler, ok := handler.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			delete(handler, "signing_secrets")
+			delete(handler, "fcm_server_key")
+			for _, key := range []string{"url", "dead_letter_url"} {
+				if url, ok := handler[key].(string); ok {
+					handler[key] = base.RedactBasicAuthURL(url)
+				}
+			}
+		}
+	}
+}
+
+// Returns the principal's config, without its password, for recording in the audit log.
+func auditPrincipalSummary(dbc *db.DatabaseContext, name string, isUser bool) *db.PrincipalConfig {
+	info, err := dbc.GetPrincipal(name, isUser)
+	if err != nil {
+		return nil
+	}
+	return info
+}
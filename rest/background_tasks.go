@@ -0,0 +1,64 @@
+package rest
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbase/sync_gateway/db"
+)
+
+// Converts a database's background tasks config to its options.
+func makeBackgroundTaskOptions(config *BackgroundTasksConfig) (options db.BackgroundTaskOptions, err error) {
+	if config == nil {
+		return options, nil
+	}
+	if config.MaxConcurrent != nil {
+		if *config.MaxConcurrent <= 0 {
+			return options, fmt.Errorf("background_tasks.max_concurrent: %d must be greater than zero", *config.MaxConcurrent)
+		}
+		options.MaxConcurrent = *config.MaxConcurrent
+	}
+	for _, window := range config.Windows {
+		parsed, err := db.ParseBackgroundTaskWindow(window)
+		if err != nil {
+			return options, fmt.Errorf("background_tasks.windows: %q %v", window, err)
+		}
+		options.Windows = append(options.Windows, parsed)
+	}
+	return options, nil
+}
+
+// HTTP handler for a GET of _tasks, which lists the database's background tasks on all of its nodes
+func (h *handler) handleGetBackgroundTasks() error {
+	tasks, err := h.db.BackgroundTasks.List()
+	if err != nil {
+		return err
+	}
+	h.writeJSON(db.Body{"tasks": tasks})
+	return nil
+}
+
+// HTTP handler for a POST to _tasks/{taskid}, which pauses, resumes or cancels one of this node's tasks, as given by
+// the action query parameter
+func (h *handler) handleBackgroundTaskAction() error {
+	taskID := h.PathVar("taskid")
+	action := h.getQuery("action")
+	var err error
+	switch action {
+	case "pause":
+		err = h.db.BackgroundTasks.Pause(taskID)
+	case "resume":
+		err = h.db.BackgroundTasks.Resume(taskID)
+	case "cancel":
+		err = h.db.BackgroundTasks.Cancel(taskID)
+	default:
+		return base.HTTPErrorf(http.StatusBadRequest, "action must be pause, resume or cancel")
+	}
+	if err != nil {
+		return err
+	}
+	h.setAuditSummary(nil, db.Body{"task": taskID, "action": action})
+	h.writeJSON(db.Body{"ok": true})
+	return nil
+}
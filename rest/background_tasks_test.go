@@ -0,0 +1,67 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/couchbase/sync_gateway/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackgroundTasks(t *testing.T) {
+	var rt RestTester
+	defer rt.Close()
+
+	// _compact responds straight away with the ID of the task
+	response := rt.SendAdminRequest("POST", "/db/_compact", "")
+	assertStatus(t, response, http.StatusAccepted)
+	var started struct {
+		ID string `json:"id"`
+	}
+	assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &started))
+	assert.NotEmpty(t, started.ID)
+
+	var list struct {
+		Tasks []db.BackgroundTaskStatus `json:"tasks"`
+	}
+	for i := 0; i < 100; i++ {
+		response = rt.SendAdminRequest("GET", "/db/_tasks", "")
+		assertStatus(t, response, http.StatusOK)
+		assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &list))
+		if len(list.Tasks) == 1 && list.Tasks[0].State == db.BackgroundTaskCompleted {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if assert.Len(t, list.Tasks, 1) {
+		assert.Equal(t, started.ID, list.Tasks[0].ID)
+		assert.Equal(t, db.BackgroundTaskCompact, list.Tasks[0].Name)
+		assert.Equal(t, db.BackgroundTaskCompleted, list.Tasks[0].State)
+		assert.Equal(t, float64(0), list.Tasks[0].Result)
+
+		// Finished tasks can't be paused
+		assertStatus(t, rt.SendAdminRequest("POST", "/db/_tasks/"+list.Tasks[0].ID+"?action=pause", ""), http.StatusNotFound)
+	}
+	assertStatus(t, rt.SendAdminRequest("POST", "/db/_tasks/nonexistent?action=cancel", ""), http.StatusNotFound)
+	assertStatus(t, rt.SendAdminRequest("POST", "/db/_tasks/nonexistent?action=restart", ""), http.StatusBadRequest)
+}
+
+func TestMakeBackgroundTaskOptions(t *testing.T) {
+	options, err := makeBackgroundTaskOptions(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, db.BackgroundTaskOptions{}, options)
+
+	maxConcurrent := 2
+	options, err = makeBackgroundTaskOptions(&BackgroundTasksConfig{MaxConcurrent: &maxConcurrent, Windows: []string{"22:00-02:00"}})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, options.MaxConcurrent)
+	assert.Equal(t, []db.BackgroundTaskWindow{{Start: 22 * time.Hour, End: 2 * time.Hour}}, options.Windows)
+
+	maxConcurrent = 0
+	_, err = makeBackgroundTaskOptions(&BackgroundTasksConfig{MaxConcurrent: &maxConcurrent})
+	assert.Error(t, err)
+	_, err = makeBackgroundTaskOptions(&BackgroundTasksConfig{Windows: []string{"nightly"}})
+	assert.Error(t, err)
+}
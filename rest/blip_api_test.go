@@ -1020,6 +1020,8 @@ func TestCheckpoint(t *testing.T) {
 	errorcode, ok := checkpointResponse.Properties["Error-Code"]
 	goassert.True(t, ok)
 	goassert.Equals(t, errorcode, "404")
+	goassert.Equals(t, checkpointResponse.Properties["code"], base.ErrorCodeNotFound)
+	goassert.Equals(t, checkpointResponse.Properties["retryable"], "false")
 
 	// Set a checkpoint
 	requestSetCheckpoint := blip.NewRequest()
@@ -1758,3 +1760,233 @@ func TestBlipNonDeltaSyncPush(t *testing.T) {
 	assert.Equal(t, http.StatusOK, resp.Code)
 	assert.Equal(t, `{"_id":"doc1","_rev":"2-abcxyz","greetings":[{"hello":"world!"},{"hi":"alice"},{"howdy":"bob"}]}`, resp.Body.String())
 }
+
+// Test that document expiry is carried by the exp property of rev messages, in both directions.
+func TestBlipRevExpiry(t *testing.T) {
+
+	defer base.SetUpTestLogging(base.LevelInfo, base.KeyHTTP|base.KeySync|base.KeySyncMsg)()
+
+	rt := RestTester{}
+	defer rt.Close()
+	expiry := "2100-01-01T00:00:00Z"
+	expiryTime, _ := time.Parse(time.RFC3339, expiry)
+
+	// Push a rev with an expiry
+	bt, err := NewBlipTesterFromSpec(BlipTesterSpec{restTester: &rt})
+	assert.NoError(t, err, "Unexpected error creating BlipTester")
+	defer bt.Close()
+	sent, _, resp, err := bt.SendRev("pushedDoc", "1-abc", []byte(`{"key": "val"}`), blip.Properties{revMessageExpiry: expiry})
+	assert.True(t, sent)
+	assert.NoError(t, err)
+	assert.Equal(t, "", resp.Properties["Error-Code"])
+
+	response := rt.SendAdminRequest(http.MethodGet, "/db/pushedDoc?show_exp=true", "")
+	assertStatus(t, response, http.StatusOK)
+	var responseBody RestDocument
+	assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &responseBody))
+	pushedExpiry, err := time.Parse(time.RFC3339, responseBody[db.BodyExpiry].(string))
+	assert.NoError(t, err)
+	assert.True(t, expiryTime.Equal(pushedExpiry))
+
+	// An invalid expiry is rejected
+	_, _, resp, err = bt.SendRev("invalidExpiryDoc", "1-abc", []byte(`{"key": "val"}`), blip.Properties{revMessageExpiry: "tomorrow"})
+	assert.NoError(t, err)
+	assert.Equal(t, "400", resp.Properties["Error-Code"])
+
+	// Pull a doc written over REST with an expiry
+	client, err := NewBlipTesterClient(&rt)
+	assert.NoError(t, err)
+	defer client.Close()
+	client.StartPull()
+
+	response = rt.SendAdminRequest(http.MethodPut, "/db/pulledDoc", `{"key": "val", "_exp": "`+expiry+`"}`)
+	assertStatus(t, response, http.StatusCreated)
+	var putResponse struct{ Rev string }
+	assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &putResponse))
+
+	data, ok := client.WaitForRev("pulledDoc", putResponse.Rev)
+	assert.True(t, ok)
+	assert.Equal(t, `{"key":"val"}`, string(data))
+
+	var revMsg *blip.Message
+	for serialNumber := blip.MessageNumber(1); serialNumber < 10 && revMsg == nil; serialNumber++ {
+		if msg, found := client.pullReplication.GetMessage(serialNumber); found && msg.Profile() == messageRev && msg.Properties[revMessageId] == "pulledDoc" {
+			revMsg = msg
+		}
+	}
+	if assert.NotNil(t, revMsg) {
+		pulledExpiry, err := time.Parse(time.RFC3339, revMsg.Properties[revMessageExpiry])
+		assert.NoError(t, err)
+		assert.True(t, expiryTime.Equal(pulledExpiry))
+	}
+}
+
+// Test multiple clients pushing and pulling concurrently against the same Sync Gateway.
+func TestBlipConcurrentClients(t *testing.T) {
+
+	defer base.SetUpTestLogging(base.LevelInfo, base.KeyHTTP|base.KeySync|base.KeySyncMsg)()
+
+	rt := RestTester{}
+	defer rt.Close()
+
+	const numClients = 3
+	const numDocsPerClient = 5
+	clients, err := NewBlipTesterClients(&rt, numClients)
+	assert.NoError(t, err)
+	for _, client := range clients {
+		defer client.Close()
+	}
+
+	// Each client pushes its own docs, all at the same time
+	pushes := make([]func() error, 0, numClients)
+	for i, client := range clients {
+		i, client := i, client
+		pushes = append(pushes, func() error {
+			for j := 0; j < numDocsPerClient; j++ {
+				if _, err := client.PushRev(fmt.Sprintf("client%d-doc%d", i, j), "", []byte(`{"key": "val"}`)); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	assert.NoError(t, RunConcurrently(10*time.Second, pushes...))
+
+	// Every client pulls every other client's docs
+	for _, client := range clients {
+		assert.NoError(t, client.StartPull())
+	}
+	for _, client := range clients {
+		for i := 0; i < numClients; i++ {
+			for j := 0; j < numDocsPerClient; j++ {
+				_, ok := client.WaitForRev(fmt.Sprintf("client%d-doc%d", i, j), "1-abcxyz")
+				assert.True(t, ok)
+			}
+		}
+	}
+
+	// Updates to a doc arrive at every client in order
+	revID := ""
+	for i := 0; i < 5; i++ {
+		resource := "/db/sharedDoc"
+		if revID != "" {
+			resource += "?rev=" + revID
+		}
+		response := rt.SendAdminRequest(http.MethodPut, resource, fmt.Sprintf(`{"update": %d}`, i))
+		assertStatus(t, response, http.StatusCreated)
+		var putResponse struct{ Rev string }
+		assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &putResponse))
+		revID = putResponse.Rev
+	}
+	for _, client := range clients {
+		_, ok := client.WaitForRev("sharedDoc", revID)
+		assert.True(t, ok)
+		client.AssertRevsReceivedInOrder(t, "sharedDoc")
+	}
+
+	// Each client's checkpoints are kept separately
+	for i, client := range clients {
+		_, _, found, err := client.GetCheckpoint()
+		assert.NoError(t, err)
+		assert.False(t, found)
+
+		checkpointRev, err := client.SetCheckpoint("", db.Body{"client": i})
+		assert.NoError(t, err)
+		assert.Equal(t, "0-1", checkpointRev)
+	}
+	for i, client := range clients {
+		checkpoint, checkpointRev, found, err := client.GetCheckpoint()
+		assert.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, "0-1", checkpointRev)
+		assert.Equal(t, float64(i), checkpoint["client"])
+	}
+}
+
+// An event handler that forwards the replication events it receives
+type testReplicationEventHandler struct {
+	events chan db.Body
+}
+
+func (h *testReplicationEventHandler) HandleEvent(event db.Event) {
+	if replicationEvent, ok := event.(*db.ReplicationEvent); ok {
+		h.events <- replicationEvent.Doc
+	}
+}
+
+func (h *testReplicationEventHandler) String() string {
+	return "Test replication event handler"
+}
+
+// Test that replication events are raised when a client connects, completes a one-shot pull, and disconnects
+func TestBlipReplicationEvents(t *testing.T) {
+
+	defer base.SetUpTestLogging(base.LevelInfo, base.KeyHTTP|base.KeySync|base.KeyEvents)()
+
+	rt := RestTester{}
+	events := make(chan db.Body, 10)
+	database := rt.GetDatabase()
+	database.EventMgr.RegisterEventHandler(&testReplicationEventHandler{events: events}, db.ReplicationChange)
+	database.EventMgr.Start(0, -1)
+
+	bt, err := NewBlipTesterFromSpec(BlipTesterSpec{restTester: &rt})
+	assert.NoError(t, err, "Error creating BlipTester")
+	defer bt.Close()
+
+	nextEvent := func(state string) db.Body {
+		select {
+		case event := <-events:
+			assert.Equal(t, state, event["state"])
+			return event
+		case <-time.After(10 * time.Second):
+			t.Fatalf("Timed out waiting for replication %s event", state)
+			return nil
+		}
+	}
+
+	started := nextEvent(db.ReplicationStarted)
+	replicationID := started["replication_id"]
+	assert.NotEmpty(t, replicationID)
+	assert.Nil(t, started["client_id"])
+
+	// Identify the client by setting a checkpoint, and push a doc
+	_, _, checkpointResponse, err := bt.SetCheckpoint("testclient", "", []byte(`{"local": 1}`))
+	assert.NoError(t, err)
+	_, err = checkpointResponse.Body()
+	assert.NoError(t, err)
+	_, _, revResponse, err := bt.SendRev("doc1", "1-abc", []byte(`{"key": "val"}`), blip.Properties{})
+	assert.NoError(t, err)
+	_, err = revResponse.Body()
+	assert.NoError(t, err)
+	assert.NoError(t, rt.WaitForPendingChanges())
+
+	// Pull the doc back with a one-shot subChanges, asking for every change
+	bt.blipContext.HandlerForProfile["changes"] = func(request *blip.Message) {
+		var changes []interface{}
+		assert.NoError(t, request.ReadJSONBody(&changes))
+		if !request.NoReply() {
+			answer := make([]interface{}, len(changes))
+			for i := range answer {
+				answer[i] = []interface{}{}
+			}
+			request.Response().SetJSONBody(answer)
+		}
+	}
+	bt.blipContext.HandlerForProfile["rev"] = func(request *blip.Message) {}
+
+	subChangesRequest := blip.NewRequest()
+	subChangesRequest.SetProfile("subChanges")
+	subChangesRequest.Properties["continuous"] = "false"
+	assert.True(t, bt.sender.Send(subChangesRequest))
+
+	completed := nextEvent(db.ReplicationCompleted)
+	assert.Equal(t, replicationID, completed["replication_id"])
+	assert.Equal(t, "testclient", completed["client_id"])
+	assert.Equal(t, uint64(1), completed["docs_sent"])
+	assert.Equal(t, uint64(1), completed["docs_received"])
+	assert.Equal(t, "1", database.DbStats.StatsDatabase().Get(base.StatKeyNumReplicationsCompleted).String())
+
+	bt.sender.Close()
+	stopped := nextEvent(db.ReplicationStopped)
+	assert.Equal(t, replicationID, stopped["replication_id"])
+}
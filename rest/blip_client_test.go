@@ -4,14 +4,19 @@ import (
 	"encoding/json"
 	"fmt"
 	"sync"
+	"testing"
 	"time"
 
 	"github.com/couchbase/go-blip"
 	"github.com/couchbase/sync_gateway/base"
 	"github.com/couchbase/sync_gateway/db"
 	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
 )
 
+// How long WaitForRev and WaitForMessage wait before giving up
+const blipTesterWaitTimeout = 10 * time.Second
+
 // BlipTesterClient is a fully fledged client to emulate CBL behaviour on both push and pull replications through methods on this type.
 type BlipTesterClient struct {
 	ClientDeltas bool // Support deltas on the client side
@@ -19,6 +24,7 @@ type BlipTesterClient struct {
 	rt *RestTester
 
 	docs                  map[string]map[string][]byte // Client's local store of documents - Map of docID to rev ID to bytes
+	revsReceived          map[string][]string          // Rev IDs pulled for each docID, in the order they arrived.  Protected by docsLock
 	lastReplicatedRev     map[string]string            // Latest known rev pulled or pushed
 	docsLock              sync.RWMutex                 // lock for docs map
 	lastReplicatedRevLock sync.RWMutex                 // lock for lastReplicatedRev map
@@ -169,6 +175,7 @@ func (btr *BlipTesterReplicator) initHandlers(btc *BlipTesterClient) {
 		} else {
 			btc.docs[docID] = map[string][]byte{revID: body}
 		}
+		btc.revsReceived[docID] = append(btc.revsReceived[docID], revID)
 		btc.updateLastReplicatedRev(docID, revID)
 		btc.docsLock.Unlock()
 
@@ -231,6 +238,7 @@ func NewBlipTesterClient(rt *RestTester) (client *BlipTesterClient, err error) {
 	btc := BlipTesterClient{
 		rt:                rt,
 		docs:              make(map[string]map[string][]byte),
+		revsReceived:      make(map[string][]string),
 		lastReplicatedRev: make(map[string]string),
 	}
 
@@ -249,6 +257,22 @@ func NewBlipTesterClient(rt *RestTester) (client *BlipTesterClient, err error) {
 	return &btc, nil
 }
 
+// NewBlipTesterClients returns numClients clients connected to the same RestTester, for testing concurrent replications.
+func NewBlipTesterClients(rt *RestTester, numClients int) (clients []*BlipTesterClient, err error) {
+	clients = make([]*BlipTesterClient, 0, numClients)
+	for i := 0; i < numClients; i++ {
+		client, err := NewBlipTesterClient(rt)
+		if err != nil {
+			for _, client := range clients {
+				client.Close()
+			}
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+	return clients, nil
+}
+
 // StartPull will begin a continuous pull replication since 0 between the client and server
 func (btc *BlipTesterClient) StartPull() (err error) {
 	return btc.StartPullSince("true", "0")
@@ -283,6 +307,7 @@ func (btc *BlipTesterClient) StartPullSince(continuous, since string) (err error
 func (btc *BlipTesterClient) Close() {
 	btc.docsLock.Lock()
 	btc.docs = make(map[string]map[string][]byte, 0)
+	btc.revsReceived = make(map[string][]string, 0)
 	btc.lastReplicatedRev = make(map[string]string, 0)
 	btc.docsLock.Unlock()
 
@@ -314,9 +339,11 @@ func (btc *BlipTesterClient) PushRev(docID, parentRev string, body []byte) (revI
 				parentDocBody = parentDoc
 				btc.docs[docID][newRevID] = body
 			} else {
+				btc.docsLock.Unlock()
 				return "", fmt.Errorf("docID: %v with parent rev: %v was not found on the client", docID, parentRev)
 			}
 		} else {
+			btc.docsLock.Unlock()
 			return "", fmt.Errorf("docID: %v was not found on the client", docID)
 		}
 	} else {
@@ -397,18 +424,89 @@ func (btc *BlipTesterClient) GetRev(docID, revID string) (data []byte, found boo
 }
 
 // WaitForRev blocks until the given doc ID and rev ID have been stored by the client, and returns the data when found.
+// Returns found=false if the rev hasn't been stored within blipTesterWaitTimeout.
 func (btc *BlipTesterClient) WaitForRev(docID, revID string) (data []byte, found bool) {
 	ticker := time.NewTicker(time.Millisecond * 50)
+	defer ticker.Stop()
+	timeout := time.After(blipTesterWaitTimeout)
 	for {
 		select {
 		case <-ticker.C:
 			if data, found := btc.GetRev(docID, revID); found {
 				return data, found
 			}
+		case <-timeout:
+			return nil, false
 		}
 	}
 }
 
+// ReceivedRevs returns the rev IDs pulled by the client for the given doc ID, in the order they arrived.
+func (btc *BlipTesterClient) ReceivedRevs(docID string) []string {
+	btc.docsLock.RLock()
+	defer btc.docsLock.RUnlock()
+
+	return append([]string(nil), btc.revsReceived[docID]...)
+}
+
+// AssertRevsReceivedInOrder asserts that the client never pulled a rev of the given doc with a lower generation than
+// one it had already pulled.
+func (btc *BlipTesterClient) AssertRevsReceivedInOrder(t *testing.T, docID string) {
+	revIDs := btc.ReceivedRevs(docID)
+	for i := 1; i < len(revIDs); i++ {
+		prevGen, _ := db.ParseRevID(revIDs[i-1])
+		gen, _ := db.ParseRevID(revIDs[i])
+		assert.True(t, gen > prevGen, "Doc %s received out of order: %v", docID, revIDs)
+	}
+}
+
+// SetCheckpoint stores a checkpoint for the client's pull replication, and returns the rev ID of the new checkpoint.
+// parentRev must be the rev ID of the existing checkpoint, if there is one.
+func (btc *BlipTesterClient) SetCheckpoint(parentRev string, checkpoint db.Body) (revID string, err error) {
+	body, err := json.Marshal(checkpoint)
+	if err != nil {
+		return "", err
+	}
+
+	scm := NewSetCheckpointMessage()
+	scm.setClient(btc.pullReplication.id)
+	scm.setRev(parentRev)
+	scm.SetBody(body)
+	if err := btc.pullReplication.sendMsg(scm.Message); err != nil {
+		return "", err
+	}
+
+	scr := &SetCheckpointResponse{scm.Response()}
+	if errorCode := scr.Properties["Error-Code"]; errorCode != "" {
+		return "", fmt.Errorf("error from setCheckpoint: %s", errorCode)
+	}
+	return scr.Rev(), nil
+}
+
+// GetCheckpoint returns the checkpoint stored for the client's pull replication, and its rev ID.
+// Returns found=false if there isn't one.
+func (btc *BlipTesterClient) GetCheckpoint() (checkpoint db.Body, revID string, found bool, err error) {
+	getCheckpointRequest := blip.NewRequest()
+	getCheckpointRequest.SetProfile(messageGetCheckpoint)
+	getCheckpointRequest.Properties[blipClient] = btc.pullReplication.id
+	if err := btc.pullReplication.sendMsg(getCheckpointRequest); err != nil {
+		return nil, "", false, err
+	}
+
+	response := getCheckpointRequest.Response()
+	switch errorCode := response.Properties["Error-Code"]; errorCode {
+	case "":
+	case "404":
+		return nil, "", false, nil
+	default:
+		return nil, "", false, fmt.Errorf("error from getCheckpoint: %s", errorCode)
+	}
+	if err := response.ReadJSONBody(&checkpoint); err != nil {
+		return nil, "", false, err
+	}
+	return checkpoint, response.Properties[getCheckpointResponseRev], true, nil
+}
+
 // GetMessage returns the message stored in the Client under the given serial number
 func (btr *BlipTesterReplicator) GetMessage(serialNumber blip.MessageNumber) (msg *blip.Message, found bool) {
 	btr.messagesLock.RLock()
@@ -422,14 +520,19 @@ func (btr *BlipTesterReplicator) GetMessage(serialNumber blip.MessageNumber) (ms
 }
 
 // WaitForMessage blocks until the given message serial number has been stored by the replicator, and returns the message when found.
+// Returns found=false if the message hasn't been stored within blipTesterWaitTimeout.
 func (btr *BlipTesterReplicator) WaitForMessage(serialNumber blip.MessageNumber) (msg *blip.Message, found bool) {
 	ticker := time.NewTicker(time.Millisecond * 50)
+	defer ticker.Stop()
+	timeout := time.After(blipTesterWaitTimeout)
 	for {
 		select {
 		case <-ticker.C:
 			if msg, ok := btr.GetMessage(serialNumber); ok {
 				return msg, ok
 			}
+		case <-timeout:
+			return nil, false
 		}
 	}
 }
@@ -5,6 +5,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"math"
 	"net/http"
 	"regexp"
 	"runtime/debug"
@@ -50,8 +51,24 @@ type blipSyncContext struct {
 	activeSubChanges    uint32    // Flag for whether there is a subChanges subscription currently active.  Atomic access
 	useDeltas           bool      // Whether deltas can be used for this connection - This should be set via setUseDeltas()
 	sgCanUseDeltas      bool      // Whether deltas can be used by Sync Gateway for this connection
+	userAgent           string    // The client's User-Agent header, for replication events
+	clientID            string    // The client's checkpoint ID, once known, for replication events.  Protected by lock
+	docsSent            uint64    // # of revisions sent to the client.  Atomic access
+	docsReceived        uint64    // # of revisions received from the client.  Atomic access
+	changesPending      int32     // # of "changes" messages awaiting a response from the client.  Atomic access
+	oneShotState        uint32    // Progress of a one-shot pull towards completion, for replication events.  Atomic access
+
+	// Set if the node is a read-only replica and the client's connected to the public API, so pushes are rejected
+	readOnly *ReadOnlyConfig
 }
 
+// Values of blipSyncContext.oneShotState
+const (
+	oneShotPulling   = uint32(iota) // Not caught up yet, or the pull is continuous
+	oneShotCaughtUp                 // All changes have been sent, but some haven't been answered yet
+	oneShotCompleted                // All changes the client asked for have been sent
+)
+
 type blipHandler struct {
 	*blipSyncContext
 	db           *db.Database
@@ -94,15 +111,20 @@ var kHandlersByProfile = map[string]blipHandlerMethod{
 	messageGetCheckpoint:  (*blipHandler).handleGetCheckpoint,
 	messageSetCheckpoint:  (*blipHandler).handleSetCheckpoint,
 	messageSubChanges:     userBlipHandler((*blipHandler).handleSubChanges),
-	messageChanges:        userBlipHandler((*blipHandler).handleChanges),
-	messageRev:            userBlipHandler((*blipHandler).handleRev),
+	messageChanges:        writeBlipHandler(userBlipHandler((*blipHandler).handleChanges)),
+	messageRev:            writeBlipHandler(userBlipHandler((*blipHandler).handleRev)),
 	messageGetAttachment:  userBlipHandler((*blipHandler).handleGetAttachment),
-	messageProposeChanges: (*blipHandler).handleProposeChanges,
+	messageProposeChanges: writeBlipHandler((*blipHandler).handleProposeChanges),
 }
 
 // HTTP handler for incoming BLIP sync WebSocket request (/db/_blipsync)
 func (h *handler) handleBLIPSync() error {
 
+	if err := h.db.StartReplication(); err != nil {
+		return err
+	}
+	defer h.db.EndReplication()
+
 	h.db.DatabaseContext.DbStats.StatsDatabase().Add(base.StatKeyNumReplicationsActive, 1)
 	h.db.DatabaseContext.DbStats.StatsDatabase().Add(base.StatKeyNumReplicationsTotal, 1)
 	defer h.db.DatabaseContext.DbStats.StatsDatabase().Add(base.StatKeyNumReplicationsActive, -1)
@@ -118,7 +140,7 @@ func (h *handler) handleBLIPSync() error {
 
 	// Overwrite the existing logging context with the blip context ID
 	h.db.Ctx = context.WithValue(h.db.Ctx, base.LogContextKey{},
-		base.LogContext{CorrelationID: formatBlipContextID(blipContext.ID)},
+		base.LogContext{CorrelationID: formatBlipContextID(blipContext.ID), Database: h.db.Name},
 	)
 	blipContext.Logger = DefaultBlipLogger(h.db.Ctx)
 
@@ -128,6 +150,8 @@ func (h *handler) handleBLIPSync() error {
 		db:                h.db,
 		effectiveUsername: h.currentEffectiveUserName(),
 		terminator:        make(chan bool),
+		userAgent:         h.rq.Header.Get("User-Agent"),
+		readOnly:          h.readOnlyConfig(),
 	}
 	defer ctx.close()
 
@@ -141,21 +165,53 @@ func (h *handler) handleBLIPSync() error {
 
 	ctx.blipContext.FatalErrorHandler = func(err error) {
 		ctx.Logf(base.LevelInfo, base.KeyHTTP, "%s:     --> BLIP+WebSocket connection error: %v", h.formatSerialNumber(), err)
+		ctx.db.DatabaseContext.DbStats.StatsDatabase().Add(base.StatKeyNumReplicationErrors, 1)
+		ctx.raiseReplicationEvent(db.ReplicationError, err)
+	}
+
+	// If the database allows it and the client offers it, messages are compressed with permessage-deflate
+	response := h.response
+	compression := h.db.Options.WebSocketCompression
+	useDeflate := compression.Enabled && acceptsWebSocketDeflate(h.rq.Header)
+	if useDeflate {
+		response = &deflateResponseWriter{ResponseWriter: h.response, options: compression}
 	}
 
 	// Create a BLIP WebSocket handler and have it handle the request:
 	server := blipContext.WebSocketServer()
+	defaultHandshake := server.Handshake
+	server.Handshake = func(config *websocket.Config, rq *http.Request) error {
+		if err := h.checkWebSocketOrigin(rq); err != nil {
+			return err
+		}
+		if defaultHandshake != nil {
+			if err := defaultHandshake(config, rq); err != nil {
+				return err
+			}
+		}
+		// The handshake response's Date header lets clients estimate their clock skew against the server
+		if config.Header == nil {
+			config.Header = http.Header{}
+		}
+		config.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+		if useDeflate {
+			config.Header.Set("Sec-WebSocket-Extensions", webSocketDeflateResponse)
+		}
+		return nil
+	}
 	defaultHandler := server.Handler
 	server.Handler = func(conn *websocket.Conn) {
 		h.logStatus(101, fmt.Sprintf("[%s] Upgraded to BLIP+WebSocket protocol. User:%s.", blipContext.ID, ctx.effectiveUsername))
+		ctx.raiseReplicationEvent(db.ReplicationStarted, nil)
 		defer func() {
 			conn.Close() // in case it wasn't closed already
 			ctx.Logf(base.LevelInfo, base.KeyHTTP, "%s:    --> BLIP+WebSocket connection closed", h.formatSerialNumber())
+			ctx.raiseReplicationEvent(db.ReplicationStopped, nil)
 		}()
 		defaultHandler(conn)
 	}
 
-	server.ServeHTTP(h.response, h.rq)
+	server.ServeHTTP(response, h.rq)
 	return nil
 }
 
@@ -173,10 +229,15 @@ func (ctx *blipSyncContext) register(profile string, handlerFn func(*blipHandler
 			serialNumber:    ctx.incrementSerialNumber(),
 		}
 
-		if err := handlerFn(&handler, rq); err != nil {
+		err := ctx.db.CheckOpRate()
+		if err == nil {
+			err = handlerFn(&handler, rq)
+		}
+		if err != nil {
 			status, msg := base.ErrorAsHTTPStatus(err)
 			if response := rq.Response(); response != nil {
 				response.SetError("HTTP", status, msg)
+				setErrorProperties(response.Properties, err, formatBlipContextID(ctx.blipContext.ID))
 			}
 			ctx.Logf(base.LevelInfo, base.KeySyncMsg, "#%d: Type:%s   --> %d %s Time:%v User:%s", handler.serialNumber, profile, status, msg, time.Since(startTime), ctx.effectiveUsername)
 		} else {
@@ -196,6 +257,54 @@ func (ctx *blipSyncContext) close() {
 	close(ctx.terminator)
 }
 
+// Raises a replication event for the connection, if there's a handler for it.  err is only given for
+// ReplicationError.
+func (ctx *blipSyncContext) raiseReplicationEvent(state string, err error) {
+	if !ctx.db.EventMgr.HasHandlerForEvent(db.ReplicationChange) {
+		return
+	}
+	ctx.lock.Lock()
+	clientID := ctx.clientID
+	ctx.lock.Unlock()
+
+	status := db.ReplicationStatus{
+		State:         state,
+		ReplicationID: ctx.blipContext.ID,
+		Username:      ctx.effectiveUsername,
+		ClientID:      clientID,
+		UserAgent:     ctx.userAgent,
+		DocsSent:      atomic.LoadUint64(&ctx.docsSent),
+		DocsReceived:  atomic.LoadUint64(&ctx.docsReceived),
+	}
+	if err != nil {
+		status.Error = err.Error()
+	}
+	if err := ctx.db.EventMgr.RaiseReplicationEvent(status); err != nil {
+		ctx.Logf(base.LevelDebug, base.KeyEvents, "Unable to raise replication %s event: %v", state, err)
+	}
+}
+
+// Records the client's checkpoint ID, to identify it in replication events.
+func (ctx *blipSyncContext) setClientID(clientID string) {
+	ctx.lock.Lock()
+	ctx.clientID = clientID
+	ctx.lock.Unlock()
+}
+
+// Called when a one-shot pull has caught up, and whenever the client answers a "changes" message.  Once both
+// have happened and no "changes" messages are left unanswered, all the revisions the client asked for have been
+// sent, and the pull has completed.
+func (ctx *blipSyncContext) checkOneShotCompleted() {
+	if atomic.LoadInt32(&ctx.changesPending) > 0 {
+		return
+	}
+	if atomic.CompareAndSwapUint32(&ctx.oneShotState, oneShotCaughtUp, oneShotCompleted) {
+		ctx.Logf(base.LevelInfo, base.KeySync, "One-shot pull completed. User:%s", base.UD(ctx.effectiveUsername))
+		ctx.db.DatabaseContext.DbStats.StatsDatabase().Add(base.StatKeyNumReplicationsCompleted, 1)
+		ctx.raiseReplicationEvent(db.ReplicationCompleted, nil)
+	}
+}
+
 // Handler for unknown requests
 func (ctx *blipSyncContext) notFound(rq *blip.Message) {
 	ctx.Logf(base.LevelInfo, base.KeySync, "%s Type:%q User:%s", rq, rq.Profile(), ctx.effectiveUsername)
@@ -225,6 +334,7 @@ func (bh *blipHandler) handleGetCheckpoint(rq *blip.Message) error {
 
 	client := rq.Properties[blipClient]
 	bh.logEndpointEntry(rq.Profile(), fmt.Sprintf("Client:%s", client))
+	bh.setClientID(client)
 
 	docID := fmt.Sprintf("checkpoint/%s", client)
 	response := rq.Response()
@@ -251,6 +361,7 @@ func (bh *blipHandler) handleSetCheckpoint(rq *blip.Message) error {
 
 	checkpointMessage := SetCheckpointMessage{rq}
 	bh.logEndpointEntry(rq.Profile(), checkpointMessage.String())
+	bh.setClientID(checkpointMessage.client())
 
 	docID := fmt.Sprintf("checkpoint/%s", checkpointMessage.client())
 
@@ -414,6 +525,12 @@ func (bh *blipHandler) sendChanges(sender *blip.Sender, params *subChangesParams
 		bh.db.DatabaseContext.NotifyTerminatedChanges(bh.db.User().Name())
 	}
 
+	// A one-shot pull completes once the client's answered all the changes sent
+	if !bh.continuous && !forceClose {
+		atomic.StoreUint32(&bh.oneShotState, oneShotCaughtUp)
+		bh.checkOneShotCompleted()
+	}
+
 }
 
 func (bh *blipHandler) sendBatchOfChanges(sender *blip.Sender, changeArray [][]interface{}) {
@@ -423,6 +540,7 @@ func (bh *blipHandler) sendBatchOfChanges(sender *blip.Sender, changeArray [][]i
 	if len(changeArray) > 0 {
 		// Spawn a goroutine to await the client's response:
 		sendTime := time.Now()
+		atomic.AddInt32(&bh.changesPending, 1)
 		sender.Send(outrq)
 		go bh.handleChangesResponse(sender, outrq.Response(), changeArray, sendTime)
 	} else {
@@ -444,6 +562,10 @@ func (bh *blipHandler) handleChangesResponse(sender *blip.Sender, response *blip
 			base.Warnf(base.KeyAll, "[%s] PANIC handling 'changes' response: %v\n%s", bh.blipContext.ID, panicked, debug.Stack())
 		}
 	}()
+	defer func() {
+		atomic.AddInt32(&bh.changesPending, -1)
+		bh.checkOneShotCompleted()
+	}()
 
 	if response.Type() == blip.ErrorType {
 		errorBody, _ := response.Body()
@@ -555,8 +677,10 @@ func (bh *blipHandler) handleChanges(rq *blip.Message) error {
 	}()
 
 	for _, change := range changeList {
-		docID := change[1].(string)
-		revID := change[2].(string)
+		docID, revID, err := parseChangeDocRev(change, 1)
+		if err != nil {
+			return err
+		}
 		missing, possible := bh.db.RevDiff(docID, []string{revID})
 		if nWritten > 0 {
 			output.Write([]byte(","))
@@ -577,6 +701,20 @@ func (bh *blipHandler) handleChanges(rq *blip.Message) error {
 	return nil
 }
 
+// Returns the docID and revID at change[i] and change[i+1] of an entry in a changes or proposeChanges list.  The
+// list comes from the client, so a 400 error is returned if they're missing or not strings.
+func parseChangeDocRev(change []interface{}, i int) (docID, revID string, err error) {
+	if len(change) < i+2 {
+		return "", "", base.HTTPErrorf(http.StatusBadRequest, "Changes entry is missing docID or revID")
+	}
+	docID, docOK := change[i].(string)
+	revID, revOK := change[i+1].(string)
+	if !docOK || !revOK {
+		return "", "", base.HTTPErrorf(http.StatusBadRequest, "Changes entry has invalid docID or revID")
+	}
+	return docID, revID, nil
+}
+
 // Handles a "proposeChanges" request, similar to "changes" but in no-conflicts mode
 func (bh *blipHandler) handleProposeChanges(rq *blip.Message) error {
 	var changeList [][]interface{}
@@ -599,11 +737,16 @@ func (bh *blipHandler) handleProposeChanges(rq *blip.Message) error {
 	}()
 
 	for i, change := range changeList {
-		docID := change[0].(string)
-		revID := change[1].(string)
+		docID, revID, err := parseChangeDocRev(change, 0)
+		if err != nil {
+			return err
+		}
 		parentRevID := ""
 		if len(change) > 2 {
-			parentRevID = change[2].(string)
+			var ok bool
+			if parentRevID, ok = change[2].(string); !ok {
+				return base.HTTPErrorf(http.StatusBadRequest, "Invalid parent revID in proposeChanges entry %d", i)
+			}
 		}
 		status := bh.db.CheckProposedRev(docID, revID, parentRevID)
 		if status != 0 {
@@ -637,13 +780,13 @@ func (bh *blipHandler) sendRevAsDelta(sender *blip.Sender, seq db.SequenceID, do
 
 	delta, err := bh.db.GetDelta(docID, deltaSrcRevID, revID)
 	if err != nil {
-		bh.Logf(base.LevelInfo, base.KeySync, "DELTA: error generating delta from %s to %s for key %s; falling back to full body replication.  err: %v", deltaSrcRevID, revID, base.UD(docID), err)
+		bh.Logf(base.LevelInfo, base.KeySync, "DELTA: error generating delta from %s to %s for key %s; falling back to full body replication.  err: %v", deltaSrcRevID, revID, base.UDDocID(docID), err)
 		bh.sendRevOrNorev(sender, seq, docID, revID, knownRevs, maxHistory)
 		return
 	}
 
 	if delta == nil {
-		bh.Logf(base.LevelDebug, base.KeySync, "DELTA: unable to generate delta from %s to %s for key %s; falling back to full body replication.", deltaSrcRevID, revID, base.UD(docID))
+		bh.Logf(base.LevelDebug, base.KeySync, "DELTA: unable to generate delta from %s to %s for key %s; falling back to full body replication.", deltaSrcRevID, revID, base.UDDocID(docID))
 		bh.sendRevOrNorev(sender, seq, docID, revID, knownRevs, maxHistory)
 		return
 	}
@@ -661,13 +804,22 @@ func (bh *blipHandler) sendDelta(delta []byte, deltaSrcRevID string, sender *bli
 		return
 	}
 
-	bh.Logf(base.LevelDebug, base.KeySync, "Sending rev %q %s as delta based on %d known. DeltaSrc:%s  User:%s", base.UD(docID), revID, len(knownRevs), deltaSrcRevID, base.UD(bh.effectiveUsername))
-	bh.sendRevisionWithProperties(body, sender, seq, docID, revID, knownRevs, maxHistory, blip.Properties{revMessageDeltaSrc: deltaSrcRevID})
+	properties := blip.Properties{revMessageDeltaSrc: deltaSrcRevID}
+
+	// The delta doesn't carry the document's expiry, so look it up from the (by now cached) revision
+	if revBody, err := bh.db.GetRevWithHistory(docID, revID, 0, nil, nil, true); err == nil {
+		if expiry, ok := revBody[db.BodyExpiry].(string); ok {
+			properties[revMessageExpiry] = expiry
+		}
+	}
+
+	bh.Logf(base.LevelDebug, base.KeySync, "Sending rev %q %s as delta based on %d known. DeltaSrc:%s  User:%s", base.UDDocID(docID), revID, len(knownRevs), deltaSrcRevID, base.UD(bh.effectiveUsername))
+	bh.sendRevisionWithProperties(body, sender, seq, docID, revID, knownRevs, maxHistory, properties)
 }
 
 func (bh *blipHandler) sendRevOrNorev(sender *blip.Sender, seq db.SequenceID, docID string, revID string, knownRevs map[string]bool, maxHistory int) {
 
-	body, err := bh.db.GetRev(docID, revID, true, nil)
+	body, err := bh.db.GetRevWithHistory(docID, revID, math.MaxInt32, nil, nil, true)
 	if err != nil {
 		bh.sendNoRev(err, sender, seq, docID, revID)
 	} else {
@@ -677,7 +829,7 @@ func (bh *blipHandler) sendRevOrNorev(sender *blip.Sender, seq db.SequenceID, do
 
 func (bh *blipHandler) sendNoRev(err error, sender *blip.Sender, seq db.SequenceID, docID string, revID string) {
 
-	bh.Logf(base.LevelDebug, base.KeySync, "Sending norev %q %s due to unavailable revision: %v.  User:%s", base.UD(docID), revID, err, base.UD(bh.effectiveUsername))
+	bh.Logf(base.LevelDebug, base.KeySync, "Sending norev %q %s due to unavailable revision: %v.  User:%s", base.UDDocID(docID), revID, err, base.UD(bh.effectiveUsername))
 
 	noRevRq := NewNoRevMessage()
 	noRevRq.setId(docID)
@@ -688,6 +840,7 @@ func (bh *blipHandler) sendNoRev(err error, sender *blip.Sender, seq db.Sequence
 
 	// Add a "reason" field that gives more detailed explanation on the cause of the error.
 	noRevRq.setReason(reason)
+	setErrorProperties(noRevRq.Properties, err, formatBlipContextID(bh.blipContext.ID))
 
 	noRevRq.SetNoReply(true)
 	sender.Send(noRevRq.Message)
@@ -696,7 +849,7 @@ func (bh *blipHandler) sendNoRev(err error, sender *blip.Sender, seq db.Sequence
 
 // Pushes a revision body to the client
 func (bh *blipHandler) sendRevision(body db.Body, sender *blip.Sender, seq db.SequenceID, docID string, revID string, knownRevs map[string]bool, maxHistory int) {
-	bh.Logf(base.LevelDebug, base.KeySync, "Sending rev %q %s based on %d known.  User:%s", base.UD(docID), revID, len(knownRevs), base.UD(bh.effectiveUsername))
+	bh.Logf(base.LevelDebug, base.KeySync, "Sending rev %q %s based on %d known.  User:%s", base.UDDocID(docID), revID, len(knownRevs), base.UD(bh.effectiveUsername))
 	bh.sendRevisionWithProperties(body, sender, seq, docID, revID, knownRevs, maxHistory, nil)
 }
 
@@ -723,6 +876,9 @@ func (bh *blipHandler) sendRevisionWithProperties(body db.Body, sender *blip.Sen
 	}
 	outrq.setSequence(seq)
 	outrq.setHistory(history)
+	if expiry, ok := body[db.BodyExpiry].(string); ok {
+		outrq.setExpiry(expiry)
+	}
 
 	// add additional properties passed through
 	outrq.setProperties(properties)
@@ -730,14 +886,17 @@ func (bh *blipHandler) sendRevisionWithProperties(body db.Body, sender *blip.Sen
 	delete(body, db.BodyId)
 	delete(body, db.BodyRev)
 	delete(body, db.BodyDeleted)
+	delete(body, db.BodyExpiry)
 
 	outrq.SetJSONBody(body)
 
 	// Update read stats
 	if messageBody, err := outrq.Body(); err == nil {
 		bh.db.DbStats.StatsDatabase().Add(base.StatKeyDocReadsBytesBlip, int64(len(messageBody)))
+		bh.db.UsageStats.AddDocPulled(bh.usageUsername(), len(messageBody))
 	}
 	bh.db.DbStats.StatsDatabase().Add(base.StatKeyNumDocReadsBlip, 1)
+	atomic.AddUint64(&bh.docsSent, 1)
 
 	if atts := db.GetBodyAttachments(body); atts != nil {
 		// Allow client to download attachments in 'atts', but only while pulling this rev
@@ -786,6 +945,7 @@ func (bh *blipHandler) handleRev(rq *blip.Message) error {
 
 	if bodyBytes, err := rq.Body(); err == nil {
 		bh.db.DbStats.StatsDatabase().Add(base.StatKeyDocWritesBytesBlip, int64(len(bodyBytes)))
+		bh.db.UsageStats.AddDocPushed(bh.usageUsername(), len(bodyBytes))
 	}
 
 	// Doc metadata comes from the BLIP message metadata, not magic document properties:
@@ -831,7 +991,7 @@ func (bh *blipHandler) handleRev(rq *blip.Message) error {
 		}
 
 		body = db.Body(deltaSrcMap)
-		bh.Logf(base.LevelTrace, base.KeySync, "docID: %s - body after patching: %v", base.UD(docID), base.UD(body))
+		bh.Logf(base.LevelTrace, base.KeySync, "docID: %s - body after patching: %v", base.UDDocID(docID), base.UDBody(body))
 		bh.db.DbStats.StatsDeltaSync().Add(base.StatKeyDeltaPushDocCount, 1)
 	}
 
@@ -839,6 +999,11 @@ func (bh *blipHandler) handleRev(rq *blip.Message) error {
 		body[db.BodyDeleted] = true
 	}
 
+	// PutExistingRev applies the expiry from the body
+	if expiry, found := revMessage.expiry(); found {
+		body[db.BodyExpiry] = expiry
+	}
+
 	// noconflicts flag from LiteCore
 	// https://github.com/couchbase/couchbase-lite-core/wiki/Replication-Protocol#rev
 	var noConflicts bool
@@ -862,14 +1027,24 @@ func (bh *blipHandler) handleRev(rq *blip.Message) error {
 		minRevpos++
 	}
 
+	// Attachments that have changed since the parent revision can be sent as deltas from the parent's versions
+	var deltaSrcDigests map[string]string
+	if bh.sgCanUseDeltas && len(history) > 1 && db.GetBodyAttachments(body) != nil {
+		deltaSrcDigests = bh.attachmentDigests(docID, history[1])
+	}
+
 	// Check for any attachments I don't have yet, and request them:
-	if err := bh.downloadOrVerifyAttachments(body, minRevpos, rq.Sender); err != nil {
+	if err := bh.downloadOrVerifyAttachments(body, minRevpos, rq.Sender, deltaSrcDigests); err != nil {
 		return err
 	}
 
 	// Finally, save the revision (with the new attachments inline)
 	bh.db.DbStats.CblReplicationPush().Add(base.StatKeyDocPushCount, 1)
-	return bh.db.PutExistingRev(docID, body, history, noConflicts)
+	if err := bh.db.PutExistingRev(docID, body, history, noConflicts); err != nil {
+		return err
+	}
+	atomic.AddUint64(&bh.docsReceived, 1)
+	return nil
 }
 
 //////// ATTACHMENTS:
@@ -891,19 +1066,89 @@ func (bh *blipHandler) handleGetAttachment(rq *blip.Message) error {
 	if err != nil {
 		return err
 	}
-	bh.Logf(base.LevelDebug, base.KeySync, "Sending attachment with digest=%q (%dkb) User:%s", digest, len(attachment)/1024, base.UD(bh.effectiveUsername))
 	response := rq.Response()
+
+	// If the client has another version of the attachment, send a binary delta from it when that's smaller
+	var delta []byte
+	deltaSrcDigest := getAttachmentParams.deltaSrc()
+	if deltaSrcDigest != "" && bh.sgCanUseDeltas {
+		delta = bh.attachmentDelta(rq.Sender, deltaSrcDigest, attachment)
+	}
+	if delta != nil {
+		bh.Logf(base.LevelDebug, base.KeySync, "Sending delta of attachment with digest=%q from deltaSrc=%q (%dkb of %dkb) User:%s", digest, deltaSrcDigest, len(delta)/1024, len(attachment)/1024, base.UD(bh.effectiveUsername))
+		response.Properties[getAttachmentDeltaSrc] = deltaSrcDigest
+		attachment = delta
+	} else {
+		bh.Logf(base.LevelDebug, base.KeySync, "Sending attachment with digest=%q (%dkb) User:%s", digest, len(attachment)/1024, base.UD(bh.effectiveUsername))
+	}
 	response.SetBody(attachment)
 	response.SetCompressed(rq.Properties[blipCompress] == "true")
 	bh.db.DatabaseContext.DbStats.StatsCblReplicationPull().Add(base.StatKeyAttachmentPullCount, 1)
 	bh.db.DatabaseContext.DbStats.StatsCblReplicationPull().Add(base.StatKeyAttachmentPullBytes, int64(len(attachment)))
+	bh.db.UsageStats.AddAttachmentPulled(bh.usageUsername(), len(attachment))
 
 	return nil
 }
 
+// Returns a binary delta of the attachment from the attachment with the digest deltaSrcDigest, or nil if the delta
+// isn't smaller than the attachment.  The client has to prove it has the deltaSrc attachment, as the delta reveals
+// which parts of it match the attachment.
+func (bh *blipHandler) attachmentDelta(sender *blip.Sender, deltaSrcDigest string, attachment []byte) []byte {
+	deltaSrc, err := bh.db.GetAttachment(db.AttachmentKey(deltaSrcDigest))
+	if err != nil {
+		bh.Logf(base.LevelDebug, base.KeySync, "Unable to get deltaSrc attachment %s, sending the full attachment: %v", deltaSrcDigest, err)
+		return nil
+	}
+	delta := base.BinaryDiff(deltaSrc, attachment)
+	if len(delta) >= len(attachment) {
+		return nil
+	}
+	if err := bh.proveAttachment(sender, deltaSrcDigest, deltaSrc); err != nil {
+		bh.Logf(base.LevelDebug, base.KeySync, "Client didn't prove it has deltaSrc attachment %s, sending the full attachment: %v", deltaSrcDigest, err)
+		return nil
+	}
+	return delta
+}
+
+// Asks the client to prove it has the attachment with the given digest and data.
+func (bh *blipHandler) proveAttachment(sender *blip.Sender, digest string, data []byte) error {
+	nonce, proof := db.GenerateProofOfAttachment(data)
+	outrq := blip.NewRequest()
+	outrq.Properties = map[string]string{blipProfile: messageProveAttachment, proveAttachmentDigest: digest}
+	outrq.SetBody(nonce)
+	sender.Send(outrq)
+	if body, err := outrq.Response().Body(); err != nil {
+		return err
+	} else if string(body) != proof {
+		bh.Logf(base.LevelDebug, base.KeySync, "Error: Incorrect proof for attachment %s : I sent nonce %x, expected proof %q, got %q.  User:%s", digest, base.MD(nonce), base.MD(proof), base.MD(body), base.UD(bh.effectiveUsername))
+		return base.HTTPErrorf(http.StatusForbidden, "Incorrect proof for attachment %s", digest)
+	}
+	return nil
+}
+
+// Returns the digests of the attachments of the given revision, by attachment name, or nil if the revision isn't
+// available to the user.
+func (bh *blipHandler) attachmentDigests(docID, revID string) map[string]string {
+	body, err := bh.db.GetRevCopy(docID, revID, false, nil, db.BodyShallowCopy)
+	if err != nil {
+		return nil
+	}
+	digests := make(map[string]string)
+	for name, value := range db.GetBodyAttachments(body) {
+		if meta, ok := value.(map[string]interface{}); ok {
+			if digest, ok := meta["digest"].(string); ok {
+				digests[name] = digest
+			}
+		}
+	}
+	return digests
+}
+
 // For each attachment in the revision, makes sure it's in the database, asking the client to
 // upload it if necessary. This method blocks until all the attachments have been processed.
-func (bh *blipHandler) downloadOrVerifyAttachments(body db.Body, minRevpos int, sender *blip.Sender) error {
+// deltaSrcDigests optionally maps attachment names to the digests of versions of the attachments the
+// client may send binary deltas from.
+func (bh *blipHandler) downloadOrVerifyAttachments(body db.Body, minRevpos int, sender *blip.Sender, deltaSrcDigests map[string]string) error {
 	return bh.db.ForEachStubAttachment(body, minRevpos,
 		func(name string, digest string, knownData []byte, meta map[string]interface{}) ([]byte, error) {
 			if knownData != nil {
@@ -912,18 +1157,7 @@ func (bh *blipHandler) downloadOrVerifyAttachments(body db.Body, minRevpos int,
 				// it knew the digest it could acquire the data by uploading a document with the
 				// claimed attachment, then downloading it.
 				bh.Logf(base.LevelDebug, base.KeySync, "    Verifying attachment %q (digest %s).  User:%s", base.UD(name), digest, base.UD(bh.effectiveUsername))
-				nonce, proof := db.GenerateProofOfAttachment(knownData)
-				outrq := blip.NewRequest()
-				outrq.Properties = map[string]string{blipProfile: messageProveAttachment, proveAttachmentDigest: digest}
-				outrq.SetBody(nonce)
-				sender.Send(outrq)
-				if body, err := outrq.Response().Body(); err != nil {
-					return nil, err
-				} else if string(body) != proof {
-					bh.Logf(base.LevelDebug, base.KeySync, "Error: Incorrect proof for attachment %s : I sent nonce %x, expected proof %q, got %q.  User:%s", digest, base.MD(nonce), base.MD(proof), base.MD(body), base.UD(bh.effectiveUsername))
-					return nil, base.HTTPErrorf(http.StatusForbidden, "Incorrect proof for attachment %s", digest)
-				}
-				return nil, nil
+				return nil, bh.proveAttachment(sender, digest, knownData)
 			} else {
 				// If I don't have the attachment, I will request it from the client:
 				bh.Logf(base.LevelDebug, base.KeySync, "    Asking for attachment %q (digest %s). User:%s", base.UD(name), digest, base.UD(bh.effectiveUsername))
@@ -932,12 +1166,48 @@ func (bh *blipHandler) downloadOrVerifyAttachments(body db.Body, minRevpos int,
 				if isCompressible(name, meta) {
 					outrq.Properties[blipCompress] = "true"
 				}
+				deltaSrcDigest := deltaSrcDigests[name]
+				if deltaSrcDigest != "" && deltaSrcDigest != digest {
+					outrq.Properties[getAttachmentDeltaSrc] = deltaSrcDigest
+				}
 				sender.Send(outrq)
-				return outrq.Response().Body()
+				attachment, err := outrq.Response().Body()
+				if err == nil && deltaSrcDigest != "" && outrq.Response().Properties[getAttachmentDeltaSrc] == deltaSrcDigest {
+					attachment, err = bh.patchAttachment(deltaSrcDigest, digest, attachment)
+				}
+				if err == nil {
+					bh.db.UsageStats.AddAttachmentPushed(bh.usageUsername(), len(attachment))
+				}
+				return attachment, err
 			}
 		})
 }
 
+// Applies a binary delta sent by the client to the deltaSrc attachment, and checks the result has the expected digest.
+func (bh *blipHandler) patchAttachment(deltaSrcDigest, digest string, delta []byte) ([]byte, error) {
+	deltaSrc, err := bh.db.GetAttachment(db.AttachmentKey(deltaSrcDigest))
+	if err != nil {
+		return nil, err
+	}
+	attachment, err := base.BinaryPatch(deltaSrc, delta)
+	if err != nil {
+		return nil, base.HTTPErrorf(http.StatusBadRequest, "Error patching deltaSrc attachment %s: %v", deltaSrcDigest, err)
+	}
+	if db.Sha1DigestKey(attachment) != digest {
+		return nil, base.HTTPErrorf(http.StatusBadRequest, "Patched attachment doesn't match digest %s", digest)
+	}
+	return attachment, nil
+}
+
+// Returns the name of the user that usage stats are recorded against for this replication.  Usage by the
+// admin user isn't tracked.
+func (bh *blipHandler) usageUsername() string {
+	if user := bh.db.User(); user != nil {
+		return externalUserName(user.Name())
+	}
+	return ""
+}
+
 func (ctx *blipSyncContext) incrementSerialNumber() uint64 {
 	return atomic.AddUint64(&ctx.handlerSerialNumber, 1)
 }
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"math"
+	"strconv"
 	"strings"
 
 	"github.com/couchbase/go-blip"
@@ -55,6 +56,7 @@ const (
 	revMessageHistory     = "history"
 	revMessageNoConflicts = "noconflicts"
 	revMessageDeltaSrc    = "deltaSrc"
+	revMessageExpiry      = "exp" // Document expiry, as an ISO-8601 date or a Couchbase Server expiry value
 
 	// norev message properties
 	norevMessageId     = "id"
@@ -62,6 +64,12 @@ const (
 	norevMessageError  = "error"
 	norevMessageReason = "reason"
 
+	// Properties added to error responses and norev messages, as in the body of REST error responses
+	errorPropertyCode          = "code"           // One of the base.ErrorCode constants
+	errorPropertyRetryable     = "retryable"      // "true" if retrying the request later may succeed
+	errorPropertyCorrelationID = "correlation_id" // Identifies the replication in the logs
+	errorPropertyDetails       = "details"        // JSON description of the error, for some codes
+
 	// changes message properties
 	changesResponseMaxHistory = "maxHistory"
 	changesResponseDeltas     = "deltas"
@@ -70,7 +78,8 @@ const (
 	proposeChangesResponseDeltas = "deltas"
 
 	// getAttachment message properties
-	getAttachmentDigest = "digest"
+	getAttachmentDigest   = "digest"
+	getAttachmentDeltaSrc = "deltaSrc" // Digest of an attachment the recipient can send a binary delta from
 
 	// proveAttachment
 	proveAttachmentDigest = "digest"
@@ -307,6 +316,11 @@ func (rm *revMessage) deltaSrc() (deltaSrc string, found bool) {
 	return deltaSrc, found
 }
 
+func (rm *revMessage) expiry() (expiry string, found bool) {
+	expiry, found = rm.Properties[revMessageExpiry]
+	return expiry, found
+}
+
 func (rm *revMessage) hasDeletedProperty() bool {
 	_, found := rm.Properties[revMessageDeleted]
 	return found
@@ -340,6 +354,14 @@ func (rm *revMessage) setProperties(properties blip.Properties) {
 	}
 }
 
+func (rm *revMessage) setExpiry(expiry string) {
+	if expiry != "" {
+		rm.Properties[revMessageExpiry] = expiry
+	} else {
+		delete(rm.Properties, revMessageExpiry)
+	}
+}
+
 func (rm *revMessage) setHistory(history []string) {
 	if len(history) > 0 {
 		rm.Properties[revMessageHistory] = strings.Join(history, ",")
@@ -377,6 +399,10 @@ func (rm *revMessage) String() string {
 		buffer.WriteString(fmt.Sprintf("DeltaSrc:%v ", deltaSrc))
 	}
 
+	if expiry, foundExpiry := rm.expiry(); foundExpiry {
+		buffer.WriteString(fmt.Sprintf("Exp:%v ", expiry))
+	}
+
 	if sequence, foundSequence := rm.sequence(); foundSequence == true {
 		buffer.WriteString(fmt.Sprintf("Sequence:%v ", sequence))
 	}
@@ -411,6 +437,19 @@ func (nrm *noRevMessage) setError(message string) {
 	nrm.Properties[norevMessageError] = message
 }
 
+// Sets the machine-readable error properties of a message, from the error it reports.
+func setErrorProperties(properties blip.Properties, err error, correlationID string) {
+	code, retryable := base.ErrorCode(err)
+	properties[errorPropertyCode] = code
+	properties[errorPropertyRetryable] = strconv.FormatBool(retryable)
+	properties[errorPropertyCorrelationID] = correlationID
+	if details := base.ErrorDetails(err); details != nil {
+		if detailsJSON, marshalErr := json.Marshal(details); marshalErr == nil {
+			properties[errorPropertyDetails] = string(detailsJSON)
+		}
+	}
+}
+
 type getAttachmentParams struct {
 	rq *blip.Message // The underlying BLIP message
 }
@@ -425,12 +464,20 @@ func (g *getAttachmentParams) digest() string {
 	return g.rq.Properties[getAttachmentDigest]
 }
 
+func (g *getAttachmentParams) deltaSrc() string {
+	return g.rq.Properties[getAttachmentDeltaSrc]
+}
+
 func (g *getAttachmentParams) String() string {
 
 	buffer := bytes.NewBufferString("")
 
 	buffer.WriteString(fmt.Sprintf("Digest:%v ", g.digest()))
 
+	if deltaSrc := g.deltaSrc(); deltaSrc != "" {
+		buffer.WriteString(fmt.Sprintf("DeltaSrc:%v ", deltaSrc))
+	}
+
 	return buffer.String()
 
 }
@@ -85,3 +85,16 @@ func TestSubChangesSince(t *testing.T) {
 	goassert.True(t, seqId.Seq == 1)
 
 }
+
+func TestParseChangeDocRev(t *testing.T) {
+	docID, revID, err := parseChangeDocRev([]interface{}{float64(1), "doc1", "1-abc"}, 1)
+	goassert.Equals(t, err, nil)
+	goassert.Equals(t, docID, "doc1")
+	goassert.Equals(t, revID, "1-abc")
+
+	// Entries come from the client, so malformed ones are rejected rather than trusted
+	_, _, err = parseChangeDocRev([]interface{}{"doc1"}, 0)
+	goassert.NotEquals(t, err, nil)
+	_, _, err = parseChangeDocRev([]interface{}{"doc1", float64(2)}, 0)
+	goassert.NotEquals(t, err, nil)
+}
@@ -218,6 +218,31 @@ func (h *handler) handleAllDocs() error {
 	return nil
 }
 
+// Default value of _conflicts?limit property
+const kDefaultConflictsLimit = 100
+
+// Maximum value of _conflicts?limit property
+const kMaxConflictsLimit = 1000
+
+// HTTP handler for _conflicts, which lists the documents that are currently in conflict.  Results are paged by doc
+// ID - a response with a next_startkey property has more results, which are fetched by passing it as the startkey.
+func (h *handler) handleConflicts() error {
+	startKey := h.getJSONStringQuery("startkey")
+	limit := getRestrictedIntQuery(h.getQueryValues(), "limit", kDefaultConflictsLimit, 1, kMaxConflictsLimit, false)
+
+	conflicts, nextKey, err := h.db.GetConflicts(startKey, int(limit))
+	if err != nil {
+		return err
+	}
+
+	response := db.Body{"conflicts": conflicts}
+	if nextKey != "" {
+		response["next_startkey"] = nextKey
+	}
+	h.writeJSON(response)
+	return nil
+}
+
 // HTTP handler for _dump
 func (h *handler) handleDump() error {
 	viewName := h.PathVar("view")
@@ -294,7 +319,7 @@ func (h *handler) handleRepair() error {
 func (h *handler) handleDumpChannel() error {
 	channelName := h.PathVar("channel")
 	since := h.getIntQuery("since", 0)
-	base.Infof(base.KeyHTTP, "Dump channel %q", base.UD(channelName))
+	base.Infof(base.KeyHTTP, "Dump channel %q", base.UDChannel(channelName))
 
 	chanLog := h.db.GetChangeLog(channelName, since)
 	if chanLog == nil {
@@ -416,8 +441,9 @@ func (h *handler) handleBulkGet() error {
 			if err != nil {
 				// Report error in the response for this doc:
 				status, reason := base.ErrorAsHTTPStatus(err)
+				code, retryable := base.ErrorCode(err)
 				errStr := base.CouchHTTPErrorName(status)
-				body = db.Body{"id": docid, "error": errStr, "reason": reason, "status": status}
+				body = db.Body{"id": docid, "error": errStr, "reason": reason, "status": status, "code": code, "retryable": retryable}
 				if revid != "" {
 					body["rev"] = revid
 				}
@@ -510,7 +536,11 @@ func (h *handler) handleBulkDocs() error {
 			status["status"] = code
 			status["error"] = base.CouchHTTPErrorName(code)
 			status["reason"] = msg
-			base.Infof(base.KeyAll, "\tBulkDocs: Doc %q --> %d %s (%v)", base.UD(docid), code, msg, err)
+			status["code"], status["retryable"] = base.ErrorCode(err)
+			if details := base.ErrorDetails(err); details != nil {
+				status["details"] = details
+			}
+			base.Infof(base.KeyAll, "\tBulkDocs: Doc %q --> %d %s (%v)", base.UDDocID(docid), code, msg, err)
 			err = nil // wrote it to output already; not going to return it
 		} else {
 			status["rev"] = revid
@@ -536,7 +566,8 @@ func (h *handler) handleBulkDocs() error {
 			status["status"] = code
 			status["error"] = base.CouchHTTPErrorName(code)
 			status["reason"] = msg
-			base.Infof(base.KeyAll, "\tBulkDocs: Local Doc %q --> %d %s (%v)", base.UD(docid), code, msg, err)
+			status["code"], status["retryable"] = base.ErrorCode(err)
+			base.Infof(base.KeyAll, "\tBulkDocs: Local Doc %q --> %d %s (%v)", base.UDDocID(docid), code, msg, err)
 			err = nil
 		} else {
 			status["rev"] = revid
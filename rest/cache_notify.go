@@ -0,0 +1,50 @@
+package rest
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbase/sync_gateway/db"
+)
+
+// Converts a database's cache notifications config to its options.
+func makeCacheNotifyOptions(config *CacheNotificationsConfig) (options db.CacheNotifyOptions, err error) {
+	if config == nil || config.Enabled == nil || !*config.Enabled {
+		return options, nil
+	}
+	if config.AdvertiseURL == "" {
+		return options, errors.New("cache_notifications.advertise_url is required when notifications are enabled")
+	}
+	if u, err := url.Parse(config.AdvertiseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return options, errors.New("cache_notifications.advertise_url must be an http or https URL")
+	}
+	if config.Secret == "" {
+		return options, errors.New("cache_notifications.secret is required when notifications are enabled")
+	}
+	options.Enabled = true
+	options.AdvertiseURL = config.AdvertiseURL
+	options.Secret = config.Secret
+	return options, nil
+}
+
+// HTTP handler for a POST to _cache_notify, by which another node sends the revisions it has written.  The node has to
+// send the database's shared secret, as the notifications go straight into the channel caches.
+func (h *handler) handleCacheNotify() error {
+	options := h.db.Options.CacheNotifyOptions
+	if !options.Enabled {
+		return base.HTTPErrorf(http.StatusNotFound, "Cache notifications aren't enabled")
+	}
+	secret := h.rq.Header.Get(db.CacheNotifySecretHeader)
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(options.Secret)) != 1 {
+		return base.HTTPErrorf(http.StatusUnauthorized, "Invalid cache notification secret")
+	}
+	var notifications []*db.CacheNotification
+	if err := h.readJSONInto(&notifications); err != nil {
+		return err
+	}
+	h.db.ApplyCacheNotifications(notifications)
+	return nil
+}
@@ -0,0 +1,67 @@
+package rest
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbase/sync_gateway/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheNotify(t *testing.T) {
+	rt := RestTester{DatabaseConfig: &DbConfig{
+		CacheNotifications: &CacheNotificationsConfig{Enabled: base.BoolPtr(true), AdvertiseURL: "http://localhost:4985/db", Secret: "s3cret"},
+	}}
+	defer rt.Close()
+	headers := map[string]string{db.CacheNotifySecretHeader: "s3cret"}
+
+	lastSeq, err := rt.GetDatabase().LastSequence()
+	assert.NoError(t, err)
+	body := fmt.Sprintf(`[{"id":"doc1", "rev":"1-abc", "seq":%d, "channels":{"ABC":null}}]`, lastSeq+1)
+	assertStatus(t, rt.SendAdminRequestWithHeaders("POST", "/db/_cache_notify", body, headers), http.StatusOK)
+
+	// The notified revision is in the channel cache, without being in the bucket
+	response := rt.SendAdminRequest("GET", "/db/_changes?filter=sync_gateway/bychannel&channels=ABC", "")
+	assertStatus(t, response, http.StatusOK)
+	assert.Contains(t, response.Body.String(), `"id":"doc1"`)
+
+	assertStatus(t, rt.SendAdminRequestWithHeaders("POST", "/db/_cache_notify", `{"id":"doc1"}`, headers), http.StatusBadRequest)
+
+	// Notifications without the secret are rejected
+	assertStatus(t, rt.SendAdminRequest("POST", "/db/_cache_notify", body), http.StatusUnauthorized)
+	headers[db.CacheNotifySecretHeader] = "guess"
+	assertStatus(t, rt.SendAdminRequestWithHeaders("POST", "/db/_cache_notify", body, headers), http.StatusUnauthorized)
+	assertStatus(t, rt.SendRequest("POST", "/db/_cache_notify", body), http.StatusNotFound)
+}
+
+func TestCacheNotifyNotEnabled(t *testing.T) {
+	rt := RestTester{}
+	defer rt.Close()
+
+	assertStatus(t, rt.SendAdminRequest("POST", "/db/_cache_notify", `[]`), http.StatusNotFound)
+}
+
+func TestMakeCacheNotifyOptions(t *testing.T) {
+	options, err := makeCacheNotifyOptions(nil)
+	assert.NoError(t, err)
+	assert.False(t, options.Enabled)
+
+	options, err = makeCacheNotifyOptions(&CacheNotificationsConfig{Enabled: base.BoolPtr(true), AdvertiseURL: "https://sg1:4985/db", Secret: "s3cret"})
+	assert.NoError(t, err)
+	assert.True(t, options.Enabled)
+	assert.Equal(t, "https://sg1:4985/db", options.AdvertiseURL)
+	assert.Equal(t, "s3cret", options.Secret)
+
+	_, err = makeCacheNotifyOptions(&CacheNotificationsConfig{Enabled: base.BoolPtr(true), AdvertiseURL: "https://sg1:4985/db"})
+	assert.Error(t, err)
+
+	_, err = makeCacheNotifyOptions(&CacheNotificationsConfig{Enabled: base.BoolPtr(true)})
+	assert.Error(t, err)
+	_, err = makeCacheNotifyOptions(&CacheNotificationsConfig{Enabled: base.BoolPtr(true), AdvertiseURL: "sg1:4985"})
+	assert.Error(t, err)
+	options, err = makeCacheNotifyOptions(&CacheNotificationsConfig{Enabled: base.BoolPtr(false), AdvertiseURL: "sg1:4985"})
+	assert.NoError(t, err)
+	assert.False(t, options.Enabled)
+}
@@ -273,6 +273,13 @@ func (h *handler) handleChanges() error {
 		}
 	}
 
+	if feed != "normal" && feed != "" {
+		if err := h.db.StartReplication(); err != nil {
+			return err
+		}
+		defer h.db.EndReplication()
+	}
+
 	// Pull replication stats by type
 	if feed == "normal" {
 		h.db.DatabaseContext.DbStats.StatsCblReplicationPull().Add(base.StatKeyPullReplicationsActiveOneShot, 1)
@@ -0,0 +1,100 @@
+package rest
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbase/sync_gateway/db"
+)
+
+// RebuildChannelIndexFromConfig rebuilds the dense channel index of the named database in the given config files,
+// for the channels in the comma-separated channelList, or for all channels if it's empty.  Used by the
+// -rebuild-channel-index command line flag.  The database must be offline while its index is rebuilt: no Sync
+// Gateway or index writer nodes should be running against it.  Returns the process exit code.
+func RebuildChannelIndexFromConfig(runMode SyncGatewayRunMode, paths []string, dbName string, channelList string, w io.Writer) int {
+	if len(paths) == 0 {
+		fmt.Fprintln(w, "No config file specified")
+		return 1
+	}
+
+	var config *ServerConfig
+	for _, path := range paths {
+		c, err := ReadServerConfig(runMode, path)
+		if err != nil {
+			fmt.Fprintf(w, "Error reading config file %s: %v\n", path, err)
+			return 1
+		}
+		if config == nil {
+			config = c
+		} else if err := config.MergeWith(c); err != nil {
+			fmt.Fprintf(w, "Error reading config file %s: %v\n", path, err)
+			return 1
+		}
+	}
+
+	dbConfig := config.Databases[dbName]
+	if dbConfig == nil {
+		fmt.Fprintf(w, "Database %q isn't in the config\n", dbName)
+		return 1
+	}
+	if dbConfig.ChannelIndex == nil {
+		fmt.Fprintf(w, "Database %q doesn't have a channel_index\n", dbName)
+		return 1
+	}
+
+	var options db.ChannelIndexRebuildOptions
+	for _, channel := range strings.Split(channelList, ",") {
+		if channel = strings.TrimSpace(channel); channel != "" {
+			options.Channels = append(options.Channels, channel)
+		}
+	}
+	if dbConfig.CacheConfig != nil && dbConfig.CacheConfig.EnableStarChannel != nil {
+		db.EnableStarChannelLog = *dbConfig.CacheConfig.EnableStarChannel
+	}
+
+	spec, err := GetBucketSpec(dbConfig)
+	if err != nil {
+		fmt.Fprintf(w, "Invalid bucket config: %v\n", err)
+		return 1
+	}
+	bucket, err := db.ConnectToBucket(spec, nil)
+	if err != nil {
+		fmt.Fprintf(w, "Error opening bucket %s: %v\n", base.MD(spec.BucketName), err)
+		return 1
+	}
+	defer bucket.Close()
+
+	indexSpec := dbConfig.ChannelIndex.MakeBucketSpec()
+	indexSpec.CouchbaseDriver = base.ChooseCouchbaseDriver(base.IndexBucket)
+	indexBucket, err := base.GetBucket(indexSpec, nil)
+	if err != nil {
+		fmt.Fprintf(w, "Error opening channel index bucket %s: %v\n", base.MD(indexSpec.BucketName), err)
+		return 1
+	}
+	defer indexBucket.Close()
+
+	if options.Store, err = db.NewDenseStore(indexBucket, dbConfig.ChannelIndex.LocalStoreDir); err != nil {
+		fmt.Fprintf(w, "Error opening local channel index store %s: %v\n", base.MD(dbConfig.ChannelIndex.LocalStoreDir), err)
+		return 1
+	}
+
+	stats, err := db.RebuildChannelIndex(bucket, spec, indexBucket, options)
+	if err != nil {
+		fmt.Fprintf(w, "Error rebuilding channel index: %v\n", err)
+		return 1
+	}
+
+	channelNames := make([]string, 0, len(stats.Entries))
+	for channel := range stats.Entries {
+		channelNames = append(channelNames, channel)
+	}
+	sort.Strings(channelNames)
+	for _, channel := range channelNames {
+		fmt.Fprintf(w, "%s: %d entries\n", channel, stats.Entries[channel])
+	}
+	fmt.Fprintf(w, "Rebuilt channel index for %d channels from %d documents\n", len(channelNames), stats.DocsProcessed)
+	return 0
+}
@@ -23,10 +23,12 @@ import (
 	"runtime"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/couchbase/sync_gateway/auth"
 	"github.com/couchbase/sync_gateway/base"
 	"github.com/couchbase/sync_gateway/db"
+	"github.com/ghodss/yaml"
 
 	// Register profiling handlers (see Go docs)
 	_ "net/http/pprof"
@@ -54,6 +56,9 @@ const (
 	// Default value of ServerConfig.MaxFileDescriptors
 	DefaultMaxFileDescriptors uint64 = 5000
 
+	// Default value of ServerConfig.ListenerDrainTimeout, in seconds
+	DefaultListenerDrainTimeout = 300
+
 	// Default number of index replicas
 	DefaultNumIndexReplicas = uint(1)
 )
@@ -69,7 +74,8 @@ const (
 type ServerConfig struct {
 	Interface                  *string                  `json:",omitempty"`                        // Interface to bind REST API to, default ":4984"
 	SSLCert                    *string                  `json:",omitempty"`                        // Path to SSL cert file, or nil
-	SSLKey                     *string                  `json:",omitempty"`                        // Path to SSL private key file, or nil
+	SSLKey                     *string                  `json:",omitempty"`                        // Path to SSL private key file, or a secret reference, or nil
+	TLS                        *TLSConfig               `json:"tls,omitempty"`                     // TLS versions, cipher suites, HTTP/2 and certificate reloading for each listener
 	ServerReadTimeout          *int                     `json:",omitempty"`                        // maximum duration.Second before timing out read of the HTTP(S) request
 	ServerWriteTimeout         *int                     `json:",omitempty"`                        // maximum duration.Second before timing out write of the HTTP(S) response
 	AdminInterface             *string                  `json:",omitempty"`                        // Interface to bind admin API to, default "localhost:4985"
@@ -89,10 +95,22 @@ type ServerConfig struct {
 	MaxCouchbaseOverflow       *int                     `json:",omitempty"`                        // Max # of overflow sockets to open
 	CouchbaseKeepaliveInterval *int                     `json:",omitempty"`                        // TCP keep-alive interval between SG and Couchbase server
 	SlowQueryWarningThreshold  *int                     `json:",omitempty"`                        // Log warnings if N1QL queries take this many ms
+	SlowOperationThresholds    *SlowOperationConfig     `json:"slow_operations,omitempty"`         // Log warnings if operations exceed these per-subsystem thresholds
+	AuditLog                   *AuditLogConfig          `json:"audit_log,omitempty"`               // Configuration for the audit log of admin API mutations
+	RateLimits                 *RateLimitConfig         `json:"rate_limits,omitempty"`             // Per-IP and per-user request rate limits for the public API
+	RequestLimits              *RequestLimitsConfig     `json:"request_limits,omitempty"`          // Request body size and JSON nesting limits for the public API
+	RequestTimeouts            *RequestTimeoutsConfig   `json:"request_timeouts,omitempty"`        // Server-side time limits on requests, by kind of endpoint
+	ValidateRequests           *bool                    `json:"validate_requests,omitempty"`       // Reject requests whose query parameters don't match the API spec (GET /_openapi)
+	AdminIPFilter              *AdminIPFilterConfig     `json:"admin_ip_filter,omitempty"`         // Addresses the admin API accepts requests from
+	ReadOnly                   *ReadOnlyConfig          `json:"read_only,omitempty"`               // Run as a read-only replica, rejecting writes to the public API
 	MaxIncomingConnections     *int                     `json:",omitempty"`                        // Max # of incoming HTTP connections to accept
 	MaxFileDescriptors         *uint64                  `json:",omitempty"`                        // Max # of open file descriptors (RLIMIT_NOFILE)
+	ListenerDrainTimeout       *int                     `json:"listener_drain_timeout,omitempty"`  // Seconds to finish open requests and replications for, when restarting listeners
 	CompressResponses          *bool                    `json:",omitempty"`                        // If false, disables compression of HTTP responses
 	Databases                  DbConfigMap              `json:",omitempty"`                        // Pre-configured databases, mapped by name
+	DatabaseAliases            map[string]string        `json:"database_aliases,omitempty"`        // Alternative names of databases, that the admin API can repoint to other databases
+	DatabasesDir               *string                  `json:"databases_dir,omitempty"`           // Directory of per-database config files, added and removed as the files change
+	ConfigBucket               *ConfigBucketConfig      `json:"config_bucket,omitempty"`           // Bucket storing database configs shared by every node in the cluster
 	Replications               []*ReplicationConfig     `json:",omitempty"`                        // sg-replicate replication definitions
 	MaxHeartbeat               uint64                   `json:",omitempty"`                        // Max heartbeat value for _changes request (seconds)
 	ClusterConfig              *ClusterConfig           `json:"cluster_config,omitempty"`          // Bucket and other config related to CBGT
@@ -105,15 +123,16 @@ type ServerConfig struct {
 
 // Bucket configuration elements - used by db, shadow, index
 type BucketConfig struct {
-	Server     *string `json:"server,omitempty"`      // Couchbase server URL
-	Pool       *string `json:"pool,omitempty"`        // Couchbase pool name, default "default"
-	Bucket     *string `json:"bucket,omitempty"`      // Bucket name
-	Username   string  `json:"username,omitempty"`    // Username for authenticating to server
-	Password   string  `json:"password,omitempty"`    // Password for authenticating to server
-	CertPath   string  `json:"certpath,omitempty"`    // Cert path (public key) for X.509 bucket auth
-	KeyPath    string  `json:"keypath,omitempty"`     // Key path (private key) for X.509 bucket auth
-	CACertPath string  `json:"cacertpath,omitempty"`  // Root CA cert path for X.509 bucket auth
-	KvTLSPort  int     `json:"kv_tls_port,omitempty"` // Memcached TLS port, if not default (11207)
+	Server        *string `json:"server,omitempty"`          // Couchbase server URL
+	Pool          *string `json:"pool,omitempty"`            // Couchbase pool name, default "default"
+	Bucket        *string `json:"bucket,omitempty"`          // Bucket name
+	Username      string  `json:"username,omitempty"`        // RBAC username for authenticating to server.  Default: the bucket name, for legacy bucket passwords
+	Password      string  `json:"password,omitempty"`        // Password for authenticating to server, or a secret reference
+	CertPath      string  `json:"certpath,omitempty"`        // Cert path (public key) for X.509 bucket auth
+	KeyPath       string  `json:"keypath,omitempty"`         // Key path (private key) for X.509 bucket auth
+	CACertPath    string  `json:"cacertpath,omitempty"`      // Root CA cert (or bundle) path for verifying the server's TLS cert
+	TLSSkipVerify *bool   `json:"tls_skip_verify,omitempty"` // Don't verify the server's TLS cert.  Default: only verified when cacertpath is set
+	KvTLSPort     int     `json:"kv_tls_port,omitempty"`     // Memcached TLS port, if not default (11207)
 }
 
 func (bc *BucketConfig) MakeBucketSpec() base.BucketSpec {
@@ -138,14 +157,15 @@ func (bc *BucketConfig) MakeBucketSpec() base.BucketSpec {
 	}
 
 	return base.BucketSpec{
-		Server:     server,
-		PoolName:   pool,
-		BucketName: bucketName,
-		Keypath:    bc.KeyPath,
-		Certpath:   bc.CertPath,
-		CACertPath: bc.CACertPath,
-		KvTLSPort:  tlsPort,
-		Auth:       bc,
+		Server:        server,
+		PoolName:      pool,
+		BucketName:    bucketName,
+		Keypath:       bc.KeyPath,
+		Certpath:      bc.CertPath,
+		CACertPath:    bc.CACertPath,
+		TLSSkipVerify: bc.TLSSkipVerify,
+		KvTLSPort:     tlsPort,
+		Auth:          bc,
 	}
 }
 
@@ -154,6 +174,15 @@ func (bucketConfig *BucketConfig) GetCredentials() (username string, password st
 	return base.TransformBucketCredentials(bucketConfig.Username, bucketConfig.Password, *bucketConfig.Bucket)
 }
 
+// Configuration of the bucket that database configs created through the admin REST API are stored in.  Every node
+// sharing the bucket polls it, so databases added, changed or removed on one node take effect on them all.  The
+// stored configs' passwords have to be secret references (see base.ResolveSecret), and users' passwords are stored
+// as bcrypt hashes.
+type ConfigBucketConfig struct {
+	BucketConfig
+	PollIntervalSecs *uint32 `json:"poll_interval_secs,omitempty"` // How often to check for changed configs - Default: 10
+}
+
 type ClusterConfig struct {
 	BucketConfig
 	DataDir                  string  `json:"data_dir,omitempty"`
@@ -170,9 +199,19 @@ type DbConfig struct {
 	BucketConfig
 	Name                      string                         `json:"name,omitempty"`                         // Database name in REST API (stored as key in JSON)
 	Sync                      *string                        `json:"sync,omitempty"`                         // Sync function defines which users can see which data
+	Schemas                   *SchemasConfig                 `json:"schemas,omitempty"`                      // JSON Schemas documents are validated against, by type, before the sync function runs
+	ReadTransform             *ReadTransformConfig           `json:"read_transform,omitempty"`               // Hides document properties from some users, on the public API
+	Queries                   map[string]*NamedQueryConfig   `json:"queries,omitempty"`                      // Named N1QL queries clients can run at /{db}/_query/{name}
+	Functions                 map[string]string              `json:"functions,omitempty"`                    // JavaScript functions clients can call at /{db}/_function/{name}
+	GraphQL                   *GraphQLConfig                 `json:"graphql,omitempty"`                      // GraphQL schema clients can query at /{db}/_graphql
+	QueryResultCache          *QueryResultCacheConfig        `json:"query_result_cache,omitempty"`           // Reuse _all_docs and view query results until a document changes
+	WebSocketCompression      *WebSocketCompressionConfig    `json:"websocket_compression,omitempty"`        // permessage-deflate compression of BLIP WebSocket messages
+	CacheNotifications        *CacheNotificationsConfig      `json:"cache_notifications,omitempty"`          // Notify the database's other nodes of writes, to update their channel caches sooner
 	Users                     map[string]*db.PrincipalConfig `json:"users,omitempty"`                        // Initial user accounts
 	Roles                     map[string]*db.PrincipalConfig `json:"roles,omitempty"`                        // Initial roles
+	Signup                    *SignupConfig                  `json:"signup,omitempty"`                       // Self-service user signup on the public API
 	RevsLimit                 *uint32                        `json:"revs_limit,omitempty"`                   // Max depth a document's revision tree can grow to
+	RevsLimitOverrides        []RevsLimitOverrideConfig      `json:"revs_limit_overrides,omitempty"`         // Per-document revs_limit overrides, by doc ID pattern and/or type.  The first match applies
 	AutoImport                interface{}                    `json:"import_docs,omitempty"`                  // Whether to automatically import Couchbase Server docs into SG.  Xattrs must be enabled.  true or "continuous" both enable this.
 	ImportFilter              *string                        `json:"import_filter,omitempty"`                // Filter function (import)
 	ImportBackupOldRev        bool                           `json:"import_backup_old_rev"`                  // Whether import should attempt to create a temporary backup of the previous revision body, when available.
@@ -183,6 +222,10 @@ type DbConfig struct {
 	CacheConfig               *CacheConfig                   `json:"cache,omitempty"`                        // Cache settings
 	ChannelIndex              *ChannelIndexConfig            `json:"channel_index,omitempty"`                // Channel index settings
 	RevCacheSize              *uint32                        `json:"rev_cache_size,omitempty"`               // Maximum number of revisions to store in the revision cache
+	SharedRevCache            *SharedRevCacheConfig          `json:"shared_rev_cache,omitempty"`             // Cache revisions in a bucket shared by the database's nodes
+	ColdStorage               *ColdStorageConfig             `json:"cold_storage,omitempty"`                 // Move old revision bodies kept for a long time to a cheaper bucket
+	BodyCompression           *BodyCompressionConfig         `json:"body_compression,omitempty"`             // Store large document bodies compressed.  Not supported with enable_shared_bucket_access
+	ChannelSizes              *ChannelSizesConfig            `json:"channel_sizes,omitempty"`                // Estimate the number and size of the documents in each channel
 	StartOffline              bool                           `json:"offline,omitempty"`                      // start the DB in the offline state, defaults to false
 	Unsupported               db.UnsupportedOptions          `json:"unsupported,omitempty"`                  // Config for unsupported features
 	Deprecated                DeprecatedOptions              `json:"deprecated,omitempty"`                   // Config for Deprecated features
@@ -192,17 +235,213 @@ type DbConfig struct {
 	LocalDocExpirySecs        *uint32                        `json:"local_doc_expiry_secs,omitempty"`        // The _local doc expiry time in seconds
 	EnableXattrs              *bool                          `json:"enable_shared_bucket_access,omitempty"`  // Whether to use extended attributes to store _sync metadata
 	SessionCookieName         string                         `json:"session_cookie_name"`                    // Custom per-database session cookie name
+	SessionCookie             *SessionCookieConfig           `json:"session_cookie,omitempty"`               // Attributes of the session cookies set
+	CSRF                      *CSRFConfig                    `json:"csrf,omitempty"`                         // Cross-site request forgery protection for cookie-authenticated requests
+	AccessAudit               *AccessAuditConfig             `json:"access_audit,omitempty"`                 // Config for the audit trail of the sync function's access() and role() grants
 	AllowConflicts            *bool                          `json:"allow_conflicts,omitempty"`              // False forbids creating conflicts
 	NumIndexReplicas          *uint                          `json:"num_index_replicas"`                     // Number of GSI index replicas used for core indexes
 	UseViews                  bool                           `json:"use_views"`                              // Force use of views instead of GSI
 	SendWWWAuthenticateHeader *bool                          `json:"send_www_authenticate_header,omitempty"` // If false, disables setting of 'WWW-Authenticate' header in 401 responses
 	BucketOpTimeoutMs         *uint32                        `json:"bucket_op_timeout_ms,omitempty"`         // How long bucket ops should block returning "operation timed out". If nil, uses GoCB default.  GoCB buckets only.
+	BucketOpRetries           *base.RetryPolicy              `json:"bucket_op_retries,omitempty"`            // Retry policy for bucket ops that fail with transient errors.  GoCB buckets only.
+	BucketOpCircuitBreaker    *base.CircuitBreakerConfig     `json:"bucket_op_circuit_breaker,omitempty"`    // Fail bucket ops fast with 503s while they keep failing with transient errors.  GoCB buckets only.
 	DeltaSync                 *DeltaSyncConfig               `json:"delta_sync,omitempty"`                   // Config for delta sync
+	UsageStats                *UsageStatsConfig              `json:"usage_stats,omitempty"`                  // Config for per-user and per-channel usage tracking
+	WalrusSnapshot            *WalrusSnapshotConfig          `json:"walrus_snapshot,omitempty"`              // Persist a walrus bucket to disk, to keep its state across restarts
+	MetadataBucket            *BucketConfig                  `json:"metadata_bucket,omitempty"`              // Separate bucket for Sync Gateway's internal docs (sequences, users, roles, sessions, local docs)
+	KeyPrefix                 string                         `json:"key_prefix,omitempty"`                   // Namespace all the database's keys with this prefix, so several databases can share a bucket.  Requires views.
+	TombstonePurge            *TombstonePurgeConfig          `json:"tombstone_purge,omitempty"`              // Config for automatic tombstone purge.  Xattrs must be enabled.
+	BackgroundTasks           *BackgroundTasksConfig         `json:"background_tasks,omitempty"`             // Limits and scheduling windows of maintenance tasks such as compaction and resync
+	Trash                     *TrashConfig                   `json:"trash,omitempty"`                        // Keep purged documents for a while, so they can be restored
+	DeterministicRevIDs       *bool                          `json:"deterministic_revids,omitempty"`         // Generate revIDs from the revisions' content, so identical edits made through different nodes converge
+	Quotas                    *QuotaConfig                   `json:"quotas,omitempty"`                       // Per-database resource quotas, for shared deployments
+}
+
+// SchemasConfig attaches a JSON Schema to each document type.  Documents of those types that don't match their schema
+// are rejected with a 400 invalid_document error, whose details list the violations, before the sync function runs.
+type SchemasConfig struct {
+	TypeProperty string                 `json:"type_property,omitempty"` // Top-level document property holding its type - Default: "type"
+	Types        map[string]interface{} `json:"types"`                   // JSON Schema of each type.  Documents of other types, and deletions, aren't validated
+}
+
+// ReadTransformConfig changes the bodies of the revisions users read through the public API, so some properties can be
+// hidden from them.  The masks are applied first, then the function.  Admins always read the whole document.
+type ReadTransformConfig struct {
+	Masks    []PropertyMaskConfig `json:"masks,omitempty"`    // Properties to hide from users without the masks' roles
+	Function *string              `json:"function,omitempty"` // JavaScript function(doc, user) returning the body the user reads
+}
+
+type PropertyMaskConfig struct {
+	DocType        string   `json:"doc_type,omitempty"`         // Matched against the document's "type" property
+	Properties     []string `json:"properties"`                 // Properties to remove, with dots between nested property names, like "address.street"
+	VisibleToRoles []string `json:"visible_to_roles,omitempty"` // Users with any of these roles still see the properties
+}
+
+// SignupConfig enables POST /{db}/_signup on the public API, so that apps' clients can create their own users without
+// a separate backend.  New users only get the default channels and roles; the sync function can grant them more.
+type SignupConfig struct {
+	Enabled         *bool                  `json:"enabled,omitempty"`          // Whether clients can sign up
+	DefaultChannels []string               `json:"default_channels,omitempty"` // Admin channels of new users
+	DefaultRoles    []string               `json:"default_roles,omitempty"`    // Admin roles of new users
+	RequireEmail    *bool                  `json:"require_email,omitempty"`    // Whether new users have to give an email address - Default: false
+	RequireApproval *bool                  `json:"require_approval,omitempty"` // Whether new users are disabled until an admin enables them - Default: false
+	RateLimit       *RateLimitBudgetConfig `json:"rate_limit,omitempty"`       // Signups allowed from each client IP address - Default: 1 every 10 seconds, in bursts of 5
+}
+
+// NamedQueryConfig defines a N1QL query that clients can run by name, giving only the values of its parameters.  The
+// clauses are N1QL, referring to the parameters as $name and to the documents' properties directly; the query's FROM
+// clause is the database's bucket.  Users only get the rows of documents in channels they can see.
+type NamedQueryConfig struct {
+	Select     string   `json:"select"`               // Expressions to return for each matching document, besides its "id"
+	Where      string   `json:"where,omitempty"`      // Condition documents have to match
+	OrderBy    string   `json:"order_by,omitempty"`   // Order of the rows
+	Limit      int      `json:"limit,omitempty"`      // Max # of rows to return - Default: no limit
+	Parameters []string `json:"parameters,omitempty"` // Names of the parameters clients have to give values for
+}
+
+// GraphQLConfig defines the GraphQL API of a database.  Fields of the schema's object types resolve to the property
+// of the parent object with the same name, unless they have a resolver; the fields of the Query and Mutation types
+// all need one.
+type GraphQLConfig struct {
+	Schema    string                                       `json:"schema"`              // Schema, in the GraphQL schema definition language
+	Resolvers map[string]map[string]*GraphQLResolverConfig `json:"resolvers,omitempty"` // Resolvers of fields, by type name and field name
+}
+
+// GraphQLResolverConfig defines how a field is resolved.  Exactly one of its properties must be given.
+type GraphQLResolverConfig struct {
+	GetDoc   string `json:"get_doc,omitempty"`  // Argument or parent property holding the ID (or list of IDs) of the documents to return
+	Query    string `json:"query,omitempty"`    // Named query whose rows to return, given the arguments or parent properties with its parameters' names
+	Function string `json:"function,omitempty"` // User function to call with the field's arguments
+}
+
+// QueryResultCacheConfig enables a short-lived cache of _all_docs and view query results, which are reused for
+// identical queries by users with the same channels until any document changes.
+type QueryResultCacheConfig struct {
+	TTLMs      *int `json:"ttl_ms,omitempty"`      // How long a result is reused for, in milliseconds.  Zero disables the cache - Default: 0
+	MaxEntries *int `json:"max_entries,omitempty"` // Max number of results cached - Default: 1000
+}
+
+// WebSocketCompressionConfig configures the permessage-deflate WebSocket extension for BLIP replication connections.
+// It's only used when the client offers it.  BLIP already compresses the bodies of large revisions, so compressing
+// every message mostly costs CPU; the min message size keeps small messages, like changes and acks, uncompressed.
+type WebSocketCompressionConfig struct {
+	Enabled        *bool `json:"enabled,omitempty"`          // Whether to accept permessage-deflate - Default: false
+	Level          *int  `json:"level,omitempty"`            // Compression level, 1 (fastest) to 9 (smallest) - Default: 6
+	MinMessageSize *int  `json:"min_message_size,omitempty"` // Messages smaller than this many bytes are sent uncompressed - Default: 256
+}
+
+// CacheNotificationsConfig enables nodes to send the revisions they write straight to the database's other nodes,
+// which add them to their channel caches without waiting for their feeds.  Nodes find each other through the bucket,
+// and each has to be reachable by the others at its advertise URL.  The nodes share a secret, which they only accept
+// notifications with.
+type CacheNotificationsConfig struct {
+	Enabled      *bool  `json:"enabled,omitempty"`       // Whether to send and accept notifications - Default: false
+	AdvertiseURL string `json:"advertise_url,omitempty"` // URL of the database on this node's admin API, e.g. http://10.0.0.1:4985/db
+	Secret       string `json:"secret,omitempty"`        // Secret shared by the database's nodes.  Required when enabled
+}
+
+type RevsLimitOverrideConfig struct {
+	DocIDPattern string  `json:"doc_id_pattern,omitempty"` // Regular expression matched against the doc ID
+	DocType      string  `json:"doc_type,omitempty"`       // Matched against the document's "type" property
+	RevsLimit    *uint32 `json:"revs_limit"`               // Max depth the revision tree of matching documents can grow to
 }
 
 type DeltaSyncConfig struct {
 	Enabled          *bool   `json:"enabled,omitempty"`             // Whether delta sync is enabled (requires EE)
 	RevMaxAgeSeconds *uint32 `json:"rev_max_age_seconds,omitempty"` // The number of seconds deltas for old revs are available for
+	CacheMaxBytes    *int64  `json:"cache_max_bytes,omitempty"`     // Memory budget for the cache of generated deltas.  Zero disables the cache - Default: 10 MB
+}
+
+// SharedRevCacheConfig enables a revision cache shared by all of a database's nodes, so a revision loaded by one node
+// doesn't have to be loaded from its document by the others.
+type SharedRevCacheConfig struct {
+	Enabled    *bool         `json:"enabled,omitempty"`     // Whether revisions are cached for the database's other nodes
+	Bucket     *BucketConfig `json:"bucket,omitempty"`      // Bucket the revisions are cached in, such as a memcached or ephemeral bucket.  Required when enabled
+	ExpirySecs *uint32       `json:"expiry_secs,omitempty"` // How long revisions are kept in the shared cache, in seconds - Default: 300
+}
+
+// ColdStorageConfig moves the bodies of old revisions, which are kept for old_rev_expiry_seconds, to a separate
+// bucket if they're kept for longer than after_secs, such as a bucket on cheaper nodes.  They're still read from there for
+// open_revs requests and conflict resolution.
+type ColdStorageConfig struct {
+	Enabled   *bool         `json:"enabled,omitempty"`    // Whether old revision bodies are moved to cold storage
+	Bucket    *BucketConfig `json:"bucket,omitempty"`     // Bucket old revision bodies are moved to.  Required
+	AfterSecs *uint32       `json:"after_secs,omitempty"` // Old revision bodies kept for longer than this, in seconds, are moved - Default: 3600
+}
+
+// BodyCompressionConfig stores large document bodies gzip-compressed, with a flag in their sync metadata.  Sync
+// Gateway decompresses them as they're read, but other clients of the bucket, N1QL and views see only the compressed
+// body, so it can't be enabled along with queries, user views or shared bucket access, and design docs can't be
+// created while it's enabled.  Setting enabled to false stores each document uncompressed again the next time it's
+// updated.
+type BodyCompressionConfig struct {
+	Enabled  *bool   `json:"enabled,omitempty"`   // Whether large document bodies are stored compressed
+	MinBytes *uint32 `json:"min_bytes,omitempty"` // Bodies smaller than this are stored uncompressed - Default: 16384
+}
+
+type ChannelSizesConfig struct {
+	Enabled *bool `json:"enabled,omitempty"` // Whether documents are counted as they're added to and removed from channels
+}
+
+type WalrusSnapshotConfig struct {
+	Path         string  `json:"path"`                    // File the bucket is persisted to, and restored from when the database is opened
+	IntervalSecs *uint32 `json:"interval_secs,omitempty"` // How often the bucket is persisted, in seconds.  Defaults to 60
+}
+
+type UsageStatsConfig struct {
+	Enabled    *bool   `json:"enabled,omitempty"`     // Whether per-user and per-channel usage is tracked
+	WindowSecs *uint32 `json:"window_secs,omitempty"` // Rolling window usage is tracked over, in seconds.  Defaults to one hour
+}
+
+type TombstonePurgeConfig struct {
+	Enabled       *bool `json:"enabled,omitempty"`        // Whether tombstones older than the purge interval are purged automatically
+	IntervalHours *int  `json:"interval_hours,omitempty"` // How long tombstones are kept before being purged, in hours.  Defaults to the server's metadata purge interval
+}
+
+// BackgroundTasksConfig limits how many of the database's maintenance tasks, such as compaction, resync and tombstone
+// purge, run at once on each node, and when the scheduled ones start.  Tasks are listed at /{db}/_tasks, and can be
+// paused, resumed and cancelled at /{db}/_tasks/{id}?action=pause|resume|cancel.
+type BackgroundTasksConfig struct {
+	MaxConcurrent *int     `json:"max_concurrent,omitempty"` // Max number of tasks that run at once on each node; others are queued - Default: 1
+	Windows       []string `json:"windows,omitempty"`        // Daily UTC windows scheduled tasks may start in, such as "01:00-05:00".  Default: any time
+}
+
+// TrashConfig keeps a copy of each document purged through _purge, which can be listed at /{db}/_trash and restored
+// at /{db}/_trash/{docid}/_restore until its retention expires.  Tombstones aren't kept.
+type TrashConfig struct {
+	Enabled       *bool   `json:"enabled,omitempty"`        // Whether purged documents are moved to the trash - Default: false
+	RetentionDays *uint32 `json:"retention_days,omitempty"` // How long purged documents are kept before they're deleted for good, in days - Default: 7
+}
+
+// Over-quota writes fail with 507 Insufficient Storage, and over-quota replications and ops with 429 Too Many Requests.
+// Unset or zero quotas are unlimited.
+type QuotaConfig struct {
+	MaxDocs                   *uint64 `json:"max_docs,omitempty"`                    // Max # of live (non-deleted) documents
+	MaxAttachmentBytes        *uint64 `json:"max_attachment_bytes,omitempty"`        // Max total size of the attachments stored
+	MaxConcurrentReplications *uint32 `json:"max_concurrent_replications,omitempty"` // Max # of concurrent BLIP sync connections and continuous/longpoll _changes feeds, per node
+	MaxOpsPerSec              *uint32 `json:"max_ops_per_sec,omitempty"`             // Max # of non-admin REST requests and BLIP messages per second, per node
+}
+
+// SessionCookieConfig sets the attributes of a database's session cookies, for web apps that are served from
+// other domains or through proxies.  The cookie's name is set by session_cookie_name.
+type SessionCookieConfig struct {
+	Secure   *bool   `json:"secure,omitempty"`    // Only send the cookie over HTTPS.  Defaults to false, unless same_site is "none"
+	HTTPOnly *bool   `json:"http_only,omitempty"` // Hide the cookie from scripts.  Defaults to false
+	SameSite *string `json:"same_site,omitempty"` // "lax", "strict" or "none".  If unset, the attribute is omitted
+	Domain   *string `json:"domain,omitempty"`    // Send the cookie to this domain and its subdomains.  Defaults to the host that set it
+	Path     *string `json:"path,omitempty"`      // Send the cookie for this path.  Defaults to the database's path
+}
+
+// CSRFConfig protects state-changing public API requests that are authenticated by session cookie from cross-site
+// request forgery.  Requests authenticated any other way, such as with basic auth, aren't affected.
+type CSRFConfig struct {
+	Mode           string   `json:"mode"`                      // "origin" rejects requests whose Origin or Referer header is another site's; "token" requires the X-CSRF-Token header, whose value is returned by _session
+	TrustedOrigins []string `json:"trusted_origins,omitempty"` // Origins such as "https://app.example.com" whose pages may make requests, in origin mode
+}
+
+type AccessAuditConfig struct {
+	Enabled    *bool   `json:"enabled,omitempty"`     // Whether changes to documents' access() and role() grants are recorded, per user and role
+	MaxEntries *uint32 `json:"max_entries,omitempty"` // Max # of changes kept for each user or role, oldest discarded first - Default: 100
 }
 
 type DeprecatedOptions struct {
@@ -236,35 +475,85 @@ type ShadowConfig struct {
 }
 
 type EventHandlerConfig struct {
-	MaxEventProc    uint           `json:"max_processes,omitempty"`    // Max concurrent event handling goroutines
-	WaitForProcess  string         `json:"wait_for_process,omitempty"` // Max wait time when event queue is full (ms)
-	DocumentChanged []*EventConfig `json:"document_changed,omitempty"` // Document Commit
-	DBStateChanged  []*EventConfig `json:"db_state_changed,omitempty"` // DB state change
+	MaxEventProc       uint              `json:"max_processes,omitempty"`        // Max concurrent event handling goroutines
+	WaitForProcess     string            `json:"wait_for_process,omitempty"`     // Max wait time when event queue is full (ms)
+	DocumentChanged    []*EventConfig    `json:"document_changed,omitempty"`     // Document Commit
+	DBStateChanged     []*EventConfig    `json:"db_state_changed,omitempty"`     // DB state change
+	PrincipalChanged   []*EventConfig    `json:"principal_changed,omitempty"`    // User or role created, updated or deleted, or its grants changed
+	ReplicationChanged []*EventConfig    `json:"replication_changed,omitempty"`  // BLIP replication started, stopped, failed or completed a one-shot pull
+	DBLifecycleChanged []*EventConfig    `json:"db_lifecycle_changed,omitempty"` // DB came online or went offline, or a resync or compaction started or finished
+	PersistentQueue    *EventQueueConfig `json:"persistent_queue,omitempty"`     // Queue document change events in the bucket, instead of in memory
+}
+
+type EventQueueConfig struct {
+	Name           string  `json:"name,omitempty"`            // Identifies this node's queue, so it can resume it after a restart.  Defaults to the hostname
+	MaxDepth       *uint64 `json:"max_depth,omitempty"`       // Max # of queued events.  Defaults to 100000
+	OverflowPolicy string  `json:"overflow_policy,omitempty"` // "drop" new events while the queue is full, or "block" for up to wait_for_process.  Defaults to "drop"
 }
 
 type EventConfig struct {
-	HandlerType string  `json:"handler"`           // Handler type
-	Url         string  `json:"url,omitempty"`     // Url (webhook)
-	Filter      string  `json:"filter,omitempty"`  // Filter function (webhook)
-	Timeout     *uint64 `json:"timeout,omitempty"` // Timeout (webhook)
+	HandlerType     string   `json:"handler"`                     // Handler type
+	Url             string   `json:"url,omitempty"`               // Url (webhook, nats, amqp), or the APNs server to use instead of Apple's (push)
+	Filter          string   `json:"filter,omitempty"`            // Filter function (webhook)
+	Transform       string   `json:"transform,omitempty"`         // Function that returns the payload to post, instead of the event's body (webhook)
+	PayloadTemplate string   `json:"payload_template,omitempty"`  // Go text/template that generates the payload to post, instead of the event's body (webhook)
+	ContentType     string   `json:"content_type,omitempty"`      // Content type of transformed or templated payloads.  Defaults to application/json (webhook)
+	Timeout         *uint64  `json:"timeout,omitempty"`           // Timeout (webhook, push)
+	Channels        []string `json:"channels,omitempty"`          // Only post changes to docs in one of these channels (webhook, kafka, nats, amqp, push)
+	MaxRetries      *uint    `json:"max_retries,omitempty"`       // Max # of retries of a failed post.  Defaults to 0 (webhook)
+	RetryBackoff    *uint64  `json:"retry_backoff,omitempty"`     // Wait before the first retry (ms), doubled for each retry after that.  Defaults to 1000 (webhook)
+	MaxRetryBackoff *uint64  `json:"max_retry_backoff,omitempty"` // Max wait between retries (ms).  Defaults to 60000 (webhook)
+	DeadLetterUrl   string   `json:"dead_letter_url,omitempty"`   // Url to post events to once all retries have failed (webhook)
+	ClientCertPath  string   `json:"client_cert_path,omitempty"`  // Client certificate for mutual TLS (webhook)
+	ClientKeyPath   string   `json:"client_key_path,omitempty"`   // Client certificate's private key (webhook)
+	CACertPath      string   `json:"ca_cert_path,omitempty"`      // CA that must have signed the receiver's certificate.  Defaults to the system's CAs (webhook)
+	SigningSecrets  []string `json:"signing_secrets,omitempty"`   // HMAC secrets to sign posts with.  List a new secret alongside the old one while rotating them (webhook)
+	Brokers         []string `json:"brokers,omitempty"`           // Broker addresses, as host:port (kafka)
+	Topic           string   `json:"topic,omitempty"`             // Topic to publish document changes to (kafka)
+	PartitionBy     string   `json:"partition_by,omitempty"`      // "doc_id" or "channel".  Defaults to "doc_id" (kafka)
+	IncludeBody     bool     `json:"include_body,omitempty"`      // Whether to include document bodies in messages (kafka)
+	Subject         string   `json:"subject,omitempty"`           // Subject to publish events to (nats)
+	Exchange        string   `json:"exchange,omitempty"`          // Exchange to publish events to.  Defaults to the default exchange (amqp)
+	RoutingKey      string   `json:"routing_key,omitempty"`       // Routing key to publish events with (amqp)
+	PushInterval    *uint64  `json:"push_interval,omitempty"`     // Time (ms) changes are batched for, so devices are woken at most once per interval.  Defaults to 5000 (push)
+	APNsTopic       string   `json:"apns_topic,omitempty"`        // The app's bundle ID, for sending notifications to APNs devices (push)
+	APNsCertPath    string   `json:"apns_cert_path,omitempty"`    // Certificate to authenticate to APNs with (push)
+	APNsKeyPath     string   `json:"apns_key_path,omitempty"`     // Private key of the APNs certificate (push)
+	FCMServerKey    string   `json:"fcm_server_key,omitempty"`    // Server key for sending notifications to FCM devices, or a secret reference (push)
 }
 
 type CacheConfig struct {
-	CachePendingSeqMaxWait *uint32 `json:"max_wait_pending,omitempty"` // Max wait for pending sequence before skipping
-	CachePendingSeqMaxNum  *int    `json:"max_num_pending,omitempty"`  // Max number of pending sequences before skipping
-	CacheSkippedSeqMaxWait *uint32 `json:"max_wait_skipped,omitempty"` // Max wait for skipped sequence before abandoning
-	EnableStarChannel      *bool   `json:"enable_star_channel"`        // Enable star channel
-	ChannelCacheMaxLength  *int    `json:"channel_cache_max_length"`   // Maximum number of entries maintained in cache per channel
-	ChannelCacheMinLength  *int    `json:"channel_cache_min_length"`   // Minimum number of entries maintained in cache per channel
-	ChannelCacheAge        *int    `json:"channel_cache_expiry"`       // Time (seconds) to keep entries in cache beyond the minimum retained
+	CachePendingSeqMaxWait      *uint32 `json:"max_wait_pending,omitempty"`               // Max wait for pending sequence before skipping
+	CachePendingSeqMaxNum       *int    `json:"max_num_pending,omitempty"`                // Max number of pending sequences before skipping
+	CacheSkippedSeqMaxWait      *uint32 `json:"max_wait_skipped,omitempty"`               // Max wait for skipped sequence before abandoning
+	CacheSkippedSeqLookupWait   *uint32 `json:"lookup_wait_skipped,omitempty"`            // Wait for skipped sequence before looking up its doc by sequence, and abandoning it if not found
+	EnableStarChannel           *bool   `json:"enable_star_channel"`                      // Enable star channel
+	ChannelCacheMaxLength       *int    `json:"channel_cache_max_length"`                 // Maximum number of entries maintained in cache per channel
+	ChannelCacheMinLength       *int    `json:"channel_cache_min_length"`                 // Minimum number of entries maintained in cache per channel
+	ChannelCacheAge             *int    `json:"channel_cache_expiry"`                     // Time (seconds) to keep entries in cache beyond the minimum retained
+	ChannelCachePersistInterval *int    `json:"channel_cache_persist_interval,omitempty"` // Time (seconds) between saves of the channel caches to the bucket, to restore on restart
+
+	ChannelOverrides []ChannelCacheOverrideConfig `json:"channel_overrides,omitempty"` // Per-channel cache settings, by channel name or pattern.  The first match applies
+}
+
+// Overrides the cache settings of a channel, or of the channels matching a pattern.  Settings that aren't set are
+// the same as for other channels.
+type ChannelCacheOverrideConfig struct {
+	Channel               string `json:"channel,omitempty"`                  // Name of the channel
+	ChannelPattern        string `json:"channel_pattern,omitempty"`          // Regular expression matched against the channel name
+	ChannelCacheMaxLength *int   `json:"channel_cache_max_length,omitempty"` // Maximum number of entries maintained in the cache
+	ChannelCacheMinLength *int   `json:"channel_cache_min_length,omitempty"` // Minimum number of entries maintained in the cache
+	ChannelCacheAge       *int   `json:"channel_cache_expiry,omitempty"`     // Time (seconds) to keep entries in the cache beyond the minimum retained
 }
 
 type ChannelIndexConfig struct {
 	BucketConfig
 	IndexWriter               bool                `json:"writer,omitempty"`       // Whether SG node is a channel index writer
+	Distributed               bool                `json:"distributed,omitempty"`  // Whether the Sync Gateway nodes write the index between them, without SG Accel
 	NumShards                 uint16              `json:"num_shards,omitempty"`   // Number of partitions in the channel index
 	SequenceHashConfig        *SequenceHashConfig `json:"seq_hashing,omitempty"`  // Sequence hash configuration
 	TombstoneCompactFrequency *int                `json:"tombstone_compact_freq"` // How often sg-accel attempts to compact purged tombstones
+	LocalStoreDir             string              `json:"local_store,omitempty"`  // Directory to keep the channel blocks in instead of the index bucket, for single node deployments
 }
 
 type SequenceHashConfig struct {
@@ -282,6 +571,120 @@ type Http2Config struct {
 	Enabled *bool `json:"enabled,omitempty"` // Whether HTTP2 support is enabled
 }
 
+// TLS settings for each listener, used when SSLCert and SSLKey are set.
+type TLSConfig struct {
+	Public  *ListenerTLSConfig `json:"public,omitempty"`  // The REST API and BLIP sync listener
+	Admin   *ListenerTLSConfig `json:"admin,omitempty"`   // The admin API listener
+	Metrics *ListenerTLSConfig `json:"metrics,omitempty"` // The profile listener, which serves expvar stats and pprof profiles.  It only uses TLS if this is set
+}
+
+type ListenerTLSConfig struct {
+	MinVersion             string   `json:"min_version,omitempty"`               // Min TLS version accepted: "tlsv1.0" (the default), "tlsv1.1" or "tlsv1.2"
+	CipherSuites           []string `json:"cipher_suites,omitempty"`             // Cipher suites allowed, in order of preference, by their Go crypto/tls names.  Defaults to Go's defaults
+	HTTP2                  *bool    `json:"http2,omitempty"`                     // Whether HTTP/2 is enabled.  Defaults to unsupported.http2.enabled
+	CertReloadIntervalSecs *uint32  `json:"cert_reload_interval_secs,omitempty"` // How often the cert and key files are checked for changes, and reloaded.  Unset or zero disables reloading
+}
+
+// Returns the listener's TLS options.  HTTP/2 is enabled by default if http2Enabled is true.
+func (c *ListenerTLSConfig) tlsOptions(http2Enabled bool) (options base.TLSOptions, err error) {
+	options.HTTP2Enabled = http2Enabled
+	if c == nil {
+		return options, nil
+	}
+	if c.MinVersion != "" {
+		if options.MinVersion, err = base.ParseTLSVersion(c.MinVersion); err != nil {
+			return options, err
+		}
+	}
+	if len(c.CipherSuites) > 0 {
+		if options.CipherSuites, err = base.ParseTLSCipherSuites(c.CipherSuites); err != nil {
+			return options, err
+		}
+	}
+	if c.HTTP2 != nil {
+		options.HTTP2Enabled = *c.HTTP2
+	}
+	if c.CertReloadIntervalSecs != nil {
+		options.ReloadInterval = time.Duration(*c.CertReloadIntervalSecs) * time.Second
+	}
+	return options, nil
+}
+
+type AuditLogConfig struct {
+	Enabled      bool    `json:"enabled,omitempty"`   // Whether mutating admin API calls are recorded in the audit log
+	Sink         string  `json:"sink,omitempty"`      // Where audit entries are written: "file" (default) or "bucket"
+	FilePath     *string `json:"file_path,omitempty"` // Path to the audit log file.  Defaults to sg_audit.log in the log_file_path directory
+	BucketConfig         // Bucket to write audit entries to, when using the bucket sink
+}
+
+// Request rate limits for the public REST and BLIP API, to protect shared deployments from noisy clients.  Requests
+// over budget fail with 429 Too Many Requests, and a Retry-After header giving the seconds until the client can retry.
+// Limits are enforced by each node separately.
+type RateLimitConfig struct {
+	PerIP              *RateLimitBudgetsConfig `json:"per_ip,omitempty"`                // Budgets for each client IP address
+	PerUser            *RateLimitBudgetsConfig `json:"per_user,omitempty"`              // Budgets for each authenticated user of each database
+	TrustXForwardedFor *bool                   `json:"trust_x_forwarded_for,omitempty"` // Identify clients by the X-Forwarded-For header set by a proxy, instead of the connection's address
+	TrustedProxyHops   *int                    `json:"trusted_proxy_hops,omitempty"`    // Number of trusted proxies that append to X-Forwarded-For; the client is the entry this far from the end - Default: 1
+}
+
+// Separate budgets for reads, writes and changes feeds (including BLIP sync connections).  Unset budgets are unlimited.
+type RateLimitBudgetsConfig struct {
+	Reads   *RateLimitBudgetConfig `json:"reads,omitempty"`
+	Writes  *RateLimitBudgetConfig `json:"writes,omitempty"`
+	Changes *RateLimitBudgetConfig `json:"changes,omitempty"`
+}
+
+type RateLimitBudgetConfig struct {
+	RequestsPerSec float64 `json:"requests_per_sec"` // Sustained request rate
+	Burst          *uint32 `json:"burst,omitempty"`  // Max # of requests in a burst.  Defaults to one second's worth
+}
+
+// Server-side time limits on requests to each kind of endpoint, in seconds, on both the public and admin APIs, so
+// that requests stuck on the bucket don't hold their connections forever.  Requests over a limit fail with 503
+// Service Unavailable; a response that's already being sent, such as a changes feed, just ends.  WebSocket requests
+// aren't limited.  Unset limits are unlimited.
+type RequestTimeoutsConfig struct {
+	CRUD        *int `json:"crud,omitempty"`        // Document and local document requests
+	Bulk        *int `json:"bulk,omitempty"`        // _bulk_docs, _bulk_get, _all_docs and _revs_diff requests
+	Changes     *int `json:"changes,omitempty"`     // _changes feeds, including longpoll and continuous ones
+	Attachments *int `json:"attachments,omitempty"` // Attachment uploads and downloads
+}
+
+// Limits on the public API's request bodies, so that hostile clients can't exhaust the node's memory.  Requests over a
+// limit fail with 413 Request Entity Too Large, without the body being read if its Content-Length exceeds the limit.
+// Unset limits are unlimited.
+type RequestLimitsConfig struct {
+	MaxDocBytes        *int64 `json:"max_doc_bytes,omitempty"`        // Max body size of a document PUT or POST, including any multipart attachments
+	MaxBulkDocsBytes   *int64 `json:"max_bulk_docs_bytes,omitempty"`  // Max body size of a _bulk_docs request
+	MaxAttachmentBytes *int64 `json:"max_attachment_bytes,omitempty"` // Max body size of an attachment PUT
+	MaxBodyBytes       *int64 `json:"max_body_bytes,omitempty"`       // Max body size of any other request
+	MaxJSONDepth       *int   `json:"max_json_depth,omitempty"`       // Max nesting depth of objects and arrays in JSON request bodies
+}
+
+// AdminIPFilterConfig restricts the client addresses that the admin API accepts requests from, as defense in depth
+// where the admin port can't be isolated from the network.  Entries are CIDR blocks ("10.0.0.0/8") or single
+// addresses ("127.0.0.1").
+type AdminIPFilterConfig struct {
+	Allow []string `json:"allow,omitempty"` // If set, only requests from these addresses are accepted
+	Deny  []string `json:"deny,omitempty"`  // Requests from these addresses are rejected, even if they're allowed
+}
+
+// ReadOnlyConfig makes the node a read-only replica, whose public API serves reads and pull replications but rejects
+// document writes and pushes.  Replicas hold no state of their own, so read-heavy deployments can scale them out
+// behind a load balancer while writes go to separate nodes.  The admin API isn't affected.
+type ReadOnlyConfig struct {
+	Enabled  bool   `json:"enabled"`             // Whether the public API rejects writes
+	WriteURL string `json:"write_url,omitempty"` // Public URL of the nodes accepting writes, that rejected writes are redirected to
+}
+
+// SlowOperationConfig holds per-subsystem thresholds, in milliseconds, above which operations are logged as warnings.
+type SlowOperationConfig struct {
+	BucketOpMs     *int `json:"bucket_op_ms,omitempty"`     // Log warnings if individual bucket operations take this many ms
+	SyncFunctionMs *int `json:"sync_function_ms,omitempty"` // Log warnings if a sync function invocation takes this many ms
+	QueryMs        *int `json:"query_ms,omitempty"`         // Log warnings if view or N1QL queries take this many ms (overrides SlowQueryWarningThreshold)
+	ChangesMs      *int `json:"changes_ms,omitempty"`       // Log warnings if retrieving changes for a channel takes this many ms
+}
+
 func (dbConfig *DbConfig) setup(name string) error {
 
 	dbConfig.Name = name
@@ -391,6 +794,11 @@ func (dbConfig DbConfig) validate() error {
 		}
 	}
 
+	// A local store is only seen by the node that has it, so that node has to write the index, and be the only one
+	if dbConfig.ChannelIndex != nil && dbConfig.ChannelIndex.LocalStoreDir != "" && !dbConfig.ChannelIndex.Distributed {
+		return fmt.Errorf("channel_index local_store requires distributed, with a single Sync Gateway node")
+	}
+
 	// Error if Delta Sync is explicitly enabled in CE
 	if dbConfig.DeltaSync != nil && dbConfig.DeltaSync.Enabled != nil {
 		if *dbConfig.DeltaSync.Enabled && !base.IsEnterpriseEdition() {
@@ -444,6 +852,10 @@ func (dbConfig *DbConfig) validateSgAccelDbConfig() error {
 		return fmt.Errorf("Invalid configuration for Sync Gw Accel.  Must be configured as an IndexWriter")
 	}
 
+	if dbConfig.ChannelIndex.Distributed {
+		return fmt.Errorf("Invalid configuration for Sync Gw Accel.  A distributed index is written by Sync Gw, not Sync Gw Accel")
+	}
+
 	if strings.ToLower(dbConfig.FeedType) != strings.ToLower(base.DcpShardFeedType) {
 		return fmt.Errorf("Invalid configuration for Sync Gw Accel.  Must be configured for DCPSHARD feedtype")
 
@@ -495,6 +907,53 @@ func (dbConfig *DbConfig) UseXattrs() bool {
 	return base.DefaultUseXattrs
 }
 
+// Returns pointers to the config's string fields that may hold secret references.
+func (dbConfig *DbConfig) secretFields() []*string {
+	fields := []*string{&dbConfig.Password}
+	if dbConfig.Deprecated.Shadow != nil {
+		fields = append(fields, &dbConfig.Deprecated.Shadow.Password)
+	}
+	if dbConfig.ChannelIndex != nil {
+		fields = append(fields, &dbConfig.ChannelIndex.Password)
+	}
+	if dbConfig.MetadataBucket != nil {
+		fields = append(fields, &dbConfig.MetadataBucket.Password)
+	}
+	if dbConfig.OIDCConfig != nil {
+		for _, provider := range dbConfig.OIDCConfig.Providers {
+			if provider.ValidationKey != nil {
+				fields = append(fields, provider.ValidationKey)
+			}
+		}
+	}
+	return fields
+}
+
+// Returns a copy of the config with any secret references (see base.ResolveSecret) replaced by the secrets they
+// reference, or the config itself if it has no secret references.
+func (dbConfig *DbConfig) withResolvedSecrets() (*DbConfig, error) {
+	hasSecretRefs := false
+	for _, field := range dbConfig.secretFields() {
+		if base.IsSecretRef(*field) {
+			hasSecretRefs = true
+		}
+	}
+	if !hasSecretRefs {
+		return dbConfig, nil
+	}
+
+	resolved, err := dbConfig.DeepCopy()
+	if err != nil {
+		return nil, err
+	}
+	for _, field := range resolved.secretFields() {
+		if *field, err = base.ResolveSecret(*field); err != nil {
+			return nil, err
+		}
+	}
+	return resolved, nil
+}
+
 // Create a deepcopy of this DbConfig, or panic.
 // This will only copy all of the _exported_ fields of the DbConfig.
 func (dbConfig *DbConfig) DeepCopy() (dbConfigCopy *DbConfig, err error) {
@@ -513,17 +972,18 @@ func (clusterConfig *ClusterConfig) GetCredentials() (string, string, string) {
 	return base.TransformBucketCredentials(clusterConfig.Username, clusterConfig.Password, *clusterConfig.Bucket)
 }
 
-// Reads a ServerConfig from raw data
+// Reads a ServerConfig from raw JSON data
 func ReadServerConfigFromData(runMode SyncGatewayRunMode, data []byte) (*ServerConfig, error) {
+	return readServerConfigFromData(runMode, data, configFormatJSON)
+}
 
-	data = base.ConvertBackQuotedStrings(data)
-	var config *ServerConfig
-	if err := json.Unmarshal(data, &config); err != nil {
+func readServerConfigFromData(runMode SyncGatewayRunMode, data []byte, format configFormat) (*ServerConfig, error) {
+
+	config, err := parseServerConfig(runMode, data, format)
+	if err != nil {
 		return nil, err
 	}
 
-	config.RunMode = runMode
-
 	// Validation:
 	if err := config.setupAndValidateDatabases(); err != nil {
 		return nil, err
@@ -532,23 +992,54 @@ func ReadServerConfigFromData(runMode SyncGatewayRunMode, data []byte) (*ServerC
 	return config, nil
 }
 
-// Reads a ServerConfig from a URL.
-func ReadServerConfigFromUrl(runMode SyncGatewayRunMode, url string) (*ServerConfig, error) {
+// Parses a ServerConfig from raw data in the given format, without any validation.
+func parseServerConfig(runMode SyncGatewayRunMode, data []byte, format configFormat) (*ServerConfig, error) {
 
-	resp, err := http.Get(url)
+	data, err := preprocessConfigData(data, format)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-	responseBody, err := ioutil.ReadAll(resp.Body)
+
+	var config *ServerConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+
+	config.RunMode = runMode
+	return config, nil
+}
+
+// Converts raw config data in the given format to plain JSON, expanding any environment variable references.
+func preprocessConfigData(data []byte, format configFormat) ([]byte, error) {
+
+	var err error
+	if format == configFormatYAML {
+		// YAML has its own multi-line strings, so backquoted strings aren't converted
+		if data, err = yaml.YAMLToJSON(data); err != nil {
+			return nil, err
+		}
+	}
+
+	// Backquoted strings are converted first, since expanded values are already escaped for a JSON string
+	if format == configFormatJSON {
+		data = base.ConvertBackQuotedStrings(data)
+	}
+	return base.ExpandEnvVars(data)
+}
+
+// Reads a ServerConfig from a URL.  The config is parsed as YAML if the URL path has a .yaml or .yml extension,
+// and as JSON otherwise.
+func ReadServerConfigFromUrl(runMode SyncGatewayRunMode, url string) (*ServerConfig, error) {
+
+	responseBody, err := readConfigDataFromUrl(url)
 	if err != nil {
 		return nil, err
 	}
-	return ReadServerConfigFromData(runMode, responseBody)
+	return readServerConfigFromData(runMode, responseBody, configFormatForPath(url))
 
 }
 
-// Reads a ServerConfig from either a JSON file or from a URL.
+// Reads a ServerConfig from either a JSON or YAML file, or from a URL.
 func ReadServerConfig(runMode SyncGatewayRunMode, path string) (*ServerConfig, error) {
 	if strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
 		return ReadServerConfigFromUrl(runMode, path)
@@ -557,33 +1048,64 @@ func ReadServerConfig(runMode SyncGatewayRunMode, path string) (*ServerConfig, e
 	}
 }
 
-// Reads a ServerConfig from a JSON file.
+// Reads a ServerConfig from a file.  The file is parsed as YAML if it has a .yaml or .yml extension, and as JSON
+// otherwise.
 func ReadServerConfigFromFile(runMode SyncGatewayRunMode, path string) (*ServerConfig, error) {
-	file, err := os.Open(path)
+
+	data, err := readConfigDataFromFile(path)
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
+	return readServerConfigFromData(runMode, data, configFormatForPath(path))
 
-	data, err := ioutil.ReadAll(file)
-	if err != nil {
-		return nil, err
+}
+
+type configFormat int
+
+const (
+	configFormatJSON configFormat = iota // JSON, with support for `...`-delimited strings
+	configFormatYAML
+)
+
+// Returns the format of the config at the given file path or URL, based on its extension.
+func configFormatForPath(path string) configFormat {
+	if strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
+		if u, err := url.Parse(path); err == nil {
+			path = u.Path
+		}
 	}
-	data = base.ConvertBackQuotedStrings(data)
-	var config *ServerConfig
-	if err := json.Unmarshal(data, &config); err != nil {
-		return nil, err
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return configFormatYAML
+	default:
+		return configFormatJSON
 	}
+}
 
-	config.RunMode = runMode
+// Reads raw config data from either a file or a URL.
+func readConfigData(path string) ([]byte, error) {
+	if strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
+		return readConfigDataFromUrl(path)
+	}
+	return readConfigDataFromFile(path)
+}
 
-	// Validation:
-	if err := config.setupAndValidateDatabases(); err != nil {
+func readConfigDataFromUrl(url string) ([]byte, error) {
+	resp, err := http.Get(url)
+	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
 
-	return config, nil
-
+func readConfigDataFromFile(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ioutil.ReadAll(file)
 }
 
 func (config *ServerConfig) setupAndValidateDatabases() error {
@@ -612,6 +1134,7 @@ func (config *ServerConfig) SetupAndValidateLogging() (warnings []base.DeferredL
 	warnings = config.deprecatedConfigLoggingFallback()
 
 	base.SetRedaction(config.Logging.RedactionLevel)
+	base.SetUserDataRedaction(config.Logging.RedactClasses, config.Logging.RedactionSalt)
 
 	warningsInit, err := config.Logging.Init(defaultLogFilePath)
 	warnings = append(warnings, warningsInit...)
@@ -722,6 +1245,12 @@ func (self *ServerConfig) MergeWith(other *ServerConfig) error {
 	if other.Pretty {
 		self.Pretty = true
 	}
+	if self.DatabasesDir == nil {
+		self.DatabasesDir = other.DatabasesDir
+	}
+	if self.ConfigBucket == nil {
+		self.ConfigBucket = other.ConfigBucket
+	}
 	for name, db := range other.Databases {
 		if self.Databases[name] != nil {
 			return base.RedactErrorf("Database %q already specified earlier", base.UD(name))
@@ -754,8 +1283,88 @@ func ParseCommandLine(runMode SyncGatewayRunMode) {
 	// used by service scripts as a way to specify a per-distro defaultLogFilePath
 	defaultLogFilePathFlag := flag.String("defaultLogFilePath", "", "Path to log files, if not overridden by --logFilePath, or the config")
 
+	verifyConfig := flag.Bool("verify-config", false, "Validate the config file(s) and report all errors, without starting the server")
+	migrateConfig := flag.Bool("migrate-config", false, "Convert the deprecated options in a config file from an older release to the current schema, reporting each change, then exit")
+	migrateConfigOutput := flag.String("migrate-config-output", "", "Path to save the config converted by -migrate-config to; if omitted, the changes are only reported")
+
+	collectDiagnostics := flag.String("collect-diagnostics", "", "Save a diagnostics archive from the running server at -adminInterface to this path, then exit")
+	diagnosticsRedactLevel := flag.String("diagnostics-redact-level", "", "Redaction level for -collect-diagnostics (none, partial or full); defaults to the server's log redaction level")
+	diagnosticsProfileSecs := flag.Uint("diagnostics-profile-secs", 0, "Duration of the CPU profile included by -collect-diagnostics; zero skips it")
+
+	rebuildChannelIndex := flag.String("rebuild-channel-index", "", "Rebuild the channel index of this database from the data bucket, then exit.  The database must be offline")
+	rebuildChannels := flag.String("rebuild-channels", "", "Comma-separated channels for -rebuild-channel-index; defaults to all channels")
+
+	exportPrincipals := flag.String("export-principals", "", "Export the users and roles of this database on the running server at -adminInterface to -principals-file, then exit")
+	importPrincipals := flag.String("import-principals", "", "Import the users and roles in -principals-file into this database on the running server at -adminInterface, then exit")
+	principalsFile := flag.String("principals-file", "", "Path of the file for -export-principals or -import-principals")
+	principalsImportMode := flag.String("principals-import-mode", "", "How -import-principals treats existing users and roles: merge (the default) adds the imported grants, overwrite replaces them")
+
+	backupMetadata := flag.String("backup-metadata", "", "Back up the users, roles, local docs and other metadata of this database on the running server at -adminInterface to -metadata-file, then exit")
+	backupSessions := flag.Bool("backup-sessions", false, "Include users' login sessions in -backup-metadata")
+	restoreMetadata := flag.String("restore-metadata", "", "Restore the metadata backup in -metadata-file into this database on the running server at -adminInterface, then exit.  The database must be offline")
+	metadataFile := flag.String("metadata-file", "", "Path of the file for -backup-metadata or -restore-metadata")
+
+	repairSequences := flag.String("repair-sequences", "", "Fast-forward the sequence counter of this database on the running server at -adminInterface past the highest sequence in its docs, e.g. after a bucket restore, then exit.  The database must be offline")
+	repairSequencesMargin := flag.Uint64("repair-sequences-margin", 0, "Number of extra sequences -repair-sequences skips past the highest one found")
+	repairSequencesPreview := flag.Bool("repair-sequences-preview", false, "Only report what -repair-sequences would do")
+
+	cleanMetadata := flag.String("clean-metadata", "", "Delete the orphaned sessions, checkpoints and other internal docs of this database on the running server at -adminInterface, then exit")
+	cleanMetadataCheckpointAge := flag.Uint64("clean-metadata-checkpoint-age-days", 0, "Days after which checkpoints that haven't been updated are deleted by -clean-metadata; zero keeps them")
+	cleanMetadataPreview := flag.Bool("clean-metadata-preview", false, "Only report what -clean-metadata would delete")
+
+	simulateLoad := flag.String("simulate-load", "", "Run simulated Couchbase Lite clients against the database at this public API URL (with any user's credentials in it), then report push and pull throughput and latency, and exit")
+	simulateClients := flag.Int("simulate-clients", 10, "Number of clients simulated by -simulate-load")
+	simulateDuration := flag.Duration("simulate-duration", time.Minute, "How long -simulate-load runs for")
+	simulateDocSize := flag.Int("simulate-doc-size", 1024, "Approximate size in bytes of the docs pushed by -simulate-load")
+	simulateChannels := flag.String("simulate-channels", "", "Comma-separated channels the docs pushed by -simulate-load are assigned to, and its clients pull; defaults to pulling all channels")
+	simulateWritesPerSec := flag.Float64("simulate-writes-per-sec", 1, "Rate each -simulate-load client pushes docs at; zero only pulls")
+
 	flag.Parse()
 
+	if *verifyConfig {
+		os.Exit(VerifyConfigFiles(runMode, flag.Args(), os.Stdout))
+	}
+	if *migrateConfig {
+		os.Exit(MigrateConfigFile(runMode, flag.Arg(0), *migrateConfigOutput, os.Stdout))
+	}
+	if *collectDiagnostics != "" {
+		os.Exit(CollectDiagnostics(*authAddr, *collectDiagnostics, *diagnosticsRedactLevel, *diagnosticsProfileSecs, os.Stdout))
+	}
+	if *rebuildChannelIndex != "" {
+		os.Exit(RebuildChannelIndexFromConfig(runMode, flag.Args(), *rebuildChannelIndex, *rebuildChannels, os.Stdout))
+	}
+	if *exportPrincipals != "" {
+		os.Exit(ExportPrincipalsFromServer(*authAddr, *exportPrincipals, *principalsFile, os.Stdout))
+	}
+	if *importPrincipals != "" {
+		os.Exit(ImportPrincipalsToServer(*authAddr, *importPrincipals, *principalsFile, *principalsImportMode, os.Stdout))
+	}
+	if *backupMetadata != "" {
+		os.Exit(BackupMetadataFromServer(*authAddr, *backupMetadata, *metadataFile, *backupSessions, os.Stdout))
+	}
+	if *restoreMetadata != "" {
+		os.Exit(RestoreMetadataToServer(*authAddr, *restoreMetadata, *metadataFile, os.Stdout))
+	}
+	if *repairSequences != "" {
+		os.Exit(RepairSequencesOnServer(*authAddr, *repairSequences, *repairSequencesMargin, *repairSequencesPreview, os.Stdout))
+	}
+	if *cleanMetadata != "" {
+		os.Exit(CleanMetadataOnServer(*authAddr, *cleanMetadata, *cleanMetadataCheckpointAge, *cleanMetadataPreview, os.Stdout))
+	}
+	if *simulateLoad != "" {
+		options := LoadSimulatorOptions{
+			TargetURL:    *simulateLoad,
+			Clients:      *simulateClients,
+			Duration:     *simulateDuration,
+			DocSize:      *simulateDocSize,
+			WritesPerSec: *simulateWritesPerSec,
+		}
+		if *simulateChannels != "" {
+			options.Channels = strings.Split(*simulateChannels, ",")
+		}
+		os.Exit(SimulateLoad(options, os.Stdout))
+	}
+
 	if flag.NArg() > 0 {
 		// Read the configuration file(s), if any:
 		for i := 0; i < flag.NArg(); i++ {
@@ -877,7 +1486,7 @@ func SetMaxFileDescriptors(maxP *uint64) {
 	}
 }
 
-func (config *ServerConfig) Serve(addr string, handler http.Handler) {
+func (config *ServerConfig) Serve(addr string, handler http.Handler, tlsConfig *ListenerTLSConfig) {
 	maxConns := DefaultMaxIncomingConnections
 	if config.MaxIncomingConnections != nil {
 		maxConns = *config.MaxIncomingConnections
@@ -887,7 +1496,11 @@ func (config *ServerConfig) Serve(addr string, handler http.Handler) {
 	if config.Unsupported != nil && config.Unsupported.Http2Config != nil {
 		http2Enabled = *config.Unsupported.Http2Config.Enabled
 	}
-	err := base.ListenAndServeHTTP(
+	tlsOptions, err := tlsConfig.tlsOptions(http2Enabled)
+	if err != nil {
+		base.Fatalf(base.KeyAll, "Invalid TLS config for HTTP server on %s: %v", base.UD(addr), err)
+	}
+	err = base.ListenAndServeHTTP(
 		addr,
 		maxConns,
 		config.SSLCert,
@@ -895,7 +1508,7 @@ func (config *ServerConfig) Serve(addr string, handler http.Handler) {
 		handler,
 		config.ServerReadTimeout,
 		config.ServerWriteTimeout,
-		http2Enabled,
+		tlsOptions,
 	)
 	if err != nil {
 		base.Fatalf(base.KeyAll, "Failed to start HTTP server on %s: %v", base.UD(addr), err)
@@ -960,27 +1573,57 @@ func RunServer(config *ServerConfig) {
 	}
 
 	sc := NewServerContext(config)
+	if err := sc.startAuditLogger(); err != nil {
+		base.Fatalf(base.KeyAll, "Error starting audit log: %v", err)
+	}
+	if err := sc.setupAdminIPFilter(); err != nil {
+		base.Fatalf(base.KeyAll, "Configuration error: %v", err)
+	}
+	if err := validateReadOnlyConfig(config.ReadOnly); err != nil {
+		base.Fatalf(base.KeyAll, "Configuration error: %v", err)
+	} else if config.ReadOnly != nil && config.ReadOnly.Enabled {
+		base.Infof(base.KeyAll, "Running as a read-only replica: the public API will reject writes")
+	}
 	for _, dbConfig := range config.Databases {
 		if _, err := sc.AddDatabaseFromConfig(dbConfig); err != nil {
 			base.Fatalf(base.KeyAll, "Error opening database %s: %+v", base.MD(dbConfig.Name), err)
 		}
 	}
+	if err := sc.validateDatabaseAliases(); err != nil {
+		base.Fatalf(base.KeyAll, "Configuration error: %v", err)
+	}
+	if err := sc.startDatabasesDirWatcher(); err != nil {
+		base.Fatalf(base.KeyAll, "Error loading databases_dir: %v", err)
+	}
+	if err := sc.startConfigBucketWatcher(); err != nil {
+		base.Fatalf(base.KeyAll, "Error loading databases from config_bucket: %v", err)
+	}
+
+	var publicTLS, adminTLS, metricsTLS *ListenerTLSConfig
+	if config.TLS != nil {
+		publicTLS, adminTLS, metricsTLS = config.TLS.Public, config.TLS.Admin, config.TLS.Metrics
+	}
 
 	if config.ProfileInterface != nil {
 		//runtime.MemProfileRate = 10 * 1024
 		base.Infof(base.KeyAll, "Starting profile server on %s", base.UD(*config.ProfileInterface))
-		go func() {
-			http.ListenAndServe(*config.ProfileInterface, nil)
-		}()
+		if metricsTLS != nil && config.SSLCert != nil {
+			go config.Serve(*config.ProfileInterface, http.DefaultServeMux, metricsTLS)
+		} else {
+			go func() {
+				http.ListenAndServe(*config.ProfileInterface, nil)
+			}()
+		}
 	}
 
 	go sc.PostStartup()
 
 	base.Infof(base.KeyAll, "Starting admin server on %s", base.UD(*config.AdminInterface))
-	go config.Serve(*config.AdminInterface, CreateAdminHandler(sc))
+	go config.Serve(*config.AdminInterface, CreateAdminHandler(sc), adminTLS)
+	go base.NotifyListenersReady(*config.AdminInterface, *config.Interface)
 
 	base.Infof(base.KeyAll, "Starting server on %s ...", base.UD(*config.Interface))
-	config.Serve(*config.Interface, CreatePublicHandler(sc))
+	config.Serve(*config.Interface, CreatePublicHandler(sc), publicTLS)
 }
 
 func HandleSighup() {
@@ -1020,11 +1663,19 @@ func ValidateConfigOrPanic(runMode SyncGatewayRunMode) {
 
 func RegisterSignalHandler() {
 	signalchannel := make(chan os.Signal, 1)
-	signal.Notify(signalchannel, syscall.SIGHUP, os.Interrupt, os.Kill)
+	signals := []os.Signal{syscall.SIGHUP, os.Interrupt, os.Kill}
+	if base.ListenerRestartSignal != nil {
+		signals = append(signals, base.ListenerRestartSignal)
+	}
+	signal.Notify(signalchannel, signals...)
 
 	go func() {
 		for sig := range signalchannel {
 			base.Infof(base.KeyAll, "Handling signal: %v", sig)
+			if sig == base.ListenerRestartSignal {
+				go RestartListeners()
+				continue
+			}
 			switch sig {
 			case syscall.SIGHUP:
 				HandleSighup()
@@ -0,0 +1,335 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbase/sync_gateway/db"
+)
+
+// Key of the doc in the config bucket holding the configs of every bucket-stored database.  A single doc is used so
+// that nodes can detect changes with a single read, and so updates can be made atomically with CAS.
+const ConfigBucketDocKey = "_sync:dbconfigs"
+
+// How often the config bucket is polled for changes, if not set in the config.
+const kDefaultConfigBucketPollIntervalSecs = 10
+
+// The contents of the ConfigBucketDocKey doc.
+type bucketDbConfigs struct {
+	Databases map[string]*bucketDbConfig `json:"databases"`
+}
+
+// A single database config stored in the config bucket.  The version is incremented on every change, so nodes can
+// tell which configs they need to reload.  Secrets aren't stored in the config: its bucket passwords have to be secret
+// references, which each node resolves, and its users' passwords are replaced by their bcrypt hashes.
+type bucketDbConfig struct {
+	Version uint64            `json:"version"`
+	Config  json.RawMessage   `json:"config"`
+	Hashes  map[string][]byte `json:"password_hashes,omitempty"` // bcrypt hashes of the users' passwords, by username
+}
+
+// Keeps the databases in a ServerContext in sync with the database configs stored in the config bucket, so that
+// databases added, changed or removed on any node take effect on every node.
+type configBucketWatcher struct {
+	sc       *ServerContext
+	bucket   base.Bucket
+	versions map[string]uint64 // Versions of the bucket-stored configs loaded on this node, keyed by database name
+	failed   map[string]uint64 // Versions of configs that failed to load, so they aren't retried until changed
+	lock     sync.Mutex        // Serializes polls and updates
+	stopped  bool
+	ticker   *time.Ticker
+	stopChan chan struct{}
+}
+
+// Opens the configured config bucket, if any, and loads the databases stored in it.  The bucket is then polled for
+// changes until the ServerContext is closed.
+func (sc *ServerContext) startConfigBucketWatcher() error {
+	bucketConfig := sc.config.ConfigBucket
+	if bucketConfig == nil {
+		return nil
+	}
+
+	if bucketConfig.Bucket == nil || *bucketConfig.Bucket == "" {
+		return fmt.Errorf("config_bucket must specify a bucket")
+	}
+
+	// Resolve the password into a copy, so any secret reference isn't replaced in the server config
+	resolved := bucketConfig.BucketConfig
+	var err error
+	if resolved.Password, err = base.ResolveSecret(resolved.Password); err != nil {
+		return err
+	}
+
+	spec := resolved.MakeBucketSpec()
+	spec.CouchbaseDriver = base.ChooseCouchbaseDriver(base.DataBucket)
+	bucket, err := base.GetBucket(spec, nil)
+	if err != nil {
+		return err
+	}
+
+	pollInterval := time.Duration(kDefaultConfigBucketPollIntervalSecs) * time.Second
+	if bucketConfig.PollIntervalSecs != nil && *bucketConfig.PollIntervalSecs > 0 {
+		pollInterval = time.Duration(*bucketConfig.PollIntervalSecs) * time.Second
+	}
+
+	watcher := &configBucketWatcher{
+		sc:       sc,
+		bucket:   bucket,
+		versions: map[string]uint64{},
+		failed:   map[string]uint64{},
+		stopChan: make(chan struct{}),
+	}
+	base.Infof(base.KeyAll, "Loading database configs from config bucket %q, polling every %v", base.MD(spec.BucketName), pollInterval)
+	watcher.poll()
+
+	watcher.ticker = time.NewTicker(pollInterval)
+	go func() {
+		for {
+			select {
+			case <-watcher.ticker.C:
+				watcher.poll()
+			case <-watcher.stopChan:
+				return
+			}
+		}
+	}()
+
+	sc.configBucketWatcher = watcher
+	return nil
+}
+
+// Stops polling the config bucket and closes it, waiting for any in-progress poll to complete.  Must be called
+// without holding sc.lock, as polls acquire it when adding and removing databases.
+func (sc *ServerContext) stopConfigBucketWatcher() {
+	watcher := sc.configBucketWatcher
+	if watcher == nil {
+		return
+	}
+	watcher.ticker.Stop()
+	close(watcher.stopChan)
+
+	watcher.lock.Lock()
+	watcher.stopped = true
+	watcher.bucket.Close()
+	watcher.lock.Unlock()
+	sc.configBucketWatcher = nil
+}
+
+// Reads the stored database configs from the bucket.  Returns an empty set if none have been stored yet.
+func (w *configBucketWatcher) readConfigs() (*bucketDbConfigs, error) {
+	stored := &bucketDbConfigs{}
+	if _, err := w.bucket.Get(ConfigBucketDocKey, stored); err != nil && !base.IsDocNotFoundError(err) {
+		return nil, err
+	}
+	if stored.Databases == nil {
+		stored.Databases = map[string]*bucketDbConfig{}
+	}
+	return stored, nil
+}
+
+// Compares the stored database configs against those loaded on this node, adding, reloading and removing databases
+// as needed.
+func (w *configBucketWatcher) poll() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.stopped {
+		return
+	}
+
+	stored, err := w.readConfigs()
+	if err != nil {
+		base.Warnf(base.KeyAll, "Unable to read database configs from config bucket: %v", err)
+		return
+	}
+
+	for dbName, entry := range stored.Databases {
+		if w.versions[dbName] == entry.Version || w.failed[dbName] == entry.Version {
+			continue
+		}
+		if err := w.loadConfig(dbName, entry); err != nil {
+			base.Errorf(base.KeyAll, "Error loading config for db /%s from config bucket: %v", base.MD(dbName), err)
+			w.failed[dbName] = entry.Version
+			continue
+		}
+		w.versions[dbName] = entry.Version
+		delete(w.failed, dbName)
+	}
+
+	for dbName := range w.versions {
+		if _, ok := stored.Databases[dbName]; !ok {
+			base.Infof(base.KeyAll, "Config for db /%s removed from config bucket, removing db", base.MD(dbName))
+			w.sc.removeDatabaseAndConfig(dbName)
+			delete(w.versions, dbName)
+		}
+	}
+	for dbName := range w.failed {
+		if _, ok := stored.Databases[dbName]; !ok {
+			delete(w.failed, dbName)
+		}
+	}
+}
+
+// Adds or reloads a database from its stored config.  Requires w.lock to be held.
+func (w *configBucketWatcher) loadConfig(dbName string, entry *bucketDbConfig) error {
+	var config *DbConfig
+	if err := json.Unmarshal(entry.Config, &config); err != nil {
+		return err
+	}
+	if config == nil {
+		return fmt.Errorf("stored config is empty")
+	}
+	for username, hash := range entry.Hashes {
+		if user := config.Users[username]; user != nil {
+			user.PasswordHash = hash
+		}
+	}
+	if err := config.setup(dbName); err != nil {
+		return err
+	}
+	if err := w.sc.config.validateDbConfig(config); err != nil {
+		return err
+	}
+
+	// Don't let a stored config replace a database defined locally, in the server config or databases_dir
+	if _, owned := w.versions[dbName]; !owned && w.sc.GetDatabaseConfig(dbName) != nil {
+		return fmt.Errorf("database %q is already defined locally", dbName)
+	}
+
+	base.Infof(base.KeyAll, "Loading db /%s from config bucket", base.MD(dbName))
+	_, err := w.sc.replaceDatabaseFromConfig(config)
+	return err
+}
+
+// Returns true if the given database was loaded from the config bucket.
+func (w *configBucketWatcher) ownsDatabase(dbName string) bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	_, owned := w.versions[dbName]
+	return owned
+}
+
+// Adds the database on this node, or replaces it if replace is true, then stores its config in the config bucket
+// so every other node loads it too.
+func (w *configBucketWatcher) putDatabase(config *DbConfig, replace bool) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	for _, field := range config.secretFields() {
+		if *field != "" && !base.IsSecretRef(*field) {
+			return base.HTTPErrorf(http.StatusBadRequest, "Passwords and other secrets in database configs stored in the config bucket must be secret references")
+		}
+	}
+
+	var dbcontext *db.DatabaseContext
+	var err error
+	if replace {
+		dbcontext, err = w.sc.replaceDatabaseFromConfig(config)
+	} else {
+		dbcontext, err = w.sc.AddDatabaseFromConfig(config)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := w._storeConfig(dbcontext, config); err != nil {
+		if replace {
+			// Reload whatever version is stored on the next poll, so this node doesn't diverge from the others
+			w.versions[config.Name] = 0
+		} else {
+			w.sc.removeDatabaseAndConfig(config.Name)
+		}
+		return err
+	}
+	return nil
+}
+
+// Removes the database's config from the config bucket, so every node removes the database, and removes it from
+// this node.  Returns false if this node had no such database.
+func (w *configBucketWatcher) deleteDatabase(dbName string) (bool, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if err := w._deleteConfig(dbName); err != nil {
+		return false, err
+	}
+	return w.sc.removeDatabaseAndConfig(dbName), nil
+}
+
+// Applies the given change to the ConfigBucketDocKey doc, using CAS to retry on conflicting updates.
+func (w *configBucketWatcher) updateConfigs(callback func(stored *bucketDbConfigs)) error {
+	_, err := w.bucket.Update(ConfigBucketDocKey, 0, func(currentValue []byte) ([]byte, *uint32, error) {
+		stored := &bucketDbConfigs{}
+		if len(currentValue) > 0 {
+			if err := json.Unmarshal(currentValue, stored); err != nil {
+				return nil, nil, err
+			}
+		}
+		if stored.Databases == nil {
+			stored.Databases = map[string]*bucketDbConfig{}
+		}
+		callback(stored)
+		updated, err := json.Marshal(stored)
+		return updated, nil, err
+	})
+	return err
+}
+
+// Stores the given database config in the config bucket, with the next version number.  The users' passwords are
+// stored as the hashes the database has for them.  Requires w.lock to be held.
+func (w *configBucketWatcher) _storeConfig(dbcontext *db.DatabaseContext, config *DbConfig) error {
+	storedConfig, err := config.DeepCopy()
+	if err != nil {
+		return err
+	}
+	var hashes map[string][]byte
+	for username, user := range storedConfig.Users {
+		if user.Password == nil {
+			continue
+		}
+		user.Password = nil
+		if authUser, err := dbcontext.Authenticator().GetUser(username); err == nil && authUser != nil && authUser.PasswordHash() != nil {
+			if hashes == nil {
+				hashes = map[string][]byte{}
+			}
+			hashes[username] = authUser.PasswordHash()
+		}
+	}
+	data, err := json.Marshal(storedConfig)
+	if err != nil {
+		return err
+	}
+
+	var version uint64
+	err = w.updateConfigs(func(stored *bucketDbConfigs) {
+		version = 1
+		if existing := stored.Databases[config.Name]; existing != nil {
+			version = existing.Version + 1
+		}
+		stored.Databases[config.Name] = &bucketDbConfig{Version: version, Config: data, Hashes: hashes}
+	})
+	if err != nil {
+		return base.HTTPErrorf(http.StatusBadGateway, "Unable to store config in config bucket: %v", err)
+	}
+
+	w.versions[config.Name] = version
+	delete(w.failed, config.Name)
+	return nil
+}
+
+// Removes the given database's config from the config bucket.  Requires w.lock to be held.
+func (w *configBucketWatcher) _deleteConfig(dbName string) error {
+	err := w.updateConfigs(func(stored *bucketDbConfigs) {
+		delete(stored.Databases, dbName)
+	})
+	if err != nil {
+		return base.HTTPErrorf(http.StatusBadGateway, "Unable to remove config from config bucket: %v", err)
+	}
+
+	delete(w.versions, dbName)
+	delete(w.failed, dbName)
+	return nil
+}
@@ -0,0 +1,107 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"testing"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbase/sync_gateway/db"
+	"github.com/stretchr/testify/assert"
+)
+
+// Stores a database config in the config bucket as another node would.
+func storeBucketDbConfig(t *testing.T, w *configBucketWatcher, dbName string, config string) {
+	err := w.updateConfigs(func(stored *bucketDbConfigs) {
+		version := uint64(1)
+		if existing := stored.Databases[dbName]; existing != nil {
+			version = existing.Version + 1
+		}
+		stored.Databases[dbName] = &bucketDbConfig{Version: version, Config: json.RawMessage(config)}
+	})
+	assert.NoError(t, err)
+}
+
+func TestConfigBucket(t *testing.T) {
+	configBucketName := "sg_config"
+	configBucket := &ConfigBucketConfig{BucketConfig: BucketConfig{Server: &DefaultServer, Bucket: &configBucketName}}
+	sc := NewServerContext(&ServerConfig{ConfigBucket: configBucket, AdminInterface: &DefaultAdminInterface})
+	defer sc.Close()
+
+	mainBucket := "main"
+	_, err := sc.AddDatabaseFromConfig(&DbConfig{Name: "main", BucketConfig: BucketConfig{Server: &DefaultServer, Bucket: &mainBucket}})
+	assert.NoError(t, err)
+
+	assert.NoError(t, sc.startConfigBucketWatcher())
+	watcher := sc.configBucketWatcher
+	assert.Equal(t, []string{"main"}, sortedDatabaseNames(sc))
+
+	// Configs stored by other nodes are loaded, except for those of databases defined locally
+	storeBucketDbConfig(t, watcher, "db1", `{"server": "walrus:", "revs_limit": 100}`)
+	storeBucketDbConfig(t, watcher, "main", `{"server": "walrus:"}`)
+	watcher.poll()
+	assert.Equal(t, []string{"db1", "main"}, sortedDatabaseNames(sc))
+	assert.Equal(t, "main", *sc.GetDatabaseConfig("main").Bucket)
+	assert.True(t, watcher.ownsDatabase("db1"))
+	assert.False(t, watcher.ownsDatabase("main"))
+
+	// Changed configs are reloaded, but invalid changes leave the existing database running
+	storeBucketDbConfig(t, watcher, "db1", `{"server": "walrus:", "revs_limit": 500}`)
+	watcher.poll()
+	assert.Equal(t, uint32(500), *sc.GetDatabaseConfig("db1").RevsLimit)
+	storeBucketDbConfig(t, watcher, "db1", `{"server": "walrus:", "revs_limit": "lots"}`)
+	watcher.poll()
+	assert.Equal(t, uint32(500), *sc.GetDatabaseConfig("db1").RevsLimit)
+
+	// Databases added on this node are stored for the other nodes
+	db2Bucket := "db2"
+	assert.NoError(t, watcher.putDatabase(&DbConfig{Name: "db2", BucketConfig: BucketConfig{Server: &DefaultServer, Bucket: &db2Bucket}}, false))
+	stored, err := watcher.readConfigs()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), stored.Databases["db2"].Version)
+	watcher.poll()
+	assert.Equal(t, []string{"db1", "db2", "main"}, sortedDatabaseNames(sc))
+
+	// Plaintext bucket passwords aren't stored, and users' passwords are stored as their hashes
+	db3Bucket := "db3"
+	err = watcher.putDatabase(&DbConfig{Name: "db3", BucketConfig: BucketConfig{Server: &DefaultServer, Bucket: &db3Bucket, Password: "letmein"}}, false)
+	status, _ := base.ErrorAsHTTPStatus(err)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Nil(t, sc.GetDatabaseConfig("db3"))
+	password := "s3cret"
+	assert.NoError(t, watcher.putDatabase(&DbConfig{Name: "db3", BucketConfig: BucketConfig{Server: &DefaultServer, Bucket: &db3Bucket},
+		Users: map[string]*db.PrincipalConfig{"alice": {Password: &password}}}, false))
+	stored, err = watcher.readConfigs()
+	assert.NoError(t, err)
+	assert.NotContains(t, string(stored.Databases["db3"].Config), password)
+	assert.NotEmpty(t, stored.Databases["db3"].Hashes["alice"])
+	var loaded *DbConfig
+	assert.NoError(t, json.Unmarshal(stored.Databases["db3"].Config, &loaded))
+	assert.Nil(t, loaded.Users["alice"].Password)
+	found, err := watcher.deleteDatabase("db3")
+	assert.NoError(t, err)
+	assert.True(t, found)
+
+	// Databases deleted on this node, or by other nodes, are removed
+	found, err = watcher.deleteDatabase("db2")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.NoError(t, watcher.updateConfigs(func(stored *bucketDbConfigs) {
+		delete(stored.Databases, "db1")
+	}))
+	watcher.poll()
+	assert.Equal(t, []string{"main"}, sortedDatabaseNames(sc))
+	stored, err = watcher.readConfigs()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"main"}, sortedBucketDbConfigNames(stored))
+}
+
+func sortedBucketDbConfigNames(stored *bucketDbConfigs) []string {
+	names := make([]string, 0, len(stored.Databases))
+	for name := range stored.Databases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,303 @@
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/ghodss/yaml"
+)
+
+// MigrateConfigFile converts a config file written for an older Sync Gateway to the current schema: deprecated
+// logging options are moved into the "logging" section, credentials embedded in bucket server URLs are moved into
+// username and password properties, and shadow configs are moved into the "deprecated" section.  A warning for each
+// change, and a diff of the old and new config, are written to w.  If outputPath is set the migrated config is
+// written to it, as YAML if it has a .yaml or .yml extension.  Environment variable references are kept as they are.
+// Returns the process exit code.
+func MigrateConfigFile(runMode SyncGatewayRunMode, path string, outputPath string, w io.Writer) int {
+	if path == "" {
+		fmt.Fprintln(w, "No config file specified")
+		return 1
+	}
+	data, err := readConfigData(path)
+	if err != nil {
+		fmt.Fprintf(w, "Error reading config file %s: %v\n", path, err)
+		return 1
+	}
+	if configFormatForPath(path) == configFormatYAML {
+		if data, err = yaml.YAMLToJSON(data); err != nil {
+			fmt.Fprintf(w, "Error parsing config file %s: %v\n", path, err)
+			return 1
+		}
+	} else {
+		data = base.ConvertBackQuotedStrings(data)
+	}
+
+	var config map[string]interface{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		fmt.Fprintf(w, "Error parsing config file %s: %v\n", path, err)
+		return 1
+	}
+	original, _ := json.MarshalIndent(config, "", "  ")
+
+	warnings := migrateServerConfig(config)
+	migrated, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		fmt.Fprintf(w, "Error encoding migrated config: %v\n", err)
+		return 1
+	}
+
+	// Make sure the current version can read the migrated config
+	if _, err := parseServerConfig(runMode, migrated, configFormatJSON); err != nil {
+		fmt.Fprintf(w, "Error parsing migrated config: %v\n", err)
+		return 1
+	}
+
+	if len(warnings) == 0 {
+		fmt.Fprintln(w, "Config is already up to date")
+	} else {
+		for _, warning := range warnings {
+			fmt.Fprintf(w, "Warning: %s\n", warning)
+		}
+		fmt.Fprintln(w)
+		writeLineDiff(w, strings.Split(string(original), "\n"), strings.Split(string(migrated), "\n"))
+	}
+
+	if outputPath != "" {
+		output := append(migrated, '\n')
+		if configFormatForPath(outputPath) == configFormatYAML {
+			if output, err = yaml.JSONToYAML(migrated); err != nil {
+				fmt.Fprintf(w, "Error encoding migrated config: %v\n", err)
+				return 1
+			}
+		}
+		// Configs hold bucket passwords, so the migrated config is only readable by its owner
+		if err := ioutil.WriteFile(outputPath, output, 0600); err != nil {
+			fmt.Fprintf(w, "Error writing %s: %v\n", outputPath, err)
+			return 1
+		}
+		fmt.Fprintf(w, "Migrated config saved to %s\n", outputPath)
+	}
+	return 0
+}
+
+// Migrates the deprecated options of a server config, as decoded into a generic map, returning a warning describing
+// each change.
+func migrateServerConfig(config map[string]interface{}) (warnings []string) {
+
+	// Moves a deprecated option to its replacement, unless the replacement is already set.  If convert is non-nil,
+	// it converts the value to the replacement's format, returning false if the value is invalid.
+	move := func(from map[string]interface{}, key, oldName string, to map[string]interface{}, toKey, newName string, convert func(interface{}) (interface{}, bool)) {
+		value, ok := takeConfigKey(from, key)
+		if !ok {
+			return
+		}
+		if to[toKey] != nil {
+			warnings = append(warnings, fmt.Sprintf("Removed deprecated option %q, as %q is already set", oldName, newName))
+			return
+		}
+		if convert != nil {
+			if value, ok = convert(value); !ok {
+				warnings = append(warnings, fmt.Sprintf("Removed deprecated option %q, as its value is invalid", oldName))
+				return
+			}
+		}
+		to[toKey] = value
+		warnings = append(warnings, fmt.Sprintf("Moved deprecated option %q to %q", oldName, newName))
+	}
+
+	// The new log_file_path is the directory the log files are written to, rather than the path of a file
+	logFileDir := func(value interface{}) (interface{}, bool) {
+		path, ok := value.(string)
+		if ok && filepath.Ext(path) != "" {
+			path = filepath.Dir(path)
+		}
+		return path, ok
+	}
+
+	logging, _ := config[configKey(config, "logging")].(map[string]interface{})
+	if logging == nil {
+		logging = map[string]interface{}{}
+	}
+	console, _ := logging["console"].(map[string]interface{})
+	if console == nil {
+		console = map[string]interface{}{}
+	}
+
+	if defaultLog, ok := takeConfigKey(logging, "default"); ok {
+		if defaultLog, ok := defaultLog.(map[string]interface{}); ok {
+			move(defaultLog, "LogFilePath", "logging.default.LogFilePath", logging, "log_file_path", "logging.log_file_path", func(value interface{}) (interface{}, bool) {
+				path, ok := value.(string)
+				return filepath.Dir(path), ok
+			})
+			move(defaultLog, "LogKeys", "logging.default.LogKeys", console, "log_keys", "logging.console.log_keys", nil)
+			move(defaultLog, "LogLevel", "logging.default.LogLevel", console, "log_level", "logging.console.log_level", func(value interface{}) (interface{}, bool) {
+				var level base.Level
+				name, ok := value.(string)
+				if !ok || level.UnmarshalText([]byte(name)) != nil {
+					return nil, false
+				}
+				return base.ToLogLevel(level).String(), true
+			})
+			move(defaultLog, "RedactionLevel", "logging.default.RedactionLevel", logging, "redaction_level", "logging.redaction_level", nil)
+			for key := range defaultLog {
+				warnings = append(warnings, fmt.Sprintf("Removed deprecated option %q, which has no equivalent.  Each log file's rotation is set in its own section of \"logging\"", "logging.default."+key))
+			}
+		}
+	}
+
+	move(config, "logFilePath", "logFilePath", logging, "log_file_path", "logging.log_file_path", logFileDir)
+	move(config, "log", "log", console, "log_keys", "logging.console.log_keys", nil)
+
+	if logKeys, ok := console["log_keys"].([]interface{}); ok {
+		for i, key := range logKeys {
+			// HTTP+ is still a valid log key, but other keys no longer have a "+" suffix
+			if key, ok := key.(string); ok && key != "HTTP+" && strings.HasSuffix(key, "+") {
+				logKeys[i] = strings.TrimSuffix(key, "+")
+				warnings = append(warnings, fmt.Sprintf("Changed deprecated log key %q to %q", key, logKeys[i]))
+			}
+		}
+	}
+
+	if len(console) > 0 {
+		logging["console"] = console
+	}
+	if len(logging) > 0 {
+		delete(config, configKey(config, "logging"))
+		config["logging"] = logging
+	}
+
+	databases, _ := config[configKey(config, "databases")].(map[string]interface{})
+	dbNames := make([]string, 0, len(databases))
+	for name := range databases {
+		dbNames = append(dbNames, name)
+	}
+	sort.Strings(dbNames)
+	for _, name := range dbNames {
+		if dbConfig, ok := databases[name].(map[string]interface{}); ok {
+			for _, warning := range migrateDbConfig(dbConfig) {
+				warnings = append(warnings, fmt.Sprintf("Database %q: %s", name, warning))
+			}
+		}
+	}
+	return warnings
+}
+
+// Migrates the deprecated options of a database config, returning a warning describing each change.
+func migrateDbConfig(dbConfig map[string]interface{}) (warnings []string) {
+	deprecated, _ := dbConfig["deprecated"].(map[string]interface{})
+	if shadow, ok := takeConfigKey(dbConfig, "shadow"); ok {
+		if deprecated == nil {
+			deprecated = map[string]interface{}{}
+			dbConfig["deprecated"] = deprecated
+		}
+		if deprecated["shadow"] == nil {
+			deprecated["shadow"] = shadow
+			warnings = append(warnings, fmt.Sprintf("Moved %q to %q", "shadow", "deprecated.shadow"))
+		} else {
+			warnings = append(warnings, fmt.Sprintf("Removed %q, as %q is already set", "shadow", "deprecated.shadow"))
+		}
+	}
+
+	buckets := []struct {
+		name   string
+		config interface{}
+	}{
+		{"", dbConfig},
+		{"deprecated.shadow.", deprecated["shadow"]},
+		{"channel_index.", dbConfig["channel_index"]},
+		{"metadata_bucket.", dbConfig["metadata_bucket"]},
+	}
+	for _, bucket := range buckets {
+		if bucketConfig, ok := bucket.config.(map[string]interface{}); ok && migrateBucketCredentials(bucketConfig) {
+			warnings = append(warnings, fmt.Sprintf("Moved the credentials in %sserver to %susername and %spassword", bucket.name, bucket.name, bucket.name))
+		}
+	}
+	return warnings
+}
+
+// Removes the credentials from a bucket config's server URL, putting them into the username and password properties
+// if those aren't already set, as DbConfig.setup does.  Returns false if the URL doesn't have credentials.
+func migrateBucketCredentials(bucketConfig map[string]interface{}) bool {
+	serverKey := configKey(bucketConfig, "server")
+	server, _ := bucketConfig[serverKey].(string)
+	serverURL, err := url.Parse(server)
+	if err != nil || serverURL.User == nil {
+		return false
+	}
+	if bucketConfig[configKey(bucketConfig, "username")] == nil {
+		bucketConfig["username"] = serverURL.User.Username()
+	}
+	if password, exists := serverURL.User.Password(); exists && bucketConfig[configKey(bucketConfig, "password")] == nil {
+		bucketConfig["password"] = password
+	}
+	serverURL.User = nil
+	bucketConfig[serverKey] = serverURL.String()
+	return true
+}
+
+// Returns the key in the config map that matches name, which like encoding/json is case-insensitive, or name if
+// there isn't one.
+func configKey(config map[string]interface{}, name string) string {
+	if _, ok := config[name]; ok {
+		return name
+	}
+	for key := range config {
+		if strings.EqualFold(key, name) {
+			return key
+		}
+	}
+	return name
+}
+
+// Removes the value with the given case-insensitive key from the config map, returning it.
+func takeConfigKey(config map[string]interface{}, name string) (interface{}, bool) {
+	key := configKey(config, name)
+	value, ok := config[key]
+	delete(config, key)
+	return value, ok
+}
+
+// Writes the lines that were removed from and added to a text, prefixed with "-" and "+", using the longest common
+// subsequence of the lines.
+func writeLineDiff(w io.Writer, before, after []string) {
+	lcs := make([][]int, len(before)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(after)+1)
+	}
+	for i := len(before) - 1; i >= 0; i-- {
+		for j := len(after) - 1; j >= 0; j-- {
+			if before[i] == after[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	i, j := 0, 0
+	for i < len(before) || j < len(after) {
+		switch {
+		case i < len(before) && j < len(after) && before[i] == after[j]:
+			i++
+			j++
+		case i < len(before) && (j == len(after) || lcs[i+1][j] >= lcs[i][j+1]):
+			fmt.Fprintf(&buf, "- %s\n", before[i])
+			i++
+		default:
+			fmt.Fprintf(&buf, "+ %s\n", after[j])
+			j++
+		}
+	}
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,131 @@
+package rest
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/couchbase/sync_gateway/auth"
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigFormatForPath(t *testing.T) {
+	assert.Equal(t, configFormatJSON, configFormatForPath("sync_gateway.json"))
+	assert.Equal(t, configFormatJSON, configFormatForPath("sync_gateway"))
+	assert.Equal(t, configFormatYAML, configFormatForPath("/etc/sync_gateway.yaml"))
+	assert.Equal(t, configFormatYAML, configFormatForPath("sync_gateway.YML"))
+	assert.Equal(t, configFormatYAML, configFormatForPath("http://example.com/config.yaml?rev=2"))
+	assert.Equal(t, configFormatJSON, configFormatForPath("http://example.com/config?format=.yaml"))
+}
+
+func TestReadServerConfigYAML(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config_yaml")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	os.Setenv("SG_TEST_YAML_BUCKET", "yaml-bucket")
+	defer os.Unsetenv("SG_TEST_YAML_BUCKET")
+
+	path := filepath.Join(dir, "sync_gateway.yaml")
+	assert.NoError(t, ioutil.WriteFile(path, []byte(`
+interface: ":4984"
+databases:
+  db:
+    server: "walrus:"
+    bucket: "${SG_TEST_YAML_BUCKET}"
+    revs_limit: 20
+    users:
+      GUEST: {disabled: false, admin_channels: ["*"]}
+    sync: |
+      function(doc) {
+        channel(doc.channels);
+      }
+`), 0600))
+
+	config, err := ReadServerConfig(SyncGatewayRunModeNormal, path)
+	assert.NoError(t, err)
+	assert.Equal(t, ":4984", *config.Interface)
+
+	dbConfig := config.Databases["db"]
+	if assert.NotNil(t, dbConfig) {
+		assert.Equal(t, "db", dbConfig.Name)
+		assert.Equal(t, "yaml-bucket", *dbConfig.Bucket)
+		assert.Equal(t, uint32(20), *dbConfig.RevsLimit)
+		assert.Equal(t, "function(doc) {\n  channel(doc.channels);\n}\n", *dbConfig.Sync)
+		assert.False(t, dbConfig.Users["GUEST"].Disabled)
+	}
+
+	// Invalid YAML is reported as an error
+	assert.NoError(t, ioutil.WriteFile(path, []byte("databases:\n  db: [\n"), 0600))
+	_, err = ReadServerConfig(SyncGatewayRunModeNormal, path)
+	assert.Error(t, err)
+}
+
+func TestDbConfigWithResolvedSecrets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config_secrets")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	passwordPath := filepath.Join(dir, "password")
+	assert.NoError(t, ioutil.WriteFile(passwordPath, []byte("bucket-password\n"), 0600))
+	clientSecretPath := filepath.Join(dir, "client_secret")
+	assert.NoError(t, ioutil.WriteFile(clientSecretPath, []byte("oidc-secret"), 0600))
+
+	passwordRef := "file://" + filepath.ToSlash(passwordPath)
+	clientSecretRef := "file://" + filepath.ToSlash(clientSecretPath)
+	clientID := "sync_gateway"
+	dbConfig := &DbConfig{
+		Name:         "db",
+		BucketConfig: BucketConfig{Username: "sg", Password: passwordRef},
+		OIDCConfig: &auth.OIDCOptions{Providers: auth.OIDCProviderMap{
+			"provider": {Issuer: "http://example.com", ClientID: &clientID, ValidationKey: &clientSecretRef},
+		}},
+	}
+
+	resolved, err := dbConfig.withResolvedSecrets()
+	assert.NoError(t, err)
+	assert.Equal(t, "bucket-password", resolved.Password)
+	assert.Equal(t, "oidc-secret", *resolved.OIDCConfig.Providers["provider"].ValidationKey)
+
+	// The original config keeps the references
+	assert.Equal(t, passwordRef, dbConfig.Password)
+	assert.Equal(t, clientSecretRef, *dbConfig.OIDCConfig.Providers["provider"].ValidationKey)
+
+	// Configs without references are returned as-is
+	plainConfig := &DbConfig{Name: "db", BucketConfig: BucketConfig{Password: "password"}}
+	resolved, err = plainConfig.withResolvedSecrets()
+	assert.NoError(t, err)
+	assert.True(t, resolved == plainConfig)
+
+	dbConfig.Password = "file://" + filepath.ToSlash(filepath.Join(dir, "missing"))
+	_, err = dbConfig.withResolvedSecrets()
+	assert.Error(t, err)
+}
+
+func TestChannelIndexLocalStoreConfig(t *testing.T) {
+	dbConfig := DbConfig{
+		FeedType:     base.DcpShardFeedType,
+		ChannelIndex: &ChannelIndexConfig{LocalStoreDir: "/var/lib/sync_gateway/index"},
+	}
+
+	// Only a node that writes the index itself can keep it in a local store
+	assert.Error(t, dbConfig.validate())
+	dbConfig.ChannelIndex.Distributed = true
+	assert.NoError(t, dbConfig.validate())
+}
+
+func TestPreprocessConfigDataBackquotes(t *testing.T) {
+	os.Setenv("SG_TEST_CHANNEL", `a"b\c`)
+	defer os.Unsetenv("SG_TEST_CHANNEL")
+
+	// Expanded values are only escaped once, whether or not they're in a backquoted string
+	data, err := preprocessConfigData([]byte("{\"sync\": `channel('${SG_TEST_CHANNEL}')`, \"name\": \"${SG_TEST_CHANNEL}\"}"), configFormatJSON)
+	assert.NoError(t, err)
+	var config map[string]string
+	assert.NoError(t, json.Unmarshal(data, &config))
+	assert.Equal(t, `channel('a"b\c')`, config["sync"])
+	assert.Equal(t, `a"b\c`, config["name"])
+}
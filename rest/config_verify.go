@@ -0,0 +1,361 @@
+package rest
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbase/sync_gateway/channels"
+	"github.com/couchbase/sync_gateway/db"
+)
+
+// VerifyConfigFiles reads, merges and fully validates the given config files, writing every error found to w.
+// No buckets are opened and no listeners are started.  Returns the process exit code: 0 if the config is valid,
+// and 1 otherwise.
+func VerifyConfigFiles(runMode SyncGatewayRunMode, paths []string, w io.Writer) int {
+	if len(paths) == 0 {
+		fmt.Fprintln(w, "No config file specified")
+		return 1
+	}
+
+	var merged *ServerConfig
+	var errs []error
+	for _, path := range paths {
+		data, err := readConfigData(path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Error reading config file %s: %v", path, err))
+			continue
+		}
+		config, err := parseServerConfig(runMode, data, configFormatForPath(path))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Error parsing config file %s: %v", path, err))
+			continue
+		}
+		if merged == nil {
+			merged = config
+		} else if err := merged.MergeWith(config); err != nil {
+			errs = append(errs, fmt.Errorf("Error merging config file %s: %v", path, err))
+		}
+	}
+
+	if merged != nil {
+		errs = append(errs, merged.Verify()...)
+	}
+
+	if len(errs) > 0 {
+		for _, err := range errs {
+			fmt.Fprintln(w, err)
+		}
+		fmt.Fprintf(w, "Config is invalid: %d error(s) found\n", len(errs))
+		return 1
+	}
+
+	fmt.Fprintln(w, "Config is valid")
+	return 0
+}
+
+// Verify validates the server config and every database config within it, including compiling sync and
+// import filter functions, checking OIDC provider settings and parsing bucket server URLs.  Unlike the
+// validation done when the config is read, all errors are returned rather than only the first.
+func (config *ServerConfig) Verify() (errs []error) {
+
+	interfaces := []struct {
+		name string
+		addr *string
+	}{
+		{"interface", config.Interface},
+		{"adminInterface", config.AdminInterface},
+		{"profileInterface", config.ProfileInterface},
+	}
+	for _, iface := range interfaces {
+		if iface.addr == nil {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(*iface.addr); err != nil {
+			errs = append(errs, fmt.Errorf("Invalid %s %q: %v", iface.name, *iface.addr, err))
+		}
+	}
+
+	if (config.SSLCert == nil) != (config.SSLKey == nil) {
+		errs = append(errs, fmt.Errorf("SSLCert and SSLKey must both be specified to enable TLS"))
+	}
+	for _, path := range []*string{config.SSLCert, config.SSLKey} {
+		if path == nil || base.IsSecretRef(*path) {
+			continue
+		}
+		if _, err := os.Stat(*path); err != nil {
+			errs = append(errs, fmt.Errorf("Unable to read TLS file: %v", err))
+		}
+	}
+
+	dbNames := make([]string, 0, len(config.Databases))
+	for name := range config.Databases {
+		dbNames = append(dbNames, name)
+	}
+	sort.Strings(dbNames)
+
+	for _, name := range dbNames {
+		for _, err := range config.verifyDbConfig(name, config.Databases[name]) {
+			errs = append(errs, fmt.Errorf("Database %q: %v", name, err))
+		}
+	}
+
+	if config.DatabasesDir != nil && *config.DatabasesDir != "" {
+		errs = append(errs, config.verifyDatabasesDir(*config.DatabasesDir)...)
+	}
+	if config.ConfigBucket != nil && (config.ConfigBucket.Bucket == nil || *config.ConfigBucket.Bucket == "") {
+		errs = append(errs, fmt.Errorf("config_bucket must specify a bucket"))
+	}
+	if err := validateReadOnlyConfig(config.ReadOnly); err != nil {
+		errs = append(errs, err)
+	}
+	aliases := make([]string, 0, len(config.DatabaseAliases))
+	for alias := range config.DatabaseAliases {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+	for _, alias := range aliases {
+		target := config.DatabaseAliases[alias]
+		if config.Databases[alias] != nil {
+			errs = append(errs, fmt.Errorf("Alias %q is the name of a database", alias))
+		} else if config.Databases[target] == nil && config.DatabasesDir == nil && config.ConfigBucket == nil {
+			errs = append(errs, fmt.Errorf("Alias %q points to a missing database %q", alias, target))
+		}
+	}
+
+	return errs
+}
+
+// Returns every error found in the database config files in the given databases_dir.
+func (config *ServerConfig) verifyDatabasesDir(dir string) (errs []error) {
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return append(errs, fmt.Errorf("Unable to read databases_dir: %v", err))
+	}
+
+	dbFiles := map[string]string{}
+	for _, entry := range entries {
+		if entry.IsDir() || !isDbConfigFile(entry.Name()) {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		dbConfig, err := parseDbConfigFile(path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Database config file %s: %v", path, err))
+			continue
+		}
+
+		name := dbConfig.Name
+		if _, ok := config.Databases[name]; ok {
+			errs = append(errs, fmt.Errorf("Database config file %s: database %q is already defined in the server config", path, name))
+			continue
+		}
+		if other, ok := dbFiles[name]; ok {
+			errs = append(errs, fmt.Errorf("Database config file %s: database %q is already defined in %s", path, name, other))
+			continue
+		}
+		dbFiles[name] = path
+
+		for _, err := range config.verifyDbConfig(name, dbConfig) {
+			errs = append(errs, fmt.Errorf("Database config file %s: %v", path, err))
+		}
+	}
+	return errs
+}
+
+// Returns every error found in the given database config.
+func (config *ServerConfig) verifyDbConfig(name string, dbConfig *DbConfig) (errs []error) {
+
+	if err := dbConfig.setup(name); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := config.validateDbConfig(dbConfig); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := db.ValidateDatabaseName(name); err != nil {
+		errs = append(errs, err)
+	}
+
+	if spec, err := GetBucketSpec(dbConfig); err != nil {
+		errs = append(errs, err)
+	} else {
+		if !spec.IsWalrusBucket() {
+			if _, err := spec.GetGoCBConnString(); err != nil {
+				errs = append(errs, fmt.Errorf("Invalid server %q: %v", spec.Server, err))
+			}
+		}
+		if metadataSpec, err := GetMetadataBucketSpec(dbConfig, spec); err != nil {
+			errs = append(errs, err)
+		} else if metadataSpec != nil && !metadataSpec.IsWalrusBucket() {
+			if _, err := metadataSpec.GetGoCBConnString(); err != nil {
+				errs = append(errs, fmt.Errorf("Invalid metadata_bucket server %q: %v", metadataSpec.Server, err))
+			}
+		}
+		if sharedSpec, err := GetSharedRevCacheBucketSpec(dbConfig, spec); err != nil {
+			errs = append(errs, err)
+		} else if sharedSpec != nil && !sharedSpec.IsWalrusBucket() {
+			if _, err := sharedSpec.GetGoCBConnString(); err != nil {
+				errs = append(errs, fmt.Errorf("Invalid shared_rev_cache bucket server %q: %v", sharedSpec.Server, err))
+			}
+		}
+		if coldSpec, err := GetColdStorageBucketSpec(dbConfig, spec); err != nil {
+			errs = append(errs, err)
+		} else if coldSpec != nil && !coldSpec.IsWalrusBucket() {
+			if _, err := coldSpec.GetGoCBConnString(); err != nil {
+				errs = append(errs, fmt.Errorf("Invalid cold_storage bucket server %q: %v", coldSpec.Server, err))
+			}
+		}
+	}
+
+	if dbConfig.Sync != nil {
+		if _, err := channels.NewSyncRunner(*dbConfig.Sync); err != nil {
+			errs = append(errs, fmt.Errorf("Error compiling sync function: %v", err))
+		}
+	}
+
+	if dbConfig.Schemas != nil {
+		if _, err := makeDocSchemas(dbConfig.Schemas); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if _, err := makeSignupOptions(dbConfig.Signup); err != nil {
+		errs = append(errs, err)
+	}
+
+	if _, err := makeNamedQueries(dbConfig.Queries); err != nil {
+		errs = append(errs, err)
+	}
+
+	for name, source := range dbConfig.Functions {
+		if err := db.ValidateUserFunction(source); err != nil {
+			errs = append(errs, fmt.Errorf("Error compiling function %q: %v", name, err))
+		}
+	}
+
+	if _, err := makeQueryResultCacheOptions(dbConfig.QueryResultCache); err != nil {
+		errs = append(errs, err)
+	}
+
+	if _, err := makeWebSocketCompressionOptions(dbConfig.WebSocketCompression); err != nil {
+		errs = append(errs, err)
+	}
+
+	if _, err := makeCacheNotifyOptions(dbConfig.CacheNotifications); err != nil {
+		errs = append(errs, err)
+	}
+
+	if _, err := makeTrashOptions(dbConfig.Trash); err != nil {
+		errs = append(errs, err)
+	}
+
+	if _, err := makeBackgroundTaskOptions(dbConfig.BackgroundTasks); err != nil {
+		errs = append(errs, err)
+	}
+
+	if dbConfig.GraphQL != nil {
+		if _, err := makeGraphQLSchema(dbConfig.GraphQL, dbConfig.Queries, dbConfig.Functions); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if dbConfig.ReadTransform != nil {
+		if _, err := makeReadTransform(dbConfig.ReadTransform); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if dbConfig.ImportFilter != nil {
+		if err := db.ValidateImportFilter(*dbConfig.ImportFilter); err != nil {
+			errs = append(errs, fmt.Errorf("Error compiling import filter: %v", err))
+		}
+	}
+
+	if dbConfig.OIDCConfig != nil {
+		errs = append(errs, verifyOIDCConfig(dbConfig)...)
+	}
+
+	return errs
+}
+
+// Returns every error found in a database's OIDC provider settings.  Providers that would be skipped with
+// a warning at startup are reported as errors.
+func verifyOIDCConfig(dbConfig *DbConfig) (errs []error) {
+
+	oidcConfig := dbConfig.OIDCConfig
+	if len(oidcConfig.Providers) == 0 {
+		return append(errs, fmt.Errorf("OpenID Connect defined in config, but no OpenID Connect providers specified"))
+	}
+
+	if oidcConfig.DefaultProvider != nil {
+		if _, ok := oidcConfig.Providers[*oidcConfig.DefaultProvider]; !ok {
+			errs = append(errs, fmt.Errorf("OpenID Connect default_provider %q is not a defined provider", *oidcConfig.DefaultProvider))
+		}
+	}
+
+	providerNames := make([]string, 0, len(oidcConfig.Providers))
+	for name := range oidcConfig.Providers {
+		providerNames = append(providerNames, name)
+	}
+	sort.Strings(providerNames)
+
+	issuers := make(map[string]string, len(providerNames))
+	for _, name := range providerNames {
+		provider := oidcConfig.Providers[name]
+		if strings.Contains(name, "_") {
+			errs = append(errs, fmt.Errorf("OpenID Connect provider names cannot contain underscore: %s", name))
+		}
+		if provider.ClientID == nil {
+			errs = append(errs, fmt.Errorf("OpenID Connect provider %q: client_id is required", name))
+		}
+		if provider.Issuer == "" {
+			errs = append(errs, fmt.Errorf("OpenID Connect provider %q: issuer is required", name))
+			continue
+		}
+		if err := verifyHTTPURL(provider.Issuer); err != nil {
+			errs = append(errs, fmt.Errorf("OpenID Connect provider %q: invalid issuer: %v", name, err))
+		}
+		if other, ok := issuers[provider.Issuer]; ok {
+			errs = append(errs, fmt.Errorf("OpenID Connect providers %q and %q have the same issuer", other, name))
+		}
+		issuers[provider.Issuer] = name
+
+		if provider.DiscoveryURI != "" {
+			if err := verifyHTTPURL(provider.DiscoveryURI); err != nil {
+				errs = append(errs, fmt.Errorf("OpenID Connect provider %q: invalid discovery_url: %v", name, err))
+			}
+		}
+		if provider.CallbackURL != nil {
+			if err := verifyHTTPURL(*provider.CallbackURL); err != nil {
+				errs = append(errs, fmt.Errorf("OpenID Connect provider %q: invalid callback_url: %v", name, err))
+			}
+		}
+	}
+
+	return errs
+}
+
+// Returns an error if the given string isn't an absolute http or https URL.
+func verifyHTTPURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%q must be an http or https URL", rawURL)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("%q has no host", rawURL)
+	}
+	return nil
+}
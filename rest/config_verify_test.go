@@ -0,0 +1,80 @@
+package rest
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTestConfig(t *testing.T, dir, name, contents string) string {
+	path := filepath.Join(dir, name)
+	assert.NoError(t, ioutil.WriteFile(path, []byte(contents), 0600))
+	return path
+}
+
+func TestVerifyConfigFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "verify_config")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	validPath := writeTestConfig(t, dir, "valid.json", `{
+		"interface": ":4984",
+		"databases": {
+			"db": {
+				"server": "walrus:",
+				"sync": `+"`function(doc) { channel(doc.channels); }`"+`,
+				"import_filter": "function(doc) { return true; }"
+			}
+		}
+	}`)
+
+	var output bytes.Buffer
+	assert.Equal(t, 0, VerifyConfigFiles(SyncGatewayRunModeNormal, []string{validPath}, &output))
+	assert.Contains(t, output.String(), "Config is valid")
+
+	invalidPath := writeTestConfig(t, dir, "invalid.json", `{
+		"adminInterface": "localhost",
+		"databases": {
+			"db1": {
+				"server": "walrus:",
+				"sync": "function(doc) { channel(doc.channels); ",
+				"import_filter": "function(doc) { return }}"
+			},
+			"db2": {
+				"server": "walrus:",
+				"oidc": {
+					"default_provider": "missing",
+					"providers": {
+						"bad_name": {"issuer": "ftp://example.com", "client_id": "sync_gateway"},
+						"noclient": {"issuer": "http://example.com"}
+					}
+				}
+			}
+		}
+	}`)
+
+	// Every error should be reported, not just the first
+	output.Reset()
+	assert.Equal(t, 1, VerifyConfigFiles(SyncGatewayRunModeNormal, []string{invalidPath}, &output))
+	assert.Contains(t, output.String(), `Invalid adminInterface "localhost"`)
+	assert.Contains(t, output.String(), `Database "db1": Error compiling sync function`)
+	assert.Contains(t, output.String(), `Database "db1": Error compiling import filter`)
+	assert.Contains(t, output.String(), `default_provider "missing" is not a defined provider`)
+	assert.Contains(t, output.String(), "provider names cannot contain underscore: bad_name")
+	assert.Contains(t, output.String(), `provider "bad_name": invalid issuer`)
+	assert.Contains(t, output.String(), `provider "noclient": client_id is required`)
+	assert.Contains(t, output.String(), "Config is invalid: 7 error(s) found")
+
+	// Duplicate databases across merged files are reported
+	output.Reset()
+	assert.Equal(t, 1, VerifyConfigFiles(SyncGatewayRunModeNormal, []string{validPath, validPath}, &output))
+	assert.Contains(t, output.String(), "Error merging config file")
+
+	output.Reset()
+	assert.Equal(t, 1, VerifyConfigFiles(SyncGatewayRunModeNormal, []string{filepath.Join(dir, "missing.json")}, &output))
+	assert.Contains(t, output.String(), "Error reading config file")
+}
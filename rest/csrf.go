@@ -0,0 +1,103 @@
+package rest
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbase/sync_gateway/db"
+)
+
+// The header holding the CSRF token of cookie-authenticated requests, in token mode
+const csrfTokenHeader = "X-CSRF-Token"
+
+// Returns the CSRF token for a login session.  It's derived from the session ID so that it needn't be stored, but
+// the ID can't be recovered from it.  A cross-site attacker can't read the session cookie, so can't compute it.
+func csrfTokenForSession(sessionID string) string {
+	sum := sha256.Sum256([]byte("csrf:" + sessionID))
+	return hex.EncodeToString(sum[:])
+}
+
+// Returns the CSRF token for the request's login session, if the database uses tokens.
+func (h *handler) csrfToken() string {
+	if h.db == nil || h.db.Options.CSRFOptions.Mode != db.CSRFModeToken || h.sessionID == "" {
+		return ""
+	}
+	return csrfTokenForSession(h.sessionID)
+}
+
+// Returns a 403 error if the request is a state-changing one authenticated by session cookie that fails the
+// database's CSRF check.
+func (h *handler) checkCSRF(context *db.DatabaseContext) error {
+	options := context.Options.CSRFOptions
+	if options.Mode == "" || h.sessionID == "" || h.privs != regularPrivs {
+		return nil
+	}
+	switch h.rq.Method {
+	case "GET", "HEAD", "OPTIONS":
+		return nil
+	}
+
+	switch options.Mode {
+	case db.CSRFModeOrigin:
+		// Browsers send Origin with cross-site requests that change state; other clients may send neither header
+		if origin := requestOrigin(h.rq); origin != "" && !isTrustedOrigin(h.rq, origin, options.TrustedOrigins) {
+			base.InfofCtx(h.logCtx, base.KeyHTTP, "Rejected cookie-authenticated %s from origin %s", h.rq.Method, base.UD(origin))
+			return base.HTTPErrorf(http.StatusForbidden, "Cross-origin request rejected")
+		}
+	case db.CSRFModeToken:
+		token := h.rq.Header.Get(csrfTokenHeader)
+		if subtle.ConstantTimeCompare([]byte(token), []byte(csrfTokenForSession(h.sessionID))) != 1 {
+			base.InfofCtx(h.logCtx, base.KeyHTTP, "Rejected cookie-authenticated %s with missing or invalid %s", h.rq.Method, csrfTokenHeader)
+			return base.HTTPErrorf(http.StatusForbidden, "Missing or invalid %s header", csrfTokenHeader)
+		}
+	}
+	return nil
+}
+
+// Returns a 403 error if the request is a WebSocket upgrade authenticated by session cookie, from an origin that the
+// database's CSRF check doesn't trust.  Browsers let any site open a WebSocket with the user's cookies, and don't let
+// it set headers, so the upgrade's GET is checked by its Origin in token mode too.  Called from the handshake, as
+// checkCSRF lets GETs through.
+func (h *handler) checkWebSocketOrigin(rq *http.Request) error {
+	options := h.db.Options.CSRFOptions
+	if options.Mode == "" || h.sessionID == "" || h.privs != regularPrivs {
+		return nil
+	}
+	if origin := requestOrigin(rq); origin != "" && !isTrustedOrigin(rq, origin, options.TrustedOrigins) {
+		base.InfofCtx(h.logCtx, base.KeyHTTP, "Rejected cookie-authenticated WebSocket connection from origin %s", base.UD(origin))
+		return base.HTTPErrorf(http.StatusForbidden, "Cross-origin WebSocket connection rejected")
+	}
+	return nil
+}
+
+// Returns the origin ("scheme://host[:port]") the request says it was made from, or "" if it doesn't say.
+func requestOrigin(rq *http.Request) string {
+	if origin := rq.Header.Get("Origin"); origin != "" {
+		return origin
+	}
+	if referer := rq.Header.Get("Referer"); referer != "" {
+		if u, err := url.Parse(referer); err == nil && u.Host != "" {
+			return u.Scheme + "://" + u.Host
+		}
+		return referer
+	}
+	return ""
+}
+
+// Returns true if the origin is the request's own, or a trusted one.
+func isTrustedOrigin(rq *http.Request, origin string, trustedOrigins []string) bool {
+	if u, err := url.Parse(origin); err == nil && u.Host != "" && strings.EqualFold(u.Host, rq.Host) {
+		return true
+	}
+	for _, trusted := range trustedOrigins {
+		if strings.EqualFold(trusted, origin) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbase/sync_gateway/db"
+	"github.com/stretchr/testify/assert"
+)
+
+// Creates user1 and logs in, returning the session's Cookie header and the login response.
+func loginWithCookie(t *testing.T, rt *RestTester) (map[string]string, *TestResponse) {
+	assertStatus(t, rt.SendAdminRequest("POST", "/db/_user/", `{"name":"user1", "password":"letmein", "admin_channels":["*"]}`), http.StatusCreated)
+	response := rt.SendRequest("POST", "/db/_session", `{"name":"user1", "password":"letmein"}`)
+	assertStatus(t, response, http.StatusOK)
+	cookie := response.Result().Cookies()[0]
+	return map[string]string{"Cookie": fmt.Sprintf("%s=%s", cookie.Name, cookie.Value)}, response
+}
+
+func TestCSRFOriginMode(t *testing.T) {
+	rt := RestTester{
+		noAdminParty: true,
+		DatabaseConfig: &DbConfig{
+			Name: "db",
+			CSRF: &CSRFConfig{Mode: "origin", TrustedOrigins: []string{"https://app.example.com"}},
+		},
+	}
+	defer rt.Close()
+	headers, _ := loginWithCookie(t, &rt)
+
+	// Requests without Origin or Referer, from the database's own origin, or from trusted origins are allowed
+	assertStatus(t, rt.SendRequestWithHeaders("PUT", "/db/doc1", `{}`, headers), http.StatusCreated)
+	headers["Origin"] = "http://localhost"
+	assertStatus(t, rt.SendRequestWithHeaders("PUT", "/db/doc2", `{}`, headers), http.StatusCreated)
+	headers["Origin"] = "https://app.example.com"
+	assertStatus(t, rt.SendRequestWithHeaders("PUT", "/db/doc3", `{}`, headers), http.StatusCreated)
+
+	// Other origins can only read
+	headers["Origin"] = "https://evil.example.com"
+	assertStatus(t, rt.SendRequestWithHeaders("PUT", "/db/doc4", `{}`, headers), http.StatusForbidden)
+	assertStatus(t, rt.SendRequestWithHeaders("GET", "/db/doc1", "", headers), http.StatusOK)
+	delete(headers, "Origin")
+	headers["Referer"] = "https://evil.example.com/page.html"
+	assertStatus(t, rt.SendRequestWithHeaders("PUT", "/db/doc4", `{}`, headers), http.StatusForbidden)
+
+	// Basic auth isn't affected
+	request := requestByUser("PUT", "/db/doc4", `{}`, "user1")
+	request.Header.Set("Origin", "https://evil.example.com")
+	assertStatus(t, rt.Send(request), http.StatusCreated)
+}
+
+func TestCSRFTokenMode(t *testing.T) {
+	rt := RestTester{
+		noAdminParty: true,
+		DatabaseConfig: &DbConfig{
+			Name: "db",
+			CSRF: &CSRFConfig{Mode: "token"},
+		},
+	}
+	defer rt.Close()
+	headers, response := loginWithCookie(t, &rt)
+
+	var session struct {
+		CSRFToken string `json:"csrf_token"`
+	}
+	assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &session))
+	assert.NotEqual(t, "", session.CSRFToken)
+
+	// GET _session returns the same token
+	response = rt.SendRequestWithHeaders("GET", "/db/_session", "", headers)
+	assertStatus(t, response, http.StatusOK)
+	var sessionInfo struct {
+		CSRFToken string `json:"csrf_token"`
+	}
+	assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &sessionInfo))
+	assert.Equal(t, session.CSRFToken, sessionInfo.CSRFToken)
+
+	assertStatus(t, rt.SendRequestWithHeaders("PUT", "/db/doc1", `{}`, headers), http.StatusForbidden)
+	headers[csrfTokenHeader] = "wrong"
+	assertStatus(t, rt.SendRequestWithHeaders("PUT", "/db/doc1", `{}`, headers), http.StatusForbidden)
+	headers[csrfTokenHeader] = session.CSRFToken
+	assertStatus(t, rt.SendRequestWithHeaders("PUT", "/db/doc1", `{}`, headers), http.StatusCreated)
+	delete(headers, csrfTokenHeader)
+	assertStatus(t, rt.SendRequestWithHeaders("GET", "/db/doc1", "", headers), http.StatusOK)
+}
+
+func TestCSRFWebSocketOrigin(t *testing.T) {
+	rt := RestTester{
+		noAdminParty: true,
+		DatabaseConfig: &DbConfig{
+			Name: "db",
+			CSRF: &CSRFConfig{Mode: "token", TrustedOrigins: []string{"https://app.example.com"}},
+		},
+	}
+	defer rt.Close()
+
+	checkOrigin := func(origin string, sessionID string) error {
+		rq := request("GET", "/db/_blipsync", "")
+		if origin != "" {
+			rq.Header.Set("Origin", origin)
+		}
+		h := newHandler(rt.ServerContext(), regularPrivs, httptest.NewRecorder(), rq, false)
+		h.db = &db.Database{DatabaseContext: rt.GetDatabase()}
+		h.sessionID = sessionID
+		return h.checkWebSocketOrigin(rq)
+	}
+
+	// Cookie-authenticated connections have to come from the database's own origin or a trusted one
+	assert.NoError(t, checkOrigin("", "session"))
+	assert.NoError(t, checkOrigin("http://localhost", "session"))
+	assert.NoError(t, checkOrigin("https://app.example.com", "session"))
+	status, _ := base.ErrorAsHTTPStatus(checkOrigin("https://evil.example.com", "session"))
+	assert.Equal(t, http.StatusForbidden, status)
+
+	// Connections authenticated otherwise aren't affected
+	assert.NoError(t, checkOrigin("https://evil.example.com", ""))
+}
@@ -0,0 +1,138 @@
+package rest
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbase/sync_gateway/db"
+)
+
+// Database aliases let clients use a stable database name while the database behind it is replaced: requests to
+// /{alias}/... are handled by the database the alias points to, which can be changed with the admin API.  To roll out
+// a new sync function or bucket, add a database with the new config, check it, and then point the alias at it; new
+// requests go to the new database at once, while requests already in progress finish on the old one.  Aliases are
+// per node, so in a cluster each node's alias has to be repointed.
+
+// Checks the aliases in the server config, after its databases have been added.
+func (sc *ServerContext) validateDatabaseAliases() error {
+	sc.lock.RLock()
+	defer sc.lock.RUnlock()
+	for alias, target := range sc.config.DatabaseAliases {
+		if err := sc._validateDatabaseAlias(alias, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Requires the lock to be held.
+func (sc *ServerContext) _validateDatabaseAlias(alias string, target string) error {
+	if err := db.ValidateDatabaseName(alias); err != nil {
+		return err
+	}
+	if sc.databases_[alias] != nil {
+		return base.HTTPErrorf(http.StatusPreconditionFailed, "Alias %q is the name of a database", alias)
+	}
+	if sc.databases_[target] == nil {
+		return base.HTTPErrorf(http.StatusNotFound, "Alias %q points to a missing database %q", alias, target)
+	}
+	return nil
+}
+
+// Points an alias at a database, which has to exist, creating the alias if it's new.  Returns the database the alias
+// pointed at before, or "" if it's new.
+func (sc *ServerContext) SetDatabaseAlias(alias string, target string) (previous string, err error) {
+	sc.lock.Lock()
+	defer sc.lock.Unlock()
+	if err := sc._validateDatabaseAlias(alias, target); err != nil {
+		return "", err
+	}
+	if sc.config.DatabaseAliases == nil {
+		sc.config.DatabaseAliases = map[string]string{}
+	}
+	previous = sc.config.DatabaseAliases[alias]
+	sc.config.DatabaseAliases[alias] = target
+	base.Infof(base.KeyAll, "Database alias /%s now points to db /%s", base.MD(alias), base.MD(target))
+	return previous, nil
+}
+
+// Removes an alias, returning the database it pointed at, or "" if there's no such alias.
+func (sc *ServerContext) RemoveDatabaseAlias(alias string) string {
+	sc.lock.Lock()
+	defer sc.lock.Unlock()
+	target := sc.config.DatabaseAliases[alias]
+	delete(sc.config.DatabaseAliases, alias)
+	return target
+}
+
+// Returns a copy of the database aliases, mapped to the databases they point at.
+func (sc *ServerContext) DatabaseAliases() map[string]string {
+	sc.lock.RLock()
+	defer sc.lock.RUnlock()
+	aliases := make(map[string]string, len(sc.config.DatabaseAliases))
+	for alias, target := range sc.config.DatabaseAliases {
+		aliases[alias] = target
+	}
+	return aliases
+}
+
+// GET /_alias/ returns the names of the database aliases
+func (h *handler) handleGetDatabaseAliases() error {
+	aliases := h.server.DatabaseAliases()
+	names := make([]string, 0, len(aliases))
+	for alias := range aliases {
+		names = append(names, alias)
+	}
+	sort.Strings(names)
+	h.writeJSON(names)
+	return nil
+}
+
+// GET /_alias/{alias} returns the database an alias points at
+func (h *handler) handleGetDatabaseAlias() error {
+	alias := h.PathVar("alias")
+	target, ok := h.server.DatabaseAliases()[alias]
+	if !ok {
+		return base.HTTPErrorf(http.StatusNotFound, "No such alias %q", alias)
+	}
+	h.writeJSON(db.Body{"alias": alias, "db": target})
+	return nil
+}
+
+// PUT /_alias/{alias} points an alias at the database given in the body's "db" property
+func (h *handler) handlePutDatabaseAlias() error {
+	var params struct {
+		DB string `json:"db"`
+	}
+	if err := h.readJSONInto(&params); err != nil {
+		return err
+	}
+	if params.DB == "" {
+		return base.HTTPErrorf(http.StatusBadRequest, "Missing db property")
+	}
+	alias := h.PathVar("alias")
+	previous, err := h.server.SetDatabaseAlias(alias, params.DB)
+	if err != nil {
+		return err
+	}
+	var before interface{}
+	if previous != "" {
+		before = db.Body{"db": previous}
+	}
+	h.setAuditSummary(before, db.Body{"db": params.DB})
+	h.writeJSON(db.Body{"alias": alias, "db": params.DB})
+	return nil
+}
+
+// DELETE /_alias/{alias} removes an alias.  The database it pointed at isn't affected.
+func (h *handler) handleDeleteDatabaseAlias() error {
+	alias := h.PathVar("alias")
+	target := h.server.RemoveDatabaseAlias(alias)
+	if target == "" {
+		return base.HTTPErrorf(http.StatusNotFound, "No such alias %q", alias)
+	}
+	h.setAuditSummary(db.Body{"db": target}, nil)
+	h.response.Write([]byte("{}"))
+	return nil
+}
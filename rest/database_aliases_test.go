@@ -0,0 +1,61 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDatabaseAliases(t *testing.T) {
+	var rt RestTester
+	defer rt.Close()
+	sc := rt.ServerContext()
+
+	greenBucket := "alias_test_green"
+	_, err := sc.AddDatabaseFromConfig(&DbConfig{Name: "green", BucketConfig: BucketConfig{Server: &DefaultServer, Bucket: &greenBucket}})
+	assert.NoError(t, err)
+	assertStatus(t, rt.SendAdminRequest("PUT", "/db/doc1", `{"color":"blue"}`), http.StatusCreated)
+	assertStatus(t, rt.SendAdminRequest("PUT", "/green/doc1", `{"color":"green"}`), http.StatusCreated)
+
+	// Aliases have to point at an existing database, and can't hide one
+	assertStatus(t, rt.SendAdminRequest("PUT", "/_alias/app", `{"db":"missing"}`), http.StatusNotFound)
+	assertStatus(t, rt.SendAdminRequest("PUT", "/_alias/green", `{"db":"db"}`), http.StatusPreconditionFailed)
+	assertStatus(t, rt.SendAdminRequest("PUT", "/_alias/app", `{}`), http.StatusBadRequest)
+
+	color := func() string {
+		response := rt.SendRequest("GET", "/app/doc1", "")
+		assertStatus(t, response, http.StatusOK)
+		var body struct {
+			Color string `json:"color"`
+		}
+		assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &body))
+		return body.Color
+	}
+	assertStatus(t, rt.SendAdminRequest("PUT", "/_alias/app", `{"db":"db"}`), http.StatusOK)
+	assert.Equal(t, "blue", color())
+	assertStatus(t, rt.SendAdminRequest("PUT", "/_alias/app", `{"db":"green"}`), http.StatusOK)
+	assert.Equal(t, "green", color())
+
+	response := rt.SendAdminRequest("GET", "/_alias/", "")
+	assertStatus(t, response, http.StatusOK)
+	var names []string
+	assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &names))
+	assert.Equal(t, []string{"app"}, names)
+	response = rt.SendAdminRequest("GET", "/_alias/app", "")
+	assertStatus(t, response, http.StatusOK)
+	var alias map[string]string
+	assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &alias))
+	assert.Equal(t, map[string]string{"alias": "app", "db": "green"}, alias)
+
+	// Databases can't be created with an alias's name, or deleted through one
+	assertStatus(t, rt.SendAdminRequest("PUT", "/app/", `{"server":"walrus:"}`), http.StatusPreconditionFailed)
+	assertStatus(t, rt.SendAdminRequest("DELETE", "/app/", ""), http.StatusBadRequest)
+
+	// Once the alias is removed, it's gone, but the database remains
+	assertStatus(t, rt.SendAdminRequest("DELETE", "/_alias/app", ""), http.StatusOK)
+	assertStatus(t, rt.SendAdminRequest("DELETE", "/_alias/app", ""), http.StatusNotFound)
+	assertStatus(t, rt.SendRequest("GET", "/app/doc1", ""), http.StatusNotFound)
+	assertStatus(t, rt.SendRequest("GET", "/green/doc1", ""), http.StatusOK)
+}
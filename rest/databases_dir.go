@@ -0,0 +1,224 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/couchbase/sync_gateway/base"
+)
+
+// How often the databases_dir is checked for added, modified and removed config files.
+const kDatabasesDirPollInterval = 5 * time.Second
+
+// The state of a database config file, as of the last time the databases_dir was scanned.
+type dbConfigFileState struct {
+	modTime time.Time
+	size    int64
+	dbName  string // Name of the database loaded from the file, or empty if the file couldn't be loaded
+}
+
+// Keeps the databases in a ServerContext in sync with the config files in a directory.  Each file holds a single
+// database config, in JSON or YAML.  The database name is taken from the config's "name" property, or from the
+// file name if not set.
+type databasesDirWatcher struct {
+	sc       *ServerContext
+	dir      string
+	files    map[string]dbConfigFileState // Keyed by file path
+	lock     sync.Mutex                   // Serializes scans
+	stopped  bool                         // Set once stopped, to prevent any further scans
+	ticker   *time.Ticker
+	stopChan chan struct{}
+}
+
+// Returns whether the given file in a databases_dir should be loaded as a database config.  Hidden files
+// (eg. editor swap files) are ignored.
+func isDbConfigFile(name string) bool {
+	if strings.HasPrefix(name, ".") {
+		return false
+	}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json", ".yaml", ".yml":
+		return true
+	}
+	return false
+}
+
+// Parses a single database config from the given file, without any validation.  The config's Name is set from
+// the file name if not specified in the config.
+func parseDbConfigFile(path string) (*DbConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = preprocessConfigData(data, configFormatForPath(path)); err != nil {
+		return nil, err
+	}
+
+	var dbConfig *DbConfig
+	if err := json.Unmarshal(data, &dbConfig); err != nil {
+		return nil, err
+	}
+	if dbConfig == nil {
+		return nil, fmt.Errorf("file does not contain a database config")
+	}
+
+	if dbConfig.Name == "" {
+		dbConfig.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	return dbConfig, nil
+}
+
+// Reads and validates a single database config from the given file.
+func (sc *ServerContext) readDbConfigFile(path string) (*DbConfig, error) {
+	dbConfig, err := parseDbConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := dbConfig.setup(dbConfig.Name); err != nil {
+		return nil, err
+	}
+	if err := sc.config.validateDbConfig(dbConfig); err != nil {
+		return nil, err
+	}
+	return dbConfig, nil
+}
+
+// Starts loading databases from the configured databases_dir, if any.  The directory is scanned once before
+// returning, and then polled for changes until the ServerContext is closed.
+func (sc *ServerContext) startDatabasesDirWatcher() error {
+	if sc.config.DatabasesDir == nil || *sc.config.DatabasesDir == "" {
+		return nil
+	}
+
+	dir := *sc.config.DatabasesDir
+	if info, err := os.Stat(dir); err != nil {
+		return err
+	} else if !info.IsDir() {
+		return fmt.Errorf("databases_dir %s is not a directory", dir)
+	}
+
+	watcher := &databasesDirWatcher{
+		sc:       sc,
+		dir:      dir,
+		files:    map[string]dbConfigFileState{},
+		stopChan: make(chan struct{}),
+	}
+	base.Infof(base.KeyAll, "Loading database configs from %s", base.UD(dir))
+	watcher.scan()
+
+	watcher.ticker = time.NewTicker(kDatabasesDirPollInterval)
+	go func() {
+		for {
+			select {
+			case <-watcher.ticker.C:
+				watcher.scan()
+			case <-watcher.stopChan:
+				return
+			}
+		}
+	}()
+
+	sc.databasesDirWatcher = watcher
+	return nil
+}
+
+// Stops polling the databases_dir, waiting for any in-progress scan to complete.  Must be called without holding
+// sc.lock, as scans acquire it when adding and removing databases.
+func (sc *ServerContext) stopDatabasesDirWatcher() {
+	watcher := sc.databasesDirWatcher
+	if watcher == nil {
+		return
+	}
+	watcher.ticker.Stop()
+	close(watcher.stopChan)
+
+	watcher.lock.Lock()
+	watcher.stopped = true
+	watcher.lock.Unlock()
+	sc.databasesDirWatcher = nil
+}
+
+// Compares the files in the directory against the last scan, adding, reloading and removing databases as needed.
+func (w *databasesDirWatcher) scan() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.stopped {
+		return
+	}
+
+	entries, err := ioutil.ReadDir(w.dir)
+	if err != nil {
+		base.Warnf(base.KeyAll, "Unable to read databases_dir %s: %v", base.UD(w.dir), err)
+		return
+	}
+
+	present := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || !isDbConfigFile(entry.Name()) {
+			continue
+		}
+		path := filepath.Join(w.dir, entry.Name())
+		present[path] = true
+
+		previous, known := w.files[path]
+		if known && previous.modTime.Equal(entry.ModTime()) && previous.size == entry.Size() {
+			continue
+		}
+
+		state := dbConfigFileState{modTime: entry.ModTime(), size: entry.Size(), dbName: previous.dbName}
+		if dbName, err := w.loadFile(path, previous.dbName); err != nil {
+			base.Errorf(base.KeyAll, "Error loading database config file %s: %v", base.UD(path), err)
+		} else {
+			state.dbName = dbName
+		}
+		w.files[path] = state
+	}
+
+	for path, state := range w.files {
+		if present[path] {
+			continue
+		}
+		if state.dbName != "" {
+			base.Infof(base.KeyAll, "Database config file %s removed, removing db /%s", base.UD(path), base.MD(state.dbName))
+			w.sc.removeDatabaseAndConfig(state.dbName)
+		}
+		delete(w.files, path)
+	}
+}
+
+// Adds or reloads the database defined in the given file, returning its name.  currentDbName is the name of the
+// database previously loaded from this file, if any.  If the new config can't be read or is invalid, the current
+// database is left running.
+func (w *databasesDirWatcher) loadFile(path string, currentDbName string) (string, error) {
+	dbConfig, err := w.sc.readDbConfigFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	// Don't let a file replace a database defined in the server config, or in another file
+	if dbConfig.Name != currentDbName {
+		if w.sc.GetDatabaseConfig(dbConfig.Name) != nil {
+			return "", fmt.Errorf("database %q is already defined", dbConfig.Name)
+		}
+	}
+
+	if currentDbName == "" {
+		base.Infof(base.KeyAll, "Adding db /%s from config file %s", base.MD(dbConfig.Name), base.UD(path))
+	} else {
+		base.Infof(base.KeyAll, "Reloading db /%s from modified config file %s", base.MD(dbConfig.Name), base.UD(path))
+		if dbConfig.Name != currentDbName {
+			w.sc.removeDatabaseAndConfig(currentDbName)
+		}
+	}
+
+	if _, err := w.sc.replaceDatabaseFromConfig(dbConfig); err != nil {
+		return "", err
+	}
+	return dbConfig.Name, nil
+}
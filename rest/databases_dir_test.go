@@ -0,0 +1,72 @@
+package rest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func sortedDatabaseNames(sc *ServerContext) []string {
+	names := sc.AllDatabaseNames()
+	sort.Strings(names)
+	return names
+}
+
+func TestDatabasesDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "databases_dir")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "db1.json"), []byte(`{"server": "walrus:", "revs_limit": 100}`), 0600))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "team2.yaml"), []byte("name: db2\nserver: \"walrus:\"\n"), 0600))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "invalid.json"), []byte(`{"server": `), 0600))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "notes.txt"), []byte(`not a config`), 0600))
+
+	// A file can't replace a database defined in the server config
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "main.json"), []byte(`{"name": "main", "server": "walrus:"}`), 0600))
+
+	sc := NewServerContext(&ServerConfig{DatabasesDir: &dir, AdminInterface: &DefaultAdminInterface})
+	defer sc.Close()
+	mainBucket := "main"
+	_, err = sc.AddDatabaseFromConfig(&DbConfig{Name: "main", BucketConfig: BucketConfig{Server: &DefaultServer, Bucket: &mainBucket}})
+	assert.NoError(t, err)
+
+	assert.NoError(t, sc.startDatabasesDirWatcher())
+	assert.Equal(t, []string{"db1", "db2", "main"}, sortedDatabaseNames(sc))
+	assert.Equal(t, "main", *sc.GetDatabaseConfig("main").Bucket)
+	assert.Equal(t, uint32(100), *sc.GetDatabaseConfig("db1").RevsLimit)
+
+	// Modified files are reloaded
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "db1.json"), []byte(`{"server": "walrus:", "revs_limit": 500}`), 0600))
+	sc.databasesDirWatcher.scan()
+	assert.Equal(t, uint32(500), *sc.GetDatabaseConfig("db1").RevsLimit)
+	dbc, err := sc.GetDatabase("db1")
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(500), dbc.RevsLimit)
+
+	// An invalid modification leaves the existing database running
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "db1.json"), []byte(`{"server": "walrus:", "revs_limit": "lots"}`), 0600))
+	sc.databasesDirWatcher.scan()
+	assert.Equal(t, uint32(500), *sc.GetDatabaseConfig("db1").RevsLimit)
+
+	// So does one the new database can't be created from
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "db1.json"), []byte(`{"server": "walrus:", "revs_limit": 600,
+		"body_compression": {"enabled": true}, "queries": {"all": {"select": "name"}}}`), 0600))
+	sc.databasesDirWatcher.scan()
+	assert.Equal(t, uint32(500), *sc.GetDatabaseConfig("db1").RevsLimit)
+	reloaded, err := sc.GetDatabase("db1")
+	assert.NoError(t, err)
+	assert.True(t, reloaded == dbc)
+	assert.False(t, reloaded.IsClosed())
+
+	// Removed files remove their database, and new files are added
+	assert.NoError(t, os.Remove(filepath.Join(dir, "team2.yaml")))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "db3.json"), []byte(`{"server": "walrus:"}`), 0600))
+	sc.databasesDirWatcher.scan()
+	assert.Equal(t, []string{"db1", "db3", "main"}, sortedDatabaseNames(sc))
+	assert.Nil(t, sc.GetDatabaseConfig("db2"))
+}
@@ -0,0 +1,306 @@
+package rest
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"time"
+
+	"github.com/couchbase/sync_gateway/base"
+)
+
+// The directory that the files of a diagnostics archive are stored in
+const kDiagnosticsArchiveDir = "sgdiagnostics"
+
+// Options for collecting diagnostics, from the body of a POST to /_diagnostics.
+type diagnosticsOptions struct {
+	RedactLevel *base.RedactionLevel `json:"redact_level,omitempty"` // Defaults to the configured log redaction level
+	RedactSalt  string               `json:"redact_salt,omitempty"`  // Salt for the hashes of redacted user data.  Defaults to a random salt
+	ProfileSecs uint                 `json:"profile_secs,omitempty"` // Duration of the CPU profile.  Zero skips it
+	ExcludeLogs bool                 `json:"exclude_logs,omitempty"` // If true, the log files aren't included
+	ExcludeHeap bool                 `json:"exclude_heap,omitempty"` // If true, the heap profile isn't included
+}
+
+// POST /_diagnostics gathers the redacted config, expvar stats, profiles, platform info and log files into a zip
+// archive, for support.  User data tagged in the log files and the config is replaced by its salted hash unless the
+// redaction level is "none", in the same way as sgcollect_info.
+func (h *handler) handleDiagnostics() error {
+	var options diagnosticsOptions
+	body, err := h.readBody()
+	if err != nil {
+		return err
+	}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &options); err != nil {
+			return base.HTTPErrorf(http.StatusBadRequest, "Unable to parse request body: %v", err)
+		}
+	}
+	if time.Duration(options.ProfileSecs)*time.Second > kMaxCPUProfileDuration {
+		return base.HTTPErrorf(http.StatusBadRequest, "profile_secs must not be greater than %d", int(kMaxCPUProfileDuration.Seconds()))
+	}
+	if options.RedactLevel == nil {
+		level := base.RedactNone
+		if h.server.config.Logging != nil {
+			level = h.server.config.Logging.RedactionLevel
+		}
+		options.RedactLevel = &level
+	}
+	if *options.RedactLevel != base.RedactNone && options.RedactSalt == "" {
+		options.RedactSalt = base.GenerateRandomSecret()
+	}
+
+	base.Infof(base.KeyAdmin, "Collecting diagnostics with redaction level %s", *options.RedactLevel)
+	h.setHeader("Content-Type", "application/zip")
+	h.setHeader("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, diagnosticsFilename()))
+	return h.server.writeDiagnostics(h.response, options)
+}
+
+// Writes a diagnostics archive to w.  Failures to collect individual files are recorded in the archive's
+// errors.txt, so that one missing file doesn't lose the rest.
+func (sc *ServerContext) writeDiagnostics(w io.Writer, options diagnosticsOptions) error {
+	archive := zip.NewWriter(w)
+	redact := *options.RedactLevel != base.RedactNone
+	var collectErrors []string
+
+	add := func(name string, redactable bool, write func(io.Writer) error) {
+		var buf bytes.Buffer
+		if err := write(&buf); err != nil {
+			base.Warnf(base.KeyAll, "Unable to collect %s for diagnostics: %v", name, err)
+			collectErrors = append(collectErrors, fmt.Sprintf("%s: %v", name, err))
+			return
+		}
+		f, err := archive.Create(kDiagnosticsArchiveDir + "/" + name)
+		if err != nil {
+			collectErrors = append(collectErrors, fmt.Sprintf("%s: %v", name, err))
+			return
+		}
+		if redactable && redact {
+			err = base.RedactTaggedUserData(&buf, f, options.RedactSalt)
+		} else {
+			_, err = buf.WriteTo(f)
+		}
+		if err != nil {
+			collectErrors = append(collectErrors, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+
+	add("platform.json", false, writeDiagnosticsPlatform)
+	add("config.json", true, func(w io.Writer) error { return sc.writeDiagnosticsConfig(w, redact) })
+	add("expvars.json", false, writeDiagnosticsExpvars)
+	add("goroutines.txt", false, func(w io.Writer) error { return pprof.Lookup("goroutine").WriteTo(w, 2) })
+	if !options.ExcludeHeap {
+		add("heap.pprof", false, pprof.WriteHeapProfile)
+	}
+	if options.ProfileSecs > 0 {
+		add("cpu.pprof", false, func(w io.Writer) error {
+			if err := pprof.StartCPUProfile(w); err != nil {
+				return err
+			}
+			time.Sleep(time.Duration(options.ProfileSecs) * time.Second)
+			pprof.StopCPUProfile()
+			return nil
+		})
+	}
+	if !options.ExcludeLogs && sc.config.Logging != nil && sc.config.Logging.LogFilePath != "" {
+		logFiles, err := filepath.Glob(filepath.Join(sc.config.Logging.LogFilePath, "sg_*.log"))
+		if err != nil {
+			collectErrors = append(collectErrors, fmt.Sprintf("logs: %v", err))
+		}
+		for _, logFile := range logFiles {
+			logFile := logFile
+			add("logs/"+filepath.Base(logFile), true, func(w io.Writer) error {
+				f, err := os.Open(logFile)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				_, err = io.Copy(w, f)
+				return err
+			})
+		}
+	}
+
+	if len(collectErrors) > 0 {
+		if f, err := archive.Create(kDiagnosticsArchiveDir + "/errors.txt"); err == nil {
+			_, _ = io.WriteString(f, strings.Join(collectErrors, "\n")+"\n")
+		}
+	}
+	return archive.Close()
+}
+
+// Writes the Sync Gateway version and details of the platform it's running on.
+func writeDiagnosticsPlatform(w io.Writer) error {
+	hostname, _ := os.Hostname()
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	platform := map[string]interface{}{
+		"version":       base.LongVersionString,
+		"go_version":    runtime.Version(),
+		"os":            runtime.GOOS,
+		"arch":          runtime.GOARCH,
+		"hostname":      hostname,
+		"num_cpu":       runtime.NumCPU(),
+		"gomaxprocs":    runtime.GOMAXPROCS(0),
+		"num_goroutine": runtime.NumGoroutine(),
+		"memstats_sys":  memStats.Sys,
+		"heap_alloc":    memStats.HeapAlloc,
+		"args":          os.Args,
+		"time":          time.Now().Format(time.RFC3339),
+	}
+	return json.NewEncoder(w).Encode(platform)
+}
+
+// Writes the server config with its passwords and secrets removed.  If redact is true, the names of the configured
+// users and roles are tagged as user data.
+func (sc *ServerContext) writeDiagnosticsConfig(w io.Writer, redact bool) error {
+	configJSON, err := json.Marshal(sc.GetConfig())
+	if err != nil {
+		return err
+	}
+	var config interface{}
+	if err := json.Unmarshal(configJSON, &config); err != nil {
+		return err
+	}
+	config = removeConfigSecrets(config, "", redact)
+	configJSON, err = json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(configJSON, '\n'))
+	return err
+}
+
+// Returns a copy of a config value, unmarshalled from JSON, with the values of secret properties replaced by
+// asterisks and credentials removed from URLs.  If redact is true, the keys of "users" and "roles" objects are
+// tagged as user data.
+func removeConfigSecrets(value interface{}, key string, redact bool) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(value))
+		tagKeys := redact && (key == "users" || key == "roles")
+		for k, v := range value {
+			if isSecretConfigKey(k) {
+				if _, isBool := v.(bool); !isBool {
+					result[k] = "******"
+					continue
+				}
+			}
+			if tagKeys {
+				result["<ud>"+k+"</ud>"] = removeConfigSecrets(v, "", redact)
+			} else {
+				result[k] = removeConfigSecrets(v, strings.ToLower(k), redact)
+			}
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(value))
+		for i, v := range value {
+			result[i] = removeConfigSecrets(v, key, redact)
+		}
+		return result
+	case string:
+		return base.RedactBasicAuthURL(value)
+	default:
+		return value
+	}
+}
+
+// Returns true if a config property holds a password or other secret.
+func isSecretConfigKey(key string) bool {
+	key = strings.ToLower(key)
+	return strings.Contains(key, "password") || strings.Contains(key, "secret") || key == "validation_key"
+}
+
+// Writes all the expvar stats, in the same format as /_expvar.
+func writeDiagnosticsExpvars(w io.Writer) error {
+	var buf bytes.Buffer
+	buf.WriteString("{\n")
+	first := true
+	expvar.Do(func(kv expvar.KeyValue) {
+		if !first {
+			buf.WriteString(",\n")
+		}
+		first = false
+		fmt.Fprintf(&buf, "%q: %s", kv.Key, kv.Value)
+	})
+	buf.WriteString("\n}\n")
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// Returns a Windows-safe filename for a diagnostics archive, like the ones sgcollect_info creates.
+func diagnosticsFilename() string {
+	return strings.Replace(sgcollectFilename(), "sgcollectinfo-", "sgdiagnostics-", 1)
+}
+
+// Collects a diagnostics archive from the admin API of the Sync Gateway listening on adminAddr, and saves it to
+// outputPath.  An empty redactLevel uses the server's configured log redaction level.  Used by the
+// -collect-diagnostics command line flag; returns the process exit code.
+func CollectDiagnostics(adminAddr string, outputPath string, redactLevel string, profileSecs uint, w io.Writer) int {
+	var options diagnosticsOptions
+	if redactLevel != "" {
+		var level base.RedactionLevel
+		if err := level.UnmarshalText([]byte(redactLevel)); err != nil {
+			fmt.Fprintf(w, "Invalid redaction level: %v\n", err)
+			return 1
+		}
+		options.RedactLevel = &level
+	}
+	options.ProfileSecs = profileSecs
+	body, err := json.Marshal(options)
+	if err != nil {
+		fmt.Fprintf(w, "Error encoding diagnostics options: %v\n", err)
+		return 1
+	}
+
+	resp, err := http.Post(adminAPIURL(adminAddr, "/_diagnostics"), "application/json", bytes.NewReader(body))
+	if err != nil {
+		fmt.Fprintf(w, "Error connecting to the admin API at %s: %v\n", base.RedactBasicAuthURL(adminAddr), err)
+		return 1
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(resp.Body)
+		fmt.Fprintf(w, "Error collecting diagnostics: %s %s\n", resp.Status, message)
+		return 1
+	}
+
+	if outputPath == "" || strings.HasSuffix(outputPath, string(filepath.Separator)) {
+		outputPath = filepath.Join(outputPath, diagnosticsFilename())
+	}
+	f, err := os.Create(outputPath)
+	if err != nil {
+		fmt.Fprintf(w, "Error creating %s: %v\n", outputPath, err)
+		return 1
+	}
+	_, err = io.Copy(f, resp.Body)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fmt.Fprintf(w, "Error writing %s: %v\n", outputPath, err)
+		return 1
+	}
+	fmt.Fprintf(w, "Diagnostics saved to %s\n", outputPath)
+	return 0
+}
+
+// Returns the URL of a path on the admin API of the Sync Gateway listening on adminAddr, which may be a host and
+// port or a URL.
+func adminAPIURL(adminAddr string, path string) string {
+	url := adminAddr
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		url = "http://" + url
+	}
+	return strings.TrimSuffix(url, "/") + path
+}
@@ -0,0 +1,50 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDocSchemaValidation(t *testing.T) {
+	var schema interface{}
+	assert.NoError(t, json.Unmarshal([]byte(`{"required":["title"], "properties":{"title":{"type":"string"}}}`), &schema))
+	rt := RestTester{
+		SyncFn:         `function(doc) {if (doc.type == "task" && !doc.title) {throw({forbidden: "sync function saw an invalid task"})}}`,
+		DatabaseConfig: &DbConfig{Schemas: &SchemasConfig{Types: map[string]interface{}{"task": schema}}},
+	}
+	defer rt.Close()
+
+	assertStatus(t, rt.SendAdminRequest("PUT", "/db/task1", `{"type":"task", "title":"Write tests"}`), http.StatusCreated)
+
+	// The schema rejects the document before the sync function runs
+	response := rt.SendAdminRequest("PUT", "/db/task2", `{"type":"task"}`)
+	assertStatus(t, response, http.StatusBadRequest)
+	var errorResponse ErrorResponse
+	assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &errorResponse))
+	assert.Equal(t, base.ErrorCodeInvalidDocument, errorResponse.Code)
+	assert.Equal(t, []interface{}{map[string]interface{}{"path": "", "message": `missing required property "title"`}}, errorResponse.Details)
+
+	response = rt.SendAdminRequest("POST", "/db/_bulk_docs", `{"docs":[{"_id":"task3", "type":"task", "title":3}, {"_id":"note1", "type":"note"}]}`)
+	assertStatus(t, response, http.StatusCreated)
+	var results []map[string]interface{}
+	assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &results))
+	assert.Len(t, results, 2)
+	assert.Equal(t, base.ErrorCodeInvalidDocument, results[0]["code"])
+	assert.Equal(t, []interface{}{map[string]interface{}{"path": "/title", "message": "must be of type string, not integer"}}, results[0]["details"])
+	assert.Equal(t, "note1", results[1]["id"])
+	assert.NotNil(t, results[1]["rev"])
+}
+
+func TestMakeDocSchemas(t *testing.T) {
+	schemas, err := makeDocSchemas(&SchemasConfig{TypeProperty: "kind", Types: map[string]interface{}{"task": map[string]interface{}{}}})
+	assert.NoError(t, err)
+	assert.Equal(t, "kind", schemas.TypeProperty)
+	assert.NotNil(t, schemas.Types["task"])
+
+	_, err = makeDocSchemas(&SchemasConfig{Types: map[string]interface{}{"task": map[string]interface{}{"type": "date"}}})
+	assert.EqualError(t, err, `Invalid schema for document type "task": /type: unknown type "date"`)
+}
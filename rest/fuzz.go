@@ -0,0 +1,54 @@
+// +build gofuzz
+
+package rest
+
+import (
+	"github.com/couchbase/go-blip"
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbase/sync_gateway/db"
+)
+
+// Fuzz targets for parsing of BLIP message bodies sent by clients, for use with go-fuzz
+// (github.com/dvyukov/go-fuzz):
+//
+//     go-fuzz-build -func FuzzBlipChanges github.com/couchbase/sync_gateway/rest
+//     go-fuzz -bin rest-fuzz.zip -workdir fuzz/blip_changes
+//
+// Each target returns 1 if the input parsed (so the fuzzer should prioritize it), and 0 otherwise.
+
+// FuzzBlipChanges fuzzes parsing of the body of a changes or proposeChanges message.
+func FuzzBlipChanges(data []byte) int {
+	rq := blip.NewRequest()
+	rq.SetBody(data)
+	var changeList [][]interface{}
+	if err := rq.ReadJSONBody(&changeList); err != nil {
+		return 0
+	}
+	for _, change := range changeList {
+		if _, _, err := parseChangeDocRev(change, 1); err != nil {
+			return 0
+		}
+		if _, _, err := parseChangeDocRev(change, 0); err != nil {
+			return 0
+		}
+	}
+	return 1
+}
+
+// FuzzBlipRev fuzzes parsing of the body of a rev message, both as a document body and as a delta against an
+// existing revision.
+func FuzzBlipRev(data []byte) int {
+	rq := blip.NewRequest()
+	rq.SetBody(data)
+	result := 0
+	var body db.Body
+	if err := rq.ReadJSONBody(&body); err == nil {
+		result = 1
+	}
+
+	deltaSrc := map[string]interface{}{"key": "value", "list": []interface{}{1, 2, 3}, "obj": map[string]interface{}{"a": true}}
+	if err := base.Patch(&deltaSrc, data); err == nil {
+		result = 1
+	}
+	return result
+}
@@ -0,0 +1,106 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbase/sync_gateway/db"
+)
+
+// Validates a database's GraphQL config, whose resolvers may refer to the database's named queries and functions,
+// and builds its schema.
+func makeGraphQLSchema(config *GraphQLConfig, queries map[string]*NamedQueryConfig, functions map[string]string) (*db.GraphQLSchema, error) {
+	resolvers := make(map[string]map[string]*db.GraphQLResolver, len(config.Resolvers))
+	for typeName, fields := range config.Resolvers {
+		resolvers[typeName] = make(map[string]*db.GraphQLResolver, len(fields))
+		for fieldName, resolver := range fields {
+			if resolver == nil {
+				return nil, fmt.Errorf("graphql.resolvers.%s.%s: resolver must be given", typeName, fieldName)
+			}
+			if resolver.Query != "" && queries[resolver.Query] == nil {
+				return nil, fmt.Errorf("graphql.resolvers.%s.%s: no such query %q", typeName, fieldName, resolver.Query)
+			}
+			if _, ok := functions[resolver.Function]; resolver.Function != "" && !ok {
+				return nil, fmt.Errorf("graphql.resolvers.%s.%s: no such function %q", typeName, fieldName, resolver.Function)
+			}
+			resolvers[typeName][fieldName] = &db.GraphQLResolver{
+				GetDoc:   resolver.GetDoc,
+				Query:    resolver.Query,
+				Function: resolver.Function,
+			}
+		}
+	}
+	schema, err := db.NewGraphQLSchema(config.Schema, resolvers)
+	if err != nil {
+		return nil, fmt.Errorf("graphql: %v", err)
+	}
+	return schema, nil
+}
+
+// Body of a GraphQL request
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+// GET or POST /db/_graphql runs a GraphQL query or mutation, given in a JSON body of a POST, or in the query string
+// of a GET with the variables as a JSON object.  Mutations have to be POSTed.  Invalid requests fail with a 400 whose
+// body has the GraphQL errors; otherwise the response has the data, and the errors resolving any fields.
+func (h *handler) handleGraphQL() error {
+	schema := h.db.Options.GraphQLSchema
+	if schema == nil {
+		return base.HTTPErrorf(http.StatusNotFound, "GraphQL is not configured for this database")
+	}
+
+	var request graphQLRequest
+	if h.rq.Method == "POST" {
+		if err := h.readJSONInto(&request); err != nil {
+			return err
+		}
+	} else {
+		request.Query = h.getQuery("query")
+		request.OperationName = h.getQuery("operationName")
+		if variables := h.getQuery("variables"); len(variables) > db.GraphQLMaxQueryLength {
+			return base.HTTPErrorf(http.StatusRequestEntityTooLarge, "Variables exceed the limit of %d bytes", db.GraphQLMaxQueryLength)
+		} else if variables != "" {
+			if err := json.Unmarshal([]byte(variables), &request.Variables); err != nil {
+				return base.HTTPErrorf(http.StatusBadRequest, "Invalid variables: %v", err)
+			}
+		}
+	}
+	if request.Query == "" {
+		return base.HTTPErrorf(http.StatusBadRequest, "A query must be given")
+	}
+	if len(request.Query) > db.GraphQLMaxQueryLength {
+		return base.HTTPErrorf(http.StatusRequestEntityTooLarge, "Query exceeds the limit of %d bytes", db.GraphQLMaxQueryLength)
+	}
+
+	op, err := schema.Prepare(request.Query, request.OperationName)
+	if err != nil {
+		h.writeJSONStatus(http.StatusBadRequest, db.Body{"errors": err})
+		return nil
+	}
+	if op.IsMutation() {
+		if h.rq.Method != "POST" {
+			return base.HTTPErrorf(http.StatusMethodNotAllowed, "Mutations must be POSTed")
+		}
+		if config := h.readOnlyConfig(); config != nil {
+			return h.readOnlyError(config)
+		}
+	}
+
+	result, err := h.db.ExecuteGraphQL(op, request.Variables)
+	if err != nil {
+		h.writeJSONStatus(http.StatusBadRequest, db.Body{"errors": err})
+		return nil
+	}
+	response := db.Body{"data": result.Data}
+	if len(result.Errors) > 0 {
+		response["errors"] = result.Errors
+	}
+	h.writeJSON(response)
+	return nil
+}
@@ -0,0 +1,90 @@
+package rest
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/couchbase/sync_gateway/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGraphQLAPI(t *testing.T) {
+	rt := RestTester{DatabaseConfig: &DbConfig{
+		Functions: map[string]string{
+			"rename": `function(args, user) {
+				var doc = getDoc(args.id);
+				doc.name = args.name;
+				putDoc(args.id, doc);
+				return doc;
+			}`,
+		},
+		GraphQL: &GraphQLConfig{
+			Schema: `
+				type Query { item(id: ID!): Item }
+				type Mutation { rename(id: ID!, name: String!): Item }
+				type Item { name: String }`,
+			Resolvers: map[string]map[string]*GraphQLResolverConfig{
+				"Query":    {"item": {GetDoc: "id"}},
+				"Mutation": {"rename": {Function: "rename"}},
+			},
+		},
+	}}
+	defer rt.Close()
+
+	assertStatus(t, rt.SendAdminRequest("PUT", "/db/item1", `{"name":"one"}`), http.StatusCreated)
+
+	response := rt.SendRequest("POST", "/db/_graphql", `{"query":"query($id: ID!) { item(id: $id) { name } }", "variables":{"id":"item1"}}`)
+	assertStatus(t, response, http.StatusOK)
+	assert.JSONEq(t, `{"data":{"item":{"name":"one"}}}`, response.Body.String())
+
+	response = rt.SendRequest("GET", "/db/_graphql?query="+url.QueryEscape(`{ item(id: "item1") { name } }`), "")
+	assertStatus(t, response, http.StatusOK)
+	assert.JSONEq(t, `{"data":{"item":{"name":"one"}}}`, response.Body.String())
+
+	// Invalid requests fail with the GraphQL errors
+	response = rt.SendRequest("POST", "/db/_graphql", `{"query":"{ item { name } }"}`)
+	assertStatus(t, response, http.StatusBadRequest)
+	assert.Contains(t, response.Body.String(), `"errors"`)
+	assertStatus(t, rt.SendRequest("POST", "/db/_graphql", `{}`), http.StatusBadRequest)
+
+	// Overlong and deeply nested queries are rejected
+	longQuery := `{ item(id: "` + strings.Repeat("x", db.GraphQLMaxQueryLength) + `") { name } }`
+	assertStatus(t, rt.SendRequest("GET", "/db/_graphql?query="+url.QueryEscape(longQuery), ""), http.StatusRequestEntityTooLarge)
+	deepQuery := `{ item(id: ` + strings.Repeat("[", 1000) + `) { name } }`
+	assertStatus(t, rt.SendRequest("GET", "/db/_graphql?query="+url.QueryEscape(deepQuery), ""), http.StatusBadRequest)
+
+	// Mutations have to be POSTed
+	mutation := `mutation { rename(id: "item1", name: "two") { name } }`
+	assertStatus(t, rt.SendRequest("GET", "/db/_graphql?query="+url.QueryEscape(mutation), ""), http.StatusMethodNotAllowed)
+	response = rt.SendAdminRequest("POST", "/db/_graphql", `{"query":"mutation { rename(id: \"item1\", name: \"two\") { name } }"}`)
+	assertStatus(t, response, http.StatusOK)
+	assert.JSONEq(t, `{"data":{"rename":{"name":"two"}}}`, response.Body.String())
+}
+
+func TestGraphQLNotConfigured(t *testing.T) {
+	rt := RestTester{}
+	defer rt.Close()
+
+	assertStatus(t, rt.SendRequest("POST", "/db/_graphql", `{"query":"{ item }"}`), http.StatusNotFound)
+}
+
+func TestMakeGraphQLSchema(t *testing.T) {
+	config := &GraphQLConfig{
+		Schema:    `type Query { items(type: String): [String] }`,
+		Resolvers: map[string]map[string]*GraphQLResolverConfig{"Query": {"items": {Query: "items"}}},
+	}
+	queries := map[string]*NamedQueryConfig{"items": {Select: "name", Where: "type = $type", Parameters: []string{"type"}}}
+	_, err := makeGraphQLSchema(config, queries, nil)
+	assert.NoError(t, err)
+
+	_, err = makeGraphQLSchema(config, nil, nil)
+	assert.Error(t, err)
+	config.Resolvers["Query"]["items"] = &GraphQLResolverConfig{Function: "items"}
+	_, err = makeGraphQLSchema(config, queries, nil)
+	assert.Error(t, err)
+	config.Schema = `type Query { items: [String`
+	_, err = makeGraphQLSchema(config, nil, map[string]string{"items": `function(args, user) {return [];}`})
+	assert.Error(t, err)
+}
@@ -45,6 +45,9 @@ var DebugMultipart bool = false
 
 var lastSerialNum uint64 = 0
 
+// The response header used to return the request's log correlation ID to the client.
+const correlationIDHeader = "X-Correlation-ID"
+
 func init() {
 	DebugMultipart = (os.Getenv("GatewayDebugMultipart") != "")
 }
@@ -68,7 +71,11 @@ type handler struct {
 	serialNumber   uint64
 	loggedDuration bool
 	runOffline     bool
-	queryValues    url.Values // Copy of results of rq.URL.Query()
+	queryValues    url.Values      // Copy of results of rq.URL.Query()
+	logCtx         context.Context // Context carrying the base.LogContext for this request
+	auditBefore    json.RawMessage // JSON summary of the state modified by this request, before the change
+	auditAfter     json.RawMessage // JSON summary of the state modified by this request, after the change
+	sessionID      string          // The request's login session: the one its cookie authenticated, or the one it created
 }
 
 type handlerPrivs int
@@ -88,6 +95,7 @@ func makeHandler(server *ServerContext, privs handlerPrivs, method handlerMethod
 		h := newHandler(server, privs, r, rq, runOffline)
 		err := h.invoke(method)
 		h.writeError(err)
+		h.logAudit()
 		h.logDuration(true)
 	})
 }
@@ -99,12 +107,13 @@ func makeOfflineHandler(server *ServerContext, privs handlerPrivs, method handle
 		h := newHandler(server, privs, r, rq, runOffline)
 		err := h.invoke(method)
 		h.writeError(err)
+		h.logAudit()
 		h.logDuration(true)
 	})
 }
 
 func newHandler(server *ServerContext, privs handlerPrivs, r http.ResponseWriter, rq *http.Request, runOffline bool) *handler {
-	return &handler{
+	h := &handler{
 		server:       server,
 		privs:        privs,
 		rq:           rq,
@@ -114,6 +123,13 @@ func newHandler(server *ServerContext, privs handlerPrivs, r http.ResponseWriter
 		startTime:    time.Now(),
 		runOffline:   runOffline,
 	}
+
+	h.logCtx = context.WithValue(context.Background(), base.LogContextKey{},
+		base.LogContext{CorrelationID: h.formatSerialNumber()},
+	)
+	h.setHeader(correlationIDHeader, h.formatSerialNumber())
+
+	return h
 }
 
 // Top-level handler call. It's passed a pointer to the specific method to run.
@@ -141,6 +157,23 @@ func (h *handler) invoke(method handlerMethod) error {
 
 	h.setHeader("Server", base.VersionString)
 
+	if h.privs != adminPrivs {
+		if err = h.checkIPRateLimit(); err != nil {
+			h.logRequestLine()
+			return err
+		}
+	}
+
+	if err = h.limitRequestBody(); err != nil {
+		h.logRequestLine()
+		return err
+	}
+
+	if err = h.validateRequest(); err != nil {
+		h.logRequestLine()
+		return err
+	}
+
 	// If there is a "db" path variable, look up the database context:
 	var dbContext *db.DatabaseContext
 	if dbname := h.PathVar("db"); dbname != "" {
@@ -148,6 +181,9 @@ func (h *handler) invoke(method handlerMethod) error {
 			h.logRequestLine()
 			return err
 		}
+		h.logCtx = context.WithValue(context.Background(), base.LogContextKey{},
+			base.LogContext{CorrelationID: h.formatSerialNumber(), Database: dbContext.Name},
+		)
 	}
 
 	// If this call is in the context of a DB make sure the DB is in a valid state
@@ -167,10 +203,10 @@ func (h *handler) invoke(method handlerMethod) error {
 			//if dbState == db.DBOnline, continue flow and invoke the handler method
 			if dbState == db.DBOffline {
 				//DB is offline, only handlers with runOffline true can run in this state
-				return base.HTTPErrorf(http.StatusServiceUnavailable, "DB is currently under maintenance")
+				return base.CodedHTTPErrorf(http.StatusServiceUnavailable, base.ErrorCodeDatabaseUnavailable, "DB is currently under maintenance")
 			} else if dbState != db.DBOnline {
 				//DB is in transition state, no calls will be accepted until it is Online or Offline state
-				return base.HTTPErrorf(http.StatusServiceUnavailable, fmt.Sprintf("DB is %v - try again later", db.RunStateString[dbState]))
+				return base.CodedHTTPErrorf(http.StatusServiceUnavailable, base.ErrorCodeDatabaseUnavailable, "DB is %v - try again later", db.RunStateString[dbState])
 			}
 		}
 	}
@@ -181,6 +217,20 @@ func (h *handler) invoke(method handlerMethod) error {
 			h.logRequestLine()
 			return err
 		}
+		if dbContext != nil {
+			if err = h.checkCSRF(dbContext); err != nil {
+				h.logRequestLine()
+				return err
+			}
+			if err = h.checkUserRateLimit(dbContext.Name); err != nil {
+				h.logRequestLine()
+				return err
+			}
+			if err = dbContext.CheckOpRate(); err != nil {
+				h.logRequestLine()
+				return err
+			}
+		}
 	}
 
 	h.logRequestLine()
@@ -195,9 +245,7 @@ func (h *handler) invoke(method handlerMethod) error {
 		if err != nil {
 			return err
 		}
-		h.db.Ctx = context.WithValue(context.Background(), base.LogContextKey{},
-			base.LogContext{CorrelationID: h.formatSerialNumber()},
-		)
+		h.db.Ctx = h.logCtx
 	}
 
 	if base.EnableLogHTTPBodies {
@@ -212,6 +260,9 @@ func (h *handler) invoke(method handlerMethod) error {
 		)
 	}
 
+	if timeout := h.requestTimeout(); timeout > 0 {
+		return h.invokeWithTimeout(method, timeout)
+	}
 	return method(h) // Call the actual handler code
 }
 
@@ -227,7 +278,7 @@ func (h *handler) logRequestLine() {
 	}
 
 	queryValues := h.getQueryValues()
-	base.Infof(base.KeyHTTP, " %s: %s %s%s%s", h.formatSerialNumber(), h.rq.Method, base.SanitizeRequestURL(h.rq, &queryValues), proto, h.currentEffectiveUserNameAsUser())
+	base.InfofCtx(h.logCtx, base.KeyHTTP, "%s %s%s%s", h.rq.Method, base.SanitizeRequestURL(h.rq, &queryValues), proto, h.currentEffectiveUserNameAsUser())
 }
 
 func (h *handler) logRequestBody() {
@@ -268,8 +319,8 @@ func (h *handler) logDuration(realTime bool) {
 		logKey = base.KeyHTTP
 	}
 
-	base.Infof(logKey, "%s:     --> %d %s  (%.1f ms)",
-		h.formatSerialNumber(), h.status, h.statusMessage,
+	base.InfofCtx(h.logCtx, logKey, "    --> %d %s  (%.1f ms)",
+		h.status, h.statusMessage,
 		float64(duration)/float64(time.Millisecond),
 	)
 }
@@ -339,7 +390,7 @@ func (h *handler) checkAuth(context *db.DatabaseContext) (err error) {
 	if userName, password := h.getBasicAuth(); userName != "" {
 		h.user = context.Authenticator().AuthenticateUser(userName, password)
 		if h.user == nil {
-			base.Infof(base.KeyAll, "HTTP auth failed for username=%q", base.UD(userName))
+			base.Infof(base.KeyAll, "HTTP auth failed for username=%q", base.UDUsername(userName))
 			if context.Options.SendWWWAuthenticateHeader == nil || *context.Options.SendWWWAuthenticateHeader {
 				h.response.Header().Set("WWW-Authenticate", `Basic realm="Couchbase Sync Gateway"`)
 			}
@@ -353,6 +404,9 @@ func (h *handler) checkAuth(context *db.DatabaseContext) (err error) {
 	if err != nil {
 		return err
 	} else if h.user != nil {
+		if cookie, _ := h.rq.Cookie(context.Authenticator().SessionCookieName()); cookie != nil {
+			h.sessionID = cookie.Value
+		}
 		return nil
 	}
 
@@ -461,7 +515,7 @@ func (h *handler) readJSON() (db.Body, error) {
 
 // Parses a JSON request body into a custom structure.
 func (h *handler) readJSONInto(into interface{}) error {
-	return db.ReadJSONFromMIME(h.rq.Header, h.requestBody, into)
+	return db.ReadJSONFromMIME(h.rq.Header, h.requestBody, into, h.maxJSONDepth())
 }
 
 // Reads & parses the request body, handling either JSON or multipart.
@@ -477,7 +531,7 @@ func (h *handler) readDocument() (db.Body, error) {
 				return nil, err
 			}
 			reader := multipart.NewReader(bytes.NewReader(raw), attrs["boundary"])
-			body, err := db.ReadMultipartDocument(reader)
+			body, err := db.ReadMultipartDocument(reader, h.maxJSONDepth())
 			if err != nil {
 				ioutil.WriteFile("GatewayPUT.mime", raw, 0600)
 				base.Warnf(base.KeyAll, "Error reading MIME data: copied to file GatewayPUT.mime")
@@ -485,7 +539,7 @@ func (h *handler) readDocument() (db.Body, error) {
 			return body, err
 		} else {
 			reader := multipart.NewReader(h.requestBody, attrs["boundary"])
-			return db.ReadMultipartDocument(reader)
+			return db.ReadMultipartDocument(reader, h.maxJSONDepth())
 		}
 	default:
 		return nil, base.HTTPErrorf(http.StatusUnsupportedMediaType, "Invalid content type %s", contentType)
@@ -528,7 +582,7 @@ func (h *handler) currentEffectiveUserName() string {
 		effectiveName = "ADMIN"
 	} else if h.user != nil {
 		if name := h.user.Name(); name != "" {
-			effectiveName = base.UD(name).Redact()
+			effectiveName = base.UDUsername(name).Redact()
 		} else {
 			effectiveName = "GUEST"
 		}
@@ -557,7 +611,11 @@ func (h *handler) setStatus(status int, message string) {
 }
 
 func (h *handler) disableResponseCompression() {
-	switch r := h.response.(type) {
+	response := h.response
+	if tw, ok := response.(*timeoutResponseWriter); ok {
+		response = tw.w
+	}
+	switch r := response.(type) {
 	case *EncodedResponseWriter:
 		r.disableCompression()
 	}
@@ -682,7 +740,8 @@ func (h *handler) writeError(err error) {
 	if err != nil {
 		err = auth.OIDCToHTTPError(err) // Map OIDC/OAuth2 errors to HTTP form
 		status, message := base.ErrorAsHTTPStatus(err)
-		h.writeStatus(status, message)
+		code, retryable := base.ErrorCode(err)
+		h.writeErrorResponse(status, message, code, retryable, base.ErrorDetails(err))
 		format := "%v"
 		if base.StacktraceOnAPIErrors {
 			format = "%+v"
@@ -691,6 +750,17 @@ func (h *handler) writeError(err error) {
 	}
 }
 
+// The JSON body of an error response.  Error and Reason are as in CouchDB; clients should act on Code, which is
+// stable across releases, rather than matching Reason.
+type ErrorResponse struct {
+	Error         string      `json:"error"`
+	Reason        string      `json:"reason"`
+	Code          string      `json:"code"`                     // One of the base.ErrorCode constants
+	Retryable     bool        `json:"retryable"`                // Whether retrying the request later may succeed
+	CorrelationID string      `json:"correlation_id,omitempty"` // Identifies the request in the logs
+	Details       interface{} `json:"details,omitempty"`        // More about the error, for some codes; for invalid_document, the schema violations
+}
+
 // Writes the response status code, and if it's an error writes a JSON description to the body.
 func (h *handler) writeStatus(status int, message string) {
 	if status < 300 {
@@ -698,7 +768,12 @@ func (h *handler) writeStatus(status int, message string) {
 		h.setStatus(status, message)
 		return
 	}
-	// Got an error:
+	code := base.HTTPStatusErrorCode(status)
+	h.writeErrorResponse(status, message, code, base.IsRetryableErrorCode(code), nil)
+}
+
+// Writes an error response status code, and an ErrorResponse body.
+func (h *handler) writeErrorResponse(status int, message string, code string, retryable bool, details interface{}) {
 	var errorStr string
 	switch status {
 	case http.StatusNotFound:
@@ -716,7 +791,14 @@ func (h *handler) writeStatus(status int, message string) {
 	h.setHeader("Content-Type", "application/json")
 	h.response.WriteHeader(status)
 	h.setStatus(status, message)
-	jsonOut, _ := json.Marshal(db.Body{"error": errorStr, "reason": message})
+	jsonOut, _ := json.Marshal(ErrorResponse{
+		Error:         errorStr,
+		Reason:        message,
+		Code:          code,
+		Retryable:     retryable,
+		CorrelationID: h.formatSerialNumber(),
+		Details:       details,
+	})
 	h.response.Write(jsonOut)
 }
 
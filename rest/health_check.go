@@ -0,0 +1,169 @@
+package rest
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbase/sync_gateway/db"
+)
+
+const (
+	healthStatusOK   = "ok"
+	healthStatusFail = "fail"
+)
+
+// How long each health check may take before it's considered failed.
+const kHealthCheckTimeout = 5 * time.Second
+
+// How long the results of the health checks are reused for, so that frequent probes don't each write to the buckets
+// and wait on their feeds.
+var healthCheckCacheTTL = 5 * time.Second
+
+// The result of a single health check.
+type healthCheckResult struct {
+	Status     string  `json:"status"`
+	Error      string  `json:"error,omitempty"`
+	Detail     string  `json:"detail,omitempty"`
+	DurationMs float64 `json:"duration_ms"`
+}
+
+// The results of all health checks for a database, keyed by check name.
+type databaseHealth struct {
+	Status string                        `json:"status"`
+	Checks map[string]*healthCheckResult `json:"checks"`
+}
+
+type healthResponse struct {
+	Status    string                     `json:"status"`
+	Databases map[string]*databaseHealth `json:"databases,omitempty"`
+}
+
+// The latest results of the full and read-only health checks.  The lock is held while checking, so concurrent
+// requests wait for the same results rather than each running the checks.
+type healthCheckCache struct {
+	lock     sync.Mutex
+	full     *healthResponse
+	fullAt   time.Time
+	readOnly *healthResponse
+	readAt   time.Time
+}
+
+// Runs the given check, timing it and converting any error into a failed result.
+func runHealthCheck(check func() (detail string, err error)) *healthCheckResult {
+	startTime := time.Now()
+	detail, err := check()
+	result := &healthCheckResult{
+		Status:     healthStatusOK,
+		Detail:     detail,
+		DurationMs: float64(time.Since(startTime)) / float64(time.Millisecond),
+	}
+	if err != nil {
+		result.Status = healthStatusFail
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// Runs the bucket, mutation feed, OIDC and background task checks for the given database.  The read-only checks
+// don't write to the bucket, so they skip the feed check, and don't contact the OIDC providers.
+func (sc *ServerContext) checkDatabaseHealth(dbc *db.DatabaseContext, readOnly bool) *databaseHealth {
+	health := &databaseHealth{
+		Status: healthStatusOK,
+		Checks: map[string]*healthCheckResult{},
+	}
+
+	if readOnly {
+		health.Checks["bucket"] = runHealthCheck(func() (string, error) {
+			return "", dbc.CheckBucketReadHealth()
+		})
+	} else {
+		health.Checks["bucket"] = runHealthCheck(func() (string, error) {
+			return "", dbc.CheckBucketHealth()
+		})
+		health.Checks["feed"] = runHealthCheck(func() (string, error) {
+			return base.GetFeedType(dbc.Bucket), dbc.CheckFeedHealth(kHealthCheckTimeout)
+		})
+
+		client := &http.Client{Timeout: kHealthCheckTimeout}
+		for name, provider := range dbc.OIDCProviders {
+			provider := provider
+			health.Checks["oidc:"+name] = runHealthCheck(func() (string, error) {
+				return provider.Issuer, provider.CheckReachable(client)
+			})
+		}
+	}
+
+	health.Checks["tasks"] = runHealthCheck(dbc.CheckBackgroundTaskHealth)
+
+	health.Checks["state"] = runHealthCheck(func() (string, error) {
+		state := atomic.LoadUint32(&dbc.State)
+		if state != db.DBOnline {
+			return db.RunStateString[state], errors.New("database is not online")
+		}
+		return db.RunStateString[state], nil
+	})
+
+	for _, result := range health.Checks {
+		if result.Status != healthStatusOK {
+			health.Status = healthStatusFail
+		}
+	}
+	return health
+}
+
+// Returns the results of the health checks of every database, running them unless the cached results are recent
+// enough.
+func (sc *ServerContext) healthCheck(readOnly bool) *healthResponse {
+	cache := &sc.healthCache
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	cached, checkedAt := cache.full, cache.fullAt
+	if readOnly {
+		cached, checkedAt = cache.readOnly, cache.readAt
+	}
+	if cached != nil && time.Since(checkedAt) < healthCheckCacheTTL {
+		return cached
+	}
+
+	response := &healthResponse{
+		Status:    healthStatusOK,
+		Databases: map[string]*databaseHealth{},
+	}
+	for name, dbc := range sc.AllDatabases() {
+		health := sc.checkDatabaseHealth(dbc, readOnly)
+		if health.Status != healthStatusOK {
+			response.Status = healthStatusFail
+			base.Warnf(base.KeyHTTP, "Health check failed for database %s", base.MD(name))
+		}
+		response.Databases[name] = health
+	}
+
+	if readOnly {
+		cache.readOnly, cache.readAt = response, time.Now()
+	} else {
+		cache.full, cache.fullAt = response, time.Now()
+	}
+	return response
+}
+
+// HTTP handler for GET /_health.  Returns 200 if every check for every database passes, and 503 otherwise.  The
+// public port only runs the checks that don't write to the buckets, and only reports the overall status; per-check
+// results are only included on the admin port.  Results are reused for healthCheckCacheTTL.
+func (h *handler) handleHealthCheck() error {
+	health := h.server.healthCheck(h.privs != adminPrivs)
+	response := healthResponse{Status: health.Status}
+	if h.privs == adminPrivs {
+		response.Databases = health.Databases
+	}
+
+	status := http.StatusOK
+	if response.Status != healthStatusOK {
+		status = http.StatusServiceUnavailable
+	}
+	h.writeJSONStatus(status, response)
+	return nil
+}
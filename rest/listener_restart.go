@@ -0,0 +1,43 @@
+package rest
+
+import (
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/sync_gateway/base"
+)
+
+// How long to wait for the new process to start listening when restarting listeners.
+const listenerRestartReadyTimeout = 2 * time.Minute
+
+// Set while a listener restart is in progress
+var restartingListeners int32
+
+// Restarts the listeners without dropping the connections in progress, e.g. to change interfaces or TLS certs.  A
+// new Sync Gateway process is started with the same command line, so it rereads the config, and is handed the
+// listening sockets.  Once it's listening, this process stops accepting connections, gives the requests and
+// replications in progress up to listener_drain_timeout to finish, then exits; clients reconnect to the new process
+// as their connections close, rather than all at once.  If the new process fails to start, this one carries on.
+// Triggered by base.ListenerRestartSignal (SIGUSR2).
+func RestartListeners() {
+	if !atomic.CompareAndSwapInt32(&restartingListeners, 0, 1) {
+		base.Infof(base.KeyAll, "Listener restart already in progress")
+		return
+	}
+	base.Infof(base.KeyAll, "Restarting listeners")
+	if err := base.HandOffListeners(listenerRestartReadyTimeout); err != nil {
+		base.Errorf(base.KeyAll, "Unable to restart listeners, will keep using the current ones: %v", err)
+		atomic.StoreInt32(&restartingListeners, 0)
+		return
+	}
+
+	drainTimeout := DefaultListenerDrainTimeout
+	if config != nil && config.ListenerDrainTimeout != nil {
+		drainTimeout = *config.ListenerDrainTimeout
+	}
+	base.Infof(base.KeyAll, "Draining connections for up to %d seconds", drainTimeout)
+	base.DrainListeners(time.Duration(drainTimeout) * time.Second)
+	base.FlushLogBuffers()
+	os.Exit(0)
+}
@@ -0,0 +1,296 @@
+package rest
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/couchbase/go-blip"
+	"github.com/couchbase/sync_gateway/base"
+	"golang.org/x/net/websocket"
+)
+
+// Options for SimulateLoad.
+type LoadSimulatorOptions struct {
+	TargetURL    string        // Public API URL of the database, with the credentials of the user the clients log in as, if any
+	Clients      int           // Number of simulated Couchbase Lite clients
+	Duration     time.Duration // How long the clients push and pull for
+	DocSize      int           // Approximate size of the pushed docs' bodies, in bytes
+	Channels     []string      // Channels the pushed docs are assigned to, in turn, and that the clients pull.  All channels if empty
+	WritesPerSec float64       // Rate each client pushes new docs at.  Zero only pulls
+}
+
+// Latencies and counts recorded by the simulated clients.
+type loadSimulatorStats struct {
+	lock          sync.Mutex
+	pushLatencies []time.Duration // From sending a rev to receiving its response
+	pullLatencies []time.Duration // From a simulated client pushing a rev to another one pulling it
+	pushErrors    int
+	pulledRevs    int // Every rev pulled, including docs that weren't pushed by the simulator
+	connectErrors int
+	disconnects   int
+}
+
+// A simulated Couchbase Lite client, pushing new docs and pulling a continuous changes feed over BLIP.
+type simulatedClient struct {
+	options     *LoadSimulatorOptions
+	stats       *loadSimulatorStats
+	docIDPrefix string // Prefix of the IDs of the docs this client pushes
+	sender      *blip.Sender
+}
+
+// The properties added to the docs pushed by simulated clients
+type simulatedDoc struct {
+	Channels []string  `json:"channels,omitempty"`
+	PushedAt time.Time `json:"sim_pushed_at"`
+	Data     string    `json:"sim_data"`
+}
+
+// SimulateLoad runs a number of simulated Couchbase Lite clients against the database at options.TargetURL for
+// options.Duration, each pushing new docs at the given rate while pulling a continuous changes feed, then writes the
+// push and pull throughput and latencies to w.  Used by the -simulate-load command line flag for capacity planning;
+// returns the process exit code.
+func SimulateLoad(options LoadSimulatorOptions, w io.Writer) int {
+	if options.Clients <= 0 {
+		fmt.Fprintln(w, "The number of simulated clients must be at least 1")
+		return 1
+	}
+	blipURL, authHeader, err := blipSyncURL(options.TargetURL)
+	if err != nil {
+		fmt.Fprintf(w, "Invalid database URL %s: %v\n", base.RedactBasicAuthURL(options.TargetURL), err)
+		return 1
+	}
+
+	stats := &loadSimulatorStats{}
+	runID := base.CreateUUID()[:8]
+	clients := make([]*simulatedClient, 0, options.Clients)
+	for i := 0; i < options.Clients; i++ {
+		client := &simulatedClient{
+			options:     &options,
+			stats:       stats,
+			docIDPrefix: fmt.Sprintf("sim-%s-%d-", runID, i),
+		}
+		if err := client.connect(blipURL, authHeader); err != nil {
+			if stats.connectErrors == 0 {
+				fmt.Fprintf(w, "Error connecting to %s: %v\n", base.RedactBasicAuthURL(options.TargetURL), err)
+			}
+			stats.connectErrors++
+			continue
+		}
+		clients = append(clients, client)
+	}
+	if len(clients) == 0 {
+		fmt.Fprintln(w, "None of the simulated clients could connect")
+		return 1
+	}
+
+	fmt.Fprintf(w, "Simulating %d clients against %s for %v...\n", len(clients), base.RedactBasicAuthURL(options.TargetURL), options.Duration)
+	start := time.Now()
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, client := range clients {
+		wg.Add(1)
+		go func(client *simulatedClient) {
+			defer wg.Done()
+			client.push(stop)
+		}(client)
+	}
+	time.Sleep(options.Duration)
+	close(stop)
+	wg.Wait()
+	elapsed := time.Since(start)
+	for _, client := range clients {
+		client.sender.Close()
+	}
+
+	stats.lock.Lock()
+	defer stats.lock.Unlock()
+	fmt.Fprintf(w, "Clients: %d connected, %d failed to connect, %d disconnected\n", len(clients), stats.connectErrors, stats.disconnects)
+	fmt.Fprintf(w, "Push: %d revs (%.1f/s), %d errors, latency %s\n", len(stats.pushLatencies), float64(len(stats.pushLatencies))/elapsed.Seconds(), stats.pushErrors, formatLatencies(stats.pushLatencies))
+	fmt.Fprintf(w, "Pull: %d revs (%.1f/s), latency %s\n", stats.pulledRevs, float64(stats.pulledRevs)/elapsed.Seconds(), formatLatencies(stats.pullLatencies))
+	return 0
+}
+
+// Returns the BLIP sync URL of the database with the given public API URL, and the Authorization header for the
+// credentials in the URL, if any.
+func blipSyncURL(dbURL string) (blipURL *url.URL, authHeader string, err error) {
+	blipURL, err = url.Parse(dbURL)
+	if err != nil {
+		return nil, "", err
+	}
+	switch blipURL.Scheme {
+	case "http":
+		blipURL.Scheme = "ws"
+	case "https":
+		blipURL.Scheme = "wss"
+	default:
+		return nil, "", fmt.Errorf("must be an http or https URL")
+	}
+	if blipURL.User != nil {
+		password, _ := blipURL.User.Password()
+		authHeader = "Basic " + base64.StdEncoding.EncodeToString([]byte(blipURL.User.Username()+":"+password))
+		blipURL.User = nil
+	}
+	blipURL.Path = strings.TrimSuffix(blipURL.Path, "/") + "/_blipsync"
+	return blipURL, authHeader, nil
+}
+
+// Opens the client's BLIP connection and subscribes to the continuous changes feed.
+func (client *simulatedClient) connect(blipURL *url.URL, authHeader string) error {
+	blipContext := blip.NewContext(BlipCBMobileReplication)
+	blipContext.Logger = DefaultBlipLogger(context.WithValue(context.Background(), base.LogContextKey{},
+		base.LogContext{CorrelationID: formatBlipContextID(blipContext.ID)},
+	))
+	blipContext.HandlerForProfile[messageChanges] = client.handleChanges
+	blipContext.HandlerForProfile[messageRev] = client.handleRev
+	blipContext.FatalErrorHandler = func(err error) {
+		client.stats.lock.Lock()
+		client.stats.disconnects++
+		client.stats.lock.Unlock()
+	}
+
+	origin := *blipURL
+	origin.Scheme = strings.Replace(origin.Scheme, "ws", "http", 1)
+	origin.Path = ""
+	config, err := websocket.NewConfig(blipURL.String(), origin.String())
+	if err != nil {
+		return err
+	}
+	if authHeader != "" {
+		config.Header = http.Header{"Authorization": {authHeader}}
+	}
+	if client.sender, err = blipContext.DialConfig(config); err != nil {
+		return err
+	}
+
+	subChanges := blip.NewRequest()
+	subChanges.SetProfile(messageSubChanges)
+	subChanges.Properties[subChangesContinuous] = "true"
+	if len(client.options.Channels) > 0 {
+		subChanges.Properties[subChangesFilter] = "sync_gateway/bychannel"
+		subChanges.Properties[subChangesChannels] = strings.Join(client.options.Channels, ",")
+	}
+	if !client.sender.Send(subChanges) {
+		return fmt.Errorf("Unable to send subChanges")
+	}
+	if errorCode := subChanges.Response().Properties["Error-Code"]; errorCode != "" {
+		body, _ := subChanges.Response().Body()
+		return fmt.Errorf("subChanges failed with error %s: %s", errorCode, body)
+	}
+	return nil
+}
+
+// Asks for every rev in a batch of changes, except for the client's own docs.
+func (client *simulatedClient) handleChanges(rq *blip.Message) {
+	if rq.NoReply() {
+		return // The client is caught up
+	}
+	var changes [][]interface{}
+	if err := rq.ReadJSONBody(&changes); err != nil {
+		base.Warnf(base.KeyAll, "Simulated client received invalid changes: %v", err)
+		return
+	}
+	answer := make([]interface{}, len(changes))
+	for i, change := range changes {
+		answer[i] = []interface{}{}
+		if len(change) > 1 {
+			if docID, ok := change[1].(string); ok && strings.HasPrefix(docID, client.docIDPrefix) {
+				answer[i] = 0
+			}
+		}
+	}
+	rq.Response().SetJSONBody(answer)
+}
+
+// Records the latency of a pulled rev that was pushed by a simulated client.
+func (client *simulatedClient) handleRev(rq *blip.Message) {
+	var doc simulatedDoc
+	err := rq.ReadJSONBody(&doc)
+
+	client.stats.lock.Lock()
+	defer client.stats.lock.Unlock()
+	client.stats.pulledRevs++
+	if err == nil && !doc.PushedAt.IsZero() {
+		client.stats.pullLatencies = append(client.stats.pullLatencies, time.Since(doc.PushedAt))
+	}
+}
+
+// Pushes new docs at the client's write rate until stop is closed, then waits for the responses to the revs sent.
+func (client *simulatedClient) push(stop <-chan struct{}) {
+	if client.options.WritesPerSec <= 0 {
+		<-stop
+		return
+	}
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / client.options.WritesPerSec))
+	defer ticker.Stop()
+
+	var pending sync.WaitGroup
+	defer pending.Wait()
+	data := strings.Repeat("x", client.options.DocSize)
+	for n := 0; ; n++ {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		doc := simulatedDoc{PushedAt: time.Now(), Data: data}
+		if len(client.options.Channels) > 0 {
+			doc.Channels = []string{client.options.Channels[n%len(client.options.Channels)]}
+		}
+		body, err := json.Marshal(doc)
+		if err != nil {
+			base.Warnf(base.KeyAll, "Simulated client unable to encode doc: %v", err)
+			return
+		}
+
+		rq := blip.NewRequest()
+		rq.SetProfile(messageRev)
+		rq.SetCompressed(true)
+		rq.Properties[revMessageId] = fmt.Sprintf("%s%d", client.docIDPrefix, n)
+		rq.Properties[revMessageRev] = fmt.Sprintf("1-%x", md5.Sum(body))
+		rq.SetBody(body)
+		sent := time.Now()
+		if !client.sender.Send(rq) {
+			client.recordPush(0, false)
+			continue
+		}
+		pending.Add(1)
+		go func() {
+			defer pending.Done()
+			_, failed := rq.Response().Properties["Error-Code"]
+			client.recordPush(time.Since(sent), !failed)
+		}()
+	}
+}
+
+func (client *simulatedClient) recordPush(latency time.Duration, ok bool) {
+	client.stats.lock.Lock()
+	defer client.stats.lock.Unlock()
+	if ok {
+		client.stats.pushLatencies = append(client.stats.pushLatencies, latency)
+	} else {
+		client.stats.pushErrors++
+	}
+}
+
+// Formats the median, 95th and 99th percentiles and maximum of a set of latencies.
+func formatLatencies(latencies []time.Duration) string {
+	if len(latencies) == 0 {
+		return "n/a"
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	percentile := func(p float64) time.Duration {
+		return latencies[int(p*float64(len(latencies)-1))]
+	}
+	return fmt.Sprintf("p50 %v, p95 %v, p99 %v, max %v", percentile(0.5), percentile(0.95), percentile(0.99), latencies[len(latencies)-1])
+}
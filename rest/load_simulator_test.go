@@ -0,0 +1,52 @@
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSimulateLoad(t *testing.T) {
+	rt := RestTester{noAdminParty: true}
+	defer rt.Close()
+	assertStatus(t, rt.SendAdminRequest("PUT", "/db/_user/sim", `{"password":"letmein", "admin_channels":["a", "b"]}`), http.StatusCreated)
+
+	// BLIP needs a real listener for the websocket upgrade
+	srv := httptest.NewServer(rt.TestPublicHandler())
+	defer srv.Close()
+
+	options := LoadSimulatorOptions{
+		TargetURL:    strings.Replace(srv.URL, "http://", "http://sim:letmein@", 1) + "/db",
+		Clients:      2,
+		Duration:     time.Second,
+		DocSize:      100,
+		Channels:     []string{"a", "b"},
+		WritesPerSec: 20,
+	}
+	var output bytes.Buffer
+	assert.Equal(t, 0, SimulateLoad(options, &output))
+	assert.Contains(t, output.String(), "Clients: 2 connected, 0 failed to connect")
+	assert.Contains(t, output.String(), ", 0 errors")
+	assert.NotContains(t, output.String(), "letmein")
+
+	response := rt.SendAdminRequest("GET", "/db/_changes?filter=sync_gateway/bychannel&channels=b", "")
+	assertStatus(t, response, http.StatusOK)
+	var changes struct {
+		Results []interface{} `json:"results"`
+	}
+	assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &changes))
+	assert.True(t, len(changes.Results) > 0)
+
+	// Connection failures are reported
+	options.TargetURL = srv.URL + "/db"
+	options.Duration = 0
+	output.Reset()
+	assert.Equal(t, 1, SimulateLoad(options, &output))
+	assert.Contains(t, output.String(), "None of the simulated clients could connect")
+}
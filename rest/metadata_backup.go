@@ -0,0 +1,133 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"sync/atomic"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbase/sync_gateway/db"
+)
+
+// GET /db/_metadata_backup streams a backup of the database's users, roles, local docs, sequence counter and other
+// Sync Gateway metadata, without the app's documents.  ?sessions=true includes users' login sessions.
+func (h *handler) handleMetadataBackup() error {
+	h.assertAdminOnly()
+	options := db.MetadataBackupOptions{IncludeSessions: h.getBoolQuery("sessions")}
+
+	h.setHeader("Content-Type", "application/x-ndjson")
+	if _, err := h.db.BackupMetadata(h.response, options); err != nil {
+		// The response may already have been started, so the error can only be logged
+		base.Warnf(base.KeyAll, "Error writing metadata backup of db %q: %v", base.MD(h.db.Name), err)
+	}
+	return nil
+}
+
+// POST /db/_metadata_restore restores a metadata backup into the database, typically one with a fresh bucket.  The
+// database must be offline.
+func (h *handler) handleMetadataRestore() error {
+	h.assertAdminOnly()
+	if atomic.LoadUint32(&h.db.State) != db.DBOffline {
+		return base.CodedHTTPErrorf(http.StatusServiceUnavailable, base.ErrorCodeDatabaseNotOffline, "Database must be _offline before calling /_metadata_restore")
+	}
+
+	counts, err := h.db.RestoreMetadata(h.requestBody)
+	if err != nil {
+		return base.HTTPErrorf(http.StatusBadRequest, "Unable to restore metadata: %v", err)
+	}
+	h.setAuditSummary(nil, counts)
+	h.writeJSON(db.Body{"restored": counts})
+	return nil
+}
+
+// Saves a metadata backup of a database on the Sync Gateway listening on adminAddr to outputPath.  Used by the
+// -backup-metadata command line flag; returns the process exit code.
+func BackupMetadataFromServer(adminAddr string, dbName string, outputPath string, includeSessions bool, w io.Writer) int {
+	if outputPath == "" {
+		fmt.Fprintf(w, "-metadata-file must be set to the path to back up to\n")
+		return 1
+	}
+	backupURL := adminAPIURL(adminAddr, "/"+url.PathEscape(dbName)+"/_metadata_backup")
+	if includeSessions {
+		backupURL += "?sessions=true"
+	}
+	resp, err := http.Get(backupURL)
+	if err != nil {
+		fmt.Fprintf(w, "Error connecting to the admin API at %s: %v\n", base.RedactBasicAuthURL(adminAddr), err)
+		return 1
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(resp.Body)
+		fmt.Fprintf(w, "Error backing up metadata: %s %s\n", resp.Status, message)
+		return 1
+	}
+
+	// The backup includes password hashes, so it's only readable by its owner
+	f, err := os.OpenFile(outputPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		fmt.Fprintf(w, "Error creating %s: %v\n", outputPath, err)
+		return 1
+	}
+	_, err = io.Copy(f, resp.Body)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fmt.Fprintf(w, "Error writing %s: %v\n", outputPath, err)
+		return 1
+	}
+	fmt.Fprintf(w, "Metadata backup saved to %s\n", outputPath)
+	return 0
+}
+
+// Restores the metadata backup in inputPath into a database on the Sync Gateway listening on adminAddr.  The
+// database must be offline.  Used by the -restore-metadata command line flag; returns the process exit code.
+func RestoreMetadataToServer(adminAddr string, dbName string, inputPath string, w io.Writer) int {
+	if inputPath == "" {
+		fmt.Fprintf(w, "-metadata-file must be set to the path to restore from\n")
+		return 1
+	}
+	input, err := os.Open(inputPath)
+	if err != nil {
+		fmt.Fprintf(w, "Error opening %s: %v\n", inputPath, err)
+		return 1
+	}
+	defer input.Close()
+
+	resp, err := http.Post(adminAPIURL(adminAddr, "/"+url.PathEscape(dbName)+"/_metadata_restore"), "application/x-ndjson", input)
+	if err != nil {
+		fmt.Fprintf(w, "Error connecting to the admin API at %s: %v\n", base.RedactBasicAuthURL(adminAddr), err)
+		return 1
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintf(w, "Error restoring metadata: %s %s\n", resp.Status, body)
+		return 1
+	}
+
+	var result struct {
+		Restored map[string]int `json:"restored"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		fmt.Fprintf(w, "Error reading restore result: %v\n", err)
+		return 1
+	}
+	categories := make([]string, 0, len(result.Restored))
+	for category := range result.Restored {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+	for _, category := range categories {
+		fmt.Fprintf(w, "%s: %d\n", category, result.Restored[category])
+	}
+	fmt.Fprintf(w, "Metadata restored into %s\n", dbName)
+	return 0
+}
@@ -0,0 +1,94 @@
+package rest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/couchbase/sync_gateway/auth"
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbase/sync_gateway/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetadataBackupRestore(t *testing.T) {
+	rt := RestTester{}
+	defer rt.Close()
+
+	assertStatus(t, rt.SendAdminRequest("PUT", "/db/_role/staff", `{"admin_channels":["s"]}`), http.StatusCreated)
+	assertStatus(t, rt.SendAdminRequest("PUT", "/db/_user/alice", `{"password":"letmein", "admin_roles":["staff"]}`), http.StatusCreated)
+	assertStatus(t, rt.SendAdminRequest("PUT", "/db/_local/checkpoint", `{"seq":12}`), http.StatusCreated)
+	assertStatus(t, rt.SendAdminRequest("PUT", "/db/doc1", `{"value":1}`), http.StatusCreated)
+	response := rt.SendAdminRequest("POST", "/db/_session", `{"name":"alice"}`)
+	assertStatus(t, response, http.StatusOK)
+	var session db.Body
+	assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &session))
+	sessionKey := auth.SessionKeyPrefix + session["session_id"].(string)
+
+	response = rt.SendAdminRequest("GET", "/db/_metadata_backup?sessions=true", "")
+	assertStatus(t, response, http.StatusOK)
+	backup := response.Body.Bytes()
+
+	backedUp := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(backup))
+	for scanner.Scan() {
+		var doc db.MetadataBackupDoc
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &doc))
+		backedUp[doc.Key] = doc.Category
+	}
+	assert.Equal(t, db.MetadataUsers, backedUp[auth.UserKeyPrefix+"alice"])
+	assert.Equal(t, db.MetadataRoles, backedUp[auth.RoleKeyPrefix+"staff"])
+	assert.Equal(t, db.MetadataLocalDocs, backedUp["_sync:local:checkpoint"])
+	assert.Equal(t, db.MetadataSessions, backedUp[sessionKey])
+	assert.Equal(t, db.MetadataSequence, backedUp[db.SyncSeqKey])
+	assert.NotContains(t, backedUp, "doc1")
+
+	// Sessions are only backed up when requested
+	response = rt.SendAdminRequest("GET", "/db/_metadata_backup", "")
+	assertStatus(t, response, http.StatusOK)
+	assert.NotContains(t, response.Body.String(), sessionKey)
+
+	// Restoring requires the database to be offline
+	assertStatus(t, rt.SendAdminRequest("POST", "/db/_metadata_restore", string(backup)), http.StatusServiceUnavailable)
+
+	bucket := rt.Bucket()
+	assert.NoError(t, bucket.Delete(auth.UserKeyPrefix+"alice"))
+	assert.NoError(t, bucket.Delete(auth.RoleKeyPrefix+"staff"))
+	assert.NoError(t, bucket.Delete("_sync:local:checkpoint"))
+	assert.NoError(t, bucket.Delete(sessionKey))
+	seq, err := bucket.Incr(db.SyncSeqKey, 10, 0, 0)
+	assert.NoError(t, err)
+
+	assertStatus(t, rt.SendAdminRequest("POST", "/db/_offline", ""), http.StatusOK)
+	response = rt.SendAdminRequest("POST", "/db/_metadata_restore", string(backup))
+	assertStatus(t, response, http.StatusOK)
+	var result struct {
+		Restored map[string]int `json:"restored"`
+	}
+	assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &result))
+	assert.Equal(t, 1, result.Restored[db.MetadataRoles])
+	assert.Equal(t, 1, result.Restored[db.MetadataLocalDocs])
+	assert.Equal(t, 1, result.Restored[db.MetadataSessions])
+
+	user, err := rt.GetDatabase().Authenticator().GetUser("alice")
+	assert.NoError(t, err)
+	if assert.NotNil(t, user) {
+		assert.True(t, user.Authenticate("letmein"))
+		assert.Equal(t, base.SetOf("staff"), user.ExplicitRoles().AsSet())
+	}
+	var checkpoint db.Body
+	_, err = bucket.Get("_sync:local:checkpoint", &checkpoint)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(12), checkpoint["seq"])
+	_, _, err = bucket.GetRaw(sessionKey)
+	assert.NoError(t, err)
+
+	// The sequence counter isn't moved backwards
+	restoredSeq, err := bucket.Incr(db.SyncSeqKey, 0, 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, seq, restoredSeq)
+
+	assertStatus(t, rt.SendAdminRequest("POST", "/db/_metadata_restore", `{"category":"users","key":"doc1"}`), http.StatusBadRequest)
+}
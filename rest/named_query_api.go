@@ -0,0 +1,73 @@
+package rest
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbase/sync_gateway/db"
+)
+
+// Validates a database's named query configs, and converts them to the database's NamedQueries.
+func makeNamedQueries(configs map[string]*NamedQueryConfig) (map[string]*db.NamedQuery, error) {
+	if len(configs) == 0 {
+		return nil, nil
+	}
+	queries := make(map[string]*db.NamedQuery, len(configs))
+	for name, config := range configs {
+		if name == "" || strings.Contains(name, "/") {
+			return nil, fmt.Errorf("queries: invalid query name %q", name)
+		}
+		if config == nil || strings.TrimSpace(config.Select) == "" {
+			return nil, fmt.Errorf("queries.%s: select must be given", name)
+		}
+		if config.Limit < 0 {
+			return nil, fmt.Errorf("queries.%s: limit can't be negative", name)
+		}
+		params := make(map[string]bool, len(config.Parameters))
+		for _, param := range config.Parameters {
+			if !db.IsValidNamedQueryParameter(param) {
+				return nil, fmt.Errorf("queries.%s: invalid parameter name %q", name, param)
+			}
+			if params[param] {
+				return nil, fmt.Errorf("queries.%s: duplicate parameter %q", name, param)
+			}
+			params[param] = true
+		}
+		queries[name] = &db.NamedQuery{
+			Select:     config.Select,
+			Where:      config.Where,
+			OrderBy:    config.OrderBy,
+			Limit:      config.Limit,
+			Parameters: config.Parameters,
+		}
+	}
+	return queries, nil
+}
+
+// GET or POST /db/_query/{name} runs a named query.  Parameter values are given in the query string (as strings), or
+// in a JSON object in the body of a POST.
+func (h *handler) handleNamedQuery() error {
+	name := h.PathVar("name")
+	params := map[string]interface{}{}
+	if h.rq.Method == "POST" {
+		if err := h.readJSONInto(&params); err != nil {
+			return err
+		}
+	} else {
+		for param, values := range h.rq.URL.Query() {
+			if len(values) > 1 {
+				return base.HTTPErrorf(http.StatusBadRequest, "Query parameter %q given more than once", param)
+			}
+			params[param] = values[0]
+		}
+	}
+
+	rows, err := h.db.QueryNamed(name, params)
+	if err != nil {
+		return err
+	}
+	h.writeJSON(db.Body{"rows": rows})
+	return nil
+}
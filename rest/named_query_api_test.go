@@ -0,0 +1,50 @@
+package rest
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbase/sync_gateway/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMakeNamedQueries(t *testing.T) {
+	queries, err := makeNamedQueries(map[string]*NamedQueryConfig{
+		"posts": {Select: "title", Where: "author = $author", Limit: 5, Parameters: []string{"author"}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]*db.NamedQuery{
+		"posts": {Select: "title", Where: "author = $author", Limit: 5, Parameters: []string{"author"}},
+	}, queries)
+
+	for _, configs := range []map[string]*NamedQueryConfig{
+		{"": {Select: "title"}},
+		{"a/b": {Select: "title"}},
+		{"posts": nil},
+		{"posts": {Select: " "}},
+		{"posts": {Select: "title", Limit: -1}},
+		{"posts": {Select: "title", Parameters: []string{"_userChannels"}}},
+		{"posts": {Select: "title", Parameters: []string{"author", "author"}}},
+	} {
+		_, err := makeNamedQueries(configs)
+		assert.Error(t, err, "%+v", configs)
+	}
+}
+
+func TestNamedQueryAPI(t *testing.T) {
+	rt := RestTester{DatabaseConfig: &DbConfig{Queries: map[string]*NamedQueryConfig{
+		"posts": {Select: "title", Where: "author = $author", Parameters: []string{"author"}},
+	}}}
+	defer rt.Close()
+
+	assertStatus(t, rt.SendRequest("GET", "/db/_query/missing", ""), http.StatusNotFound)
+	assertStatus(t, rt.SendRequest("GET", "/db/_query/posts?author=a&author=b", ""), http.StatusBadRequest)
+	if base.UnitTestUrlIsWalrus() {
+		assertStatus(t, rt.SendRequest("GET", "/db/_query/posts?author=alice", ""), http.StatusNotImplemented)
+		return
+	}
+	assertStatus(t, rt.SendRequest("GET", "/db/_query/posts", ""), http.StatusBadRequest)
+	assertStatus(t, rt.SendRequest("POST", "/db/_query/posts", `{"author":"alice", "extra":1}`), http.StatusBadRequest)
+	assertStatus(t, rt.SendRequest("POST", "/db/_query/posts", `{"author":"alice"}`), http.StatusOK)
+}
@@ -0,0 +1,380 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbase/sync_gateway/db"
+	"github.com/gorilla/mux"
+)
+
+// Kinds of query parameter value
+const (
+	apiParamString  = "string"
+	apiParamInteger = "integer" // A non-negative integer
+	apiParamBoolean = "boolean" // "true" or "false"
+	apiParamJSON    = "json"    // A JSON value, such as an array of doc IDs
+)
+
+// A query parameter of an endpoint.
+type apiParam struct {
+	name        string
+	kind        string
+	enum        []string // The values allowed, if limited
+	description string
+}
+
+// Checks a value of the parameter, returning an error describing what's wrong with it.
+func (p apiParam) validate(value string) error {
+	switch p.kind {
+	case apiParamInteger:
+		if _, err := strconv.ParseUint(value, 10, 64); err != nil {
+			return fmt.Errorf("must be a non-negative integer")
+		}
+	case apiParamBoolean:
+		if value != "true" && value != "false" {
+			return fmt.Errorf("must be true or false")
+		}
+	case apiParamJSON:
+		if !json.Valid([]byte(value)) {
+			return fmt.Errorf("must be JSON")
+		}
+	}
+	if len(p.enum) > 0 && !base.StringSliceContains(p.enum, value) {
+		return fmt.Errorf("must be one of %s", strings.Join(p.enum, ", "))
+	}
+	return nil
+}
+
+// Returns the parameter's OpenAPI parameter object.
+func (p apiParam) openAPI() map[string]interface{} {
+	schema := map[string]interface{}{"type": p.kind}
+	switch p.kind {
+	case apiParamInteger:
+		schema["minimum"] = 0
+	case apiParamJSON:
+		schema["type"] = "string"
+		schema["format"] = "json"
+	}
+	if len(p.enum) > 0 {
+		schema["enum"] = p.enum
+	}
+	param := map[string]interface{}{"name": p.name, "in": "query", "schema": schema}
+	if p.description != "" {
+		param["description"] = p.description
+	}
+	return param
+}
+
+// Query parameters shared by the endpoints that return revisions
+var revisionParams = []apiParam{
+	{name: "revs", kind: apiParamBoolean, description: "Include the revision history"},
+	{name: "revs_limit", kind: apiParamInteger, description: "Max length of the revision history"},
+	{name: "attachments", kind: apiParamBoolean, description: "Include attachment bodies"},
+	{name: "show_exp", kind: apiParamBoolean, description: "Include the document expiry"},
+}
+
+// Query parameters of each endpoint, by path as written in the spec (see specPath).  They apply to all of the path's
+// methods.  Endpoints not listed here, and parameters not listed for an endpoint, aren't validated.
+var apiQueryParams = map[string][]apiParam{
+	"/{db}/_changes": {
+		{name: "feed", kind: apiParamString, enum: []string{"normal", "longpoll", "continuous", "websocket"}},
+		{name: "since", kind: apiParamString, description: "Sequence to send changes after"},
+		{name: "limit", kind: apiParamInteger},
+		{name: "style", kind: apiParamString, enum: []string{"main_only", "all_docs"}},
+		{name: "active_only", kind: apiParamBoolean},
+		{name: "include_docs", kind: apiParamBoolean},
+		{name: "filter", kind: apiParamString, enum: []string{"sync_gateway/bychannel", "_doc_ids"}},
+		{name: "channels", kind: apiParamString, description: "Comma-separated channels, for the bychannel filter"},
+		{name: "doc_ids", kind: apiParamString, description: "Doc IDs, as a JSON array or comma-separated, for the _doc_ids filter"},
+		{name: "heartbeat", kind: apiParamInteger, description: "Milliseconds between heartbeats"},
+		{name: "timeout", kind: apiParamInteger, description: "Milliseconds to wait for changes"},
+	},
+	"/{db}/_all_docs": {
+		{name: "include_docs", kind: apiParamBoolean},
+		{name: "channels", kind: apiParamBoolean, description: "Include each doc's channels"},
+		{name: "access", kind: apiParamBoolean, description: "Include the access each doc grants"},
+		{name: "revs", kind: apiParamBoolean},
+		{name: "update_seq", kind: apiParamBoolean},
+		{name: "keys", kind: apiParamJSON, description: "JSON array of the doc IDs to return"},
+		{name: "startkey", kind: apiParamString},
+		{name: "endkey", kind: apiParamString},
+		{name: "limit", kind: apiParamInteger},
+	},
+	"/{db}/_bulk_get": revisionParams,
+	"/{db}/_conflicts": {
+		{name: "startkey", kind: apiParamString},
+		{name: "limit", kind: apiParamInteger},
+	},
+	"/{db}/{docid}": append([]apiParam{
+		{name: "rev", kind: apiParamString},
+		{name: "open_revs", kind: apiParamString, description: `"all", or a JSON array of revision IDs`},
+		{name: "revs_from", kind: apiParamJSON, description: "JSON array of revision IDs the client has"},
+		{name: "atts_since", kind: apiParamJSON, description: "JSON array of revision IDs whose attachments the client has"},
+		{name: "new_edits", kind: apiParamBoolean},
+	}, revisionParams...),
+	"/{db}/{docid}/{attach}": {
+		{name: "rev", kind: apiParamString},
+		{name: "content_encoding", kind: apiParamBoolean, description: "Send the attachment with its stored encoding"},
+	},
+	"/{db}/_local/{docid}": {
+		{name: "rev", kind: apiParamString},
+	},
+	"/{db}/_dumpchannel/{channel}": {
+		{name: "since", kind: apiParamInteger},
+	},
+	"/{db}/_resolve_conflicts": {
+		{name: "policy", kind: apiParamString, enum: []string{string(db.ConflictResolutionWinner), string(db.ConflictResolutionDelete)}},
+		{name: "preview", kind: apiParamBoolean},
+	},
+	"/_post_upgrade": {
+		{name: "preview", kind: apiParamBoolean},
+	},
+	"/{db}/_repair_sequences": {
+		{name: "margin", kind: apiParamInteger, description: "Extra sequences to skip"},
+		{name: "preview", kind: apiParamBoolean},
+	},
+	"/{db}/_clean_metadata": {
+		{name: "checkpoint_max_age_days", kind: apiParamInteger},
+		{name: "preview", kind: apiParamBoolean},
+	},
+}
+
+// Path variables that some routes name differently from others with the same path, mapped to the common name
+var pathVarAliases = map[string]string{
+	"newdb":    "db",
+	"targetdb": "db",
+}
+
+// A variable in a route's path template.
+type pathVar struct {
+	name    string
+	pattern string // The regexp the variable must match, if any
+	raw     string // The variable as written in the template
+}
+
+// Converts a route's path template, e.g. "/{db:[^_/][^/]*}/_changes", into its path in the spec, "/{db}/_changes",
+// returning the variables it contains.
+func specPath(template string) (string, []pathVar) {
+	var path strings.Builder
+	var vars []pathVar
+	for {
+		start := strings.IndexByte(template, '{')
+		if start < 0 {
+			path.WriteString(template)
+			return path.String(), vars
+		}
+		path.WriteString(template[:start])
+
+		// Find the matching brace, as a variable's pattern may contain braces
+		depth, end := 0, -1
+		for i := start; i < len(template) && end < 0; i++ {
+			switch template[i] {
+			case '{':
+				depth++
+			case '}':
+				if depth--; depth == 0 {
+					end = i
+				}
+			}
+		}
+		if end < 0 {
+			path.WriteString(template[start:])
+			return path.String(), vars
+		}
+		v := pathVar{name: template[start+1 : end], raw: template[start : end+1]}
+		if i := strings.IndexByte(v.name, ':'); i >= 0 {
+			v.name, v.pattern = v.name[:i], v.name[i+1:]
+		}
+		if alias, ok := pathVarAliases[v.name]; ok {
+			v.name = alias
+		}
+		vars = append(vars, v)
+		path.WriteString("{" + v.name + "}")
+		template = template[end+1:]
+	}
+}
+
+// Returns an OpenAPI 3.0 description of the endpoints in a router, generated from its routes so that it's always
+// current.
+func openAPISpec(router *mux.Router, title string) (map[string]interface{}, error) {
+	paths := map[string]map[string]interface{}{}
+	err := router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		path, vars := specPath(template)
+		methods := routeMethods(route, vars, template)
+		if len(methods) == 0 || len(methods) == len(apiMethods) {
+			return nil // A subrouter's prefix, or a route for all methods such as the admin UI
+		}
+		pathItem := paths[path]
+		if pathItem == nil {
+			pathItem = map[string]interface{}{}
+			paths[path] = pathItem
+		}
+		for _, method := range methods {
+			method = strings.ToLower(method)
+			if _, found := pathItem[method]; !found { // The first route for a method is the one that's matched
+				pathItem[method] = openAPIOperation(path, vars)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	spec := map[string]interface{}{
+		"openapi": "3.0.0",
+		"info": map[string]interface{}{
+			"title":   title,
+			"version": base.VersionNumber,
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": map[string]interface{}{
+				"ErrorResponse": map[string]interface{}{
+					"type":     "object",
+					"required": []string{"error", "reason", "code", "retryable"},
+					"properties": map[string]interface{}{
+						"error":          map[string]interface{}{"type": "string"},
+						"reason":         map[string]interface{}{"type": "string"},
+						"code":           map[string]interface{}{"type": "string"},
+						"retryable":      map[string]interface{}{"type": "boolean"},
+						"correlation_id": map[string]interface{}{"type": "string"},
+						"details":        map[string]interface{}{},
+					},
+				},
+			},
+		},
+	}
+	return spec, nil
+}
+
+// The HTTP methods that routes are checked for
+var apiMethods = []string{"GET", "HEAD", "POST", "PUT", "DELETE", "PATCH", "OPTIONS"}
+
+// Returns the methods a route handles.  The router's version of mux doesn't expose a route's methods, so they're found
+// by matching the route against a request for each method, to a path made from its template.
+func routeMethods(route *mux.Route, vars []pathVar, template string) []string {
+	path := template
+	for _, v := range vars {
+		path = strings.Replace(path, v.raw, samplePathValue(v.pattern), 1)
+	}
+	var methods []string
+	for _, method := range apiMethods {
+		rq, err := http.NewRequest(method, "http://localhost"+path, nil)
+		if err != nil {
+			return nil
+		}
+		var match mux.RouteMatch
+		if route.Match(rq, &match) {
+			methods = append(methods, method)
+		}
+	}
+	return methods
+}
+
+// Returns a value for a path variable that matches its pattern.
+func samplePathValue(pattern string) string {
+	for _, value := range []string{"x", "1", "_x"} {
+		if pattern == "" {
+			return value
+		}
+		if matched, _ := regexp.MatchString("^(?:"+pattern+")$", value); matched {
+			return value
+		}
+	}
+	return "x"
+}
+
+func openAPIOperation(path string, vars []pathVar) map[string]interface{} {
+	var params []interface{}
+	for _, v := range vars {
+		schema := map[string]interface{}{"type": "string"}
+		if v.pattern != "" {
+			schema["pattern"] = "^" + v.pattern + "$"
+		}
+		params = append(params, map[string]interface{}{"name": v.name, "in": "path", "required": true, "schema": schema})
+	}
+	queryParams := append([]apiParam(nil), apiQueryParams[path]...)
+	sort.Slice(queryParams, func(i, j int) bool { return queryParams[i].name < queryParams[j].name })
+	for _, p := range queryParams {
+		params = append(params, p.openAPI())
+	}
+
+	operation := map[string]interface{}{
+		"responses": map[string]interface{}{
+			"2XX": map[string]interface{}{"description": "Success"},
+			"default": map[string]interface{}{
+				"description": "Error",
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{
+						"schema": map[string]interface{}{"$ref": "#/components/schemas/ErrorResponse"},
+					},
+				},
+			},
+		},
+	}
+	if len(params) > 0 {
+		operation["parameters"] = params
+	}
+	return operation
+}
+
+// Checks the request's query parameters against the API spec, failing with a 400 error naming the first one with an
+// invalid value.  Parameters not in the spec aren't checked.  Only done if the config's validate_requests is set.
+func (h *handler) validateRequest() error {
+	if validate := h.server.config.ValidateRequests; validate == nil || !*validate {
+		return nil
+	}
+	route := mux.CurrentRoute(h.rq)
+	if route == nil {
+		return nil
+	}
+	template, err := route.GetPathTemplate()
+	if err != nil {
+		return nil
+	}
+	path, _ := specPath(template)
+	values := h.getQueryValues()
+	for _, param := range apiQueryParams[path] {
+		for _, value := range values[param.name] {
+			if value == "" {
+				continue // Handlers treat an empty parameter as missing
+			}
+			if err := param.validate(value); err != nil {
+				return base.CodedHTTPErrorf(http.StatusBadRequest, base.ErrorCodeInvalidParameter, "Invalid %s parameter %q: %v", param.name, value, err)
+			}
+		}
+	}
+	return nil
+}
+
+// GET /_openapi returns an OpenAPI description of the admin API, or of the public API if ?api=public.
+func (h *handler) handleOpenAPI() error {
+	h.assertAdminOnly()
+	var spec map[string]interface{}
+	var err error
+	switch api := h.getQuery("api"); api {
+	case "", "admin":
+		spec, err = openAPISpec(CreateAdminRouter(h.server), base.ProductName+" Admin API")
+	case "public":
+		spec, err = openAPISpec(createPublicRouter(h.server), base.ProductName+" REST API")
+	default:
+		return base.HTTPErrorf(http.StatusBadRequest, "Unknown api %q; must be admin or public", api)
+	}
+	if err != nil {
+		return err
+	}
+	h.writeJSON(spec)
+	return nil
+}
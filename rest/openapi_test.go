@@ -0,0 +1,95 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpecPath(t *testing.T) {
+	path, vars := specPath("/{db:" + dbRegex + "}/{docid:" + docRegex + "}/{attach}")
+	assert.Equal(t, "/{db}/{docid}/{attach}", path)
+	assert.Equal(t, []pathVar{
+		{name: "db", pattern: dbRegex, raw: "{db:" + dbRegex + "}"},
+		{name: "docid", pattern: docRegex, raw: "{docid:" + docRegex + "}"},
+		{name: "attach", raw: "{attach}"},
+	}, vars)
+
+	path, vars = specPath("/{targetdb:[a-z]{2,}}/")
+	assert.Equal(t, "/{db}/", path)
+	assert.Equal(t, []pathVar{{name: "db", pattern: "[a-z]{2,}", raw: "{targetdb:[a-z]{2,}}"}}, vars)
+}
+
+func TestOpenAPISpec(t *testing.T) {
+	rt := RestTester{}
+	defer rt.Close()
+
+	var spec struct {
+		OpenAPI string `json:"openapi"`
+		Paths   map[string]map[string]struct {
+			Parameters []struct {
+				Name   string                 `json:"name"`
+				In     string                 `json:"in"`
+				Schema map[string]interface{} `json:"schema"`
+			} `json:"parameters"`
+		} `json:"paths"`
+	}
+	response := rt.SendAdminRequest("GET", "/_openapi", "")
+	assertStatus(t, response, http.StatusOK)
+	assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &spec))
+	assert.Equal(t, "3.0.0", spec.OpenAPI)
+
+	changes := spec.Paths["/{db}/_changes"]
+	assert.Contains(t, changes, "get")
+	assert.Contains(t, changes, "post")
+	assert.NotContains(t, changes, "put")
+	var feed map[string]interface{}
+	for _, param := range changes["get"].Parameters {
+		if param.Name == "feed" {
+			feed = param.Schema
+		} else if param.Name == "db" {
+			assert.Equal(t, "path", param.In)
+			assert.Equal(t, "^"+dbRegex+"$", param.Schema["pattern"])
+		}
+	}
+	assert.Equal(t, []interface{}{"normal", "longpoll", "continuous", "websocket"}, feed["enum"])
+	assert.Contains(t, spec.Paths, "/{db}/_raw/{docid}")
+	assert.Contains(t, spec.Paths["/{db}/{docid}"], "put")
+	assert.NotContains(t, spec.Paths, "/_admin/") // Not specific to any method
+
+	// The public API doesn't include the admin endpoints
+	response = rt.SendAdminRequest("GET", "/_openapi?api=public", "")
+	assertStatus(t, response, http.StatusOK)
+	assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &spec))
+	assert.Contains(t, spec.Paths, "/{db}/_changes")
+	assert.NotContains(t, spec.Paths, "/{db}/_raw/{docid}")
+
+	assertStatus(t, rt.SendAdminRequest("GET", "/_openapi?api=other", ""), http.StatusBadRequest)
+}
+
+func TestValidateRequests(t *testing.T) {
+	validate := true
+	rt := RestTester{ServerConfig: &ServerConfig{ValidateRequests: &validate}}
+	defer rt.Close()
+
+	assertStatus(t, rt.SendAdminRequest("PUT", "/db/doc1", `{"value":1}`), http.StatusCreated)
+	assertStatus(t, rt.SendAdminRequest("GET", "/db/doc1?revs=true&revs_limit=5&unknown=x", ""), http.StatusOK)
+	assertStatus(t, rt.SendAdminRequest("GET", "/db/_changes?feed=normal&limit=10&style=", ""), http.StatusOK)
+
+	for _, query := range []string{
+		"/db/doc1?revs_limit=-1",
+		"/db/doc1?revs=yes",
+		"/db/doc1?atts_since=[1-abc]",
+		"/db/_changes?feed=sometimes",
+		"/db/_changes?limit=ten",
+	} {
+		response := rt.SendAdminRequest("GET", query, "")
+		assertStatus(t, response, http.StatusBadRequest)
+		var body ErrorResponse
+		assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &body))
+		assert.Equal(t, base.ErrorCodeInvalidParameter, body.Code, "For %s", query)
+	}
+}
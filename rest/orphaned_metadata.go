@@ -0,0 +1,75 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbase/sync_gateway/db"
+)
+
+// POST /db/_clean_metadata deletes orphaned internal docs: sessions, email index entries and access audit trails of
+// users and roles that no longer exist, and, if ?checkpoint_max_age_days= is set, replication client checkpoints
+// that haven't been updated for that many days.  ?preview=true only reports them.
+func (h *handler) handleCleanMetadata() error {
+	h.assertAdminOnly()
+	options := db.OrphanedMetadataOptions{
+		CheckpointMaxAge: time.Duration(h.getIntQuery("checkpoint_max_age_days", 0)) * 24 * time.Hour,
+		Preview:          h.getBoolQuery("preview"),
+	}
+	result, err := h.db.CleanOrphanedMetadata(options)
+	if err != nil {
+		return err
+	}
+	if !options.Preview {
+		h.setAuditSummary(nil, db.Body{"deleted": result.Deleted})
+	}
+	h.writeJSON(result)
+	return nil
+}
+
+// Finds, and unless previewing deletes, the orphaned metadata of a database on the Sync Gateway listening on
+// adminAddr.  Used by the -clean-metadata command line flag; returns the process exit code.
+func CleanMetadataOnServer(adminAddr string, dbName string, checkpointMaxAgeDays uint64, preview bool, w io.Writer) int {
+	cleanURL := adminAPIURL(adminAddr, "/"+url.PathEscape(dbName)+"/_clean_metadata") +
+		"?checkpoint_max_age_days=" + strconv.FormatUint(checkpointMaxAgeDays, 10) + "&preview=" + strconv.FormatBool(preview)
+	resp, err := http.Post(cleanURL, "application/json", nil)
+	if err != nil {
+		fmt.Fprintf(w, "Error connecting to the admin API at %s: %v\n", base.RedactBasicAuthURL(adminAddr), err)
+		return 1
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintf(w, "Error cleaning metadata: %s %s\n", resp.Status, body)
+		return 1
+	}
+
+	var result db.OrphanedMetadataResult
+	if err := json.Unmarshal(body, &result); err != nil {
+		fmt.Fprintf(w, "Error reading clean result: %v\n", err)
+		return 1
+	}
+	kinds := make([]string, 0, len(result.Orphaned))
+	for kind := range result.Orphaned {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	fmt.Fprintf(w, "Scanned %d internal docs\n", result.Scanned)
+	for _, kind := range kinds {
+		fmt.Fprintf(w, "Orphaned %s: %d\n", kind, len(result.Orphaned[kind]))
+	}
+	if preview {
+		fmt.Fprintln(w, "Preview only; nothing was deleted")
+	} else {
+		fmt.Fprintf(w, "Deleted %d docs\n", result.Deleted)
+	}
+	return 0
+}
@@ -0,0 +1,74 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/couchbase/sync_gateway/auth"
+	"github.com/couchbase/sync_gateway/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCleanOrphanedMetadata(t *testing.T) {
+	rt := RestTester{}
+	defer rt.Close()
+
+	assertStatus(t, rt.SendAdminRequest("PUT", "/db/_user/alice", `{"password":"letmein"}`), http.StatusCreated)
+	assertStatus(t, rt.SendAdminRequest("PUT", "/db/_user/bob", `{"password":"letmein"}`), http.StatusCreated)
+	sessionKey := func(name string) string {
+		response := rt.SendAdminRequest("POST", "/db/_session", `{"name":"`+name+`"}`)
+		assertStatus(t, response, http.StatusOK)
+		var session db.Body
+		assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &session))
+		return auth.SessionKeyPrefix + session["session_id"].(string)
+	}
+	aliceSession := sessionKey("alice")
+	bobSession := sessionKey("bob")
+	assertStatus(t, rt.SendAdminRequest("DELETE", "/db/_user/alice", ""), http.StatusOK)
+
+	// The update time of local docs is recorded, but not returned
+	assertStatus(t, rt.SendAdminRequest("PUT", "/db/_local/recent", `{"seq":1}`), http.StatusCreated)
+	response := rt.SendAdminRequest("GET", "/db/_local/recent", "")
+	assertStatus(t, response, http.StatusOK)
+	assert.NotContains(t, response.Body.String(), "_updated_at")
+	bucket := rt.Bucket()
+	assert.NoError(t, bucket.SetRaw("_sync:local:checkpoint/stale", 0, []byte(`{"_rev":"0-1","seq":1,"_updated_at":"2000-01-01T00:00:00Z"}`)))
+	assert.NoError(t, bucket.SetRaw("_sync:local:checkpoint/legacy", 0, []byte(`{"_rev":"0-1","seq":1}`)))
+	// Local docs that aren't checkpoints belong to the app, however old they are
+	assert.NoError(t, bucket.SetRaw("_sync:local:app", 0, []byte(`{"_rev":"0-1","seq":1,"_updated_at":"2000-01-01T00:00:00Z"}`)))
+
+	clean := func(query string) (result db.OrphanedMetadataResult) {
+		response := rt.SendAdminRequest("POST", "/db/_clean_metadata"+query, "")
+		assertStatus(t, response, http.StatusOK)
+		assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &result))
+		return result
+	}
+
+	result := clean("?preview=true&checkpoint_max_age_days=30")
+	assert.Equal(t, []string{aliceSession}, result.Orphaned[db.OrphanedSessions])
+	assert.Equal(t, []string{"_sync:local:checkpoint/stale"}, result.Orphaned[db.OrphanedCheckpoints])
+	assert.Equal(t, 0, result.Deleted)
+	_, err := bucket.GetRaw(aliceSession)
+	assert.NoError(t, err)
+
+	// Checkpoints are kept unless a max age is given
+	result = clean("")
+	assert.Equal(t, []string{aliceSession}, result.Orphaned[db.OrphanedSessions])
+	assert.Empty(t, result.Orphaned[db.OrphanedCheckpoints])
+	assert.Equal(t, 1, result.Deleted)
+	_, err = bucket.GetRaw(aliceSession)
+	assert.Error(t, err)
+	_, err = bucket.GetRaw(bobSession)
+	assert.NoError(t, err)
+
+	result = clean("?checkpoint_max_age_days=30")
+	assert.Empty(t, result.Orphaned[db.OrphanedSessions])
+	assert.Equal(t, 1, result.Deleted)
+	_, err = bucket.GetRaw("_sync:local:checkpoint/stale")
+	assert.Error(t, err)
+	_, err = bucket.GetRaw("_sync:local:checkpoint/legacy")
+	assert.NoError(t, err)
+	assertStatus(t, rt.SendAdminRequest("GET", "/db/_local/recent", ""), http.StatusOK)
+	assertStatus(t, rt.SendAdminRequest("GET", "/db/_local/app", ""), http.StatusOK)
+}
@@ -0,0 +1,137 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbase/sync_gateway/db"
+)
+
+// GET /db/_principals exports the database's users and roles, with their admin channel and role grants, for
+// importing into another database.  ?password_hashes=false leaves out the users' password hashes.
+func (h *handler) handleExportPrincipals() error {
+	h.assertAdminOnly()
+	export, err := h.db.ExportPrincipals(h.getOptBoolQuery("password_hashes", true))
+	if err != nil {
+		return err
+	}
+	h.writeJSON(export)
+	return nil
+}
+
+// POST /db/_principals imports users and roles exported by GET /db/_principals.  By default (?mode=merge), the
+// imported grants are added to those of existing principals; ?mode=overwrite replaces existing principals instead.
+func (h *handler) handleImportPrincipals() error {
+	h.assertAdminOnly()
+	var export db.PrincipalExport
+	if err := h.readJSONInto(&export); err != nil {
+		return err
+	}
+	mode := db.PrincipalImportMode(h.getQuery("mode"))
+	if mode == "" {
+		mode = db.PrincipalImportMerge
+	}
+	result, err := h.db.ImportPrincipals(&export, mode)
+	if err != nil {
+		return err
+	}
+	h.setAuditSummary(nil, result)
+	h.writeJSON(result)
+	return nil
+}
+
+// Saves the users and roles of a database on the Sync Gateway listening on adminAddr to outputPath.  Used by the
+// -export-principals command line flag; returns the process exit code.
+func ExportPrincipalsFromServer(adminAddr string, dbName string, outputPath string, w io.Writer) int {
+	if outputPath == "" {
+		fmt.Fprintf(w, "-principals-file must be set to the path to export to\n")
+		return 1
+	}
+	resp, err := http.Get(adminAPIURL(adminAddr, "/"+url.PathEscape(dbName)+"/_principals"))
+	if err != nil {
+		fmt.Fprintf(w, "Error connecting to the admin API at %s: %v\n", base.RedactBasicAuthURL(adminAddr), err)
+		return 1
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		fmt.Fprintf(w, "Error exporting principals: %s %s %v\n", resp.Status, body, err)
+		return 1
+	}
+
+	var export db.PrincipalExport
+	if err := json.Unmarshal(body, &export); err != nil {
+		fmt.Fprintf(w, "Error reading exported principals: %v\n", err)
+		return 1
+	}
+	output, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		fmt.Fprintf(w, "Error encoding exported principals: %v\n", err)
+		return 1
+	}
+	// The export includes password hashes, so it's only readable by its owner
+	if err := ioutil.WriteFile(outputPath, append(output, '\n'), 0600); err != nil {
+		fmt.Fprintf(w, "Error writing %s: %v\n", outputPath, err)
+		return 1
+	}
+	fmt.Fprintf(w, "Exported %d users and %d roles to %s\n", len(export.Users), len(export.Roles), outputPath)
+	return 0
+}
+
+// Imports the users and roles in inputPath, exported by -export-principals, into a database on the Sync Gateway
+// listening on adminAddr.  Used by the -import-principals command line flag; returns the process exit code.
+func ImportPrincipalsToServer(adminAddr string, dbName string, inputPath string, mode string, w io.Writer) int {
+	if inputPath == "" {
+		fmt.Fprintf(w, "-principals-file must be set to the path to import from\n")
+		return 1
+	}
+	input, err := os.Open(inputPath)
+	if err != nil {
+		fmt.Fprintf(w, "Error opening %s: %v\n", inputPath, err)
+		return 1
+	}
+	defer input.Close()
+
+	importURL := adminAPIURL(adminAddr, "/"+url.PathEscape(dbName)+"/_principals")
+	if mode != "" {
+		importURL += "?mode=" + url.QueryEscape(mode)
+	}
+	resp, err := http.Post(importURL, "application/json", input)
+	if err != nil {
+		fmt.Fprintf(w, "Error connecting to the admin API at %s: %v\n", base.RedactBasicAuthURL(adminAddr), err)
+		return 1
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintf(w, "Error importing principals: %s %s\n", resp.Status, body)
+		return 1
+	}
+
+	var result db.PrincipalImportResult
+	if err := json.Unmarshal(body, &result); err != nil {
+		fmt.Fprintf(w, "Error reading import result: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(w, "Created %d and updated %d principals\n", result.Created, result.Updated)
+	if len(result.Failed) > 0 {
+		names := make([]string, 0, len(result.Failed))
+		for name := range result.Failed {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Fprintf(w, "Failed to import %d principals:\n", len(names))
+		for _, name := range names {
+			fmt.Fprintf(w, "  %s: %s\n", name, result.Failed[name])
+		}
+		return 1
+	}
+	return 0
+}
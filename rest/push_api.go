@@ -0,0 +1,37 @@
+package rest
+
+// GET /db/_push_registration returns the devices the current user has registered for push notifications
+func (h *handler) handleGetPushRegistrations() error {
+	registrations, err := h.db.GetPushRegistrations()
+	if err != nil {
+		return err
+	}
+	h.writeJSON(map[string]interface{}{"registrations": registrations})
+	return nil
+}
+
+// PUT /db/_push_registration registers one of the current user's devices for push notifications, given its
+// platform ("apns" or "fcm") and token
+func (h *handler) handlePutPushRegistration() error {
+	var params struct {
+		Platform string `json:"platform"`
+		Token    string `json:"token"`
+	}
+	if err := h.readJSONInto(&params); err != nil {
+		return err
+	}
+	if err := h.db.RegisterPushToken(params.Platform, params.Token); err != nil {
+		return err
+	}
+	h.writeJSON(map[string]interface{}{"ok": true})
+	return nil
+}
+
+// DELETE /db/_push_registration/{token} unregisters one of the current user's devices
+func (h *handler) handleDeletePushRegistration() error {
+	if err := h.db.UnregisterPushToken(h.PathVar("token")); err != nil {
+		return err
+	}
+	h.writeJSON(map[string]interface{}{"ok": true})
+	return nil
+}
@@ -0,0 +1,39 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPushRegistrationAPI(t *testing.T) {
+	var rt RestTester
+	defer rt.Close()
+
+	assertStatus(t, rt.SendAdminRequest("PUT", "/db/_user/user1", `{"password":"letmein", "admin_channels":["alpha"]}`), http.StatusCreated)
+	send := func(method, resource, body string) *TestResponse {
+		return rt.SendUserRequestWithHeaders(method, resource, body, nil, "user1", "letmein")
+	}
+
+	assertStatus(t, rt.SendRequest("PUT", "/db/_push_registration", `{"platform":"fcm","token":"abc"}`), http.StatusForbidden)
+	assertStatus(t, send("PUT", "/db/_push_registration", `{"platform":"fcm"}`), http.StatusBadRequest)
+	assertStatus(t, send("PUT", "/db/_push_registration", `{"platform":"fcm","token":"abc"}`), http.StatusOK)
+
+	response := send("GET", "/db/_push_registration", "")
+	assertStatus(t, response, http.StatusOK)
+	var body struct {
+		Registrations []struct {
+			Platform string `json:"platform"`
+			Token    string `json:"token"`
+		} `json:"registrations"`
+	}
+	assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &body))
+	assert.Len(t, body.Registrations, 1)
+	assert.Equal(t, "fcm", body.Registrations[0].Platform)
+	assert.Equal(t, "abc", body.Registrations[0].Token)
+
+	assertStatus(t, send("DELETE", "/db/_push_registration/abc", ""), http.StatusOK)
+	assertStatus(t, send("DELETE", "/db/_push_registration/abc", ""), http.StatusNotFound)
+}
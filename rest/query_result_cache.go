@@ -0,0 +1,102 @@
+package rest
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/couchbase/sync_gateway/db"
+)
+
+// Converts a database's query result cache config to its options.
+func makeQueryResultCacheOptions(config *QueryResultCacheConfig) (options db.QueryResultCacheOptions, err error) {
+	if config == nil {
+		return options, nil
+	}
+	if config.TTLMs != nil {
+		if *config.TTLMs < 0 {
+			return options, fmt.Errorf("query_result_cache.ttl_ms: %d must not be negative", *config.TTLMs)
+		}
+		options.TTL = time.Duration(*config.TTLMs) * time.Millisecond
+	}
+	options.MaxEntries = db.DefaultQueryResultCacheMaxEntries
+	if config.MaxEntries != nil {
+		if *config.MaxEntries <= 0 {
+			return options, fmt.Errorf("query_result_cache.max_entries: %d must be greater than zero", *config.MaxEntries)
+		}
+		options.MaxEntries = *config.MaxEntries
+	}
+	return options, nil
+}
+
+// Buffers a handler's response, so that it can be cached before it's sent.
+type bufferedResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *bufferedResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *bufferedResponseWriter) Write(data []byte) (int, error) {
+	return w.body.Write(data)
+}
+
+// Wraps the method of a handler that queries the database, so that if the database has a query result cache, an
+// identical query (by URL and body) from a user with the same channels gets the cached response.  Queries by users
+// whose results the database's read transform changes aren't cached, as they depend on more than the channels.
+func cachedQueryMethod(method handlerMethod) handlerMethod {
+	return func(h *handler) error {
+		cache := h.db.QueryResultCache
+		if cache == nil || (h.rq.Method != "GET" && h.rq.Method != "POST") {
+			return method(h)
+		}
+		if h.db.Options.ReadTransform.AppliesTo(h.user) {
+			return method(h)
+		}
+
+		query := h.rq.Method + " " + h.rq.URL.RequestURI()
+		if h.rq.Method == "POST" {
+			body, err := h.readBody()
+			if err != nil {
+				return err
+			}
+			h.requestBody = ioutil.NopCloser(bytes.NewReader(body))
+			query += "\n" + string(body)
+		}
+		key := db.QueryResultCacheKey(h.user, query)
+
+		// Results are only reused if no document has changed since they were generated
+		lastSeq, err := h.db.LastSequence()
+		if err != nil {
+			return method(h)
+		}
+		if result := cache.Get(key, lastSeq); result != nil {
+			h.setHeader("Content-Type", "application/json")
+			h.setHeader("Content-Length", fmt.Sprintf("%d", len(result)))
+			h.response.Write(result)
+			return nil
+		}
+
+		response := h.response
+		buffered := &bufferedResponseWriter{ResponseWriter: response}
+		h.response = buffered
+		err = method(h)
+		h.response = response
+
+		if err == nil && (buffered.status == 0 || buffered.status == http.StatusOK) {
+			cache.Put(key, lastSeq, buffered.body.Bytes())
+		}
+		if buffered.status != 0 {
+			response.WriteHeader(buffered.status)
+		}
+		response.Write(buffered.body.Bytes())
+		return err
+	}
+}
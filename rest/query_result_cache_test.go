@@ -0,0 +1,115 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/couchbase/sync_gateway/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryResultCacheAllDocs(t *testing.T) {
+	ttl := 60000
+	rt := RestTester{
+		SyncFn:         `function(doc) {channel(doc.channels);}`,
+		DatabaseConfig: &DbConfig{QueryResultCache: &QueryResultCacheConfig{TTLMs: &ttl}},
+	}
+	defer rt.Close()
+
+	assertStatus(t, rt.SendAdminRequest("PUT", "/db/doc1", `{"channels":["red"]}`), http.StatusCreated)
+	assertStatus(t, rt.SendAdminRequest("PUT", "/db/doc2", `{"channels":["blue"]}`), http.StatusCreated)
+	assertStatus(t, rt.SendAdminRequest("PUT", "/db/_user/alice", `{"password":"letmein", "admin_channels":["red"]}`), http.StatusCreated)
+	assertStatus(t, rt.SendAdminRequest("PUT", "/db/_user/bob", `{"password":"letmein", "admin_channels":["red"]}`), http.StatusCreated)
+
+	rowIDs := func(response *TestResponse) []string {
+		assertStatus(t, response, http.StatusOK)
+		var result struct {
+			Rows []struct {
+				ID string `json:"id"`
+			} `json:"rows"`
+		}
+		assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &result))
+		var ids []string
+		for _, row := range result.Rows {
+			ids = append(ids, row.ID)
+		}
+		return ids
+	}
+
+	cache := rt.GetDatabase().QueryResultCache
+	lastSeq, err := rt.GetDatabase().LastSequence()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"doc1"}, rowIDs(rt.SendUserRequestWithHeaders("GET", "/db/_all_docs", "", nil, "alice", "letmein")))
+	assert.Equal(t, []string{"doc1", "doc2"}, rowIDs(rt.SendAdminRequest("GET", "/db/_all_docs", "")))
+
+	// Users with the same channels get the same cached result
+	bob, err := rt.GetDatabase().Authenticator().GetUser("bob")
+	assert.NoError(t, err)
+	assert.NotNil(t, cache.Get(db.QueryResultCacheKey(bob, "GET /db/_all_docs"), lastSeq))
+	assert.Equal(t, []string{"doc1"}, rowIDs(rt.SendUserRequestWithHeaders("GET", "/db/_all_docs", "", nil, "bob", "letmein")))
+
+	// A document change invalidates the cached results
+	assertStatus(t, rt.SendAdminRequest("PUT", "/db/doc3", `{"channels":["red"]}`), http.StatusCreated)
+	assert.Equal(t, []string{"doc1", "doc3"}, rowIDs(rt.SendUserRequestWithHeaders("GET", "/db/_all_docs", "", nil, "alice", "letmein")))
+	assert.Equal(t, []string{"doc1", "doc2", "doc3"}, rowIDs(rt.SendAdminRequest("GET", "/db/_all_docs", "")))
+
+	// POSTed keys are part of the query
+	assert.Equal(t, []string{"doc2"}, rowIDs(rt.SendAdminRequest("POST", "/db/_all_docs", `{"keys":["doc2"]}`)))
+	assert.Equal(t, []string{"doc3"}, rowIDs(rt.SendAdminRequest("POST", "/db/_all_docs", `{"keys":["doc3"]}`)))
+}
+
+// Results that the read transform changes for a user aren't cached, so users with the same channels but different
+// roles don't share them.
+func TestQueryResultCacheReadTransform(t *testing.T) {
+	ttl := 60000
+	rt := RestTester{DatabaseConfig: &DbConfig{
+		QueryResultCache: &QueryResultCacheConfig{TTLMs: &ttl},
+		ReadTransform: &ReadTransformConfig{
+			Masks: []PropertyMaskConfig{{Properties: []string{"salary"}, VisibleToRoles: []string{"hr"}}},
+		},
+	}}
+	defer rt.Close()
+
+	assertStatus(t, rt.SendAdminRequest("PUT", "/db/_role/hr", `{}`), http.StatusCreated)
+	assertStatus(t, rt.SendAdminRequest("PUT", "/db/_user/alice", `{"password":"letmein", "admin_channels":["*"], "admin_roles":["hr"]}`), http.StatusCreated)
+	assertStatus(t, rt.SendAdminRequest("PUT", "/db/_user/bob", `{"password":"letmein", "admin_channels":["*"]}`), http.StatusCreated)
+	assertStatus(t, rt.SendAdminRequest("PUT", "/db/emp1", `{"salary":100}`), http.StatusCreated)
+
+	allDocs := func(user string) string {
+		response := rt.SendUserRequestWithHeaders("GET", "/db/_all_docs?include_docs=true", "", nil, user, "letmein")
+		assertStatus(t, response, http.StatusOK)
+		return response.Body.String()
+	}
+	assert.Contains(t, allDocs("alice"), `"salary"`)
+	assert.NotContains(t, allDocs("bob"), `"salary"`)
+	assert.Contains(t, allDocs("alice"), `"salary"`)
+
+	bob, err := rt.GetDatabase().Authenticator().GetUser("bob")
+	assert.NoError(t, err)
+	lastSeq, err := rt.GetDatabase().LastSequence()
+	assert.NoError(t, err)
+	assert.Nil(t, rt.GetDatabase().QueryResultCache.Get(db.QueryResultCacheKey(bob, "GET /db/_all_docs?include_docs=true"), lastSeq))
+}
+
+func TestMakeQueryResultCacheOptions(t *testing.T) {
+	options, err := makeQueryResultCacheOptions(nil)
+	assert.NoError(t, err)
+	assert.Zero(t, options.TTL)
+
+	ttl, maxEntries := 250, 10
+	options, err = makeQueryResultCacheOptions(&QueryResultCacheConfig{TTLMs: &ttl})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(250), options.TTL.Nanoseconds()/1e6)
+	assert.Equal(t, db.DefaultQueryResultCacheMaxEntries, options.MaxEntries)
+	options, err = makeQueryResultCacheOptions(&QueryResultCacheConfig{TTLMs: &ttl, MaxEntries: &maxEntries})
+	assert.NoError(t, err)
+	assert.Equal(t, 10, options.MaxEntries)
+
+	ttl, maxEntries = -1, 0
+	_, err = makeQueryResultCacheOptions(&QueryResultCacheConfig{TTLMs: &ttl})
+	assert.Error(t, err)
+	ttl = 250
+	_, err = makeQueryResultCacheOptions(&QueryResultCacheConfig{TTLMs: &ttl, MaxEntries: &maxEntries})
+	assert.Error(t, err)
+}
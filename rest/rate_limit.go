@@ -0,0 +1,269 @@
+package rest
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/couchbase/sync_gateway/base"
+)
+
+// Categories of public API request.  Each has its own budget, so that for example a client's changes feeds can't use
+// up its budget for writes.
+type rateLimitCategory int
+
+const (
+	rateLimitReads   rateLimitCategory = iota // GET and HEAD requests, and POSTs that only read
+	rateLimitWrites                           // Requests that modify the database
+	rateLimitChanges                          // _changes feeds and BLIP sync connections
+	numRateLimitCategories
+)
+
+var rateLimitCategoryNames = [numRateLimitCategories]string{"reads", "writes", "changes"}
+
+// How often idle clients' token buckets are discarded
+const kRateLimitSweepInterval = time.Minute
+
+// Max number of token buckets kept, so that requests from many addresses can't exhaust the node's memory
+var rateLimitMaxBuckets = 100000
+
+// A budget of rate requests per second, with bursts of up to burst requests.
+type rateLimitBudget struct {
+	rate  float64
+	burst float64
+}
+
+// A client's remaining budget for one category of request.
+type tokenBucket struct {
+	tokens   float64   // Requests allowed before the budget is exceeded, refilled at the budget's rate
+	lastTime time.Time // When tokens was last refilled
+	fullTime time.Time // When tokens will have refilled to the budget's burst
+}
+
+// rateLimiter enforces the per-IP and per-user request budgets of the public API, using a token bucket for each
+// client and category.  A server without rate limits has a nil *rateLimiter, which the handler checks skip.
+type rateLimiter struct {
+	ipBudgets         [numRateLimitCategories]*rateLimitBudget // Nil where unlimited
+	userBudgets       [numRateLimitCategories]*rateLimitBudget // Nil where unlimited
+	trustForwardedFor bool                                     // Whether clients are identified by X-Forwarded-For
+	trustedProxyHops  int                                      // Number of trusted proxies that append to X-Forwarded-For
+	lock              sync.Mutex                               // Protects the fields below
+	buckets           map[string]*tokenBucket                  // Keyed by client and category
+	lastSweep         time.Time                                // When idle buckets were last discarded
+	now               func() time.Time                         // Returns the current time.  Overridden in tests
+}
+
+// Returns a rateLimiter for the given config, or nil if it doesn't set any budgets.
+func newRateLimiter(config *RateLimitConfig) *rateLimiter {
+	if config == nil {
+		return nil
+	}
+	rl := &rateLimiter{
+		buckets: map[string]*tokenBucket{},
+		now:     time.Now,
+	}
+	enabled := rl.setBudgets(&rl.ipBudgets, config.PerIP, "per_ip")
+	if rl.setBudgets(&rl.userBudgets, config.PerUser, "per_user") {
+		enabled = true
+	}
+	if !enabled {
+		return nil
+	}
+	rl.setForwardedFor(config)
+	rl.lastSweep = rl.now()
+	return rl
+}
+
+// Sets whether and how clients are identified by X-Forwarded-For.
+func (rl *rateLimiter) setForwardedFor(config *RateLimitConfig) {
+	if config == nil {
+		return
+	}
+	rl.trustForwardedFor = config.TrustXForwardedFor != nil && *config.TrustXForwardedFor
+	rl.trustedProxyHops = 1
+	if config.TrustedProxyHops != nil {
+		if *config.TrustedProxyHops > 0 {
+			rl.trustedProxyHops = *config.TrustedProxyHops
+		} else {
+			base.Warnf(base.KeyAll, "Ignoring rate_limits.trusted_proxy_hops, as it must be greater than zero")
+		}
+	}
+}
+
+// Sets budgets from the config, returning true if any are set.
+func (rl *rateLimiter) setBudgets(budgets *[numRateLimitCategories]*rateLimitBudget, config *RateLimitBudgetsConfig, name string) bool {
+	if config == nil {
+		return false
+	}
+	enabled := false
+	for category, budgetConfig := range [numRateLimitCategories]*RateLimitBudgetConfig{config.Reads, config.Writes, config.Changes} {
+		if budgetConfig == nil {
+			continue
+		}
+		if budgetConfig.RequestsPerSec <= 0 {
+			base.Warnf(base.KeyAll, "Ignoring rate_limits.%s.%s, as requests_per_sec must be greater than zero", name, rateLimitCategoryNames[category])
+			continue
+		}
+		budget := &rateLimitBudget{rate: budgetConfig.RequestsPerSec, burst: math.Max(budgetConfig.RequestsPerSec, 1)}
+		if budgetConfig.Burst != nil && *budgetConfig.Burst > 0 {
+			budget.burst = float64(*budgetConfig.Burst)
+		}
+		budgets[category] = budget
+		enabled = true
+	}
+	return enabled
+}
+
+// Spends one request from the client's budget for the category.  If the budget's been exceeded, returns false and
+// how long until the client can retry.
+func (rl *rateLimiter) allow(key string, budget *rateLimitBudget) (bool, time.Duration) {
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+
+	now := rl.now()
+	if now.Sub(rl.lastSweep) >= kRateLimitSweepInterval {
+		rl._sweep(now)
+	}
+
+	bucket, ok := rl.buckets[key]
+	if !ok {
+		if len(rl.buckets) >= rateLimitMaxBuckets {
+			rl._sweep(now)
+			rl._evict(len(rl.buckets) - rateLimitMaxBuckets + 1)
+		}
+		bucket = &tokenBucket{tokens: budget.burst}
+		rl.buckets[key] = bucket
+	} else {
+		bucket.tokens = math.Min(bucket.tokens+now.Sub(bucket.lastTime).Seconds()*budget.rate, budget.burst)
+	}
+	bucket.lastTime = now
+
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) / budget.rate * float64(time.Second))
+	}
+	bucket.tokens--
+	bucket.fullTime = now.Add(time.Duration((budget.burst - bucket.tokens) / budget.rate * float64(time.Second)))
+	return true, 0
+}
+
+// Discards buckets that haven't been used for long enough to have refilled, since they'd be recreated full anyway.
+// Requires the lock.
+func (rl *rateLimiter) _sweep(now time.Time) {
+	for key, bucket := range rl.buckets {
+		if !now.Before(bucket.fullTime) {
+			delete(rl.buckets, key)
+		}
+	}
+	rl.lastSweep = now
+}
+
+// Discards n buckets, which are arbitrary as map iteration order is unspecified.  Their clients start again with a
+// full budget, which is better than refusing new clients once there are too many.  Requires the lock.
+func (rl *rateLimiter) _evict(n int) {
+	for key := range rl.buckets {
+		if n <= 0 {
+			return
+		}
+		delete(rl.buckets, key)
+		n--
+	}
+}
+
+// Returns a rateLimiter for signups.  Its budgets are set by each database's signup config, rather than by the
+// server's rate limits.
+func newSignupRateLimiter(config *RateLimitConfig) *rateLimiter {
+	rl := &rateLimiter{
+		buckets: map[string]*tokenBucket{},
+		now:     time.Now,
+	}
+	rl.setForwardedFor(config)
+	rl.lastSweep = rl.now()
+	return rl
+}
+
+// Returns the address identifying the client that sent the request.
+func (rl *rateLimiter) clientIP(rq *http.Request) string {
+	if rl.trustForwardedFor {
+		if forwarded := rq.Header.Get("X-Forwarded-For"); forwarded != "" {
+			// Each proxy appends the address it received the request from, so only the entries added by the trusted
+			// proxies can be relied on; anything before them is whatever the client sent.
+			addresses := strings.Split(forwarded, ",")
+			index := len(addresses) - rl.trustedProxyHops
+			if index < 0 {
+				index = 0
+			}
+			return strings.TrimSpace(addresses[index])
+		}
+	}
+	host, _, err := net.SplitHostPort(rq.RemoteAddr)
+	if err != nil {
+		return rq.RemoteAddr
+	}
+	return host
+}
+
+// Returns the category of a public API request.
+func rateLimitCategoryOf(rq *http.Request) rateLimitCategory {
+	path := rq.URL.Path
+	if strings.HasSuffix(path, "/_changes") || strings.HasSuffix(path, "/_blipsync") {
+		return rateLimitChanges
+	}
+	switch rq.Method {
+	case "GET", "HEAD", "OPTIONS":
+		return rateLimitReads
+	case "POST":
+		if strings.HasSuffix(path, "/_all_docs") || strings.HasSuffix(path, "/_bulk_get") || strings.HasSuffix(path, "/_revs_diff") {
+			return rateLimitReads
+		}
+	}
+	return rateLimitWrites
+}
+
+// Returns a 429 error, and sets the Retry-After header, if the client has exceeded its budget for the named kind of
+// request.
+func (h *handler) checkRateLimit(rl *rateLimiter, key string, budget *rateLimitBudget, name string, client string) error {
+	ok, retryAfter := rl.allow(key, budget)
+	if ok {
+		return nil
+	}
+	base.InfofCtx(h.logCtx, base.KeyHTTP, "Rate limit for %s exceeded by %s", name, base.UD(client))
+	base.StatsResourceUtilization().Add(base.StatKeyRateLimitedRequestCount, 1)
+	h.setHeader("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	return base.HTTPErrorf(http.StatusTooManyRequests, "Rate limit exceeded for %s - retry later", name)
+}
+
+// Returns a 429 error if the client's IP address has exceeded its budget for the request's category.
+func (h *handler) checkIPRateLimit() error {
+	rl := h.server.rateLimiter
+	if rl == nil {
+		return nil
+	}
+	category := rateLimitCategoryOf(h.rq)
+	budget := rl.ipBudgets[category]
+	if budget == nil {
+		return nil
+	}
+	ip := rl.clientIP(h.rq)
+	name := rateLimitCategoryNames[category]
+	return h.checkRateLimit(rl, "ip:"+ip+":"+name, budget, name, "client "+ip)
+}
+
+// Returns a 429 error if the authenticated user has exceeded its budget for the request's category.  The guest user
+// is only limited by IP address.
+func (h *handler) checkUserRateLimit(dbName string) error {
+	rl := h.server.rateLimiter
+	if rl == nil || h.user == nil || h.user.Name() == "" {
+		return nil
+	}
+	category := rateLimitCategoryOf(h.rq)
+	budget := rl.userBudgets[category]
+	if budget == nil {
+		return nil
+	}
+	name := rateLimitCategoryNames[category]
+	return h.checkRateLimit(rl, "user:"+dbName+"/"+h.user.Name()+":"+name, budget, name, "user "+h.user.Name())
+}
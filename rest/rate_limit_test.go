@@ -0,0 +1,135 @@
+package rest
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiterTokenBucket(t *testing.T) {
+	burst := uint32(2)
+	rl := newRateLimiter(&RateLimitConfig{
+		PerIP: &RateLimitBudgetsConfig{Writes: &RateLimitBudgetConfig{RequestsPerSec: 1, Burst: &burst}},
+	})
+	now := time.Now()
+	rl.now = func() time.Time { return now }
+	budget := rl.ipBudgets[rateLimitWrites]
+
+	// A burst is allowed, then the client must wait for the budget to refill
+	ok, _ := rl.allow("ip:a", budget)
+	assert.True(t, ok)
+	ok, _ = rl.allow("ip:a", budget)
+	assert.True(t, ok)
+	ok, retryAfter := rl.allow("ip:a", budget)
+	assert.False(t, ok)
+	assert.Equal(t, time.Second, retryAfter)
+
+	// Other clients have their own budgets
+	ok, _ = rl.allow("ip:b", budget)
+	assert.True(t, ok)
+
+	now = now.Add(500 * time.Millisecond)
+	ok, retryAfter = rl.allow("ip:a", budget)
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, retryAfter)
+	now = now.Add(500 * time.Millisecond)
+	ok, _ = rl.allow("ip:a", budget)
+	assert.True(t, ok)
+
+	// Idle clients' buckets are discarded
+	now = now.Add(kRateLimitSweepInterval)
+	rl.allow("ip:c", budget)
+	assert.Len(t, rl.buckets, 1)
+
+	// Without any budgets, there's no rate limiter
+	assert.Nil(t, newRateLimiter(&RateLimitConfig{PerIP: &RateLimitBudgetsConfig{Reads: &RateLimitBudgetConfig{}}}))
+	assert.Nil(t, newRateLimiter(nil))
+}
+
+func TestRateLimitCategories(t *testing.T) {
+	for _, test := range []struct {
+		method, path string
+		category     rateLimitCategory
+	}{
+		{"GET", "/db/doc1", rateLimitReads},
+		{"HEAD", "/db/doc1", rateLimitReads},
+		{"POST", "/db/_bulk_get", rateLimitReads},
+		{"PUT", "/db/doc1", rateLimitWrites},
+		{"POST", "/db/_bulk_docs", rateLimitWrites},
+		{"DELETE", "/db/doc1", rateLimitWrites},
+		{"GET", "/db/_changes", rateLimitChanges},
+		{"POST", "/db/_changes", rateLimitChanges},
+		{"GET", "/db/_blipsync", rateLimitChanges},
+	} {
+		rq, _ := http.NewRequest(test.method, "http://localhost"+test.path, nil)
+		assert.Equal(t, test.category, rateLimitCategoryOf(rq), "%s %s", test.method, test.path)
+	}
+}
+
+func TestRateLimitPerIP(t *testing.T) {
+	rt := RestTester{ServerConfig: &ServerConfig{
+		RateLimits: &RateLimitConfig{
+			PerIP:              &RateLimitBudgetsConfig{Reads: &RateLimitBudgetConfig{RequestsPerSec: 0.1}},
+			TrustXForwardedFor: base.BoolPtr(true),
+			TrustedProxyHops:   base.IntPtr(2),
+		},
+	}}
+	defer rt.Close()
+
+	assertStatus(t, rt.SendAdminRequest("PUT", "/db/doc1", `{"value":1}`), http.StatusCreated)
+
+	headers := map[string]string{"X-Forwarded-For": "192.0.2.1, 10.0.0.1"}
+	assertStatus(t, rt.SendRequestWithHeaders("GET", "/db/doc1", "", headers), http.StatusOK)
+	response := rt.SendRequestWithHeaders("GET", "/db/doc1", "", headers)
+	assertStatus(t, response, http.StatusTooManyRequests)
+	assert.Equal(t, "10", response.Header().Get("Retry-After"))
+
+	// Addresses the client adds before those of the trusted proxies don't change who it is
+	spoofed := map[string]string{"X-Forwarded-For": "203.0.113.9, 192.0.2.1, 10.0.0.1"}
+	assertStatus(t, rt.SendRequestWithHeaders("GET", "/db/doc1", "", spoofed), http.StatusTooManyRequests)
+
+	// Writes and other clients' reads aren't limited, nor is the admin API
+	assertStatus(t, rt.SendRequestWithHeaders("PUT", "/db/doc2", `{"value":2}`, headers), http.StatusCreated)
+	assertStatus(t, rt.SendRequestWithHeaders("GET", "/db/doc1", "", map[string]string{"X-Forwarded-For": "192.0.2.2"}), http.StatusOK)
+	assertStatus(t, rt.SendAdminRequest("GET", "/db/doc1", ""), http.StatusOK)
+}
+
+func TestRateLimitMaxBuckets(t *testing.T) {
+	defer func(max int) { rateLimitMaxBuckets = max }(rateLimitMaxBuckets)
+	rateLimitMaxBuckets = 10
+
+	rl := newRateLimiter(&RateLimitConfig{PerIP: &RateLimitBudgetsConfig{Reads: &RateLimitBudgetConfig{RequestsPerSec: 0.1}}})
+	for i := 0; i < 100; i++ {
+		ok, _ := rl.allow(fmt.Sprintf("client%d", i), rl.ipBudgets[rateLimitReads])
+		assert.True(t, ok)
+	}
+	assert.Len(t, rl.buckets, 10)
+}
+
+func TestRateLimitPerUser(t *testing.T) {
+	rt := RestTester{
+		noAdminParty: true,
+		ServerConfig: &ServerConfig{
+			RateLimits: &RateLimitConfig{
+				PerUser: &RateLimitBudgetsConfig{Writes: &RateLimitBudgetConfig{RequestsPerSec: 0.1}},
+			},
+		},
+	}
+	defer rt.Close()
+
+	assertStatus(t, rt.SendAdminRequest("PUT", "/db/_user/alice", `{"password":"letmein", "admin_channels":["*"]}`), http.StatusCreated)
+	assertStatus(t, rt.SendAdminRequest("PUT", "/db/_user/bob", `{"password":"letmein", "admin_channels":["*"]}`), http.StatusCreated)
+
+	assertStatus(t, rt.Send(requestByUser("PUT", "/db/doc1", `{"value":1}`, "alice")), http.StatusCreated)
+	response := rt.Send(requestByUser("PUT", "/db/doc2", `{"value":2}`, "alice"))
+	assertStatus(t, response, http.StatusTooManyRequests)
+	assert.NotEqual(t, "", response.Header().Get("Retry-After"))
+
+	// Each user has its own budget, and reads aren't limited
+	assertStatus(t, rt.Send(requestByUser("PUT", "/db/doc2", `{"value":2}`, "bob")), http.StatusCreated)
+	assertStatus(t, rt.Send(requestByUser("GET", "/db/doc1", "", "alice")), http.StatusOK)
+}
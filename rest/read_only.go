@@ -0,0 +1,70 @@
+package rest
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/couchbase/go-blip"
+	"github.com/couchbase/sync_gateway/base"
+)
+
+// Checks the read_only config.  The write URL, if set, has to be an absolute URL, as rejected requests' paths are
+// appended to it.
+func validateReadOnlyConfig(config *ReadOnlyConfig) error {
+	if config == nil || !config.Enabled || config.WriteURL == "" {
+		return nil
+	}
+	if err := verifyHTTPURL(config.WriteURL); err != nil {
+		return fmt.Errorf("Invalid read_only.write_url: %v", err)
+	}
+	return nil
+}
+
+// Returns the read_only config if the handler's requests are subject to it, or nil if writes are allowed.  The admin
+// API always accepts writes.
+func (h *handler) readOnlyConfig() *ReadOnlyConfig {
+	config := h.server.config.ReadOnly
+	if config == nil || !config.Enabled || h.privs == adminPrivs {
+		return nil
+	}
+	return config
+}
+
+// Wraps the method of a handler that writes documents, so a read-only replica rejects the request before it's run.
+func writeMethod(method handlerMethod) handlerMethod {
+	return func(h *handler) error {
+		if config := h.readOnlyConfig(); config != nil {
+			return h.readOnlyError(config)
+		}
+		return method(h)
+	}
+}
+
+// Returns the error a read-only replica rejects a write with.  If a write URL is configured, the request is
+// redirected to the same path there with a 307, so clients that follow redirects resend it with the same method and
+// body; otherwise it's a 403.  Either way the error code is read_only, so clients can tell it from a permissions
+// failure.
+func (h *handler) readOnlyError(config *ReadOnlyConfig) error {
+	if config.WriteURL == "" {
+		return base.CodedHTTPErrorf(http.StatusForbidden, base.ErrorCodeReadOnly, "This node is read-only; send writes to another node")
+	}
+	location := strings.TrimSuffix(config.WriteURL, "/") + h.rq.URL.RequestURI()
+	h.setHeader("Location", location)
+	return base.CodedHTTPErrorf(http.StatusTemporaryRedirect, base.ErrorCodeReadOnly, "This node is read-only; send writes to %s", location)
+}
+
+// Wraps the handler of a BLIP message a client sends when pushing, so a read-only replica rejects it.  Pulls and
+// checkpoints still work, so a push-and-pull replication fails as soon as it has a change to push.  BLIP can't
+// redirect, so the error message gives the write URL for the client to replicate with instead.
+func writeBlipHandler(underlyingMethod blipHandlerMethod) blipHandlerMethod {
+	return func(bh *blipHandler, bm *blip.Message) error {
+		if config := bh.readOnly; config != nil {
+			if config.WriteURL != "" {
+				return base.CodedHTTPErrorf(http.StatusForbidden, base.ErrorCodeReadOnly, "This node is read-only; push to %s", config.WriteURL)
+			}
+			return base.CodedHTTPErrorf(http.StatusForbidden, base.ErrorCodeReadOnly, "This node is read-only; push to another node")
+		}
+		return underlyingMethod(bh, bm)
+	}
+}
@@ -0,0 +1,72 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadOnlyReplica(t *testing.T) {
+	rt := RestTester{ServerConfig: &ServerConfig{ReadOnly: &ReadOnlyConfig{Enabled: true}}}
+	defer rt.Close()
+
+	// Writes through the admin API still work, and can be read through the public API
+	assertStatus(t, rt.SendAdminRequest("PUT", "/db/doc1", `{"value":1}`), http.StatusCreated)
+	assertStatus(t, rt.SendRequest("GET", "/db/doc1", ""), http.StatusOK)
+	assertStatus(t, rt.SendRequest("POST", "/db/_bulk_get", `{"docs":[{"id":"doc1"}]}`), http.StatusOK)
+	assertStatus(t, rt.SendRequest("GET", "/db/_changes", ""), http.StatusOK)
+
+	// Pull replications still save checkpoints
+	assertStatus(t, rt.SendRequest("PUT", "/db/_local/checkpoint", `{"seq":1}`), http.StatusCreated)
+
+	for _, request := range []struct{ method, path, body string }{
+		{"PUT", "/db/doc2", `{"value":2}`},
+		{"POST", "/db/", `{"value":2}`},
+		{"POST", "/db/_bulk_docs", `{"docs":[{"_id":"doc2"}]}`},
+		{"DELETE", "/db/doc1?rev=1-abc", ""},
+		{"PUT", "/db/doc1/att?rev=1-abc", "data"},
+	} {
+		response := rt.SendRequest(request.method, request.path, request.body)
+		assertStatus(t, response, http.StatusForbidden)
+		var body ErrorResponse
+		assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &body))
+		assert.Equal(t, base.ErrorCodeReadOnly, body.Code, "For %s %s", request.method, request.path)
+	}
+	assertStatus(t, rt.SendRequest("GET", "/db/doc2", ""), http.StatusNotFound)
+}
+
+func TestReadOnlyReplicaRedirect(t *testing.T) {
+	rt := RestTester{ServerConfig: &ServerConfig{ReadOnly: &ReadOnlyConfig{Enabled: true, WriteURL: "https://writes.example.com/"}}}
+	defer rt.Close()
+
+	response := rt.SendRequest("PUT", "/db/doc1?new_edits=true", `{"value":1}`)
+	assertStatus(t, response, http.StatusTemporaryRedirect)
+	assert.Equal(t, "https://writes.example.com/db/doc1?new_edits=true", response.Header().Get("Location"))
+}
+
+func TestReadOnlyReplicaBlipPush(t *testing.T) {
+	rt := RestTester{ServerConfig: &ServerConfig{ReadOnly: &ReadOnlyConfig{Enabled: true, WriteURL: "https://writes.example.com"}}}
+	bt, err := NewBlipTesterFromSpec(BlipTesterSpec{restTester: &rt})
+	assert.NoError(t, err)
+	defer bt.Close()
+
+	_, _, res, err := bt.SendRev("doc1", "1-abc", []byte(`{"value":1}`), nil)
+	assert.Error(t, err)
+	assert.Equal(t, "403", res.Properties["Error-Code"])
+	assert.Equal(t, base.ErrorCodeReadOnly, res.Properties[errorPropertyCode])
+
+	// Pulls still work
+	assertStatus(t, rt.SendAdminRequest("PUT", "/db/doc2", `{"value":2}`), http.StatusCreated)
+	assert.Len(t, bt.WaitForNumDocsViaChanges(1), 1)
+}
+
+func TestValidateReadOnlyConfig(t *testing.T) {
+	assert.NoError(t, validateReadOnlyConfig(nil))
+	assert.NoError(t, validateReadOnlyConfig(&ReadOnlyConfig{Enabled: true}))
+	assert.NoError(t, validateReadOnlyConfig(&ReadOnlyConfig{Enabled: true, WriteURL: "http://writes:4984"}))
+	assert.Error(t, validateReadOnlyConfig(&ReadOnlyConfig{Enabled: true, WriteURL: "writes:4984"}))
+	assert.Error(t, validateReadOnlyConfig(&ReadOnlyConfig{Enabled: true, WriteURL: "/db"}))
+}
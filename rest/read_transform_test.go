@@ -0,0 +1,87 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadTransform(t *testing.T) {
+	rt := RestTester{DatabaseConfig: &DbConfig{ReadTransform: &ReadTransformConfig{
+		Masks: []PropertyMaskConfig{{Properties: []string{"salary", "address.street"}, VisibleToRoles: []string{"hr"}}},
+	}}}
+	defer rt.Close()
+
+	assertStatus(t, rt.SendAdminRequest("PUT", "/db/_role/hr", `{}`), http.StatusCreated)
+	assertStatus(t, rt.SendAdminRequest("PUT", "/db/_user/alice", `{"password":"letmein", "admin_channels":["*"], "admin_roles":["hr"]}`), http.StatusCreated)
+	assertStatus(t, rt.SendAdminRequest("PUT", "/db/_user/bob", `{"password":"letmein", "admin_channels":["*"]}`), http.StatusCreated)
+	assertStatus(t, rt.SendAdminRequest("PUT", "/db/emp1", `{"name":"Ann", "salary":100, "address":{"street":"1 Main St", "city":"Springfield"}}`), http.StatusCreated)
+
+	get := func(user string) (body map[string]interface{}) {
+		response := rt.SendUserRequestWithHeaders("GET", "/db/emp1", "", nil, user, "letmein")
+		assertStatus(t, response, http.StatusOK)
+		assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &body))
+		return body
+	}
+	body := get("bob")
+	assert.Nil(t, body["salary"])
+	assert.Equal(t, map[string]interface{}{"city": "Springfield"}, body["address"])
+	body = get("alice")
+	assert.Equal(t, float64(100), body["salary"])
+	assert.Equal(t, map[string]interface{}{"street": "1 Main St", "city": "Springfield"}, body["address"])
+}
+
+func TestMakeReadTransform(t *testing.T) {
+	function := `function(doc, user) {return doc;}`
+	transform, err := makeReadTransform(&ReadTransformConfig{
+		Masks:    []PropertyMaskConfig{{DocType: "employee", Properties: []string{"address.street"}}},
+		Function: &function,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{{"address", "street"}}, transform.Masks[0].Properties)
+	assert.NotNil(t, transform.Function)
+
+	_, err = makeReadTransform(&ReadTransformConfig{Masks: []PropertyMaskConfig{{}}})
+	assert.EqualError(t, err, "read_transform.masks[0]: properties must be set")
+	_, err = makeReadTransform(&ReadTransformConfig{Masks: []PropertyMaskConfig{{Properties: []string{"_attachments"}}}})
+	assert.EqualError(t, err, `read_transform.masks[0]: invalid property "_attachments"`)
+	function = `function(doc, user) {`
+	_, err = makeReadTransform(&ReadTransformConfig{Function: &function})
+	assert.Error(t, err)
+}
+
+// Named queries return rows straight from the bucket, so users whose reads are transformed can't run them, either
+// directly or through GraphQL.
+func TestReadTransformNamedQuery(t *testing.T) {
+	rt := RestTester{DatabaseConfig: &DbConfig{
+		ReadTransform: &ReadTransformConfig{
+			Masks: []PropertyMaskConfig{{Properties: []string{"salary"}, VisibleToRoles: []string{"hr"}}},
+		},
+		Queries: map[string]*NamedQueryConfig{
+			"salaries": {Select: "salary"},
+		},
+		GraphQL: &GraphQLConfig{
+			Schema: `
+				type Query { salaries: [Employee] }
+				type Employee { salary: Int }`,
+			Resolvers: map[string]map[string]*GraphQLResolverConfig{
+				"Query": {"salaries": {Query: "salaries"}},
+			},
+		},
+	}}
+	defer rt.Close()
+
+	assertStatus(t, rt.SendAdminRequest("PUT", "/db/_user/bob", `{"password":"letmein", "admin_channels":["*"]}`), http.StatusCreated)
+	assertStatus(t, rt.SendAdminRequest("PUT", "/db/emp1", `{"salary":12345}`), http.StatusCreated)
+
+	response := rt.SendUserRequestWithHeaders("GET", "/db/_query/salaries", "", nil, "bob", "letmein")
+	assertStatus(t, response, http.StatusForbidden)
+	assert.NotContains(t, response.Body.String(), "12345")
+
+	response = rt.SendUserRequestWithHeaders("POST", "/db/_graphql", `{"query":"{ salaries { salary } }"}`, nil, "bob", "letmein")
+	assert.Contains(t, response.Body.String(), `"errors"`)
+	assert.Contains(t, response.Body.String(), "Named queries aren't available")
+	assert.NotContains(t, response.Body.String(), "12345")
+}
@@ -0,0 +1,81 @@
+package rest
+
+import (
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/couchbase/sync_gateway/base"
+)
+
+// Passes a request body through, failing with a 413 error once more than limit bytes have been read.
+type limitedRequestBody struct {
+	io.ReadCloser
+	limit     int64
+	remaining int64
+}
+
+func (b *limitedRequestBody) Read(p []byte) (int, error) {
+	// Read one byte more than the limit allows, to find out whether the body exceeds it
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if int64(n) > b.remaining {
+		n, b.remaining = int(b.remaining), 0
+		return n, requestTooLargeError(b.limit)
+	}
+	b.remaining -= int64(n)
+	return n, err
+}
+
+func requestTooLargeError(limit int64) error {
+	return base.HTTPErrorf(http.StatusRequestEntityTooLarge, "Request body exceeds the limit of %d bytes", limit)
+}
+
+// Returns the size limit for the request's body, depending on the kind of endpoint, or zero if it's unlimited.  The
+// admin API is unlimited.
+func (h *handler) maxBodyBytes() int64 {
+	limits := h.server.config.RequestLimits
+	if limits == nil || h.privs == adminPrivs {
+		return 0
+	}
+	var limit *int64
+	switch {
+	case h.PathVar("attach") != "":
+		limit = limits.MaxAttachmentBytes
+	case strings.HasSuffix(h.rq.URL.Path, "/_bulk_docs"):
+		limit = limits.MaxBulkDocsBytes
+	case h.PathVar("docid") != "" || (h.rq.Method == "POST" && h.PathVar("db") != "" && strings.HasSuffix(h.rq.URL.Path, "/")):
+		limit = limits.MaxDocBytes
+	default:
+		limit = limits.MaxBodyBytes
+	}
+	if limit == nil || *limit <= 0 {
+		return 0
+	}
+	return *limit
+}
+
+// Returns the max nesting depth of JSON request bodies, or zero if it's unlimited.  The admin API is unlimited.
+func (h *handler) maxJSONDepth() int {
+	limits := h.server.config.RequestLimits
+	if limits == nil || limits.MaxJSONDepth == nil || h.privs == adminPrivs {
+		return 0
+	}
+	return *limits.MaxJSONDepth
+}
+
+// Applies the size limit for the request's body.  If the Content-Length header shows the body is too large, it's
+// rejected with a 413 error without being read; otherwise reading the body fails once it exceeds the limit.
+func (h *handler) limitRequestBody() error {
+	limit := h.maxBodyBytes()
+	if limit == 0 {
+		return nil
+	}
+	if h.rq.ContentLength > limit {
+		return requestTooLargeError(limit)
+	}
+	h.requestBody = &limitedRequestBody{ReadCloser: h.requestBody, limit: limit, remaining: limit}
+	return nil
+}
@@ -0,0 +1,54 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestBodyLimits(t *testing.T) {
+	maxDocBytes, maxBulkDocsBytes, maxAttachmentBytes, maxJSONDepth := int64(100), int64(200), int64(10), 3
+	rt := RestTester{ServerConfig: &ServerConfig{
+		RequestLimits: &RequestLimitsConfig{
+			MaxDocBytes:        &maxDocBytes,
+			MaxBulkDocsBytes:   &maxBulkDocsBytes,
+			MaxAttachmentBytes: &maxAttachmentBytes,
+			MaxJSONDepth:       &maxJSONDepth,
+		},
+	}}
+	defer rt.Close()
+
+	largeDoc := fmt.Sprintf(`{"value":%q}`, strings.Repeat("x", 100))
+	response := rt.SendRequest("PUT", "/db/doc1", `{"value":"x"}`)
+	assertStatus(t, response, http.StatusCreated)
+	var putResult struct {
+		Rev string `json:"rev"`
+	}
+	assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &putResult))
+	assertStatus(t, rt.SendRequest("PUT", "/db/doc2", largeDoc), http.StatusRequestEntityTooLarge)
+	assertStatus(t, rt.SendRequest("POST", "/db/", largeDoc), http.StatusRequestEntityTooLarge)
+
+	// Bodies without a Content-Length fail once they've been read past the limit
+	rq := request("PUT", "/db/doc2", largeDoc)
+	rq.ContentLength = -1
+	assertStatus(t, rt.Send(rq), http.StatusRequestEntityTooLarge)
+
+	// Each kind of endpoint has its own limit
+	bulkDocs := fmt.Sprintf(`{"docs":[%s]}`, largeDoc)
+	assertStatus(t, rt.SendRequest("POST", "/db/_bulk_docs", bulkDocs), http.StatusCreated)
+	assertStatus(t, rt.SendRequest("POST", "/db/_bulk_docs", fmt.Sprintf(`{"docs":[%s,%s]}`, largeDoc, largeDoc)), http.StatusRequestEntityTooLarge)
+	response = rt.SendRequestWithHeaders("PUT", "/db/doc1/att?rev="+putResult.Rev, "0123456789a", map[string]string{"Content-Type": "text/plain"})
+	assertStatus(t, response, http.StatusRequestEntityTooLarge)
+
+	// JSON nested too deeply is rejected
+	assertStatus(t, rt.SendRequest("PUT", "/db/doc3", `{"a":{"b":[1]}}`), http.StatusCreated)
+	assertStatus(t, rt.SendRequest("PUT", "/db/doc4", `{"a":{"b":[{}]}}`), http.StatusRequestEntityTooLarge)
+
+	// The admin API is unlimited
+	assertStatus(t, rt.SendAdminRequest("PUT", "/db/doc2", largeDoc), http.StatusCreated)
+	assertStatus(t, rt.SendAdminRequest("PUT", "/db/doc4", `{"a":{"b":[{}]}}`), http.StatusCreated)
+}
@@ -0,0 +1,216 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/couchbase/sync_gateway/base"
+)
+
+// Returned by writes to a timeoutResponseWriter after its request has timed out
+var errRequestTimedOut = errors.New("Request timed out")
+
+// Returns the server-side time limit for the request, depending on the kind of endpoint, or zero if it's unlimited.
+// Only reads are limited, as a timed-out request's handler method carries on until it notices; a write could still
+// complete after its client has been told it failed.  WebSocket requests, including BLIP sync connections, are
+// unlimited.
+func (h *handler) requestTimeout() time.Duration {
+	timeouts := h.server.config.RequestTimeouts
+	if timeouts == nil || !h.isReadRequest() || strings.ToLower(h.rq.Header.Get("Upgrade")) == "websocket" {
+		return 0
+	}
+	path := h.rq.URL.Path
+	var timeout *int
+	switch {
+	case h.PathVar("attach") != "":
+		timeout = timeouts.Attachments
+	case strings.HasSuffix(path, "/_changes"):
+		timeout = timeouts.Changes
+	case strings.HasSuffix(path, "/_bulk_docs"), strings.HasSuffix(path, "/_bulk_get"),
+		strings.HasSuffix(path, "/_all_docs"), strings.HasSuffix(path, "/_revs_diff"):
+		timeout = timeouts.Bulk
+	case h.PathVar("docid") != "" || (h.rq.Method == "POST" && h.PathVar("db") != "" && strings.HasSuffix(path, "/")):
+		timeout = timeouts.CRUD
+	}
+	if timeout == nil || *timeout <= 0 {
+		return 0
+	}
+	return time.Duration(*timeout) * time.Second
+}
+
+// Returns true if the request doesn't change anything, so it's safe to stop waiting for it.
+func (h *handler) isReadRequest() bool {
+	switch h.rq.Method {
+	case "GET", "HEAD":
+		return true
+	case "POST":
+		path := h.rq.URL.Path
+		return strings.HasSuffix(path, "/_bulk_get") || strings.HasSuffix(path, "/_all_docs") ||
+			strings.HasSuffix(path, "/_revs_diff") || strings.HasSuffix(path, "/_changes")
+	}
+	return false
+}
+
+// Calls the handler method, failing the request with a 503 error if it takes longer than timeout.  The method runs
+// on a copy of the handler, in its own goroutine, so that a method stuck in a bucket operation doesn't hold the
+// request's connection; when the timeout expires the request's context is cancelled, its changes feed (if any) ends,
+// and anything the method writes after that is discarded.  The request body is read up front, as net/http closes it
+// once the request has been handled.  Only used for reads; see requestTimeout.
+func (h *handler) invokeWithTimeout(method handlerMethod, timeout time.Duration) error {
+	if h.rq.Body != nil && h.rq.Body != http.NoBody {
+		body, err := h.readBody()
+		if err != nil {
+			return err
+		}
+		h.requestBody = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	ctx, cancel := context.WithTimeout(h.rq.Context(), timeout)
+	defer cancel()
+	response := newTimeoutResponseWriter(h.response)
+	defer response.finish()
+
+	worker := *h
+	worker.rq = h.rq.WithContext(ctx)
+	worker.response = response
+
+	type result struct {
+		err        error
+		panicValue interface{}
+		stack      []byte
+	}
+	done := make(chan result, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- result{panicValue: r, stack: debug.Stack()}
+			}
+		}()
+		done <- result{err: method(&worker)}
+	}()
+
+	// Not ctx.Done(), which is also closed if the client goes away; the method handles that itself, as usual
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		if r.panicValue != nil {
+			panic(fmt.Sprintf("%v\n\n%s", r.panicValue, r.stack)) // Let net/http recover it, as it would have
+		}
+		*h = worker
+		return r.err
+	case <-timer.C:
+		cancel()
+		wroteHeader := response.timeOut()
+		base.InfofCtx(h.logCtx, base.KeyHTTP, "Request timed out after %v", timeout)
+		if wroteHeader {
+			h.setStatus(http.StatusServiceUnavailable, "timed out")
+			return nil // Too late to send an error; the response just ends
+		}
+		return base.CodedHTTPErrorf(http.StatusServiceUnavailable, base.ErrorCodeRequestTimedOut, "Request timed out after %v", timeout)
+	}
+}
+
+// Wraps a handler's response, so that once the request has timed out anything more the handler method writes is
+// discarded, and its CloseNotify channel fires, ending changes feeds.  Headers are buffered until the status is
+// written, so the method can't modify them while the timed-out request's error is being written.
+type timeoutResponseWriter struct {
+	w           http.ResponseWriter
+	header      http.Header
+	lock        sync.Mutex // Protects the fields below, and writes to w
+	wroteHeader bool
+	timedOut    bool
+	done        chan struct{} // Closed by timeOut
+	finished    chan struct{} // Closed by finish, once the request's been handled
+}
+
+func newTimeoutResponseWriter(w http.ResponseWriter) *timeoutResponseWriter {
+	return &timeoutResponseWriter{
+		w:        w,
+		header:   http.Header{},
+		done:     make(chan struct{}),
+		finished: make(chan struct{}),
+	}
+}
+
+func (tw *timeoutResponseWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutResponseWriter) WriteHeader(status int) {
+	tw.lock.Lock()
+	defer tw.lock.Unlock()
+	if !tw.timedOut {
+		tw._writeHeader(status)
+	}
+}
+
+func (tw *timeoutResponseWriter) _writeHeader(status int) {
+	if tw.wroteHeader {
+		return
+	}
+	tw.wroteHeader = true
+	header := tw.w.Header()
+	for key, values := range tw.header {
+		header[key] = values
+	}
+	tw.w.WriteHeader(status)
+}
+
+func (tw *timeoutResponseWriter) Write(b []byte) (int, error) {
+	tw.lock.Lock()
+	defer tw.lock.Unlock()
+	if tw.timedOut {
+		return 0, errRequestTimedOut
+	}
+	tw._writeHeader(http.StatusOK)
+	return tw.w.Write(b)
+}
+
+func (tw *timeoutResponseWriter) Flush() {
+	tw.lock.Lock()
+	defer tw.lock.Unlock()
+	if flusher, ok := tw.w.(http.Flusher); ok && !tw.timedOut {
+		flusher.Flush()
+	}
+}
+
+// Fires when the client closes the connection, or the request times out.
+func (tw *timeoutResponseWriter) CloseNotify() <-chan bool {
+	var clientClosed <-chan bool
+	if cn, ok := tw.w.(http.CloseNotifier); ok {
+		clientClosed = cn.CloseNotify()
+	}
+	closed := make(chan bool, 1)
+	go func() {
+		select {
+		case <-clientClosed:
+			closed <- true
+		case <-tw.done:
+			closed <- true
+		case <-tw.finished:
+		}
+	}()
+	return closed
+}
+
+// Marks the request as timed out, returning whether the status had already been written.
+func (tw *timeoutResponseWriter) timeOut() (wroteHeader bool) {
+	tw.lock.Lock()
+	defer tw.lock.Unlock()
+	tw.timedOut = true
+	close(tw.done)
+	return tw.wroteHeader
+}
+
+func (tw *timeoutResponseWriter) finish() {
+	close(tw.finished)
+}
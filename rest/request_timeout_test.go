@@ -0,0 +1,92 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInvokeWithTimeout(t *testing.T) {
+	rt := RestTester{}
+	defer rt.Close()
+	newTestHandler := func() (*handler, *httptest.ResponseRecorder) {
+		recorder := httptest.NewRecorder()
+		return newHandler(rt.ServerContext(), adminPrivs, recorder, request("GET", "/db/doc", ""), false), recorder
+	}
+
+	// A method that finishes in time writes its response as usual
+	h, recorder := newTestHandler()
+	err := h.invokeWithTimeout(func(h *handler) error {
+		h.setHeader("Content-Type", "text/plain")
+		_, err := h.response.Write([]byte("done"))
+		return err
+	}, time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, "text/plain", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, "done", recorder.Body.String())
+
+	// A stuck method's request fails, and what the method writes once it's unstuck is discarded
+	release := make(chan struct{})
+	written := make(chan error, 1)
+	h, recorder = newTestHandler()
+	err = h.invokeWithTimeout(func(h *handler) error {
+		<-release
+		assert.Error(t, h.rq.Context().Err())
+		_, err := h.response.Write([]byte("too late"))
+		written <- err
+		return nil
+	}, 50*time.Millisecond)
+	status, _ := base.ErrorAsHTTPStatus(err)
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	code, retryable := base.ErrorCode(err)
+	assert.Equal(t, base.ErrorCodeRequestTimedOut, code)
+	assert.False(t, retryable)
+	close(release)
+	assert.Equal(t, errRequestTimedOut, <-written)
+	assert.Equal(t, "", recorder.Body.String())
+
+	// Panics are passed on to net/http
+	h, _ = newTestHandler()
+	assert.Panics(t, func() {
+		h.invokeWithTimeout(func(h *handler) error { panic("oops") }, time.Second)
+	})
+}
+
+func TestRequestTimeoutOnlyForReads(t *testing.T) {
+	bulkTimeout := 1
+	rt := RestTester{ServerConfig: &ServerConfig{RequestTimeouts: &RequestTimeoutsConfig{Bulk: &bulkTimeout}}}
+	defer rt.Close()
+
+	timeout := func(method, path string) time.Duration {
+		return newHandler(rt.ServerContext(), regularPrivs, httptest.NewRecorder(), request(method, path, ""), false).requestTimeout()
+	}
+	assert.Equal(t, time.Second, timeout("GET", "/db/_all_docs"))
+	assert.Equal(t, time.Second, timeout("POST", "/db/_bulk_get"))
+	assert.Equal(t, time.Duration(0), timeout("POST", "/db/_bulk_docs"))
+}
+
+func TestChangesRequestTimeout(t *testing.T) {
+	changesTimeout := 1
+	rt := RestTester{ServerConfig: &ServerConfig{RequestTimeouts: &RequestTimeoutsConfig{Changes: &changesTimeout}}}
+	defer rt.Close()
+
+	assertStatus(t, rt.SendAdminRequest("PUT", "/db/doc1", `{"value":1}`), http.StatusCreated)
+	response := rt.SendAdminRequest("GET", "/db/_changes", "")
+	assertStatus(t, response, http.StatusOK)
+	var changes struct {
+		LastSeq interface{} `json:"last_seq"`
+	}
+	assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &changes))
+	since, _ := json.Marshal(changes.LastSeq)
+
+	// A longpoll feed with nothing to send ends at the request timeout, rather than its own
+	start := time.Now()
+	response = rt.SendAdminRequest("GET", "/db/_changes?feed=longpoll&timeout=60000&since="+string(since), "")
+	assert.True(t, time.Since(start) < 30*time.Second, "Feed didn't end at the request timeout")
+	assert.Equal(t, http.StatusOK, response.Code)
+}
@@ -42,24 +42,30 @@ func createHandler(sc *ServerContext, privs handlerPrivs) (*mux.Router, *mux.Rou
 	r.StrictSlash(true)
 	// Global operations:
 	r.Handle("/", makeHandler(sc, privs, (*handler).handleRoot)).Methods("GET", "HEAD")
+	r.Handle("/_health", makeHandler(sc, privs, (*handler).handleHealthCheck)).Methods("GET", "HEAD")
 
 	// Operations on databases:
 	r.Handle("/{db:"+dbRegex+"}/", makeOfflineHandler(sc, privs, (*handler).handleGetDB)).Methods("GET", "HEAD")
-	r.Handle("/{db:"+dbRegex+"}/", makeHandler(sc, privs, (*handler).handlePostDoc)).Methods("POST")
+	r.Handle("/{db:"+dbRegex+"}/", makeHandler(sc, privs, writeMethod((*handler).handlePostDoc))).Methods("POST")
 
 	// Special database URLs:
 	dbr := r.PathPrefix("/{db:" + dbRegex + "}/").Subrouter()
 	dbr.StrictSlash(true)
-	dbr.Handle("/_all_docs", makeHandler(sc, privs, (*handler).handleAllDocs)).Methods("GET", "HEAD", "POST")
-	dbr.Handle("/_bulk_docs", makeHandler(sc, privs, (*handler).handleBulkDocs)).Methods("POST")
+	dbr.Handle("/_all_docs", makeHandler(sc, privs, cachedQueryMethod((*handler).handleAllDocs))).Methods("GET", "HEAD", "POST")
+	dbr.Handle("/_bulk_docs", makeHandler(sc, privs, writeMethod((*handler).handleBulkDocs))).Methods("POST")
 	dbr.Handle("/_bulk_get", makeHandler(sc, privs, (*handler).handleBulkGet)).Methods("POST")
 	dbr.Handle("/_changes", makeHandler(sc, privs, (*handler).handleChanges)).Methods("GET", "HEAD", "POST")
+	dbr.Handle("/_conflicts", makeHandler(sc, privs, (*handler).handleConflicts)).Methods("GET", "HEAD")
 	dbr.Handle("/_design/{ddoc}", makeHandler(sc, privs, (*handler).handleGetDesignDoc)).Methods("GET", "HEAD")
-	dbr.Handle("/_design/{ddoc}", makeHandler(sc, privs, (*handler).handlePutDesignDoc)).Methods("PUT")
-	dbr.Handle("/_design/{ddoc}", makeHandler(sc, privs, (*handler).handleDeleteDesignDoc)).Methods("DELETE")
-	dbr.Handle("/_design/{ddoc}/_view/{view}", makeHandler(sc, privs, (*handler).handleView)).Methods("GET")
+	dbr.Handle("/_design/{ddoc}", makeHandler(sc, privs, writeMethod((*handler).handlePutDesignDoc))).Methods("PUT")
+	dbr.Handle("/_design/{ddoc}", makeHandler(sc, privs, writeMethod((*handler).handleDeleteDesignDoc))).Methods("DELETE")
+	dbr.Handle("/_design/{ddoc}/_view/{view}", makeHandler(sc, privs, cachedQueryMethod((*handler).handleView))).Methods("GET")
 	dbr.Handle("/_ensure_full_commit", makeHandler(sc, privs, (*handler).handleEFC)).Methods("POST")
 	dbr.Handle("/_revs_diff", makeHandler(sc, privs, (*handler).handleRevsDiff)).Methods("POST")
+	dbr.Handle("/_time", makeHandler(sc, privs, (*handler).handleGetTime)).Methods("GET")
+	dbr.Handle("/_query/{name}", makeHandler(sc, privs, (*handler).handleNamedQuery)).Methods("GET", "POST")
+	dbr.Handle("/_function/{name}", makeHandler(sc, privs, writeMethod((*handler).handleCallUserFunction))).Methods("POST")
+	dbr.Handle("/_graphql", makeHandler(sc, privs, (*handler).handleGraphQL)).Methods("GET", "POST")
 
 	// Document URLs:
 	dbr.Handle("/_local/{docid}", makeHandler(sc, privs, (*handler).handleGetLocalDoc)).Methods("GET", "HEAD")
@@ -67,11 +73,11 @@ func createHandler(sc *ServerContext, privs handlerPrivs) (*mux.Router, *mux.Rou
 	dbr.Handle("/_local/{docid}", makeHandler(sc, privs, (*handler).handleDelLocalDoc)).Methods("DELETE")
 
 	dbr.Handle("/{docid:"+docRegex+"}", makeHandler(sc, privs, (*handler).handleGetDoc)).Methods("GET", "HEAD")
-	dbr.Handle("/{docid:"+docRegex+"}", makeHandler(sc, privs, (*handler).handlePutDoc)).Methods("PUT")
-	dbr.Handle("/{docid:"+docRegex+"}", makeHandler(sc, privs, (*handler).handleDeleteDoc)).Methods("DELETE")
+	dbr.Handle("/{docid:"+docRegex+"}", makeHandler(sc, privs, writeMethod((*handler).handlePutDoc))).Methods("PUT")
+	dbr.Handle("/{docid:"+docRegex+"}", makeHandler(sc, privs, writeMethod((*handler).handleDeleteDoc))).Methods("DELETE")
 
 	dbr.Handle("/{docid:"+docRegex+"}/{attach}", makeHandler(sc, privs, (*handler).handleGetAttachment)).Methods("GET", "HEAD")
-	dbr.Handle("/{docid:"+docRegex+"}/{attach}", makeHandler(sc, privs, (*handler).handlePutAttachment)).Methods("PUT")
+	dbr.Handle("/{docid:"+docRegex+"}/{attach}", makeHandler(sc, privs, writeMethod((*handler).handlePutAttachment))).Methods("PUT")
 
 	// Session/login URLs are per-database (unlike in CouchDB)
 	// These have public privileges so that they can be called without being logged in already
@@ -115,18 +121,30 @@ func createHandler(sc *ServerContext, privs handlerPrivs) (*mux.Router, *mux.Rou
 
 // Creates the HTTP handler for the public API of a gateway server.
 func CreatePublicHandler(sc *ServerContext) http.Handler {
+	return wrapRouter(sc, regularPrivs, createPublicRouter(sc))
+}
+
+// Creates the router for the public REST API of a gateway server.
+func createPublicRouter(sc *ServerContext) *mux.Router {
 	r, dbr := createHandler(sc, regularPrivs)
 
 	dbr.Handle("/_session", makeHandler(sc, publicPrivs,
 		(*handler).handleSessionPOST)).Methods("POST")
 	dbr.Handle("/_session", makeHandler(sc, regularPrivs,
 		(*handler).handleSessionDELETE)).Methods("DELETE")
+
+	dbr.Handle("/_signup", makeHandler(sc, publicPrivs, writeMethod((*handler).handleSignup))).Methods("POST")
+
+	// Push notification registrations of the current user's devices
+	dbr.Handle("/_push_registration", makeHandler(sc, regularPrivs, (*handler).handleGetPushRegistrations)).Methods("GET", "HEAD")
+	dbr.Handle("/_push_registration", makeHandler(sc, regularPrivs, (*handler).handlePutPushRegistration)).Methods("PUT")
+	dbr.Handle("/_push_registration/{token}", makeHandler(sc, regularPrivs, (*handler).handleDeletePushRegistration)).Methods("DELETE")
 	// The routine below is part of the CouchDB REST API, users can't create DB's via the pblic API
 	// but if the client set the 'createTarget' property of the Replicatior SG should return HTTP status 412
 	// if the db exists, and 403 if it doesn't.
 	r.Handle("/{targetdb:"+dbRegex+"}/",
 		makeHandler(sc, publicPrivs, (*handler).handleCreateTarget)).Methods("PUT")
-	return wrapRouter(sc, regularPrivs, r)
+	return r
 }
 
 //////// ADMIN API:
@@ -179,6 +197,8 @@ func CreateAdminRouter(sc *ServerContext) *mux.Router {
 	dbr.Handle("/_user/{name}",
 		makeHandler(sc, adminPrivs, (*handler).deleteUser)).Methods("DELETE")
 
+	dbr.Handle("/_user/{name}/_access_audit",
+		makeHandler(sc, adminPrivs, (*handler).getUserAccessAudit)).Methods("GET")
 	dbr.Handle("/_user/{name}/_session",
 		makeHandler(sc, adminPrivs, (*handler).deleteUserSessions)).Methods("DELETE")
 	dbr.Handle("/_user/{name}/_session/{sessionid}",
@@ -194,11 +214,29 @@ func CreateAdminRouter(sc *ServerContext) *mux.Router {
 		makeHandler(sc, adminPrivs, (*handler).putRole)).Methods("PUT")
 	dbr.Handle("/_role/{name}",
 		makeHandler(sc, adminPrivs, (*handler).deleteRole)).Methods("DELETE")
+	dbr.Handle("/_role/{name}/_access_audit",
+		makeHandler(sc, adminPrivs, (*handler).getRoleAccessAudit)).Methods("GET")
+	dbr.Handle("/_principals",
+		makeHandler(sc, adminPrivs, (*handler).handleExportPrincipals)).Methods("GET")
+	dbr.Handle("/_principals",
+		makeHandler(sc, adminPrivs, (*handler).handleImportPrincipals)).Methods("POST")
+	dbr.Handle("/_channel/{name}/_access",
+		makeHandler(sc, adminPrivs, (*handler).handleGetChannelAccess)).Methods("GET")
+	dbr.Handle("/_channel_grants",
+		makeHandler(sc, adminPrivs, (*handler).handleChannelGrants)).Methods("POST")
+	dbr.Handle("/_usage",
+		makeHandler(sc, adminPrivs, (*handler).handleGetUsage)).Methods("GET")
+	dbr.Handle("/_channel_sizes",
+		makeHandler(sc, adminPrivs, (*handler).handleGetChannelSizes)).Methods("GET")
+	dbr.Handle("/_cache_notify",
+		makeHandler(sc, adminPrivs, (*handler).handleCacheNotify)).Methods("POST")
 
 	r.Handle("/_logging",
 		makeHandler(sc, adminPrivs, (*handler).handleGetLogging)).Methods("GET")
 	r.Handle("/_logging",
 		makeHandler(sc, adminPrivs, (*handler).handleSetLogging)).Methods("PUT", "POST")
+	r.Handle("/_logging/export",
+		makeHandler(sc, adminPrivs, (*handler).handleLogExport)).Methods("POST")
 	r.Handle("/_profile/{profilename}",
 		makeHandler(sc, adminPrivs, (*handler).handleProfiling)).Methods("POST")
 	r.Handle("/_profile",
@@ -211,6 +249,14 @@ func CreateAdminRouter(sc *ServerContext) *mux.Router {
 		makeHandler(sc, adminPrivs, (*handler).handleExpvar)).Methods("GET")
 	r.Handle("/_config",
 		makeHandler(sc, adminPrivs, (*handler).handleGetConfig)).Methods("GET")
+	r.Handle("/_alias/",
+		makeHandler(sc, adminPrivs, (*handler).handleGetDatabaseAliases)).Methods("GET", "HEAD")
+	r.Handle("/_alias/{alias}",
+		makeHandler(sc, adminPrivs, (*handler).handleGetDatabaseAlias)).Methods("GET", "HEAD")
+	r.Handle("/_alias/{alias}",
+		makeHandler(sc, adminPrivs, (*handler).handlePutDatabaseAlias)).Methods("PUT")
+	r.Handle("/_alias/{alias}",
+		makeHandler(sc, adminPrivs, (*handler).handleDeleteDatabaseAlias)).Methods("DELETE")
 	r.Handle("/_replicate",
 		makeOfflineHandler(sc, adminPrivs, (*handler).handleReplicate)).Methods("POST")
 	r.Handle("/_active_tasks",
@@ -222,6 +268,8 @@ func CreateAdminRouter(sc *ServerContext) *mux.Router {
 		makeHandler(sc, adminPrivs, (*handler).handleSGCollectCancel)).Methods("DELETE")
 	r.Handle("/_sgcollect_info",
 		makeHandler(sc, adminPrivs, (*handler).handleSGCollect)).Methods("POST")
+	r.Handle("/_diagnostics",
+		makeHandler(sc, adminPrivs, (*handler).handleDiagnostics)).Methods("POST")
 
 	// Debugging handlers
 	r.Handle("/_debug/pprof/goroutine",
@@ -238,10 +286,14 @@ func CreateAdminRouter(sc *ServerContext) *mux.Router {
 		makeHandler(sc, adminPrivs, (*handler).handlePprofBlock)).Methods("GET", "POST")
 	r.Handle("/_debug/pprof/threadcreate",
 		makeHandler(sc, adminPrivs, (*handler).handlePprofThreadcreate)).Methods("GET", "POST")
+	r.Handle("/_debug/pprof/mutex",
+		makeHandler(sc, adminPrivs, (*handler).handlePprofMutex)).Methods("GET", "POST")
 	r.Handle("/_debug/pprof/trace",
 		makeHandler(sc, adminPrivs, (*handler).handlePprofTrace)).Methods("GET", "POST")
 	r.Handle("/_post_upgrade",
 		makeHandler(sc, adminPrivs, (*handler).handlePostUpgrade)).Methods("POST")
+	r.Handle("/_openapi",
+		makeHandler(sc, adminPrivs, (*handler).handleOpenAPI)).Methods("GET")
 
 	// Database-relative handlers:
 	dbr.Handle("/_config",
@@ -250,12 +302,34 @@ func CreateAdminRouter(sc *ServerContext) *mux.Router {
 		makeOfflineHandler(sc, adminPrivs, (*handler).handlePutDbConfig)).Methods("PUT")
 	dbr.Handle("/_resync",
 		makeOfflineHandler(sc, adminPrivs, (*handler).handleResync)).Methods("POST")
+	dbr.Handle("/_resolve_conflicts",
+		makeHandler(sc, adminPrivs, (*handler).handleResolveConflicts)).Methods("POST")
 	dbr.Handle("/_vacuum",
 		makeHandler(sc, adminPrivs, (*handler).handleVacuum)).Methods("POST")
+	dbr.Handle("/_clean_metadata",
+		makeHandler(sc, adminPrivs, (*handler).handleCleanMetadata)).Methods("POST")
 	dbr.Handle("/_purge",
 		makeHandler(sc, adminPrivs, (*handler).handlePurge)).Methods("POST")
+	dbr.Handle("/_tasks",
+		makeOfflineHandler(sc, adminPrivs, (*handler).handleGetBackgroundTasks)).Methods("GET")
+	dbr.Handle("/_tasks/{taskid}",
+		makeOfflineHandler(sc, adminPrivs, (*handler).handleBackgroundTaskAction)).Methods("POST")
+	dbr.Handle("/_trash",
+		makeHandler(sc, adminPrivs, (*handler).handleGetTrash)).Methods("GET")
+	dbr.Handle("/_trash/{docid:"+docRegex+"}/_restore",
+		makeHandler(sc, adminPrivs, (*handler).handleRestoreFromTrash)).Methods("POST")
 	dbr.Handle("/_flush",
 		makeHandler(sc, adminPrivs, (*handler).handleFlush)).Methods("POST")
+	dbr.Handle("/_snapshot",
+		makeHandler(sc, adminPrivs, (*handler).handleGetSnapshot)).Methods("GET")
+	dbr.Handle("/_restore",
+		makeOfflineHandler(sc, adminPrivs, (*handler).handleRestoreSnapshot)).Methods("POST")
+	dbr.Handle("/_metadata_backup",
+		makeOfflineHandler(sc, adminPrivs, (*handler).handleMetadataBackup)).Methods("GET")
+	dbr.Handle("/_metadata_restore",
+		makeOfflineHandler(sc, adminPrivs, (*handler).handleMetadataRestore)).Methods("POST")
+	dbr.Handle("/_repair_sequences",
+		makeOfflineHandler(sc, adminPrivs, (*handler).handleRepairSequences)).Methods("POST")
 	dbr.Handle("/_online",
 		makeOfflineHandler(sc, adminPrivs, (*handler).handleDbOnline)).Methods("POST")
 	dbr.Handle("/_offline",
@@ -263,7 +337,7 @@ func CreateAdminRouter(sc *ServerContext) *mux.Router {
 	dbr.Handle("/_dump/{view}",
 		makeHandler(sc, adminPrivs, (*handler).handleDump)).Methods("GET")
 	dbr.Handle("/_view/{view}", // redundant; just for backward compatibility with 1.0
-		makeHandler(sc, adminPrivs, (*handler).handleView)).Methods("GET")
+		makeHandler(sc, adminPrivs, cachedQueryMethod((*handler).handleView))).Methods("GET")
 	dbr.Handle("/_dumpchannel/{channel}",
 		makeHandler(sc, adminPrivs, (*handler).handleDumpChannel)).Methods("GET")
 	dbr.Handle("/_index",
@@ -295,6 +369,10 @@ func CreateAdminRouter(sc *ServerContext) *mux.Router {
 // for URLs that don't match a route.
 func wrapRouter(sc *ServerContext, privs handlerPrivs, router *mux.Router) http.Handler {
 	return http.HandlerFunc(func(response http.ResponseWriter, rq *http.Request) {
+		if privs == adminPrivs && !sc.checkAdminIPFilter(response, rq) {
+			return
+		}
+
 		FixQuotedSlashes(rq)
 		var match mux.RouteMatch
 
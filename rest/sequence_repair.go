@@ -0,0 +1,72 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbase/sync_gateway/db"
+)
+
+// POST /db/_repair_sequences fast-forwards the sequence counter past the highest sequence in the database's docs
+// and principals, if it's behind.  ?margin= skips that many more sequences, and ?preview=true only reports what
+// would be done.  Unless previewing, the database must be offline.
+func (h *handler) handleRepairSequences() error {
+	h.assertAdminOnly()
+	preview := h.getBoolQuery("preview")
+	if !preview && atomic.LoadUint32(&h.db.State) != db.DBOffline {
+		return base.CodedHTTPErrorf(http.StatusServiceUnavailable, base.ErrorCodeDatabaseNotOffline, "Database must be _offline before calling /_repair_sequences")
+	}
+
+	result, err := h.db.RepairSequenceCounter(h.getIntQuery("margin", 0), preview)
+	if err != nil {
+		return err
+	}
+	if !preview {
+		h.setAuditSummary(nil, result)
+	}
+	h.writeJSON(result)
+	return nil
+}
+
+// Repairs the sequence counter of a database on the Sync Gateway listening on adminAddr, which must be offline
+// unless previewing.  Used by the -repair-sequences command line flag; returns the process exit code.
+func RepairSequencesOnServer(adminAddr string, dbName string, margin uint64, preview bool, w io.Writer) int {
+	repairURL := adminAPIURL(adminAddr, "/"+url.PathEscape(dbName)+"/_repair_sequences") +
+		"?margin=" + strconv.FormatUint(margin, 10) + "&preview=" + strconv.FormatBool(preview)
+	resp, err := http.Post(repairURL, "application/json", nil)
+	if err != nil {
+		fmt.Fprintf(w, "Error connecting to the admin API at %s: %v\n", base.RedactBasicAuthURL(adminAddr), err)
+		return 1
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintf(w, "Error repairing sequences: %s %s\n", resp.Status, body)
+		return 1
+	}
+
+	var result db.SequenceRepairResult
+	if err := json.Unmarshal(body, &result); err != nil {
+		fmt.Fprintf(w, "Error reading repair result: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(w, "Sequence counter: %d\n", result.Counter)
+	fmt.Fprintf(w, "Highest doc sequence ahead of the counter: %d\n", result.MaxDocSequence)
+	fmt.Fprintf(w, "Highest user or role sequence: %d\n", result.MaxPrincipalSequence)
+	switch {
+	case result.DocsAhead == 0:
+		fmt.Fprintln(w, "The sequence counter doesn't need repairing")
+	case preview:
+		fmt.Fprintf(w, "%d docs and principals are ahead of the counter, which would be fast-forwarded to %d\n", result.DocsAhead, result.NewCounter)
+	default:
+		fmt.Fprintf(w, "%d docs and principals were ahead of the counter, which was fast-forwarded to %d\n", result.DocsAhead, result.NewCounter)
+	}
+	return 0
+}
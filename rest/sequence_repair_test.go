@@ -0,0 +1,61 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/couchbase/sync_gateway/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRepairSequences(t *testing.T) {
+	rt := RestTester{}
+	defer rt.Close()
+
+	assertStatus(t, rt.SendAdminRequest("PUT", "/db/doc1", `{"value":1}`), http.StatusCreated)
+	assertStatus(t, rt.SendAdminRequest("PUT", "/db/doc2", `{"value":2}`), http.StatusCreated)
+	assertStatus(t, rt.SendAdminRequest("PUT", "/db/_user/alice", `{"password":"letmein"}`), http.StatusCreated)
+	bucket := rt.Bucket()
+	maxSeq, err := bucket.Incr(db.SyncSeqKey, 0, 0, 0)
+	assert.NoError(t, err)
+
+	// Roll the counter back, as restoring an older backup of the bucket would
+	assert.NoError(t, bucket.Delete(db.SyncSeqKey))
+	_, err = bucket.Incr(db.SyncSeqKey, 1, 1, 0)
+	assert.NoError(t, err)
+
+	repair := func(query string, expectedStatus int) (result db.SequenceRepairResult) {
+		response := rt.SendAdminRequest("POST", "/db/_repair_sequences"+query, "")
+		assertStatus(t, response, expectedStatus)
+		if expectedStatus == http.StatusOK {
+			assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &result))
+		}
+		return result
+	}
+
+	// Previewing doesn't need the database to be offline, and doesn't change the counter
+	result := repair("?preview=true", http.StatusOK)
+	assert.Equal(t, uint64(1), result.Counter)
+	assert.True(t, result.DocsAhead >= 2) // doc2 and alice
+	assert.Equal(t, maxSeq, result.NewCounter)
+	assert.False(t, result.Repaired)
+	counter, err := bucket.Incr(db.SyncSeqKey, 0, 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), counter)
+
+	repair("", http.StatusServiceUnavailable)
+
+	assertStatus(t, rt.SendAdminRequest("POST", "/db/_offline", ""), http.StatusOK)
+	result = repair("?margin=10", http.StatusOK)
+	assert.True(t, result.Repaired)
+	assert.Equal(t, maxSeq+10, result.NewCounter)
+	counter, err = bucket.Incr(db.SyncSeqKey, 0, 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, maxSeq+10, counter)
+
+	// Once repaired, nothing is ahead of the counter
+	result = repair("", http.StatusOK)
+	assert.Equal(t, 0, result.DocsAhead)
+	assert.False(t, result.Repaired)
+}
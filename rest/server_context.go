@@ -25,6 +25,7 @@ import (
 	"time"
 
 	"github.com/couchbase/go-couchbase"
+	"github.com/couchbase/sync_gateway/auth"
 	"github.com/couchbase/sync_gateway/base"
 	"github.com/couchbase/sync_gateway/db"
 	pkgerrors "github.com/pkg/errors"
@@ -37,17 +38,28 @@ const kDefaultSlowQueryWarningThreshold = 500 // ms
 const KDefaultNumShards = 16
 const DefaultStatsLogFrequencySecs = 60
 
+var keyPrefixRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_\-.:]*$`)
+
 // Shared context of HTTP handlers: primarily a registry of databases by name. It also stores
 // the configuration settings so handlers can refer to them.
 // This struct is accessed from HTTP handlers running on multiple goroutines, so it needs to
 // be thread-safe.
 type ServerContext struct {
-	config       *ServerConfig
-	databases_   map[string]*db.DatabaseContext
-	lock         sync.RWMutex
-	statsContext *statsContext
-	HTTPClient   *http.Client
-	replicator   *base.Replicator
+	config        *ServerConfig
+	databases_    map[string]*db.DatabaseContext
+	lock          sync.RWMutex
+	statsContext  *statsContext
+	HTTPClient    *http.Client
+	replicator    *base.Replicator
+	auditLogger   *base.AuditLogger
+	rateLimiter   *rateLimiter   // Enforces the public API's rate limits, or nil if none are set
+	adminIPFilter *adminIPFilter // Restricts the addresses the admin API accepts requests from, or nil if unrestricted
+	signupLimiter *rateLimiter   // Limits the signups from each client IP address, with each database's budget
+
+	databasesDirWatcher *databasesDirWatcher
+	configBucketWatcher *configBucketWatcher
+
+	healthCache healthCheckCache // The latest results of /_health
 }
 
 func NewServerContext(config *ServerConfig) *ServerContext {
@@ -57,7 +69,9 @@ func NewServerContext(config *ServerConfig) *ServerContext {
 		HTTPClient:   http.DefaultClient,
 		replicator:   base.NewReplicator(),
 		statsContext: &statsContext{},
+		rateLimiter:  newRateLimiter(config.RateLimits),
 	}
+	sc.signupLimiter = newSignupRateLimiter(config.RateLimits)
 	if config.Databases == nil {
 		config.Databases = DbConfigMap{}
 	}
@@ -82,6 +96,21 @@ func NewServerContext(config *ServerConfig) *ServerContext {
 	}
 	base.SlowQueryWarningThreshold = time.Duration(slowQuery) * time.Millisecond
 
+	if thresholds := config.SlowOperationThresholds; thresholds != nil {
+		if thresholds.QueryMs != nil {
+			base.SlowQueryWarningThreshold = time.Duration(*thresholds.QueryMs) * time.Millisecond
+		}
+		if thresholds.BucketOpMs != nil {
+			base.SlowBucketOpWarningThreshold = time.Duration(*thresholds.BucketOpMs) * time.Millisecond
+		}
+		if thresholds.SyncFunctionMs != nil {
+			base.SlowSyncFunctionWarningThreshold = time.Duration(*thresholds.SyncFunctionMs) * time.Millisecond
+		}
+		if thresholds.ChangesMs != nil {
+			base.SlowChangesWarningThreshold = time.Duration(*thresholds.ChangesMs) * time.Millisecond
+		}
+	}
+
 	sc.startStatsLogger()
 
 	return sc
@@ -139,6 +168,9 @@ func (sc *ServerContext) FindDbByBucketName(bucketName string) string {
 }
 
 func (sc *ServerContext) Close() {
+	sc.stopDatabasesDirWatcher()
+	sc.stopConfigBucketWatcher()
+
 	sc.lock.Lock()
 	defer sc.lock.Unlock()
 
@@ -147,12 +179,14 @@ func (sc *ServerContext) Close() {
 	}
 
 	sc.stopStatsLogger()
+	sc.stopAuditLogger()
 
 	for _, ctx := range sc.databases_ {
 		ctx.Close()
 		if ctx.EventMgr.HasHandlerForEvent(db.DBStateChange) {
 			ctx.EventMgr.RaiseDBStateChangeEvent(ctx.Name, "offline", "Database context closed", *sc.config.AdminInterface)
 		}
+		ctx.NotifyLifecycle(db.DBLifecycleStatus{Transition: db.DBLifecycleOffline, Reason: "Database context closed"})
 	}
 
 	sc.databases_ = nil
@@ -163,9 +197,15 @@ func (sc *ServerContext) Close() {
 func (sc *ServerContext) GetDatabase(name string) (*db.DatabaseContext, error) {
 	sc.lock.RLock()
 	dbc := sc.databases_[name]
+	target, isAlias := sc.config.DatabaseAliases[name]
+	if dbc == nil && isAlias {
+		dbc = sc.databases_[target]
+	}
 	sc.lock.RUnlock()
 	if dbc != nil {
 		return dbc, nil
+	} else if isAlias {
+		return nil, base.HTTPErrorf(http.StatusNotFound, "alias %q points to a missing database %q", name, target)
 	} else if db.ValidateDatabaseName(name) != nil {
 		return nil, base.HTTPErrorf(http.StatusBadRequest, "invalid database name %q", name)
 	} else if sc.config.ConfigServer == nil {
@@ -328,9 +368,259 @@ func GetBucketSpec(config *DbConfig) (spec base.BucketSpec, err error) {
 		operationTimeout := time.Millisecond * time.Duration(*config.BucketOpTimeoutMs)
 		spec.BucketOpTimeout = &operationTimeout
 	}
+
+	if config.BucketOpRetries != nil {
+		if err := config.BucketOpRetries.Validate(); err != nil {
+			return spec, err
+		}
+		spec.RetryPolicy = config.BucketOpRetries
+	}
+
+	if config.BucketOpCircuitBreaker != nil {
+		if err := config.BucketOpCircuitBreaker.Validate(); err != nil {
+			return spec, err
+		}
+		spec.CircuitBreaker = config.BucketOpCircuitBreaker
+	}
+
+	if err := spec.ValidateTLS(); err != nil {
+		return spec, err
+	}
+
+	if config.WalrusSnapshot != nil {
+		if !spec.IsWalrusBucket() {
+			return spec, fmt.Errorf("walrus_snapshot is only supported for walrus buckets")
+		}
+		spec.WalrusSnapshotPath = config.WalrusSnapshot.Path
+		if config.WalrusSnapshot.IntervalSecs != nil {
+			spec.WalrusSnapshotInterval = time.Second * time.Duration(*config.WalrusSnapshot.IntervalSecs)
+		}
+	}
 	return spec, nil
 }
 
+// Returns the spec of the database's metadata bucket, or nil if Sync Gateway's internal docs are stored in the data
+// bucket.  Unless it specifies a server, the metadata bucket is on the same server as the data bucket, and it uses the
+// data bucket's feed type and bucket op settings.
+func GetMetadataBucketSpec(config *DbConfig, dataSpec base.BucketSpec) (*base.BucketSpec, error) {
+	if config.MetadataBucket == nil {
+		return nil, nil
+	}
+	return getSecondaryBucketSpec("metadata_bucket", *config.MetadataBucket, dataSpec)
+}
+
+// Returns the spec of the separate bucket the shared revision cache is stored in, or nil if none is configured.  The
+// bucket defaults to the data bucket's server and settings, like the metadata bucket.
+func GetSharedRevCacheBucketSpec(config *DbConfig, dataSpec base.BucketSpec) (*base.BucketSpec, error) {
+	if config.SharedRevCache == nil || config.SharedRevCache.Bucket == nil {
+		return nil, nil
+	}
+	return getSecondaryBucketSpec("shared_rev_cache.bucket", *config.SharedRevCache.Bucket, dataSpec)
+}
+
+// Returns the spec of the bucket old revision bodies are moved to, or nil if cold storage isn't configured.  The bucket
+// defaults to the data bucket's server and settings, like the metadata bucket.
+func GetColdStorageBucketSpec(config *DbConfig, dataSpec base.BucketSpec) (*base.BucketSpec, error) {
+	if config.ColdStorage == nil || config.ColdStorage.Enabled == nil || !*config.ColdStorage.Enabled {
+		return nil, nil
+	}
+	if config.ColdStorage.Bucket == nil {
+		return nil, errors.New("cold_storage.bucket is required when cold storage is enabled")
+	}
+	return getSecondaryBucketSpec("cold_storage.bucket", *config.ColdStorage.Bucket, dataSpec)
+}
+
+// Returns the spec of a bucket the database uses besides its data bucket, configured by the given option.
+func getSecondaryBucketSpec(option string, bucketConfig BucketConfig, dataSpec base.BucketSpec) (*base.BucketSpec, error) {
+	if bucketConfig.Bucket == nil || *bucketConfig.Bucket == "" {
+		return nil, fmt.Errorf("%s must specify a bucket", option)
+	}
+
+	if bucketConfig.Server == nil {
+		bucketConfig.Server = &dataSpec.Server
+	}
+	spec := bucketConfig.MakeBucketSpec()
+	if spec.Server == dataSpec.Server && spec.BucketName == dataSpec.BucketName {
+		return nil, fmt.Errorf("%s must be a different bucket from the database's bucket", option)
+	}
+	if err := spec.ValidateTLS(); err != nil {
+		return nil, err
+	}
+
+	spec.FeedType = dataSpec.FeedType
+	spec.CouchbaseDriver = dataSpec.CouchbaseDriver
+	spec.ViewQueryTimeoutSecs = dataSpec.ViewQueryTimeoutSecs
+	spec.BucketOpTimeout = dataSpec.BucketOpTimeout
+	spec.RetryPolicy = dataSpec.RetryPolicy
+	spec.CircuitBreaker = dataSpec.CircuitBreaker
+	spec.DatabaseName = dataSpec.DatabaseName
+	return &spec, nil
+}
+
+// Returns an error if the revs_limit is too low for the database's conflict mode.
+func validateRevsLimit(revsLimit uint32, allowConflicts bool) error {
+	if allowConflicts {
+		if revsLimit < 20 {
+			return fmt.Errorf("The revs_limit (%v) value in your Sync Gateway configuration cannot be set lower than 20.", revsLimit)
+		}
+
+		if revsLimit < 100 {
+			base.Warnf(base.KeyAll, "Setting the revs_limit (%v) to less than 100 may have unwanted results when documents are frequently updated. Please see documentation for details.", revsLimit)
+		}
+	} else {
+		if revsLimit <= 0 {
+			return fmt.Errorf("The revs_limit (%v) value in your Sync Gateway configuration must be greater than zero.", revsLimit)
+		}
+	}
+	return nil
+}
+
+// Validates a revs_limit override from the config, and compiles its doc ID pattern.
+func makeRevsLimitOverride(config RevsLimitOverrideConfig, allowConflicts bool) (override db.RevsLimitOverride, err error) {
+	if config.DocIDPattern == "" && config.DocType == "" {
+		return override, fmt.Errorf("doc_id_pattern or doc_type must be set")
+	}
+	if config.RevsLimit == nil {
+		return override, fmt.Errorf("revs_limit must be set")
+	}
+	if err := validateRevsLimit(*config.RevsLimit, allowConflicts); err != nil {
+		return override, err
+	}
+	if config.DocIDPattern != "" {
+		if override.DocIDPattern, err = regexp.Compile(config.DocIDPattern); err != nil {
+			return override, fmt.Errorf("invalid doc_id_pattern: %v", err)
+		}
+	}
+	override.DocType = config.DocType
+	override.RevsLimit = *config.RevsLimit
+	return override, nil
+}
+
+// Compiles the JSON Schemas of the document types in a database's config.
+func makeDocSchemas(config *SchemasConfig) (*db.DocSchemas, error) {
+	schemas := &db.DocSchemas{TypeProperty: "type", Types: make(map[string]*db.JSONSchema, len(config.Types))}
+	if config.TypeProperty != "" {
+		schemas.TypeProperty = config.TypeProperty
+	}
+	for docType, schema := range config.Types {
+		compiled, err := db.NewJSONSchema(schema)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid schema for document type %q: %v", docType, err)
+		}
+		schemas.Types[docType] = compiled
+	}
+	return schemas, nil
+}
+
+// Validates a database's read transform config, and compiles its function.
+func makeReadTransform(config *ReadTransformConfig) (*db.ReadTransform, error) {
+	transform := &db.ReadTransform{}
+	for i, maskConfig := range config.Masks {
+		if len(maskConfig.Properties) == 0 {
+			return nil, fmt.Errorf("read_transform.masks[%d]: properties must be set", i)
+		}
+		mask := db.PropertyMask{DocType: maskConfig.DocType, VisibleToRoles: maskConfig.VisibleToRoles}
+		for _, property := range maskConfig.Properties {
+			path := strings.Split(property, ".")
+			for _, name := range path {
+				if name == "" || strings.HasPrefix(name, "_") {
+					return nil, fmt.Errorf("read_transform.masks[%d]: invalid property %q", i, property)
+				}
+			}
+			mask.Properties = append(mask.Properties, path)
+		}
+		transform.Masks = append(transform.Masks, mask)
+	}
+	if config.Function != nil {
+		if err := db.ValidateReadTransformFunction(*config.Function); err != nil {
+			return nil, fmt.Errorf("Error compiling read transform function: %v", err)
+		}
+		transform.Function = db.NewReadTransformFunction(*config.Function)
+	}
+	return transform, nil
+}
+
+// Validates a channel cache override from the config, and compiles its channel pattern.  A channel name is matched
+// exactly.
+func makeChannelCacheOverride(config ChannelCacheOverrideConfig) (override db.ChannelCacheOverride, err error) {
+	if (config.Channel == "") == (config.ChannelPattern == "") {
+		return override, fmt.Errorf("one of channel or channel_pattern must be set")
+	}
+	pattern := config.ChannelPattern
+	if config.Channel != "" {
+		pattern = "^" + regexp.QuoteMeta(config.Channel) + "$"
+	}
+	if override.ChannelPattern, err = regexp.Compile(pattern); err != nil {
+		return override, fmt.Errorf("invalid channel_pattern: %v", err)
+	}
+
+	if config.ChannelCacheMaxLength != nil {
+		if *config.ChannelCacheMaxLength <= 0 {
+			return override, fmt.Errorf("channel_cache_max_length must be greater than zero")
+		}
+		override.ChannelCacheMaxLength = *config.ChannelCacheMaxLength
+	}
+	if config.ChannelCacheMinLength != nil {
+		if *config.ChannelCacheMinLength <= 0 {
+			return override, fmt.Errorf("channel_cache_min_length must be greater than zero")
+		}
+		override.ChannelCacheMinLength = *config.ChannelCacheMinLength
+	}
+	if config.ChannelCacheAge != nil {
+		if *config.ChannelCacheAge <= 0 {
+			return override, fmt.Errorf("channel_cache_expiry must be greater than zero")
+		}
+		override.ChannelCacheAge = time.Duration(*config.ChannelCacheAge) * time.Second
+	}
+	if override.ChannelCacheOptions == (db.ChannelCacheOptions{}) {
+		return override, fmt.Errorf("no cache settings to override")
+	}
+	return override, nil
+}
+
+// Returns an error if the database's key_prefix is invalid, or used with features that don't support it: N1QL queries
+// would span all the bucket's namespaces, import accesses the underlying Couchbase bucket directly, and channel index
+// buckets aren't namespaced.
+func validateKeyPrefix(config *DbConfig, useViews bool) error {
+	if !keyPrefixRegexp.MatchString(config.KeyPrefix) {
+		return fmt.Errorf("key_prefix %q must start with a letter or digit, and only contain letters, digits and any of '_-.:'", config.KeyPrefix)
+	}
+	if !useViews {
+		return fmt.Errorf("key_prefix requires use_views")
+	}
+	if config.UseXattrs() {
+		return fmt.Errorf("key_prefix is not supported with enable_shared_bucket_access")
+	}
+	if config.ChannelIndex != nil {
+		return fmt.Errorf("key_prefix is not supported with channel_index")
+	}
+	return nil
+}
+
+// Initializes the views or GSI indexes used by Sync Gateway on the given bucket.
+func initializeViewsOrIndexes(bucket base.Bucket, config *DbConfig, useViews bool) error {
+	if useViews {
+		return db.InitializeViews(bucket)
+	}
+
+	// Couchbase Server version must be 5.5 or higher to use GSI
+	gsiSupported, errServerVersion := base.IsMinimumServerVersion(bucket, 5, 5)
+	if errServerVersion != nil {
+		return errServerVersion
+	}
+
+	if !gsiSupported {
+		return errors.New("Couchbase Server version must be 5.5 or higher for Sync Gateway to use GSI.  Upgrade the server, or set 'use_views':true in Sync Gateway's database config.")
+	}
+
+	numReplicas := DefaultNumIndexReplicas
+	if config.NumIndexReplicas != nil {
+		numReplicas = *config.NumIndexReplicas
+	}
+
+	return db.InitializeIndexes(bucket, config.UseXattrs(), numReplicas)
+}
+
 // Adds a database to the ServerContext.  Attempts a read after it gets the write
 // lock to see if it's already been added by another process. If so, returns either the
 // existing DatabaseContext or an error based on the useExisting flag.
@@ -338,16 +628,27 @@ func (sc *ServerContext) _getOrAddDatabaseFromConfig(config *DbConfig, useExisti
 
 	oldRevExpirySeconds := base.DefaultOldRevExpirySeconds
 
+	// Secret references are resolved in a copy of the config, so the saved config keeps the references
+	savedConfig := config
+	config, err := config.withResolvedSecrets()
+	if err != nil {
+		return nil, err
+	}
+
 	// Connect to the bucket and add the database:
 	spec, err := GetBucketSpec(config)
 	if err != nil {
 		return nil, err
 	}
-
 	dbName := config.Name
 	if dbName == "" {
 		dbName = spec.BucketName
 	}
+	spec.DatabaseName = dbName
+	metadataSpec, err := GetMetadataBucketSpec(config, spec)
+	if err != nil {
+		return nil, err
+	}
 
 	if config.OldRevExpirySeconds != nil && *config.OldRevExpirySeconds >= 0 {
 		oldRevExpirySeconds = *config.OldRevExpirySeconds
@@ -366,6 +667,9 @@ func (sc *ServerContext) _getOrAddDatabaseFromConfig(config *DbConfig, useExisti
 				"Duplicate database name %q", dbName)
 		}
 	}
+	if _, isAlias := sc.config.DatabaseAliases[dbName]; isAlias {
+		return nil, base.HTTPErrorf(http.StatusPreconditionFailed, "Database name %q is already an alias", dbName)
+	}
 
 	base.Infof(base.KeyAll, "Opening db /%s as bucket %q, pool %q, server <%s>",
 		base.MD(dbName), base.MD(spec.BucketName), base.SD(spec.PoolName), base.SD(spec.Server))
@@ -397,6 +701,9 @@ func (sc *ServerContext) _getOrAddDatabaseFromConfig(config *DbConfig, useExisti
 		if config.CacheConfig.CacheSkippedSeqMaxWait != nil && *config.CacheConfig.CacheSkippedSeqMaxWait > 0 {
 			cacheOptions.CacheSkippedSeqMaxWait = time.Duration(*config.CacheConfig.CacheSkippedSeqMaxWait) * time.Millisecond
 		}
+		if config.CacheConfig.CacheSkippedSeqLookupWait != nil && *config.CacheConfig.CacheSkippedSeqLookupWait > 0 {
+			cacheOptions.CacheSkippedSeqLookupWait = time.Duration(*config.CacheConfig.CacheSkippedSeqLookupWait) * time.Millisecond
+		}
 		// set EnableStarChannelLog directly here (instead of via NewDatabaseContext), so that it's set when we create the channels view in ConnectToBucket
 		if config.CacheConfig.EnableStarChannel != nil {
 			db.EnableStarChannelLog = *config.CacheConfig.EnableStarChannel
@@ -411,6 +718,16 @@ func (sc *ServerContext) _getOrAddDatabaseFromConfig(config *DbConfig, useExisti
 		if config.CacheConfig.ChannelCacheAge != nil && *config.CacheConfig.ChannelCacheAge > 0 {
 			cacheOptions.ChannelCacheAge = time.Duration(*config.CacheConfig.ChannelCacheAge) * time.Second
 		}
+		if config.CacheConfig.ChannelCachePersistInterval != nil && *config.CacheConfig.ChannelCachePersistInterval > 0 {
+			cacheOptions.ChannelCachePersistInterval = time.Duration(*config.CacheConfig.ChannelCachePersistInterval) * time.Second
+		}
+		for i, overrideConfig := range config.CacheConfig.ChannelOverrides {
+			override, err := makeChannelCacheOverride(overrideConfig)
+			if err != nil {
+				return nil, fmt.Errorf("cache.channel_overrides[%d]: %v", i, err)
+			}
+			cacheOptions.ChannelCacheOverrides = append(cacheOptions.ChannelCacheOverrides, override)
+		}
 
 	}
 
@@ -475,32 +792,33 @@ func (sc *ServerContext) _getOrAddDatabaseFromConfig(config *DbConfig, useExisti
 		useViews = true
 	}
 
-	// Initialize Views or GSI indexes
-	if !useViews {
-
-		// Couchbase Server version must be 5.5 or higher to use GSI
-		gsiSupported, errServerVersion := base.IsMinimumServerVersion(bucket, 5, 5)
-		if errServerVersion != nil {
-			return nil, errServerVersion
+	// Namespace the database's keys within a shared bucket
+	if config.KeyPrefix != "" {
+		if err := validateKeyPrefix(config, useViews); err != nil {
+			bucket.Close()
+			return nil, err
 		}
+		bucket = base.NewPrefixedBucket(bucket, config.KeyPrefix)
+	}
 
-		if !gsiSupported {
-			return nil, errors.New("Couchbase Server version must be 5.5 or higher for Sync Gateway to use GSI.  Upgrade the server, or set 'use_views':true in Sync Gateway's database config.")
-		}
+	// Initialize Views or GSI indexes
+	if err := initializeViewsOrIndexes(bucket, config, useViews); err != nil {
+		return nil, err
+	}
 
-		numReplicas := DefaultNumIndexReplicas
-		if config.NumIndexReplicas != nil {
-			numReplicas = *config.NumIndexReplicas
+	// Connect to the metadata bucket, if any.  Principals and sessions are queried on the metadata bucket, so it needs
+	// the same views or indexes.
+	var metadataBucket base.Bucket
+	if metadataSpec != nil {
+		if metadataBucket, err = db.ConnectToBucket(*metadataSpec, nil); err != nil {
+			return nil, err
 		}
-
-		indexErr := db.InitializeIndexes(bucket, config.UseXattrs(), numReplicas)
-		if indexErr != nil {
-			return nil, indexErr
+		if config.KeyPrefix != "" {
+			metadataBucket = base.NewPrefixedBucket(metadataBucket, config.KeyPrefix)
 		}
-	} else {
-		viewErr := db.InitializeViews(bucket)
-		if viewErr != nil {
-			return nil, viewErr
+		if err := initializeViewsOrIndexes(metadataBucket, config, useViews); err != nil {
+			metadataBucket.Close()
+			return nil, err
 		}
 	}
 
@@ -514,6 +832,7 @@ func (sc *ServerContext) _getOrAddDatabaseFromConfig(config *DbConfig, useExisti
 
 		indexSpec := config.ChannelIndex.MakeBucketSpec()
 		indexSpec.CouchbaseDriver = couchbaseDriverIndexBucket
+		indexSpec.DatabaseName = spec.DatabaseName
 
 		if config.ChannelIndex.NumShards != 0 {
 			channelIndexOptions.NumShards = config.ChannelIndex.NumShards
@@ -525,7 +844,9 @@ func (sc *ServerContext) _getOrAddDatabaseFromConfig(config *DbConfig, useExisti
 
 		channelIndexOptions.Spec = indexSpec
 		channelIndexOptions.Writer = config.ChannelIndex.IndexWriter
+		channelIndexOptions.Distributed = config.ChannelIndex.Distributed
 		channelIndexOptions.TombstoneCompactFrequency = config.ChannelIndex.TombstoneCompactFrequency
+		channelIndexOptions.LocalStoreDir = config.ChannelIndex.LocalStoreDir
 
 		// Hash bucket defaults to index bucket, but can be customized.
 		sequenceHashOptions.Size = 32
@@ -571,6 +892,7 @@ func (sc *ServerContext) _getOrAddDatabaseFromConfig(config *DbConfig, useExisti
 	deltaSyncOptions := db.DeltaSyncOptions{
 		Enabled:          db.DefaultDeltaSyncEnabled,
 		RevMaxAgeSeconds: db.DefaultDeltaSyncRevMaxAge,
+		CacheMaxBytes:    db.DefaultDeltaCacheMaxBytes,
 	}
 
 	if config.DeltaSync != nil {
@@ -586,6 +908,13 @@ func (sc *ServerContext) _getOrAddDatabaseFromConfig(config *DbConfig, useExisti
 			}
 			deltaSyncOptions.RevMaxAgeSeconds = *revMaxAge
 		}
+
+		if cacheMaxBytes := config.DeltaSync.CacheMaxBytes; cacheMaxBytes != nil {
+			if *cacheMaxBytes < 0 {
+				return nil, fmt.Errorf("delta_sync.cache_max_bytes: %d must not be negative", *cacheMaxBytes)
+			}
+			deltaSyncOptions.CacheMaxBytes = *cacheMaxBytes
+		}
 	}
 	base.Infof(base.KeyAll, "delta_sync enabled=%t with rev_max_age_seconds=%d for database %s", deltaSyncOptions.Enabled, deltaSyncOptions.RevMaxAgeSeconds, dbName)
 
@@ -620,6 +949,238 @@ func (sc *ServerContext) _getOrAddDatabaseFromConfig(config *DbConfig, useExisti
 		}
 	}
 
+	var usageStatsOptions db.UsageStatsOptions
+	if config.UsageStats != nil {
+		if enable := config.UsageStats.Enabled; enable != nil {
+			usageStatsOptions.Enabled = *enable
+		}
+		if windowSecs := config.UsageStats.WindowSecs; windowSecs != nil {
+			usageStatsOptions.Window = time.Duration(*windowSecs) * time.Second
+		}
+	}
+
+	var tombstonePurgeOptions db.TombstonePurgeOptions
+	if config.TombstonePurge != nil {
+		if !config.UseXattrs() {
+			return nil, fmt.Errorf("tombstone_purge requires enable_shared_bucket_access")
+		}
+		if enable := config.TombstonePurge.Enabled; enable != nil {
+			tombstonePurgeOptions.Enabled = *enable
+		}
+		if intervalHours := config.TombstonePurge.IntervalHours; intervalHours != nil {
+			if *intervalHours <= 0 {
+				return nil, fmt.Errorf("tombstone_purge interval_hours must be greater than zero")
+			}
+			tombstonePurgeOptions.IntervalHours = *intervalHours
+		}
+	}
+
+	var quotaOptions db.QuotaOptions
+	if config.Quotas != nil {
+		if maxDocs := config.Quotas.MaxDocs; maxDocs != nil {
+			quotaOptions.MaxDocs = *maxDocs
+		}
+		if maxAttachmentBytes := config.Quotas.MaxAttachmentBytes; maxAttachmentBytes != nil {
+			quotaOptions.MaxAttachmentBytes = *maxAttachmentBytes
+		}
+		if maxReplications := config.Quotas.MaxConcurrentReplications; maxReplications != nil {
+			quotaOptions.MaxConcurrentReplications = int(*maxReplications)
+		}
+		if maxOpsPerSec := config.Quotas.MaxOpsPerSec; maxOpsPerSec != nil {
+			quotaOptions.MaxOpsPerSec = int(*maxOpsPerSec)
+		}
+	}
+
+	var sessionCookieOptions auth.SessionCookieOptions
+	if config.SessionCookie != nil {
+		if sameSite := config.SessionCookie.SameSite; sameSite != nil {
+			switch strings.ToLower(*sameSite) {
+			case "lax":
+				sessionCookieOptions.SameSite = auth.SameSiteLax
+			case "strict":
+				sessionCookieOptions.SameSite = auth.SameSiteStrict
+			case "none":
+				sessionCookieOptions.SameSite = auth.SameSiteNone
+			default:
+				return nil, fmt.Errorf("session_cookie same_site must be \"lax\", \"strict\" or \"none\"")
+			}
+		}
+		if secure := config.SessionCookie.Secure; secure != nil {
+			sessionCookieOptions.Secure = *secure
+		} else {
+			sessionCookieOptions.Secure = sessionCookieOptions.SameSite == auth.SameSiteNone
+		}
+		if sessionCookieOptions.SameSite == auth.SameSiteNone && !sessionCookieOptions.Secure {
+			return nil, fmt.Errorf("session_cookie secure can't be false when same_site is \"none\", since browsers would reject the cookie")
+		}
+		if httpOnly := config.SessionCookie.HTTPOnly; httpOnly != nil {
+			sessionCookieOptions.HTTPOnly = *httpOnly
+		}
+		if domain := config.SessionCookie.Domain; domain != nil {
+			sessionCookieOptions.Domain = *domain
+		}
+		if path := config.SessionCookie.Path; path != nil {
+			if !strings.HasPrefix(*path, "/") {
+				return nil, fmt.Errorf("session_cookie path must start with \"/\"")
+			}
+			sessionCookieOptions.Path = *path
+		}
+	}
+
+	var csrfOptions db.CSRFOptions
+	if config.CSRF != nil {
+		switch mode := strings.ToLower(config.CSRF.Mode); mode {
+		case db.CSRFModeOrigin, db.CSRFModeToken:
+			csrfOptions.Mode = mode
+		default:
+			return nil, fmt.Errorf("csrf mode must be %q or %q", db.CSRFModeOrigin, db.CSRFModeToken)
+		}
+		for _, origin := range config.CSRF.TrustedOrigins {
+			if u, err := url.Parse(origin); err != nil || u.Scheme == "" || u.Host == "" {
+				return nil, fmt.Errorf("csrf trusted_origins must be origins such as \"https://app.example.com\", not %q", origin)
+			}
+			csrfOptions.TrustedOrigins = append(csrfOptions.TrustedOrigins, strings.TrimSuffix(origin, "/"))
+		}
+	}
+
+	var accessAuditOptions db.AccessAuditOptions
+	if config.AccessAudit != nil {
+		if enabled := config.AccessAudit.Enabled; enabled != nil {
+			accessAuditOptions.Enabled = *enabled
+		}
+		if maxEntries := config.AccessAudit.MaxEntries; maxEntries != nil {
+			accessAuditOptions.MaxEntries = int(*maxEntries)
+		}
+	}
+
+	bodyCompressionOptions := db.BodyCompressionOptions{MinBytes: db.DefaultBodyCompressionMinBytes}
+	if config.BodyCompression != nil {
+		if enabled := config.BodyCompression.Enabled; enabled != nil {
+			bodyCompressionOptions.Enabled = *enabled
+		}
+		if minBytes := config.BodyCompression.MinBytes; minBytes != nil {
+			bodyCompressionOptions.MinBytes = int(*minBytes)
+		}
+		// With shared bucket access, SDK clients and import read the bodies directly
+		if bodyCompressionOptions.Enabled && config.UseXattrs() {
+			return nil, fmt.Errorf("body_compression is not supported with enable_shared_bucket_access")
+		}
+		// N1QL queries (including those of GraphQL resolvers) and user views would see only the compressed body
+		if bodyCompressionOptions.Enabled && len(config.Queries) > 0 {
+			return nil, fmt.Errorf("body_compression is not supported with queries")
+		}
+		if bodyCompressionOptions.Enabled && config.Unsupported.UserViews.Enabled != nil && *config.Unsupported.UserViews.Enabled {
+			return nil, fmt.Errorf("body_compression is not supported with user_views")
+		}
+	}
+
+	var channelSizeOptions db.ChannelSizeOptions
+	if config.ChannelSizes != nil && config.ChannelSizes.Enabled != nil {
+		channelSizeOptions.Enabled = *config.ChannelSizes.Enabled
+	}
+
+	var sharedRevCacheOptions db.SharedRevCacheOptions
+	if config.SharedRevCache != nil {
+		if enabled := config.SharedRevCache.Enabled; enabled != nil {
+			sharedRevCacheOptions.Enabled = *enabled
+		}
+		if expirySecs := config.SharedRevCache.ExpirySecs; expirySecs != nil {
+			sharedRevCacheOptions.ExpirySeconds = *expirySecs
+		}
+		sharedSpec, err := GetSharedRevCacheBucketSpec(config, spec)
+		if err != nil {
+			return nil, err
+		}
+		if sharedRevCacheOptions.Enabled && sharedSpec == nil {
+			return nil, fmt.Errorf("shared_rev_cache.bucket is required when the shared revision cache is enabled")
+		}
+		if sharedRevCacheOptions.Enabled {
+			if sharedRevCacheOptions.Bucket, err = base.GetBucket(*sharedSpec, nil); err != nil {
+				base.Warnf(base.KeyAll, "Error opening shared revision cache bucket %q, pool %q, server <%s>",
+					base.MD(sharedSpec.BucketName), base.SD(sharedSpec.PoolName), base.SD(sharedSpec.Server))
+				return nil, err
+			}
+		}
+	}
+
+	var coldStorageOptions db.ColdStorageOptions
+	coldSpec, err := GetColdStorageBucketSpec(config, spec)
+	if err != nil {
+		return nil, err
+	}
+	if coldSpec != nil {
+		coldStorageOptions.Enabled = true
+		if afterSecs := config.ColdStorage.AfterSecs; afterSecs != nil {
+			coldStorageOptions.AfterSecs = *afterSecs
+		}
+		if coldStorageOptions.Bucket, err = base.GetBucket(*coldSpec, nil); err != nil {
+			base.Warnf(base.KeyAll, "Error opening cold storage bucket %q, pool %q, server <%s>",
+				base.MD(coldSpec.BucketName), base.SD(coldSpec.PoolName), base.SD(coldSpec.Server))
+			return nil, err
+		}
+	}
+
+	signupOptions, err := makeSignupOptions(config.Signup)
+	if err != nil {
+		return nil, err
+	}
+
+	var docSchemas *db.DocSchemas
+	if config.Schemas != nil {
+		if docSchemas, err = makeDocSchemas(config.Schemas); err != nil {
+			return nil, err
+		}
+	}
+
+	namedQueries, err := makeNamedQueries(config.Queries)
+	if err != nil {
+		return nil, err
+	}
+
+	userFunctions, err := makeUserFunctions(config.Functions)
+	if err != nil {
+		return nil, err
+	}
+
+	queryResultCacheOptions, err := makeQueryResultCacheOptions(config.QueryResultCache)
+	if err != nil {
+		return nil, err
+	}
+
+	webSocketCompression, err := makeWebSocketCompressionOptions(config.WebSocketCompression)
+	if err != nil {
+		return nil, err
+	}
+
+	cacheNotifyOptions, err := makeCacheNotifyOptions(config.CacheNotifications)
+	if err != nil {
+		return nil, err
+	}
+
+	trashOptions, err := makeTrashOptions(config.Trash)
+	if err != nil {
+		return nil, err
+	}
+
+	backgroundTaskOptions, err := makeBackgroundTaskOptions(config.BackgroundTasks)
+	if err != nil {
+		return nil, err
+	}
+
+	var graphQLSchema *db.GraphQLSchema
+	if config.GraphQL != nil {
+		if graphQLSchema, err = makeGraphQLSchema(config.GraphQL, config.Queries, config.Functions); err != nil {
+			return nil, err
+		}
+	}
+
+	var readTransform *db.ReadTransform
+	if config.ReadTransform != nil {
+		if readTransform, err = makeReadTransform(config.ReadTransform); err != nil {
+			return nil, err
+		}
+	}
+
 	contextOptions := db.DatabaseContextOptions{
 		CacheOptions:              &cacheOptions,
 		IndexOptions:              channelIndexOptions,
@@ -635,10 +1196,33 @@ func (sc *ServerContext) _getOrAddDatabaseFromConfig(config *DbConfig, useExisti
 		ImportOptions:             importOptions,
 		EnableXattr:               config.UseXattrs(),
 		SessionCookieName:         config.SessionCookieName,
+		SessionCookieOptions:      sessionCookieOptions,
+		CSRFOptions:               csrfOptions,
+		AccessAuditOptions:        accessAuditOptions,
 		AllowConflicts:            config.ConflictsAllowed(),
 		SendWWWAuthenticateHeader: config.SendWWWAuthenticateHeader,
 		UseViews:                  useViews,
 		DeltaSyncOptions:          deltaSyncOptions,
+		UsageStatsOptions:         usageStatsOptions,
+		MetadataBucket:            metadataBucket,
+		TombstonePurgeOptions:     tombstonePurgeOptions,
+		DeterministicRevIDs:       config.DeterministicRevIDs != nil && *config.DeterministicRevIDs,
+		QuotaOptions:              quotaOptions,
+		SharedRevCacheOptions:     sharedRevCacheOptions,
+		BodyCompressionOptions:    bodyCompressionOptions,
+		ChannelSizeOptions:        channelSizeOptions,
+		DocSchemas:                docSchemas,
+		ReadTransform:             readTransform,
+		SignupOptions:             signupOptions,
+		NamedQueries:              namedQueries,
+		UserFunctions:             userFunctions,
+		GraphQLSchema:             graphQLSchema,
+		QueryResultCacheOptions:   queryResultCacheOptions,
+		WebSocketCompression:      webSocketCompression,
+		CacheNotifyOptions:        cacheNotifyOptions,
+		TrashOptions:              trashOptions,
+		ColdStorageOptions:        coldStorageOptions,
+		BackgroundTaskOptions:     backgroundTaskOptions,
 	}
 
 	// Create the DB Context
@@ -658,19 +1242,17 @@ func (sc *ServerContext) _getOrAddDatabaseFromConfig(config *DbConfig, useExisti
 
 	if config.RevsLimit != nil {
 		dbcontext.RevsLimit = *config.RevsLimit
-		if dbcontext.AllowConflicts() {
-			if dbcontext.RevsLimit < 20 {
-				return nil, fmt.Errorf("The revs_limit (%v) value in your Sync Gateway configuration cannot be set lower than 20.", dbcontext.RevsLimit)
-			}
+		if err := validateRevsLimit(dbcontext.RevsLimit, dbcontext.AllowConflicts()); err != nil {
+			return nil, err
+		}
+	}
 
-			if dbcontext.RevsLimit < 100 {
-				base.Warnf(base.KeyAll, "Setting the revs_limit (%v) to less than 100 may have unwanted results when documents are frequently updated. Please see documentation for details.", dbcontext.RevsLimit)
-			}
-		} else {
-			if dbcontext.RevsLimit <= 0 {
-				return nil, fmt.Errorf("The revs_limit (%v) value in your Sync Gateway configuration must be greater than zero.", dbcontext.RevsLimit)
-			}
+	for i, overrideConfig := range config.RevsLimitOverrides {
+		override, err := makeRevsLimitOverride(overrideConfig, dbcontext.AllowConflicts())
+		if err != nil {
+			return nil, fmt.Errorf("revs_limit_overrides[%d]: %v", i, err)
 		}
+		dbcontext.RevsLimitOverrides = append(dbcontext.RevsLimitOverrides, override)
 	}
 
 	dbcontext.AllowEmptyPassword = config.AllowEmptyPassword
@@ -708,18 +1290,20 @@ func (sc *ServerContext) _getOrAddDatabaseFromConfig(config *DbConfig, useExisti
 	sc.databases_[dbcontext.Name] = dbcontext
 
 	// Save the config
-	sc.config.Databases[dbName] = config
+	sc.config.Databases[dbName] = savedConfig
 
 	if config.StartOffline {
 		atomic.StoreUint32(&dbcontext.State, db.DBOffline)
 		if dbcontext.EventMgr.HasHandlerForEvent(db.DBStateChange) {
 			dbcontext.EventMgr.RaiseDBStateChangeEvent(dbName, "offline", "DB loaded from config", *sc.config.AdminInterface)
 		}
+		dbcontext.NotifyLifecycle(db.DBLifecycleStatus{Transition: db.DBLifecycleOffline, Reason: "DB loaded from config"})
 	} else {
 		atomic.StoreUint32(&dbcontext.State, db.DBOnline)
 		if dbcontext.EventMgr.HasHandlerForEvent(db.DBStateChange) {
 			dbcontext.EventMgr.RaiseDBStateChangeEvent(dbName, "online", "DB loaded from config", *sc.config.AdminInterface)
 		}
+		dbcontext.NotifyLifecycle(db.DBLifecycleStatus{Transition: db.DBLifecycleOnline, Reason: "DB loaded from config"})
 	}
 
 	return dbcontext, nil
@@ -742,7 +1326,9 @@ func (sc *ServerContext) TakeDbOnline(database *db.DatabaseContext) {
 
 		// Reloaded DB should already be online in most cases, but force state to online to handle cases
 		// where config specifies offline startup
-		atomic.StoreUint32(&reloadedDb.State, db.DBOnline)
+		if atomic.SwapUint32(&reloadedDb.State, db.DBOnline) != db.DBOnline {
+			reloadedDb.NotifyLifecycle(db.DBLifecycleStatus{Transition: db.DBLifecycleOnline, Reason: "ADMIN Request"})
+		}
 
 	} else {
 		base.Infof(base.KeyCRUD, "Unable to take Database : %v online , database must be in Offline state", base.UD(database.Name))
@@ -770,7 +1356,7 @@ func (sc *ServerContext) initEventHandlers(dbcontext *db.DatabaseContext, config
 
 		// validate event-related keys
 		for k := range eventHandlersMap {
-			if k != "max_processes" && k != "wait_for_process" && k != "document_changed" && k != "db_state_changed" {
+			if k != "max_processes" && k != "wait_for_process" && k != "document_changed" && k != "db_state_changed" && k != "principal_changed" && k != "replication_changed" && k != "db_lifecycle_changed" && k != "persistent_queue" {
 				return errors.New(fmt.Sprintf("Unsupported event property '%s' defined for db %s", k, dbcontext.Name))
 			}
 		}
@@ -792,6 +1378,21 @@ func (sc *ServerContext) initEventHandlers(dbcontext *db.DatabaseContext, config
 		if err = sc.processEventHandlersForEvent(eventHandlers.DBStateChanged, db.DBStateChange, dbcontext); err != nil {
 			return err
 		}
+
+		// Process principal change event handlers
+		if err = sc.processEventHandlersForEvent(eventHandlers.PrincipalChanged, db.PrincipalChange, dbcontext); err != nil {
+			return err
+		}
+
+		// Process replication event handlers
+		if err = sc.processEventHandlersForEvent(eventHandlers.ReplicationChanged, db.ReplicationChange, dbcontext); err != nil {
+			return err
+		}
+
+		// Process db lifecycle event handlers
+		if err = sc.processEventHandlersForEvent(eventHandlers.DBLifecycleChanged, db.DBLifecycleChange, dbcontext); err != nil {
+			return err
+		}
 		// WaitForProcess uses string, to support both omitempty and zero values
 		customWaitTime := int64(-1)
 		if eventHandlers.WaitForProcess != "" {
@@ -801,6 +1402,20 @@ func (sc *ServerContext) initEventHandlers(dbcontext *db.DatabaseContext, config
 				base.Warnf(base.KeyAll, "Error parsing wait_for_process from config, using default %s", err)
 			}
 		}
+
+		if queueConfig := eventHandlers.PersistentQueue; queueConfig != nil {
+			options := db.PersistentEventQueueOptions{
+				Name:     queueConfig.Name,
+				Overflow: queueConfig.OverflowPolicy,
+				Stats:    dbcontext.DbStats.StatsDatabase(),
+			}
+			if queueConfig.MaxDepth != nil {
+				options.MaxDepth = *queueConfig.MaxDepth
+			}
+			if err := dbcontext.EventMgr.EnablePersistentQueue(dbcontext.MetadataBucket, options); err != nil {
+				return err
+			}
+		}
 		dbcontext.EventMgr.Start(eventHandlers.MaxEventProc, int(customWaitTime))
 
 	}
@@ -818,12 +1433,97 @@ func (sc *ServerContext) processEventHandlersForEvent(events []*EventConfig, eve
 	for _, event := range events {
 		switch event.HandlerType {
 		case "webhook":
-			wh, err := db.NewWebhook(event.Url, event.Filter, event.Timeout)
+			options := db.WebhookOptions{
+				Filter:         event.Filter,
+				Transform:      event.Transform,
+				Template:       event.PayloadTemplate,
+				ContentType:    event.ContentType,
+				Channels:       event.Channels,
+				Timeout:        event.Timeout,
+				DeadLetterUrl:  event.DeadLetterUrl,
+				ClientCertPath: event.ClientCertPath,
+				ClientKeyPath:  event.ClientKeyPath,
+				CACertPath:     event.CACertPath,
+				SigningSecrets: event.SigningSecrets,
+				Stats:          dbcontext.DbStats.StatsDatabase(),
+			}
+			if event.MaxRetries != nil {
+				options.MaxRetries = *event.MaxRetries
+			}
+			if event.RetryBackoff != nil {
+				options.RetryBackoff = time.Duration(*event.RetryBackoff) * time.Millisecond
+			}
+			if event.MaxRetryBackoff != nil {
+				options.MaxRetryBackoff = time.Duration(*event.MaxRetryBackoff) * time.Millisecond
+			}
+			wh, err := db.NewWebhookWithOptions(event.Url, options)
 			if err != nil {
 				base.Warnf(base.KeyAll, "Error creating webhook %v", err)
 				return err
 			}
 			dbcontext.EventMgr.RegisterEventHandler(wh, eventType)
+		case "kafka":
+			if eventType != db.DocumentChange {
+				return fmt.Errorf("kafka event handlers are only supported for document_changed events")
+			}
+			options := db.KafkaSinkOptions{
+				PartitionBy: event.PartitionBy,
+				IncludeBody: event.IncludeBody,
+				Channels:    event.Channels,
+				Timeout:     event.Timeout,
+				Stats:       dbcontext.DbStats.StatsDatabase(),
+			}
+			ks, err := db.NewKafkaSink(event.Brokers, event.Topic, options)
+			if err != nil {
+				base.Warnf(base.KeyAll, "Error creating kafka sink %v", err)
+				return err
+			}
+			dbcontext.EventMgr.RegisterEventHandler(ks, eventType)
+		case "nats":
+			options := db.MessageSinkOptions{Channels: event.Channels, Stats: dbcontext.DbStats.StatsDatabase()}
+			sink, err := db.NewNATSSink(event.Url, event.Subject, options)
+			if err != nil {
+				base.Warnf(base.KeyAll, "Error creating nats sink %v", err)
+				return err
+			}
+			dbcontext.EventMgr.RegisterEventHandler(sink, eventType)
+		case "amqp":
+			options := db.MessageSinkOptions{Channels: event.Channels, Stats: dbcontext.DbStats.StatsDatabase()}
+			sink, err := db.NewAMQPSink(event.Url, event.Exchange, event.RoutingKey, options)
+			if err != nil {
+				base.Warnf(base.KeyAll, "Error creating amqp sink %v", err)
+				return err
+			}
+			dbcontext.EventMgr.RegisterEventHandler(sink, eventType)
+		case "push":
+			if eventType != db.DocumentChange {
+				return fmt.Errorf("push event handlers are only supported for document_changed events")
+			}
+			serverKey, err := base.ResolveSecret(event.FCMServerKey)
+			if err != nil {
+				return err
+			}
+			options := db.PushNotifierOptions{
+				Channels:     event.Channels,
+				APNsURL:      event.Url,
+				APNsTopic:    event.APNsTopic,
+				APNsCertPath: event.APNsCertPath,
+				APNsKeyPath:  event.APNsKeyPath,
+				FCMServerKey: serverKey,
+				Stats:        dbcontext.DbStats.StatsDatabase(),
+			}
+			if event.PushInterval != nil {
+				options.Interval = time.Duration(*event.PushInterval) * time.Millisecond
+			}
+			if event.Timeout != nil {
+				options.Timeout = time.Duration(*event.Timeout) * time.Second
+			}
+			pn, err := db.NewPushNotifier(dbcontext, options)
+			if err != nil {
+				base.Warnf(base.KeyAll, "Error creating push notifier %v", err)
+				return err
+			}
+			dbcontext.EventMgr.RegisterEventHandler(pn, eventType)
 		default:
 			return errors.New(fmt.Sprintf("Unknown event handler type %s", event.HandlerType))
 		}
@@ -901,6 +1601,46 @@ func (sc *ServerContext) RemoveDatabase(dbName string) bool {
 	return sc._removeDatabase(dbName)
 }
 
+// Removes the database with the given name, and its config, so it can't be reloaded.
+func (sc *ServerContext) removeDatabaseAndConfig(dbName string) bool {
+	sc.lock.Lock()
+	defer sc.lock.Unlock()
+
+	delete(sc.config.Databases, dbName)
+	return sc._removeDatabase(dbName)
+}
+
+// Replaces any existing database with the given config's name with one created from the config.  The new database is
+// created before the existing one is closed, so if it can't be, the existing database and its config are kept.
+func (sc *ServerContext) replaceDatabaseFromConfig(config *DbConfig) (*db.DatabaseContext, error) {
+	sc.lock.Lock()
+	defer sc.lock.Unlock()
+
+	oldContext := sc.databases_[config.Name]
+	oldConfig, hadConfig := sc.config.Databases[config.Name]
+	delete(sc.databases_, config.Name) // So that it's not mistaken for a duplicate
+	dbcontext, err := sc._getOrAddDatabaseFromConfig(config, false)
+	if err != nil {
+		if oldContext != nil {
+			sc.databases_[config.Name] = oldContext
+		}
+		if hadConfig {
+			sc.config.Databases[config.Name] = oldConfig
+		} else {
+			delete(sc.config.Databases, config.Name)
+		}
+		return nil, err
+	}
+
+	if oldContext != nil {
+		base.Infof(base.KeyAll, "Closing replaced db /%s (bucket %q)", base.MD(oldContext.Name), base.MD(oldContext.Bucket.GetName()))
+		oldContext.Close()
+		// Closing the old database cleared the stats of its name, which the new database's stats are published under
+		base.PerDbStats.Set(dbcontext.Name, dbcontext.DbStats.ExpvarMap())
+	}
+	return dbcontext, nil
+}
+
 func (sc *ServerContext) _removeDatabase(dbName string) bool {
 
 	context := sc.databases_[dbName]
@@ -992,7 +1732,11 @@ func (sc *ServerContext) getDbConfigFromServer(dbName string) (*DbConfig, error)
 		return nil, err
 	}
 
-	j := json.NewDecoder(bytes.NewReader(base.ConvertBackQuotedStrings(bodyBytes)))
+	if bodyBytes, err = base.ExpandEnvVars(base.ConvertBackQuotedStrings(bodyBytes)); err != nil {
+		return nil, err
+	}
+
+	j := json.NewDecoder(bytes.NewReader(bodyBytes))
 	if err = j.Decode(&config); err != nil {
 		return nil, base.HTTPErrorf(http.StatusBadGateway,
 			"Bad response from config server: %v", err)
@@ -16,8 +16,10 @@ import (
 	"io/ioutil"
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbase/sync_gateway/db"
 	goassert "github.com/couchbaselabs/go.assert"
 	"github.com/stretchr/testify/assert"
 	"sync/atomic"
@@ -167,3 +169,159 @@ func (client *MockClient) RespondToGET(url string, response *http.Response) {
 	tripper := client.Transport.(*mockTripper)
 	tripper.getURLs[url] = response
 }
+
+func TestMetadataBucket(t *testing.T) {
+	sc := NewServerContext(&ServerConfig{})
+	defer sc.Close()
+
+	dataBucketName := "metadata_test_data"
+	metadataBucketName := "metadata_test_meta"
+	dbContext, err := sc.AddDatabaseFromConfig(&DbConfig{
+		Name:           "db",
+		BucketConfig:   BucketConfig{Server: &DefaultServer, Bucket: &dataBucketName},
+		MetadataBucket: &BucketConfig{Bucket: &metadataBucketName},
+	})
+	assert.NoError(t, err)
+	assert.True(t, dbContext.HasMetadataBucket())
+	assert.Equal(t, metadataBucketName, dbContext.MetadataBucket.GetName())
+
+	database, err := db.CreateDatabase(dbContext)
+	assert.NoError(t, err)
+	_, err = database.Put("doc1", db.Body{"value": 1})
+	assert.NoError(t, err)
+	_, err = database.PutSpecial("local", "checkpoint", db.Body{"value": 1})
+	assert.NoError(t, err)
+	authenticator := dbContext.Authenticator()
+	user, err := authenticator.NewUser("alice", "letmein", nil)
+	assert.NoError(t, err)
+	assert.NoError(t, authenticator.Save(user))
+
+	// Internal docs are only stored in the metadata bucket, and documents only in the data bucket
+	for _, key := range []string{db.SyncSeqKey, "_sync:local:checkpoint", "_sync:user:alice"} {
+		_, _, err := dbContext.MetadataBucket.GetRaw(key)
+		assert.NoError(t, err, "Expected %s in metadata bucket", key)
+		_, _, err = dbContext.Bucket.GetRaw(key)
+		assert.True(t, base.IsDocNotFoundError(err), "Expected %s not to be in data bucket", key)
+	}
+	_, _, err = dbContext.Bucket.GetRaw("doc1")
+	assert.NoError(t, err)
+	_, _, err = dbContext.MetadataBucket.GetRaw("doc1")
+	assert.True(t, base.IsDocNotFoundError(err))
+
+	// Principals are queried on the metadata bucket
+	users, _, err := dbContext.AllPrincipalIDs()
+	assert.NoError(t, err)
+	assert.Contains(t, users, "alice")
+}
+
+func TestGetMetadataBucketSpec(t *testing.T) {
+	dataBucketName := "data"
+	config := &DbConfig{BucketConfig: BucketConfig{Server: &DefaultServer, Bucket: &dataBucketName}}
+	dataSpec, err := GetBucketSpec(config)
+	assert.NoError(t, err)
+
+	spec, err := GetMetadataBucketSpec(config, dataSpec)
+	assert.NoError(t, err)
+	assert.Nil(t, spec)
+
+	// The metadata bucket defaults to the data bucket's server
+	metadataBucketName := "metadata"
+	config.MetadataBucket = &BucketConfig{Bucket: &metadataBucketName}
+	spec, err = GetMetadataBucketSpec(config, dataSpec)
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultServer, spec.Server)
+	assert.Equal(t, metadataBucketName, spec.BucketName)
+
+	config.MetadataBucket = &BucketConfig{}
+	_, err = GetMetadataBucketSpec(config, dataSpec)
+	assert.Error(t, err)
+
+	config.MetadataBucket = &BucketConfig{Bucket: &dataBucketName}
+	_, err = GetMetadataBucketSpec(config, dataSpec)
+	assert.Error(t, err)
+}
+
+func TestGetColdStorageBucketSpec(t *testing.T) {
+	dataBucketName := "data"
+	config := &DbConfig{BucketConfig: BucketConfig{Server: &DefaultServer, Bucket: &dataBucketName}}
+	dataSpec, err := GetBucketSpec(config)
+	assert.NoError(t, err)
+
+	coldBucketName := "cold"
+	config.ColdStorage = &ColdStorageConfig{Bucket: &BucketConfig{Bucket: &coldBucketName}}
+	spec, err := GetColdStorageBucketSpec(config, dataSpec)
+	assert.NoError(t, err)
+	assert.Nil(t, spec)
+
+	config.ColdStorage.Enabled = base.BoolPtr(true)
+	spec, err = GetColdStorageBucketSpec(config, dataSpec)
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultServer, spec.Server)
+	assert.Equal(t, coldBucketName, spec.BucketName)
+
+	config.ColdStorage.Bucket = nil
+	_, err = GetColdStorageBucketSpec(config, dataSpec)
+	assert.Error(t, err)
+}
+
+func TestMakeRevsLimitOverride(t *testing.T) {
+	revsLimit := uint32(10)
+	override, err := makeRevsLimitOverride(RevsLimitOverrideConfig{DocIDPattern: "^telemetry:", RevsLimit: &revsLimit}, false)
+	assert.NoError(t, err)
+	assert.Equal(t, revsLimit, override.RevsLimit)
+	assert.True(t, override.Matches("telemetry:1", db.Body{}))
+	assert.False(t, override.Matches("doc1", db.Body{}))
+
+	// revs_limit of 10 is too low when conflicts are allowed
+	_, err = makeRevsLimitOverride(RevsLimitOverrideConfig{DocType: "log", RevsLimit: &revsLimit}, true)
+	assert.Error(t, err)
+
+	_, err = makeRevsLimitOverride(RevsLimitOverrideConfig{RevsLimit: &revsLimit}, false)
+	assert.Error(t, err)
+	_, err = makeRevsLimitOverride(RevsLimitOverrideConfig{DocType: "log"}, false)
+	assert.Error(t, err)
+	_, err = makeRevsLimitOverride(RevsLimitOverrideConfig{DocIDPattern: "[", RevsLimit: &revsLimit}, false)
+	assert.Error(t, err)
+}
+
+func TestMakeChannelCacheOverride(t *testing.T) {
+	maxLength, expiry := 5000, 600
+	override, err := makeChannelCacheOverride(ChannelCacheOverrideConfig{Channel: "global.news", ChannelCacheMaxLength: &maxLength, ChannelCacheAge: &expiry})
+	assert.NoError(t, err)
+	assert.Equal(t, db.ChannelCacheOptions{ChannelCacheMaxLength: 5000, ChannelCacheAge: 10 * time.Minute}, override.ChannelCacheOptions)
+	assert.True(t, override.ChannelPattern.MatchString("global.news"))
+	assert.False(t, override.ChannelPattern.MatchString("globalxnews"))
+	assert.False(t, override.ChannelPattern.MatchString("global.news2"))
+
+	override, err = makeChannelCacheOverride(ChannelCacheOverrideConfig{ChannelPattern: "^user-", ChannelCacheMaxLength: &maxLength})
+	assert.NoError(t, err)
+	assert.True(t, override.ChannelPattern.MatchString("user-alice"))
+
+	zero := 0
+	_, err = makeChannelCacheOverride(ChannelCacheOverrideConfig{ChannelCacheMaxLength: &maxLength})
+	assert.Error(t, err)
+	_, err = makeChannelCacheOverride(ChannelCacheOverrideConfig{Channel: "a", ChannelPattern: "^a", ChannelCacheMaxLength: &maxLength})
+	assert.Error(t, err)
+	_, err = makeChannelCacheOverride(ChannelCacheOverrideConfig{Channel: "a"})
+	assert.Error(t, err)
+	_, err = makeChannelCacheOverride(ChannelCacheOverrideConfig{Channel: "a", ChannelCacheMinLength: &zero})
+	assert.Error(t, err)
+	_, err = makeChannelCacheOverride(ChannelCacheOverrideConfig{ChannelPattern: "[", ChannelCacheMaxLength: &maxLength})
+	assert.Error(t, err)
+}
+
+func TestValidateKeyPrefix(t *testing.T) {
+	config := &DbConfig{KeyPrefix: "tenant1:"}
+	assert.NoError(t, validateKeyPrefix(config, true))
+	assert.Error(t, validateKeyPrefix(config, false))
+
+	config.KeyPrefix = "_sync:"
+	assert.Error(t, validateKeyPrefix(config, true))
+	config.KeyPrefix = "tenant 1"
+	assert.Error(t, validateKeyPrefix(config, true))
+
+	config.KeyPrefix = "tenant1:"
+	enableXattrs := true
+	config.EnableXattrs = &enableXattrs
+	assert.Error(t, validateKeyPrefix(config, true))
+}
@@ -25,6 +25,9 @@ const kDefaultSessionTTL = 24 * time.Hour
 func (h *handler) respondWithSessionInfo() error {
 
 	response := h.formatSessionResponse(h.user)
+	if token := h.csrfToken(); token != "" {
+		response["csrf_token"] = token
+	}
 
 	h.writeJSON(response)
 	return nil
@@ -100,11 +103,12 @@ func (h *handler) handleSessionDELETE() error {
 		}
 	}
 
-	cookie := h.db.Authenticator().DeleteSessionForCookie(h.rq)
+	authenticator := h.db.Authenticator()
+	cookie := authenticator.DeleteSessionForCookie(h.rq)
 	if cookie == nil {
 		return base.HTTPErrorf(http.StatusNotFound, "no session")
 	}
-	http.SetCookie(h.response, cookie)
+	authenticator.SetSessionCookie(h.rq, h.response, cookie)
 	return nil
 }
 
@@ -128,9 +132,8 @@ func (h *handler) makeSessionWithTTL(user auth.User, expiry time.Duration) (sess
 	if err != nil {
 		return "", err
 	}
-	cookie := auth.MakeSessionCookie(session)
-	base.AddDbPathToCookie(h.rq, cookie)
-	http.SetCookie(h.response, cookie)
+	auth.SetSessionCookie(h.rq, h.response, auth.MakeSessionCookie(session))
+	h.sessionID = session.ID
 	return session.ID, nil
 }
 
@@ -153,7 +156,7 @@ func (h *handler) makeSessionFromNameAndEmail(username, email string, createUser
 			if email != user.Email() {
 				if err = h.db.Authenticator().UpdateUserEmail(user, email); err != nil {
 					// Failure to update email during session creation is non-critical, log and continue.
-					base.Infof(base.KeyAuth, "Unable to update email for user %s during session creation.  Session will still be created. Error:%v,", base.UD(username), err)
+					base.Infof(base.KeyAuth, "Unable to update email for user %s during session creation.  Session will still be created. Error:%v,", base.UDUsername(username), err)
 				}
 			}
 		} else {
@@ -0,0 +1,76 @@
+package rest
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/couchbase/sync_gateway/base"
+	ch "github.com/couchbase/sync_gateway/channels"
+	"github.com/couchbase/sync_gateway/db"
+)
+
+// Default signup budget of each client IP address, so that a client can't create users in bulk
+const (
+	kDefaultSignupRequestsPerSec = 0.1
+	kDefaultSignupBurst          = 5
+)
+
+// Validates a database's signup config, and converts it to the database's SignupOptions.
+func makeSignupOptions(config *SignupConfig) (options db.SignupOptions, err error) {
+	if config == nil {
+		return options, nil
+	}
+	options.Enabled = config.Enabled != nil && *config.Enabled
+	if options.DefaultChannels, err = ch.SetFromArray(config.DefaultChannels, ch.KeepStar); err != nil {
+		return options, fmt.Errorf("signup.default_channels: %v", err)
+	}
+	options.DefaultRoles = config.DefaultRoles
+	options.RequireEmail = config.RequireEmail != nil && *config.RequireEmail
+	options.RequireApproval = config.RequireApproval != nil && *config.RequireApproval
+
+	options.RequestsPerSec = kDefaultSignupRequestsPerSec
+	options.Burst = kDefaultSignupBurst
+	if config.RateLimit != nil {
+		if config.RateLimit.RequestsPerSec <= 0 {
+			return options, fmt.Errorf("signup.rate_limit.requests_per_sec must be greater than zero")
+		}
+		options.RequestsPerSec = config.RateLimit.RequestsPerSec
+		options.Burst = 1
+		if config.RateLimit.Burst != nil && *config.RateLimit.Burst > 0 {
+			options.Burst = *config.RateLimit.Burst
+		}
+	}
+	return options, nil
+}
+
+// POST /db/_signup creates a user, given its name, password and (optionally) email address.  Users that need an
+// admin's approval are created disabled.
+func (h *handler) handleSignup() error {
+	options := h.db.Options.SignupOptions
+	if !options.Enabled {
+		return base.HTTPErrorf(http.StatusForbidden, "Signup is not enabled for this database")
+	}
+	var params struct {
+		Name     string `json:"name"`
+		Password string `json:"password"`
+		Email    string `json:"email"`
+	}
+	if err := h.readJSONInto(&params); err != nil {
+		return err
+	}
+
+	// Requests with unreadable bodies don't count against the client's budget
+	rl := h.server.signupLimiter
+	ip := rl.clientIP(h.rq)
+	budget := &rateLimitBudget{rate: options.RequestsPerSec, burst: float64(options.Burst)}
+	if err := h.checkRateLimit(rl, "signup:"+h.db.Name+":"+ip, budget, "signups", "client "+ip); err != nil {
+		return err
+	}
+
+	pendingApproval, err := h.db.Signup(params.Name, params.Password, params.Email)
+	if err != nil {
+		return err
+	}
+	h.writeJSONStatus(http.StatusCreated, db.Body{"ok": true, "name": params.Name, "pending_approval": pendingApproval})
+	return nil
+}
@@ -0,0 +1,53 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignupAPI(t *testing.T) {
+	enabled := true
+	burst := uint32(2)
+	rt := RestTester{DatabaseConfig: &DbConfig{Signup: &SignupConfig{
+		Enabled:         &enabled,
+		DefaultChannels: []string{"public"},
+		RateLimit:       &RateLimitBudgetConfig{RequestsPerSec: 0.001, Burst: &burst},
+	}}}
+	defer rt.Close()
+
+	response := rt.SendRequest("POST", "/db/_signup", `{"name":"alice", "password":"letmein"}`)
+	assertStatus(t, response, http.StatusCreated)
+	var body map[string]interface{}
+	assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &body))
+	assert.Equal(t, map[string]interface{}{"ok": true, "name": "alice", "pending_approval": false}, body)
+	assertStatus(t, rt.SendUserRequestWithHeaders("GET", "/db/", "", nil, "alice", "letmein"), http.StatusOK)
+
+	// Failed signups count against the budget too
+	assertStatus(t, rt.SendRequest("POST", "/db/_signup", `{"name":"alice", "password":"letmein"}`), http.StatusConflict)
+	response = rt.SendRequest("POST", "/db/_signup", `{"name":"bob", "password":"letmein"}`)
+	assertStatus(t, response, http.StatusTooManyRequests)
+	assert.NotEmpty(t, response.Header().Get("Retry-After"))
+}
+
+func TestMakeSignupOptions(t *testing.T) {
+	options, err := makeSignupOptions(nil)
+	assert.NoError(t, err)
+	assert.False(t, options.Enabled)
+
+	enabled := true
+	options, err = makeSignupOptions(&SignupConfig{Enabled: &enabled, DefaultChannels: []string{"a", "b"}})
+	assert.NoError(t, err)
+	assert.True(t, options.Enabled)
+	assert.Equal(t, base.SetOf("a", "b"), options.DefaultChannels)
+	assert.Equal(t, kDefaultSignupRequestsPerSec, options.RequestsPerSec)
+	assert.Equal(t, uint32(kDefaultSignupBurst), options.Burst)
+
+	_, err = makeSignupOptions(&SignupConfig{DefaultChannels: []string{"bad,name"}})
+	assert.Error(t, err)
+	_, err = makeSignupOptions(&SignupConfig{RateLimit: &RateLimitBudgetConfig{}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,54 @@
+package rest
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbase/sync_gateway/db"
+)
+
+// Converts a database's trash config to its options.
+func makeTrashOptions(config *TrashConfig) (options db.TrashOptions, err error) {
+	if config == nil || config.Enabled == nil || !*config.Enabled {
+		return options, nil
+	}
+	retentionDays := uint32(db.DefaultTrashRetentionDays)
+	if config.RetentionDays != nil {
+		if *config.RetentionDays == 0 {
+			return options, errors.New("trash.retention_days must be greater than zero")
+		}
+		retentionDays = *config.RetentionDays
+	}
+	options.Enabled = true
+	options.Retention = time.Duration(retentionDays) * 24 * time.Hour
+	return options, nil
+}
+
+// HTTP handler for a GET of _trash, which lists the purged documents that can be restored
+func (h *handler) handleGetTrash() error {
+	if !h.db.Options.TrashOptions.Enabled {
+		return base.HTTPErrorf(http.StatusNotFound, "The trash isn't enabled")
+	}
+	entries, err := h.db.TrashEntries()
+	if err != nil {
+		return err
+	}
+	h.writeJSON(db.Body{"docs": entries})
+	return nil
+}
+
+// HTTP handler for a POST to _trash/{docid}/_restore, which restores a purged document
+func (h *handler) handleRestoreFromTrash() error {
+	if !h.db.Options.TrashOptions.Enabled {
+		return base.HTTPErrorf(http.StatusNotFound, "The trash isn't enabled")
+	}
+	docID := h.PathVar("docid")
+	if err := h.db.RestoreFromTrash(docID); err != nil {
+		return err
+	}
+	h.setAuditSummary(nil, db.Body{"restored": docID})
+	h.writeJSON(db.Body{"ok": true, "id": docID})
+	return nil
+}
@@ -0,0 +1,132 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrash(t *testing.T) {
+	rt := RestTester{DatabaseConfig: &DbConfig{
+		Trash: &TrashConfig{Enabled: base.BoolPtr(true)},
+	}}
+	defer rt.Close()
+
+	assertStatus(t, rt.SendAdminRequest("PUT", "/db/doc1", `{"value":1}`), http.StatusCreated)
+	var raw struct {
+		Sync struct {
+			Sequence uint64 `json:"sequence"`
+		} `json:"_sync"`
+	}
+	response := rt.SendAdminRequest("GET", "/db/_raw/doc1", "")
+	assertStatus(t, response, http.StatusOK)
+	assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &raw))
+	purgedSequence := raw.Sync.Sequence
+	response = rt.SendAdminRequest("PUT", "/db/doc2", `{"value":2}`)
+	assertStatus(t, response, http.StatusCreated)
+	var putResult struct {
+		Rev string `json:"rev"`
+	}
+	assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &putResult))
+	assertStatus(t, rt.SendAdminRequest("DELETE", "/db/doc2?rev="+putResult.Rev, ""), http.StatusOK)
+	assertStatus(t, rt.SendAdminRequest("POST", "/db/_purge", `{"doc1":["*"], "doc2":["*"]}`), http.StatusOK)
+	assertStatus(t, rt.SendAdminRequest("GET", "/db/doc1", ""), http.StatusNotFound)
+
+	// Only the live document is kept
+	response = rt.SendAdminRequest("GET", "/db/_trash", "")
+	assertStatus(t, response, http.StatusOK)
+	var trash struct {
+		Docs []struct {
+			ID       string    `json:"id"`
+			PurgedAt time.Time `json:"purged_at"`
+			Expires  time.Time `json:"expires"`
+		} `json:"docs"`
+	}
+	assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &trash))
+	if assert.Len(t, trash.Docs, 1) {
+		assert.Equal(t, "doc1", trash.Docs[0].ID)
+		assert.Equal(t, 7*24*time.Hour, trash.Docs[0].Expires.Sub(trash.Docs[0].PurgedAt))
+	}
+
+	assertStatus(t, rt.SendAdminRequest("POST", "/db/_trash/doc1/_restore", ""), http.StatusOK)
+	response = rt.SendAdminRequest("GET", "/db/doc1", "")
+	assertStatus(t, response, http.StatusOK)
+	assert.Contains(t, response.Body.String(), `"value":1`)
+	assert.Contains(t, response.Body.String(), `"_rev":"1-`)
+
+	// The restored document has a new sequence, so it's sent to clients again
+	response = rt.SendAdminRequest("GET", "/db/_raw/doc1", "")
+	assertStatus(t, response, http.StatusOK)
+	assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &raw))
+	assert.True(t, raw.Sync.Sequence > purgedSequence, "Restored sequence %d isn't after %d", raw.Sync.Sequence, purgedSequence)
+	changes, err := rt.WaitForChanges(1, "/db/_changes?since="+strconv.FormatUint(raw.Sync.Sequence-1, 10), "", true)
+	assert.NoError(t, err)
+	if assert.Len(t, changes.Results, 1) {
+		assert.Equal(t, "doc1", changes.Results[0].ID)
+	}
+
+	// A restored document leaves the trash
+	response = rt.SendAdminRequest("GET", "/db/_trash", "")
+	assertStatus(t, response, http.StatusOK)
+	assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &trash))
+	assert.Len(t, trash.Docs, 0)
+	assertStatus(t, rt.SendAdminRequest("POST", "/db/_trash/doc1/_restore", ""), http.StatusNotFound)
+	assertStatus(t, rt.SendAdminRequest("POST", "/db/_trash/doc2/_restore", ""), http.StatusNotFound)
+
+	// A document recreated since it was purged isn't overwritten
+	assertStatus(t, rt.SendAdminRequest("POST", "/db/_purge", `{"doc1":["*"]}`), http.StatusOK)
+	assertStatus(t, rt.SendAdminRequest("PUT", "/db/doc1", `{"value":3}`), http.StatusCreated)
+	assertStatus(t, rt.SendAdminRequest("POST", "/db/_trash/doc1/_restore", ""), http.StatusConflict)
+
+	assertStatus(t, rt.SendRequest("GET", "/db/_trash", ""), http.StatusNotFound)
+}
+
+func TestTrashRestoreQuota(t *testing.T) {
+	maxDocs := uint64(1)
+	rt := RestTester{DatabaseConfig: &DbConfig{
+		Trash:  &TrashConfig{Enabled: base.BoolPtr(true)},
+		Quotas: &QuotaConfig{MaxDocs: &maxDocs},
+	}}
+	defer rt.Close()
+
+	assertStatus(t, rt.SendAdminRequest("PUT", "/db/doc1", `{"value":1}`), http.StatusCreated)
+	assertStatus(t, rt.SendAdminRequest("POST", "/db/_purge", `{"doc1":["*"]}`), http.StatusOK)
+	assertStatus(t, rt.SendAdminRequest("PUT", "/db/doc2", `{"value":2}`), http.StatusCreated)
+
+	// Restoring a document needs room under the quota, like creating one
+	assertStatus(t, rt.SendAdminRequest("POST", "/db/_trash/doc1/_restore", ""), http.StatusInsufficientStorage)
+	assertStatus(t, rt.SendAdminRequest("GET", "/db/doc1", ""), http.StatusNotFound)
+
+	assertStatus(t, rt.SendAdminRequest("POST", "/db/_purge", `{"doc2":["*"]}`), http.StatusOK)
+	assertStatus(t, rt.SendAdminRequest("POST", "/db/_trash/doc1/_restore", ""), http.StatusOK)
+	assertStatus(t, rt.SendAdminRequest("PUT", "/db/doc3", `{"value":3}`), http.StatusInsufficientStorage)
+}
+
+func TestTrashNotEnabled(t *testing.T) {
+	rt := RestTester{}
+	defer rt.Close()
+
+	assertStatus(t, rt.SendAdminRequest("PUT", "/db/doc1", `{"value":1}`), http.StatusCreated)
+	assertStatus(t, rt.SendAdminRequest("POST", "/db/_purge", `{"doc1":["*"]}`), http.StatusOK)
+	assertStatus(t, rt.SendAdminRequest("GET", "/db/_trash", ""), http.StatusNotFound)
+	assertStatus(t, rt.SendAdminRequest("POST", "/db/_trash/doc1/_restore", ""), http.StatusNotFound)
+}
+
+func TestMakeTrashOptions(t *testing.T) {
+	options, err := makeTrashOptions(nil)
+	assert.NoError(t, err)
+	assert.False(t, options.Enabled)
+
+	options, err = makeTrashOptions(&TrashConfig{Enabled: base.BoolPtr(true), RetentionDays: base.Uint32Ptr(30)})
+	assert.NoError(t, err)
+	assert.True(t, options.Enabled)
+	assert.Equal(t, 30*24*time.Hour, options.Retention)
+
+	_, err = makeTrashOptions(&TrashConfig{Enabled: base.BoolPtr(true), RetentionDays: base.Uint32Ptr(0)})
+	assert.Error(t, err)
+}
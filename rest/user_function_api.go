@@ -0,0 +1,49 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/couchbase/sync_gateway/db"
+)
+
+// Compiles a database's user functions.
+func makeUserFunctions(sources map[string]string) (map[string]*db.UserFunction, error) {
+	if len(sources) == 0 {
+		return nil, nil
+	}
+	functions := make(map[string]*db.UserFunction, len(sources))
+	for name, source := range sources {
+		if name == "" || strings.Contains(name, "/") {
+			return nil, fmt.Errorf("functions: invalid function name %q", name)
+		}
+		if err := db.ValidateUserFunction(source); err != nil {
+			return nil, fmt.Errorf("Error compiling function %q: %v", name, err)
+		}
+		functions[name] = db.NewUserFunction(source)
+	}
+	return functions, nil
+}
+
+// POST /db/_function/{name} calls a user function, passing it the JSON request body (or null, if there's no body) as
+// its arguments.  The response is the function's result.
+func (h *handler) handleCallUserFunction() error {
+	var args interface{}
+	if h.rq.ContentLength != 0 {
+		if err := h.readJSONInto(&args); err != nil {
+			return err
+		}
+	}
+	argsJSON, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+
+	result, err := h.db.CallUserFunction(h.PathVar("name"), argsJSON)
+	if err != nil {
+		return err
+	}
+	h.writeJSON(result)
+	return nil
+}
@@ -0,0 +1,36 @@
+package rest
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserFunctionAPI(t *testing.T) {
+	rt := RestTester{DatabaseConfig: &DbConfig{Functions: map[string]string{
+		"greet": `function(args, user) {return {greeting: "Hello, " + args.name};}`,
+		"save":  `function(args, user) {return putDoc(args.docid, {saved: true});}`,
+	}}}
+	defer rt.Close()
+
+	response := rt.SendRequest("POST", "/db/_function/greet", `{"name":"world"}`)
+	assertStatus(t, response, http.StatusOK)
+	assert.JSONEq(t, `{"greeting":"Hello, world"}`, response.Body.String())
+	assertStatus(t, rt.SendRequest("POST", "/db/_function/greet", `not JSON`), http.StatusBadRequest)
+	assertStatus(t, rt.SendRequest("POST", "/db/_function/missing", ``), http.StatusNotFound)
+
+	assertStatus(t, rt.SendAdminRequest("POST", "/db/_function/save", `{"docid":"doc1"}`), http.StatusOK)
+	assertStatus(t, rt.SendAdminRequest("GET", "/db/doc1", ""), http.StatusOK)
+}
+
+func TestMakeUserFunctions(t *testing.T) {
+	functions, err := makeUserFunctions(map[string]string{"f": `function(args, user) {return 1;}`})
+	assert.NoError(t, err)
+	assert.Len(t, functions, 1)
+
+	_, err = makeUserFunctions(map[string]string{"a/b": `function(args, user) {return 1;}`})
+	assert.Error(t, err)
+	_, err = makeUserFunctions(map[string]string{"f": `function(args, user) {`})
+	assert.Error(t, err)
+}
@@ -35,15 +35,70 @@ var gBucketCounter = 0
 type RestTester struct {
 	RestTesterBucket        base.Bucket
 	RestTesterServerContext *ServerContext
-	noAdminParty            bool      // Unless this is true, Admin Party is in full effect
-	distributedIndex        bool      // Test with walrus-based index bucket
-	SyncFn                  string    // put the sync() function source in here (optional)
-	DatabaseConfig          *DbConfig // Supports additional config options.  BucketConfig, Name, Sync, Unsupported will be ignored (overridden)
+	testBucket              *base.TestBucket // The test bucket the database uses, returned to the pool on Close.  Only set for pooled buckets
+	noAdminParty            bool             // Unless this is true, Admin Party is in full effect
+	distributedIndex        bool             // Test with walrus-based index bucket
+	SyncFn                  string           // put the sync() function source in here (optional)
+	DatabaseConfig          *DbConfig        // Supports additional config options.  BucketConfig, Name, Sync, Unsupported will be ignored (overridden)
 	AdminHandler            http.Handler
 	PublicHandler           http.Handler
-	EnableNoConflictsMode   bool   // Enable no-conflicts mode.  By default, conflicts will be allowed, which is the default behavior
-	NoFlush                 bool   // Skip bucket flush step during creation.  Used by tests that need to simulate start/stop of Sync Gateway with backing bucket intact.
-	InitSyncSeq             uint64 // If specified, initializes _sync:seq on bucket creation.  Not supported when running against walrus
+	EnableNoConflictsMode   bool                   // Enable no-conflicts mode.  By default, conflicts will be allowed, which is the default behavior
+	NoFlush                 bool                   // Skip bucket flush step during creation.  Used by tests that need to simulate start/stop of Sync Gateway with backing bucket intact.
+	InitSyncSeq             uint64                 // If specified, initializes _sync:seq on bucket creation.  Not supported when running against walrus
+	ServerConfig            *ServerConfig          // Supports server-level config options.  Databases will be ignored, and CORS, Facebook and AdminInterface defaulted if unset.  Console logging is still set up by base.SetUpTestLogging
+	Middlewares             []RestTesterMiddleware // Wrap both the public and admin handlers, outermost first.  Must be set before the first request is sent
+}
+
+// A RestTesterMiddleware wraps a RestTester's handlers, to change the requests they see or the responses they send.
+type RestTesterMiddleware func(next http.Handler) http.Handler
+
+// Returns a RestTesterMiddleware that delays every request by the given duration before handling it.
+func LatencyMiddleware(latency time.Duration) RestTesterMiddleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(latency)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Returns a RestTesterMiddleware that sends every request without an Authorization header as the given user.
+func BasicAuthMiddleware(username, password string) RestTesterMiddleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") == "" {
+				r.SetBasicAuth(username, password)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Returns the server config to create the ServerContext with, based on ServerConfig if it was set.
+func (rt *RestTester) serverConfig(corsConfig *CORSConfig) *ServerConfig {
+	config := ServerConfig{}
+	if rt.ServerConfig != nil {
+		config = *rt.ServerConfig
+	}
+	config.Databases = nil
+	if config.CORS == nil {
+		config.CORS = corsConfig
+	}
+	if config.Facebook == nil {
+		config.Facebook = &FacebookConfig{}
+	}
+	if config.AdminInterface == nil {
+		config.AdminInterface = &DefaultAdminInterface
+	}
+	return &config
+}
+
+// Wraps a handler in the RestTester's middlewares.
+func (rt *RestTester) withMiddlewares(handler http.Handler) http.Handler {
+	for i := len(rt.Middlewares) - 1; i >= 0; i-- {
+		handler = rt.Middlewares[i](handler)
+	}
+	return handler
 }
 
 func (rt *RestTester) Bucket() base.Bucket {
@@ -56,24 +111,33 @@ func (rt *RestTester) Bucket() base.Bucket {
 	// Limit number of attempts to 2.
 	for i := 0; i < 2; i++ {
 
-		// Initialize the bucket.  For couchbase-backed tests, triggers with creation/flushing of the bucket
+		// Initialize the bucket.  For couchbase-backed tests, gets a bucket from the test bucket pool, emptied unless
+		// NoFlush is set, which the database uses until the RestTester is closed
+		rt.closeTestBucket()
+		var testBucket base.TestBucket
 		if !rt.NoFlush {
-			tempBucket := base.GetTestBucketOrPanic() // side effect of creating/flushing bucket
+			testBucket = base.GetTestBucketOrPanic() // side effect of creating/flushing bucket
 			if rt.InitSyncSeq > 0 {
 				log.Printf("Initializing %s to %d", db.SyncSeqKey, rt.InitSyncSeq)
-				_, incrErr := tempBucket.Incr(db.SyncSeqKey, rt.InitSyncSeq, rt.InitSyncSeq, 0)
+				_, incrErr := testBucket.Incr(db.SyncSeqKey, rt.InitSyncSeq, rt.InitSyncSeq, 0)
 				if incrErr != nil {
 					panic(fmt.Sprintf("Error initializing %s in test bucket: %v", db.SyncSeqKey, incrErr))
 				}
 			}
-			tempBucket.Close()
 		} else {
 			if rt.InitSyncSeq > 0 {
 				panic("RestTester doesn't support NoFlush and InitSyncSeq in same test")
 			}
+			testBucket = base.GetTestBucketNoFlushOrPanic()
+		}
+		spec := testBucket.BucketSpec
+		if spec.IsWalrusBucket() {
+			// Walrus buckets aren't pooled, and the database opens a new one of its own
+			testBucket.Close()
+			spec = base.GetTestBucketSpec(base.DataBucket)
+		} else {
+			rt.testBucket = &testBucket
 		}
-
-		spec := base.GetTestBucketSpec(base.DataBucket)
 
 		username, password, _ := spec.Auth.GetCredentials()
 
@@ -92,11 +156,7 @@ func (rt *RestTester) Bucket() base.Bucket {
 			MaxAge:      1728000,
 		}
 
-		rt.RestTesterServerContext = NewServerContext(&ServerConfig{
-			CORS:           corsConfig,
-			Facebook:       &FacebookConfig{},
-			AdminInterface: &DefaultAdminInterface,
-		})
+		rt.RestTesterServerContext = NewServerContext(rt.serverConfig(corsConfig))
 
 		useXattrs := base.TestUseXattrs()
 
@@ -168,11 +228,7 @@ func (rt *RestTester) BucketAllowEmptyPassword() base.Bucket {
 	bucketName := fmt.Sprintf("sync_gateway_test_%d", gBucketCounter)
 	gBucketCounter++
 
-	rt.RestTesterServerContext = NewServerContext(&ServerConfig{
-		CORS:           &CORSConfig{},
-		Facebook:       &FacebookConfig{},
-		AdminInterface: &DefaultAdminInterface,
-	})
+	rt.RestTesterServerContext = NewServerContext(rt.serverConfig(&CORSConfig{}))
 
 	_, err := rt.RestTesterServerContext.AddDatabaseFromConfig(&DbConfig{
 		BucketConfig: BucketConfig{
@@ -268,6 +324,15 @@ func (rt *RestTester) Close() {
 	if rt.RestTesterServerContext != nil {
 		rt.RestTesterServerContext.Close()
 	}
+	rt.closeTestBucket()
+}
+
+// Closes the RestTester's handle on its test bucket, returning it to the test bucket pool.
+func (rt *RestTester) closeTestBucket() {
+	if rt.testBucket != nil {
+		rt.testBucket.Close()
+		rt.testBucket = nil
+	}
 }
 
 func (rt *RestTester) SendRequest(method, resource string, body string) *TestResponse {
@@ -300,21 +365,21 @@ func (rt *RestTester) Send(request *http.Request) *TestResponse {
 func (rt *RestTester) TestAdminHandlerNoConflictsMode() http.Handler {
 	rt.EnableNoConflictsMode = true
 	if rt.AdminHandler == nil {
-		rt.AdminHandler = CreateAdminHandler(rt.ServerContext())
+		rt.AdminHandler = rt.withMiddlewares(CreateAdminHandler(rt.ServerContext()))
 	}
 	return rt.AdminHandler
 }
 
 func (rt *RestTester) TestAdminHandler() http.Handler {
 	if rt.AdminHandler == nil {
-		rt.AdminHandler = CreateAdminHandler(rt.ServerContext())
+		rt.AdminHandler = rt.withMiddlewares(CreateAdminHandler(rt.ServerContext()))
 	}
 	return rt.AdminHandler
 }
 
 func (rt *RestTester) TestPublicHandler() http.Handler {
 	if rt.PublicHandler == nil {
-		rt.PublicHandler = CreatePublicHandler(rt.ServerContext())
+		rt.PublicHandler = rt.withMiddlewares(CreatePublicHandler(rt.ServerContext()))
 	}
 	return rt.PublicHandler
 }
@@ -1428,6 +1493,30 @@ func WaitWithTimeout(wg *sync.WaitGroup, timeout time.Duration) error {
 
 }
 
+// Runs each of the given funcs in its own goroutine, and waits for them all to return.  Returns the first error returned
+// by any of them, or an error if they don't all return within timeout.
+func RunConcurrently(timeout time.Duration, funcs ...func() error) error {
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(funcs))
+	for _, f := range funcs {
+		wg.Add(1)
+		go func(f func() error) {
+			defer wg.Done()
+			if err := f(); err != nil {
+				errs <- err
+			}
+		}(f)
+	}
+
+	if err := WaitWithTimeout(&wg, timeout); err != nil {
+		return err
+	}
+	close(errs)
+	return <-errs
+
+}
+
 type TestLogger struct {
 	T *testing.T
 }
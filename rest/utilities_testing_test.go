@@ -2,11 +2,16 @@ package rest
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
+	"net/http"
 	"testing"
+	"time"
 
+	"github.com/couchbase/sync_gateway/base"
 	"github.com/couchbase/sync_gateway/db"
 	goassert "github.com/couchbaselabs/go.assert"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestDocumentUnmarshal(t *testing.T) {
@@ -78,3 +83,47 @@ func TestAttachmentRoundTrip(t *testing.T) {
 	}
 
 }
+
+func TestRestTesterServerConfig(t *testing.T) {
+
+	// Make a doc longer than 1k so the HTTP response would normally be compressed
+	str := "DORKY "
+	for i := 0; i < 10; i++ {
+		str = str + str
+	}
+
+	rt := RestTester{ServerConfig: &ServerConfig{CompressResponses: base.BoolPtr(false)}}
+	defer rt.Close()
+
+	response := rt.SendRequest(http.MethodPut, "/db/_local/loc1", fmt.Sprintf(`{"long": %q}`, str))
+	assertStatus(t, response, http.StatusCreated)
+	response = rt.SendRequestWithHeaders(http.MethodGet, "/db/_local/loc1", "", map[string]string{"Accept-Encoding": "gzip"})
+	assertStatus(t, response, http.StatusOK)
+	assert.Equal(t, "", response.Header().Get("Content-Encoding"))
+
+	// The defaults are still applied to server config that isn't set
+	assert.Equal(t, DefaultAdminInterface, *rt.ServerContext().config.AdminInterface)
+}
+
+func TestRestTesterMiddlewares(t *testing.T) {
+
+	latency := 100 * time.Millisecond
+	rt := RestTester{
+		noAdminParty: true,
+		Middlewares:  []RestTesterMiddleware{LatencyMiddleware(latency), BasicAuthMiddleware("alice", "letmein")},
+	}
+	defer rt.Close()
+
+	response := rt.SendAdminRequest(http.MethodPut, "/db/_user/alice", `{"password": "letmein"}`)
+	assertStatus(t, response, http.StatusCreated)
+
+	// Requests without credentials are sent as alice
+	start := time.Now()
+	response = rt.SendRequest(http.MethodGet, "/db/", "")
+	assertStatus(t, response, http.StatusOK)
+	assert.True(t, time.Since(start) >= latency, "Request wasn't delayed")
+
+	// Requests with their own credentials aren't overridden
+	response = rt.SendUserRequestWithHeaders(http.MethodGet, "/db/", "", nil, "alice", "wrong")
+	assertStatus(t, response, http.StatusUnauthorized)
+}
@@ -0,0 +1,372 @@
+package rest
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbase/sync_gateway/db"
+)
+
+// The WebSocket package doesn't support extensions, so permessage-deflate (RFC 7692) is implemented underneath it,
+// by a net.Conn that inflates the messages the client sends and deflates those sent to it, rewriting their frames.
+// No context is kept between messages, by either side, so each message is compressed on its own.
+
+// The Sec-WebSocket-Extensions response header that accepts a permessage-deflate offer
+const webSocketDeflateResponse = "permessage-deflate; server_no_context_takeover; client_no_context_takeover"
+
+// Max size of a message the client sends, once inflated
+const kMaxInflatedWebSocketMessageSize = 64 * 1024 * 1024
+
+// Appended to a compressed message's payload to inflate it: the sync flush marker the sender stripped, followed by
+// an empty final block.
+var webSocketDeflateTail = []byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}
+
+// Converts a database's WebSocket compression config to its options.
+func makeWebSocketCompressionOptions(config *WebSocketCompressionConfig) (options db.WebSocketCompressionOptions, err error) {
+	options.Level = db.DefaultWebSocketCompressionLevel
+	options.MinMessageSize = db.DefaultWebSocketCompressionMinMessageSize
+	if config == nil {
+		return options, nil
+	}
+	options.Enabled = config.Enabled != nil && *config.Enabled
+	if config.Level != nil {
+		if *config.Level < flate.BestSpeed || *config.Level > flate.BestCompression {
+			return options, fmt.Errorf("websocket_compression.level: %d must be between %d and %d", *config.Level, flate.BestSpeed, flate.BestCompression)
+		}
+		options.Level = *config.Level
+	}
+	if config.MinMessageSize != nil {
+		if *config.MinMessageSize < 0 {
+			return options, fmt.Errorf("websocket_compression.min_message_size: %d must not be negative", *config.MinMessageSize)
+		}
+		options.MinMessageSize = *config.MinMessageSize
+	}
+	return options, nil
+}
+
+// Returns whether the client's Sec-WebSocket-Extensions header offers permessage-deflate with parameters the server
+// can accept.  The server can't limit its window size, so offers that ask it to are declined.
+func acceptsWebSocketDeflate(header http.Header) bool {
+	for _, value := range header[http.CanonicalHeaderKey("Sec-WebSocket-Extensions")] {
+	offers:
+		for _, offer := range strings.Split(value, ",") {
+			params := strings.Split(offer, ";")
+			if strings.TrimSpace(params[0]) != "permessage-deflate" {
+				continue
+			}
+			for _, param := range params[1:] {
+				name, paramValue := strings.TrimSpace(param), ""
+				if i := strings.Index(name, "="); i >= 0 {
+					name, paramValue = strings.TrimSpace(name[:i]), strings.Trim(strings.TrimSpace(name[i+1:]), `"`)
+				}
+				switch name {
+				case "server_no_context_takeover", "client_no_context_takeover", "client_max_window_bits":
+				case "server_max_window_bits":
+					if paramValue != "15" {
+						continue offers
+					}
+				default:
+					continue offers
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// Wraps the response of a WebSocket upgrade, so that the connection it hijacks compresses messages.
+type deflateResponseWriter struct {
+	http.ResponseWriter
+	options db.WebSocketCompressionOptions
+}
+
+func (w *deflateResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response can't be hijacked")
+	}
+	conn, buf, err := hijacker.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	// Frames the client already sent may have been read into the buffer
+	buffered, _ := buf.Reader.Peek(buf.Reader.Buffered())
+	reader := io.MultiReader(bytes.NewReader(append([]byte(nil), buffered...)), conn)
+	deflateConn := newDeflateConn(conn, reader, w.options)
+	return deflateConn, bufio.NewReadWriter(bufio.NewReader(deflateConn), bufio.NewWriter(deflateConn)), nil
+}
+
+// WebSocket opcodes
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8 // Opcodes from here on are control frames
+)
+
+type webSocketFrame struct {
+	fin     bool
+	rsv1    bool // Set on the first frame of a compressed message
+	opcode  byte
+	payload []byte
+}
+
+// A server's connection to a WebSocket client that negotiated permessage-deflate.
+type deflateConn struct {
+	net.Conn
+	options       db.WebSocketCompressionOptions
+	reader        *bufio.Reader // Reads the client's frames
+	readBuf       bytes.Buffer  // Rewritten frames not yet read by the WebSocket package
+	message       *webSocketFrame
+	inflater      io.ReadCloser
+	writeLock     sync.Mutex
+	writeBuf      []byte // Bytes written by the WebSocket package that don't make up a whole frame yet
+	handshakeDone bool   // Whether the handshake response has been written
+	deflater      *flate.Writer
+}
+
+func newDeflateConn(conn net.Conn, reader io.Reader, options db.WebSocketCompressionOptions) *deflateConn {
+	return &deflateConn{
+		Conn:     conn,
+		options:  options,
+		reader:   bufio.NewReader(reader),
+		inflater: flate.NewReader(bytes.NewReader(nil)),
+	}
+}
+
+// Reads the client's frames, with compressed messages inflated.
+func (c *deflateConn) Read(p []byte) (int, error) {
+	for c.readBuf.Len() == 0 {
+		frame, err := readWebSocketFrame(c.reader)
+		if err != nil {
+			return 0, err
+		}
+		if err = c.receiveFrame(frame); err != nil {
+			base.Infof(base.KeyWebSocket, "Closing WebSocket connection: %v", err)
+			return 0, err
+		}
+	}
+	return c.readBuf.Read(p)
+}
+
+// Rewrites a frame the client sent, buffering the frames of a compressed message until it's complete.
+func (c *deflateConn) receiveFrame(frame *webSocketFrame) error {
+	if c.message != nil && frame.opcode != wsOpContinuation && frame.opcode < wsOpClose {
+		return errors.New("new message started before the last one ended")
+	}
+	switch {
+	case frame.opcode >= wsOpClose:
+		// Control frames can come between the frames of a message
+	case frame.opcode == wsOpContinuation && c.message != nil:
+		if len(c.message.payload)+len(frame.payload) > kMaxInflatedWebSocketMessageSize {
+			return errors.New("compressed message is too large")
+		}
+		c.message.payload = append(c.message.payload, frame.payload...)
+		if !frame.fin {
+			return nil
+		}
+		frame, c.message = c.message, nil
+		frame.fin = true
+		return c.receiveMessage(frame)
+	case frame.rsv1 && frame.opcode != wsOpContinuation:
+		if !frame.fin {
+			c.message = frame
+			return nil
+		}
+		return c.receiveMessage(frame)
+	}
+	if frame.rsv1 {
+		return errors.New("unexpected compressed frame")
+	}
+	writeWebSocketFrame(&c.readBuf, frame, true)
+	return nil
+}
+
+func (c *deflateConn) receiveMessage(frame *webSocketFrame) error {
+	if err := c.inflater.(flate.Resetter).Reset(io.MultiReader(bytes.NewReader(frame.payload), bytes.NewReader(webSocketDeflateTail)), nil); err != nil {
+		return err
+	}
+	payload, err := ioutil.ReadAll(io.LimitReader(c.inflater, kMaxInflatedWebSocketMessageSize+1))
+	if err != nil {
+		return fmt.Errorf("couldn't inflate message: %v", err)
+	} else if len(payload) > kMaxInflatedWebSocketMessageSize {
+		return errors.New("inflated message is too large")
+	}
+	frame.rsv1, frame.payload = false, payload
+	writeWebSocketFrame(&c.readBuf, frame, true)
+	return nil
+}
+
+// Writes the WebSocket package's output: the handshake response, then frames, whose messages are compressed unless
+// they're small or split into several frames.
+func (c *deflateConn) Write(p []byte) (int, error) {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	c.writeBuf = append(c.writeBuf, p...)
+	if !c.handshakeDone {
+		end := bytes.Index(c.writeBuf, []byte("\r\n\r\n"))
+		if end < 0 {
+			return len(p), nil
+		}
+		if _, err := c.Conn.Write(c.writeBuf[:end+4]); err != nil {
+			return 0, err
+		}
+		c.writeBuf = c.writeBuf[end+4:]
+		c.handshakeDone = true
+	}
+
+	var out bytes.Buffer
+	for {
+		frame, n := parseWebSocketFrame(c.writeBuf)
+		if frame == nil {
+			break
+		}
+		c.writeBuf = c.writeBuf[n:]
+		if frame.fin && !frame.rsv1 && (frame.opcode == wsOpText || frame.opcode == wsOpBinary) &&
+			len(frame.payload) >= c.options.MinMessageSize {
+			if compressed := c.deflate(frame.payload); len(compressed) < len(frame.payload) {
+				frame.rsv1, frame.payload = true, compressed
+			}
+		}
+		writeWebSocketFrame(&out, frame, false)
+	}
+	if out.Len() > 0 {
+		if _, err := c.Conn.Write(out.Bytes()); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Compresses a message's payload, without the sync flush marker at the end.
+func (c *deflateConn) deflate(payload []byte) []byte {
+	var out bytes.Buffer
+	if c.deflater == nil {
+		c.deflater, _ = flate.NewWriter(&out, c.options.Level)
+	} else {
+		c.deflater.Reset(&out)
+	}
+	c.deflater.Write(payload)
+	c.deflater.Flush()
+	return bytes.TrimSuffix(out.Bytes(), webSocketDeflateTail[:4])
+}
+
+// Reads a frame, unmasking its payload.
+func readWebSocketFrame(reader *bufio.Reader) (*webSocketFrame, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return nil, err
+	}
+	frame := &webSocketFrame{fin: header[0]&0x80 != 0, rsv1: header[0]&0x40 != 0, opcode: header[0] & 0x0f}
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(reader, ext[:]); err != nil {
+			return nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(reader, ext[:]); err != nil {
+			return nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > kMaxInflatedWebSocketMessageSize {
+		return nil, errors.New("WebSocket frame is too large")
+	}
+	var mask [4]byte
+	masked := header[1]&0x80 != 0
+	if masked {
+		if _, err := io.ReadFull(reader, mask[:]); err != nil {
+			return nil, err
+		}
+	}
+	frame.payload = make([]byte, length)
+	if _, err := io.ReadFull(reader, frame.payload); err != nil {
+		return nil, err
+	}
+	if masked {
+		for i := range frame.payload {
+			frame.payload[i] ^= mask[i%4]
+		}
+	}
+	return frame, nil
+}
+
+// Parses the unmasked frame at the start of the data, returning it and its size, or nil if the data doesn't hold a
+// whole frame yet.
+func parseWebSocketFrame(data []byte) (*webSocketFrame, int) {
+	if len(data) < 2 {
+		return nil, 0
+	}
+	frame := &webSocketFrame{fin: data[0]&0x80 != 0, rsv1: data[0]&0x40 != 0, opcode: data[0] & 0x0f}
+	length, n := uint64(data[1]&0x7f), 2
+	switch length {
+	case 126:
+		if len(data) < 4 {
+			return nil, 0
+		}
+		length, n = uint64(binary.BigEndian.Uint16(data[2:4])), 4
+	case 127:
+		if len(data) < 10 {
+			return nil, 0
+		}
+		length, n = binary.BigEndian.Uint64(data[2:10]), 10
+	}
+	if uint64(len(data)-n) < length {
+		return nil, 0
+	}
+	frame.payload = data[n : n+int(length)]
+	return frame, n + int(length)
+}
+
+// Writes a frame.  Frames passed to the WebSocket package are marked as masked, as a client's are, but with a zero
+// masking key so the payload is unchanged.
+func writeWebSocketFrame(out *bytes.Buffer, frame *webSocketFrame, masked bool) {
+	b := frame.opcode
+	if frame.fin {
+		b |= 0x80
+	}
+	if frame.rsv1 {
+		b |= 0x40
+	}
+	out.WriteByte(b)
+
+	var maskBit byte
+	if masked {
+		maskBit = 0x80
+	}
+	length := len(frame.payload)
+	switch {
+	case length < 126:
+		out.WriteByte(maskBit | byte(length))
+	case length <= 0xffff:
+		out.WriteByte(maskBit | 126)
+		var ext [2]byte
+		binary.BigEndian.PutUint16(ext[:], uint16(length))
+		out.Write(ext[:])
+	default:
+		out.WriteByte(maskBit | 127)
+		var ext [8]byte
+		binary.BigEndian.PutUint64(ext[:], uint64(length))
+		out.Write(ext[:])
+	}
+	if masked {
+		out.Write([]byte{0, 0, 0, 0})
+	}
+	out.Write(frame.payload)
+}
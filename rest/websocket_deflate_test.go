@@ -0,0 +1,123 @@
+package rest
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/couchbase/sync_gateway/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAcceptsWebSocketDeflate(t *testing.T) {
+	tests := []struct {
+		offer   string
+		accepts bool
+	}{
+		{"", false},
+		{"permessage-deflate", true},
+		{"permessage-deflate; client_max_window_bits", true},
+		{"permessage-deflate; server_max_window_bits=10, permessage-deflate", true},
+		{"permessage-deflate; server_max_window_bits=10", false},
+		{`permessage-deflate; server_max_window_bits="15"; server_no_context_takeover`, true},
+		{"permessage-deflate; mystery_param", false},
+		{"x-webkit-deflate-frame", false},
+	}
+	for _, test := range tests {
+		header := http.Header{}
+		if test.offer != "" {
+			header.Set("Sec-WebSocket-Extensions", test.offer)
+		}
+		assert.Equal(t, test.accepts, acceptsWebSocketDeflate(header), test.offer)
+	}
+}
+
+func TestMakeWebSocketCompressionOptions(t *testing.T) {
+	options, err := makeWebSocketCompressionOptions(nil)
+	assert.NoError(t, err)
+	assert.False(t, options.Enabled)
+	assert.Equal(t, db.DefaultWebSocketCompressionLevel, options.Level)
+
+	enabled, level, minSize := true, 1, 0
+	options, err = makeWebSocketCompressionOptions(&WebSocketCompressionConfig{Enabled: &enabled, Level: &level, MinMessageSize: &minSize})
+	assert.NoError(t, err)
+	assert.Equal(t, db.WebSocketCompressionOptions{Enabled: true, Level: 1, MinMessageSize: 0}, options)
+
+	level = 10
+	_, err = makeWebSocketCompressionOptions(&WebSocketCompressionConfig{Level: &level})
+	assert.Error(t, err)
+	minSize = -1
+	_, err = makeWebSocketCompressionOptions(&WebSocketCompressionConfig{MinMessageSize: &minSize})
+	assert.Error(t, err)
+}
+
+func TestDeflateConn(t *testing.T) {
+	serverSide, clientSide := net.Pipe()
+	defer clientSide.Close()
+	conn := newDeflateConn(serverSide, serverSide, db.WebSocketCompressionOptions{Enabled: true, Level: 6, MinMessageSize: 100})
+	defer conn.Close()
+	client := bufio.NewReader(clientSide)
+	large := bytes.Repeat([]byte("compressible "), 100)
+
+	// Messages sent to the client are compressed, unless they're small
+	var out bytes.Buffer
+	out.WriteString("HTTP/1.1 101 Switching Protocols\r\n\r\n")
+	writeWebSocketFrame(&out, &webSocketFrame{fin: true, opcode: wsOpBinary, payload: large}, false)
+	writeWebSocketFrame(&out, &webSocketFrame{fin: true, opcode: wsOpBinary, payload: []byte("small")}, false)
+	go func() {
+		// Split the output, as the frames may be written in pieces
+		data := out.Bytes()
+		_, _ = conn.Write(data[:50])
+		_, _ = conn.Write(data[50:])
+	}()
+
+	response, err := http.ReadResponse(client, nil)
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusSwitchingProtocols, response.StatusCode)
+	}
+	frame, err := readWebSocketFrame(client)
+	if assert.NoError(t, err) {
+		assert.True(t, frame.rsv1)
+		assert.True(t, len(frame.payload) < len(large))
+		inflated, err := ioutil.ReadAll(flate.NewReader(io.MultiReader(bytes.NewReader(frame.payload), bytes.NewReader(webSocketDeflateTail))))
+		assert.NoError(t, err)
+		assert.Equal(t, large, inflated)
+	}
+	frame, err = readWebSocketFrame(client)
+	if assert.NoError(t, err) {
+		assert.False(t, frame.rsv1)
+		assert.Equal(t, []byte("small"), frame.payload)
+	}
+
+	// Compressed messages from the client are inflated, even when they're split into frames
+	var compressed bytes.Buffer
+	deflater, _ := flate.NewWriter(&compressed, flate.BestSpeed)
+	_, _ = deflater.Write(large)
+	_ = deflater.Flush()
+	payload := bytes.TrimSuffix(compressed.Bytes(), webSocketDeflateTail[:4])
+	var in bytes.Buffer
+	writeWebSocketFrame(&in, &webSocketFrame{rsv1: true, opcode: wsOpBinary, payload: payload[:10]}, true)
+	writeWebSocketFrame(&in, &webSocketFrame{fin: true, opcode: 0x9, payload: []byte("ping")}, true)
+	writeWebSocketFrame(&in, &webSocketFrame{fin: true, opcode: wsOpContinuation, payload: payload[10:]}, true)
+	writeWebSocketFrame(&in, &webSocketFrame{fin: true, opcode: wsOpText, payload: []byte("plain")}, true)
+	go func() {
+		_, _ = clientSide.Write(in.Bytes())
+	}()
+
+	server := bufio.NewReader(conn)
+	for _, expected := range []*webSocketFrame{
+		{fin: true, opcode: 0x9, payload: []byte("ping")},
+		{fin: true, opcode: wsOpBinary, payload: large},
+		{fin: true, opcode: wsOpText, payload: []byte("plain")},
+	} {
+		frame, err := readWebSocketFrame(server)
+		if assert.NoError(t, err) {
+			assert.Equal(t, expected, frame)
+		}
+	}
+}